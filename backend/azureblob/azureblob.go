@@ -60,6 +60,7 @@ const (
 	maxUploadCutoff     = 256 * fs.MebiByte
 	defaultAccessTier   = azblob.AccessTierNone
 	maxTryTimeout       = time.Hour * 24 * 365 //max time of an azure web request response window (whether or not data is flowing)
+	copySASExpiry       = time.Hour * 24       // how long the SAS signed for a copy from another account lasts
 	// Default storage account, key and blob endpoint for emulator support,
 	// though it is a base64 key checked in here, it is publicly available secret.
 	emulatorAccount      = "devstoreaccount1"
@@ -219,6 +220,7 @@ type Fs struct {
 	pacer         *fs.Pacer                       // To pace and retry the API calls
 	uploadToken   *pacer.TokenDispenser           // control concurrency
 	pool          *pool.Pool                      // memory pool
+	sharedKey     *azblob.SharedKeyCredential     // set if using an account key, to sign SAS URLs
 }
 
 // Object describes an azure object
@@ -510,6 +512,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to parse credentials")
 		}
+		f.sharedKey = credential
 		u, err = url.Parse(emulatorBlobEndpoint)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to make azure storage url from account and endpoint")
@@ -521,6 +524,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to parse credentials")
 		}
+		f.sharedKey = credential
 
 		u, err = url.Parse(fmt.Sprintf("https://%s.%s", opt.Account, opt.Endpoint))
 		if err != nil {
@@ -1045,6 +1049,28 @@ func (f *Fs) Precision() time.Duration {
 	return time.Nanosecond
 }
 
+// CanServerSideFrom returns true if objects in src can be copied to f
+// server-side.
+//
+// The copy reads the source by URL so this is possible if the source
+// URL carries its own authorization, a SAS can be signed for it with
+// the source's account key, or the source is in the same account.
+func (f *Fs) CanServerSideFrom(ctx context.Context, src fs.Info) bool {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return false
+	}
+	switch {
+	case srcFs.opt.SASURL != "":
+		return true
+	case srcFs.sharedKey != nil:
+		return true
+	case f.opt.Account != "" && f.opt.Account == srcFs.opt.Account && f.opt.Endpoint == srcFs.opt.Endpoint:
+		return true
+	}
+	return false
+}
+
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
 	return hash.Set(hash.MD5)
@@ -1087,6 +1113,20 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if err != nil {
 		return nil, err
 	}
+	if srcObj.fs != f && srcObj.fs.sharedKey != nil {
+		// Authorize reading the source in a different account
+		srcContainer, srcPath := srcObj.split()
+		sasQuery, err := azblob.BlobSASSignatureValues{
+			ExpiryTime:    time.Now().Add(copySASExpiry),
+			ContainerName: srcContainer,
+			BlobName:      srcPath,
+			Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+		}.NewSASQueryParameters(srcObj.fs.sharedKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to sign source URL for copy")
+		}
+		source.RawQuery = sasQuery.Encode()
+	}
 
 	options := azblob.BlobAccessConditions{}
 	var startCopy *azblob.BlobStartCopyFromURLResponse
@@ -1656,13 +1696,14 @@ func (o *Object) GetTier() string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.Purger      = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
-	_ fs.GetTierer   = &Object{}
-	_ fs.SetTierer   = &Object{}
+	_ fs.Fs                   = &Fs{}
+	_ fs.Copier               = &Fs{}
+	_ fs.ServerSideNegotiator = &Fs{}
+	_ fs.PutStreamer          = &Fs{}
+	_ fs.Purger               = &Fs{}
+	_ fs.ListRer              = &Fs{}
+	_ fs.Object               = &Object{}
+	_ fs.MimeTyper            = &Object{}
+	_ fs.GetTierer            = &Object{}
+	_ fs.SetTierer            = &Object{}
 )
@@ -3,8 +3,10 @@
 package azureblob
 
 import (
+	"context"
 	"testing"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,3 +35,24 @@ func TestIncrement(t *testing.T) {
 		assert.Equal(t, test.want, test.in)
 	}
 }
+
+func TestCanServerSideFrom(t *testing.T) {
+	ctx := context.Background()
+	f := &Fs{opt: Options{Account: "account", Endpoint: storageDefaultBaseURL}}
+	for _, test := range []struct {
+		name string
+		src  *Fs
+		want bool
+	}{
+		{"SameAccount", &Fs{opt: Options{Account: "account", Endpoint: storageDefaultBaseURL}}, true},
+		{"OtherAccount", &Fs{opt: Options{Account: "other", Endpoint: storageDefaultBaseURL}}, false},
+		{"OtherEndpoint", &Fs{opt: Options{Account: "account", Endpoint: "blob.core.chinacloudapi.cn"}}, false},
+		{"SASURL", &Fs{opt: Options{SASURL: "https://other.blob.core.windows.net/container?sig=potato"}}, true},
+		{"SharedKey", &Fs{opt: Options{Account: "other"}, sharedKey: &azblob.SharedKeyCredential{}}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, f.CanServerSideFrom(ctx, test.src))
+		})
+	}
+	assert.False(t, f.CanServerSideFrom(ctx, nil))
+}
@@ -120,14 +120,17 @@ func (t Timestamp) Equal(s Timestamp) bool {
 
 // File is info about a file
 type File struct {
-	ID              string            `json:"fileId"`          // The unique identifier for this version of this file. Used with b2_get_file_info, b2_download_file_by_id, and b2_delete_file_version.
-	Name            string            `json:"fileName"`        // The name of this file, which can be used with b2_download_file_by_name.
-	Action          string            `json:"action"`          // Either "upload" or "hide". "upload" means a file that was uploaded to B2 Cloud Storage. "hide" means a file version marking the file as hidden, so that it will not show up in b2_list_file_names. The result of b2_list_file_names will contain only "upload". The result of b2_list_file_versions may have both.
-	Size            int64             `json:"size"`            // The number of bytes in the file.
-	UploadTimestamp Timestamp         `json:"uploadTimestamp"` // This is a UTC time when this file was uploaded.
-	SHA1            string            `json:"contentSha1"`     // The SHA1 of the bytes stored in the file.
-	ContentType     string            `json:"contentType"`     // The MIME type of the file.
-	Info            map[string]string `json:"fileInfo"`        // The custom information that was uploaded with the file. This is a JSON object, holding the name/value pairs that were uploaded with the file.
+	ID                string            `json:"fileId"`                      // The unique identifier for this version of this file. Used with b2_get_file_info, b2_download_file_by_id, and b2_delete_file_version.
+	Name              string            `json:"fileName"`                    // The name of this file, which can be used with b2_download_file_by_name.
+	Action            string            `json:"action"`                      // Either "upload" or "hide". "upload" means a file that was uploaded to B2 Cloud Storage. "hide" means a file version marking the file as hidden, so that it will not show up in b2_list_file_names. The result of b2_list_file_names will contain only "upload". The result of b2_list_file_versions may have both.
+	Size              int64             `json:"size"`                        // The number of bytes in the file.
+	UploadTimestamp   Timestamp         `json:"uploadTimestamp"`             // This is a UTC time when this file was uploaded.
+	SHA1              string            `json:"contentSha1"`                 // The SHA1 of the bytes stored in the file.
+	ContentType       string            `json:"contentType"`                 // The MIME type of the file.
+	Info              map[string]string `json:"fileInfo"`                    // The custom information that was uploaded with the file. This is a JSON object, holding the name/value pairs that were uploaded with the file.
+	FileRetention     *FileRetention    `json:"fileRetention,omitempty"`     // The file lock retention settings of the file (v2 API only)
+	LegalHold         *LegalHold        `json:"legalHold,omitempty"`         // The file lock legal hold status of the file (v2 API only)
+	ReplicationStatus string            `json:"replicationStatus,omitempty"` // One of "pending", "completed", "failed" or "replica" if the bucket is replicated (v2 API only)
 }
 
 // AuthorizeAccountResponse is as returned from the b2_authorize_account call
@@ -206,16 +209,19 @@ type GetDownloadAuthorizationResponse struct {
 
 // FileInfo is received from b2_upload_file, b2_get_file_info and b2_finish_large_file
 type FileInfo struct {
-	ID              string            `json:"fileId"`          // The unique identifier for this version of this file. Used with b2_get_file_info, b2_download_file_by_id, and b2_delete_file_version.
-	Name            string            `json:"fileName"`        // The name of this file, which can be used with b2_download_file_by_name.
-	Action          string            `json:"action"`          // Either "upload" or "hide". "upload" means a file that was uploaded to B2 Cloud Storage. "hide" means a file version marking the file as hidden, so that it will not show up in b2_list_file_names. The result of b2_list_file_names will contain only "upload". The result of b2_list_file_versions may have both.
-	AccountID       string            `json:"accountId"`       // Your account ID.
-	BucketID        string            `json:"bucketId"`        // The bucket that the file is in.
-	Size            int64             `json:"contentLength"`   // The number of bytes stored in the file.
-	UploadTimestamp Timestamp         `json:"uploadTimestamp"` // This is a UTC time when this file was uploaded.
-	SHA1            string            `json:"contentSha1"`     // The SHA1 of the bytes stored in the file.
-	ContentType     string            `json:"contentType"`     // The MIME type of the file.
-	Info            map[string]string `json:"fileInfo"`        // The custom information that was uploaded with the file. This is a JSON object, holding the name/value pairs that were uploaded with the file.
+	ID                string            `json:"fileId"`                      // The unique identifier for this version of this file. Used with b2_get_file_info, b2_download_file_by_id, and b2_delete_file_version.
+	Name              string            `json:"fileName"`                    // The name of this file, which can be used with b2_download_file_by_name.
+	Action            string            `json:"action"`                      // Either "upload" or "hide". "upload" means a file that was uploaded to B2 Cloud Storage. "hide" means a file version marking the file as hidden, so that it will not show up in b2_list_file_names. The result of b2_list_file_names will contain only "upload". The result of b2_list_file_versions may have both.
+	AccountID         string            `json:"accountId"`                   // Your account ID.
+	BucketID          string            `json:"bucketId"`                    // The bucket that the file is in.
+	Size              int64             `json:"contentLength"`               // The number of bytes stored in the file.
+	UploadTimestamp   Timestamp         `json:"uploadTimestamp"`             // This is a UTC time when this file was uploaded.
+	SHA1              string            `json:"contentSha1"`                 // The SHA1 of the bytes stored in the file.
+	ContentType       string            `json:"contentType"`                 // The MIME type of the file.
+	Info              map[string]string `json:"fileInfo"`                    // The custom information that was uploaded with the file. This is a JSON object, holding the name/value pairs that were uploaded with the file.
+	FileRetention     *FileRetention    `json:"fileRetention,omitempty"`     // The file lock retention settings of the file (v2 API only)
+	LegalHold         *LegalHold        `json:"legalHold,omitempty"`         // The file lock legal hold status of the file (v2 API only)
+	ReplicationStatus string            `json:"replicationStatus,omitempty"` // One of "pending", "completed", "failed" or "replica" if the bucket is replicated (v2 API only)
 }
 
 // CreateBucketRequest is used to create a bucket
@@ -265,10 +271,12 @@ type GetFileInfoRequest struct {
 //
 // Example: { "src_last_modified_millis" : "1452802803026", "large_file_sha1" : "a3195dc1e7b46a2ff5da4b3c179175b75671e80d", "color": "blue" }
 type StartLargeFileRequest struct {
-	BucketID    string            `json:"bucketId"`    //The ID of the bucket that the file will go in.
-	Name        string            `json:"fileName"`    // The name of the file. See Files for requirements on file names.
-	ContentType string            `json:"contentType"` // The MIME type of the content of the file, which will be returned in the Content-Type header when downloading the file. Use the Content-Type b2/x-auto to automatically set the stored Content-Type post upload. In the case where a file extension is absent or the lookup fails, the Content-Type is set to application/octet-stream.
-	Info        map[string]string `json:"fileInfo"`    // A JSON object holding the name/value pairs for the custom file info.
+	BucketID      string            `json:"bucketId"`                //The ID of the bucket that the file will go in.
+	Name          string            `json:"fileName"`                // The name of the file. See Files for requirements on file names.
+	ContentType   string            `json:"contentType"`             // The MIME type of the content of the file, which will be returned in the Content-Type header when downloading the file. Use the Content-Type b2/x-auto to automatically set the stored Content-Type post upload. In the case where a file extension is absent or the lookup fails, the Content-Type is set to application/octet-stream.
+	Info          map[string]string `json:"fileInfo"`                // A JSON object holding the name/value pairs for the custom file info.
+	FileRetention *RetentionSetting `json:"fileRetention,omitempty"` // The file lock retention settings for the file (v2 API only)
+	LegalHold     string            `json:"legalHold,omitempty"`     // The file lock legal hold status for the file: "on" or "off" (v2 API only)
 }
 
 // StartLargeFileResponse is the response to StartLargeFileRequest
@@ -336,6 +344,8 @@ type CopyFileRequest struct {
 	ContentType       string            `json:"contentType,omitempty"`         // The MIME type of the content of the file (REPLACE only)
 	Info              map[string]string `json:"fileInfo,omitempty"`            // This field stores the metadata that will be stored with the file. (REPLACE only)
 	DestBucketID      string            `json:"destinationBucketId,omitempty"` // The destination ID of the bucket if set, if not the source bucket will be used
+	FileRetention     *RetentionSetting `json:"fileRetention,omitempty"`       // The file lock retention settings for the new file (v2 API only)
+	LegalHold         string            `json:"legalHold,omitempty"`           // The file lock legal hold status for the new file: "on" or "off" (v2 API only)
 }
 
 // CopyPartRequest is the request for b2_copy_part - the response is UploadPartResponse
@@ -345,3 +355,64 @@ type CopyPartRequest struct {
 	PartNumber  int64  `json:"partNumber"`      // Which part this is (starting from 1)
 	Range       string `json:"range,omitempty"` // The range of bytes to copy. If not provided, the whole source file will be copied.
 }
+
+// File lock retention modes
+const (
+	RetentionModeGovernance = "governance" // can be removed or shortened by a key with bypassGovernance
+	RetentionModeCompliance = "compliance" // can't be removed or shortened by anyone
+)
+
+// File lock legal hold values
+const (
+	LegalHoldOn  = "on"
+	LegalHoldOff = "off"
+)
+
+// RetentionSetting is the file lock retention of a file
+//
+// Both fields are null if the file has no retention
+type RetentionSetting struct {
+	Mode                 *string    `json:"mode"`                 // The retention mode - "governance" or "compliance"
+	RetainUntilTimestamp *Timestamp `json:"retainUntilTimestamp"` // The file can't be deleted or overwritten until this time
+}
+
+// FileRetention is the file lock retention as returned with a file
+type FileRetention struct {
+	IsClientAuthorizedToRead bool             `json:"isClientAuthorizedToRead"` // Set if the key has the readFileRetentions capability
+	Value                    RetentionSetting `json:"value"`                    // The retention settings - only valid if IsClientAuthorizedToRead
+}
+
+// LegalHold is the file lock legal hold as returned with a file
+type LegalHold struct {
+	IsClientAuthorizedToRead bool    `json:"isClientAuthorizedToRead"` // Set if the key has the readFileLegalHolds capability
+	Value                    *string `json:"value"`                    // "on", "off" or null if never set - only valid if IsClientAuthorizedToRead
+}
+
+// UpdateFileRetentionRequest is as passed to b2_update_file_retention
+type UpdateFileRetentionRequest struct {
+	Name             string           `json:"fileName"`                   // The name of the file.
+	ID               string           `json:"fileId"`                     // The ID of the file.
+	FileRetention    RetentionSetting `json:"fileRetention"`              // The new retention settings - set both fields null to remove the retention.
+	BypassGovernance bool             `json:"bypassGovernance,omitempty"` // Must be set to shorten or remove a governance mode retention.
+}
+
+// UpdateFileRetentionResponse is as returned from b2_update_file_retention
+type UpdateFileRetentionResponse struct {
+	ID            string           `json:"fileId"`        // The ID of the file.
+	Name          string           `json:"fileName"`      // The name of the file.
+	FileRetention RetentionSetting `json:"fileRetention"` // The retention settings of the file.
+}
+
+// UpdateFileLegalHoldRequest is as passed to b2_update_file_legal_hold
+type UpdateFileLegalHoldRequest struct {
+	Name      string `json:"fileName"`  // The name of the file.
+	ID        string `json:"fileId"`    // The ID of the file.
+	LegalHold string `json:"legalHold"` // "on" or "off"
+}
+
+// UpdateFileLegalHoldResponse is as returned from b2_update_file_legal_hold
+type UpdateFileLegalHoldResponse struct {
+	ID        string `json:"fileId"`    // The ID of the file.
+	Name      string `json:"fileName"`  // The name of the file.
+	LegalHold string `json:"legalHold"` // "on" or "off"
+}
@@ -1,6 +1,7 @@
 package api_test
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -85,3 +86,31 @@ func TestTimestampEqual(t *testing.T) {
 	assert.True(t, t0.Equal(t0))
 	assert.True(t, t1.Equal(t1))
 }
+
+func TestRetentionSettingJSON(t *testing.T) {
+	mode := api.RetentionModeGovernance
+	out, err := json.Marshal(api.RetentionSetting{Mode: &mode, RetainUntilTimestamp: &t1})
+	require.NoError(t, err)
+	assert.Equal(t, `{"mode":"governance","retainUntilTimestamp":981173106123}`, string(out))
+
+	// No retention must be sent as nulls
+	out, err = json.Marshal(api.RetentionSetting{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"mode":null,"retainUntilTimestamp":null}`, string(out))
+
+	var file api.FileInfo
+	err = json.Unmarshal([]byte(`{
+		"fileRetention": {"isClientAuthorizedToRead": true, "value": {"mode": null, "retainUntilTimestamp": null}},
+		"legalHold": {"isClientAuthorizedToRead": true, "value": "on"},
+		"replicationStatus": "replica"
+	}`), &file)
+	require.NoError(t, err)
+	require.NotNil(t, file.FileRetention)
+	assert.True(t, file.FileRetention.IsClientAuthorizedToRead)
+	assert.Nil(t, file.FileRetention.Value.Mode)
+	assert.Nil(t, file.FileRetention.Value.RetainUntilTimestamp)
+	require.NotNil(t, file.LegalHold)
+	require.NotNil(t, file.LegalHold.Value)
+	assert.Equal(t, api.LegalHoldOn, *file.LegalHold.Value)
+	assert.Equal(t, "replica", file.ReplicationStatus)
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"net/http"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +30,7 @@ import (
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/lib/bucket"
 	"github.com/rclone/rclone/lib/encoder"
@@ -49,6 +51,10 @@ const (
 	nameHeader          = "X-Bz-File-Name"
 	timestampHeader     = "X-Bz-Upload-Timestamp"
 	retryAfterHeader    = "Retry-After"
+	retentionModeHeader = "X-Bz-File-Retention-Mode"
+	retainUntilHeader   = "X-Bz-File-Retention-Retain-Until-Timestamp"
+	legalHoldHeader     = "X-Bz-File-Legal-Hold"
+	apiV2Path           = "/b2api/v2" // the file lock calls and fields need the v2 API
 	minSleep            = 10 * time.Millisecond
 	maxSleep            = 5 * time.Minute
 	decayConstant       = 1 // bigger for slower decay, exponential
@@ -73,6 +79,7 @@ func init() {
 		Name:        "b2",
 		Description: "Backblaze B2",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:     "account",
 			Help:     "Account ID or Application Key ID",
@@ -110,6 +117,52 @@ in the [b2 integrations checklist](https://www.backblaze.com/b2/docs/integration
 			Name:    "hard_delete",
 			Help:    "Permanently delete files on remote removal, otherwise hide files.",
 			Default: false,
+		}, {
+			Name: "file_lock_mode",
+			Help: `File lock retention mode to set on uploaded and copied files.
+
+The bucket must have file lock enabled to use this. Files can't be
+deleted or overwritten until the retention set with
+"--b2-file-lock-retain" expires. Leave blank to use the default
+retention of the bucket, if any.`,
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Use the default retention of the bucket",
+			}, {
+				Value: api.RetentionModeGovernance,
+				Help:  "Governance mode - keys with bypassGovernance can remove the retention",
+			}, {
+				Value: api.RetentionModeCompliance,
+				Help:  "Compliance mode - nobody can remove or shorten the retention",
+			}},
+			Advanced: true,
+		}, {
+			Name: "file_lock_retain",
+			Help: `How long to retain uploaded and copied files for in s or suffix ms|s|m|h|d.
+
+This must be set if "--b2-file-lock-mode" is set. The retention
+expires this long after the file is uploaded.`,
+			Default:  fs.Duration(0),
+			Advanced: true,
+		}, {
+			Name: "legal_hold",
+			Help: `Place a legal hold on uploaded and copied files.
+
+Files with a legal hold can't be deleted or overwritten until the hold
+is removed, for example with the "legalhold" backend command. The
+bucket must have file lock enabled to use this.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "server_side_across_configs",
+			Help: `Allow server-side operations (e.g. copy) to work across different b2 configs.
+
+This can be useful if you wish to do a server-side copy between
+buckets configured in different remotes. Note that the key of the
+destination remote must be able to read the source bucket, which is
+why this isn't enabled by default.`,
+			Default:  false,
+			Advanced: true,
 		}, {
 			Name: "upload_cutoff",
 			Help: `Cutoff for switching to chunked upload.
@@ -200,6 +253,10 @@ type Options struct {
 	TestMode                      string               `config:"test_mode"`
 	Versions                      bool                 `config:"versions"`
 	HardDelete                    bool                 `config:"hard_delete"`
+	FileLockMode                  string               `config:"file_lock_mode"`
+	FileLockRetain                fs.Duration          `config:"file_lock_retain"`
+	LegalHold                     bool                 `config:"legal_hold"`
+	ServerSideAcrossConfigs       bool                 `config:"server_side_across_configs"`
 	UploadCutoff                  fs.SizeSuffix        `config:"upload_cutoff"`
 	CopyCutoff                    fs.SizeSuffix        `config:"copy_cutoff"`
 	ChunkSize                     fs.SizeSuffix        `config:"chunk_size"`
@@ -387,6 +444,46 @@ func (f *Fs) setUploadCutoff(cs fs.SizeSuffix) (old fs.SizeSuffix, err error) {
 	return
 }
 
+// checkFileLock checks the file lock options are consistent
+func checkFileLock(opt *Options) error {
+	switch opt.FileLockMode {
+	case "":
+		if opt.FileLockRetain != 0 {
+			return errors.New("file_lock_retain needs file_lock_mode to be set")
+		}
+	case api.RetentionModeGovernance, api.RetentionModeCompliance:
+		if opt.FileLockRetain <= 0 {
+			return errors.New("file_lock_retain must be set with file_lock_mode")
+		}
+	default:
+		return errors.Errorf("unknown file_lock_mode %q", opt.FileLockMode)
+	}
+	return nil
+}
+
+// fileLock returns the retention and legal hold to set on new files
+//
+// retention is nil and legalHold is "" if they should not be set
+func (f *Fs) fileLock() (retention *api.RetentionSetting, legalHold string) {
+	if f.opt.FileLockMode != "" {
+		mode := f.opt.FileLockMode
+		retainUntil := api.Timestamp(time.Now().Add(time.Duration(f.opt.FileLockRetain)))
+		retention = &api.RetentionSetting{
+			Mode:                 &mode,
+			RetainUntilTimestamp: &retainUntil,
+		}
+	}
+	if f.opt.LegalHold {
+		legalHold = api.LegalHoldOn
+	}
+	return retention, legalHold
+}
+
+// apiV2URL returns the root URL for calls which need the v2 API
+func (f *Fs) apiV2URL() string {
+	return f.info.APIURL + apiV2Path
+}
+
 // setRoot changes the root of the Fs
 func (f *Fs) setRoot(root string) {
 	f.root = parsePath(root)
@@ -415,6 +512,10 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if opt.Key == "" {
 		return nil, errors.New("key not found")
 	}
+	err = checkFileLock(opt)
+	if err != nil {
+		return nil, errors.Wrap(err, "b2: file lock")
+	}
 	if opt.Endpoint == "" {
 		opt.Endpoint = defaultEndpoint
 	}
@@ -439,10 +540,11 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	}
 	f.setRoot(root)
 	f.features = (&fs.Features{
-		ReadMimeType:      true,
-		WriteMimeType:     true,
-		BucketBased:       true,
-		BucketBasedRootOK: true,
+		ReadMimeType:            true,
+		WriteMimeType:           true,
+		BucketBased:             true,
+		BucketBasedRootOK:       true,
+		ServerSideAcrossConfigs: opt.ServerSideAcrossConfigs,
 	}).Fill(ctx, f)
 	// Set the test flag if required
 	if opt.TestMode != "" {
@@ -1277,6 +1379,10 @@ func (f *Fs) copy(ctx context.Context, dstObj *Object, srcObj *Object, newInfo *
 		Name:         f.opt.Enc.FromStandardPath(dstPath),
 		DestBucketID: destBucketID,
 	}
+	request.FileRetention, request.LegalHold = f.fileLock()
+	if request.FileRetention != nil || request.LegalHold != "" {
+		opts.RootURL = f.apiV2URL()
+	}
 	if newInfo == nil {
 		request.MetadataDirective = "COPY"
 	} else {
@@ -1301,7 +1407,9 @@ func (f *Fs) copy(ctx context.Context, dstObj *Object, srcObj *Object, newInfo *
 //
 // It returns the destination Object and a possible error
 //
-// Will only be called if src.Fs().Name() == f.Name()
+// Will only be called if src.Fs().Name() == f.Name() or if
+// server_side_across_configs is set in which case the source may be
+// in a bucket of a different remote.
 //
 // If it isn't possible then return fs.ErrorCantCopy
 func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
@@ -1936,6 +2044,14 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		},
 		ContentLength: &size,
 	}
+	retention, legalHold := o.fs.fileLock()
+	if retention != nil {
+		opts.ExtraHeaders[retentionModeHeader] = *retention.Mode
+		opts.ExtraHeaders[retainUntilHeader] = timeString(time.Time(*retention.RetainUntilTimestamp))
+	}
+	if legalHold != "" {
+		opts.ExtraHeaders[legalHoldHeader] = legalHold
+	}
 	var response api.FileInfo
 	// Don't retry, return a retry error instead
 	err = o.fs.pacer.CallNoRetry(func() (bool, error) {
@@ -1976,6 +2092,344 @@ func (o *Object) ID() string {
 	return o.id
 }
 
+var commandHelp = []fs.CommandHelp{{
+	Name:  "retention",
+	Short: "Set the file lock retention of files",
+	Long: `This command sets the file lock retention of one or more files. The
+bucket must have file lock enabled.
+
+Usage Examples:
+
+    rclone backend retention b2:bucket/path/to/object -o mode=governance -o retain=30d
+    rclone backend retention b2:bucket/path/to/directory -o mode=compliance -o until=2030-01-01T00:00:00Z
+    rclone backend retention b2:bucket/path -o mode=none -o bypass-governance
+
+Use mode=none to remove a governance mode retention. Shortening or
+removing a governance mode retention needs the bypass-governance
+option and a key with the bypassGovernance capability. A compliance
+mode retention can only be extended.
+
+This command obeys the filters. Test first with -i/--interactive or
+--dry-run flags. With --b2-versions it can be used on old versions of
+files too.
+
+It returns a list of status dictionaries with Remote and Status
+keys. The Status will be OK if it was successful or an error message
+if not.
+
+    [
+        {
+            "Status": "OK",
+            "Remote": "test.txt"
+        },
+        {
+            "Status": "OK",
+            "Remote": "test/file4.txt"
+        }
+    ]
+`,
+	Opts: map[string]string{
+		"mode":              "Retention mode: governance|compliance|none",
+		"retain":            "Retain the files for this long from now, e.g. 30d",
+		"until":             "Retain the files until this RFC 3339 time",
+		"bypass-governance": "Allow a governance mode retention to be shortened or removed",
+	},
+}, {
+	Name:  "legalhold",
+	Short: "Place or remove a legal hold on files",
+	Long: `This command places or removes a file lock legal hold on one or more
+files. The bucket must have file lock enabled.
+
+Usage Examples:
+
+    rclone backend legalhold b2:bucket/path/to/object on
+    rclone backend legalhold b2:bucket/path/to/directory off
+
+This command obeys the filters. Test first with -i/--interactive or
+--dry-run flags.
+
+It returns a list of status dictionaries in the same format as the
+retention command.
+`,
+}, {
+	Name:  "status",
+	Short: "Show replication status, retention and legal hold of files",
+	Long: `This command shows the replication status and the file lock settings
+of one or more files.
+
+Usage Examples:
+
+    rclone backend status b2:bucket/path/to/object
+    rclone backend status b2:bucket/path/to/directory
+
+ReplicationStatus is one of "pending", "completed" or "failed" for a
+file in the source bucket of a replication rule, "replica" for a
+replicated copy of a file, or empty if the file isn't replicated.
+Fields the key isn't allowed to read are left empty.
+
+    [
+        {
+            "Remote": "test.txt",
+            "ReplicationStatus": "completed",
+            "RetentionMode": "governance",
+            "RetainUntil": "2030-01-01T00:00:00Z",
+            "LegalHold": "on"
+        }
+    ]
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "retention":
+		retention, bypassGovernance, err := parseRetention(opt, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return f.applyToObjects(ctx, "set retention", func(o *Object) error {
+			return o.setRetention(ctx, retention, bypassGovernance)
+		})
+	case "legalhold":
+		if len(arg) != 1 || (arg[0] != api.LegalHoldOn && arg[0] != api.LegalHoldOff) {
+			return nil, errors.New("need \"on\" or \"off\" as the argument")
+		}
+		legalHold := arg[0]
+		return f.applyToObjects(ctx, "set legal hold", func(o *Object) error {
+			return o.setLegalHold(ctx, legalHold)
+		})
+	case "status":
+		return f.fileStatus(ctx)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// parseRetention reads the retention command options
+//
+// now is the time retain is measured from
+func parseRetention(opt map[string]string, now time.Time) (retention api.RetentionSetting, bypassGovernance bool, err error) {
+	_, bypassGovernance = opt["bypass-governance"]
+	mode := opt["mode"]
+	switch mode {
+	case "none":
+		if opt["retain"] != "" || opt["until"] != "" {
+			return retention, false, errors.New("can't use retain or until with mode none")
+		}
+		return retention, bypassGovernance, nil
+	case api.RetentionModeGovernance, api.RetentionModeCompliance:
+	case "":
+		return retention, false, errors.New("need a mode option")
+	default:
+		return retention, false, errors.Errorf("unknown mode %q", mode)
+	}
+	var retainUntil time.Time
+	switch {
+	case opt["retain"] != "" && opt["until"] != "":
+		return retention, false, errors.New("can't use both retain and until")
+	case opt["retain"] != "":
+		retain, err := fs.ParseDuration(opt["retain"])
+		if err != nil {
+			return retention, false, errors.Wrap(err, "bad retain")
+		}
+		retainUntil = now.Add(retain)
+	case opt["until"] != "":
+		retainUntil, err = time.Parse(time.RFC3339, opt["until"])
+		if err != nil {
+			return retention, false, errors.Wrap(err, "bad until")
+		}
+	default:
+		return retention, false, errors.New("need a retain or until option")
+	}
+	timestamp := api.Timestamp(retainUntil)
+	retention.Mode = &mode
+	retention.RetainUntilTimestamp = &timestamp
+	return retention, bypassGovernance, nil
+}
+
+// commandStatus is the result of a command on a single object
+type commandStatus struct {
+	Status string
+	Remote string
+}
+
+// applyToObjects runs fn on all the objects in f which pass the
+// filters, returning a status for each one.
+func (f *Fs) applyToObjects(ctx context.Context, action string, fn func(o *Object) error) (out []commandStatus, err error) {
+	var outMu sync.Mutex
+	out = []commandStatus{}
+	err = operations.ListFn(ctx, f, func(obj fs.Object) {
+		// Remember this is run --checkers times concurrently
+		o, ok := obj.(*Object)
+		st := commandStatus{Status: "OK", Remote: obj.Remote()}
+		defer func() {
+			outMu.Lock()
+			out = append(out, st)
+			outMu.Unlock()
+		}()
+		if operations.SkipDestructive(ctx, obj, action) {
+			return
+		}
+		if !ok {
+			st.Status = "Not a B2 object"
+			return
+		}
+		if err := fn(o); err != nil {
+			st.Status = err.Error()
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Remote < out[j].Remote })
+	return out, err
+}
+
+// fileStatus is the replication and file lock status of an object
+type fileStatus struct {
+	Remote            string
+	ReplicationStatus string
+	RetentionMode     string
+	RetainUntil       string
+	LegalHold         string
+	Error             string `json:",omitempty"`
+}
+
+// fileStatus reads the status of all the objects in f which pass
+// the filters
+func (f *Fs) fileStatus(ctx context.Context) (out []fileStatus, err error) {
+	var outMu sync.Mutex
+	out = []fileStatus{}
+	err = operations.ListFn(ctx, f, func(obj fs.Object) {
+		st := fileStatus{Remote: obj.Remote()}
+		defer func() {
+			outMu.Lock()
+			out = append(out, st)
+			outMu.Unlock()
+		}()
+		o, ok := obj.(*Object)
+		if !ok {
+			st.Error = "Not a B2 object"
+			return
+		}
+		info, err := o.getFileInfoV2(ctx)
+		if err != nil {
+			st.Error = err.Error()
+			return
+		}
+		st.ReplicationStatus = info.ReplicationStatus
+		if info.FileRetention != nil && info.FileRetention.IsClientAuthorizedToRead {
+			retention := info.FileRetention.Value
+			if retention.Mode != nil {
+				st.RetentionMode = *retention.Mode
+			}
+			if retention.RetainUntilTimestamp != nil {
+				st.RetainUntil = time.Time(*retention.RetainUntilTimestamp).Format(time.RFC3339)
+			}
+		}
+		if info.LegalHold != nil && info.LegalHold.IsClientAuthorizedToRead && info.LegalHold.Value != nil {
+			st.LegalHold = *info.LegalHold.Value
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Remote < out[j].Remote })
+	return out, err
+}
+
+// fileLockName returns the name of the object for the file lock
+// calls with any version suffix removed
+func (o *Object) fileLockName() string {
+	_, bucketPath := o.split()
+	if o.fs.opt.Versions {
+		_, bucketPath = api.RemoveVersion(bucketPath)
+	}
+	return o.fs.opt.Enc.FromStandardPath(bucketPath)
+}
+
+// getFileInfoV2 reads the file info with the v2 API which includes
+// the replication status and file lock settings
+func (o *Object) getFileInfoV2(ctx context.Context) (info *api.FileInfo, err error) {
+	err = o.readMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := rest.Opts{
+		Method:  "POST",
+		RootURL: o.fs.apiV2URL(),
+		Path:    "/b2_get_file_info",
+	}
+	var request = api.GetFileInfoRequest{
+		ID: o.id,
+	}
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, &request, &info)
+		return o.fs.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read file info")
+	}
+	return info, nil
+}
+
+// setRetention sets the file lock retention of the object
+func (o *Object) setRetention(ctx context.Context, retention api.RetentionSetting, bypassGovernance bool) error {
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method:  "POST",
+		RootURL: o.fs.apiV2URL(),
+		Path:    "/b2_update_file_retention",
+	}
+	var request = api.UpdateFileRetentionRequest{
+		Name:             o.fileLockName(),
+		ID:               o.id,
+		FileRetention:    retention,
+		BypassGovernance: bypassGovernance,
+	}
+	var response api.UpdateFileRetentionResponse
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, &request, &response)
+		return o.fs.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set retention")
+	}
+	return nil
+}
+
+// setLegalHold places or removes a legal hold on the object
+func (o *Object) setLegalHold(ctx context.Context, legalHold string) error {
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method:  "POST",
+		RootURL: o.fs.apiV2URL(),
+		Path:    "/b2_update_file_legal_hold",
+	}
+	var request = api.UpdateFileLegalHoldRequest{
+		Name:      o.fileLockName(),
+		ID:        o.id,
+		LegalHold: legalHold,
+	}
+	var response api.UpdateFileLegalHoldResponse
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, &request, &response)
+		return o.fs.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set legal hold")
+	}
+	return nil
+}
+
 // Check the interfaces are satisfied
 var (
 	_ fs.Fs           = &Fs{}
@@ -1985,6 +2439,7 @@ var (
 	_ fs.CleanUpper   = &Fs{}
 	_ fs.ListRer      = &Fs{}
 	_ fs.PublicLinker = &Fs{}
+	_ fs.Commander    = &Fs{}
 	_ fs.Object       = &Object{}
 	_ fs.MimeTyper    = &Object{}
 	_ fs.IDer         = &Object{}
@@ -4,7 +4,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rclone/rclone/backend/b2/api"
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Test b2 string encoding
@@ -168,3 +172,83 @@ func TestParseTimeString(t *testing.T) {
 	}
 
 }
+
+func TestCheckFileLock(t *testing.T) {
+	for _, test := range []struct {
+		mode      string
+		retain    fs.Duration
+		wantError string
+	}{
+		{"", 0, ""},
+		{"", fs.Duration(time.Hour), "file_lock_retain needs file_lock_mode to be set"},
+		{"governance", fs.Duration(time.Hour), ""},
+		{"compliance", fs.Duration(time.Hour), ""},
+		{"compliance", 0, "file_lock_retain must be set with file_lock_mode"},
+		{"potato", fs.Duration(time.Hour), `unknown file_lock_mode "potato"`},
+	} {
+		err := checkFileLock(&Options{FileLockMode: test.mode, FileLockRetain: test.retain})
+		if test.wantError == "" {
+			assert.NoError(t, err, test.mode)
+		} else {
+			assert.EqualError(t, err, test.wantError, test.mode)
+		}
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	f := &Fs{}
+	retention, legalHold := f.fileLock()
+	assert.Nil(t, retention)
+	assert.Equal(t, "", legalHold)
+
+	f.opt.FileLockMode = api.RetentionModeCompliance
+	f.opt.FileLockRetain = fs.Duration(24 * time.Hour)
+	f.opt.LegalHold = true
+	before := time.Now()
+	retention, legalHold = f.fileLock()
+	require.NotNil(t, retention)
+	assert.Equal(t, api.RetentionModeCompliance, *retention.Mode)
+	retainUntil := time.Time(*retention.RetainUntilTimestamp)
+	assert.False(t, retainUntil.Before(before.Add(24*time.Hour)))
+	assert.True(t, retainUntil.Before(time.Now().Add(25*time.Hour)))
+	assert.Equal(t, api.LegalHoldOn, legalHold)
+}
+
+func TestParseRetention(t *testing.T) {
+	now := fstest.Time("2001-02-03T04:05:06Z")
+	for _, test := range []struct {
+		opt        map[string]string
+		wantMode   string
+		wantUntil  time.Time
+		wantBypass bool
+		wantError  string
+	}{
+		{map[string]string{"mode": "governance", "retain": "1d"}, "governance", fstest.Time("2001-02-04T04:05:06Z"), false, ""},
+		{map[string]string{"mode": "compliance", "until": "2030-01-01T00:00:00Z"}, "compliance", fstest.Time("2030-01-01T00:00:00Z"), false, ""},
+		{map[string]string{"mode": "governance", "retain": "1h", "bypass-governance": "true"}, "governance", fstest.Time("2001-02-03T05:05:06Z"), true, ""},
+		{map[string]string{"mode": "none", "bypass-governance": "true"}, "", time.Time{}, true, ""},
+		{map[string]string{"mode": "none", "retain": "1d"}, "", time.Time{}, false, "can't use retain or until with mode none"},
+		{map[string]string{"retain": "1d"}, "", time.Time{}, false, "need a mode option"},
+		{map[string]string{"mode": "potato"}, "", time.Time{}, false, `unknown mode "potato"`},
+		{map[string]string{"mode": "governance"}, "", time.Time{}, false, "need a retain or until option"},
+		{map[string]string{"mode": "governance", "retain": "1d", "until": "2030-01-01T00:00:00Z"}, "", time.Time{}, false, "can't use both retain and until"},
+		{map[string]string{"mode": "governance", "retain": "potato"}, "", time.Time{}, false, "bad retain"},
+	} {
+		retention, bypass, err := parseRetention(test.opt, now)
+		if test.wantError != "" {
+			require.Error(t, err, test.opt)
+			assert.Contains(t, err.Error(), test.wantError, test.opt)
+			continue
+		}
+		require.NoError(t, err, test.opt)
+		assert.Equal(t, test.wantBypass, bypass, test.opt)
+		if test.wantMode == "" {
+			assert.Nil(t, retention.Mode, test.opt)
+			assert.Nil(t, retention.RetainUntilTimestamp, test.opt)
+			continue
+		}
+		require.NotNil(t, retention.Mode, test.opt)
+		assert.Equal(t, test.wantMode, *retention.Mode, test.opt)
+		assert.True(t, test.wantUntil.Equal(time.Time(*retention.RetainUntilTimestamp)), test.opt)
+	}
+}
@@ -136,6 +136,10 @@ func (f *Fs) newLargeUpload(ctx context.Context, o *Object, in io.Reader, src fs
 		request.ContentType = newInfo.ContentType
 		request.Info = newInfo.Info
 	}
+	request.FileRetention, request.LegalHold = f.fileLock()
+	if request.FileRetention != nil || request.LegalHold != "" {
+		opts.RootURL = f.apiV2URL()
+	}
 	var response api.StartLargeFileResponse
 	err = f.pacer.Call(func() (bool, error) {
 		resp, err := f.srv.CallJSON(ctx, &opts, &request, &response)
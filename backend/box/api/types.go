@@ -242,3 +242,50 @@ type User struct {
 	Address       string    `json:"address"`
 	AvatarURL     string    `json:"avatar_url"`
 }
+
+// Event types which change files and folders
+const (
+	EventItemCreate   = "ITEM_CREATE"
+	EventItemUpload   = "ITEM_UPLOAD"
+	EventItemMove     = "ITEM_MOVE"
+	EventItemRename   = "ITEM_RENAME"
+	EventItemCopy     = "ITEM_COPY"
+	EventItemTrash    = "ITEM_TRASH"
+	EventItemUndelete = "ITEM_UNDELETE_VIA_TRASH"
+)
+
+// MiniItem is the short form of a file or folder used in paths
+type MiniItem struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PathCollection is the folders from the root to an item
+type PathCollection struct {
+	TotalCount int        `json:"total_count"`
+	Entries    []MiniItem `json:"entries"`
+}
+
+// EventSource is the file or folder an event happened to
+type EventSource struct {
+	MiniItem
+	ItemStatus     string         `json:"item_status"`
+	Parent         *MiniItem      `json:"parent"`
+	PathCollection PathCollection `json:"path_collection"`
+}
+
+// Event is an entry in the events stream
+type Event struct {
+	Type      string       `json:"type"`
+	EventID   string       `json:"event_id"`
+	EventType string       `json:"event_type"`
+	Source    *EventSource `json:"source"`
+}
+
+// Events is returned from the events call
+type Events struct {
+	ChunkSize          int         `json:"chunk_size"`
+	NextStreamPosition json.Number `json:"next_stream_position"`
+	Entries            []Event     `json:"entries"`
+}
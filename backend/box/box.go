@@ -909,6 +909,154 @@ func (f *Fs) About(ctx context.Context) (usage *fs.Usage, err error) {
 	return usage, nil
 }
 
+// ChangeToken returns a token for the current state of the remote
+// which can be passed to Changes to find what has changed since.
+func (f *Fs) ChangeToken(ctx context.Context) (string, error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/events",
+		Parameters: url.Values{},
+	}
+	opts.Parameters.Set("stream_position", "now")
+	var result api.Events
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read stream position")
+	}
+	return result.NextStreamPosition.String(), nil
+}
+
+// Changes returns the paths which have changed since token was read
+// with ChangeToken and a new token for the current state.
+//
+// Files moved or renamed are only reported at their new location,
+// and files moved to the trash are reported as their directory.
+func (f *Fs) Changes(ctx context.Context, token string) (paths []string, newToken string, err error) {
+	rootID, err := f.dirCache.RootID(ctx, false)
+	if err != nil {
+		return nil, "", err
+	}
+	position := token
+	for {
+		opts := rest.Opts{
+			Method:     "GET",
+			Path:       "/events",
+			Parameters: url.Values{},
+		}
+		opts.Parameters.Set("stream_position", position)
+		opts.Parameters.Set("stream_type", "changes")
+		opts.Parameters.Set("limit", "500")
+		var result api.Events
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			if apiErr, ok := err.(*api.Error); ok && apiErr.Status == http.StatusBadRequest {
+				fs.Debugf(f, "Stream position rejected: %v", err)
+				return nil, "", fs.ErrorChangesUnavailable
+			}
+			return nil, "", errors.Wrap(err, "failed to read events")
+		}
+		for _, event := range result.Entries {
+			source := event.Source
+			if source == nil || (source.Type != api.ItemTypeFile && source.Type != api.ItemTypeFolder) {
+				continue
+			}
+			switch event.EventType {
+			case api.EventItemCreate, api.EventItemUpload, api.EventItemMove, api.EventItemRename, api.EventItemCopy, api.EventItemUndelete:
+				if remote, ok := f.changedPath(rootID, source); ok {
+					paths = append(paths, remote)
+				}
+			case api.EventItemTrash:
+				// The item is in the trash now so find where
+				// it was from its directory
+				if oldPath, ok := f.dirCache.GetInv(source.ID); ok {
+					paths = append(paths, oldPath)
+					continue
+				}
+				if source.Parent == nil {
+					return nil, "", fs.ErrorChangesUnavailable
+				}
+				dirPath, ok, err := f.changedDirPath(ctx, rootID, source.Parent.ID)
+				if err != nil {
+					return nil, "", err
+				}
+				if ok {
+					paths = append(paths, dirPath)
+				}
+			}
+		}
+		if result.NextStreamPosition != "" {
+			position = result.NextStreamPosition.String()
+		}
+		if len(result.Entries) == 0 {
+			break
+		}
+	}
+	return paths, position, nil
+}
+
+// changedPath returns the path relative to the root of the item
+// source from its path collection or false if it isn't inside the
+// root.
+func (f *Fs) changedPath(rootID string, source *api.EventSource) (remote string, ok bool) {
+	if source.ID == rootID {
+		return "", true
+	}
+	entries := source.PathCollection.Entries
+	for i := range entries {
+		if entries[i].ID != rootID {
+			continue
+		}
+		for _, entry := range entries[i+1:] {
+			remote = path.Join(remote, f.opt.Enc.ToStandardName(entry.Name))
+		}
+		return path.Join(remote, f.opt.Enc.ToStandardName(source.Name)), true
+	}
+	return "", false
+}
+
+// changedDirPath returns the path relative to the root of the
+// directory with ID dirID or false if it isn't inside the root.
+func (f *Fs) changedDirPath(ctx context.Context, rootID, dirID string) (dirPath string, ok bool, err error) {
+	if dirID == rootID {
+		return "", true, nil
+	}
+	if dirPath, ok := f.dirCache.GetInv(dirID); ok {
+		return dirPath, true, nil
+	}
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/folders/" + dirID,
+		Parameters: url.Values{},
+	}
+	opts.Parameters.Set("fields", "type,id,name,path_collection")
+	var info api.EventSource
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &info)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		if apiErr, ok := err.(*api.Error); ok && apiErr.Status == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, errors.Wrap(err, "failed to find changed directory")
+	}
+	dirPath, ok = f.changedPath(rootID, &info)
+	if ok {
+		f.dirCache.Put(dirPath, dirID)
+	}
+	return dirPath, ok, nil
+}
+
 // Move src to this remote using server-side move operations.
 //
 // This is stored with the remote path given
@@ -1318,6 +1466,7 @@ var (
 	_ fs.DirCacheFlusher = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.ChangeLister    = (*Fs)(nil)
 	_ fs.Object          = (*Object)(nil)
 	_ fs.IDer            = (*Object)(nil)
 )
@@ -0,0 +1,37 @@
+package box
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/backend/box/api"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalChangedPath(t *testing.T) {
+	f := &Fs{opt: Options{Enc: encoder.Base}}
+	source := func(id, name string, parents ...string) *api.EventSource {
+		s := &api.EventSource{MiniItem: api.MiniItem{Type: api.ItemTypeFile, ID: id, Name: name}}
+		for i, parent := range parents {
+			s.PathCollection.Entries = append(s.PathCollection.Entries, api.MiniItem{Type: api.ItemTypeFolder, ID: string(rune('0' + i)), Name: parent})
+		}
+		return s
+	}
+	for _, test := range []struct {
+		rootID string
+		source *api.EventSource
+		want   string
+		ok     bool
+	}{
+		{"0", source("10", "file.txt", "All Files"), "file.txt", true},
+		{"0", source("10", "file.txt", "All Files", "dir", "sub"), "dir/sub/file.txt", true},
+		{"1", source("10", "file.txt", "All Files", "dir", "sub"), "sub/file.txt", true},
+		{"2", source("10", "file.txt", "All Files", "dir", "sub"), "file.txt", true},
+		{"5", source("10", "file.txt", "All Files", "dir", "sub"), "", false},
+		{"10", source("10", "dir", "All Files"), "", true},
+	} {
+		got, ok := f.changedPath(test.rootID, test.source)
+		assert.Equal(t, test.want, got, test.rootID)
+		assert.Equal(t, test.ok, ok, test.rootID)
+	}
+}
@@ -0,0 +1,911 @@
+// Package cas provides an overlay backend which stores files by the
+// hash of their contents and keeps named trees of them which can be
+// snapshotted and rolled back cheaply.
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/bucket"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "cas",
+		Description: "Content addressable store with snapshots of directory trees",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: `Remote to store the blobs, trees and refs in.
+
+Normally should contain a ':' and a path, eg "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).`,
+			Required: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+}
+
+// Fs represents named trees of files stored by hash
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	base     fs.Fs        // the wrapped remote
+	s        *store       // the state shared with other Fs on base
+	features *fs.Features // optional features
+}
+
+// NewFs constructs an Fs from the path, tree:path
+func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point cas remote at itself - check the value of the remote setting")
+	}
+	base, err := cache.Get(ctx, opt.Remote)
+	if err == fs.ErrorIsFile {
+		return nil, errors.Errorf("remote %q to wrap is a file not a directory", opt.Remote)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to make remote %q to wrap", opt.Remote)
+	}
+	f := &Fs{
+		name: name,
+		root: strings.Trim(rpath, "/"),
+		opt:  *opt,
+		base: base,
+		s:    getStore(base),
+	}
+	cache.PinUntilFinalized(base, f)
+	f.features = (&fs.Features{
+		CaseInsensitive:         false,
+		DuplicateFiles:          false,
+		ReadMimeType:            false,
+		WriteMimeType:           false,
+		BucketBased:             true,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	// If the root points to a file then point at its parent
+	ref, p := bucket.Split(f.root)
+	if p != "" {
+		root, err := f.s.ref(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		e, err := f.s.lookup(ctx, root, p)
+		if err == nil && !e.isDir() {
+			f.root = path.Dir(f.root)
+			return f, fs.ErrorIsFile
+		} else if err != nil && err != fs.ErrorObjectNotFound {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("CAS '%s:%s'", f.name, f.root)
+}
+
+// Precision returns the precision of this Fs
+//
+// The modification times are kept in the trees.
+func (f *Fs) Precision() time.Duration {
+	return time.Nanosecond
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5 | hash.SHA1)
+}
+
+// split returns the ref and the path in its tree of the remote
+func (f *Fs) split(remote string) (ref, p string) {
+	return bucket.Split(path.Join(f.root, remote))
+}
+
+// entries makes the directory entries in dir from the tree t
+func (f *Fs) entries(dir string, t *tree) (entries fs.DirEntries) {
+	entries = make(fs.DirEntries, 0, len(t.Entries))
+	for _, e := range t.Entries {
+		remote := path.Join(dir, e.Name)
+		if e.isDir() {
+			entries = append(entries, fs.NewDir(remote, e.ModTime).SetID(e.Tree))
+		} else {
+			entries = append(entries, &Object{f: f, remote: remote, e: e})
+		}
+	}
+	return entries
+}
+
+// listRefs lists the refs as directories in dir
+func (f *Fs) listRefs(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	refs, err := f.base.List(ctx, refsDir)
+	if err == fs.ErrorDirNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to list refs")
+	}
+	for _, entry := range refs {
+		if o, ok := entry.(fs.Object); ok {
+			entries = append(entries, fs.NewDir(path.Join(dir, path.Base(o.Remote())), o.ModTime(ctx)))
+		}
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// The refs are listed as the directories in the root.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	ref, p := f.split(dir)
+	if ref == "" {
+		return f.listRefs(ctx, dir)
+	}
+	root, err := f.s.ref(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	t, err := f.s.lookupDir(ctx, root, splitPath(p))
+	if err != nil {
+		return nil, err
+	}
+	return f.entries(dir, t), nil
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	var listTree func(dir string, t *tree) error
+	listTree = func(dir string, t *tree) error {
+		err := callback(f.entries(dir, t))
+		if err != nil {
+			return err
+		}
+		for _, e := range t.Entries {
+			if !e.isDir() {
+				continue
+			}
+			sub, err := f.s.tree(ctx, e.Tree)
+			if err != nil {
+				return err
+			}
+			err = listTree(path.Join(dir, e.Name), sub)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	listRef := func(dir string) error {
+		ref, p := f.split(dir)
+		root, err := f.s.ref(ctx, ref)
+		if err != nil {
+			return err
+		}
+		t, err := f.s.lookupDir(ctx, root, splitPath(p))
+		if err != nil {
+			return err
+		}
+		return listTree(dir, t)
+	}
+	ref, _ := f.split(dir)
+	if ref != "" {
+		return listRef(dir)
+	}
+	refs, err := f.listRefs(ctx, dir)
+	if err != nil {
+		return err
+	}
+	err = callback(refs)
+	if err != nil {
+		return err
+	}
+	for _, entry := range refs {
+		err = listRef(entry.Remote())
+		if err == fs.ErrorDirNotFound {
+			// removed since it was listed
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	ref, p := f.split(remote)
+	if p == "" {
+		return nil, fs.ErrorObjectNotFound
+	}
+	root, err := f.s.ref(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	e, err := f.s.lookup(ctx, root, p)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir() {
+		return nil, fs.ErrorNotAFile
+	}
+	return &Object{f: f, remote: remote, e: *e}, nil
+}
+
+// storeBlob stores the contents of in as a blob if it isn't stored
+// already, returning an entry for it with the metadata of src
+func (f *Fs) storeBlob(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (e treeEntry, err error) {
+	// Spool the contents as the hash is needed to name the blob
+	tmp, err := ioutil.TempFile("", "rclone-cas-")
+	if err != nil {
+		return e, errors.Wrap(err, "failed to make temporary file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	sha := sha256.New()
+	hashes, err := hash.NewMultiHasherTypes(hash.NewHashSet(hash.MD5, hash.SHA1))
+	if err != nil {
+		return e, err
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, sha, hashes), in)
+	if err != nil {
+		return e, errors.Wrap(err, "failed to read file")
+	}
+	if srcSize := src.Size(); srcSize >= 0 && srcSize != size {
+		return e, errors.Errorf("read %d bytes expecting %d", size, srcSize)
+	}
+	sums := hashes.Sums()
+	if srcMD5, _ := src.Hash(ctx, hash.MD5); srcMD5 != "" && srcMD5 != sums[hash.MD5] {
+		return e, errors.Errorf("corrupted on transfer: MD5 hash differ %q vs %q", srcMD5, sums[hash.MD5])
+	}
+	e = treeEntry{
+		Blob:    hex.EncodeToString(sha.Sum(nil)),
+		Size:    size,
+		ModTime: src.ModTime(ctx),
+		MD5:     sums[hash.MD5],
+		SHA1:    sums[hash.SHA1],
+	}
+	remote := hashRemote(blobsDir, e.Blob)
+	found, err := f.s.exists(ctx, remote)
+	if err != nil {
+		return e, errors.Wrap(err, "failed to find blob")
+	}
+	if found {
+		fs.Debugf(src, "Blob %s is stored already", e.Blob)
+		return e, nil
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return e, err
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), size, true, sums, f.base)
+	_, err = f.base.Put(ctx, tmp, info, options...)
+	if err != nil {
+		return e, errors.Wrap(err, "failed to store blob")
+	}
+	f.s.setKnown(remote)
+	return e, nil
+}
+
+// put stores the contents of in at remote replacing any file there
+func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string, options ...fs.OpenOption) (*Object, error) {
+	ref, p := f.split(remote)
+	if p == "" {
+		return nil, errors.Errorf("can't store file %q at the root of a tree", remote)
+	}
+	e, err := f.storeBlob(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	err = f.s.change(ctx, []string{ref}, func(roots map[string]string) error {
+		return f.s.attach(ctx, roots, ref, p, e, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Name = path.Base(p)
+	return &Object{f: f, remote: remote, e: e}, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// When called from outside an Fs by rclone, src.Size() will always be >= 0.
+// But for unknown-sized objects (indicated by src.Size() == -1), Put should either
+// return an error or upload it properly (rather than e.g. calling panic).
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, in, src, src.Remote(), options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, in, src, src.Remote(), options...)
+}
+
+// Mkdir makes the directory, making a new tree if it is a ref
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	ref, p := f.split(dir)
+	if ref == "" {
+		return nil
+	}
+	return f.s.change(ctx, []string{ref}, func(roots map[string]string) (err error) {
+		roots[ref], err = f.s.editDir(ctx, roots[ref], splitPath(p), true, func(t *tree) error {
+			return nil
+		})
+		return err
+	})
+}
+
+// Rmdir removes the directory, removing the ref if it is one
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	ref, p := f.split(dir)
+	if ref == "" {
+		return nil
+	}
+	return f.s.change(ctx, []string{ref}, func(roots map[string]string) error {
+		if p == "" {
+			t, err := f.s.lookupDir(ctx, roots[ref], nil)
+			if err != nil {
+				return err
+			}
+			if len(t.Entries) != 0 {
+				return fs.ErrorDirectoryNotEmpty
+			}
+			roots[ref] = ""
+			return nil
+		}
+		parent, leaf := dirAndLeaf(p)
+		root, err := f.s.editDir(ctx, roots[ref], parent, false, func(t *tree) error {
+			e := t.get(leaf)
+			if e == nil || !e.isDir() {
+				return fs.ErrorDirNotFound
+			}
+			sub, err := f.s.tree(ctx, e.Tree)
+			if err != nil {
+				return err
+			}
+			if len(sub.Entries) != 0 {
+				return fs.ErrorDirectoryNotEmpty
+			}
+			t.remove(leaf)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		roots[ref] = root
+		return nil
+	})
+}
+
+// Purge deletes all the files in the directory instantly by removing
+// it from its tree
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	ref, p := f.split(dir)
+	if ref == "" {
+		return fs.ErrorCantPurge
+	}
+	return f.s.change(ctx, []string{ref}, func(roots map[string]string) error {
+		e, err := f.s.detach(ctx, roots, ref, p)
+		if err == fs.ErrorObjectNotFound || (err == nil && !e.isDir()) {
+			return fs.ErrorDirNotFound
+		}
+		return err
+	})
+}
+
+// refsOf returns the distinct refs given
+func refsOf(refs ...string) []string {
+	if len(refs) == 2 && refs[0] == refs[1] {
+		return refs[:1]
+	}
+	return refs
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This only adds the blob of src to the tree so is instant.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.f.s != f.s {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	srcRef, srcP := srcObj.f.split(srcObj.remote)
+	dstRef, dstP := f.split(remote)
+	if dstP == "" {
+		return nil, fs.ErrorCantCopy
+	}
+	var e treeEntry
+	err := f.s.change(ctx, refsOf(srcRef, dstRef), func(roots map[string]string) error {
+		srcEntry, err := f.s.lookup(ctx, roots[srcRef], srcP)
+		if err != nil {
+			return err
+		}
+		if srcEntry.isDir() {
+			return fs.ErrorNotAFile
+		}
+		e = *srcEntry
+		return f.s.attach(ctx, roots, dstRef, dstP, e, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Name = path.Base(dstP)
+	return &Object{f: f, remote: remote, e: e}, nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.f.s != f.s {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	srcRef, srcP := srcObj.f.split(srcObj.remote)
+	dstRef, dstP := f.split(remote)
+	if dstP == "" {
+		return nil, fs.ErrorCantMove
+	}
+	var e treeEntry
+	err := f.s.change(ctx, refsOf(srcRef, dstRef), func(roots map[string]string) (err error) {
+		e, err = f.s.detach(ctx, roots, srcRef, srcP)
+		if err != nil {
+			return err
+		}
+		if e.isDir() {
+			return fs.ErrorNotAFile
+		}
+		return f.s.attach(ctx, roots, dstRef, dstP, e, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Name = path.Base(dstP)
+	return &Object{f: f, remote: remote, e: e}, nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// This moves the tree of the directory so is instant. Moving the
+// root of a ref renames the ref.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs.s != f.s {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcRef, srcP := srcFs.split(srcRemote)
+	dstRef, dstP := f.split(dstRemote)
+	if srcRef == "" || dstRef == "" {
+		return fs.ErrorCantDirMove
+	}
+	if srcRef == dstRef && srcP == dstP {
+		return fs.ErrorDirExists
+	}
+	if srcRef == dstRef && (srcP == "" || strings.HasPrefix(dstP, srcP+"/")) {
+		return errors.New("can't move a directory into itself")
+	}
+	return f.s.change(ctx, refsOf(srcRef, dstRef), func(roots map[string]string) error {
+		_, err := f.s.lookup(ctx, roots[dstRef], dstP)
+		if err == nil {
+			return fs.ErrorDirExists
+		} else if err != fs.ErrorObjectNotFound {
+			return err
+		}
+		e, err := f.s.detach(ctx, roots, srcRef, srcP)
+		if err == fs.ErrorObjectNotFound || (err == nil && !e.isDir()) {
+			return fs.ErrorDirNotFound
+		} else if err != nil {
+			return err
+		}
+		return f.s.attach(ctx, roots, dstRef, dstP, e, false)
+	})
+}
+
+// CleanUp deletes the blobs and trees which no ref refers to.
+//
+// Blobs and trees stored in the last hour are kept as they may belong
+// to a file which is still being uploaded.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	s := f.s
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+	refs, err := f.base.List(ctx, refsDir)
+	if err != nil && err != fs.ErrorDirNotFound {
+		return errors.Wrap(err, "failed to list refs")
+	}
+	used := make(map[string]struct{})
+	seen := make(map[string]struct{})
+	for _, entry := range refs {
+		if _, ok := entry.(fs.Object); !ok {
+			continue
+		}
+		root, err := s.readRef(ctx, path.Base(entry.Remote()))
+		if err != nil {
+			return err
+		}
+		if root == "" {
+			continue
+		}
+		err = s.walk(ctx, root, seen, func(dir, h string) {
+			used[hashRemote(dir, h)] = struct{}{}
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to read trees - not deleting anything")
+		}
+	}
+	s.cacheMu.Lock()
+	s.known = make(map[string]struct{})
+	s.cacheMu.Unlock()
+	toBeDeleted := make(fs.ObjectsChan, fs.GetConfig(ctx).Transfers)
+	var listErr error
+	go func() {
+		defer close(toBeDeleted)
+		for _, dir := range []string{treesDir, blobsDir} {
+			err := walk.ListR(ctx, f.base, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+				entries.ForObject(func(o fs.Object) {
+					if _, found := used[o.Remote()]; found {
+						return
+					}
+					if time.Since(o.ModTime(ctx)) < cleanUpMinAge {
+						return
+					}
+					toBeDeleted <- o
+				})
+				return nil
+			})
+			if err != nil && err != fs.ErrorDirNotFound {
+				listErr = errors.Wrapf(err, "failed to list %s", dir)
+				return
+			}
+		}
+	}()
+	err = operations.DeleteFiles(ctx, toBeDeleted)
+	if listErr != nil {
+		return listErr
+	}
+	return err
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "snapshot",
+	Short: "Copy a directory tree instantly",
+	Long: `This copies the directory src to dst, which mustn't exist, by
+pointing dst at the tree of src, so it takes the same time however
+big the tree is and uses no more space until the copies are changed.
+The paths are relative to the root of the remote and dst may be the
+name of a new ref.
+
+Usage Example:
+
+    rclone backend snapshot cas: src dst
+    rclone backend snapshot cas: projects projects-2021-03-01
+    rclone rc backend/command command=snapshot fs=cas: src dst
+
+It returns the hash of the tree copied.
+`,
+}, {
+	Name:  "rollback",
+	Short: "Replace a directory tree with a snapshot of it",
+	Long: `This replaces the directory dst with a copy of the directory src,
+which is usually a snapshot made earlier of dst. Like snapshot, this
+takes the same time however big the trees are.
+
+Usage Example:
+
+    rclone backend rollback cas: dst src
+    rclone backend rollback cas: projects projects-2021-03-01
+    rclone rc backend/command command=rollback fs=cas: dst src
+
+It returns the hash of the tree dst used to point to, which can be
+used to find the files from before the rollback if it hasn't been
+cleaned up.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "snapshot":
+		if len(arg) != 2 {
+			return nil, errors.New("need src and dst arguments")
+		}
+		return f.copyTree(ctx, arg[0], arg[1], false)
+	case "rollback":
+		if len(arg) != 2 {
+			return nil, errors.New("need dst and src arguments")
+		}
+		return f.copyTree(ctx, arg[1], arg[0], true)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// copyTree points the directory dst at the tree of the directory
+// src, replacing dst if replace is set, and returns the hash of the
+// tree of dst if replaced or of src if not
+func (f *Fs) copyTree(ctx context.Context, src, dst string, replace bool) (h string, err error) {
+	srcRef, srcP := f.split(strings.Trim(src, "/"))
+	dstRef, dstP := f.split(strings.Trim(dst, "/"))
+	if srcRef == "" || dstRef == "" {
+		return "", errors.New("can't copy the root of the remote")
+	}
+	err = f.s.change(ctx, refsOf(srcRef, dstRef), func(roots map[string]string) error {
+		e, err := f.s.lookup(ctx, roots[srcRef], srcP)
+		if err == fs.ErrorObjectNotFound {
+			return errors.Errorf("%q not found", src)
+		} else if err != nil {
+			return err
+		}
+		if !e.isDir() {
+			return errors.Errorf("%q is not a directory", src)
+		}
+		old, err := f.s.lookup(ctx, roots[dstRef], dstP)
+		switch {
+		case err == nil && !replace:
+			return errors.Errorf("%q already exists", dst)
+		case err == nil && !old.isDir():
+			return errors.Errorf("%q is not a directory", dst)
+		case err == nil:
+			h = old.Tree
+		case err == fs.ErrorObjectNotFound:
+			h = e.Tree
+		default:
+			return err
+		}
+		return f.s.attach(ctx, roots, dstRef, dstP, *e, replace)
+	})
+	if err != nil {
+		return "", err
+	}
+	return h, nil
+}
+
+// Object describes a file in a tree
+type Object struct {
+	f      *Fs
+	remote string
+	e      treeEntry
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.e.ModTime
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.e.Size
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	switch ht {
+	case hash.MD5:
+		return o.e.MD5, nil
+	case hash.SHA1:
+		return o.e.SHA1, nil
+	}
+	return "", hash.ErrUnsupported
+}
+
+// ID returns the hash of the contents of the file which is the same
+// for all the files with the same contents
+func (o *Object) ID() string {
+	return o.e.Blob
+}
+
+// Storable returns whether object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// edit calls fn with the entry of the file in its tree to change it
+func (o *Object) edit(ctx context.Context, fn func(t *tree, e *treeEntry) error) error {
+	ref, p := o.f.split(o.remote)
+	parent, leaf := dirAndLeaf(p)
+	return o.f.s.change(ctx, []string{ref}, func(roots map[string]string) error {
+		root, err := o.f.s.editDir(ctx, roots[ref], parent, false, func(t *tree) error {
+			e := t.get(leaf)
+			if e == nil || e.isDir() {
+				return fs.ErrorObjectNotFound
+			}
+			return fn(t, e)
+		})
+		if err == fs.ErrorDirNotFound {
+			return fs.ErrorObjectNotFound
+		} else if err != nil {
+			return err
+		}
+		roots[ref] = root
+		return nil
+	})
+}
+
+// SetModTime sets the modification time of the file in its tree
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	err := o.edit(ctx, func(t *tree, e *treeEntry) error {
+		e.ModTime = modTime
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	o.e.ModTime = modTime
+	return nil
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	blob, err := o.f.base.NewObject(ctx, hashRemote(blobsDir, o.e.Blob))
+	if err == fs.ErrorObjectNotFound {
+		return nil, errors.Errorf("blob %s of %q is missing", o.e.Blob, o.remote)
+	} else if err != nil {
+		return nil, err
+	}
+	return blob.Open(ctx, options...)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newObj, err := o.f.put(ctx, in, src, o.remote, options...)
+	if err != nil {
+		return err
+	}
+	o.e = newObj.e
+	return nil
+}
+
+// Remove removes the file from its tree
+//
+// The blob is left for CleanUp to remove as other files may have the
+// same contents.
+func (o *Object) Remove(ctx context.Context) error {
+	return o.edit(ctx, func(t *tree, e *treeEntry) error {
+		t.remove(e.Name)
+		return nil
+	})
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.Purger      = (*Fs)(nil)
+	_ fs.Copier      = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.DirMover    = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.CleanUpper  = (*Fs)(nil)
+	_ fs.ListRer     = (*Fs)(nil)
+	_ fs.Commander   = (*Fs)(nil)
+	_ fs.Object      = (*Object)(nil)
+	_ fs.IDer        = (*Object)(nil)
+)
@@ -0,0 +1,236 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTree(t *testing.T) {
+	tr := newTree()
+	for _, name := range []string{"b", "c", "a", "b"} {
+		tr.set(treeEntry{Name: name, Blob: name})
+	}
+	require.Len(t, tr.Entries, 3)
+	assert.Equal(t, "a", tr.Entries[0].Name)
+	assert.Equal(t, "c", tr.Entries[2].Name)
+	assert.Equal(t, "b", tr.get("b").Blob)
+	assert.Nil(t, tr.get("d"))
+
+	other := tr.clone()
+	assert.True(t, other.remove("b"))
+	assert.False(t, other.remove("b"))
+	assert.Len(t, other.Entries, 2)
+	assert.Len(t, tr.Entries, 3)
+}
+
+// newTestFs makes a cas Fs storing in a new temporary directory
+// returning it and the directory
+func newTestFs(t *testing.T) (*Fs, string) {
+	dir, err := ioutil.TempDir("", "rclone-cas-internal")
+	require.NoError(t, err)
+	f, err := NewFs(context.Background(), "TestCAS", "", configmap.Simple{"remote": dir})
+	require.NoError(t, err)
+	return f.(*Fs), dir
+}
+
+// put stores contents at remote in f
+func put(t *testing.T, f *Fs, remote, contents string) fs.Object {
+	src := object.NewStaticObjectInfo(remote, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), int64(len(contents)), true, nil, nil)
+	o, err := f.Put(context.Background(), bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+	return o
+}
+
+// read returns the contents of the file at remote in f or "" if it
+// doesn't exist
+func read(t *testing.T, f *Fs, remote string) string {
+	o, err := f.NewObject(context.Background(), remote)
+	if err == fs.ErrorObjectNotFound {
+		return ""
+	}
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+// count returns the number of files in dir in the wrapped remote
+func count(t *testing.T, dir string) (n int) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return n
+}
+
+// remotes returns the remotes of entries
+func remotes(entries fs.DirEntries) (out []string) {
+	for _, entry := range entries {
+		out = append(out, entry.Remote())
+	}
+	return out
+}
+
+func TestSnapshotAndRollback(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	put(t, f, "proj/a.txt", "hello")
+	put(t, f, "proj/sub/b.txt", "hello")
+	put(t, f, "proj/sub/c.txt", "world")
+	assert.Equal(t, 2, count(t, filepath.Join(dir, blobsDir)))
+
+	// Snapshot the tree as a new ref
+	out, err := f.Command(ctx, "snapshot", []string{"proj", "proj-1"}, nil)
+	require.NoError(t, err)
+	root, err := f.s.ref(ctx, "proj")
+	require.NoError(t, err)
+	assert.Equal(t, root, out)
+	_, err = f.Command(ctx, "snapshot", []string{"proj", "proj-1"}, nil)
+	assert.Error(t, err)
+
+	// Snapshot a directory in the tree
+	_, err = f.Command(ctx, "snapshot", []string{"proj/sub", "proj/sub.old"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "world", read(t, f, "proj/sub.old/c.txt"))
+
+	// Change the original
+	put(t, f, "proj/a.txt", "changed")
+	o, err := f.NewObject(ctx, "proj/sub/c.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	assert.Equal(t, "changed", read(t, f, "proj/a.txt"))
+	assert.Equal(t, "", read(t, f, "proj/sub/c.txt"))
+	assert.Equal(t, "hello", read(t, f, "proj-1/a.txt"))
+	assert.Equal(t, "world", read(t, f, "proj-1/sub/c.txt"))
+	assert.Equal(t, 3, count(t, filepath.Join(dir, blobsDir)))
+
+	// Copying shares the blob
+	_, err = f.Copy(ctx, o, "proj/copy.txt")
+	assert.Error(t, err)
+	o, err = f.NewObject(ctx, "proj-1/sub/c.txt")
+	require.NoError(t, err)
+	o2, err := f.Copy(ctx, o, "proj/copy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, o.(*Object).ID(), o2.(*Object).ID())
+	assert.Equal(t, "world", read(t, f, "proj/copy.txt"))
+
+	// Roll back
+	out, err = f.Command(ctx, "rollback", []string{"proj", "proj-1"}, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, root, out)
+	assert.Equal(t, "hello", read(t, f, "proj/a.txt"))
+	assert.Equal(t, "world", read(t, f, "proj/sub/c.txt"))
+	assert.Equal(t, "", read(t, f, "proj/copy.txt"))
+	root2, err := f.s.ref(ctx, "proj")
+	require.NoError(t, err)
+	assert.Equal(t, root, root2)
+
+	// A new Fs reads the refs from the remote
+	f2, err := NewFs(ctx, "TestCAS2", "proj-1/sub", configmap.Simple{"remote": dir})
+	require.NoError(t, err)
+	entries, err := f2.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// Pointing at a file
+	_, err = NewFs(ctx, "TestCAS2", "proj-1/sub/b.txt", configmap.Simple{"remote": dir})
+	assert.Equal(t, fs.ErrorIsFile, err)
+}
+
+func TestDirMoveRef(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	put(t, f, "a/dir/file.txt", "potato")
+
+	// Rename a ref
+	require.NoError(t, f.DirMove(ctx, f, "a", "b"))
+	assert.Equal(t, "", read(t, f, "a/dir/file.txt"))
+	assert.Equal(t, "potato", read(t, f, "b/dir/file.txt"))
+
+	// Move a directory out into a ref
+	require.NoError(t, f.DirMove(ctx, f, "b/dir", "c"))
+	assert.Equal(t, "potato", read(t, f, "c/file.txt"))
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, remotes(entries))
+
+	assert.Equal(t, fs.ErrorDirExists, f.DirMove(ctx, f, "b", "c"))
+	assert.Error(t, f.DirMove(ctx, f, "c", "c/inside"))
+	assert.Equal(t, fs.ErrorDirNotFound, f.DirMove(ctx, f, "a", "d"))
+
+	// Purge a ref
+	require.NoError(t, f.Purge(ctx, "c"))
+	assert.Equal(t, fs.ErrorDirNotFound, f.Purge(ctx, "c"))
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, remotes(entries))
+}
+
+func TestCleanUp(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	put(t, f, "a/one.txt", "one")
+	put(t, f, "a/two.txt", "two")
+	o, err := f.NewObject(ctx, "a/two.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	blobs, trees := filepath.Join(dir, blobsDir), filepath.Join(dir, treesDir)
+	assert.Equal(t, 2, count(t, blobs))
+	assert.Equal(t, 2, count(t, trees))
+
+	// Recent blobs and trees are kept
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, 2, count(t, blobs))
+	assert.Equal(t, 2, count(t, trees))
+
+	// Old ones are removed if unused
+	old := time.Now().Add(-2 * cleanUpMinAge)
+	for _, d := range []string{blobs, trees} {
+		err = filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				err = os.Chtimes(path, old, old)
+			}
+			return err
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, 1, count(t, blobs))
+	assert.Equal(t, 1, count(t, trees))
+	assert.Equal(t, "one", read(t, f, "a/one.txt"))
+
+	// Storing the removed contents again stores the blob again
+	put(t, f, "a/three.txt", "two")
+	assert.Equal(t, "two", read(t, f, "a/three.txt"))
+	assert.Equal(t, 2, count(t, blobs))
+}
@@ -0,0 +1,69 @@
+// Test CAS filesystem interface
+package cas
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var unimplementableFsMethods = []string{
+	"OpenWriterAt",
+	"UpdateWriterAt",
+	"ChangeToken",
+	"Changes",
+	"MergeDirs",
+	"DirCacheFlush",
+	"PutUnchecked",
+	"UserInfo",
+	"Disconnect",
+	"About",
+	"ChangeNotify",
+	"PublicLink",
+	"Shutdown",
+	"WrapFs",
+	"SetWrapper",
+}
+
+var unimplementableObjectMethods = []string{
+	"GetTier",
+	"SetTier",
+	"MimeType",
+	"UnWrap",
+}
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                   *fstest.RemoteName,
+		NilObject:                    (*Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+	})
+}
+
+// TestLocal runs the integration tests over a local directory
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-cas-test")
+	name := "TestCASLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                   name + ":",
+		NilObject:                    (*Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "cas"},
+			{Name: name, Key: "remote", Value: tempdir},
+		},
+	})
+}
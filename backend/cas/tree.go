@@ -0,0 +1,556 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Layout of the wrapped remote
+//
+//     blobs/ab/abcd...  contents of files by SHA-256
+//     trees/ab/abcd...  directories by SHA-256 of their JSON
+//     refs/name         SHA-256 of the root tree of the named tree
+//
+// Blobs and trees never change once written, so a directory tree can
+// be copied by pointing a ref or an entry in a tree at its hash.
+
+const (
+	blobsDir      = "blobs"
+	treesDir      = "trees"
+	refsDir       = "refs"
+	treeVersion   = 1
+	maxTreeSize   = 64 * 1024 * 1024 // trees bigger than this aren't read
+	maxRefSize    = 1024
+	cleanUpMinAge = time.Hour // blobs and trees younger than this are never cleaned up
+)
+
+// treeEntry is a file or directory in a tree
+type treeEntry struct {
+	Name    string    `json:"name"`
+	Tree    string    `json:"tree,omitempty"` // hash of the tree of a directory
+	Blob    string    `json:"blob,omitempty"` // hash of the contents of a file
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	MD5     string    `json:"md5,omitempty"`
+	SHA1    string    `json:"sha1,omitempty"`
+}
+
+// isDir returns whether the entry is a directory
+func (e *treeEntry) isDir() bool {
+	return e.Tree != ""
+}
+
+// tree is a directory stored by the hash of its JSON
+type tree struct {
+	Version int         `json:"ver"`
+	Entries []treeEntry `json:"entries"` // sorted by name
+}
+
+// newTree returns an empty tree
+func newTree() *tree {
+	return &tree{
+		Version: treeVersion,
+		Entries: []treeEntry{},
+	}
+}
+
+// find returns the index of the entry called name, or where it
+// would be inserted, and whether it was found
+func (t *tree) find(name string) (int, bool) {
+	i := sort.Search(len(t.Entries), func(i int) bool {
+		return t.Entries[i].Name >= name
+	})
+	return i, i < len(t.Entries) && t.Entries[i].Name == name
+}
+
+// get returns the entry called name or nil
+func (t *tree) get(name string) *treeEntry {
+	i, found := t.find(name)
+	if !found {
+		return nil
+	}
+	return &t.Entries[i]
+}
+
+// set adds or replaces the entry with the name of e
+func (t *tree) set(e treeEntry) {
+	i, found := t.find(e.Name)
+	if found {
+		t.Entries[i] = e
+		return
+	}
+	t.Entries = append(t.Entries, treeEntry{})
+	copy(t.Entries[i+1:], t.Entries[i:])
+	t.Entries[i] = e
+}
+
+// remove removes the entry called name returning whether it was there
+func (t *tree) remove(name string) bool {
+	i, found := t.find(name)
+	if found {
+		t.Entries = append(t.Entries[:i], t.Entries[i+1:]...)
+	}
+	return found
+}
+
+// clone returns a copy of t which can be changed
+func (t *tree) clone() *tree {
+	return &tree{
+		Version: t.Version,
+		Entries: append([]treeEntry{}, t.Entries...),
+	}
+}
+
+// hashRemote returns the path of the object with hash h in dir
+func hashRemote(dir, h string) string {
+	return path.Join(dir, h[:2], h)
+}
+
+// splitPath splits a path in a tree into its parts
+func splitPath(p string) []string {
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// store is the state shared by the Fs using the same wrapped remote
+type store struct {
+	base    fs.Fs      // the wrapped remote
+	editMu  sync.Mutex // held while changing refs
+	cacheMu sync.Mutex
+	refs    map[string]string   // root tree of each ref read or written
+	trees   map[string]*tree    // trees read or written by hash
+	known   map[string]struct{} // blobs and trees known to be stored
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*store{}
+)
+
+// getStore returns the store for the wrapped remote base
+func getStore(base fs.Fs) *store {
+	key := fs.ConfigString(base)
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	s := stores[key]
+	if s == nil {
+		s = &store{
+			base:  base,
+			refs:  map[string]string{},
+			trees: map[string]*tree{},
+			known: map[string]struct{}{},
+		}
+		stores[key] = s
+	}
+	return s
+}
+
+// isKnown returns whether the object at remote is known to be stored
+func (s *store) isKnown(remote string) bool {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	_, found := s.known[remote]
+	return found
+}
+
+// setKnown records that the object at remote is stored
+func (s *store) setKnown(remote string) {
+	s.cacheMu.Lock()
+	s.known[remote] = struct{}{}
+	s.cacheMu.Unlock()
+}
+
+// exists returns whether the object at remote is stored
+func (s *store) exists(ctx context.Context, remote string) (bool, error) {
+	if s.isKnown(remote) {
+		return true, nil
+	}
+	_, err := s.base.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	s.setKnown(remote)
+	return true, nil
+}
+
+// readSmall reads the object at remote which must be smaller than max
+func (s *store) readSmall(ctx context.Context, remote string, max int64) (data []byte, err error) {
+	o, err := s.base.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	if o.Size() > max {
+		return nil, errors.Errorf("%q is too big", remote)
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	return ioutil.ReadAll(io.LimitReader(in, max))
+}
+
+// putSmall writes data to the object at remote
+func (s *store) putSmall(ctx context.Context, remote string, data []byte) error {
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, s.base)
+	_, err := s.base.Put(ctx, bytes.NewReader(data), info)
+	return err
+}
+
+// tree returns the tree with hash h
+func (s *store) tree(ctx context.Context, h string) (*tree, error) {
+	s.cacheMu.Lock()
+	t := s.trees[h]
+	s.cacheMu.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	data, err := s.readSmall(ctx, hashRemote(treesDir, h), maxTreeSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read tree %s", h)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != h {
+		return nil, errors.Errorf("tree %s is corrupt", h)
+	}
+	t = new(tree)
+	err = json.Unmarshal(data, t)
+	if err != nil {
+		return nil, errors.Wrapf(err, "tree %s is corrupt", h)
+	}
+	if t.Version != treeVersion {
+		return nil, errors.Errorf("tree %s has unknown version %d", h, t.Version)
+	}
+	s.cacheMu.Lock()
+	s.trees[h] = t
+	s.cacheMu.Unlock()
+	return t, nil
+}
+
+// putTree stores t, which mustn't be changed afterwards, returning
+// its hash
+func (s *store) putTree(ctx context.Context, t *tree) (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:])
+	remote := hashRemote(treesDir, h)
+	found, err := s.exists(ctx, remote)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find tree")
+	}
+	if !found {
+		err = s.putSmall(ctx, remote, data)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to write tree")
+		}
+		s.setKnown(remote)
+	}
+	s.cacheMu.Lock()
+	s.trees[h] = t
+	s.cacheMu.Unlock()
+	return h, nil
+}
+
+// readRef reads the hash of the root tree of ref from the wrapped
+// remote, returning "" if there is no such ref
+func (s *store) readRef(ctx context.Context, ref string) (string, error) {
+	data, err := s.readSmall(ctx, path.Join(refsDir, ref), maxRefSize)
+	if err == fs.ErrorObjectNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "failed to read ref %q", ref)
+	}
+	h := strings.TrimSpace(string(data))
+	s.cacheMu.Lock()
+	s.refs[ref] = h
+	s.cacheMu.Unlock()
+	return h, nil
+}
+
+// ref returns the hash of the root tree of ref, reading it if it
+// hasn't been read yet, or "" if there is no such ref
+func (s *store) ref(ctx context.Context, ref string) (string, error) {
+	s.cacheMu.Lock()
+	h, found := s.refs[ref]
+	s.cacheMu.Unlock()
+	if found {
+		return h, nil
+	}
+	return s.readRef(ctx, ref)
+}
+
+// writeRef points ref at the tree with hash h, removing the ref if h
+// is ""
+func (s *store) writeRef(ctx context.Context, ref string, h string) (err error) {
+	remote := path.Join(refsDir, ref)
+	if h == "" {
+		var o fs.Object
+		o, err = s.base.NewObject(ctx, remote)
+		if err == nil {
+			err = o.Remove(ctx)
+		}
+		if err == fs.ErrorObjectNotFound {
+			err = nil
+		}
+	} else {
+		err = s.putSmall(ctx, remote, []byte(h+"\n"))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write ref %q", ref)
+	}
+	s.cacheMu.Lock()
+	s.refs[ref] = h
+	s.cacheMu.Unlock()
+	return nil
+}
+
+// change calls fn with the root trees of refs read afresh, with ""
+// for refs which don't exist, then points the refs at the roots fn
+// leaves in roots.
+//
+// Only one change is made at once by this process, but changes made
+// by other processes at the same time will be lost.
+func (s *store) change(ctx context.Context, refs []string, fn func(roots map[string]string) error) error {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+	roots := make(map[string]string, len(refs))
+	old := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		h, err := s.readRef(ctx, ref)
+		if err != nil {
+			return err
+		}
+		roots[ref], old[ref] = h, h
+	}
+	err := fn(roots)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if roots[ref] != old[ref] {
+			err = s.writeRef(ctx, ref, roots[ref])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupDir returns the tree of the directory at dir in the tree root
+//
+// It returns fs.ErrorDirNotFound if it doesn't exist.
+func (s *store) lookupDir(ctx context.Context, root string, dir []string) (*tree, error) {
+	if root == "" {
+		return nil, fs.ErrorDirNotFound
+	}
+	t, err := s.tree(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range dir {
+		e := t.get(name)
+		if e == nil || !e.isDir() {
+			return nil, fs.ErrorDirNotFound
+		}
+		t, err = s.tree(ctx, e.Tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// editDir calls fn with a copy of the tree of the directory at dir
+// in the tree root and stores the trees changed, returning the hash
+// of the new root.
+//
+// Directories which don't exist are made if create is set, otherwise
+// fs.ErrorDirNotFound is returned. A root of "" is an empty tree.
+func (s *store) editDir(ctx context.Context, root string, dir []string, create bool, fn func(t *tree) error) (string, error) {
+	var t *tree
+	if root == "" {
+		if !create {
+			return "", fs.ErrorDirNotFound
+		}
+		t = newTree()
+	} else {
+		old, err := s.tree(ctx, root)
+		if err != nil {
+			return "", err
+		}
+		t = old.clone()
+	}
+	if len(dir) == 0 {
+		err := fn(t)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		e := t.get(dir[0])
+		sub, modTime := "", time.Now()
+		if e != nil {
+			if !e.isDir() {
+				return "", errors.Errorf("%q is a file not a directory", dir[0])
+			}
+			sub, modTime = e.Tree, e.ModTime
+		}
+		newSub, err := s.editDir(ctx, sub, dir[1:], create, fn)
+		if err != nil {
+			return "", err
+		}
+		t.set(treeEntry{Name: dir[0], Tree: newSub, ModTime: modTime})
+	}
+	return s.putTree(ctx, t)
+}
+
+// walk calls fn for each tree reachable from the tree root and each
+// blob in them, reading each tree once
+func (s *store) walk(ctx context.Context, root string, seen map[string]struct{}, fn func(dir, h string)) error {
+	if _, found := seen[root]; found {
+		return nil
+	}
+	seen[root] = struct{}{}
+	fn(treesDir, root)
+	t, err := s.tree(ctx, root)
+	if err != nil {
+		return err
+	}
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		if e.isDir() {
+			err = s.walk(ctx, e.Tree, seen, fn)
+			if err != nil {
+				return err
+			}
+		} else {
+			fn(blobsDir, e.Blob)
+		}
+	}
+	return nil
+}
+
+// dirAndLeaf splits p into the parts of its directory and its leaf
+func dirAndLeaf(p string) ([]string, string) {
+	dir, leaf := path.Split(p)
+	return splitPath(strings.TrimSuffix(dir, "/")), leaf
+}
+
+// lookup returns the entry at p in the tree root, or an entry for
+// the root itself if p is "".
+//
+// It returns fs.ErrorObjectNotFound if it doesn't exist.
+func (s *store) lookup(ctx context.Context, root string, p string) (*treeEntry, error) {
+	if p == "" {
+		if root == "" {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return &treeEntry{Tree: root, ModTime: time.Now()}, nil
+	}
+	dir, leaf := dirAndLeaf(p)
+	t, err := s.lookupDir(ctx, root, dir)
+	if err == fs.ErrorDirNotFound {
+		return nil, fs.ErrorObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	e := t.get(leaf)
+	if e == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return e, nil
+}
+
+// attach puts e at p in the tree of ref in roots, making the
+// directories above it.
+//
+// If something is at p already it is replaced if replace is set
+// and is the same type as e, otherwise an error is returned.
+func (s *store) attach(ctx context.Context, roots map[string]string, ref string, p string, e treeEntry, replace bool) error {
+	if p == "" {
+		if !e.isDir() {
+			return errors.New("can't put a file at the root of a tree")
+		}
+		if roots[ref] != "" && !replace {
+			return fs.ErrorDirExists
+		}
+		roots[ref] = e.Tree
+		return nil
+	}
+	dir, leaf := dirAndLeaf(p)
+	root, err := s.editDir(ctx, roots[ref], dir, true, func(t *tree) error {
+		if old := t.get(leaf); old != nil {
+			if old.isDir() && !e.isDir() {
+				return errors.Errorf("can't replace directory %q with a file", p)
+			} else if !old.isDir() && e.isDir() {
+				return errors.Errorf("can't replace file %q with a directory", p)
+			} else if !replace {
+				if old.isDir() {
+					return fs.ErrorDirExists
+				}
+				return errors.Errorf("%q already exists", p)
+			}
+		}
+		e.Name = leaf
+		t.set(e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	roots[ref] = root
+	return nil
+}
+
+// detach removes the entry at p from the tree of ref in roots and
+// returns it. If p is "" then the whole ref is removed.
+//
+// It returns fs.ErrorObjectNotFound if it doesn't exist.
+func (s *store) detach(ctx context.Context, roots map[string]string, ref string, p string) (e treeEntry, err error) {
+	if p == "" {
+		if roots[ref] == "" {
+			return e, fs.ErrorObjectNotFound
+		}
+		e = treeEntry{Tree: roots[ref], ModTime: time.Now()}
+		roots[ref] = ""
+		return e, nil
+	}
+	dir, leaf := dirAndLeaf(p)
+	root, err := s.editDir(ctx, roots[ref], dir, false, func(t *tree) error {
+		old := t.get(leaf)
+		if old == nil {
+			return fs.ErrorObjectNotFound
+		}
+		e = *old
+		t.remove(leaf)
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		return e, fs.ErrorObjectNotFound
+	} else if err != nil {
+		return e, err
+	}
+	roots[ref] = root
+	return e, nil
+}
@@ -204,6 +204,21 @@ It has the following fields: ver, size, nchunks, md5, sha1.`,
 				Value: "sha1quick",
 				Help:  `Similar to "md5quick" but prefers SHA1 over MD5`,
 			}},
+		}, {
+			Name:     "transfers",
+			Advanced: true,
+			Default:  1,
+			Help: `Number of chunks of a file to upload or download at once.
+
+If this is more than 1 the chunks of files with more than one chunk
+are uploaded and downloaded in parallel, which can speed up transfers
+of large files to and from remotes which are slow for a single
+stream.
+
+Each chunk being transferred is buffered in memory, so up to
+chunk_size * transfers of memory may be used for each file
+transferred. Set chunk_size to something small like 100M when using
+this.`,
 		}, {
 			Name:     "fail_hard",
 			Advanced: true,
@@ -233,6 +248,9 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if opt.StartFrom < 0 {
 		return nil, errors.New("start_from must be non-negative")
 	}
+	if opt.Transfers < 1 {
+		return nil, errors.New("transfers must be at least 1")
+	}
 
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
@@ -309,6 +327,7 @@ type Options struct {
 	StartFrom  int           `config:"start_from"`
 	MetaFormat string        `config:"meta_format"`
 	HashType   string        `config:"hash_type"`
+	Transfers  int           `config:"transfers"`
 	FailHard   bool          `config:"fail_hard"`
 }
 
@@ -940,7 +959,12 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote st
 		return nil, errXact
 	}
 
-	// Transfer chunks data
+	// Transfer chunks data, several at once if more than one is expected
+	if f.opt.Transfers > 1 && c.sizeTotal > c.chunkSize {
+		if err = f.putParallel(ctx, c, wrapIn, src, baseRemote, xactID, options, basePut); err != nil {
+			return nil, err
+		}
+	}
 	for c.chunkNo = 0; !c.done; c.chunkNo++ {
 		if c.chunkNo > maxSafeChunkNumber {
 			return nil, ErrChunkOverflow
@@ -1358,6 +1382,33 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	return f.base.Rmdir(ctx, dir)
 }
 
+// DirSetModTime sets the modification time of the directory dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	do := f.base.Features().DirSetModTime
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, modTime)
+}
+
+// DirMetadata returns the metadata of the directory dir
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	do := f.base.Features().DirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	return do(ctx, dir)
+}
+
+// DirSetMetadata sets the metadata of the directory dir
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	do := f.base.Features().DirSetMetadata
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, metadata)
+}
+
 // Purge all files in the directory
 //
 // Implement this if you have a way of deleting all the files
@@ -1954,6 +2005,9 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		limit = o.size - offset
 	}
 
+	if o.f.opt.Transfers > 1 && len(o.chunks) > 1 {
+		return o.newParallelReader(ctx, offset, limit, openOptions), nil
+	}
 	return o.newLinearReader(ctx, offset, limit, openOptions)
 }
 
@@ -2300,6 +2354,8 @@ var (
 	_ fs.Copier          = (*Fs)(nil)
 	_ fs.Mover           = (*Fs)(nil)
 	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirSetModTimer  = (*Fs)(nil)
+	_ fs.DirMetadataer   = (*Fs)(nil)
 	_ fs.PutUncheckeder  = (*Fs)(nil)
 	_ fs.PutStreamer     = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
@@ -663,6 +663,59 @@ func testMetadataInput(t *testing.T, f *Fs) {
 	runSubtest(futureMeta, "future")
 }
 
+// test uploading and downloading the chunks of a file in parallel
+func testParallelTransfers(t *testing.T, f *Fs) {
+	const dir = "parallel"
+	ctx := context.Background()
+	saveOpt := f.opt
+	defer func() {
+		_ = operations.Purge(ctx, f.base, dir)
+		f.opt = saveOpt
+	}()
+	f.opt.ChunkSize = 10
+	f.opt.Transfers = 4
+
+	modTime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	contents := random.String(95)
+	item := fstest.Item{Path: path.Join(dir, "file"), ModTime: modTime}
+	_, obj := fstests.PutTestContents(ctx, t, f, &item, contents, true)
+	require.NotNil(t, obj)
+	o := obj.(*Object)
+	assert.Equal(t, 10, len(o.chunks))
+	assert.Equal(t, int64(95), o.Size())
+
+	// No temporary chunks are left behind
+	entries, err := f.base.List(ctx, dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		_, _, _, xactID := f.parseChunkName(entry.Remote())
+		assert.Equal(t, "", xactID, entry.Remote())
+	}
+
+	readRange := func(options ...fs.OpenOption) string {
+		in, err := obj.Open(ctx, options...)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return string(data)
+	}
+	assert.Equal(t, contents, readRange())
+	assert.Equal(t, contents[33:], readRange(&fs.SeekOption{Offset: 33}))
+	assert.Equal(t, contents[15:65], readRange(&fs.RangeOption{Start: 15, End: 64}))
+	assert.Equal(t, contents[90:], readRange(&fs.RangeOption{Start: -1, End: 5}))
+	assert.Equal(t, "", readRange(&fs.SeekOption{Offset: 95}))
+
+	// Closing before the end stops the downloads
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = in.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, contents[:5], string(buf))
+	require.NoError(t, in.Close())
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("PutLarge", func(t *testing.T) {
@@ -686,6 +739,9 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("MetadataInput", func(t *testing.T) {
 		testMetadataInput(t, f)
 	})
+	t.Run("ParallelTransfers", func(t *testing.T) {
+		testParallelTransfers(t, f)
+	})
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
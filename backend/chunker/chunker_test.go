@@ -39,6 +39,9 @@ func TestIntegration(t *testing.T) {
 		UnimplementableFsMethods: []string{
 			"PublicLink",
 			"OpenWriterAt",
+			"UpdateWriterAt",
+			"ChangeToken",
+			"Changes",
 			"MergeDirs",
 			"DirCacheFlush",
 			"UserInfo",
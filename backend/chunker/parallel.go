@@ -0,0 +1,226 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"golang.org/x/sync/errgroup"
+)
+
+// putParallel uploads the data chunks of a file of known size read
+// from wrapIn, up to opt.Transfers of them at once, leaving c done.
+//
+// The chunks are read in order into memory so the hashes and the
+// accounting work as they do for sequential uploads.
+func (f *Fs) putParallel(ctx context.Context, c *chunkingReader, wrapIn io.Reader, src fs.ObjectInfo, baseRemote, xactID string, options []fs.OpenOption, basePut putFn) error {
+	in, wrap := accounting.UnWrap(wrapIn)
+	g, gCtx := errgroup.WithContext(ctx)
+	buffers := make(chan []byte, f.opt.Transfers)
+	for i := 0; i < f.opt.Transfers; i++ {
+		buffers <- nil
+	}
+	var mu sync.Mutex
+	var chunks []fs.Object
+	var err error
+	for c.chunkNo = 0; c.sizeLeft > 0; c.chunkNo++ {
+		if c.chunkNo > maxSafeChunkNumber {
+			err = ErrChunkOverflow
+			break
+		}
+		// Wait for a free buffer
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-gCtx.Done():
+		}
+		if gCtx.Err() != nil {
+			break
+		}
+		size := c.sizeLeft
+		if size > c.chunkSize {
+			size = c.chunkSize
+		}
+		if int64(cap(buf)) < size {
+			buf = make([]byte, c.chunkSize)
+		}
+		buf = buf[:size]
+
+		// Read the chunk
+		c.chunkLimit = c.chunkSize
+		var n int
+		n, err = io.ReadFull(in, buf)
+		if c.err != nil {
+			err = c.err
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// too short - noticed by the size check in put
+				err = nil
+			}
+			break
+		}
+
+		// Upload it in the background
+		chunkNo := c.chunkNo
+		mu.Lock()
+		chunks = append(chunks, nil)
+		mu.Unlock()
+		g.Go(func() error {
+			defer func() {
+				buffers <- buf
+			}()
+			tempRemote := f.makeChunkName(baseRemote, chunkNo, "", xactID)
+			info := f.wrapInfo(src, tempRemote, int64(n))
+			chunk, err := basePut(gCtx, wrap(bytes.NewReader(buf[:n])), info, options...)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			chunks[chunkNo] = chunk
+			mu.Unlock()
+			return nil
+		})
+	}
+	if waitErr := g.Wait(); err == nil {
+		err = waitErr
+	}
+	c.done = true
+
+	// Keep the chunks uploaded so they are removed on failure
+	for _, chunk := range chunks {
+		if chunk != nil {
+			c.chunks = append(c.chunks, chunk)
+		}
+	}
+	if err == nil && len(c.chunks) != len(chunks) {
+		err = errors.New("chunk upload missing")
+	}
+	return err
+}
+
+// readPart is the contents of part of a file read by a parallelReader
+type readPart struct {
+	data []byte
+	err  error
+}
+
+// parallelReader reads the chunks of a file, downloading up to
+// opt.Transfers of them at once into memory
+type parallelReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	parts  []chan readPart // the parts to read in order
+	slots  chan struct{}   // a slot for each part being downloaded
+	pos    int             // index of the next part to read
+	buf    []byte          // unread data of the current part
+	err    error
+}
+
+// newParallelReader returns a reader for limit bytes of o from offset
+// which reads its chunks in parallel
+func (o *Object) newParallelReader(ctx context.Context, offset, limit int64, options []fs.OpenOption) io.ReadCloser {
+	// Work out which parts of which chunks to read
+	type chunkPart struct {
+		chunk        fs.Object
+		offset, size int64
+	}
+	var toRead []chunkPart
+	for _, chunk := range o.chunks {
+		if limit <= 0 {
+			break
+		}
+		size := chunk.Size()
+		if offset >= size {
+			offset -= size
+			continue
+		}
+		size -= offset
+		if size > limit {
+			size = limit
+		}
+		toRead = append(toRead, chunkPart{chunk: chunk, offset: offset, size: size})
+		offset = 0
+		limit -= size
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &parallelReader{
+		ctx:    ctx,
+		cancel: cancel,
+		parts:  make([]chan readPart, len(toRead)),
+		slots:  make(chan struct{}, o.f.opt.Transfers),
+	}
+	for i := range r.parts {
+		r.parts[i] = make(chan readPart, 1)
+	}
+	go func() {
+		for i, part := range toRead {
+			select {
+			case r.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, chunk fs.Object, offset, size int64) {
+				data, err := readChunkPart(ctx, chunk, offset, size, options)
+				r.parts[i] <- readPart{data: data, err: err}
+			}(i, part.chunk, part.offset, part.size)
+		}
+	}()
+	return r
+}
+
+// readChunkPart reads size bytes from offset of chunk into memory
+func readChunkPart(ctx context.Context, chunk fs.Object, offset, size int64, options []fs.OpenOption) (data []byte, err error) {
+	options = append(options[:len(options):len(options)], &fs.RangeOption{Start: offset, End: offset + size - 1})
+	in, err := chunk.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	data = make([]byte, size)
+	_, err = io.ReadFull(in, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read chunk %q", chunk.Remote())
+	}
+	return data, nil
+}
+
+// Read as per io.Reader
+func (r *parallelReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.pos >= len(r.parts) {
+			r.err = io.EOF
+			continue
+		}
+		select {
+		case part := <-r.parts[r.pos]:
+			r.buf, r.err = part.data, part.err
+			r.pos++
+			<-r.slots // let the next part start downloading
+		case <-r.ctx.Done():
+			r.err = r.ctx.Err()
+		}
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops any downloads in progress
+func (r *parallelReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// check interfaces
+var (
+	_ io.ReadCloser = (*parallelReader)(nil)
+)
@@ -12,12 +12,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/buengese/sgzip"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
@@ -40,6 +43,7 @@ const (
 	minCompressionRatio = 1.1
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -48,6 +52,7 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
 )
 
 var nameRegexp = regexp.MustCompile("^(.+?)\\.([A-Za-z0-9+_]{11})$")
@@ -59,6 +64,9 @@ func init() {
 		{ // Default compression mode options {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
+		}, {
+			Value: "zstd",
+			Help:  "Zstandard compression - faster and smaller than gzip but slower to seek in.",
 		},
 	}
 
@@ -78,26 +86,40 @@ func init() {
 			Examples: compressionModeOptions,
 		}, {
 			Name: "level",
-			Help: `GZIP compression level (-2 to 9).
-
-			Generally -1 (default, equivalent to 5) is recommended.
-			Levels 1 to 9 increase compressiong at the cost of speed.. Going past 6 
-			generally offers very little return.
-			
-			Level -2 uses Huffmann encoding only. Only use if you now what you
-			are doing
-			Level 0 turns off compression.`,
+			Help: `Compression level (-2 to 9 for gzip, 1 to 22 for zstd).
+
+Generally -1 (default, equivalent to 5 for gzip and 3 for zstd) is
+recommended.
+
+For gzip levels 1 to 9 increase compression at the cost of speed. Going past 6
+generally offers very little return.
+Level -2 uses Huffman encoding only. Only use if you know what you
+are doing.
+Level 0 turns off compression.
+
+For zstd levels 1 and 2 are the fastest, 3 to 5 the default and 6 and
+over give the best compression.`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
+		}, {
+			Name: "skip_extensions",
+			Help: `Comma separated list of file extensions not to compress.
+
+Files with these extensions are usually compressed already so are
+stored uncompressed without checking how well they compress. The
+extensions are matched case insensitively and without the dot.`,
+			Default:  fs.CommaSepList{"7z", "avi", "bz2", "flac", "gif", "gz", "jpeg", "jpg", "m4a", "mkv", "mov", "mp3", "mp4", "ogg", "png", "rar", "webm", "webp", "xz", "zip", "zst"},
+			Advanced: true,
 		}},
 	})
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote           string `config:"remote"`
-	CompressionMode  string `config:"mode"`
-	CompressionLevel int    `config:"level"`
+	Remote           string          `config:"remote"`
+	CompressionMode  string          `config:"mode"`
+	CompressionLevel int             `config:"level"`
+	SkipExtensions   fs.CommaSepList `config:"skip_extensions"`
 }
 
 /*** FILESYSTEM FUNCTIONS ***/
@@ -122,6 +144,11 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		return nil, err
 	}
 
+	mode := compressionModeFromName(opt.CompressionMode)
+	if mode == Uncompressed {
+		return nil, errors.Errorf("unknown compression mode %q", opt.CompressionMode)
+	}
+
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
 		return nil, errors.New("can't point press remote at itself - check the value of the remote setting")
@@ -153,7 +180,7 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		name: name,
 		root: rpath,
 		opt:  *opt,
-		mode: compressionModeFromName(opt.CompressionMode),
+		mode: mode,
 	}
 	// the features here are ones we could support, and they are
 	// ANDed with the ones from wrappedFs
@@ -181,6 +208,8 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd":
+		return Zstd
 	default:
 		return Uncompressed
 	}
@@ -223,7 +252,19 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("Could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
+}
+
+// Returns the file extension of a data file with specified compression mode
+func dataFileExt(mode int) string {
+	switch mode {
+	case Zstd:
+		return zstdFileExt
+	case Gzip:
+		return gzFileExt
+	default:
+		return uncompressedFileExt
+	}
 }
 
 // Generates the file name for a metadata file
@@ -239,7 +280,7 @@ func isMetadataFile(filename string) bool {
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
 	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
+		newRemote = remote + "." + int64ToBase64(size) + dataFileExt(mode)
 	} else {
 		newRemote = remote + uncompressedFileExt
 	}
@@ -356,9 +397,26 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	return f.newObject(o, mo, meta), err
 }
 
+// skipCompression returns true if remote has one of the extensions
+// in the skip_extensions list so shouldn't be compressed
+func (f *Fs) skipCompression(remote string) bool {
+	ext := strings.TrimPrefix(path.Ext(remote), ".")
+	if ext == "" {
+		return false
+	}
+	for _, skip := range f.opt.SkipExtensions {
+		if strings.EqualFold(ext, strings.TrimPrefix(skip, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
 // checkCompressAndType checks if an object is compressible and determines it's mime type
 // returns a multireader with the bytes that were read to determine mime type
-func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool, mimeType string, err error) {
+//
+// Objects with an extension in the skip_extensions list are never compressible.
+func (f *Fs) checkCompressAndType(in io.Reader, remote string) (newReader io.Reader, compressible bool, mimeType string, err error) {
 	in, wrap := accounting.UnWrap(in)
 	buf := make([]byte, heuristicBytes)
 	n, err := in.Read(buf)
@@ -367,9 +425,11 @@ func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool,
 		return nil, false, "", err
 	}
 	mime := mimetype.Detect(buf)
-	compressible, err = isCompressible(bytes.NewReader(buf))
-	if err != nil {
-		return nil, false, "", err
+	if !f.skipCompression(remote) {
+		compressible, err = isCompressible(bytes.NewReader(buf))
+		if err != nil {
+			return nil, false, "", err
+		}
 	}
 	in = io.MultiReader(bytes.NewReader(buf), in)
 	return wrap(in), compressible, mime.String(), nil
@@ -416,6 +476,22 @@ type compressionResult struct {
 	meta sgzip.GzipMetadata
 }
 
+// newCompressor returns a writer which compresses into w with the
+// compression mode and level of the Fs
+func (f *Fs) newCompressor(w io.Writer) (io.WriteCloser, error) {
+	switch f.mode {
+	case Gzip:
+		return sgzip.NewWriterLevel(w, f.opt.CompressionLevel)
+	case Zstd:
+		level := zstd.SpeedDefault
+		if f.opt.CompressionLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(f.opt.CompressionLevel)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	}
+	return nil, errors.Errorf("unknown compression mode %d", f.mode)
+}
+
 // Put a compressed version of a file. Returns a wrappable object and metadata.
 func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption, put putFn, mimeType string) (fs.Object, *ObjectMetadata, error) {
 	// Unwrap reader accounting
@@ -429,12 +505,13 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
+		gz, err := f.newCompressor(pipeWriter)
 		if err != nil {
+			_ = pipeWriter.CloseWithError(err)
 			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
 			return
 		}
-		_, err = io.Copy(gz, in)
+		n, err := io.Copy(gz, in)
 		gzErr := gz.Close()
 		if gzErr != nil {
 			fs.Errorf(nil, "Failed to close compress: %v", gzErr)
@@ -449,7 +526,12 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 				err = closeErr
 			}
 		}
-		results <- compressionResult{err: err, meta: gz.MetaData()}
+		// Only gzip has metadata for seeking so just record the size for the others
+		meta := sgzip.GzipMetadata{Size: n}
+		if sgz, ok := gz.(*sgzip.Writer); ok {
+			meta = sgz.MetaData()
+		}
+		results <- compressionResult{err: err, meta: meta}
 	}()
 	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
 
@@ -617,7 +699,7 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	o, err := f.NewObject(ctx, src.Remote())
 	if err == fs.ErrorObjectNotFound {
 		// Get our file compressibility
-		in, compressible, mimeType, err := checkCompressAndType(in)
+		in, compressible, mimeType, err := f.checkCompressAndType(in, src.Remote())
 		if err != nil {
 			return nil, err
 		}
@@ -637,7 +719,7 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 	}
 	found := err == nil
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := f.checkCompressAndType(in, src.Remote())
 	if err != nil {
 		return nil, err
 	}
@@ -694,6 +776,33 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	return f.Fs.Rmdir(ctx, dir)
 }
 
+// DirSetModTime sets the modification time of the directory dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	do := f.Fs.Features().DirSetModTime
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, modTime)
+}
+
+// DirMetadata returns the metadata of the directory dir
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	do := f.Fs.Features().DirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	return do(ctx, dir)
+}
+
+// DirSetMetadata sets the metadata of the directory dir
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	do := f.Fs.Features().DirSetMetadata
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, metadata)
+}
+
 // Purge all files in the root and the root directory
 //
 // Implement this if you have a way of deleting all the files
@@ -1016,7 +1125,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		return o.mo, o.mo.Update(ctx, in, src, options...)
 	}
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := o.f.checkCompressAndType(in, src.Remote())
 	if err != nil {
 		return err
 	}
@@ -1206,14 +1315,34 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	}
 	// Get a chunkedreader for the wrapped object
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize)
+	var closer io.Closer = chunkedReader
 	// Get file handle
 	var file io.Reader
-	if offset != 0 {
-		file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
-	} else {
-		file, err = sgzip.NewReader(chunkedReader)
+	switch o.meta.Mode {
+	case Zstd:
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(chunkedReader)
+		if err != nil {
+			break
+		}
+		file = zr
+		closer = zstdCloser{Decoder: zr, in: chunkedReader}
+		// zstd streams can't be seeked so read up to the offset
+		if offset != 0 {
+			_, err = io.CopyN(ioutil.Discard, zr, offset)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+	default:
+		if offset != 0 {
+			file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
+		} else {
+			file, err = sgzip.NewReader(chunkedReader)
+		}
 	}
 	if err != nil {
+		_ = closer.Close()
 		return nil, err
 	}
 
@@ -1224,7 +1353,19 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		fileReader = file
 	}
 	// Return a ReadCloser
-	return ReadCloserWrapper{Reader: fileReader, Closer: chunkedReader}, nil
+	return ReadCloserWrapper{Reader: fileReader, Closer: closer}, nil
+}
+
+// zstdCloser closes a zstd decoder and the reader it is reading from
+type zstdCloser struct {
+	*zstd.Decoder
+	in io.Closer
+}
+
+// Close stops the decoder and closes the underlying reader
+func (z zstdCloser) Close() error {
+	z.Decoder.Close()
+	return z.in.Close()
 }
 
 // ObjectInfo describes a wrapped fs.ObjectInfo for being the source
@@ -1337,6 +1478,8 @@ var (
 	_ fs.Copier          = (*Fs)(nil)
 	_ fs.Mover           = (*Fs)(nil)
 	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirSetModTimer  = (*Fs)(nil)
+	_ fs.DirMetadataer   = (*Fs)(nil)
 	_ fs.PutStreamer     = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.UnWrapper       = (*Fs)(nil)
@@ -0,0 +1,104 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataName(t *testing.T) {
+	for _, mode := range []int{Uncompressed, Gzip, Zstd} {
+		name := makeDataName("dir/file.txt", 12345, mode)
+		origName, ext, size, err := processFileName(name)
+		require.NoError(t, err)
+		assert.Equal(t, "dir/file.txt", origName)
+		assert.Equal(t, dataFileExt(mode), ext)
+		if mode == Uncompressed {
+			assert.Equal(t, int64(-2), size)
+		} else {
+			assert.Equal(t, int64(12345), size)
+		}
+	}
+}
+
+func TestSkipCompression(t *testing.T) {
+	f := &Fs{opt: Options{SkipExtensions: fs.CommaSepList{"mp4", ".jpg"}}}
+	for _, test := range []struct {
+		remote string
+		want   bool
+	}{
+		{"video.mp4", true},
+		{"dir/VIDEO.MP4", true},
+		{"photo.jpg", true},
+		{"file.txt", false},
+		{"mp4", false},
+		{"mp4.dir/file", false},
+	} {
+		assert.Equal(t, test.want, f.skipCompression(test.remote), test.remote)
+	}
+}
+
+// Test that files written in different modes and skipped files can
+// all be read back from the same remote
+func TestMixedModes(t *testing.T) {
+	ctx := context.Background()
+	tempdir, err := ioutil.TempDir("", "rclone-compress-test-mixed")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(tempdir)
+	}()
+	newFs := func(mode string) *Fs {
+		f, err := NewFs(ctx, "TestCompressMixed", "", configmap.Simple{
+			"remote":          tempdir,
+			"mode":            mode,
+			"skip_extensions": "mp4",
+		})
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	put := func(f *Fs, remote, contents string) {
+		src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(contents)), true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString(contents), src)
+		require.NoError(t, err)
+	}
+	contents := strings.Repeat("compressible ", 1000)
+	gzipFs, zstdFs := newFs("gzip"), newFs("zstd")
+	put(gzipFs, "gzip.txt", contents)
+	put(zstdFs, "zstd.txt", contents)
+	put(zstdFs, "video.mp4", contents)
+
+	_, err = NewFs(ctx, "TestCompressMixed", "", configmap.Simple{"remote": tempdir, "mode": "potato"})
+	assert.Error(t, err)
+
+	for remote, mode := range map[string]int{
+		"gzip.txt":  Gzip,
+		"zstd.txt":  Zstd,
+		"video.mp4": Uncompressed,
+	} {
+		for _, f := range []*Fs{gzipFs, zstdFs} {
+			obj, err := f.NewObject(ctx, remote)
+			require.NoError(t, err)
+			o := obj.(*Object)
+			assert.Equal(t, mode, o.meta.Mode, remote)
+			assert.Equal(t, int64(len(contents)), o.Size())
+
+			in, err := o.Open(ctx, &fs.RangeOption{Start: 100, End: 199})
+			require.NoError(t, err)
+			data, err := ioutil.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, contents[100:200], string(data), remote)
+		}
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"context"
 	"crypto/aes"
 	gocipher "crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"strings"
@@ -34,6 +37,17 @@ const (
 	blockDataSize       = 64 * 1024
 	blockSize           = blockHeaderSize + blockDataSize
 	encryptedSuffix     = ".bin" // when file name encryption is off we add this suffix to make sure the cloud provider doesn't process the file
+	fileMagicV2         = "RCLONE\x00\x02"
+	fileKeySize         = 32
+	fileKeyWrappedSize  = secretbox.Overhead + fileKeySize
+	fileHeaderSizeV2    = fileHeaderSize + fileKeyWrappedSize
+	encryptedSuffixV2   = ".bin2" // suffix for format v2 files when file name encryption is off
+	nameMarkerV2        = "z"     // encrypted names of format v2 files start with this
+	fileMagicV3         = "RCLONE\x00\x03"
+	fileTrailerSizeV3   = sha256.Size
+	encryptedSuffixV3   = ".bin3" // suffix for format v3 files when file name encryption is off
+	nameMarkerV3        = "y"     // encrypted names of format v3 files start with this
+	hmacKeyInfo         = "rclone crypt content hmac"
 )
 
 // Errors returned by cipher
@@ -47,6 +61,8 @@ var (
 	ErrorEncryptedFileBadHeader  = errors.New("file has truncated block header")
 	ErrorEncryptedBadMagic       = errors.New("not an encrypted file - bad magic string")
 	ErrorEncryptedBadBlock       = errors.New("failed to authenticate decrypted block - bad password?")
+	ErrorEncryptedBadKey         = errors.New("failed to authenticate file key - bad password?")
+	ErrorEncryptedBadHMAC        = errors.New("failed to authenticate file contents - file truncated or corrupted?")
 	ErrorBadBase32Encoding       = errors.New("bad base32 filename encoding")
 	ErrorFileClosed              = errors.New("file already closed")
 	ErrorNotAnEncryptedFile      = errors.New("not an encrypted file - no \"" + encryptedSuffix + "\" suffix")
@@ -57,7 +73,9 @@ var (
 
 // Global variables
 var (
-	fileMagicBytes = []byte(fileMagic)
+	fileMagicBytes   = []byte(fileMagic)
+	fileMagicV2Bytes = []byte(fileMagicV2)
+	fileMagicV3Bytes = []byte(fileMagicV3)
 )
 
 // ReadSeekCloser is the interface of the read handles
@@ -112,6 +130,95 @@ func (mode NameEncryptionMode) String() (out string) {
 	return out
 }
 
+// fileFormat is the format of the encrypted files and their names
+type fileFormat int
+
+// fileFormat versions
+const (
+	// fileFormatV1 encrypts the data of every file with the data key
+	fileFormatV1 fileFormat = iota
+	// fileFormatV2 encrypts the data of each file with a random key
+	// which is stored in the header encrypted with the data key, and
+	// marks the names of the files so the format is known without
+	// reading them
+	fileFormatV2
+	// fileFormatV3 is format v2 with an HMAC of the contents of the
+	// file appended so they can be checked without reading it all
+	fileFormatV3
+)
+
+// newFileFormat turns a string into a fileFormat
+func newFileFormat(s string) (format fileFormat, err error) {
+	switch strings.ToLower(s) {
+	case "v1", "":
+		format = fileFormatV1
+	case "v2":
+		format = fileFormatV2
+	case "v3":
+		format = fileFormatV3
+	default:
+		err = errors.Errorf("Unknown file format %q", s)
+	}
+	return format, err
+}
+
+// String turns format into a human readable string
+func (format fileFormat) String() string {
+	switch format {
+	case fileFormatV1:
+		return "v1"
+	case fileFormatV2:
+		return "v2"
+	case fileFormatV3:
+		return "v3"
+	}
+	return fmt.Sprintf("Unknown format #%d", int(format))
+}
+
+// headerSize returns the size of the header of files in this format
+func (format fileFormat) headerSize() int {
+	if format == fileFormatV1 {
+		return fileHeaderSize
+	}
+	return fileHeaderSizeV2
+}
+
+// trailerSize returns the size of the trailer of files in this format
+func (format fileFormat) trailerSize() int {
+	if format == fileFormatV3 {
+		return fileTrailerSizeV3
+	}
+	return 0
+}
+
+// encryptedSize calculates the size of the data when encrypted
+func (format fileFormat) encryptedSize(size int64) int64 {
+	blocks, residue := size/blockDataSize, size%blockDataSize
+	encryptedSize := int64(format.headerSize()+format.trailerSize()) + blocks*(blockHeaderSize+blockDataSize)
+	if residue != 0 {
+		encryptedSize += blockHeaderSize + residue
+	}
+	return encryptedSize
+}
+
+// decryptedSize calculates the size of the data when decrypted
+func (format fileFormat) decryptedSize(size int64) (int64, error) {
+	size -= int64(format.headerSize() + format.trailerSize())
+	if size < 0 {
+		return 0, ErrorEncryptedFileTooShort
+	}
+	blocks, residue := size/blockSize, size%blockSize
+	decryptedSize := blocks * blockDataSize
+	if residue != 0 {
+		residue -= blockHeaderSize
+		if residue <= 0 {
+			return 0, ErrorEncryptedFileBadHeader
+		}
+	}
+	decryptedSize += residue
+	return decryptedSize, nil
+}
+
 // Cipher defines an encoding and decoding cipher for the crypt backend
 type Cipher struct {
 	dataKey        [32]byte                  // Key for secretbox
@@ -122,6 +229,9 @@ type Cipher struct {
 	buffers        sync.Pool // encrypt/decrypt buffers
 	cryptoRand     io.Reader // read crypto random numbers from here
 	dirNameEncrypt bool
+	format         fileFormat // format of the files written
+	mixedFormats   bool       // set if there may be files in formats other than v1
+	old            []*Cipher  // ciphers for previous passwords, newest first
 }
 
 // newCipher initialises the cipher.  If salt is "" then it uses a built in salt val
@@ -451,12 +561,37 @@ func (c *Cipher) encryptFileName(in string) string {
 	return strings.Join(segments, "/")
 }
 
-// EncryptFileName encrypts a file path
-func (c *Cipher) EncryptFileName(in string) string {
+// encryptFileNameFormat encrypts a file path for a file in the format given
+func (c *Cipher) encryptFileNameFormat(in string, format fileFormat) string {
 	if c.mode == NameEncryptionOff {
+		switch format {
+		case fileFormatV2:
+			return in + encryptedSuffixV2
+		case fileFormatV3:
+			return in + encryptedSuffixV3
+		}
 		return in + encryptedSuffix
 	}
-	return c.encryptFileName(in)
+	out := c.encryptFileName(in)
+	// Neither base32hex nor obfuscate make names starting with the
+	// markers so they can't be confused with v1
+	marker := ""
+	switch format {
+	case fileFormatV2:
+		marker = nameMarkerV2
+	case fileFormatV3:
+		marker = nameMarkerV3
+	}
+	if marker != "" {
+		leaf := strings.LastIndex(out, "/") + 1
+		out = out[:leaf] + marker + out[leaf:]
+	}
+	return out
+}
+
+// EncryptFileName encrypts a file path
+func (c *Cipher) EncryptFileName(in string) string {
+	return c.encryptFileNameFormat(in, c.format)
 }
 
 // EncryptDirName encrypts a directory path
@@ -490,16 +625,60 @@ func (c *Cipher) decryptFileName(in string) (string, error) {
 	return strings.Join(segments, "/"), nil
 }
 
-// DecryptFileName decrypts a file path
-func (c *Cipher) DecryptFileName(in string) (string, error) {
+// decryptFileNameFormat decrypts a file path returning the format
+// of the file as well
+func (c *Cipher) decryptFileNameFormat(in string) (string, fileFormat, error) {
 	if c.mode == NameEncryptionOff {
-		remainingLength := len(in) - len(encryptedSuffix)
-		if remainingLength > 0 && strings.HasSuffix(in, encryptedSuffix) {
-			return in[:remainingLength], nil
+		for _, suffix := range []struct {
+			suffix string
+			format fileFormat
+		}{
+			{encryptedSuffixV3, fileFormatV3},
+			{encryptedSuffixV2, fileFormatV2},
+			{encryptedSuffix, fileFormatV1},
+		} {
+			remainingLength := len(in) - len(suffix.suffix)
+			if remainingLength > 0 && strings.HasSuffix(in, suffix.suffix) {
+				return in[:remainingLength], suffix.format, nil
+			}
+		}
+		return "", fileFormatV1, ErrorNotAnEncryptedFile
+	}
+	format := fileFormatV1
+	leaf := strings.LastIndex(in, "/") + 1
+	switch {
+	case strings.HasPrefix(in[leaf:], nameMarkerV2):
+		format = fileFormatV2
+		in = in[:leaf] + in[leaf+len(nameMarkerV2):]
+	case strings.HasPrefix(in[leaf:], nameMarkerV3):
+		format = fileFormatV3
+		in = in[:leaf] + in[leaf+len(nameMarkerV3):]
+	}
+	out, err := c.decryptFileName(in)
+	return out, format, err
+}
+
+// decryptFileNameCipher decrypts a file path with the newest key
+// which can, returning the format of the file and the cipher of
+// that key too
+func (c *Cipher) decryptFileNameCipher(in string) (out string, format fileFormat, keyCipher *Cipher, err error) {
+	var firstErr error
+	for _, keyCipher = range c.ciphers() {
+		out, format, err = keyCipher.decryptFileNameFormat(in)
+		if err == nil || c.mode == NameEncryptionOff {
+			return out, format, keyCipher, err
+		}
+		if firstErr == nil {
+			firstErr = err
 		}
-		return "", ErrorNotAnEncryptedFile
 	}
-	return c.decryptFileName(in)
+	return "", format, nil, firstErr
+}
+
+// DecryptFileName decrypts a file path
+func (c *Cipher) DecryptFileName(in string) (string, error) {
+	out, _, _, err := c.decryptFileNameCipher(in)
+	return out, err
 }
 
 // DecryptDirName decrypts a directory path
@@ -507,7 +686,42 @@ func (c *Cipher) DecryptDirName(in string) (string, error) {
 	if c.mode == NameEncryptionOff || !c.dirNameEncrypt {
 		return in, nil
 	}
-	return c.decryptFileName(in)
+	var firstErr error
+	for _, keyCipher := range c.ciphers() {
+		out, err := keyCipher.decryptFileName(in)
+		if err == nil {
+			return out, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", firstErr
+}
+
+// ciphers returns the ciphers of all the keys which may have
+// encrypted files, the newest first
+func (c *Cipher) ciphers() []*Cipher {
+	return append([]*Cipher{c}, c.old...)
+}
+
+// formats returns the file formats files may be stored in, the one
+// written first
+//
+// Files are only looked for in format v1 if that is the format
+// written unless mixedFormats is set, so looking for a file needs a
+// single lookup on remotes which have only ever used format v1.
+func (c *Cipher) formats() []fileFormat {
+	formats := []fileFormat{c.format}
+	if c.format == fileFormatV1 && !c.mixedFormats {
+		return formats
+	}
+	for _, format := range []fileFormat{fileFormatV1, fileFormatV2, fileFormatV3} {
+		if format != c.format {
+			formats = append(formats, format)
+		}
+	}
+	return formats
 }
 
 // NameEncryptionMode returns the encryption mode in use for names
@@ -575,11 +789,68 @@ func (n *nonce) add(x uint64) {
 	}
 }
 
+// fileHeader is the information about a file kept in its header
+type fileHeader struct {
+	format fileFormat
+	nonce  nonce             // nonce of the first block
+	key    [fileKeySize]byte // key the blocks are encrypted with
+}
+
+// newFileHeader makes the header for a new file in the format given
+// with a random nonce and, for formats v2 and v3, a random key
+func (c *Cipher) newFileHeader(format fileFormat) (h fileHeader, err error) {
+	h.format = format
+	err = h.nonce.fromReader(c.cryptoRand)
+	if err != nil {
+		return h, err
+	}
+	if format == fileFormatV1 {
+		h.key = c.dataKey
+		return h, nil
+	}
+	_, err = io.ReadFull(c.cryptoRand, h.key[:])
+	if err != nil {
+		return h, errors.Wrap(err, "short read of file key")
+	}
+	return h, nil
+}
+
+// appendHeader appends the encoded header h to buf
+//
+// In formats v2 and v3 the file key is stored encrypted with the data
+// key using the nonce of the file.  The blocks use a different key so
+// the nonce isn't reused.
+func (c *Cipher) appendHeader(buf []byte, h *fileHeader) []byte {
+	switch h.format {
+	case fileFormatV1:
+		buf = append(buf, fileMagicBytes...)
+		return append(buf, h.nonce[:]...)
+	case fileFormatV2:
+		buf = append(buf, fileMagicV2Bytes...)
+	default:
+		buf = append(buf, fileMagicV3Bytes...)
+	}
+	buf = append(buf, h.nonce[:]...)
+	return secretbox.Seal(buf, h.key[:], h.nonce.pointer(), &c.dataKey)
+}
+
+// newHMAC returns the HMAC of the contents of a file in format v3
+//
+// Its key is derived from the file key so it isn't used for two
+// different things.
+func (h *fileHeader) newHMAC() hash.Hash {
+	keyMAC := hmac.New(sha256.New, h.key[:])
+	_, _ = keyMAC.Write([]byte(hmacKeyInfo))
+	return hmac.New(sha256.New, keyMAC.Sum(nil))
+}
+
 // encrypter encrypts an io.Reader on the fly
 type encrypter struct {
 	mu       sync.Mutex
 	in       io.Reader
 	c        *Cipher
+	header   fileHeader // header written at the start of the file
+	mac      hash.Hash  // HMAC of the contents written at the end in format v3
 	nonce    nonce
 	buf      []byte
 	readBuf  []byte
@@ -589,27 +860,31 @@ type encrypter struct {
 }
 
 // newEncrypter creates a new file handle encrypting on the fly
-func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
+//
+// If header is nil a new one is made in the format of the cipher
+func (c *Cipher) newEncrypter(in io.Reader, header *fileHeader) (*encrypter, error) {
 	fh := &encrypter{
 		in:      in,
 		c:       c,
 		buf:     c.getBlock(),
 		readBuf: c.getBlock(),
-		bufSize: fileHeaderSize,
 	}
-	// Initialise nonce
-	if nonce != nil {
-		fh.nonce = *nonce
+	// Initialise header
+	if header != nil {
+		fh.header = *header
 	} else {
-		err := fh.nonce.fromReader(c.cryptoRand)
+		var err error
+		fh.header, err = c.newFileHeader(c.format)
 		if err != nil {
 			return nil, err
 		}
 	}
-	// Copy magic into buffer
-	copy(fh.buf, fileMagicBytes)
-	// Copy nonce into buffer
-	copy(fh.buf[fileMagicSize:], fh.nonce[:])
+	fh.nonce = fh.header.nonce
+	if fh.header.format == fileFormatV3 {
+		fh.mac = fh.header.newHMAC()
+	}
+	// Copy header into buffer
+	fh.bufSize = len(c.appendHeader(fh.buf[:0], &fh.header))
 	return fh, nil
 }
 
@@ -629,15 +904,25 @@ func (fh *encrypter) Read(p []byte) (n int, err error) {
 		if n == 0 {
 			// err can't be nil since:
 			// n == len(buf) if and only if err == nil.
-			return fh.finish(err)
+			if err != io.EOF || fh.mac == nil {
+				return fh.finish(err)
+			}
+			// Write the HMAC of the contents at the end
+			fh.bufIndex = 0
+			fh.bufSize = len(fh.mac.Sum(fh.buf[:0]))
+			fh.mac = nil
+		} else {
+			// possibly err != nil here, but we will process the
+			// data and the next call to ReadFull will return 0, err
+			if fh.mac != nil {
+				_, _ = fh.mac.Write(readBuf[:n])
+			}
+			// Encrypt the block using the nonce
+			secretbox.Seal(fh.buf[:0], readBuf[:n], fh.nonce.pointer(), &fh.header.key)
+			fh.bufIndex = 0
+			fh.bufSize = blockHeaderSize + n
+			fh.nonce.increment()
 		}
-		// possibly err != nil here, but we will process the
-		// data and the next call to ReadFull will return 0, err
-		// Encrypt the block using the nonce
-		secretbox.Seal(fh.buf[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
-		fh.bufIndex = 0
-		fh.bufSize = blockHeaderSize + n
-		fh.nonce.increment()
 	}
 	n = copy(p, fh.buf[fh.bufIndex:fh.bufSize])
 	fh.bufIndex += n
@@ -657,10 +942,14 @@ func (fh *encrypter) finish(err error) (int, error) {
 	return 0, err
 }
 
-// Encrypt data encrypts the data stream
-func (c *Cipher) encryptData(in io.Reader) (io.Reader, *encrypter, error) {
+// Encrypt data encrypts the data stream in the format given
+func (c *Cipher) encryptData(in io.Reader, format fileFormat) (io.Reader, *encrypter, error) {
 	in, wrap := accounting.UnWrap(in) // unwrap the accounting off the Reader
-	out, err := c.newEncrypter(in, nil)
+	header, err := c.newFileHeader(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := c.newEncrypter(in, &header)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -669,24 +958,27 @@ func (c *Cipher) encryptData(in io.Reader) (io.Reader, *encrypter, error) {
 
 // EncryptData encrypts the data stream
 func (c *Cipher) EncryptData(in io.Reader) (io.Reader, error) {
-	out, _, err := c.encryptData(in)
+	out, _, err := c.encryptData(in, c.format)
 	return out, err
 }
 
 // decrypter decrypts an io.ReaderCloser on the fly
 type decrypter struct {
-	mu           sync.Mutex
-	rc           io.ReadCloser
-	nonce        nonce
-	initialNonce nonce
-	c            *Cipher
-	buf          []byte
-	readBuf      []byte
-	bufIndex     int
-	bufSize      int
-	err          error
-	limit        int64 // limit of bytes to read, -1 for unlimited
-	open         OpenRangeSeek
+	mu       sync.Mutex
+	rc       io.ReadCloser
+	nonce    nonce
+	header   fileHeader // header read from the start of the file
+	c        *Cipher
+	cipher   *Cipher   // cipher of the key the file was encrypted with
+	others   []*Cipher // other ciphers to try if the key isn't known yet
+	mac      hash.Hash // HMAC of the contents if checking it at the end
+	buf      []byte
+	readBuf  []byte
+	bufIndex int
+	bufSize  int
+	err      error
+	limit    int64 // limit of bytes to read, -1 for unlimited
+	open     OpenRangeSeek
 }
 
 // newDecrypter creates a new file handle decrypting on the fly
@@ -708,17 +1000,59 @@ func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
 		return nil, fh.finishAndClose(err)
 	}
 	// check the magic
-	if !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
+	switch {
+	case bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes):
+		fh.header.format = fileFormatV1
+	case bytes.Equal(readBuf[:fileMagicSize], fileMagicV2Bytes):
+		fh.header.format = fileFormatV2
+	case bytes.Equal(readBuf[:fileMagicSize], fileMagicV3Bytes):
+		fh.header.format = fileFormatV3
+	default:
 		return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
 	}
 	// retrieve the nonce
-	fh.nonce.fromBuf(readBuf[fileMagicSize:])
-	fh.initialNonce = fh.nonce
-	return fh, nil
+	fh.header.nonce.fromBuf(readBuf[fileMagicSize:])
+	fh.nonce = fh.header.nonce
+	keyCiphers := c.ciphers()
+	if fh.header.format == fileFormatV1 {
+		// the key is found when the first block is read
+		fh.setCipher(keyCiphers[0])
+		fh.others = keyCiphers[1:]
+		return fh, nil
+	}
+	// read and decrypt the file key
+	readBuf = fh.readBuf[:fileKeyWrappedSize]
+	_, err = io.ReadFull(fh.rc, readBuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fh.finishAndClose(ErrorEncryptedFileTooShort)
+	} else if err != nil {
+		return nil, fh.finishAndClose(err)
+	}
+	for _, keyCipher := range keyCiphers {
+		_, ok := secretbox.Open(fh.header.key[:0], readBuf, fh.header.nonce.pointer(), &keyCipher.dataKey)
+		if ok {
+			fh.cipher = keyCipher
+			if fh.header.format == fileFormatV3 {
+				// don't return the trailer as data
+				fh.rc = newTrailerReader(fh.rc, fileTrailerSizeV3)
+				fh.mac = fh.header.newHMAC()
+			}
+			return fh, nil
+		}
+	}
+	return nil, fh.finishAndClose(ErrorEncryptedBadKey)
+}
+
+// setCipher sets the cipher of the key of a format v1 file
+func (fh *decrypter) setCipher(keyCipher *Cipher) {
+	fh.cipher = keyCipher
+	fh.header.key = keyCipher.dataKey
 }
 
 // newDecrypterSeek creates a new file handle decrypting on the fly
-func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (fh *decrypter, err error) {
+// for a file in the format given
+func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, format fileFormat, offset, limit int64) (fh *decrypter, err error) {
+	headerSize := int64(format.headerSize())
 	var rc io.ReadCloser
 	doRangeSeek := false
 	setLimit := false
@@ -728,12 +1062,13 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 		rc, err = open(ctx, 0, -1)
 	} else if offset == 0 {
 		// If no offset open the header + limit worth of the file
-		_, underlyingLimit, _, _ := calculateUnderlying(offset, limit)
-		rc, err = open(ctx, 0, int64(fileHeaderSize)+underlyingLimit)
+		_, underlyingLimit, _, _ := calculateUnderlying(headerSize, offset, limit)
+		// read the trailer too, if any, in case the limit is the end of the file
+		rc, err = open(ctx, 0, headerSize+underlyingLimit+int64(format.trailerSize()))
 		setLimit = true
 	} else {
 		// Otherwise just read the header to start with
-		rc, err = open(ctx, 0, int64(fileHeaderSize))
+		rc, err = open(ctx, 0, headerSize)
 		doRangeSeek = true
 	}
 	if err != nil {
@@ -744,6 +1079,10 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 	if err != nil {
 		return nil, err
 	}
+	if fh.header.format != format {
+		_ = fh.Close()
+		return nil, errors.Errorf("file is in format %v not %v", fh.header.format, format)
+	}
 	fh.open = open // will be called by fh.RangeSeek
 	if doRangeSeek {
 		_, err = fh.RangeSeek(ctx, offset, io.SeekStart, limit)
@@ -754,10 +1093,43 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 	}
 	if setLimit {
 		fh.limit = limit
+		fh.mac = nil // the HMAC can only be checked if all the file is read
 	}
 	return fh, nil
 }
 
+// openUnderlying opens the underlying file at offset with limit,
+// not returning the trailer of the file if it has one
+func (fh *decrypter) openUnderlying(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+	trailerSize := fh.header.format.trailerSize()
+	if trailerSize == 0 {
+		return fh.open(ctx, offset, limit)
+	}
+	if limit >= 0 {
+		// read the trailer too in case the limit is the end of the file
+		limit += int64(trailerSize)
+	}
+	rc, err := fh.open(ctx, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return newTrailerReader(rc, trailerSize), nil
+}
+
+// checkHMAC checks the HMAC of the contents read against the one in
+// the trailer of the file if all of it has been read
+func (fh *decrypter) checkHMAC() error {
+	if fh.mac == nil {
+		return nil
+	}
+	tr, ok := fh.rc.(*trailerReader)
+	if !ok || !hmac.Equal(fh.mac.Sum(nil), tr.trailer()) {
+		return ErrorEncryptedBadHMAC
+	}
+	fh.mac = nil
+	return nil
+}
+
 // read data into internal buffer - call with fh.mu held
 func (fh *decrypter) fillBuffer() (err error) {
 	// FIXME should overlap the reads with a go-routine and 2 buffers?
@@ -766,6 +1138,11 @@ func (fh *decrypter) fillBuffer() (err error) {
 	if n == 0 {
 		// err can't be nil since:
 		// n == len(buf) if and only if err == nil.
+		if err == io.EOF {
+			if macErr := fh.checkHMAC(); macErr != nil {
+				return macErr
+			}
+		}
 		return err
 	}
 	// possibly err != nil here, but we will process the data and
@@ -779,13 +1156,27 @@ func (fh *decrypter) fillBuffer() (err error) {
 		return ErrorEncryptedFileBadHeader
 	}
 	// Decrypt the block using the nonce
-	_, ok := secretbox.Open(fh.buf[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
+	_, ok := secretbox.Open(fh.buf[:0], readBuf[:n], fh.nonce.pointer(), &fh.header.key)
+	if !ok {
+		// Try the other keys if the key isn't known yet
+		for _, keyCipher := range fh.others {
+			_, ok = secretbox.Open(fh.buf[:0], readBuf[:n], fh.nonce.pointer(), &keyCipher.dataKey)
+			if ok {
+				fh.setCipher(keyCipher)
+				break
+			}
+		}
+	}
 	if !ok {
 		if err != nil {
 			return err // return pending error as it is likely more accurate
 		}
 		return ErrorEncryptedBadBlock
 	}
+	fh.others = nil // the key is known now
+	if fh.mac != nil {
+		_, _ = fh.mac.Write(fh.buf[:n-blockHeaderSize])
+	}
 	fh.bufIndex = 0
 	fh.bufSize = n - blockHeaderSize
 	fh.nonce.increment()
@@ -823,17 +1214,17 @@ func (fh *decrypter) Read(p []byte) (n int, err error) {
 
 // calculateUnderlying converts an (offset, limit) in a crypted file
 // into an (underlyingOffset, underlyingLimit) for the underlying
-// file.
+// file with a header of headerSize bytes.
 //
 // It also returns number of bytes to discard after reading the first
 // block and number of blocks this is from the start so the nonce can
 // be incremented.
-func calculateUnderlying(offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
+func calculateUnderlying(headerSize, offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
 	// blocks we need to seek, plus bytes we need to discard
 	blocks, discard = offset/blockDataSize, offset%blockDataSize
 
 	// Offset in underlying stream we need to seek
-	underlyingOffset = int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	underlyingOffset = headerSize + blocks*(blockHeaderSize+blockDataSize)
 
 	// work out how many blocks we need to read
 	underlyingLimit = int64(-1)
@@ -883,10 +1274,13 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 		return 0, fh.err
 	}
 
-	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(offset, limit)
+	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(int64(fh.header.format.headerSize()), offset, limit)
+
+	// The HMAC can only be checked if all the file is read
+	fh.mac = nil
 
 	// Move the nonce on the correct number of blocks from the start
-	fh.nonce = fh.initialNonce
+	fh.nonce = fh.header.nonce
 	fh.nonce.add(uint64(blocks))
 
 	// Can we seek underlying stream directly?
@@ -902,7 +1296,7 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 		fh.rc = nil
 
 		// Re-open the underlying object with the offset given
-		rc, err := fh.open(ctx, underlyingOffset, underlyingLimit)
+		rc, err := fh.openUnderlying(ctx, underlyingOffset, underlyingLimit)
 		if err != nil {
 			return 0, fh.finish(errors.Wrap(err, "couldn't reopen file with offset and limit"))
 		}
@@ -991,6 +1385,49 @@ func (fh *decrypter) finishAndClose(err error) error {
 	return err
 }
 
+// trailerReader reads an io.ReadCloser except for the last size
+// bytes, which are the trailer of the file
+type trailerReader struct {
+	rc   io.ReadCloser
+	size int
+	buf  []byte // bytes to return followed by the possible trailer
+	held []byte // the last size bytes read, which may be the trailer
+}
+
+// newTrailerReader returns a reader which reads rc except for the
+// last size bytes
+func newTrailerReader(rc io.ReadCloser, size int) *trailerReader {
+	return &trailerReader{
+		rc:   rc,
+		size: size,
+	}
+}
+
+// Read as per io.Reader
+func (r *trailerReader) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil && len(p) > 0 {
+		var m int
+		m, err = r.rc.Read(p)
+		// hold back the last size bytes of the held and read bytes
+		r.buf = append(append(r.buf[:0], r.held...), p[:m]...)
+		if out := len(r.buf) - r.size; out > 0 {
+			n = copy(p, r.buf[:out])
+		}
+		r.held = append(r.held[:0], r.buf[n:]...)
+	}
+	return n, err
+}
+
+// trailer returns the trailer once all the file has been read
+func (r *trailerReader) trailer() []byte {
+	return r.held
+}
+
+// Close the underlying reader
+func (r *trailerReader) Close() error {
+	return r.rc.Close()
+}
+
 // DecryptData decrypts the data stream
 func (c *Cipher) DecryptData(rc io.ReadCloser) (io.ReadCloser, error) {
 	out, err := c.newDecrypter(rc)
@@ -1006,7 +1443,13 @@ func (c *Cipher) DecryptData(rc io.ReadCloser) (io.ReadCloser, error) {
 //
 // You must use this form of DecryptData if you might want to Seek the file handle
 func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (ReadSeekCloser, error) {
-	out, err := c.newDecrypterSeek(ctx, open, offset, limit)
+	return c.decryptDataSeek(ctx, open, c.format, offset, limit)
+}
+
+// decryptDataSeek decrypts the data stream of a file in the format
+// given from offset
+func (c *Cipher) decryptDataSeek(ctx context.Context, open OpenRangeSeek, format fileFormat, offset, limit int64) (ReadSeekCloser, error) {
+	out, err := c.newDecrypterSeek(ctx, open, format, offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1015,30 +1458,12 @@ func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset
 
 // EncryptedSize calculates the size of the data when encrypted
 func (c *Cipher) EncryptedSize(size int64) int64 {
-	blocks, residue := size/blockDataSize, size%blockDataSize
-	encryptedSize := int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
-	if residue != 0 {
-		encryptedSize += blockHeaderSize + residue
-	}
-	return encryptedSize
+	return c.format.encryptedSize(size)
 }
 
 // DecryptedSize calculates the size of the data when decrypted
 func (c *Cipher) DecryptedSize(size int64) (int64, error) {
-	size -= int64(fileHeaderSize)
-	if size < 0 {
-		return 0, ErrorEncryptedFileTooShort
-	}
-	blocks, residue := size/blockSize, size%blockSize
-	decryptedSize := blocks * blockDataSize
-	if residue != 0 {
-		residue -= blockHeaderSize
-		if residue <= 0 {
-			return 0, ErrorEncryptedFileBadHeader
-		}
-	}
-	decryptedSize += residue
-	return decryptedSize, nil
+	return c.format.decryptedSize(size)
 }
 
 // check interfaces
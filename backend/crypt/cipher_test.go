@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path"
 	"strings"
 	"testing"
 
@@ -1067,7 +1068,7 @@ func TestDecrypterCalculateUnderlying(t *testing.T) {
 		{blockDataSize + 1, blockDataSize + 1, int64(fileHeaderSize) + blockSize, 2 * blockSize, 1, 1},
 	} {
 		what := fmt.Sprintf("offset = %d, limit = %d", test.offset, test.limit)
-		underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(test.offset, test.limit)
+		underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(int64(fileHeaderSize), test.offset, test.limit)
 		assert.Equal(t, test.wantOffset, underlyingOffset, what)
 		assert.Equal(t, test.wantLimit, underlyingLimit, what)
 		assert.Equal(t, test.wantDiscard, discard, what)
@@ -1222,3 +1223,332 @@ func TestKey(t *testing.T) {
 	assert.Equal(t, [32]byte{}, c.nameKey)
 	assert.Equal(t, [16]byte{}, c.nameTweak)
 }
+
+func TestNewFileFormat(t *testing.T) {
+	for _, test := range []struct {
+		in          string
+		expected    fileFormat
+		expectedErr string
+	}{
+		{"", fileFormatV1, ""},
+		{"v1", fileFormatV1, ""},
+		{"V2", fileFormatV2, ""},
+		{"v3", fileFormatV3, ""},
+		{"v4", fileFormatV1, "Unknown file format \"v4\""},
+	} {
+		actual, actualErr := newFileFormat(test.in)
+		assert.Equal(t, test.expected, actual)
+		if test.expectedErr == "" {
+			assert.NoError(t, actualErr)
+		} else {
+			assert.EqualError(t, actualErr, test.expectedErr)
+		}
+	}
+	assert.Equal(t, "v1", fileFormatV1.String())
+	assert.Equal(t, "v2", fileFormatV2.String())
+	assert.Equal(t, "v3", fileFormatV3.String())
+	assert.Equal(t, "Unknown format #3", fileFormat(3).String())
+}
+
+func TestFileNameFormat(t *testing.T) {
+	for _, mode := range []NameEncryptionMode{NameEncryptionOff, NameEncryptionStandard, NameEncryptionObfuscated} {
+		c, _ := newCipher(mode, "", "", true)
+		for _, format := range []fileFormat{fileFormatV1, fileFormatV2, fileFormatV3} {
+			what := fmt.Sprintf("mode %v format %v", mode, format)
+			for _, in := range []string{"1", "z", "dir/zebra", "dir/sub/file.txt"} {
+				enc := c.encryptFileNameFormat(in, format)
+				out, gotFormat, err := c.decryptFileNameFormat(enc)
+				require.NoError(t, err, what)
+				assert.Equal(t, in, out, what)
+				assert.Equal(t, format, gotFormat, what)
+
+				// directory names are the same in all formats
+				dir, leaf := path.Split(enc)
+				assert.Equal(t, path.Dir(in), path.Clean(decryptDir(t, c, dir)), what)
+				if mode != NameEncryptionOff {
+					assert.Equal(t, format == fileFormatV2, strings.HasPrefix(leaf, nameMarkerV2), what)
+					assert.Equal(t, format == fileFormatV3, strings.HasPrefix(leaf, nameMarkerV3), what)
+				}
+			}
+		}
+	}
+	c, _ := newCipher(NameEncryptionStandard, "", "", true)
+	assert.Equal(t, "zp0e52nreeaj0a5ea7s64m4j72s", c.encryptFileNameFormat("1", fileFormatV2))
+	c.format = fileFormatV2
+	assert.Equal(t, "p0e52nreeaj0a5ea7s64m4j72s/zl42g6771hnv3an9cgc8cr2n1ng", c.EncryptFileName("1/12"))
+	assert.Equal(t, "p0e52nreeaj0a5ea7s64m4j72s", c.EncryptDirName("1"))
+	assert.Equal(t, "yp0e52nreeaj0a5ea7s64m4j72s", c.encryptFileNameFormat("1", fileFormatV3))
+	assert.Equal(t, []fileFormat{fileFormatV2, fileFormatV1, fileFormatV3}, c.formats())
+	c.format = fileFormatV1
+	assert.Equal(t, []fileFormat{fileFormatV1}, c.formats())
+	c.mixedFormats = true
+	assert.Equal(t, []fileFormat{fileFormatV1, fileFormatV2, fileFormatV3}, c.formats())
+}
+
+// decryptDir decrypts the directory part of an encrypted path
+func decryptDir(t *testing.T, c *Cipher, dir string) string {
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		return "."
+	}
+	out, err := c.DecryptDirName(dir)
+	require.NoError(t, err)
+	return out
+}
+
+func TestEncryptedSizeV2(t *testing.T) {
+	for _, test := range []struct {
+		in       int64
+		expected int64
+	}{
+		{0, 80},
+		{1, 80 + 16 + 1},
+		{65537, 80 + 16 + 65536 + 16 + 1},
+	} {
+		actual := fileFormatV2.encryptedSize(test.in)
+		assert.Equal(t, test.expected, actual, fmt.Sprintf("Testing %d", test.in))
+		recovered, err := fileFormatV2.decryptedSize(test.expected)
+		assert.NoError(t, err, fmt.Sprintf("Testing reverse %d", test.expected))
+		assert.Equal(t, test.in, recovered, fmt.Sprintf("Testing reverse %d", test.expected))
+	}
+	_, err := fileFormatV2.decryptedSize(79)
+	assert.Equal(t, ErrorEncryptedFileTooShort, err)
+}
+
+func TestEncryptDecryptV2(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "", "", true)
+	require.NoError(t, err)
+	c.format = fileFormatV2
+	const size = 3*blockDataSize + 17
+	plaintext := make([]byte, size)
+	_, err = io.ReadFull(newRandomSource(size), plaintext)
+	require.NoError(t, err)
+
+	// Encrypt and check the header
+	enc, err := c.newEncrypter(bytes.NewBuffer(plaintext), nil)
+	require.NoError(t, err)
+	assert.Equal(t, fileFormatV2, enc.header.format)
+	assert.NotEqual(t, c.dataKey, enc.header.key)
+	encrypted, err := ioutil.ReadAll(enc)
+	require.NoError(t, err)
+	assert.Equal(t, c.EncryptedSize(size), int64(len(encrypted)))
+	assert.Equal(t, fileMagicV2, string(encrypted[:fileMagicSize]))
+
+	// Decrypt it
+	dec, err := c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+	require.NoError(t, err)
+	assert.Equal(t, enc.header, dec.header)
+	decrypted, err := ioutil.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// Decrypt from an offset
+	open := func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+		end := int64(len(encrypted))
+		if limit >= 0 && offset+limit < end {
+			end = offset + limit
+		}
+		return ioutil.NopCloser(bytes.NewBuffer(encrypted[offset:end])), nil
+	}
+	for _, offset := range []int64{0, 1, blockDataSize + 5, size - 1} {
+		rc, err := c.decryptDataSeek(context.Background(), open, fileFormatV2, offset, 100)
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		end := offset + 100
+		if end > size {
+			end = size
+		}
+		assert.Equal(t, plaintext[offset:end], got, fmt.Sprintf("offset %d", offset))
+	}
+	_, err = c.decryptDataSeek(context.Background(), open, fileFormatV1, 0, -1)
+	assert.EqualError(t, err, "file is in format v2 not v1")
+
+	// v1 files are still readable
+	c.format = fileFormatV1
+	dec, err = c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+	require.NoError(t, err)
+	decrypted, err = ioutil.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// Corrupting the file key fails
+	corrupt := append([]byte{}, encrypted...)
+	corrupt[fileHeaderSize+3] ^= 1
+	_, err = c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(corrupt)))
+	assert.Equal(t, ErrorEncryptedBadKey, err)
+
+	// As does a truncated header
+	_, err = c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted[:fileHeaderSizeV2-1])))
+	assert.Equal(t, ErrorEncryptedFileTooShort, err)
+
+	// And a different password
+	require.NoError(t, c.Key("potato", ""))
+	_, err = c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+	assert.Equal(t, ErrorEncryptedBadKey, err)
+}
+
+func TestOldKeys(t *testing.T) {
+	oldCipher, err := newCipher(NameEncryptionStandard, "potato", "", true)
+	require.NoError(t, err)
+	c, err := newCipher(NameEncryptionStandard, "sausage", "", true)
+	require.NoError(t, err)
+	c.old = []*Cipher{oldCipher}
+	assert.Equal(t, []*Cipher{c, oldCipher}, c.ciphers())
+
+	// Names with either key decrypt
+	for _, keyCipher := range []*Cipher{c, oldCipher} {
+		out, format, gotCipher, err := c.decryptFileNameCipher(keyCipher.encryptFileNameFormat("dir/file", fileFormatV2))
+		require.NoError(t, err)
+		assert.Equal(t, "dir/file", out)
+		assert.Equal(t, fileFormatV2, format)
+		assert.Equal(t, keyCipher, gotCipher)
+		dir, err := c.DecryptDirName(keyCipher.EncryptDirName("dir"))
+		require.NoError(t, err)
+		assert.Equal(t, "dir", dir)
+	}
+	_, _, _, err = c.decryptFileNameCipher("potato")
+	assert.Error(t, err)
+
+	// Data with either key decrypts in either format
+	plaintext := []byte("hello, world")
+	for _, keyCipher := range []*Cipher{c, oldCipher} {
+		for _, format := range []fileFormat{fileFormatV1, fileFormatV2} {
+			what := fmt.Sprintf("format %v", format)
+			header, err := keyCipher.newFileHeader(format)
+			require.NoError(t, err)
+			enc, err := keyCipher.newEncrypter(bytes.NewBuffer(plaintext), &header)
+			require.NoError(t, err)
+			encrypted, err := ioutil.ReadAll(enc)
+			require.NoError(t, err)
+
+			dec, err := c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+			require.NoError(t, err, what)
+			decrypted, err := ioutil.ReadAll(dec)
+			require.NoError(t, err, what)
+			assert.Equal(t, plaintext, decrypted, what)
+			assert.Equal(t, keyCipher, dec.cipher, what)
+			assert.Equal(t, header.key, dec.header.key, what)
+		}
+	}
+}
+
+func TestEncryptedSizeV3(t *testing.T) {
+	for _, test := range []struct {
+		in       int64
+		expected int64
+	}{
+		{0, 80 + 32},
+		{1, 80 + 16 + 1 + 32},
+		{65537, 80 + 16 + 65536 + 16 + 1 + 32},
+	} {
+		actual := fileFormatV3.encryptedSize(test.in)
+		assert.Equal(t, test.expected, actual, fmt.Sprintf("Testing %d", test.in))
+		recovered, err := fileFormatV3.decryptedSize(test.expected)
+		assert.NoError(t, err, fmt.Sprintf("Testing reverse %d", test.expected))
+		assert.Equal(t, test.in, recovered, fmt.Sprintf("Testing reverse %d", test.expected))
+	}
+	_, err := fileFormatV3.decryptedSize(80 + 31)
+	assert.Equal(t, ErrorEncryptedFileTooShort, err)
+}
+
+func TestTrailerReader(t *testing.T) {
+	in := []byte("hello, world!")
+	for _, readSize := range []int{1, 2, 5, 100} {
+		r := newTrailerReader(ioutil.NopCloser(bytes.NewBuffer(in)), 4)
+		var out []byte
+		buf := make([]byte, readSize)
+		for {
+			n, err := r.Read(buf)
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+		}
+		assert.Equal(t, "hello, wo", string(out), fmt.Sprintf("read size %d", readSize))
+		assert.Equal(t, "rld!", string(r.trailer()), fmt.Sprintf("read size %d", readSize))
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestEncryptDecryptV3(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "", "", true)
+	require.NoError(t, err)
+	c.format = fileFormatV3
+	for _, size := range []int64{0, 1, blockDataSize, 3*blockDataSize + 17} {
+		what := fmt.Sprintf("size %d", size)
+		plaintext := make([]byte, size)
+		_, err = io.ReadFull(newRandomSource(size), plaintext)
+		require.NoError(t, err)
+
+		// Encrypt and check the header and trailer
+		enc, err := c.newEncrypter(bytes.NewBuffer(plaintext), nil)
+		require.NoError(t, err)
+		assert.Equal(t, fileFormatV3, enc.header.format)
+		encrypted, err := ioutil.ReadAll(enc)
+		require.NoError(t, err)
+		assert.Equal(t, c.EncryptedSize(size), int64(len(encrypted)), what)
+		assert.Equal(t, fileMagicV3, string(encrypted[:fileMagicSize]), what)
+		mac := enc.header.newHMAC()
+		_, _ = mac.Write(plaintext)
+		assert.Equal(t, mac.Sum(nil), encrypted[len(encrypted)-fileTrailerSizeV3:], what)
+
+		// Decrypt it
+		dec, err := c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+		require.NoError(t, err, what)
+		decrypted, err := ioutil.ReadAll(dec)
+		require.NoError(t, err, what)
+		assert.Equal(t, plaintext, decrypted, what)
+
+		// Decrypt ranges, which don't return the trailer
+		open := func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+			end := int64(len(encrypted))
+			if limit >= 0 && offset+limit < end {
+				end = offset + limit
+			}
+			return ioutil.NopCloser(bytes.NewBuffer(encrypted[offset:end])), nil
+		}
+		for _, offset := range []int64{0, 1, blockDataSize + 5, size - 1} {
+			if offset < 0 || offset >= size {
+				continue
+			}
+			for _, limit := range []int64{-1, 100} {
+				rc, err := c.decryptDataSeek(context.Background(), open, fileFormatV3, offset, limit)
+				require.NoError(t, err, "%s offset %d limit %d", what, offset, limit)
+				got, err := ioutil.ReadAll(rc)
+				require.NoError(t, err, what)
+				end := size
+				if limit >= 0 && offset+limit < size {
+					end = offset + limit
+				}
+				assert.Equal(t, plaintext[offset:end], got, fmt.Sprintf("%s offset %d limit %d", what, offset, limit))
+			}
+		}
+	}
+
+	// A corrupted HMAC is noticed when the whole file is read
+	plaintext := []byte("potato")
+	enc, err := c.newEncrypter(bytes.NewBuffer(plaintext), nil)
+	require.NoError(t, err)
+	encrypted, err := ioutil.ReadAll(enc)
+	require.NoError(t, err)
+	encrypted[len(encrypted)-1] ^= 1
+	dec, err := c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(encrypted)))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(dec)
+	assert.Equal(t, ErrorEncryptedBadHMAC, err)
+
+	// As is a file truncated at the end of a block
+	const size = 2 * blockDataSize
+	plaintext = make([]byte, size)
+	enc, err = c.newEncrypter(bytes.NewBuffer(plaintext), nil)
+	require.NoError(t, err)
+	encrypted, err = ioutil.ReadAll(enc)
+	require.NoError(t, err)
+	truncated := append(encrypted[:fileHeaderSizeV2+blockSize:fileHeaderSizeV2+blockSize], encrypted[len(encrypted)-fileTrailerSizeV3:]...)
+	dec, err = c.newDecrypter(ioutil.NopCloser(bytes.NewBuffer(truncated)))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(dec)
+	assert.Equal(t, ErrorEncryptedBadHMAC, err)
+}
@@ -3,6 +3,7 @@ package crypt
 
 import (
 	"context"
+	"crypto/hmac"
 	"fmt"
 	"io"
 	"path"
@@ -15,7 +16,6 @@ import (
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/configstruct"
-	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/fspath"
 	"github.com/rclone/rclone/fs/hash"
 )
@@ -73,6 +73,114 @@ NB If filename_encryption is "off" then this option will do nothing.`,
 			Name:       "password2",
 			Help:       "Password or pass phrase for salt. Optional but recommended.\nShould be different to the previous password.",
 			IsPassword: true,
+		}, {
+			Name: "old_passwords",
+			Help: `Previous passwords, so files encrypted with them can still be read.
+
+This is a comma separated list of the passwords, newest first, each
+obscured with "rclone obscure". If an old password had a password2,
+obscure it too and put it after the password with a ":" between them.
+
+If "kms_key" is set they are encrypted with it instead, as
+"password" is.
+
+New files are always written with "password". After changing it put
+the old one here and run the "rekey" backend command to encrypt the
+existing files with the new one.
+
+This can't be used with obfuscate filename encryption as which
+password a name was obfuscated with can't be told.`,
+			Default:  fs.CommaSepList{},
+			Advanced: true,
+		}, {
+			Name: "kms_key",
+			Help: `Key in a key management service the passwords are encrypted with.
+
+If this is set then "password", "password2" and "old_passwords" are
+the base64 encoded ciphertext of the passwords encrypted with this
+key, rather than the passwords, and are decrypted with it whenever
+the remote is started. They are obscured in the config file as usual.
+
+For AWS KMS use "aws-kms://" followed by the ARN, alias or ID of the
+key, with the credentials found in the same way as the AWS CLI.
+
+For Google Cloud KMS use "gcp-kms://" followed by the resource name of
+the key (projects/*/locations/*/keyRings/*/cryptoKeys/*), with the
+application default credentials.
+
+For Azure Key Vault use "azure-kv://" followed by the key identifier
+without the "https://" (VAULT.vault.azure.net/keys/NAME/VERSION) of
+an RSA key, which decrypts with RSA-OAEP-256. The service principal in
+AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET is used if
+set, otherwise the managed identity of the machine.
+
+For a key on a PKCS#11 token (a hardware security module or smart
+card) use a PKCS#11 URI naming a private RSA key, which decrypts with
+RSA-OAEP using SHA-256, and the module to load, eg
+"pkcs11:token=rclone;object=crypt?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/rclone/pin".
+The PIN is read from the file in pin-source or given as pin-value.
+This needs rclone built with cgo on Linux, macOS or FreeBSD.`,
+			Advanced: true,
+		}, {
+			Name: "password_command",
+			Help: `Command to run to get the passwords.
+
+If this is set then the command is run whenever the remote is started
+and the first line of its output is used as the password and the
+second line, if any, as password2. "password" and "password2" aren't
+used, so set "password" to anything.
+
+This can be used to read the passwords from a hardware token or a
+secrets manager which "kms_key" doesn't support.`,
+			Default:  fs.SpaceSepList{},
+			Advanced: true,
+		}, {
+			Name: "format",
+			Help: `Format of the files written.
+
+Files in any format can always be read, so this can be changed at
+any time, but set "mixed_formats" when changing it back to v1. Use the
+"migrate-format" backend command to convert existing files to the
+format set here.
+
+Format v2 files are encrypted with a random key for each file, kept in
+the file encrypted with the password, so need 48 more bytes each, and
+their encrypted names start with "z" (or end ".bin2" if
+filename_encryption is "off"). They can't be read by versions of
+rclone before this option was added.
+
+Format v3 files are format v2 files with an HMAC of their contents
+appended, so need 32 more bytes again, and their encrypted names
+start with "y" (or end ".bin3"). The HMAC is checked whenever a whole
+file is read, and "rclone check" and "rclone cryptcheck" can check
+files against it reading only the start and end of them.`,
+			Default: "v1",
+			Examples: []fs.OptionExample{
+				{
+					Value: "v1",
+					Help:  "Encrypt the data of all files with the password.",
+				}, {
+					Value: "v2",
+					Help:  "Encrypt the data of each file with its own random key.",
+				}, {
+					Value: "v3",
+					Help:  "As v2 with an HMAC of the contents of each file appended.",
+				},
+			},
+			Advanced: true,
+		}, {
+			Name: "mixed_formats",
+			Help: `Look for files in all the formats when format is v1.
+
+When format is v1 files are only looked for by their name in format
+v1, as looking for them in the other formats needs a lookup for each
+format. Set this if format has been set back to v1 after writing files
+in format v2 or v3.
+
+This is set by "migrate-format" when it converts files to format v1
+and unset once it has converted the whole remote.`,
+			Default:  false,
+			Advanced: true,
 		}, {
 			Name:    "server_side_across_configs",
 			Default: false,
@@ -106,29 +214,75 @@ names, or for debugging purposes.`,
 }
 
 // newCipherForConfig constructs a Cipher for the given config name
-func newCipherForConfig(opt *Options) (*Cipher, error) {
+func newCipherForConfig(ctx context.Context, opt *Options) (*Cipher, error) {
 	mode, err := NewNameEncryptionMode(opt.FilenameEncryption)
 	if err != nil {
 		return nil, err
 	}
-	if opt.Password == "" {
-		return nil, errors.New("password not set in config file")
-	}
-	password, err := obscure.Reveal(opt.Password)
+	format, err := newFileFormat(opt.Format)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to decrypt password")
+		return nil, err
 	}
-	var salt string
-	if opt.Password2 != "" {
-		salt, err = obscure.Reveal(opt.Password2)
+	var unwrap unwrapFn
+	if opt.KMSKey != "" {
+		unwrap, err = newUnwrapper(ctx, opt.KMSKey)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to decrypt password2")
+			return nil, err
+		}
+	}
+	var password, salt string
+	if len(opt.PasswordCommand) > 0 {
+		password, salt, err = runPasswordCommand(ctx, opt.PasswordCommand)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if opt.Password == "" {
+			return nil, errors.New("password not set in config file")
+		}
+		password, err = revealPassword(ctx, opt.Password, unwrap)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt password")
+		}
+		if opt.Password2 != "" {
+			salt, err = revealPassword(ctx, opt.Password2, unwrap)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to decrypt password2")
+			}
 		}
 	}
 	cipher, err := newCipher(mode, password, salt, opt.DirectoryNameEncryption)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make cipher")
 	}
+	cipher.format = format
+	cipher.mixedFormats = opt.MixedFormats
+	if len(opt.OldPasswords) > 0 && mode == NameEncryptionObfuscated {
+		return nil, errors.New("old_passwords can't be used with obfuscate filename encryption")
+	}
+	for i, old := range opt.OldPasswords {
+		oldPassword, oldSalt := old, ""
+		if colon := strings.IndexRune(old, ':'); colon >= 0 {
+			oldPassword, oldSalt = old[:colon], old[colon+1:]
+		}
+		oldPassword, err = revealPassword(ctx, oldPassword, unwrap)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt old password #%d", i+1)
+		}
+		if oldSalt != "" {
+			oldSalt, err = revealPassword(ctx, oldSalt, unwrap)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decrypt password2 of old password #%d", i+1)
+			}
+		}
+		oldCipher, err := newCipher(mode, oldPassword, oldSalt, opt.DirectoryNameEncryption)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to make cipher for old password")
+		}
+		oldCipher.format = format
+		oldCipher.mixedFormats = opt.MixedFormats
+		cipher.old = append(cipher.old, oldCipher)
+	}
 	return cipher, nil
 }
 
@@ -140,7 +294,7 @@ func NewCipher(m configmap.Mapper) (*Cipher, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newCipherForConfig(opt)
+	return newCipherForConfig(context.Background(), opt)
 }
 
 // NewFs constructs an Fs from the path, container:path
@@ -151,7 +305,7 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if err != nil {
 		return nil, err
 	}
-	cipher, err := newCipherForConfig(opt)
+	cipher, err := newCipherForConfig(ctx, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -163,28 +317,45 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if path.Base(rpath) == "." {
 		rpath = strings.TrimSuffix(rpath, ".")
 	}
-	// Look for a file first
-	var wrappedFs fs.Fs
-	if rpath == "" {
-		wrappedFs, err = cache.Get(ctx, remote)
-	} else {
-		remotePath := fspath.JoinRootPath(remote, cipher.EncryptFileName(rpath))
-		wrappedFs, err = cache.Get(ctx, remotePath)
-		// if that didn't produce a file, look for a directory
-		if err != fs.ErrorIsFile {
-			remotePath = fspath.JoinRootPath(remote, cipher.EncryptDirName(rpath))
-			wrappedFs, err = cache.Get(ctx, remotePath)
+	// Find the root with each key, looking for a file first
+	var keys []keyFs
+	isFile := false
+	for _, keyCipher := range cipher.ciphers() {
+		keyWrappedFs, err := newWrappedFs(ctx, remote, rpath, keyCipher)
+		if err == fs.ErrorIsFile {
+			isFile = true
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to make remote %q to wrap", remote)
+		}
+		keys = append(keys, keyFs{cipher: keyCipher, fs: keyWrappedFs, isFile: err == fs.ErrorIsFile})
+	}
+	if isFile {
+		err = fs.ErrorIsFile
+		// Root the keys without the file at its directory too
+		dir := path.Dir(rpath)
+		if dir == "." {
+			dir = ""
+		}
+		for i := range keys {
+			if keys[i].isFile {
+				continue
+			}
+			keys[i].fs, err = cache.Get(ctx, fspath.JoinRootPath(remote, keys[i].cipher.EncryptDirName(dir)))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to make remote %q to wrap", remote)
+			}
+			err = fs.ErrorIsFile
 		}
 	}
-	if err != fs.ErrorIsFile && err != nil {
-		return nil, errors.Wrapf(err, "failed to make remote %q to wrap", remote)
-	}
+	wrappedFs := keys[0].fs
 	f := &Fs{
 		Fs:     wrappedFs,
 		name:   name,
 		root:   rpath,
 		opt:    *opt,
+		m:      m,
 		cipher: cipher,
+		keys:   keys,
 	}
 	cache.PinUntilFinalized(f.Fs, f)
 	// the features here are ones we could support, and they are
@@ -200,19 +371,44 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		GetTier:                 true,
 		ServerSideAcrossConfigs: opt.ServerSideAcrossConfigs,
 	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
+	if len(keys) > 1 {
+		// the trees of the old keys are listed a directory at a time
+		f.features.ListR = nil
+	}
 
 	return f, err
 }
 
+// newWrappedFs makes the wrapped Fs for rpath with the key of c
+// looking for a file in any format first
+func newWrappedFs(ctx context.Context, remote, rpath string, c *Cipher) (wrappedFs fs.Fs, err error) {
+	if rpath == "" {
+		return cache.Get(ctx, remote)
+	}
+	for _, format := range c.formats() {
+		wrappedFs, err = cache.Get(ctx, fspath.JoinRootPath(remote, c.encryptFileNameFormat(rpath, format)))
+		if err == fs.ErrorIsFile {
+			return wrappedFs, err
+		}
+	}
+	// if that didn't produce a file, look for a directory
+	return cache.Get(ctx, fspath.JoinRootPath(remote, c.EncryptDirName(rpath)))
+}
+
 // Options defines the configuration for this backend
 type Options struct {
-	Remote                  string `config:"remote"`
-	FilenameEncryption      string `config:"filename_encryption"`
-	DirectoryNameEncryption bool   `config:"directory_name_encryption"`
-	Password                string `config:"password"`
-	Password2               string `config:"password2"`
-	ServerSideAcrossConfigs bool   `config:"server_side_across_configs"`
-	ShowMapping             bool   `config:"show_mapping"`
+	Remote                  string          `config:"remote"`
+	FilenameEncryption      string          `config:"filename_encryption"`
+	DirectoryNameEncryption bool            `config:"directory_name_encryption"`
+	Password                string          `config:"password"`
+	Password2               string          `config:"password2"`
+	OldPasswords            fs.CommaSepList `config:"old_passwords"`
+	KMSKey                  string          `config:"kms_key"`
+	PasswordCommand         fs.SpaceSepList `config:"password_command"`
+	Format                  string          `config:"format"`
+	MixedFormats            bool            `config:"mixed_formats"`
+	ServerSideAcrossConfigs bool            `config:"server_side_across_configs"`
+	ShowMapping             bool            `config:"show_mapping"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -222,8 +418,63 @@ type Fs struct {
 	name     string
 	root     string
 	opt      Options
-	features *fs.Features // optional features
+	m        configmap.Mapper // config, to set mixed_formats in
+	features *fs.Features     // optional features
 	cipher   *Cipher
+	keys     []keyFs // the wrapped remote for each key, newest first
+}
+
+// keyFs is the wrapped remote at the root of the Fs encrypted with a key
+type keyFs struct {
+	cipher *Cipher
+	fs     fs.Fs
+	isFile bool // set if the root is a file encrypted with this key
+}
+
+// tree is a directory of the wrapped remote
+type tree struct {
+	fs  fs.Fs
+	dir string // encrypted name of the directory
+}
+
+// trees returns the directories of the wrapped remote the files in
+// dir may be in, one for each key which encrypts its name
+// differently, newest key first.
+func (f *Fs) trees(dir string) (trees []tree) {
+	for _, key := range f.keys {
+		t := tree{fs: key.fs, dir: key.cipher.EncryptDirName(dir)}
+		if len(trees) > 0 && t == trees[0] {
+			// names in the same directory are decrypted with any key
+			continue
+		}
+		trees = append(trees, t)
+	}
+	return trees
+}
+
+// existingTrees returns the trees of dir which exist, or
+// fs.ErrorDirNotFound if none do.
+//
+// If there is only one tree it isn't checked.
+func (f *Fs) existingTrees(ctx context.Context, dir string) (trees []tree, err error) {
+	trees = f.trees(dir)
+	if len(trees) == 1 {
+		return trees, nil
+	}
+	existing := trees[:0]
+	for _, t := range trees {
+		_, err = t.fs.List(ctx, t.dir)
+		if err == fs.ErrorDirNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		existing = append(existing, t)
+	}
+	if len(existing) == 0 {
+		return nil, fs.ErrorDirNotFound
+	}
+	return existing, nil
 }
 
 // Name of the remote (as passed into NewFs)
@@ -300,11 +551,44 @@ func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntr
 // This should return ErrDirNotFound if the directory isn't
 // found.
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
-	entries, err = f.Fs.List(ctx, f.cipher.EncryptDirName(dir))
-	if err != nil {
-		return nil, err
+	if len(f.keys) == 1 {
+		entries, err = f.Fs.List(ctx, f.cipher.EncryptDirName(dir))
+		if err != nil {
+			return nil, err
+		}
+		return f.encryptEntries(ctx, entries)
+	}
+	// Merge the trees of all the keys removing directories in
+	// more than one
+	trees := f.trees(dir)
+	found := false
+	seenDirs := make(map[string]struct{})
+	for _, t := range trees {
+		treeEntries, err := t.fs.List(ctx, t.dir)
+		if err == fs.ErrorDirNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		treeEntries, err = f.encryptEntries(ctx, treeEntries)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range treeEntries {
+			if _, isDir := entry.(fs.Directory); isDir {
+				if _, seen := seenDirs[entry.Remote()]; seen {
+					continue
+				}
+				seenDirs[entry.Remote()] = struct{}{}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if !found {
+		return nil, fs.ErrorDirNotFound
 	}
-	return f.encryptEntries(ctx, entries)
+	return entries, nil
 }
 
 // ListR lists the objects and directories of the Fs starting
@@ -334,20 +618,37 @@ func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (
 }
 
 // NewObject finds the Object at remote.
+//
+// The file is looked for in the format written first then in the
+// other formats if there may be files in them.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
-	o, err := f.Fs.NewObject(ctx, f.cipher.EncryptFileName(remote))
-	if err != nil {
-		return nil, err
+	var err error
+	for i, key := range f.keys {
+		if i > 0 && key.fs == f.Fs && key.cipher.EncryptFileName(remote) == f.cipher.EncryptFileName(remote) {
+			// the name is the same with this key
+			continue
+		}
+		for _, format := range f.cipher.formats() {
+			var o fs.Object
+			o, err = key.fs.NewObject(ctx, key.cipher.encryptFileNameFormat(remote, format))
+			if err == nil {
+				return f.newObject(o), nil
+			}
+			if err != fs.ErrorObjectNotFound {
+				return nil, err
+			}
+		}
 	}
-	return f.newObject(o), nil
+	return nil, err
 }
 
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
 
-// put implements Put or PutStream
-func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption, put putFn) (fs.Object, error) {
+// put implements Put or PutStream writing a file with the key of c in
+// the format given
+func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption, put putFn, c *Cipher, format fileFormat) (fs.Object, error) {
 	// Encrypt the data into wrappedIn
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	wrappedIn, encrypter, err := c.encryptData(in, format)
 	if err != nil {
 		return nil, err
 	}
@@ -371,7 +672,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, c, encrypter.header), options...)
 	if err != nil {
 		return nil, err
 	}
@@ -403,12 +704,12 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 // will return the object and the error, otherwise will return
 // nil and the error
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
-	return f.put(ctx, in, src, options, f.Fs.Put)
+	return f.put(ctx, in, src, options, f.Fs.Put, f.cipher, f.cipher.format)
 }
 
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
-	return f.put(ctx, in, src, options, f.Fs.Features().PutStream)
+	return f.put(ctx, in, src, options, f.Fs.Features().PutStream, f.cipher, f.cipher.format)
 }
 
 // Hashes returns the supported hash sets.
@@ -427,7 +728,68 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 //
 // Return an error if it doesn't exist or isn't empty
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return f.Fs.Rmdir(ctx, f.cipher.EncryptDirName(dir))
+	trees, err := f.existingTrees(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range trees {
+		err = t.fs.Rmdir(ctx, t.dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DirSetModTime sets the modification time of the directory dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	trees, err := f.existingTrees(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range trees {
+		do := t.fs.Features().DirSetModTime
+		if do == nil {
+			return fs.ErrorNotImplemented
+		}
+		err = do(ctx, t.dir, modTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DirMetadata returns the metadata of the directory dir
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	trees, err := f.existingTrees(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	do := trees[0].fs.Features().DirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	return do(ctx, trees[0].dir)
+}
+
+// DirSetMetadata sets the metadata of the directory dir
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	trees, err := f.existingTrees(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range trees {
+		do := t.fs.Features().DirSetMetadata
+		if do == nil {
+			return fs.ErrorNotImplemented
+		}
+		err = do(ctx, t.dir, metadata)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Purge all files in the directory specified
@@ -441,7 +803,21 @@ func (f *Fs) Purge(ctx context.Context, dir string) error {
 	if do == nil {
 		return fs.ErrorCantPurge
 	}
-	return do(ctx, f.cipher.EncryptDirName(dir))
+	trees, err := f.existingTrees(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range trees {
+		do = t.fs.Features().Purge
+		if do == nil {
+			return fs.ErrorCantPurge
+		}
+		err = do(ctx, t.dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Copy src to this remote using server-side copy operations.
@@ -459,10 +835,11 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantCopy
 	}
 	o, ok := src.(*Object)
-	if !ok {
+	if !ok || o.cipher != o.f.cipher {
+		// files with old keys are encrypted again with the new one
 		return nil, fs.ErrorCantCopy
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	oResult, err := do(ctx, o.Object, f.cipher.encryptFileNameFormat(remote, o.format))
 	if err != nil {
 		return nil, err
 	}
@@ -484,10 +861,11 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantMove
 	}
 	o, ok := src.(*Object)
-	if !ok {
+	if !ok || o.cipher != o.f.cipher {
+		// files with old keys are encrypted again with the new one
 		return nil, fs.ErrorCantMove
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	oResult, err := do(ctx, o.Object, f.cipher.encryptFileNameFormat(remote, o.format))
 	if err != nil {
 		return nil, err
 	}
@@ -512,6 +890,22 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 		fs.Debugf(srcFs, "Can't move directory - not same remote type")
 		return fs.ErrorCantDirMove
 	}
+	if len(srcFs.keys) > 1 {
+		trees, err := srcFs.existingTrees(ctx, srcRemote)
+		if err != nil {
+			return err
+		}
+		if len(trees) > 1 || trees[0] != srcFs.trees(srcRemote)[0] {
+			fs.Debugf(srcFs, "Can't move directory - has files encrypted with old passwords")
+			return fs.ErrorCantDirMove
+		}
+	}
+	if len(f.keys) > 1 {
+		_, err := f.existingTrees(ctx, dstRemote)
+		if err == nil {
+			return fs.ErrorDirExists
+		}
+	}
 	return do(ctx, srcFs.Fs, f.cipher.EncryptDirName(srcRemote), f.cipher.EncryptDirName(dstRemote))
 }
 
@@ -524,11 +918,11 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	if do == nil {
 		return nil, errors.New("can't PutUnchecked")
 	}
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	wrappedIn, encrypter, err := f.cipher.encryptData(in, f.cipher.format)
 	if err != nil {
 		return nil, err
 	}
-	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce))
+	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, f.cipher, encrypter.header))
 	if err != nil {
 		return nil, err
 	}
@@ -581,11 +975,12 @@ func (f *Fs) DecryptFileName(encryptedFileName string) (string, error) {
 	return f.cipher.DecryptFileName(encryptedFileName)
 }
 
-// computeHashWithNonce takes the nonce and encrypts the contents of
-// src with it, and calculates the hash given by HashType on the fly
+// computeHashWithHeader takes the header and encrypts the contents of
+// src with it and the key of c, and calculates the hash given by
+// HashType on the fly
 //
 // Note that we break lots of encapsulation in this function.
-func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Object, hashType hash.Type) (hashStr string, err error) {
+func (f *Fs) computeHashWithHeader(ctx context.Context, c *Cipher, header fileHeader, src fs.Object, hashType hash.Type) (hashStr string, err error) {
 	// Open the src for input
 	in, err := src.Open(ctx)
 	if err != nil {
@@ -593,8 +988,8 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	}
 	defer fs.CheckClose(in, &err)
 
-	// Now encrypt the src with the nonce
-	out, err := f.cipher.newEncrypter(in, &nonce)
+	// Now encrypt the src with the nonce and key
+	out, err := c.newEncrypter(in, &header)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to make encrypter")
 	}
@@ -612,23 +1007,17 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	return m.Sums()[hashType], nil
 }
 
-// ComputeHash takes the nonce from o, and encrypts the contents of
-// src with it, and calculates the hash given by HashType on the fly
+// ComputeHash takes the nonce and key from o, and encrypts the
+// contents of src with them, and calculates the hash given by
+// HashType on the fly
 //
 // Note that we break lots of encapsulation in this function.
 func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType hash.Type) (hashStr string, err error) {
-	// Read the nonce - opening the file is sufficient to read the nonce in
-	// use a limited read so we only read the header
-	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
-	if err != nil {
-		return "", errors.Wrap(err, "failed to open object to read nonce")
-	}
-	d, err := f.cipher.newDecrypter(in)
+	header, keyCipher, err := o.readHeader(ctx)
 	if err != nil {
-		_ = in.Close()
-		return "", errors.Wrap(err, "failed to open object to read nonce")
+		return "", err
 	}
-	nonce := d.nonce
+	nonce := header.nonce
 	// fs.Debugf(o, "Read nonce % 2x", nonce)
 
 	// Check nonce isn't all zeros
@@ -642,13 +1031,87 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		fs.Errorf(o, "empty nonce read")
 	}
 
+	return f.computeHashWithHeader(ctx, keyCipher, header, src, hashType)
+}
+
+// readHeader reads the header of o returning it and the cipher of the
+// key it was encrypted with
+func (o *Object) readHeader(ctx context.Context) (header fileHeader, keyCipher *Cipher, err error) {
+	// Read the nonce - opening the file is sufficient to read the nonce in
+	// use a limited read so we only read the header
+	end := int64(o.format.headerSize()) - 1
+	findKey := o.format == fileFormatV1 && len(o.cipher.old) > 0
+	if findKey {
+		// the key of a v1 file is found by decrypting the first block
+		end += blockSize
+	}
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: end})
+	if err != nil {
+		return header, nil, errors.Wrap(err, "failed to open object to read nonce")
+	}
+	d, err := o.cipher.newDecrypter(in)
+	if err != nil {
+		_ = in.Close()
+		return header, nil, errors.Wrap(err, "failed to open object to read nonce")
+	}
+	if findKey {
+		_, err = d.Read(make([]byte, 1))
+		if err != nil && err != io.EOF {
+			_ = d.Close()
+			return header, nil, errors.Wrap(err, "failed to read first block")
+		}
+	}
+	header, keyCipher = d.header, d.cipher
+
 	// Close d (and hence in) once we have read the nonce
 	err = d.Close()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to close nonce read")
+		return header, nil, errors.Wrap(err, "failed to close nonce read")
+	}
+	return header, keyCipher, nil
+}
+
+// CheckHMAC checks the contents of src against the HMAC stored at the
+// end of o, which only needs the header and trailer of o to be read.
+//
+// It returns true if differences were found, and noHMAC true if o
+// isn't in a format with an HMAC.
+func (o *Object) CheckHMAC(ctx context.Context, src fs.Object) (differ bool, noHMAC bool, err error) {
+	trailerSize := int64(o.format.trailerSize())
+	if trailerSize == 0 {
+		return false, true, nil
+	}
+	header, _, err := o.readHeader(ctx)
+	if err != nil {
+		return true, false, err
+	}
+	size := o.Object.Size()
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: size - trailerSize, End: size - 1})
+	if err != nil {
+		return true, false, errors.Wrap(err, "failed to open object to read HMAC")
+	}
+	trailer := make([]byte, trailerSize)
+	_, err = io.ReadFull(in, trailer)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return true, false, errors.Wrap(err, "failed to read HMAC")
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	// Open the src and calculate the HMAC of its contents
+	srcIn, err := src.Open(ctx)
+	if err != nil {
+		return true, false, errors.Wrap(err, "failed to open src")
+	}
+	defer fs.CheckClose(srcIn, &err)
+	mac := header.newHMAC()
+	_, err = io.Copy(mac, srcIn)
+	if err != nil {
+		return true, false, errors.Wrap(err, "failed to calculate HMAC")
+	}
+	return !hmac.Equal(mac.Sum(nil), trailer), false, nil
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -744,6 +1207,59 @@ Usage Example:
 
     rclone backend decode crypt: encryptedfile1 [encryptedfile2...]
     rclone rc backend/command command=decode fs=crypt: encryptedfile1 [encryptedfile2...]
+`,
+	},
+	{
+		Name:  "migrate-format",
+		Short: "Convert files to the format set in the config",
+		Long: `This rewrites the files in the paths given, or the whole remote if
+none are given, which aren't in the format set by the "format" option,
+then deletes the old copies.
+
+Usage Example:
+
+    rclone backend migrate-format crypt: [path...]
+    rclone backend migrate-format --crypt-format v2 crypt: photos
+    rclone rc backend/command command=migrate-format fs=crypt: [path...]
+
+Each file is read, encrypted again and uploaded, so this takes as long
+as downloading and uploading the files. Only the file names change, so
+directories don't need to be renamed. It can be stopped and run again
+at any time, and with --dry-run shows what it would convert.
+
+When converting to format v1 it sets "mixed_formats" in the config so
+the files not converted yet can still be found, and unsets it once the
+whole remote has been converted without failures.
+
+It returns the number of files checked and the files converted and
+failed.
+`,
+	},
+	{
+		Name:  "rekey",
+		Short: "Encrypt files encrypted with old passwords with the password",
+		Long: `This encrypts the files in the paths given, or the whole remote if none
+are given, which were encrypted with one of the "old_passwords" again
+with "password", then deletes the old copies. They are written in the
+format set by the "format" option.
+
+Usage Example:
+
+    rclone backend rekey crypt: [path...]
+    rclone backend rekey -P crypt: photos
+    rclone rc backend/command command=rekey fs=crypt: [path...]
+
+Each file is read, encrypted again and uploaded, so this takes as long
+as downloading and uploading the files. Use -P to see the progress. It
+can be stopped and run again at any time as files already encrypted
+with the password are skipped, and with --dry-run shows what it would
+encrypt.
+
+Once it has finished without failures the old passwords can be removed
+from the config.
+
+It returns the number of files checked and the files encrypted again
+and failed.
 `,
 	},
 }
@@ -776,6 +1292,10 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			out = append(out, encryptedFileName)
 		}
 		return out, nil
+	case "migrate-format":
+		return f.migrateFormat(ctx, arg)
+	case "rekey":
+		return f.rekey(ctx, arg)
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -786,13 +1306,21 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 // This decrypts the remote name and decrypts the data
 type Object struct {
 	fs.Object
-	f *Fs
+	f      *Fs
+	cipher *Cipher    // cipher of the key of the name
+	format fileFormat // format of the file, from its name
 }
 
 func (f *Fs) newObject(o fs.Object) *Object {
+	_, format, keyCipher, err := f.cipher.decryptFileNameCipher(o.Remote())
+	if err != nil {
+		format, keyCipher = f.cipher.format, f.cipher
+	}
 	return &Object{
 		Object: o,
 		f:      f,
+		cipher: keyCipher,
+		format: format,
 	}
 }
 
@@ -812,7 +1340,7 @@ func (o *Object) String() string {
 // Remote returns the remote path
 func (o *Object) Remote() string {
 	remote := o.Object.Remote()
-	decryptedName, err := o.f.cipher.DecryptFileName(remote)
+	decryptedName, err := o.cipher.DecryptFileName(remote)
 	if err != nil {
 		fs.Debugf(remote, "Undecryptable file name: %v", err)
 		return remote
@@ -822,7 +1350,7 @@ func (o *Object) Remote() string {
 
 // Size returns the size of the file
 func (o *Object) Size() int64 {
-	size, err := o.f.cipher.DecryptedSize(o.Object.Size())
+	size, err := o.format.decryptedSize(o.Object.Size())
 	if err != nil {
 		fs.Debugf(o, "Bad size for decrypt: %v", err)
 	}
@@ -855,7 +1383,7 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 			openOptions = append(openOptions, option)
 		}
 	}
-	rc, err = o.f.cipher.DecryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+	rc, err = o.cipher.decryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
 		if underlyingOffset == 0 && underlyingLimit < 0 {
 			// Open with no seek
 			return o.Object.Open(ctx, openOptions...)
@@ -870,7 +1398,7 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		}
 		newOpenOptions := append(openOptions, &fs.RangeOption{Start: underlyingOffset, End: end})
 		return o.Object.Open(ctx, newOpenOptions...)
-	}, offset, limit)
+	}, o.format, offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -878,11 +1406,14 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 }
 
 // Update in to the object with the modTime given of the given size
+//
+// The file is kept in the same format with the same key so its name
+// doesn't change.
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
 	update := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 		return o.Object, o.Object.Update(ctx, in, src, options...)
 	}
-	_, err := o.f.put(ctx, in, src, options, update)
+	_, err := o.f.put(ctx, in, src, options, update, o.cipher, o.format)
 	return err
 }
 
@@ -932,15 +1463,17 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 // This encrypts the remote name and adjusts the size
 type ObjectInfo struct {
 	fs.ObjectInfo
-	f     *Fs
-	nonce nonce
+	f      *Fs
+	cipher *Cipher
+	header fileHeader
 }
 
-func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce) *ObjectInfo {
+func (f *Fs) newObjectInfo(src fs.ObjectInfo, c *Cipher, header fileHeader) *ObjectInfo {
 	return &ObjectInfo{
 		ObjectInfo: src,
 		f:          f,
-		nonce:      nonce,
+		cipher:     c,
+		header:     header,
 	}
 }
 
@@ -951,7 +1484,7 @@ func (o *ObjectInfo) Fs() fs.Info {
 
 // Remote returns the remote path
 func (o *ObjectInfo) Remote() string {
-	return o.f.cipher.EncryptFileName(o.ObjectInfo.Remote())
+	return o.cipher.encryptFileNameFormat(o.ObjectInfo.Remote(), o.header.format)
 }
 
 // Size returns the size of the file
@@ -960,7 +1493,7 @@ func (o *ObjectInfo) Size() int64 {
 	if size < 0 {
 		return size
 	}
-	return o.f.cipher.EncryptedSize(size)
+	return o.header.format.encryptedSize(size)
 }
 
 // Hash returns the selected checksum of the file
@@ -981,7 +1514,7 @@ func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
 	if srcObj.Fs().Features().IsLocal {
 		// Read the data and encrypt it to calculate the hash
 		fs.Debugf(o, "Computing %v hash of encrypted source", hash)
-		return o.f.computeHashWithNonce(ctx, o.nonce, srcObj, hash)
+		return o.f.computeHashWithHeader(ctx, o.cipher, o.header, srcObj, hash)
 	}
 	return "", nil
 }
@@ -1021,6 +1554,8 @@ var (
 	_ fs.Copier          = (*Fs)(nil)
 	_ fs.Mover           = (*Fs)(nil)
 	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirSetModTimer  = (*Fs)(nil)
+	_ fs.DirMetadataer   = (*Fs)(nil)
 	_ fs.Commander       = (*Fs)(nil)
 	_ fs.PutUncheckeder  = (*Fs)(nil)
 	_ fs.PutStreamer     = (*Fs)(nil)
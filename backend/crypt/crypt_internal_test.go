@@ -4,12 +4,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/lib/random"
@@ -76,7 +86,7 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 	var outBuf bytes.Buffer
 	enc, err := f.cipher.newEncrypter(inBuf, nil)
 	require.NoError(t, err)
-	nonce := enc.nonce // read the nonce at the start
+	header := enc.header // read the header at the start
 	_, err = io.Copy(&outBuf, enc)
 	require.NoError(t, err)
 
@@ -86,9 +96,9 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 		oi = testWrapper{oi}
 	}
 
-	// wrap the object in a crypt for upload using the nonce we
+	// wrap the object in a crypt for upload using the header we
 	// saved from the encrypter
-	src := f.newObjectInfo(oi, nonce)
+	src := f.newObjectInfo(oi, f.cipher, header)
 
 	// Test ObjectInfo methods
 	assert.Equal(t, int64(outBuf.Len()), src.Size())
@@ -141,3 +151,415 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("ObjectInfoWrap", func(t *testing.T) { testObjectInfo(t, f, true) })
 	t.Run("ComputeHash", func(t *testing.T) { testComputeHash(t, f) })
 }
+
+// newTestFs makes a crypt Fs on dir with the config given added to
+// the defaults
+func newTestFs(t *testing.T, dir string, config configmap.Simple) *Fs {
+	m := configmap.Simple{
+		"remote":                    dir,
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+	}
+	for k, v := range config {
+		m[k] = v
+	}
+	f, err := NewFs(context.Background(), "crypt", "", m)
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+// readFile reads the contents of remote from f
+func readFile(t *testing.T, f fs.Fs, remote string) string {
+	o, err := f.NewObject(context.Background(), remote)
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestMigrateFormat(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-crypt-migrate")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f1 := newTestFs(t, dir, configmap.Simple{"format": "v1", "mixed_formats": "true"})
+	f2 := newTestFs(t, dir, configmap.Simple{"format": "v2"})
+
+	uploadFile(t, f1, "a/one.txt", "one")
+	uploadFile(t, f1, "two.txt", "two")
+	three, _ := uploadFile(t, f2, "a/three.txt", "three")
+	assert.Equal(t, fileFormatV2, three.(*Object).format)
+	assert.Equal(t, int64(5), three.Size())
+
+	// Both formats can be read whatever the format written
+	for _, f := range []*Fs{f1, f2} {
+		assert.Equal(t, "one", readFile(t, f, "a/one.txt"))
+		assert.Equal(t, "two", readFile(t, f, "two.txt"))
+		assert.Equal(t, "three", readFile(t, f, "a/three.txt"))
+		entries, err := f.List(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(entries))
+	}
+
+	// Without mixed_formats format v1 files are only looked for
+	fv1 := newTestFs(t, dir, configmap.Simple{"format": "v1"})
+	assert.Equal(t, "one", readFile(t, fv1, "a/one.txt"))
+	_, err = fv1.NewObject(ctx, "a/three.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	entries, err := fv1.List(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// Updating a file keeps its format
+	o, err := f2.NewObject(ctx, "two.txt")
+	require.NoError(t, err)
+	src := object.NewStaticObjectInfo("two.txt", time.Now(), 4, true, nil, nil)
+	require.NoError(t, o.Update(ctx, bytes.NewBufferString("TWO!"), src))
+	assert.Equal(t, fileFormatV1, o.(*Object).format)
+	assert.Equal(t, "TWO!", readFile(t, f2, "two.txt"))
+
+	// Migrate a directory then the rest
+	out, err := f2.Command(ctx, "migrate-format", []string{"a"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &migrateResult{
+		Checked:  2,
+		Migrated: []string{"a/one.txt"},
+		Failed:   []string{},
+	}, out)
+	out, err = f2.Command(ctx, "migrate-format", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, out.(*migrateResult).Checked)
+	assert.Equal(t, []string{"two.txt"}, out.(*migrateResult).Migrated)
+
+	// Only v2 files are left
+	for _, remote := range []string{"a/one.txt", "two.txt", "a/three.txt"} {
+		o, err := f1.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, fileFormatV2, o.(*Object).format, remote)
+		_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(f1.cipher.encryptFileNameFormat(remote, fileFormatV1))))
+		assert.True(t, os.IsNotExist(err), remote)
+	}
+	assert.Equal(t, "one", readFile(t, f1, "a/one.txt"))
+	assert.Equal(t, "TWO!", readFile(t, f1, "two.txt"))
+
+	// and they can be migrated back, setting mixed_formats until
+	// the whole remote is done
+	f3 := newTestFs(t, dir, configmap.Simple{"format": "v1"})
+	out, err = f3.Command(ctx, "migrate-format", []string{"a"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(out.(*migrateResult).Migrated))
+	assert.Equal(t, "true", f3.m.(configmap.Simple)["mixed_formats"])
+	assert.Equal(t, "TWO!", readFile(t, f3, "two.txt"))
+	out, err = f3.Command(ctx, "migrate-format", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two.txt"}, out.(*migrateResult).Migrated)
+	assert.Equal(t, "false", f3.m.(configmap.Simple)["mixed_formats"])
+	assert.Equal(t, "three", readFile(t, f2, "a/three.txt"))
+}
+
+func TestRekey(t *testing.T) {
+	for _, mode := range []string{"standard", "off"} {
+		t.Run(mode, func(t *testing.T) {
+			testRekey(t, mode)
+		})
+	}
+}
+
+func testRekey(t *testing.T, mode string) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-crypt-rekey")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	fOld := newTestFs(t, dir, configmap.Simple{"filename_encryption": mode})
+	fNew := newTestFs(t, dir, configmap.Simple{
+		"filename_encryption": mode,
+		"password":            obscure.MustObscure("sausage"),
+		"old_passwords":       obscure.MustObscure("potato"),
+	})
+	fNewOnly := newTestFs(t, dir, configmap.Simple{
+		"filename_encryption": mode,
+		"password":            obscure.MustObscure("sausage"),
+	})
+
+	uploadFile(t, fOld, "a/b/one.txt", "one")
+	uploadFile(t, fOld, "two.txt", "two")
+	uploadFile(t, fNew, "a/b/three.txt", "three")
+	uploadFile(t, fNew, "a/b/four.txt", "")
+
+	// The files with either key are read with the new one
+	assert.Equal(t, "one", readFile(t, fNew, "a/b/one.txt"))
+	assert.Equal(t, "two", readFile(t, fNew, "two.txt"))
+	assert.Equal(t, "three", readFile(t, fNew, "a/b/three.txt"))
+	for dir, want := range map[string][]string{
+		"":    {"a", "two.txt"},
+		"a":   {"a/b"},
+		"a/b": {"a/b/four.txt", "a/b/one.txt", "a/b/three.txt"},
+	} {
+		entries, err := fNew.List(ctx, dir)
+		require.NoError(t, err)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Remote())
+		}
+		assert.ElementsMatch(t, want, got, dir)
+	}
+	_, err = fNew.List(ctx, "potato")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	// Rekey the old files
+	out, err := fNew.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	res := out.(*rekeyResult)
+	assert.Equal(t, 4, res.Checked)
+	assert.ElementsMatch(t, []string{"a/b/one.txt", "two.txt"}, res.Rekeyed)
+	assert.Equal(t, []string{}, res.Failed)
+	out, err = fNew.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, out.(*rekeyResult).Rekeyed)
+
+	// Now they can be read without the old key
+	assert.Equal(t, "one", readFile(t, fNewOnly, "a/b/one.txt"))
+	assert.Equal(t, "two", readFile(t, fNewOnly, "two.txt"))
+	assert.Equal(t, "three", readFile(t, fNewOnly, "a/b/three.txt"))
+	_, err = fOld.NewObject(ctx, "two.txt")
+	if mode == "off" {
+		// the name is the same but the data can't be read
+		require.NoError(t, err)
+		in, err := fOld.keys[0].fs.NewObject(ctx, "two.txt.bin")
+		require.NoError(t, err)
+		rc, err := fOld.cipher.DecryptData(mustOpen(t, in))
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(rc)
+		assert.Error(t, err)
+	} else {
+		assert.Equal(t, fs.ErrorObjectNotFound, err)
+	}
+
+	// The directories of both keys are removed
+	for _, remote := range []string{"a/b/one.txt", "a/b/three.txt", "a/b/four.txt"} {
+		o, err := fNew.NewObject(ctx, remote)
+		require.NoError(t, err)
+		require.NoError(t, o.Remove(ctx))
+	}
+	require.NoError(t, fNew.Rmdir(ctx, "a/b"))
+	require.NoError(t, fNew.Rmdir(ctx, "a"))
+	_, err = fNew.List(ctx, "a")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
+
+// mustOpen opens o for reading
+func mustOpen(t *testing.T, o fs.Object) io.ReadCloser {
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	return in
+}
+
+func TestCheckHMAC(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-crypt-hmac")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f1 := newTestFs(t, dir, configmap.Simple{"format": "v1", "mixed_formats": "true"})
+	f3 := newTestFs(t, dir, configmap.Simple{"format": "v3"})
+	localFs, cleanup := makeTempLocalFs(t)
+	defer cleanup()
+
+	o1, _ := uploadFile(t, f1, "one.txt", "one")
+	o3, _ := uploadFile(t, f3, "three.txt", "three")
+	assert.Equal(t, fileFormatV3, o3.(*Object).format)
+	assert.Equal(t, int64(5), o3.Size())
+	assert.Equal(t, "three", readFile(t, f1, "three.txt"))
+
+	// Files without an HMAC can't be checked
+	src, srcCleanup := uploadFile(t, localFs, "one.txt", "one")
+	defer srcCleanup()
+	differ, noHMAC, err := o1.(*Object).CheckHMAC(ctx, src)
+	require.NoError(t, err)
+	assert.False(t, differ)
+	assert.True(t, noHMAC)
+
+	// Files with one can
+	src, srcCleanup = uploadFile(t, localFs, "three.txt", "three")
+	differ, noHMAC, err = o3.(*Object).CheckHMAC(ctx, src)
+	require.NoError(t, err)
+	assert.False(t, differ)
+	assert.False(t, noHMAC)
+	require.NoError(t, src.Update(ctx, bytes.NewBufferString("THREE"), src))
+	differ, _, err = o3.(*Object).CheckHMAC(ctx, src)
+	require.NoError(t, err)
+	assert.True(t, differ)
+	srcCleanup()
+
+	// Corrupting the HMAC is noticed when the file is read
+	path := filepath.Join(dir, f3.cipher.EncryptFileName("three.txt"))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	in := mustOpen(t, o3)
+	_, err = ioutil.ReadAll(in)
+	assert.Equal(t, ErrorEncryptedBadHMAC, err)
+	require.NoError(t, in.Close())
+}
+
+func TestPasswordCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-crypt-password-command")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f := newTestFs(t, dir, configmap.Simple{"password2": obscure.MustObscure("salt")})
+	uploadFile(t, f, "file.txt", "contents")
+
+	// The password and password2 come from the command
+	fCommand := newTestFs(t, dir, configmap.Simple{
+		"password":         obscure.MustObscure("ignored"),
+		"password_command": "printf potato\\nsalt\\n",
+	})
+	assert.Equal(t, "contents", readFile(t, fCommand, "file.txt"))
+
+	password, password2, err := runPasswordCommand(context.Background(), fs.SpaceSepList{"echo", "potato"})
+	require.NoError(t, err)
+	assert.Equal(t, "potato", password)
+	assert.Equal(t, "", password2)
+	_, _, err = runPasswordCommand(context.Background(), fs.SpaceSepList{"echo"})
+	assert.EqualError(t, err, "password_command returned empty string")
+	_, _, err = runPasswordCommand(context.Background(), fs.SpaceSepList{"false"})
+	assert.Error(t, err)
+}
+
+func TestKMSKey(t *testing.T) {
+	ctx := context.Background()
+	for _, kmsKey := range []string{
+		"potato",
+		"aws-kms://",
+		"gcp-kms://potato",
+		"gcp-kms://projects/p/locations/l/keyRings/r",
+		"azure-kv://",
+		"azure-kv://vault.vault.azure.net/keys/name",
+		"azure-kv://vault.vault.azure.net/secrets/name/version",
+		"pkcs11:object=crypt",
+		"pkcs11:object=crypt?module-path=/lib/p11.so&pin-source=/does/not/exist",
+	} {
+		_, err := newUnwrapper(ctx, kmsKey)
+		assert.Error(t, err, kmsKey)
+	}
+
+	// Passwords are base64 encoded ciphertext when unwrapping
+	reverse := func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		plaintext := make([]byte, len(ciphertext))
+		for i := range ciphertext {
+			plaintext[len(ciphertext)-1-i] = ciphertext[i]
+		}
+		return plaintext, nil
+	}
+	password, err := revealPassword(ctx, obscure.MustObscure("b3RhdG9w"), reverse)
+	require.NoError(t, err)
+	assert.Equal(t, "potato", password)
+	password, err = revealPassword(ctx, obscure.MustObscure("potato"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "potato", password)
+	_, err = revealPassword(ctx, obscure.MustObscure("not base64!"), reverse)
+	assert.Error(t, err)
+
+	// The URL safe alphabet is accepted too
+	password, err = revealPassword(ctx, obscure.MustObscure("-_-_"), func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%x", ciphertext)), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "fbffbf", password)
+}
+
+func TestParseAzureKeyID(t *testing.T) {
+	vaultURL, keyPath, err := parseAzureKeyID("vault.vault.azure.net/keys/rclone/0123")
+	require.NoError(t, err)
+	assert.Equal(t, "https://vault.vault.azure.net/", vaultURL)
+	assert.Equal(t, "keys/rclone/0123", keyPath)
+	_, _, err = parseAzureKeyID("vault.vault.azure.net/keys/rclone/")
+	assert.Error(t, err)
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	key, err := parsePKCS11URI("pkcs11:token=my%20token;serial=0001;slot-id=7;object=crypt;id=%01%02;type=private?module-path=/lib/p11.so&pin-value=1234")
+	require.NoError(t, err)
+	slotID := uint64(7)
+	assert.Equal(t, &pkcs11Key{
+		module: "/lib/p11.so",
+		token:  "my token",
+		serial: "0001",
+		slotID: &slotID,
+		label:  "crypt",
+		id:     []byte{1, 2},
+		pin:    "1234",
+	}, key)
+
+	key, err = parsePKCS11URI("pkcs11:id=%01?module-path=/lib/p11.so&pin-source=file:/etc/pin")
+	require.NoError(t, err)
+	assert.Equal(t, &pkcs11Key{
+		module:    "/lib/p11.so",
+		id:        []byte{1},
+		pinSource: "/etc/pin",
+	}, key)
+
+	for _, uri := range []string{
+		"pkcs12:object=crypt?module-path=/lib/p11.so",
+		"pkcs11:object=crypt",
+		"pkcs11:token=rclone?module-path=/lib/p11.so",
+		"pkcs11:object=crypt;type=public?module-path=/lib/p11.so",
+		"pkcs11:object=crypt;slot-id=x?module-path=/lib/p11.so",
+		"pkcs11:object=crypt;potato=1?module-path=/lib/p11.so",
+		"pkcs11:object=crypt?module-path=/lib/p11.so&potato=1",
+		"pkcs11:object?module-path=/lib/p11.so",
+		"pkcs11:object=%zz?module-path=/lib/p11.so",
+	} {
+		_, err := parsePKCS11URI(uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestAzureUnwrapper(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"Unauthorized","message":"bad token"}}`))
+			return
+		}
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/keys/rclone/0123/decrypt", r.URL.Path)
+		assert.Equal(t, "7.1", r.URL.Query().Get("api-version"))
+		var request azureKeyOperation
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "RSA-OAEP-256", request.Algorithm)
+		ciphertext, err := base64.RawURLEncoding.DecodeString(request.Value)
+		require.NoError(t, err)
+		// "decrypt" by reversing
+		for i, j := 0, len(ciphertext)-1; i < j; i, j = i+1, j-1 {
+			ciphertext[i], ciphertext[j] = ciphertext[j], ciphertext[i]
+		}
+		_ = json.NewEncoder(w).Encode(azureKeyOperation{Value: base64.RawURLEncoding.EncodeToString(ciphertext)})
+	}))
+	defer server.Close()
+
+	token := "token"
+	unwrap := azureUnwrapper(server.Client(), server.URL+"/", "keys/rclone/0123", func(ctx context.Context) (string, error) {
+		return token, nil
+	})
+	plaintext, err := unwrap(ctx, []byte("otatop"))
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(plaintext))
+
+	token = "wrong"
+	_, err = unwrap(ctx, []byte("otatop"))
+	assert.EqualError(t, err, "Unauthorized: bad token")
+}
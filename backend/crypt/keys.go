@@ -0,0 +1,308 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/lib/rest"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// Prefixes of the kms_key option for each key management service
+const (
+	awsKMSPrefix  = "aws-kms://"
+	gcpKMSPrefix  = "gcp-kms://"
+	azureKVPrefix = "azure-kv://"
+)
+
+// Azure Key Vault settings
+const (
+	azureActiveDirectoryEndpoint = "https://login.microsoftonline.com/"
+	azureKeyVaultResource        = "https://vault.azure.net"
+	azureKeyVaultAPIVersion      = "7.1"
+	azureKeyVaultAlgorithm       = "RSA-OAEP-256"
+)
+
+// unwrapFn decrypts a password encrypted with a key management service
+type unwrapFn func(ctx context.Context, ciphertext []byte) ([]byte, error)
+
+// newUnwrapper returns the unwrapFn for the kms_key given
+func newUnwrapper(ctx context.Context, kmsKey string) (unwrapFn, error) {
+	switch {
+	case strings.HasPrefix(kmsKey, awsKMSPrefix):
+		return newAWSUnwrapper(ctx, kmsKey[len(awsKMSPrefix):])
+	case strings.HasPrefix(kmsKey, gcpKMSPrefix):
+		return newGCPUnwrapper(ctx, kmsKey[len(gcpKMSPrefix):])
+	case strings.HasPrefix(kmsKey, azureKVPrefix):
+		return newAzureUnwrapper(ctx, kmsKey[len(azureKVPrefix):])
+	case strings.HasPrefix(kmsKey, pkcs11Prefix):
+		return newPKCS11Unwrapper(ctx, kmsKey)
+	}
+	return nil, errors.Errorf("unknown kms_key %q - must start with %q, %q, %q or %q", kmsKey, awsKMSPrefix, gcpKMSPrefix, azureKVPrefix, pkcs11Prefix)
+}
+
+// newAWSUnwrapper returns an unwrapFn which decrypts with the AWS KMS
+// key given, which may be an ARN, an alias or a key ID
+//
+// The credentials are found the same way as the AWS CLI does.
+func newAWSUnwrapper(ctx context.Context, keyID string) (unwrapFn, error) {
+	if keyID == "" {
+		return nil, errors.New("no key in kms_key")
+	}
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	opts.Config.HTTPClient = fshttp.NewClient(ctx)
+	if arn.IsARN(keyID) {
+		keyARN, err := arn.Parse(keyID)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad ARN in kms_key")
+		}
+		opts.Config.Region = aws.String(keyARN.Region)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make AWS session")
+	}
+	svc := kms.New(sess)
+	return func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		out, err := svc.DecryptWithContext(ctx, &kms.DecryptInput{
+			CiphertextBlob: ciphertext,
+			KeyId:          aws.String(keyID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Plaintext, nil
+	}, nil
+}
+
+// newGCPUnwrapper returns an unwrapFn which decrypts with the Google
+// Cloud KMS key given as projects/*/locations/*/keyRings/*/cryptoKeys/*
+//
+// The credentials are the application default credentials.
+func newGCPUnwrapper(ctx context.Context, name string) (unwrapFn, error) {
+	if !strings.HasPrefix(name, "projects/") || strings.Count(name, "/") != 7 {
+		return nil, errors.Errorf("kms_key %q must be %sprojects/*/locations/*/keyRings/*/cryptoKeys/*", gcpKMSPrefix+name, gcpKMSPrefix)
+	}
+	client, err := google.DefaultClient(context.WithValue(ctx, oauth2.HTTPClient, fshttp.NewClient(ctx)), cloudkms.CloudPlatformScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find Google Cloud credentials")
+	}
+	svc, err := cloudkms.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make Google Cloud KMS client")
+	}
+	return func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		out, err := svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(name, &cloudkms.DecryptRequest{
+			Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		}).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(out.Plaintext)
+	}, nil
+}
+
+// parseAzureKeyID splits an Azure Key Vault key identifier without
+// its https:// into the vault URL and the path of the key version
+func parseAzureKeyID(keyID string) (vaultURL, keyPath string, err error) {
+	parts := strings.Split(keyID, "/")
+	if len(parts) != 4 || parts[0] == "" || parts[1] != "keys" || parts[2] == "" || parts[3] == "" {
+		return "", "", errors.Errorf("kms_key %q must be %sVAULT.vault.azure.net/keys/NAME/VERSION", azureKVPrefix+keyID, azureKVPrefix)
+	}
+	return "https://" + parts[0] + "/", strings.Join(parts[1:], "/"), nil
+}
+
+// newAzureToken returns a token for Azure Key Vault
+//
+// A service principal is used if AZURE_CLIENT_SECRET is set, with its
+// tenant in AZURE_TENANT_ID and its ID in AZURE_CLIENT_ID, otherwise
+// the managed identity of the machine, or the one with the ID in
+// AZURE_CLIENT_ID if set.
+func newAzureToken(ctx context.Context) (*adal.ServicePrincipalToken, error) {
+	var token *adal.ServicePrincipalToken
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+		oauthConfig, err := adal.NewOAuthConfig(azureActiveDirectoryEndpoint, os.Getenv("AZURE_TENANT_ID"))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating oauth config")
+		}
+		token, err = adal.NewServicePrincipalToken(*oauthConfig, clientID, secret, azureKeyVaultResource)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating service principal token")
+		}
+	} else {
+		msiEndpoint, err := adal.GetMSIEndpoint()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find managed identity endpoint")
+		}
+		if clientID != "" {
+			token, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, azureKeyVaultResource, clientID)
+		} else {
+			token, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azureKeyVaultResource)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating managed identity token")
+		}
+	}
+	token.SetSender(fshttp.NewClient(ctx))
+	return token, nil
+}
+
+// newAzureUnwrapper returns an unwrapFn which decrypts with the Azure
+// Key Vault key given as VAULT.vault.azure.net/keys/NAME/VERSION
+func newAzureUnwrapper(ctx context.Context, keyID string) (unwrapFn, error) {
+	vaultURL, keyPath, err := parseAzureKeyID(keyID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newAzureToken(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find Azure credentials")
+	}
+	return azureUnwrapper(fshttp.NewClient(ctx), vaultURL, keyPath, func(ctx context.Context) (string, error) {
+		err := token.EnsureFreshWithContext(ctx)
+		if err != nil {
+			return "", err
+		}
+		return token.OAuthToken(), nil
+	}), nil
+}
+
+// azureKeyOperation is the request and response of a Key Vault decrypt
+type azureKeyOperation struct {
+	Algorithm string `json:"alg,omitempty"`
+	Value     string `json:"value"`
+}
+
+// azureError is the error response of Key Vault
+type azureError struct {
+	Err struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Error satisfies the error interface
+func (e *azureError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err.Code, e.Err.Message)
+}
+
+// azureErrorHandler parses a non 2xx response from Key Vault
+func azureErrorHandler(resp *http.Response) error {
+	errResponse := new(azureError)
+	err := rest.DecodeJSON(resp, errResponse)
+	if err != nil || errResponse.Err.Code == "" {
+		return errors.Errorf("key vault error: %s", resp.Status)
+	}
+	return errResponse
+}
+
+// azureUnwrapper returns an unwrapFn which decrypts with the key at
+// keyPath in the Key Vault at vaultURL, authenticating with the
+// bearer tokens returned by token
+func azureUnwrapper(client *http.Client, vaultURL, keyPath string, token func(ctx context.Context) (string, error)) unwrapFn {
+	srv := rest.NewClient(client).SetRoot(vaultURL).SetErrorHandler(azureErrorHandler)
+	return func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		accessToken, err := token(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get Azure token")
+		}
+		opts := rest.Opts{
+			Method:       "POST",
+			Path:         keyPath + "/decrypt",
+			Parameters:   url.Values{"api-version": {azureKeyVaultAPIVersion}},
+			ExtraHeaders: map[string]string{"Authorization": "Bearer " + accessToken},
+		}
+		request := azureKeyOperation{
+			Algorithm: azureKeyVaultAlgorithm,
+			Value:     base64.RawURLEncoding.EncodeToString(ciphertext),
+		}
+		var response azureKeyOperation
+		_, err = srv.CallJSON(ctx, &opts, &request, &response)
+		if err != nil {
+			return nil, err
+		}
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(response.Value, "="))
+	}
+}
+
+// decodeCiphertext decodes the base64 encoded ciphertext of a password
+// accepting the URL safe alphabet as Azure uses it
+func decodeCiphertext(encoded string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil {
+		return ciphertext, nil
+	}
+	ciphertext, urlErr := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if urlErr == nil {
+		return ciphertext, nil
+	}
+	return nil, err
+}
+
+// revealPassword reveals a password from the config and, if unwrap
+// is set, decrypts it from the base64 encoded ciphertext it is
+func revealPassword(ctx context.Context, obscured string, unwrap unwrapFn) (string, error) {
+	password, err := obscure.Reveal(obscured)
+	if err != nil || unwrap == nil {
+		return password, err
+	}
+	ciphertext, err := decodeCiphertext(strings.TrimSpace(password))
+	if err != nil {
+		return "", errors.Wrap(err, "password isn't base64 encoded")
+	}
+	plaintext, err := unwrap(ctx, ciphertext)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt with kms_key")
+	}
+	return string(plaintext), nil
+}
+
+// runPasswordCommand runs the password_command returning the password
+// from the first line of its output and password2, if any, from the
+// second
+func runPasswordCommand(ctx context.Context, command fs.SpaceSepList) (password, password2 string, err error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		if ers := strings.TrimSpace(stderr.String()); ers != "" {
+			fs.Errorf(nil, "crypt password_command stderr: %s", ers)
+		}
+		return "", "", errors.Wrap(err, "password_command failed")
+	}
+	lines := strings.SplitN(strings.Trim(stdout.String(), "\r\n"), "\n", 3)
+	password = strings.TrimRight(lines[0], "\r")
+	if password == "" {
+		return "", "", errors.New("password_command returned empty string")
+	}
+	if len(lines) > 1 {
+		password2 = strings.TrimRight(lines[1], "\r")
+	}
+	return password, password2, nil
+}
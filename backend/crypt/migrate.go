@@ -0,0 +1,227 @@
+package crypt
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// migrateResult is returned by the migrate-format command
+type migrateResult struct {
+	Checked  int      `json:"checked"`  // number of files checked
+	Migrated []string `json:"migrated"` // files converted to the configured format
+	Failed   []string `json:"failed"`   // files which couldn't be converted
+}
+
+// rekeyResult is returned by the rekey command
+type rekeyResult struct {
+	Checked int      `json:"checked"` // number of files checked
+	Rekeyed []string `json:"rekeyed"` // files encrypted again with the password
+	Failed  []string `json:"failed"`  // files which couldn't be encrypted again
+}
+
+// migrateFormat converts the files at the paths given, which may be
+// directories, or all the files if there are none, to the format of
+// the cipher
+func (f *Fs) migrateFormat(ctx context.Context, paths []string) (*migrateResult, error) {
+	action := "migrate to format " + f.cipher.format.String()
+	toV1 := f.cipher.format == fileFormatV1 && !fs.GetConfig(ctx).DryRun
+	if toV1 {
+		// Find the files not converted yet until they all are
+		f.setMixedFormats(true)
+	}
+	checked, migrated, failed, err := f.rewriteFiles(ctx, paths, action, func(ctx context.Context, o *Object) (bool, error) {
+		return o.format != f.cipher.format, nil
+	})
+	if toV1 && err == nil && len(paths) == 0 && len(failed) == 0 && f.root == "" {
+		f.setMixedFormats(false)
+	}
+	return &migrateResult{
+		Checked:  checked,
+		Migrated: migrated,
+		Failed:   failed,
+	}, err
+}
+
+// setMixedFormats sets whether files are looked for in all the
+// formats and saves it in the config
+func (f *Fs) setMixedFormats(mixed bool) {
+	if f.cipher.mixedFormats == mixed {
+		return
+	}
+	for _, c := range f.cipher.ciphers() {
+		c.mixedFormats = mixed
+	}
+	f.opt.MixedFormats = mixed
+	f.m.Set("mixed_formats", strconv.FormatBool(mixed))
+	fs.Infof(f, "Set mixed_formats = %v in the config", mixed)
+}
+
+// rekey encrypts the files at the paths given, which may be
+// directories, or all the files if there are none, which were
+// encrypted with old passwords again with the password
+func (f *Fs) rekey(ctx context.Context, paths []string) (*rekeyResult, error) {
+	checked, rekeyed, failed, err := f.rewriteFiles(ctx, paths, "rekey", func(ctx context.Context, o *Object) (bool, error) {
+		keyCipher, err := o.keyCipher(ctx)
+		return keyCipher != f.cipher, err
+	})
+	return &rekeyResult{
+		Checked: checked,
+		Rekeyed: rekeyed,
+		Failed:  failed,
+	}, err
+}
+
+// rewriteFiles writes the files at the paths given, which may be
+// directories, or all the files if there are none, again with the
+// password in the configured format if need returns true for them.
+//
+// It returns the number of files checked and the files rewritten
+// and which failed.
+func (f *Fs) rewriteFiles(ctx context.Context, paths []string, action string, need func(ctx context.Context, o *Object) (bool, error)) (checked int, rewritten, failed []string, err error) {
+	rewritten, failed = []string{}, []string{}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	for _, remote := range paths {
+		var objs []*Object
+		if remote != "" {
+			o, err := f.NewObject(ctx, remote)
+			if err == nil {
+				objs = append(objs, o.(*Object))
+			} else if err != fs.ErrorObjectNotFound && errors.Cause(err) != fs.ErrorNotAFile {
+				return checked, rewritten, failed, err
+			}
+		}
+		if objs == nil {
+			// Read the listing first as rewriting renames files
+			err := walk.ListR(ctx, f, remote, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+				entries.ForObject(func(o fs.Object) {
+					objs = append(objs, o.(*Object))
+				})
+				return nil
+			})
+			if err != nil {
+				return checked, rewritten, failed, err
+			}
+		}
+		for _, o := range objs {
+			checked++
+			needed, err := need(ctx, o)
+			if err != nil {
+				fs.Errorf(o, "Failed to check: %v", err)
+				failed = append(failed, o.Remote())
+				continue
+			}
+			if !needed {
+				continue
+			}
+			if fs.GetConfig(ctx).DryRun {
+				fs.Logf(o, "Skipped %s as --dry-run is set", action)
+				rewritten = append(rewritten, o.Remote())
+				continue
+			}
+			err = f.rewriteObject(ctx, o)
+			if err != nil {
+				fs.Errorf(o, "Failed to %s: %v", action, err)
+				failed = append(failed, o.Remote())
+				continue
+			}
+			fs.Infof(o, "Finished %s", action)
+			rewritten = append(rewritten, o.Remote())
+		}
+	}
+	return checked, rewritten, failed, nil
+}
+
+// rewriteObject writes the contents of o again with the password in
+// the configured format then removes o if it has a different name.
+//
+// If it is interrupted the old file is still there, and any new one
+// is replaced when it is run again.
+func (f *Fs) rewriteObject(ctx context.Context, o *Object) (err error) {
+	tr := accounting.Stats(ctx).NewTransfer(o)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	in, err := o.Open(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to open")
+	}
+	inPlace := o.Object.Fs() == f.Fs && o.Object.Remote() == f.cipher.EncryptFileName(o.Remote())
+	if inPlace {
+		// Spool the contents as the file is overwritten
+		in, err = spool(in)
+		if err != nil {
+			return err
+		}
+	}
+	acc := tr.Account(ctx, in)
+	_, err = f.put(ctx, acc, o, nil, f.Fs.Put, f.cipher, f.cipher.format)
+	closeErr := acc.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return errors.Wrap(closeErr, "failed to read")
+	}
+	if inPlace {
+		return nil
+	}
+	return o.Object.Remove(ctx)
+}
+
+// spool copies in to a temporary file returning it open for reading
+// and removed when closed. in is closed.
+func spool(in io.ReadCloser) (io.ReadCloser, error) {
+	tmp, err := ioutil.TempFile("", "rclone-crypt-")
+	if err != nil {
+		_ = in.Close()
+		return nil, errors.Wrap(err, "failed to make temporary file")
+	}
+	_, err = io.Copy(tmp, in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+	return tempFile{tmp}, nil
+}
+
+// tempFile is a temporary file which is removed when closed
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the file
+func (tmp tempFile) Close() error {
+	err := tmp.File.Close()
+	_ = os.Remove(tmp.Name())
+	return err
+}
+
+// keyCipher returns the cipher of the key o was encrypted with
+//
+// This is the key of its name unless the names aren't encrypted, when
+// the start of the file is read to find it.
+func (o *Object) keyCipher(ctx context.Context) (*Cipher, error) {
+	if o.cipher.mode != NameEncryptionOff || len(o.cipher.old) == 0 {
+		return o.cipher, nil
+	}
+	_, keyCipher, err := o.readHeader(ctx)
+	return keyCipher, err
+}
@@ -0,0 +1,136 @@
+package crypt
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pkcs11Prefix is the scheme of a PKCS#11 URI (RFC 7512) in kms_key
+const pkcs11Prefix = "pkcs11:"
+
+// pkcs11Key is a private key on a PKCS#11 token as found by the
+// attributes of a PKCS#11 URI
+type pkcs11Key struct {
+	module    string  // path of the PKCS#11 module to load
+	token     string  // label of the token, "" for any
+	serial    string  // serial number of the token, "" for any
+	slotID    *uint64 // ID of the slot of the token, nil for any
+	label     string  // label of the key, "" for any
+	id        []byte  // ID of the key, nil for any
+	pin       string  // PIN to log in to the token with, "" for none
+	pinSource string  // file to read the PIN from, "" for none
+}
+
+// parsePKCS11URI parses a PKCS#11 URI such as
+//
+//	pkcs11:token=rclone;object=crypt?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/rclone/pin
+//
+// into the key it names. Only the attributes needed to find a private
+// key are understood.
+func parsePKCS11URI(uri string) (*pkcs11Key, error) {
+	if !strings.HasPrefix(uri, pkcs11Prefix) {
+		return nil, errors.Errorf("kms_key %q must start with %q", uri, pkcs11Prefix)
+	}
+	path, query := uri[len(pkcs11Prefix):], ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	key := new(pkcs11Key)
+	parse := func(attrs, sep string, set func(name, value string) error) error {
+		if attrs == "" {
+			return nil
+		}
+		for _, attr := range strings.Split(attrs, sep) {
+			i := strings.IndexByte(attr, '=')
+			if i < 0 {
+				return errors.Errorf("no value for %q in kms_key", attr)
+			}
+			value, err := url.PathUnescape(attr[i+1:])
+			if err != nil {
+				return errors.Wrapf(err, "bad value for %q in kms_key", attr[:i])
+			}
+			err = set(attr[:i], value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := parse(path, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			key.token = value
+		case "serial":
+			key.serial = value
+		case "slot-id":
+			slotID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return errors.Wrap(err, "bad slot-id in kms_key")
+			}
+			key.slotID = &slotID
+		case "object":
+			key.label = value
+		case "id":
+			key.id = []byte(value)
+		case "type":
+			if value != "private" {
+				return errors.Errorf("kms_key must be a private key not %q", value)
+			}
+		default:
+			return errors.Errorf("unknown attribute %q in kms_key", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = parse(query, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			key.module = value
+		case "pin-value":
+			key.pin = value
+		case "pin-source":
+			key.pinSource = strings.TrimPrefix(value, "file:")
+		default:
+			return errors.Errorf("unknown query attribute %q in kms_key", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key.module == "" {
+		return nil, errors.New("no module-path in kms_key")
+	}
+	if key.label == "" && key.id == nil {
+		return nil, errors.New("no object or id in kms_key")
+	}
+	return key, nil
+}
+
+// newPKCS11Unwrapper returns an unwrapFn which decrypts with the
+// private RSA key on a PKCS#11 token named by the PKCS#11 URI given
+//
+// The PIN is read from the URI or the file named by its pin-source.
+func newPKCS11Unwrapper(ctx context.Context, uri string) (unwrapFn, error) {
+	key, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if key.pinSource != "" {
+		pin, err := ioutil.ReadFile(key.pinSource)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read PIN")
+		}
+		key.pin = strings.TrimRight(string(pin), "\r\n")
+	}
+	return func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		return pkcs11Decrypt(key, ciphertext)
+	}, nil
+}
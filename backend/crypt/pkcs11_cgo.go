@@ -0,0 +1,469 @@
+// Decrypt with keys on PKCS#11 tokens by loading their module
+
+// +build cgo
+// +build linux darwin freebsd
+
+package crypt
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+// The parts of the PKCS#11 v2.40 API which are used
+
+typedef unsigned long CK_ULONG;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_FLAGS;
+typedef CK_ULONG CK_SLOT_ID;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+typedef unsigned char CK_BYTE;
+typedef unsigned char CK_BBOOL;
+
+typedef struct {
+	CK_BYTE major;
+	CK_BYTE minor;
+} CK_VERSION;
+
+typedef struct {
+	void *CreateMutex;
+	void *DestroyMutex;
+	void *LockMutex;
+	void *UnlockMutex;
+	CK_FLAGS flags;
+	void *pReserved;
+} CK_C_INITIALIZE_ARGS;
+
+typedef struct {
+	CK_BYTE label[32];
+	CK_BYTE manufacturerID[32];
+	CK_BYTE model[16];
+	CK_BYTE serialNumber[16];
+	CK_FLAGS flags;
+	CK_ULONG ulMaxSessionCount;
+	CK_ULONG ulSessionCount;
+	CK_ULONG ulMaxRwSessionCount;
+	CK_ULONG ulRwSessionCount;
+	CK_ULONG ulMaxPinLen;
+	CK_ULONG ulMinPinLen;
+	CK_ULONG ulTotalPublicMemory;
+	CK_ULONG ulFreePublicMemory;
+	CK_ULONG ulTotalPrivateMemory;
+	CK_ULONG ulFreePrivateMemory;
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	CK_BYTE utcTime[16];
+} CK_TOKEN_INFO;
+
+typedef struct {
+	CK_ULONG type;
+	void *pValue;
+	CK_ULONG ulValueLen;
+} CK_ATTRIBUTE;
+
+typedef struct {
+	CK_ULONG mechanism;
+	void *pParameter;
+	CK_ULONG ulParameterLen;
+} CK_MECHANISM;
+
+typedef struct {
+	CK_ULONG hashAlg;
+	CK_ULONG mgf;
+	CK_ULONG source;
+	void *pSourceData;
+	CK_ULONG ulSourceDataLen;
+} CK_RSA_PKCS_OAEP_PARAMS;
+
+// CK_FUNCTION_LIST up to C_Decrypt - the functions which aren't used
+// are void pointers
+typedef struct {
+	CK_VERSION version;
+	CK_RV (*C_Initialize)(void *);
+	CK_RV (*C_Finalize)(void *);
+	void *C_GetInfo;
+	void *C_GetFunctionList;
+	CK_RV (*C_GetSlotList)(CK_BBOOL, CK_SLOT_ID *, CK_ULONG *);
+	void *C_GetSlotInfo;
+	CK_RV (*C_GetTokenInfo)(CK_SLOT_ID, CK_TOKEN_INFO *);
+	void *C_GetMechanismList;
+	void *C_GetMechanismInfo;
+	void *C_InitToken;
+	void *C_InitPIN;
+	void *C_SetPIN;
+	CK_RV (*C_OpenSession)(CK_SLOT_ID, CK_FLAGS, void *, void *, CK_SESSION_HANDLE *);
+	CK_RV (*C_CloseSession)(CK_SESSION_HANDLE);
+	void *C_CloseAllSessions;
+	void *C_GetSessionInfo;
+	void *C_GetOperationState;
+	void *C_SetOperationState;
+	CK_RV (*C_Login)(CK_SESSION_HANDLE, CK_ULONG, CK_BYTE *, CK_ULONG);
+	CK_RV (*C_Logout)(CK_SESSION_HANDLE);
+	void *C_CreateObject;
+	void *C_CopyObject;
+	void *C_DestroyObject;
+	void *C_GetObjectSize;
+	void *C_GetAttributeValue;
+	void *C_SetAttributeValue;
+	CK_RV (*C_FindObjectsInit)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*C_FindObjects)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *);
+	CK_RV (*C_FindObjectsFinal)(CK_SESSION_HANDLE);
+	void *C_EncryptInit;
+	void *C_Encrypt;
+	void *C_EncryptUpdate;
+	void *C_EncryptFinal;
+	CK_RV (*C_DecryptInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*C_Decrypt)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+} CK_FUNCTION_LIST;
+
+#define CKR_OK 0x0
+#define CKF_OS_LOCKING_OK 0x2
+#define CKF_SERIAL_SESSION 0x4
+#define CKU_USER 1
+#define CKA_CLASS 0x0
+#define CKA_LABEL 0x3
+#define CKA_ID 0x102
+#define CKO_PRIVATE_KEY 0x3
+#define CKM_RSA_PKCS_OAEP 0x9
+#define CKM_SHA256 0x250
+#define CKG_MGF1_SHA256 0x2
+#define CKZ_DATA_SPECIFIED 0x1
+
+static CK_RV ck_get_function_list(void *getFunctionList, CK_FUNCTION_LIST **f) {
+	return ((CK_RV (*)(CK_FUNCTION_LIST **))getFunctionList)(f);
+}
+
+static CK_RV ck_initialize(CK_FUNCTION_LIST *f) {
+	CK_C_INITIALIZE_ARGS args;
+	memset(&args, 0, sizeof(args));
+	args.flags = CKF_OS_LOCKING_OK;
+	return f->C_Initialize(&args);
+}
+
+static CK_RV ck_finalize(CK_FUNCTION_LIST *f) {
+	return f->C_Finalize(NULL);
+}
+
+static CK_RV ck_get_slot_list(CK_FUNCTION_LIST *f, CK_SLOT_ID *slots, CK_ULONG *n) {
+	return f->C_GetSlotList(1, slots, n);
+}
+
+static CK_RV ck_get_token_info(CK_FUNCTION_LIST *f, CK_SLOT_ID slot, CK_TOKEN_INFO *info) {
+	return f->C_GetTokenInfo(slot, info);
+}
+
+static CK_RV ck_open_session(CK_FUNCTION_LIST *f, CK_SLOT_ID slot, CK_SESSION_HANDLE *session) {
+	return f->C_OpenSession(slot, CKF_SERIAL_SESSION, NULL, NULL, session);
+}
+
+static CK_RV ck_close_session(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session) {
+	return f->C_CloseSession(session);
+}
+
+static CK_RV ck_login(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session, CK_BYTE *pin, CK_ULONG pinLen) {
+	return f->C_Login(session, CKU_USER, pin, pinLen);
+}
+
+static CK_RV ck_logout(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session) {
+	return f->C_Logout(session);
+}
+
+// ck_find_keys finds up to max private keys with the label and ID
+// given, either of which may be NULL to match any
+static CK_RV ck_find_keys(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session, CK_BYTE *label, CK_ULONG labelLen, CK_BYTE *id, CK_ULONG idLen, CK_OBJECT_HANDLE *keys, CK_ULONG max, CK_ULONG *n) {
+	CK_ULONG class = CKO_PRIVATE_KEY;
+	CK_ATTRIBUTE template[3];
+	CK_ULONG count = 0;
+	template[count].type = CKA_CLASS;
+	template[count].pValue = &class;
+	template[count].ulValueLen = sizeof(class);
+	count++;
+	if (label != NULL) {
+		template[count].type = CKA_LABEL;
+		template[count].pValue = label;
+		template[count].ulValueLen = labelLen;
+		count++;
+	}
+	if (id != NULL) {
+		template[count].type = CKA_ID;
+		template[count].pValue = id;
+		template[count].ulValueLen = idLen;
+		count++;
+	}
+	CK_RV rv = f->C_FindObjectsInit(session, template, count);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = f->C_FindObjects(session, keys, max, n);
+	CK_RV finalRv = f->C_FindObjectsFinal(session);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return finalRv;
+}
+
+// ck_decrypt_init starts decrypting with RSA OAEP using SHA-256
+static CK_RV ck_decrypt_init(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE key) {
+	CK_RSA_PKCS_OAEP_PARAMS params = {CKM_SHA256, CKG_MGF1_SHA256, CKZ_DATA_SPECIFIED, NULL, 0};
+	CK_MECHANISM mechanism = {CKM_RSA_PKCS_OAEP, &params, sizeof(params)};
+	return f->C_DecryptInit(session, &mechanism, key);
+}
+
+static CK_RV ck_decrypt(CK_FUNCTION_LIST *f, CK_SESSION_HANDLE session, CK_BYTE *in, CK_ULONG inLen, CK_BYTE *out, CK_ULONG *outLen) {
+	return f->C_Decrypt(session, in, inLen, out, outLen);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// PKCS#11 return values which are looked for or worth naming
+const (
+	ckrOK                         = 0x0
+	ckrSlotIDInvalid              = 0x3
+	ckrGeneralError               = 0x5
+	ckrFunctionFailed             = 0x6
+	ckrArgumentsBad               = 0x7
+	ckrEncryptedDataInvalid       = 0x40
+	ckrEncryptedDataLenRange      = 0x41
+	ckrKeyTypeInconsistent        = 0x63
+	ckrKeyFunctionNotPermitted    = 0x68
+	ckrMechanismInvalid           = 0x70
+	ckrMechanismParamInvalid      = 0x71
+	ckrPinIncorrect               = 0xa0
+	ckrPinLocked                  = 0xa4
+	ckrTokenNotPresent            = 0xe0
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrUserNotLoggedIn            = 0x101
+	ckrBufferTooSmall             = 0x150
+	ckrCryptokiAlreadyInitialized = 0x191
+)
+
+// pkcs11ErrorNames are the names of the return values above
+var pkcs11ErrorNames = map[C.CK_RV]string{
+	ckrSlotIDInvalid:              "CKR_SLOT_ID_INVALID",
+	ckrGeneralError:               "CKR_GENERAL_ERROR",
+	ckrFunctionFailed:             "CKR_FUNCTION_FAILED",
+	ckrArgumentsBad:               "CKR_ARGUMENTS_BAD",
+	ckrEncryptedDataInvalid:       "CKR_ENCRYPTED_DATA_INVALID",
+	ckrEncryptedDataLenRange:      "CKR_ENCRYPTED_DATA_LEN_RANGE",
+	ckrKeyTypeInconsistent:        "CKR_KEY_TYPE_INCONSISTENT",
+	ckrKeyFunctionNotPermitted:    "CKR_KEY_FUNCTION_NOT_PERMITTED",
+	ckrMechanismInvalid:           "CKR_MECHANISM_INVALID",
+	ckrMechanismParamInvalid:      "CKR_MECHANISM_PARAM_INVALID",
+	ckrPinIncorrect:               "CKR_PIN_INCORRECT",
+	ckrPinLocked:                  "CKR_PIN_LOCKED",
+	ckrTokenNotPresent:            "CKR_TOKEN_NOT_PRESENT",
+	ckrUserAlreadyLoggedIn:        "CKR_USER_ALREADY_LOGGED_IN",
+	ckrUserNotLoggedIn:            "CKR_USER_NOT_LOGGED_IN",
+	ckrBufferTooSmall:             "CKR_BUFFER_TOO_SMALL",
+	ckrCryptokiAlreadyInitialized: "CKR_CRYPTOKI_ALREADY_INITIALIZED",
+}
+
+// pkcs11Error is an error returned by a PKCS#11 function
+type pkcs11Error struct {
+	function string
+	rv       C.CK_RV
+}
+
+// Error satisfies the error interface
+func (e *pkcs11Error) Error() string {
+	name, ok := pkcs11ErrorNames[e.rv]
+	if !ok {
+		name = fmt.Sprintf("CKR 0x%X", uint64(e.rv))
+	}
+	return fmt.Sprintf("%s failed: %s", e.function, name)
+}
+
+// pkcs11Check returns an error if rv from function isn't CKR_OK
+func pkcs11Check(function string, rv C.CK_RV) error {
+	if rv == ckrOK {
+		return nil
+	}
+	return &pkcs11Error{function: function, rv: rv}
+}
+
+// pkcs11Mu stops PKCS#11 modules being initialized and finalized by
+// more than one remote at once
+var pkcs11Mu sync.Mutex
+
+// pkcs11Module is a loaded and initialized PKCS#11 module
+type pkcs11Module struct {
+	handle   unsafe.Pointer
+	f        *C.CK_FUNCTION_LIST
+	finalize bool // set if the module was initialized by us
+}
+
+// loadPKCS11Module loads and initializes the PKCS#11 module at path
+func loadPKCS11Module(path string) (m *pkcs11Module, err error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	m = new(pkcs11Module)
+	m.handle = C.dlopen(cPath, C.RTLD_NOW|C.RTLD_LOCAL)
+	if m.handle == nil {
+		return nil, errors.Errorf("failed to load PKCS#11 module: %s", C.GoString(C.dlerror()))
+	}
+	defer func() {
+		if err != nil {
+			C.dlclose(m.handle)
+		}
+	}()
+	cName := C.CString("C_GetFunctionList")
+	defer C.free(unsafe.Pointer(cName))
+	getFunctionList := C.dlsym(m.handle, cName)
+	if getFunctionList == nil {
+		return nil, errors.Errorf("%q isn't a PKCS#11 module: %s", path, C.GoString(C.dlerror()))
+	}
+	err = pkcs11Check("C_GetFunctionList", C.ck_get_function_list(getFunctionList, &m.f))
+	if err != nil {
+		return nil, err
+	}
+	rv := C.ck_initialize(m.f)
+	m.finalize = rv != ckrCryptokiAlreadyInitialized
+	if m.finalize {
+		err = pkcs11Check("C_Initialize", rv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// close finalizes and unloads the module
+func (m *pkcs11Module) close() {
+	if m.finalize {
+		C.ck_finalize(m.f)
+	}
+	C.dlclose(m.handle)
+}
+
+// findSlot returns the slot of the token key is on
+func (m *pkcs11Module) findSlot(key *pkcs11Key) (C.CK_SLOT_ID, error) {
+	var n C.CK_ULONG
+	err := pkcs11Check("C_GetSlotList", C.ck_get_slot_list(m.f, nil, &n))
+	if err != nil {
+		return 0, err
+	}
+	slots := make([]C.CK_SLOT_ID, n+1)
+	if n > 0 {
+		err = pkcs11Check("C_GetSlotList", C.ck_get_slot_list(m.f, &slots[0], &n))
+		if err != nil {
+			return 0, err
+		}
+	}
+	var found []C.CK_SLOT_ID
+	for _, slot := range slots[:n] {
+		if key.slotID != nil && uint64(slot) != *key.slotID {
+			continue
+		}
+		var info C.CK_TOKEN_INFO
+		err = pkcs11Check("C_GetTokenInfo", C.ck_get_token_info(m.f, slot, &info))
+		if err != nil {
+			return 0, err
+		}
+		label := C.GoBytes(unsafe.Pointer(&info.label[0]), C.int(len(info.label)))
+		serial := C.GoBytes(unsafe.Pointer(&info.serialNumber[0]), C.int(len(info.serialNumber)))
+		if key.token != "" && string(bytes.TrimRight(label, " \x00")) != key.token {
+			continue
+		}
+		if key.serial != "" && string(bytes.TrimRight(serial, " \x00")) != key.serial {
+			continue
+		}
+		found = append(found, slot)
+	}
+	switch len(found) {
+	case 0:
+		return 0, errors.New("no PKCS#11 token found which matches kms_key")
+	case 1:
+		return found[0], nil
+	}
+	return 0, errors.New("more than one PKCS#11 token matches kms_key - set its token or serial")
+}
+
+// decrypt decrypts ciphertext with key in session
+func (m *pkcs11Module) decrypt(session C.CK_SESSION_HANDLE, key *pkcs11Key, ciphertext []byte) ([]byte, error) {
+	var label, id *C.CK_BYTE
+	if key.label != "" {
+		label = (*C.CK_BYTE)(unsafe.Pointer(&[]byte(key.label)[0]))
+	}
+	if len(key.id) > 0 {
+		id = (*C.CK_BYTE)(unsafe.Pointer(&key.id[0]))
+	}
+	var keys [2]C.CK_OBJECT_HANDLE
+	var n C.CK_ULONG
+	err := pkcs11Check("C_FindObjects", C.ck_find_keys(m.f, session, label, C.CK_ULONG(len(key.label)), id, C.CK_ULONG(len(key.id)), &keys[0], C.CK_ULONG(len(keys)), &n))
+	if err != nil {
+		return nil, err
+	}
+	switch n {
+	case 0:
+		return nil, errors.New("no private key found on the PKCS#11 token which matches kms_key")
+	case 1:
+	default:
+		return nil, errors.New("more than one private key on the PKCS#11 token matches kms_key")
+	}
+	if len(ciphertext) == 0 {
+		return nil, errors.New("no ciphertext to decrypt")
+	}
+	err = pkcs11Check("C_DecryptInit", C.ck_decrypt_init(m.f, session, keys[0]))
+	if err != nil {
+		return nil, err
+	}
+	// The plaintext of RSA is never longer than the ciphertext
+	plaintext := make([]byte, len(ciphertext))
+	plaintextLen := C.CK_ULONG(len(plaintext))
+	rv := C.ck_decrypt(m.f, session, (*C.CK_BYTE)(unsafe.Pointer(&ciphertext[0])), C.CK_ULONG(len(ciphertext)), (*C.CK_BYTE)(unsafe.Pointer(&plaintext[0])), &plaintextLen)
+	if rv == ckrBufferTooSmall {
+		plaintext = make([]byte, plaintextLen)
+		rv = C.ck_decrypt(m.f, session, (*C.CK_BYTE)(unsafe.Pointer(&ciphertext[0])), C.CK_ULONG(len(ciphertext)), (*C.CK_BYTE)(unsafe.Pointer(&plaintext[0])), &plaintextLen)
+	}
+	err = pkcs11Check("C_Decrypt", rv)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext[:plaintextLen], nil
+}
+
+// pkcs11Decrypt decrypts ciphertext with RSA OAEP using SHA-256 with
+// the private key on a PKCS#11 token given, loading its module,
+// logging in with the PIN if set and unloading it again afterwards.
+func pkcs11Decrypt(key *pkcs11Key, ciphertext []byte) ([]byte, error) {
+	pkcs11Mu.Lock()
+	defer pkcs11Mu.Unlock()
+	m, err := loadPKCS11Module(key.module)
+	if err != nil {
+		return nil, err
+	}
+	defer m.close()
+	slot, err := m.findSlot(key)
+	if err != nil {
+		return nil, err
+	}
+	var session C.CK_SESSION_HANDLE
+	err = pkcs11Check("C_OpenSession", C.ck_open_session(m.f, slot, &session))
+	if err != nil {
+		return nil, err
+	}
+	defer C.ck_close_session(m.f, session)
+	if key.pin != "" {
+		pin := []byte(key.pin)
+		rv := C.ck_login(m.f, session, (*C.CK_BYTE)(unsafe.Pointer(&pin[0])), C.CK_ULONG(len(pin)))
+		if rv != ckrUserAlreadyLoggedIn {
+			err = pkcs11Check("C_Login", rv)
+			if err != nil {
+				return nil, err
+			}
+			defer C.ck_logout(m.f, session)
+		}
+	}
+	return m.decrypt(session, key, ciphertext)
+}
@@ -0,0 +1,66 @@
+// +build cgo
+// +build linux darwin freebsd
+
+package crypt
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPKCS11Unwrapper decrypts with the fake PKCS#11 module in
+// testdata/pkcs11.c
+func TestPKCS11Unwrapper(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler to build the PKCS#11 module with")
+	}
+	tempdir, err := ioutil.TempDir("", "rclone-pkcs11-test-")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tempdir))
+	}()
+	module := filepath.Join(tempdir, "pkcs11.so")
+	out, err := exec.Command(cc, "-shared", "-fPIC", "-o", module, filepath.Join("testdata", "pkcs11.c")).CombinedOutput()
+	require.NoError(t, err, string(out))
+	pin := filepath.Join(tempdir, "pin")
+	require.NoError(t, ioutil.WriteFile(pin, []byte("1234\n"), 0600))
+
+	ctx := context.Background()
+	for _, uri := range []string{
+		"pkcs11:object=crypt?module-path=" + module + "&pin-value=1234",
+		"pkcs11:token=rclone;id=%01?module-path=" + module + "&pin-source=" + pin,
+		"pkcs11:serial=0001;slot-id=7;object=crypt;id=%01;type=private?module-path=" + module + "&pin-value=1234",
+	} {
+		unwrap, err := newUnwrapper(ctx, uri)
+		require.NoError(t, err, uri)
+		// twice to check the module is loaded again cleanly
+		for i := 0; i < 2; i++ {
+			plaintext, err := unwrap(ctx, []byte("otatop"))
+			require.NoError(t, err, uri)
+			assert.Equal(t, "potato", string(plaintext))
+		}
+	}
+
+	for uri, wantErr := range map[string]string{
+		"pkcs11:object=crypt?module-path=" + module + "&pin-value=4321":                        "C_Login failed: CKR_PIN_INCORRECT",
+		"pkcs11:object=crypt?module-path=" + module:                                            "no private key found on the PKCS#11 token which matches kms_key",
+		"pkcs11:object=other?module-path=" + module + "&pin-value=1234":                        "no private key found on the PKCS#11 token which matches kms_key",
+		"pkcs11:token=other;object=crypt?module-path=" + module + "&pin-value=1234":            "no PKCS#11 token found which matches kms_key",
+		"pkcs11:slot-id=1;object=crypt?module-path=" + module + "&pin-value=1234":              "no PKCS#11 token found which matches kms_key",
+		"pkcs11:object=crypt?module-path=" + filepath.Join(tempdir, "pin") + "&pin-value=1234": "failed to load PKCS#11 module",
+	} {
+		unwrap, err := newUnwrapper(ctx, uri)
+		require.NoError(t, err, uri)
+		_, err = unwrap(ctx, []byte("otatop"))
+		require.Error(t, err, uri)
+		assert.Contains(t, err.Error(), wantErr, uri)
+	}
+}
@@ -0,0 +1,12 @@
+// Build for platforms where PKCS#11 modules can't be loaded
+
+// +build !linux,!darwin,!freebsd !cgo
+
+package crypt
+
+import "github.com/pkg/errors"
+
+// pkcs11Decrypt returns an error as PKCS#11 modules can't be loaded
+func pkcs11Decrypt(key *pkcs11Key, ciphertext []byte) ([]byte, error) {
+	return nil, errors.New("PKCS#11 tokens need rclone built with cgo on Linux, macOS or FreeBSD")
+}
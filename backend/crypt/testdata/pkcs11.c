@@ -0,0 +1,223 @@
+// A fake PKCS#11 module for testing kms_key which has one token
+// labelled "rclone" with the PIN "1234" holding one private key
+// labelled "crypt" with the ID 0x01 which "decrypts" by reversing.
+//
+// Build with: cc -shared -fPIC -o pkcs11.so pkcs11.c
+
+#include <string.h>
+
+typedef unsigned long CK_ULONG;
+typedef CK_ULONG CK_RV;
+typedef unsigned char CK_BYTE;
+
+typedef struct {
+	CK_BYTE major;
+	CK_BYTE minor;
+} CK_VERSION;
+
+typedef struct {
+	CK_BYTE label[32];
+	CK_BYTE manufacturerID[32];
+	CK_BYTE model[16];
+	CK_BYTE serialNumber[16];
+	CK_ULONG flags;
+	CK_ULONG counts[10];
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	CK_BYTE utcTime[16];
+} CK_TOKEN_INFO;
+
+typedef struct {
+	CK_ULONG type;
+	void *pValue;
+	CK_ULONG ulValueLen;
+} CK_ATTRIBUTE;
+
+typedef struct {
+	CK_ULONG mechanism;
+	void *pParameter;
+	CK_ULONG ulParameterLen;
+} CK_MECHANISM;
+
+typedef struct {
+	CK_ULONG hashAlg;
+	CK_ULONG mgf;
+	CK_ULONG source;
+	void *pSourceData;
+	CK_ULONG ulSourceDataLen;
+} CK_RSA_PKCS_OAEP_PARAMS;
+
+#define SLOT 7
+#define SESSION 42
+#define KEY 99
+
+static int initialized, loggedIn, found, decrypting;
+
+static CK_RV initialize(void *args) {
+	if (initialized) {
+		return 0x191;
+	}
+	initialized = 1;
+	return 0;
+}
+
+static CK_RV finalize(void *reserved) {
+	initialized = 0;
+	return 0;
+}
+
+static CK_RV getSlotList(CK_BYTE tokenPresent, CK_ULONG *slots, CK_ULONG *n) {
+	if (slots != NULL) {
+		if (*n < 1) {
+			return 0x150;
+		}
+		slots[0] = SLOT;
+	}
+	*n = 1;
+	return 0;
+}
+
+static CK_RV getTokenInfo(CK_ULONG slot, CK_TOKEN_INFO *info) {
+	if (slot != SLOT) {
+		return 0x3;
+	}
+	memset(info, ' ', sizeof(*info));
+	memcpy(info->label, "rclone", 6);
+	memcpy(info->serialNumber, "0001", 4);
+	return 0;
+}
+
+static CK_RV openSession(CK_ULONG slot, CK_ULONG flags, void *app, void *notify, CK_ULONG *session) {
+	if (!initialized || slot != SLOT) {
+		return 0x3;
+	}
+	*session = SESSION;
+	return 0;
+}
+
+static CK_RV closeSession(CK_ULONG session) {
+	loggedIn = 0;
+	return 0;
+}
+
+static CK_RV login(CK_ULONG session, CK_ULONG user, CK_BYTE *pin, CK_ULONG pinLen) {
+	if (pinLen != 4 || memcmp(pin, "1234", 4) != 0) {
+		return 0xa0;
+	}
+	loggedIn = 1;
+	return 0;
+}
+
+static CK_RV logout(CK_ULONG session) {
+	loggedIn = 0;
+	return 0;
+}
+
+static CK_RV findObjectsInit(CK_ULONG session, CK_ATTRIBUTE *template, CK_ULONG count) {
+	CK_ULONG i;
+	found = loggedIn;
+	for (i = 0; i < count; i++) {
+		CK_ATTRIBUTE *a = &template[i];
+		switch (a->type) {
+		case 0x0: // CKA_CLASS
+			found = found && *(CK_ULONG *)a->pValue == 0x3;
+			break;
+		case 0x3: // CKA_LABEL
+			found = found && a->ulValueLen == 5 && memcmp(a->pValue, "crypt", 5) == 0;
+			break;
+		case 0x102: // CKA_ID
+			found = found && a->ulValueLen == 1 && *(CK_BYTE *)a->pValue == 1;
+			break;
+		default:
+			found = 0;
+		}
+	}
+	return 0;
+}
+
+static CK_RV findObjects(CK_ULONG session, CK_ULONG *objects, CK_ULONG max, CK_ULONG *n) {
+	*n = 0;
+	if (found && max > 0) {
+		objects[0] = KEY;
+		*n = 1;
+	}
+	return 0;
+}
+
+static CK_RV findObjectsFinal(CK_ULONG session) {
+	return 0;
+}
+
+static CK_RV decryptInit(CK_ULONG session, CK_MECHANISM *mechanism, CK_ULONG key) {
+	CK_RSA_PKCS_OAEP_PARAMS *params = mechanism->pParameter;
+	if (mechanism->mechanism != 0x9 || mechanism->ulParameterLen != sizeof(*params) ||
+	    params->hashAlg != 0x250 || params->mgf != 0x2) {
+		return 0x70;
+	}
+	if (key != KEY) {
+		return 0x60;
+	}
+	decrypting = 1;
+	return 0;
+}
+
+static CK_RV decrypt(CK_ULONG session, CK_BYTE *in, CK_ULONG inLen, CK_BYTE *out, CK_ULONG *outLen) {
+	CK_ULONG i;
+	if (!decrypting) {
+		return 0x91;
+	}
+	if (out == NULL || *outLen < inLen) {
+		*outLen = inLen;
+		return out == NULL ? 0 : 0x150;
+	}
+	for (i = 0; i < inLen; i++) {
+		out[i] = in[inLen - 1 - i];
+	}
+	*outLen = inLen;
+	decrypting = 0;
+	return 0;
+}
+
+static void *functionList[] = {
+	NULL, // version
+	initialize,
+	finalize,
+	NULL, // C_GetInfo
+	NULL, // C_GetFunctionList
+	getSlotList,
+	NULL, // C_GetSlotInfo
+	getTokenInfo,
+	NULL, // C_GetMechanismList
+	NULL, // C_GetMechanismInfo
+	NULL, // C_InitToken
+	NULL, // C_InitPIN
+	NULL, // C_SetPIN
+	openSession,
+	closeSession,
+	NULL, // C_CloseAllSessions
+	NULL, // C_GetSessionInfo
+	NULL, // C_GetOperationState
+	NULL, // C_SetOperationState
+	login,
+	logout,
+	NULL, // C_CreateObject
+	NULL, // C_CopyObject
+	NULL, // C_DestroyObject
+	NULL, // C_GetObjectSize
+	NULL, // C_GetAttributeValue
+	NULL, // C_SetAttributeValue
+	findObjectsInit,
+	findObjects,
+	findObjectsFinal,
+	NULL, // C_EncryptInit
+	NULL, // C_Encrypt
+	NULL, // C_EncryptUpdate
+	NULL, // C_EncryptFinal
+	decryptInit,
+	decrypt,
+};
+
+CK_RV C_GetFunctionList(void **list) {
+	*list = functionList;
+	return 0;
+}
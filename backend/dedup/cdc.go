@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// gear is the table of random values for the rolling hash.
+//
+// It is made with splitmix64 from a fixed seed rather than math/rand
+// so the chunk boundaries - and so which chunks are shared - can
+// never change.
+var gear [256]uint64
+
+func init() {
+	x := uint64(0x3243f6a8885a308d)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// splitter splits a stream into content defined chunks.
+//
+// A boundary is put where the top bits of a gear hash over the last
+// 64 bytes are all zero, so the boundaries move with the data when
+// bytes are inserted or removed and unchanged regions of a file make
+// the same chunks.
+type splitter struct {
+	in   *bufio.Reader
+	min  int    // don't cut chunks smaller than this
+	max  int    // always cut chunks this big
+	mask uint64 // cut when the hash & mask == 0
+	buf  []byte
+}
+
+// newSplitter makes a splitter reading in which makes chunks of
+// average size avg
+func newSplitter(in io.Reader, avg int) *splitter {
+	min := avg / 4
+	max := avg * 4
+	n := uint(bits.Len64(uint64(avg-min))) - 1
+	return &splitter{
+		in:   bufio.NewReaderSize(in, 64*1024),
+		min:  min,
+		max:  max,
+		mask: ((1 << n) - 1) << (64 - n),
+		buf:  make([]byte, 0, max),
+	}
+}
+
+// next returns the next chunk or io.EOF if there are no more.
+//
+// The chunk returned is only valid until next is called again.
+func (s *splitter) next() ([]byte, error) {
+	s.buf = s.buf[:0]
+	var h uint64
+	for len(s.buf) < s.max {
+		c, err := s.in.ReadByte()
+		if err == io.EOF {
+			if len(s.buf) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.buf = append(s.buf, c)
+		h = (h << 1) + gear[c]
+		if len(s.buf) >= s.min && h&s.mask == 0 {
+			break
+		}
+	}
+	return s.buf, nil
+}
@@ -0,0 +1,839 @@
+// Package dedup provides wrappers for Fs and Object which store files
+// as content defined chunks so identical data is only stored once.
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// Globals
+const (
+	filesDir         = "files"  // directory in the wrapped remote for the manifests
+	chunksDir        = "chunks" // directory in the wrapped remote for the chunks
+	manifestVersion  = 1
+	maxManifestSize  = 64 * 1024 * 1024 // manifests bigger than this aren't read
+	minChunkSize     = 1024
+	cleanUpMinAge    = time.Hour // chunks younger than this are never cleaned up
+	defaultChunkSize = fs.SizeSuffix(1024 * 1024)
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "dedup",
+		Description: "Deduplicate files into content defined chunks",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: `Remote to store the chunks and manifests in.
+
+Normally should contain a ':' and a path, eg "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).`,
+			Required: true,
+		}, {
+			Name: "chunk_size",
+			Help: `Average size of the chunks files are split into.
+
+Smaller chunks find more duplicate data but make more objects in the
+wrapped remote. Chunks are between a quarter and four times this size.
+
+Changing this on a remote with files in it means new files won't
+share chunks with the existing ones.`,
+			Default:  defaultChunkSize,
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote    string        `config:"remote"`
+	ChunkSize fs.SizeSuffix `config:"chunk_size"`
+}
+
+// chunkRef is a reference to a chunk in a manifest
+type chunkRef struct {
+	Hash string `json:"h"` // SHA-256 of the chunk in hex
+	Size int64  `json:"s"` // size of the chunk
+}
+
+// manifest is stored in place of each file and lists its chunks
+type manifest struct {
+	Version int        `json:"ver"`
+	Size    int64      `json:"size"`
+	MD5     string     `json:"md5"`
+	Chunks  []chunkRef `json:"chunks"`
+}
+
+// chunkRemote returns the path of the chunk with hash h
+func chunkRemote(h string) string {
+	return h[:2] + "/" + h
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	name       string
+	root       string
+	opt        Options
+	files      fs.Fs // manifests under remote/files/root
+	chunks     fs.Fs // chunks under remote/chunks
+	newWrapped func(ctx context.Context, dir string) (fs.Fs, error)
+	wrapper    fs.Fs
+	features   *fs.Features // optional features
+	knownMu    sync.Mutex
+	known      map[string]struct{} // chunks known to be stored
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.ChunkSize < minChunkSize {
+		return nil, errors.Errorf("chunk_size must be at least %v", fs.SizeSuffix(minChunkSize))
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point dedup remote at itself - check the value of the remote setting")
+	}
+	wInfo, wName, wPath, wConfig, err := fs.ConfigFs(opt.Remote)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse remote %q to wrap", opt.Remote)
+	}
+	rpath = strings.Trim(rpath, "/")
+	f := &Fs{
+		name: name,
+		root: rpath,
+		opt:  *opt,
+		newWrapped: func(ctx context.Context, dir string) (fs.Fs, error) {
+			return wInfo.NewFs(ctx, wName, fspath.JoinRootPath(wPath, dir), wConfig)
+		},
+		known: make(map[string]struct{}),
+	}
+	f.chunks, err = f.newWrapped(ctx, chunksDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make remote %s:%q to store chunks", wName, chunksDir)
+	}
+	// If rpath points to a manifest then this returns fs.ErrorIsFile
+	// and the files remote points to its parent
+	f.files, err = f.newWrapped(ctx, path.Join(filesDir, rpath))
+	if err == fs.ErrorIsFile {
+		f.root = path.Dir(rpath)
+		if f.root == "." {
+			f.root = ""
+		}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to make remote %s:%q to wrap", wName, path.Join(filesDir, rpath))
+	}
+	// the features here are ones we could support, and they are
+	// ANDed with the ones from the files remote
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		ReadMimeType:            false,
+		WriteMimeType:           false,
+		BucketBased:             true,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f).Mask(ctx, f.files).WrapsFs(f, f.files)
+	// Copying only rewrites the manifest so doesn't need the
+	// files remote to support it
+	f.features.Copy = f.Copy
+	return f, err
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("Dedup '%s:%s'", f.name, f.root)
+}
+
+// Precision returns the precision of this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.files.Precision()
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5)
+}
+
+// newObject makes an Object from the manifest object mo and its
+// contents m if known
+func (f *Fs) newObject(mo fs.Object, m *manifest) *Object {
+	return &Object{
+		f:  f,
+		mo: mo,
+		m:  m,
+	}
+}
+
+// wrapEntries wraps the manifests in entries as Objects
+func (f *Fs) wrapEntries(entries fs.DirEntries) (fs.DirEntries, error) {
+	for i, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Object:
+			entries[i] = f.newObject(x, nil)
+		case fs.Directory:
+			// the directories are the same
+		default:
+			return nil, errors.Errorf("unknown object type %T", entry)
+		}
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.files.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries)
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	do := f.files.Features().ListR
+	return do(ctx, dir, func(entries fs.DirEntries) error {
+		newEntries, err := f.wrapEntries(entries)
+		if err != nil {
+			return err
+		}
+		return callback(newEntries)
+	})
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	mo, err := f.files.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(mo, nil), nil
+}
+
+// isKnown returns true if the chunk with hash h is known to be stored
+func (f *Fs) isKnown(h string) bool {
+	f.knownMu.Lock()
+	defer f.knownMu.Unlock()
+	_, found := f.known[h]
+	return found
+}
+
+// setKnown records that the chunk with hash h is stored
+func (f *Fs) setKnown(h string) {
+	f.knownMu.Lock()
+	f.known[h] = struct{}{}
+	f.knownMu.Unlock()
+}
+
+// storeChunk stores data as the chunk with hash h unless it is
+// stored already
+func (f *Fs) storeChunk(ctx context.Context, h string, data []byte) error {
+	if f.isKnown(h) {
+		return nil
+	}
+	remote := chunkRemote(h)
+	_, err := f.chunks.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, f.chunks)
+		_, err = f.chunks.Put(ctx, bytes.NewReader(data), info)
+		if err != nil {
+			return errors.Wrap(err, "failed to store chunk")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to find chunk")
+	}
+	f.setKnown(h)
+	return nil
+}
+
+// storeChunks splits in into chunks, storing the ones which aren't
+// stored already, and returns the manifest for it
+func (f *Fs) storeChunks(ctx context.Context, in io.Reader) (*manifest, error) {
+	m := &manifest{
+		Version: manifestVersion,
+		Chunks:  []chunkRef{},
+	}
+	md5sum := md5.New()
+	s := newSplitter(in, int(f.opt.ChunkSize))
+	for {
+		data, err := s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		_, _ = md5sum.Write(data)
+		sum := sha256.Sum256(data)
+		h := hex.EncodeToString(sum[:])
+		err = f.storeChunk(ctx, h, data)
+		if err != nil {
+			return nil, err
+		}
+		m.Chunks = append(m.Chunks, chunkRef{Hash: h, Size: int64(len(data))})
+		m.Size += int64(len(data))
+	}
+	m.MD5 = hex.EncodeToString(md5sum.Sum(nil))
+	return m, nil
+}
+
+// putManifest writes m as the manifest for remote, updating mo if it
+// isn't nil
+func (f *Fs) putManifest(ctx context.Context, mo fs.Object, remote string, modTime time.Time, m *manifest, options ...fs.OpenOption) (*Object, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	info := object.NewStaticObjectInfo(remote, modTime, int64(len(data)), true, nil, f.files)
+	if mo != nil {
+		err = mo.Update(ctx, bytes.NewReader(data), info, options...)
+	} else {
+		mo, err = f.files.Put(ctx, bytes.NewReader(data), info, options...)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write manifest")
+	}
+	return f.newObject(mo, m), nil
+}
+
+// put stores in as src, updating the manifest mo if it isn't nil
+func (f *Fs) put(ctx context.Context, mo fs.Object, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (*Object, error) {
+	m, err := f.storeChunks(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if size := src.Size(); size >= 0 && size != m.Size {
+		return nil, errors.Errorf("upload size mismatch: expecting %d bytes but read %d", size, m.Size)
+	}
+	if srcMD5, _ := src.Hash(ctx, hash.MD5); srcMD5 != "" && srcMD5 != m.MD5 {
+		return nil, errors.Errorf("upload corrupted: MD5 %q read but source has %q", m.MD5, srcMD5)
+	}
+	return f.putManifest(ctx, mo, src.Remote(), src.ModTime(ctx), m, options...)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	mo, err := f.files.NewObject(ctx, src.Remote())
+	if err == fs.ErrorObjectNotFound {
+		mo = nil
+	} else if err != nil {
+		return nil, err
+	}
+	o, err := f.put(ctx, mo, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.files.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.files.Rmdir(ctx, dir)
+}
+
+// DirSetModTime sets the modification time of the directory dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	do := f.files.Features().DirSetModTime
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, modTime)
+}
+
+// DirMetadata returns the metadata of the directory dir
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	do := f.files.Features().DirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	return do(ctx, dir)
+}
+
+// DirSetMetadata sets the metadata of the directory dir
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	do := f.files.Features().DirSetMetadata
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	return do(ctx, dir, metadata)
+}
+
+// sameStore returns true if srcFs keeps its chunks in the same place
+// as f so manifests can be shared between them
+func (f *Fs) sameStore(srcFs *Fs) bool {
+	return operations.SameConfig(f.chunks, srcFs.chunks) && f.chunks.Root() == srcFs.chunks.Root()
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored in the object's manifest only so the chunks are
+// shared with the source.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameStore(srcObj.f) {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	m, err := srcObj.manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mo, err := f.files.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		mo = nil
+	} else if err != nil {
+		return nil, err
+	}
+	return f.putManifest(ctx, mo, remote, srcObj.ModTime(ctx), m)
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameStore(srcObj.f) {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	do := f.files.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	mo, err := do(ctx, srcObj.mo, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(mo, nil), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameStore(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	do := f.files.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.files, srcRemote, dstRemote)
+}
+
+// CleanUp deletes the chunks which no manifest refers to.
+//
+// Chunks stored in the last hour are kept as they may belong to a
+// file which is still being uploaded.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	allFiles, err := f.newWrapped(ctx, filesDir)
+	if err != nil && err != fs.ErrorIsFile {
+		return err
+	}
+	used := make(map[string]struct{})
+	err = walk.ListR(ctx, allFiles, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			mo, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			m, err := readManifest(ctx, mo)
+			if err != nil {
+				return err
+			}
+			for _, chunk := range m.Chunks {
+				used[chunk.Hash] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil && err != fs.ErrorDirNotFound {
+		return errors.Wrap(err, "failed to read manifests - not deleting any chunks")
+	}
+	f.knownMu.Lock()
+	f.known = make(map[string]struct{})
+	f.knownMu.Unlock()
+	toBeDeleted := make(fs.ObjectsChan, fs.GetConfig(ctx).Transfers)
+	var listErr error
+	go func() {
+		defer close(toBeDeleted)
+		listErr = walk.ListR(ctx, f.chunks, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			entries.ForObject(func(o fs.Object) {
+				if _, found := used[path.Base(o.Remote())]; found {
+					return
+				}
+				if time.Since(o.ModTime(ctx)) < cleanUpMinAge {
+					return
+				}
+				toBeDeleted <- o
+			})
+			return nil
+		})
+		if listErr == fs.ErrorDirNotFound {
+			listErr = nil
+		}
+	}()
+	err = operations.DeleteFiles(ctx, toBeDeleted)
+	if listErr != nil {
+		return errors.Wrap(listErr, "failed to list chunks")
+	}
+	return err
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.files
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Object describes a file stored as chunks
+type Object struct {
+	f  *Fs
+	mo fs.Object // the manifest
+	mu sync.Mutex
+	m  *manifest // the contents of the manifest, nil if not read yet
+}
+
+// readManifest reads and decodes the manifest mo
+func readManifest(ctx context.Context, mo fs.Object) (m *manifest, err error) {
+	if mo.Size() > maxManifestSize {
+		return nil, errors.Errorf("%q is too big to be a manifest", mo.Remote())
+	}
+	in, err := mo.Open(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open manifest")
+	}
+	defer fs.CheckClose(in, &err)
+	data, err := ioutil.ReadAll(io.LimitReader(in, maxManifestSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+	m = new(manifest)
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%q isn't a manifest", mo.Remote())
+	}
+	if m.Version != manifestVersion {
+		return nil, errors.Errorf("%q has unknown manifest version %d", mo.Remote(), m.Version)
+	}
+	return m, nil
+}
+
+// manifest returns the manifest for the object, reading it if needed
+func (o *Object) manifest(ctx context.Context) (*manifest, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.m == nil {
+		m, err := readManifest(ctx, o.mo)
+		if err != nil {
+			return nil, err
+		}
+		o.m = m
+	}
+	return o.m, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Remote()
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.mo.Remote()
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.mo.ModTime(ctx)
+}
+
+// Size returns the size of the file
+//
+// This reads the manifest the first time it is called.
+func (o *Object) Size() int64 {
+	m, err := o.manifest(context.Background())
+	if err != nil {
+		fs.Errorf(o, "Failed to read size: %v", err)
+		return -1
+	}
+	return m.Size
+}
+
+// Hash returns the MD5 of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 {
+		return "", hash.ErrUnsupported
+	}
+	m, err := o.manifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	return m.MD5, nil
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	return o.mo.SetModTime(ctx, t)
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	m, err := o.manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(m.Size)
+		}
+	}
+	if limit < 0 || offset+limit > m.Size {
+		limit = m.Size - offset
+	}
+	// Skip the chunks before offset
+	chunks := m.Chunks
+	for len(chunks) > 0 && offset >= chunks[0].Size {
+		offset -= chunks[0].Size
+		chunks = chunks[1:]
+	}
+	return &reader{
+		ctx:    ctx,
+		f:      o.f,
+		chunks: chunks,
+		offset: offset,
+		limit:  limit,
+	}, nil
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.f.put(ctx, o.mo, in, src, options...)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.m = newO.m
+	o.mu.Unlock()
+	return nil
+}
+
+// Remove an object
+//
+// Only the manifest is removed - use CleanUp to remove the chunks
+// which are no longer used.
+func (o *Object) Remove(ctx context.Context) error {
+	return o.mo.Remove(ctx)
+}
+
+// reader reads the chunks of a file in turn
+type reader struct {
+	ctx    context.Context
+	f      *Fs
+	chunks []chunkRef    // chunks still to read
+	offset int64         // offset into the first chunk
+	limit  int64         // bytes still to read
+	in     io.ReadCloser // current chunk, nil if none open
+}
+
+// open the next chunk
+func (r *reader) open() error {
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	o, err := r.f.chunks.NewObject(r.ctx, chunkRemote(chunk.Hash))
+	if err != nil {
+		return errors.Wrapf(err, "failed to find chunk %s", chunk.Hash)
+	}
+	var options []fs.OpenOption
+	if r.offset > 0 {
+		options = append(options, &fs.SeekOption{Offset: r.offset})
+		r.offset = 0
+	}
+	r.in, err = o.Open(r.ctx, options...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open chunk %s", chunk.Hash)
+	}
+	return nil
+}
+
+// Read bytes from the chunks
+func (r *reader) Read(p []byte) (n int, err error) {
+	for {
+		if r.limit <= 0 {
+			return 0, io.EOF
+		}
+		if r.in == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			err = r.open()
+			if err != nil {
+				return 0, err
+			}
+		}
+		if int64(len(p)) > r.limit {
+			p = p[:r.limit]
+		}
+		n, err = r.in.Read(p)
+		r.limit -= int64(n)
+		if err == io.EOF {
+			err = r.in.Close()
+			r.in = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close the chunk being read
+func (r *reader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs             = (*Fs)(nil)
+	_ fs.Copier         = (*Fs)(nil)
+	_ fs.Mover          = (*Fs)(nil)
+	_ fs.DirMover       = (*Fs)(nil)
+	_ fs.DirSetModTimer = (*Fs)(nil)
+	_ fs.DirMetadataer  = (*Fs)(nil)
+	_ fs.PutStreamer    = (*Fs)(nil)
+	_ fs.CleanUpper     = (*Fs)(nil)
+	_ fs.ListRer        = (*Fs)(nil)
+	_ fs.UnWrapper      = (*Fs)(nil)
+	_ fs.Wrapper        = (*Fs)(nil)
+	_ fs.Object         = (*Object)(nil)
+)
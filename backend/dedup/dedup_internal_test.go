@@ -0,0 +1,128 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// split returns the hashes of the chunks data is split into
+func split(t *testing.T, data []byte, avg int) (chunks []string) {
+	s := newSplitter(bytes.NewReader(data), avg)
+	total := 0
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.True(t, len(chunk) <= 4*avg)
+		total += len(chunk)
+		chunks = append(chunks, string(chunk))
+	}
+	assert.Equal(t, len(data), total)
+	return chunks
+}
+
+func TestSplitter(t *testing.T) {
+	data := make([]byte, 256*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+	chunks := split(t, data, 4096)
+	assert.True(t, len(chunks) > 16, len(chunks))
+
+	// Inserting data at the start only changes the first chunk
+	shifted := split(t, append([]byte("inserted"), data...), 4096)
+	shared := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		shared[chunk] = true
+	}
+	notShared := 0
+	for _, chunk := range shifted {
+		if !shared[chunk] {
+			notShared++
+		}
+	}
+	assert.True(t, notShared <= 2, notShared)
+
+	assert.Nil(t, split(t, nil, 4096))
+}
+
+func TestDedupAndCleanUp(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-dedup-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	fi, err := NewFs(ctx, "TestDedup", "", configmap.Simple{"remote": dir, "chunk_size": "1k"})
+	require.NoError(t, err)
+	f := fi.(*Fs)
+
+	countChunks := func() (n int) {
+		err := filepath.Walk(filepath.Join(dir, chunksDir), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				n++
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return n
+	}
+	put := func(remote string, data []byte) fs.Object {
+		src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, nil)
+		o, err := f.Put(ctx, bytes.NewReader(data), src)
+		require.NoError(t, err)
+		return o
+	}
+
+	data := make([]byte, 64*1024)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+	o1 := put("file1", data)
+	chunks := countChunks()
+	assert.True(t, chunks > 4)
+
+	// The same data again stores no new chunks
+	o2 := put("dir/file2", data)
+	assert.Equal(t, chunks, countChunks())
+
+	// Reads back with ranges across the chunks
+	in, err := o2.Open(ctx, &fs.RangeOption{Start: 1000, End: 40000})
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, data[1000:40001], got)
+
+	// Chunks still in use or too new aren't cleaned up
+	age := func() {
+		old := time.Now().Add(-2 * cleanUpMinAge)
+		err := filepath.Walk(filepath.Join(dir, chunksDir), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				err = os.Chtimes(path, old, old)
+			}
+			return err
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, o1.Remove(ctx))
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks, countChunks())
+	require.NoError(t, o2.Remove(ctx))
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks, countChunks())
+	age()
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, 0, countChunks())
+}
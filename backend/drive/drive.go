@@ -2796,6 +2796,128 @@ func (f *Fs) changeNotifyRunner(ctx context.Context, notifyFunc func(string, fs.
 	}
 }
 
+// ChangeToken returns a token for the current state of the remote
+// which can be passed to Changes to find what has changed since.
+func (f *Fs) ChangeToken(ctx context.Context) (string, error) {
+	return f.changeNotifyStartPageToken()
+}
+
+// Changes returns the paths which have changed since token was read
+// with ChangeToken and a new token for the current state.
+//
+// Files moved out of a directory are only reported at their new
+// location, and Google docs are reported as their directory as their
+// names depend on the export formats.
+func (f *Fs) Changes(ctx context.Context, token string) (paths []string, newToken string, err error) {
+	rootID, err := f.dirCache.RootID(ctx, false)
+	if err != nil {
+		return nil, "", err
+	}
+	pageToken := token
+	for {
+		var changeList *drive.ChangeList
+		err = f.pacer.Call(func() (bool, error) {
+			changesCall := f.svc.Changes.List(pageToken).
+				Fields("nextPageToken,newStartPageToken,changes(fileId,removed,file(name,parents,mimeType))")
+			if f.opt.ListChunk > 0 {
+				changesCall.PageSize(f.opt.ListChunk)
+			}
+			changesCall.SupportsAllDrives(true)
+			changesCall.IncludeItemsFromAllDrives(true)
+			if f.isTeamDrive {
+				changesCall.DriveId(f.opt.TeamDriveID)
+			}
+			// If using appDataFolder then need to add Spaces
+			if f.rootFolderID == "appDataFolder" {
+				changesCall.Spaces("appDataFolder")
+			}
+			changeList, err = changesCall.Context(ctx).Do()
+			return f.shouldRetry(err)
+		})
+		if err != nil {
+			if gerr, ok := errors.Cause(err).(*googleapi.Error); ok && (gerr.Code == 400 || gerr.Code == 404) {
+				fs.Debugf(f, "Change token rejected: %v", err)
+				return nil, "", fs.ErrorChangesUnavailable
+			}
+			return nil, "", err
+		}
+		for _, change := range changeList.Changes {
+			// the previous path if we have seen it
+			if oldPath, ok := f.dirCache.GetInv(change.FileId); ok {
+				paths = append(paths, oldPath)
+			}
+			if change.File == nil {
+				if change.Removed {
+					// Deleted forever, or no longer shared, and
+					// there is no way of finding where it was
+					return nil, "", fs.ErrorChangesUnavailable
+				}
+				continue
+			}
+			name := f.opt.Enc.ToStandardName(change.File.Name)
+			isDoc := change.File.MimeType != driveFolderType && isInternalMimeType(change.File.MimeType)
+			for _, parent := range change.File.Parents {
+				parentPath, ok, err := f.changedDirPath(ctx, rootID, parent)
+				if err != nil {
+					return nil, "", err
+				}
+				if !ok {
+					continue
+				}
+				if isDoc {
+					paths = append(paths, parentPath)
+				} else {
+					paths = append(paths, path.Join(parentPath, name))
+				}
+			}
+		}
+		switch {
+		case changeList.NewStartPageToken != "":
+			return paths, changeList.NewStartPageToken, nil
+		case changeList.NextPageToken != "":
+			pageToken = changeList.NextPageToken
+		default:
+			return nil, "", errors.New("no token returned from the changes list")
+		}
+	}
+}
+
+// changedDirPath returns the path relative to the root of the
+// directory with ID dirID or false if it isn't inside the root.
+func (f *Fs) changedDirPath(ctx context.Context, rootID, dirID string) (dirPath string, ok bool, err error) {
+	var leaves []string
+	ID := dirID
+	for {
+		if ID == rootID {
+			dirPath = ""
+			break
+		}
+		if parentPath, found := f.dirCache.GetInv(ID); found {
+			dirPath = parentPath
+			break
+		}
+		info, err := f.getFile(ID, "name,parents")
+		if err != nil {
+			if gerr, ok := errors.Cause(err).(*googleapi.Error); ok && gerr.Code == 404 {
+				return "", false, nil
+			}
+			return "", false, errors.Wrap(err, "failed to find changed directory")
+		}
+		if len(info.Parents) == 0 {
+			return "", false, nil
+		}
+		leaves = append(leaves, f.opt.Enc.ToStandardName(info.Name))
+		ID = info.Parents[0]
+	}
+	for i := len(leaves) - 1; i >= 0; i-- {
+		dirPath = path.Join(dirPath, leaves[i])
+	}
+	if dirID != rootID {
+		f.dirCache.Put(dirPath, dirID)
+	}
+	return dirPath, true, nil
+}
+
 // DirCacheFlush resets the directory cache - used in testing as an
 // optional interface
 func (f *Fs) DirCacheFlush() {
@@ -3401,6 +3523,66 @@ func (o *baseObject) SetModTime(ctx context.Context, modTime time.Time) error {
 	return nil
 }
 
+// Metadata returns the metadata of the object
+//
+// The properties of the file are returned as extended attributes, so
+// the property "colour" is returned as "user.colour".
+func (o *baseObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	var info *drive.File
+	err := o.fs.pacer.Call(func() (bool, error) {
+		var err error
+		info, err = o.fs.svc.Files.Get(actualID(o.id)).
+			Fields("modifiedTime,properties").
+			SupportsAllDrives(true).
+			Do()
+		return o.fs.shouldRetry(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	metadata := fs.Metadata{
+		"mtime": info.ModifiedTime,
+	}
+	for k, v := range info.Properties {
+		metadata[fs.XattrPrefix+k] = v
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the properties of the object from the extended
+// attributes in metadata
+func (o *baseObject) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	current, err := o.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	xattrs := metadata.Xattrs()
+	updateInfo := &drive.File{
+		Properties: map[string]string{},
+	}
+	for k, v := range xattrs {
+		if current[k] != v {
+			updateInfo.Properties[strings.TrimPrefix(k, fs.XattrPrefix)] = v
+		}
+	}
+	for k := range current.Xattrs() {
+		if _, found := xattrs[k]; !found {
+			// properties are removed by setting them to null
+			updateInfo.NullFields = append(updateInfo.NullFields, "Properties."+strings.TrimPrefix(k, fs.XattrPrefix))
+		}
+	}
+	if len(updateInfo.Properties) == 0 && len(updateInfo.NullFields) == 0 {
+		return nil
+	}
+	return o.fs.pacer.Call(func() (bool, error) {
+		_, err := o.fs.svc.Files.Update(actualID(o.id), updateInfo).
+			Fields("id").
+			SupportsAllDrives(true).
+			Do()
+		return o.fs.shouldRetry(err)
+	})
+}
+
 // Storable returns a boolean as to whether this object is storable
 func (o *baseObject) Storable() bool {
 	return true
@@ -3776,6 +3958,7 @@ var (
 	_ fs.Commander       = (*Fs)(nil)
 	_ fs.DirCacheFlusher = (*Fs)(nil)
 	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.ChangeLister    = (*Fs)(nil)
 	_ fs.PutUncheckeder  = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
@@ -3784,10 +3967,16 @@ var (
 	_ fs.Object          = (*Object)(nil)
 	_ fs.MimeTyper       = (*Object)(nil)
 	_ fs.IDer            = (*Object)(nil)
+	_ fs.Metadataer      = (*Object)(nil)
+	_ fs.SetMetadataer   = (*Object)(nil)
 	_ fs.Object          = (*documentObject)(nil)
 	_ fs.MimeTyper       = (*documentObject)(nil)
 	_ fs.IDer            = (*documentObject)(nil)
+	_ fs.Metadataer      = (*documentObject)(nil)
+	_ fs.SetMetadataer   = (*documentObject)(nil)
 	_ fs.Object          = (*linkObject)(nil)
 	_ fs.MimeTyper       = (*linkObject)(nil)
 	_ fs.IDer            = (*linkObject)(nil)
+	_ fs.Metadataer      = (*linkObject)(nil)
+	_ fs.SetMetadataer   = (*linkObject)(nil)
 )
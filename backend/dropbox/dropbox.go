@@ -1129,6 +1129,80 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 	return nil
 }
 
+// ChangeToken returns a token for the current state of the remote
+// which can be passed to Changes to find what has changed since.
+func (f *Fs) ChangeToken(ctx context.Context) (string, error) {
+	if f.opt.SharedFiles || f.opt.SharedFolders {
+		return "", errNotSupportedInSharedMode
+	}
+	arg := files.ListFolderArg{
+		Path:      f.opt.Enc.FromStandardPath(f.slashRoot),
+		Recursive: true,
+	}
+	if f.slashRoot == "/" {
+		arg.Path = "" // Specify root folder as empty string
+	}
+	var res *files.ListFolderGetLatestCursorResult
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		res, err = f.srv.ListFolderGetLatestCursor(&arg)
+		return shouldRetry(err)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read cursor")
+	}
+	return res.Cursor, nil
+}
+
+// Changes returns the paths which have changed since token was read
+// with ChangeToken and a new token for the current state.
+func (f *Fs) Changes(ctx context.Context, token string) (paths []string, newToken string, err error) {
+	if f.opt.SharedFiles || f.opt.SharedFolders {
+		return nil, "", fs.ErrorChangesUnavailable
+	}
+	var res *files.ListFolderResult
+	cursor := token
+	for {
+		arg := files.ListFolderContinueArg{
+			Cursor: cursor,
+		}
+		err = f.pacer.Call(func() (bool, error) {
+			res, err = f.srv.ListFolderContinue(&arg)
+			return shouldRetry(err)
+		})
+		if err != nil {
+			if e, ok := err.(files.ListFolderContinueAPIError); ok && e.EndpointError != nil && e.EndpointError.Tag == files.ListFolderContinueErrorReset {
+				return nil, "", fs.ErrorChangesUnavailable
+			}
+			return nil, "", errors.Wrap(err, "list continue")
+		}
+		for _, entry := range res.Entries {
+			var metadata *files.Metadata
+			switch info := entry.(type) {
+			case *files.FolderMetadata:
+				metadata = &info.Metadata
+			case *files.FileMetadata:
+				metadata = &info.Metadata
+			case *files.DeletedMetadata:
+				metadata = &info.Metadata
+			default:
+				fs.Errorf(f, "Unknown type %T", entry)
+				continue
+			}
+			entryPath := metadata.PathDisplay
+			if !strings.HasPrefix(strings.ToLower(entryPath), strings.ToLower(f.slashRootSlash)) {
+				continue
+			}
+			paths = append(paths, f.opt.Enc.ToStandardPath(entryPath[len(f.slashRootSlash):]))
+		}
+		cursor = res.Cursor
+		if !res.HasMore {
+			break
+		}
+	}
+	return paths, cursor, nil
+}
+
 // About gets quota information
 func (f *Fs) About(ctx context.Context) (usage *fs.Usage, err error) {
 	var q *users.SpaceUsage
@@ -1515,6 +1589,7 @@ var (
 	_ fs.PublicLinker = (*Fs)(nil)
 	_ fs.DirMover     = (*Fs)(nil)
 	_ fs.Abouter      = (*Fs)(nil)
+	_ fs.ChangeLister = (*Fs)(nil)
 	_ fs.Object       = (*Object)(nil)
 	_ fs.IDer         = (*Object)(nil)
 )
@@ -0,0 +1,238 @@
+package erasure
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// decoder reads a file from its shards
+//
+// It reads the data shards, and if any of those can't be read or are
+// corrupt it opens parity shards and reconstructs the data from them.
+type decoder struct {
+	ctx     context.Context
+	o       *Object
+	c       *coder
+	stripe  int64           // next stripe to read
+	stripes int64           // number of stripes in the file
+	readers []io.ReadCloser // open shards
+	failed  []bool          // shards which can't be read
+	read    []bool          // shards whose piece of the stripe has been read
+	headers []*header       // headers read if opened at the start
+	id      uint64          // id of the shards if known
+	idSet   bool
+	out     []byte // data of the stripe not yet returned
+	outBuf  []byte
+	err     error
+}
+
+// newDecoder opens the data shards of o to read it from stripe
+func newDecoder(ctx context.Context, o *Object, stripe int64) (*decoder, error) {
+	l := o.fs.layout
+	c, err := newCoder(l)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{
+		ctx:     ctx,
+		o:       o,
+		c:       c,
+		stripe:  stripe,
+		stripes: l.stripes(o.size),
+		readers: make([]io.ReadCloser, l.shards()),
+		failed:  make([]bool, l.shards()),
+		read:    make([]bool, l.shards()),
+		headers: make([]*header, l.shards()),
+	}
+	if d.stripe >= d.stripes {
+		return d, nil
+	}
+	d.outBuf = make([]byte, 0, l.stripeSize())
+	for i, shard := range o.shards {
+		if shard == nil {
+			fs.Logf(o, "Shard %d is missing", i)
+			d.failed[i] = true
+		}
+	}
+	for i := 0; i < l.data; i++ {
+		if !d.failed[i] {
+			d.open(i)
+		}
+	}
+	if d.stripe == 0 {
+		// Use the id most of the data shards have
+		if h := consensus(d.headers); h != nil {
+			d.id, d.idSet = h.id, true
+		}
+		for i, h := range d.headers {
+			if h != nil && h.id != d.id {
+				d.fail(i, errors.New("shard is from a different upload"))
+			}
+		}
+	}
+	return d, nil
+}
+
+// open opens shard i at the current stripe returning false if it failed
+func (d *decoder) open(i int) bool {
+	l := d.o.fs.layout
+	var options []fs.OpenOption
+	if d.stripe > 0 {
+		options = append(options, &fs.SeekOption{Offset: l.offset(d.stripe)})
+	}
+	in, err := d.o.shards[i].Open(d.ctx, options...)
+	if err != nil {
+		d.fail(i, err)
+		return false
+	}
+	d.readers[i] = in
+	if d.stripe == 0 {
+		h, err := readHeader(in)
+		if err == nil {
+			err = l.check(h, i)
+		}
+		if err == nil && h.size != d.o.size {
+			err = errors.Errorf("shard is for a file of size %d not %d", h.size, d.o.size)
+		}
+		if err == nil && d.idSet && h.id != d.id {
+			err = errors.New("shard is from a different upload")
+		}
+		if err != nil {
+			d.fail(i, err)
+			return false
+		}
+		d.headers[i] = h
+	}
+	return true
+}
+
+// openNext opens the next shard which hasn't been tried returning
+// false if there are none left
+func (d *decoder) openNext() bool {
+	for i, in := range d.readers {
+		if in == nil && !d.failed[i] {
+			if d.open(i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fail marks shard i as failed closing it if it is open
+func (d *decoder) fail(i int, err error) {
+	fs.Errorf(d.o, "Shard %d on %v failed - using parity: %v", i, d.o.fs.upstreams[i], err)
+	d.failed[i] = true
+	if d.readers[i] != nil {
+		_ = d.readers[i].Close()
+		d.readers[i] = nil
+	}
+}
+
+// readStripe reads the next stripe into d.out
+func (d *decoder) readStripe() error {
+	l := d.o.fs.layout
+	c := d.c
+	piece := l.pieceLengths(d.o.size, d.stripe, c.lengths)
+	for i := range d.read {
+		d.read[i] = false
+	}
+	for {
+		good := 0
+		for i, in := range d.readers {
+			if in == nil {
+				continue
+			}
+			if !d.read[i] {
+				err := readPiece(in, c.bufs[i], c.lengths[i])
+				if err != nil {
+					d.fail(i, err)
+					continue
+				}
+				d.read[i] = true
+			}
+			good++
+		}
+		if good >= l.data {
+			break
+		}
+		if !d.openNext() {
+			return errors.Errorf("can't read %q: only %d of the %d shards needed are readable", d.o.remote, good, l.data)
+		}
+	}
+	missing := false
+	for i := range c.shards {
+		if d.read[i] {
+			c.pad(i, piece)
+		} else {
+			c.shards[i] = c.bufs[i][:0]
+			missing = missing || i < l.data
+		}
+	}
+	if missing {
+		err := c.rs.ReconstructData(c.shards)
+		if err != nil {
+			return errors.Wrapf(err, "failed to reconstruct %q", d.o.remote)
+		}
+	}
+	d.out = d.outBuf[:0]
+	for i := 0; i < l.data; i++ {
+		d.out = append(d.out, c.shards[i][:c.lengths[i]]...)
+	}
+	d.stripe++
+	return nil
+}
+
+// skip discards n bytes from the start of the current stripe
+func (d *decoder) skip(n int64) error {
+	if n == 0 || d.stripe >= d.stripes {
+		return nil
+	}
+	err := d.readStripe()
+	if err != nil {
+		return err
+	}
+	if n > int64(len(d.out)) {
+		n = int64(len(d.out))
+	}
+	d.out = d.out[n:]
+	return nil
+}
+
+// Read reads the file
+func (d *decoder) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	for len(d.out) == 0 {
+		if d.stripe >= d.stripes {
+			d.err = io.EOF
+			return 0, d.err
+		}
+		err = d.readStripe()
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+	}
+	n = copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Close closes the shards
+func (d *decoder) Close() (err error) {
+	for i, in := range d.readers {
+		if in != nil {
+			closeErr := in.Close()
+			if err == nil {
+				err = closeErr
+			}
+			d.readers[i] = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,808 @@
+// Package erasure provides an overlay backend which spreads files
+// over several upstreams with Reed-Solomon parity
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "erasure",
+		Description: "Spread files over several remotes with parity to survive the loss of some",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Each file is split into a shard on each upstream, so the order of the
+upstreams mustn't be changed once files have been written.
+Can be 'remotea:dir remoteb:dir remotec:dir', '"remotea:space dir" remoteb:', etc.`,
+			Required: true,
+		}, {
+			Name: "parity_shards",
+			Help: `Number of upstreams holding parity.
+
+Files can be read as long as no more than this many of the upstreams
+are lost or corrupt. The rest of the upstreams hold the data, so the
+space used is the size of the files times the number of upstreams
+divided by the number of those holding data.`,
+			Default: 1,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams    fs.SpaceSepList `config:"upstreams"`
+	ParityShards int             `config:"parity_shards"`
+}
+
+// Fs represents files spread over upstreams with parity
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // parsed options
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // upstream i holds shard i
+	layout    layout       // how files are split into shards
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Upstreams) < 2 {
+		return nil, errors.New("erasure needs at least 2 upstreams - check the value of the upstreams setting")
+	}
+	if len(opt.Upstreams) > maxShards {
+		return nil, errors.Errorf("erasure can't have more than %d upstreams - check the value of the upstreams setting", maxShards)
+	}
+	if opt.ParityShards < 1 || opt.ParityShards >= len(opt.Upstreams) {
+		return nil, errors.Errorf("parity_shards must be at least 1 and less than the %d upstreams", len(opt.Upstreams))
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point erasure remote at itself - check the value of the upstreams setting")
+		}
+	}
+	f := &Fs{
+		name: name,
+		root: root,
+		opt:  *opt,
+		layout: layout{
+			data:      len(opt.Upstreams) - opt.ParityShards,
+			parity:    opt.ParityShards,
+			blockSize: defaultBlockSize,
+		},
+	}
+	isFile, err := f.makeUpstreams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		// Point the upstreams at the parent of the file
+		f.root = path.Dir(root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+		_, err = f.makeUpstreams(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+	}).Fill(ctx, f)
+	for _, u := range f.upstreams {
+		f.features = f.features.Mask(ctx, u)
+	}
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// makeUpstreams makes the upstreams at f.root returning whether the
+// root is a file on any of them
+func (f *Fs) makeUpstreams(ctx context.Context) (isFile bool, err error) {
+	f.upstreams = make([]fs.Fs, len(f.opt.Upstreams))
+	errs := make([]error, len(f.opt.Upstreams))
+	multithread(len(f.opt.Upstreams), func(i int) {
+		u := f.opt.Upstreams[i]
+		upstreamName, upstreamPath, err := fspath.Parse(u)
+		if err != nil {
+			errs[i] = errors.Wrapf(err, "failed to parse upstream %q", u)
+			return
+		}
+		if upstreamName != "" {
+			upstreamName += ":"
+		}
+		f.upstreams[i], errs[i] = cache.Get(ctx, upstreamName+fspath.JoinRootPath(upstreamPath, f.root))
+	})
+	for i, err := range errs {
+		if err == fs.ErrorIsFile {
+			isFile = true
+		} else if err != nil {
+			return false, errors.Wrapf(err, "failed to make upstream %q", f.opt.Upstreams[i])
+		}
+	}
+	return isFile, nil
+}
+
+// multithread calls fn for 0..num-1 in parallel and waits for them
+func multithread(num int, fn func(int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("erasure root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision of the ModTimes in this Fs which is the coarsest of the
+// upstreams
+func (f *Fs) Precision() time.Duration {
+	var precision time.Duration
+	for _, u := range f.upstreams {
+		p := u.Precision()
+		if p == fs.ModTimeNotSupported {
+			return p
+		}
+		if p > precision {
+			precision = p
+		}
+	}
+	return precision
+}
+
+// Hashes returns the supported hash sets.
+//
+// The shards have hashes but the files don't.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// Upstreams which can't be listed are skipped as long as no more
+// than parity_shards of them fail.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	lists := make([]fs.DirEntries, len(f.upstreams))
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		lists[i], errs[i] = f.upstreams[i].List(ctx, dir)
+	})
+	notFound, failed := 0, 0
+	for i, err := range errs {
+		if err == fs.ErrorDirNotFound {
+			notFound++
+		} else if err != nil {
+			failed++
+			fs.Errorf(f.upstreams[i], "Failed to list %q: %v", dir, err)
+			if failed > f.layout.parity {
+				return nil, errors.Wrapf(err, "failed to list %q on upstream %d", dir, i)
+			}
+		}
+	}
+	if notFound+failed == len(f.upstreams) {
+		return nil, fs.ErrorDirNotFound
+	}
+	dirs := map[string]bool{}
+	objects := map[string]*Object{}
+	for i, list := range lists {
+		for _, entry := range list {
+			remote := entry.Remote()
+			switch x := entry.(type) {
+			case fs.Object:
+				o := objects[remote]
+				if o == nil {
+					o = f.newObject(remote)
+					objects[remote] = o
+				}
+				o.shards[i] = x
+			case fs.Directory:
+				if !dirs[remote] {
+					dirs[remote] = true
+					entries = append(entries, x)
+				}
+			}
+		}
+	}
+	for remote, o := range objects {
+		if dirs[remote] {
+			fs.Errorf(o, "Ignoring shards of file which is a directory on another upstream")
+			continue
+		}
+		err = o.findSize(ctx)
+		if err != nil {
+			fs.Errorf(o, "Ignoring file: %v", err)
+			continue
+		}
+		entries = append(entries, o)
+	}
+	sort.Sort(entries)
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := f.newObject(remote)
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		o.shards[i], errs[i] = f.upstreams[i].NewObject(ctx, remote)
+	})
+	found, failed := 0, 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			found++
+		case err == fs.ErrorObjectNotFound:
+		case errors.Cause(err) == fs.ErrorNotAFile:
+			return nil, err
+		default:
+			failed++
+			fs.Errorf(f.upstreams[i], "Failed to find %q: %v", remote, err)
+			if failed > f.layout.parity {
+				return nil, errors.Wrapf(err, "failed to find %q on upstream %d", remote, i)
+			}
+		}
+	}
+	if found == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	err := o.findSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newObject makes an Object at remote with no shards
+func (f *Fs) newObject(remote string) *Object {
+	return &Object{
+		fs:     f,
+		remote: remote,
+		size:   -1,
+		shards: make([]fs.Object, len(f.upstreams)),
+	}
+}
+
+// upload writes the shards of the file at remote to the upstreams
+//
+// fn is called to write the shards to out, which has a writer for
+// each shard where upload[i] is set. Shard i replaces old[i] if that
+// is set.
+//
+// If the upload fails then the shards which were created are removed.
+func (f *Fs) upload(ctx context.Context, remote string, modTime time.Time, size int64, upload []bool, old []fs.Object, fn func(out []io.Writer) error, options ...fs.OpenOption) (shards []fs.Object, err error) {
+	n := len(f.upstreams)
+	shards = make([]fs.Object, n)
+	out := make([]io.Writer, n)
+	pipes := make([]*io.PipeWriter, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range f.upstreams {
+		if !upload[i] {
+			continue
+		}
+		pr, pw := io.Pipe()
+		out[i], pipes[i] = pw, pw
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info := object.NewStaticObjectInfo(remote, modTime, f.layout.shardSize(size, i), true, nil, f)
+			var err error
+			if old[i] != nil {
+				err = old[i].Update(ctx, pr, info, options...)
+				shards[i] = old[i]
+			} else {
+				shards[i], err = f.upstreams[i].Put(ctx, pr, info, options...)
+			}
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "failed to upload shard %d to %v", i, f.upstreams[i])
+			}
+			// Stop the writer if the upload didn't read everything
+			_ = pr.CloseWithError(err)
+		}(i)
+	}
+	err = fn(out)
+	for _, pw := range pipes {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	wg.Wait()
+	if err == nil || err == io.ErrClosedPipe {
+		// Report why the upload stopped reading
+		for _, uploadErr := range errs {
+			if uploadErr != nil {
+				err = uploadErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		for i, shard := range shards {
+			if shard != nil && old[i] == nil {
+				removeErr := shard.Remove(ctx)
+				if removeErr != nil {
+					fs.Errorf(shard, "Failed to remove shard after failed upload: %v", removeErr)
+				}
+			}
+		}
+		return nil, err
+	}
+	return shards, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// The size must be known as it is written in each shard.
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	err := o.Update(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Mkdir makes the directory (container, bucket) on all the upstreams
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		errs[i] = f.upstreams[i].Mkdir(ctx, dir)
+	})
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "failed to make directory on upstream %d", i)
+		}
+	}
+	return nil
+}
+
+// Rmdir removes the directory (container, bucket) from all the
+// upstreams
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		errs[i] = f.upstreams[i].Rmdir(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		// Not all upstreams say when the directory isn't there
+		if err != fs.ErrorDirNotFound {
+			_, listErr := f.upstreams[i].List(ctx, dir)
+			if listErr != fs.ErrorDirNotFound {
+				return errors.Wrapf(err, "failed to remove directory on upstream %d", i)
+			}
+		}
+		notFound++
+	}
+	if notFound == len(f.upstreams) {
+		return fs.ErrorDirNotFound
+	}
+	return nil
+}
+
+// sameUpstreams returns whether src has the same upstreams as f so
+// shards can be moved between them
+func (f *Fs) sameUpstreams(src *Fs) bool {
+	if len(src.upstreams) != len(f.upstreams) || src.layout != f.layout {
+		return false
+	}
+	for i, u := range f.upstreams {
+		if !operations.SameConfig(u, src.upstreams[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameUpstreams(srcObj.fs) {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	o := f.newObject(remote)
+	o.size = srcObj.size
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		shard := srcObj.shards[i]
+		if shard == nil {
+			return
+		}
+		o.shards[i], errs[i] = f.upstreams[i].Features().Move(ctx, shard, remote)
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to move shard %d", i)
+		}
+	}
+	return o, nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameUpstreams(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	// Check the destination isn't on any upstream before moving any
+	_, err := f.List(ctx, dstRemote)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if err != fs.ErrorDirNotFound {
+		return err
+	}
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		errs[i] = f.upstreams[i].Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	notFound := 0
+	for i, err := range errs {
+		switch errors.Cause(err) {
+		case nil:
+		case fs.ErrorDirNotFound:
+			notFound++
+		case fs.ErrorDirExists:
+			return fs.ErrorDirExists
+		default:
+			return errors.Wrapf(err, "failed to move directory on upstream %d", i)
+		}
+	}
+	if notFound == len(f.upstreams) {
+		return fs.ErrorDirNotFound
+	}
+	return nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "repair",
+	Short: "Check the shards of files and rebuild any which are lost or corrupt",
+	Long: `This reads every shard of the files given, or of all the files if
+none are given, and rebuilds the shards which are missing or corrupt
+from the others, as long as no more than parity_shards of the shards of
+a file are bad.
+
+Usage Example:
+
+    rclone backend repair erasure: [path/to/file_or_dir...]
+    rclone rc backend/command command=repair fs=erasure: [path/to/file_or_dir...]
+
+Use this after replacing a lost upstream with an empty one. It returns
+the number of files checked and the files which were repaired or
+couldn't be, eg
+
+    {
+        "checked": 3,
+        "repaired": ["path/to/file1"],
+        "unrepairable": []
+    }
+
+Use --dry-run to see what would be repaired.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "repair":
+		return f.repairPaths(ctx, arg)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// Object is a file made from shards on the upstreams
+type Object struct {
+	fs     *Fs
+	remote string
+	size   int64
+	shards []fs.Object // shard i from upstream i, nil if missing
+}
+
+// findSize works out the size of the file from its shards
+//
+// If shards are missing or damaged it reads it from their headers.
+func (o *Object) findSize(ctx context.Context) error {
+	sizes := make([]int64, len(o.shards))
+	for i, shard := range o.shards {
+		sizes[i] = -1
+		if shard != nil {
+			sizes[i] = shard.Size()
+		}
+	}
+	var ok bool
+	o.size, ok = o.fs.layout.sizeFromShards(sizes)
+	if ok {
+		return nil
+	}
+	fs.Debugf(o, "Shards missing or wrong size - reading size from headers")
+	h := consensus(o.readHeaders(ctx))
+	if h == nil {
+		return errors.New("no readable shards")
+	}
+	o.size = h.size
+	return nil
+}
+
+// readHeaders reads the headers of the shards returning nil for
+// shards which are missing or whose header is bad
+func (o *Object) readHeaders(ctx context.Context) []*header {
+	headers := make([]*header, len(o.shards))
+	multithread(len(o.shards), func(i int) {
+		shard := o.shards[i]
+		if shard == nil {
+			return
+		}
+		in, err := shard.Open(ctx, &fs.RangeOption{Start: 0, End: headerSize - 1})
+		if err != nil {
+			fs.Debugf(shard, "Failed to open shard: %v", err)
+			return
+		}
+		h, err := readHeader(in)
+		_ = in.Close()
+		if err == nil {
+			err = o.fs.layout.check(h, i)
+		}
+		if err != nil {
+			fs.Debugf(shard, "Bad shard: %v", err)
+			return
+		}
+		headers[i] = h
+	})
+	return headers
+}
+
+// firstShard returns the first shard which is present
+func (o *Object) firstShard() fs.Object {
+	for _, shard := range o.shards {
+		if shard != nil {
+			return shard
+		}
+	}
+	return nil
+}
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the hash of the file - not supported
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	shard := o.firstShard()
+	if shard == nil {
+		return time.Now()
+	}
+	return shard.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of all the shards
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	errs := make([]error, len(o.shards))
+	multithread(len(o.shards), func(i int) {
+		if o.shards[i] != nil {
+			errs[i] = o.shards[i].SetModTime(ctx, modTime)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Storable returns whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open the file for read, reconstructing it from the parity if any
+// of the data shards are missing or corrupt.  Call Close() on the
+// returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	stripeSize := o.fs.layout.stripeSize()
+	d, err := newDecoder(ctx, o, offset/stripeSize)
+	if err != nil {
+		return nil, err
+	}
+	err = d.skip(offset % stripeSize)
+	if err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	if limit >= 0 {
+		return readers.NewLimitedReadCloser(d, limit), nil
+	}
+	return d, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// The size must be known as it is written in each shard.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	f := o.fs
+	size := src.Size()
+	if size < 0 {
+		return errors.New("erasure can't upload files of unknown size")
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	c, err := newCoder(f.layout)
+	if err != nil {
+		return err
+	}
+	upload := make([]bool, len(f.upstreams))
+	for i := range upload {
+		upload[i] = true
+	}
+	shards, err := f.upload(ctx, o.remote, src.ModTime(ctx), size, upload, o.shards, func(out []io.Writer) error {
+		return c.encode(in, size, id, out)
+	}, options...)
+	if err != nil {
+		return err
+	}
+	o.shards = shards
+	o.size = size
+	return nil
+}
+
+// Remove the shards of the object
+func (o *Object) Remove(ctx context.Context) error {
+	errs := make([]error, len(o.shards))
+	multithread(len(o.shards), func(i int) {
+		if o.shards[i] != nil {
+			errs[i] = o.shards[i].Remove(ctx)
+		}
+	})
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove shard %d", i)
+		}
+	}
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.Mover     = (*Fs)(nil)
+	_ fs.DirMover  = (*Fs)(nil)
+	_ fs.Commander = (*Fs)(nil)
+	_ fs.Object    = (*Object)(nil)
+)
@@ -0,0 +1,232 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	h := &header{index: 3, data: 4, parity: 2, blockSize: 1024, size: 123456789, id: 0x0102030405060708}
+	buf := h.marshal()
+	assert.Len(t, buf, headerSize)
+	got, err := parseHeader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, h, got)
+
+	buf[20] ^= 1
+	_, err = parseHeader(buf)
+	assert.Equal(t, errBadHeader, err)
+
+	_, err = parseHeader([]byte("not a shard header at all, not at all!!!"))
+	assert.Equal(t, errBadMagic, err)
+
+	other := *h
+	other.index = 1
+	assert.True(t, h.sameFile(&other))
+	other.id++
+	assert.False(t, h.sameFile(&other))
+	assert.Equal(t, &other, consensus([]*header{nil, h, &other, &other}))
+	assert.Nil(t, consensus([]*header{nil, nil}))
+}
+
+func TestLayout(t *testing.T) {
+	l := layout{data: 3, parity: 2, blockSize: 16}
+	assert.Equal(t, int64(48), l.stripeSize())
+	lengths := make([]int, l.shards())
+	for size := int64(0); size <= 200; size++ {
+		sizes := make([]int64, l.shards())
+		var total int64
+		for i := range sizes {
+			sizes[i] = l.shardSize(size, i)
+			if i < l.data {
+				total += l.payload(sizes[i])
+			}
+		}
+		assert.Equal(t, size, total, "size %d", size)
+		got, ok := l.sizeFromShards(sizes)
+		assert.True(t, ok, "size %d", size)
+		assert.Equal(t, size, got)
+
+		// the pieces hold the whole file
+		total = 0
+		for s := int64(0); s < l.stripes(size); s++ {
+			piece := l.pieceLengths(size, s, lengths)
+			assert.True(t, piece > 0 && piece <= l.blockSize)
+			for i := 0; i < l.data; i++ {
+				total += int64(lengths[i])
+			}
+		}
+		assert.Equal(t, size, total, "size %d", size)
+
+		if size > 0 {
+			// a missing data shard or a shard of the wrong size is noticed
+			sizes[1] = -1
+			_, ok = l.sizeFromShards(sizes)
+			assert.False(t, ok)
+			sizes[1] = l.shardSize(size, 1)
+			sizes[4]++
+			_, ok = l.sizeFromShards(sizes)
+			assert.False(t, ok)
+		}
+	}
+}
+
+// newTestFs makes an erasure Fs with 2 data and 2 parity shards on
+// local directories in dir with a small block size
+func newTestFs(t *testing.T, dir string) *Fs {
+	var upstreams []string
+	for i := 0; i < 4; i++ {
+		upstreams = append(upstreams, filepath.Join(dir, string(rune('a'+i))))
+	}
+	f, err := NewFs(context.Background(), "erasure", "", configmap.Simple{
+		"upstreams":     strings.Join(upstreams, " "),
+		"parity_shards": "2",
+	})
+	require.NoError(t, err)
+	ef := f.(*Fs)
+	ef.layout.blockSize = 16
+	return ef
+}
+
+// shardPath returns the path of shard i of remote
+func shardPath(dir string, i int, remote string) string {
+	return filepath.Join(dir, string(rune('a'+i)), remote)
+}
+
+// readObject opens o with the options returning its contents
+func readObject(t *testing.T, o fs.Object, options ...fs.OpenOption) []byte {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return data
+}
+
+func TestReconstruct(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-erasure-reconstruct")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f := newTestFs(t, dir)
+
+	contents := make([]byte, 150)
+	_, _ = rand.New(rand.NewSource(1)).Read(contents)
+	remote := "dir/file.bin"
+	modTime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	src := object.NewStaticObjectInfo(remote, modTime, int64(len(contents)), true, nil, nil)
+	o, err := f.Put(ctx, bytes.NewReader(contents), src)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+
+	check := func(what string) {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err, what)
+		assert.Equal(t, int64(len(contents)), o.Size(), what)
+		assert.Equal(t, contents, readObject(t, o), what)
+		assert.Equal(t, contents[40:], readObject(t, o, &fs.SeekOption{Offset: 40}), what)
+		assert.Equal(t, contents[33:97], readObject(t, o, &fs.RangeOption{Start: 33, End: 96}), what)
+		assert.Equal(t, contents[140:], readObject(t, o, &fs.RangeOption{Start: -1, End: 10}), what)
+	}
+	check("all shards")
+
+	// Lose a data shard and corrupt another
+	require.NoError(t, os.Remove(shardPath(dir, 0, remote)))
+	shard1 := shardPath(dir, 1, remote)
+	data, err := ioutil.ReadFile(shard1)
+	require.NoError(t, err)
+	data[headerSize+5] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(shard1, data, 0600))
+	check("two bad shards")
+
+	entries, err := f.List(ctx, "dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(len(contents)), entries[0].Size())
+
+	// A shard left from an earlier upload of the same size is ignored
+	shard2 := shardPath(dir, 2, remote)
+	old, err := ioutil.ReadFile(shard2)
+	require.NoError(t, err)
+	newContents := append([]byte{}, contents...)
+	newContents[0]++
+	o, err = f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	require.NoError(t, o.Update(ctx, bytes.NewReader(newContents), src))
+	require.NoError(t, ioutil.WriteFile(shard2, old, 0600))
+	contents = newContents
+	check("stale shard")
+
+	// Repair the stale shard
+	out, err := f.Command(ctx, "repair", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &repairResult{
+		Checked:      1,
+		Repaired:     []string{remote},
+		Unrepairable: []string{},
+	}, out)
+	out, err = f.Command(ctx, "repair", []string{remote}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, out.(*repairResult).Repaired)
+
+	// Repair a lost data shard and a corrupt parity shard
+	require.NoError(t, os.Remove(shardPath(dir, 0, remote)))
+	shard3 := shardPath(dir, 3, remote)
+	data, err = ioutil.ReadFile(shard3)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(shard3, data, 0600))
+	out, err = f.Command(ctx, "repair", []string{"dir"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{remote}, out.(*repairResult).Repaired)
+	o, err = f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	_, bad := o.(*Object).verify(ctx)
+	assert.Equal(t, []bool{false, false, false, false}, bad)
+	assert.True(t, modTime.Equal(o.(*Object).shards[0].ModTime(ctx)))
+	check("repaired")
+
+	// Too many lost shards can't be read or repaired
+	for i := 0; i < 3; i++ {
+		require.NoError(t, os.Remove(shardPath(dir, i, remote)))
+	}
+	o, err = f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(in)
+	assert.Error(t, err)
+	require.NoError(t, in.Close())
+	out, err = f.Command(ctx, "repair", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{remote}, out.(*repairResult).Unrepairable)
+}
+
+func TestNewFsErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, m := range []configmap.Simple{
+		{"upstreams": "/tmp/a"},
+		{"upstreams": "/tmp/a /tmp/b", "parity_shards": "2"},
+		{"upstreams": "/tmp/a /tmp/b", "parity_shards": "0"},
+		{"upstreams": "/tmp/a erasure:b"},
+	} {
+		_, err := NewFs(ctx, "erasure", "", m)
+		assert.Error(t, err, m)
+	}
+}
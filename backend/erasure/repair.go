@@ -0,0 +1,213 @@
+package erasure
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// repairResult is returned by the repair command
+type repairResult struct {
+	Checked      int      `json:"checked"`      // number of files checked
+	Repaired     []string `json:"repaired"`     // files with shards rebuilt
+	Unrepairable []string `json:"unrepairable"` // files with too many bad shards
+}
+
+// repairPaths repairs the files at the paths given, which may be
+// directories, or all the files if there are none
+func (f *Fs) repairPaths(ctx context.Context, paths []string) (*repairResult, error) {
+	res := &repairResult{
+		Repaired:     []string{},
+		Unrepairable: []string{},
+	}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	for _, remote := range paths {
+		if remote != "" {
+			o, err := f.NewObject(ctx, remote)
+			if err == nil {
+				err = f.repairObject(ctx, o.(*Object), res)
+				if err != nil {
+					return res, err
+				}
+				continue
+			} else if err != fs.ErrorObjectNotFound && errors.Cause(err) != fs.ErrorNotAFile {
+				return res, err
+			}
+		}
+		err := walk.ListR(ctx, f, remote, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			return entries.ForObjectError(func(o fs.Object) error {
+				return f.repairObject(ctx, o.(*Object), res)
+			})
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// repairObject checks the shards of o and rebuilds any which are bad,
+// adding the result to res
+func (f *Fs) repairObject(ctx context.Context, o *Object, res *repairResult) error {
+	res.Checked++
+	h, bad := o.verify(ctx)
+	nBad := 0
+	for _, isBad := range bad {
+		if isBad {
+			nBad++
+		}
+	}
+	if nBad == 0 {
+		return nil
+	}
+	if h == nil || nBad > f.layout.parity {
+		fs.Errorf(o, "Can't repair: %d of %d shards are bad", nBad, len(bad))
+		res.Unrepairable = append(res.Unrepairable, o.remote)
+		return nil
+	}
+	if operations.SkipDestructive(ctx, o, "repair") {
+		res.Repaired = append(res.Repaired, o.remote)
+		return nil
+	}
+	err := o.rebuild(ctx, h, bad)
+	if err != nil {
+		return errors.Wrapf(err, "failed to repair %q", o.remote)
+	}
+	fs.Infof(o, "Repaired %d shards", nBad)
+	res.Repaired = append(res.Repaired, o.remote)
+	return nil
+}
+
+// verify reads all the shards of o returning the header most of
+// them agree on and which of them are missing or corrupt
+func (o *Object) verify(ctx context.Context) (h *header, bad []bool) {
+	bad = make([]bool, len(o.shards))
+	headers := o.readHeaders(ctx)
+	h = consensus(headers)
+	for i := range o.shards {
+		bad[i] = headers[i] == nil || h == nil || !headers[i].sameFile(h)
+	}
+	if h == nil {
+		return nil, bad
+	}
+	multithread(len(o.shards), func(i int) {
+		if bad[i] {
+			return
+		}
+		err := o.verifyShard(ctx, i, h.size)
+		if err != nil {
+			fs.Errorf(o.shards[i], "Bad shard: %v", err)
+			bad[i] = true
+		}
+	})
+	return h, bad
+}
+
+// verifyShard reads the pieces of shard i of a file of size bytes
+// checking their CRCs
+func (o *Object) verifyShard(ctx context.Context, i int, size int64) (err error) {
+	l := o.fs.layout
+	if o.shards[i].Size() != l.shardSize(size, i) {
+		return errors.Errorf("size is %d not %d", o.shards[i].Size(), l.shardSize(size, i))
+	}
+	in, err := o.shards[i].Open(ctx, &fs.SeekOption{Offset: headerSize})
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, l.blockSize+crcSize)
+	lengths := make([]int, l.shards())
+	for s := int64(0); s < l.stripes(size); s++ {
+		l.pieceLengths(size, s, lengths)
+		err = readPiece(in, buf, lengths[i])
+		if err != nil {
+			return errors.Wrapf(err, "stripe %d", s)
+		}
+	}
+	n, _ := io.ReadFull(in, buf[:1])
+	if n != 0 {
+		return errors.New("shard is too long")
+	}
+	return nil
+}
+
+// rebuild rebuilds the bad shards of o from the others using the
+// header h they agree on
+func (o *Object) rebuild(ctx context.Context, h *header, bad []bool) error {
+	f := o.fs
+	l := f.layout
+	c, err := newCoder(l)
+	if err != nil {
+		return err
+	}
+	// Read from the first good shards
+	var modTime time.Time
+	ins := make([]io.ReadCloser, len(o.shards))
+	defer func() {
+		for _, in := range ins {
+			if in != nil {
+				_ = in.Close()
+			}
+		}
+	}()
+	opened := 0
+	for i, shard := range o.shards {
+		if bad[i] || opened == l.data {
+			continue
+		}
+		if opened == 0 {
+			modTime = shard.ModTime(ctx)
+		}
+		ins[i], err = shard.Open(ctx, &fs.SeekOption{Offset: headerSize})
+		if err != nil {
+			return err
+		}
+		opened++
+	}
+	shards, err := f.upload(ctx, o.remote, modTime, h.size, bad, o.shards, func(out []io.Writer) error {
+		err := c.writeHeaders(out, h.size, h.id)
+		if err != nil {
+			return err
+		}
+		for s := int64(0); s < l.stripes(h.size); s++ {
+			piece := l.pieceLengths(h.size, s, c.lengths)
+			for i := range c.shards {
+				if ins[i] == nil {
+					c.shards[i] = c.bufs[i][:0]
+					continue
+				}
+				err = readPiece(ins[i], c.bufs[i], c.lengths[i])
+				if err != nil {
+					return errors.Wrapf(err, "failed to read shard %d", i)
+				}
+				c.pad(i, piece)
+			}
+			err = c.rs.Reconstruct(c.shards)
+			if err != nil {
+				return err
+			}
+			err = c.writePieces(out)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		if bad[i] {
+			o.shards[i] = shard
+		}
+	}
+	o.size = h.size
+	return nil
+}
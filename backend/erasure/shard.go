@@ -0,0 +1,419 @@
+package erasure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/pkg/errors"
+)
+
+// Shard format
+//
+// Each file is cut into stripes of data blocks which are Reed-Solomon
+// encoded to make parity blocks. Shard i of the file holds piece i of
+// every stripe, and each shard is stored on its own upstream with the
+// same name as the file.
+//
+// A shard starts with a header
+//
+//     magic      8 bytes  "RCLONEEC"
+//     version    1 byte   1
+//     index      1 byte   which shard this is
+//     data       1 byte   number of data shards
+//     parity     1 byte   number of parity shards
+//     block size 4 bytes  size of the pieces of full stripes
+//     size       8 bytes  size of the file
+//     id         8 bytes  random number shared by the shards of an upload
+//     crc        4 bytes  CRC-32C of the above
+//     reserved   4 bytes  zero
+//
+// which is followed by the pieces of each stripe, each followed by its
+// CRC-32C. All numbers are little endian.
+//
+// The pieces of the last stripe are made just long enough to hold the
+// end of the file. The data shards don't store the padding at the end
+// of their last piece so the size of a file is the sum of what its data
+// shards hold.
+
+const (
+	shardMagic       = "RCLONEEC"
+	shardVersion     = 1
+	headerSize       = 40
+	crcSize          = 4
+	defaultBlockSize = 1024 * 1024
+	maxShards        = 256
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errBadMagic  = errors.New("not a shard - bad magic")
+	errBadHeader = errors.New("shard header is corrupt")
+	errBadCRC    = errors.New("shard data is corrupt - bad CRC")
+)
+
+// header is the start of each shard
+type header struct {
+	index     int    // which shard this is
+	data      int    // number of data shards
+	parity    int    // number of parity shards
+	blockSize int    // size of the pieces of full stripes
+	size      int64  // size of the file
+	id        uint64 // random number shared by the shards of an upload
+}
+
+// marshal returns the header as stored in the shard
+func (h *header) marshal() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, shardMagic)
+	buf[8] = shardVersion
+	buf[9] = byte(h.index)
+	buf[10] = byte(h.data)
+	buf[11] = byte(h.parity)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(h.blockSize))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(h.size))
+	binary.LittleEndian.PutUint64(buf[24:], h.id)
+	binary.LittleEndian.PutUint32(buf[32:], crc32.Checksum(buf[:32], crcTable))
+	return buf
+}
+
+// parseHeader parses a header read from a shard
+func parseHeader(buf []byte) (*header, error) {
+	if len(buf) < headerSize || !bytes.Equal(buf[:8], []byte(shardMagic)) {
+		return nil, errBadMagic
+	}
+	if crc32.Checksum(buf[:32], crcTable) != binary.LittleEndian.Uint32(buf[32:]) {
+		return nil, errBadHeader
+	}
+	if buf[8] != shardVersion {
+		return nil, errors.Errorf("unsupported shard version %d", buf[8])
+	}
+	return &header{
+		index:     int(buf[9]),
+		data:      int(buf[10]),
+		parity:    int(buf[11]),
+		blockSize: int(binary.LittleEndian.Uint32(buf[12:])),
+		size:      int64(binary.LittleEndian.Uint64(buf[16:])),
+		id:        binary.LittleEndian.Uint64(buf[24:]),
+	}, nil
+}
+
+// readHeader reads and parses the header at the start of a shard
+func readHeader(in io.Reader) (*header, error) {
+	buf := make([]byte, headerSize)
+	_, err := io.ReadFull(in, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseHeader(buf)
+}
+
+// sameFile returns whether h and other are from shards of the same upload
+func (h *header) sameFile(other *header) bool {
+	return h.size == other.size && h.id == other.id
+}
+
+// consensus returns the header most of the shards agree on, ignoring
+// their index, or nil if there are no headers
+func consensus(headers []*header) (best *header) {
+	bestVotes := 0
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		votes := 0
+		for _, other := range headers {
+			if other != nil && h.sameFile(other) {
+				votes++
+			}
+		}
+		if votes > bestVotes {
+			best, bestVotes = h, votes
+		}
+	}
+	return best
+}
+
+// newID returns a random id for the shards of an upload
+func newID() (uint64, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(rand.Reader, buf[:])
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to make shard id")
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// layout describes how files are cut into shards
+type layout struct {
+	data      int // number of data shards
+	parity    int // number of parity shards
+	blockSize int // size of the pieces of full stripes
+}
+
+// shards returns the total number of shards
+func (l layout) shards() int {
+	return l.data + l.parity
+}
+
+// stripeSize returns how much of the file a full stripe holds
+func (l layout) stripeSize() int64 {
+	return int64(l.data) * int64(l.blockSize)
+}
+
+// stripes returns the number of stripes in a file of size bytes
+func (l layout) stripes(size int64) int64 {
+	return (size + l.stripeSize() - 1) / l.stripeSize()
+}
+
+// offset returns where stripe s starts in a shard
+func (l layout) offset(s int64) int64 {
+	return headerSize + s*int64(l.blockSize+crcSize)
+}
+
+// pieceLengths returns the size of the pieces of stripe s of a file
+// of size bytes, and sets lengths to how much of each piece is stored
+// in each shard.
+func (l layout) pieceLengths(size int64, s int64, lengths []int) (piece int) {
+	left := size - s*l.stripeSize()
+	if left >= l.stripeSize() {
+		piece = l.blockSize
+	} else {
+		piece = int((left + int64(l.data) - 1) / int64(l.data))
+	}
+	for i := range lengths {
+		if i >= l.data {
+			lengths[i] = piece
+			continue
+		}
+		n := left - int64(i)*int64(piece)
+		if n < 0 {
+			n = 0
+		} else if n > int64(piece) {
+			n = int64(piece)
+		}
+		lengths[i] = int(n)
+	}
+	return piece
+}
+
+// shardSize returns the size of shard i of a file of size bytes
+func (l layout) shardSize(size int64, i int) int64 {
+	full := size / l.stripeSize()
+	n := l.offset(full)
+	if size%l.stripeSize() != 0 {
+		lengths := make([]int, l.shards())
+		l.pieceLengths(size, full, lengths)
+		if lengths[i] > 0 {
+			n += int64(lengths[i] + crcSize)
+		}
+	}
+	return n
+}
+
+// payload returns how much of the file a data shard of shardSize
+// bytes holds, or -1 if no data shard can be that size
+func (l layout) payload(shardSize int64) int64 {
+	n := shardSize - headerSize
+	if n < 0 {
+		return -1
+	}
+	full, rem := n/int64(l.blockSize+crcSize), n%int64(l.blockSize+crcSize)
+	if rem == 0 {
+		return full * int64(l.blockSize)
+	}
+	if rem <= crcSize {
+		return -1
+	}
+	return full*int64(l.blockSize) + rem - crcSize
+}
+
+// sizeFromShards works out the size of a file from the sizes of its
+// shards, with -1 for missing shards.
+//
+// It returns false if a data shard is missing or any of the shards
+// isn't the size it should be.
+func (l layout) sizeFromShards(sizes []int64) (size int64, ok bool) {
+	for i := 0; i < l.data; i++ {
+		n := l.payload(sizes[i])
+		if n < 0 {
+			return -1, false
+		}
+		size += n
+	}
+	for i, n := range sizes {
+		if n >= 0 && n != l.shardSize(size, i) {
+			return -1, false
+		}
+	}
+	return size, true
+}
+
+// check returns an error if h isn't the header of shard i in this layout
+func (l layout) check(h *header, i int) error {
+	if h.index != i {
+		return errors.Errorf("shard is number %d not %d", h.index, i)
+	}
+	if h.data != l.data || h.parity != l.parity {
+		return errors.Errorf("shard is from %d data and %d parity shards not %d and %d", h.data, h.parity, l.data, l.parity)
+	}
+	if h.blockSize != l.blockSize {
+		return errors.Errorf("shard has block size %d not %d", h.blockSize, l.blockSize)
+	}
+	return nil
+}
+
+// coder holds the buffers for encoding and decoding stripes
+type coder struct {
+	l       layout
+	rs      reedsolomon.Encoder
+	bufs    [][]byte // a piece and its CRC for each shard
+	shards  [][]byte // the pieces of the stripe being coded
+	lengths []int    // how much of each piece is stored
+}
+
+// newCoder makes a coder for the layout
+func newCoder(l layout) (*coder, error) {
+	rs, err := reedsolomon.New(l.data, l.parity)
+	if err != nil {
+		return nil, err
+	}
+	c := &coder{
+		l:       l,
+		rs:      rs,
+		bufs:    make([][]byte, l.shards()),
+		shards:  make([][]byte, l.shards()),
+		lengths: make([]int, l.shards()),
+	}
+	for i := range c.bufs {
+		c.bufs[i] = make([]byte, l.blockSize+crcSize)
+	}
+	return c, nil
+}
+
+// readPiece reads a piece of n bytes and its CRC into buf
+func readPiece(in io.Reader, buf []byte, n int) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := io.ReadFull(in, buf[:n+crcSize])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if crc32.Checksum(buf[:n], crcTable) != binary.LittleEndian.Uint32(buf[n:]) {
+		return errBadCRC
+	}
+	return nil
+}
+
+// writePiece writes piece and its CRC to out
+func writePiece(out io.Writer, piece []byte) error {
+	if len(piece) == 0 {
+		return nil
+	}
+	_, err := out.Write(piece)
+	if err != nil {
+		return err
+	}
+	var crc [crcSize]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.Checksum(piece, crcTable))
+	_, err = out.Write(crc[:])
+	return err
+}
+
+// writeHeaders writes the headers of the shards with a writer in out
+func (c *coder) writeHeaders(out []io.Writer, size int64, id uint64) error {
+	for i, w := range out {
+		if w == nil {
+			continue
+		}
+		h := header{
+			index:     i,
+			data:      c.l.data,
+			parity:    c.l.parity,
+			blockSize: c.l.blockSize,
+			size:      size,
+			id:        id,
+		}
+		_, err := w.Write(h.marshal())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePieces writes the pieces of the stripe in c.shards to the
+// shards with a writer in out
+func (c *coder) writePieces(out []io.Writer) error {
+	for i, w := range out {
+		if w == nil {
+			continue
+		}
+		err := writePiece(w, c.shards[i][:c.lengths[i]])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pad zeroes the end of the piece of shard i which isn't stored
+// and sets it as the piece to be coded
+func (c *coder) pad(i int, piece int) {
+	b := c.bufs[i][:piece]
+	for j := c.lengths[i]; j < piece; j++ {
+		b[j] = 0
+	}
+	c.shards[i] = b
+}
+
+// encode reads a file of size bytes from in and writes its shards to
+// out which has a writer for each shard to be written.
+func (c *coder) encode(in io.Reader, size int64, id uint64, out []io.Writer) error {
+	err := c.writeHeaders(out, size, id)
+	if err != nil {
+		return err
+	}
+	stripes := c.l.stripes(size)
+	for s := int64(0); s < stripes; s++ {
+		piece := c.l.pieceLengths(size, s, c.lengths)
+		for i := 0; i < c.l.data; i++ {
+			_, err = io.ReadFull(in, c.bufs[i][:c.lengths[i]])
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to read source")
+			}
+			c.pad(i, piece)
+		}
+		for i := c.l.data; i < c.l.shards(); i++ {
+			c.shards[i] = c.bufs[i][:piece]
+		}
+		err = c.rs.Encode(c.shards)
+		if err != nil {
+			return err
+		}
+		err = c.writePieces(out)
+		if err != nil {
+			return err
+		}
+	}
+	var extra [1]byte
+	n, _ := io.ReadFull(in, extra[:])
+	if n != 0 {
+		return errors.Errorf("source is longer than its size %d", size)
+	}
+	return nil
+}
@@ -0,0 +1,634 @@
+// Package git provides read only access to the files in a commit of
+// a git repository, either on the local disk or on a server which
+// speaks the smart HTTP protocol.
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+var errorReadOnly = errors.New("git remotes are read only")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "git",
+		Description: "Git repository (read only)",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: `Path or URL of the repository
+
+This can be the path of a working tree or a bare repository on the
+local disk, or an http:// or https:// URL of a repository on a server.`,
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "/home/user/src/project",
+				Help:  "Repository on the local disk",
+			}, {
+				Value: "https://github.com/rclone/rclone.git",
+				Help:  "Repository on a server",
+			}},
+		}, {
+			Name: "ref",
+			Help: `Branch, tag or commit to read the files from
+
+This is looked up like git does, so it can be a branch or tag name,
+a full ref like refs/heads/main, HEAD, or a commit ID.`,
+			Default: "HEAD",
+		}, {
+			Name: "user",
+			Help: "User name for a repository on a server",
+		}, {
+			Name:       "pass",
+			Help:       "Password or access token for a repository on a server",
+			IsPassword: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+	Ref    string `config:"ref"`
+	User   string `config:"user"`
+	Pass   string `config:"pass"`
+}
+
+// Fs represents the files in a commit
+type Fs struct {
+	name     string       // name of this remote
+	root     string       // the path we are working on
+	opt      Options      // parsed options
+	features *fs.Features // optional features
+	store    objectStore  // where the objects are read from
+	commitID oid          // commit the files are from
+	tree     oid          // root tree of the commit
+	modTime  time.Time    // time of the commit
+
+	mu     sync.Mutex
+	trees  map[oid][]treeEntry // cache of parsed trees
+	hashes map[string]string   // cache of hashes by type and oid
+}
+
+// Object describes a file in the commit
+type Object struct {
+	fs     *Fs    // what this object is part of
+	remote string // The remote path
+	id     oid    // oid of the blob
+	size   int64  // size of the object
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("git repository %s at %s (%s) path %q", f.opt.Remote, f.opt.Ref, f.commitID.String()[:12], f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// isURL returns true if remote is a URL for a server
+func isURL(remote string) bool {
+	return strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://")
+}
+
+// Repositories on the local disk and packs fetched from servers are
+// shared between all the Fs using them
+var (
+	storesMu sync.Mutex
+	stores   = map[string]objectStore{}
+)
+
+// fetchedPack is a pack fetched from a server
+type fetchedPack struct {
+	*packFile
+	offsets map[oid]int64
+}
+
+// read returns the type and contents of an object
+func (p *fetchedPack) read(id oid) (objectType, []byte, error) {
+	offset, ok := p.offsets[id]
+	if !ok {
+		return 0, nil, errObjectMissing
+	}
+	return p.readAt(offset)
+}
+
+// stat returns the type and size of an object
+func (p *fetchedPack) stat(id oid) (objectType, int64, error) {
+	offset, ok := p.offsets[id]
+	if !ok {
+		return 0, 0, errObjectMissing
+	}
+	return p.statAt(offset)
+}
+
+// open returns a reader for the contents of an object
+func (p *fetchedPack) open(id oid) (io.ReadCloser, error) {
+	offset, ok := p.offsets[id]
+	if !ok {
+		return nil, errObjectMissing
+	}
+	return p.openAt(offset)
+}
+
+// checkPackChecksum checks the SHA-1 at the end of the pack matches
+// the rest of it
+func checkPackChecksum(file *os.File, size int64) error {
+	if size < packHeaderSize+sha1.Size {
+		return errors.New("pack truncated")
+	}
+	h := sha1.New()
+	_, err := io.Copy(h, io.NewSectionReader(file, 0, size-sha1.Size))
+	if err != nil {
+		return err
+	}
+	trailer := make([]byte, sha1.Size)
+	_, err = file.ReadAt(trailer, size-sha1.Size)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), trailer) {
+		return errors.New("pack checksum mismatch")
+	}
+	return nil
+}
+
+// fetchPack fetches the commit want from the server into a temporary
+// file which is removed when rclone exits
+func fetchPack(ctx context.Context, client *smartClient, rr *remoteRefs, want oid) (store objectStore, err error) {
+	file, err := ioutil.TempFile("", "rclone-git-*.pack")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make temporary file")
+	}
+	remove := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	handle := atexit.Register(remove)
+	defer func() {
+		if err != nil {
+			atexit.Unregister(handle)
+			remove()
+		}
+	}()
+	fs.Debugf(nil, "git: fetching %v into %q", want, file.Name())
+	err = client.fetch(ctx, rr, want, file)
+	if err != nil {
+		return nil, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPackChecksum(file, size)
+	if err != nil {
+		return nil, err
+	}
+	p, count, err := newPackFile(file, size)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := indexPack(p, count)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to index pack")
+	}
+	fs.Debugf(nil, "git: fetched %d objects (%d bytes)", count, size)
+	return &fetchedPack{packFile: p, offsets: offsets}, nil
+}
+
+// openStore opens the repository and resolves the ref
+func openStore(ctx context.Context, opt *Options) (store objectStore, id oid, err error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if !isURL(opt.Remote) {
+		repoPath := strings.TrimPrefix(opt.Remote, "file://")
+		repo, ok := stores[repoPath].(*localRepo)
+		if !ok {
+			repo, err = openLocal(repoPath)
+			if err != nil {
+				return nil, id, err
+			}
+			stores[repoPath] = repo
+		}
+		id, err = repo.resolve(opt.Ref)
+		return repo, id, err
+	}
+	rootURL := strings.TrimSuffix(opt.Remote, "/")
+	srv := rest.NewClient(fshttp.NewClient(ctx)).SetRoot(rootURL)
+	srv.SetErrorHandler(errorHandler)
+	if opt.User != "" || opt.Pass != "" {
+		pass, err := obscure.Reveal(opt.Pass)
+		if err != nil {
+			return nil, id, errors.Wrap(err, "couldn't decrypt password")
+		}
+		srv.SetUserPass(opt.User, pass)
+	}
+	client := &smartClient{srv: srv}
+	rr, err := client.lsRefs(ctx)
+	if err != nil {
+		return nil, id, err
+	}
+	id, err = rr.resolve(opt.Ref)
+	if err != nil {
+		return nil, id, err
+	}
+	key := rootURL + "\x00" + id.String()
+	if store, ok := stores[key]; ok {
+		return store, id, nil
+	}
+	store, err = fetchPack(ctx, client, rr, id)
+	if err != nil {
+		return nil, id, err
+	}
+	stores[key] = store
+	return store, id, nil
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Remote == "" {
+		return nil, errors.New("remote must be set to the path or URL of the repository")
+	}
+	if opt.Ref == "" {
+		opt.Ref = "HEAD"
+	}
+	store, id, err := openStore(ctx, opt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s at %s", opt.Remote, opt.Ref)
+	}
+	f := &Fs{
+		name:   name,
+		root:   strings.Trim(root, "/"),
+		opt:    *opt,
+		store:  store,
+		trees:  map[oid][]treeEntry{},
+		hashes: map[string]string{},
+	}
+	f.features = (&fs.Features{}).Fill(ctx, f)
+
+	// Peel tags to find the commit
+	for depth := 0; ; depth++ {
+		typ, data, err := store.read(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %v", id)
+		}
+		if typ == typeTag && depth < 10 {
+			id, _, err = parseTag(data)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if typ != typeCommit {
+			return nil, errors.Errorf("%s is a %v not a commit", opt.Ref, typ)
+		}
+		c, err := parseCommit(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse commit %v", id)
+		}
+		f.commitID, f.tree, f.modTime = id, c.tree, c.time
+		break
+	}
+
+	// Check to see if the root is a file
+	if f.root != "" {
+		entry, err := f.findEntry(f.root)
+		if err == nil && entry.isFile() {
+			f.root = path.Dir(f.root)
+			if f.root == "." {
+				f.root = ""
+			}
+			return f, fs.ErrorIsFile
+		} else if err != nil && err != fs.ErrorObjectNotFound {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// readTree reads the tree with oid id
+func (f *Fs) readTree(id oid) ([]treeEntry, error) {
+	f.mu.Lock()
+	entries, ok := f.trees[id]
+	f.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+	typ, data, err := f.store.read(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read tree %v", id)
+	}
+	if typ != typeTree {
+		return nil, errors.Errorf("%v is a %v not a tree", id, typ)
+	}
+	entries, err = parseTree(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse tree %v", id)
+	}
+	f.mu.Lock()
+	f.trees[id] = entries
+	f.mu.Unlock()
+	return entries, nil
+}
+
+// findEntry finds the tree entry for p relative to the root of the
+// commit, returning fs.ErrorObjectNotFound if it doesn't exist
+func (f *Fs) findEntry(p string) (*treeEntry, error) {
+	entry := &treeEntry{mode: modeTree, id: f.tree}
+	if p == "" {
+		return entry, nil
+	}
+	for _, name := range strings.Split(p, "/") {
+		if !entry.isDir() {
+			return nil, fs.ErrorObjectNotFound
+		}
+		entries, err := f.readTree(entry.id)
+		if err != nil {
+			return nil, err
+		}
+		entry = nil
+		for i := range entries {
+			if entries[i].name == name {
+				entry = &entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, fs.ErrorObjectNotFound
+		}
+	}
+	return entry, nil
+}
+
+// absPath returns the path of remote relative to the root of the commit
+func (f *Fs) absPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// newObject makes an Object for the file entry
+func (f *Fs) newObject(remote string, entry *treeEntry) (*Object, error) {
+	typ, size, err := f.store.stat(entry.id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", remote)
+	}
+	if typ != typeBlob {
+		return nil, errors.Errorf("%q is a %v not a blob", remote, typ)
+	}
+	return &Object{
+		fs:     f,
+		remote: remote,
+		id:     entry.id,
+		size:   size,
+	}, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entry, err := f.findEntry(f.absPath(dir))
+	if err == fs.ErrorObjectNotFound || (err == nil && !entry.isDir()) {
+		return nil, fs.ErrorDirNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	items, err := f.readTree(entry.id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		item := &items[i]
+		remote := path.Join(dir, item.name)
+		switch {
+		case item.isDir():
+			d := fs.NewDir(remote, f.modTime).SetID(item.id.String())
+			entries = append(entries, d)
+		case item.isFile():
+			o, err := f.newObject(remote, item)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, o)
+		case item.mode == modeSymlink:
+			fs.Debugf(f, "Ignoring symlink %q", remote)
+		case item.mode == modeSubmodule:
+			fs.Debugf(f, "Ignoring submodule %q", remote)
+		default:
+			fs.Debugf(f, "Ignoring %q with unknown mode %o", remote, item.mode)
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	entry, err := f.findEntry(f.absPath(remote))
+	if err != nil {
+		return nil, err
+	}
+	if entry.isDir() {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if !entry.isFile() {
+		return nil, errors.Wrapf(fs.ErrorNotAFile, "%q has mode %o", remote, entry.mode)
+	}
+	return f.newObject(remote, entry)
+}
+
+// Put the object
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, errorReadOnly
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// Rmdir deletes the directory if empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// Precision of the remote
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5 | hash.SHA1)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the hash of an object returning a lowercase hex string
+//
+// Git doesn't store these so they are calculated by reading the
+// object and cached.
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	if t != hash.MD5 && t != hash.SHA1 {
+		return "", hash.ErrUnsupported
+	}
+	key := t.String() + ":" + o.id.String()
+	o.fs.mu.Lock()
+	sum, ok := o.fs.hashes[key]
+	o.fs.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	in, err := o.fs.store.open(o.id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read object for hash")
+	}
+	defer fs.CheckClose(in, &err)
+	hashes, err := hash.StreamTypes(in, hash.NewHashSet(t))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read object for hash")
+	}
+	sum = hashes[t]
+	o.fs.mu.Lock()
+	o.fs.hashes[key] = sum
+	o.fs.mu.Unlock()
+	return sum, nil
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ID returns the git object ID of the blob
+func (o *Object) ID() string {
+	return o.id.String()
+}
+
+// ModTime returns the modification time of the object
+//
+// This is the time of the commit.
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.fs.modTime
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	in, err = o.fs.store.open(o.id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open object")
+	}
+	if offset > 0 {
+		_, err = io.CopyN(ioutil.Discard, in, offset)
+		if err != nil && err != io.EOF {
+			_ = in.Close()
+			return nil, errors.Wrap(err, "failed to seek object")
+		}
+	}
+	return readers.NewLimitedReadCloser(in, limit), nil
+}
+
+// Update the object with the contents of the io.Reader
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errorReadOnly
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return errorReadOnly
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs     = (*Fs)(nil)
+	_ fs.Object = (*Object)(nil)
+	_ fs.IDer   = (*Object)(nil)
+)
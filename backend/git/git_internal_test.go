@@ -0,0 +1,716 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTime = time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("+0100", 3600))
+
+// testObject is an object to put in a test repository
+type testObject struct {
+	typ  objectType
+	data []byte
+	base int  // index of the delta base or -1
+	ref  bool // use a ref-delta rather than an ofs-delta
+}
+
+// testRepo builds the objects of a test repository
+type testRepo struct {
+	objects []testObject
+}
+
+// add adds an object returning its oid
+func (r *testRepo) add(typ objectType, data string) oid {
+	r.objects = append(r.objects, testObject{typ: typ, data: []byte(data), base: -1})
+	return hashObject(typ, []byte(data))
+}
+
+// addDelta adds an object stored as a delta against the object at base
+func (r *testRepo) addDelta(base int, ref bool, data string) oid {
+	r.objects = append(r.objects, testObject{typ: typeBlob, data: []byte(data), base: base, ref: ref})
+	return hashObject(typeBlob, []byte(data))
+}
+
+// tree makes the data for a tree object
+func tree(entries ...treeEntry) string {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	var buf bytes.Buffer
+	for _, e := range entries {
+		_, _ = fmt.Fprintf(&buf, "%o %s\x00", e.mode, e.name)
+		_, _ = buf.Write(e.id[:])
+	}
+	return buf.String()
+}
+
+// commitData makes the data for a commit object
+func commitData(treeID oid, message string) string {
+	return fmt.Sprintf("tree %v\nauthor A U Thor <a@example.com> %d +0100\ncommitter C O Mitter <c@example.com> %d +0100\n\n%s\n",
+		treeID, testTime.Unix(), testTime.Unix(), message)
+}
+
+// tagData makes the data for an annotated tag object
+func tagData(target oid, name string) string {
+	return fmt.Sprintf("object %v\ntype commit\ntag %s\ntagger T Agger <t@example.com> %d +0100\n\nRelease\n",
+		target, name, testTime.Unix())
+}
+
+// deflate compresses data with zlib
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+// makeDelta makes a delta which copies the common prefix from base
+// then inserts the rest of target
+func makeDelta(base, target []byte) []byte {
+	var delta []byte
+	varint := func(n int) {
+		for n >= 0x80 {
+			delta = append(delta, byte(n)|0x80)
+			n >>= 7
+		}
+		delta = append(delta, byte(n))
+	}
+	varint(len(base))
+	varint(len(target))
+	common := 0
+	for common < len(base) && common < len(target) && common < 0xffff && base[common] == target[common] {
+		common++
+	}
+	if common > 0 {
+		delta = append(delta, 0x80|0x10|0x20, byte(common), byte(common>>8))
+	}
+	for rest := target[common:]; len(rest) > 0; {
+		n := len(rest)
+		if n > 0x7f {
+			n = 0x7f
+		}
+		delta = append(delta, byte(n))
+		delta = append(delta, rest[:n]...)
+		rest = rest[n:]
+	}
+	return delta
+}
+
+// pack makes a pack and a version 2 index for the objects
+func (r *testRepo) pack() (pack, idx []byte) {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("PACK")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(2))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(r.objects)))
+	offsets := make([]int64, len(r.objects))
+	ids := make([]oid, len(r.objects))
+	for i, o := range r.objects {
+		offsets[i] = int64(buf.Len())
+		ids[i] = hashObject(o.typ, o.data)
+		typ, data := o.typ, o.data
+		if o.base >= 0 {
+			data = makeDelta(r.objects[o.base].data, o.data)
+			typ = typeOfsDelta
+			if o.ref {
+				typ = typeRefDelta
+			}
+		}
+		size := len(data)
+		c := byte(typ)<<4 | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			_ = buf.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		_ = buf.WriteByte(c)
+		switch typ {
+		case typeOfsDelta:
+			n := offsets[i] - offsets[o.base]
+			enc := []byte{byte(n & 0x7f)}
+			for n >>= 7; n > 0; n >>= 7 {
+				n--
+				enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+			}
+			_, _ = buf.Write(enc)
+		case typeRefDelta:
+			_, _ = buf.Write(ids[o.base][:])
+		}
+		_, _ = buf.Write(deflate(data))
+	}
+	sum := sha1.Sum(buf.Bytes())
+	_, _ = buf.Write(sum[:])
+	pack = buf.Bytes()
+
+	order := make([]int, len(ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(ids[order[i]][:], ids[order[j]][:]) < 0
+	})
+	var ibuf bytes.Buffer
+	_, _ = ibuf.WriteString(idxMagic)
+	for b := 0; b < 256; b++ {
+		n := 0
+		for _, id := range ids {
+			if int(id[0]) <= b {
+				n++
+			}
+		}
+		_ = binary.Write(&ibuf, binary.BigEndian, uint32(n))
+	}
+	for _, i := range order {
+		_, _ = ibuf.Write(ids[i][:])
+	}
+	for range order {
+		_ = binary.Write(&ibuf, binary.BigEndian, uint32(0)) // CRCs aren't checked
+	}
+	for _, i := range order {
+		_ = binary.Write(&ibuf, binary.BigEndian, uint32(offsets[i]))
+	}
+	_, _ = ibuf.Write(sum[:])
+	isum := sha1.Sum(ibuf.Bytes())
+	_, _ = ibuf.Write(isum[:])
+	return pack, ibuf.Bytes()
+}
+
+// writeLoose writes the objects as loose objects into dir
+func (r *testRepo) writeLoose(t *testing.T, dir string) {
+	for _, o := range r.objects {
+		id := hashObject(o.typ, o.data).String()
+		p := filepath.Join(dir, "objects", id[:2], id[2:])
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+		data := append(objectHeader(o.typ, int64(len(o.data))), o.data...)
+		require.NoError(t, ioutil.WriteFile(p, deflate(data), 0666))
+	}
+}
+
+// writePack writes the objects as a pack into dir
+func (r *testRepo) writePack(t *testing.T, dir string) {
+	pack, idx := r.pack()
+	name := filepath.Join(dir, "objects", "pack", fmt.Sprintf("pack-%x", pack[len(pack)-sha1.Size:]))
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0777))
+	require.NoError(t, ioutil.WriteFile(name+".pack", pack, 0666))
+	require.NoError(t, ioutil.WriteFile(name+".idx", idx, 0666))
+}
+
+// writeFile writes a file making its directory
+func writeFile(t *testing.T, p, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+	require.NoError(t, ioutil.WriteFile(p, []byte(data), 0666))
+}
+
+// testFiles are the files in the second test commit
+var testFiles = map[string]string{
+	"hello.txt":      "hello world\n",
+	"run.sh":         "#!/bin/sh\necho hello\n",
+	"dir/a.txt":      strings.Repeat("potato ", 1000),
+	"dir/sub/b.txt":  strings.Repeat("potato ", 1000) + "and chips\n",
+	"dir/sub/c.txt":  strings.Repeat("potato ", 1000) + "and gravy\n",
+	"dir/sub/empty":  "",
+	"dir/unchanged":  "unchanged\n",
+	"with space.txt": "spaced out\n",
+}
+
+// testHistory builds two commits - the first as loose objects and
+// the second as a pack with deltas against the first
+type testHistory struct {
+	loose, packed testRepo
+	first, second oid // commits
+	tag           oid // annotated tag of the first commit
+}
+
+func newTestHistory() *testHistory {
+	h := &testHistory{}
+	l, p := &h.loose, &h.packed
+
+	// first commit
+	hello := l.add(typeBlob, "hello\n")
+	a := l.add(typeBlob, testFiles["dir/a.txt"])
+	unchanged := l.add(typeBlob, testFiles["dir/unchanged"])
+	dir := l.add(typeTree, tree(
+		treeEntry{modeFile, "a.txt", a},
+		treeEntry{modeFile, "unchanged", unchanged},
+	))
+	root := l.add(typeTree, tree(
+		treeEntry{modeFile, "hello.txt", hello},
+		treeEntry{modeTree, "dir", dir},
+	))
+	h.first = l.add(typeCommit, commitData(root, "first"))
+	h.tag = l.add(typeTag, tagData(h.first, "v1.0"))
+
+	// second commit
+	a = p.add(typeBlob, testFiles["dir/a.txt"])
+	b := p.addDelta(0, false, testFiles["dir/sub/b.txt"])
+	c := p.addDelta(1, true, testFiles["dir/sub/c.txt"])
+	empty := p.add(typeBlob, "")
+	sub := p.add(typeTree, tree(
+		treeEntry{modeFile, "b.txt", b},
+		treeEntry{modeFile, "c.txt", c},
+		treeEntry{modeFile, "empty", empty},
+	))
+	dir = p.add(typeTree, tree(
+		treeEntry{modeFile, "a.txt", a},
+		treeEntry{modeTree, "sub", sub},
+		treeEntry{modeFile, "unchanged", unchanged},
+	))
+	link := p.add(typeBlob, "hello.txt")
+	root = p.add(typeTree, tree(
+		treeEntry{modeFile, "hello.txt", p.add(typeBlob, testFiles["hello.txt"])},
+		treeEntry{modeExecutable, "run.sh", p.add(typeBlob, testFiles["run.sh"])},
+		treeEntry{modeFile, "with space.txt", p.add(typeBlob, testFiles["with space.txt"])},
+		treeEntry{modeSymlink, "link", link},
+		treeEntry{modeSubmodule, "module", h.first},
+		treeEntry{modeTree, "dir", dir},
+	))
+	h.second = p.add(typeCommit, commitData(root, "second"))
+	return h
+}
+
+// makeLocalRepo makes a repository on disk with the test history
+// and returns its working tree
+func makeLocalRepo(t *testing.T) (dir string, h *testHistory, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-git-test")
+	require.NoError(t, err)
+	h = newTestHistory()
+	gitDir := filepath.Join(dir, ".git")
+	h.loose.writeLoose(t, gitDir)
+	h.packed.writePack(t, gitDir)
+	writeFile(t, filepath.Join(gitDir, "HEAD"), "ref: refs/heads/main\n")
+	writeFile(t, filepath.Join(gitDir, "refs", "heads", "main"), h.second.String()+"\n")
+	writeFile(t, filepath.Join(gitDir, "packed-refs"), fmt.Sprintf(
+		"# pack-refs with: peeled fully-peeled sorted\n%v refs/heads/old\n%v refs/tags/v1.0\n^%v\n",
+		h.first, h.tag, h.first))
+	return dir, h, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// newFs makes an Fs for remote at ref
+func newFs(t *testing.T, remote, ref, root string) (*Fs, error) {
+	f, err := NewFs(context.Background(), "test", root, configmap.Simple{
+		"remote": remote,
+		"ref":    ref,
+		"user":   "",
+		"pass":   "",
+	})
+	if f == nil {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// listAll lists f recursively returning the files and their contents
+func listAll(t *testing.T, f fs.Fs, dir string, files map[string]string) {
+	ctx := context.Background()
+	entries, err := f.List(ctx, dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			listAll(t, f, x.Remote(), files)
+		case fs.Object:
+			files[x.Remote()] = read(t, x)
+		}
+	}
+}
+
+// read reads the contents of o
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) string {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("the quick brown fox")
+	target := []byte("the quick red fox jumps")
+	got, err := applyDelta(base, makeDelta(base, target))
+	require.NoError(t, err)
+	assert.Equal(t, target, got)
+
+	_, err = applyDelta([]byte("short"), makeDelta(base, target))
+	assert.Error(t, err)
+	_, err = applyDelta(base, []byte{19, 10, 0x91, 100, 10})
+	assert.Error(t, err)
+}
+
+func TestParseCommit(t *testing.T) {
+	c, err := parseCommit([]byte(commitData(oid{1}, "message")))
+	require.NoError(t, err)
+	assert.Equal(t, oid{1}, c.tree)
+	assert.True(t, testTime.Equal(c.time))
+	_, offset := c.time.Zone()
+	assert.Equal(t, 3600, offset)
+
+	_, err = parseCommit([]byte("author A <a> 1 +0000\n\ntree in message\n"))
+	assert.Error(t, err)
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir, h, cleanup := makeLocalRepo(t)
+	defer cleanup()
+
+	f, err := newFs(t, dir, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, h.second, f.commitID)
+	files := map[string]string{}
+	listAll(t, f, "", files)
+	assert.Equal(t, testFiles, files)
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.True(t, testTime.Equal(entry.ModTime(ctx)), entry.Remote())
+	}
+
+	_, err = f.List(ctx, "hello.txt")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+	_, err = f.List(ctx, "missing")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	// symlinks and submodules aren't files
+	_, err = f.NewObject(ctx, "link")
+	assert.True(t, errors.Cause(err) == fs.ErrorNotAFile, err)
+	_, err = f.NewObject(ctx, "module")
+	assert.True(t, errors.Cause(err) == fs.ErrorNotAFile, err)
+	_, err = f.NewObject(ctx, "dir")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	_, err = f.NewObject(ctx, "dir/missing")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	_, err = f.NewObject(ctx, "hello.txt/missing")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// the old commit is loose objects
+	for _, ref := range []string{"old", "refs/heads/old", "v1.0", "tags/v1.0", h.first.String(), h.first.String()[:7], h.tag.String()} {
+		f, err = newFs(t, dir, ref, "")
+		require.NoError(t, err, ref)
+		assert.Equal(t, h.first, f.commitID, ref)
+		files := map[string]string{}
+		listAll(t, f, "", files)
+		assert.Equal(t, map[string]string{
+			"hello.txt":     "hello\n",
+			"dir/a.txt":     testFiles["dir/a.txt"],
+			"dir/unchanged": testFiles["dir/unchanged"],
+		}, files, ref)
+	}
+
+	for _, ref := range []string{"missing", "../../HEAD", "abc", h.second.String()[:3]} {
+		_, err = newFs(t, dir, ref, "")
+		assert.Error(t, err, ref)
+	}
+
+	// the ref can't be a tree or a blob
+	_, err = newFs(t, dir, hashObject(typeBlob, []byte("hello\n")).String(), "")
+	assert.Error(t, err)
+}
+
+func TestRoot(t *testing.T) {
+	ctx := context.Background()
+	dir, _, cleanup := makeLocalRepo(t)
+	defer cleanup()
+
+	f, err := newFs(t, dir, "main", "dir/sub")
+	require.NoError(t, err)
+	o, err := f.NewObject(ctx, "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, testFiles["dir/sub/b.txt"], read(t, o))
+
+	f, err = newFs(t, dir, "main", "dir/sub/c.txt")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "dir/sub", f.Root())
+	o, err = f.NewObject(ctx, "c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, testFiles["dir/sub/c.txt"], read(t, o))
+
+	f, err = newFs(t, dir, "main", "missing")
+	require.NoError(t, err)
+	_, err = f.List(ctx, "")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
+
+func TestObject(t *testing.T) {
+	ctx := context.Background()
+	dir, _, cleanup := makeLocalRepo(t)
+	defer cleanup()
+	f, err := newFs(t, dir, "main", "")
+	require.NoError(t, err)
+
+	o, err := f.NewObject(ctx, "dir/sub/b.txt")
+	require.NoError(t, err)
+	contents := testFiles["dir/sub/b.txt"]
+	assert.Equal(t, int64(len(contents)), o.Size())
+	assert.Equal(t, hashObject(typeBlob, []byte(contents)).String(), o.(fs.IDer).ID())
+	assert.True(t, testTime.Equal(o.ModTime(ctx)))
+
+	for _, ht := range []hash.Type{hash.MD5, hash.SHA1} {
+		want, err := hash.StreamTypes(strings.NewReader(contents), hash.NewHashSet(ht))
+		require.NoError(t, err)
+		got, err := o.Hash(ctx, ht)
+		require.NoError(t, err)
+		assert.Equal(t, want[ht], got)
+	}
+	_, err = o.Hash(ctx, hash.Whirlpool)
+	assert.Equal(t, hash.ErrUnsupported, err)
+
+	assert.Equal(t, contents[100:], read(t, o, &fs.SeekOption{Offset: 100}))
+	assert.Equal(t, contents[100:201], read(t, o, &fs.RangeOption{Start: 100, End: 200}))
+	assert.Equal(t, contents[len(contents)-10:], read(t, o, &fs.RangeOption{Start: -1, End: 10}))
+
+	assert.Equal(t, errorReadOnly, o.Remove(ctx))
+	assert.Equal(t, errorReadOnly, f.Mkdir(ctx, "new"))
+	assert.Equal(t, fs.ErrorCantSetModTime, o.SetModTime(ctx, time.Now()))
+}
+
+func TestLocalFormats(t *testing.T) {
+	// a bare repository with the objects in an alternate
+	dir, err := ioutil.TempDir("", "rclone-git-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	h := newTestHistory()
+	bare := filepath.Join(dir, "bare.git")
+	other := filepath.Join(dir, "other")
+	h.loose.writeLoose(t, other)
+	h.packed.writePack(t, bare)
+	writeFile(t, filepath.Join(bare, "HEAD"), "ref: refs/heads/main\n")
+	writeFile(t, filepath.Join(bare, "refs", "heads", "main"), h.second.String()+"\n")
+	writeFile(t, filepath.Join(bare, "refs", "tags", "v1.0"), h.tag.String()+"\n")
+	writeFile(t, filepath.Join(bare, "objects", "info", "alternates"), filepath.Join(other, "objects")+"\n")
+
+	f, err := newFs(t, bare, "v1.0", "")
+	require.NoError(t, err)
+	assert.Equal(t, h.first, f.commitID)
+	f, err = newFs(t, "file://"+bare, "HEAD", "")
+	require.NoError(t, err)
+	assert.Equal(t, h.second, f.commitID)
+
+	// a linked working tree with its own HEAD
+	work := filepath.Join(dir, "work")
+	worktreeDir := filepath.Join(bare, "worktrees", "work")
+	writeFile(t, filepath.Join(work, ".git"), "gitdir: "+worktreeDir+"\n")
+	writeFile(t, filepath.Join(worktreeDir, "commondir"), "../..\n")
+	writeFile(t, filepath.Join(worktreeDir, "HEAD"), h.first.String()+"\n")
+	f, err = newFs(t, work, "HEAD", "")
+	require.NoError(t, err)
+	assert.Equal(t, h.first, f.commitID)
+	f, err = newFs(t, work, "main", "")
+	require.NoError(t, err)
+	assert.Equal(t, h.second, f.commitID)
+
+	_, err = newFs(t, other, "HEAD", "")
+	assert.Error(t, err)
+}
+
+// fakeServer is a git server using the smart HTTP protocol
+type fakeServer struct {
+	t        *testing.T
+	srv      *httptest.Server
+	refs     string // advertised refs
+	pack     []byte // pack to send for any fetch
+	sideBand bool   // whether side-band-64k is supported
+	user     string
+	pass     string
+	fetches  int
+}
+
+func newFakeServer(t *testing.T, h *testHistory) *fakeServer {
+	var all testRepo
+	all.objects = append(all.objects, h.packed.objects...)
+	all.objects = append(all.objects, h.loose.objects...)
+	pack, _ := all.pack()
+	srv := &fakeServer{
+		t: t,
+		refs: fmt.Sprintf("%v refs/heads/main\n%v refs/heads/old\n%v refs/tags/v1.0\n%v refs/tags/v1.0^{}\n",
+			h.second, h.first, h.tag, h.first),
+		pack:     pack,
+		sideBand: true,
+	}
+	srv.srv = httptest.NewServer(http.HandlerFunc(srv.handle))
+	// forget packs fetched by other tests
+	storesMu.Lock()
+	stores = map[string]objectStore{}
+	storesMu.Unlock()
+	return srv
+}
+
+func (srv *fakeServer) close() {
+	srv.srv.Close()
+}
+
+func (srv *fakeServer) url() string {
+	return srv.srv.URL + "/repo.git"
+}
+
+func (srv *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	if srv.user != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != srv.user || pass != srv.pass {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+	}
+	caps := "ofs-delta shallow no-progress"
+	if srv.sideBand {
+		caps += " side-band-64k"
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/repo.git/info/refs":
+		if r.URL.Query().Get("service") != uploadPack {
+			http.Error(w, "dumb protocol", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		lines := strings.Split(strings.TrimSuffix(srv.refs, "\n"), "\n")
+		_, _ = io.WriteString(w, pktLine("# service=git-upload-pack\n")+pktFlush)
+		for i, line := range lines {
+			if i == 0 {
+				line += "\x00" + caps
+			}
+			_, _ = io.WriteString(w, pktLine(line+"\n"))
+		}
+		_, _ = io.WriteString(w, pktFlush)
+	case r.Method == "POST" && r.URL.Path == "/repo.git/git-upload-pack":
+		srv.fetches++
+		var wants []string
+		shallow := false
+		for {
+			line, err := readPkt(r.Body)
+			if err == errFlush {
+				continue
+			}
+			require.NoError(srv.t, err)
+			text := strings.TrimSpace(string(line))
+			if text == "done" {
+				break
+			}
+			fields := strings.Fields(text)
+			switch fields[0] {
+			case "want":
+				wants = append(wants, fields[1])
+			case "deepen":
+				shallow = true
+			}
+		}
+		assert.Len(srv.t, wants, 1)
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		if shallow {
+			_, _ = io.WriteString(w, pktLine("shallow "+wants[0]+"\n")+pktFlush)
+		}
+		_, _ = io.WriteString(w, pktLine("NAK\n"))
+		if !srv.sideBand {
+			_, _ = w.Write(srv.pack)
+			return
+		}
+		for data := srv.pack; len(data) > 0; {
+			n := len(data)
+			if n > 1000 {
+				n = 1000
+			}
+			_, _ = io.WriteString(w, pktLine("\x02Counting objects\n"))
+			_, _ = io.WriteString(w, pktLine("\x01"+string(data[:n])))
+			data = data[n:]
+		}
+		_, _ = io.WriteString(w, pktFlush)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSmart(t *testing.T) {
+	h := newTestHistory()
+	for _, sideBand := range []bool{true, false} {
+		t.Run(fmt.Sprintf("sideBand=%v", sideBand), func(t *testing.T) {
+			srv := newFakeServer(t, h)
+			defer srv.close()
+			srv.sideBand = sideBand
+
+			f, err := newFs(t, srv.url(), "main", "")
+			require.NoError(t, err)
+			assert.Equal(t, h.second, f.commitID)
+			files := map[string]string{}
+			listAll(t, f, "", files)
+			assert.Equal(t, testFiles, files)
+			assert.Equal(t, 1, srv.fetches)
+
+			// the fetched pack is shared
+			_, err = newFs(t, srv.url()+"/", "refs/heads/main", "dir")
+			require.NoError(t, err)
+			assert.Equal(t, 1, srv.fetches)
+
+			// annotated tags are peeled
+			f, err = newFs(t, srv.url(), "v1.0", "")
+			require.NoError(t, err)
+			assert.Equal(t, h.first, f.commitID)
+			assert.Equal(t, 2, srv.fetches)
+
+			// an advertised commit can be abbreviated
+			f, err = newFs(t, srv.url(), h.first.String()[:8], "")
+			require.NoError(t, err)
+			assert.Equal(t, h.first, f.commitID)
+
+			_, err = newFs(t, srv.url(), "missing", "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSmartErrors(t *testing.T) {
+	h := newTestHistory()
+	srv := newFakeServer(t, h)
+	defer srv.close()
+
+	// a corrupt pack is detected
+	srv.pack[len(srv.pack)/2] ^= 0xff
+	_, err := newFs(t, srv.url(), "main", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+	srv.pack[len(srv.pack)/2] ^= 0xff
+
+	// a server without the smart protocol
+	_, err = newFs(t, srv.srv.URL+"/missing.git", "main", "")
+	assert.Error(t, err)
+
+	// authentication
+	srv.user, srv.pass = "user", "secret"
+	_, err = newFs(t, srv.url(), "old", "")
+	assert.Error(t, err)
+	f, err := NewFs(context.Background(), "test", "", configmap.Simple{
+		"remote": srv.url(),
+		"ref":    "old",
+		"user":   "user",
+		"pass":   obscure.MustObscure("secret"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, h.first, f.(*Fs).commitID)
+}
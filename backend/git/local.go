@@ -0,0 +1,449 @@
+package git
+
+// This reads objects and refs from a repository on the local disk.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// objectStore reads objects from a repository
+type objectStore interface {
+	// read returns the type and contents of an object
+	read(id oid) (objectType, []byte, error)
+	// stat returns the type and size of an object
+	stat(id oid) (objectType, int64, error)
+	// open returns a reader for the contents of an object
+	open(id oid) (io.ReadCloser, error)
+}
+
+// objectDir is a directory of loose objects and packs
+type objectDir struct {
+	path  string
+	packs map[string]*localPack // by path of the index
+}
+
+// localPack is a pack with its index
+type localPack struct {
+	*packFile
+	idx  *packIndex
+	file *os.File
+}
+
+// localRepo is a repository on the local disk
+type localRepo struct {
+	gitDir    string // directory with HEAD in
+	commonDir string // directory with the refs and objects in
+	dirs      []*objectDir
+
+	mu sync.Mutex // protects the packs in dirs
+}
+
+// findGitDir returns the git directory for the repository or working
+// tree at root
+func findGitDir(root string) (string, error) {
+	dotGit := filepath.Join(root, ".git")
+	fi, err := os.Stat(dotGit)
+	if err == nil && fi.IsDir() {
+		return dotGit, nil
+	}
+	if err == nil {
+		// a linked working tree has a .git file pointing
+		// to the git directory
+		data, err := ioutil.ReadFile(dotGit)
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir: ") {
+			return "", errors.Errorf("bad .git file %q", dotGit)
+		}
+		gitDir := line[8:]
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(root, gitDir)
+		}
+		return gitDir, nil
+	}
+	// a bare repository
+	if _, err := os.Stat(filepath.Join(root, "HEAD")); err == nil {
+		if _, err := os.Stat(filepath.Join(root, "objects")); err == nil {
+			return root, nil
+		}
+	}
+	return "", errors.Errorf("%q isn't a git repository", root)
+}
+
+// openLocal opens the repository at root
+func openLocal(root string) (*localRepo, error) {
+	gitDir, err := findGitDir(root)
+	if err != nil {
+		return nil, err
+	}
+	r := &localRepo{
+		gitDir:    gitDir,
+		commonDir: gitDir,
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		r.commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(r.commonDir) {
+			r.commonDir = filepath.Join(gitDir, r.commonDir)
+		}
+	}
+	err = r.addObjectDir(filepath.Join(r.commonDir, "objects"), 0)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// addObjectDir adds the objects directory at path and any
+// alternates it has
+func (r *localRepo) addObjectDir(path string, depth int) error {
+	if depth > 5 {
+		return errors.New("too many nested alternates")
+	}
+	d := &objectDir{
+		path:  path,
+		packs: map[string]*localPack{},
+	}
+	r.dirs = append(r.dirs, d)
+	err := r.scanPacks(d)
+	if err != nil {
+		return err
+	}
+	alternates, err := ioutil.ReadFile(filepath.Join(path, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(alternates), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(path, line)
+		}
+		err = r.addObjectDir(line, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanPacks opens any packs in d which aren't open already
+func (r *localRepo) scanPacks(d *objectDir) error {
+	idxs, err := filepath.Glob(filepath.Join(d.path, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, idxPath := range idxs {
+		if _, found := d.packs[idxPath]; found {
+			continue
+		}
+		pack, err := r.openPack(idxPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open pack %q", idxPath)
+		}
+		d.packs[idxPath] = pack
+	}
+	return nil
+}
+
+// rescan looks for new packs - objects may have been packed by git
+// gc since the repository was opened
+func (r *localRepo) rescan() error {
+	for _, d := range r.dirs {
+		err := r.scanPacks(d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openPack opens the pack with the index at idxPath
+func (r *localRepo) openPack(idxPath string) (*localPack, error) {
+	data, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := loadPackIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	p, _, err := newPackFile(file, fi.Size())
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	p.find = idx.find
+	p.readBase = r.readOnce
+	return &localPack{packFile: p, idx: idx, file: file}, nil
+}
+
+// findPacked returns the pack with id in it and its offset
+func (r *localRepo) findPacked(id oid) (*localPack, int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.dirs {
+		for _, p := range d.packs {
+			if offset, ok := p.idx.find(id); ok {
+				return p, offset, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// openLoose opens the loose object id returning a reader for its
+// contents after the header
+func (r *localRepo) openLoose(id oid) (io.ReadCloser, objectType, int64, error) {
+	hexID := id.String()
+	for _, d := range r.dirs {
+		file, err := os.Open(filepath.Join(d.path, hexID[:2], hexID[2:]))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+		zr, err := zlib.NewReader(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, 0, errors.Wrapf(err, "bad loose object %v", id)
+		}
+		br := bufio.NewReader(zr)
+		header, err := br.ReadString(0)
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, 0, errors.Wrapf(err, "bad loose object %v", id)
+		}
+		fields := strings.Fields(strings.TrimSuffix(header, "\x00"))
+		if len(fields) != 2 {
+			_ = file.Close()
+			return nil, 0, 0, errors.Errorf("bad loose object header %q", header)
+		}
+		typ, err := parseType(fields[0])
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, 0, err
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, 0, errors.Errorf("bad loose object header %q", header)
+		}
+		in := readers.NewLimitedReadCloser(ioutil.NopCloser(br), size)
+		return struct {
+			io.Reader
+			io.Closer
+		}{in, file}, typ, size, nil
+	}
+	return nil, 0, 0, errObjectMissing
+}
+
+// retry calls fn again after looking for new packs if it couldn't
+// find the object
+func (r *localRepo) retry(fn func() error) error {
+	err := fn()
+	if err == errObjectMissing {
+		if rescanErr := r.rescan(); rescanErr != nil {
+			return rescanErr
+		}
+		err = fn()
+	}
+	return err
+}
+
+// read returns the type and contents of an object
+func (r *localRepo) read(id oid) (typ objectType, data []byte, err error) {
+	err = r.retry(func() error {
+		typ, data, err = r.readOnce(id)
+		return err
+	})
+	return typ, data, err
+}
+
+// readOnce reads the object from the packs and loose objects found
+// so far
+func (r *localRepo) readOnce(id oid) (objectType, []byte, error) {
+	if p, offset, ok := r.findPacked(id); ok {
+		return p.readAt(offset)
+	}
+	in, typ, size, err := r.openLoose(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	data := make([]byte, size)
+	_, err = io.ReadFull(in, data)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "failed to read object %v", id)
+	}
+	return typ, data, nil
+}
+
+// stat returns the type and size of an object
+func (r *localRepo) stat(id oid) (typ objectType, size int64, err error) {
+	err = r.retry(func() error {
+		if p, offset, ok := r.findPacked(id); ok {
+			typ, size, err = p.statAt(offset)
+			return err
+		}
+		var in io.ReadCloser
+		in, typ, size, err = r.openLoose(id)
+		if err != nil {
+			return err
+		}
+		return in.Close()
+	})
+	return typ, size, err
+}
+
+// open returns a reader for the contents of an object
+func (r *localRepo) open(id oid) (in io.ReadCloser, err error) {
+	err = r.retry(func() error {
+		if p, offset, ok := r.findPacked(id); ok {
+			in, err = p.openAt(offset)
+			return err
+		}
+		in, _, _, err = r.openLoose(id)
+		return err
+	})
+	return in, err
+}
+
+// readRef reads the ref called name returning false if not found
+//
+// Symbolic refs are followed.
+func (r *localRepo) readRef(name string, depth int) (id oid, found bool, err error) {
+	if depth > 5 {
+		return id, false, errors.Errorf("too many levels of symbolic refs at %q", name)
+	}
+	dir := r.commonDir
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/worktree/") || strings.HasPrefix(name, "refs/bisect/") {
+		dir = r.gitDir
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		line := strings.TrimSpace(string(data))
+		if strings.HasPrefix(line, "ref: ") {
+			return r.readRef(line[5:], depth+1)
+		}
+		id, err = parseOID(line)
+		return id, err == nil, err
+	}
+	// Look in packed-refs
+	data, err = ioutil.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return id, false, nil
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 2 && fields[1] == name {
+			id, err = parseOID(fields[0])
+			return id, err == nil, err
+		}
+	}
+	return id, false, nil
+}
+
+// matchPrefix returns the objects whose oid starts with the hex prefix
+func (r *localRepo) matchPrefix(prefix string) (ids []oid) {
+	seen := map[oid]struct{}{}
+	add := func(id oid) {
+		if _, found := seen[id]; !found {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.dirs {
+		names, _ := filepath.Glob(filepath.Join(d.path, prefix[:2], prefix[2:]+"*"))
+		for _, name := range names {
+			id, err := parseOID(prefix[:2] + filepath.Base(name))
+			if err == nil {
+				add(id)
+			}
+		}
+		for _, p := range d.packs {
+			for _, id := range p.idx.matchPrefix(prefix) {
+				add(id)
+			}
+		}
+	}
+	return ids
+}
+
+// resolve finds the object ref refers to
+//
+// ref may be a branch, tag or other ref, HEAD, or a full or
+// abbreviated object name, looked for in the same order as git does.
+func (r *localRepo) resolve(ref string) (oid, error) {
+	if len(ref) == 2*len(oid{}) && isHex(ref) {
+		return parseOID(ref)
+	}
+	if strings.Contains(ref, "..") {
+		return oid{}, errors.Errorf("bad ref %q", ref)
+	}
+	for _, name := range refCandidates(ref) {
+		id, found, err := r.readRef(name, 0)
+		if err != nil {
+			return id, err
+		}
+		if found {
+			return id, nil
+		}
+	}
+	if len(ref) >= 4 && isHex(ref) {
+		switch ids := r.matchPrefix(ref); len(ids) {
+		case 0:
+		case 1:
+			return ids[0], nil
+		default:
+			return oid{}, errors.Errorf("object name %q is ambiguous", ref)
+		}
+	}
+	return oid{}, errors.Errorf("ref %q not found", ref)
+}
+
+// refCandidates returns the full names ref might refer to in the
+// order git tries them
+func refCandidates(ref string) []string {
+	return []string{
+		ref,
+		"refs/" + ref,
+		"refs/tags/" + ref,
+		"refs/heads/" + ref,
+		"refs/remotes/" + ref,
+		"refs/remotes/" + ref + "/HEAD",
+	}
+}
@@ -0,0 +1,340 @@
+package git
+
+// This decodes git objects - commits, trees and tags - and the
+// deltas objects are stored as in packs.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// objectType is the type of a git object
+type objectType int8
+
+// Object types as used in packs
+const (
+	typeCommit   objectType = 1
+	typeTree     objectType = 2
+	typeBlob     objectType = 3
+	typeTag      objectType = 4
+	typeOfsDelta objectType = 6
+	typeRefDelta objectType = 7
+)
+
+// String returns the name of the type as used in object headers
+func (t objectType) String() string {
+	switch t {
+	case typeCommit:
+		return "commit"
+	case typeTree:
+		return "tree"
+	case typeBlob:
+		return "blob"
+	case typeTag:
+		return "tag"
+	case typeOfsDelta:
+		return "ofs-delta"
+	case typeRefDelta:
+		return "ref-delta"
+	}
+	return fmt.Sprintf("type(%d)", int(t))
+}
+
+// parseType parses the name of an object type
+func parseType(s string) (objectType, error) {
+	switch s {
+	case "commit":
+		return typeCommit, nil
+	case "tree":
+		return typeTree, nil
+	case "blob":
+		return typeBlob, nil
+	case "tag":
+		return typeTag, nil
+	}
+	return 0, errors.Errorf("unknown object type %q", s)
+}
+
+// oid is the SHA-1 name of a git object
+type oid [sha1.Size]byte
+
+// String returns the oid as hex
+func (id oid) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// parseOID parses a hex oid
+func parseOID(s string) (id oid, err error) {
+	if len(s) != 2*len(id) {
+		return id, errors.Errorf("bad object name %q", s)
+	}
+	_, err = hex.Decode(id[:], []byte(s))
+	if err != nil {
+		return id, errors.Errorf("bad object name %q", s)
+	}
+	return id, nil
+}
+
+// isHex returns true if s is all lower case hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// objectHeader returns the header which is hashed before the data
+func objectHeader(t objectType, size int64) []byte {
+	return []byte(t.String() + " " + strconv.FormatInt(size, 10) + "\x00")
+}
+
+// hashObject returns the oid of an object
+func hashObject(t objectType, data []byte) (id oid) {
+	h := sha1.New()
+	_, _ = h.Write(objectHeader(t, int64(len(data))))
+	_, _ = h.Write(data)
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// commit is the part of a commit object we use
+type commit struct {
+	tree oid       // root tree
+	time time.Time // committer time
+}
+
+// parseSignatureTime reads the time from "Name <email> 1600000000 +0100"
+func parseSignatureTime(s string) (time.Time, error) {
+	i := strings.LastIndexByte(s, '>')
+	if i < 0 {
+		return time.Time{}, errors.Errorf("bad signature %q", s)
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) != 2 {
+		return time.Time{}, errors.Errorf("bad signature time %q", s)
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("bad signature time %q", s)
+	}
+	offset, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return time.Time{}, errors.Errorf("bad signature time zone %q", s)
+	}
+	sign := 1
+	if offset < 0 {
+		sign, offset = -1, -offset
+	}
+	zone := time.FixedZone(fields[1], sign*((offset/100)*3600+(offset%100)*60))
+	return time.Unix(secs, 0).In(zone), nil
+}
+
+// parseCommit parses the headers of a commit object
+func parseCommit(data []byte) (c commit, err error) {
+	foundTree := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			// end of headers
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			c.tree, err = parseOID(line[5:])
+			if err != nil {
+				return c, err
+			}
+			foundTree = true
+		case strings.HasPrefix(line, "committer "):
+			c.time, err = parseSignatureTime(line[10:])
+			if err != nil {
+				return c, err
+			}
+		}
+	}
+	if !foundTree {
+		return c, errors.New("commit has no tree")
+	}
+	return c, nil
+}
+
+// parseTag returns the object an annotated tag points to
+func parseTag(data []byte) (target oid, t objectType, err error) {
+	foundObject := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "object "):
+			target, err = parseOID(line[7:])
+			if err != nil {
+				return target, t, err
+			}
+			foundObject = true
+		case strings.HasPrefix(line, "type "):
+			t, err = parseType(line[5:])
+			if err != nil {
+				return target, t, err
+			}
+		}
+	}
+	if !foundObject || t == 0 {
+		return target, t, errors.New("bad tag object")
+	}
+	return target, t, nil
+}
+
+// Modes of the entries in a tree
+const (
+	modeTree       = 0040000
+	modeFile       = 0100644
+	modeExecutable = 0100755
+	modeSymlink    = 0120000
+	modeSubmodule  = 0160000
+)
+
+// treeEntry is an entry in a tree
+type treeEntry struct {
+	mode uint32
+	name string
+	id   oid
+}
+
+// isDir returns true if the entry is a tree
+func (e *treeEntry) isDir() bool {
+	return e.mode == modeTree
+}
+
+// isFile returns true if the entry is a regular file
+func (e *treeEntry) isFile() bool {
+	return e.mode == modeFile || e.mode == modeExecutable
+}
+
+// parseTree parses the entries of a tree object
+//
+// Each entry is "mode name\0" followed by the binary oid.
+func parseTree(data []byte) (entries []treeEntry, err error) {
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, errors.New("bad tree entry: no mode")
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return nil, errors.Errorf("bad tree entry mode %q", data[:space])
+		}
+		data = data[space+1:]
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 || len(data) < nul+1+len(oid{}) {
+			return nil, errors.New("bad tree entry: truncated")
+		}
+		entry := treeEntry{
+			mode: uint32(mode),
+			name: string(data[:nul]),
+		}
+		copy(entry.id[:], data[nul+1:])
+		entries = append(entries, entry)
+		data = data[nul+1+len(oid{}):]
+	}
+	return entries, nil
+}
+
+// deltaVarint reads a size from the start of a delta
+func deltaVarint(delta []byte) (size int64, n int, err error) {
+	var shift uint
+	for {
+		if n >= len(delta) {
+			return 0, 0, errors.New("delta truncated")
+		}
+		c := delta[n]
+		n++
+		size |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return size, n, nil
+		}
+	}
+}
+
+// deltaSizes returns the base and result sizes from the header of a
+// delta and the length of the header
+func deltaSizes(delta []byte) (baseSize, resultSize int64, n int, err error) {
+	baseSize, n1, err := deltaVarint(delta)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	resultSize, n2, err := deltaVarint(delta[n1:])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return baseSize, resultSize, n1 + n2, nil
+}
+
+// applyDelta makes an object from its base and a delta
+//
+// The delta is a list of instructions to copy a range of the base
+// or to insert literal data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, resultSize, n, err := deltaSizes(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != int64(len(base)) {
+		return nil, errors.Errorf("delta base size %d doesn't match base %d", baseSize, len(base))
+	}
+	result := make([]byte, 0, resultSize)
+	delta = delta[n:]
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 != 0 {
+			// copy from base - the bits of op say which
+			// bytes of the offset and size follow
+			var offset, size uint32
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("delta copy truncated")
+				}
+				if i < 4 {
+					offset |= uint32(delta[0]) << (8 * i)
+				} else {
+					size |= uint32(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			end := uint64(offset) + uint64(size)
+			if end > uint64(len(base)) {
+				return nil, errors.New("delta copy out of range")
+			}
+			result = append(result, base[offset:end]...)
+		} else if op != 0 {
+			// insert the next op bytes
+			if int(op) > len(delta) {
+				return nil, errors.New("delta insert truncated")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		} else {
+			return nil, errors.New("delta has reserved instruction")
+		}
+	}
+	if int64(len(result)) != resultSize {
+		return nil, errors.Errorf("delta result size %d doesn't match expected %d", len(result), resultSize)
+	}
+	return result, nil
+}
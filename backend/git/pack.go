@@ -0,0 +1,484 @@
+package git
+
+// This reads objects from pack files, using a version 2 pack index
+// (.idx) for the packs in local repositories or an index built by
+// scanning the pack for packs fetched from a server.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+const (
+	packHeaderSize = 12                        // "PACK", version, object count
+	maxHeaderSize  = 32                        // most bytes an entry header can take
+	maxCacheSize   = 32 * 1024 * 1024          // most bytes of delta bases to cache
+	maxDeltaDepth  = 10000                     // deepest delta chain to follow
+	idxHeaderSize  = 8 + 256*4                 // magic, version and fanout table
+	idxMagic       = "\xfftOc\x00\x00\x00\x02" // magic and version 2
+)
+
+// errObjectMissing is returned when an object isn't in the store
+var errObjectMissing = errors.New("object not found")
+
+// packIndex is a version 2 pack index
+//
+// After the header it has a sorted table of the oids, their CRCs,
+// their 4 byte offsets then the 8 byte offsets of any which didn't
+// fit.
+type packIndex struct {
+	data  []byte
+	count int
+}
+
+// loadPackIndex checks the index and returns it
+func loadPackIndex(data []byte) (*packIndex, error) {
+	if len(data) < idxHeaderSize || string(data[:8]) != idxMagic {
+		return nil, errors.New("pack index isn't version 2")
+	}
+	idx := &packIndex{
+		data:  data,
+		count: int(binary.BigEndian.Uint32(data[idxHeaderSize-4:])),
+	}
+	if len(data) < idxHeaderSize+idx.count*(sha1.Size+4+4)+2*sha1.Size {
+		return nil, errors.New("pack index truncated")
+	}
+	return idx, nil
+}
+
+// fanout returns the number of oids with a first byte less than or
+// equal to b
+func (idx *packIndex) fanout(b int) int {
+	if b < 0 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(idx.data[8+4*b:]))
+}
+
+// oidAt returns the i-th oid
+func (idx *packIndex) oidAt(i int) (id oid) {
+	copy(id[:], idx.data[idxHeaderSize+i*sha1.Size:])
+	return id
+}
+
+// offsetAt returns the pack offset of the i-th oid
+func (idx *packIndex) offsetAt(i int) int64 {
+	offsets := idxHeaderSize + idx.count*(sha1.Size+4)
+	offset := binary.BigEndian.Uint32(idx.data[offsets+4*i:])
+	if offset&0x80000000 == 0 {
+		return int64(offset)
+	}
+	large := offsets + 4*idx.count + 8*int(offset&0x7fffffff)
+	return int64(binary.BigEndian.Uint64(idx.data[large:]))
+}
+
+// find returns the offset of id in the pack
+func (idx *packIndex) find(id oid) (int64, bool) {
+	lo, hi := idx.fanout(int(id[0])-1), idx.fanout(int(id[0]))
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		other := idx.oidAt(lo + i)
+		return bytes.Compare(other[:], id[:]) >= 0
+	})
+	if i < hi && idx.oidAt(i) == id {
+		return idx.offsetAt(i), true
+	}
+	return 0, false
+}
+
+// matchPrefix returns the oids starting with the hex prefix
+func (idx *packIndex) matchPrefix(prefix string) (ids []oid) {
+	for i := 0; i < idx.count; i++ {
+		id := idx.oidAt(i)
+		if strings.HasPrefix(id.String(), prefix) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// packEntry is the header of an object in a pack
+type packEntry struct {
+	offset     int64      // offset of the entry
+	typ        objectType // type of the entry
+	size       int64      // inflated size of the data
+	dataOffset int64      // offset of the compressed data
+	baseOffset int64      // offset of the base of an ofs-delta
+	baseID     oid        // oid of the base of a ref-delta
+}
+
+// parseEntryHeader parses the header of the entry at offset reading
+// its bytes with next
+//
+// The header has the type and size in a variable length integer,
+// followed by the base of a delta.
+func parseEntryHeader(offset int64, next func() (byte, error)) (e packEntry, err error) {
+	e.offset = offset
+	var n int64
+	c, err := next()
+	if err != nil {
+		return e, err
+	}
+	n++
+	e.typ = objectType((c >> 4) & 7)
+	e.size = int64(c & 15)
+	shift := uint(4)
+	for c&0x80 != 0 {
+		if c, err = next(); err != nil {
+			return e, err
+		}
+		n++
+		e.size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	switch e.typ {
+	case typeCommit, typeTree, typeBlob, typeTag:
+	case typeOfsDelta:
+		// The offset back to the base is big endian with one
+		// added for each continuation byte
+		if c, err = next(); err != nil {
+			return e, err
+		}
+		n++
+		back := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = next(); err != nil {
+				return e, err
+			}
+			n++
+			back = ((back + 1) << 7) | int64(c&0x7f)
+		}
+		e.baseOffset = offset - back
+		if back <= 0 || e.baseOffset < packHeaderSize {
+			return e, errors.New("bad delta base offset")
+		}
+	case typeRefDelta:
+		for i := range e.baseID {
+			if e.baseID[i], err = next(); err != nil {
+				return e, err
+			}
+		}
+		n += sha1.Size
+	default:
+		return e, errors.Errorf("bad object type %d in pack", e.typ)
+	}
+	e.dataOffset = offset + n
+	return e, nil
+}
+
+// cachedObject is a resolved object kept to use as a delta base
+type cachedObject struct {
+	typ  objectType
+	data []byte
+}
+
+// packFile reads objects from a pack
+type packFile struct {
+	r        io.ReaderAt
+	size     int64
+	find     func(id oid) (int64, bool)               // find an object in this pack
+	readBase func(id oid) (objectType, []byte, error) // read a ref-delta base from elsewhere
+
+	mu        sync.Mutex
+	cache     map[int64]cachedObject
+	cacheSize int
+}
+
+// newPackFile checks the header of the pack and returns a packFile
+// to read it
+func newPackFile(r io.ReaderAt, size int64) (*packFile, int, error) {
+	var header [packHeaderSize]byte
+	_, err := r.ReadAt(header[:], 0)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read pack header")
+	}
+	if string(header[:4]) != "PACK" {
+		return nil, 0, errors.New("not a pack file")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != 2 && version != 3 {
+		return nil, 0, errors.Errorf("unsupported pack version %d", version)
+	}
+	p := &packFile{
+		r:     r,
+		size:  size,
+		cache: make(map[int64]cachedObject),
+	}
+	return p, int(binary.BigEndian.Uint32(header[8:])), nil
+}
+
+// entryAt reads the header of the entry at offset
+func (p *packFile) entryAt(offset int64) (e packEntry, err error) {
+	if offset < packHeaderSize || offset >= p.size {
+		return e, errors.Errorf("pack offset %d out of range", offset)
+	}
+	var buf [maxHeaderSize]byte
+	n, err := p.r.ReadAt(buf[:], offset)
+	if n == 0 && err != nil {
+		return e, errors.Wrap(err, "failed to read pack entry")
+	}
+	i := 0
+	e, err = parseEntryHeader(offset, func() (byte, error) {
+		if i >= n {
+			return 0, io.ErrUnexpectedEOF
+		}
+		i++
+		return buf[i-1], nil
+	})
+	return e, err
+}
+
+// openData returns a reader for the inflated data of the entry
+func (p *packFile) openData(e packEntry) (io.ReadCloser, error) {
+	zr, err := zlib.NewReader(io.NewSectionReader(p.r, e.dataOffset, p.size-e.dataOffset))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to inflate pack entry")
+	}
+	return readers.NewLimitedReadCloser(zr, e.size), nil
+}
+
+// inflate reads the data of the entry
+func (p *packFile) inflate(e packEntry) ([]byte, error) {
+	in, err := p.openData(e)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, e.size)
+	_, err = io.ReadFull(in, data)
+	_ = in.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to inflate pack entry")
+	}
+	return data, nil
+}
+
+// readAt reads the object at offset resolving any deltas
+func (p *packFile) readAt(offset int64) (objectType, []byte, error) {
+	return p.readAtDepth(offset, 0)
+}
+
+// readAtDepth reads the object at offset which is depth deltas from
+// the object asked for
+func (p *packFile) readAtDepth(offset int64, depth int) (objectType, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, errors.New("delta chain too long")
+	}
+	if depth > 0 {
+		p.mu.Lock()
+		cached, ok := p.cache[offset]
+		p.mu.Unlock()
+		if ok {
+			return cached.typ, cached.data, nil
+		}
+	}
+	e, err := p.entryAt(offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := p.inflate(e)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := e.typ
+	if typ == typeOfsDelta || typ == typeRefDelta {
+		var base []byte
+		if typ == typeOfsDelta {
+			typ, base, err = p.readAtDepth(e.baseOffset, depth+1)
+		} else if baseOffset, ok := p.find(e.baseID); ok {
+			typ, base, err = p.readAtDepth(baseOffset, depth+1)
+		} else if p.readBase != nil {
+			typ, base, err = p.readBase(e.baseID)
+		} else {
+			err = errObjectMissing
+		}
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "failed to read delta base")
+		}
+		data, err = applyDelta(base, data)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if depth > 0 {
+		// cache the objects used as bases as they are likely
+		// to be used again
+		p.mu.Lock()
+		if p.cacheSize+len(data) > maxCacheSize {
+			p.cache = make(map[int64]cachedObject)
+			p.cacheSize = 0
+		}
+		p.cache[offset] = cachedObject{typ: typ, data: data}
+		p.cacheSize += len(data)
+		p.mu.Unlock()
+	}
+	return typ, data, nil
+}
+
+// statAt returns the type and size of the object at offset without
+// reading all of it
+func (p *packFile) statAt(offset int64) (objectType, int64, error) {
+	e, err := p.entryAt(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	if e.typ != typeOfsDelta && e.typ != typeRefDelta {
+		return e.typ, e.size, nil
+	}
+	// The size is at the start of the delta
+	in, err := p.openData(e)
+	if err != nil {
+		return 0, 0, err
+	}
+	var buf [2 * 10]byte
+	n, _ := io.ReadFull(in, buf[:])
+	_ = in.Close()
+	_, size, _, err := deltaSizes(buf[:n])
+	if err != nil {
+		return 0, 0, err
+	}
+	// Follow the chain to find the type
+	for depth := 0; e.typ == typeOfsDelta || e.typ == typeRefDelta; depth++ {
+		if depth > maxDeltaDepth {
+			return 0, 0, errors.New("delta chain too long")
+		}
+		if e.typ == typeOfsDelta {
+			e, err = p.entryAt(e.baseOffset)
+		} else if baseOffset, ok := p.find(e.baseID); ok {
+			e, err = p.entryAt(baseOffset)
+		} else if p.readBase != nil {
+			var typ objectType
+			typ, _, err = p.readBase(e.baseID)
+			return typ, size, err
+		} else {
+			return 0, 0, errObjectMissing
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return e.typ, size, nil
+}
+
+// openAt returns a reader for the contents of the object at offset
+//
+// Objects which aren't deltas are streamed, deltas are read into
+// memory.
+func (p *packFile) openAt(offset int64) (io.ReadCloser, error) {
+	e, err := p.entryAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	if e.typ != typeOfsDelta && e.typ != typeRefDelta {
+		return p.openData(e)
+	}
+	_, data, err := p.readAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// countingReader counts the bytes read through it
+//
+// It is a flate.Reader so zlib doesn't read past the end of each
+// compressed entry.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read bytes counting them
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadByte reads a single byte counting it
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// indexPack scans all the entries in the pack and returns the
+// offsets of the objects in it
+//
+// Objects which aren't deltas are hashed as they are read, then the
+// deltas are resolved.
+func indexPack(p *packFile, count int) (map[oid]int64, error) {
+	offsets := make(map[oid]int64, count)
+	p.find = func(id oid) (int64, bool) {
+		offset, ok := offsets[id]
+		return offset, ok
+	}
+	in := &countingReader{
+		r: bufio.NewReader(io.NewSectionReader(p.r, packHeaderSize, p.size-packHeaderSize)),
+		n: packHeaderSize,
+	}
+	var deltas []int64
+	for i := 0; i < count; i++ {
+		offset := in.n
+		e, err := parseEntryHeader(offset, in.ReadByte)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read pack entry %d", i)
+		}
+		zr, err := zlib.NewReader(in)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inflate pack entry %d", i)
+		}
+		var n int64
+		if e.typ == typeOfsDelta || e.typ == typeRefDelta {
+			deltas = append(deltas, offset)
+			n, err = io.Copy(ioutil.Discard, zr)
+		} else {
+			h := sha1.New()
+			_, _ = h.Write(objectHeader(e.typ, e.size))
+			n, err = io.Copy(h, zr)
+			var id oid
+			copy(id[:], h.Sum(nil))
+			offsets[id] = offset
+		}
+		if err == nil && n != e.size {
+			err = errors.Errorf("size %d doesn't match header %d", n, e.size)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inflate pack entry %d", i)
+		}
+		_ = zr.Close()
+	}
+	if in.n+sha1.Size != p.size {
+		return nil, errors.New("pack has trailing data")
+	}
+	// Resolve the deltas - ref-deltas may need bases which are
+	// deltas themselves so repeat until no progress is made
+	for len(deltas) > 0 {
+		var remaining []int64
+		var lastErr error
+		for _, offset := range deltas {
+			typ, data, err := p.readAt(offset)
+			if err != nil {
+				remaining = append(remaining, offset)
+				lastErr = err
+				continue
+			}
+			offsets[hashObject(typ, data)] = offset
+		}
+		if len(remaining) == len(deltas) {
+			return nil, errors.Wrap(lastErr, "failed to resolve deltas")
+		}
+		deltas = remaining
+	}
+	return offsets, nil
+}
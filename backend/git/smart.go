@@ -0,0 +1,315 @@
+package git
+
+// This fetches a commit from a server using the smart HTTP protocol
+// (version 0/1) which is what git uses for http:// and https://
+// remotes.
+//
+// The refs are read from info/refs then a shallow fetch of the
+// commit is POSTed to git-upload-pack which returns a pack with the
+// commit and all the trees and blobs in it.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	uploadPack = "git-upload-pack"
+	maxPktLen  = 65520 // largest pkt-line allowed
+)
+
+// Side band channels
+const (
+	bandData     = 1
+	bandProgress = 2
+	bandError    = 3
+)
+
+// errFlush is returned by readPkt when it reads a flush packet
+var errFlush = errors.New("flush packet")
+
+// pktLine encodes s as a pkt-line - a 4 digit hex length (including
+// the length itself) followed by the data
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+// pktFlush is the flush packet which ends a section
+const pktFlush = "0000"
+
+// readPkt reads a pkt-line from r
+//
+// It returns errFlush if it reads a flush packet.
+func readPkt(r io.Reader) ([]byte, error) {
+	var lenHex [4]byte
+	_, err := io.ReadFull(r, lenHex[:])
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(lenHex[:]), 16, 16)
+	if err != nil {
+		return nil, errors.Errorf("bad pkt-line length %q", lenHex)
+	}
+	if n == 0 {
+		return nil, errFlush
+	}
+	if n < 4 || n > maxPktLen {
+		return nil, errors.Errorf("bad pkt-line length %d", n)
+	}
+	data := make([]byte, n-4)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("ERR ")) {
+		return nil, errors.Errorf("server error: %s", strings.TrimSpace(string(data[4:])))
+	}
+	return data, nil
+}
+
+// remoteRefs are the refs a server advertises
+type remoteRefs struct {
+	refs   map[string]oid      // ref name to oid
+	peeled map[string]oid      // tag name to the commit it points to
+	caps   map[string]struct{} // capabilities of the server
+}
+
+// parseRefs parses the response from info/refs
+func parseRefs(r io.Reader) (*remoteRefs, error) {
+	rr := &remoteRefs{
+		refs:   map[string]oid{},
+		peeled: map[string]oid{},
+		caps:   map[string]struct{}{},
+	}
+	line, err := readPkt(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read refs")
+	}
+	if string(bytes.TrimSpace(line)) != "# service="+uploadPack {
+		return nil, errors.New("server doesn't support the smart HTTP protocol")
+	}
+	_, err = readPkt(r)
+	if err != errFlush {
+		return nil, errors.New("bad service header from server")
+	}
+	first := true
+	for {
+		line, err = readPkt(r)
+		if err == errFlush {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read refs")
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		if first {
+			// The capabilities are after a NUL on the first line
+			first = false
+			if i := strings.IndexByte(text, 0); i >= 0 {
+				for _, c := range strings.Fields(text[i+1:]) {
+					rr.caps[c] = struct{}{}
+				}
+				text = text[:i]
+			}
+		}
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("bad ref line %q", text)
+		}
+		id, err := parseOID(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		name := fields[1]
+		if name == "capabilities^{}" {
+			// empty repository
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") {
+			rr.peeled[strings.TrimSuffix(name, "^{}")] = id
+		} else {
+			rr.refs[name] = id
+		}
+	}
+	return rr, nil
+}
+
+// has returns true if the server has the capability
+func (rr *remoteRefs) has(capability string) bool {
+	_, ok := rr.caps[capability]
+	return ok
+}
+
+// resolve finds the commit ref refers to
+//
+// ref may be a branch, tag or other ref, HEAD, or a full object name
+// or an abbreviation of an advertised one.
+func (rr *remoteRefs) resolve(ref string) (oid, error) {
+	if len(ref) == 2*len(oid{}) && isHex(ref) {
+		return parseOID(ref)
+	}
+	for _, name := range refCandidates(ref) {
+		if id, ok := rr.peeled[name]; ok {
+			return id, nil
+		}
+		if id, ok := rr.refs[name]; ok {
+			return id, nil
+		}
+	}
+	if len(ref) >= 4 && isHex(ref) {
+		var found []oid
+		seen := map[oid]struct{}{}
+		for _, ids := range []map[string]oid{rr.refs, rr.peeled} {
+			for _, id := range ids {
+				if _, ok := seen[id]; !ok && strings.HasPrefix(id.String(), ref) {
+					seen[id] = struct{}{}
+					found = append(found, id)
+				}
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		} else if len(found) > 1 {
+			return oid{}, errors.Errorf("object name %q is ambiguous", ref)
+		}
+	}
+	return oid{}, errors.Errorf("ref %q not found on server", ref)
+}
+
+// smartClient talks to a git server using the smart HTTP protocol
+type smartClient struct {
+	srv *rest.Client
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	body, err := rest.ReadBody(resp)
+	if err != nil {
+		body = nil
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) > 256 || message == "" {
+		message = resp.Status
+	}
+	return errors.Errorf("git server error: %s", message)
+}
+
+// lsRefs reads the refs the server has
+func (c *smartClient) lsRefs(ctx context.Context) (*remoteRefs, error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/info/refs",
+		Parameters: map[string][]string{"service": {uploadPack}},
+	}
+	resp, err := c.srv.Call(ctx, &opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list refs")
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-"+uploadPack+"-advertisement" {
+		return nil, errors.Errorf("server doesn't support the smart HTTP protocol (content type %q)", contentType)
+	}
+	return parseRefs(bufio.NewReader(resp.Body))
+}
+
+// fetch fetches a pack containing the commit want and everything in
+// its tree writing it to out
+func (c *smartClient) fetch(ctx context.Context, rr *remoteRefs, want oid, out io.Writer) (err error) {
+	var caps []string
+	for _, capability := range []string{"ofs-delta", "side-band-64k", "no-progress"} {
+		if rr.has(capability) {
+			caps = append(caps, capability)
+		}
+	}
+	shallow := rr.has("shallow")
+	if shallow {
+		caps = append(caps, "shallow")
+	}
+	caps = append(caps, "agent=rclone/"+fs.Version)
+	var request strings.Builder
+	request.WriteString(pktLine("want " + want.String() + " " + strings.Join(caps, " ") + "\n"))
+	if shallow {
+		request.WriteString(pktLine("deepen 1\n"))
+	}
+	request.WriteString(pktFlush)
+	request.WriteString(pktLine("done\n"))
+	opts := rest.Opts{
+		Method:      "POST",
+		Path:        "/" + uploadPack,
+		Body:        strings.NewReader(request.String()),
+		ContentType: "application/x-" + uploadPack + "-request",
+		ExtraHeaders: map[string]string{
+			"Accept": "application/x-" + uploadPack + "-result",
+		},
+	}
+	resp, err := c.srv.Call(ctx, &opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch")
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	in := bufio.NewReader(resp.Body)
+	if shallow {
+		// The shallow commits are listed first
+		for {
+			_, err = readPkt(in)
+			if err == errFlush {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "failed to read shallow list")
+			}
+		}
+	}
+	line, err := readPkt(in)
+	if err != nil {
+		return errors.Wrap(err, "failed to read fetch response")
+	}
+	if string(bytes.TrimSpace(line)) != "NAK" {
+		return errors.Errorf("unexpected fetch response %q", line)
+	}
+	if !rr.has("side-band-64k") {
+		_, err = io.Copy(out, in)
+	} else {
+		err = readSideBand(in, out)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read pack")
+	}
+	return nil
+}
+
+// readSideBand copies the data channel of a side band stream to out
+func readSideBand(in io.Reader, out io.Writer) error {
+	for {
+		data, err := readPkt(in)
+		if err == errFlush {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case bandData:
+			_, err = out.Write(data[1:])
+			if err != nil {
+				return err
+			}
+		case bandProgress:
+			fs.Debugf(nil, "git server: %s", strings.TrimSpace(string(data[1:])))
+		case bandError:
+			return errors.Errorf("server error: %s", strings.TrimSpace(string(data[1:])))
+		default:
+			return errors.Errorf("bad side band channel %d", data[0])
+		}
+	}
+}
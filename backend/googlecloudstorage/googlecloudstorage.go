@@ -864,6 +864,20 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	return dstObj, nil
 }
 
+// CanServerSideFrom returns true if objects in src can be copied to f
+// server-side.
+//
+// Rewrites are done with the credentials of the destination so this
+// is possible if both remotes use the same service account, which can
+// then copy between buckets in different projects it has access to.
+func (f *Fs) CanServerSideFrom(ctx context.Context, src fs.Info) bool {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return false
+	}
+	return f.opt.ServiceAccountCredentials != "" && f.opt.ServiceAccountCredentials == srcFs.opt.ServiceAccountCredentials
+}
+
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
 	return hash.Set(hash.MD5)
@@ -1137,10 +1151,11 @@ func (o *Object) MimeType(ctx context.Context) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
+	_ fs.Fs                   = &Fs{}
+	_ fs.Copier               = &Fs{}
+	_ fs.ServerSideNegotiator = &Fs{}
+	_ fs.PutStreamer          = &Fs{}
+	_ fs.ListRer              = &Fs{}
+	_ fs.Object               = &Object{}
+	_ fs.MimeTyper            = &Object{}
 )
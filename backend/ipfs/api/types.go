@@ -0,0 +1,101 @@
+// Package api contains definitions for using the IPFS (Kubo) RPC API
+package api
+
+import "strings"
+
+// Error is returned by the API when a command fails
+type Error struct {
+	Message string `json:"Message"`
+	Code    int    `json:"Code"`
+	Type    string `json:"Type"`
+}
+
+// Error satisfies the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NotFound returns true if the error is because the path doesn't exist
+func (e *Error) NotFound() bool {
+	return strings.Contains(e.Message, "does not exist") ||
+		strings.Contains(e.Message, "no link named")
+}
+
+// Types returned by files/stat
+const (
+	StatTypeFile      = "file"
+	StatTypeDirectory = "directory"
+)
+
+// Stat is returned by files/stat
+type Stat struct {
+	Hash           string `json:"Hash"`
+	Size           int64  `json:"Size"`
+	CumulativeSize int64  `json:"CumulativeSize"`
+	Type           string `json:"Type"`
+}
+
+// Types of the entries returned by files/ls
+const (
+	FilesTypeFile      = 0
+	FilesTypeDirectory = 1
+)
+
+// FilesEntry is a file or directory in an MFS directory
+type FilesEntry struct {
+	Name string `json:"Name"`
+	Type int    `json:"Type"`
+	Size int64  `json:"Size"`
+	Hash string `json:"Hash"`
+}
+
+// FilesList is returned by files/ls
+type FilesList struct {
+	Entries []FilesEntry `json:"Entries"`
+}
+
+// UnixFS types of the links returned by ls
+const (
+	LinkTypeRaw       = 0
+	LinkTypeDirectory = 1
+	LinkTypeFile      = 2
+	LinkTypeHAMTShard = 5
+)
+
+// Link is a file or directory in an immutable directory
+type Link struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+	Size int64  `json:"Size"`
+	Type int    `json:"Type"`
+}
+
+// LsObject is a directory listed by ls
+type LsObject struct {
+	Hash  string `json:"Hash"`
+	Links []Link `json:"Links"`
+}
+
+// LsResult is returned by ls
+type LsResult struct {
+	Objects []LsObject `json:"Objects"`
+}
+
+// AddResult is returned by add
+type AddResult struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+	Size string `json:"Size"`
+}
+
+// ResolveResult is returned by name/resolve
+type ResolveResult struct {
+	Path string `json:"Path"`
+}
+
+// RepoStat is returned by repo/stat
+type RepoStat struct {
+	RepoSize   int64 `json:"RepoSize"`
+	StorageMax int64 `json:"StorageMax"`
+	NumObjects int64 `json:"NumObjects"`
+}
@@ -0,0 +1,904 @@
+// Package ipfs provides an interface to IPFS through the RPC API of
+// an IPFS node such as Kubo.
+//
+// Paths starting /ipfs/ or /ipns/ are immutable and read only. All
+// other paths are in the Mutable File System (MFS) of the node which
+// files are written to by adding them to IPFS then copying their CID
+// into place.
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/ipfs/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	minSleep      = 10 * time.Millisecond
+	apiPath       = "/api/v0"
+	streamErrorHd = "X-Stream-Error" // trailer set if a streamed response fails
+)
+
+var errorReadOnly = errors.New("IPFS paths are immutable - use an MFS path to write")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "ipfs",
+		Description: "IPFS",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "url",
+			Help: `URL of the RPC API of the IPFS node
+
+This is the API (normally port 5001) not the gateway.`,
+			Default: "http://127.0.0.1:5001",
+			Examples: []fs.OptionExample{{
+				Value: "http://127.0.0.1:5001",
+				Help:  "Connect to the IPFS node running on this machine",
+			}},
+		}, {
+			Name: "user",
+			Help: "User name if the API needs basic auth",
+		}, {
+			Name:       "pass",
+			Help:       "Password if the API needs basic auth",
+			IsPassword: true,
+		}, {
+			Name: "bearer_token",
+			Help: "Bearer token instead of user/pass if the API needs one",
+		}, {
+			Name: "pin",
+			Help: `Pin the files which are uploaded
+
+The files are referenced from MFS so the node keeps them anyway, but
+pinning them too means they stay on the node after they are deleted
+or overwritten in MFS.`,
+			Default:  true,
+			Advanced: true,
+		}, {
+			Name: "cid_version",
+			Help: `CID version to add files with
+
+Version 1 CIDs use raw leaves which share blocks with files added by
+other tools using the defaults.`,
+			Default:  1,
+			Advanced: true,
+			Examples: []fs.OptionExample{{
+				Value: "0",
+				Help:  "CID version 0 (Qm...)",
+			}, {
+				Value: "1",
+				Help:  "CID version 1 (bafy...)",
+			}},
+		}, {
+			Name:     config.ConfigEncoding,
+			Help:     config.ConfigEncodingHelp,
+			Advanced: true,
+			// Encode invalid UTF-8 bytes as json doesn't handle them properly.
+			Default: (encoder.Base |
+				encoder.EncodeInvalidUtf8),
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	URL         string               `config:"url"`
+	User        string               `config:"user"`
+	Pass        string               `config:"pass"`
+	BearerToken string               `config:"bearer_token"`
+	Pin         bool                 `config:"pin"`
+	CIDVersion  int                  `config:"cid_version"`
+	Enc         encoder.MultiEncoder `config:"encoding"`
+}
+
+// Fs represents a path in IPFS
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // parsed options
+	features  *fs.Features // optional features
+	srv       *rest.Client // the connection to the API
+	pacer     *fs.Pacer    // pacer for API calls
+	base      string       // absolute IPFS or MFS path of the root
+	immutable bool         // set if base is an /ipfs/ path
+}
+
+// Object describes a file
+type Object struct {
+	fs     *Fs    // what this object is part of
+	remote string // The remote path
+	size   int64  // size of the object
+	cid    string // CID of the contents
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	if f.immutable {
+		return fmt.Sprintf("IPFS path %s", f.base)
+	}
+	return fmt.Sprintf("IPFS MFS path %s", f.base)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+//
+// The API returns 500 for all command errors so that isn't retried.
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+func shouldRetry(resp *http.Response, err error) (bool, error) {
+	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	body, err := rest.ReadBody(resp)
+	if err != nil {
+		body = nil
+	}
+	e := &api.Error{}
+	if body == nil || json.Unmarshal(body, e) != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+		if e.Message == "" {
+			e.Message = resp.Status
+		}
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		e.Message = fmt.Sprintf("%s (%s)", e.Message, resp.Status)
+	}
+	return e
+}
+
+// isNotFound returns true if err is because the path doesn't exist
+func isNotFound(err error) bool {
+	if e, ok := errors.Cause(err).(*api.Error); ok {
+		return e.NotFound()
+	}
+	return false
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.CIDVersion != 0 && opt.CIDVersion != 1 {
+		return nil, errors.Errorf("cid_version must be 0 or 1 not %d", opt.CIDVersion)
+	}
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse url")
+	}
+	u.Path = path.Join(u.Path, apiPath)
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name: name,
+		root: root,
+		opt:  *opt,
+		srv:  rest.NewClient(fshttp.NewClient(ctx)).SetRoot(u.String()),
+		// The API is usually local so there is no need to wait
+		// between successful calls
+		pacer: fs.NewPacer(ctx, pacer.NewS3(pacer.MinSleep(minSleep))),
+	}
+	f.srv.SetErrorHandler(errorHandler)
+	if opt.BearerToken != "" {
+		f.srv.SetHeader("Authorization", "Bearer "+opt.BearerToken)
+	} else if opt.User != "" {
+		pass, err := obscure.Reveal(opt.Pass)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't decrypt password")
+		}
+		f.srv.SetUserPass(opt.User, pass)
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+
+	err = f.setBase(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if f.immutable {
+		f.features.Copy = nil
+		f.features.Move = nil
+		f.features.DirMove = nil
+		f.features.Purge = nil
+		f.features.PutStream = nil
+	}
+
+	// Check to see if the root is a file
+	if root != "" {
+		info, err := f.stat(ctx, f.base)
+		if err == nil && info.Type == api.StatTypeFile {
+			newRoot := path.Dir(root)
+			if newRoot == "." {
+				newRoot = ""
+			}
+			f.root = newRoot
+			err = f.setBase(ctx, newRoot)
+			if err != nil {
+				return nil, err
+			}
+			return f, fs.ErrorIsFile
+		} else if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// setBase sets f.base and f.immutable from root resolving any IPNS
+// name into an immutable path
+func (f *Fs) setBase(ctx context.Context, root string) error {
+	f.immutable = false
+	f.base = "/" + f.opt.Enc.FromStandardPath(root)
+	parts := strings.SplitN(root, "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil
+	}
+	rest := ""
+	if len(parts) == 3 {
+		rest = f.opt.Enc.FromStandardPath(parts[2])
+	}
+	switch parts[0] {
+	case "ipfs":
+		f.base = path.Join("/ipfs", parts[1], rest)
+	case "ipns":
+		var result api.ResolveResult
+		err := f.call(ctx, "name/resolve", url.Values{
+			"arg":       {parts[1]},
+			"recursive": {"true"},
+		}, &result)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve /ipns/%s", parts[1])
+		}
+		f.base = path.Join(result.Path, rest)
+	default:
+		return nil
+	}
+	f.immutable = true
+	return nil
+}
+
+// call runs the API command with params decoding the result into
+// result if set
+func (f *Fs) call(ctx context.Context, command string, params url.Values, result interface{}) error {
+	opts := rest.Opts{
+		Method:     "POST",
+		Path:       "/" + command,
+		Parameters: params,
+		NoResponse: result == nil,
+	}
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.CallJSON(ctx, &opts, nil, result)
+		return shouldRetry(resp, err)
+	})
+}
+
+// absPath returns the IPFS or MFS path of remote
+func (f *Fs) absPath(remote string) string {
+	return path.Join(f.base, f.opt.Enc.FromStandardPath(remote))
+}
+
+// stat returns info about the IPFS or MFS path p
+func (f *Fs) stat(ctx context.Context, p string) (*api.Stat, error) {
+	var info api.Stat
+	err := f.call(ctx, "files/stat", url.Values{"arg": {p}}, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// listEntry is a file or directory found when listing
+type listEntry struct {
+	name  string
+	isDir bool
+	size  int64
+	cid   string
+}
+
+// listDir lists the IPFS or MFS directory at p
+func (f *Fs) listDir(ctx context.Context, p string) (entries []listEntry, err error) {
+	info, err := f.stat(ctx, p)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fs.ErrorDirNotFound
+		}
+		return nil, err
+	}
+	if info.Type != api.StatTypeDirectory {
+		return nil, fs.ErrorDirNotFound
+	}
+	if !f.immutable {
+		var result api.FilesList
+		err = f.call(ctx, "files/ls", url.Values{
+			"arg":  {p},
+			"long": {"true"},
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Entries {
+			entries = append(entries, listEntry{
+				name:  item.Name,
+				isDir: item.Type == api.FilesTypeDirectory,
+				size:  item.Size,
+				cid:   item.Hash,
+			})
+		}
+		return entries, nil
+	}
+	var result api.LsResult
+	err = f.call(ctx, "ls", url.Values{
+		"arg":          {p},
+		"resolve-type": {"true"},
+		"size":         {"true"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range result.Objects {
+		for _, link := range object.Links {
+			entry := listEntry{
+				name: link.Name,
+				size: link.Size,
+				cid:  link.Hash,
+			}
+			switch link.Type {
+			case api.LinkTypeDirectory, api.LinkTypeHAMTShard:
+				entry.isDir = true
+			case api.LinkTypeFile, api.LinkTypeRaw:
+			default:
+				fs.Debugf(f, "Ignoring %q with unknown type %d", link.Name, link.Type)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	items, err := f.listDir(ctx, f.absPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		remote := path.Join(dir, f.opt.Enc.ToStandardName(item.name))
+		if item.isDir {
+			d := fs.NewDir(remote, time.Time{}).SetID(item.cid)
+			entries = append(entries, d)
+		} else {
+			entries = append(entries, &Object{
+				fs:     f,
+				remote: remote,
+				size:   item.size,
+				cid:    item.cid,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// mkdirParent makes the parent directory of the MFS path p
+func (f *Fs) mkdirParent(ctx context.Context, p string) error {
+	return f.mkdir(ctx, path.Dir(p))
+}
+
+// mkdir makes the MFS directory p and its parents
+func (f *Fs) mkdir(ctx context.Context, p string) error {
+	if p == "/" {
+		return nil
+	}
+	return f.call(ctx, "files/mkdir", url.Values{
+		"arg":     {p},
+		"parents": {"true"},
+	}, nil)
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if f.immutable {
+		return errorReadOnly
+	}
+	return f.mkdir(ctx, f.absPath(dir))
+}
+
+// remove deletes the MFS path p
+func (f *Fs) remove(ctx context.Context, p string, recursive bool) error {
+	params := url.Values{"arg": {p}}
+	if recursive {
+		params.Set("recursive", "true")
+	}
+	return f.call(ctx, "files/rm", params, nil)
+}
+
+// Rmdir deletes the directory if empty
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if f.immutable {
+		return errorReadOnly
+	}
+	p := f.absPath(dir)
+	entries, err := f.listDir(ctx, p)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 0 {
+		return fs.ErrorDirectoryNotEmpty
+	}
+	if p == "/" {
+		// The MFS root always exists
+		return nil
+	}
+	return f.remove(ctx, p, true)
+}
+
+// Purge deletes all the files in the directory
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	p := f.absPath(dir)
+	info, err := f.stat(ctx, p)
+	if err != nil {
+		if isNotFound(err) {
+			return fs.ErrorDirNotFound
+		}
+		return err
+	}
+	if info.Type != api.StatTypeDirectory {
+		return fs.ErrorDirNotFound
+	}
+	if p == "/" {
+		return errors.New("can't purge the MFS root")
+	}
+	return f.remove(ctx, p, true)
+}
+
+// Precision of the remote
+func (f *Fs) Precision() time.Duration {
+	return fs.ModTimeNotSupported
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// sameNode returns true if src uses the same IPFS node as f
+func (f *Fs) sameNode(src *Fs) bool {
+	return src.opt.URL == f.opt.URL && src.opt.User == f.opt.User && src.opt.BearerToken == f.opt.BearerToken
+}
+
+// place puts the content with the CID given at the MFS path p
+// replacing anything there
+func (f *Fs) place(ctx context.Context, cid, p string) error {
+	err := f.mkdirParent(ctx, p)
+	if err != nil {
+		return errors.Wrap(err, "failed to make parent directory")
+	}
+	err = f.remove(ctx, p, false)
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "failed to remove old file")
+	}
+	return f.call(ctx, "files/cp", url.Values{
+		"arg": {"/ipfs/" + cid, p},
+	}, nil)
+}
+
+// Copy src to this remote using server side copy operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameNode(srcObj.fs) || srcObj.cid == "" {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	if f.opt.Pin {
+		err := f.call(ctx, "pin/add", url.Values{"arg": {srcObj.cid}}, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "copy: failed to pin")
+		}
+	}
+	err := f.place(ctx, srcObj.cid, f.absPath(remote))
+	if err != nil {
+		return nil, errors.Wrap(err, "copy failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameNode(srcObj.fs) || srcObj.fs.immutable {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	dstPath := f.absPath(remote)
+	err := f.mkdirParent(ctx, dstPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "move: failed to make parent directory")
+	}
+	err = f.remove(ctx, dstPath, false)
+	if err != nil && !isNotFound(err) {
+		return nil, errors.Wrap(err, "move: failed to remove old file")
+	}
+	err = f.call(ctx, "files/mv", url.Values{
+		"arg": {srcObj.fs.absPath(srcObj.remote), dstPath},
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "move failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameNode(srcFs) || srcFs.immutable {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcPath := srcFs.absPath(srcRemote)
+	dstPath := f.absPath(dstRemote)
+	_, err := f.stat(ctx, dstPath)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if !isNotFound(err) {
+		return err
+	}
+	err = f.mkdirParent(ctx, dstPath)
+	if err != nil {
+		return errors.Wrap(err, "dirmove: failed to make parent directory")
+	}
+	err = f.call(ctx, "files/mv", url.Values{
+		"arg": {srcPath, dstPath},
+	}, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return fs.ErrorDirNotFound
+		}
+		return errors.Wrap(err, "dirmove failed")
+	}
+	return nil
+}
+
+// About gets quota information from the repository of the node
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	var stat api.RepoStat
+	err := f.call(ctx, "repo/stat", url.Values{"size-only": {"true"}}, &stat)
+	if err != nil {
+		return nil, errors.Wrap(err, "about failed")
+	}
+	usage := &fs.Usage{
+		Used: fs.NewUsageValue(stat.RepoSize),
+	}
+	if stat.StorageMax > 0 {
+		usage.Total = fs.NewUsageValue(stat.StorageMax)
+		if free := stat.StorageMax - stat.RepoSize; free >= 0 {
+			usage.Free = fs.NewUsageValue(free)
+		}
+	}
+	return usage, nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the hash of an object returning a lowercase hex string
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ID returns the CID of the Object
+func (o *Object) ID() string {
+	return o.cid
+}
+
+// readMetaData reads the size and CID of the object
+func (o *Object) readMetaData(ctx context.Context) error {
+	info, err := o.fs.stat(ctx, o.fs.absPath(o.remote))
+	if err != nil {
+		if isNotFound(err) {
+			return fs.ErrorObjectNotFound
+		}
+		return err
+	}
+	if info.Type != api.StatTypeFile {
+		return fs.ErrorObjectNotFound
+	}
+	o.size = info.Size
+	o.cid = info.Hash
+	return nil
+}
+
+// ModTime returns the modification time of the object
+//
+// IPFS doesn't store modification times so this returns the current
+// time
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return time.Now()
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// streamReader returns the error in the trailer of a streamed
+// response if it fails part way through
+type streamReader struct {
+	resp *http.Response
+}
+
+// Read from the response checking the trailer at the end
+func (r *streamReader) Read(p []byte) (n int, err error) {
+	n, err = r.resp.Body.Read(p)
+	if err == io.EOF {
+		if message := r.resp.Trailer.Get(streamErrorHd); message != "" {
+			err = &api.Error{Message: message}
+		}
+	}
+	return n, err
+}
+
+// Close the response
+func (r *streamReader) Close() error {
+	return r.resp.Body.Close()
+}
+
+// Open an object for read
+//
+// This reads the content by CID so it reads the version of the file
+// which was listed.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	if o.cid == "" {
+		return nil, errors.New("can't download - no CID")
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	params := url.Values{"arg": {"/ipfs/" + o.cid}}
+	if offset > 0 {
+		params.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	if limit >= 0 {
+		params.Set("length", strconv.FormatInt(limit, 10))
+	}
+	opts := rest.Opts{
+		Method:     "POST",
+		Path:       "/cat",
+		Parameters: params,
+	}
+	var resp *http.Response
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err = o.fs.srv.Call(ctx, &opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{resp: resp}, nil
+}
+
+// Update the object with the contents of the io.Reader
+//
+// The content is added to IPFS then its CID is copied into MFS.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.fs.immutable {
+		return errorReadOnly
+	}
+	size := src.Size()
+	params := url.Values{
+		"pin":         {strconv.FormatBool(o.fs.opt.Pin)},
+		"cid-version": {strconv.Itoa(o.fs.opt.CIDVersion)},
+		"quieter":     {"true"},
+		"progress":    {"false"},
+	}
+	opts := rest.Opts{
+		Method:               "POST",
+		Path:                 "/add",
+		Parameters:           params,
+		Body:                 in,
+		Options:              options,
+		MultipartContentName: "file",
+		MultipartFileName:    path.Base(o.remote),
+	}
+	if size >= 0 {
+		opts.ContentLength = &size
+	}
+	var result api.AddResult
+	err := o.fs.pacer.CallNoRetry(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, nil, &result)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "upload failed")
+	}
+	if result.Hash == "" {
+		return errors.New("upload failed: no CID returned")
+	}
+	err = o.fs.place(ctx, result.Hash, o.fs.absPath(o.remote))
+	if err != nil {
+		return errors.Wrap(err, "upload failed to copy into MFS")
+	}
+	return o.readMetaData(ctx)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	if o.fs.immutable {
+		return errorReadOnly
+	}
+	err := o.fs.remove(ctx, o.fs.absPath(o.remote), false)
+	if isNotFound(err) {
+		return fs.ErrorObjectNotFound
+	}
+	return err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.Copier      = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.DirMover    = (*Fs)(nil)
+	_ fs.Purger      = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.Abouter     = (*Fs)(nil)
+	_ fs.Object      = (*Object)(nil)
+	_ fs.IDer        = (*Object)(nil)
+)
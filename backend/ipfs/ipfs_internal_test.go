@@ -0,0 +1,586 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/ipfs/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// node is a file or directory in the fake node
+type node struct {
+	data     []byte
+	children map[string]*node // set for directories
+}
+
+// clone returns a deep copy of n
+func (n *node) clone() *node {
+	c := &node{data: n.data}
+	if n.children != nil {
+		c.children = make(map[string]*node, len(n.children))
+		for name, child := range n.children {
+			c.children[name] = child.clone()
+		}
+	}
+	return c
+}
+
+// size returns the size of a file or 0 for a directory
+func (n *node) size() int64 {
+	return int64(len(n.data))
+}
+
+// fakeServer is a fake IPFS node serving the parts of the RPC API
+// the backend uses
+type fakeServer struct {
+	t      *testing.T
+	server *httptest.Server
+	token  string // bearer token to require if set
+
+	mu       sync.Mutex
+	root     *node            // root of MFS
+	blocks   map[string]*node // immutable content by CID
+	names    map[string]string
+	pins     map[string]bool
+	failCats map[string]bool // CIDs which fail part way through cat
+}
+
+// newFakeServer starts a fake IPFS node
+func newFakeServer(t *testing.T) *fakeServer {
+	srv := &fakeServer{
+		t:        t,
+		root:     &node{children: map[string]*node{}},
+		blocks:   map[string]*node{},
+		names:    map[string]string{},
+		pins:     map[string]bool{},
+		failCats: map[string]bool{},
+	}
+	srv.server = httptest.NewServer(http.HandlerFunc(srv.handle))
+	return srv
+}
+
+// close the server
+func (srv *fakeServer) close() {
+	srv.server.Close()
+}
+
+// config returns the config to use the server
+func (srv *fakeServer) config() configmap.Simple {
+	return configmap.Simple{
+		"url":         srv.server.URL,
+		"pin":         "true",
+		"cid_version": "1",
+		"encoding":    "Slash,InvalidUtf8,Dot",
+	}
+}
+
+// cid returns the CID of n, storing a copy of it in the blocks
+func (srv *fakeServer) cid(n *node) string {
+	h := sha256.New()
+	prefix := "bafk"
+	if n.children != nil {
+		prefix = "bafy"
+		var names []string
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(h, "%s %s\n", name, srv.cid(n.children[name]))
+		}
+	} else {
+		_, _ = h.Write(n.data)
+	}
+	cid := prefix + hex.EncodeToString(h.Sum(nil))[:32]
+	if _, found := srv.blocks[cid]; !found {
+		srv.blocks[cid] = n.clone()
+	}
+	return cid
+}
+
+// apiError is an error returned by a command
+type apiError string
+
+func (e apiError) Error() string { return string(e) }
+
+// walk finds p relative to n
+func walk(n *node, p, cid string) (*node, error) {
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child := n.children[name]
+		if child == nil {
+			if cid != "" {
+				return nil, apiError(fmt.Sprintf("no link named %q under %s", name, cid))
+			}
+			return nil, apiError("file does not exist")
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// resolve finds the node for an IPFS or MFS path
+func (srv *fakeServer) resolve(p string) (*node, error) {
+	if strings.HasPrefix(p, "/ipfs/") {
+		parts := strings.SplitN(strings.TrimPrefix(p, "/ipfs/"), "/", 2)
+		n := srv.blocks[parts[0]]
+		if n == nil {
+			return nil, apiError("block was not found locally (offline): ipld: could not find " + parts[0])
+		}
+		if len(parts) == 1 {
+			return n, nil
+		}
+		return walk(n, parts[1], parts[0])
+	}
+	return walk(srv.root, p, "")
+}
+
+// parent finds the parent directory of the MFS path p
+func (srv *fakeServer) parent(p string) (*node, string, error) {
+	dir, leaf := path.Split(path.Clean(p))
+	n, err := walk(srv.root, dir, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if n.children == nil {
+		return nil, "", apiError("not a directory")
+	}
+	return n, leaf, nil
+}
+
+// writeJSON writes v as the response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an API error
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(api.Error{Message: message, Code: 0, Type: "error"})
+}
+
+// handle an API request
+func (srv *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "405 - Method Not Allowed")
+		return
+	}
+	if srv.token != "" && r.Header.Get("Authorization") != "Bearer "+srv.token {
+		writeError(w, http.StatusForbidden, "403 - Forbidden")
+		return
+	}
+	command := strings.TrimPrefix(r.URL.Path, apiPath+"/")
+	query := r.URL.Query()
+	args := query["arg"]
+	var data []byte
+	if command == "add" {
+		reader, err := r.MultipartReader()
+		require.NoError(srv.t, err)
+		part, err := reader.NextPart()
+		require.NoError(srv.t, err)
+		assert.Equal(srv.t, "file", part.FormName())
+		data, err = ioutil.ReadAll(part)
+		if err != nil {
+			// the upload was aborted
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	result, err := srv.run(w, command, args, query, data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	} else if result != nil {
+		writeJSON(w, result)
+	}
+}
+
+// run the command returning the result to write as JSON
+func (srv *fakeServer) run(w http.ResponseWriter, command string, args []string, query map[string][]string, data []byte) (interface{}, error) {
+	flag := func(name string) bool {
+		return len(query[name]) > 0 && query[name][0] == "true"
+	}
+	switch command {
+	case "add":
+		n := &node{data: data}
+		cid := srv.cid(n)
+		if flag("pin") {
+			srv.pins[cid] = true
+		}
+		return api.AddResult{Name: cid, Hash: cid, Size: strconv.Itoa(len(data) + 11)}, nil
+	case "cat":
+		n, err := srv.resolve(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n.children != nil {
+			return nil, apiError("this dag node is a directory")
+		}
+		data := n.data
+		if offsets := query["offset"]; len(offsets) > 0 {
+			offset, _ := strconv.Atoi(offsets[0])
+			if offset > len(data) {
+				offset = len(data)
+			}
+			data = data[offset:]
+		}
+		if lengths := query["length"]; len(lengths) > 0 {
+			length, _ := strconv.Atoi(lengths[0])
+			if length < len(data) {
+				data = data[:length]
+			}
+		}
+		w.Header().Set("Trailer", streamErrorHd)
+		if srv.failCats[strings.TrimPrefix(args[0], "/ipfs/")] {
+			_, _ = w.Write(data[:len(data)/2])
+			w.Header().Set(streamErrorHd, "context deadline exceeded")
+			return nil, nil
+		}
+		_, _ = w.Write(data)
+		return nil, nil
+	case "ls":
+		n, err := srv.resolve(args[0])
+		if err != nil {
+			return nil, err
+		}
+		object := api.LsObject{Hash: srv.cid(n), Links: []api.Link{}}
+		for name, child := range n.children {
+			link := api.Link{Name: name, Hash: srv.cid(child), Size: child.size(), Type: api.LinkTypeFile}
+			if child.children != nil {
+				link.Type = api.LinkTypeDirectory
+			}
+			object.Links = append(object.Links, link)
+		}
+		return api.LsResult{Objects: []api.LsObject{object}}, nil
+	case "files/stat":
+		n, err := srv.resolve(args[0])
+		if err != nil {
+			return nil, err
+		}
+		stat := api.Stat{Hash: srv.cid(n), Size: n.size(), CumulativeSize: n.size() + 11, Type: api.StatTypeFile}
+		if n.children != nil {
+			stat.Type = api.StatTypeDirectory
+		}
+		return stat, nil
+	case "files/ls":
+		n, err := srv.resolve(args[0])
+		if err != nil {
+			return nil, err
+		}
+		assert.True(srv.t, flag("long"))
+		list := api.FilesList{}
+		for name, child := range n.children {
+			entry := api.FilesEntry{Name: name, Hash: srv.cid(child), Size: child.size(), Type: api.FilesTypeFile}
+			if child.children != nil {
+				entry.Type = api.FilesTypeDirectory
+			}
+			list.Entries = append(list.Entries, entry)
+		}
+		return list, nil
+	case "files/mkdir":
+		if flag("parents") {
+			n := srv.root
+			for _, name := range strings.Split(strings.Trim(args[0], "/"), "/") {
+				child := n.children[name]
+				if child == nil {
+					child = &node{children: map[string]*node{}}
+					n.children[name] = child
+				} else if child.children == nil {
+					return nil, apiError("not a directory")
+				}
+				n = child
+			}
+			return nil, nil
+		}
+		dir, leaf, err := srv.parent(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if dir.children[leaf] != nil {
+			return nil, apiError("file already exists")
+		}
+		dir.children[leaf] = &node{children: map[string]*node{}}
+		return nil, nil
+	case "files/rm":
+		if path.Clean(args[0]) == "/" {
+			return nil, apiError("cannot delete root")
+		}
+		dir, leaf, err := srv.parent(args[0])
+		if err != nil {
+			return nil, err
+		}
+		n := dir.children[leaf]
+		if n == nil {
+			return nil, apiError("file does not exist")
+		}
+		if n.children != nil && !flag("recursive") {
+			return nil, apiError(args[0] + " is a directory, use -r to remove directories")
+		}
+		delete(dir.children, leaf)
+		return nil, nil
+	case "files/cp":
+		n, err := srv.resolve(args[0])
+		if err != nil {
+			return nil, err
+		}
+		dir, leaf, err := srv.parent(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if dir.children[leaf] != nil {
+			return nil, apiError("directory already has entry by that name")
+		}
+		dir.children[leaf] = n.clone()
+		return nil, nil
+	case "files/mv":
+		srcDir, srcLeaf, err := srv.parent(args[0])
+		if err != nil {
+			return nil, err
+		}
+		n := srcDir.children[srcLeaf]
+		if n == nil {
+			return nil, apiError("file does not exist")
+		}
+		dstDir, dstLeaf, err := srv.parent(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if existing := dstDir.children[dstLeaf]; existing != nil {
+			if existing.children == nil {
+				return nil, apiError("directory already has entry by that name")
+			}
+			dstDir, dstLeaf = existing, srcLeaf
+		}
+		delete(srcDir.children, srcLeaf)
+		dstDir.children[dstLeaf] = n
+		return nil, nil
+	case "pin/add":
+		if srv.blocks[args[0]] == nil {
+			return nil, apiError("block not found")
+		}
+		srv.pins[args[0]] = true
+		return nil, nil
+	case "name/resolve":
+		p, ok := srv.names[args[0]]
+		if !ok {
+			return nil, apiError("could not resolve name")
+		}
+		return api.ResolveResult{Path: p}, nil
+	case "repo/stat":
+		return api.RepoStat{RepoSize: 1000, StorageMax: 10000}, nil
+	}
+	return nil, apiError("unknown command " + command)
+}
+
+// newFs makes an Fs for root on the server
+func (srv *fakeServer) newFs(root string) (*Fs, error) {
+	f, err := NewFs(context.Background(), "TestIPFS", root, srv.config())
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// put uploads a file
+func put(t *testing.T, f fs.Fs, remote, contents string) fs.Object {
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(contents)), true, nil, nil)
+	o, err := f.Put(context.Background(), strings.NewReader(contents), src)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads a file
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) string {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestErrorNotFound(t *testing.T) {
+	assert.True(t, isNotFound(&api.Error{Message: "file does not exist"}))
+	assert.True(t, isNotFound(errors.Wrap(&api.Error{Message: `no link named "x" under bafy`}, "wrapped")))
+	assert.False(t, isNotFound(&api.Error{Message: "cannot delete root"}))
+	assert.False(t, isNotFound(io.EOF))
+}
+
+func TestImmutable(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	put(t, f, "dir/a.txt", "hello world")
+	put(t, f, "dir/sub/b.txt", "potato")
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	dirCID := entries[0].(fs.IDer).ID()
+	assert.True(t, strings.HasPrefix(dirCID, "bafy"))
+
+	// Snapshot the directory then change MFS
+	put(t, f, "dir/a.txt", "changed")
+	srv.names["k51name"] = "/ipfs/" + dirCID
+
+	for _, root := range []string{"ipfs/" + dirCID, "/ipfs/" + dirCID, "ipns/k51name"} {
+		t.Run(root, func(t *testing.T) {
+			g, err := srv.newFs(root)
+			require.NoError(t, err)
+			assert.True(t, g.immutable)
+			assert.Nil(t, g.Features().Copy)
+			assert.Nil(t, g.Features().Purge)
+
+			entries, err := g.List(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, 2, len(entries))
+
+			o, err := g.NewObject(ctx, "a.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(11), o.Size())
+			assert.Equal(t, "hello world", read(t, o))
+			assert.Equal(t, "llo", read(t, o, &fs.RangeOption{Start: 2, End: 4}))
+
+			entries, err = g.List(ctx, "sub")
+			require.NoError(t, err)
+			require.Equal(t, 1, len(entries))
+			assert.Equal(t, "sub/b.txt", entries[0].Remote())
+
+			_, err = g.List(ctx, "missing")
+			assert.Equal(t, fs.ErrorDirNotFound, err)
+			_, err = g.NewObject(ctx, "missing")
+			assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+			src := object.NewStaticObjectInfo("new.txt", time.Now(), 1, true, nil, nil)
+			_, err = g.Put(ctx, strings.NewReader("x"), src)
+			assert.Equal(t, errorReadOnly, err)
+			assert.Equal(t, errorReadOnly, g.Mkdir(ctx, "new"))
+			assert.Equal(t, errorReadOnly, o.Remove(ctx))
+		})
+	}
+
+	// Root pointing at a file
+	g, err := srv.newFs("ipfs/" + dirCID + "/sub/b.txt")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "ipfs/"+dirCID+"/sub", g.Root())
+
+	// Server side copy from the snapshot back into MFS
+	g, err = srv.newFs("ipfs/" + dirCID)
+	require.NoError(t, err)
+	o, err := g.NewObject(ctx, "a.txt")
+	require.NoError(t, err)
+	delete(srv.pins, o.(fs.IDer).ID())
+	copied, err := f.Copy(ctx, o, "restored/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", read(t, copied))
+	assert.True(t, srv.pins[o.(fs.IDer).ID()])
+
+	// Unknown IPNS names
+	_, err = srv.newFs("ipns/unknown")
+	assert.Error(t, err)
+}
+
+func TestPin(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	o := put(t, f, "pinned.txt", "pinned")
+	assert.True(t, srv.pins[o.(fs.IDer).ID()])
+
+	f.opt.Pin = false
+	o = put(t, f, "unpinned.txt", "unpinned")
+	assert.False(t, srv.pins[o.(fs.IDer).ID()])
+
+	// Overwriting replaces the file in MFS
+	o = put(t, f, "pinned.txt", "new contents")
+	o, err = f.NewObject(ctx, "pinned.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "new contents", read(t, o))
+}
+
+func TestStreamError(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	o := put(t, f, "file.txt", "0123456789")
+	srv.failCats[o.(fs.IDer).ID()] = true
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	assert.Equal(t, "01234", string(data))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	require.NoError(t, in.Close())
+}
+
+func TestAuth(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	srv.token = "secret"
+	_, err := srv.newFs("dir")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	m := srv.config()
+	m["bearer_token"] = "secret"
+	_, err = NewFs(context.Background(), "TestIPFS", "dir", m)
+	require.NoError(t, err)
+}
+
+func TestAbout(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	usage, err := f.About(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), *usage.Used)
+	assert.Equal(t, int64(10000), *usage.Total)
+	assert.Equal(t, int64(9000), *usage.Free)
+}
+
+func TestIntegrationFake(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	for key, value := range srv.config() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestIPFSFake", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestIPFSFake", "type"), "ipfs"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestIPFSFake:",
+		NilObject:  (*Object)(nil),
+	})
+}
@@ -0,0 +1,186 @@
+// Package api has type definitions for the parts of the Kubernetes
+// API used by the kubernetes backend
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ObjectMeta is the metadata common to all objects
+type ObjectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	GenerateName    string            `json:"generateName,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	OwnerReferences []OwnerReference  `json:"ownerReferences,omitempty"`
+}
+
+// OwnerReference makes an object be deleted when its owner is
+type OwnerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// Pod is a group of containers
+type Pod struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       PodSpec    `json:"spec"`
+	Status     PodStatus  `json:"status,omitempty"`
+}
+
+// PodSpec describes a pod
+type PodSpec struct {
+	Containers    []Container       `json:"containers"`
+	Volumes       []Volume          `json:"volumes,omitempty"`
+	RestartPolicy string            `json:"restartPolicy,omitempty"`
+	NodeSelector  map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// Container is a container in a pod
+type Container struct {
+	Name         string        `json:"name"`
+	Image        string        `json:"image"`
+	Command      []string      `json:"command,omitempty"`
+	VolumeMounts []VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+// Volume is a volume available to a pod
+type Volume struct {
+	Name                  string                             `json:"name"`
+	PersistentVolumeClaim *PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+// PersistentVolumeClaimVolumeSource refers to a claim in the pod's namespace
+type PersistentVolumeClaimVolumeSource struct {
+	ClaimName string `json:"claimName"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// VolumeMount mounts a volume in a container
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// PodStatus is the observed state of the pod
+type PodStatus struct {
+	Phase             string            `json:"phase,omitempty"`
+	Message           string            `json:"message,omitempty"`
+	Reason            string            `json:"reason,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+// ContainerStatus is the observed state of a container
+type ContainerStatus struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	State struct {
+		Waiting *struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"waiting,omitempty"`
+	} `json:"state"`
+}
+
+// PersistentVolumeClaim is a request for storage
+type PersistentVolumeClaim struct {
+	APIVersion string                    `json:"apiVersion,omitempty"`
+	Kind       string                    `json:"kind,omitempty"`
+	Metadata   ObjectMeta                `json:"metadata"`
+	Spec       PersistentVolumeClaimSpec `json:"spec"`
+}
+
+// PersistentVolumeClaimSpec describes a claim
+type PersistentVolumeClaimSpec struct {
+	AccessModes      []string                   `json:"accessModes,omitempty"`
+	StorageClassName *string                    `json:"storageClassName,omitempty"`
+	DataSource       *TypedLocalObjectReference `json:"dataSource,omitempty"`
+	Resources        ResourceRequirements       `json:"resources"`
+}
+
+// TypedLocalObjectReference refers to an object in the same namespace
+type TypedLocalObjectReference struct {
+	APIGroup *string `json:"apiGroup,omitempty"`
+	Kind     string  `json:"kind"`
+	Name     string  `json:"name"`
+}
+
+// ResourceRequirements is the storage requested
+type ResourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+// VolumeSnapshot is a CSI snapshot of a volume
+type VolumeSnapshot struct {
+	Metadata ObjectMeta           `json:"metadata"`
+	Status   VolumeSnapshotStatus `json:"status"`
+}
+
+// VolumeSnapshotStatus is the observed state of a snapshot
+type VolumeSnapshotStatus struct {
+	ReadyToUse  *bool  `json:"readyToUse,omitempty"`
+	RestoreSize string `json:"restoreSize,omitempty"`
+	Error       *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Status is returned by the API for errors and by exec when the
+// command finishes
+type Status struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message"`
+	Reason  string        `json:"reason"`
+	Code    int           `json:"code"`
+	Details StatusDetails `json:"details"`
+}
+
+// StatusDetails has more information about the Status
+type StatusDetails struct {
+	Causes []StatusCause `json:"causes"`
+}
+
+// StatusCause is one cause of the Status
+type StatusCause struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Error returns a string for the error and satisfies the error interface
+func (e *Status) Error() string {
+	out := e.Message
+	if out == "" {
+		out = strings.ToLower(e.Reason)
+	}
+	if e.Code != 0 {
+		out = fmt.Sprintf("%s (%d %s)", out, e.Code, e.Reason)
+	}
+	return out
+}
+
+// ExitCode returns the exit code of the command if the Status is the
+// result of an exec or -1 if it isn't
+func (e *Status) ExitCode() int {
+	if e.Status == "Success" {
+		return 0
+	}
+	for _, cause := range e.Details.Causes {
+		if cause.Reason == "ExitCode" {
+			var code int
+			if _, err := fmt.Sscan(cause.Message, &code); err == nil {
+				return code
+			}
+		}
+	}
+	return -1
+}
+
+// Check Status satisfies the error interface
+var _ error = (*Status)(nil)
@@ -0,0 +1,293 @@
+package kubernetes
+
+// This runs commands in the helper pod with the exec API, which
+// streams stdin, stdout and stderr over a websocket using the
+// v4.channel.k8s.io protocol. Each message starts with a byte saying
+// which stream it is for.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/kubernetes/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	execProtocol  = "v4.channel.k8s.io"
+	stdinChannel  = 0
+	stdoutChannel = 1
+	stderrChannel = 2
+	errorChannel  = 3
+	maxStderr     = 64 * 1024 // most stderr to keep for error messages
+)
+
+// Exit codes the scripts use to report errors
+const (
+	exitNotFound  = 3 // file or directory doesn't exist
+	exitNotEmpty  = 4 // directory isn't empty
+	exitDirExists = 5 // destination directory exists
+	exitShort     = 6 // upload was incomplete
+)
+
+// exitError is returned when a command exits with a non zero status
+type exitError struct {
+	code   int
+	stderr string
+}
+
+// Error satisfies the error interface
+func (e *exitError) Error() string {
+	if e.stderr != "" {
+		return fmt.Sprintf("command failed with exit code %d: %s", e.code, e.stderr)
+	}
+	return fmt.Sprintf("command failed with exit code %d", e.code)
+}
+
+// exitCode returns the exit code of the command which returned err
+// or 0 if it wasn't an exitError
+func exitCode(err error) int {
+	if e, ok := errors.Cause(err).(*exitError); ok {
+		return e.code
+	}
+	return 0
+}
+
+// execution is a command running in the helper pod
+type execution struct {
+	ctx     context.Context
+	ws      *websocket.Conn
+	stdout  *io.PipeReader
+	stdoutW *io.PipeWriter
+	stderr  bytes.Buffer
+	done    chan struct{} // closed when the command has finished
+	err     error         // result of the command, valid once done is closed
+
+	mu     sync.Mutex
+	closed bool // set if close has been called
+}
+
+// dialExec opens the websocket to run args in the helper pod
+func (f *Fs) dialExec(ctx context.Context, args []string, stdin bool) (*websocket.Conn, error) {
+	u, err := url.Parse(f.apiConfig.server)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad server URL")
+	}
+	params := url.Values{}
+	params.Set("container", containerName)
+	params.Set("stdout", "true")
+	params.Set("stderr", "true")
+	if stdin {
+		params.Set("stdin", "true")
+	}
+	for _, arg := range args {
+		params.Add("command", arg)
+	}
+	u.Path = path.Join(u.Path, "api/v1/namespaces", f.namespace, "pods", f.pod.name, "exec")
+	u.RawQuery = params.Encode()
+	origin := u.String()
+	port := u.Port()
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+		if port == "" {
+			port = "443"
+		}
+	case "http":
+		u.Scheme = "ws"
+		if port == "" {
+			port = "80"
+		}
+	default:
+		return nil, errors.Errorf("unsupported server URL scheme %q", u.Scheme)
+	}
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{execProtocol}
+	config.Header = f.authHeader()
+	conn, err := fshttp.NewDialer(ctx).DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, errors.Wrap(err, "exec failed to connect")
+	}
+	if u.Scheme == "wss" {
+		tlsConfig := f.apiConfig.tls.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "exec TLS handshake failed")
+		}
+		conn = tlsConn
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "exec failed")
+	}
+	return ws, nil
+}
+
+// start runs args in the helper pod
+//
+// The output can be read from stdout which returns the result of the
+// command as its error once the output is finished.
+func (f *Fs) start(ctx context.Context, stdin bool, args ...string) (*execution, error) {
+	ws, err := f.dialExec(ctx, args, stdin)
+	if err != nil {
+		return nil, err
+	}
+	e := &execution{
+		ctx:  ctx,
+		ws:   ws,
+		done: make(chan struct{}),
+	}
+	e.stdout, e.stdoutW = io.Pipe()
+	go e.receive()
+	go func() {
+		select {
+		case <-ctx.Done():
+			e.close()
+		case <-e.done:
+		}
+	}()
+	return e, nil
+}
+
+// receive reads the messages from the websocket until it is closed
+func (e *execution) receive() {
+	var err error
+	for {
+		var message []byte
+		if receiveErr := websocket.Message.Receive(e.ws, &message); receiveErr != nil {
+			if receiveErr != io.EOF && err == nil && !e.isClosed() {
+				err = errors.Wrap(receiveErr, "exec connection failed")
+			}
+			break
+		}
+		if len(message) == 0 {
+			continue
+		}
+		data := message[1:]
+		switch message[0] {
+		case stdoutChannel:
+			if _, writeErr := e.stdoutW.Write(data); writeErr != nil {
+				// the reader has been closed
+				e.close()
+			}
+		case stderrChannel:
+			if e.stderr.Len() < maxStderr {
+				_, _ = e.stderr.Write(data)
+			}
+		case errorChannel:
+			if len(data) == 0 {
+				continue
+			}
+			var status api.Status
+			if jsonErr := json.Unmarshal(data, &status); jsonErr != nil {
+				err = errors.Wrapf(jsonErr, "exec returned bad status %q", data)
+			} else if code := status.ExitCode(); code > 0 {
+				err = &exitError{code: code}
+			} else if code < 0 {
+				err = &status
+			}
+		}
+	}
+	if err == nil && e.ctx.Err() != nil {
+		err = e.ctx.Err()
+	}
+	if exitErr, ok := err.(*exitError); ok {
+		exitErr.stderr = strings.TrimSpace(e.stderr.String())
+	}
+	_ = e.ws.Close()
+	e.err = err
+	_ = e.stdoutW.CloseWithError(err)
+	close(e.done)
+}
+
+// isClosed returns true if close has been called
+func (e *execution) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+// close the connection which kills the command
+func (e *execution) close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	_ = e.ws.Close()
+}
+
+// write sends p to the stdin of the command
+func (e *execution) write(p []byte) error {
+	message := make([]byte, 1+len(p))
+	message[0] = stdinChannel
+	copy(message[1:], p)
+	return websocket.Message.Send(e.ws, message)
+}
+
+// wait for the command to finish returning its result
+func (e *execution) wait() error {
+	<-e.done
+	return e.err
+}
+
+// run runs args in the helper pod returning the output
+func (f *Fs) run(ctx context.Context, args ...string) (out []byte, err error) {
+	err = f.pacer.Call(func() (bool, error) {
+		e, err := f.start(ctx, false, args...)
+		if err != nil {
+			return shouldRetry(nil, err)
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, e.stdout)
+		if err == nil {
+			err = e.wait()
+		}
+		out = buf.Bytes()
+		return shouldRetry(nil, err)
+	})
+	return out, err
+}
+
+// script runs the shell script in the helper pod with the args as
+// its positional parameters, returning the output
+func (f *Fs) script(ctx context.Context, script string, args ...string) (out []byte, err error) {
+	fs.Debugf(f, "script %q %q", script, args)
+	return f.run(ctx, append([]string{"sh", "-c", script, "rclone"}, args...)...)
+}
+
+// execReader reads the output of a command
+type execReader struct {
+	e *execution
+}
+
+// Read the output
+func (r *execReader) Read(p []byte) (n int, err error) {
+	return r.e.stdout.Read(p)
+}
+
+// Close the reader, killing the command if it is still running
+func (r *execReader) Close() error {
+	_ = r.e.stdout.Close()
+	r.e.close()
+	<-r.e.done
+	return nil
+}
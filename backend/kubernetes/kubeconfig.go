@@ -0,0 +1,220 @@
+package kubernetes
+
+// This reads the credentials for the API server from a kubeconfig
+// file or from the service account of the pod rclone is running in.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/env"
+	"gopkg.in/yaml.v2"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeConfig is the subset of the kubeconfig file format we use
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Username              string      `yaml:"username"`
+			Password              string      `yaml:"password"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// apiConfig is how to connect to the API server
+type apiConfig struct {
+	server    string      // URL of the API server
+	namespace string      // default namespace or ""
+	token     string      // bearer token or ""
+	username  string      // basic auth user or ""
+	password  string      // basic auth password
+	tls       *tls.Config // TLS config for the server
+}
+
+// kubeConfigPath returns the kubeconfig file to use or "" if there
+// isn't one
+func kubeConfigPath(opt string) string {
+	if opt != "" {
+		return env.ShellExpand(opt)
+	}
+	if paths := os.Getenv("KUBECONFIG"); paths != "" {
+		return filepath.SplitList(paths)[0]
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".kube", "config")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// readData returns the inline base64 data or the contents of file
+// relative to dir
+func readData(data, file, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// loadKubeConfig reads the API config from the kubeconfig file at
+// path using the context given or the current context if blank
+func loadKubeConfig(path, contextName string) (*apiConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read kubeconfig")
+	}
+	var kc kubeConfig
+	err = yaml.Unmarshal(data, &kc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse kubeconfig %q", path)
+	}
+	dir := filepath.Dir(path)
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	api := &apiConfig{}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, api.namespace = c.Context.Cluster, c.Context.User, c.Context.Namespace
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("context %q not found in kubeconfig %q", contextName, path)
+	}
+	api.tls = &tls.Config{}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		api.server = c.Cluster.Server
+		api.tls.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := readData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read certificate authority")
+		}
+		if ca != nil {
+			api.tls.RootCAs = x509.NewCertPool()
+			if !api.tls.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, errors.New("no certificates found in certificate authority")
+			}
+		}
+		break
+	}
+	if !found {
+		return nil, errors.Errorf("cluster %q not found in kubeconfig %q", clusterName, path)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		user := u.User
+		if user.Exec != nil || user.AuthProvider != nil {
+			return nil, errors.Errorf("user %q authenticates with a plugin which isn't supported - use a service account token instead", userName)
+		}
+		api.token = user.Token
+		if user.TokenFile != "" {
+			token, err := readData("", user.TokenFile, dir)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read token file")
+			}
+			api.token = strings.TrimSpace(string(token))
+		}
+		api.username, api.password = user.Username, user.Password
+		cert, err := readData(user.ClientCertificateData, user.ClientCertificate, dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client certificate")
+		}
+		key, err := readData(user.ClientKeyData, user.ClientKey, dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client key")
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load client certificate")
+			}
+			api.tls.Certificates = []tls.Certificate{pair}
+		}
+		break
+	}
+	return api, nil
+}
+
+// loadInClusterConfig reads the API config from the service account
+// of the pod rclone is running in
+func loadInClusterConfig() (*apiConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("no kubeconfig found and not running in a cluster")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service account token")
+	}
+	api := &apiConfig{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		tls:    &tls.Config{},
+	}
+	if ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt")); err == nil {
+		api.tls.RootCAs = x509.NewCertPool()
+		api.tls.RootCAs.AppendCertsFromPEM(ca)
+	}
+	if namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		api.namespace = strings.TrimSpace(string(namespace))
+	}
+	return api, nil
+}
@@ -0,0 +1,1110 @@
+// Package kubernetes provides access to the files on Kubernetes
+// persistent volumes and volume snapshots
+//
+// It starts a helper pod which mounts the volume and runs commands in
+// it with the exec API, so no privileged access to the nodes is
+// needed.
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/kubernetes/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/env"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	minSleep         = 10 * time.Millisecond
+	maxSleep         = 2 * time.Second
+	decayConstant    = 2 // bigger for slower decay, exponential
+	containerName    = "rclone"
+	volumeName       = "data"
+	mountPath        = "/data"
+	managedByLabel   = "app.kubernetes.io/managed-by"
+	snapshotAPIGroup = "snapshot.storage.k8s.io"
+	partialSuffix    = ".rclone-partial"
+	statFormat       = "%f %s %Y %n"
+	uploadBufferSize = 64 * 1024
+)
+
+// podPollInterval is how often to check whether the helper pod has
+// started
+var podPollInterval = time.Second
+
+var errorReadOnly = errors.New("kubernetes remote is read only")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "kubernetes",
+		Description: "Kubernetes persistent volume or volume snapshot",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "pvc",
+			Help: `Name of the PersistentVolumeClaim to access
+
+Set this or snapshot.`,
+		}, {
+			Name: "snapshot",
+			Help: `Name of the VolumeSnapshot to access
+
+A temporary PersistentVolumeClaim is restored from the snapshot and
+deleted when rclone has finished. Snapshots are always read only.
+
+Set this or pvc.`,
+		}, {
+			Name: "namespace",
+			Help: `Namespace of the volume
+
+Leave blank to use the namespace of the kubeconfig context or the
+namespace rclone is running in.`,
+		}, {
+			Name: "kubeconfig",
+			Help: `Path to the kubeconfig file
+
+Leave blank to use $KUBECONFIG or ~/.kube/config, or the service
+account of the pod if rclone is running in the cluster.` + env.ShellExpandHelp,
+			Advanced: true,
+		}, {
+			Name:     "context",
+			Help:     "Context to use from the kubeconfig file, leave blank for the current context",
+			Advanced: true,
+		}, {
+			Name:     "read_only",
+			Help:     "Mount the persistent volume read only",
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "image",
+			Help: `Container image to run in the helper pod
+
+This needs sh and the usual file utilities (find, stat, head, tail,
+md5sum, ...) which busybox provides.`,
+			Default:  "busybox:stable",
+			Advanced: true,
+		}, {
+			Name:     "storage_class",
+			Help:     "Storage class for the volume restored from a snapshot, leave blank for the default",
+			Advanced: true,
+		}, {
+			Name:     "pod_timeout",
+			Help:     "How long to wait for the helper pod to start",
+			Default:  fs.Duration(5 * time.Minute),
+			Advanced: true,
+		}, {
+			Name: "pod_lifetime",
+			Help: `How long the helper pod runs for
+
+rclone deletes the helper pod when it exits, but if it is killed the
+pod will stop after this long.`,
+			Default:  fs.Duration(24 * time.Hour),
+			Advanced: true,
+		}, {
+			Name:     config.ConfigEncoding,
+			Help:     config.ConfigEncodingHelp,
+			Advanced: true,
+			Default: (encoder.Base |
+				encoder.EncodeCrLf |
+				encoder.EncodeInvalidUtf8),
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	PVC          string               `config:"pvc"`
+	Snapshot     string               `config:"snapshot"`
+	Namespace    string               `config:"namespace"`
+	KubeConfig   string               `config:"kubeconfig"`
+	Context      string               `config:"context"`
+	ReadOnly     bool                 `config:"read_only"`
+	Image        string               `config:"image"`
+	StorageClass string               `config:"storage_class"`
+	PodTimeout   fs.Duration          `config:"pod_timeout"`
+	PodLifetime  fs.Duration          `config:"pod_lifetime"`
+	Enc          encoder.MultiEncoder `config:"encoding"`
+}
+
+// Fs represents a directory on a Kubernetes volume
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // parsed options
+	features  *fs.Features // optional features
+	apiConfig *apiConfig   // how to connect to the API server
+	namespace string       // namespace of the volume
+	srv       *rest.Client // the connection to the API server
+	pacer     *fs.Pacer    // pacer for API calls
+	pod       *helperPod   // the pod the volume is mounted in
+}
+
+// Object describes a file on the volume
+type Object struct {
+	fs      *Fs       // what this object is part of
+	remote  string    // the remote path
+	size    int64     // size of the object
+	modTime time.Time // modification time of the object
+	md5     string    // MD5 if known
+	sha1    string    // SHA1 if known
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	if f.opt.Snapshot != "" {
+		return fmt.Sprintf("kubernetes snapshot %s/%s:%s", f.namespace, f.opt.Snapshot, f.root)
+	}
+	return fmt.Sprintf("kubernetes pvc %s/%s:%s", f.namespace, f.opt.PVC, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+func shouldRetry(resp *http.Response, err error) (bool, error) {
+	if exitCode(err) != 0 {
+		return false, err
+	}
+	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	errResponse := new(api.Status)
+	err := rest.DecodeJSON(resp, &errResponse)
+	if err != nil {
+		fs.Debugf(nil, "Couldn't decode error response: %v", err)
+	}
+	if errResponse.Code == 0 {
+		errResponse.Code = resp.StatusCode
+	}
+	if errResponse.Message == "" {
+		errResponse.Message = resp.Status
+	}
+	return errResponse
+}
+
+// isNotFound returns true if err is a 404 from the API server
+func isNotFound(err error) bool {
+	status, ok := errors.Cause(err).(*api.Status)
+	return ok && status.Code == http.StatusNotFound
+}
+
+// authHeader returns the headers to authenticate to the API server
+func (f *Fs) authHeader() http.Header {
+	header := http.Header{}
+	if f.apiConfig.token != "" {
+		header.Set("Authorization", "Bearer "+f.apiConfig.token)
+	} else if f.apiConfig.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(f.apiConfig.username + ":" + f.apiConfig.password))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	return header
+}
+
+// callJSON calls the API server with the pacer
+func (f *Fs) callJSON(ctx context.Context, opts *rest.Opts, request, response interface{}) error {
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.CallJSON(ctx, opts, request, response)
+		return shouldRetry(resp, err)
+	})
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if (opt.PVC == "") == (opt.Snapshot == "") {
+		return nil, errors.New("kubernetes: exactly one of pvc and snapshot must be set")
+	}
+	if opt.Snapshot != "" {
+		opt.ReadOnly = true
+	}
+	var apiConfig *apiConfig
+	if kubeConfig := kubeConfigPath(opt.KubeConfig); kubeConfig != "" {
+		apiConfig, err = loadKubeConfig(kubeConfig, opt.Context)
+	} else {
+		apiConfig, err = loadInClusterConfig()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes")
+	}
+	f := &Fs{
+		name:      name,
+		root:      strings.Trim(path.Clean(root), "/"),
+		opt:       *opt,
+		apiConfig: apiConfig,
+		namespace: opt.Namespace,
+		pacer:     fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+	}
+	if f.root == "." {
+		f.root = ""
+	}
+	if f.namespace == "" {
+		f.namespace = apiConfig.namespace
+	}
+	if f.namespace == "" {
+		f.namespace = "default"
+	}
+	client := &http.Client{
+		Transport: fshttp.NewTransportCustom(ctx, func(t *http.Transport) {
+			t.TLSClientConfig = apiConfig.tls
+		}),
+	}
+	f.srv = rest.NewClient(client).SetRoot(strings.TrimRight(apiConfig.server, "/")).SetErrorHandler(errorHandler)
+	for key, values := range f.authHeader() {
+		f.srv.SetHeader(key, values[0])
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	if f.opt.ReadOnly {
+		f.features.Move = nil
+		f.features.DirMove = nil
+		f.features.Purge = nil
+	}
+
+	f.pod, err = f.getPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check to see if the root is a file
+	if f.root != "" {
+		_, err := f.stat(ctx, "")
+		if err == nil {
+			newRoot := path.Dir(f.root)
+			if newRoot == "." {
+				newRoot = ""
+			}
+			f.root = newRoot
+			// return an error with an fs which points to the parent
+			return f, fs.ErrorIsFile
+		}
+	}
+	return f, nil
+}
+
+// ------------------------------------------------------------
+// The helper pod
+
+// helperPod is a pod with the volume mounted in it
+//
+// These are shared between all the Fs using the same volume since
+// a ReadWriteOnce volume can't be mounted in pods on different nodes.
+type helperPod struct {
+	key     string          // key in the pods map
+	name    string          // name of the pod
+	claim   string          // name of the temporary claim or ""
+	refs    int             // number of Fs using the pod
+	cleanup atexit.FnHandle // delete the pod on exit
+}
+
+var (
+	podsMu sync.Mutex
+	pods   = map[string]*helperPod{}
+)
+
+// podKey returns the key identifying the pod f needs
+func (f *Fs) podKey() string {
+	return strings.Join([]string{f.apiConfig.server, f.namespace, f.opt.PVC, f.opt.Snapshot, strconv.FormatBool(f.opt.ReadOnly), f.opt.Image}, "\x00")
+}
+
+// getPod returns the helper pod for the volume, starting it if necessary
+func (f *Fs) getPod(ctx context.Context) (*helperPod, error) {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	key := f.podKey()
+	if pod, ok := pods[key]; ok {
+		pod.refs++
+		return pod, nil
+	}
+	pod := &helperPod{key: key}
+	f.pod = pod
+	err := f.startPod(ctx)
+	if err != nil {
+		f.deletePod(context.Background())
+		return nil, err
+	}
+	pod.refs = 1
+	pod.cleanup = atexit.Register(func() {
+		f.deletePod(context.Background())
+	})
+	pods[key] = pod
+	return pod, nil
+}
+
+// releasePod releases the helper pod, deleting it if it isn't in use
+func (f *Fs) releasePod(ctx context.Context) {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	pod := f.pod
+	pod.refs--
+	if pod.refs > 0 {
+		return
+	}
+	delete(pods, pod.key)
+	atexit.Unregister(pod.cleanup)
+	f.deletePod(ctx)
+}
+
+// podsPath returns the API path for the pods in the namespace
+func (f *Fs) podsPath() string {
+	return "/api/v1/namespaces/" + f.namespace + "/pods"
+}
+
+// claimsPath returns the API path for the claims in the namespace
+func (f *Fs) claimsPath() string {
+	return "/api/v1/namespaces/" + f.namespace + "/persistentvolumeclaims"
+}
+
+// startPod creates the helper pod, and the claim for a snapshot, and
+// waits for it to start
+func (f *Fs) startPod(ctx context.Context) error {
+	claim := f.opt.PVC
+	var restoreSize string
+	if f.opt.Snapshot != "" {
+		var snapshot api.VolumeSnapshot
+		opts := rest.Opts{
+			Method: "GET",
+			Path:   "/apis/" + snapshotAPIGroup + "/v1/namespaces/" + f.namespace + "/volumesnapshots/" + f.opt.Snapshot,
+		}
+		err := f.callJSON(ctx, &opts, nil, &snapshot)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read snapshot %q", f.opt.Snapshot)
+		}
+		if snapshot.Status.Error != nil {
+			return errors.Errorf("snapshot %q has an error: %s", f.opt.Snapshot, snapshot.Status.Error.Message)
+		}
+		if snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse || snapshot.Status.RestoreSize == "" {
+			return errors.Errorf("snapshot %q isn't ready to use", f.opt.Snapshot)
+		}
+		restoreSize = snapshot.Status.RestoreSize
+		claim = "rclone-" + random.String(10)
+		f.pod.claim = claim
+	}
+
+	pod := api.Pod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata: api.ObjectMeta{
+			GenerateName: "rclone-",
+			Labels:       map[string]string{managedByLabel: "rclone"},
+		},
+		Spec: api.PodSpec{
+			RestartPolicy: "Never",
+			Containers: []api.Container{{
+				Name:    containerName,
+				Image:   f.opt.Image,
+				Command: []string{"sleep", strconv.Itoa(int(time.Duration(f.opt.PodLifetime).Seconds()))},
+				VolumeMounts: []api.VolumeMount{{
+					Name:      volumeName,
+					MountPath: mountPath,
+					ReadOnly:  f.opt.ReadOnly,
+				}},
+			}},
+			Volumes: []api.Volume{{
+				Name: volumeName,
+				PersistentVolumeClaim: &api.PersistentVolumeClaimVolumeSource{
+					ClaimName: claim,
+					ReadOnly:  f.opt.ReadOnly,
+				},
+			}},
+		},
+	}
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   f.podsPath(),
+	}
+	var created api.Pod
+	err := f.callJSON(ctx, &opts, &pod, &created)
+	if err != nil {
+		return errors.Wrap(err, "failed to create helper pod")
+	}
+	f.pod.name = created.Metadata.Name
+	fs.Debugf(f, "Created helper pod %q", f.pod.name)
+
+	if f.pod.claim != "" {
+		apiGroup := snapshotAPIGroup
+		pvc := api.PersistentVolumeClaim{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Metadata: api.ObjectMeta{
+				Name:   f.pod.claim,
+				Labels: map[string]string{managedByLabel: "rclone"},
+				// delete the claim when the pod is deleted
+				OwnerReferences: []api.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       created.Metadata.Name,
+					UID:        created.Metadata.UID,
+				}},
+			},
+			Spec: api.PersistentVolumeClaimSpec{
+				AccessModes: []string{"ReadWriteOnce"},
+				DataSource: &api.TypedLocalObjectReference{
+					APIGroup: &apiGroup,
+					Kind:     "VolumeSnapshot",
+					Name:     f.opt.Snapshot,
+				},
+				Resources: api.ResourceRequirements{
+					Requests: map[string]string{"storage": restoreSize},
+				},
+			},
+		}
+		if f.opt.StorageClass != "" {
+			pvc.Spec.StorageClassName = &f.opt.StorageClass
+		}
+		opts := rest.Opts{
+			Method: "POST",
+			Path:   f.claimsPath(),
+		}
+		err = f.callJSON(ctx, &opts, &pvc, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to restore snapshot")
+		}
+		fs.Debugf(f, "Restoring snapshot %q to claim %q", f.opt.Snapshot, f.pod.claim)
+	}
+	return f.waitForPod(ctx)
+}
+
+// waitForPod waits for the helper pod to be running
+func (f *Fs) waitForPod(ctx context.Context) error {
+	deadline := time.Now().Add(time.Duration(f.opt.PodTimeout))
+	for {
+		var pod api.Pod
+		opts := rest.Opts{
+			Method: "GET",
+			Path:   f.podsPath() + "/" + f.pod.name,
+		}
+		err := f.callJSON(ctx, &opts, nil, &pod)
+		if err != nil {
+			return errors.Wrap(err, "failed to read helper pod")
+		}
+		reason := pod.Status.Reason
+		switch pod.Status.Phase {
+		case "Running":
+			ready := len(pod.Status.ContainerStatuses) > 0
+			for _, status := range pod.Status.ContainerStatuses {
+				ready = ready && status.Ready
+			}
+			if ready {
+				return nil
+			}
+		case "Failed", "Succeeded":
+			return errors.Errorf("helper pod %q stopped: %s %s", f.pod.name, pod.Status.Reason, pod.Status.Message)
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if waiting := status.State.Waiting; waiting != nil {
+				switch waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError":
+					return errors.Errorf("helper pod %q can't start: %s: %s", f.pod.name, waiting.Reason, waiting.Message)
+				}
+				reason = waiting.Reason
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("helper pod %q didn't start within %v: %s %s", f.pod.name, f.opt.PodTimeout, pod.Status.Phase, reason)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(podPollInterval):
+		}
+	}
+}
+
+// deletePod deletes the helper pod and the temporary claim
+func (f *Fs) deletePod(ctx context.Context) {
+	if f.pod.name != "" {
+		opts := rest.Opts{
+			Method:     "DELETE",
+			Path:       f.podsPath() + "/" + f.pod.name,
+			Parameters: map[string][]string{"gracePeriodSeconds": {"0"}},
+		}
+		err := f.callJSON(ctx, &opts, nil, nil)
+		if err != nil && !isNotFound(err) {
+			fs.Errorf(f, "Failed to delete helper pod %q: %v", f.pod.name, err)
+		} else {
+			fs.Debugf(f, "Deleted helper pod %q", f.pod.name)
+		}
+	}
+	if f.pod.claim != "" {
+		opts := rest.Opts{
+			Method: "DELETE",
+			Path:   f.claimsPath() + "/" + f.pod.claim,
+		}
+		err := f.callJSON(ctx, &opts, nil, nil)
+		if err != nil && !isNotFound(err) {
+			fs.Errorf(f, "Failed to delete claim %q: %v", f.pod.claim, err)
+		}
+	}
+}
+
+// Shutdown deletes the helper pod if no other remotes are using it
+func (f *Fs) Shutdown(ctx context.Context) error {
+	f.releasePod(ctx)
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// absPath returns the path of remote in the helper pod
+func (f *Fs) absPath(remote string) string {
+	return path.Join(mountPath, f.opt.Enc.FromStandardPath(path.Join(f.root, remote)))
+}
+
+// statEntry is a parsed line of stat output
+type statEntry struct {
+	mode    uint32
+	size    int64
+	modTime time.Time
+	name    string
+}
+
+// Mode bits of the types of files
+const (
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeRegular  = 0100000
+)
+
+// parseStat parses a line of stat output in statFormat
+func parseStat(line string) (entry statEntry, err error) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) != 4 {
+		return entry, errors.Errorf("bad stat output %q", line)
+	}
+	mode, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return entry, errors.Wrapf(err, "bad mode in stat output %q", line)
+	}
+	entry.size, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return entry, errors.Wrapf(err, "bad size in stat output %q", line)
+	}
+	seconds, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return entry, errors.Wrapf(err, "bad time in stat output %q", line)
+	}
+	entry.mode = uint32(mode)
+	entry.modTime = time.Unix(seconds, 0)
+	entry.name = parts[3]
+	return entry, nil
+}
+
+// stat reads the metadata of the file at remote
+//
+// It returns fs.ErrorObjectNotFound if it isn't a regular file
+func (f *Fs) stat(ctx context.Context, remote string) (entry statEntry, err error) {
+	out, err := f.script(ctx, `[ -f "$1" ] || exit 3; exec stat -c "$2" "$1"`, f.absPath(remote), statFormat)
+	if exitCode(err) == exitNotFound {
+		return entry, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return entry, err
+	}
+	entry, err = parseStat(strings.TrimRight(string(out), "\n"))
+	if err != nil {
+		return entry, err
+	}
+	if entry.mode&modeTypeMask != modeRegular {
+		return entry, fs.ErrorObjectNotFound
+	}
+	return entry, nil
+}
+
+// list lists dir calling fn for each entry, recursively if recurse is
+// set
+func (f *Fs) list(ctx context.Context, dir string, recurse bool, fn func(fs.DirEntry) error) error {
+	depth := ""
+	if !recurse {
+		depth = "-maxdepth 1"
+	}
+	out, err := f.script(ctx, `[ -d "$1" ] || exit 3; cd "$1" && exec find . -mindepth 1 `+depth+` -exec stat -c "$2" {} +`, f.absPath(dir), statFormat)
+	if exitCode(err) == exitNotFound {
+		return fs.ErrorDirNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "list failed")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseStat(scanner.Text())
+		if err != nil {
+			fs.Debugf(f, "Skipping: %v", err)
+			continue
+		}
+		remote := path.Join(dir, f.opt.Enc.ToStandardPath(strings.TrimPrefix(entry.name, "./")))
+		switch entry.mode & modeTypeMask {
+		case modeDir:
+			err = fn(fs.NewDir(remote, entry.modTime))
+		case modeRegular:
+			err = fn(f.newObject(remote, entry))
+		default:
+			fs.Debugf(f, "Skipping %q - not a regular file or directory", remote)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	err = f.list(ctx, dir, false, func(entry fs.DirEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	list := walk.NewListRHelper(callback)
+	err = f.list(ctx, dir, true, list.Add)
+	if err != nil {
+		return err
+	}
+	return list.Flush()
+}
+
+// newObject makes an Object from a stat entry
+func (f *Fs) newObject(remote string, entry statEntry) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		size:    entry.size,
+		modTime: entry.modTime,
+	}
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	entry, err := f.stat(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(remote, entry), nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if f.opt.ReadOnly {
+		return errorReadOnly
+	}
+	_, err := f.script(ctx, `exec mkdir -p "$1"`, f.absPath(dir))
+	return err
+}
+
+// Rmdir removes the directory
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if f.opt.ReadOnly {
+		return errorReadOnly
+	}
+	_, err := f.script(ctx, `[ -d "$1" ] || exit 3; [ -z "$(ls -A "$1")" ] || exit 4; exec rmdir "$1"`, f.absPath(dir))
+	switch exitCode(err) {
+	case exitNotFound:
+		return fs.ErrorDirNotFound
+	case exitNotEmpty:
+		return fs.ErrorDirectoryNotEmpty
+	}
+	return err
+}
+
+// Purge deletes all the files in the directory
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.opt.ReadOnly {
+		return errorReadOnly
+	}
+	_, err := f.script(ctx, `[ -d "$1" ] || exit 3; exec rm -rf "$1"`, f.absPath(dir))
+	if exitCode(err) == exitNotFound {
+		return fs.ErrorDirNotFound
+	}
+	return err
+}
+
+// Precision of the modification times
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5 | hash.SHA1)
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.fs.pod != f.pod {
+		fs.Debugf(src, "Can't move - not same volume")
+		return nil, fs.ErrorCantMove
+	}
+	dstPath := f.absPath(remote)
+	_, err := f.script(ctx, `[ -f "$1" ] || exit 3; mkdir -p "$3" && exec mv -f "$1" "$2"`, srcObj.fs.absPath(srcObj.remote), dstPath, path.Dir(dstPath))
+	if exitCode(err) == exitNotFound {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "move failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs.pod != f.pod {
+		fs.Debugf(srcFs, "Can't move directory - not same volume")
+		return fs.ErrorCantDirMove
+	}
+	dstPath := f.absPath(dstRemote)
+	_, err := f.script(ctx, `[ -d "$1" ] || exit 3; [ -e "$2" ] && exit 5; mkdir -p "$3" && exec mv "$1" "$2"`, srcFs.absPath(srcRemote), dstPath, path.Dir(dstPath))
+	switch exitCode(err) {
+	case exitNotFound:
+		return fs.ErrorDirNotFound
+	case exitDirExists:
+		return fs.ErrorDirExists
+	}
+	if err != nil {
+		return errors.Wrap(err, "dirmove failed")
+	}
+	return nil
+}
+
+// About gets quota information
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	out, err := f.script(ctx, `exec df -kP "$1"`, mountPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "about failed")
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return nil, errors.Errorf("about failed: bad df output %q", out)
+	}
+	var values [3]int64
+	for i := range values {
+		values[i], err = strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "about failed: bad df output %q", out)
+		}
+	}
+	usage := &fs.Usage{
+		Total: fs.NewUsageValue(values[0] * 1024), // quota of bytes that can be used
+		Used:  fs.NewUsageValue(values[1] * 1024), // bytes in use
+		Free:  fs.NewUsageValue(values[2] * 1024), // bytes which can be uploaded before reaching the quota
+	}
+	return usage, nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the MD5 or SHA1 of the object, reading the whole file
+// in the helper pod
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	var command string
+	var cached *string
+	switch t {
+	case hash.MD5:
+		command, cached = "md5sum", &o.md5
+	case hash.SHA1:
+		command, cached = "sha1sum", &o.sha1
+	default:
+		return "", hash.ErrUnsupported
+	}
+	if *cached != "" {
+		return *cached, nil
+	}
+	// read from stdin as md5sum escapes file names with \ in them
+	out, err := o.fs.script(ctx, `[ -f "$1" ] || exit 3; exec `+command+` < "$1"`, o.fs.absPath(o.remote))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", t)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", errors.Errorf("failed to read %s: bad output %q", t, out)
+	}
+	*cached = strings.ToLower(fields[0])
+	return *cached, nil
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// readMetaData reads the metadata of the object
+func (o *Object) readMetaData(ctx context.Context) error {
+	entry, err := o.fs.stat(ctx, o.remote)
+	if err != nil {
+		return err
+	}
+	o.size = entry.size
+	o.modTime = entry.modTime
+	return nil
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if o.fs.opt.ReadOnly {
+		return errorReadOnly
+	}
+	_, err := o.fs.script(ctx, `[ -f "$1" ] || exit 3; exec touch -c -d "@$2" "$1"`, o.fs.absPath(o.remote), strconv.FormatInt(modTime.Unix(), 10))
+	if exitCode(err) == exitNotFound {
+		return fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+	return o.readMetaData(ctx)
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	script := `[ -f "$1" ] || exit 3; exec cat "$1"`
+	switch {
+	case limit >= 0:
+		script = `[ -f "$1" ] || exit 3; tail -c +"$2" "$1" | head -c "$3"`
+	case offset > 0:
+		script = `[ -f "$1" ] || exit 3; exec tail -c +"$2" "$1"`
+	}
+	var e *execution
+	err = o.fs.pacer.Call(func() (bool, error) {
+		e, err = o.fs.start(ctx, false, "sh", "-c", script, "rclone", o.fs.absPath(o.remote), strconv.FormatInt(offset+1, 10), strconv.FormatInt(limit, 10))
+		return shouldRetry(nil, err)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "open failed")
+	}
+	return &execReader{e: e}, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// The file is written to a temporary name then renamed so a failed
+// upload doesn't leave a partial file.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	f := o.fs
+	if f.opt.ReadOnly {
+		return errorReadOnly
+	}
+	size := src.Size()
+	if size < 0 {
+		return errors.New("can't upload files of unknown size")
+	}
+	filePath := f.absPath(o.remote)
+	const script = `mkdir -p "$2" || exit 1
+head -c "$3" > "$4"
+if [ "$(stat -c %s "$4")" != "$3" ]; then rm -f "$4"; exit 6; fi
+touch -c -d "@$5" "$4" && exec mv -f "$4" "$1"`
+	e, err := f.start(ctx, true, "sh", "-c", script, "rclone", filePath, path.Dir(filePath), strconv.FormatInt(size, 10), filePath+partialSuffix, strconv.FormatInt(src.ModTime(ctx).Unix(), 10))
+	if err != nil {
+		return errors.Wrap(err, "upload failed")
+	}
+	buf := make([]byte, uploadBufferSize)
+	for {
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			if err = e.write(buf[:n]); err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+	if err != nil {
+		// closing the connection closes stdin so the script
+		// removes the partial file
+		e.close()
+		_ = e.wait()
+		return errors.Wrap(err, "upload failed")
+	}
+	_, _ = io.Copy(ioutil.Discard, e.stdout)
+	err = e.wait()
+	if exitCode(err) == exitShort {
+		return errors.New("upload failed: file incomplete")
+	}
+	if err != nil {
+		return errors.Wrap(err, "upload failed")
+	}
+	o.md5, o.sha1 = "", ""
+	return o.readMetaData(ctx)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	if o.fs.opt.ReadOnly {
+		return errorReadOnly
+	}
+	_, err := o.fs.script(ctx, `[ -f "$1" ] || exit 3; exec rm -f "$1"`, o.fs.absPath(o.remote))
+	if exitCode(err) == exitNotFound {
+		return fs.ErrorObjectNotFound
+	}
+	return err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs         = &Fs{}
+	_ fs.Purger     = &Fs{}
+	_ fs.Mover      = &Fs{}
+	_ fs.DirMover   = &Fs{}
+	_ fs.ListRer    = &Fs{}
+	_ fs.Abouter    = &Fs{}
+	_ fs.Shutdowner = &Fs{}
+	_ fs.Object     = &Object{}
+)
@@ -0,0 +1,483 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/kubernetes/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	testNamespace = "backup"
+	testToken     = "potato"
+)
+
+func TestKubeConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-kubernetes-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	kubeConfig := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(kubeConfig, []byte(`apiVersion: v1
+kind: Config
+current-context: one
+clusters:
+- name: c1
+  cluster:
+    server: https://one.example.com:6443
+    insecure-skip-tls-verify: true
+- name: c2
+  cluster:
+    server: https://two.example.com
+contexts:
+- name: one
+  context:
+    cluster: c1
+    user: u1
+    namespace: ns1
+- name: two
+  context:
+    cluster: c2
+    user: u2
+- name: plugin
+  context:
+    cluster: c2
+    user: u3
+users:
+- name: u1
+  user:
+    token: inline-token
+- name: u2
+  user:
+    tokenFile: token
+- name: u3
+  user:
+    exec:
+      command: aws
+`), 0600))
+
+	config, err := loadKubeConfig(kubeConfig, "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://one.example.com:6443", config.server)
+	assert.Equal(t, "ns1", config.namespace)
+	assert.Equal(t, "inline-token", config.token)
+	assert.True(t, config.tls.InsecureSkipVerify)
+
+	config, err = loadKubeConfig(kubeConfig, "two")
+	require.NoError(t, err)
+	assert.Equal(t, "https://two.example.com", config.server)
+	assert.Equal(t, "", config.namespace)
+	assert.Equal(t, "file-token", config.token)
+
+	_, err = loadKubeConfig(kubeConfig, "plugin")
+	assert.Error(t, err)
+	_, err = loadKubeConfig(kubeConfig, "potato")
+	assert.Error(t, err)
+}
+
+func TestParseStat(t *testing.T) {
+	entry, err := parseStat("81a4 123 1600000000 ./dir/file with spaces")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0100644), entry.mode)
+	assert.Equal(t, int64(123), entry.size)
+	assert.Equal(t, time.Unix(1600000000, 0), entry.modTime)
+	assert.Equal(t, "./dir/file with spaces", entry.name)
+	_, err = parseStat("81a4 123")
+	assert.Error(t, err)
+	_, err = parseStat("zzzz 123 1600000000 file")
+	assert.Error(t, err)
+}
+
+func TestStatusExitCode(t *testing.T) {
+	var status api.Status
+	require.NoError(t, json.Unmarshal([]byte(`{"metadata":{},"status":"Failure","message":"command terminated with non-zero exit code","reason":"NonZeroExitCode","details":{"causes":[{"reason":"ExitCode","message":"3"}]}}`), &status))
+	assert.Equal(t, 3, status.ExitCode())
+	assert.Equal(t, 0, (&api.Status{Status: "Success"}).ExitCode())
+	assert.Equal(t, -1, (&api.Status{Status: "Failure", Reason: "InternalError"}).ExitCode())
+}
+
+// fakeServer is a fake Kubernetes API server
+//
+// Commands run with exec are run locally with the mount path
+// replaced by a temporary directory.
+type fakeServer struct {
+	t      *testing.T
+	root   string
+	server *httptest.Server
+
+	mu      sync.Mutex
+	pods    map[string]api.Pod
+	claims  map[string]api.PersistentVolumeClaim
+	deleted []string
+	counter int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	if runtime.GOOS != "linux" {
+		t.Skip("fake server needs GNU utilities")
+	}
+	root, err := ioutil.TempDir("", "rclone-kubernetes-test")
+	require.NoError(t, err)
+	srv := &fakeServer{
+		t:      t,
+		root:   root,
+		pods:   map[string]api.Pod{},
+		claims: map[string]api.PersistentVolumeClaim{},
+	}
+	srv.server = httptest.NewServer(srv)
+	return srv
+}
+
+// close the server and remove its files
+func (srv *fakeServer) close() {
+	srv.server.Close()
+	_ = os.RemoveAll(srv.root)
+}
+
+// kubeConfig writes a kubeconfig for the server returning its path
+func (srv *fakeServer) kubeConfig() string {
+	kubeConfig := filepath.Join(srv.root, ".kubeconfig")
+	require.NoError(srv.t, ioutil.WriteFile(kubeConfig, []byte(fmt.Sprintf(`current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: %s
+users:
+- name: test
+  user:
+    token: %s
+`, srv.server.URL, testNamespace, testToken)), 0600))
+	return kubeConfig
+}
+
+// config returns the config for a remote using the server
+func (srv *fakeServer) config() configmap.Simple {
+	return configmap.Simple{
+		"kubeconfig":   srv.kubeConfig(),
+		"pvc":          "data",
+		"image":        "busybox:stable",
+		"pod_timeout":  "10s",
+		"pod_lifetime": "1h",
+		"encoding":     "Slash,CrLf,InvalidUtf8,Dot",
+	}
+}
+
+// writeJSON writes v as the response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ServeHTTP serves the API
+func (srv *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		writeJSON(w, http.StatusUnauthorized, api.Status{Status: "Failure", Reason: "Unauthorized", Code: 401})
+		return
+	}
+	notFound := func() {
+		writeJSON(w, http.StatusNotFound, api.Status{Status: "Failure", Reason: "NotFound", Code: 404, Message: r.URL.Path + " not found"})
+	}
+	prefix := "/api/v1/namespaces/" + testNamespace + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		if r.URL.Path == "/apis/snapshot.storage.k8s.io/v1/namespaces/"+testNamespace+"/volumesnapshots/snap" {
+			ready := true
+			writeJSON(w, http.StatusOK, api.VolumeSnapshot{Status: api.VolumeSnapshotStatus{ReadyToUse: &ready, RestoreSize: "1Gi"}})
+			return
+		}
+		notFound()
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if len(parts) == 3 && parts[0] == "pods" && parts[2] == "exec" {
+		srv.mu.Lock()
+		_, ok := srv.pods[parts[1]]
+		srv.mu.Unlock()
+		if !ok {
+			notFound()
+			return
+		}
+		websocket.Server{
+			Handshake: func(config *websocket.Config, r *http.Request) error {
+				config.Protocol = []string{execProtocol}
+				return nil
+			},
+			Handler: srv.exec,
+		}.ServeHTTP(w, r)
+		return
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	switch {
+	case parts[0] == "pods" && len(parts) == 1 && r.Method == "POST":
+		var pod api.Pod
+		require.NoError(srv.t, json.NewDecoder(r.Body).Decode(&pod))
+		srv.counter++
+		pod.Metadata.Name = fmt.Sprintf("%s%d", pod.Metadata.GenerateName, srv.counter)
+		pod.Metadata.UID = fmt.Sprintf("uid-%d", srv.counter)
+		srv.pods[pod.Metadata.Name] = pod
+		writeJSON(w, http.StatusCreated, pod)
+	case parts[0] == "pods" && len(parts) == 2:
+		pod, ok := srv.pods[parts[1]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case "GET":
+			pod.Status.Phase = "Running"
+			pod.Status.ContainerStatuses = []api.ContainerStatus{{Name: containerName, Ready: true}}
+			writeJSON(w, http.StatusOK, pod)
+		case "DELETE":
+			delete(srv.pods, parts[1])
+			srv.deleted = append(srv.deleted, "pod/"+parts[1])
+			writeJSON(w, http.StatusOK, pod)
+		}
+	case parts[0] == "persistentvolumeclaims" && len(parts) == 1 && r.Method == "POST":
+		var pvc api.PersistentVolumeClaim
+		require.NoError(srv.t, json.NewDecoder(r.Body).Decode(&pvc))
+		srv.claims[pvc.Metadata.Name] = pvc
+		writeJSON(w, http.StatusCreated, pvc)
+	case parts[0] == "persistentvolumeclaims" && len(parts) == 2 && r.Method == "DELETE":
+		delete(srv.claims, parts[1])
+		srv.deleted = append(srv.deleted, "pvc/"+parts[1])
+		writeJSON(w, http.StatusOK, api.Status{Status: "Success"})
+	default:
+		notFound()
+	}
+}
+
+// send sends data on the channel
+func send(ws *websocket.Conn, channel byte, data []byte) {
+	_ = websocket.Message.Send(ws, append([]byte{channel}, data...))
+}
+
+// channelWriter writes to a channel of the websocket
+type channelWriter struct {
+	ws      *websocket.Conn
+	channel byte
+}
+
+func (w channelWriter) Write(p []byte) (int, error) {
+	send(w.ws, w.channel, p)
+	return len(p), nil
+}
+
+// exec runs the command locally
+func (srv *fakeServer) exec(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	query := ws.Request().URL.Query()
+	args := query["command"]
+	for i := range args {
+		if args[i] == mountPath || strings.HasPrefix(args[i], mountPath+"/") {
+			args[i] = srv.root + args[i][len(mountPath):]
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = channelWriter{ws, stdoutChannel}
+	cmd.Stderr = channelWriter{ws, stderrChannel}
+	if query.Get("stdin") == "true" {
+		stdin, err := cmd.StdinPipe()
+		require.NoError(srv.t, err)
+		go func() {
+			defer func() {
+				_ = stdin.Close()
+			}()
+			for {
+				var message []byte
+				if websocket.Message.Receive(ws, &message) != nil {
+					return
+				}
+				if len(message) > 0 && message[0] == stdinChannel {
+					if _, err := stdin.Write(message[1:]); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	err := cmd.Run()
+	status := api.Status{Status: "Success"}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status = api.Status{
+			Status: "Failure",
+			Reason: "NonZeroExitCode",
+			Details: api.StatusDetails{Causes: []api.StatusCause{{
+				Reason:  "ExitCode",
+				Message: fmt.Sprint(exitErr.ExitCode()),
+			}}},
+		}
+	} else if err != nil {
+		status = api.Status{Status: "Failure", Reason: "InternalError", Message: err.Error()}
+	}
+	data, _ := json.Marshal(status)
+	send(ws, errorChannel, data)
+}
+
+func TestPod(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+
+	f1, err := NewFs(ctx, "TestKubernetes", "", srv.config())
+	require.NoError(t, err)
+	f2, err := NewFs(ctx, "TestKubernetes", "dir", srv.config())
+	require.NoError(t, err)
+
+	// the pod is shared
+	srv.mu.Lock()
+	require.Equal(t, 1, len(srv.pods))
+	for _, pod := range srv.pods {
+		assert.Equal(t, "data", pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+		assert.False(t, pod.Spec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+		assert.Equal(t, []string{"sleep", "3600"}, pod.Spec.Containers[0].Command)
+		assert.Equal(t, "rclone", pod.Metadata.Labels[managedByLabel])
+	}
+	srv.mu.Unlock()
+
+	require.NoError(t, f1.Features().Shutdown(ctx))
+	assert.Equal(t, 0, len(srv.deleted))
+	require.NoError(t, f2.Features().Shutdown(ctx))
+	assert.Equal(t, []string{"pod/rclone-1"}, srv.deleted)
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, "file.txt"), []byte("hello"), 0644))
+
+	m := srv.config()
+	m.Set("pvc", "")
+	m.Set("snapshot", "snap")
+	m.Set("storage_class", "fast")
+	f, err := NewFs(ctx, "TestKubernetes", "file.txt", m)
+	assert.Equal(t, fs.ErrorIsFile, err)
+
+	srv.mu.Lock()
+	require.Equal(t, 1, len(srv.claims))
+	var claimName string
+	for name, claim := range srv.claims {
+		claimName = name
+		assert.Equal(t, "snap", claim.Spec.DataSource.Name)
+		assert.Equal(t, "VolumeSnapshot", claim.Spec.DataSource.Kind)
+		assert.Equal(t, "1Gi", claim.Spec.Resources.Requests["storage"])
+		assert.Equal(t, "fast", *claim.Spec.StorageClassName)
+		assert.Equal(t, "rclone-1", claim.Metadata.OwnerReferences[0].Name)
+		assert.Equal(t, "uid-1", claim.Metadata.OwnerReferences[0].UID)
+	}
+	for _, pod := range srv.pods {
+		assert.Equal(t, claimName, pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+		assert.True(t, pod.Spec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+	}
+	srv.mu.Unlock()
+
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 1, End: 3})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "ell", string(data))
+
+	assert.Equal(t, errorReadOnly, f.Mkdir(ctx, "dir"))
+	assert.Equal(t, errorReadOnly, o.Remove(ctx))
+	assert.Nil(t, f.Features().Move)
+
+	require.NoError(t, f.Features().Shutdown(ctx))
+	assert.Equal(t, []string{"pod/rclone-1", "pvc/" + claimName}, srv.deleted)
+}
+
+func TestOpenClose(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, "big"), data, 0644))
+	f, err := NewFs(ctx, "TestKubernetes", "", srv.config())
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Features().Shutdown(ctx)
+	}()
+	o, err := f.NewObject(ctx, "big")
+	require.NoError(t, err)
+
+	// closing part way through stops the command
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(in, buf)
+	require.NoError(t, err)
+	assert.Equal(t, data[:100], buf)
+	require.NoError(t, in.Close())
+
+	sum, err := o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum(data)), sum)
+
+	// a missing file returns an error from Read
+	o.(*Object).remote = "missing"
+	in, err = o.Open(ctx)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(in)
+	assert.Equal(t, exitNotFound, exitCode(err))
+	require.NoError(t, in.Close())
+}
+
+func TestAuthFailure(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	m := srv.config()
+	data, err := ioutil.ReadFile(m["kubeconfig"])
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(m["kubeconfig"], bytes.Replace(data, []byte(testToken), []byte("wrong"), 1), 0600))
+	_, err = NewFs(context.Background(), "TestKubernetes", "", m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+// TestIntegrationFake runs the integration tests against the fake
+// server
+func TestIntegrationFake(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	for key, value := range srv.config() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestKubernetesFake", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestKubernetesFake", "type"), "kubernetes"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestKubernetesFake:",
+		NilObject:  (*Object)(nil),
+	})
+}
@@ -0,0 +1,63 @@
+// Directory modification times and metadata
+
+package local
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// dirError converts the error from an operation on a directory
+func dirError(err error) error {
+	if os.IsNotExist(err) {
+		return fs.ErrorDirNotFound
+	}
+	return err
+}
+
+// DirSetModTime sets the modification time of the directory dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	if f.opt.NoSetModTime {
+		return nil
+	}
+	return dirError(os.Chtimes(f.localPath(dir), modTime, modTime))
+}
+
+// DirMetadata returns the metadata of the directory dir
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	localPath := f.localPath(dir)
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return nil, dirError(err)
+	}
+	if !fi.IsDir() {
+		return nil, fs.ErrorIsFile
+	}
+	metadata := fs.Metadata{
+		"mtime": fi.ModTime().Format(time.RFC3339Nano),
+	}
+	err = readMetadata(localPath, fi, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// DirSetMetadata sets the metadata of the directory dir
+//
+// The modification time is set with DirSetModTime so any "mtime" is
+// ignored.
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	localPath := f.localPath(dir)
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return dirError(err)
+	}
+	if !fi.IsDir() {
+		return fs.ErrorIsFile
+	}
+	return writeMetadata(localPath, fi, metadata)
+}
@@ -0,0 +1,22 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package local
+
+import (
+	"os"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// readMetadata reads the metadata of the file or directory at
+// localPath into metadata - only the modification time is supported
+// here
+func readMetadata(localPath string, fi os.FileInfo, metadata fs.Metadata) error {
+	return nil
+}
+
+// writeMetadata sets the metadata of the file or directory at
+// localPath - only the modification time is supported here
+func writeMetadata(localPath string, fi os.FileInfo, metadata fs.Metadata) error {
+	return nil
+}
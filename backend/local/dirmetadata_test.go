@@ -0,0 +1,52 @@
+// +build linux
+
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestDirMetadata(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	f := r.Flocal.(*Fs)
+	require.NoError(t, os.MkdirAll(filepath.Join(f.root, "dir"), 0755))
+	require.NoError(t, os.Chmod(filepath.Join(f.root, "dir"), 0755))
+
+	modTime := fstest.Time("2001-02-03T04:05:10.123123123Z")
+	require.NoError(t, f.DirSetModTime(ctx, "dir", modTime))
+	md, err := f.DirMetadata(ctx, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, "2001-02-03T04:05:10.123123123Z", md["mtime"])
+	assert.Equal(t, "755", md["mode"])
+	assert.NotEqual(t, "", md["uid"])
+
+	md["mode"] = "700"
+	xattrs := unix.Setxattr(filepath.Join(f.root, "dir"), "user.test", []byte("x"), 0) == nil
+	if xattrs {
+		md["user.potato"] = "jersey royal"
+	}
+	require.NoError(t, f.DirSetMetadata(ctx, "dir", md))
+	got, err := f.DirMetadata(ctx, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, "700", got["mode"])
+	if xattrs {
+		assert.Equal(t, "jersey royal", got["user.potato"])
+		_, found := got["user.test"]
+		assert.False(t, found, "extended attribute not in the metadata should be removed")
+	}
+
+	_, err = f.DirMetadata(ctx, "notfound")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+	assert.Equal(t, fs.ErrorDirNotFound, f.DirSetModTime(ctx, "notfound", modTime))
+}
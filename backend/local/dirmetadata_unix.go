@@ -0,0 +1,73 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package local
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/sys/unix"
+)
+
+// readMetadata reads the permissions, owner and extended
+// attributes of the file or directory at localPath into metadata
+func readMetadata(localPath string, fi os.FileInfo, metadata fs.Metadata) error {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		metadata["mode"] = strconv.FormatUint(uint64(stat.Mode)&07777, 8)
+		metadata["uid"] = strconv.FormatUint(uint64(stat.Uid), 10)
+		metadata["gid"] = strconv.FormatUint(uint64(stat.Gid), 10)
+	}
+	xattrs, err := readXattrs(localPath)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		metadata[name] = value
+	}
+	return nil
+}
+
+// writeMetadata sets the permissions, owner and extended
+// attributes of the file or directory at localPath from metadata
+func writeMetadata(localPath string, fi os.FileInfo, metadata fs.Metadata) error {
+	current := fs.Metadata{}
+	err := readMetadata(localPath, fi, current)
+	if err != nil {
+		return err
+	}
+	if mode, ok := metadata["mode"]; ok && mode != current["mode"] {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return errors.Wrapf(err, "bad mode %q", mode)
+		}
+		err = unix.Chmod(localPath, uint32(perm))
+		if err != nil {
+			return errors.Wrap(err, "failed to set mode")
+		}
+	}
+	uid, gid := metadata["uid"], metadata["gid"]
+	if (uid != "" && uid != current["uid"]) || (gid != "" && gid != current["gid"]) {
+		newUID, newGID := -1, -1
+		if uid != "" {
+			if newUID, err = strconv.Atoi(uid); err != nil {
+				return errors.Wrapf(err, "bad uid %q", uid)
+			}
+		}
+		if gid != "" {
+			if newGID, err = strconv.Atoi(gid); err != nil {
+				return errors.Wrapf(err, "bad gid %q", gid)
+			}
+		}
+		err = os.Chown(localPath, newUID, newGID)
+		if os.IsPermission(err) {
+			// Only root can give files away so don't fail the sync
+			fs.Debugf(localPath, "Not permitted to set owner to %s:%s", uid, gid)
+		} else if err != nil {
+			return errors.Wrap(err, "failed to set owner")
+		}
+	}
+	return writeXattrs(localPath, current, metadata)
+}
@@ -0,0 +1,13 @@
+// Inode reading functions
+
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package local
+
+import "os"
+
+// readInode turns a valid os.FileInfo into an identifier made from
+// its device and inode numbers, returning "" if it fails.
+func readInode(fi os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,21 @@
+// Inode reading functions
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package local
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// readInode turns a valid os.FileInfo into an identifier made from
+// its device and inode numbers, returning "" if it fails.
+func readInode(fi os.FileInfo) string {
+	statT, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", uint64(statT.Dev), uint64(statT.Ino)) // nolint: unconvert
+}
@@ -207,6 +207,7 @@ type Object struct {
 	size    int64 // file metadata - always present
 	mode    os.FileMode
 	modTime time.Time
+	inode   string               // device and inode numbers if known
 	hashes  map[hash.Type]string // Hashes
 	// these are read only and don't need the mutex held
 	translatedLink bool // Is this object a translated link
@@ -915,6 +916,14 @@ func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
 	return o.lstat()
 }
 
+// Inode returns the device and inode numbers of the file, which are
+// kept when it is renamed, or "" if not known
+func (o *Object) Inode() string {
+	o.fs.objectMetaMu.RLock()
+	defer o.fs.objectMetaMu.RUnlock()
+	return o.inode
+}
+
 // Storable returns a boolean showing if this object is storable
 func (o *Object) Storable() bool {
 	o.fs.objectMetaMu.RLock()
@@ -1220,6 +1229,27 @@ func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.Wr
 	return out, nil
 }
 
+// UpdateWriterAt opens an existing file with a handle for random
+// access writes without truncating it
+//
+// The file is truncated or extended to size.
+func (f *Fs) UpdateWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	o := f.newObject(remote)
+	if o.translatedLink {
+		return nil, errors.New("can't open a symlink for random writing")
+	}
+	out, err := file.OpenFile(o.path, os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = out.Truncate(size)
+	if err != nil {
+		_ = out.Close()
+		return nil, errors.Wrap(err, "failed to set size")
+	}
+	return out, nil
+}
+
 // setMetadata sets the file info from the os.FileInfo passed in
 func (o *Object) setMetadata(info os.FileInfo) {
 	// if not checking updated then don't update the stat
@@ -1230,6 +1260,7 @@ func (o *Object) setMetadata(info os.FileInfo) {
 	o.size = info.Size()
 	o.modTime = info.ModTime()
 	o.mode = info.Mode()
+	o.inode = readInode(info)
 	o.fs.objectMetaMu.Unlock()
 	// On Windows links read as 0 size so set the correct size here
 	if runtime.GOOS == "windows" && o.translatedLink {
@@ -1287,12 +1318,18 @@ func cleanRootPath(s string, noUNC bool, enc encoder.MultiEncoder) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs             = &Fs{}
-	_ fs.Purger         = &Fs{}
-	_ fs.PutStreamer    = &Fs{}
-	_ fs.Mover          = &Fs{}
-	_ fs.DirMover       = &Fs{}
-	_ fs.Commander      = &Fs{}
-	_ fs.OpenWriterAter = &Fs{}
-	_ fs.Object         = &Object{}
+	_ fs.Fs               = &Fs{}
+	_ fs.Purger           = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.Mover            = &Fs{}
+	_ fs.DirMover         = &Fs{}
+	_ fs.DirSetModTimer   = &Fs{}
+	_ fs.DirMetadataer    = &Fs{}
+	_ fs.Commander        = &Fs{}
+	_ fs.OpenWriterAter   = &Fs{}
+	_ fs.UpdateWriterAter = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.Metadataer       = &Object{}
+	_ fs.SetMetadataer    = &Object{}
+	_ fs.Inoder           = &Object{}
 )
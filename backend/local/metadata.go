@@ -0,0 +1,54 @@
+// Object metadata
+
+package local
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// objectError converts the error from an operation on an object
+func objectError(err error) error {
+	if os.IsNotExist(err) {
+		return fs.ErrorObjectNotFound
+	}
+	return err
+}
+
+// Metadata returns the metadata of the object
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	fi, err := os.Stat(o.path)
+	if err != nil {
+		return nil, objectError(err)
+	}
+	metadata := fs.Metadata{
+		"mtime": fi.ModTime().Format(time.RFC3339Nano),
+	}
+	if o.translatedLink {
+		// the metadata is of the file the link points to
+		return metadata, nil
+	}
+	err = readMetadata(o.path, fi, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the metadata of the object
+//
+// The modification time is set with SetModTime so any "mtime" is
+// ignored.
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	if o.translatedLink {
+		return nil
+	}
+	fi, err := os.Stat(o.path)
+	if err != nil {
+		return objectError(err)
+	}
+	return writeMetadata(o.path, fi, metadata)
+}
@@ -0,0 +1,44 @@
+// +build linux
+
+package local
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	f := r.Flocal.(*Fs)
+	file := r.WriteFile("file.txt", "hello", fstest.Time("2001-02-03T04:05:10.123123123Z"))
+	o, err := f.NewObject(ctx, file.Path)
+	require.NoError(t, err)
+
+	md, err := fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, "2001-02-03T04:05:10.123123123Z", md["mtime"])
+	assert.NotEqual(t, "", md["mode"])
+
+	localPath := filepath.Join(f.root, "file.txt")
+	if unix.Setxattr(localPath, "user.test", []byte("x"), 0) != nil {
+		t.Skip("extended attributes not supported")
+	}
+	md, err = fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{"user.test": "x"}, md.Xattrs())
+
+	err = o.(fs.SetMetadataer).SetMetadata(ctx, fs.Metadata{"user.potato": "jersey royal"})
+	require.NoError(t, err)
+	md, err = fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{"user.potato": "jersey royal"}, md.Xattrs())
+}
@@ -0,0 +1,81 @@
+// +build linux
+
+package local
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/sys/unix"
+)
+
+// xattrPrefix is the namespace of the extended attributes which are
+// read and written - the others need privileges to set
+const xattrPrefix = "user."
+
+// readXattrs returns the extended attributes of localPath
+func readXattrs(localPath string) (map[string]string, error) {
+	size, err := unix.Listxattr(localPath, nil)
+	if err == unix.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to list extended attributes")
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(localPath, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list extended attributes")
+	}
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if !strings.HasPrefix(string(name), xattrPrefix) {
+			continue
+		}
+		valueSize, err := unix.Getxattr(localPath, string(name), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read extended attribute %q", name)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Getxattr(localPath, string(name), value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read extended attribute %q", name)
+		}
+		xattrs[string(name)] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
+
+// writeXattrs sets the extended attributes of localPath in metadata
+// and removes the ones in current which aren't in metadata
+func writeXattrs(localPath string, current, metadata fs.Metadata) error {
+	for name, value := range metadata {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+		if currentValue, ok := current[name]; ok && currentValue == value {
+			continue
+		}
+		err := unix.Setxattr(localPath, name, []byte(value), 0)
+		if err != nil {
+			return errors.Wrapf(err, "failed to set extended attribute %q", name)
+		}
+	}
+	for name := range current {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+		if _, ok := metadata[name]; ok {
+			continue
+		}
+		err := unix.Removexattr(localPath, name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove extended attribute %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package local
+
+import "github.com/rclone/rclone/fs"
+
+// readXattrs returns the extended attributes of localPath - they
+// aren't supported on this OS
+func readXattrs(localPath string) (map[string]string, error) {
+	return nil, nil
+}
+
+// writeXattrs sets the extended attributes of localPath - they
+// aren't supported on this OS
+func writeXattrs(localPath string, current, metadata fs.Metadata) error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package ltfs
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/encoder"
+)
+
+// Reading the index an LTFS volume keeps of its contents [LTFS Format
+// Specification 2.4, section 7].
+
+// XML of the index
+type xmlIndex struct {
+	XMLName          xml.Name     `xml:"ltfsindex"`
+	VolumeUUID       string       `xml:"volumeuuid"`
+	GenerationNumber int64        `xml:"generationnumber"`
+	UpdateTime       string       `xml:"updatetime"`
+	Directory        xmlDirectory `xml:"directory"`
+}
+
+type xmlName struct {
+	Value          string `xml:",chardata"`
+	PercentEncoded bool   `xml:"percentencoded,attr"`
+}
+
+type xmlDirectory struct {
+	Name       xmlName `xml:"name"`
+	ModifyTime string  `xml:"modifytime"`
+	Contents   struct {
+		Files       []xmlFile      `xml:"file"`
+		Directories []xmlDirectory `xml:"directory"`
+	} `xml:"contents"`
+}
+
+type xmlFile struct {
+	Name       xmlName     `xml:"name"`
+	Length     int64       `xml:"length"`
+	ModifyTime string      `xml:"modifytime"`
+	Symlink    *string     `xml:"symlink"`
+	Extents    []xmlExtent `xml:"extentinfo>extent"`
+}
+
+type xmlExtent struct {
+	FileOffset int64  `xml:"fileoffset"`
+	Partition  string `xml:"partition"`
+	StartBlock int64  `xml:"startblock"`
+}
+
+// position is where a file starts on the tape
+type position struct {
+	partition  string
+	startBlock int64
+}
+
+// isSet returns whether the position is known
+func (p position) isSet() bool {
+	return p.partition != ""
+}
+
+// String returns the position as partition:block
+func (p position) String() string {
+	return p.partition + ":" + strconv.FormatInt(p.startBlock, 10)
+}
+
+// indexEntry is a file or directory in the index
+type indexEntry struct {
+	remote  string // path from the root of the volume
+	isDir   bool
+	size    int64
+	modTime time.Time
+	pos     position
+}
+
+// index is the contents of an LTFS volume read from its index
+type index struct {
+	volumeUUID string
+	generation int64
+	entries    map[string]*indexEntry   // by path from the root of the volume
+	children   map[string][]*indexEntry // by path of the parent directory
+}
+
+// readIndex reads the index from the file at indexPath
+func readIndex(indexPath string) (*index, error) {
+	in, err := os.Open(indexPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open LTFS index")
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	idx, err := parseIndex(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read LTFS index %q", indexPath)
+	}
+	return idx, nil
+}
+
+// parseIndex parses the XML of an index
+func parseIndex(in io.Reader) (*index, error) {
+	var x xmlIndex
+	err := xml.NewDecoder(in).Decode(&x)
+	if err != nil {
+		return nil, err
+	}
+	idx := &index{
+		volumeUUID: x.VolumeUUID,
+		generation: x.GenerationNumber,
+		entries:    map[string]*indexEntry{},
+		children:   map[string][]*indexEntry{},
+	}
+	root := &indexEntry{
+		isDir:   true,
+		modTime: parseTime(x.Directory.ModifyTime),
+	}
+	idx.entries[""] = root
+	err = idx.addDirectory("", &x.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// addDirectory adds the contents of the directory at dir to the index
+func (idx *index) addDirectory(dir string, d *xmlDirectory) error {
+	for i := range d.Contents.Files {
+		file := &d.Contents.Files[i]
+		if file.Symlink != nil {
+			continue
+		}
+		name, err := decodeName(file.Name)
+		if err != nil {
+			return err
+		}
+		entry := &indexEntry{
+			remote:  path.Join(dir, name),
+			size:    file.Length,
+			modTime: parseTime(file.ModifyTime),
+		}
+		for _, extent := range file.Extents {
+			if extent.FileOffset == 0 {
+				entry.pos = position{partition: extent.Partition, startBlock: extent.StartBlock}
+				break
+			}
+		}
+		idx.add(dir, entry)
+	}
+	for i := range d.Contents.Directories {
+		subDir := &d.Contents.Directories[i]
+		name, err := decodeName(subDir.Name)
+		if err != nil {
+			return err
+		}
+		entry := &indexEntry{
+			remote:  path.Join(dir, name),
+			isDir:   true,
+			modTime: parseTime(subDir.ModifyTime),
+		}
+		idx.add(dir, entry)
+		err = idx.addDirectory(entry.remote, subDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds entry to the index in dir
+func (idx *index) add(dir string, entry *indexEntry) {
+	idx.entries[entry.remote] = entry
+	idx.children[dir] = append(idx.children[dir], entry)
+}
+
+// decodeName returns the standard name for the name in the index
+//
+// This uses the same encoding as the local backend does on Linux so
+// names match those read from the mounted volume.
+func decodeName(name xmlName) (string, error) {
+	value := name.Value
+	if name.PercentEncoded {
+		var err error
+		value, err = url.PathUnescape(value)
+		if err != nil {
+			return "", errors.Wrapf(err, "bad percent encoded name %q", name.Value)
+		}
+	}
+	if value == "" || value == "." || value == ".." || strings.ContainsRune(value, '/') {
+		return "", errors.Errorf("bad name %q", value)
+	}
+	return encoder.Base.ToStandardName(value), nil
+}
+
+// parseTime parses a time in the index returning the zero time if
+// it can't be parsed
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,638 @@
+// Package ltfs provides an interface to tapes formatted with the
+// Linear Tape File System
+package ltfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Tiers reported for objects
+const (
+	tierTape    = "tape"    // on a mounted tape
+	tierOffline = "offline" // on a tape which isn't mounted
+)
+
+var errOffline = errors.New("tape isn't mounted - only listing from the index is possible")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "ltfs",
+		Description: "Tape formatted with LTFS",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "mount",
+			Help:     "Path where the LTFS volume is mounted",
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "/mnt/ltfs",
+				Help:  "The usual place to mount LTFS on Linux",
+			}},
+		}, {
+			Name: "index",
+			Help: `Path to a copy of the LTFS index of the tape
+
+If set then the tape can be listed from the index when it isn't
+mounted, and the position of each file on the tape is shown as its
+ID in listings.
+
+Copies of the index can be made with "ltfsck --capture-index" or found
+in the work directory of the LTFS driver.`,
+		}, {
+			Name: "offline",
+			Help: `List the tape from the index even if the mount point exists
+
+The tape is assumed to be offline if the mount point doesn't exist.
+Set this if the mount point is left as an empty directory when the
+tape is unmounted.`,
+			Default:  false,
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Mount   string `config:"mount"`
+	Index   string `config:"index"`
+	Offline bool   `config:"offline"`
+}
+
+// Fs represents a directory on an LTFS volume
+type Fs struct {
+	name     string       // name of this remote
+	root     string       // the path we are working on
+	opt      Options      // parsed options
+	features *fs.Features // optional features
+	local    fs.Fs        // the mounted volume or nil if offline
+	idx      *index       // the index of the volume or nil if not set
+	readMu   sync.Mutex   // held while a file is being read from the tape
+	writeMu  sync.Mutex   // held while a file is being written to the tape
+}
+
+// Object describes a file on an LTFS volume
+type Object struct {
+	fs      *Fs
+	o       fs.Object // the object on the mounted volume or nil if offline
+	remote  string
+	size    int64     // size if offline
+	modTime time.Time // modification time if offline
+	pos     position  // where the file is on the tape if known
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Mount == "" {
+		return nil, errors.New("mount must be set")
+	}
+	f := &Fs{
+		name: name,
+		root: strings.Trim(root, "/"),
+		opt:  *opt,
+	}
+	if opt.Index != "" {
+		f.idx, err = readIndex(opt.Index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	offline := opt.Offline
+	if _, err := os.Stat(opt.Mount); os.IsNotExist(err) {
+		offline = true
+	}
+	if offline && f.idx == nil {
+		return nil, errors.Errorf("tape isn't mounted at %q and no index is set", opt.Mount)
+	}
+
+	var isFile bool
+	if offline {
+		fs.Debugf(nil, "LTFS: listing volume %s from the index %q", f.idx.volumeUUID, opt.Index)
+		if entry, ok := f.idx.entries[f.root]; ok && !entry.isDir {
+			isFile = true
+		}
+	} else {
+		localInfo, err := fs.Find("local")
+		if err != nil {
+			return nil, err
+		}
+		localRoot := filepath.Join(opt.Mount, filepath.FromSlash(f.root))
+		f.local, err = local.NewFs(ctx, "local", localRoot, fs.ConfigMap(localInfo, "local"))
+		if err == fs.ErrorIsFile {
+			isFile = true
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to open the mounted volume")
+		}
+	}
+	if isFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." {
+			f.root = ""
+		}
+	}
+
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		GetTier:                 true,
+		SlowHash:                true,
+	}).Fill(ctx, f)
+	if f.local != nil {
+		f.features = f.features.Mask(ctx, f.local)
+		f.features.GetTier = true
+	}
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	if f.local == nil {
+		return fmt.Sprintf("Offline LTFS volume %s path %q", f.idx.volumeUUID, f.root)
+	}
+	return fmt.Sprintf("LTFS volume at %s path %q", f.opt.Mount, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	if f.local == nil {
+		return time.Nanosecond
+	}
+	return f.local.Precision()
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	if f.local == nil {
+		return hash.Set(hash.None)
+	}
+	return f.local.Hashes()
+}
+
+// position returns where the root relative remote is on the tape
+// according to the index
+func (f *Fs) position(remote string) position {
+	if f.idx == nil {
+		return position{}
+	}
+	entry, ok := f.idx.entries[path.Join(f.root, remote)]
+	if !ok {
+		return position{}
+	}
+	return entry.pos
+}
+
+// wrapObject wraps o from the mounted volume
+func (f *Fs) wrapObject(o fs.Object) *Object {
+	return &Object{
+		fs:     f,
+		o:      o,
+		remote: o.Remote(),
+		pos:    f.position(o.Remote()),
+	}
+}
+
+// newIndexObject makes an Object from the index entry
+func (f *Fs) newIndexObject(remote string, entry *indexEntry) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		size:    entry.size,
+		modTime: entry.modTime,
+		pos:     entry.pos,
+	}
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.local == nil {
+		return f.listIndex(dir)
+	}
+	localEntries, err := f.local.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries = make(fs.DirEntries, 0, len(localEntries))
+	for _, entry := range localEntries {
+		if o, ok := entry.(fs.Object); ok {
+			entry = f.wrapObject(o)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// listIndex lists dir from the index
+func (f *Fs) listIndex(dir string) (entries fs.DirEntries, err error) {
+	dirPath := path.Join(f.root, dir)
+	if entry, ok := f.idx.entries[dirPath]; !ok || !entry.isDir {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, entry := range f.idx.children[dirPath] {
+		remote := path.Join(dir, path.Base(entry.remote))
+		if entry.isDir {
+			entries = append(entries, fs.NewDir(remote, entry.modTime))
+		} else {
+			entries = append(entries, f.newIndexObject(remote, entry))
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if f.local == nil {
+		entry, ok := f.idx.entries[path.Join(f.root, remote)]
+		if !ok {
+			return nil, fs.ErrorObjectNotFound
+		}
+		if entry.isDir {
+			return nil, errors.Wrapf(fs.ErrorNotAFile, "%q", remote)
+		}
+		return f.newIndexObject(remote, entry), nil
+	}
+	o, err := f.local.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapObject(o), nil
+}
+
+// Put the object
+//
+// Files are written to the tape one at a time so the drive can stream
+// rather than stopping to switch between files.
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if f.local == nil {
+		return nil, errOffline
+	}
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	o, err := f.local.Put(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapObject(o), nil
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if f.local == nil {
+		return nil, errOffline
+	}
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	o, err := f.local.Features().PutStream(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapObject(o), nil
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if f.local == nil {
+		return errOffline
+	}
+	return f.local.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if f.local == nil {
+		return errOffline
+	}
+	return f.local.Rmdir(ctx, dir)
+}
+
+// Purge deletes all the files in the directory
+//
+// LTFS only marks the files as deleted in the index, it can't reclaim
+// the space they used on the tape.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.local == nil {
+		return errOffline
+	}
+	return f.local.Features().Purge(ctx, dir)
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.o == nil || f.local == nil || srcObj.fs.opt.Mount != f.opt.Mount {
+		fs.Debugf(src, "Can't move - not on the same mounted volume")
+		return nil, fs.ErrorCantMove
+	}
+	o, err := f.local.Features().Move(ctx, srcObj.o, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapObject(o), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs.local == nil || f.local == nil || srcFs.opt.Mount != f.opt.Mount {
+		fs.Debugf(srcFs, "Can't move directory - not on the same mounted volume")
+		return fs.ErrorCantDirMove
+	}
+	return f.local.Features().DirMove(ctx, srcFs.local, srcRemote, dstRemote)
+}
+
+// DirSetModTime sets the modification time of the directory
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	if f.local == nil {
+		return errOffline
+	}
+	return f.local.Features().DirSetModTime(ctx, dir, modTime)
+}
+
+// DirMetadata returns the metadata of the directory
+func (f *Fs) DirMetadata(ctx context.Context, dir string) (fs.Metadata, error) {
+	if f.local == nil {
+		entry, ok := f.idx.entries[path.Join(f.root, dir)]
+		if !ok || !entry.isDir {
+			return nil, fs.ErrorDirNotFound
+		}
+		return fs.Metadata{"mtime": entry.modTime.Format(time.RFC3339Nano)}, nil
+	}
+	return f.local.Features().DirMetadata(ctx, dir)
+}
+
+// DirSetMetadata sets the metadata of the directory
+func (f *Fs) DirSetMetadata(ctx context.Context, dir string, metadata fs.Metadata) error {
+	if f.local == nil {
+		return errOffline
+	}
+	return f.local.Features().DirSetMetadata(ctx, dir, metadata)
+}
+
+// About gets quota information
+//
+// If the tape isn't mounted this is the space used by the files in the
+// index.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if f.local == nil {
+		var used, objects int64
+		for _, entry := range f.idx.entries {
+			if !entry.isDir {
+				used += entry.size
+				objects++
+			}
+		}
+		return &fs.Usage{
+			Used:    fs.NewUsageValue(used),
+			Objects: fs.NewUsageValue(objects),
+		}, nil
+	}
+	return f.local.Features().About(ctx)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the requested hash of the contents
+//
+// This reads the whole file from the tape.
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	if o.o == nil {
+		return "", hash.ErrUnsupported
+	}
+	o.fs.readMu.Lock()
+	defer o.fs.readMu.Unlock()
+	return o.o.Hash(ctx, t)
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	if o.o == nil {
+		return o.size
+	}
+	return o.o.Size()
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	if o.o == nil {
+		return o.modTime
+	}
+	return o.o.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if o.o == nil {
+		return errOffline
+	}
+	return o.o.SetModTime(ctx, modTime)
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// GetTier returns whether the tape the object is on is mounted
+func (o *Object) GetTier() string {
+	if o.o == nil {
+		return tierOffline
+	}
+	return tierTape
+}
+
+// ID returns where the object is on the tape as partition:block if
+// it is known
+//
+// This shows in listings so reads can be ordered to save seeking.
+func (o *Object) ID() string {
+	if !o.pos.isSet() {
+		return ""
+	}
+	return o.pos.String()
+}
+
+// Metadata returns the metadata of the object along with where it is
+// on the tape if that is known
+func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error) {
+	if o.o != nil {
+		metadata, err = fs.GetMetadata(ctx, o.o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if metadata == nil {
+		metadata = fs.Metadata{}
+	}
+	if o.o == nil {
+		metadata["mtime"] = o.modTime.Format(time.RFC3339Nano)
+	}
+	if o.pos.isSet() {
+		metadata["ltfs.partition"] = o.pos.partition
+		metadata["ltfs.startblock"] = strconv.FormatInt(o.pos.startBlock, 10)
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the metadata of the object
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	if o.o == nil {
+		return errOffline
+	}
+	do, ok := o.o.(fs.SetMetadataer)
+	if !ok {
+		return nil
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// tapeReader releases the tape for reading when it is closed
+type tapeReader struct {
+	io.ReadCloser
+	once sync.Once
+	mu   *sync.Mutex
+}
+
+// Close the file and release the tape
+func (r *tapeReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.mu.Unlock)
+	return err
+}
+
+// Open an object for read
+//
+// Only one file is read from the tape at once, so the tape isn't
+// wound back and forth between files being read in parallel. Other
+// reads wait until the file is closed.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	if o.o == nil {
+		return nil, errOffline
+	}
+	if o.pos.isSet() {
+		fs.Debugf(o, "Reading from tape at %v", o.pos)
+	}
+	o.fs.readMu.Lock()
+	in, err := o.o.Open(ctx, options...)
+	if err != nil {
+		o.fs.readMu.Unlock()
+		return nil, err
+	}
+	return &tapeReader{ReadCloser: in, mu: &o.fs.readMu}, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// Files are written to the tape one at a time.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.o == nil {
+		return errOffline
+	}
+	o.fs.writeMu.Lock()
+	defer o.fs.writeMu.Unlock()
+	return o.o.Update(ctx, in, src, options...)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	if o.o == nil {
+		return errOffline
+	}
+	return o.o.Remove(ctx)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs             = (*Fs)(nil)
+	_ fs.Purger         = (*Fs)(nil)
+	_ fs.PutStreamer    = (*Fs)(nil)
+	_ fs.Mover          = (*Fs)(nil)
+	_ fs.DirMover       = (*Fs)(nil)
+	_ fs.DirSetModTimer = (*Fs)(nil)
+	_ fs.DirMetadataer  = (*Fs)(nil)
+	_ fs.Abouter        = (*Fs)(nil)
+	_ fs.Object         = (*Object)(nil)
+	_ fs.GetTierer      = (*Object)(nil)
+	_ fs.IDer           = (*Object)(nil)
+	_ fs.Metadataer     = (*Object)(nil)
+	_ fs.SetMetadataer  = (*Object)(nil)
+)
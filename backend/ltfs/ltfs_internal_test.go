@@ -0,0 +1,242 @@
+package ltfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<ltfsindex version="2.4.0">
+  <creator>test</creator>
+  <volumeuuid>2f1c7f8e-0f5e-4c8a-9d3b-6a1f0e2d4c5b</volumeuuid>
+  <generationnumber>7</generationnumber>
+  <updatetime>2021-03-04T05:06:07.000000000Z</updatetime>
+  <location><partition>a</partition><startblock>20</startblock></location>
+  <directory>
+    <name>VOL001</name>
+    <modifytime>2021-03-04T05:06:07.000000000Z</modifytime>
+    <contents>
+      <file>
+        <name>one.txt</name>
+        <length>3</length>
+        <modifytime>2021-01-02T03:04:05.123456789Z</modifytime>
+        <extentinfo>
+          <extent><fileoffset>0</fileoffset><partition>b</partition><startblock>100</startblock><byteoffset>0</byteoffset><bytecount>3</bytecount></extent>
+        </extentinfo>
+      </file>
+      <file>
+        <name>link</name>
+        <symlink>one.txt</symlink>
+      </file>
+      <directory>
+        <name>sub dir</name>
+        <modifytime>2021-02-03T04:05:06Z</modifytime>
+        <contents>
+          <file>
+            <name percentencoded="true">a%3Ab.bin</name>
+            <length>1000</length>
+            <modifytime>2021-02-03T04:05:06Z</modifytime>
+            <extentinfo>
+              <extent><fileoffset>512</fileoffset><partition>b</partition><startblock>300</startblock></extent>
+              <extent><fileoffset>0</fileoffset><partition>b</partition><startblock>200</startblock></extent>
+            </extentinfo>
+          </file>
+          <directory>
+            <name>empty</name>
+            <contents/>
+          </directory>
+        </contents>
+      </directory>
+    </contents>
+  </directory>
+</ltfsindex>
+`
+
+func TestParseIndex(t *testing.T) {
+	idx, err := parseIndex(strings.NewReader(testIndex))
+	require.NoError(t, err)
+	assert.Equal(t, "2f1c7f8e-0f5e-4c8a-9d3b-6a1f0e2d4c5b", idx.volumeUUID)
+	assert.Equal(t, int64(7), idx.generation)
+	assert.Len(t, idx.entries, 5)
+
+	one := idx.entries["one.txt"]
+	require.NotNil(t, one)
+	assert.False(t, one.isDir)
+	assert.Equal(t, int64(3), one.size)
+	assert.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 123456789, time.UTC), one.modTime)
+	assert.Equal(t, position{partition: "b", startBlock: 100}, one.pos)
+	assert.Equal(t, "b:100", one.pos.String())
+
+	// symlinks are skipped
+	assert.Nil(t, idx.entries["link"])
+
+	// percent encoded names are decoded and the extent at the start is used
+	bin := idx.entries["sub dir/a:b.bin"]
+	require.NotNil(t, bin)
+	assert.Equal(t, position{partition: "b", startBlock: 200}, bin.pos)
+
+	assert.True(t, idx.entries["sub dir/empty"].isDir)
+	assert.Len(t, idx.children[""], 2)
+	assert.Len(t, idx.children["sub dir"], 2)
+	assert.Len(t, idx.children["sub dir/empty"], 0)
+
+	for _, bad := range []string{
+		"not xml",
+		`<ltfsindex><directory><contents><file><name>a/b</name></file></contents></directory></ltfsindex>`,
+		`<ltfsindex><directory><contents><directory><name>..</name></directory></contents></directory></ltfsindex>`,
+	} {
+		_, err = parseIndex(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+// writeTestIndex writes the test index into dir returning its path
+func writeTestIndex(t *testing.T, dir string) string {
+	indexPath := filepath.Join(dir, "index.xml")
+	require.NoError(t, ioutil.WriteFile(indexPath, []byte(testIndex), 0600))
+	return indexPath
+}
+
+func TestOffline(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-ltfs-offline")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	m := configmap.Simple{
+		"mount": filepath.Join(dir, "not mounted"),
+		"index": writeTestIndex(t, dir),
+	}
+
+	f, err := NewFs(ctx, "ltfs", "", m)
+	require.NoError(t, err)
+	assert.Contains(t, f.String(), "Offline")
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "one.txt", entries[0].Remote())
+	assert.Equal(t, "sub dir", entries[1].Remote())
+	_, isDir := entries[1].(fs.Directory)
+	assert.True(t, isDir)
+
+	entries, err = f.List(ctx, "sub dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "sub dir/a:b.bin", entries[0].Remote())
+	assert.Equal(t, "sub dir/empty", entries[1].Remote())
+
+	_, err = f.List(ctx, "missing")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+	_, err = f.List(ctx, "one.txt")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	o, err := f.NewObject(ctx, "one.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), o.Size())
+	assert.Equal(t, tierOffline, o.(*Object).GetTier())
+	assert.Equal(t, "b:100", o.(*Object).ID())
+	metadata, err := o.(*Object).Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{
+		"mtime":           "2021-01-02T03:04:05.123456789Z",
+		"ltfs.partition":  "b",
+		"ltfs.startblock": "100",
+	}, metadata)
+
+	_, err = o.Open(ctx)
+	assert.Equal(t, errOffline, err)
+	assert.Equal(t, errOffline, o.Remove(ctx))
+	assert.Equal(t, errOffline, f.Mkdir(ctx, "new"))
+
+	_, err = f.NewObject(ctx, "sub dir")
+	assert.Equal(t, fs.ErrorNotAFile, errors.Cause(err))
+	_, err = f.NewObject(ctx, "missing")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	usage, err := f.Features().About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1003), *usage.Used)
+	assert.Equal(t, int64(2), *usage.Objects)
+
+	// a root pointing to a file
+	f, err = NewFs(ctx, "ltfs", "sub dir/a:b.bin", m)
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "sub dir", f.Root())
+
+	// an index is needed to be offline
+	delete(m, "index")
+	_, err = NewFs(ctx, "ltfs", "", m)
+	assert.Error(t, err)
+}
+
+func TestMounted(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-ltfs-mounted")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	mount := filepath.Join(dir, "mnt")
+	require.NoError(t, os.Mkdir(mount, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mount, "one.txt"), []byte("one"), 0600))
+	m := configmap.Simple{
+		"mount": mount,
+		"index": writeTestIndex(t, dir),
+	}
+
+	f, err := NewFs(ctx, "ltfs", "", m)
+	require.NoError(t, err)
+
+	o, err := f.NewObject(ctx, "one.txt")
+	require.NoError(t, err)
+	assert.Equal(t, tierTape, o.(*Object).GetTier())
+	assert.Equal(t, "b:100", o.(*Object).ID())
+	metadata, err := o.(*Object).Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", metadata["ltfs.partition"])
+	assert.Equal(t, "100", metadata["ltfs.startblock"])
+	assert.NotEmpty(t, metadata["mtime"])
+
+	// only one file is read at once
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	opened := make(chan struct{})
+	go func() {
+		in2, err := o.Open(ctx)
+		assert.NoError(t, err)
+		close(opened)
+		assert.NoError(t, in2.Close())
+	}()
+	select {
+	case <-opened:
+		t.Fatal("second file opened while first was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+	require.NoError(t, in.Close())
+	select {
+	case <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second file not opened after first closed")
+	}
+
+	// a root pointing to a file
+	f, err = NewFs(ctx, "ltfs", "one.txt", m)
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "", f.Root())
+}
@@ -0,0 +1,36 @@
+// Test LTFS filesystem interface
+package ltfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/backend/ltfs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against a directory standing
+// in for a mounted tape
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	mount, err := ioutil.TempDir("", "rclone-ltfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(mount)
+	}()
+	name := "TestLTFS"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*ltfs.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "ltfs"},
+			{Name: name, Key: "mount", Value: mount},
+		},
+	})
+}
@@ -0,0 +1,907 @@
+// Package nfs provides an interface to NFS servers
+//
+// It speaks NFS version 3 or 4.1 directly so no kernel mount is needed.
+
+// +build !plan9
+
+package nfs
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+const (
+	minSleep         = 10 * time.Millisecond // In case of error, start at 10ms sleep.
+	defaultChunkSize = 64 * 1024
+	dirMode          = 0755
+	fileMode         = 0644
+	nobody           = 65534
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "nfs",
+		Description: "NFS",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "host",
+			Help:     "NFS server to connect to",
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "nfs.example.com",
+				Help:  "Connect to nfs.example.com",
+			}},
+		}, {
+			Name:     "export",
+			Help:     "Path of the export on the server, e.g. /srv/nfs",
+			Required: true,
+		}, {
+			Name: "version",
+			Help: `NFS version to speak
+
+Use 4.1 for servers which only speak NFS version 4 and to read from
+the data servers of pNFS servers directly.`,
+			Default: "3",
+			Examples: []fs.OptionExample{{
+				Value: "3",
+				Help:  "NFS version 3",
+			}, {
+				Value: "4.1",
+				Help:  "NFS version 4.1, with pNFS if the server supports it",
+			}},
+		}, {
+			Name: "uid",
+			Help: `User ID to send to the server
+
+NFS servers trust the user ID the client sends, so this sets who the
+files are created as and who the permissions are checked for. Leave
+as -1 to use the user ID rclone is running as.`,
+			Default: -1,
+		}, {
+			Name:    "gid",
+			Help:    "Group ID to send to the server, leave as -1 to use the group ID rclone is running as",
+			Default: -1,
+		}, {
+			Name: "port",
+			Help: `Port of the NFS service
+
+Leave as 0 to ask the portmapper on the server, which is normally on
+port 111, with version 3 or to use 2049 with version 4.1.`,
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name:     "mount_port",
+			Help:     "Port of the MOUNT service, leave as 0 to ask the portmapper - not used with version 4.1",
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name: "use_reserved_port",
+			Help: `Connect from a port below 1024
+
+Many NFS servers only accept connections from privileged ports (the
+"secure" export option). Binding to one needs rclone to be run as
+root or with the CAP_NET_BIND_SERVICE capability.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name:     "machine_name",
+			Help:     "Machine name to send to the server, leave blank to use the host name",
+			Advanced: true,
+		}, {
+			Name: "parallel_reads",
+			Help: `Number of READ requests to have in progress for each file
+
+Each download is split into requests the size the server prefers,
+which are sent on separate connections so the server can read them in
+parallel. With pNFS they are sent to the data servers holding each
+part of the file. Set to 1 to read files sequentially.`,
+			Default:  4,
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Host            string `config:"host"`
+	Export          string `config:"export"`
+	Version         string `config:"version"`
+	UID             int    `config:"uid"`
+	GID             int    `config:"gid"`
+	Port            int    `config:"port"`
+	MountPort       int    `config:"mount_port"`
+	UseReservedPort bool   `config:"use_reserved_port"`
+	MachineName     string `config:"machine_name"`
+	ParallelReads   int    `config:"parallel_reads"`
+}
+
+// Fs represents an NFS export
+type Fs struct {
+	name       string             // name of this remote
+	root       string             // the path we are working on
+	opt        Options            // parsed options
+	features   *fs.Features       // optional features
+	pacer      *fs.Pacer          // pacer for calls
+	dirCache   *dircache.DirCache // map of directory path to file handle
+	nfsAddr    string             // address of the NFS service
+	proto      protocol           // the version of NFS spoken
+	rootHandle []byte             // file handle of the export
+	info       *fsInfo            // static information about the export
+}
+
+// protocol is a version of the NFS protocol
+//
+// The operations are tried once - the Fs retries them if necessary.
+type protocol interface {
+	// mount finds the file handle of the root of the export
+	mount(ctx context.Context) (root []byte, err error)
+	// fsInfo reads the static information about the export
+	fsInfo(ctx context.Context, root []byte) (*fsInfo, error)
+	// fsStat reads the usage of the export
+	fsStat(ctx context.Context, root []byte) (*fsStat, error)
+	// getAttr reads the attributes of handle
+	getAttr(ctx context.Context, handle []byte) (*fileAttr, error)
+	// setModTime sets the modification time of handle
+	setModTime(ctx context.Context, handle []byte, modTime time.Time) error
+	// lookup finds name in the directory dir
+	lookup(ctx context.Context, dir []byte, name string) (handle []byte, attr *fileAttr, err error)
+	// readDir reads a part of the directory dir starting at cookie
+	readDir(ctx context.Context, dir []byte, cookie uint64, cookieVerf []byte, maxCount uint32) (entries []dirEntry, nextCookie uint64, nextVerf []byte, eof bool, err error)
+	// mkdir makes the directory name in dir
+	mkdir(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, err error)
+	// remove removes the file or, if isDir is set, the directory name in dir
+	remove(ctx context.Context, dir []byte, name string, isDir bool) error
+	// rename renames fromName in fromDir to toName in toDir
+	rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error
+	// open opens handle for reading
+	open(ctx context.Context, handle []byte) (openFile, error)
+	// create makes or truncates the file name in dir and opens it for writing
+	create(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, file openFile, err error)
+	// shutdown tells the server the export is no longer used
+	shutdown(ctx context.Context) error
+}
+
+// openFile is a file opened for reading or writing
+type openFile interface {
+	// readSize returns the size of the READs to make given the size the server prefers
+	readSize(size uint32) uint32
+	// read reads up to count bytes at offset
+	read(ctx context.Context, offset uint64, count uint32) (data []byte, eof bool, err error)
+	// write writes data unstably at offset returning the number of bytes written and the write verifier
+	write(ctx context.Context, offset uint64, data []byte) (n uint32, verf []byte, err error)
+	// commit commits the unstable writes returning the write verifier
+	commit(ctx context.Context) (verf []byte, err error)
+	// close closes the file
+	close(ctx context.Context) error
+}
+
+// Object describes an NFS file
+type Object struct {
+	fs      *Fs       // what this object is part of
+	remote  string    // the remote path
+	handle  []byte    // NFS file handle
+	size    int64     // size of the object
+	modTime time.Time // modification time of the object
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("nfs://%s%s/%s", f.opt.Host, f.opt.Export, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// handleToID converts a file handle into an ID for the dircache
+func handleToID(handle []byte) string {
+	return hex.EncodeToString(handle)
+}
+
+// idToHandle converts a dircache ID back into a file handle
+func idToHandle(id string) []byte {
+	handle, _ := hex.DecodeString(id)
+	return handle
+}
+
+// shouldRetry returns a boolean as to whether this err deserves to be
+// retried.  It returns the err as a convenience
+func shouldRetry(err error) (bool, error) {
+	if status, ok := errors.Cause(err).(nfsStatus); ok {
+		return status == nfsErrJukebox || status == nfs4ErrGrace || sessionLost(status), err
+	}
+	return fserrors.ShouldRetry(err), err
+}
+
+// call runs fn, retrying if necessary
+func (f *Fs) call(fn func() error) error {
+	return f.pacer.Call(func() (bool, error) {
+		return shouldRetry(fn())
+	})
+}
+
+// servicePort returns port or, if it is 0, asks the portmapper
+func servicePort(ctx context.Context, host string, port int, prog, vers uint32) (int, error) {
+	if port != 0 {
+		return port, nil
+	}
+	return getPort(ctx, host, prog, vers)
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Export == "" {
+		return nil, errors.New("nfs: export must be set")
+	}
+	if opt.ParallelReads < 1 {
+		opt.ParallelReads = 1
+	}
+	if opt.UID < 0 {
+		opt.UID = os.Getuid()
+	}
+	if opt.GID < 0 {
+		opt.GID = os.Getgid()
+	}
+	// os.Getuid returns -1 on Windows
+	if opt.UID < 0 || opt.GID < 0 {
+		opt.UID, opt.GID = nobody, nobody
+	}
+	if opt.MachineName == "" {
+		opt.MachineName, _ = os.Hostname()
+	}
+	root = strings.Trim(path.Clean(root), "/")
+	if root == "." {
+		root = ""
+	}
+	f := &Fs{
+		name:  name,
+		root:  root,
+		opt:   *opt,
+		pacer: fs.NewPacer(ctx, pacer.NewS3(pacer.MinSleep(minSleep))),
+	}
+	cred := authSysCred(opt.MachineName, uint32(opt.UID), uint32(opt.GID))
+	switch opt.Version {
+	case "3":
+		f.proto, f.nfsAddr, err = newNFS3(ctx, opt, cred)
+	case "4.1":
+		f.proto, f.nfsAddr = newNFS4(ctx, opt, cred)
+	default:
+		err = errors.Errorf("version must be 3 or 4.1 not %q", opt.Version)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "nfs")
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+
+	// Mount the export to find its root handle
+	err = f.call(func() (err error) {
+		f.rootHandle, err = f.proto.mount(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "nfs: mount failed")
+	}
+	err = f.call(func() (err error) {
+		f.info, err = f.proto.fsInfo(ctx, f.rootHandle)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "nfs: failed to read file system info")
+	}
+
+	rootID := handleToID(f.rootHandle)
+	f.dirCache = dircache.New(root, rootID, f)
+
+	// Find the current root
+	err = f.dirCache.FindRoot(ctx, false)
+	if err != nil {
+		// Assume it is a file
+		newRoot, remote := dircache.SplitPath(root)
+		tempF := *f
+		tempF.dirCache = dircache.New(newRoot, rootID, &tempF)
+		tempF.root = newRoot
+		// Make new Fs which is the parent
+		err = tempF.dirCache.FindRoot(ctx, false)
+		if err != nil {
+			// No root so return old f
+			return f, nil
+		}
+		_, err := tempF.NewObject(ctx, remote)
+		if err != nil {
+			if err == fs.ErrorObjectNotFound {
+				// File doesn't exist so return old f
+				return f, nil
+			}
+			return nil, err
+		}
+		f.features.Fill(ctx, &tempF)
+		// XXX: update the old f here instead of returning tempF, since
+		// `features` were already filled with functions having *f as a receiver.
+		// See https://github.com/rclone/rclone/issues/2182
+		f.dirCache = tempF.dirCache
+		f.root = tempF.root
+		// return an error with an fs which points to the parent
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// lookup finds leaf in the directory with ID dirID
+func (f *Fs) lookup(ctx context.Context, dirID, leaf string) (handle []byte, attr *fileAttr, err error) {
+	err = f.call(func() (err error) {
+		handle, attr, err = f.proto.lookup(ctx, idToHandle(dirID), leaf)
+		return err
+	})
+	return handle, attr, err
+}
+
+// FindLeaf finds a directory of name leaf in the folder with ID pathID
+func (f *Fs) FindLeaf(ctx context.Context, pathID, leaf string) (pathIDOut string, found bool, err error) {
+	handle, attr, err := f.lookup(ctx, pathID, leaf)
+	if errors.Cause(err) == nfsErrNoEnt {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if !attr.isDir() {
+		return "", false, nil
+	}
+	return handleToID(handle), true, nil
+}
+
+// CreateDir makes a directory with pathID as parent and name leaf
+func (f *Fs) CreateDir(ctx context.Context, pathID, leaf string) (newID string, err error) {
+	var handle []byte
+	err = f.call(func() (err error) {
+		handle, err = f.proto.mkdir(ctx, idToHandle(pathID), leaf, dirMode)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return handleToID(handle), nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	directoryID, err := f.dirCache.FindDir(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+	dirHandle := idToHandle(directoryID)
+	maxCount := f.info.dirPref
+	if maxCount < 4096 {
+		maxCount = 4096
+	}
+	var (
+		cookie     uint64
+		cookieVerf []byte
+		eof        bool
+	)
+	for !eof {
+		var items []dirEntry
+		err = f.call(func() (err error) {
+			items, cookie, cookieVerf, eof, err = f.proto.readDir(ctx, dirHandle, cookie, cookieVerf, maxCount)
+			return err
+		})
+		if err != nil {
+			if errors.Cause(err) == nfsErrNoEnt || errors.Cause(err) == nfsErrStale {
+				return nil, fs.ErrorDirNotFound
+			}
+			return nil, errors.Wrap(err, "list failed")
+		}
+		for _, item := range items {
+			if item.name == "." || item.name == ".." {
+				continue
+			}
+			if item.handle == nil || item.attr == nil {
+				item.handle, item.attr, err = f.lookup(ctx, directoryID, item.name)
+				if errors.Cause(err) == nfsErrNoEnt {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			remote := path.Join(dir, item.name)
+			switch item.attr.Type {
+			case typeDir:
+				id := handleToID(item.handle)
+				// cache the directory ID for later lookups
+				f.dirCache.Put(remote, id)
+				entries = append(entries, fs.NewDir(remote, item.attr.ModTime).SetID(id))
+			case typeRegular:
+				entries = append(entries, f.newObject(remote, item.handle, item.attr))
+			default:
+				fs.Debugf(f, "Skipping %q - not a regular file or directory", remote)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// newObject makes an Object from its handle and attributes
+func (f *Fs) newObject(remote string, handle []byte, attr *fileAttr) *Object {
+	o := &Object{
+		fs:     f,
+		remote: remote,
+		handle: handle,
+	}
+	o.setMetaData(attr)
+	return o
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	leaf, directoryID, err := f.dirCache.FindPath(ctx, remote, false)
+	if err != nil {
+		if err == fs.ErrorDirNotFound {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return nil, err
+	}
+	handle, attr, err := f.lookup(ctx, directoryID, leaf)
+	if errors.Cause(err) == nfsErrNoEnt || errors.Cause(err) == nfsErrNotDir {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attr.isDir() {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if attr.Type != typeRegular {
+		return nil, errors.Wrapf(fs.ErrorNotAFile, "%q", remote)
+	}
+	return f.newObject(remote, handle, attr), nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	_, err := f.dirCache.FindDir(ctx, dir, true)
+	return err
+}
+
+// Rmdir removes the directory
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	_, err := f.dirCache.FindDir(ctx, dir, false)
+	if err != nil {
+		return err
+	}
+	var leaf, parentID string
+	if dir == "" {
+		leaf = path.Base(f.root)
+		parentID, err = f.dirCache.RootParentID(ctx, false)
+	} else {
+		leaf, parentID, err = f.dirCache.FindPath(ctx, dir, false)
+	}
+	if err != nil {
+		return err
+	}
+	err = f.call(func() error {
+		return f.proto.remove(ctx, idToHandle(parentID), leaf, true)
+	})
+	switch errors.Cause(err) {
+	case nil:
+	case nfsErrNotEmpty, nfsErrExist:
+		return fs.ErrorDirectoryNotEmpty
+	case nfsErrNoEnt:
+		return fs.ErrorDirNotFound
+	default:
+		return errors.Wrap(err, "rmdir failed")
+	}
+	f.dirCache.FlushDir(dir)
+	if dir == "" {
+		f.dirCache.ResetRoot()
+	}
+	return nil
+}
+
+// Precision of the modification times on the export
+func (f *Fs) Precision() time.Duration {
+	if f.info.timeDelta <= 0 {
+		return time.Nanosecond
+	}
+	return f.info.timeDelta
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// sameExport returns true if src is on the same export as f
+func (f *Fs) sameExport(src *Fs) bool {
+	return f.nfsAddr == src.nfsAddr && f.opt.Export == src.opt.Export
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameExport(srcObj.fs) {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	srcLeaf, srcDirectoryID, err := srcObj.fs.dirCache.FindPath(ctx, srcObj.remote, false)
+	if err != nil {
+		return nil, err
+	}
+	dstLeaf, dstDirectoryID, err := f.dirCache.FindPath(ctx, remote, true)
+	if err != nil {
+		return nil, err
+	}
+	err = f.call(func() error {
+		return f.proto.rename(ctx, idToHandle(srcDirectoryID), srcLeaf, idToHandle(dstDirectoryID), dstLeaf)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "move failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameExport(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	_, srcDirectoryID, srcLeaf, dstDirectoryID, dstLeaf, err := f.dirCache.DirMove(ctx, srcFs.dirCache, srcFs.root, srcRemote, f.root, dstRemote)
+	if err != nil {
+		return err
+	}
+	err = f.call(func() error {
+		return f.proto.rename(ctx, idToHandle(srcDirectoryID), srcLeaf, idToHandle(dstDirectoryID), dstLeaf)
+	})
+	if err != nil {
+		return errors.Wrap(err, "dirmove failed")
+	}
+	srcFs.dirCache.FlushDir(srcRemote)
+	return nil
+}
+
+// About gets quota information
+func (f *Fs) About(ctx context.Context) (usage *fs.Usage, err error) {
+	var stat *fsStat
+	err = f.call(func() (err error) {
+		stat, err = f.proto.fsStat(ctx, f.rootHandle)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "about failed")
+	}
+	usage = &fs.Usage{
+		Total: fs.NewUsageValue(int64(stat.totalBytes)),                  // quota of bytes that can be used
+		Used:  fs.NewUsageValue(int64(stat.totalBytes - stat.freeBytes)), // bytes in use
+		Free:  fs.NewUsageValue(int64(stat.availBytes)),                  // bytes which can be uploaded before reaching the quota
+	}
+	return usage, nil
+}
+
+// DirCacheFlush resets the directory cache - used in testing as an
+// optional interface
+func (f *Fs) DirCacheFlush() {
+	f.dirCache.ResetRoot()
+}
+
+// Shutdown unmounts the export and closes the connections
+func (f *Fs) Shutdown(ctx context.Context) error {
+	err := f.proto.shutdown(ctx)
+	if err != nil {
+		return errors.Wrap(err, "nfs: unmount failed")
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash is unsupported on NFS
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// setMetaData sets the metadata from the attributes
+func (o *Object) setMetaData(attr *fileAttr) {
+	o.size = int64(attr.Size)
+	o.modTime = attr.ModTime
+}
+
+// readMetaData reads the attributes of the object
+func (o *Object) readMetaData(ctx context.Context) error {
+	var attr *fileAttr
+	err := o.fs.call(func() (err error) {
+		attr, err = o.fs.proto.getAttr(ctx, o.handle)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	o.setMetaData(attr)
+	return nil
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	err := o.fs.call(func() error {
+		return o.fs.proto.setModTime(ctx, o.handle, modTime)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+	return o.readMetaData(ctx)
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	var file openFile
+	err = o.fs.call(func() (err error) {
+		file, err = o.fs.proto.open(ctx, o.handle)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	return readers.NewLimitedReadCloser(o.fs.newParallelReader(ctx, file, offset, limit), limit), nil
+}
+
+// chunkSize returns the size of the READ or WRITE requests to make
+func chunkSize(pref, max uint32) uint32 {
+	size := pref
+	if size == 0 {
+		size = defaultChunkSize
+	}
+	if max != 0 && size > max {
+		size = max
+	}
+	return size
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// If existing is set then it updates the object rather than creating a new one
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	f := o.fs
+	leaf, directoryID, err := f.dirCache.FindPath(ctx, o.remote, true)
+	if err != nil {
+		return err
+	}
+	dirHandle := idToHandle(directoryID)
+	// Create the file, truncating it if it exists
+	var file openFile
+	err = f.call(func() (err error) {
+		o.handle, file, err = f.proto.create(ctx, dirHandle, leaf, fileMode)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	// Close and remove the file if the upload fails
+	defer func() {
+		if err != nil {
+			if file != nil {
+				closeErr := file.close(ctx)
+				if closeErr != nil {
+					fs.Debugf(o, "Failed to close partial upload: %v", closeErr)
+				}
+			}
+			removeErr := f.call(func() error {
+				return f.proto.remove(ctx, dirHandle, leaf, false)
+			})
+			if removeErr != nil {
+				fs.Debugf(o, "Failed to remove partial upload: %v", removeErr)
+			}
+		}
+	}()
+	var (
+		buf    = make([]byte, chunkSize(f.info.writePref, f.info.writeMax))
+		offset uint64
+		verf   []byte
+	)
+	for {
+		n, readErr := io.ReadFull(in, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		for data := buf[:n]; len(data) > 0; {
+			var written uint32
+			var writeVerf []byte
+			err = f.call(func() (err error) {
+				written, writeVerf, err = file.write(ctx, offset, data)
+				return err
+			})
+			if err != nil {
+				return errors.Wrap(err, "write failed")
+			}
+			if written == 0 || int(written) > len(data) {
+				return errors.Errorf("write failed: server wrote %d of %d bytes", written, len(data))
+			}
+			if verf == nil {
+				verf = writeVerf
+			} else if string(verf) != string(writeVerf) {
+				return errors.New("write failed: server restarted during upload")
+			}
+			offset += uint64(written)
+			data = data[written:]
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	var commitVerf []byte
+	err = f.call(func() (err error) {
+		commitVerf, err = file.commit(ctx)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "commit failed")
+	}
+	if verf != nil && string(verf) != string(commitVerf) {
+		return errors.New("commit failed: server restarted during upload")
+	}
+	err = file.close(ctx)
+	file = nil
+	if err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	err = f.call(func() error {
+		return f.proto.setModTime(ctx, o.handle, src.ModTime(ctx))
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+	return o.readMetaData(ctx)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	leaf, directoryID, err := o.fs.dirCache.FindPath(ctx, o.remote, false)
+	if err != nil {
+		return err
+	}
+	return o.fs.call(func() error {
+		return o.fs.proto.remove(ctx, idToHandle(directoryID), leaf, false)
+	})
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = &Fs{}
+	_ fs.Mover           = &Fs{}
+	_ fs.DirMover        = &Fs{}
+	_ fs.Abouter         = &Fs{}
+	_ fs.DirCacheFlusher = &Fs{}
+	_ fs.Shutdowner      = &Fs{}
+	_ fs.Object          = &Object{}
+)
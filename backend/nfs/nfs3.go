@@ -0,0 +1,773 @@
+//go:build !plan9
+// +build !plan9
+
+package nfs
+
+// This implements the client side of the MOUNT version 3 and NFS
+// version 3 protocols (RFC 1813).
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/xdr"
+)
+
+const (
+	mountProgram = 100005
+	mountVersion = 3
+	mountProcMnt = 1
+	mountProcUmt = 3
+
+	nfsProgram = 100003
+	nfsVersion = 3
+
+	procGetAttr     = 1
+	procSetAttr     = 2
+	procLookup      = 3
+	procRead        = 6
+	procWrite       = 7
+	procCreate      = 8
+	procMkdir       = 9
+	procRemove      = 12
+	procRmdir       = 13
+	procRename      = 14
+	procReadDirPlus = 17
+	procFSStat      = 18
+	procFSInfo      = 19
+	procCommit      = 21
+
+	maxHandle  = 64   // largest file handle
+	maxName    = 4096 // longest name we accept
+	maxPath    = 1024 // longest mount path
+	verfLength = 8    // length of cookie and write verifiers
+
+	// file types
+	typeRegular = 1
+	typeDir     = 2
+
+	// how WRITE data is stored
+	writeUnstable = 0
+
+	// how CREATE treats existing files
+	createUnchecked = 0
+	createGuarded   = 1
+
+	// how SETATTR sets the times
+	timeDontChange = 0
+	timeSetClient  = 2
+)
+
+// nfsStatus is an error status returned by the server
+type nfsStatus uint32
+
+// NFS status codes used by the backend
+const (
+	nfsOK          nfsStatus = 0
+	nfsErrNoEnt    nfsStatus = 2
+	nfsErrExist    nfsStatus = 17
+	nfsErrNotDir   nfsStatus = 20
+	nfsErrIsDir    nfsStatus = 21
+	nfsErrNotEmpty nfsStatus = 66
+	nfsErrStale    nfsStatus = 70
+	nfsErrJukebox  nfsStatus = 10008
+)
+
+var nfsStatusNames = map[nfsStatus]string{
+	1:     "not owner",
+	2:     "no such file or directory",
+	5:     "I/O error",
+	6:     "no such device or address",
+	13:    "permission denied",
+	17:    "file exists",
+	18:    "cross device link",
+	19:    "no such device",
+	20:    "not a directory",
+	21:    "is a directory",
+	22:    "invalid argument",
+	27:    "file too large",
+	28:    "no space left on device",
+	30:    "read only file system",
+	31:    "too many links",
+	63:    "file name too long",
+	66:    "directory not empty",
+	69:    "disk quota exceeded",
+	70:    "stale file handle",
+	71:    "too many levels of remote in path",
+	10001: "illegal file handle",
+	10002: "update synchronization mismatch",
+	10003: "readdir cookie is stale",
+	10004: "operation not supported",
+	10005: "buffer or request is too small",
+	10006: "server fault",
+	10007: "type not supported by server",
+	10008: "server busy - try again later",
+	// NFS version 4
+	10009: "server can't handle file type",
+	10010: "file locked",
+	10011: "lease expired",
+	10012: "conflicting lock",
+	10013: "server in grace period - try again later",
+	10020: "no current file handle",
+	10022: "client ID is stale",
+	10025: "bad stateid",
+	10038: "file opened in the wrong mode",
+	10050: "bad layout",
+	10052: "bad session",
+	10054: "reclaim already complete",
+	10058: "layout unavailable - try later",
+	10059: "layout unavailable",
+	10062: "unknown layout type",
+	10063: "sequence misordered",
+	10068: "retry of uncached reply",
+	10078: "dead session",
+}
+
+// Error satisfies the error interface
+func (s nfsStatus) Error() string {
+	name, ok := nfsStatusNames[s]
+	if !ok {
+		name = "error " + strconv.Itoa(int(s))
+	}
+	return "nfs: " + name
+}
+
+// fileAttr is the fattr3 attributes of a file
+type fileAttr struct {
+	Type    uint32
+	Mode    uint32
+	Size    uint64
+	FileID  uint64
+	ModTime time.Time
+}
+
+// isDir returns true if the attributes are for a directory
+func (a *fileAttr) isDir() bool {
+	return a.Type == typeDir
+}
+
+// readTime reads an nfstime3
+func readTime(r *xdr.Reader) time.Time {
+	seconds := r.Uint32()
+	nanoseconds := r.Uint32()
+	return time.Unix(int64(seconds), int64(nanoseconds))
+}
+
+// writeTime writes an nfstime3
+func writeTime(w *xdr.Writer, t time.Time) {
+	w.Uint32(uint32(t.Unix()))
+	w.Uint32(uint32(t.Nanosecond()))
+}
+
+// readAttr reads a fattr3
+func readAttr(r *xdr.Reader) *fileAttr {
+	var a fileAttr
+	a.Type = r.Uint32()
+	a.Mode = r.Uint32()
+	r.Uint32() // nlink
+	r.Uint32() // uid
+	r.Uint32() // gid
+	a.Size = r.Uint64()
+	r.Uint64() // used
+	r.Uint64() // rdev
+	r.Uint64() // fsid
+	a.FileID = r.Uint64()
+	readTime(r) // atime
+	a.ModTime = readTime(r)
+	readTime(r) // ctime
+	return &a
+}
+
+// readPostOpAttr reads a post_op_attr returning nil if not present
+func readPostOpAttr(r *xdr.Reader) *fileAttr {
+	if !r.Bool() {
+		return nil
+	}
+	return readAttr(r)
+}
+
+// readWcc skips a wcc_data
+func readWcc(r *xdr.Reader) {
+	if r.Bool() {
+		r.Uint64()  // size
+		readTime(r) // mtime
+		readTime(r) // ctime
+	}
+	readPostOpAttr(r)
+}
+
+// readPostOpHandle reads a post_op_fh3 returning nil if not present
+func readPostOpHandle(r *xdr.Reader) []byte {
+	if !r.Bool() {
+		return nil
+	}
+	return r.Opaque(maxHandle)
+}
+
+// setAttr is the attributes to set with a sattr3
+type setAttr struct {
+	mode    *uint32
+	size    *uint64
+	modTime time.Time
+}
+
+// writeSetAttr writes a sattr3
+func writeSetAttr(w *xdr.Writer, s setAttr) {
+	w.Bool(s.mode != nil)
+	if s.mode != nil {
+		w.Uint32(*s.mode)
+	}
+	w.Bool(false) // uid
+	w.Bool(false) // gid
+	w.Bool(s.size != nil)
+	if s.size != nil {
+		w.Uint64(*s.size)
+	}
+	w.Uint32(timeDontChange) // atime
+	if s.modTime.IsZero() {
+		w.Uint32(timeDontChange)
+	} else {
+		w.Uint32(timeSetClient)
+		writeTime(w, s.modTime)
+	}
+}
+
+// readStatus reads the status of the result of an NFS call
+func readStatus(r *xdr.Reader) error {
+	status := nfsStatus(r.Uint32())
+	if r.Err() != nil {
+		return errors.Wrap(r.Err(), "nfs protocol error")
+	}
+	if status != nfsOK {
+		return status
+	}
+	return nil
+}
+
+// checkResult returns the error if the results couldn't be decoded
+func checkResult(r *xdr.Reader) error {
+	if r.Err() != nil {
+		return errors.Wrap(r.Err(), "nfs protocol error")
+	}
+	return nil
+}
+
+// nfsCall calls an NFS procedure
+func (c *rpcClient) nfsCall(ctx context.Context, proc uint32, w *xdr.Writer) (*xdr.Reader, error) {
+	return c.call(ctx, nfsProgram, nfsVersion, proc, w.Bytes())
+}
+
+// mount mounts the export returning the file handle of its root
+func (c *rpcClient) mount(ctx context.Context, export string) (handle []byte, err error) {
+	var w xdr.Writer
+	w.String(export)
+	r, err := c.call(ctx, mountProgram, mountVersion, mountProcMnt, w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status := r.Uint32(); r.Err() == nil && status != 0 {
+		return nil, errors.Wrapf(nfsStatus(status), "failed to mount %q", export)
+	}
+	handle = r.Opaque(maxHandle)
+	return handle, checkResult(r)
+}
+
+// unmount tells the server the export is no longer mounted
+func (c *rpcClient) unmount(ctx context.Context, export string) error {
+	var w xdr.Writer
+	w.String(export)
+	_, err := c.call(ctx, mountProgram, mountVersion, mountProcUmt, w.Bytes())
+	return err
+}
+
+// getAttr reads the attributes of handle
+func (c *rpcClient) getAttr(ctx context.Context, handle []byte) (*fileAttr, error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	r, err := c.nfsCall(ctx, procGetAttr, &w)
+	if err != nil {
+		return nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+	attr := readAttr(r)
+	return attr, checkResult(r)
+}
+
+// setAttr sets the attributes of handle
+func (c *rpcClient) setAttr(ctx context.Context, handle []byte, s setAttr) error {
+	var w xdr.Writer
+	w.Opaque(handle)
+	writeSetAttr(&w, s)
+	w.Bool(false) // no guard
+	r, err := c.nfsCall(ctx, procSetAttr, &w)
+	if err != nil {
+		return err
+	}
+	return readStatus(r)
+}
+
+// lookup finds name in the directory dir
+func (c *rpcClient) lookup(ctx context.Context, dir []byte, name string) (handle []byte, attr *fileAttr, err error) {
+	var w xdr.Writer
+	w.Opaque(dir)
+	w.String(name)
+	r, err := c.nfsCall(ctx, procLookup, &w)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, nil, err
+	}
+	handle = r.Opaque(maxHandle)
+	attr = readPostOpAttr(r)
+	if err = checkResult(r); err != nil {
+		return nil, nil, err
+	}
+	if attr == nil {
+		attr, err = c.getAttr(ctx, handle)
+	}
+	return handle, attr, err
+}
+
+// read reads up to count bytes from handle at offset
+func (c *rpcClient) read(ctx context.Context, handle []byte, offset uint64, count uint32) (data []byte, eof bool, err error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	w.Uint64(offset)
+	w.Uint32(count)
+	r, err := c.nfsCall(ctx, procRead, &w)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, false, err
+	}
+	readPostOpAttr(r)
+	r.Uint32() // count
+	eof = r.Bool()
+	data = r.Opaque(int(count))
+	return data, eof, checkResult(r)
+}
+
+// write writes data unstably to handle at offset returning the number
+// of bytes written and the write verifier
+func (c *rpcClient) write(ctx context.Context, handle []byte, offset uint64, data []byte) (n uint32, verf []byte, err error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	w.Uint64(offset)
+	w.Uint32(uint32(len(data)))
+	w.Uint32(writeUnstable)
+	w.Opaque(data)
+	r, err := c.nfsCall(ctx, procWrite, &w)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return 0, nil, err
+	}
+	readWcc(r)
+	n = r.Uint32()
+	r.Uint32() // committed
+	verf = r.Fixed(verfLength)
+	return n, verf, checkResult(r)
+}
+
+// commit commits the unstable writes to handle returning the write
+// verifier
+func (c *rpcClient) commit(ctx context.Context, handle []byte) (verf []byte, err error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	w.Uint64(0) // offset
+	w.Uint32(0) // count - 0 means the whole file
+	r, err := c.nfsCall(ctx, procCommit, &w)
+	if err != nil {
+		return nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+	readWcc(r)
+	verf = r.Fixed(verfLength)
+	return verf, checkResult(r)
+}
+
+// create makes or truncates the file name in dir
+func (c *rpcClient) create(ctx context.Context, dir []byte, name string, how uint32, mode uint32) (handle []byte, err error) {
+	var w xdr.Writer
+	w.Opaque(dir)
+	w.String(name)
+	w.Uint32(how)
+	size := uint64(0)
+	writeSetAttr(&w, setAttr{mode: &mode, size: &size})
+	return c.createResult(ctx, procCreate, &w, dir, name)
+}
+
+// mkdir makes the directory name in dir
+func (c *rpcClient) mkdir(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, err error) {
+	var w xdr.Writer
+	w.Opaque(dir)
+	w.String(name)
+	writeSetAttr(&w, setAttr{mode: &mode})
+	return c.createResult(ctx, procMkdir, &w, dir, name)
+}
+
+// createResult calls CREATE or MKDIR returning the handle of the
+// new object, looking it up if the server doesn't return it
+func (c *rpcClient) createResult(ctx context.Context, proc uint32, w *xdr.Writer, dir []byte, name string) (handle []byte, err error) {
+	r, err := c.nfsCall(ctx, proc, w)
+	if err != nil {
+		return nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+	handle = readPostOpHandle(r)
+	if err = checkResult(r); err != nil {
+		return nil, err
+	}
+	if handle == nil {
+		handle, _, err = c.lookup(ctx, dir, name)
+	}
+	return handle, err
+}
+
+// remove removes the file (proc = procRemove) or directory (proc =
+// procRmdir) name in dir
+func (c *rpcClient) remove(ctx context.Context, proc uint32, dir []byte, name string) error {
+	var w xdr.Writer
+	w.Opaque(dir)
+	w.String(name)
+	r, err := c.nfsCall(ctx, proc, &w)
+	if err != nil {
+		return err
+	}
+	return readStatus(r)
+}
+
+// rename renames fromName in fromDir to toName in toDir
+func (c *rpcClient) rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error {
+	var w xdr.Writer
+	w.Opaque(fromDir)
+	w.String(fromName)
+	w.Opaque(toDir)
+	w.String(toName)
+	r, err := c.nfsCall(ctx, procRename, &w)
+	if err != nil {
+		return err
+	}
+	return readStatus(r)
+}
+
+// dirEntry is an entry read with READDIRPLUS
+type dirEntry struct {
+	name   string
+	handle []byte    // may be nil
+	attr   *fileAttr // may be nil
+}
+
+// readDirPlus reads a part of the directory dir starting at cookie
+//
+// It returns the entries, the cookie and cookie verifier to read the
+// next part with and whether the end of the directory was reached.
+func (c *rpcClient) readDirPlus(ctx context.Context, dir []byte, cookie uint64, cookieVerf []byte, maxCount uint32) (entries []dirEntry, nextCookie uint64, nextVerf []byte, eof bool, err error) {
+	if cookieVerf == nil {
+		cookieVerf = make([]byte, verfLength)
+	}
+	var w xdr.Writer
+	w.Opaque(dir)
+	w.Uint64(cookie)
+	w.Fixed(cookieVerf)
+	w.Uint32(maxCount / 4) // dircount - the size of the names and cookies
+	w.Uint32(maxCount)
+	r, err := c.nfsCall(ctx, procReadDirPlus, &w)
+	if err != nil {
+		return nil, 0, nil, false, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, 0, nil, false, err
+	}
+	readPostOpAttr(r)
+	nextVerf = r.Fixed(verfLength)
+	nextCookie = cookie
+	for r.Err() == nil && r.Bool() {
+		r.Uint64() // fileid
+		entry := dirEntry{
+			name: r.String(maxName),
+		}
+		nextCookie = r.Uint64()
+		entry.attr = readPostOpAttr(r)
+		entry.handle = readPostOpHandle(r)
+		entries = append(entries, entry)
+	}
+	eof = r.Bool()
+	return entries, nextCookie, nextVerf, eof, checkResult(r)
+}
+
+// fsStat is the result of FSSTAT
+type fsStat struct {
+	totalBytes uint64
+	freeBytes  uint64
+	availBytes uint64
+	totalFiles uint64
+	freeFiles  uint64
+}
+
+// fsStat reads the usage of the file system containing handle
+func (c *rpcClient) fsStat(ctx context.Context, handle []byte) (*fsStat, error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	r, err := c.nfsCall(ctx, procFSStat, &w)
+	if err != nil {
+		return nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+	readPostOpAttr(r)
+	stat := &fsStat{
+		totalBytes: r.Uint64(),
+		freeBytes:  r.Uint64(),
+		availBytes: r.Uint64(),
+		totalFiles: r.Uint64(),
+		freeFiles:  r.Uint64(),
+	}
+	return stat, checkResult(r)
+}
+
+// fsInfo is the result of FSINFO
+type fsInfo struct {
+	readMax   uint32
+	readPref  uint32
+	writeMax  uint32
+	writePref uint32
+	dirPref   uint32
+	timeDelta time.Duration
+}
+
+// fsInfo reads the static information about the file system
+// containing handle
+func (c *rpcClient) fsInfo(ctx context.Context, handle []byte) (*fsInfo, error) {
+	var w xdr.Writer
+	w.Opaque(handle)
+	r, err := c.nfsCall(ctx, procFSInfo, &w)
+	if err != nil {
+		return nil, err
+	}
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+	readPostOpAttr(r)
+	info := &fsInfo{}
+	info.readMax = r.Uint32()
+	info.readPref = r.Uint32()
+	r.Uint32() // rtmult
+	info.writeMax = r.Uint32()
+	info.writePref = r.Uint32()
+	r.Uint32() // wtmult
+	info.dirPref = r.Uint32()
+	r.Uint64() // maxfilesize
+	seconds := r.Uint32()
+	nanoseconds := r.Uint32()
+	info.timeDelta = time.Duration(seconds)*time.Second + time.Duration(nanoseconds)
+	return info, checkResult(r)
+}
+
+// nfs3 speaks NFS version 3 to the server using a pool of connections
+type nfs3 struct {
+	export    string    // path of the export
+	mountAddr string    // address of the MOUNT service
+	reserved  bool      // set to connect from a reserved port
+	cred      []byte    // encoded AUTH_SYS credentials
+	pool      *connPool // idle connections to the NFS service
+}
+
+// newNFS3 finds the NFS and MOUNT services on the server returning
+// the protocol and the address of the NFS service
+func newNFS3(ctx context.Context, opt *Options, cred []byte) (p *nfs3, nfsAddr string, err error) {
+	mountPort, err := servicePort(ctx, opt.Host, opt.MountPort, mountProgram, mountVersion)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "couldn't find MOUNT version 3 service")
+	}
+	nfsPort, err := servicePort(ctx, opt.Host, opt.Port, nfsProgram, nfsVersion)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "couldn't find NFS version 3 service - set version to 4.1 for servers which only speak NFS version 4")
+	}
+	nfsAddr = net.JoinHostPort(opt.Host, strconv.Itoa(nfsPort))
+	return &nfs3{
+		export:    opt.Export,
+		mountAddr: net.JoinHostPort(opt.Host, strconv.Itoa(mountPort)),
+		reserved:  opt.UseReservedPort,
+		cred:      cred,
+		pool:      newConnPool(nfsAddr, opt.UseReservedPort, authSys, cred),
+	}, nfsAddr, nil
+}
+
+// mount mounts the export returning the file handle of its root
+func (p *nfs3) mount(ctx context.Context) (root []byte, err error) {
+	c, err := dialRPC(ctx, p.mountAddr, p.reserved, authSys, p.cred)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.mount(ctx, p.export)
+}
+
+// fsInfo reads the static information about the export
+func (p *nfs3) fsInfo(ctx context.Context, root []byte) (info *fsInfo, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		info, err = c.fsInfo(ctx, root)
+		return err
+	})
+	return info, err
+}
+
+// fsStat reads the usage of the export
+func (p *nfs3) fsStat(ctx context.Context, root []byte) (stat *fsStat, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		stat, err = c.fsStat(ctx, root)
+		return err
+	})
+	return stat, err
+}
+
+// getAttr reads the attributes of handle
+func (p *nfs3) getAttr(ctx context.Context, handle []byte) (attr *fileAttr, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		attr, err = c.getAttr(ctx, handle)
+		return err
+	})
+	return attr, err
+}
+
+// setModTime sets the modification time of handle
+func (p *nfs3) setModTime(ctx context.Context, handle []byte, modTime time.Time) error {
+	return p.pool.call(ctx, func(c *rpcClient) error {
+		return c.setAttr(ctx, handle, setAttr{modTime: modTime})
+	})
+}
+
+// lookup finds name in the directory dir
+func (p *nfs3) lookup(ctx context.Context, dir []byte, name string) (handle []byte, attr *fileAttr, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		handle, attr, err = c.lookup(ctx, dir, name)
+		return err
+	})
+	return handle, attr, err
+}
+
+// readDir reads a part of the directory dir starting at cookie
+func (p *nfs3) readDir(ctx context.Context, dir []byte, cookie uint64, cookieVerf []byte, maxCount uint32) (entries []dirEntry, nextCookie uint64, nextVerf []byte, eof bool, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		entries, nextCookie, nextVerf, eof, err = c.readDirPlus(ctx, dir, cookie, cookieVerf, maxCount)
+		return err
+	})
+	return entries, nextCookie, nextVerf, eof, err
+}
+
+// mkdir makes the directory name in dir
+func (p *nfs3) mkdir(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		handle, err = c.mkdir(ctx, dir, name, mode)
+		return err
+	})
+	return handle, err
+}
+
+// remove removes the file or, if isDir is set, the directory name
+// in dir
+func (p *nfs3) remove(ctx context.Context, dir []byte, name string, isDir bool) error {
+	proc := uint32(procRemove)
+	if isDir {
+		proc = procRmdir
+	}
+	return p.pool.call(ctx, func(c *rpcClient) error {
+		return c.remove(ctx, proc, dir, name)
+	})
+}
+
+// rename renames fromName in fromDir to toName in toDir
+func (p *nfs3) rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error {
+	return p.pool.call(ctx, func(c *rpcClient) error {
+		return c.rename(ctx, fromDir, fromName, toDir, toName)
+	})
+}
+
+// open opens handle for reading
+func (p *nfs3) open(ctx context.Context, handle []byte) (openFile, error) {
+	return &nfs3File{p: p, handle: handle}, nil
+}
+
+// create makes or truncates the file name in dir and opens it for
+// writing
+func (p *nfs3) create(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, file openFile, err error) {
+	err = p.pool.call(ctx, func(c *rpcClient) error {
+		handle, err = c.create(ctx, dir, name, createUnchecked, mode)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return handle, &nfs3File{p: p, handle: handle}, nil
+}
+
+// shutdown unmounts the export and closes the connections
+func (p *nfs3) shutdown(ctx context.Context) error {
+	p.pool.close()
+	c, err := dialRPC(ctx, p.mountAddr, p.reserved, authSys, p.cred)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return c.unmount(ctx, p.export)
+}
+
+// nfs3File is a file opened with NFS version 3 which has no state on
+// the server
+type nfs3File struct {
+	p      *nfs3
+	handle []byte
+}
+
+// readSize returns the size of the READs to make given the size
+// the server prefers
+func (file *nfs3File) readSize(size uint32) uint32 {
+	return size
+}
+
+// read reads up to count bytes at offset
+func (file *nfs3File) read(ctx context.Context, offset uint64, count uint32) (data []byte, eof bool, err error) {
+	err = file.p.pool.call(ctx, func(c *rpcClient) error {
+		data, eof, err = c.read(ctx, file.handle, offset, count)
+		return err
+	})
+	return data, eof, err
+}
+
+// write writes data unstably at offset returning the number of bytes
+// written and the write verifier
+func (file *nfs3File) write(ctx context.Context, offset uint64, data []byte) (n uint32, verf []byte, err error) {
+	err = file.p.pool.call(ctx, func(c *rpcClient) error {
+		n, verf, err = c.write(ctx, file.handle, offset, data)
+		return err
+	})
+	return n, verf, err
+}
+
+// commit commits the unstable writes returning the write verifier
+func (file *nfs3File) commit(ctx context.Context) (verf []byte, err error) {
+	err = file.p.pool.call(ctx, func(c *rpcClient) error {
+		verf, err = c.commit(ctx, file.handle)
+		return err
+	})
+	return verf, err
+}
+
+// close does nothing as there is nothing to close
+func (file *nfs3File) close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,1236 @@
+// +build !plan9
+
+package nfs
+
+// This implements the client side of NFS version 4.1 (RFC 8881)
+// without delegations, locks or a back channel.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/xdr"
+)
+
+const (
+	nfs4Version  = 4
+	nfs4Minor    = 1
+	nfs4Port     = 2049
+	procCompound = 1
+
+	// operations
+	opClose           = 4
+	opCommit          = 5
+	opCreate          = 6
+	opDelegReturn     = 8
+	opGetAttr         = 9
+	opGetFH           = 10
+	opLookup          = 15
+	opOpen            = 18
+	opPutFH           = 22
+	opPutRootFH       = 24
+	opRead            = 25
+	opReadDir         = 26
+	opRemove          = 28
+	opRename          = 29
+	opSaveFH          = 32
+	opSetAttr         = 34
+	opWrite           = 38
+	opExchangeID      = 42
+	opCreateSession   = 43
+	opDestroySession  = 44
+	opGetDeviceInfo   = 47
+	opLayoutGet       = 50
+	opLayoutReturn    = 51
+	opSequence        = 53
+	opDestroyClientID = 57
+	opReclaimComplete = 58
+
+	// attributes
+	attrType          = 1
+	attrSize          = 4
+	attrLeaseTime     = 10
+	attrFileHandle    = 19
+	attrFileID        = 20
+	attrFilesFree     = 22
+	attrFilesTotal    = 23
+	attrMaxRead       = 30
+	attrMaxWrite      = 31
+	attrMode          = 33
+	attrSpaceAvail    = 42
+	attrSpaceFree     = 43
+	attrSpaceTotal    = 44
+	attrTimeDelta     = 51
+	attrTimeModify    = 53
+	attrTimeModifySet = 54
+	attrFSLayoutTypes = 62
+
+	// EXCHANGE_ID flags
+	exchangeUsePNFSMDS = 0x00020000
+	exchangeUsePNFSDS  = 0x00040000
+
+	// OPEN share access and claims
+	shareAccessRead   = 1
+	shareAccessWrite  = 2
+	shareWantNoDeleg  = 0x0400
+	openNoCreate      = 0
+	openCreate        = 1
+	claimNull         = 0
+	claimFH           = 4
+	delegateNone      = 0
+	delegateRead      = 1
+	delegateWrite     = 2
+	delegateNoneExt   = 3
+	whyNoDelegContend = 1
+	whyNoDelegResorce = 2
+	limitSize         = 1
+	limitBlocks       = 2
+
+	// how SETATTR sets the times
+	setToClientTime = 1
+
+	maxSlots       = 64      // most requests to have in progress on a session
+	maxSessionSize = 1 << 20 // largest request and reply to ask for beyond the data
+	maxOpaque      = 1 << 20 // largest opaque data we accept other than READ data
+	maxHandle4     = 128     // largest file handle
+	sessionIDLen   = 16
+	stateOtherLen  = 12
+	callbackProg   = 0x40000000
+	maxSessionTry  = 3 // number of times to make a new session for a call
+)
+
+// NFS version 4 status codes used by the backend
+const (
+	nfs4ErrExpired          nfsStatus = 10011
+	nfs4ErrGrace            nfsStatus = 10013
+	nfs4ErrStaleClientID    nfsStatus = 10022
+	nfs4ErrBadSession       nfsStatus = 10052
+	nfs4ErrCompleteAlready  nfsStatus = 10054
+	nfs4ErrSeqMisordered    nfsStatus = 10063
+	nfs4ErrRetryUncachedRep nfsStatus = 10068
+	nfs4ErrDeadSession      nfsStatus = 10078
+)
+
+// sessionLost returns true if status means the session or client ID
+// is no longer known to the server so a new one must be made
+func sessionLost(status nfsStatus) bool {
+	switch status {
+	case nfs4ErrBadSession, nfs4ErrDeadSession, nfs4ErrStaleClientID, nfs4ErrExpired, nfs4ErrSeqMisordered:
+		return true
+	}
+	return false
+}
+
+// stateID is a stateid4 identifying an open file or a layout
+type stateID struct {
+	seq   uint32
+	other []byte
+}
+
+// anonymousStateID is the special stateid for I/O without an OPEN
+var anonymousStateID = stateID{other: make([]byte, stateOtherLen)}
+
+// readStateID reads a stateid4
+func readStateID(r *xdr.Reader) stateID {
+	return stateID{
+		seq:   r.Uint32(),
+		other: r.Fixed(stateOtherLen),
+	}
+}
+
+// writeStateID writes a stateid4
+func writeStateID(w *xdr.Writer, s stateID) {
+	w.Uint32(s.seq)
+	w.Fixed(s.other)
+}
+
+// readTime4 reads an nfstime4
+func readTime4(r *xdr.Reader) time.Time {
+	seconds := int64(r.Uint64())
+	nanoseconds := r.Uint32()
+	return time.Unix(seconds, int64(nanoseconds))
+}
+
+// writeTime4 writes an nfstime4
+func writeTime4(w *xdr.Writer, t time.Time) {
+	w.Uint64(uint64(t.Unix()))
+	w.Uint32(uint32(t.Nanosecond()))
+}
+
+// attr4 is the fattr4 attributes the backend reads
+type attr4 struct {
+	fileAttr
+	handle      []byte
+	leaseTime   time.Duration
+	maxRead     uint64
+	maxWrite    uint64
+	filesFree   uint64
+	filesTotal  uint64
+	spaceAvail  uint64
+	spaceFree   uint64
+	spaceTotal  uint64
+	timeDelta   time.Duration
+	layoutTypes []uint32
+}
+
+// fileAttrs are the attributes read for files and directories
+var fileAttrs = xdr.NewBitmap(attrType, attrSize, attrFileHandle, attrFileID, attrMode, attrTimeModify)
+
+// readAttr4 reads a fattr4
+//
+// The values must be decoded in order so it fails if the server
+// returns an attribute it doesn't know.
+func readAttr4(r *xdr.Reader) *attr4 {
+	var a attr4
+	bitmap := r.Bitmap()
+	values := xdr.NewReader(r.Opaque(maxOpaque))
+	for _, bit := range bitmap.Bits() {
+		switch bit {
+		case attrType:
+			a.Type = values.Uint32()
+		case attrSize:
+			a.Size = values.Uint64()
+		case attrLeaseTime:
+			a.leaseTime = time.Duration(values.Uint32()) * time.Second
+		case attrFileHandle:
+			a.handle = values.Opaque(maxHandle4)
+		case attrFileID:
+			a.FileID = values.Uint64()
+		case attrFilesFree:
+			a.filesFree = values.Uint64()
+		case attrFilesTotal:
+			a.filesTotal = values.Uint64()
+		case attrMaxRead:
+			a.maxRead = values.Uint64()
+		case attrMaxWrite:
+			a.maxWrite = values.Uint64()
+		case attrMode:
+			a.Mode = values.Uint32()
+		case attrSpaceAvail:
+			a.spaceAvail = values.Uint64()
+		case attrSpaceFree:
+			a.spaceFree = values.Uint64()
+		case attrSpaceTotal:
+			a.spaceTotal = values.Uint64()
+		case attrTimeDelta:
+			t := readTime4(values)
+			a.timeDelta = time.Duration(t.Unix())*time.Second + time.Duration(t.Nanosecond())
+		case attrTimeModify:
+			a.ModTime = readTime4(values)
+		case attrFSLayoutTypes:
+			n := values.Uint32()
+			for i := uint32(0); i < n && values.Err() == nil; i++ {
+				a.layoutTypes = append(a.layoutTypes, values.Uint32())
+			}
+		default:
+			r.SetErr(errors.Errorf("unexpected attribute %d", bit))
+			return &a
+		}
+	}
+	if values.Err() != nil {
+		r.SetErr(values.Err())
+	}
+	return &a
+}
+
+// writeSetAttr4 writes a fattr4 setting the attributes in s
+func writeSetAttr4(w *xdr.Writer, s setAttr) {
+	var bitmap xdr.Bitmap
+	var values xdr.Writer
+	if s.size != nil {
+		bitmap.Set(attrSize)
+		values.Uint64(*s.size)
+	}
+	if s.mode != nil {
+		bitmap.Set(attrMode)
+		values.Uint32(*s.mode)
+	}
+	if !s.modTime.IsZero() {
+		bitmap.Set(attrTimeModifySet)
+		values.Uint32(setToClientTime)
+		writeTime4(&values, s.modTime)
+	}
+	w.Bitmap(bitmap)
+	w.Opaque(values.Bytes())
+}
+
+// compoundArgs are the operations of a COMPOUND being built
+type compoundArgs struct {
+	xdr.Writer
+	ops uint32
+}
+
+// op starts operation op
+func (a *compoundArgs) op(op uint32) {
+	a.ops++
+	a.Uint32(op)
+}
+
+// putFH adds PUTFH of handle
+func (a *compoundArgs) putFH(handle []byte) {
+	a.op(opPutFH)
+	a.Opaque(handle)
+}
+
+// compoundResult reads the results of a COMPOUND
+type compoundResult struct {
+	*xdr.Reader
+}
+
+// op reads the start of the result of op returning its status as an
+// error
+func (r compoundResult) op(op uint32) error {
+	got := r.Uint32()
+	status := nfsStatus(r.Uint32())
+	if r.Err() != nil {
+		return errors.Wrap(r.Err(), "nfs protocol error")
+	}
+	if got != op {
+		return errors.Errorf("nfs protocol error: expecting result of operation %d but got %d", op, got)
+	}
+	if status != nfsOK {
+		return status
+	}
+	return nil
+}
+
+// ops reads the results of ops which return only a status
+func (r compoundResult) ops(ops ...uint32) error {
+	for _, op := range ops {
+		if err := r.op(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// session4 is a session with a server
+type session4 struct {
+	clientID uint64
+	id       []byte
+	slots    chan *slot4 // idle slots
+	maxResp  uint32      // largest reply the server will send
+	maxReq   uint32      // largest request the server will accept
+	maxSlot  uint32      // highest slot ID
+}
+
+// slot4 is a slot of a session
+type slot4 struct {
+	id  uint32
+	seq uint32 // sequence ID of the last request completed
+}
+
+// client4 is a client of one NFS version 4.1 server with a session
+// which is made when needed and remade if the server loses it
+type client4 struct {
+	name  string    // for logging
+	pool  *connPool // idle connections to the server
+	owner []byte    // client owner ID
+	flags uint32    // EXCHANGE_ID flags to ask for
+
+	mu          sync.Mutex
+	session     *session4     // current session, nil if none
+	serverFlags uint32        // EXCHANGE_ID flags the server returned
+	opens       int           // number of files open
+	stopRenew   chan struct{} // closed to stop renewing the lease
+}
+
+// newClient4 makes a client for the server at addr
+func newClient4(name, addr string, opt *Options, cred, owner []byte, flags uint32) *client4 {
+	return &client4{
+		name:  name,
+		pool:  newConnPool(addr, opt.UseReservedPort, authSys, cred),
+		owner: owner,
+		flags: flags,
+	}
+}
+
+// rpc sends the COMPOUND args on a connection from the pool
+// returning the results after the header
+func (c *client4) rpc(ctx context.Context, args *compoundArgs) (res compoundResult, err error) {
+	var w xdr.Writer
+	w.String("") // tag
+	w.Uint32(nfs4Minor)
+	w.Uint32(args.ops)
+	w.Fixed(args.Bytes())
+	err = c.pool.call(ctx, func(rc *rpcClient) error {
+		r, err := rc.call(ctx, nfsProgram, nfs4Version, procCompound, w.Bytes())
+		if err != nil {
+			return err
+		}
+		r.Uint32()        // status - the failing operation has it too
+		r.Opaque(maxName) // tag
+		r.Uint32()        // number of results
+		res = compoundResult{r}
+		return checkResult(r)
+	})
+	return res, err
+}
+
+// newSession establishes a client ID and session with the server
+func (c *client4) newSession(ctx context.Context) (*session4, error) {
+	// EXCHANGE_ID
+	var args compoundArgs
+	args.op(opExchangeID)
+	var verifier [8]byte
+	_, _ = rand.Read(verifier[:])
+	args.Fixed(verifier[:])
+	args.Opaque(c.owner)
+	args.Uint32(c.flags)
+	args.Uint32(0) // SP4_NONE
+	args.Uint32(0) // no implementation ID
+	r, err := c.rpc(ctx, &args)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.op(opExchangeID); err != nil {
+		return nil, errors.Wrap(err, "EXCHANGE_ID failed")
+	}
+	s := &session4{}
+	s.clientID = r.Uint64()
+	seq := r.Uint32()
+	serverFlags := r.Uint32()
+	r.Uint32()          // state protection - SP4_NONE
+	r.Uint64()          // server owner minor ID
+	r.Opaque(maxOpaque) // server owner major ID
+	r.Opaque(maxOpaque) // server scope
+	if r.Uint32() != 0 {
+		r.String(maxName) // implementation domain
+		r.String(maxName) // implementation name
+		readTime4(r.Reader)
+	}
+	if err = checkResult(r.Reader); err != nil {
+		return nil, err
+	}
+
+	// CREATE_SESSION
+	args = compoundArgs{}
+	args.op(opCreateSession)
+	args.Uint64(s.clientID)
+	args.Uint32(seq)
+	args.Uint32(0) // flags - no persistence or back channel
+	// fore channel
+	args.Uint32(0)                  // header padding
+	args.Uint32(maxRecord)          // max request size
+	args.Uint32(maxRecord)          // max response size
+	args.Uint32(maxSessionSize / 4) // max cached response size
+	args.Uint32(16)                 // max operations
+	args.Uint32(maxSlots)           // max requests
+	args.Uint32(0)                  // no RDMA
+	// back channel which isn't used
+	args.Uint32(0)
+	args.Uint32(4096)
+	args.Uint32(4096)
+	args.Uint32(0)
+	args.Uint32(2)
+	args.Uint32(1)
+	args.Uint32(0)
+	args.Uint32(callbackProg)
+	args.Uint32(1) // callback security
+	args.Uint32(authNone)
+	r, err = c.rpc(ctx, &args)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.op(opCreateSession); err != nil {
+		return nil, errors.Wrap(err, "CREATE_SESSION failed")
+	}
+	s.id = r.Fixed(sessionIDLen)
+	r.Uint32() // sequence ID
+	r.Uint32() // flags
+	r.Uint32() // header padding
+	s.maxReq = r.Uint32()
+	s.maxResp = r.Uint32()
+	r.Uint32() // max cached response size
+	r.Uint32() // max operations
+	slots := r.Uint32()
+	if err = checkResult(r.Reader); err != nil {
+		return nil, err
+	}
+	if slots == 0 || slots > maxSlots {
+		slots = maxSlots
+	}
+	s.maxSlot = slots - 1
+	s.slots = make(chan *slot4, slots)
+	for i := uint32(0); i < slots; i++ {
+		s.slots <- &slot4{id: i}
+	}
+	c.serverFlags = serverFlags
+	fs.Debugf(nil, "%s: made NFS session with client ID %x", c.name, s.clientID)
+	return s, nil
+}
+
+// getSession returns the current session making one if necessary
+func (c *client4) getSession(ctx context.Context) (*session4, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session != nil {
+		return c.session, nil
+	}
+	s, err := c.newSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Tell the server we won't reclaim any state
+	err = c.send(ctx, s, func(args *compoundArgs) {
+		args.op(opReclaimComplete)
+		args.Bool(false) // for the whole client
+	}, func(r compoundResult) error {
+		err := r.op(opReclaimComplete)
+		if err == nfs4ErrCompleteAlready {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "RECLAIM_COMPLETE failed")
+	}
+	c.session = s
+	return s, nil
+}
+
+// resetSession forgets session s so the next call makes a new one
+func (c *client4) resetSession(s *session4) {
+	c.mu.Lock()
+	if c.session == s {
+		c.session = nil
+	}
+	c.mu.Unlock()
+}
+
+// send sends a COMPOUND on session s starting with SEQUENCE followed
+// by the operations written by args and reads their results with res
+func (c *client4) send(ctx context.Context, s *session4, args func(args *compoundArgs), res func(r compoundResult) error) error {
+	var slot *slot4
+	select {
+	case slot = <-s.slots:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	var a compoundArgs
+	a.op(opSequence)
+	a.Fixed(s.id)
+	a.Uint32(slot.seq + 1)
+	a.Uint32(slot.id)
+	a.Uint32(s.maxSlot)
+	a.Bool(false) // don't cache this
+	args(&a)
+	r, err := c.rpc(ctx, &a)
+	if err != nil {
+		// The server may or may not have seen the request so keep
+		// the sequence ID of the slot - if it did it will say so
+		// with RETRY_UNCACHED_REP.
+		s.slots <- slot
+		return err
+	}
+	err = r.op(opSequence)
+	if err == nil || err == nfs4ErrRetryUncachedRep {
+		slot.seq++
+	}
+	s.slots <- slot
+	if err != nil {
+		return err
+	}
+	r.Fixed(sessionIDLen)
+	r.Uint32() // sequence ID
+	r.Uint32() // slot ID
+	r.Uint32() // highest slot ID
+	r.Uint32() // target highest slot ID
+	r.Uint32() // status flags
+	if err = checkResult(r.Reader); err != nil {
+		return err
+	}
+	return res(r)
+}
+
+// compound sends a COMPOUND starting with SEQUENCE followed by the
+// operations written by args and reads their results with res.
+//
+// It makes a new session and tries again if the server has lost the
+// session.
+func (c *client4) compound(ctx context.Context, args func(args *compoundArgs), res func(r compoundResult) error) error {
+	for try := 1; ; try++ {
+		s, err := c.getSession(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.send(ctx, s, args, res)
+		status, ok := errors.Cause(err).(nfsStatus)
+		if !ok || try >= maxSessionTry {
+			return err
+		}
+		switch {
+		case status == nfs4ErrRetryUncachedRep:
+			continue
+		case sessionLost(status):
+			fs.Debugf(nil, "%s: making new NFS session: %v", c.name, err)
+			c.resetSession(s)
+			continue
+		}
+		return err
+	}
+}
+
+// opened records that a file was opened, starting the lease renewer
+// for the first one
+func (c *client4) opened(lease time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opens++
+	if c.opens == 1 {
+		c.stopRenew = make(chan struct{})
+		go c.renew(lease, c.stopRenew)
+	}
+}
+
+// closed records that a file was closed, stopping the lease renewer
+// after the last one
+func (c *client4) closed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opens--
+	if c.opens == 0 {
+		close(c.stopRenew)
+	}
+}
+
+// renew renews the lease every third of its time until stop is
+// closed so open files aren't lost while idle
+func (c *client4) renew(lease time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), lease/3)
+		err := c.compound(ctx, func(args *compoundArgs) {}, func(r compoundResult) error { return nil })
+		cancel()
+		if err != nil {
+			fs.Debugf(nil, "%s: failed to renew NFS lease: %v", c.name, err)
+		}
+	}
+}
+
+// shutdown destroys the session and client ID and closes the
+// connections
+func (c *client4) shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	s := c.session
+	c.session = nil
+	if c.opens > 0 {
+		close(c.stopRenew)
+		c.opens = 0
+	}
+	c.mu.Unlock()
+	defer c.pool.close()
+	if s == nil {
+		return nil
+	}
+	var args compoundArgs
+	args.op(opDestroySession)
+	args.Fixed(s.id)
+	r, err := c.rpc(ctx, &args)
+	if err == nil {
+		err = r.op(opDestroySession)
+	}
+	if err != nil {
+		return errors.Wrap(err, "DESTROY_SESSION failed")
+	}
+	args = compoundArgs{}
+	args.op(opDestroyClientID)
+	args.Uint64(s.clientID)
+	r, err = c.rpc(ctx, &args)
+	if err == nil {
+		err = r.op(opDestroyClientID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "DESTROY_CLIENTID failed")
+	}
+	return nil
+}
+
+// maxData returns the largest READ or WRITE the session allows
+func (c *client4) maxData(ctx context.Context) (maxRead, maxWrite uint32, err error) {
+	s, err := c.getSession(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	const overhead = 1024 // for the RPC and COMPOUND headers
+	return s.maxResp - overhead, s.maxReq - overhead, nil
+}
+
+// nfs4 speaks NFS version 4.1 to the server
+type nfs4 struct {
+	opt        *Options
+	cred       []byte   // encoded AUTH_SYS credentials
+	owner      []byte   // client owner ID unique to this Fs
+	mds        *client4 // the server
+	lease      time.Duration
+	openOwners uint64 // number of open owners made
+
+	// pNFS state
+	pnfs        bool // set if the server gives out file layouts
+	mu          sync.Mutex
+	devices     map[string]*device  // by device ID
+	dataServers map[string]*client4 // by address
+}
+
+// newNFS4 makes the protocol for the server returning the address of
+// its NFS service
+//
+// It doesn't talk to the server until the export is mounted.
+func newNFS4(ctx context.Context, opt *Options, cred []byte) (p *nfs4, nfsAddr string) {
+	port := opt.Port
+	if port == 0 {
+		port = nfs4Port
+	}
+	nfsAddr = net.JoinHostPort(opt.Host, strconv.Itoa(port))
+	// The owner must be unique to this client and the same for all
+	// its sessions
+	machine := opt.MachineName
+	if machine == "" {
+		machine, _ = os.Hostname()
+	}
+	var random [8]byte
+	_, _ = rand.Read(random[:])
+	owner := []byte("rclone " + machine + " " + hex.EncodeToString(random[:]))
+	return &nfs4{
+		opt:         opt,
+		cred:        cred,
+		owner:       owner,
+		mds:         newClient4(opt.Host, nfsAddr, opt, cred, owner, 0),
+		devices:     make(map[string]*device),
+		dataServers: make(map[string]*client4),
+	}, nfsAddr
+}
+
+// getAttrs reads the attributes in bitmap of handle
+func (p *nfs4) getAttrs(ctx context.Context, handle []byte, bitmap xdr.Bitmap) (attr *attr4, err error) {
+	err = p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(handle)
+		args.op(opGetAttr)
+		args.Bitmap(bitmap)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opGetAttr); err != nil {
+			return err
+		}
+		attr = readAttr4(r.Reader)
+		return checkResult(r.Reader)
+	})
+	return attr, err
+}
+
+// mount finds the file handle of the root of the export
+func (p *nfs4) mount(ctx context.Context) (root []byte, err error) {
+	var names []string
+	for _, name := range strings.Split(p.opt.Export, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	err = p.mds.compound(ctx, func(args *compoundArgs) {
+		args.op(opPutRootFH)
+		for _, name := range names {
+			args.op(opLookup)
+			args.String(name)
+		}
+		args.op(opGetFH)
+	}, func(r compoundResult) error {
+		if err := r.op(opPutRootFH); err != nil {
+			return err
+		}
+		for range names {
+			if err := r.op(opLookup); err != nil {
+				return errors.Wrapf(err, "failed to mount %q", p.opt.Export)
+			}
+		}
+		if err := r.op(opGetFH); err != nil {
+			return err
+		}
+		root = r.Opaque(maxHandle4)
+		return checkResult(r.Reader)
+	})
+	return root, err
+}
+
+// fsInfo reads the static information about the export
+func (p *nfs4) fsInfo(ctx context.Context, root []byte) (*fsInfo, error) {
+	attr, err := p.getAttrs(ctx, root, xdr.NewBitmap(attrLeaseTime, attrMaxRead, attrMaxWrite, attrTimeDelta, attrFSLayoutTypes))
+	if err != nil {
+		return nil, err
+	}
+	maxRead, maxWrite, err := p.mds.maxData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := &fsInfo{
+		readMax:   maxRead,
+		writeMax:  maxWrite,
+		timeDelta: attr.timeDelta,
+	}
+	if attr.maxRead != 0 && attr.maxRead < uint64(info.readMax) {
+		info.readMax = uint32(attr.maxRead)
+	}
+	if attr.maxWrite != 0 && attr.maxWrite < uint64(info.writeMax) {
+		info.writeMax = uint32(attr.maxWrite)
+	}
+	info.readPref = info.readMax
+	info.writePref = info.writeMax
+	p.lease = attr.leaseTime
+	if p.lease <= 0 {
+		p.lease = 90 * time.Second
+	}
+	p.mds.mu.Lock()
+	usePNFS := p.mds.serverFlags&exchangeUsePNFSMDS != 0
+	p.mds.mu.Unlock()
+	for _, layoutType := range attr.layoutTypes {
+		if layoutType == layoutNFSv41Files && usePNFS {
+			p.pnfs = true
+			fs.Debugf(nil, "%s: server supports pNFS file layouts", p.opt.Host)
+		}
+	}
+	return info, nil
+}
+
+// fsStat reads the usage of the export
+func (p *nfs4) fsStat(ctx context.Context, root []byte) (*fsStat, error) {
+	attr, err := p.getAttrs(ctx, root, xdr.NewBitmap(attrFilesFree, attrFilesTotal, attrSpaceAvail, attrSpaceFree, attrSpaceTotal))
+	if err != nil {
+		return nil, err
+	}
+	return &fsStat{
+		totalBytes: attr.spaceTotal,
+		freeBytes:  attr.spaceFree,
+		availBytes: attr.spaceAvail,
+		totalFiles: attr.filesTotal,
+		freeFiles:  attr.filesFree,
+	}, nil
+}
+
+// getAttr reads the attributes of handle
+func (p *nfs4) getAttr(ctx context.Context, handle []byte) (*fileAttr, error) {
+	attr, err := p.getAttrs(ctx, handle, fileAttrs)
+	if err != nil {
+		return nil, err
+	}
+	return &attr.fileAttr, nil
+}
+
+// setModTime sets the modification time of handle
+func (p *nfs4) setModTime(ctx context.Context, handle []byte, modTime time.Time) error {
+	return p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(handle)
+		args.op(opSetAttr)
+		writeStateID(&args.Writer, anonymousStateID)
+		writeSetAttr4(&args.Writer, setAttr{modTime: modTime})
+	}, func(r compoundResult) error {
+		return r.ops(opPutFH, opSetAttr)
+	})
+}
+
+// lookup finds name in the directory dir
+func (p *nfs4) lookup(ctx context.Context, dir []byte, name string) (handle []byte, attr *fileAttr, err error) {
+	err = p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(dir)
+		args.op(opLookup)
+		args.String(name)
+		args.op(opGetAttr)
+		args.Bitmap(fileAttrs)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opLookup, opGetAttr); err != nil {
+			return err
+		}
+		a := readAttr4(r.Reader)
+		handle, attr = a.handle, &a.fileAttr
+		return checkResult(r.Reader)
+	})
+	return handle, attr, err
+}
+
+// readDir reads a part of the directory dir starting at cookie
+func (p *nfs4) readDir(ctx context.Context, dir []byte, cookie uint64, cookieVerf []byte, maxCount uint32) (entries []dirEntry, nextCookie uint64, nextVerf []byte, eof bool, err error) {
+	if cookieVerf == nil {
+		cookieVerf = make([]byte, verfLength)
+	}
+	err = p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(dir)
+		args.op(opReadDir)
+		args.Uint64(cookie)
+		args.Fixed(cookieVerf)
+		args.Uint32(maxCount / 4) // dircount - the size of the names and cookies
+		args.Uint32(maxCount)
+		args.Bitmap(fileAttrs)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opReadDir); err != nil {
+			return err
+		}
+		nextVerf = r.Fixed(verfLength)
+		nextCookie = cookie
+		for r.Err() == nil && r.Bool() {
+			nextCookie = r.Uint64()
+			entry := dirEntry{
+				name: r.String(maxName),
+			}
+			a := readAttr4(r.Reader)
+			entry.handle, entry.attr = a.handle, &a.fileAttr
+			entries = append(entries, entry)
+		}
+		eof = r.Bool()
+		return checkResult(r.Reader)
+	})
+	return entries, nextCookie, nextVerf, eof, err
+}
+
+// mkdir makes the directory name in dir
+func (p *nfs4) mkdir(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, err error) {
+	err = p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(dir)
+		args.op(opCreate)
+		args.Uint32(typeDir)
+		args.String(name)
+		writeSetAttr4(&args.Writer, setAttr{mode: &mode})
+		args.op(opGetFH)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opCreate); err != nil {
+			return err
+		}
+		readChangeInfo(r.Reader)
+		r.Bitmap() // attributes set
+		if err := r.op(opGetFH); err != nil {
+			return err
+		}
+		handle = r.Opaque(maxHandle4)
+		return checkResult(r.Reader)
+	})
+	return handle, err
+}
+
+// readChangeInfo skips a change_info4
+func readChangeInfo(r *xdr.Reader) {
+	r.Bool()   // atomic
+	r.Uint64() // before
+	r.Uint64() // after
+}
+
+// remove removes the file or directory name in dir
+//
+// NFS version 4 removes both with REMOVE so isDir isn't needed.
+func (p *nfs4) remove(ctx context.Context, dir []byte, name string, isDir bool) error {
+	return p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(dir)
+		args.op(opRemove)
+		args.String(name)
+	}, func(r compoundResult) error {
+		return r.ops(opPutFH, opRemove)
+	})
+}
+
+// rename renames fromName in fromDir to toName in toDir
+func (p *nfs4) rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error {
+	return p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(fromDir)
+		args.op(opSaveFH)
+		args.putFH(toDir)
+		args.op(opRename)
+		args.String(fromName)
+		args.String(toName)
+	}, func(r compoundResult) error {
+		return r.ops(opPutFH, opSaveFH, opPutFH, opRename)
+	})
+}
+
+// openArgs writes an OPEN
+//
+// Each OPEN has its own open owner so files opened more than once
+// have independent state which can be closed separately.
+func (p *nfs4) openArgs(args *compoundArgs, access uint32, clientID uint64) {
+	args.op(opOpen)
+	args.Uint32(0) // sequence ID - not used by NFS version 4.1
+	args.Uint32(access | shareWantNoDeleg)
+	args.Uint32(0) // deny nothing
+	args.Uint64(clientID)
+	args.String("open " + strconv.FormatUint(atomic.AddUint64(&p.openOwners, 1), 10))
+}
+
+// readOpenResult reads the result of OPEN returning the stateid and
+// any delegation the server gave out anyway
+func readOpenResult(r compoundResult) (state stateID, deleg *stateID, err error) {
+	if err = r.op(opOpen); err != nil {
+		return state, nil, err
+	}
+	state = readStateID(r.Reader)
+	readChangeInfo(r.Reader)
+	r.Uint32() // result flags
+	r.Bitmap() // attributes set
+	switch delegType := r.Uint32(); delegType {
+	case delegateNone:
+	case delegateRead, delegateWrite:
+		// The delegation must be returned as there is no back
+		// channel to recall it on
+		d := readStateID(r.Reader)
+		deleg = &d
+		r.Bool() // recall
+		if delegType == delegateWrite {
+			switch r.Uint32() { // space limit
+			case limitSize:
+				r.Uint64()
+			case limitBlocks:
+				r.Uint32()
+				r.Uint32()
+			}
+		}
+		r.Uint32()        // ACE type
+		r.Uint32()        // ACE flags
+		r.Uint32()        // ACE access mask
+		r.String(maxName) // ACE who
+	case delegateNoneExt:
+		switch r.Uint32() {
+		case whyNoDelegContend, whyNoDelegResorce:
+			r.Bool()
+		}
+	default:
+		r.SetErr(errors.New("unknown delegation type"))
+	}
+	return state, deleg, checkResult(r.Reader)
+}
+
+// open opens handle for reading
+func (p *nfs4) open(ctx context.Context, handle []byte) (openFile, error) {
+	return p.openFile(ctx, handle, func(args *compoundArgs, clientID uint64) {
+		args.putFH(handle)
+		p.openArgs(args, shareAccessRead, clientID)
+		args.Uint32(openNoCreate)
+		args.Uint32(claimFH)
+		args.op(opGetAttr)
+		args.Bitmap(xdr.NewBitmap(attrSize))
+	}, func(r compoundResult, file *nfs4File) error {
+		if err := r.op(opGetAttr); err != nil {
+			return err
+		}
+		file.size = readAttr4(r.Reader).Size
+		return nil
+	})
+}
+
+// create makes or truncates the file name in dir and opens it for
+// writing
+func (p *nfs4) create(ctx context.Context, dir []byte, name string, mode uint32) (handle []byte, file openFile, err error) {
+	var nfile *nfs4File
+	file, err = p.openFile(ctx, dir, func(args *compoundArgs, clientID uint64) {
+		args.putFH(dir)
+		p.openArgs(args, shareAccessWrite, clientID)
+		args.Uint32(openCreate)
+		args.Uint32(createUnchecked)
+		size := uint64(0)
+		writeSetAttr4(&args.Writer, setAttr{mode: &mode, size: &size})
+		args.Uint32(claimNull)
+		args.String(name)
+		args.op(opGetFH)
+	}, func(r compoundResult, file *nfs4File) error {
+		if err := r.op(opGetFH); err != nil {
+			return err
+		}
+		file.handle = r.Opaque(maxHandle4)
+		nfile = file
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return nfile.handle, file, nil
+}
+
+// openFile sends the OPEN written by args, reading the results after
+// it with res
+func (p *nfs4) openFile(ctx context.Context, handle []byte, args func(args *compoundArgs, clientID uint64), res func(r compoundResult, file *nfs4File) error) (openFile, error) {
+	s, err := p.mds.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file := &nfs4File{
+		p:      p,
+		handle: handle,
+	}
+	var deleg *stateID
+	err = p.mds.compound(ctx, func(a *compoundArgs) {
+		args(a, s.clientID)
+	}, func(r compoundResult) error {
+		if err := r.op(opPutFH); err != nil {
+			return err
+		}
+		var err error
+		file.state, deleg, err = readOpenResult(r)
+		if err != nil {
+			return err
+		}
+		return res(r, file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.mds.opened(p.lease)
+	if deleg != nil {
+		p.delegReturn(ctx, file.handle, *deleg)
+	}
+	if p.pnfs && file.size > 0 {
+		p.layoutGet(ctx, file)
+	}
+	return file, nil
+}
+
+// delegReturn returns a delegation the server gave out
+func (p *nfs4) delegReturn(ctx context.Context, handle []byte, deleg stateID) {
+	err := p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(handle)
+		args.op(opDelegReturn)
+		writeStateID(&args.Writer, deleg)
+	}, func(r compoundResult) error {
+		return r.ops(opPutFH, opDelegReturn)
+	})
+	if err != nil {
+		fs.Debugf(nil, "%s: failed to return delegation: %v", p.opt.Host, err)
+	}
+}
+
+// shutdown destroys the sessions and closes the connections
+func (p *nfs4) shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	dataServers := p.dataServers
+	p.dataServers = make(map[string]*client4)
+	p.mu.Unlock()
+	for _, ds := range dataServers {
+		if err := ds.shutdown(ctx); err != nil {
+			fs.Debugf(nil, "%s: %v", ds.name, err)
+		}
+	}
+	return p.mds.shutdown(ctx)
+}
+
+// nfs4File is a file opened with NFS version 4.1
+type nfs4File struct {
+	p      *nfs4
+	handle []byte
+	state  stateID  // open stateid
+	size   uint64   // size of the file when opened for reading
+	layout *layout4 // the pNFS layout or nil to use the server
+
+	mu             sync.Mutex // held while closing
+	layoutReturned bool       // set once the layout has been returned
+	closed         bool       // set once the file has been closed
+}
+
+// readSize returns the size of the READs to make given the size
+// the server prefers
+func (file *nfs4File) readSize(size uint32) uint32 {
+	return file.layout.readSize(size)
+}
+
+// read reads up to count bytes at offset
+func (file *nfs4File) read(ctx context.Context, offset uint64, count uint32) (data []byte, eof bool, err error) {
+	if segment := file.layout.find(offset); segment != nil {
+		data, eof, err = file.readDataServer(ctx, segment, offset, count)
+		if err == nil {
+			return data, eof, nil
+		}
+		if ctx.Err() != nil {
+			return nil, false, err
+		}
+		fs.Debugf(nil, "%s: reading from the server after pNFS read failed: %v", file.p.opt.Host, err)
+	}
+	err = file.p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opRead)
+		writeStateID(&args.Writer, file.state)
+		args.Uint64(offset)
+		args.Uint32(count)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opRead); err != nil {
+			return err
+		}
+		eof = r.Bool()
+		data = r.Opaque(int(count))
+		return checkResult(r.Reader)
+	})
+	return data, eof, err
+}
+
+// write writes data unstably at offset returning the number of bytes
+// written and the write verifier
+func (file *nfs4File) write(ctx context.Context, offset uint64, data []byte) (n uint32, verf []byte, err error) {
+	err = file.p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opWrite)
+		writeStateID(&args.Writer, file.state)
+		args.Uint64(offset)
+		args.Uint32(writeUnstable)
+		args.Opaque(data)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opWrite); err != nil {
+			return err
+		}
+		n = r.Uint32()
+		r.Uint32() // committed
+		verf = r.Fixed(verfLength)
+		return checkResult(r.Reader)
+	})
+	return n, verf, err
+}
+
+// commit commits the unstable writes returning the write verifier
+func (file *nfs4File) commit(ctx context.Context) (verf []byte, err error) {
+	err = file.p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opCommit)
+		args.Uint64(0) // offset
+		args.Uint32(0) // count - 0 means the whole file
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opCommit); err != nil {
+			return err
+		}
+		verf = r.Fixed(verfLength)
+		return checkResult(r.Reader)
+	})
+	return verf, err
+}
+
+// close returns the layout and closes the file
+//
+// It can be called more than once if it fails.
+func (file *nfs4File) close(ctx context.Context) error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return nil
+	}
+	if file.layout != nil && !file.layoutReturned {
+		file.layoutReturn(ctx)
+		file.layoutReturned = true
+	}
+	err := file.p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opClose)
+		args.Uint32(0) // sequence ID - not used by NFS version 4.1
+		writeStateID(&args.Writer, file.state)
+	}, func(r compoundResult) error {
+		return r.ops(opPutFH, opClose)
+	})
+	if retry, _ := shouldRetry(err); retry {
+		return err
+	}
+	file.closed = true
+	file.p.mds.closed()
+	return err
+}
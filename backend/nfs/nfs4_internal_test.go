@@ -0,0 +1,981 @@
+// +build !plan9
+
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStripeUnit  = 64 << 10
+	testFirstStripe = 1
+	pseudoRoot      = "\x00" // path of the root of the pseudo file system
+)
+
+// fakeServer4 is the NFS version 4.1 state of a fakeServer
+//
+// The server exports its directory as testExport in its pseudo file
+// system. If it has data servers it gives out dense file layouts
+// striping files across them. The data servers read the directory of
+// the server.
+type fakeServer4 struct {
+	mds         *fakeServer   // the server of a data server
+	index       int           // index of a data server
+	dataServers []*fakeServer // pNFS data servers
+
+	sessions      map[string][]uint32 // session ID to sequence IDs of its slots
+	nextClientID  uint64
+	nextState     uint64
+	opens         map[string]string // open stateid to path
+	layoutGets    int32
+	layoutReturns int32
+	failReads     int32 // set to fail READs on a data server
+	inFlight      int32 // READs in progress on all the data servers
+	maxInFlight   int32 // most READs seen in progress at once on all the data servers
+}
+
+func newFakeServer4(t *testing.T, root string, dataServers int) *fakeServer {
+	srv := newFakeServer(t, root)
+	srv.v4.sessions = map[string][]uint32{}
+	srv.v4.opens = map[string]string{}
+	for i := 0; i < dataServers; i++ {
+		ds := newFakeServer(t, root)
+		ds.v4.mds = srv
+		ds.v4.index = i
+		ds.v4.sessions = map[string][]uint32{}
+		srv.v4.dataServers = append(srv.v4.dataServers, ds)
+	}
+	return srv
+}
+
+// close4 closes the server and its data servers
+func (srv *fakeServer) close4() {
+	for _, ds := range srv.v4.dataServers {
+		ds.close()
+	}
+	srv.close()
+}
+
+// config4 returns the config for a remote using the server with NFS
+// version 4.1
+func (srv *fakeServer) config4() configmap.Simple {
+	return configmap.Simple{
+		"host":           "127.0.0.1",
+		"export":         testExport,
+		"version":        "4.1",
+		"port":           srv.port(),
+		"parallel_reads": "4",
+	}
+}
+
+// dropSessions forgets all sessions as a restarted server would
+func (srv *fakeServer) dropSessions() {
+	srv.mu.Lock()
+	srv.v4.sessions = map[string][]uint32{}
+	srv.mu.Unlock()
+}
+
+// openFiles returns the number of files open
+func (srv *fakeServer) openFiles() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.v4.opens)
+}
+
+// openPath returns the path of the open stateid or "" if it isn't open
+func (srv *fakeServer) openPath(state stateID) string {
+	if srv.v4.mds != nil {
+		return srv.v4.mds.openPath(state)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.v4.opens[string(state.other)]
+}
+
+// newState returns a new stateid
+func (srv *fakeServer) newState() stateID {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.v4.nextState++
+	state := stateID{seq: 1, other: make([]byte, stateOtherLen)}
+	binary.BigEndian.PutUint64(state.other, srv.v4.nextState)
+	return state
+}
+
+// attr4 returns the fattr4 for the attributes of p in bitmap which
+// the server supports
+func (srv *fakeServer) attr4(p string, bitmap xdr.Bitmap) ([]byte, error) {
+	local := srv.local(p)
+	if p == pseudoRoot {
+		local = srv.root
+	}
+	fi, err := os.Lstat(local)
+	if err != nil {
+		return nil, err
+	}
+	var set xdr.Bitmap
+	var values xdr.Writer
+	for _, bit := range bitmap.Bits() {
+		switch bit {
+		case attrType:
+			if fi.IsDir() {
+				values.Uint32(typeDir)
+			} else if fi.Mode().IsRegular() {
+				values.Uint32(typeRegular)
+			} else {
+				values.Uint32(5) // symlink
+			}
+		case attrSize:
+			values.Uint64(uint64(fi.Size()))
+		case attrLeaseTime:
+			values.Uint32(90)
+		case attrFileHandle:
+			values.Opaque(srv.handle(p))
+		case attrFileID:
+			values.Fixed(srv.handle(p))
+		case attrFilesFree:
+			values.Uint64(500)
+		case attrFilesTotal:
+			values.Uint64(1000)
+		case attrMaxRead:
+			values.Uint64(uint64(srv.readMax))
+		case attrMaxWrite:
+			values.Uint64(1 << 20)
+		case attrMode:
+			values.Uint32(uint32(fi.Mode().Perm()))
+		case attrSpaceAvail:
+			values.Uint64(1 << 28)
+		case attrSpaceFree:
+			values.Uint64(1 << 29)
+		case attrSpaceTotal:
+			values.Uint64(1 << 30)
+		case attrTimeDelta:
+			writeTime4(&values, time.Unix(0, 1))
+		case attrTimeModify:
+			writeTime4(&values, fi.ModTime())
+		case attrFSLayoutTypes:
+			if len(srv.v4.dataServers) == 0 {
+				continue
+			}
+			values.Uint32(1)
+			values.Uint32(layoutNFSv41Files)
+		default:
+			continue
+		}
+		set.Set(bit)
+	}
+	var w xdr.Writer
+	w.Bitmap(set)
+	w.Opaque(values.Bytes())
+	return w.Bytes(), nil
+}
+
+// readSetAttr4 reads the fattr4 of SETATTR, OPEN and CREATE
+func readSetAttr4(r *xdr.Reader) (s setAttr) {
+	bitmap := r.Bitmap()
+	values := xdr.NewReader(r.Opaque(maxOpaque))
+	for _, bit := range bitmap.Bits() {
+		switch bit {
+		case attrSize:
+			size := values.Uint64()
+			s.size = &size
+		case attrMode:
+			mode := values.Uint32()
+			s.mode = &mode
+		case attrTimeModifySet:
+			if values.Uint32() == setToClientTime {
+				s.modTime = readTime4(values)
+			}
+		default:
+			r.SetErr(errors.Errorf("unexpected attribute %d", bit))
+		}
+	}
+	return s
+}
+
+// compound serves an NFS version 4.1 COMPOUND
+func (srv *fakeServer) compound(r *xdr.Reader, w *xdr.Writer) {
+	r.Opaque(maxName) // tag
+	r.Uint32()        // minor version
+	n := r.Uint32()
+	statusAt := w.Len()
+	w.Uint32(0) // status
+	w.String("")
+	countAt := w.Len()
+	w.Uint32(0) // number of results
+	var (
+		current, saved string
+		haveFH         bool
+		count          uint32
+		status         nfsStatus
+	)
+	for i := uint32(0); i < n && status == nfsOK; i++ {
+		op := r.Uint32()
+		if r.Err() != nil {
+			status = 10036 // bad XDR
+			break
+		}
+		w.Uint32(op)
+		count++
+		if i == 0 && op != opSequence && op != opExchangeID && op != opCreateSession && op != opDestroySession && op != opDestroyClientID {
+			status = 10071 // op not in session
+			w.Uint32(uint32(status))
+			break
+		}
+		needFH := op != opSequence && op != opExchangeID && op != opCreateSession && op != opDestroySession &&
+			op != opDestroyClientID && op != opReclaimComplete && op != opPutFH && op != opPutRootFH && op != opGetDeviceInfo
+		if needFH && !haveFH {
+			status = 10020 // no file handle
+			w.Uint32(uint32(status))
+			break
+		}
+		status = srv.op4(op, r, w, &current, &saved)
+		haveFH = haveFH || op == opPutFH || op == opPutRootFH
+	}
+	binary.BigEndian.PutUint32(w.Bytes()[statusAt:], uint32(status))
+	binary.BigEndian.PutUint32(w.Bytes()[countAt:], count)
+}
+
+// op4 serves one operation with current and saved file handles
+// returning its status
+func (srv *fakeServer) op4(op uint32, r *xdr.Reader, w *xdr.Writer, current, saved *string) nfsStatus {
+	status := func(err error) bool {
+		w.Uint32(uint32(toStatus(err)))
+		return err == nil
+	}
+	fail := func(status nfsStatus) nfsStatus {
+		w.Uint32(uint32(status))
+		return status
+	}
+	isDS := srv.v4.mds != nil
+	if isDS {
+		switch op {
+		case opExchangeID, opCreateSession, opDestroySession, opDestroyClientID, opSequence, opReclaimComplete, opPutFH, opRead:
+		default:
+			return fail(10004) // not supported on a data server
+		}
+	}
+	switch op {
+	case opExchangeID:
+		r.Fixed(8)           // verifier
+		r.Opaque(maxOpaque)  // owner
+		flags := r.Uint32()  // flags
+		if r.Uint32() != 0 { // state protection
+			return fail(10004)
+		}
+		if r.Uint32() != 0 { // implementation ID
+			r.String(maxName)
+			r.String(maxName)
+			readTime4(r)
+		}
+		if isDS && flags&exchangeUsePNFSDS == 0 {
+			return fail(22) // invalid
+		}
+		srv.mu.Lock()
+		srv.v4.nextClientID++
+		clientID := srv.v4.nextClientID
+		srv.mu.Unlock()
+		w.Uint32(uint32(nfsOK))
+		w.Uint64(clientID)
+		w.Uint32(1) // sequence ID
+		switch {
+		case isDS:
+			w.Uint32(exchangeUsePNFSDS)
+		case len(srv.v4.dataServers) > 0:
+			w.Uint32(exchangeUsePNFSMDS)
+		default:
+			w.Uint32(0x00010000) // not pNFS
+		}
+		w.Uint32(0) // SP4_NONE
+		w.Uint64(0)
+		w.String("fake")
+		w.String("fake")
+		w.Uint32(0)
+	case opCreateSession:
+		clientID := r.Uint64()
+		r.Uint32() // sequence ID
+		r.Uint32() // flags
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 6; j++ {
+				r.Uint32() // channel attributes
+			}
+			if r.Uint32() != 0 {
+				r.Uint32() // RDMA
+			}
+		}
+		r.Uint32()      // callback program
+		n := r.Uint32() // callback security
+		for i := uint32(0); i < n; i++ {
+			if r.Uint32() != authNone {
+				return fail(10004)
+			}
+		}
+		id := make([]byte, sessionIDLen)
+		binary.BigEndian.PutUint64(id, clientID)
+		const slots = 8
+		srv.mu.Lock()
+		srv.v4.sessions[string(id)] = make([]uint32, slots)
+		srv.mu.Unlock()
+		w.Uint32(uint32(nfsOK))
+		w.Fixed(id)
+		w.Uint32(1) // sequence ID
+		w.Uint32(0) // flags
+		for _, x := range []uint32{0, 2 << 20, 2 << 20, 4096, 16, slots, 0, 0, 4096, 4096, 0, 2, 1, 0} {
+			w.Uint32(x)
+		}
+	case opDestroySession:
+		id := r.Fixed(sessionIDLen)
+		srv.mu.Lock()
+		_, ok := srv.v4.sessions[string(id)]
+		delete(srv.v4.sessions, string(id))
+		srv.mu.Unlock()
+		if !ok {
+			return fail(nfs4ErrBadSession)
+		}
+		w.Uint32(uint32(nfsOK))
+	case opDestroyClientID:
+		r.Uint64()
+		w.Uint32(uint32(nfsOK))
+	case opSequence:
+		id := r.Fixed(sessionIDLen)
+		seq := r.Uint32()
+		slot := r.Uint32()
+		highest := r.Uint32()
+		r.Bool() // cache this
+		srv.mu.Lock()
+		slots, ok := srv.v4.sessions[string(id)]
+		var status nfsStatus
+		switch {
+		case !ok:
+			status = nfs4ErrBadSession
+		case slot >= uint32(len(slots)):
+			status = 10053 // bad slot
+		case seq == slots[slot]:
+			status = nfs4ErrRetryUncachedRep
+		case seq != slots[slot]+1:
+			status = nfs4ErrSeqMisordered
+		default:
+			slots[slot] = seq
+		}
+		srv.mu.Unlock()
+		if status != nfsOK {
+			return fail(status)
+		}
+		w.Uint32(uint32(nfsOK))
+		w.Fixed(id)
+		w.Uint32(seq)
+		w.Uint32(slot)
+		w.Uint32(highest)
+		w.Uint32(uint32(len(slots) - 1))
+		w.Uint32(0) // status flags
+	case opReclaimComplete:
+		r.Bool()
+		w.Uint32(uint32(nfsOK))
+	case opPutRootFH:
+		*current = pseudoRoot
+		w.Uint32(uint32(nfsOK))
+	case opPutFH:
+		p, ok := srv.path(r.Opaque(maxHandle4))
+		if !ok {
+			return fail(nfsErrStale)
+		}
+		*current = p
+		w.Uint32(uint32(nfsOK))
+	case opGetFH:
+		if *current == pseudoRoot {
+			return fail(10001) // bad handle
+		}
+		w.Uint32(uint32(nfsOK))
+		w.Opaque(srv.handle(*current))
+	case opSaveFH:
+		*saved = *current
+		w.Uint32(uint32(nfsOK))
+	case opLookup:
+		name := r.String(maxName)
+		if *current == pseudoRoot {
+			if name != strings.Trim(testExport, "/") {
+				return fail(nfsErrNoEnt)
+			}
+			*current = ""
+			w.Uint32(uint32(nfsOK))
+			return nfsOK
+		}
+		p := path.Join(*current, name)
+		if _, err := os.Lstat(srv.local(p)); !status(err) {
+			return toStatus(err)
+		}
+		*current = p
+	case opGetAttr:
+		attr, err := srv.attr4(*current, r.Bitmap())
+		if !status(err) {
+			return toStatus(err)
+		}
+		w.Fixed(attr)
+	case opSetAttr:
+		readStateID(r)
+		s := readSetAttr4(r)
+		var err error
+		if s.mode != nil {
+			err = os.Chmod(srv.local(*current), os.FileMode(*s.mode))
+		}
+		if s.size != nil && err == nil {
+			err = os.Truncate(srv.local(*current), int64(*s.size))
+		}
+		if !s.modTime.IsZero() && err == nil {
+			err = os.Chtimes(srv.local(*current), s.modTime, s.modTime)
+		}
+		status(err)
+		w.Bitmap(nil) // attributes set
+		return toStatus(err)
+	case opOpen:
+		r.Uint32() // sequence ID
+		access := r.Uint32()
+		r.Uint32() // deny
+		r.Uint64() // client ID
+		r.Opaque(maxOpaque)
+		var s setAttr
+		create := r.Uint32() == openCreate
+		if create {
+			if r.Uint32() != createUnchecked {
+				return fail(10004)
+			}
+			s = readSetAttr4(r)
+		}
+		p := *current
+		switch r.Uint32() {
+		case claimNull:
+			p = path.Join(p, r.String(maxName))
+		case claimFH:
+		default:
+			return fail(10004)
+		}
+		if access&shareWantNoDeleg == 0 {
+			return fail(22)
+		}
+		flags := os.O_RDONLY
+		if access&shareAccessWrite != 0 {
+			flags = os.O_WRONLY
+		}
+		if create {
+			flags |= os.O_CREATE
+			if s.size != nil && *s.size == 0 {
+				flags |= os.O_TRUNC
+			}
+		}
+		fi, err := os.Lstat(srv.local(p))
+		if err == nil && fi.IsDir() {
+			return fail(nfsErrIsDir)
+		}
+		fd, err := os.OpenFile(srv.local(p), flags, 0644)
+		if !status(err) {
+			return toStatus(err)
+		}
+		_ = fd.Close()
+		state := srv.newState()
+		srv.mu.Lock()
+		srv.v4.opens[string(state.other)] = p
+		srv.mu.Unlock()
+		*current = p
+		writeStateID(w, state)
+		w.Bool(false) // change info
+		w.Uint64(0)
+		w.Uint64(0)
+		w.Uint32(0)   // result flags
+		w.Bitmap(nil) // attributes set
+		w.Uint32(delegateNone)
+	case opClose:
+		r.Uint32() // sequence ID
+		state := readStateID(r)
+		srv.mu.Lock()
+		_, ok := srv.v4.opens[string(state.other)]
+		delete(srv.v4.opens, string(state.other))
+		srv.mu.Unlock()
+		if !ok {
+			return fail(10025) // bad stateid
+		}
+		w.Uint32(uint32(nfsOK))
+		state.seq++
+		writeStateID(w, state)
+	case opRead:
+		state := readStateID(r)
+		offset := r.Uint64()
+		count := r.Uint32()
+		if !bytes.Equal(state.other, anonymousStateID.other) && srv.openPath(state) != *current {
+			return fail(10025)
+		}
+		if count > srv.readMax {
+			count = srv.readMax
+		}
+		if isDS {
+			mds := &srv.v4.mds.v4
+			n := atomic.AddInt32(&mds.inFlight, 1)
+			for {
+				max := atomic.LoadInt32(&mds.maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&mds.maxInFlight, max, n) {
+					break
+				}
+			}
+			defer atomic.AddInt32(&mds.inFlight, -1)
+		}
+		srv.countRead()
+		if isDS {
+			if atomic.LoadInt32(&srv.v4.failReads) != 0 {
+				return fail(5)
+			}
+			// convert the offset in the dense layout on this data
+			// server to the offset in the file
+			stripes := uint64(len(srv.v4.mds.v4.dataServers))
+			stripe := (uint64(srv.v4.index) + stripes - testFirstStripe) % stripes
+			unit := offset / testStripeUnit
+			offset = (unit*stripes+stripe)*testStripeUnit + offset%testStripeUnit
+		}
+		fd, err := os.Open(srv.local(*current))
+		if !status(err) {
+			return toStatus(err)
+		}
+		defer func() {
+			_ = fd.Close()
+		}()
+		buf := make([]byte, count)
+		n, err := fd.ReadAt(buf, int64(offset))
+		w.Bool(err == io.EOF)
+		w.Opaque(buf[:n])
+	case opWrite:
+		state := readStateID(r)
+		offset := r.Uint64()
+		r.Uint32() // stable
+		data := r.Opaque(1 << 20)
+		if srv.openPath(state) != *current {
+			return fail(10025)
+		}
+		fd, err := os.OpenFile(srv.local(*current), os.O_WRONLY, 0)
+		if err == nil {
+			_, err = fd.WriteAt(data, int64(offset))
+			_ = fd.Close()
+		}
+		if !status(err) {
+			return toStatus(err)
+		}
+		w.Uint32(uint32(len(data)))
+		w.Uint32(writeUnstable)
+		w.Fixed([]byte(testWriteVerf))
+	case opCommit:
+		r.Uint64()
+		r.Uint32()
+		w.Uint32(uint32(nfsOK))
+		w.Fixed([]byte(testWriteVerf))
+	case opCreate:
+		if r.Uint32() != typeDir {
+			return fail(10007) // bad type
+		}
+		p := path.Join(*current, r.String(maxName))
+		readSetAttr4(r)
+		err := os.Mkdir(srv.local(p), 0755)
+		if !status(err) {
+			return toStatus(err)
+		}
+		*current = p
+		w.Bool(false) // change info
+		w.Uint64(0)
+		w.Uint64(0)
+		w.Bitmap(nil) // attributes set
+	case opRemove:
+		p := path.Join(*current, r.String(maxName))
+		err := os.Remove(srv.local(p))
+		if !status(err) {
+			return toStatus(err)
+		}
+		srv.forget(p)
+		w.Bool(false) // change info
+		w.Uint64(0)
+		w.Uint64(0)
+	case opRename:
+		from := path.Join(*saved, r.String(maxName))
+		to := path.Join(*current, r.String(maxName))
+		err := os.Rename(srv.local(from), srv.local(to))
+		if !status(err) {
+			return toStatus(err)
+		}
+		srv.renamed(from, to)
+		for i := 0; i < 2; i++ {
+			w.Bool(false) // change info
+			w.Uint64(0)
+			w.Uint64(0)
+		}
+	case opReadDir:
+		cookie := r.Uint64()
+		r.Fixed(verfLength)
+		r.Uint32() // dircount
+		r.Uint32() // maxcount
+		bitmap := r.Bitmap()
+		infos, err := ioutil.ReadDir(srv.local(*current))
+		if err != nil {
+			return fail(toStatus(err))
+		}
+		w.Uint32(uint32(nfsOK))
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		w.Fixed(make([]byte, verfLength))
+		// return a few entries at a time to exercise the cookies
+		// which start at 3 as 1 and 2 are reserved
+		const perCall = 3
+		i := 0
+		if cookie != 0 {
+			i = int(cookie) - 2
+		}
+		end := i + perCall
+		for ; i < len(infos) && i < end; i++ {
+			attr, err := srv.attr4(path.Join(*current, infos[i].Name()), bitmap)
+			if err != nil {
+				continue
+			}
+			w.Bool(true)
+			w.Uint64(uint64(i + 3))
+			w.String(infos[i].Name())
+			w.Fixed(attr)
+		}
+		w.Bool(false) // no more entries
+		w.Bool(i >= len(infos))
+	case opLayoutGet:
+		r.Bool() // signal
+		layoutType := r.Uint32()
+		r.Uint32() // iomode
+		r.Uint64() // offset
+		r.Uint64() // length
+		r.Uint64() // minimum length
+		state := readStateID(r)
+		r.Uint32() // maximum size
+		if len(srv.v4.dataServers) == 0 || layoutType != layoutNFSv41Files {
+			return fail(10062) // unknown layout type
+		}
+		if srv.openPath(state) != *current {
+			return fail(10025)
+		}
+		atomic.AddInt32(&srv.v4.layoutGets, 1)
+		var body xdr.Writer
+		body.Fixed([]byte("fake device id!!"))
+		body.Uint32(testStripeUnit | fileLayoutDense)
+		body.Uint32(testFirstStripe)
+		body.Uint64(0) // pattern offset
+		body.Uint32(1) // one file handle for all
+		body.Opaque(srv.handle(*current))
+		w.Uint32(uint32(nfsOK))
+		w.Bool(false) // return on close
+		writeStateID(w, srv.newState())
+		w.Uint32(1) // layouts
+		w.Uint64(0)
+		w.Uint64(^uint64(0))
+		w.Uint32(layoutIOModeRead)
+		w.Uint32(layoutNFSv41Files)
+		w.Opaque(body.Bytes())
+	case opGetDeviceInfo:
+		r.Fixed(deviceIDLen)
+		r.Uint32() // layout type
+		r.Uint32() // maximum size
+		r.Bitmap() // notifications
+		var body xdr.Writer
+		body.Uint32(uint32(len(srv.v4.dataServers)))
+		for i := range srv.v4.dataServers {
+			body.Uint32(uint32(i))
+		}
+		body.Uint32(uint32(len(srv.v4.dataServers)))
+		for _, ds := range srv.v4.dataServers {
+			port, _ := strconv.Atoi(ds.port())
+			body.Uint32(1)
+			body.String("tcp")
+			body.String(fmt.Sprintf("127.0.0.1.%d.%d", port>>8, port&0xff))
+		}
+		w.Uint32(uint32(nfsOK))
+		w.Uint32(layoutNFSv41Files)
+		w.Opaque(body.Bytes())
+		w.Bitmap(nil)
+	case opLayoutReturn:
+		r.Bool()   // reclaim
+		r.Uint32() // layout type
+		r.Uint32() // iomode
+		if r.Uint32() == layoutReturnFile {
+			r.Uint64()
+			r.Uint64()
+			readStateID(r)
+			r.Opaque(maxOpaque)
+		}
+		atomic.AddInt32(&srv.v4.layoutReturns, 1)
+		w.Uint32(uint32(nfsOK))
+		w.Bool(false) // no stateid
+	default:
+		return fail(10044) // illegal operation
+	}
+	return nfsOK
+}
+
+// prepare4 makes a fake server with dataServers data servers serving
+// a temporary directory and an Fs using it with NFS version 4.1
+func prepare4(t *testing.T, dataServers int) (f *Fs, srv *fakeServer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-nfs-test")
+	require.NoError(t, err)
+	srv = newFakeServer4(t, dir, dataServers)
+	fsys, err := NewFs(context.Background(), "TestNFS4", "", srv.config4())
+	if err != nil {
+		srv.close4()
+		_ = os.RemoveAll(dir)
+		require.NoError(t, err)
+	}
+	return fsys.(*Fs), srv, func() {
+		_ = fsys.(*Fs).Shutdown(context.Background())
+		srv.close4()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestParseUniversalAddr(t *testing.T) {
+	for _, test := range []struct {
+		netID string
+		uaddr string
+		want  string
+		err   bool
+	}{
+		{"tcp", "192.168.1.2.8.1", "192.168.1.2:2049", false},
+		{"tcp6", "fe80::1.8.1", "[fe80::1]:2049", false},
+		{"tcp", "host.0.111", "host:111", false},
+		{"udp", "192.168.1.2.8.1", "", true},
+		{"tcp", "192", "", true},
+		{"tcp", "1.2.3.4.256.1", "", true},
+	} {
+		got, err := parseUniversalAddr(test.netID, test.uaddr)
+		if test.err {
+			assert.Error(t, err, test.uaddr)
+		} else {
+			assert.NoError(t, err, test.uaddr)
+			assert.Equal(t, test.want, got, test.uaddr)
+		}
+	}
+}
+
+func TestFileLayoutLocate(t *testing.T) {
+	ds := []*client4{{name: "ds0"}, {name: "ds1"}, {name: "ds2"}}
+	l := &fileLayout{
+		device: &device{
+			stripeIndices: []uint32{2, 0, 1},
+			dataServers:   ds,
+		},
+		unit:          100,
+		firstStripe:   1,
+		patternOffset: 50,
+		handles:       [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+	}
+	for _, dense := range []bool{true, false} {
+		l.dense = dense
+		for _, test := range []struct {
+			offset    uint64
+			ds        string
+			handle    string
+			denseOff  uint64
+			remaining uint64
+		}{
+			{50, "ds0", "b", 0, 100},
+			{149, "ds0", "b", 99, 1},
+			{150, "ds1", "c", 0, 100},
+			{250, "ds2", "a", 0, 100},
+			{375, "ds0", "b", 125, 75},
+			{650, "ds0", "b", 200, 100},
+		} {
+			gotDS, handle, dsOffset, remaining := l.locate(test.offset)
+			assert.Equal(t, test.ds, gotDS.name, test.offset)
+			assert.Equal(t, test.handle, string(handle), test.offset)
+			if dense {
+				assert.Equal(t, test.denseOff, dsOffset, test.offset)
+			} else {
+				assert.Equal(t, test.offset, dsOffset, test.offset)
+			}
+			assert.Equal(t, test.remaining, remaining, test.offset)
+		}
+	}
+	// one file handle is used for all the data servers
+	l.handles = l.handles[:1]
+	_, handle, _, _ := l.locate(250)
+	assert.Equal(t, "a", string(handle))
+}
+
+func TestNFS4MountFailure(t *testing.T) {
+	_, srv, cleanup := prepare4(t, 0)
+	defer cleanup()
+	m := srv.config4()
+	m.Set("export", "/potato")
+	_, err := NewFs(context.Background(), "TestNFS4", "", m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such file or directory")
+
+	m.Set("version", "2")
+	_, err = NewFs(context.Background(), "TestNFS4", "", m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "version must be 3 or 4.1")
+}
+
+func TestNFS4List(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare4(t, 0)
+	defer cleanup()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, fmt.Sprintf("file%d", i)), []byte("hello"), 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(srv.root, "dir"), 0755))
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	assert.Equal(t, []string{"dir", "file0", "file1", "file2", "file3", "file4", "file5", "file6"}, names)
+	assert.Equal(t, int64(5), entries[1].Size())
+
+	_, err = f.List(ctx, "potato")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
+
+func TestNFS4SessionLost(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare4(t, 0)
+	defer cleanup()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, "file"), []byte("hello"), 0644))
+
+	// a new session is made when the server loses the old one
+	srv.dropSessions()
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+
+	// even with a file open
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	srv.dropSessions()
+	got, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	require.NoError(t, in.Close())
+	assert.Equal(t, 0, srv.openFiles())
+}
+
+func TestNFS4About(t *testing.T) {
+	f, _, cleanup := prepare4(t, 0)
+	defer cleanup()
+	usage, err := f.About(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), *usage.Total)
+	assert.Equal(t, int64(1<<29), *usage.Used)
+	assert.Equal(t, int64(1<<28), *usage.Free)
+}
+
+func TestPNFSParallelRead(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare4(t, 3)
+	defer cleanup()
+	data := make([]byte, 1<<20+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, "file"), data, 0644))
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	for _, ds := range srv.v4.dataServers {
+		ds.readDelay = 10 * time.Millisecond
+	}
+	opens := 0
+	for _, test := range []struct {
+		options []fs.OpenOption
+		want    []byte
+	}{
+		{nil, data},
+		{[]fs.OpenOption{&fs.SeekOption{Offset: 100000}}, data[100000:]},
+		{[]fs.OpenOption{&fs.RangeOption{Start: 5, End: 70000}}, data[5:70001]},
+		{[]fs.OpenOption{&fs.RangeOption{Start: -1, End: 20}}, data[len(data)-20:]},
+	} {
+		in, err := o.Open(ctx, test.options...)
+		require.NoError(t, err)
+		opens++
+		got, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.True(t, bytes.Equal(test.want, got), fmt.Sprintf("%v: got %d bytes want %d", test.options, len(got), len(test.want)))
+	}
+
+	// all the data came from the data servers in parallel
+	assert.Equal(t, int32(0), atomic.LoadInt32(&srv.reads))
+	for i, ds := range srv.v4.dataServers {
+		assert.True(t, atomic.LoadInt32(&ds.reads) > 0, "data server %d not used", i)
+	}
+	assert.True(t, atomic.LoadInt32(&srv.v4.maxInFlight) > 1, "expecting parallel reads")
+	assert.True(t, atomic.LoadInt32(&srv.v4.maxInFlight) <= 4, "expecting at most parallel_reads reads")
+	assert.Equal(t, int32(opens), atomic.LoadInt32(&srv.v4.layoutGets))
+	assert.Equal(t, int32(opens), atomic.LoadInt32(&srv.v4.layoutReturns))
+	assert.Equal(t, 0, srv.openFiles())
+
+	// reads fall back to the server if a data server fails
+	atomic.StoreInt32(&srv.v4.dataServers[1].v4.failReads, 1)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.True(t, bytes.Equal(data, got))
+	assert.True(t, atomic.LoadInt32(&srv.reads) > 0, "expecting reads from the server")
+}
+
+func TestPNFSWrite(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare4(t, 2)
+	defer cleanup()
+
+	// files are written through the server
+	data := bytes.Repeat([]byte("potato"), 100000)
+	modTime := time.Unix(1600000000, 0)
+	src := object.NewStaticObjectInfo("dir/file", modTime, int64(len(data)), true, nil, nil)
+	o, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	got, err := ioutil.ReadFile(filepath.Join(srv.root, "dir", "file"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+	assert.True(t, modTime.Equal(o.ModTime(ctx)))
+	assert.Equal(t, 0, srv.openFiles())
+}
+
+// TestIntegrationFake4 runs the integration tests against the fake
+// server with NFS version 4.1 and pNFS
+func TestIntegrationFake4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-nfs-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	srv := newFakeServer4(t, dir, 2)
+	defer srv.close4()
+	for key, value := range srv.config4() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestNFSFake4", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestNFSFake4", "type"), "nfs"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestNFSFake4:",
+		NilObject:  (*Object)(nil),
+	})
+}
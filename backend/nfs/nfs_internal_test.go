@@ -0,0 +1,685 @@
+// +build !plan9
+
+package nfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testExport    = "/export"
+	testWriteVerf = "rclone!!"
+)
+
+func TestNFSStatus(t *testing.T) {
+	assert.Equal(t, "nfs: no such file or directory", nfsErrNoEnt.Error())
+	assert.Equal(t, "nfs: error 12345", nfsStatus(12345).Error())
+	retry, _ := shouldRetry(nfsErrJukebox)
+	assert.True(t, retry)
+	retry, _ = shouldRetry(nfsErrNoEnt)
+	assert.False(t, retry)
+}
+
+// fakeServer is a minimal NFSv3 and MOUNT server serving a local
+// directory
+//
+// File handles are 8 byte IDs which are kept over renames like a real
+// server does.
+type fakeServer struct {
+	root     string
+	listener net.Listener
+	readMax  uint32 // largest READ to return
+
+	mu     sync.Mutex
+	paths  map[uint64]string // ID to path relative to root
+	ids    map[string]uint64 // path to ID
+	nextID uint64
+
+	reads       int32 // number of READ calls
+	inFlight    int32 // READs in progress
+	maxInFlight int32 // most READs seen in progress at once
+	readDelay   time.Duration
+
+	v4 fakeServer4 // NFS version 4.1 state
+}
+
+func newFakeServer(t *testing.T, root string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &fakeServer{
+		root:     root,
+		listener: listener,
+		readMax:  1 << 20,
+		paths:    map[uint64]string{1: ""},
+		ids:      map[string]uint64{"": 1},
+		nextID:   2,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+// close the server
+func (srv *fakeServer) close() {
+	_ = srv.listener.Close()
+}
+
+// port returns the port the server is listening on
+func (srv *fakeServer) port() string {
+	return strconv.Itoa(srv.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// config returns the config for a remote using the server
+func (srv *fakeServer) config() configmap.Simple {
+	return configmap.Simple{
+		"host":           "127.0.0.1",
+		"export":         testExport,
+		"version":        "3",
+		"port":           srv.port(),
+		"mount_port":     srv.port(),
+		"parallel_reads": "4",
+	}
+}
+
+// handle returns the file handle for p
+func (srv *fakeServer) handle(p string) []byte {
+	if srv.v4.mds != nil {
+		return srv.v4.mds.handle(p)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	id, ok := srv.ids[p]
+	if !ok {
+		id = srv.nextID
+		srv.nextID++
+		srv.ids[p] = id
+		srv.paths[id] = p
+	}
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, id)
+	return handle
+}
+
+// path returns the path for the file handle
+func (srv *fakeServer) path(handle []byte) (string, bool) {
+	if len(handle) != 8 {
+		return "", false
+	}
+	if srv.v4.mds != nil {
+		return srv.v4.mds.path(handle)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	p, ok := srv.paths[binary.BigEndian.Uint64(handle)]
+	return p, ok
+}
+
+// forget removes p from the handle maps
+func (srv *fakeServer) forget(p string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if id, ok := srv.ids[p]; ok {
+		delete(srv.ids, p)
+		delete(srv.paths, id)
+	}
+}
+
+// renamed updates the handle maps after from is renamed to to
+func (srv *fakeServer) renamed(from, to string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if id, ok := srv.ids[to]; ok {
+		delete(srv.ids, to)
+		delete(srv.paths, id)
+	}
+	for p, id := range srv.ids {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			newPath := to + p[len(from):]
+			delete(srv.ids, p)
+			srv.ids[newPath] = id
+			srv.paths[id] = newPath
+		}
+	}
+}
+
+// local returns the local path of p
+func (srv *fakeServer) local(p string) string {
+	return filepath.Join(srv.root, filepath.FromSlash(p))
+}
+
+// serve the RPC calls on conn
+func (srv *fakeServer) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	in := &rpcClient{in: bufio.NewReader(conn)}
+	for {
+		record, err := in.readRecord()
+		if err != nil {
+			return
+		}
+		r := xdr.NewReader(record)
+		xid := r.Uint32()
+		r.Uint32() // msgCall
+		r.Uint32() // rpc version
+		prog := r.Uint32()
+		vers := r.Uint32()
+		proc := r.Uint32()
+		r.Uint32() // cred flavor
+		r.Opaque(400)
+		r.Uint32() // verf flavor
+		r.Opaque(400)
+		if r.Err() != nil {
+			return
+		}
+		var w xdr.Writer
+		w.Uint32(0) // record marker
+		w.Uint32(xid)
+		w.Uint32(msgReply)
+		w.Uint32(replyAccepted)
+		w.Uint32(authNone)
+		w.Opaque(nil)
+		switch prog {
+		case mountProgram:
+			w.Uint32(acceptSuccess)
+			srv.mount(proc, r, &w)
+		case nfsProgram:
+			w.Uint32(acceptSuccess)
+			if vers == nfs4Version {
+				srv.compound(r, &w)
+			} else {
+				srv.nfs(proc, r, &w)
+			}
+		default:
+			w.Uint32(1) // program unavailable
+		}
+		binary.BigEndian.PutUint32(w.Bytes(), lastFragment|uint32(w.Len()-4))
+		if _, err = conn.Write(w.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// mount serves the MOUNT program
+func (srv *fakeServer) mount(proc uint32, r *xdr.Reader, w *xdr.Writer) {
+	switch proc {
+	case mountProcMnt:
+		if r.String(maxPath) != testExport {
+			w.Uint32(uint32(nfsErrNoEnt))
+			return
+		}
+		w.Uint32(0)
+		w.Opaque(srv.handle(""))
+		w.Uint32(1) // auth flavors
+		w.Uint32(authSys)
+	}
+}
+
+// toStatus converts a local error into an NFS status
+func toStatus(err error) nfsStatus {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if linkErr, ok := err.(*os.LinkError); ok {
+		err = linkErr.Err
+	}
+	switch err {
+	case nil:
+		return nfsOK
+	case syscall.ENOENT:
+		return nfsErrNoEnt
+	case syscall.EEXIST:
+		return nfsErrExist
+	case syscall.ENOTDIR:
+		return nfsErrNotDir
+	case syscall.EISDIR:
+		return nfsErrIsDir
+	case syscall.ENOTEMPTY:
+		return nfsErrNotEmpty
+	}
+	return 5 // I/O error
+}
+
+// writeAttr writes the fattr3 for fi
+func writeAttr(w *xdr.Writer, id []byte, fi os.FileInfo) {
+	if fi.IsDir() {
+		w.Uint32(typeDir)
+	} else if fi.Mode().IsRegular() {
+		w.Uint32(typeRegular)
+	} else {
+		w.Uint32(5) // symlink
+	}
+	w.Uint32(uint32(fi.Mode().Perm()))
+	w.Uint32(1) // nlink
+	w.Uint32(0) // uid
+	w.Uint32(0) // gid
+	w.Uint64(uint64(fi.Size()))
+	w.Uint64(uint64(fi.Size()))
+	w.Uint64(0) // rdev
+	w.Uint64(0) // fsid
+	w.Fixed(id) // fileid
+	writeTime(w, fi.ModTime())
+	writeTime(w, fi.ModTime())
+	writeTime(w, fi.ModTime())
+}
+
+// writePostOpAttr writes a post_op_attr for p
+func (srv *fakeServer) writePostOpAttr(w *xdr.Writer, p string) {
+	fi, err := os.Lstat(srv.local(p))
+	w.Bool(err == nil)
+	if err == nil {
+		writeAttr(w, srv.handle(p), fi)
+	}
+}
+
+// writeNewHandle writes the result of CREATE and MKDIR
+func (srv *fakeServer) writeNewHandle(w *xdr.Writer, p string) {
+	w.Uint32(uint32(nfsOK))
+	w.Bool(true)
+	w.Opaque(srv.handle(p))
+	srv.writePostOpAttr(w, p)
+	w.Bool(false) // wcc before
+	w.Bool(false) // wcc after
+}
+
+// readSetAttr reads a sattr3
+func readSetAttr(r *xdr.Reader) (s setAttr) {
+	if r.Bool() {
+		mode := r.Uint32()
+		s.mode = &mode
+	}
+	if r.Bool() {
+		r.Uint32() // uid
+	}
+	if r.Bool() {
+		r.Uint32() // gid
+	}
+	if r.Bool() {
+		size := r.Uint64()
+		s.size = &size
+	}
+	if r.Uint32() == timeSetClient {
+		readTime(r) // atime
+	}
+	if r.Uint32() == timeSetClient {
+		s.modTime = readTime(r)
+	}
+	return s
+}
+
+// countRead counts a READ and waits for readDelay
+func (srv *fakeServer) countRead() {
+	atomic.AddInt32(&srv.reads, 1)
+	n := atomic.AddInt32(&srv.inFlight, 1)
+	for {
+		max := atomic.LoadInt32(&srv.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&srv.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(srv.readDelay)
+	atomic.AddInt32(&srv.inFlight, -1)
+}
+
+// nfs serves the NFS program
+func (srv *fakeServer) nfs(proc uint32, r *xdr.Reader, w *xdr.Writer) {
+	p, ok := srv.path(r.Opaque(maxHandle))
+	if !ok {
+		w.Uint32(uint32(nfsErrStale))
+		return
+	}
+	status := func(err error) bool {
+		w.Uint32(uint32(toStatus(err)))
+		return err == nil
+	}
+	switch proc {
+	case procGetAttr:
+		fi, err := os.Lstat(srv.local(p))
+		if status(err) {
+			writeAttr(w, srv.handle(p), fi)
+		}
+	case procSetAttr:
+		s := readSetAttr(r)
+		var err error
+		if s.mode != nil {
+			err = os.Chmod(srv.local(p), os.FileMode(*s.mode))
+		}
+		if s.size != nil && err == nil {
+			err = os.Truncate(srv.local(p), int64(*s.size))
+		}
+		if !s.modTime.IsZero() && err == nil {
+			err = os.Chtimes(srv.local(p), s.modTime, s.modTime)
+		}
+		if status(err) {
+			w.Bool(false)
+			w.Bool(false)
+		}
+	case procLookup:
+		p = path.Join(p, r.String(maxName))
+		fi, err := os.Lstat(srv.local(p))
+		if status(err) {
+			w.Opaque(srv.handle(p))
+			w.Bool(true)
+			writeAttr(w, srv.handle(p), fi)
+			w.Bool(false) // dir attributes
+		}
+	case procRead:
+		offset := r.Uint64()
+		count := r.Uint32()
+		if count > srv.readMax {
+			count = srv.readMax
+		}
+		srv.countRead()
+		fd, err := os.Open(srv.local(p))
+		if !status(err) {
+			return
+		}
+		defer func() {
+			_ = fd.Close()
+		}()
+		buf := make([]byte, count)
+		n2, err := fd.ReadAt(buf, int64(offset))
+		w.Bool(false) // attributes
+		w.Uint32(uint32(n2))
+		w.Bool(err == io.EOF)
+		w.Opaque(buf[:n2])
+	case procWrite:
+		offset := r.Uint64()
+		r.Uint32() // count
+		r.Uint32() // stable
+		data := r.Opaque(1 << 20)
+		fd, err := os.OpenFile(srv.local(p), os.O_WRONLY, 0)
+		if err == nil {
+			_, err = fd.WriteAt(data, int64(offset))
+			_ = fd.Close()
+		}
+		if status(err) {
+			w.Bool(false)
+			w.Bool(false)
+			w.Uint32(uint32(len(data)))
+			w.Uint32(writeUnstable)
+			w.Fixed([]byte(testWriteVerf))
+		}
+	case procCommit:
+		w.Uint32(uint32(nfsOK))
+		w.Bool(false)
+		w.Bool(false)
+		w.Fixed([]byte(testWriteVerf))
+	case procCreate:
+		p = path.Join(p, r.String(maxName))
+		how := r.Uint32()
+		s := readSetAttr(r)
+		flags := os.O_WRONLY | os.O_CREATE
+		if how == createGuarded {
+			flags |= os.O_EXCL
+		}
+		if s.size != nil && *s.size == 0 {
+			flags |= os.O_TRUNC
+		}
+		fd, err := os.OpenFile(srv.local(p), flags, 0644)
+		if err == nil {
+			_ = fd.Close()
+			srv.writeNewHandle(w, p)
+		} else {
+			status(err)
+		}
+	case procMkdir:
+		p = path.Join(p, r.String(maxName))
+		err := os.Mkdir(srv.local(p), 0755)
+		if err == nil {
+			srv.writeNewHandle(w, p)
+		} else {
+			status(err)
+		}
+	case procRemove, procRmdir:
+		p = path.Join(p, r.String(maxName))
+		fi, err := os.Lstat(srv.local(p))
+		if err == nil {
+			if proc == procRmdir && !fi.IsDir() {
+				err = syscall.ENOTDIR
+			} else if proc == procRemove && fi.IsDir() {
+				err = syscall.EISDIR
+			} else {
+				err = os.Remove(srv.local(p))
+			}
+		}
+		if status(err) {
+			srv.forget(p)
+			w.Bool(false)
+			w.Bool(false)
+		}
+	case procRename:
+		from := path.Join(p, r.String(maxName))
+		toDir, ok := srv.path(r.Opaque(maxHandle))
+		if !ok {
+			w.Uint32(uint32(nfsErrStale))
+			return
+		}
+		to := path.Join(toDir, r.String(maxName))
+		err := os.Rename(srv.local(from), srv.local(to))
+		if status(err) {
+			srv.renamed(from, to)
+			for i := 0; i < 4; i++ {
+				w.Bool(false)
+			}
+		}
+	case procReadDirPlus:
+		cookie := r.Uint64()
+		infos, err := ioutil.ReadDir(srv.local(p))
+		if !status(err) {
+			return
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		names := []string{"."}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		w.Bool(false) // dir attributes
+		w.Fixed(make([]byte, verfLength))
+		// return a few entries at a time to exercise the cookies
+		const perCall = 3
+		i := int(cookie)
+		for ; i < len(names) && i < int(cookie)+perCall; i++ {
+			entry := path.Join(p, names[i])
+			w.Bool(true)
+			w.Fixed(srv.handle(entry)) // fileid
+			w.String(names[i])
+			w.Uint64(uint64(i + 1))
+			// leave out every other handle to make the client look it up
+			if i%2 == 0 {
+				srv.writePostOpAttr(w, entry)
+				w.Bool(true)
+				w.Opaque(srv.handle(entry))
+			} else {
+				w.Bool(false)
+				w.Bool(false)
+			}
+		}
+		w.Bool(false) // no more entries
+		w.Bool(i >= len(names))
+	case procFSStat:
+		w.Uint32(uint32(nfsOK))
+		w.Bool(false)
+		w.Uint64(1 << 30) // total bytes
+		w.Uint64(1 << 29) // free bytes
+		w.Uint64(1 << 28) // available bytes
+		w.Uint64(1000)    // total files
+		w.Uint64(500)     // free files
+		w.Uint64(500)     // available files
+		w.Uint32(0)       // invarsec
+	case procFSInfo:
+		w.Uint32(uint32(nfsOK))
+		w.Bool(false)
+		w.Uint32(1 << 20)  // rtmax
+		w.Uint32(64 << 10) // rtpref
+		w.Uint32(4096)     // rtmult
+		w.Uint32(1 << 20)  // wtmax
+		w.Uint32(64 << 10) // wtpref
+		w.Uint32(4096)     // wtmult
+		w.Uint32(8192)     // dtpref
+		w.Uint64(1 << 62)  // maxfilesize
+		w.Uint32(0)        // time delta seconds
+		w.Uint32(1)        // time delta nanoseconds
+		w.Uint32(0)        // properties
+	default:
+		w.Uint32(10004) // not supported
+	}
+}
+
+// prepare makes a fake server serving a temporary directory and an
+// Fs using it
+func prepare(t *testing.T) (f *Fs, srv *fakeServer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-nfs-test")
+	require.NoError(t, err)
+	srv = newFakeServer(t, dir)
+	fsys, err := NewFs(context.Background(), "TestNFS", "", srv.config())
+	if err != nil {
+		srv.close()
+		_ = os.RemoveAll(dir)
+		require.NoError(t, err)
+	}
+	return fsys.(*Fs), srv, func() {
+		_ = fsys.(*Fs).Shutdown(context.Background())
+		srv.close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestMountFailure(t *testing.T) {
+	_, srv, cleanup := prepare(t)
+	defer cleanup()
+	m := srv.config()
+	m.Set("export", "/potato")
+	_, err := NewFs(context.Background(), "TestNFS", "", m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such file or directory")
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare(t)
+	defer cleanup()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, fmt.Sprintf("file%d", i)), []byte("hello"), 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(srv.root, "dir"), 0755))
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	assert.Equal(t, []string{"dir", "file0", "file1", "file2", "file3", "file4", "file5", "file6"}, names)
+	assert.Equal(t, int64(5), entries[1].Size())
+
+	_, err = f.List(ctx, "potato")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
+
+func TestParallelRead(t *testing.T) {
+	ctx := context.Background()
+	f, srv, cleanup := prepare(t)
+	defer cleanup()
+	data := make([]byte, 1<<20+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srv.root, "file"), data, 0644))
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+
+	// short reads from the server must be retried
+	srv.readMax = 10000
+	srv.readDelay = 10 * time.Millisecond
+	for _, test := range []struct {
+		options []fs.OpenOption
+		want    []byte
+	}{
+		{nil, data},
+		{[]fs.OpenOption{&fs.SeekOption{Offset: 100000}}, data[100000:]},
+		{[]fs.OpenOption{&fs.RangeOption{Start: 5, End: 70000}}, data[5:70001]},
+		{[]fs.OpenOption{&fs.RangeOption{Start: -1, End: 20}}, data[len(data)-20:]},
+	} {
+		in, err := o.Open(ctx, test.options...)
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.True(t, bytes.Equal(test.want, got), fmt.Sprintf("%v: got %d bytes want %d", test.options, len(got), len(test.want)))
+	}
+	assert.True(t, atomic.LoadInt32(&srv.maxInFlight) > 1, "expecting parallel reads")
+	assert.True(t, atomic.LoadInt32(&srv.maxInFlight) <= 4, "expecting at most parallel_reads reads")
+
+	// closing early works
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(in, buf)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	_, err = in.Read(buf)
+	assert.Error(t, err)
+}
+
+func TestAbout(t *testing.T) {
+	f, _, cleanup := prepare(t)
+	defer cleanup()
+	usage, err := f.About(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), *usage.Total)
+	assert.Equal(t, int64(1<<29), *usage.Used)
+	assert.Equal(t, int64(1<<28), *usage.Free)
+}
+
+// TestIntegrationFake runs the integration tests against the fake
+// server
+func TestIntegrationFake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-nfs-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	srv := newFakeServer(t, dir)
+	defer srv.close()
+	for key, value := range srv.config() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestNFSFake", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestNFSFake", "type"), "nfs"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestNFSFake:",
+		NilObject:  (*Object)(nil),
+	})
+}
@@ -0,0 +1,379 @@
+// +build !plan9
+
+package nfs
+
+// This implements reading files from the data servers of pNFS servers
+// which give out NFS version 4.1 file layouts (RFC 8881 section 13).
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/xdr"
+)
+
+const (
+	layoutNFSv41Files = 1
+	layoutIOModeRead  = 1
+	layoutReturnFile  = 1
+
+	// file layout util flags and mask
+	fileLayoutDense    = 0x1
+	fileLayoutUnitMask = 0xFFFFFFC0
+
+	deviceIDLen   = 16
+	maxLayoutSize = 64 * 1024 // largest layout or device to ask for
+)
+
+// layout4 is a read layout of a whole file
+type layout4 struct {
+	state    stateID       // layout stateid
+	segments []*fileLayout // parts of the file with their own layouts
+}
+
+// fileLayout is a file layout of a part of a file
+type fileLayout struct {
+	offset        uint64 // offset in the file of the part
+	length        uint64 // length of the part, all ones for to the end of file
+	device        *device
+	dense         bool     // set if the data servers store only their stripe units
+	unit          uint64   // size of the stripe unit
+	firstStripe   uint32   // index into the stripe indices of the stripe unit at the pattern offset
+	patternOffset uint64   // offset in the file the striping starts at
+	handles       [][]byte // file handles on the data servers, one for all or one per stripe index
+}
+
+// device is a file layout device - the stripe indices and the data
+// servers they index
+type device struct {
+	stripeIndices []uint32
+	dataServers   []*client4
+}
+
+// find returns the part of the layout containing offset or nil if
+// the data must be read from the server
+func (l *layout4) find(offset uint64) *fileLayout {
+	if l == nil {
+		return nil
+	}
+	for _, segment := range l.segments {
+		if offset >= segment.offset && (segment.length == ^uint64(0) || offset-segment.offset < segment.length) {
+			return segment
+		}
+	}
+	return nil
+}
+
+// readSize returns size reduced to the smallest stripe unit so each
+// READ goes to one data server
+func (l *layout4) readSize(size uint32) uint32 {
+	if l == nil {
+		return size
+	}
+	for _, segment := range l.segments {
+		if segment.unit < uint64(size) {
+			size = uint32(segment.unit)
+		}
+	}
+	return size
+}
+
+// locate returns the data server and file handle holding offset, the
+// offset on the data server and the number of bytes to the end of the
+// stripe unit.
+func (l *fileLayout) locate(offset uint64) (ds *client4, handle []byte, dsOffset uint64, n uint64) {
+	rel := offset - l.patternOffset
+	stripeUnit := rel / l.unit
+	stripeCount := uint64(len(l.device.stripeIndices))
+	j := (stripeUnit + uint64(l.firstStripe)) % stripeCount
+	ds = l.device.dataServers[l.device.stripeIndices[j]]
+	if len(l.handles) == 1 {
+		handle = l.handles[0]
+	} else {
+		handle = l.handles[j]
+	}
+	if l.dense {
+		dsOffset = (stripeUnit/stripeCount)*l.unit + rel%l.unit
+	} else {
+		dsOffset = offset
+	}
+	return ds, handle, dsOffset, l.unit - rel%l.unit
+}
+
+// readFileLayout reads the body of a file layout
+func readFileLayout(r *xdr.Reader) (deviceID []byte, l *fileLayout) {
+	l = new(fileLayout)
+	deviceID = r.Fixed(deviceIDLen)
+	util := r.Uint32()
+	l.dense = util&fileLayoutDense != 0
+	l.unit = uint64(util & fileLayoutUnitMask)
+	l.firstStripe = r.Uint32()
+	l.patternOffset = r.Uint64()
+	n := r.Uint32()
+	for i := uint32(0); i < n && r.Err() == nil; i++ {
+		l.handles = append(l.handles, r.Opaque(maxHandle4))
+	}
+	return deviceID, l
+}
+
+// layoutGet gets a read layout for file
+//
+// If the server doesn't give one out or it can't be used the file is
+// read from the server.
+func (p *nfs4) layoutGet(ctx context.Context, file *nfs4File) {
+	var layout layout4
+	var deviceIDs [][]byte
+	err := p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opLayoutGet)
+		args.Bool(false) // no signal
+		args.Uint32(layoutNFSv41Files)
+		args.Uint32(layoutIOModeRead)
+		args.Uint64(0)          // offset
+		args.Uint64(^uint64(0)) // length - the whole file
+		args.Uint64(0)          // minimum length
+		writeStateID(&args.Writer, file.state)
+		args.Uint32(maxLayoutSize)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opLayoutGet); err != nil {
+			return err
+		}
+		r.Bool() // return on close
+		layout.state = readStateID(r.Reader)
+		n := r.Uint32()
+		for i := uint32(0); i < n && r.Err() == nil; i++ {
+			offset := r.Uint64()
+			length := r.Uint64()
+			r.Uint32() // iomode
+			layoutType := r.Uint32()
+			body := r.Opaque(maxLayoutSize)
+			if layoutType != layoutNFSv41Files {
+				r.SetErr(errors.Errorf("unexpected layout type %d", layoutType))
+				break
+			}
+			bodyReader := xdr.NewReader(body)
+			deviceID, segment := readFileLayout(bodyReader)
+			if bodyReader.Err() != nil {
+				r.SetErr(bodyReader.Err())
+			}
+			segment.offset, segment.length = offset, length
+			layout.segments = append(layout.segments, segment)
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+		return checkResult(r.Reader)
+	})
+	if err != nil {
+		fs.Debugf(nil, "%s: reading from the server as LAYOUTGET failed: %v", p.opt.Host, err)
+		return
+	}
+	file.layout = &layout
+	for i, segment := range layout.segments {
+		segment.device, err = p.getDevice(ctx, deviceIDs[i])
+		if err == nil {
+			err = segment.check()
+		}
+		if err != nil {
+			fs.Debugf(nil, "%s: reading from the server as the layout can't be used: %v", p.opt.Host, err)
+			file.layoutReturn(ctx)
+			file.layout = nil
+			return
+		}
+	}
+}
+
+// check the layout can be used with its device
+func (l *fileLayout) check() error {
+	if l.unit == 0 {
+		return errors.New("stripe unit is 0")
+	}
+	if len(l.device.stripeIndices) == 0 {
+		return errors.New("no stripe indices")
+	}
+	if len(l.handles) != 1 && len(l.handles) != len(l.device.stripeIndices) {
+		return errors.Errorf("%d file handles for %d stripe indices", len(l.handles), len(l.device.stripeIndices))
+	}
+	if l.patternOffset > l.offset {
+		return errors.New("pattern offset is after the layout")
+	}
+	for _, index := range l.device.stripeIndices {
+		if int(index) >= len(l.device.dataServers) || l.device.dataServers[index] == nil {
+			return errors.Errorf("no usable data server for stripe index %d", index)
+		}
+	}
+	return nil
+}
+
+// layoutReturn returns the layout of file to the server
+func (file *nfs4File) layoutReturn(ctx context.Context) {
+	err := file.p.mds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(file.handle)
+		args.op(opLayoutReturn)
+		args.Bool(false) // not reclaiming
+		args.Uint32(layoutNFSv41Files)
+		args.Uint32(layoutIOModeRead)
+		args.Uint32(layoutReturnFile)
+		args.Uint64(0)          // offset
+		args.Uint64(^uint64(0)) // length - the whole file
+		writeStateID(&args.Writer, file.layout.state)
+		args.Opaque(nil) // nothing to report
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opLayoutReturn); err != nil {
+			return err
+		}
+		if r.Bool() {
+			readStateID(r.Reader)
+		}
+		return checkResult(r.Reader)
+	})
+	if err != nil {
+		fs.Debugf(nil, "%s: failed to return layout: %v", file.p.opt.Host, err)
+	}
+}
+
+// getDevice returns the device with deviceID asking the server for
+// it if necessary
+func (p *nfs4) getDevice(ctx context.Context, deviceID []byte) (*device, error) {
+	p.mu.Lock()
+	d := p.devices[string(deviceID)]
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+	var addrs [][]string
+	d = new(device)
+	err := p.mds.compound(ctx, func(args *compoundArgs) {
+		args.op(opGetDeviceInfo)
+		args.Fixed(deviceID)
+		args.Uint32(layoutNFSv41Files)
+		args.Uint32(maxLayoutSize)
+		args.Bitmap(nil) // no notifications
+	}, func(r compoundResult) error {
+		if err := r.op(opGetDeviceInfo); err != nil {
+			return err
+		}
+		if layoutType := r.Uint32(); layoutType != layoutNFSv41Files {
+			r.SetErr(errors.Errorf("unexpected layout type %d", layoutType))
+		}
+		body := xdr.NewReader(r.Opaque(maxLayoutSize))
+		r.Bitmap() // notifications
+		n := body.Uint32()
+		for i := uint32(0); i < n && body.Err() == nil; i++ {
+			d.stripeIndices = append(d.stripeIndices, body.Uint32())
+		}
+		n = body.Uint32()
+		for i := uint32(0); i < n && body.Err() == nil; i++ {
+			var multipath []string
+			m := body.Uint32()
+			for j := uint32(0); j < m && body.Err() == nil; j++ {
+				netID := body.String(maxName)
+				uaddr := body.String(maxName)
+				addr, err := parseUniversalAddr(netID, uaddr)
+				if err != nil {
+					fs.Debugf(nil, "%s: ignoring data server: %v", p.opt.Host, err)
+					continue
+				}
+				multipath = append(multipath, addr)
+			}
+			addrs = append(addrs, multipath)
+		}
+		if body.Err() != nil {
+			r.SetErr(body.Err())
+		}
+		return checkResult(r.Reader)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GETDEVICEINFO failed")
+	}
+	// Use the first address of each data server
+	for _, multipath := range addrs {
+		var ds *client4
+		if len(multipath) > 0 {
+			ds = p.dataServer(multipath[0])
+		}
+		d.dataServers = append(d.dataServers, ds)
+	}
+	p.mu.Lock()
+	p.devices[string(deviceID)] = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// parseUniversalAddr converts a TCP universal address, e.g.
+// "192.168.1.2.8.1" into a host:port address
+func parseUniversalAddr(netID, uaddr string) (string, error) {
+	if netID != "tcp" && netID != "tcp6" {
+		return "", errors.Errorf("unsupported network %q", netID)
+	}
+	i := strings.LastIndexByte(uaddr, '.')
+	if i < 0 {
+		return "", errors.Errorf("bad address %q", uaddr)
+	}
+	j := strings.LastIndexByte(uaddr[:i], '.')
+	if j < 0 {
+		return "", errors.Errorf("bad address %q", uaddr)
+	}
+	hi, err1 := strconv.ParseUint(uaddr[j+1:i], 10, 8)
+	lo, err2 := strconv.ParseUint(uaddr[i+1:], 10, 8)
+	if err1 != nil || err2 != nil {
+		return "", errors.Errorf("bad port in address %q", uaddr)
+	}
+	return net.JoinHostPort(uaddr[:j], strconv.FormatUint(hi<<8|lo, 10)), nil
+}
+
+// dataServer returns the client for the data server at addr
+func (p *nfs4) dataServer(addr string) *client4 {
+	if addr == p.mds.pool.addr {
+		return p.mds
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ds := p.dataServers[addr]
+	if ds == nil {
+		ds = newClient4(addr, addr, p.opt, p.cred, p.owner, exchangeUsePNFSDS)
+		p.dataServers[addr] = ds
+	}
+	return ds
+}
+
+// readDataServer reads up to count bytes at offset from the data
+// server holding it.
+//
+// It reads no further than the end of the stripe unit or the size of
+// the file when it was opened.
+func (file *nfs4File) readDataServer(ctx context.Context, segment *fileLayout, offset uint64, count uint32) (data []byte, eof bool, err error) {
+	if offset >= file.size {
+		return nil, true, nil
+	}
+	ds, handle, dsOffset, n := segment.locate(offset)
+	if uint64(count) > n {
+		count = uint32(n)
+	}
+	if uint64(count) > file.size-offset {
+		count = uint32(file.size - offset)
+	}
+	err = ds.compound(ctx, func(args *compoundArgs) {
+		args.putFH(handle)
+		args.op(opRead)
+		writeStateID(&args.Writer, file.state)
+		args.Uint64(dsOffset)
+		args.Uint32(count)
+	}, func(r compoundResult) error {
+		if err := r.ops(opPutFH, opRead); err != nil {
+			return err
+		}
+		r.Bool() // eof - for the data server not the file
+		data = r.Opaque(int(count))
+		return checkResult(r.Reader)
+	})
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "data server %s", ds.name)
+	}
+	if len(data) < int(count) {
+		return nil, false, errors.Errorf("data server %s: short read", ds.name)
+	}
+	return data, offset+uint64(count) >= file.size, nil
+}
@@ -0,0 +1,151 @@
+// +build !plan9
+
+package nfs
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var errReaderClosed = errors.New("nfs: reader closed")
+
+// readResult is the result of a READ
+type readResult struct {
+	data []byte
+	eof  bool
+	err  error
+}
+
+// readRequest is a READ in progress
+type readRequest struct {
+	offset int64
+	count  uint32
+	result chan readResult
+}
+
+// parallelReader reads a file by keeping several READ requests in
+// progress, each on its own connection, and returning the results in
+// order.
+type parallelReader struct {
+	parentCtx context.Context // context to close the file with
+	ctx       context.Context
+	cancel    context.CancelFunc
+	f         *Fs
+	file      openFile
+	chunkSize uint32
+	streams   int            // max number of requests in progress
+	next      int64          // offset of the next request
+	end       int64          // offset to stop reading at or -1 to read to EOF
+	inFlight  []*readRequest // requests in progress in file order
+	buf       []byte         // data ready to be returned
+	eof       bool           // set if the server has reported EOF
+	err       error          // error to return once buf is empty
+}
+
+// newParallelReader makes a reader for file starting at offset and
+// reading limit bytes or to the end of file if limit < 0. Closing the
+// reader closes the file.
+func (f *Fs) newParallelReader(ctx context.Context, file openFile, offset, limit int64) *parallelReader {
+	readCtx, cancel := context.WithCancel(ctx)
+	end := int64(-1)
+	if limit >= 0 {
+		end = offset + limit
+	}
+	return &parallelReader{
+		parentCtx: ctx,
+		ctx:       readCtx,
+		cancel:    cancel,
+		f:         f,
+		file:      file,
+		chunkSize: file.readSize(chunkSize(f.info.readPref, f.info.readMax)),
+		streams:   f.opt.ParallelReads,
+		next:      offset,
+		end:       end,
+	}
+}
+
+// start sends a READ of count bytes at offset
+func (r *parallelReader) start(offset int64, count uint32) *readRequest {
+	req := &readRequest{
+		offset: offset,
+		count:  count,
+		result: make(chan readResult, 1),
+	}
+	go func() {
+		var res readResult
+		res.err = r.f.call(func() (err error) {
+			res.data, res.eof, err = r.file.read(r.ctx, uint64(offset), count)
+			return err
+		})
+		req.result <- res
+	}()
+	return req
+}
+
+// fill starts READs until there are streams in progress
+func (r *parallelReader) fill() {
+	for !r.eof && len(r.inFlight) < r.streams && (r.end < 0 || r.next < r.end) {
+		count := r.chunkSize
+		if r.end >= 0 && r.end-r.next < int64(count) {
+			count = uint32(r.end - r.next)
+		}
+		r.inFlight = append(r.inFlight, r.start(r.next, count))
+		r.next += int64(count)
+	}
+}
+
+// Read reads up to len(p) bytes into p
+func (r *parallelReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+		if len(r.inFlight) == 0 {
+			r.err = io.EOF
+			continue
+		}
+		req := r.inFlight[0]
+		r.inFlight = r.inFlight[1:]
+		res := <-req.result
+		switch {
+		case res.err != nil:
+			r.err = errors.Wrap(res.err, "read failed")
+		case res.eof:
+			// Any requests after this one are past the end of the
+			// file so ignore them.
+			r.eof = true
+			r.inFlight = nil
+		case len(res.data) == 0:
+			r.err = io.ErrUnexpectedEOF
+		case len(res.data) < int(req.count):
+			// Short read - ask for the rest before anything else
+			rest := r.start(req.offset+int64(len(res.data)), req.count-uint32(len(res.data)))
+			r.inFlight = append([]*readRequest{rest}, r.inFlight...)
+		}
+		r.buf = res.data
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close the reader cancelling any READs in progress
+func (r *parallelReader) Close() error {
+	if r.err == errReaderClosed {
+		return nil
+	}
+	r.cancel()
+	r.inFlight = nil
+	r.buf = nil
+	r.err = errReaderClosed
+	err := r.f.call(func() error {
+		return r.file.close(r.parentCtx)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	return nil
+}
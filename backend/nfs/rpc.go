@@ -0,0 +1,322 @@
+// +build !plan9
+
+package nfs
+
+// This implements an ONC RPC (RFC 5531) client over TCP and the
+// portmapper call to find the ports of the MOUNT and NFS programs.
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/lib/xdr"
+)
+
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess = 0
+
+	authNone = 0
+	authSys  = 1
+
+	lastFragment = 1 << 31
+	maxRecord    = 64 << 20 // largest reply we accept
+
+	// Portmapper
+	pmapProgram     = 100000
+	pmapVersion     = 2
+	pmapProcGetPort = 3
+	pmapPort        = 111
+	protoTCP        = 6
+
+	// reserved ports used if use_reserved_port is set
+	minReservedPort = 512
+	maxReservedPort = 1023
+)
+
+// acceptErrors are the descriptions of the accept_stat values
+var acceptErrors = []string{
+	1: "program unavailable",
+	2: "program version mismatch",
+	3: "procedure unavailable",
+	4: "garbage arguments",
+	5: "system error",
+}
+
+// authSysCred returns the encoded AUTH_SYS credential
+func authSysCred(machine string, uid, gid uint32) []byte {
+	var w xdr.Writer
+	w.Uint32(uint32(time.Now().Unix())) // stamp
+	w.String(machine)
+	w.Uint32(uid)
+	w.Uint32(gid)
+	w.Uint32(1) // auxiliary gids
+	w.Uint32(gid)
+	return w.Bytes()
+}
+
+// rpcClient makes RPC calls over a single TCP connection, one at a
+// time.
+type rpcClient struct {
+	conn    net.Conn
+	in      *bufio.Reader
+	xid     uint32
+	flavor  uint32 // auth flavor of the credential
+	cred    []byte // encoded credential
+	timeout time.Duration
+}
+
+// dialRPC connects to the RPC server at addr
+//
+// If reserved is set it binds the local end to a port below 1024
+// which many NFS servers insist on.
+func dialRPC(ctx context.Context, addr string, reserved bool, flavor uint32, cred []byte) (*rpcClient, error) {
+	dialer := fshttp.NewDialer(ctx)
+	var conn net.Conn
+	var err error
+	if !reserved {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		for port := maxReservedPort; port >= minReservedPort; port-- {
+			dialer.LocalAddr = &net.TCPAddr{Port: port}
+			conn, err = dialer.DialContext(ctx, "tcp", addr)
+			if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+				break
+			}
+		}
+		if errors.Is(err, syscall.EACCES) {
+			err = errors.Wrap(err, "binding to a reserved port needs root")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rpcClient{
+		conn:    conn,
+		in:      bufio.NewReader(conn),
+		xid:     uint32(time.Now().UnixNano()),
+		flavor:  flavor,
+		cred:    cred,
+		timeout: fs.GetConfig(ctx).Timeout,
+	}, nil
+}
+
+// close the connection
+func (c *rpcClient) close() {
+	_ = c.conn.Close()
+}
+
+// setDeadline sets the deadline for the next call
+func (c *rpcClient) setDeadline(ctx context.Context) error {
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return c.conn.SetDeadline(deadline)
+}
+
+// call calls proc of the program returning a reader for the results
+func (c *rpcClient) call(ctx context.Context, prog, vers, proc uint32, args []byte) (*xdr.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.setDeadline(ctx); err != nil {
+		return nil, err
+	}
+	c.xid++
+	var w xdr.Writer
+	w.Uint32(0) // record marker filled in below
+	w.Uint32(c.xid)
+	w.Uint32(msgCall)
+	w.Uint32(rpcVersion)
+	w.Uint32(prog)
+	w.Uint32(vers)
+	w.Uint32(proc)
+	w.Uint32(c.flavor)
+	w.Opaque(c.cred)
+	w.Uint32(authNone) // verifier
+	w.Opaque(nil)
+	w.Fixed(args)
+	binary.BigEndian.PutUint32(w.Bytes(), lastFragment|uint32(w.Len()-4))
+	_, err := c.conn.Write(w.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "rpc write failed")
+	}
+	reply, err := c.readRecord()
+	if err != nil {
+		return nil, errors.Wrap(err, "rpc read failed")
+	}
+	r := xdr.NewReader(reply)
+	xid := r.Uint32()
+	msgType := r.Uint32()
+	if r.Err() == nil && (xid != c.xid || msgType != msgReply) {
+		return nil, errors.Errorf("rpc protocol error: unexpected reply xid %d type %d", xid, msgType)
+	}
+	switch stat := r.Uint32(); stat {
+	case replyAccepted:
+	case replyDenied:
+		if r.Uint32() == 1 {
+			return nil, errors.Errorf("rpc authentication error %d", r.Uint32())
+		}
+		return nil, errors.New("rpc version mismatch")
+	default:
+		return nil, errors.Errorf("rpc protocol error: bad reply status %d", stat)
+	}
+	r.Uint32()         // verifier flavor
+	r.Opaque(400)      // verifier body
+	stat := r.Uint32() // accept status
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "rpc protocol error")
+	}
+	if stat != acceptSuccess {
+		message := "unknown error " + strconv.Itoa(int(stat))
+		if int(stat) < len(acceptErrors) {
+			message = acceptErrors[stat]
+		}
+		return nil, errors.Errorf("rpc call to program %d version %d procedure %d failed: %s", prog, vers, proc, message)
+	}
+	return r, nil
+}
+
+// readRecord reads a record made of one or more fragments
+func (c *rpcClient) readRecord() (record []byte, err error) {
+	var header [4]byte
+	for {
+		_, err = io.ReadFull(c.in, header[:])
+		if err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header[:])
+		length := int(marker &^ lastFragment)
+		if len(record)+length > maxRecord {
+			return nil, errors.Errorf("rpc record too long (%d bytes)", len(record)+length)
+		}
+		start := len(record)
+		record = append(record, make([]byte, length)...)
+		_, err = io.ReadFull(c.in, record[start:])
+		if err != nil {
+			return nil, err
+		}
+		if marker&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// connPool holds idle connections to an RPC service
+type connPool struct {
+	addr     string // address of the service
+	reserved bool   // set to connect from a reserved port
+	flavor   uint32 // auth flavor of the credential
+	cred     []byte // encoded credential
+
+	mu    sync.Mutex
+	conns []*rpcClient
+}
+
+// newConnPool makes a pool of connections to the RPC service at addr
+func newConnPool(addr string, reserved bool, flavor uint32, cred []byte) *connPool {
+	return &connPool{
+		addr:     addr,
+		reserved: reserved,
+		flavor:   flavor,
+		cred:     cred,
+	}
+}
+
+// get gets an idle connection or makes a new one
+func (p *connPool) get(ctx context.Context) (*rpcClient, error) {
+	p.mu.Lock()
+	if n := len(p.conns); n > 0 {
+		c := p.conns[n-1]
+		p.conns = p.conns[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+	c, err := dialRPC(ctx, p.addr, p.reserved, p.flavor, p.cred)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to NFS server")
+	}
+	return c, nil
+}
+
+// put returns the connection to the pool unless the call on it
+// failed in a way which might leave it unusable
+func (p *connPool) put(c *rpcClient, err error) {
+	if _, ok := errors.Cause(err).(nfsStatus); err != nil && !ok {
+		c.close()
+		return
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, c)
+	p.mu.Unlock()
+}
+
+// call runs fn with a connection from the pool
+func (p *connPool) call(ctx context.Context, fn func(c *rpcClient) error) error {
+	c, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	p.put(c, err)
+	return err
+}
+
+// close closes the idle connections
+func (p *connPool) close() {
+	p.mu.Lock()
+	for _, c := range p.conns {
+		c.close()
+	}
+	p.conns = nil
+	p.mu.Unlock()
+}
+
+// getPort asks the portmapper on host for the TCP port of the
+// program and version.
+func getPort(ctx context.Context, host string, prog, vers uint32) (int, error) {
+	c, err := dialRPC(ctx, net.JoinHostPort(host, strconv.Itoa(pmapPort)), false, authNone, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to connect to portmapper")
+	}
+	defer c.close()
+	var w xdr.Writer
+	w.Uint32(prog)
+	w.Uint32(vers)
+	w.Uint32(protoTCP)
+	w.Uint32(0)
+	r, err := c.call(ctx, pmapProgram, pmapVersion, pmapProcGetPort, w.Bytes())
+	if err != nil {
+		return 0, errors.Wrap(err, "portmapper")
+	}
+	port := r.Uint32()
+	if r.Err() != nil {
+		return 0, errors.Wrap(r.Err(), "portmapper")
+	}
+	if port == 0 {
+		return 0, errors.Errorf("program %d version %d isn't registered with the portmapper on %s", prog, vers, host)
+	}
+	return int(port), nil
+}
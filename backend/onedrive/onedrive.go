@@ -57,8 +57,9 @@ const (
 
 // Globals
 var (
-	// Description of how to auth for this app for a business account
-	oauthConfig = &oauth2.Config{
+	// OAuthConfig describes how to auth for this app for a business
+	// account - the sharepoint backend uses it too
+	OAuthConfig = &oauth2.Config{
 		Endpoint: oauth2.Endpoint{
 			AuthURL:  "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
 			TokenURL: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
@@ -82,7 +83,7 @@ func init() {
 		NewFs:       NewFs,
 		Config: func(ctx context.Context, name string, m configmap.Mapper) {
 			ci := fs.GetConfig(ctx)
-			err := oauthutil.Config(ctx, "onedrive", name, m, oauthConfig, nil)
+			err := oauthutil.Config(ctx, "onedrive", name, m, OAuthConfig, nil)
 			if err != nil {
 				log.Fatalf("Failed to configure token: %v", err)
 				return
@@ -111,7 +112,7 @@ func init() {
 				Sites []siteResource `json:"value"`
 			}
 
-			oAuthClient, _, err := oauthutil.NewClient(ctx, name, m, oauthConfig)
+			oAuthClient, _, err := oauthutil.NewClient(ctx, name, m, OAuthConfig)
 			if err != nil {
 				log.Fatalf("Failed to configure OneDrive: %v", err)
 			}
@@ -241,16 +242,7 @@ func init() {
 			m.Set(configDriveType, rootItem.ParentReference.DriveType)
 			config.SaveConfig()
 		},
-		Options: append(oauthutil.SharedOptions, []fs.Option{{
-			Name: "chunk_size",
-			Help: `Chunk size to upload files with - must be multiple of 320k (327,680 bytes).
-
-Above this size files will be chunked - must be multiple of 320k (327,680 bytes) and
-should not exceed 250M (262,144,000 bytes) else you may encounter \"Microsoft.SharePoint.Client.InvalidClientQueryException: The request message is too big.\"
-Note that the chunks will be buffered into memory.`,
-			Default:  defaultChunkSize,
-			Advanced: true,
-		}, {
+		Options: append(append(oauthutil.SharedOptions, []fs.Option{{
 			Name:     "drive_id",
 			Help:     "The ID of the drive to use",
 			Default:  "",
@@ -260,31 +252,45 @@ Note that the chunks will be buffered into memory.`,
 			Help:     "The type of the drive ( " + driveTypePersonal + " | " + driveTypeBusiness + " | " + driveTypeSharepoint + " )",
 			Default:  "",
 			Advanced: true,
-		}, {
-			Name: "expose_onenote_files",
-			Help: `Set to make OneNote files show up in directory listings.
+		}}...), SharedOptions...),
+	})
+}
+
+// SharedOptions are shared between onedrive and the sharepoint backend
+var SharedOptions = []fs.Option{{
+	Name: "chunk_size",
+	Help: `Chunk size to upload files with - must be multiple of 320k (327,680 bytes).
+
+Above this size files will be chunked - must be multiple of 320k (327,680 bytes) and
+should not exceed 250M (262,144,000 bytes) else you may encounter \"Microsoft.SharePoint.Client.InvalidClientQueryException: The request message is too big.\"
+Note that the chunks will be buffered into memory.`,
+	Default:  defaultChunkSize,
+	Advanced: true,
+}, {
+	Name: "expose_onenote_files",
+	Help: `Set to make OneNote files show up in directory listings.
 
 By default rclone will hide OneNote files in directory listings because
 operations like "Open" and "Update" won't work on them.  But this
 behaviour may also prevent you from deleting them.  If you want to
 delete OneNote files or otherwise want them to show up in directory
 listing, set this option.`,
-			Default:  false,
-			Advanced: true,
-		}, {
-			Name:    "server_side_across_configs",
-			Default: false,
-			Help: `Allow server-side operations (e.g. copy) to work across different onedrive configs.
+	Default:  false,
+	Advanced: true,
+}, {
+	Name:    "server_side_across_configs",
+	Default: false,
+	Help: `Allow server-side operations (e.g. copy) to work across different onedrive configs.
 
 This can be useful if you wish to do a server-side copy between two
 different Onedrives.  Note that this isn't enabled by default
 because it isn't easy to tell if it will work between any two
 configurations.`,
-			Advanced: true,
-		}, {
-			Name:    "no_versions",
-			Default: false,
-			Help: `Remove all versions on modifying operations
+	Advanced: true,
+}, {
+	Name:    "no_versions",
+	Default: false,
+	Help: `Remove all versions on modifying operations
 
 Onedrive for business creates versions when rclone uploads new files
 overwriting an existing one and when it sets the modification time.
@@ -297,56 +303,54 @@ modification time and removes all but the last version.
 **NB** Onedrive personal can't currently delete versions so don't use
 this flag there.
 `,
-			Advanced: true,
-		}, {
-			Name:     config.ConfigEncoding,
-			Help:     config.ConfigEncodingHelp,
-			Advanced: true,
-			// List of replaced characters:
-			//   < (less than)     -> '＜' // FULLWIDTH LESS-THAN SIGN
-			//   > (greater than)  -> '＞' // FULLWIDTH GREATER-THAN SIGN
-			//   : (colon)         -> '：' // FULLWIDTH COLON
-			//   " (double quote)  -> '＂' // FULLWIDTH QUOTATION MARK
-			//   \ (backslash)     -> '＼' // FULLWIDTH REVERSE SOLIDUS
-			//   | (vertical line) -> '｜' // FULLWIDTH VERTICAL LINE
-			//   ? (question mark) -> '？' // FULLWIDTH QUESTION MARK
-			//   * (asterisk)      -> '＊' // FULLWIDTH ASTERISK
-			//   # (number sign)  -> '＃'  // FULLWIDTH NUMBER SIGN
-			//   % (percent sign) -> '％'  // FULLWIDTH PERCENT SIGN
-			//
-			// Folder names cannot begin with a tilde ('~')
-			// List of replaced characters:
-			//   ~ (tilde)        -> '～'  // FULLWIDTH TILDE
-			//
-			// Additionally names can't begin with a space ( ) or end with a period (.) or space ( ).
-			// List of replaced characters:
-			//   . (period)        -> '．' // FULLWIDTH FULL STOP
-			//     (space)         -> '␠'  // SYMBOL FOR SPACE
-			//
-			// Also encode invalid UTF-8 bytes as json doesn't handle them.
-			//
-			// The OneDrive API documentation lists the set of reserved characters, but
-			// testing showed this list is incomplete. This are the differences:
-			//  - " (double quote) is rejected, but missing in the documentation
-			//  - space at the end of file and folder names is rejected, but missing in the documentation
-			//  - period at the end of file names is rejected, but missing in the documentation
-			//
-			// Adding these restrictions to the OneDrive API documentation yields exactly
-			// the same rules as the Windows naming conventions.
-			//
-			// https://docs.microsoft.com/en-us/onedrive/developer/rest-api/concepts/addressing-driveitems?view=odsp-graph-online#path-encoding
-			Default: (encoder.Display |
-				encoder.EncodeBackSlash |
-				encoder.EncodeHashPercent |
-				encoder.EncodeLeftSpace |
-				encoder.EncodeLeftTilde |
-				encoder.EncodeRightPeriod |
-				encoder.EncodeRightSpace |
-				encoder.EncodeWin |
-				encoder.EncodeInvalidUtf8),
-		}}...),
-	})
-}
+	Advanced: true,
+}, {
+	Name:     config.ConfigEncoding,
+	Help:     config.ConfigEncodingHelp,
+	Advanced: true,
+	// List of replaced characters:
+	//   < (less than)     -> '＜' // FULLWIDTH LESS-THAN SIGN
+	//   > (greater than)  -> '＞' // FULLWIDTH GREATER-THAN SIGN
+	//   : (colon)         -> '：' // FULLWIDTH COLON
+	//   " (double quote)  -> '＂' // FULLWIDTH QUOTATION MARK
+	//   \ (backslash)     -> '＼' // FULLWIDTH REVERSE SOLIDUS
+	//   | (vertical line) -> '｜' // FULLWIDTH VERTICAL LINE
+	//   ? (question mark) -> '？' // FULLWIDTH QUESTION MARK
+	//   * (asterisk)      -> '＊' // FULLWIDTH ASTERISK
+	//   # (number sign)  -> '＃'  // FULLWIDTH NUMBER SIGN
+	//   % (percent sign) -> '％'  // FULLWIDTH PERCENT SIGN
+	//
+	// Folder names cannot begin with a tilde ('~')
+	// List of replaced characters:
+	//   ~ (tilde)        -> '～'  // FULLWIDTH TILDE
+	//
+	// Additionally names can't begin with a space ( ) or end with a period (.) or space ( ).
+	// List of replaced characters:
+	//   . (period)        -> '．' // FULLWIDTH FULL STOP
+	//     (space)         -> '␠'  // SYMBOL FOR SPACE
+	//
+	// Also encode invalid UTF-8 bytes as json doesn't handle them.
+	//
+	// The OneDrive API documentation lists the set of reserved characters, but
+	// testing showed this list is incomplete. This are the differences:
+	//  - " (double quote) is rejected, but missing in the documentation
+	//  - space at the end of file and folder names is rejected, but missing in the documentation
+	//  - period at the end of file names is rejected, but missing in the documentation
+	//
+	// Adding these restrictions to the OneDrive API documentation yields exactly
+	// the same rules as the Windows naming conventions.
+	//
+	// https://docs.microsoft.com/en-us/onedrive/developer/rest-api/concepts/addressing-driveitems?view=odsp-graph-online#path-encoding
+	Default: (encoder.Display |
+		encoder.EncodeBackSlash |
+		encoder.EncodeHashPercent |
+		encoder.EncodeLeftSpace |
+		encoder.EncodeLeftTilde |
+		encoder.EncodeRightPeriod |
+		encoder.EncodeRightSpace |
+		encoder.EncodeWin |
+		encoder.EncodeInvalidUtf8),
+}}
 
 // Options defines the configuration for this backend
 type Options struct {
@@ -605,21 +609,30 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		return nil, err
 	}
 
-	err = checkUploadChunkSize(opt.ChunkSize)
-	if err != nil {
-		return nil, errors.Wrap(err, "onedrive: chunk size")
-	}
-
 	if opt.DriveID == "" || opt.DriveType == "" {
 		return nil, errors.New("unable to get drive_id and drive_type - if you are upgrading from older versions of rclone, please run `rclone config` and re-configure this backend")
 	}
 
-	root = parsePath(root)
-	oAuthClient, ts, err := oauthutil.NewClient(ctx, name, m, oauthConfig)
+	oAuthClient, ts, err := oauthutil.NewClient(ctx, name, m, OAuthConfig)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to configure OneDrive")
 	}
+	f, err := NewFsWithClient(ctx, name, root, opt, oAuthClient, ts)
+	if f == nil {
+		return nil, err
+	}
+	return f, err
+}
 
+// NewFsWithClient constructs an Fs for the drive in opt from the
+// path using an authorised client and its token source
+func NewFsWithClient(ctx context.Context, name, root string, opt *Options, oAuthClient *http.Client, ts *oauthutil.TokenSource) (*Fs, error) {
+	err := checkUploadChunkSize(opt.ChunkSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "onedrive: chunk size")
+	}
+
+	root = parsePath(root)
 	ci := fs.GetConfig(ctx)
 	f := &Fs{
 		name:      name,
@@ -1233,6 +1246,217 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 	return nil
 }
 
+// changeTimeSlack is how much earlier than the change token a folder
+// can have been modified and still be reported as changed to allow
+// for clock differences
+const changeTimeSlack = time.Minute
+
+// makeChangeToken makes a change token from the time it was made and
+// the delta link
+func makeChangeToken(t time.Time, deltaLink string) string {
+	return strconv.FormatInt(t.Unix(), 10) + " " + deltaLink
+}
+
+// parseChangeToken parses a token made by makeChangeToken
+func parseChangeToken(token string) (t time.Time, deltaLink string, err error) {
+	parts := strings.SplitN(token, " ", 2)
+	if len(parts) != 2 {
+		return t, "", errors.New("bad change token")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return t, "", errors.Wrap(err, "bad change token")
+	}
+	return time.Unix(unix, 0), parts[1], nil
+}
+
+// ChangeToken returns a token for the current state of the remote
+// which can be passed to Changes to find what has changed since.
+func (f *Fs) ChangeToken(ctx context.Context) (string, error) {
+	rootID, err := f.dirCache.RootID(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	// Only the changes of the drive can be read, not those of
+	// folders shared from other drives
+	if _, drive, _ := parseNormalizedID(rootID); drive != "" && !strings.EqualFold(drive, f.driveID) {
+		return "", fs.ErrorNotImplemented
+	}
+	now := time.Now()
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/root/delta?token=latest",
+	}
+	var result api.ViewDeltaResponse
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read delta link")
+	}
+	if result.DeltaLink == "" {
+		return "", errors.New("no delta link returned")
+	}
+	return makeChangeToken(now, result.DeltaLink), nil
+}
+
+// Changes returns the paths which have changed since token was read
+// with ChangeToken and a new token for the current state.
+//
+// Files moved out of a directory are only reported at their new
+// location and deleted files are reported as their directory if it
+// isn't known where they were.  Folders are only reported if they
+// were modified since the token, not when only their contents
+// changed.
+func (f *Fs) Changes(ctx context.Context, token string) (paths []string, newToken string, err error) {
+	since, deltaLink, err := parseChangeToken(token)
+	if err != nil {
+		fs.Debugf(f, "Change token rejected: %v", err)
+		return nil, "", fs.ErrorChangesUnavailable
+	}
+	since = since.Add(-changeTimeSlack)
+	rootID, err := f.dirCache.RootID(ctx, false)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	opts := rest.Opts{
+		Method:  "GET",
+		RootURL: deltaLink,
+	}
+	// IDs of the directories found not to be inside the root
+	outside := map[string]struct{}{}
+	for {
+		var result api.ViewDeltaResponse
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			if resp != nil && (resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusBadRequest) {
+				fs.Debugf(f, "Delta link rejected: %v", err)
+				return nil, "", fs.ErrorChangesUnavailable
+			}
+			return nil, "", errors.Wrap(err, "failed to read changes")
+		}
+		for i := range result.Value {
+			item := &result.Value[i]
+			parent := item.GetParentReference()
+			if item.Deleted != nil {
+				if oldPath, ok := f.dirCache.GetInv(item.GetID()); ok {
+					paths = append(paths, oldPath)
+					continue
+				}
+				if parent == nil || parent.ID == "" {
+					// There is no way of finding where it was
+					return nil, "", fs.ErrorChangesUnavailable
+				}
+				dirPath, ok, err := f.changedDirPath(ctx, rootID, normalizedParentID(parent), outside)
+				if err != nil {
+					return nil, "", err
+				}
+				if ok {
+					paths = append(paths, dirPath)
+				}
+				continue
+			}
+			if parent == nil || parent.ID == "" {
+				// the root of the drive
+				continue
+			}
+			if item.GetFolder() != nil && time.Time(item.GetLastModifiedDateTime()).Before(since) {
+				// only the contents of the folder changed
+				continue
+			}
+			dirPath, ok, err := f.changedDirPath(ctx, rootID, normalizedParentID(parent), outside)
+			if err != nil {
+				return nil, "", err
+			}
+			if ok {
+				paths = append(paths, path.Join(dirPath, f.opt.Enc.ToStandardName(item.GetName())))
+			}
+		}
+		switch {
+		case result.NextLink != "":
+			opts.RootURL = result.NextLink
+		case result.DeltaLink != "":
+			return paths, makeChangeToken(now, result.DeltaLink), nil
+		default:
+			return nil, "", errors.New("no delta link returned")
+		}
+	}
+}
+
+// normalizedParentID returns the normalized ID of the parent in the
+// same form as (*api.Item).GetID
+func normalizedParentID(parent *api.ItemReference) string {
+	if parent.DriveID == "" || strings.Contains(parent.ID, "#") {
+		return parent.ID
+	}
+	return parent.DriveID + "#" + parent.ID
+}
+
+// changedDirPath returns the path relative to the root of the
+// directory with ID dirID or false if it isn't inside the root.
+//
+// The IDs of the directories found not to be inside the root are
+// added to outside so they aren't looked up again.
+func (f *Fs) changedDirPath(ctx context.Context, rootID, dirID string, outside map[string]struct{}) (dirPath string, ok bool, err error) {
+	var leaves, IDs []string
+	inside := false
+	ID := dirID
+	for {
+		if strings.EqualFold(ID, rootID) {
+			dirPath, inside = "", true
+			break
+		}
+		if parentPath, found := f.dirCache.GetInv(ID); found {
+			dirPath, inside = parentPath, true
+			break
+		}
+		if _, found := outside[ID]; found {
+			break
+		}
+		IDs = append(IDs, ID)
+		opts := newOptsCall(ID, "GET", "?$select=id,name,parentReference")
+		var info *api.Item
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &info)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			return "", false, errors.Wrap(err, "failed to find changed directory")
+		}
+		parent := info.GetParentReference()
+		if parent == nil || parent.ID == "" {
+			// reached the root of the drive
+			break
+		}
+		leaves = append(leaves, f.opt.Enc.ToStandardName(info.GetName()))
+		ID = normalizedParentID(parent)
+	}
+	if !inside {
+		for _, ID := range IDs {
+			outside[ID] = struct{}{}
+		}
+		return "", false, nil
+	}
+	for i := len(leaves) - 1; i >= 0; i-- {
+		dirPath = path.Join(dirPath, leaves[i])
+	}
+	if !strings.EqualFold(dirID, rootID) {
+		f.dirCache.Put(dirPath, dirID)
+	}
+	return dirPath, true, nil
+}
+
 // DirCacheFlush resets the directory cache - used in testing as an
 // optional interface
 func (f *Fs) DirCacheFlush() {
@@ -1950,6 +2174,7 @@ var (
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.ChangeLister    = (*Fs)(nil)
 	_ fs.Object          = (*Object)(nil)
 	_ fs.MimeTyper       = &Object{}
 	_ fs.IDer            = &Object{}
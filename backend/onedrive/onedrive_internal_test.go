@@ -0,0 +1,31 @@
+package onedrive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/onedrive/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalChangeToken(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	link := "https://graph.microsoft.com/v1.0/drives/abc/root/delta?token=xyz"
+	token := makeChangeToken(now, link)
+	gotTime, gotLink, err := parseChangeToken(token)
+	require.NoError(t, err)
+	assert.True(t, now.Equal(gotTime))
+	assert.Equal(t, link, gotLink)
+
+	for _, bad := range []string{"", "potato", "potato " + link} {
+		_, _, err = parseChangeToken(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestInternalNormalizedParentID(t *testing.T) {
+	assert.Equal(t, "drive#item", normalizedParentID(&api.ItemReference{DriveID: "drive", ID: "item"}))
+	assert.Equal(t, "item", normalizedParentID(&api.ItemReference{ID: "item"}))
+	assert.Equal(t, "other#item", normalizedParentID(&api.ItemReference{DriveID: "drive", ID: "other#item"}))
+}
@@ -0,0 +1,176 @@
+// Package ozone provides an interface to Apache Ozone using the
+// WebHDFS API of its HttpFS gateway.
+package ozone
+
+// Ozone is addressed as /volume/bucket/key. Volumes and buckets can
+// be made with Mkdir but files can only be stored in buckets, which
+// the gateway doesn't check, so this checks the paths before passing
+// the calls on to the webhdfs backend.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/webhdfs"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "ozone",
+		Description: "Apache Ozone using the HttpFS gateway",
+		NewFs:       NewFs,
+		Options: append([]fs.Option{{
+			Name: "url",
+			Help: `URL of the Ozone HttpFS gateway
+
+Use an https:// URL if the gateway has HTTPS enabled.`,
+			Default: "http://localhost:14000",
+			Examples: []fs.OptionExample{{
+				Value: "http://localhost:14000",
+				Help:  "Gateway on this machine",
+			}, {
+				Value: "https://httpfs.example.com:14000",
+				Help:  "Gateway using HTTPS",
+			}},
+		}}, webhdfs.SharedOptions...),
+	})
+}
+
+// Fs represents a path in Ozone
+type Fs struct {
+	fs.Fs                 // wrapped Fs
+	features *fs.Features // optional features
+	root     string       // the path we are working on
+}
+
+// ------------------------------------------------------------
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("Ozone %s", f.Fs.String())
+}
+
+// NewFs constructs an Fs from the path, volume/bucket/key
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into webhdfs.Options struct
+	opt := new(webhdfs.Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	webhdfsFs, err := webhdfs.NewFsWithOptions(ctx, name, root, opt)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+	f := &Fs{
+		Fs:   webhdfsFs,
+		root: webhdfsFs.Root(),
+	}
+	f.features = f.Fs.Features().Wrap(f)
+	f.features.PutStream = f.PutStream
+	return f, err
+}
+
+// nameRe matches the characters allowed in volume and bucket names
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// checkName checks name is a valid volume or bucket name
+//
+// These are the same as S3 bucket names.
+func checkName(kind, name string) error {
+	switch {
+	case !nameRe.MatchString(name):
+		return errors.Errorf("%s name %q must be 3 to 63 lower case letters, numbers, dots and hyphens starting and ending with a letter or number", kind, name)
+	case strings.Contains(name, ".."), strings.Contains(name, ".-"), strings.Contains(name, "-."):
+		return errors.Errorf("%s name %q can't have a dot next to a dot or hyphen", kind, name)
+	case net.ParseIP(name) != nil:
+		return errors.Errorf("%s name %q can't be an IP address", kind, name)
+	}
+	return nil
+}
+
+// split returns the volume and bucket of remote and how deep in the
+// namespace it is
+func (f *Fs) split(remote string) (volume, bucket string, depth int) {
+	p := strings.Trim(path.Join(f.root, remote), "/")
+	if p == "" {
+		return "", "", 0
+	}
+	parts := strings.SplitN(p, "/", 3)
+	volume = parts[0]
+	if len(parts) > 1 {
+		bucket = parts[1]
+	}
+	return volume, bucket, strings.Count(p, "/") + 1
+}
+
+// checkFile checks a file can be stored at remote
+func (f *Fs) checkFile(remote string) error {
+	if _, _, depth := f.split(remote); depth < 3 {
+		return errors.Errorf("can't store %q as files must be in a bucket - use volume/bucket/path", path.Join(f.root, remote))
+	}
+	return nil
+}
+
+// Mkdir creates the directory if it doesn't exist
+//
+// The first two levels make volumes and buckets so the names are
+// checked first.
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	volume, bucket, depth := f.split(dir)
+	if depth >= 1 {
+		if err := checkName("volume", volume); err != nil {
+			return err
+		}
+	}
+	if depth >= 2 {
+		if err := checkName("bucket", bucket); err != nil {
+			return err
+		}
+	}
+	return f.Fs.Mkdir(ctx, dir)
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if err := f.checkFile(src.Remote()); err != nil {
+		return nil, err
+	}
+	return f.Fs.Put(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.Fs
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.UnWrapper   = (*Fs)(nil)
+)
@@ -0,0 +1,96 @@
+package ozone
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFs makes an Fs for root on a gateway where nothing exists
+func newFs(t *testing.T, root string) *Fs {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"RemoteException":{"exception":"FileNotFoundException","message":"not found"}}`))
+	}))
+	f, err := NewFs(context.Background(), "TestOzone", root, configmap.Simple{
+		"url":  srv.URL,
+		"user": "hadoop",
+	})
+	srv.Close()
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"vol", "vol1", "my-bucket", "my.bucket", strings.Repeat("a", 63)} {
+		assert.NoError(t, checkName("bucket", name), name)
+	}
+	for _, name := range []string{"", "ab", "Vol", "my_bucket", "-vol", "vol-", "a..b", "a.-b", "a-.b", "192.168.1.1", strings.Repeat("a", 64)} {
+		assert.Error(t, checkName("bucket", name), name)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	f := newFs(t, "")
+	for _, test := range []struct {
+		remote string
+		volume string
+		bucket string
+		depth  int
+	}{
+		{"", "", "", 0},
+		{"vol", "vol", "", 1},
+		{"vol/bucket", "vol", "bucket", 2},
+		{"vol/bucket/dir/file.txt", "vol", "bucket", 4},
+	} {
+		volume, bucket, depth := f.split(test.remote)
+		assert.Equal(t, test.volume, volume, test.remote)
+		assert.Equal(t, test.bucket, bucket, test.remote)
+		assert.Equal(t, test.depth, depth, test.remote)
+	}
+	f = newFs(t, "vol")
+	volume, bucket, depth := f.split("bucket/file.txt")
+	assert.Equal(t, "vol", volume)
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, 3, depth)
+}
+
+func TestChecks(t *testing.T) {
+	ctx := context.Background()
+	f := newFs(t, "vol")
+	assert.True(t, strings.HasPrefix(f.String(), "Ozone HDFS at http://"))
+
+	err := f.Mkdir(ctx, "Bad_Bucket")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `bucket name "Bad_Bucket"`)
+
+	g := newFs(t, "")
+	err = g.Mkdir(ctx, "v")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `volume name "v"`)
+
+	for _, put := range []func(context.Context, fs.Fs, fs.ObjectInfo) error{
+		func(ctx context.Context, f fs.Fs, src fs.ObjectInfo) error {
+			_, err := f.Put(ctx, strings.NewReader("x"), src)
+			return err
+		},
+		func(ctx context.Context, f fs.Fs, src fs.ObjectInfo) error {
+			_, err := f.Features().PutStream(ctx, strings.NewReader("x"), src)
+			return err
+		},
+	} {
+		src := object.NewStaticObjectInfo("file.txt", time.Now(), 1, true, nil, nil)
+		err = put(ctx, f, src)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be in a bucket")
+	}
+}
@@ -0,0 +1,406 @@
+// Package api has type definitions for the Proton API
+//
+// Everything which is encrypted is an armored PGP message or key
+// unless noted otherwise.
+package api
+
+import (
+	"fmt"
+)
+
+// Response codes in the body of the response
+const (
+	CodeOK                = 1000 // success
+	CodeMultiOK           = 1001 // success for all the items of a multiple request
+	CodeAlreadyExist      = 2500 // a file or folder with that name already exists
+	CodeNotExists         = 2501 // the file or folder doesn't exist
+	CodeHumanVerification = 9001 // a captcha must be solved in a browser
+)
+
+// Link types
+const (
+	LinkTypeFolder = 1
+	LinkTypeFile   = 2
+)
+
+// Link states
+const (
+	LinkStateDraft   = 0
+	LinkStateActive  = 1
+	LinkStateTrashed = 2
+	LinkStateDeleted = 3
+)
+
+// Error is returned from the API on failure
+type Error struct {
+	Code    int                    `json:"Code"`
+	Message string                 `json:"Error"`
+	Details map[string]interface{} `json:"Details"`
+	Status  int                    `json:"-"` // the HTTP status code
+}
+
+// Error satisfies the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (Code %d, HTTP %d)", e.Message, e.Code, e.Status)
+}
+
+// Response is the part of every response with the code
+type Response struct {
+	Code int `json:"Code"`
+}
+
+// AuthInfoRequest starts logging in
+type AuthInfoRequest struct {
+	Username string `json:"Username"`
+}
+
+// AuthInfo has the parameters for the SRP exchange
+type AuthInfo struct {
+	Response
+	Version         int    `json:"Version"`
+	Modulus         string `json:"Modulus"` // clear signed base64 modulus
+	ServerEphemeral string `json:"ServerEphemeral"`
+	Salt            string `json:"Salt"`
+	SRPSession      string `json:"SRPSession"`
+}
+
+// AuthRequest has the proofs of the SRP exchange
+type AuthRequest struct {
+	Username        string `json:"Username"`
+	ClientEphemeral string `json:"ClientEphemeral"`
+	ClientProof     string `json:"ClientProof"`
+	SRPSession      string `json:"SRPSession"`
+}
+
+// TwoFAInfo describes the second factors of the account
+type TwoFAInfo struct {
+	Enabled int `json:"Enabled"` // bit 0 is TOTP
+}
+
+// Password modes of an account
+const (
+	PasswordModeOne = 1 // the login password unlocks the keys
+	PasswordModeTwo = 2 // a separate mailbox password unlocks the keys
+)
+
+// Auth is returned after logging in or refreshing
+type Auth struct {
+	Response
+	UID          string    `json:"UID"`
+	AccessToken  string    `json:"AccessToken"`
+	RefreshToken string    `json:"RefreshToken"`
+	ServerProof  string    `json:"ServerProof"`
+	Scope        string    `json:"Scope"`
+	TwoFA        TwoFAInfo `json:"2FA"`
+	PasswordMode int       `json:"PasswordMode"`
+}
+
+// TwoFARequest sends the second factor
+type TwoFARequest struct {
+	TwoFactorCode string `json:"TwoFactorCode"`
+}
+
+// RefreshRequest refreshes the access token
+type RefreshRequest struct {
+	UID          string `json:"UID"`
+	RefreshToken string `json:"RefreshToken"`
+	ResponseType string `json:"ResponseType"`
+	GrantType    string `json:"GrantType"`
+	RedirectURI  string `json:"RedirectURI"`
+}
+
+// Key is a private key of a user or an address
+type Key struct {
+	ID         string `json:"ID"`
+	PrivateKey string `json:"PrivateKey"`
+	Token      string `json:"Token"`     // passphrase encrypted to the user key, address keys only
+	Signature  string `json:"Signature"` // signature of the Token
+	Primary    int    `json:"Primary"`
+	Active     int    `json:"Active"`
+}
+
+// User is the logged in user
+type User struct {
+	ID        string `json:"ID"`
+	Name      string `json:"Name"`
+	UsedSpace int64  `json:"UsedSpace"`
+	MaxSpace  int64  `json:"MaxSpace"`
+	Keys      []Key  `json:"Keys"`
+}
+
+// UserResponse is returned when reading the user
+type UserResponse struct {
+	Response
+	User User `json:"User"`
+}
+
+// KeySalt is the salt for the passphrase of a user key
+type KeySalt struct {
+	ID      string `json:"ID"`
+	KeySalt string `json:"KeySalt"` // base64, empty for keys without salt
+}
+
+// KeySaltsResponse is returned when reading the key salts
+type KeySaltsResponse struct {
+	Response
+	KeySalts []KeySalt `json:"KeySalts"`
+}
+
+// Address is an email address of the user
+type Address struct {
+	ID     string `json:"ID"`
+	Email  string `json:"Email"`
+	Status int    `json:"Status"`
+	Order  int    `json:"Order"`
+	Keys   []Key  `json:"Keys"`
+}
+
+// AddressesResponse is returned when reading the addresses
+type AddressesResponse struct {
+	Response
+	Addresses []Address `json:"Addresses"`
+}
+
+// ShareRef is the main share of a volume
+type ShareRef struct {
+	ShareID string `json:"ShareID"`
+	LinkID  string `json:"LinkID"`
+}
+
+// Volume is a drive of the user
+type Volume struct {
+	VolumeID  string   `json:"VolumeID"`
+	State     int      `json:"State"`
+	UsedSpace int64    `json:"UsedSpace"`
+	Share     ShareRef `json:"Share"`
+}
+
+// VolumesResponse is returned when reading the volumes
+type VolumesResponse struct {
+	Response
+	Volumes []Volume `json:"Volumes"`
+}
+
+// Share is a tree of links with its own key
+type Share struct {
+	Response
+	ShareID             string `json:"ShareID"`
+	VolumeID            string `json:"VolumeID"`
+	LinkID              string `json:"LinkID"`
+	AddressID           string `json:"AddressID"`
+	Creator             string `json:"Creator"`
+	Key                 string `json:"Key"`
+	Passphrase          string `json:"Passphrase"` // encrypted to the address key
+	PassphraseSignature string `json:"PassphraseSignature"`
+}
+
+// Revision is a version of the contents of a file
+type Revision struct {
+	ID         string  `json:"ID"`
+	CreateTime int64   `json:"CreateTime"`
+	Size       int64   `json:"Size"`
+	State      int     `json:"State"`
+	XAttr      string  `json:"XAttr"` // extended attributes encrypted to the node key
+	Blocks     []Block `json:"Blocks"`
+}
+
+// FileProperties are the properties of a file link
+type FileProperties struct {
+	ContentKeyPacket          string    `json:"ContentKeyPacket"` // base64 key packet encrypted to the node key
+	ContentKeyPacketSignature string    `json:"ContentKeyPacketSignature"`
+	ActiveRevision            *Revision `json:"ActiveRevision"`
+}
+
+// FolderProperties are the properties of a folder link
+type FolderProperties struct {
+	NodeHashKey string `json:"NodeHashKey"` // encrypted to the node key
+}
+
+// Link is a file or a folder
+type Link struct {
+	LinkID                  string            `json:"LinkID"`
+	ParentLinkID            string            `json:"ParentLinkID"`
+	Type                    int               `json:"Type"`
+	Name                    string            `json:"Name"` // encrypted to the parent node key
+	NameSignatureEmail      string            `json:"NameSignatureEmail"`
+	Hash                    string            `json:"Hash"` // HMAC of the name with the parent hash key
+	State                   int               `json:"State"`
+	Size                    int64             `json:"Size"`
+	MIMEType                string            `json:"MIMEType"`
+	CreateTime              int64             `json:"CreateTime"`
+	ModifyTime              int64             `json:"ModifyTime"`
+	NodeKey                 string            `json:"NodeKey"`
+	NodePassphrase          string            `json:"NodePassphrase"` // encrypted to the parent node key
+	NodePassphraseSignature string            `json:"NodePassphraseSignature"`
+	SignatureEmail          string            `json:"SignatureEmail"`
+	FileProperties          *FileProperties   `json:"FileProperties"`
+	FolderProperties        *FolderProperties `json:"FolderProperties"`
+}
+
+// LinkResponse is returned when reading a link
+type LinkResponse struct {
+	Response
+	Link Link `json:"Link"`
+}
+
+// LinksResponse is returned when listing a folder
+type LinksResponse struct {
+	Response
+	Links []Link `json:"Links"`
+}
+
+// CreateFolderRequest makes a folder
+type CreateFolderRequest struct {
+	ParentLinkID            string `json:"ParentLinkID"`
+	Name                    string `json:"Name"`
+	Hash                    string `json:"Hash"`
+	NodeKey                 string `json:"NodeKey"`
+	NodePassphrase          string `json:"NodePassphrase"`
+	NodePassphraseSignature string `json:"NodePassphraseSignature"`
+	NodeHashKey             string `json:"NodeHashKey"`
+	SignatureAddress        string `json:"SignatureAddress"`
+}
+
+// CreateFolderResponse is returned after making a folder
+type CreateFolderResponse struct {
+	Response
+	Folder struct {
+		ID string `json:"ID"`
+	} `json:"Folder"`
+}
+
+// CreateFileRequest makes a file with a draft revision
+type CreateFileRequest struct {
+	ParentLinkID              string `json:"ParentLinkID"`
+	Name                      string `json:"Name"`
+	Hash                      string `json:"Hash"`
+	MIMEType                  string `json:"MIMEType"`
+	NodeKey                   string `json:"NodeKey"`
+	NodePassphrase            string `json:"NodePassphrase"`
+	NodePassphraseSignature   string `json:"NodePassphraseSignature"`
+	ContentKeyPacket          string `json:"ContentKeyPacket"`
+	ContentKeyPacketSignature string `json:"ContentKeyPacketSignature"`
+	SignatureAddress          string `json:"SignatureAddress"`
+}
+
+// CreateFileResponse is returned after making a file
+type CreateFileResponse struct {
+	Response
+	File struct {
+		ID         string `json:"ID"`
+		RevisionID string `json:"RevisionID"`
+	} `json:"File"`
+}
+
+// CreateRevisionResponse is returned after making a revision
+type CreateRevisionResponse struct {
+	Response
+	Revision struct {
+		ID string `json:"ID"`
+	} `json:"Revision"`
+}
+
+// BlockInfo describes a block to upload
+type BlockInfo struct {
+	Index        int    `json:"Index"` // from 1
+	Size         int64  `json:"Size"`
+	Hash         string `json:"Hash"`         // base64 SHA-256 of the encrypted block
+	EncSignature string `json:"EncSignature"` // signature of the plain block encrypted to the node key
+}
+
+// BlockUploadRequest asks for the links to upload blocks to
+type BlockUploadRequest struct {
+	AddressID     string      `json:"AddressID"`
+	ShareID       string      `json:"ShareID"`
+	LinkID        string      `json:"LinkID"`
+	RevisionID    string      `json:"RevisionID"`
+	BlockList     []BlockInfo `json:"BlockList"`
+	ThumbnailList []BlockInfo `json:"ThumbnailList"`
+}
+
+// UploadLink is where to upload a block to
+type UploadLink struct {
+	Token   string `json:"Token"`
+	BareURL string `json:"BareURL"`
+}
+
+// BlockUploadResponse is returned with the links to upload blocks to
+type BlockUploadResponse struct {
+	Response
+	UploadLinks []UploadLink `json:"UploadLinks"`
+}
+
+// Block is a block of a revision
+type Block struct {
+	Index        int    `json:"Index"`
+	BareURL      string `json:"BareURL"`
+	Token        string `json:"Token"`
+	Hash         string `json:"Hash"`
+	EncSignature string `json:"EncSignature"`
+}
+
+// RevisionResponse is returned when reading a revision
+type RevisionResponse struct {
+	Response
+	Revision Revision `json:"Revision"`
+}
+
+// CommitRevisionRequest makes a draft revision the active one
+type CommitRevisionRequest struct {
+	ManifestSignature string `json:"ManifestSignature"`
+	SignatureAddress  string `json:"SignatureAddress"`
+	XAttr             string `json:"XAttr"`
+}
+
+// LinkIDsRequest names links to trash or delete
+type LinkIDsRequest struct {
+	LinkIDs []string `json:"LinkIDs"`
+}
+
+// LinkIDResponse is the result for one link of a multiple request
+type LinkIDResponse struct {
+	LinkID   string `json:"LinkID"`
+	Response Error  `json:"Response"`
+}
+
+// MultiResponse is returned by requests on multiple links
+type MultiResponse struct {
+	Response
+	Responses []LinkIDResponse `json:"Responses"`
+}
+
+// MoveRequest moves a link to a new parent and name
+type MoveRequest struct {
+	ParentLinkID            string `json:"ParentLinkID"`
+	Name                    string `json:"Name"`
+	Hash                    string `json:"Hash"`
+	OriginalHash            string `json:"OriginalHash"`
+	NodePassphrase          string `json:"NodePassphrase"`
+	NodePassphraseSignature string `json:"NodePassphraseSignature"`
+	NameSignatureEmail      string `json:"NameSignatureEmail"`
+	SignatureAddress        string `json:"SignatureAddress"`
+}
+
+// XAttrCommon is the part of the extended attributes common to all
+// Proton clients
+type XAttrCommon struct {
+	ModificationTime string            `json:"ModificationTime"` // RFC 3339
+	Size             int64             `json:"Size"`
+	BlockSizes       []int64           `json:"BlockSizes"`
+	Digests          map[string]string `json:"Digests,omitempty"` // hex digests by name, e.g. "SHA1"
+}
+
+// XAttr is the decrypted extended attributes of a revision
+type XAttr struct {
+	Common XAttrCommon `json:"Common"`
+}
+
+// RenameRequest renames a link in its folder
+type RenameRequest struct {
+	Name               string `json:"Name"`
+	Hash               string `json:"Hash"`
+	OriginalHash       string `json:"OriginalHash"`
+	MIMEType           string `json:"MIMEType,omitempty"`
+	NameSignatureEmail string `json:"NameSignatureEmail"`
+	SignatureAddress   string `json:"SignatureAddress"`
+}
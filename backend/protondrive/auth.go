@@ -0,0 +1,342 @@
+package protondrive
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/protondrive/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// errNeed2FA is returned when logging in needs a 2FA code
+var errNeed2FA = errors.New("the account has two-factor authentication - run \"rclone config reconnect\" on the remote to log in")
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	errResponse := new(api.Error)
+	err := rest.DecodeJSON(resp, &errResponse)
+	if err != nil {
+		fs.Debugf(nil, "Couldn't decode error response: %v", err)
+	}
+	if errResponse.Message == "" {
+		errResponse.Message = resp.Status
+	}
+	errResponse.Status = resp.StatusCode
+	return errResponse
+}
+
+// apiErrorCode returns the Proton error code of err or 0
+func apiErrorCode(err error) int {
+	if apiErr, ok := errors.Cause(err).(*api.Error); ok {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// newAPIClient makes a client for the Proton API
+func newAPIClient(ctx context.Context, opt *Options) *rest.Client {
+	srv := rest.NewClient(fshttp.NewClient(ctx)).SetRoot(strings.TrimSuffix(opt.URL, "/"))
+	srv.SetHeader("x-pm-appversion", opt.AppVersion)
+	srv.SetErrorHandler(errorHandler)
+	return srv
+}
+
+// apiCaller calls the API like rest.Client.CallJSON
+type apiCaller func(ctx context.Context, opts *rest.Opts, request interface{}, response interface{}) (*http.Response, error)
+
+// setAuth makes srv use the session in auth
+func setAuth(srv *rest.Client, auth *api.Auth) {
+	srv.SetHeader("x-pm-uid", auth.UID)
+	srv.SetHeader("Authorization", "Bearer "+auth.AccessToken)
+}
+
+// login logs in with username and password, calling getCode to read
+// the 2FA code if the account needs one
+//
+// getCode may be nil in which case errNeed2FA is returned if the
+// account needs a code.
+func login(ctx context.Context, srv *rest.Client, username string, password []byte, getCode func() (string, error)) (*api.Auth, error) {
+	var info api.AuthInfo
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/auth/v4/info",
+	}
+	_, err := srv.CallJSON(ctx, &opts, &api.AuthInfoRequest{Username: username}, &info)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start login")
+	}
+	modulus, err := srpModulus(info.Modulus)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := srpHashPassword(info.Version, password, info.Salt, modulus)
+	if err != nil {
+		return nil, err
+	}
+	serverEphemeral, err := base64.StdEncoding.DecodeString(info.ServerEphemeral)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad server ephemeral")
+	}
+	proofs, err := srpGenerateProofs(modulus, serverEphemeral, hashedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth api.Auth
+	opts = rest.Opts{
+		Method: "POST",
+		Path:   "/auth/v4",
+	}
+	req := api.AuthRequest{
+		Username:        username,
+		ClientEphemeral: base64.StdEncoding.EncodeToString(proofs.clientEphemeral),
+		ClientProof:     base64.StdEncoding.EncodeToString(proofs.clientProof),
+		SRPSession:      info.SRPSession,
+	}
+	_, err = srv.CallJSON(ctx, &opts, &req, &auth)
+	if err != nil {
+		if apiErrorCode(err) == api.CodeHumanVerification {
+			return nil, errors.Wrap(err, "Proton wants a captcha solved - log in with a browser from this machine then try again")
+		}
+		return nil, errors.Wrap(err, "failed to log in")
+	}
+	serverProof, err := base64.StdEncoding.DecodeString(auth.ServerProof)
+	if err != nil || subtle.ConstantTimeCompare(serverProof, proofs.expectedServerProof) != 1 {
+		return nil, errors.New("the server's proof of the password is wrong - it may not be Proton")
+	}
+
+	if auth.TwoFA.Enabled&1 != 0 {
+		if getCode == nil {
+			return nil, errNeed2FA
+		}
+		code, err := getCode()
+		if err != nil {
+			return nil, err
+		}
+		opts = rest.Opts{
+			Method: "POST",
+			Path:   "/auth/v4/2fa",
+			ExtraHeaders: map[string]string{
+				"x-pm-uid":      auth.UID,
+				"Authorization": "Bearer " + auth.AccessToken,
+			},
+		}
+		_, err = srv.CallJSON(ctx, &opts, &api.TwoFARequest{TwoFactorCode: code}, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to check 2FA code")
+		}
+	}
+	return &auth, nil
+}
+
+// refresh gets a new access token with the refresh token
+func refresh(ctx context.Context, srv *rest.Client, uid, refreshToken string) (*api.Auth, error) {
+	var auth api.Auth
+	opts := rest.Opts{
+		Method:       "POST",
+		Path:         "/auth/v4/refresh",
+		ExtraHeaders: map[string]string{"x-pm-uid": uid},
+	}
+	req := api.RefreshRequest{
+		UID:          uid,
+		RefreshToken: refreshToken,
+		ResponseType: "token",
+		GrantType:    "refresh_token",
+		RedirectURI:  "https://protonmail.ch",
+	}
+	_, err := srv.CallJSON(ctx, &opts, &req, &auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refresh session")
+	}
+	if auth.UID == "" {
+		auth.UID = uid
+	}
+	return &auth, nil
+}
+
+// saltedKeyPass reads the salt of the primary key of the user and
+// returns the passphrase for the keys of the user from password
+func saltedKeyPass(ctx context.Context, call apiCaller, password []byte) ([]byte, error) {
+	var user api.UserResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/core/v4/users",
+	}
+	_, err := call(ctx, &opts, nil, &user)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read user")
+	}
+	var salts api.KeySaltsResponse
+	opts = rest.Opts{
+		Method: "GET",
+		Path:   "/core/v4/keys/salts",
+	}
+	_, err = call(ctx, &opts, nil, &salts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key salts")
+	}
+	for _, key := range user.User.Keys {
+		if key.Primary != 1 {
+			continue
+		}
+		for _, salt := range salts.KeySalts {
+			if salt.ID == key.ID {
+				return keyPassphrase(password, salt.KeySalt)
+			}
+		}
+	}
+	return nil, errors.New("no salt found for the primary key of the user")
+}
+
+// unlockAddressKeys unlocks the keys of the user with the passphrase
+// and uses them to unlock the keys of the addresses
+//
+// It returns the addresses and their unlocked keys by address ID.
+func unlockAddressKeys(ctx context.Context, call apiCaller, keyPass []byte) ([]api.Address, map[string]openpgp.EntityList, error) {
+	var user api.UserResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/core/v4/users",
+	}
+	_, err := call(ctx, &opts, nil, &user)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read user")
+	}
+	var userKeys openpgp.EntityList
+	for _, key := range user.User.Keys {
+		if key.Active != 1 {
+			continue
+		}
+		e, err := unlockKey(key.PrivateKey, keyPass)
+		if err != nil {
+			fs.Debugf(nil, "Failed to unlock user key %s: %v", key.ID, err)
+			continue
+		}
+		userKeys = append(userKeys, e)
+	}
+	if len(userKeys) == 0 {
+		return nil, nil, errors.New("failed to unlock the keys of the user - check the password or the mailbox password")
+	}
+
+	var addresses api.AddressesResponse
+	opts = rest.Opts{
+		Method: "GET",
+		Path:   "/core/v4/addresses",
+	}
+	_, err = call(ctx, &opts, nil, &addresses)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read addresses")
+	}
+	addressKeys := map[string]openpgp.EntityList{}
+	for _, address := range addresses.Addresses {
+		for _, key := range address.Keys {
+			if key.Active != 1 {
+				continue
+			}
+			// Newer address keys have their own passphrase
+			// encrypted to the user key
+			passphrase := keyPass
+			if key.Token != "" {
+				passphrase, err = decryptMessage(key.Token, userKeys)
+				if err != nil {
+					fs.Debugf(nil, "Failed to decrypt token of address key %s: %v", key.ID, err)
+					continue
+				}
+			}
+			e, err := unlockKey(key.PrivateKey, passphrase)
+			if err != nil {
+				fs.Debugf(nil, "Failed to unlock address key %s: %v", key.ID, err)
+				continue
+			}
+			// Put the primary key first to sign with
+			if key.Primary == 1 {
+				addressKeys[address.ID] = append(openpgp.EntityList{e}, addressKeys[address.ID]...)
+			} else {
+				addressKeys[address.ID] = append(addressKeys[address.ID], e)
+			}
+		}
+	}
+	return addresses.Addresses, addressKeys, nil
+}
+
+// Config logs in to Proton asking for the 2FA code if needed and
+// saves the session
+func Config(ctx context.Context, name string, m configmap.Mapper) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		fs.Errorf(nil, "Couldn't parse config: %v", err)
+		return
+	}
+	if opt.Username == "" || opt.Password == "" {
+		fs.Errorf(nil, "A username and password are required")
+		return
+	}
+	ci := fs.GetConfig(ctx)
+	getCode := func() (string, error) {
+		if ci.AutoConfirm {
+			return "", errNeed2FA
+		}
+		code := ""
+		for code == "" {
+			fmt.Print("Two-factor authentication: please enter your 2FA code\n2fa code> ")
+			code = config.ReadLine()
+		}
+		return code, nil
+	}
+	err = saveSession(ctx, opt, m, getCode)
+	if err != nil {
+		fs.Errorf(nil, "Failed to log in to Proton: %v", err)
+		return
+	}
+	fmt.Println("Logged in to Proton")
+}
+
+// saveSession logs in and saves the session and the passphrase of
+// the keys in the config
+func saveSession(ctx context.Context, opt *Options, m configmap.Mapper, getCode func() (string, error)) error {
+	password, err := obscure.Reveal(opt.Password)
+	if err != nil {
+		return errors.Wrap(err, "couldn't decrypt password")
+	}
+	srv := newAPIClient(ctx, opt)
+	auth, err := login(ctx, srv, opt.Username, []byte(password), getCode)
+	if err != nil {
+		return err
+	}
+	setAuth(srv, auth)
+	if auth.PasswordMode == api.PasswordModeTwo {
+		if opt.MailboxPassword == "" {
+			return errors.New("the account has a mailbox password - set mailbox_password")
+		}
+		password, err = obscure.Reveal(opt.MailboxPassword)
+		if err != nil {
+			return errors.Wrap(err, "couldn't decrypt mailbox password")
+		}
+	}
+	keyPass, err := saltedKeyPass(ctx, srv.CallJSON, []byte(password))
+	if err != nil {
+		return err
+	}
+	opt.UID = auth.UID
+	opt.AccessToken = auth.AccessToken
+	opt.RefreshToken = auth.RefreshToken
+	opt.SaltedKeyPass = obscure.MustObscure(string(keyPass))
+	m.Set("client_uid", opt.UID)
+	m.Set("client_access_token", opt.AccessToken)
+	m.Set("client_refresh_token", opt.RefreshToken)
+	m.Set("client_salted_key_pass", opt.SaltedKeyPass)
+	return nil
+}
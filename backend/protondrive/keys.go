@@ -0,0 +1,307 @@
+package protondrive
+
+// Proton Drive encrypts everything with OpenPGP.
+//
+// Each file and folder (a link) has its own key, the node key, locked
+// with a passphrase which is encrypted to the node key of its parent.
+// The root folder's passphrase is encrypted to the key of the share,
+// whose passphrase is encrypted to a key of an address of the user.
+//
+// The names of links are encrypted to the node key of their parent,
+// and the name is looked up with an HMAC of it keyed with the hash
+// key of the parent folder, which is encrypted to its node key.
+//
+// The contents of a file are split into blocks which are each
+// encrypted with the same session key, which is stored encrypted to
+// the node key of the file as the content key packet.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+)
+
+// pgpConfig is used for all the encryption and signing
+var pgpConfig = &packet.Config{
+	DefaultHash:            crypto.SHA256,
+	DefaultCipher:          packet.CipherAES256,
+	DefaultCompressionAlgo: packet.CompressionNone,
+	Algorithm:              packet.PubKeyAlgoEdDSA,
+}
+
+// sessionKeyCipher is the cipher of the contents of files
+const sessionKeyCipher = packet.CipherAES256
+
+// randomBytes returns n random bytes
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	return b, err
+}
+
+// randomPassphrase returns a new random passphrase for a key
+func randomPassphrase() ([]byte, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// unlockKey reads the armored private key and unlocks it with
+// passphrase
+func unlockKey(armored string, passphrase []byte) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+	if len(entities) != 1 {
+		return nil, errors.Errorf("expecting 1 key but got %d", len(entities))
+	}
+	e := entities[0]
+	if e.PrivateKey == nil {
+		return nil, errors.New("not a private key")
+	}
+	if e.PrivateKey.Encrypted {
+		err = e.PrivateKey.Decrypt(passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to unlock key")
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			err = subkey.PrivateKey.Decrypt(passphrase)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to unlock subkey")
+			}
+		}
+	}
+	return e, nil
+}
+
+// generateKey makes a new key locked with a random passphrase
+//
+// It returns the unlocked key, the armored locked key and the
+// passphrase.
+func generateKey() (key *openpgp.Entity, armored string, passphrase []byte, err error) {
+	passphrase, err = randomPassphrase()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	e, err := openpgp.NewEntity("Drive key", "", "no-reply@proton.me", pgpConfig)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "failed to make key")
+	}
+	err = e.PrivateKey.Encrypt(passphrase)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "failed to lock key")
+	}
+	for _, subkey := range e.Subkeys {
+		err = subkey.PrivateKey.Encrypt(passphrase)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to lock subkey")
+		}
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	err = e.SerializePrivateWithoutSigning(w, nil)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "failed to write key")
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	armored = buf.String()
+	// Read it back in to get an unlocked copy
+	key, err = unlockKey(armored, passphrase)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return key, armored, passphrase, nil
+}
+
+// decryptMessage decrypts the armored message with keys
+//
+// Any signature isn't checked.
+func decryptMessage(armored string, keys openpgp.EntityList) ([]byte, error) {
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read message")
+	}
+	md, err := openpgp.ReadMessage(block.Body, keys, nil, pgpConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt message")
+	}
+	data, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt message")
+	}
+	return data, nil
+}
+
+// encryptMessage encrypts plaintext to the key to and returns it
+// armored, signing it with signer if it isn't nil
+func encryptMessage(plaintext []byte, to, signer *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", err
+	}
+	w, err := openpgp.Encrypt(aw, []*openpgp.Entity{to}, signer, nil, pgpConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encrypt message")
+	}
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	err = aw.Close()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// signDetached returns the armored detached signature of data by
+// signer
+func signDetached(data []byte, signer *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&buf, signer, bytes.NewReader(data), pgpConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign")
+	}
+	return buf.String(), nil
+}
+
+// encryptedSignature returns the signature of data by signer
+// encrypted to the key to
+func encryptedSignature(data []byte, signer, to *openpgp.Entity) (string, error) {
+	var sig bytes.Buffer
+	err := openpgp.DetachSign(&sig, signer, bytes.NewReader(data), pgpConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign")
+	}
+	return encryptMessage(sig.Bytes(), to, nil)
+}
+
+// nameHash returns the hash used to look up name in a folder
+func nameHash(hashKey []byte, name string) string {
+	mac := hmac.New(sha256.New, hashKey)
+	_, _ = mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptSessionKey encrypts the session key to the key to and
+// returns the key packet
+func encryptSessionKey(sessionKey []byte, to *openpgp.Entity) ([]byte, error) {
+	encryptionKey, ok := to.EncryptionKey(time.Now())
+	if !ok {
+		return nil, errors.New("no encryption key")
+	}
+	var buf bytes.Buffer
+	err := packet.SerializeEncryptedKey(&buf, encryptionKey.PublicKey, sessionKeyCipher, sessionKey, pgpConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt session key")
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptSessionKey decrypts the session key in the key packet with
+// the key
+func decryptSessionKey(keyPacket []byte, key *openpgp.Entity) ([]byte, error) {
+	p, err := packet.Read(bytes.NewReader(keyPacket))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key packet")
+	}
+	ek, ok := p.(*packet.EncryptedKey)
+	if !ok {
+		return nil, errors.Errorf("expecting a key packet but got %T", p)
+	}
+	for _, k := range (openpgp.EntityList{key}).DecryptionKeys() {
+		if ek.KeyId != 0 && ek.KeyId != k.PublicKey.KeyId {
+			continue
+		}
+		if ek.Decrypt(k.PrivateKey, pgpConfig) == nil {
+			return ek.Key, nil
+		}
+	}
+	return nil, errors.New("failed to decrypt session key")
+}
+
+// encryptBlock encrypts the block with the session key
+func encryptBlock(plaintext, sessionKey []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	encrypted, err := packet.SerializeSymmetricallyEncrypted(&buf, sessionKeyCipher, sessionKey, pgpConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt block")
+	}
+	literal, err := packet.SerializeLiteral(encrypted, true, "", 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt block")
+	}
+	_, err = literal.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	// This closes encrypted too
+	err = literal.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptBlock decrypts the encrypted block with the session key
+func decryptBlock(encrypted, sessionKey []byte) ([]byte, error) {
+	p, err := packet.Read(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read block")
+	}
+	se, ok := p.(*packet.SymmetricallyEncrypted)
+	if !ok {
+		return nil, errors.Errorf("expecting an encrypted block but got %T", p)
+	}
+	decrypted, err := se.Decrypt(sessionKeyCipher, sessionKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt block")
+	}
+	p, err = packet.Read(decrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt block")
+	}
+	literal, ok := p.(*packet.LiteralData)
+	if !ok {
+		return nil, errors.Errorf("expecting literal data but got %T", p)
+	}
+	data, err := ioutil.ReadAll(literal.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt block")
+	}
+	// Closing checks the integrity of the block
+	err = decrypted.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt block")
+	}
+	return data, nil
+}
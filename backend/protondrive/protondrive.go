@@ -0,0 +1,1626 @@
+// Package protondrive provides an interface to the Proton Drive
+// end-to-end encrypted storage system.
+package protondrive
+
+/*
+The API isn't documented - this follows the open source Proton
+clients.
+
+Everything is encrypted on the client before it is sent so the
+server only ever sees encrypted names and contents.  See keys.go for
+how the keys fit together.
+
+Missing features:
+- thumbnails
+- verifying the signatures of other clients
+- shared folders other than the main share
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/protondrive/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	minSleep          = 10 * time.Millisecond
+	maxSleep          = 2 * time.Second
+	decayConstant     = 2               // bigger for slower decay, exponential
+	listChunks        = 150             // chunk size to read directory listings
+	blockListChunks   = 150             // chunk size to read the blocks of a revision
+	blockSize         = 4 * 1024 * 1024 // size of the blocks files are split into
+	timeFormat        = "2006-01-02T15:04:05.000Z07:00"
+	defaultAppVersion = "macos-drive@1.0.0-alpha.1+rclone"
+	defaultURL        = "https://mail.proton.me/api"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "protondrive",
+		Description: "Proton Drive",
+		NewFs:       NewFs,
+		Config:      Config,
+		Options: []fs.Option{{
+			Name:     "username",
+			Help:     "The username of your Proton account",
+			Required: true,
+		}, {
+			Name:       "password",
+			Help:       "The password of your Proton account",
+			IsPassword: true,
+			Required:   true,
+		}, {
+			Name: "mailbox_password",
+			Help: `The mailbox password of your Proton account
+
+This is only needed if the account has the two password mode turned
+on, in which case the keys are unlocked with this rather than the
+login password.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name:     "hard_delete",
+			Help:     `Delete files permanently rather than putting them into the trash.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "app_version",
+			Help: `The app version rclone tells Proton it is
+
+Proton rejects requests from apps it doesn't know about so this may
+need changing if Proton stops accepting the default.`,
+			Default:  defaultAppVersion,
+			Advanced: true,
+		}, {
+			Name:     "api_url",
+			Help:     "The URL of the Proton API",
+			Default:  defaultURL,
+			Advanced: true,
+		}, {
+			Name: "client_uid",
+			Help: "Session ID - rclone sets this automatically",
+			Hide: fs.OptionHideBoth,
+		}, {
+			Name: "client_access_token",
+			Help: "Session access token - rclone sets this automatically",
+			Hide: fs.OptionHideBoth,
+		}, {
+			Name: "client_refresh_token",
+			Help: "Session refresh token - rclone sets this automatically",
+			Hide: fs.OptionHideBoth,
+		}, {
+			Name: "client_salted_key_pass",
+			Help: "Passphrase of the keys - rclone sets this automatically",
+			Hide: fs.OptionHideBoth,
+		}, {
+			Name:     config.ConfigEncoding,
+			Help:     config.ConfigEncodingHelp,
+			Advanced: true,
+			Default: (encoder.Base |
+				encoder.EncodeInvalidUtf8 |
+				encoder.EncodeLeftSpace |
+				encoder.EncodeRightSpace),
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Username        string               `config:"username"`
+	Password        string               `config:"password"`
+	MailboxPassword string               `config:"mailbox_password"`
+	HardDelete      bool                 `config:"hard_delete"`
+	AppVersion      string               `config:"app_version"`
+	URL             string               `config:"api_url"`
+	UID             string               `config:"client_uid"`
+	AccessToken     string               `config:"client_access_token"`
+	RefreshToken    string               `config:"client_refresh_token"`
+	SaltedKeyPass   string               `config:"client_salted_key_pass"`
+	Enc             encoder.MultiEncoder `config:"encoding"`
+}
+
+// Fs represents a remote Proton Drive
+type Fs struct {
+	name           string             // name of this remote
+	root           string             // the path we are working on
+	opt            Options            // parsed options
+	features       *fs.Features       // optional features
+	m              configmap.Mapper   // to save config
+	srv            *rest.Client       // the connection to the server
+	dirCache       *dircache.DirCache // Map of directory path to directory id
+	pacer          *fs.Pacer          // pacer for API calls
+	authMu         sync.Mutex         // hold when reading or refreshing the session
+	shareID        string             // ID of the main share
+	addressID      string             // ID of the address of the share
+	signatureEmail string             // email of the address of the share
+	addressKeys    openpgp.EntityList // unlocked keys of the address, the first signs
+	shareKey       *openpgp.Entity    // unlocked key of the share
+	nodesMu        sync.Mutex         // protects nodes
+	nodes          map[string]*node   // unlocked folders by link ID
+}
+
+// node is a link with its key unlocked
+type node struct {
+	key        *openpgp.Entity // the unlocked node key
+	passphrase []byte          // the passphrase of the node key
+	hashKey    []byte          // key of the hashes of the names of the children, folders only
+}
+
+// Object describes a Proton Drive object
+type Object struct {
+	fs         *Fs       // what this object is part of
+	remote     string    // The remote path
+	link       *api.Link // the link of the object
+	id         string    // ID of the object
+	revisionID string    // ID of the active revision
+	size       int64     // size of the object
+	modTime    time.Time // modification time of the object
+	sha1       string    // SHA-1 of the object if known
+	mimeType   string    // MIME type of the object
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("Proton Drive root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+	509, // Bandwidth Limit Exceeded
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+//
+// token is the access token the request was made with - if it has
+// expired the session is refreshed and the request retried.
+func (f *Fs) shouldRetry(ctx context.Context, resp *http.Response, err error, token string) (bool, error) {
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		refreshErr := f.refreshSession(ctx, token)
+		if refreshErr != nil {
+			return false, refreshErr
+		}
+		return true, err
+	}
+	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// accessToken returns the current access token
+func (f *Fs) accessToken() string {
+	f.authMu.Lock()
+	defer f.authMu.Unlock()
+	return f.opt.AccessToken
+}
+
+// refreshSession gets a new access token if failedToken is still the
+// current one, logging in again if the refresh token has expired
+func (f *Fs) refreshSession(ctx context.Context, failedToken string) error {
+	f.authMu.Lock()
+	defer f.authMu.Unlock()
+	if f.opt.AccessToken != failedToken {
+		// Already refreshed by another request
+		return nil
+	}
+	fs.Debugf(f, "Session expired - refreshing")
+	srv := newAPIClient(ctx, &f.opt)
+	auth, err := refresh(ctx, srv, f.opt.UID, f.opt.RefreshToken)
+	if err != nil {
+		fs.Debugf(f, "Logging in again: %v", err)
+		password, err := obscure.Reveal(f.opt.Password)
+		if err != nil {
+			return errors.Wrap(err, "couldn't decrypt password")
+		}
+		auth, err = login(ctx, srv, f.opt.Username, []byte(password), nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to log in again")
+		}
+	}
+	f.opt.UID = auth.UID
+	f.opt.AccessToken = auth.AccessToken
+	f.opt.RefreshToken = auth.RefreshToken
+	f.m.Set("client_uid", f.opt.UID)
+	f.m.Set("client_access_token", f.opt.AccessToken)
+	f.m.Set("client_refresh_token", f.opt.RefreshToken)
+	setAuth(f.srv, auth)
+	return nil
+}
+
+// callJSON calls the API with retries, refreshing the session if it
+// has expired
+func (f *Fs) callJSON(ctx context.Context, opts *rest.Opts, request interface{}, response interface{}) (resp *http.Response, err error) {
+	err = f.pacer.Call(func() (bool, error) {
+		token := f.accessToken()
+		resp, err = f.srv.CallJSON(ctx, opts, request, response)
+		return f.shouldRetry(ctx, resp, err, token)
+	})
+	return resp, err
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Username == "" || opt.Password == "" {
+		return nil, errors.New("username and password must be set")
+	}
+
+	// Log in if there isn't a saved session
+	if opt.UID == "" || opt.SaltedKeyPass == "" {
+		err = saveSession(ctx, opt, m, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to log in to Proton")
+		}
+	}
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:  name,
+		root:  root,
+		opt:   *opt,
+		m:     m,
+		srv:   newAPIClient(ctx, opt),
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+		nodes: make(map[string]*node),
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		ReadMimeType:            true,
+		WriteMimeType:           false, // only set when a file is created
+	}).Fill(ctx, f)
+	setAuth(f.srv, &api.Auth{
+		UID:         opt.UID,
+		AccessToken: opt.AccessToken,
+	})
+
+	rootID, err := f.unlockShare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f.dirCache = dircache.New(root, rootID, f)
+
+	// Find the current root
+	err = f.dirCache.FindRoot(ctx, false)
+	if err != nil {
+		// Assume it is a file and point the root to the parent
+		newRoot, remote := dircache.SplitPath(root)
+		f.root = newRoot
+		f.dirCache = dircache.New(newRoot, rootID, f)
+		err = f.dirCache.FindRoot(ctx, false)
+		if err == nil {
+			_, err = f.NewObject(ctx, remote)
+			if err == nil {
+				// return an error with an fs which points to the parent
+				return f, fs.ErrorIsFile
+			}
+		}
+		if err != fs.ErrorDirNotFound && err != fs.ErrorObjectNotFound {
+			return nil, err
+		}
+		// File doesn't exist so put the root back
+		f.root = root
+		f.dirCache = dircache.New(root, rootID, f)
+	}
+	return f, nil
+}
+
+// unlockShare unlocks the keys down to the main share of the drive
+// and returns the link ID of its root folder
+func (f *Fs) unlockShare(ctx context.Context) (rootID string, err error) {
+	keyPass, err := obscure.Reveal(f.opt.SaltedKeyPass)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't decrypt key passphrase")
+	}
+	addresses, addressKeys, err := unlockAddressKeys(ctx, f.callJSON, []byte(keyPass))
+	if err != nil {
+		return "", err
+	}
+
+	var volumes api.VolumesResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/drive/volumes",
+	}
+	_, err = f.callJSON(ctx, &opts, nil, &volumes)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read volumes")
+	}
+	for _, volume := range volumes.Volumes {
+		if volume.State == 1 {
+			f.shareID = volume.Share.ShareID
+			break
+		}
+	}
+	if f.shareID == "" {
+		return "", errors.New("no Proton Drive found - open Proton Drive in a browser to create one")
+	}
+
+	var share api.Share
+	opts = rest.Opts{
+		Method: "GET",
+		Path:   "/drive/shares/" + f.shareID,
+	}
+	_, err = f.callJSON(ctx, &opts, nil, &share)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read share")
+	}
+	f.addressID = share.AddressID
+	f.addressKeys = addressKeys[share.AddressID]
+	if len(f.addressKeys) == 0 {
+		return "", errors.New("no keys found for the address of the share")
+	}
+	for _, address := range addresses {
+		if address.ID == share.AddressID {
+			f.signatureEmail = address.Email
+		}
+	}
+	passphrase, err := decryptMessage(share.Passphrase, f.addressKeys)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt share passphrase")
+	}
+	f.shareKey, err = unlockKey(share.Key, passphrase)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to unlock share key")
+	}
+
+	// Unlock the root folder
+	_, err = f.getNode(ctx, share.LinkID)
+	if err != nil {
+		return "", err
+	}
+	return share.LinkID, nil
+}
+
+// signer returns the key to sign with
+func (f *Fs) signer() *openpgp.Entity {
+	return f.addressKeys[0]
+}
+
+// readLink reads the link with the ID given
+func (f *Fs) readLink(ctx context.Context, linkID string) (*api.Link, error) {
+	var result api.LinkResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/drive/shares/" + f.shareID + "/links/" + linkID,
+	}
+	_, err := f.callJSON(ctx, &opts, nil, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read link")
+	}
+	return &result.Link, nil
+}
+
+// getNode returns the unlocked node of the folder with the ID given
+func (f *Fs) getNode(ctx context.Context, linkID string) (*node, error) {
+	f.nodesMu.Lock()
+	n := f.nodes[linkID]
+	f.nodesMu.Unlock()
+	if n != nil {
+		return n, nil
+	}
+	link, err := f.readLink(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	return f.linkNode(ctx, link)
+}
+
+// linkNode unlocks the key of the link with the key of its parent
+//
+// The nodes of folders are cached.
+func (f *Fs) linkNode(ctx context.Context, link *api.Link) (*node, error) {
+	f.nodesMu.Lock()
+	n := f.nodes[link.LinkID]
+	f.nodesMu.Unlock()
+	if n != nil {
+		return n, nil
+	}
+	parentKey := f.shareKey
+	if link.ParentLinkID != "" {
+		parent, err := f.getNode(ctx, link.ParentLinkID)
+		if err != nil {
+			return nil, err
+		}
+		parentKey = parent.key
+	}
+	passphrase, err := decryptMessage(link.NodePassphrase, openpgp.EntityList{parentKey})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt node passphrase")
+	}
+	key, err := unlockKey(link.NodeKey, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unlock node key")
+	}
+	n = &node{
+		key:        key,
+		passphrase: passphrase,
+	}
+	if link.Type == api.LinkTypeFolder {
+		if link.FolderProperties == nil {
+			return nil, errors.New("folder has no hash key")
+		}
+		n.hashKey, err = decryptMessage(link.FolderProperties.NodeHashKey, openpgp.EntityList{key})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt hash key")
+		}
+		f.nodesMu.Lock()
+		f.nodes[link.LinkID] = n
+		f.nodesMu.Unlock()
+	}
+	return n, nil
+}
+
+// decryptName returns the name of the link in the folder parent
+func (f *Fs) decryptName(link *api.Link, parent *node) (string, error) {
+	name, err := decryptMessage(link.Name, openpgp.EntityList{parent.key})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt name")
+	}
+	return f.opt.Enc.ToStandardName(string(name)), nil
+}
+
+// encryptName returns the encrypted name of leaf in the folder parent
+// and its hash
+func (f *Fs) encryptName(leaf string, parent *node) (name, hash string, err error) {
+	encoded := f.opt.Enc.FromStandardName(leaf)
+	name, err = encryptMessage([]byte(encoded), parent.key, f.signer())
+	if err != nil {
+		return "", "", err
+	}
+	return name, nameHash(parent.hashKey, encoded), nil
+}
+
+// encryptPassphrase encrypts the passphrase of a node key to the
+// folder parent and signs it
+func (f *Fs) encryptPassphrase(passphrase []byte, parent *node) (encrypted, signature string, err error) {
+	encrypted, err = encryptMessage(passphrase, parent.key, nil)
+	if err != nil {
+		return "", "", err
+	}
+	signature, err = signDetached(passphrase, f.signer())
+	if err != nil {
+		return "", "", err
+	}
+	return encrypted, signature, nil
+}
+
+// findLink finds the active link called leaf of type linkType in the
+// folder with ID directoryID
+func (f *Fs) findLink(ctx context.Context, directoryID, leaf string, linkType int) (link *api.Link, err error) {
+	parent, err := f.getNode(ctx, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	hash := nameHash(parent.hashKey, f.opt.Enc.FromStandardName(leaf))
+	_, err = f.listAll(ctx, directoryID, func(item *api.Link) bool {
+		if item.Hash == hash && item.Type == linkType {
+			link = item
+			return true
+		}
+		return false
+	})
+	return link, err
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	leaf, directoryID, err := f.dirCache.FindPath(ctx, remote, false)
+	if err != nil {
+		if err == fs.ErrorDirNotFound {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return nil, err
+	}
+	link, err := f.findLink(ctx, directoryID, leaf, api.LinkTypeFile)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	err = o.setMetaData(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// FindLeaf finds a directory of name leaf in the folder with ID pathID
+func (f *Fs) FindLeaf(ctx context.Context, pathID, leaf string) (pathIDOut string, found bool, err error) {
+	link, err := f.findLink(ctx, pathID, leaf, api.LinkTypeFolder)
+	if err != nil || link == nil {
+		return "", false, err
+	}
+	return link.LinkID, true, nil
+}
+
+// CreateDir makes a directory with pathID as parent and name leaf
+func (f *Fs) CreateDir(ctx context.Context, pathID, leaf string) (newID string, err error) {
+	parent, err := f.getNode(ctx, pathID)
+	if err != nil {
+		return "", err
+	}
+	name, hash, err := f.encryptName(leaf, parent)
+	if err != nil {
+		return "", err
+	}
+	key, armoredKey, passphrase, err := generateKey()
+	if err != nil {
+		return "", err
+	}
+	nodePassphrase, nodePassphraseSignature, err := f.encryptPassphrase(passphrase, parent)
+	if err != nil {
+		return "", err
+	}
+	hashKey, err := randomPassphrase()
+	if err != nil {
+		return "", err
+	}
+	nodeHashKey, err := encryptMessage(hashKey, key, f.signer())
+	if err != nil {
+		return "", err
+	}
+	req := api.CreateFolderRequest{
+		ParentLinkID:            pathID,
+		Name:                    name,
+		Hash:                    hash,
+		NodeKey:                 armoredKey,
+		NodePassphrase:          nodePassphrase,
+		NodePassphraseSignature: nodePassphraseSignature,
+		NodeHashKey:             nodeHashKey,
+		SignatureAddress:        f.signatureEmail,
+	}
+	var result api.CreateFolderResponse
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/drive/shares/" + f.shareID + "/folders",
+	}
+	_, err = f.callJSON(ctx, &opts, &req, &result)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create directory")
+	}
+	f.nodesMu.Lock()
+	f.nodes[result.Folder.ID] = &node{
+		key:        key,
+		passphrase: passphrase,
+		hashKey:    hashKey,
+	}
+	f.nodesMu.Unlock()
+	return result.Folder.ID, nil
+}
+
+// listAllFn is the user function to process a Link from listAll
+//
+// Should return true to finish processing
+type listAllFn func(*api.Link) bool
+
+// Lists the active links in the folder calling the user function on
+// each one
+//
+// If the user fn ever returns true then it early exits with found = true
+func (f *Fs) listAll(ctx context.Context, dirID string, fn listAllFn) (found bool, err error) {
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/drive/shares/" + f.shareID + "/folders/" + dirID + "/children",
+		Parameters: url.Values{
+			"PageSize": {strconv.Itoa(listChunks)},
+		},
+	}
+	for page := 0; ; page++ {
+		opts.Parameters.Set("Page", strconv.Itoa(page))
+		var result api.LinksResponse
+		_, err = f.callJSON(ctx, &opts, nil, &result)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to list directory")
+		}
+		for i := range result.Links {
+			link := &result.Links[i]
+			if link.State != api.LinkStateActive {
+				continue
+			}
+			if fn(link) {
+				return true, nil
+			}
+		}
+		if len(result.Links) < listChunks {
+			break
+		}
+	}
+	return false, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	directoryID, err := f.dirCache.FindDir(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := f.getNode(ctx, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	var iErr error
+	_, err = f.listAll(ctx, directoryID, func(link *api.Link) bool {
+		name, err := f.decryptName(link, parent)
+		if err != nil {
+			iErr = err
+			return true
+		}
+		remote := path.Join(dir, name)
+		switch link.Type {
+		case api.LinkTypeFolder:
+			// cache the directory ID for later lookups
+			f.dirCache.Put(remote, link.LinkID)
+			d := fs.NewDir(remote, time.Unix(link.ModifyTime, 0)).SetID(link.LinkID)
+			entries = append(entries, d)
+		case api.LinkTypeFile:
+			o := &Object{
+				fs:     f,
+				remote: remote,
+			}
+			err = o.setMetaData(ctx, link)
+			if err != nil {
+				iErr = err
+				return true
+			}
+			entries = append(entries, o)
+		default:
+			fs.Debugf(f, "Ignoring %q - unknown type %d", name, link.Type)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iErr != nil {
+		return nil, iErr
+	}
+	return entries, nil
+}
+
+// Put the object
+//
+// # Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	existingObj, err := f.NewObject(ctx, src.Remote())
+	switch err {
+	case nil:
+		return existingObj, existingObj.Update(ctx, in, src, options...)
+	case fs.ErrorObjectNotFound:
+		// Not found so create it
+		o := &Object{
+			fs:     f,
+			remote: src.Remote(),
+		}
+		return o, o.Update(ctx, in, src, options...)
+	default:
+		return nil, err
+	}
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir creates the container if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	_, err := f.dirCache.FindDir(ctx, dir, true)
+	return err
+}
+
+// linksRequest does a request on multiple links in the share,
+// checking the result for each of them
+func (f *Fs) linksRequest(ctx context.Context, path string, linkIDs ...string) error {
+	var result api.MultiResponse
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/drive/shares/" + f.shareID + path,
+	}
+	_, err := f.callJSON(ctx, &opts, &api.LinkIDsRequest{LinkIDs: linkIDs}, &result)
+	if err != nil {
+		return err
+	}
+	for _, item := range result.Responses {
+		if item.Response.Code != api.CodeOK {
+			itemErr := item.Response
+			return &itemErr
+		}
+	}
+	return nil
+}
+
+// remove puts the link in the folder parentID into the trash,
+// deleting it from there too if hard_delete is set
+func (f *Fs) remove(ctx context.Context, parentID, linkID string) error {
+	err := f.linksRequest(ctx, "/folders/"+parentID+"/trash_multiple", linkID)
+	if err != nil {
+		return errors.Wrap(err, "failed to trash")
+	}
+	if f.opt.HardDelete {
+		err = f.linksRequest(ctx, "/trash/delete_multiple", linkID)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete from trash")
+		}
+	}
+	return nil
+}
+
+// purgeCheck removes the root directory, if check is set then it
+// refuses to do so if it has anything in
+func (f *Fs) purgeCheck(ctx context.Context, dir string, check bool) error {
+	root := path.Join(f.root, dir)
+	if root == "" {
+		return errors.New("can't purge root directory")
+	}
+	rootID, err := f.dirCache.FindDir(ctx, dir, false)
+	if err != nil {
+		return err
+	}
+
+	if check {
+		found, err := f.listAll(ctx, rootID, func(link *api.Link) bool {
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			return fs.ErrorDirectoryNotEmpty
+		}
+	}
+
+	link, err := f.readLink(ctx, rootID)
+	if err != nil {
+		return err
+	}
+	err = f.remove(ctx, link.ParentLinkID, rootID)
+	f.dirCache.FlushDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to remove directory")
+	}
+	return nil
+}
+
+// Rmdir deletes the root folder
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.purgeCheck(ctx, dir, true)
+}
+
+// Purge deletes all the files and the container
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	return f.purgeCheck(ctx, dir, false)
+}
+
+// Precision return the precision of this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Millisecond
+}
+
+// move renames the link to leaf in the folder dstDirectoryID
+//
+// The name is encrypted to the new parent and if the parent changes
+// the passphrase of the node key is too.
+func (f *Fs) move(ctx context.Context, link *api.Link, n *node, dstDirectoryID, leaf string) error {
+	parent, err := f.getNode(ctx, dstDirectoryID)
+	if err != nil {
+		return err
+	}
+	name, hash, err := f.encryptName(leaf, parent)
+	if err != nil {
+		return err
+	}
+	var (
+		opts = rest.Opts{
+			Method: "PUT",
+			Path:   "/drive/shares/" + f.shareID + "/links/" + link.LinkID,
+		}
+		request interface{}
+	)
+	if link.ParentLinkID == dstDirectoryID {
+		req := api.RenameRequest{
+			Name:               name,
+			Hash:               hash,
+			OriginalHash:       link.Hash,
+			NameSignatureEmail: f.signatureEmail,
+			SignatureAddress:   f.signatureEmail,
+		}
+		if link.Type == api.LinkTypeFile {
+			req.MIMEType = link.MIMEType
+		}
+		opts.Path += "/rename"
+		request = &req
+	} else {
+		nodePassphrase, nodePassphraseSignature, err := f.encryptPassphrase(n.passphrase, parent)
+		if err != nil {
+			return err
+		}
+		opts.Path += "/move"
+		request = &api.MoveRequest{
+			ParentLinkID:            dstDirectoryID,
+			Name:                    name,
+			Hash:                    hash,
+			OriginalHash:            link.Hash,
+			NodePassphrase:          nodePassphrase,
+			NodePassphraseSignature: nodePassphraseSignature,
+			NameSignatureEmail:      f.signatureEmail,
+			SignatureAddress:        f.signatureEmail,
+		}
+	}
+	var result api.Response
+	_, err = f.callJSON(ctx, &opts, request, &result)
+	if err != nil {
+		return errors.Wrap(err, "failed to move")
+	}
+	return nil
+}
+
+// Move src to this remote using server side move operations.
+//
+// # This is stored with the remote path given
+//
+// # It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	dstLeaf, dstDirectoryID, err := f.dirCache.FindPath(ctx, remote, true)
+	if err != nil {
+		return nil, err
+	}
+	n, err := f.linkNode(ctx, srcObj.link)
+	if err != nil {
+		return nil, err
+	}
+	err = f.move(ctx, srcObj.link, n, dstDirectoryID, dstLeaf)
+	if err != nil {
+		return nil, err
+	}
+	link, err := f.readLink(ctx, srcObj.id)
+	if err != nil {
+		return nil, err
+	}
+	dstObj := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	err = dstObj.setMetaData(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return dstObj, nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+
+	srcID, _, _, dstDirectoryID, dstLeaf, err := f.dirCache.DirMove(ctx, srcFs.dirCache, srcFs.root, srcRemote, f.root, dstRemote)
+	if err != nil {
+		return err
+	}
+	link, err := f.readLink(ctx, srcID)
+	if err != nil {
+		return err
+	}
+	n, err := f.getNode(ctx, srcID)
+	if err != nil {
+		return err
+	}
+	err = f.move(ctx, link, n, dstDirectoryID, dstLeaf)
+	if err != nil {
+		return err
+	}
+	srcFs.dirCache.FlushDir(srcRemote)
+	return nil
+}
+
+// About gets quota information
+func (f *Fs) About(ctx context.Context) (usage *fs.Usage, err error) {
+	var user api.UserResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/core/v4/users",
+	}
+	_, err = f.callJSON(ctx, &opts, nil, &user)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read user")
+	}
+	usage = &fs.Usage{
+		Total: fs.NewUsageValue(user.User.MaxSpace),
+		Used:  fs.NewUsageValue(user.User.UsedSpace),
+		Free:  fs.NewUsageValue(user.User.MaxSpace - user.User.UsedSpace),
+	}
+	return usage, nil
+}
+
+// CleanUp empties the trash
+func (f *Fs) CleanUp(ctx context.Context) error {
+	var result api.Response
+	opts := rest.Opts{
+		Method: "DELETE",
+		Path:   "/drive/shares/" + f.shareID + "/trash",
+	}
+	_, err := f.callJSON(ctx, &opts, nil, &result)
+	if err != nil {
+		return errors.Wrap(err, "failed to empty trash")
+	}
+	return nil
+}
+
+// DirCacheFlush resets the directory cache - used in testing as an
+// optional interface
+func (f *Fs) DirCacheFlush() {
+	f.dirCache.ResetRoot()
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.SHA1)
+}
+
+// upload is a draft revision of a file being uploaded
+type upload struct {
+	linkID     string // ID of the file
+	revisionID string // ID of the draft revision
+	node       *node  // the node of the file
+	sessionKey []byte // the key the blocks are encrypted with
+}
+
+// createFile makes a new file called leaf with a draft revision in
+// the folder directoryID
+func (f *Fs) createFile(ctx context.Context, directoryID, leaf, mimeType string) (*upload, error) {
+	parent, err := f.getNode(ctx, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	name, hash, err := f.encryptName(leaf, parent)
+	if err != nil {
+		return nil, err
+	}
+	key, armoredKey, passphrase, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	nodePassphrase, nodePassphraseSignature, err := f.encryptPassphrase(passphrase, parent)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	keyPacket, err := encryptSessionKey(sessionKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyPacketSignature, err := signDetached(sessionKey, key)
+	if err != nil {
+		return nil, err
+	}
+	req := api.CreateFileRequest{
+		ParentLinkID:              directoryID,
+		Name:                      name,
+		Hash:                      hash,
+		MIMEType:                  mimeType,
+		NodeKey:                   armoredKey,
+		NodePassphrase:            nodePassphrase,
+		NodePassphraseSignature:   nodePassphraseSignature,
+		ContentKeyPacket:          base64.StdEncoding.EncodeToString(keyPacket),
+		ContentKeyPacketSignature: keyPacketSignature,
+		SignatureAddress:          f.signatureEmail,
+	}
+	var result api.CreateFileResponse
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/drive/shares/" + f.shareID + "/files",
+	}
+	_, err = f.callJSON(ctx, &opts, &req, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create file")
+	}
+	return &upload{
+		linkID:     result.File.ID,
+		revisionID: result.File.RevisionID,
+		node: &node{
+			key:        key,
+			passphrase: passphrase,
+		},
+		sessionKey: sessionKey,
+	}, nil
+}
+
+// createRevision makes a draft revision of the existing object
+func (o *Object) createRevision(ctx context.Context) (*upload, error) {
+	n, err := o.fs.linkNode(ctx, o.link)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey, err := o.sessionKey(n)
+	if err != nil {
+		return nil, err
+	}
+	var result api.CreateRevisionResponse
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/drive/shares/" + o.fs.shareID + "/files/" + o.id + "/revisions",
+	}
+	_, err = o.fs.callJSON(ctx, &opts, nil, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create revision")
+	}
+	return &upload{
+		linkID:     o.id,
+		revisionID: result.Revision.ID,
+		node:       n,
+		sessionKey: sessionKey,
+	}, nil
+}
+
+// uploadBlock uploads the encrypted block to the link given
+func (f *Fs) uploadBlock(ctx context.Context, link *api.UploadLink, block []byte) error {
+	var result api.Response
+	opts := rest.Opts{
+		Method:               "POST",
+		RootURL:              link.BareURL,
+		MultipartContentName: "Block",
+		MultipartFileName:    "blob",
+		ExtraHeaders: map[string]string{
+			"pm-storage-token": link.Token,
+		},
+	}
+	return f.pacer.Call(func() (bool, error) {
+		// Refresh the body each retry
+		opts.Body = bytes.NewReader(block)
+		token := f.accessToken()
+		resp, err := f.srv.CallJSON(ctx, &opts, nil, &result)
+		return f.shouldRetry(ctx, resp, err, token)
+	})
+}
+
+// uploadBlocks encrypts and uploads in as the blocks of the revision
+//
+// It returns the extended attributes of the revision and the
+// manifest of the hashes of the blocks to sign.
+func (f *Fs) uploadBlocks(ctx context.Context, in io.Reader, up *upload) (xattr *api.XAttr, manifest []byte, err error) {
+	xattr = new(api.XAttr)
+	sha1Hash := sha1.New()
+	buf := make([]byte, blockSize)
+	for index := 1; ; index++ {
+		n, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, nil, errors.Wrap(err, "failed to read source")
+		}
+		plaintext := buf[:n]
+		_, _ = sha1Hash.Write(plaintext)
+		encrypted, err := encryptBlock(plaintext, up.sessionKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		encSignature, err := encryptedSignature(plaintext, f.signer(), up.node.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		sum := sha256.Sum256(encrypted)
+		req := api.BlockUploadRequest{
+			AddressID:  f.addressID,
+			ShareID:    f.shareID,
+			LinkID:     up.linkID,
+			RevisionID: up.revisionID,
+			BlockList: []api.BlockInfo{{
+				Index:        index,
+				Size:         int64(len(encrypted)),
+				Hash:         base64.StdEncoding.EncodeToString(sum[:]),
+				EncSignature: encSignature,
+			}},
+			ThumbnailList: []api.BlockInfo{},
+		}
+		var result api.BlockUploadResponse
+		opts := rest.Opts{
+			Method: "POST",
+			Path:   "/drive/blocks",
+		}
+		_, err = f.callJSON(ctx, &opts, &req, &result)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to request block upload")
+		}
+		if len(result.UploadLinks) != 1 {
+			return nil, nil, errors.Errorf("expecting 1 upload link but got %d", len(result.UploadLinks))
+		}
+		err = f.uploadBlock(ctx, &result.UploadLinks[0], encrypted)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to upload block")
+		}
+		manifest = append(manifest, sum[:]...)
+		xattr.Common.Size += int64(n)
+		xattr.Common.BlockSizes = append(xattr.Common.BlockSizes, int64(n))
+		if n < blockSize {
+			break
+		}
+	}
+	xattr.Common.Digests = map[string]string{
+		"SHA1": hex.EncodeToString(sha1Hash.Sum(nil)),
+	}
+	return xattr, manifest, nil
+}
+
+// commit makes the uploaded revision the active one
+func (f *Fs) commit(ctx context.Context, up *upload, xattr *api.XAttr, manifest []byte) error {
+	xattrJSON, err := json.Marshal(xattr)
+	if err != nil {
+		return err
+	}
+	encryptedXAttr, err := encryptMessage(xattrJSON, up.node.key, f.signer())
+	if err != nil {
+		return err
+	}
+	manifestSignature, err := signDetached(manifest, f.signer())
+	if err != nil {
+		return err
+	}
+	req := api.CommitRevisionRequest{
+		ManifestSignature: manifestSignature,
+		SignatureAddress:  f.signatureEmail,
+		XAttr:             encryptedXAttr,
+	}
+	var result api.Response
+	opts := rest.Opts{
+		Method: "PUT",
+		Path:   "/drive/shares/" + f.shareID + "/files/" + up.linkID + "/revisions/" + up.revisionID,
+	}
+	_, err = f.callJSON(ctx, &opts, &req, &result)
+	if err != nil {
+		return errors.Wrap(err, "failed to commit revision")
+	}
+	return nil
+}
+
+// readBlocks reads the blocks of the revision from index from on
+func (f *Fs) readBlocks(ctx context.Context, linkID, revisionID string, from int) (blocks []api.Block, err error) {
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/drive/shares/" + f.shareID + "/files/" + linkID + "/revisions/" + revisionID,
+		Parameters: url.Values{
+			"PageSize": {strconv.Itoa(blockListChunks)},
+		},
+	}
+	for {
+		opts.Parameters.Set("FromBlockIndex", strconv.Itoa(from))
+		var result api.RevisionResponse
+		_, err = f.callJSON(ctx, &opts, nil, &result)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read revision")
+		}
+		blocks = append(blocks, result.Revision.Blocks...)
+		if len(result.Revision.Blocks) < blockListChunks {
+			break
+		}
+		from += len(result.Revision.Blocks)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Index < blocks[j].Index
+	})
+	return blocks, nil
+}
+
+// downloadBlock downloads, checks and decrypts the block
+func (f *Fs) downloadBlock(ctx context.Context, block *api.Block, sessionKey []byte) ([]byte, error) {
+	var encrypted []byte
+	opts := rest.Opts{
+		Method:  "GET",
+		RootURL: block.BareURL,
+		ExtraHeaders: map[string]string{
+			"pm-storage-token": block.Token,
+		},
+	}
+	err := f.pacer.Call(func() (bool, error) {
+		token := f.accessToken()
+		resp, err := f.srv.Call(ctx, &opts)
+		if err == nil {
+			encrypted, err = ioutil.ReadAll(resp.Body)
+			fs.CheckClose(resp.Body, &err)
+		}
+		return f.shouldRetry(ctx, resp, err, token)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download block")
+	}
+	if block.Hash != "" {
+		sum := sha256.Sum256(encrypted)
+		if base64.StdEncoding.EncodeToString(sum[:]) != block.Hash {
+			return nil, errors.Errorf("block %d is corrupted", block.Index)
+		}
+	}
+	return decryptBlock(encrypted, sessionKey)
+}
+
+// blockReader reads the blocks of a revision in turn
+type blockReader struct {
+	ctx        context.Context
+	f          *Fs
+	blocks     []api.Block // blocks still to read
+	sessionKey []byte      // key the blocks are encrypted with
+	skip       int         // bytes to skip at the start of the next block
+	buf        []byte      // decrypted data not yet read
+}
+
+// Read reads decrypted data from the blocks
+func (r *blockReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if len(r.blocks) == 0 {
+			return 0, io.EOF
+		}
+		block := &r.blocks[0]
+		r.blocks = r.blocks[1:]
+		r.buf, err = r.f.downloadBlock(r.ctx, block, r.sessionKey)
+		if err != nil {
+			return 0, err
+		}
+		if r.skip > len(r.buf) {
+			r.skip = len(r.buf)
+		}
+		r.buf = r.buf[r.skip:]
+		r.skip = 0
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close the reader
+func (r *blockReader) Close() error {
+	r.blocks = nil
+	r.buf = nil
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash of the object in the requested format as a lowercase hex string
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	if t != hash.SHA1 {
+		return "", hash.ErrUnsupported
+	}
+	return o.sha1, nil
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// setMetaData sets the metadata from the link, decrypting the
+// extended attributes of the active revision
+func (o *Object) setMetaData(ctx context.Context, link *api.Link) error {
+	if link.Type != api.LinkTypeFile {
+		return errors.Wrapf(fs.ErrorNotAFile, "%q", o.remote)
+	}
+	o.link = link
+	o.id = link.LinkID
+	o.revisionID = ""
+	o.size = link.Size
+	o.modTime = time.Unix(link.ModifyTime, 0)
+	o.sha1 = ""
+	o.mimeType = link.MIMEType
+	if link.FileProperties == nil || link.FileProperties.ActiveRevision == nil {
+		return nil
+	}
+	revision := link.FileProperties.ActiveRevision
+	o.revisionID = revision.ID
+	if revision.XAttr == "" {
+		// Only the encrypted size is known
+		return nil
+	}
+	n, err := o.fs.linkNode(ctx, link)
+	if err != nil {
+		return err
+	}
+	xattrJSON, err := decryptMessage(revision.XAttr, openpgp.EntityList{n.key})
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt extended attributes")
+	}
+	var xattr api.XAttr
+	err = json.Unmarshal(xattrJSON, &xattr)
+	if err != nil {
+		return errors.Wrap(err, "failed to read extended attributes")
+	}
+	if xattr.Common.Size > 0 || len(xattr.Common.BlockSizes) > 0 {
+		o.size = xattr.Common.Size
+	}
+	if modTime, err := time.Parse(time.RFC3339, xattr.Common.ModificationTime); err == nil {
+		o.modTime = modTime
+	}
+	o.sha1 = strings.ToLower(xattr.Common.Digests["SHA1"])
+	return nil
+}
+
+// sessionKey decrypts the key the contents are encrypted with
+func (o *Object) sessionKey(n *node) ([]byte, error) {
+	if o.link.FileProperties == nil {
+		return nil, errors.New("file has no content key")
+	}
+	keyPacket, err := base64.StdEncoding.DecodeString(o.link.FileProperties.ContentKeyPacket)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad content key")
+	}
+	return decryptSessionKey(keyPacket, n.key)
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime sets the modification time of the local fs object
+//
+// The modification time is part of a revision so it can't be changed
+// without uploading the file again.
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	if o.revisionID == "" {
+		return nil, errors.New("can't download - no revision")
+	}
+	var offset, limit int64 = 0, -1
+	fs.FixRangeOption(options, o.size)
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	n, err := o.fs.linkNode(ctx, o.link)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey, err := o.sessionKey(n)
+	if err != nil {
+		return nil, err
+	}
+	// All the blocks but the last are the same size
+	blocks, err := o.fs.readBlocks(ctx, o.id, o.revisionID, int(offset/blockSize)+1)
+	if err != nil {
+		return nil, err
+	}
+	in = &blockReader{
+		ctx:        ctx,
+		f:          o.fs,
+		blocks:     blocks,
+		sessionKey: sessionKey,
+		skip:       int(offset % blockSize),
+	}
+	if limit >= 0 {
+		in = readers.NewLimitedReadCloser(in, limit)
+	}
+	return in, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// # If existing is set then it updates the object rather than creating a new one
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	f := o.fs
+	modTime := src.ModTime(ctx)
+
+	// Create the directory for the object if it doesn't exist
+	leaf, directoryID, err := f.dirCache.FindPath(ctx, o.remote, true)
+	if err != nil {
+		return err
+	}
+
+	// Make a new file or a new revision of this one
+	var up *upload
+	created := o.link == nil
+	if created {
+		up, err = f.createFile(ctx, directoryID, leaf, fs.MimeType(ctx, src))
+	} else {
+		up, err = o.createRevision(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remove the draft if aborted or it fails
+	committed := false
+	defer atexit.OnError(&err, func() {
+		if committed {
+			return
+		}
+		fs.Debugf(o, "Removing draft after failed upload")
+		var dErr error
+		if created {
+			dErr = f.linksRequest(ctx, "/folders/"+directoryID+"/delete_multiple", up.linkID)
+		} else {
+			opts := rest.Opts{
+				Method: "DELETE",
+				Path:   "/drive/shares/" + f.shareID + "/files/" + up.linkID + "/revisions/" + up.revisionID,
+			}
+			var result api.Response
+			_, dErr = f.callJSON(ctx, &opts, nil, &result)
+		}
+		if dErr != nil {
+			fs.Errorf(o, "failed to remove draft: %v", dErr)
+		}
+	})()
+
+	xattr, manifest, err := f.uploadBlocks(ctx, in, up)
+	if err != nil {
+		return err
+	}
+	xattr.Common.ModificationTime = modTime.UTC().Format(timeFormat)
+	err = f.commit(ctx, up, xattr, manifest)
+	if err != nil {
+		return err
+	}
+	committed = true
+
+	link, err := f.readLink(ctx, up.linkID)
+	if err != nil {
+		return err
+	}
+	return o.setMetaData(ctx, link)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return o.fs.remove(ctx, o.link.ParentLinkID, o.id)
+}
+
+// ID returns the ID of the Object if known, or "" if not
+func (o *Object) ID() string {
+	return o.id
+}
+
+// MimeType returns the content type of the Object if
+// known, or "" if not
+func (o *Object) MimeType(ctx context.Context) string {
+	return o.mimeType
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.IDer            = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+)
@@ -0,0 +1,801 @@
+package protondrive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/protondrive/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test vectors from github.com/ProtonMail/go-srp
+const (
+	testSignedModulus = `-----BEGIN PGP SIGNED MESSAGE-----
+Hash: SHA256
+
+W2z5HBi8RvsfYzZTS7qBaUxxPhsfHJFZpu3Kd6s1JafNrCCH9rfvPLrfuqocxWPgWDH2R8neK7PkNvjxto9TStuY5z7jAzWRvFWN9cQhAKkdWgy0JY6ywVn22+HFpF4cYesHrqFIKUPDMSSIlWjBVmEJZ/MusD44ZT29xcPrOqeZvwtCffKtGAIjLYPZIEbZKnDM1Dm3q2K/xS5h+xdhjnndhsrkwm9U9oyA2wxzSXFL+pdfj2fOdRwuR5nW0J2NFrq3kJjkRmpO/Genq1UW+TEknIWAb6VzJJJA244K/H8cnSx2+nSNZO3bbo6Ys228ruV9A8m6DhxmS+bihN3ttQ==
+-----BEGIN PGP SIGNATURE-----
+Version: ProtonMail
+Comment: https://protonmail.com
+
+wl4EARYIABAFAlwB1j0JEDUFhcTpUY8mAAD8CgEAnsFnF4cF0uSHKkXa1GIa
+GO86yMV4zDZEZcDSJo0fgr8A/AlupGN9EdHlsrZLmTA1vhIx+rOgxdEff28N
+kvNM7qIK
+=q6vu
+-----END PGP SIGNATURE-----`
+	testServerEphemeral = "l13IQSVFBEV0ZZREuRQ4ZgP6OpGiIfIjbSDYQG3Yp39FkT2B/k3n1ZhwqrAdy+qvPPFq/le0b7UDtayoX4aOTJihoRvifas8Hr3icd9nAHqd0TUBbkZkT6Iy6UpzmirCXQtEhvGQIdOLuwvy+vZWh24G2ahBM75dAqwkP961EJMh67/I5PA5hJdQZjdPT5luCyVa7BS1d9ZdmuR0/VCjUOdJbYjgtIH7BQoZs+KacjhUN8gybu+fsycvTK3eC+9mCN2Y6GdsuCMuR3pFB0RF9eKae7cA6RbJfF1bjm0nNfWLXzgKguKBOeF3GEAsnCgK68q82/pq9etiUDizUlUBcA=="
+	testClientProof     = "Qb+1+jEqHRqpJ3nEJX2FEj0kXgCIWHngO0eT4R2Idkwke/ceCIUmQa0RfTYU53ybO1AVergtb7N0W/3bathdHT9FAHhy0vDGQDg/yPnuUneqV76NuU+pQHnO83gcjmZjDq/zvRRSD7dtIORRK97xhdR9W9bG5XRGr2c9Zev40YVcXgUiNUG/0zHSKQfEhUpMKxdauKtGC+dZnZzU6xaU0qvulYEsraawurRf0b1VXwohM6KE52Fj5xlS2FWZ3Mg0WIOC5KW5ziI6QirEUDK2pH/Rxvu4HcW9aMuppUmHk9Bm6kdg99o3vl0G7OgmEI7y6iyEYmXqH44XGORJ2sDMxQ=="
+	testServerProof     = "SLCSIClioSAtozauZZzcJuVPyY+MjnxfJSgEe9y6RafgjlPqnhQTZclRKPGsEhxVyWan7PIzhL+frPyZNaE1QaV5zbqz1yf9RXpGyTjZwU3FuVCJpkhp6iiCK3Wd2SemxawFXC06dgAdJ7I3HKvfkXeMANOUUh5ofjnJtXg42OGp4x1lKoFcH+IbB/CvRNQCmRTyhOiBJmZyUFwxHXLT/h+PlD0XSehcyybIIBIsscQ7ZPVPxQw4BqlqoYzTjjXPJxLxeQUQm2g9bPzT+izuR0VOPDtjt+dXrWny90k2nzS0Bs2YvNIqbJn1aQwFZr42p/O1I9n5S3mYtMgGk/7b1g=="
+)
+
+func TestSRP(t *testing.T) {
+	oldRandom := srpRandom
+	defer func() { srpRandom = oldRandom }()
+	srpRandom = rand.New(rand.NewSource(42))
+
+	modulus, err := srpModulus(testSignedModulus)
+	require.NoError(t, err)
+	hashed, err := srpHashPassword(4, []byte("abc123"), "yKlc5/CvObfoiw==", modulus)
+	require.NoError(t, err)
+	serverEphemeral, err := base64.StdEncoding.DecodeString(testServerEphemeral)
+	require.NoError(t, err)
+	proofs, err := srpGenerateProofs(modulus, serverEphemeral, hashed)
+	require.NoError(t, err)
+	assert.Equal(t, testClientProof, base64.StdEncoding.EncodeToString(proofs.clientProof))
+	assert.Equal(t, testServerProof, base64.StdEncoding.EncodeToString(proofs.expectedServerProof))
+
+	_, err = srpHashPassword(2, []byte("abc123"), "yKlc5/CvObfoiw==", modulus)
+	assert.Error(t, err)
+
+	// A tampered modulus must fail the signature check
+	_, err = srpModulus(strings.Replace(testSignedModulus, "W2z5", "W2z6", 1))
+	assert.Error(t, err)
+}
+
+func TestBcrypt(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	hashed, err := bcryptHash([]byte("password"), salt)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(hashed), "$2y$10$"))
+	assert.NoError(t, bcrypt.CompareHashAndPassword(hashed, []byte("password")))
+	assert.Error(t, bcrypt.CompareHashAndPassword(hashed, []byte("Password")))
+
+	passphrase, err := keyPassphrase([]byte("password"), base64.StdEncoding.EncodeToString(salt))
+	require.NoError(t, err)
+	assert.Equal(t, string(hashed[len(hashed)-31:]), string(passphrase))
+
+	_, err = bcryptHash([]byte("password"), salt[:15])
+	assert.Error(t, err)
+}
+
+func TestKeys(t *testing.T) {
+	key, armored, passphrase, err := generateKey()
+	require.NoError(t, err)
+	assert.Contains(t, armored, "PGP PRIVATE KEY BLOCK")
+	_, err = unlockKey(armored, []byte("wrong"))
+	assert.Error(t, err)
+	unlocked, err := unlockKey(armored, passphrase)
+	require.NoError(t, err)
+
+	message, err := encryptMessage([]byte("hello"), key, key)
+	require.NoError(t, err)
+	plaintext, err := decryptMessage(message, openpgp.EntityList{unlocked})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+
+	sessionKey, err := randomBytes(32)
+	require.NoError(t, err)
+	keyPacket, err := encryptSessionKey(sessionKey, key)
+	require.NoError(t, err)
+	decryptedKey, err := decryptSessionKey(keyPacket, unlocked)
+	require.NoError(t, err)
+	assert.Equal(t, sessionKey, decryptedKey)
+
+	block, err := encryptBlock([]byte("block data"), sessionKey)
+	require.NoError(t, err)
+	plaintext, err = decryptBlock(block, decryptedKey)
+	require.NoError(t, err)
+	assert.Equal(t, "block data", string(plaintext))
+	block[len(block)-5] ^= 1
+	_, err = decryptBlock(block, decryptedKey)
+	assert.Error(t, err)
+
+	assert.Equal(t, nameHash([]byte("key"), "name"), nameHash([]byte("key"), "name"))
+	assert.NotEqual(t, nameHash([]byte("key"), "name"), nameHash([]byte("key2"), "name"))
+}
+
+// fakeRevision is a revision of a file in the fake drive
+type fakeRevision struct {
+	linkID string
+	state  int
+	blocks map[int]string // storage tokens by index
+}
+
+// fakeBlock is a block announced to be uploaded
+type fakeBlock struct {
+	hash string
+	data []byte
+}
+
+// fakeServer is a fake Proton API serving one drive
+//
+// Like the real thing it can't decrypt anything it stores.
+type fakeServer struct {
+	t            *testing.T
+	server       *httptest.Server
+	mu           sync.Mutex
+	uid          string
+	accessToken  string
+	refreshToken string
+	keyPass      []byte
+	userKey      string
+	addressKey   api.Key
+	share        api.Share
+	links        map[string]*api.Link
+	revisions    map[string]*fakeRevision
+	blocks       map[string]*fakeBlock
+	nextID       int
+}
+
+// newFakeServer makes the keys of a new drive with an empty root
+// folder and starts serving it
+func newFakeServer(t *testing.T) *fakeServer {
+	srv := &fakeServer{
+		t:            t,
+		uid:          "uid",
+		accessToken:  "access-0",
+		refreshToken: "refresh-0",
+		links:        map[string]*api.Link{},
+		revisions:    map[string]*fakeRevision{},
+		blocks:       map[string]*fakeBlock{},
+	}
+	userKey, userArmored, keyPass, err := generateKey()
+	require.NoError(t, err)
+	srv.userKey, srv.keyPass = userArmored, keyPass
+
+	addressKey, addressArmored, addressPass, err := generateKey()
+	require.NoError(t, err)
+	token, err := encryptMessage(addressPass, userKey, nil)
+	require.NoError(t, err)
+	srv.addressKey = api.Key{ID: "addresskey", PrivateKey: addressArmored, Token: token, Primary: 1, Active: 1}
+
+	shareKey, shareArmored, sharePass, err := generateKey()
+	require.NoError(t, err)
+	sharePassphrase, err := encryptMessage(sharePass, addressKey, nil)
+	require.NoError(t, err)
+	srv.share = api.Share{
+		ShareID:    "share",
+		VolumeID:   "volume",
+		LinkID:     "root",
+		AddressID:  "address",
+		Key:        shareArmored,
+		Passphrase: sharePassphrase,
+	}
+
+	rootKey, rootArmored, rootPass, err := generateKey()
+	require.NoError(t, err)
+	rootPassphrase, err := encryptMessage(rootPass, shareKey, nil)
+	require.NoError(t, err)
+	hashKey, err := encryptMessage([]byte("root hash key"), rootKey, nil)
+	require.NoError(t, err)
+	name, err := encryptMessage([]byte("root"), shareKey, nil)
+	require.NoError(t, err)
+	srv.links["root"] = &api.Link{
+		LinkID:           "root",
+		Type:             api.LinkTypeFolder,
+		Name:             name,
+		State:            api.LinkStateActive,
+		NodeKey:          rootArmored,
+		NodePassphrase:   rootPassphrase,
+		FolderProperties: &api.FolderProperties{NodeHashKey: hashKey},
+	}
+	srv.server = httptest.NewServer(http.HandlerFunc(srv.handle))
+	return srv
+}
+
+// close shuts the server down
+func (srv *fakeServer) close() {
+	srv.server.Close()
+}
+
+// config returns the config for a remote using the server
+func (srv *fakeServer) config() configmap.Simple {
+	return configmap.Simple{
+		"username":               "user",
+		"password":               obscure.MustObscure("password"),
+		"api_url":                srv.server.URL,
+		"app_version":            defaultAppVersion,
+		"client_uid":             srv.uid,
+		"client_access_token":    srv.accessToken,
+		"client_refresh_token":   srv.refreshToken,
+		"client_salted_key_pass": obscure.MustObscure(string(srv.keyPass)),
+	}
+}
+
+// newFs makes an Fs for root on the server
+func (srv *fakeServer) newFs(root string, m configmap.Simple) (*Fs, error) {
+	f, err := NewFs(context.Background(), "TestProtonDrive", root, m)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*Fs), nil
+}
+
+// newID returns a new ID with the prefix given
+func (srv *fakeServer) newID(prefix string) string {
+	srv.nextID++
+	return prefix + "-" + strconv.Itoa(srv.nextID)
+}
+
+// writeJSON writes a successful JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(api.Error{Code: code, Message: message})
+}
+
+// readJSON reads the request body into v
+func readJSON(r *http.Request, v interface{}) {
+	_ = json.NewDecoder(r.Body).Decode(v)
+}
+
+// ok is the body of a successful response with no data
+var ok = api.Response{Code: api.CodeOK}
+
+// hashTaken returns true if an active or draft link in parent has hash
+func (srv *fakeServer) hashTaken(parentID, hash string) bool {
+	for _, link := range srv.links {
+		if link.ParentLinkID == parentID && link.Hash == hash && (link.State == api.LinkStateActive || link.State == api.LinkStateDraft) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle serves the API
+func (srv *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + r.URL.Path
+
+	// Block storage is authorized by the token
+	if p[0] == "storage" {
+		srv.handleStorage(w, r)
+		return
+	}
+	if route == "POST /auth/v4/refresh" {
+		var req api.RefreshRequest
+		readJSON(r, &req)
+		if req.UID != srv.uid || req.RefreshToken != srv.refreshToken {
+			writeError(w, http.StatusUnprocessableEntity, 10013, "Invalid refresh token")
+			return
+		}
+		srv.accessToken = srv.newID("access")
+		srv.refreshToken = srv.newID("refresh")
+		writeJSON(w, api.Auth{Response: ok, UID: srv.uid, AccessToken: srv.accessToken, RefreshToken: srv.refreshToken})
+		return
+	}
+	if r.Header.Get("x-pm-uid") != srv.uid || r.Header.Get("Authorization") != "Bearer "+srv.accessToken {
+		writeError(w, http.StatusUnauthorized, 401, "Invalid access token")
+		return
+	}
+	if r.Header.Get("x-pm-appversion") == "" {
+		writeError(w, http.StatusBadRequest, 5001, "Missing app version")
+		return
+	}
+
+	switch {
+	case route == "GET /core/v4/users":
+		var used int64
+		for _, block := range srv.blocks {
+			used += int64(len(block.data))
+		}
+		writeJSON(w, api.UserResponse{Response: ok, User: api.User{
+			ID:        "user",
+			UsedSpace: used,
+			MaxSpace:  1 << 30,
+			Keys:      []api.Key{{ID: "userkey", PrivateKey: srv.userKey, Primary: 1, Active: 1}},
+		}})
+	case route == "GET /core/v4/addresses":
+		writeJSON(w, api.AddressesResponse{Response: ok, Addresses: []api.Address{{
+			ID:    "address",
+			Email: "user@proton.me",
+			Keys:  []api.Key{srv.addressKey},
+		}}})
+	case route == "GET /drive/volumes":
+		writeJSON(w, api.VolumesResponse{Response: ok, Volumes: []api.Volume{{
+			VolumeID: "volume",
+			State:    1,
+			Share:    api.ShareRef{ShareID: "share", LinkID: "root"},
+		}}})
+	case route == "POST /drive/blocks":
+		var req api.BlockUploadRequest
+		readJSON(r, &req)
+		rev := srv.revisions[req.RevisionID]
+		if rev == nil || rev.linkID != req.LinkID || rev.state != api.LinkStateDraft {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeNotExists, "No such draft revision")
+			return
+		}
+		var result = api.BlockUploadResponse{Response: ok}
+		for _, info := range req.BlockList {
+			token := srv.newID("block")
+			srv.blocks[token] = &fakeBlock{hash: info.Hash}
+			rev.blocks[info.Index] = token
+			result.UploadLinks = append(result.UploadLinks, api.UploadLink{Token: token, BareURL: srv.server.URL + "/storage/blocks"})
+		}
+		writeJSON(w, result)
+	case len(p) >= 3 && p[0] == "drive" && p[1] == "shares" && p[2] == srv.share.ShareID:
+		srv.handleShare(w, r, p[3:])
+	default:
+		writeError(w, http.StatusNotFound, 2000, "Unknown route "+route)
+	}
+}
+
+// handleShare serves the requests under /drive/shares/{id}
+func (srv *fakeServer) handleShare(w http.ResponseWriter, r *http.Request, p []string) {
+	route := r.Method + " " + strings.Join(p, "/")
+	var link *api.Link
+	if len(p) >= 2 {
+		link = srv.links[p[1]]
+	}
+	switch {
+	case route == "GET ":
+		share := srv.share
+		share.Response = ok
+		writeJSON(w, share)
+	case r.Method == "GET" && len(p) == 2 && p[0] == "links" && link != nil:
+		writeJSON(w, api.LinkResponse{Response: ok, Link: *link})
+	case r.Method == "GET" && len(p) == 3 && p[0] == "folders" && p[2] == "children" && link != nil:
+		page, _ := strconv.Atoi(r.URL.Query().Get("Page"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("PageSize"))
+		var children []api.Link
+		for _, child := range srv.links {
+			if child.ParentLinkID == link.LinkID {
+				children = append(children, *child)
+			}
+		}
+		sort.Slice(children, func(i, j int) bool { return children[i].LinkID < children[j].LinkID })
+		start, end := page*pageSize, (page+1)*pageSize
+		if start > len(children) {
+			start = len(children)
+		}
+		if end > len(children) {
+			end = len(children)
+		}
+		writeJSON(w, api.LinksResponse{Response: ok, Links: children[start:end]})
+	case route == "POST folders":
+		var req api.CreateFolderRequest
+		readJSON(r, &req)
+		if srv.links[req.ParentLinkID] == nil {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeNotExists, "No such parent")
+			return
+		}
+		if srv.hashTaken(req.ParentLinkID, req.Hash) {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeAlreadyExist, "A file or folder with that name already exists")
+			return
+		}
+		id := srv.newID("folder")
+		srv.links[id] = &api.Link{
+			LinkID:                  id,
+			ParentLinkID:            req.ParentLinkID,
+			Type:                    api.LinkTypeFolder,
+			Name:                    req.Name,
+			Hash:                    req.Hash,
+			State:                   api.LinkStateActive,
+			ModifyTime:              time.Now().Unix(),
+			NodeKey:                 req.NodeKey,
+			NodePassphrase:          req.NodePassphrase,
+			NodePassphraseSignature: req.NodePassphraseSignature,
+			FolderProperties:        &api.FolderProperties{NodeHashKey: req.NodeHashKey},
+		}
+		var result = api.CreateFolderResponse{Response: ok}
+		result.Folder.ID = id
+		writeJSON(w, result)
+	case route == "POST files":
+		var req api.CreateFileRequest
+		readJSON(r, &req)
+		if srv.hashTaken(req.ParentLinkID, req.Hash) {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeAlreadyExist, "A file or folder with that name already exists")
+			return
+		}
+		id, revisionID := srv.newID("file"), srv.newID("revision")
+		srv.links[id] = &api.Link{
+			LinkID:                  id,
+			ParentLinkID:            req.ParentLinkID,
+			Type:                    api.LinkTypeFile,
+			Name:                    req.Name,
+			Hash:                    req.Hash,
+			State:                   api.LinkStateDraft,
+			MIMEType:                req.MIMEType,
+			NodeKey:                 req.NodeKey,
+			NodePassphrase:          req.NodePassphrase,
+			NodePassphraseSignature: req.NodePassphraseSignature,
+			FileProperties: &api.FileProperties{
+				ContentKeyPacket:          req.ContentKeyPacket,
+				ContentKeyPacketSignature: req.ContentKeyPacketSignature,
+			},
+		}
+		srv.revisions[revisionID] = &fakeRevision{linkID: id, state: api.LinkStateDraft, blocks: map[int]string{}}
+		var result = api.CreateFileResponse{Response: ok}
+		result.File.ID = id
+		result.File.RevisionID = revisionID
+		writeJSON(w, result)
+	case r.Method == "POST" && len(p) == 3 && p[0] == "files" && p[2] == "revisions" && link != nil:
+		revisionID := srv.newID("revision")
+		srv.revisions[revisionID] = &fakeRevision{linkID: link.LinkID, state: api.LinkStateDraft, blocks: map[int]string{}}
+		var result = api.CreateRevisionResponse{Response: ok}
+		result.Revision.ID = revisionID
+		writeJSON(w, result)
+	case len(p) == 4 && p[0] == "files" && p[2] == "revisions" && link != nil:
+		rev := srv.revisions[p[3]]
+		if rev == nil || rev.linkID != link.LinkID {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeNotExists, "No such revision")
+			return
+		}
+		srv.handleRevision(w, r, link, p[3], rev)
+	case r.Method == "PUT" && len(p) == 3 && p[0] == "links" && (p[2] == "move" || p[2] == "rename") && link != nil:
+		var req api.MoveRequest
+		readJSON(r, &req)
+		if p[2] == "rename" {
+			req.ParentLinkID = link.ParentLinkID
+		}
+		if req.OriginalHash != link.Hash {
+			writeError(w, http.StatusUnprocessableEntity, 2011, "Original hash doesn't match")
+			return
+		}
+		if srv.hashTaken(req.ParentLinkID, req.Hash) {
+			writeError(w, http.StatusUnprocessableEntity, api.CodeAlreadyExist, "A file or folder with that name already exists")
+			return
+		}
+		link.ParentLinkID = req.ParentLinkID
+		link.Name = req.Name
+		link.Hash = req.Hash
+		if p[2] == "move" {
+			link.NodePassphrase = req.NodePassphrase
+			link.NodePassphraseSignature = req.NodePassphraseSignature
+		}
+		writeJSON(w, ok)
+	case r.Method == "POST" && len(p) == 3 && p[0] == "folders" && link != nil:
+		srv.handleLinks(w, r, func(child *api.Link) bool {
+			if child.ParentLinkID != link.LinkID {
+				return false
+			}
+			switch p[2] {
+			case "trash_multiple":
+				if child.State != api.LinkStateActive {
+					return false
+				}
+				child.State = api.LinkStateTrashed
+			case "delete_multiple":
+				if child.State != api.LinkStateDraft {
+					return false
+				}
+				delete(srv.links, child.LinkID)
+			default:
+				return false
+			}
+			return true
+		})
+	case route == "POST trash/delete_multiple":
+		srv.handleLinks(w, r, func(child *api.Link) bool {
+			if child.State != api.LinkStateTrashed {
+				return false
+			}
+			delete(srv.links, child.LinkID)
+			return true
+		})
+	case route == "DELETE trash":
+		for id, child := range srv.links {
+			if child.State == api.LinkStateTrashed {
+				delete(srv.links, id)
+			}
+		}
+		writeJSON(w, ok)
+	default:
+		writeError(w, http.StatusNotFound, 2000, "Unknown route "+route)
+	}
+}
+
+// handleLinks does fn on each link of a multiple request
+func (srv *fakeServer) handleLinks(w http.ResponseWriter, r *http.Request, fn func(*api.Link) bool) {
+	var req api.LinkIDsRequest
+	readJSON(r, &req)
+	result := api.MultiResponse{Response: api.Response{Code: api.CodeMultiOK}}
+	for _, id := range req.LinkIDs {
+		item := api.LinkIDResponse{LinkID: id, Response: api.Error{Code: api.CodeOK}}
+		if link := srv.links[id]; link == nil || !fn(link) {
+			item.Response = api.Error{Code: api.CodeNotExists, Message: "Can't do that to " + id}
+		}
+		result.Responses = append(result.Responses, item)
+	}
+	writeJSON(w, result)
+}
+
+// handleRevision serves reading, committing and deleting a revision
+func (srv *fakeServer) handleRevision(w http.ResponseWriter, r *http.Request, link *api.Link, revisionID string, rev *fakeRevision) {
+	switch r.Method {
+	case "GET":
+		from, _ := strconv.Atoi(r.URL.Query().Get("FromBlockIndex"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("PageSize"))
+		var indexes []int
+		for index := range rev.blocks {
+			if index >= from {
+				indexes = append(indexes, index)
+			}
+		}
+		sort.Ints(indexes)
+		if len(indexes) > pageSize {
+			indexes = indexes[:pageSize]
+		}
+		result := api.RevisionResponse{Response: ok, Revision: api.Revision{ID: revisionID}}
+		for _, index := range indexes {
+			token := rev.blocks[index]
+			result.Revision.Blocks = append(result.Revision.Blocks, api.Block{
+				Index:   index,
+				BareURL: srv.server.URL + "/storage/blocks",
+				Token:   token,
+				Hash:    srv.blocks[token].hash,
+			})
+		}
+		writeJSON(w, result)
+	case "PUT":
+		var req api.CommitRevisionRequest
+		readJSON(r, &req)
+		if rev.state != api.LinkStateDraft || req.ManifestSignature == "" || req.XAttr == "" {
+			writeError(w, http.StatusUnprocessableEntity, 2000, "Bad commit")
+			return
+		}
+		var size int64
+		for _, token := range rev.blocks {
+			block := srv.blocks[token]
+			if block.data == nil {
+				writeError(w, http.StatusUnprocessableEntity, 2000, "Block not uploaded")
+				return
+			}
+			size += int64(len(block.data))
+		}
+		rev.state = api.LinkStateActive
+		link.State = api.LinkStateActive
+		link.Size = size
+		link.ModifyTime = time.Now().Unix()
+		link.FileProperties.ActiveRevision = &api.Revision{ID: revisionID, Size: size, State: api.LinkStateActive, XAttr: req.XAttr}
+		writeJSON(w, ok)
+	case "DELETE":
+		if rev.state != api.LinkStateDraft {
+			writeError(w, http.StatusUnprocessableEntity, 2000, "Can't delete active revision")
+			return
+		}
+		delete(srv.revisions, revisionID)
+		writeJSON(w, ok)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, 2000, "Bad method")
+	}
+}
+
+// handleStorage serves uploading and downloading blocks
+func (srv *fakeServer) handleStorage(w http.ResponseWriter, r *http.Request) {
+	block := srv.blocks[r.Header.Get("pm-storage-token")]
+	if block == nil {
+		writeError(w, http.StatusUnprocessableEntity, 2000, "Bad storage token")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		_, _ = w.Write(block.data)
+	case "POST":
+		in, _, err := r.FormFile("Block")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, 2000, "No block: "+err.Error())
+			return
+		}
+		data, err := ioutil.ReadAll(in)
+		require.NoError(srv.t, err)
+		sum := sha256.Sum256(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != block.hash {
+			writeError(w, http.StatusUnprocessableEntity, 2000, "Block hash doesn't match")
+			return
+		}
+		block.data = data
+		writeJSON(w, ok)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, 2000, "Bad method")
+	}
+}
+
+// put uploads a file
+func put(t *testing.T, f fs.Fs, remote string, contents []byte) fs.Object {
+	src := object.NewStaticObjectInfo(remote, time.Date(2021, 2, 3, 4, 5, 6, 7000000, time.UTC), int64(len(contents)), true, nil, nil)
+	o, err := f.Put(context.Background(), bytes.NewReader(contents), src)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads a file
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) []byte {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return data
+}
+
+func TestEncryptedOnServer(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("", srv.config())
+	require.NoError(t, err)
+
+	require.NoError(t, f.Mkdir(context.Background(), "secret dir"))
+	o := put(t, f, "secret dir/secret name.txt", []byte("secret contents"))
+	assert.Equal(t, int64(15), o.Size())
+	assert.Equal(t, time.Date(2021, 2, 3, 4, 5, 6, 7000000, time.UTC), o.ModTime(context.Background()).UTC())
+	sha1, err := o.Hash(context.Background(), hash.SHA1)
+	require.NoError(t, err)
+	assert.Equal(t, "2d6ffc674c6a3405d3808f9f8280bec30b34eda6", sha1)
+
+	srv.mu.Lock()
+	for _, link := range srv.links {
+		data, _ := json.Marshal(link)
+		assert.NotContains(t, string(data), "secret")
+	}
+	for _, block := range srv.blocks {
+		assert.NotContains(t, string(block.data), "secret")
+	}
+	srv.mu.Unlock()
+
+	// A new Fs can read it all back
+	f2, err := srv.newFs("secret dir", srv.config())
+	require.NoError(t, err)
+	o2, err := f2.NewObject(context.Background(), "secret name.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "secret contents", string(read(t, o2)))
+	assert.Equal(t, o.ModTime(context.Background()), o2.ModTime(context.Background()))
+
+	// Pointing the root at the file gives its parent
+	_, err = srv.newFs("secret dir/secret name.txt", srv.config())
+	assert.Equal(t, fs.ErrorIsFile, err)
+}
+
+func TestMultiBlock(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("", srv.config())
+	require.NoError(t, err)
+
+	contents := make([]byte, 2*blockSize+100)
+	_, _ = rand.New(rand.NewSource(1)).Read(contents)
+	o := put(t, f, "big", contents)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	assert.Equal(t, contents, read(t, o))
+	assert.Equal(t, contents[blockSize-10:blockSize+10], read(t, o, &fs.RangeOption{Start: blockSize - 10, End: blockSize + 9}))
+	assert.Equal(t, contents[2*blockSize+50:], read(t, o, &fs.SeekOption{Offset: 2*blockSize + 50}))
+
+	// Update makes a new revision of the same file
+	id := o.(*Object).id
+	src := object.NewStaticObjectInfo("big", time.Now(), 5, true, nil, nil)
+	require.NoError(t, o.Update(context.Background(), strings.NewReader("small"), src))
+	assert.Equal(t, id, o.(*Object).id)
+	assert.Equal(t, "small", string(read(t, o)))
+}
+
+// errorReader fails after returning some data
+type errorReader struct {
+	n int
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errors.New("read failed")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestUploadFailureRemovesDraft(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	f, err := srv.newFs("", srv.config())
+	require.NoError(t, err)
+
+	src := object.NewStaticObjectInfo("broken", time.Now(), -1, true, nil, nil)
+	_, err = f.Put(context.Background(), &errorReader{n: blockSize + 10}, src)
+	require.Error(t, err)
+	srv.mu.Lock()
+	assert.Len(t, srv.links, 1)
+	srv.mu.Unlock()
+
+	// The name is free to use again
+	o := put(t, f, "broken", []byte("fixed"))
+	assert.Equal(t, "fixed", string(read(t, o)))
+}
+
+func TestRefreshSession(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	m := srv.config()
+	m["client_access_token"] = "expired"
+	f, err := srv.newFs("", m)
+	require.NoError(t, err)
+	assert.Equal(t, srv.accessToken, m["client_access_token"])
+	assert.Equal(t, srv.refreshToken, m["client_refresh_token"])
+	assert.NotEqual(t, "access-0", srv.accessToken)
+
+	usage, err := f.About(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), *usage.Total)
+}
+
+func TestHardDelete(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	m := srv.config()
+	m["hard_delete"] = "true"
+	f, err := srv.newFs("", m)
+	require.NoError(t, err)
+	o := put(t, f, "file", []byte("data"))
+	require.NoError(t, o.Remove(context.Background()))
+	srv.mu.Lock()
+	assert.Len(t, srv.links, 1)
+	srv.mu.Unlock()
+
+	m["hard_delete"] = "false"
+	f, err = srv.newFs("", m)
+	require.NoError(t, err)
+	o = put(t, f, "file", []byte("data"))
+	require.NoError(t, o.Remove(context.Background()))
+	srv.mu.Lock()
+	assert.Equal(t, api.LinkStateTrashed, srv.links[o.(*Object).id].State)
+	srv.mu.Unlock()
+	require.NoError(t, f.CleanUp(context.Background()))
+	srv.mu.Lock()
+	assert.Len(t, srv.links, 1)
+	srv.mu.Unlock()
+}
+
+func TestIntegrationFake(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	for key, value := range srv.config() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestProtonDriveFake", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestProtonDriveFake", "type"), "protondrive"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestProtonDriveFake:",
+		NilObject:  (*Object)(nil),
+	})
+}
@@ -0,0 +1,245 @@
+package protondrive
+
+// This implements the Secure Remote Password protocol as used to log
+// in to Proton and the hashing of passwords with bcrypt used both by
+// it and to make the passphrase of the keys of the user.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blowfish"
+)
+
+const (
+	srpBitLength = 2048 // size of the SRP modulus
+	bcryptCost   = 10   // log2 of the rounds of bcrypt
+)
+
+// srpRandom is the source of the client secret - replaced in tests
+var srpRandom io.Reader = rand.Reader
+
+// modulusKey is the key Proton signs the SRP modulus with
+const modulusKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEXAHLgxYJKwYBBAHaRw8BAQdAFurWXXwjTemqjD7CXjXVyKf0of7n9Ctm
+L8v9enkzggHNEnByb3RvbkBzcnAubW9kdWx1c8J3BBAWCgApBQJcAcuDBgsJ
+BwgDAgkQNQWFxOlRjyYEFQgKAgMWAgECGQECGwMCHgEAAPGRAP9sauJsW12U
+MnTQUZpsbJb53d0Wv55mZIIiJL2XulpWPQD/V6NglBd96lZKBmInSXX/kXat
+Sv+y0io+LR8i2+jV+AbOOARcAcuDEgorBgEEAZdVAQUBAQdAeJHUz1c9+KfE
+kSIgcBRE3WuXC4oj5a2/U3oASExGDW4DAQgHwmEEGBYIABMFAlwBy4MJEDUF
+hcTpUY8mAhsMAAD/XQD8DxNI6E78meodQI+wLsrKLeHn32iLvUqJbVDhfWSU
+WO4BAMcm1u02t4VKw++ttECPt+HUgPUq5pqQWe5Q2cW4TMsE
+=Y4Mw
+-----END PGP PUBLIC KEY BLOCK-----`
+
+// bcryptBase64 is the base64 alphabet used by bcrypt
+var bcryptBase64 = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// bcryptHash returns the bcrypt hash of password with a 16 byte salt
+// in the form "$2y$10$<salt><hash>"
+func bcryptHash(password, salt []byte) ([]byte, error) {
+	if len(salt) != 16 {
+		return nil, errors.Errorf("bcrypt salt must be 16 bytes not %d", len(salt))
+	}
+	// The key is NUL terminated
+	key := make([]byte, len(password)+1)
+	copy(key, password)
+	c, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 1<<bcryptCost; i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(salt, c)
+	}
+	text := []byte("OrpheanBeholderScryDoubt")
+	for i := 0; i < len(text); i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(text[i:i+8], text[i:i+8])
+		}
+	}
+	out := "$2y$10$" + bcryptBase64.EncodeToString(salt) + bcryptBase64.EncodeToString(text[:len(text)-1])
+	return []byte(out), nil
+}
+
+// keyPassphrase returns the passphrase of the keys of the user from
+// the password and the base64 salt of the key
+func keyPassphrase(password []byte, keySalt string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(keySalt)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad key salt")
+	}
+	hashed, err := bcryptHash(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	// The passphrase is the hash without the prefix and the salt
+	return hashed[len(hashed)-31:], nil
+}
+
+// expandHash extends the SHA-512 of data to 256 bytes
+func expandHash(data []byte) []byte {
+	out := make([]byte, 0, 4*sha512.Size)
+	for i := byte(0); i < 4; i++ {
+		part := sha512.Sum512(append(data[:len(data):len(data)], i))
+		out = append(out, part[:]...)
+	}
+	return out
+}
+
+// srpHashPassword hashes the password for the SRP exchange
+func srpHashPassword(version int, password []byte, salt string, modulus []byte) ([]byte, error) {
+	if version != 3 && version != 4 {
+		return nil, errors.Errorf("unsupported auth version %d - log in to Proton in a browser to upgrade it", version)
+	}
+	decodedSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad SRP salt")
+	}
+	hashed, err := bcryptHash(password, append(decodedSalt, []byte("proton")...))
+	if err != nil {
+		return nil, err
+	}
+	return expandHash(append(hashed, modulus...)), nil
+}
+
+// srpModulus checks the signature of the clear signed modulus and
+// returns it
+func srpModulus(signed string) ([]byte, error) {
+	block, rest := clearsign.Decode([]byte(signed))
+	if block == nil {
+		return nil, errors.New("SRP modulus isn't signed")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("data after the SRP modulus")
+	}
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(modulusKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read modulus key")
+	}
+	_, err = openpgp.CheckDetachedSignature(keyRing, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad signature on the SRP modulus")
+	}
+	return base64.StdEncoding.DecodeString(string(block.Bytes))
+}
+
+// srpInt reads a little endian number
+func srpInt(b []byte) *big.Int {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(reversed)
+}
+
+// srpBytes writes n as a little endian number of srpBitLength bits
+func srpBytes(n *big.Int) []byte {
+	b := n.Bytes()
+	out := make([]byte, srpBitLength/8)
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+// srpProofs are the results of the client side of the exchange
+type srpProofs struct {
+	clientEphemeral     []byte
+	clientProof         []byte
+	expectedServerProof []byte
+}
+
+// srpCheckParams checks the modulus is a safe prime for which 2 is a
+// generator and the server ephemeral is in range
+func srpCheckParams(modulus, serverEphemeral *big.Int) error {
+	one := big.NewInt(1)
+	modulusMinusOne := new(big.Int).Sub(modulus, one)
+	if modulus.BitLen() != srpBitLength {
+		return errors.New("SRP modulus has the wrong size")
+	}
+	// N must be 3 mod 8 so 2 isn't a square and generates the group
+	if modulus.Bit(0) != 1 || modulus.Bit(1) != 1 || modulus.Bit(2) != 0 {
+		return errors.New("SRP modulus isn't 3 mod 8")
+	}
+	if serverEphemeral.Cmp(one) <= 0 || serverEphemeral.Cmp(modulusMinusOne) >= 0 {
+		return errors.New("SRP server ephemeral is out of range")
+	}
+	half := new(big.Int).Rsh(modulus, 1)
+	if !half.ProbablyPrime(10) {
+		return errors.New("SRP modulus isn't a safe prime")
+	}
+	// 2^((N-1)/2) = -1 mod N proves N prime given (N-1)/2 is
+	if new(big.Int).Exp(big.NewInt(2), half, modulus).Cmp(modulusMinusOne) != 0 {
+		return errors.New("SRP modulus isn't prime")
+	}
+	return nil
+}
+
+// srpGenerateProofs does the client side of the exchange
+func srpGenerateProofs(modulusBytes, serverEphemeralBytes, hashedPassword []byte) (*srpProofs, error) {
+	modulus := srpInt(modulusBytes)
+	serverEphemeral := srpInt(serverEphemeralBytes)
+	err := srpCheckParams(modulus, serverEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	generator := big.NewInt(2)
+	modulusMinusOne := new(big.Int).Sub(modulus, big.NewInt(1))
+
+	multiplier := srpInt(expandHash(append(srpBytes(generator), srpBytes(modulus)...)))
+	multiplier.Mod(multiplier, modulus)
+	if multiplier.Cmp(big.NewInt(1)) <= 0 || multiplier.Cmp(modulusMinusOne) >= 0 {
+		return nil, errors.New("SRP multiplier is out of range")
+	}
+
+	var (
+		secret          *big.Int
+		clientEphemeral []byte
+		scrambling      *big.Int
+		lowerBound      = big.NewInt(srpBitLength * 2)
+	)
+	for {
+		secret, err = rand.Int(srpRandom, modulusMinusOne)
+		if err != nil {
+			return nil, err
+		}
+		// Make sure g^a wraps the modulus
+		if secret.Cmp(lowerBound) <= 0 {
+			continue
+		}
+		clientEphemeral = srpBytes(new(big.Int).Exp(generator, secret, modulus))
+		scrambling = srpInt(expandHash(append(clientEphemeral[:len(clientEphemeral):len(clientEphemeral)], serverEphemeralBytes...)))
+		if scrambling.Sign() != 0 {
+			break
+		}
+	}
+
+	// S = (B - k*g^x)^(a + u*x) mod N
+	x := srpInt(hashedPassword)
+	base := new(big.Int).Exp(generator, x, modulus)
+	base.Mul(base, multiplier)
+	base.Sub(serverEphemeral, base)
+	base.Mod(base, modulus)
+	exponent := new(big.Int).Mul(scrambling, x)
+	exponent.Add(exponent, secret)
+	exponent.Mod(exponent, modulusMinusOne)
+	sharedSecret := srpBytes(new(big.Int).Exp(base, exponent, modulus))
+
+	clientProof := expandHash(bytes.Join([][]byte{clientEphemeral, serverEphemeralBytes, sharedSecret}, nil))
+	serverProof := expandHash(bytes.Join([][]byte{clientEphemeral, clientProof, sharedSecret}, nil))
+	return &srpProofs{
+		clientEphemeral:     clientEphemeral,
+		clientProof:         clientProof,
+		expectedServerProof: serverProof,
+	}, nil
+}
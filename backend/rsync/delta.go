@@ -0,0 +1,260 @@
+package rsync
+
+// This implements the receiving side of the rsync delta transfer.
+//
+// The client sends the server checksums of the blocks of the basis
+// file - an old copy of the file it already has. The server replies
+// with a stream of tokens which are either literal data or
+// references to blocks of the basis file, from which the new file is
+// reconstructed.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	blockSize      = 700     // minimum block length
+	maxBlockSize   = 1 << 29 // maximum block length for protocol < 30
+	blockSumBias   = 10      // bits of bias used to size the strong checksums
+	shortSumLength = 2       // minimum strong checksum length
+	sumLength      = md4.Size
+)
+
+// errAborted is the result of closing a transfer before the end
+var errAborted = errors.New("rsync transfer aborted")
+
+// sumHead describes the block checksums of a basis file
+type sumHead struct {
+	count     int32 // number of blocks
+	blength   int32 // length of each block
+	s2length  int32 // length of the strong checksum sent for each block
+	remainder int32 // length of the last block if it is short
+}
+
+// sumSizes returns the sumHead for a basis file of length size
+//
+// This must match sum_sizes_sqroot in rsync's generator.c as the
+// server uses it to check the checksum lengths.
+func sumSizes(size int64) (head sumHead) {
+	blength := int32(blockSize)
+	if size > blockSize*blockSize {
+		c := int64(1)
+		for l := size >> 2; l != 0; l >>= 2 {
+			c <<= 1
+		}
+		if c >= maxBlockSize {
+			blength = maxBlockSize
+		} else {
+			b := int64(0)
+			for ; c >= 8; c >>= 1 {
+				b |= c
+				if size < b*b {
+					b &^= c
+				}
+			}
+			blength = int32(b)
+			if blength < blockSize {
+				blength = blockSize
+			}
+		}
+	}
+	b := blockSumBias
+	for l := size >> 1; l != 0; l >>= 1 {
+		b += 2
+	}
+	for c := blength >> 1; c != 0 && b != 0; c >>= 1 {
+		b--
+	}
+	s2length := int32((b + 1 - 32 + 7) / 8)
+	if s2length < shortSumLength {
+		s2length = shortSumLength
+	}
+	if s2length > sumLength {
+		s2length = sumLength
+	}
+	return sumHead{
+		count:     int32((size + int64(blength) - 1) / int64(blength)),
+		blength:   blength,
+		s2length:  s2length,
+		remainder: int32(size % int64(blength)),
+	}
+}
+
+// blockLength returns the length of block i
+func (head *sumHead) blockLength(i int32) int32 {
+	if i == head.count-1 && head.remainder != 0 {
+		return head.remainder
+	}
+	return head.blength
+}
+
+// checksum1 returns the weak rolling checksum of buf
+func checksum1(buf []byte) uint32 {
+	var s1, s2 uint32
+	for _, c := range buf {
+		// rsync sums the bytes as signed chars
+		s1 += uint32(int8(c))
+		s2 += s1
+	}
+	return (s1 & 0xFFFF) + (s2 << 16)
+}
+
+// checksum2 returns the strong checksum of buf
+func checksum2(buf []byte, seed int32) []byte {
+	h := md4.New()
+	_, _ = h.Write(buf)
+	if seed != 0 {
+		var seedBytes [4]byte
+		binary.LittleEndian.PutUint32(seedBytes[:], uint32(seed))
+		_, _ = h.Write(seedBytes[:])
+	}
+	return h.Sum(nil)
+}
+
+// newFileHash returns the hash used to check the whole file
+func newFileHash(seed int32) hash.Hash {
+	h := md4.New()
+	var seedBytes [4]byte
+	binary.LittleEndian.PutUint32(seedBytes[:], uint32(seed))
+	_, _ = h.Write(seedBytes[:])
+	return h
+}
+
+// writeSums sends the checksums of the basis of length size
+//
+// basis may be nil in which case no checksums are sent and the
+// server sends the whole file.
+func (s *session) writeSums(basis io.ReaderAt, size int64) (head sumHead, err error) {
+	if basis != nil && size > 0 {
+		head = sumSizes(size)
+	} else {
+		head = sumHead{blength: blockSize, s2length: shortSumLength}
+	}
+	s.writeInt(head.count)
+	s.writeInt(head.blength)
+	s.writeInt(head.s2length)
+	s.writeInt(head.remainder)
+	buf := make([]byte, head.blength)
+	for i := int32(0); i < head.count; i++ {
+		block := buf[:head.blockLength(i)]
+		_, err = basis.ReadAt(block, int64(i)*int64(head.blength))
+		if err != nil && err != io.EOF {
+			return head, errors.Wrap(err, "failed to read basis file")
+		}
+		s.writeInt(int32(checksum1(block)))
+		s.write(checksum2(block, s.seed)[:head.s2length])
+	}
+	return head, s.err
+}
+
+// fileReader reads a file from the server, rebuilding it from the
+// literal data and basis blocks sent
+type fileReader struct {
+	s       *session
+	head    sumHead
+	basis   io.ReaderAt // the basis file or nil
+	hash    hash.Hash   // checksum of the data read
+	out     io.Writer   // a copy of the data is written here if set
+	block   []byte      // buffer for basis blocks
+	pending []byte      // data from the basis block left to return
+	literal int32       // bytes of literal data left in this token
+	done    func(err error) error
+	err     error
+}
+
+// Read reads the reconstructed file
+func (r *fileReader) Read(p []byte) (n int, err error) {
+	for n == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if len(r.pending) > 0 {
+			n = copy(p, r.pending)
+			r.pending = r.pending[n:]
+			break
+		}
+		if r.literal > 0 {
+			if int32(len(p)) > r.literal {
+				p = p[:r.literal]
+			}
+			r.s.readFull(p)
+			if r.s.err != nil {
+				return 0, r.finish(errors.Wrap(r.s.err, "failed to read rsync data"))
+			}
+			n = len(p)
+			r.literal -= int32(n)
+			break
+		}
+		r.nextToken()
+	}
+	_, _ = r.hash.Write(p[:n])
+	if r.out != nil {
+		if _, err = r.out.Write(p[:n]); err != nil {
+			return 0, r.finish(errors.Wrap(err, "failed to write basis file"))
+		}
+	}
+	return n, nil
+}
+
+// nextToken reads the next token from the server
+func (r *fileReader) nextToken() {
+	token := r.s.readInt()
+	switch {
+	case r.s.err != nil:
+		_ = r.finish(errors.Wrap(r.s.err, "failed to read rsync data"))
+	case token > 0:
+		if token > maxLiteral {
+			_ = r.finish(errors.Errorf("rsync protocol error: literal data too long %d", token))
+			return
+		}
+		r.literal = token
+	case token < 0:
+		i := -(token + 1)
+		if r.basis == nil || i >= r.head.count {
+			_ = r.finish(errors.Errorf("rsync protocol error: bad block %d", i))
+			return
+		}
+		r.pending = r.block[:r.head.blockLength(i)]
+		_, err := r.basis.ReadAt(r.pending, int64(i)*int64(r.head.blength))
+		if err != nil && err != io.EOF {
+			_ = r.finish(errors.Wrap(err, "failed to read basis file"))
+		}
+	default:
+		// End of file - check the whole file checksum
+		var sum [sumLength]byte
+		r.s.readFull(sum[:])
+		if r.s.err != nil {
+			_ = r.finish(errors.Wrap(r.s.err, "failed to read rsync checksum"))
+		} else if !bytes.Equal(sum[:], r.hash.Sum(nil)) {
+			_ = r.finish(errors.New("rsync checksum mismatch"))
+		} else {
+			_ = r.finish(io.EOF)
+		}
+	}
+}
+
+// finish records err as the result of the read and calls done
+func (r *fileReader) finish(err error) error {
+	if r.err == nil {
+		r.err = err
+		if doneErr := r.done(err); doneErr != nil && err == io.EOF {
+			r.err = doneErr
+		}
+	}
+	return r.err
+}
+
+// Close the reader, aborting the transfer if it isn't complete
+func (r *fileReader) Close() error {
+	err := r.finish(errAborted)
+	if err == io.EOF || err == errAborted {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,471 @@
+package rsync
+
+// This implements the client side of the rsync wire protocol with
+// the server as the sender.
+//
+// It speaks protocol version 27 which every rsync since 2.6.0
+// understands. This is much simpler than the current protocol as it
+// has no incremental recursion, varints or negotiated checksums, so
+// all the integers are little endian int32s and the checksums are
+// MD4.
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	protocolVersion    = 27 // the version of the protocol we speak
+	minProtocolVersion = 27 // the oldest server protocol we can use
+	daemonPrefix       = "@RSYNCD: "
+	ndxDone            = -1      // marks the end of a phase of the transfer
+	maxNameLength      = 4096    // longest file name we accept
+	maxLiteral         = 1 << 24 // most literal data we accept in one token
+
+	// Multiplexed message codes
+	mplexBase    = 7
+	msgData      = 0
+	msgErrorXfer = 1
+	msgInfo      = 2
+	msgError     = 3
+	msgWarning   = 4
+	msgIOError   = 22
+
+	// File list flags for protocol 27
+	xmitSameMode = 1 << 1
+	xmitSameName = 1 << 5
+	xmitLongName = 1 << 6
+	xmitSameTime = 1 << 7
+
+	// File types in the mode
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeRegular  = 0100000
+)
+
+// fileEntry is an entry in the file list sent by the server
+type fileEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    uint32
+}
+
+// isDir returns true if the entry is a directory
+func (e *fileEntry) isDir() bool {
+	return e.mode&modeTypeMask == modeDir
+}
+
+// isRegular returns true if the entry is a regular file
+func (e *fileEntry) isRegular() bool {
+	return e.mode&modeTypeMask == modeRegular
+}
+
+// session is a single run of the rsync protocol over a connection
+//
+// Reads and writes latch the first error into err so a sequence of
+// them can be checked once at the end.
+type session struct {
+	conn     io.ReadWriteCloser // the underlying connection
+	in       *bufio.Reader      // buffered reads from conn
+	out      *bufio.Writer      // buffered writes to conn
+	seed     int32              // checksum seed from the server
+	mux      bool               // set once the server multiplexes its output
+	dataLeft int                // bytes left in the current data message
+	err      error              // first error reading or writing
+	errors   []string           // error messages from the server
+	ioError  int32              // io error flags from the server
+	echoed   bool               // set if the end of phase 0 has been read
+}
+
+// newSession makes a session on conn
+func newSession(conn io.ReadWriteCloser) *session {
+	return &session{
+		conn: conn,
+		in:   bufio.NewReader(conn),
+		out:  bufio.NewWriter(conn),
+	}
+}
+
+// Read reads data from the server, removing the multiplexing if on
+func (s *session) Read(p []byte) (n int, err error) {
+	if !s.mux {
+		return s.in.Read(p)
+	}
+	for s.dataLeft == 0 {
+		err = s.readMessage()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > s.dataLeft {
+		p = p[:s.dataLeft]
+	}
+	n, err = s.in.Read(p)
+	s.dataLeft -= n
+	return n, err
+}
+
+// readMessage reads the header of a multiplexed message, dealing
+// with the message if it isn't data.
+func (s *session) readMessage() error {
+	var header [4]byte
+	_, err := io.ReadFull(s.in, header[:])
+	if err != nil {
+		return err
+	}
+	tag := binary.LittleEndian.Uint32(header[:])
+	code := int(tag>>24) - mplexBase
+	length := int(tag & 0xFFFFFF)
+	if code < 0 {
+		return errors.Errorf("rsync protocol error: bad message tag %#x", tag)
+	}
+	if code == msgData {
+		s.dataLeft = length
+		return nil
+	}
+	message := make([]byte, length)
+	_, err = io.ReadFull(s.in, message)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimRight(string(message), "\n")
+	switch code {
+	case msgError, msgErrorXfer:
+		// These are often expected, e.g. for a missing file, so
+		// keep them for the error rather than logging them
+		fs.Debugf(nil, "rsync server error: %s", text)
+		s.errors = append(s.errors, text)
+	case msgInfo, msgWarning:
+		fs.Infof(nil, "rsync server: %s", text)
+	case msgIOError:
+		if length == 4 {
+			s.ioError |= int32(binary.LittleEndian.Uint32(message))
+		}
+	default:
+		fs.Debugf(nil, "rsync: ignoring message %d from server", code)
+	}
+	return nil
+}
+
+// serverError returns an error with the messages from the server
+func (s *session) serverError(what string) error {
+	if len(s.errors) == 0 {
+		return errors.New(what)
+	}
+	return errors.Errorf("%s: %s", what, strings.Join(s.errors, ", "))
+}
+
+// readFull reads exactly len(buf) bytes
+func (s *session) readFull(buf []byte) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = io.ReadFull(s, buf)
+	if s.err == io.EOF {
+		s.err = io.ErrUnexpectedEOF
+	}
+}
+
+// readByte reads a single byte
+func (s *session) readByte() byte {
+	var buf [1]byte
+	s.readFull(buf[:])
+	return buf[0]
+}
+
+// readInt reads a little endian int32
+func (s *session) readInt() int32 {
+	var buf [4]byte
+	s.readFull(buf[:])
+	return int32(binary.LittleEndian.Uint32(buf[:]))
+}
+
+// readLongint reads an int32 or, if that is -1, an int64
+func (s *session) readLongint() int64 {
+	n := s.readInt()
+	if n != -1 {
+		return int64(n)
+	}
+	var buf [8]byte
+	s.readFull(buf[:])
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// write writes raw bytes
+func (s *session) write(buf []byte) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = s.out.Write(buf)
+}
+
+// writeInt writes a little endian int32
+func (s *session) writeInt(n int32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(n))
+	s.write(buf[:])
+}
+
+// flush sends any buffered writes
+func (s *session) flush() error {
+	if s.err == nil {
+		s.err = s.out.Flush()
+	}
+	return s.err
+}
+
+// readLine reads a line of the daemon handshake
+func (s *session) readLine() (string, error) {
+	line, err := s.in.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", errors.Wrap(err, "failed to read from rsync daemon")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseVersion parses the protocol version from the daemon greeting
+// which looks like "31.0" optionally followed by a list of digests
+func parseVersion(greeting string) (int, error) {
+	if !strings.HasPrefix(greeting, daemonPrefix) {
+		return 0, errors.Errorf("not an rsync daemon: %q", greeting)
+	}
+	version := greeting[len(daemonPrefix):]
+	if i := strings.IndexAny(version, ". "); i >= 0 {
+		version = version[:i]
+	}
+	return strconv.Atoi(version)
+}
+
+// authResponse returns the response to the daemon challenge for the
+// password
+func authResponse(password, challenge string) string {
+	h := md4.New()
+	var seed [4]byte // the seed is always 0 for the authentication
+	_, _ = h.Write(seed[:])
+	_, _ = h.Write([]byte(password))
+	_, _ = h.Write([]byte(challenge))
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// daemonGreeting exchanges the protocol versions with a daemon
+func (s *session) daemonGreeting() error {
+	greeting, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	version, err := parseVersion(greeting)
+	if err != nil {
+		return err
+	}
+	if version < minProtocolVersion {
+		return errors.Errorf("rsync daemon protocol version %d is too old - need %d", version, minProtocolVersion)
+	}
+	s.write([]byte(daemonPrefix + strconv.Itoa(protocolVersion) + ".0\n"))
+	return s.flush()
+}
+
+// daemonModule selects the module on a daemon, authenticating if
+// asked to.
+func (s *session) daemonModule(module, user, password string) error {
+	s.write([]byte(module + "\n"))
+	if err := s.flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == daemonPrefix+"OK":
+			return nil
+		case strings.HasPrefix(line, daemonPrefix+"AUTHREQD "):
+			if user == "" {
+				return errors.Errorf("rsync module %q needs a user and password", module)
+			}
+			challenge := line[len(daemonPrefix+"AUTHREQD "):]
+			s.write([]byte(user + " " + authResponse(password, challenge) + "\n"))
+			if err := s.flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "@ERROR"):
+			return errors.Errorf("rsync daemon: %s", strings.TrimSpace(strings.TrimPrefix(line, "@ERROR:")))
+		case line == daemonPrefix+"EXIT":
+			return errors.Errorf("rsync daemon closed the connection for module %q", module)
+		default:
+			fs.Debugf(nil, "rsync daemon: %s", line)
+		}
+	}
+}
+
+// daemonListModules reads the names of the modules from a daemon
+func (s *session) daemonListModules() (modules []string, err error) {
+	s.write([]byte("\n"))
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == daemonPrefix+"EXIT":
+			return modules, nil
+		case strings.HasPrefix(line, "@ERROR"):
+			return nil, errors.Errorf("rsync daemon: %s", strings.TrimSpace(strings.TrimPrefix(line, "@ERROR:")))
+		case strings.Contains(line, "\t"):
+			// modules are listed as "name\tcomment"
+			modules = append(modules, strings.TrimSpace(strings.SplitN(line, "\t", 2)[0]))
+		default:
+			fs.Debugf(nil, "rsync daemon: %s", line)
+		}
+	}
+}
+
+// daemonArgs sends the arguments for the server to a daemon
+func (s *session) daemonArgs(args []string) error {
+	for _, arg := range args {
+		s.write([]byte(arg + "\n"))
+	}
+	s.write([]byte("\n"))
+	return s.flush()
+}
+
+// shellGreeting exchanges the protocol versions with a server
+// started over a remote shell
+func (s *session) shellGreeting() error {
+	s.writeInt(protocolVersion)
+	if err := s.flush(); err != nil {
+		return err
+	}
+	version := s.readInt()
+	if s.err != nil {
+		return errors.Wrap(s.err, "failed to read rsync protocol version")
+	}
+	if version < minProtocolVersion {
+		return errors.Errorf("rsync protocol version %d is too old - need %d", version, minProtocolVersion)
+	}
+	return nil
+}
+
+// start reads the checksum seed, after which the server multiplexes
+// its output, and sends the empty filter list.
+func (s *session) start() error {
+	s.seed = s.readInt()
+	s.mux = true
+	s.writeInt(0)
+	return s.flush()
+}
+
+// serverArgs returns the arguments for the server to send srcPath
+func serverArgs(flags, srcPath string) []string {
+	return []string{"--server", "--sender", flags, ".", srcPath}
+}
+
+// readFileList reads the file list from the server
+func (s *session) readFileList() (entries []fileEntry, err error) {
+	var last fileEntry
+	for {
+		flags := s.readByte()
+		if s.err != nil {
+			return nil, errors.Wrap(s.err, "failed to read file list")
+		}
+		if flags == 0 {
+			break
+		}
+		var sameLength, length int
+		if flags&xmitSameName != 0 {
+			sameLength = int(s.readByte())
+		}
+		if flags&xmitLongName != 0 {
+			length = int(s.readInt())
+		} else {
+			length = int(s.readByte())
+		}
+		if s.err == nil && (sameLength > len(last.name) || length < 0 || sameLength+length > maxNameLength) {
+			return nil, errors.New("rsync protocol error: bad file name length")
+		}
+		name := make([]byte, length)
+		s.readFull(name)
+		entry := fileEntry{
+			name:    last.name[:sameLength] + string(name),
+			modTime: last.modTime,
+			mode:    last.mode,
+		}
+		entry.size = s.readLongint()
+		if flags&xmitSameTime == 0 {
+			entry.modTime = time.Unix(int64(s.readInt()), 0)
+		}
+		if flags&xmitSameMode == 0 {
+			entry.mode = uint32(s.readInt())
+		}
+		if s.err != nil {
+			return nil, errors.Wrap(s.err, "failed to read file list")
+		}
+		entries = append(entries, entry)
+		last = entry
+	}
+	s.ioError |= s.readInt()
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "failed to read file list")
+	}
+	return entries, nil
+}
+
+// endPhase0 tells the server there are no more files to request
+func (s *session) endPhase0() error {
+	s.writeInt(ndxDone)
+	return s.flush()
+}
+
+// finish ends the transfer cleanly and closes the connection
+//
+// endPhase0 must have been called first
+func (s *session) finish() error {
+	defer s.close()
+	if !s.echoed {
+		if ndx := s.readInt(); s.err == nil && ndx != ndxDone {
+			return errors.Errorf("rsync protocol error: unexpected file %d", ndx)
+		}
+	}
+	// End phase 1 which would redo failed files
+	s.writeInt(ndxDone)
+	if err := s.flush(); err != nil {
+		return errors.Wrap(err, "failed to end rsync transfer")
+	}
+	if ndx := s.readInt(); s.err == nil && ndx != ndxDone {
+		return errors.Errorf("rsync protocol error: unexpected file %d", ndx)
+	}
+	// Read the stats: total read, total written, total size
+	for i := 0; i < 3; i++ {
+		_ = s.readLongint()
+	}
+	// Say goodbye
+	s.writeInt(ndxDone)
+	if err := s.flush(); err != nil {
+		return errors.Wrap(err, "failed to end rsync transfer")
+	}
+	return nil
+}
+
+// close closes the connection without ending the transfer
+func (s *session) close() {
+	err := s.conn.Close()
+	if err != nil {
+		fs.Debugf(nil, "rsync: error closing connection: %v", err)
+	}
+}
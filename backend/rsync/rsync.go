@@ -0,0 +1,809 @@
+// Package rsync provides an interface to rsync daemons and to rsync
+// servers run over ssh.
+//
+// It speaks the rsync wire protocol with the remote end as the
+// sender, so it is read only, and can use the delta transfer
+// algorithm against local copies of the files to download less.
+package rsync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/bucket"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+const (
+	minSleep      = 10 * time.Millisecond
+	maxSleep      = 2 * time.Second
+	decayConstant = 2 // bigger for slower decay, exponential
+	defaultDaemon = 873
+	defaultSSH    = 22
+	partialSuffix = ".rclone-partial"
+)
+
+var errorReadOnly = errors.New("rsync remotes are read only")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "rsync",
+		Description: "rsync daemon or rsync over ssh (read only)",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "host",
+			Help:     "rsync host to connect to",
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "rsync.example.com",
+				Help:  "Connect to rsync.example.com",
+			}},
+		}, {
+			Name:    "transport",
+			Help:    "How to connect to the rsync server",
+			Default: "daemon",
+			Examples: []fs.OptionExample{{
+				Value: "daemon",
+				Help:  "Connect to an rsync daemon (rsync://host/module)",
+			}, {
+				Value: "ssh",
+				Help:  "Run rsync on the host over ssh (host:path)",
+			}},
+		}, {
+			Name:    "port",
+			Help:    "Port to connect to, leave as 0 for the default (873 for daemon, 22 for ssh)",
+			Default: 0,
+		}, {
+			Name: "user",
+			Help: `Username
+
+For the daemon transport this is the rsync user for modules which need
+authentication. For the ssh transport it is the ssh login name - leave
+it blank to use the ssh default.`,
+		}, {
+			Name:       "pass",
+			Help:       "Password for rsync daemon modules which need authentication",
+			IsPassword: true,
+		}, {
+			Name: "ssh",
+			Help: `The ssh command to run for the ssh transport
+
+This is a space separated list of the command and its arguments, for
+example "ssh -i /path/to/key".`,
+			Default:  fs.SpaceSepList{"ssh"},
+			Advanced: true,
+		}, {
+			Name:     "rsync_path",
+			Help:     "The name of the rsync command on the server for the ssh transport",
+			Default:  "rsync",
+			Advanced: true,
+		}, {
+			Name: "copy_links",
+			Help: `Follow symlinks on the server
+
+Normally the server skips symlinks. If this is set they are followed
+and appear as the files or directories they point to.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "basis_dir",
+			Help: `Local directory of old copies of the files for delta transfers
+
+If this is set, rclone looks for a copy of each file it downloads at
+the same path in this directory, for example
+"basis_dir/module/path/to/file" for the daemon transport. If found,
+the rsync delta transfer algorithm is used to download only the parts
+of the file which have changed.
+
+After a complete download of a file the copy in this directory is
+updated so the next download of it can use it.`,
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Host      string          `config:"host"`
+	Transport string          `config:"transport"`
+	Port      int             `config:"port"`
+	User      string          `config:"user"`
+	Pass      string          `config:"pass"`
+	SSH       fs.SpaceSepList `config:"ssh"`
+	RsyncPath string          `config:"rsync_path"`
+	CopyLinks bool            `config:"copy_links"`
+	BasisDir  string          `config:"basis_dir"`
+}
+
+// Fs represents a remote rsync server
+type Fs struct {
+	name     string       // name of this remote
+	root     string       // the path we are working on
+	opt      Options      // parsed options
+	features *fs.Features // optional features
+	pass     string       // revealed password
+	pacer    *fs.Pacer    // pacer for connections
+}
+
+// Object describes an rsync file
+type Object struct {
+	fs      *Fs       // what this object is part of
+	remote  string    // the remote path
+	size    int64     // size of the object
+	modTime time.Time // modification time of the object
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	if f.isDaemon() {
+		return "rsync://" + f.opt.Host + "/" + f.root
+	}
+	return "rsync " + f.opt.Host + ":" + f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// isDaemon returns true if talking to an rsync daemon
+func (f *Fs) isDaemon() bool {
+	return f.opt.Transport == "daemon"
+}
+
+// NewFs constructs an Fs from the path, module:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	switch opt.Transport {
+	case "daemon", "ssh":
+	default:
+		return nil, errors.Errorf("rsync: unknown transport %q - must be daemon or ssh", opt.Transport)
+	}
+	if opt.Port == 0 {
+		opt.Port = defaultDaemon
+		if opt.Transport == "ssh" {
+			opt.Port = defaultSSH
+		}
+	}
+	pass := ""
+	if opt.Pass != "" {
+		pass, err = obscure.Reveal(opt.Pass)
+		if err != nil {
+			return nil, errors.Wrap(err, "rsync: couldn't decrypt password")
+		}
+	}
+	if opt.Transport == "ssh" && len(opt.SSH) == 0 {
+		return nil, errors.New("rsync: ssh command must be set for the ssh transport")
+	}
+	root = path.Clean(root)
+	if opt.Transport == "daemon" {
+		root = strings.Trim(root, "/")
+	}
+	if root == "." {
+		root = ""
+	}
+	f := &Fs{
+		name:  name,
+		root:  root,
+		opt:   *opt,
+		pass:  pass,
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		BucketBased:             f.isDaemon(),
+		BucketBasedRootOK:       f.isDaemon(),
+	}).Fill(ctx, f)
+	_, modulePath := bucket.Split(root)
+	if root != "" && (!f.isDaemon() || modulePath != "") {
+		// Check to see if the root is actually an existing file
+		remote := path.Base(root)
+		f.root = path.Dir(root)
+		if f.root == "." {
+			f.root = ""
+		}
+		_, err := f.NewObject(ctx, remote)
+		if err != nil {
+			if err == fs.ErrorObjectNotFound {
+				// File doesn't exist so return old f
+				f.root = root
+				return f, nil
+			}
+			return nil, err
+		}
+		// return an error with an fs which points to the parent
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// srcPath returns the path on the server of remote
+func (f *Fs) srcPath(remote string) string {
+	p := path.Join(f.root, remote)
+	if p == "" && !f.isDaemon() {
+		// the home directory
+		p = "."
+	}
+	return p
+}
+
+// shellQuote quotes s for the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// sshConn is a connection to rsync run over ssh
+type sshConn struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    io.ReadCloser
+	stderr bytes.Buffer
+}
+
+// Read from the remote rsync
+func (c *sshConn) Read(p []byte) (int, error) {
+	return c.out.Read(p)
+}
+
+// Write to the remote rsync
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+// Close the connection, stopping ssh if it is still running
+func (c *sshConn) Close() error {
+	_ = c.in.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+	if c.stderr.Len() > 0 {
+		fs.Debugf(nil, "rsync: ssh: %s", strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
+
+// sshCommand returns the command to run rsync with args over ssh
+func (f *Fs) sshCommand(ctx context.Context, args []string) *exec.Cmd {
+	sshArgs := append([]string{}, f.opt.SSH[1:]...)
+	if f.opt.Port != defaultSSH {
+		sshArgs = append(sshArgs, "-p", strconv.Itoa(f.opt.Port))
+	}
+	if f.opt.User != "" {
+		sshArgs = append(sshArgs, "-l", f.opt.User)
+	}
+	remoteCommand := []string{f.opt.RsyncPath}
+	for _, arg := range args {
+		remoteCommand = append(remoteCommand, shellQuote(arg))
+	}
+	sshArgs = append(sshArgs, f.opt.Host, strings.Join(remoteCommand, " "))
+	return exec.CommandContext(ctx, f.opt.SSH[0], sshArgs...)
+}
+
+// connect starts the rsync server and does the handshake
+func (f *Fs) connect(ctx context.Context, args []string) (s *session, err error) {
+	if f.isDaemon() {
+		addr := net.JoinHostPort(f.opt.Host, strconv.Itoa(f.opt.Port))
+		conn, err := fshttp.NewDialer(ctx).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to connect to rsync daemon")
+		}
+		s = newSession(conn)
+		err = s.daemonGreeting()
+		if err == nil {
+			if args == nil {
+				return s, nil
+			}
+			module, _ := bucket.Split(args[len(args)-1])
+			err = s.daemonModule(module, f.opt.User, f.pass)
+		}
+		if err == nil {
+			err = s.daemonArgs(args)
+		}
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+	} else {
+		cmd := f.sshCommand(ctx, args)
+		conn := &sshConn{cmd: cmd}
+		cmd.Stderr = &conn.stderr
+		conn.in, err = cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		conn.out, err = cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		fs.Debugf(f, "Running %q", cmd.Args)
+		err = cmd.Start()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to run ssh")
+		}
+		s = newSession(conn)
+		err = s.shellGreeting()
+		if err != nil {
+			s.close()
+			return nil, errors.Wrapf(err, "failed to start rsync over ssh: %s", strings.TrimSpace(conn.stderr.String()))
+		}
+	}
+	err = s.start()
+	if err != nil {
+		s.close()
+		return nil, errors.Wrap(err, "failed to start rsync transfer")
+	}
+	return s, nil
+}
+
+// newSession connects to the server asking it to send srcPath with
+// the flags given, retrying if necessary.
+//
+// If srcPath is "" then it connects to the daemon to list the modules.
+func (f *Fs) newSession(ctx context.Context, flags, srcPath string) (s *session, err error) {
+	var args []string
+	if srcPath != "" {
+		if f.opt.CopyLinks {
+			flags += "L"
+		}
+		args = serverArgs(flags, srcPath)
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		s, err = f.connect(ctx, args)
+		return fserrors.ShouldRetry(err), err
+	})
+	return s, err
+}
+
+// listModules lists the modules on the daemon
+func (f *Fs) listModules(ctx context.Context) (entries fs.DirEntries, err error) {
+	s, err := f.newSession(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	modules, err := s.daemonListModules()
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range modules {
+		entries = append(entries, fs.NewDir(module, time.Time{}))
+	}
+	return entries, nil
+}
+
+// listFiles lists the files in dir, recursively if recurse is set
+func (f *Fs) listFiles(ctx context.Context, dir string, recurse bool) (files []fileEntry, err error) {
+	flags := "-td"
+	if recurse {
+		flags = "-tr"
+	}
+	s, err := f.newSession(ctx, flags, f.srcPath(dir)+"/")
+	if err != nil {
+		return nil, err
+	}
+	files, err = s.readFileList()
+	if err == nil {
+		err = s.endPhase0()
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	err = s.finish()
+	if err != nil {
+		return nil, err
+	}
+	// The directory itself is sent as "." if it exists
+	if len(files) == 0 || files[0].name != "." {
+		return nil, fs.ErrorDirNotFound
+	}
+	return files[1:], nil
+}
+
+// list the files in dir into entries
+func (f *Fs) list(ctx context.Context, dir string, recurse bool) (entries fs.DirEntries, err error) {
+	if f.isDaemon() && path.Join(f.root, dir) == "" {
+		if recurse {
+			return nil, fs.ErrorListBucketRequired
+		}
+		return f.listModules(ctx)
+	}
+	files, err := f.listFiles(ctx, dir, recurse)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		file := &files[i]
+		remote := path.Join(dir, file.name)
+		switch {
+		case file.isDir():
+			entries = append(entries, fs.NewDir(remote, file.modTime))
+		case file.isRegular():
+			entries = append(entries, f.newObject(remote, file))
+		default:
+			fs.Debugf(f, "Skipping non regular file %q", remote)
+		}
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	return f.list(ctx, dir, false)
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+//
+// Don't implement this unless you have a more efficient way
+// of listing recursively than doing a directory traversal.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	entries, err := f.list(ctx, dir, true)
+	if err != nil {
+		return err
+	}
+	return callback(entries)
+}
+
+// newObject makes an Object from the file list entry
+func (f *Fs) newObject(remote string, file *fileEntry) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		size:    file.size,
+		modTime: file.modTime,
+	}
+}
+
+// startFile starts a session sending the file at remote, returning
+// the session and its file list entry.
+func (f *Fs) startFile(ctx context.Context, remote string) (s *session, file *fileEntry, err error) {
+	srcPath := f.srcPath(remote)
+	if f.isDaemon() {
+		if _, modulePath := bucket.Split(srcPath); modulePath == "" {
+			return nil, nil, fs.ErrorObjectNotFound
+		}
+	}
+	s, err = f.newSession(ctx, "-t", srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := s.readFileList()
+	if err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	if len(files) != 1 || !files[0].isRegular() {
+		err = s.endPhase0()
+		if err == nil {
+			err = s.finish()
+		} else {
+			s.close()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fs.ErrorObjectNotFound
+	}
+	return s, &files[0], nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	s, file, err := f.startFile(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	err = s.endPhase0()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	err = s.finish()
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(remote, file), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, errorReadOnly
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, errorReadOnly
+}
+
+// Mkdir makes the root directory of the Fs object
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// Rmdir removes the root directory of the Fs object
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// Precision is the precision of the modification times sent
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash types of the filesystem
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// ------------------------------------------------------------
+
+// Fs is the filesystem this remote rsync file object is located within
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// String returns the URL to the remote rsync file
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote the name of the remote rsync file, relative to the fs root
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns "" since rsync doesn't support remote calculation of hashes
+func (o *Object) Hash(ctx context.Context, r hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size in bytes of the remote rsync file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the remote rsync file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime sets the modification and access time to the specified time
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return errorReadOnly
+}
+
+// Storable returns whether the remote rsync file is a regular file (not a directory, symbolic link, block device, character device, named pipe, etc.)
+func (o *Object) Storable() bool {
+	return true
+}
+
+// basisPath returns the local path of the basis file for o
+func (o *Object) basisPath() string {
+	return filepath.Join(o.fs.opt.BasisDir, filepath.FromSlash(o.fs.srcPath(o.remote)))
+}
+
+// Open a remote rsync file object for reading. Seek is supported
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	s, file, err := o.fs.startFile(ctx, o.remote)
+	if err != nil {
+		return nil, err
+	}
+	r := &fileReader{
+		s:    s,
+		hash: newFileHash(s.seed),
+	}
+	// Use the basis file if there is one and update it if the
+	// whole file is being read
+	var basis, partial *os.File
+	if o.fs.opt.BasisDir != "" {
+		basisPath := o.basisPath()
+		basis, err = os.Open(basisPath)
+		if err == nil {
+			fi, err := basis.Stat()
+			if err == nil && fi.Mode().IsRegular() {
+				r.basis = basis
+				fs.Debugf(o, "Using basis file %q for delta transfer", basisPath)
+			} else {
+				_ = basis.Close()
+				basis = nil
+			}
+		}
+		if offset == 0 && limit < 0 {
+			err = os.MkdirAll(filepath.Dir(basisPath), 0777)
+			if err == nil {
+				partial, err = os.Create(basisPath + partialSuffix)
+			}
+			if err != nil {
+				fs.Errorf(o, "Failed to create basis file: %v", err)
+				partial = nil
+			} else {
+				r.out = partial
+			}
+		}
+	}
+	r.done = func(err error) error {
+		if basis != nil {
+			_ = basis.Close()
+		}
+		if err != io.EOF {
+			s.close()
+			if partial != nil {
+				_ = partial.Close()
+				_ = os.Remove(partial.Name())
+			}
+			return err
+		}
+		finishErr := s.finish()
+		if partial != nil {
+			o.finishBasis(partial, file.modTime)
+		}
+		return finishErr
+	}
+	// Request the file
+	s.writeInt(0)
+	if r.basis != nil {
+		fi, _ := basis.Stat()
+		r.head, err = s.writeSums(r.basis, fi.Size())
+	} else {
+		r.head, err = s.writeSums(nil, 0)
+	}
+	if err == nil {
+		err = s.endPhase0()
+	}
+	if err == nil {
+		err = r.readHeader()
+	}
+	if err != nil {
+		return nil, r.finish(err)
+	}
+	if offset > 0 {
+		_, err = io.CopyN(ioutil.Discard, r, offset)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	return readers.NewLimitedReadCloser(r, limit), nil
+}
+
+// readHeader reads the start of the file sent by the server
+func (r *fileReader) readHeader() error {
+	s := r.s
+	ndx := s.readInt()
+	if s.err != nil {
+		return errors.Wrap(s.err, "failed to read rsync file")
+	}
+	if ndx == ndxDone {
+		// The server couldn't send the file
+		s.echoed = true
+		return s.serverError("rsync server failed to send file")
+	}
+	if ndx != 0 {
+		return errors.Errorf("rsync protocol error: unexpected file %d", ndx)
+	}
+	head := sumHead{
+		count:     s.readInt(),
+		blength:   s.readInt(),
+		s2length:  s.readInt(),
+		remainder: s.readInt(),
+	}
+	if s.err != nil {
+		return errors.Wrap(s.err, "failed to read rsync file")
+	}
+	if head != r.head {
+		return errors.New("rsync protocol error: checksum header mismatch")
+	}
+	r.block = make([]byte, head.blength)
+	return nil
+}
+
+// finishBasis moves the completed partial file into place as the
+// basis for the next transfer
+func (o *Object) finishBasis(partial *os.File, modTime time.Time) {
+	name := partial.Name()
+	err := partial.Close()
+	if err == nil {
+		err = os.Rename(name, o.basisPath())
+	}
+	if err == nil {
+		err = os.Chtimes(o.basisPath(), modTime, modTime)
+	}
+	if err != nil {
+		fs.Errorf(o, "Failed to update basis file: %v", err)
+		_ = os.Remove(name)
+	}
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errorReadOnly
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return errorReadOnly
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs      = &Fs{}
+	_ fs.ListRer = &Fs{}
+	_ fs.Object  = &Object{}
+)
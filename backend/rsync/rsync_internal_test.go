@@ -0,0 +1,698 @@
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testModule = "mod"
+	testSeed   = 0x12345678
+)
+
+var testModTime = time.Date(2020, 6, 1, 12, 30, 15, 0, time.UTC)
+
+func TestChecksums(t *testing.T) {
+	assert.Equal(t, uint32(64225674), checksum1([]byte("abcd")))
+	assert.Equal(t, "31d6cfe0d16ae931b73c59d7e0c089c0", hex.EncodeToString(checksum2(nil, 0)))
+	assert.Equal(t, "a448017aaf21d8525fc10ae87aa6729d", hex.EncodeToString(checksum2([]byte("abc"), 0)))
+	assert.NotEqual(t, checksum2([]byte("abc"), 0), checksum2([]byte("abc"), testSeed))
+}
+
+func TestSumSizes(t *testing.T) {
+	for _, test := range []struct {
+		size int64
+		want sumHead
+	}{
+		{0, sumHead{count: 0, blength: 700, s2length: 2, remainder: 0}},
+		{1000, sumHead{count: 2, blength: 700, s2length: 2, remainder: 300}},
+		{1000000, sumHead{count: 1000, blength: 1000, s2length: 2, remainder: 0}},
+		{1 << 40, sumHead{count: 1 << 20, blength: 1 << 20, s2length: 5, remainder: 0}},
+	} {
+		assert.Equal(t, test.want, sumSizes(test.size), test.size)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"@RSYNCD: 31.0", 31, false},
+		{"@RSYNCD: 31.0 sha512 sha256 md5", 31, false},
+		{"@RSYNCD: 29", 29, false},
+		{"SSH-2.0-OpenSSH", 0, true},
+		{"@RSYNCD: potato", 0, true},
+	} {
+		got, err := parseVersion(test.in)
+		assert.Equal(t, test.wantErr, err != nil, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+// testConn reads and writes the rsync protocol for the fake server
+//
+// Output is multiplexed and the first error is latched.
+type testConn struct {
+	in  *bufio.Reader
+	out *bufio.Writer
+	buf []byte // data waiting to be multiplexed
+	err error
+}
+
+func (c *testConn) read(n int) []byte {
+	buf := make([]byte, n)
+	if c.err == nil {
+		_, c.err = io.ReadFull(c.in, buf)
+	}
+	return buf
+}
+
+func (c *testConn) readInt() int32 {
+	return int32(binary.LittleEndian.Uint32(c.read(4)))
+}
+
+func (c *testConn) write(buf []byte) {
+	c.buf = append(c.buf, buf...)
+}
+
+func (c *testConn) writeInt(n int32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(n))
+	c.write(buf[:])
+}
+
+func (c *testConn) writeByte(b byte) {
+	c.write([]byte{b})
+}
+
+// message sends a multiplexed message with code
+func (c *testConn) message(code int, message []byte) {
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(mplexBase+code)<<24|uint32(len(message)))
+	if c.err == nil {
+		_, c.err = c.out.Write(append(header[:], message...))
+	}
+}
+
+// flush sends the data waiting to be multiplexed
+func (c *testConn) flush() {
+	for len(c.buf) > 0 {
+		n := len(c.buf)
+		if n > 1000 {
+			n = 1000
+		}
+		c.message(msgData, c.buf[:n])
+		c.buf = c.buf[n:]
+	}
+	if c.err == nil {
+		c.err = c.out.Flush()
+	}
+}
+
+// testFile is a file in the fake server's file list
+type testFile struct {
+	name string
+	path string
+	info os.FileInfo
+}
+
+// fakeServer is an rsync daemon which sends testModule from a local
+// directory using protocol 27
+type fakeServer struct {
+	dir      string
+	user     string
+	pass     string
+	listener net.Listener
+	literal  int64 // literal bytes sent
+}
+
+// newFakeServer starts a fake server serving dir
+func newFakeServer(t *testing.T, dir string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &fakeServer{
+		dir:      dir,
+		listener: listener,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				err := srv.handle(conn)
+				if err != nil && err != io.EOF {
+					fs.Debugf(nil, "fake rsync server: %v", err)
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+// config returns the config for an rsync remote for srv
+func (srv *fakeServer) config() configmap.Simple {
+	_, port, _ := net.SplitHostPort(srv.listener.Addr().String())
+	return configmap.Simple{
+		"type":      "rsync",
+		"host":      "127.0.0.1",
+		"port":      port,
+		"transport": "daemon",
+	}
+}
+
+// handle a connection to the daemon
+func (srv *fakeServer) handle(conn net.Conn) error {
+	c := &testConn{
+		in:  bufio.NewReader(conn),
+		out: bufio.NewWriter(conn),
+	}
+	readLine := func() string {
+		line := ""
+		if c.err == nil {
+			line, c.err = c.in.ReadString('\n')
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+	writeLine := func(line string) {
+		if c.err == nil {
+			_, c.err = c.out.WriteString(line + "\n")
+		}
+		if c.err == nil {
+			c.err = c.out.Flush()
+		}
+	}
+	writeLine("@RSYNCD: 31.0")
+	if version := readLine(); c.err == nil && version != "@RSYNCD: 27.0" {
+		return errors.Errorf("bad version %q", version)
+	}
+	module := readLine()
+	if module == "" {
+		writeLine("Welcome to the fake rsync server")
+		writeLine(fmt.Sprintf("%-15s\tTest module", testModule))
+		writeLine("@RSYNCD: EXIT")
+		return c.err
+	}
+	if module != testModule {
+		writeLine(fmt.Sprintf("@ERROR: Unknown module '%s'", module))
+		return c.err
+	}
+	if srv.pass != "" {
+		const challenge = "FwTXrSAn8ZGsn5tGrYVp1A"
+		writeLine("@RSYNCD: AUTHREQD " + challenge)
+		if response := readLine(); response != srv.user+" "+authResponse(srv.pass, challenge) {
+			writeLine("@ERROR: auth failed on module " + module)
+			return c.err
+		}
+	}
+	writeLine("@RSYNCD: OK")
+	var args []string
+	for c.err == nil {
+		arg := readLine()
+		if arg == "" {
+			break
+		}
+		args = append(args, arg)
+	}
+	if c.err != nil {
+		return c.err
+	}
+	if len(args) != 5 || args[0] != "--server" || args[1] != "--sender" {
+		return errors.Errorf("bad args %q", args)
+	}
+	var seed [4]byte
+	binary.LittleEndian.PutUint32(seed[:], testSeed)
+	_, _ = c.out.Write(seed[:])
+	c.flush()
+	if filter := c.readInt(); c.err == nil && filter != 0 {
+		return errors.New("expecting empty filter list")
+	}
+	c.message(msgInfo, []byte("sending files\n"))
+	files := srv.fileList(c, args[2], args[4])
+	srv.sendFileList(c, files)
+	c.flush()
+	for phase := 0; phase < 2 && c.err == nil; {
+		ndx := c.readInt()
+		if ndx == ndxDone {
+			phase++
+			c.writeInt(ndxDone)
+			c.flush()
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(files) {
+			return errors.Errorf("bad file index %d", ndx)
+		}
+		srv.sendFile(c, ndx, files[ndx])
+		c.flush()
+	}
+	for i := 0; i < 3; i++ {
+		c.writeInt(0)
+	}
+	c.flush()
+	if goodbye := c.readInt(); c.err == nil && goodbye != ndxDone {
+		return errors.Errorf("bad goodbye %d", goodbye)
+	}
+	return c.err
+}
+
+// fileList makes the file list for srcPath
+func (srv *fakeServer) fileList(c *testConn, flags, srcPath string) (files []testFile) {
+	rel := strings.TrimPrefix(srcPath, testModule)
+	local := filepath.Join(srv.dir, filepath.FromSlash(rel))
+	fi, err := os.Stat(local)
+	if err != nil {
+		c.message(msgError, []byte(fmt.Sprintf("rsync: link_stat %q (in %s) failed: No such file or directory (2)\n", rel, testModule)))
+		return nil
+	}
+	if !fi.IsDir() {
+		return []testFile{{name: path.Base(rel), path: local, info: fi}}
+	}
+	recurse := strings.Contains(flags, "r")
+	if !strings.HasSuffix(srcPath, "/") || !(recurse || strings.Contains(flags, "d")) {
+		c.message(msgInfo, []byte("skipping directory "+rel+"\n"))
+		return nil
+	}
+	files = append(files, testFile{name: ".", path: local, info: fi})
+	err = filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == local {
+			return err
+		}
+		name, _ := filepath.Rel(local, p)
+		files = append(files, testFile{name: filepath.ToSlash(name), path: p, info: info})
+		if info.IsDir() && !recurse {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		c.err = err
+	}
+	return files
+}
+
+// sendFileList sends the file list, compressing it like rsync does
+func (srv *fakeServer) sendFileList(c *testConn, files []testFile) {
+	var lastName string
+	var lastMode uint32
+	var lastTime int32
+	for _, file := range files {
+		mode := uint32(modeRegular | 0644)
+		if file.info.IsDir() {
+			mode = modeDir | 0755
+		}
+		modTime := int32(file.info.ModTime().Unix())
+		var flags byte
+		same := 0
+		for same < len(lastName) && same < len(file.name) && same < 255 && lastName[same] == file.name[same] {
+			same++
+		}
+		rest := file.name[same:]
+		if same > 0 {
+			flags |= xmitSameName
+		}
+		if len(rest) > 255 {
+			flags |= xmitLongName
+		}
+		if mode == lastMode {
+			flags |= xmitSameMode
+		}
+		if modTime == lastTime {
+			flags |= xmitSameTime
+		}
+		if flags == 0 {
+			if file.info.IsDir() {
+				flags |= xmitLongName
+			} else {
+				flags |= 1 // XMIT_TOP_DIR
+			}
+		}
+		c.writeByte(flags)
+		if same > 0 {
+			c.writeByte(byte(same))
+		}
+		if flags&xmitLongName != 0 {
+			c.writeInt(int32(len(rest)))
+		} else {
+			c.writeByte(byte(len(rest)))
+		}
+		c.write([]byte(rest))
+		c.writeInt(int32(file.info.Size()))
+		if flags&xmitSameTime == 0 {
+			c.writeInt(modTime)
+		}
+		if flags&xmitSameMode == 0 {
+			c.writeInt(int32(mode))
+		}
+		lastName, lastMode, lastTime = file.name, mode, modTime
+	}
+	c.writeByte(0)
+	c.writeInt(0) // io_error
+}
+
+// sendFile reads the checksums of the basis and sends the file as
+// the delta from it
+func (srv *fakeServer) sendFile(c *testConn, ndx int32, file testFile) {
+	head := sumHead{
+		count:     c.readInt(),
+		blength:   c.readInt(),
+		s2length:  c.readInt(),
+		remainder: c.readInt(),
+	}
+	blocks := map[uint32][]int32{}
+	sums2 := make([][]byte, head.count)
+	for i := int32(0); i < head.count && c.err == nil; i++ {
+		sum1 := uint32(c.readInt())
+		blocks[sum1] = append(blocks[sum1], i)
+		sums2[i] = c.read(int(head.s2length))
+	}
+	data, err := ioutil.ReadFile(file.path)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.writeInt(ndx)
+	c.writeInt(head.count)
+	c.writeInt(head.blength)
+	c.writeInt(head.s2length)
+	c.writeInt(head.remainder)
+	var literal []byte
+	sendLiteral := func() {
+		for len(literal) > 0 {
+			n := len(literal)
+			if n > 4096 {
+				n = 4096
+			}
+			c.writeInt(int32(n))
+			c.write(literal[:n])
+			atomic.AddInt64(&srv.literal, int64(n))
+			literal = literal[n:]
+		}
+	}
+	for pos := 0; pos < len(data); {
+		match := int32(-1)
+		for _, length := range []int32{head.blength, head.remainder} {
+			l := int(length)
+			if l == 0 || pos+l > len(data) {
+				continue
+			}
+			block := data[pos : pos+l]
+			for _, i := range blocks[checksum1(block)] {
+				if head.blockLength(i) == int32(l) && bytes.Equal(checksum2(block, testSeed)[:head.s2length], sums2[i]) {
+					match = i
+					break
+				}
+			}
+			if match >= 0 {
+				break
+			}
+		}
+		if match < 0 {
+			literal = append(literal, data[pos])
+			pos++
+			continue
+		}
+		sendLiteral()
+		c.writeInt(-(match + 1))
+		pos += int(head.blockLength(match))
+	}
+	sendLiteral()
+	c.writeInt(0)
+	h := newFileHash(testSeed)
+	_, _ = h.Write(data)
+	c.write(h.Sum(nil))
+}
+
+// makeTestDir makes the directory tree served by the fake server
+// returning the contents of the binary file
+func makeTestDir(t *testing.T) (dir string, binary []byte) {
+	dir, err := ioutil.TempDir("", "rclone-rsync-test")
+	require.NoError(t, err)
+	binary = make([]byte, 10000)
+	_, _ = rand.New(rand.NewSource(1)).Read(binary)
+	for name, data := range map[string][]byte{
+		"file.txt":            []byte("hello world"),
+		"sub/nested.bin":      binary,
+		"sub/deeper/more.txt": []byte("more"),
+		"empty/":              nil,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			require.NoError(t, os.MkdirAll(p, 0777))
+		} else {
+			require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+			require.NoError(t, ioutil.WriteFile(p, data, 0666))
+			require.NoError(t, os.Chtimes(p, testModTime, testModTime))
+		}
+	}
+	return dir, binary
+}
+
+// prepare starts a fake server and returns an Fs for root on it
+func prepare(t *testing.T, root string) (f fs.Fs, srv *fakeServer, binary []byte, cleanup func()) {
+	dir, binary := makeTestDir(t)
+	srv = newFakeServer(t, dir)
+	f, err := NewFs(context.Background(), "rsynctest", root, srv.config())
+	require.NoError(t, err)
+	return f, srv, binary, func() {
+		_ = srv.listener.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// remotes returns the sorted remotes of entries
+func remotes(entries fs.DirEntries) (out []string) {
+	for _, entry := range entries {
+		remote := entry.Remote()
+		if _, ok := entry.(fs.Directory); ok {
+			remote += "/"
+		}
+		out = append(out, remote)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestListModules(t *testing.T) {
+	f, _, _, cleanup := prepare(t, "")
+	defer cleanup()
+	ctx := context.Background()
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mod/"}, remotes(entries))
+
+	_, err = f.List(ctx, "potato")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown module 'potato'")
+}
+
+func TestList(t *testing.T) {
+	f, _, _, cleanup := prepare(t, testModule)
+	defer cleanup()
+	ctx := context.Background()
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"empty/", "file.txt", "sub/"}, remotes(entries))
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok {
+			assert.Equal(t, int64(11), o.Size())
+			assert.Equal(t, testModTime, o.ModTime(ctx).UTC())
+		}
+	}
+
+	entries, err = f.List(ctx, "sub")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sub/deeper/", "sub/nested.bin"}, remotes(entries))
+
+	entries, err = f.List(ctx, "empty")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	_, err = f.List(ctx, "missing")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+	_, err = f.List(ctx, "file.txt")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
+
+func TestListR(t *testing.T) {
+	f, _, _, cleanup := prepare(t, testModule)
+	defer cleanup()
+	ctx := context.Background()
+
+	var entries fs.DirEntries
+	err := f.Features().ListR(ctx, "", func(tranche fs.DirEntries) error {
+		entries = append(entries, tranche...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"empty/", "file.txt", "sub/", "sub/deeper/", "sub/deeper/more.txt", "sub/nested.bin"}, remotes(entries))
+}
+
+func TestNewObject(t *testing.T) {
+	f, srv, _, cleanup := prepare(t, testModule)
+	defer cleanup()
+	ctx := context.Background()
+
+	o, err := f.NewObject(ctx, "sub/nested.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "sub/nested.bin", o.Remote())
+	assert.Equal(t, int64(10000), o.Size())
+	assert.Equal(t, testModTime, o.ModTime(ctx).UTC())
+
+	_, err = f.NewObject(ctx, "missing")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	_, err = f.NewObject(ctx, "sub")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// Pointing at a file should return the parent
+	f2, err := NewFs(ctx, "rsynctest", testModule+"/sub/nested.bin", srv.config())
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, testModule+"/sub", f2.Root())
+}
+
+func TestOpen(t *testing.T) {
+	f, _, binary, cleanup := prepare(t, testModule)
+	defer cleanup()
+	ctx := context.Background()
+
+	o, err := f.NewObject(ctx, "sub/nested.bin")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, binary, data)
+
+	in, err = o.Open(ctx, &fs.RangeOption{Start: 100, End: 199})
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, binary[100:200], data)
+
+	// Closing early should abort the transfer
+	in, err = o.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadFull(in, make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+}
+
+func TestOpenDelta(t *testing.T) {
+	f, srv, binary, cleanup := prepare(t, testModule)
+	defer cleanup()
+	ctx := context.Background()
+	basisDir, err := ioutil.TempDir("", "rclone-rsync-basis")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(basisDir) }()
+	f.(*Fs).opt.BasisDir = basisDir
+	basisPath := filepath.Join(basisDir, testModule, "sub", "nested.bin")
+
+	read := func() (literal int64) {
+		atomic.StoreInt64(&srv.literal, 0)
+		o, err := f.NewObject(ctx, "sub/nested.bin")
+		require.NoError(t, err)
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, binary, data)
+		basis, err := ioutil.ReadFile(basisPath)
+		require.NoError(t, err)
+		assert.Equal(t, binary, basis)
+		return atomic.LoadInt64(&srv.literal)
+	}
+
+	// No basis so the whole file is sent and the basis made
+	assert.Equal(t, int64(10000), read())
+
+	// Unchanged basis so nothing is sent
+	assert.Equal(t, int64(0), read())
+
+	// Change the start of the basis so only some is sent
+	basis := append([]byte("changed"), binary[1000:]...)
+	require.NoError(t, ioutil.WriteFile(basisPath, basis, 0666))
+	literal := read()
+	assert.True(t, literal > 0 && literal < 3000, literal)
+	_, err = os.Stat(basisPath + partialSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAuth(t *testing.T) {
+	f, srv, _, cleanup := prepare(t, "")
+	defer cleanup()
+	ctx := context.Background()
+	srv.user, srv.pass = "user", "secret"
+
+	_, err := f.List(ctx, testModule)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "needs a user and password")
+
+	m := srv.config()
+	m["user"] = "user"
+	m["pass"] = obscure.MustObscure("potato")
+	f, err = NewFs(ctx, "rsynctest", "", m)
+	require.NoError(t, err)
+	_, err = f.List(ctx, testModule)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "auth failed")
+
+	m["pass"] = obscure.MustObscure("secret")
+	f, err = NewFs(ctx, "rsynctest", "", m)
+	require.NoError(t, err)
+	entries, err := f.List(ctx, testModule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mod/empty/", "mod/file.txt", "mod/sub/"}, remotes(entries))
+}
+
+func TestSSHCommand(t *testing.T) {
+	f := &Fs{
+		root: "/srv/it's here",
+		opt: Options{
+			Transport: "ssh",
+			Host:      "example.com",
+			Port:      2222,
+			User:      "bob",
+			SSH:       fs.SpaceSepList{"ssh", "-i", "key"},
+			RsyncPath: "rsync",
+		},
+	}
+	cmd := f.sshCommand(context.Background(), serverArgs("-t", f.srcPath("file.txt")))
+	assert.Equal(t, []string{
+		"ssh", "-i", "key", "-p", "2222", "-l", "bob", "example.com",
+		`rsync '--server' '--sender' '-t' '.' '/srv/it'\''s here/file.txt'`,
+	}, cmd.Args)
+
+	f.root = ""
+	assert.Equal(t, ".", f.srcPath(""))
+}
@@ -59,7 +59,7 @@ import (
 func init() {
 	fs.Register(&fs.RegInfo{
 		Name:        "s3",
-		Description: "Amazon S3 Compliant Storage Providers including AWS, Alibaba, Ceph, Digital Ocean, Dreamhost, IBM COS, Minio, and Tencent COS",
+		Description: "Amazon S3 Compliant Storage Providers including AWS, Alibaba, Ceph, Cloudflare R2, Digital Ocean, Dreamhost, IBM COS, Minio, and Tencent COS",
 		NewFs:       NewFs,
 		CommandHelp: commandHelp,
 		Options: []fs.Option{{
@@ -74,6 +74,9 @@ func init() {
 			}, {
 				Value: "Ceph",
 				Help:  "Ceph Object Storage",
+			}, {
+				Value: "Cloudflare",
+				Help:  "Cloudflare R2 Storage",
 			}, {
 				Value: "DigitalOcean",
 				Help:  "Digital Ocean Spaces",
@@ -216,10 +219,18 @@ func init() {
 				Value: "fr-par",
 				Help:  "Paris, France",
 			}},
+		}, {
+			Name:     "region",
+			Help:     "Region to connect to.\nLeave blank to use \"auto\" which is the only region R2 has.",
+			Provider: "Cloudflare",
+			Examples: []fs.OptionExample{{
+				Value: "auto",
+				Help:  "R2 buckets are automatically distributed across Cloudflare's data centers for low latency.",
+			}},
 		}, {
 			Name:     "region",
 			Help:     "Region to connect to.\nLeave blank if you are using an S3 clone and you don't have a region.",
-			Provider: "!AWS,Alibaba,Scaleway,TencentCOS",
+			Provider: "!AWS,Alibaba,Cloudflare,Scaleway,TencentCOS",
 			Examples: []fs.OptionExample{{
 				Value: "",
 				Help:  "Use this if unsure. Will use v4 signatures and an empty region.",
@@ -573,10 +584,36 @@ func init() {
 				Value: "cos.accelerate.myqcloud.com",
 				Help:  "Use Tencent COS Accelerate Endpoint.",
 			}},
+		}, {
+			Name:     "account_id",
+			Help:     "Cloudflare account ID.\nUsed to make the endpoint if endpoint is blank.",
+			Provider: "Cloudflare",
+		}, {
+			Name: "jurisdiction",
+			Help: `Jurisdiction of the R2 buckets.
+
+Buckets created in a jurisdiction keep their data in it and can only be
+accessed through the endpoint for the jurisdiction. This is used to
+make the endpoint if endpoint is blank.`,
+			Provider: "Cloudflare",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Default jurisdiction",
+			}, {
+				Value: "eu",
+				Help:  "European Union",
+			}, {
+				Value: "fedramp",
+				Help:  "FedRAMP - US government",
+			}},
+		}, {
+			Name:     "endpoint",
+			Help:     "Endpoint for Cloudflare R2 API.\nLeave blank to make it from account_id and jurisdiction.",
+			Provider: "Cloudflare",
 		}, {
 			Name:     "endpoint",
 			Help:     "Endpoint for S3 API.\nRequired when using an S3 clone.",
-			Provider: "!AWS,IBMCOS,TencentCOS,Alibaba,Scaleway,StackPath",
+			Provider: "!AWS,IBMCOS,TencentCOS,Alibaba,Cloudflare,Scaleway,StackPath",
 			Examples: []fs.OptionExample{{
 				Value:    "objects-us-east-1.dream.io",
 				Help:     "Dream Objects endpoint",
@@ -787,10 +824,36 @@ func init() {
 				Value: "tor01-flex",
 				Help:  "Toronto Flex",
 			}},
+		}, {
+			Name:     "location_constraint",
+			Help:     "Location hint for where R2 stores new buckets.\nLeave blank to place buckets near the first request. Used when creating buckets only.",
+			Provider: "Cloudflare",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "No location hint",
+			}, {
+				Value: "wnam",
+				Help:  "Western North America",
+			}, {
+				Value: "enam",
+				Help:  "Eastern North America",
+			}, {
+				Value: "weur",
+				Help:  "Western Europe",
+			}, {
+				Value: "eeur",
+				Help:  "Eastern Europe",
+			}, {
+				Value: "apac",
+				Help:  "Asia-Pacific",
+			}, {
+				Value: "oc",
+				Help:  "Oceania",
+			}},
 		}, {
 			Name:     "location_constraint",
 			Help:     "Location constraint - must be set to match the Region.\nLeave blank if not sure. Used when creating buckets only.",
-			Provider: "!AWS,IBMCOS,Alibaba,Scaleway,StackPath,TencentCOS",
+			Provider: "!AWS,IBMCOS,Alibaba,Cloudflare,Scaleway,StackPath,TencentCOS",
 		}, {
 			Name: "acl",
 			Help: `Canned ACL used when creating buckets and storing or copying objects.
@@ -801,6 +864,7 @@ For more info visit https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview
 
 Note that this ACL is applied when server-side copying objects as S3
 doesn't copy the ACL from the source but rather writes a fresh one.`,
+			Provider: "!Cloudflare",
 			Examples: []fs.OptionExample{{
 				Value:    "default",
 				Help:     "Owner gets Full_CONTROL. No one else has access rights (default).",
@@ -854,6 +918,7 @@ For more info visit https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview
 
 Note that this ACL is applied when only when creating buckets.  If it
 isn't set then "acl" is used instead.`,
+			Provider: "!Cloudflare",
 			Advanced: true,
 			Examples: []fs.OptionExample{{
 				Value: "private",
@@ -1006,6 +1071,20 @@ If you leave it blank, this is calculated automatically from the sse_customer_ke
 				Value: "GLACIER",
 				Help:  "Archived storage; prices are lower, but it needs to be restored first to be accessed.",
 			}},
+		}, {
+			Name:     "storage_class",
+			Help:     "The storage class to use when storing new objects in R2.",
+			Provider: "Cloudflare",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Default",
+			}, {
+				Value: "STANDARD",
+				Help:  "Standard storage class",
+			}, {
+				Value: "STANDARD_IA",
+				Help:  "Infrequent Access storage class - cheaper storage but a charge for reading.",
+			}},
 		}, {
 			Name: "upload_cutoff",
 			Help: `Cutoff for switching to chunked upload
@@ -1235,7 +1314,8 @@ const (
 	minChunkSize        = fs.SizeSuffix(1024 * 1024 * 5)
 	defaultUploadCutoff = fs.SizeSuffix(200 * 1024 * 1024)
 	maxUploadCutoff     = fs.SizeSuffix(5 * 1024 * 1024 * 1024)
-	minSleep            = 10 * time.Millisecond // In case of error, start at 10ms sleep.
+	maxR2UploadCutoff   = maxUploadCutoff - 5*1024*1024 // R2 single part uploads are 5MiB smaller than S3's
+	minSleep            = 10 * time.Millisecond         // In case of error, start at 10ms sleep.
 
 	memoryPoolFlushTime = fs.Duration(time.Minute) // flush the cached buffers after this long
 	memoryPoolUseMmap   = false
@@ -1250,6 +1330,8 @@ type Options struct {
 	SecretAccessKey       string               `config:"secret_access_key"`
 	Region                string               `config:"region"`
 	Endpoint              string               `config:"endpoint"`
+	AccountID             string               `config:"account_id"`
+	Jurisdiction          string               `config:"jurisdiction"`
 	LocationConstraint    string               `config:"location_constraint"`
 	ACL                   string               `config:"acl"`
 	BucketACL             string               `config:"bucket_acl"`
@@ -1569,6 +1651,45 @@ func (f *Fs) setUploadCutoff(cs fs.SizeSuffix) (old fs.SizeSuffix, err error) {
 	return
 }
 
+// cloudflareEndpoint returns the R2 endpoint for the account in the
+// jurisdiction which may be empty
+func cloudflareEndpoint(accountID, jurisdiction string) string {
+	host := accountID
+	if jurisdiction != "" {
+		host += "." + jurisdiction
+	}
+	return "https://" + host + ".r2.cloudflarestorage.com"
+}
+
+// setCloudflareQuirks adjusts the options for the ways Cloudflare R2
+// differs from S3
+func setCloudflareQuirks(opt *Options) error {
+	if opt.Region == "" {
+		opt.Region = "auto"
+	}
+	if opt.Endpoint == "" {
+		if opt.AccountID == "" {
+			return errors.New("account_id must be set if endpoint isn't")
+		}
+		opt.Endpoint = cloudflareEndpoint(opt.AccountID, opt.Jurisdiction)
+	}
+	// R2 doesn't support ACLs so never send them
+	if opt.ACL != "" || opt.BucketACL != "" {
+		fs.Logf(nil, "Ignoring acl and bucket_acl as Cloudflare R2 doesn't support ACLs")
+		opt.ACL, opt.BucketACL = "", ""
+	}
+	// R2 needs all but the last part of a multipart upload to be
+	// the same size, which uploadMultipart does, but allows slightly
+	// smaller single part uploads than S3
+	if opt.UploadCutoff > maxR2UploadCutoff {
+		return errors.Errorf("upload_cutoff %v is greater than %v", opt.UploadCutoff, maxR2UploadCutoff)
+	}
+	if opt.CopyCutoff > maxR2UploadCutoff {
+		return errors.Errorf("copy_cutoff %v is greater than %v", opt.CopyCutoff, maxR2UploadCutoff)
+	}
+	return nil
+}
+
 // setRoot changes the root of the Fs
 func (f *Fs) setRoot(root string) {
 	f.root = parsePath(root)
@@ -1591,11 +1712,18 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if err != nil {
 		return nil, errors.Wrap(err, "s3: upload cutoff")
 	}
-	if opt.ACL == "" {
-		opt.ACL = "private"
-	}
-	if opt.BucketACL == "" {
-		opt.BucketACL = opt.ACL
+	if opt.Provider == "Cloudflare" {
+		err = setCloudflareQuirks(opt)
+		if err != nil {
+			return nil, errors.Wrap(err, "s3: cloudflare")
+		}
+	} else {
+		if opt.ACL == "" {
+			opt.ACL = "private"
+		}
+		if opt.BucketACL == "" {
+			opt.BucketACL = opt.ACL
+		}
 	}
 	if opt.SSECustomerKey != "" && opt.SSECustomerKeyMD5 == "" {
 		// calculate CustomerKeyMD5 if not supplied
@@ -1781,7 +1909,7 @@ func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBuck
 	//
 	// So we enable only on providers we know supports it properly, all others can retry when a
 	// XML Syntax error is detected.
-	var urlEncodeListings = (f.opt.Provider == "AWS" || f.opt.Provider == "Wasabi" || f.opt.Provider == "Alibaba" || f.opt.Provider == "Minio" || f.opt.Provider == "TencentCOS")
+	var urlEncodeListings = (f.opt.Provider == "AWS" || f.opt.Provider == "Wasabi" || f.opt.Provider == "Alibaba" || f.opt.Provider == "Minio" || f.opt.Provider == "TencentCOS" || f.opt.Provider == "Cloudflare")
 	for {
 		// FIXME need to implement ALL loop
 		req := s3.ListObjectsInput{
@@ -2100,7 +2228,9 @@ func (f *Fs) makeBucket(ctx context.Context, bucket string) error {
 	return f.cache.Create(bucket, func() error {
 		req := s3.CreateBucketInput{
 			Bucket: &bucket,
-			ACL:    &f.opt.BucketACL,
+		}
+		if f.opt.BucketACL != "" {
+			req.ACL = &f.opt.BucketACL
 		}
 		if f.opt.LocationConstraint != "" {
 			req.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
@@ -2165,7 +2295,9 @@ func pathEscape(s string) string {
 // method
 func (f *Fs) copy(ctx context.Context, req *s3.CopyObjectInput, dstBucket, dstPath, srcBucket, srcPath string, src *Object) error {
 	req.Bucket = &dstBucket
-	req.ACL = &f.opt.ACL
+	if f.opt.ACL != "" {
+		req.ACL = &f.opt.ACL
+	}
 	req.Key = &dstPath
 	source := pathEscape(path.Join(srcBucket, srcPath))
 	req.CopySource = &source
@@ -2336,6 +2468,29 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	return f.NewObject(ctx, remote)
 }
 
+// CanServerSideFrom returns true if objects in src can be copied to f
+// server-side.
+//
+// S3 copies are done with the credentials of the destination so this
+// is possible if both remotes use the same provider, region, endpoint
+// and credentials, for example for buckets in different accounts
+// which the same key has been granted access to.
+func (f *Fs) CanServerSideFrom(ctx context.Context, src fs.Info) bool {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return false
+	}
+	opt, srcOpt := &f.opt, &srcFs.opt
+	if opt.Provider != srcOpt.Provider || opt.Region != srcOpt.Region || opt.Endpoint != srcOpt.Endpoint {
+		return false
+	}
+	if opt.AccessKeyID == "" && srcOpt.AccessKeyID == "" {
+		// both using the credentials from the environment
+		return opt.EnvAuth && srcOpt.EnvAuth
+	}
+	return opt.AccessKeyID == srcOpt.AccessKeyID
+}
+
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
 	return hash.Set(hash.MD5)
@@ -2468,6 +2623,52 @@ Durations are parsed as per the rest of rclone, 2h, 7d, 7w etc.
 	Opts: map[string]string{
 		"max-age": "Max age of upload to delete",
 	},
+}, {
+	Name:  "lifecycle",
+	Short: "Show the lifecycle rules of a bucket",
+	Long: `This command shows the lifecycle rules of a bucket in JSON format.
+
+    rclone backend lifecycle s3:bucket
+
+It returns an empty list if the bucket has no lifecycle rules.
+`,
+}, {
+	Name:  "set-lifecycle",
+	Short: "Add or replace a lifecycle rule of a bucket",
+	Long: `This command adds a lifecycle rule to a bucket or replaces the rule
+with the same id. It works with Cloudflare R2 and any other provider
+which supports the S3 lifecycle API.
+
+    rclone backend set-lifecycle s3:bucket -o id=expire-tmp -o prefix=tmp/ -o expire-days=7
+    rclone backend set-lifecycle s3:bucket/logs -o id=logs -o transition-days=30 -o storage-class=STANDARD_IA
+    rclone backend set-lifecycle s3:bucket -o id=uploads -o abort-multipart-days=1
+
+If prefix isn't set then the rule applies to the path of the remote,
+or the whole bucket if the remote is just the bucket.
+
+It returns the lifecycle rules of the bucket after the change.
+`,
+	Opts: map[string]string{
+		"id":                   "ID of the rule - required",
+		"prefix":               "Apply the rule to objects with this prefix",
+		"expire-days":          "Delete objects this many days after they are created",
+		"abort-multipart-days": "Abort unfinished multipart uploads this many days after they start",
+		"transition-days":      "Change the storage class of objects this many days after they are created",
+		"storage-class":        "Storage class to change objects to with transition-days",
+		"disabled":             "Add the rule disabled",
+	},
+}, {
+	Name:  "delete-lifecycle",
+	Short: "Delete lifecycle rules of a bucket",
+	Long: `This command deletes the lifecycle rule with the id given or all the
+lifecycle rules of the bucket if no id is given.
+
+    rclone backend delete-lifecycle s3:bucket -o id=expire-tmp
+    rclone backend delete-lifecycle s3:bucket
+`,
+	Opts: map[string]string{
+		"id": "ID of the rule to delete",
+	},
 }}
 
 // Command the backend to run a named command
@@ -2553,11 +2754,198 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			}
 		}
 		return nil, f.cleanUp(ctx, maxAge)
+	case "lifecycle":
+		if f.rootBucket == "" {
+			return nil, errors.New("need a bucket")
+		}
+		return f.getLifecycle(ctx, f.rootBucket)
+	case "set-lifecycle":
+		if f.rootBucket == "" {
+			return nil, errors.New("need a bucket")
+		}
+		rule, err := makeLifecycleRule(opt, f.rootDirectory)
+		if err != nil {
+			return nil, err
+		}
+		return f.setLifecycleRule(ctx, f.rootBucket, rule)
+	case "delete-lifecycle":
+		if f.rootBucket == "" {
+			return nil, errors.New("need a bucket")
+		}
+		return nil, f.deleteLifecycle(ctx, f.rootBucket, opt["id"])
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
 }
 
+// makeLifecycleRule makes a lifecycle rule from the set-lifecycle
+// command options.
+//
+// If the prefix isn't set, rootDirectory is used instead.
+func makeLifecycleRule(opt map[string]string, rootDirectory string) (*s3.LifecycleRule, error) {
+	id := opt["id"]
+	if id == "" {
+		return nil, errors.New("need an id option")
+	}
+	prefix, ok := opt["prefix"]
+	if !ok && rootDirectory != "" {
+		prefix = rootDirectory + "/"
+	}
+	rule := &s3.LifecycleRule{
+		ID:     &id,
+		Filter: &s3.LifecycleRuleFilter{Prefix: &prefix},
+		Status: aws.String(s3.ExpirationStatusEnabled),
+	}
+	if _, ok := opt["disabled"]; ok {
+		rule.Status = aws.String(s3.ExpirationStatusDisabled)
+	}
+	days := func(name string) (*int64, error) {
+		value, ok := opt[name]
+		if !ok {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return nil, errors.Errorf("bad %s %q - must be a number of days", name, value)
+		}
+		return &n, nil
+	}
+	expireDays, err := days("expire-days")
+	if err != nil {
+		return nil, err
+	}
+	if expireDays != nil {
+		rule.Expiration = &s3.LifecycleExpiration{Days: expireDays}
+	}
+	abortDays, err := days("abort-multipart-days")
+	if err != nil {
+		return nil, err
+	}
+	if abortDays != nil {
+		rule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: abortDays}
+	}
+	transitionDays, err := days("transition-days")
+	if err != nil {
+		return nil, err
+	}
+	storageClass := opt["storage-class"]
+	if (transitionDays == nil) != (storageClass == "") {
+		return nil, errors.New("need both transition-days and storage-class")
+	}
+	if transitionDays != nil {
+		rule.Transitions = []*s3.Transition{{
+			Days:         transitionDays,
+			StorageClass: &storageClass,
+		}}
+	}
+	if rule.Expiration == nil && rule.AbortIncompleteMultipartUpload == nil && rule.Transitions == nil {
+		return nil, errors.New("need at least one of expire-days, abort-multipart-days or transition-days")
+	}
+	return rule, nil
+}
+
+// replaceLifecycleRule replaces the rule with the same ID in rules
+// or adds it to the end if there isn't one
+func replaceLifecycleRule(rules []*s3.LifecycleRule, rule *s3.LifecycleRule) []*s3.LifecycleRule {
+	for i := range rules {
+		if aws.StringValue(rules[i].ID) == aws.StringValue(rule.ID) {
+			rules[i] = rule
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// getLifecycle reads the lifecycle rules of the bucket
+func (f *Fs) getLifecycle(ctx context.Context, bucket string) (rules []*s3.LifecycleRule, err error) {
+	var resp *s3.GetBucketLifecycleConfigurationOutput
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.c.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+			Bucket: &bucket,
+		})
+		return f.shouldRetry(err)
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchLifecycleConfiguration" {
+		return []*s3.LifecycleRule{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read lifecycle")
+	}
+	if resp.Rules == nil {
+		return []*s3.LifecycleRule{}, nil
+	}
+	return resp.Rules, nil
+}
+
+// putLifecycle writes the lifecycle rules of the bucket, deleting
+// the lifecycle configuration if there are no rules
+func (f *Fs) putLifecycle(ctx context.Context, bucket string, rules []*s3.LifecycleRule) (err error) {
+	if len(rules) == 0 {
+		err = f.pacer.Call(func() (bool, error) {
+			_, err = f.c.DeleteBucketLifecycleWithContext(ctx, &s3.DeleteBucketLifecycleInput{
+				Bucket: &bucket,
+			})
+			return f.shouldRetry(err)
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to delete lifecycle")
+		}
+		return nil
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		_, err = f.c.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 &bucket,
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+		})
+		return f.shouldRetry(err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set lifecycle")
+	}
+	return nil
+}
+
+// setLifecycleRule adds or replaces rule in the lifecycle rules of
+// the bucket and returns the new rules
+func (f *Fs) setLifecycleRule(ctx context.Context, bucket string, rule *s3.LifecycleRule) (rules []*s3.LifecycleRule, err error) {
+	rules, err = f.getLifecycle(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	rules = replaceLifecycleRule(rules, rule)
+	if operations.SkipDestructive(ctx, bucket, "set lifecycle") {
+		return rules, nil
+	}
+	return rules, f.putLifecycle(ctx, bucket, rules)
+}
+
+// deleteLifecycle deletes the rule with the id from the lifecycle
+// rules of the bucket, or all the rules if id is empty
+func (f *Fs) deleteLifecycle(ctx context.Context, bucket, id string) error {
+	var rules []*s3.LifecycleRule
+	if id != "" {
+		oldRules, err := f.getLifecycle(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, rule := range oldRules {
+			if aws.StringValue(rule.ID) == id {
+				found = true
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		if !found {
+			return errors.Errorf("lifecycle rule %q not found", id)
+		}
+	}
+	if operations.SkipDestructive(ctx, bucket, "delete lifecycle") {
+		return nil
+	}
+	return f.putLifecycle(ctx, bucket, rules)
+}
+
 // listMultipartUploads lists all outstanding multipart uploads for (bucket, key)
 //
 // Note that rather lazily we treat key as a prefix so it matches
@@ -2850,17 +3238,70 @@ func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
 	if o.storageClass == "GLACIER" || o.storageClass == "DEEP_ARCHIVE" {
 		return fs.ErrorCantSetModTime
 	}
+	return o.updateMetadata(ctx, o.meta)
+}
 
-	// Copy the object to itself to update the metadata
+// updateMetadata copies the object to itself to replace its metadata
+// with meta
+func (o *Object) updateMetadata(ctx context.Context, meta map[string]*string) error {
 	bucket, bucketPath := o.split()
 	req := s3.CopyObjectInput{
 		ContentType:       aws.String(fs.MimeType(ctx, o)), // Guess the content type
-		Metadata:          o.meta,
+		Metadata:          meta,
 		MetadataDirective: aws.String(s3.MetadataDirectiveReplace), // replace metadata with that passed in
 	}
 	return o.fs.copy(ctx, &req, bucket, bucketPath, bucket, bucketPath, o)
 }
 
+// Metadata returns the metadata of the object
+//
+// The user metadata is returned as extended attributes, so the
+// X-Amz-Meta-Colour header is returned as "user.colour".
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metadata := fs.Metadata{
+		"mtime": o.ModTime(ctx).Format(time.RFC3339Nano),
+	}
+	for k, v := range o.meta {
+		if k == metaMtime || k == metaMD5Hash || v == nil {
+			continue
+		}
+		metadata[fs.XattrPrefix+strings.ToLower(k)] = *v
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the user metadata of the object from the extended
+// attributes in metadata
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return err
+	}
+	if o.storageClass == "GLACIER" || o.storageClass == "DEEP_ARCHIVE" {
+		return errors.Errorf("can't set metadata of object in %s storage class", o.storageClass)
+	}
+	// Keep the metadata rclone uses
+	meta := map[string]*string{}
+	for _, k := range []string{metaMtime, metaMD5Hash} {
+		if v, ok := o.meta[k]; ok {
+			meta[k] = v
+		}
+	}
+	for k, v := range metadata.Xattrs() {
+		meta[strings.TrimPrefix(k, fs.XattrPrefix)] = aws.String(v)
+	}
+	err = o.updateMetadata(ctx, meta)
+	if err != nil {
+		return err
+	}
+	o.meta = meta
+	return nil
+}
+
 // Storable raturns a boolean indicating if this object is storable
 func (o *Object) Storable() bool {
 	return true
@@ -3149,11 +3590,13 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	mimeType := fs.MimeType(ctx, src)
 	req := s3.PutObjectInput{
 		Bucket:      &bucket,
-		ACL:         &o.fs.opt.ACL,
 		Key:         &bucketPath,
 		ContentType: &mimeType,
 		Metadata:    metadata,
 	}
+	if o.fs.opt.ACL != "" {
+		req.ACL = &o.fs.opt.ACL
+	}
 	if md5sum != "" {
 		req.ContentMD5 = &md5sum
 	}
@@ -3321,14 +3764,17 @@ func (o *Object) GetTier() string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.Commander   = &Fs{}
-	_ fs.CleanUpper  = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
-	_ fs.GetTierer   = &Object{}
-	_ fs.SetTierer   = &Object{}
+	_ fs.Fs                   = &Fs{}
+	_ fs.Copier               = &Fs{}
+	_ fs.ServerSideNegotiator = &Fs{}
+	_ fs.PutStreamer          = &Fs{}
+	_ fs.ListRer              = &Fs{}
+	_ fs.Commander            = &Fs{}
+	_ fs.CleanUpper           = &Fs{}
+	_ fs.Object               = &Object{}
+	_ fs.MimeTyper            = &Object{}
+	_ fs.Metadataer           = &Object{}
+	_ fs.SetMetadataer        = &Object{}
+	_ fs.GetTierer            = &Object{}
+	_ fs.SetTierer            = &Object{}
 )
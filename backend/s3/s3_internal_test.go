@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudflareEndpoint(t *testing.T) {
+	assert.Equal(t, "https://abc123.r2.cloudflarestorage.com", cloudflareEndpoint("abc123", ""))
+	assert.Equal(t, "https://abc123.eu.r2.cloudflarestorage.com", cloudflareEndpoint("abc123", "eu"))
+}
+
+func TestSetCloudflareQuirks(t *testing.T) {
+	opt := &Options{
+		AccountID:    "abc123",
+		Jurisdiction: "eu",
+		ACL:          "public-read",
+		UploadCutoff: defaultUploadCutoff,
+	}
+	require.NoError(t, setCloudflareQuirks(opt))
+	assert.Equal(t, "auto", opt.Region)
+	assert.Equal(t, "https://abc123.eu.r2.cloudflarestorage.com", opt.Endpoint)
+	assert.Equal(t, "", opt.ACL)
+	assert.Equal(t, "", opt.BucketACL)
+
+	// An explicit endpoint and region are kept
+	opt = &Options{Region: "wnam", Endpoint: "https://example.com"}
+	require.NoError(t, setCloudflareQuirks(opt))
+	assert.Equal(t, "wnam", opt.Region)
+	assert.Equal(t, "https://example.com", opt.Endpoint)
+
+	assert.EqualError(t, setCloudflareQuirks(&Options{}), "account_id must be set if endpoint isn't")
+	err := setCloudflareQuirks(&Options{AccountID: "abc123", UploadCutoff: maxUploadCutoff})
+	assert.EqualError(t, err, "upload_cutoff 5G is greater than 4.995G")
+}
+
+func TestMakeLifecycleRule(t *testing.T) {
+	rule, err := makeLifecycleRule(map[string]string{
+		"id":                   "tmp",
+		"prefix":               "tmp/",
+		"expire-days":          "7",
+		"abort-multipart-days": "1",
+	}, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, "tmp", aws.StringValue(rule.ID))
+	assert.Equal(t, "tmp/", aws.StringValue(rule.Filter.Prefix))
+	assert.Equal(t, s3.ExpirationStatusEnabled, aws.StringValue(rule.Status))
+	assert.Equal(t, int64(7), aws.Int64Value(rule.Expiration.Days))
+	assert.Equal(t, int64(1), aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+	assert.Nil(t, rule.Transitions)
+
+	rule, err = makeLifecycleRule(map[string]string{
+		"id":              "logs",
+		"transition-days": "30",
+		"storage-class":   "STANDARD_IA",
+		"disabled":        "true",
+	}, "logs")
+	require.NoError(t, err)
+	assert.Equal(t, "logs/", aws.StringValue(rule.Filter.Prefix))
+	assert.Equal(t, s3.ExpirationStatusDisabled, aws.StringValue(rule.Status))
+	require.Len(t, rule.Transitions, 1)
+	assert.Equal(t, int64(30), aws.Int64Value(rule.Transitions[0].Days))
+	assert.Equal(t, "STANDARD_IA", aws.StringValue(rule.Transitions[0].StorageClass))
+
+	for _, test := range []struct {
+		opt       map[string]string
+		wantError string
+	}{
+		{map[string]string{"expire-days": "7"}, "need an id option"},
+		{map[string]string{"id": "x"}, "need at least one of expire-days, abort-multipart-days or transition-days"},
+		{map[string]string{"id": "x", "expire-days": "potato"}, `bad expire-days "potato" - must be a number of days`},
+		{map[string]string{"id": "x", "expire-days": "0"}, `bad expire-days "0" - must be a number of days`},
+		{map[string]string{"id": "x", "transition-days": "30"}, "need both transition-days and storage-class"},
+		{map[string]string{"id": "x", "storage-class": "STANDARD_IA"}, "need both transition-days and storage-class"},
+	} {
+		_, err := makeLifecycleRule(test.opt, "")
+		assert.EqualError(t, err, test.wantError, test.opt)
+	}
+}
+
+func TestReplaceLifecycleRule(t *testing.T) {
+	rule := func(id string, days int64) *s3.LifecycleRule {
+		return &s3.LifecycleRule{ID: aws.String(id), Expiration: &s3.LifecycleExpiration{Days: aws.Int64(days)}}
+	}
+	rules := replaceLifecycleRule(nil, rule("a", 1))
+	rules = replaceLifecycleRule(rules, rule("b", 2))
+	rules = replaceLifecycleRule(rules, rule("a", 3))
+	require.Len(t, rules, 2)
+	assert.Equal(t, "a", aws.StringValue(rules[0].ID))
+	assert.Equal(t, int64(3), aws.Int64Value(rules[0].Expiration.Days))
+	assert.Equal(t, "b", aws.StringValue(rules[1].ID))
+}
@@ -222,21 +222,24 @@ type Options struct {
 
 // Fs stores the interface to the remote SFTP files
 type Fs struct {
-	name         string
-	root         string
-	absRoot      string
-	opt          Options          // parsed options
-	ci           *fs.ConfigInfo   // global config
-	m            configmap.Mapper // config
-	features     *fs.Features     // optional features
-	config       *ssh.ClientConfig
-	url          string
-	mkdirLock    *stringLock
-	cachedHashes *hash.Set
-	poolMu       sync.Mutex
-	pool         []*conn
-	pacer        *fs.Pacer // pacer for operations
-	savedpswd    string
+	name            string
+	root            string
+	absRoot         string
+	opt             Options          // parsed options
+	ci              *fs.ConfigInfo   // global config
+	m               configmap.Mapper // config
+	features        *fs.Features     // optional features
+	config          *ssh.ClientConfig
+	url             string
+	mkdirLock       *stringLock
+	cachedHashes    *hash.Set
+	poolMu          sync.Mutex
+	pool            []*conn
+	pacer           *fs.Pacer // pacer for operations
+	savedpswd       string
+	compressMu      sync.Mutex // protects the fields below
+	compressChecked bool       // set if the transfer compression has been checked
+	compressMethod  string     // method to compress data in transit with
 }
 
 // Object is a remote SFTP file that has been stat'd (so it exists, but is not necessarily open for reading)
@@ -913,6 +916,50 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	return dstObj, nil
 }
 
+// writerAt implements fs.WriterAtCloser for an sftp file
+type writerAt struct {
+	mu       sync.Mutex // protects the file offset
+	sftpFile *sftp.File
+}
+
+// WriteAt writes p at offset off in the file
+func (w *writerAt) WriteAt(p []byte, off int64) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.sftpFile.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return w.sftpFile.Write(p)
+}
+
+// Close the file
+func (w *writerAt) Close() error {
+	return w.sftpFile.Close()
+}
+
+// UpdateWriterAt opens an existing file with a handle for random
+// access writes without truncating it
+//
+// The file is truncated or extended to size.
+func (f *Fs) UpdateWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	c, err := f.getSftpConnection(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "UpdateWriterAt")
+	}
+	sftpFile, err := c.sftpClient.OpenFile(path.Join(f.absRoot, remote), os.O_WRONLY)
+	f.putSftpConnection(&c, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "UpdateWriterAt open failed")
+	}
+	err = sftpFile.Truncate(size)
+	if err != nil {
+		_ = sftpFile.Close()
+		return nil, errors.Wrap(err, "UpdateWriterAt truncate failed")
+	}
+	return &writerAt{sftpFile: sftpFile}, nil
+}
+
 // DirMove moves src, srcRemote to this remote at dstRemote
 // using server-side move operations.
 //
@@ -1347,6 +1394,11 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.Read
 			}
 		}
 	}
+	if offset == 0 && limit < 0 {
+		if method := o.fs.transferCompression(ctx); method != "" {
+			return o.openCompressed(ctx, method)
+		}
+	}
 	c, err := o.fs.getSftpConnection(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Open")
@@ -1371,6 +1423,17 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	// Clear the hash cache since we are about to update the object
 	o.md5sum = nil
 	o.sha1sum = nil
+	if method := o.fs.transferCompression(ctx); method != "" {
+		err := o.updateCompressed(ctx, method, in)
+		if err != nil {
+			return err
+		}
+		err = o.SetModTime(ctx, src.ModTime(ctx))
+		if err != nil {
+			return errors.Wrap(err, "Update SetModTime failed")
+		}
+		return nil
+	}
 	c, err := o.fs.getSftpConnection(ctx)
 	if err != nil {
 		return errors.Wrap(err, "Update")
@@ -1425,11 +1488,12 @@ func (o *Object) Remove(ctx context.Context) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.Mover       = &Fs{}
-	_ fs.DirMover    = &Fs{}
-	_ fs.Abouter     = &Fs{}
-	_ fs.Shutdowner  = &Fs{}
-	_ fs.Object      = &Object{}
+	_ fs.Fs               = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.Mover            = &Fs{}
+	_ fs.DirMover         = &Fs{}
+	_ fs.Abouter          = &Fs{}
+	_ fs.Shutdowner       = &Fs{}
+	_ fs.UpdateWriterAter = &Fs{}
+	_ fs.Object           = &Object{}
 )
@@ -0,0 +1,215 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/transfercompress"
+	"golang.org/x/crypto/ssh"
+)
+
+// transferCommands are the commands run on the remote end to compress
+// and decompress data in transit for each method.  These are also
+// understood by rclone serve sftp.
+var transferCommands = map[string]struct {
+	probe string // command which should print "rclone" if the method works
+	read  string // command to compress the file named after it to stdout
+	write string // command to decompress stdin to the file named after it
+}{
+	transfercompress.Gzip: {
+		probe: "echo 'rclone' | gzip -c | gzip -d -c",
+		read:  "gzip -c -1 < ",
+		write: "gzip -d -c > ",
+	},
+	transfercompress.Zstd: {
+		probe: "echo 'rclone' | zstd -q -c | zstd -q -d -c",
+		read:  "zstd -q -c -1 < ",
+		write: "zstd -q -d -c > ",
+	},
+}
+
+// transferCompression returns the method to compress data in transit
+// with, or "" if it shouldn't be compressed.
+//
+// The first time it is called it finds out which methods the remote
+// end supports by running their probe commands.
+func (f *Fs) transferCompression(ctx context.Context) string {
+	setting := f.ci.TransferCompression
+	if setting == "" || setting == transfercompress.Off {
+		return ""
+	}
+	f.compressMu.Lock()
+	defer f.compressMu.Unlock()
+	if f.compressChecked {
+		return f.compressMethod
+	}
+	var supported []string
+	for _, method := range transfercompress.Methods {
+		if setting != transfercompress.Auto && setting != method {
+			continue
+		}
+		output, err := f.run(ctx, transferCommands[method].probe)
+		if err == nil && string(bytes.TrimSpace(output)) == "rclone" {
+			supported = append(supported, method)
+		} else {
+			fs.Debugf(f, "Transfer compression %q not supported: %v", method, err)
+		}
+	}
+	f.compressMethod = transfercompress.Choose(setting, supported)
+	f.compressChecked = true
+	if f.compressMethod != "" {
+		fs.Debugf(f, "Using transfer compression %q", f.compressMethod)
+	} else {
+		fs.Logf(f, "Remote doesn't support transfer compression %q - transferring uncompressed", setting)
+	}
+	return f.compressMethod
+}
+
+// shellPath returns the path of the object escaped for the shell
+func (o *Object) shellPath() string {
+	if o.fs.opt.PathOverride != "" {
+		return shellEscape(path.Join(o.fs.opt.PathOverride, o.remote))
+	}
+	return shellEscape(o.path())
+}
+
+// newSession makes a new ssh session to run a command on the remote end
+func (f *Fs) newSession(ctx context.Context) (*ssh.Session, error) {
+	c, err := f.getSftpConnection(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get SFTP connection")
+	}
+	session, err := c.sshClient.NewSession()
+	f.putSftpConnection(&c, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "get SSH session")
+	}
+	return session, nil
+}
+
+// compressedReader reads the decompressed output of a command
+// compressing a file on the remote end
+type compressedReader struct {
+	cmd     string
+	session *ssh.Session
+	stderr  bytes.Buffer
+	in      io.ReadCloser
+}
+
+// wait for the command to finish returning an error if it failed
+func (r *compressedReader) wait() error {
+	err := r.session.Wait()
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %q: %s", r.cmd, bytes.TrimSpace(r.stderr.Bytes()))
+	}
+	return nil
+}
+
+// Read decompressed data
+func (r *compressedReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// Close the reader and the session
+func (r *compressedReader) Close() error {
+	err := r.in.Close()
+	_ = r.session.Close()
+	return err
+}
+
+// openCompressed opens the object for reading with the data
+// compressed in transit with method
+func (o *Object) openCompressed(ctx context.Context, method string) (io.ReadCloser, error) {
+	session, err := o.fs.newSession(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	r := &compressedReader{
+		cmd:     transferCommands[method].read + o.shellPath(),
+		session: session,
+	}
+	session.Stderr = &r.stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, errors.Wrap(err, "Open")
+	}
+	err = session.Start(r.cmd)
+	if err != nil {
+		_ = session.Close()
+		return nil, errors.Wrapf(err, "Open failed to run %q", r.cmd)
+	}
+	r.in, err = transfercompress.NewReader(method, stdout)
+	if err != nil {
+		if waitErr := r.wait(); waitErr != nil {
+			err = waitErr
+		}
+		_ = session.Close()
+		return nil, errors.Wrap(err, "Open failed")
+	}
+	return r, nil
+}
+
+// updateCompressed uploads the data in to the object compressing it
+// in transit with method
+func (o *Object) updateCompressed(ctx context.Context, method string, in io.Reader) (err error) {
+	session, err := o.fs.newSession(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Update")
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+	cmd := transferCommands[method].write + o.shellPath()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Update")
+	}
+	err = session.Start(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "Update failed to run %q", cmd)
+	}
+	out, err := transfercompress.NewWriter(method, stdin)
+	if err != nil {
+		_ = stdin.Close()
+		_ = session.Wait()
+		return errors.Wrap(err, "Update")
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	closeErr = stdin.Close()
+	if err == nil {
+		err = closeErr
+	}
+	waitErr := session.Wait()
+	if waitErr != nil {
+		err = errors.Wrapf(waitErr, "failed to run %q: %s", cmd, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if err != nil {
+		// remove the partial file if the upload failed
+		if removeErr := o.Remove(ctx); removeErr != nil {
+			fs.Debugf(o, "Failed to remove: %v", removeErr)
+		} else {
+			fs.Debugf(o, "Removed after failed upload: %v", err)
+		}
+		return errors.Wrap(err, "Update failed")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package api contains the Microsoft Graph types for SharePoint
+// sites, document libraries and list items
+//
+// The types for the files themselves are in the onedrive backend.
+package api
+
+import "time"
+
+// Site is a SharePoint site
+type Site struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	DisplayName          string    `json:"displayName"`
+	WebURL               string    `json:"webUrl"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+}
+
+// SitesResponse is returned when searching for sites
+type SitesResponse struct {
+	Value    []Site `json:"value"`
+	NextLink string `json:"@odata.nextLink"`
+}
+
+// Drive is a document library of a site
+type Drive struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	DriveType            string    `json:"driveType"`
+	WebURL               string    `json:"webUrl"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+}
+
+// DrivesResponse is returned when listing the drives of a site
+type DrivesResponse struct {
+	Value    []Drive `json:"value"`
+	NextLink string  `json:"@odata.nextLink"`
+}
+
+// ChoiceColumn describes a choice column
+type ChoiceColumn struct {
+	AllowTextEntry bool     `json:"allowTextEntry"`
+	Choices        []string `json:"choices"`
+}
+
+// ColumnDefinition describes a column of a list
+//
+// Only the facet for the type of the column is set.
+type ColumnDefinition struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"displayName"`
+	ReadOnly    bool          `json:"readOnly"`
+	Hidden      bool          `json:"hidden"`
+	Text        *struct{}     `json:"text"`
+	Number      *struct{}     `json:"number"`
+	Currency    *struct{}     `json:"currency"`
+	Boolean     *struct{}     `json:"boolean"`
+	DateTime    *struct{}     `json:"dateTime"`
+	Choice      *ChoiceColumn `json:"choice"`
+}
+
+// Types of column which can be read and written as metadata
+const (
+	ColumnText     = "text"
+	ColumnNumber   = "number"
+	ColumnCurrency = "currency"
+	ColumnBoolean  = "boolean"
+	ColumnDateTime = "dateTime"
+	ColumnChoice   = "choice"
+)
+
+// Type returns the type of the column or "" if it isn't one which
+// can be used as metadata, for example a lookup
+func (c *ColumnDefinition) Type() string {
+	switch {
+	case c.Text != nil:
+		return ColumnText
+	case c.Number != nil:
+		return ColumnNumber
+	case c.Currency != nil:
+		return ColumnCurrency
+	case c.Boolean != nil:
+		return ColumnBoolean
+	case c.DateTime != nil:
+		return ColumnDateTime
+	case c.Choice != nil:
+		return ColumnChoice
+	}
+	return ""
+}
+
+// ColumnsResponse is returned when listing the columns of a list
+type ColumnsResponse struct {
+	Value    []ColumnDefinition `json:"value"`
+	NextLink string             `json:"@odata.nextLink"`
+}
+
+// Publication levels of a file
+const (
+	PublicationPublished = "published"
+	PublicationCheckout  = "checkout"
+)
+
+// Publication is the publishing state of a file
+type Publication struct {
+	Level     string `json:"level"`
+	VersionID string `json:"versionId"`
+}
+
+// ListItem is the list item of a file with its column values
+type ListItem struct {
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// Item is a file with its publication state and list item
+type Item struct {
+	ID          string       `json:"id"`
+	Publication *Publication `json:"publication"`
+	ListItem    *ListItem    `json:"listItem"`
+}
+
+// CheckinRequest is sent to check a file in
+type CheckinRequest struct {
+	Comment string `json:"comment"`
+}
@@ -0,0 +1,1129 @@
+// Package sharepoint provides an interface to the document libraries
+// of a Microsoft SharePoint site.
+package sharepoint
+
+// The files in each document library are handled by the onedrive
+// backend. This adds the listing of the libraries of the site, the
+// columns of the files as metadata and dealing with files which are
+// checked out.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/onedrive"
+	odapi "github.com/rclone/rclone/backend/onedrive/api"
+	"github.com/rclone/rclone/backend/sharepoint/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/oauthutil"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/lib/rest"
+	"golang.org/x/oauth2"
+)
+
+const (
+	minSleep            = 10 * time.Millisecond
+	maxSleep            = 2 * time.Second
+	decayConstant       = 2 // bigger for slower decay, exponential
+	graphURL            = "https://graph.microsoft.com/v1.0"
+	driveTypeSharepoint = "documentLibrary"
+)
+
+// Globals
+var (
+	// Description of how to auth for this app - the same app as
+	// onedrive but with permission to write the columns of sites
+	oauthConfig = func() *oauth2.Config {
+		config := *onedrive.OAuthConfig
+		config.Scopes = []string{"Files.ReadWrite.All", "Sites.ReadWrite.All", "offline_access"}
+		return &config
+	}()
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "sharepoint",
+		Description: "Microsoft SharePoint document libraries",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Config: func(ctx context.Context, name string, m configmap.Mapper) {
+			err := oauthutil.Config(ctx, "sharepoint", name, m, oauthConfig, nil)
+			if err != nil {
+				log.Fatalf("Failed to configure token: %v", err)
+			}
+		},
+		Options: append(append(oauthutil.SharedOptions, []fs.Option{{
+			Name: "site_url",
+			Help: `URL of the SharePoint site
+
+Leave blank to use the root site of the organisation.`,
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "The root site",
+			}, {
+				Value: "https://contoso.sharepoint.com/sites/marketing",
+				Help:  "A site of the organisation contoso",
+			}},
+		}, {
+			Name: "checkin",
+			Help: `Check in files left checked out after uploading them
+
+In libraries which require files to be checked out to change them, new
+files are created checked out, and existing files are checked out to
+update them. Other users can't see the changes until the files are
+checked in, which rclone does unless this is false.`,
+			Default:  true,
+			Advanced: true,
+		}, {
+			Name:     "checkin_comment",
+			Help:     "Comment to check files in with",
+			Default:  "",
+			Advanced: true,
+		}}...), onedrive.SharedOptions...),
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	SiteURL        string `config:"site_url"`
+	Checkin        bool   `config:"checkin"`
+	CheckinComment string `config:"checkin_comment"`
+}
+
+// Fs represents a path in the document libraries of a site
+type Fs struct {
+	name      string                 // name of this remote
+	root      string                 // the path we are working on
+	opt       Options                // parsed options
+	odOpt     onedrive.Options       // options for the libraries
+	features  *fs.Features           // optional features
+	client    *http.Client           // authorised client
+	ts        *oauthutil.TokenSource // token source of client
+	srv       *rest.Client           // the connection to Microsoft Graph
+	pacer     *fs.Pacer              // pacer for API calls
+	siteID    string                 // ID of the site
+	mu        sync.Mutex             // protects libraries
+	libraries map[string]*library    // document libraries by name
+}
+
+// library is a document library of the site
+type library struct {
+	name    string
+	driveID string
+	modTime time.Time
+
+	mu      sync.Mutex
+	fs      *onedrive.Fs                     // set when first used
+	columns map[string]*api.ColumnDefinition // writable columns by name, nil until read
+}
+
+// Object describes a file in a document library
+type Object struct {
+	fs.Object          // the onedrive Object
+	fs        *Fs      // what this object is part of
+	lib       *library // the library it is in
+	remote    string   // the remote path
+	libPath   string   // the path in the library
+}
+
+// objectInfo is an ObjectInfo with a different remote
+type objectInfo struct {
+	fs.ObjectInfo
+	remote string
+}
+
+// Remote returns the remote path
+func (i *objectInfo) Remote() string {
+	return i.remote
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	site := f.opt.SiteURL
+	if site == "" {
+		site = "root site"
+	}
+	return fmt.Sprintf("SharePoint %s root '%s'", site, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+	509, // Bandwidth Limit Exceeded
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+func shouldRetry(resp *http.Response, err error) (bool, error) {
+	if resp != nil && resp.StatusCode == 429 {
+		if retryAfter, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil {
+			return true, pacer.RetryAfterError(err, time.Duration(retryAfter)*time.Second)
+		}
+	}
+	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	errResponse := new(odapi.Error)
+	err := rest.DecodeJSON(resp, &errResponse)
+	if err != nil {
+		fs.Debugf(nil, "Couldn't decode error response: %v", err)
+	}
+	if errResponse.ErrorInfo.Code == "" {
+		errResponse.ErrorInfo.Code = resp.Status
+	}
+	return errResponse
+}
+
+// isLocked returns true if err is because the file is checked out or
+// otherwise locked
+func isLocked(err error) bool {
+	if e, ok := errors.Cause(err).(*odapi.Error); ok {
+		return e.ErrorInfo.Code == "resourceLocked" || strings.HasPrefix(e.ErrorInfo.Code, "423")
+	}
+	return false
+}
+
+// lockedError returns err explaining it if the file is locked
+func lockedError(err error) error {
+	if isLocked(err) {
+		return fserrors.NoRetryError(errors.Wrap(err, "file is locked - it may be checked out by another user"))
+	}
+	return err
+}
+
+// NewFs constructs an Fs from the path, library/path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options structs
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	odOpt := new(onedrive.Options)
+	err = configstruct.Set(m, odOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ts, err := oauthutil.NewClient(ctx, name, m, oauthConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure SharePoint")
+	}
+
+	f := &Fs{
+		name:   name,
+		root:   strings.Trim(root, "/"),
+		opt:    *opt,
+		odOpt:  *odOpt,
+		client: client,
+		ts:     ts,
+		srv:    rest.NewClient(client).SetRoot(graphURL),
+		pacer:  fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+	}
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		ReadMimeType:            true,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	f.srv.SetErrorHandler(errorHandler)
+
+	f.siteID, err = f.findSite(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check to see if the root is a file
+	if lib, libPath := f.split(""); libPath != "" {
+		l, err := f.libraryFs(ctx, lib)
+		if err != nil && err != fs.ErrorDirNotFound {
+			return nil, err
+		}
+		if err == nil {
+			if _, err := l.fs.NewObject(ctx, libPath); err == nil {
+				f.root = path.Dir(f.root)
+				return f, fs.ErrorIsFile
+			}
+		}
+	}
+	return f, nil
+}
+
+// sitePath returns the Graph path of the site at siteURL
+func sitePath(siteURL string) (string, error) {
+	if siteURL == "" {
+		return "/sites/root", nil
+	}
+	u, err := url.Parse(siteURL)
+	if err != nil || u.Host == "" {
+		return "", errors.Errorf("site_url %q should look like https://contoso.sharepoint.com/sites/marketing", siteURL)
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	if p == "" {
+		return "/sites/" + u.Host, nil
+	}
+	return "/sites/" + u.Host + ":" + rest.URLPathEscape(p), nil
+}
+
+// findSite returns the ID of the site
+func (f *Fs) findSite(ctx context.Context) (string, error) {
+	p, err := sitePath(f.opt.SiteURL)
+	if err != nil {
+		return "", err
+	}
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   p,
+	}
+	var site api.Site
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.CallJSON(ctx, &opts, nil, &site)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find site")
+	}
+	return site.ID, nil
+}
+
+// readLibraries reads the document libraries of the site
+func (f *Fs) readLibraries(ctx context.Context) (map[string]*library, error) {
+	libraries := map[string]*library{}
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/sites/" + f.siteID + "/drives",
+	}
+	for {
+		var result api.DrivesResponse
+		err := f.pacer.Call(func() (bool, error) {
+			resp, err := f.srv.CallJSON(ctx, &opts, nil, &result)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list document libraries")
+		}
+		for _, drive := range result.Value {
+			if drive.DriveType != driveTypeSharepoint {
+				continue
+			}
+			name := f.odOpt.Enc.ToStandardName(drive.Name)
+			libraries[name] = &library{
+				name:    name,
+				driveID: drive.ID,
+				modTime: drive.LastModifiedDateTime,
+			}
+		}
+		if result.NextLink == "" {
+			break
+		}
+		opts = rest.Opts{
+			Method:  "GET",
+			RootURL: result.NextLink,
+		}
+	}
+	return libraries, nil
+}
+
+// updateLibraries re-reads the document libraries keeping the ones
+// already in use
+//
+// Call with f.mu held
+func (f *Fs) updateLibraries(ctx context.Context) error {
+	libraries, err := f.readLibraries(ctx)
+	if err != nil {
+		return err
+	}
+	for name, lib := range libraries {
+		if old := f.libraries[name]; old != nil && old.driveID == lib.driveID {
+			libraries[name] = old
+		}
+	}
+	f.libraries = libraries
+	return nil
+}
+
+// findLibrary returns the document library called name
+//
+// Names are case insensitive. It returns fs.ErrorDirNotFound if there
+// isn't one.
+func (f *Fs) findLibrary(ctx context.Context, name string) (*library, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	find := func() *library {
+		if lib := f.libraries[name]; lib != nil {
+			return lib
+		}
+		for libName, lib := range f.libraries {
+			if strings.EqualFold(libName, name) {
+				return lib
+			}
+		}
+		return nil
+	}
+	if lib := find(); lib != nil {
+		return lib, nil
+	}
+	err := f.updateLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lib := find(); lib != nil {
+		return lib, nil
+	}
+	return nil, fs.ErrorDirNotFound
+}
+
+// libraryFs returns the document library called name with its Fs
+func (f *Fs) libraryFs(ctx context.Context, name string) (*library, error) {
+	lib, err := f.findLibrary(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if lib.fs == nil {
+		opt := f.odOpt
+		opt.DriveID = lib.driveID
+		opt.DriveType = driveTypeSharepoint
+		lib.fs, err = onedrive.NewFsWithClient(ctx, f.name, "", &opt, f.client, f.ts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open document library %q", lib.name)
+		}
+	}
+	return lib, nil
+}
+
+// split returns the document library and the path in it of remote
+func (f *Fs) split(remote string) (lib, libPath string) {
+	p := path.Join(f.root, remote)
+	i := strings.IndexRune(p, '/')
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+// newObject wraps the onedrive Object o from lib
+func (f *Fs) newObject(lib *library, o fs.Object, remote, libPath string) *Object {
+	return &Object{
+		Object:  o,
+		fs:      f,
+		lib:     lib,
+		remote:  remote,
+		libPath: libPath,
+	}
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	libName, libPath := f.split(dir)
+	if libName == "" {
+		return f.listLibraries(ctx)
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err = lib.fs.List(ctx, libPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		remote := path.Join(dir, path.Base(entry.Remote()))
+		switch x := entry.(type) {
+		case fs.Object:
+			entries[i] = f.newObject(lib, x, remote, path.Join(libPath, path.Base(remote)))
+		case fs.Directory:
+			entries[i] = fs.NewDirCopy(ctx, x).SetRemote(remote)
+		}
+	}
+	return entries, nil
+}
+
+// listLibraries lists the document libraries as directories
+func (f *Fs) listLibraries(ctx context.Context) (entries fs.DirEntries, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err = f.updateLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range f.libraries {
+		entries = append(entries, fs.NewDir(lib.name, lib.modTime).SetID(lib.driveID))
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	libName, libPath := f.split(remote)
+	if libPath == "" {
+		return nil, fs.ErrorObjectNotFound
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err == fs.ErrorDirNotFound {
+		return nil, fs.ErrorObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	o, err := lib.fs.NewObject(ctx, libPath)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(lib, o, remote, libPath), nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	remote := src.Remote()
+	libName, libPath := f.split(remote)
+	if libPath == "" {
+		return nil, errors.Errorf("can't upload %q as files must be in a document library", remote)
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err == fs.ErrorDirNotFound {
+		return nil, errors.Errorf("document library %q not found", libName)
+	} else if err != nil {
+		return nil, err
+	}
+	info := &objectInfo{ObjectInfo: src, remote: libPath}
+	counter := readers.NewCountingReader(in)
+	o, err := lib.fs.Put(ctx, counter, info, options...)
+	if isLocked(err) && counter.BytesRead() == 0 {
+		// An existing file may need checking out to update it
+		if existing, findErr := lib.fs.NewObject(ctx, libPath); findErr == nil {
+			o := f.newObject(lib, existing, remote, libPath)
+			return o, o.updateCheckedOut(ctx, counter, info, options...)
+		}
+	}
+	if err != nil {
+		return nil, lockedError(err)
+	}
+	newObj := f.newObject(lib, o, remote, libPath)
+	return newObj, newObj.checkinNew(ctx)
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	libName, libPath := f.split(dir)
+	if libName == "" {
+		return nil
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err == fs.ErrorDirNotFound {
+		return errors.Errorf("can't make document library %q - make it in SharePoint first", libName)
+	} else if err != nil {
+		return err
+	}
+	if libPath == "" {
+		return nil
+	}
+	return lib.fs.Mkdir(ctx, libPath)
+}
+
+// removeDir removes the directory dir using fn on its library
+func (f *Fs) removeDir(ctx context.Context, dir string, fn func(lib *onedrive.Fs, libPath string) error) error {
+	libName, libPath := f.split(dir)
+	if libName == "" || libPath == "" {
+		return errors.New("can't remove the site or a document library")
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err != nil {
+		return err
+	}
+	return lockedError(fn(lib.fs, libPath))
+}
+
+// Rmdir deletes the directory if empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.removeDir(ctx, dir, func(lib *onedrive.Fs, libPath string) error {
+		return lib.Rmdir(ctx, libPath)
+	})
+}
+
+// Purge deletes all the files in the directory
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	return f.removeDir(ctx, dir, func(lib *onedrive.Fs, libPath string) error {
+		return lib.Purge(ctx, libPath)
+	})
+}
+
+// Precision return the precision of this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(onedrive.QuickXorHashType)
+}
+
+// copyOrMove does a server side copy or move of src to remote with fn
+func (f *Fs) copyOrMove(ctx context.Context, src fs.Object, remote string, fn func(lib *onedrive.Fs, src fs.Object, libPath string) (fs.Object, error)) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		return nil, nil
+	}
+	libName, libPath := f.split(remote)
+	if libPath == "" {
+		return nil, nil
+	}
+	lib, err := f.libraryFs(ctx, libName)
+	if err != nil {
+		return nil, nil
+	}
+	o, err := fn(lib.fs, srcObj.Object, libPath)
+	if err != nil {
+		return nil, lockedError(err)
+	}
+	dstObj := f.newObject(lib, o, remote, libPath)
+	return dstObj, dstObj.checkinNew(ctx)
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	o, err := f.copyOrMove(ctx, src, remote, func(lib *onedrive.Fs, src fs.Object, libPath string) (fs.Object, error) {
+		return lib.Copy(ctx, src, libPath)
+	})
+	if o == nil && err == nil {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	return o, err
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	o, err := f.copyOrMove(ctx, src, remote, func(lib *onedrive.Fs, src fs.Object, libPath string) (fs.Object, error) {
+		return lib.Move(ctx, src, libPath)
+	})
+	if o == nil && err == nil {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	return o, err
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcLibName, srcLibPath := srcFs.split(srcRemote)
+	dstLibName, dstLibPath := f.split(dstRemote)
+	if srcLibPath == "" || dstLibPath == "" {
+		fs.Debugf(srcFs, "Can't move document libraries")
+		return fs.ErrorCantDirMove
+	}
+	srcLib, err := srcFs.libraryFs(ctx, srcLibName)
+	if err != nil {
+		return err
+	}
+	dstLib, err := f.libraryFs(ctx, dstLibName)
+	if err != nil {
+		return err
+	}
+	return lockedError(dstLib.fs.DirMove(ctx, srcLib.fs, srcLibPath, dstLibPath))
+}
+
+// DirCacheFlush resets the directory cache - used in testing as an
+// optional interface
+func (f *Fs) DirCacheFlush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, lib := range f.libraries {
+		lib.mu.Lock()
+		if lib.fs != nil {
+			lib.fs.DirCacheFlush()
+		}
+		lib.mu.Unlock()
+	}
+}
+
+// listSites lists the sites matching search
+func (f *Fs) listSites(ctx context.Context, search string) (sites []api.Site, err error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/sites",
+		Parameters: url.Values{"search": {search}},
+	}
+	for {
+		var result api.SitesResponse
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err := f.srv.CallJSON(ctx, &opts, nil, &result)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list sites")
+		}
+		sites = append(sites, result.Value...)
+		if result.NextLink == "" {
+			break
+		}
+		opts = rest.Opts{
+			Method:  "GET",
+			RootURL: result.NextLink,
+		}
+	}
+	return sites, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "sites",
+	Short: "List the SharePoint sites",
+	Long: `This lists the sites which can be used with the site_url option,
+optionally those matching a search term.
+
+    rclone backend sites remote: [search]
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "sites":
+		search := "*"
+		if len(arg) > 0 {
+			search = arg[0]
+		}
+		return f.listSites(ctx, search)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object {
+	return o.Object
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if do, ok := o.Object.(fs.MimeTyper); ok {
+		return do.MimeType(ctx)
+	}
+	return ""
+}
+
+// ID returns the ID of the Object if known, or "" if not
+func (o *Object) ID() string {
+	if do, ok := o.Object.(fs.IDer); ok {
+		return do.ID()
+	}
+	return ""
+}
+
+// itemPath returns the Graph path of the file
+func (o *Object) itemPath() string {
+	id := o.ID()
+	if i := strings.IndexRune(id, '#'); i >= 0 {
+		id = id[i+1:]
+	}
+	return "/drives/" + o.lib.driveID + "/items/" + id
+}
+
+// readItem reads the publication state of the file and its columns
+// if withFields is set
+func (o *Object) readItem(ctx context.Context, withFields bool) (*api.Item, error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       o.itemPath(),
+		Parameters: url.Values{"$select": {"id,publication"}},
+	}
+	if withFields {
+		opts.Parameters.Set("$expand", "listItem($expand=fields)")
+	}
+	var item api.Item
+	err := o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, nil, &item)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// checkout checks the file out
+func (o *Object) checkout(ctx context.Context) error {
+	opts := rest.Opts{
+		Method:     "POST",
+		Path:       o.itemPath() + "/checkout",
+		NoResponse: true,
+	}
+	return o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.Call(ctx, &opts)
+		return shouldRetry(resp, err)
+	})
+}
+
+// checkin checks the file in
+func (o *Object) checkin(ctx context.Context) error {
+	opts := rest.Opts{
+		Method:     "POST",
+		Path:       o.itemPath() + "/checkin",
+		NoResponse: true,
+	}
+	req := api.CheckinRequest{
+		Comment: o.fs.opt.CheckinComment,
+	}
+	err := o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, &req, nil)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to check in")
+	}
+	fs.Debugf(o, "Checked in")
+	return nil
+}
+
+// checkinNew checks in a new file if the library left it checked out
+func (o *Object) checkinNew(ctx context.Context) error {
+	if !o.fs.opt.Checkin {
+		return nil
+	}
+	item, err := o.readItem(ctx, false)
+	if err != nil {
+		return errors.Wrap(err, "failed to read publication state")
+	}
+	if item.Publication == nil || item.Publication.Level != api.PublicationCheckout {
+		return nil
+	}
+	return o.checkin(ctx)
+}
+
+// updateCheckedOut checks the file out, updates it and checks it in
+// again
+func (o *Object) updateCheckedOut(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	fs.Debugf(o, "Checking out to update")
+	err := o.checkout(ctx)
+	if err != nil {
+		return lockedError(errors.Wrap(err, "failed to check out"))
+	}
+	err = o.Object.Update(ctx, in, src, options...)
+	if err != nil {
+		fs.Errorf(o, "Leaving checked out after failed update")
+		return lockedError(err)
+	}
+	if !o.fs.opt.Checkin {
+		return nil
+	}
+	return o.checkin(ctx)
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// If the file needs checking out to change it, it is checked out and
+// then checked in again afterwards.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	info := &objectInfo{ObjectInfo: src, remote: o.libPath}
+	counter := readers.NewCountingReader(in)
+	err := o.Object.Update(ctx, counter, info, options...)
+	if isLocked(err) && counter.BytesRead() == 0 {
+		return o.updateCheckedOut(ctx, counter, info, options...)
+	}
+	return lockedError(err)
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return lockedError(o.Object.SetModTime(ctx, modTime))
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return lockedError(o.Object.Remove(ctx))
+}
+
+// columns returns the columns of the library which can be set by name
+func (o *Object) columns(ctx context.Context) (map[string]*api.ColumnDefinition, error) {
+	lib := o.lib
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if lib.columns != nil {
+		return lib.columns, nil
+	}
+	columns := map[string]*api.ColumnDefinition{}
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/drives/" + lib.driveID + "/list/columns",
+	}
+	for {
+		var result api.ColumnsResponse
+		err := o.fs.pacer.Call(func() (bool, error) {
+			resp, err := o.fs.srv.CallJSON(ctx, &opts, nil, &result)
+			return shouldRetry(resp, err)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read columns")
+		}
+		for i := range result.Value {
+			column := &result.Value[i]
+			if !column.ReadOnly && !column.Hidden && column.Type() != "" {
+				columns[column.Name] = column
+			}
+		}
+		if result.NextLink == "" {
+			break
+		}
+		opts = rest.Opts{
+			Method:  "GET",
+			RootURL: result.NextLink,
+		}
+	}
+	lib.columns = columns
+	return columns, nil
+}
+
+// fieldString returns the value of a column as a string
+func fieldString(value interface{}) string {
+	switch x := value.(type) {
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+// fieldValue parses s into the value to set column to
+func fieldValue(column *api.ColumnDefinition, s string) (interface{}, error) {
+	switch column.Type() {
+	case api.ColumnNumber, api.ColumnCurrency:
+		if s == "" {
+			return nil, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	case api.ColumnBoolean:
+		if s == "" {
+			return nil, nil
+		}
+		return strconv.ParseBool(s)
+	case api.ColumnChoice:
+		if strings.HasPrefix(s, "[") {
+			// a choice column with multiple values
+			var values []string
+			err := json.Unmarshal([]byte(s), &values)
+			return values, err
+		}
+	}
+	return s, nil
+}
+
+// Metadata returns the metadata of the object
+//
+// The columns of the file which can be set are returned as extended
+// attributes, so the column "Category" is returned as
+// "user.Category". The publication state is returned as
+// "publication" which is "checkout" if the file is checked out.
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	columns, err := o.columns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	item, err := o.readItem(ctx, true)
+	if err != nil {
+		return nil, lockedError(err)
+	}
+	metadata := fs.Metadata{
+		"mtime": o.ModTime(ctx).Format(time.RFC3339),
+	}
+	if item.Publication != nil && item.Publication.Level != "" {
+		metadata["publication"] = item.Publication.Level
+	}
+	if item.ListItem != nil {
+		for name, value := range item.ListItem.Fields {
+			if columns[name] != nil && value != nil {
+				metadata[fs.XattrPrefix+name] = fieldString(value)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the columns of the file from the extended
+// attributes in metadata
+//
+// Columns which aren't in metadata are cleared. Extended attributes
+// which aren't columns which can be set are ignored.
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	current, err := o.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	columns, err := o.columns(ctx)
+	if err != nil {
+		return err
+	}
+	xattrs := metadata.Xattrs()
+	fields := map[string]interface{}{}
+	for k, v := range xattrs {
+		name := strings.TrimPrefix(k, fs.XattrPrefix)
+		column := columns[name]
+		if column == nil {
+			fs.Debugf(o, "Ignoring %q as it isn't a column which can be set", k)
+			continue
+		}
+		if current[k] == v {
+			continue
+		}
+		value, err := fieldValue(column, v)
+		if err != nil {
+			return errors.Wrapf(err, "bad value for column %q", name)
+		}
+		fields[name] = value
+		if _, ok := value.([]string); ok {
+			fields[name+"@odata.type"] = "Collection(Edm.String)"
+		}
+	}
+	for k := range current.Xattrs() {
+		if _, found := xattrs[k]; !found {
+			fields[strings.TrimPrefix(k, fs.XattrPrefix)] = nil
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	opts := rest.Opts{
+		Method:     "PATCH",
+		Path:       o.itemPath() + "/listItem/fields",
+		NoResponse: true,
+	}
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.CallJSON(ctx, &opts, &fields, nil)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return lockedError(errors.Wrap(err, "failed to set columns"))
+	}
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+	_ fs.IDer            = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+	_ fs.Metadataer      = (*Object)(nil)
+	_ fs.SetMetadataer   = (*Object)(nil)
+)
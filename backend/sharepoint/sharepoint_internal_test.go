@@ -0,0 +1,378 @@
+package sharepoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	odapi "github.com/rclone/rclone/backend/onedrive/api"
+	"github.com/rclone/rclone/backend/sharepoint/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/rest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSitePath(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "/sites/root", false},
+		{"https://contoso.sharepoint.com", "/sites/contoso.sharepoint.com", false},
+		{"https://contoso.sharepoint.com/", "/sites/contoso.sharepoint.com", false},
+		{"https://contoso.sharepoint.com/sites/marketing", "/sites/contoso.sharepoint.com:/sites/marketing", false},
+		{"https://contoso.sharepoint.com/sites/sales team/", "/sites/contoso.sharepoint.com:/sites/sales%20team", false},
+		{"contoso", "", true},
+	} {
+		got, err := sitePath(test.in)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, test := range []struct {
+		root, remote    string
+		wantLib, wantIn string
+	}{
+		{"", "", "", ""},
+		{"", "Documents", "Documents", ""},
+		{"", "Documents/a/b.txt", "Documents", "a/b.txt"},
+		{"Documents", "", "Documents", ""},
+		{"Documents", "a/b.txt", "Documents", "a/b.txt"},
+		{"Documents/a", "b.txt", "Documents", "a/b.txt"},
+	} {
+		f := &Fs{root: test.root}
+		gotLib, gotIn := f.split(test.remote)
+		assert.Equal(t, test.wantLib, gotLib, test)
+		assert.Equal(t, test.wantIn, gotIn, test)
+	}
+}
+
+func TestColumnType(t *testing.T) {
+	assert.Equal(t, api.ColumnText, (&api.ColumnDefinition{Text: &struct{}{}}).Type())
+	assert.Equal(t, api.ColumnNumber, (&api.ColumnDefinition{Number: &struct{}{}}).Type())
+	assert.Equal(t, api.ColumnChoice, (&api.ColumnDefinition{Choice: &api.ChoiceColumn{}}).Type())
+	assert.Equal(t, "", (&api.ColumnDefinition{}).Type())
+}
+
+func TestFieldString(t *testing.T) {
+	assert.Equal(t, "hello", fieldString("hello"))
+	assert.Equal(t, "true", fieldString(true))
+	assert.Equal(t, "1.5", fieldString(1.5))
+	assert.Equal(t, "42", fieldString(42.0))
+	assert.Equal(t, `["a","b"]`, fieldString([]interface{}{"a", "b"}))
+}
+
+func TestFieldValue(t *testing.T) {
+	number := &api.ColumnDefinition{Number: &struct{}{}}
+	boolean := &api.ColumnDefinition{Boolean: &struct{}{}}
+	choice := &api.ColumnDefinition{Choice: &api.ChoiceColumn{}}
+	text := &api.ColumnDefinition{Text: &struct{}{}}
+
+	v, err := fieldValue(number, "1.5")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, v)
+	_, err = fieldValue(number, "potato")
+	assert.Error(t, err)
+	v, err = fieldValue(number, "")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = fieldValue(boolean, "true")
+	require.NoError(t, err)
+	assert.Equal(t, true, v)
+
+	v, err = fieldValue(choice, "Red")
+	require.NoError(t, err)
+	assert.Equal(t, "Red", v)
+	v, err = fieldValue(choice, `["Red","Blue"]`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Red", "Blue"}, v)
+
+	v, err = fieldValue(text, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", v)
+}
+
+func TestIsLocked(t *testing.T) {
+	locked := &odapi.Error{}
+	locked.ErrorInfo.Code = "resourceLocked"
+	assert.True(t, isLocked(locked))
+	assert.True(t, isLocked(errors.Wrap(locked, "wrapped")))
+	status := &odapi.Error{}
+	status.ErrorInfo.Code = "423 Locked"
+	assert.True(t, isLocked(status))
+	other := &odapi.Error{}
+	other.ErrorInfo.Code = "itemNotFound"
+	assert.False(t, isLocked(other))
+	assert.False(t, isLocked(errors.New("boom")))
+	assert.False(t, isLocked(nil))
+}
+
+// fakeGraph is a minimal Microsoft Graph server for a site
+type fakeGraph struct {
+	mu          sync.Mutex
+	publication string                 // publication level of the item
+	fields      map[string]interface{} // columns of the item
+	patched     map[string]interface{} // last fields PATCHed
+	calls       []string               // method and path of the calls
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, r.Method+" "+r.URL.Path)
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /sites/contoso.sharepoint.com:/sites/marketing":
+		reply(api.Site{ID: "site1", Name: "marketing"})
+	case "GET /sites/site1/drives":
+		if r.URL.Query().Get("page") == "" {
+			reply(map[string]interface{}{
+				"value": []api.Drive{
+					{ID: "d1", Name: "Documents", DriveType: driveTypeSharepoint},
+					{ID: "p1", Name: "OneDrive", DriveType: "business"},
+				},
+				"@odata.nextLink": "http://" + r.Host + "/sites/site1/drives?page=2",
+			})
+		} else {
+			reply(api.DrivesResponse{Value: []api.Drive{
+				{ID: "d2", Name: "Site Assets", DriveType: driveTypeSharepoint},
+			}})
+		}
+	case "GET /drives/d1/list/columns":
+		reply(api.ColumnsResponse{Value: []api.ColumnDefinition{
+			{Name: "Category", Choice: &api.ChoiceColumn{}},
+			{Name: "Rating", Number: &struct{}{}},
+			{Name: "Title", Text: &struct{}{}},
+			{Name: "Created", DateTime: &struct{}{}, ReadOnly: true},
+			{Name: "Secret", Text: &struct{}{}, Hidden: true},
+		}})
+	case "GET /drives/d1/items/item1":
+		reply(api.Item{
+			ID:          "item1",
+			Publication: &api.Publication{Level: g.publication},
+			ListItem:    &api.ListItem{Fields: g.fields},
+		})
+	case "PATCH /drives/d1/items/item1/listItem/fields":
+		g.patched = map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&g.patched)
+		reply(g.patched)
+	case "POST /drives/d1/items/item1/checkout":
+		g.publication = api.PublicationCheckout
+		w.WriteHeader(http.StatusNoContent)
+	case "POST /drives/d1/items/item1/checkin":
+		g.publication = api.PublicationPublished
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, `{"error":{"code":"itemNotFound","message":"%s not found"}}`, r.URL.Path)
+	}
+}
+
+// newTestFs makes an Fs talking to a fake Graph server
+func newTestFs(t *testing.T) (*Fs, *fakeGraph, func()) {
+	g := &fakeGraph{
+		publication: api.PublicationPublished,
+		fields: map[string]interface{}{
+			"Category":    "Red",
+			"Rating":      3.0,
+			"Created":     "2021-01-02T03:04:05Z",
+			"Secret":      "shh",
+			"@odata.etag": "\"1\"",
+		},
+	}
+	srv := httptest.NewServer(g)
+	ctx := context.Background()
+	f := &Fs{
+		name:  "TestSharePoint",
+		opt:   Options{SiteURL: "https://contoso.sharepoint.com/sites/marketing", Checkin: true, CheckinComment: "rclone"},
+		srv:   rest.NewClient(http.DefaultClient).SetRoot(srv.URL),
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+	}
+	f.odOpt.Enc = encoder.Display
+	f.srv.SetErrorHandler(errorHandler)
+	f.features = (&fs.Features{}).Fill(ctx, f)
+	return f, g, srv.Close
+}
+
+// stubObject is an onedrive object which can be locked
+type stubObject struct {
+	mockobject.Object
+	mu      sync.Mutex
+	locked  *fakeGraph // if set, Update fails unless checked out
+	updates int
+}
+
+// ID returns the ID of the object as onedrive does
+func (o *stubObject) ID() string {
+	return "d1#item1"
+}
+
+// ModTime returns a fixed modification time
+func (o *stubObject) ModTime(ctx context.Context) time.Time {
+	return time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+}
+
+// Update fails with a locked error unless the file is checked out
+func (o *stubObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.locked != nil {
+		o.locked.mu.Lock()
+		level := o.locked.publication
+		o.locked.mu.Unlock()
+		if level != api.PublicationCheckout {
+			err := &odapi.Error{}
+			err.ErrorInfo.Code = "resourceLocked"
+			return err
+		}
+	}
+	_, err := io.Copy(ioutil.Discard, in)
+	o.updates++
+	return err
+}
+
+func TestFindSiteAndLibraries(t *testing.T) {
+	ctx := context.Background()
+	f, _, cleanup := newTestFs(t)
+	defer cleanup()
+
+	siteID, err := f.findSite(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "site1", siteID)
+	f.siteID = siteID
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	assert.ElementsMatch(t, []string{"Documents", "Site Assets"}, names)
+
+	lib, err := f.findLibrary(ctx, "documents")
+	require.NoError(t, err)
+	assert.Equal(t, "d1", lib.driveID)
+
+	_, err = f.findLibrary(ctx, "Missing")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	_, err = f.NewObject(ctx, "Missing/file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	err = f.Mkdir(ctx, "Missing")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "make it in SharePoint first")
+
+	assert.Error(t, f.Rmdir(ctx, "Documents"))
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	f, g, cleanup := newTestFs(t)
+	defer cleanup()
+	o := f.newObject(&library{name: "Documents", driveID: "d1"}, &stubObject{}, "Documents/file.txt", "file.txt")
+
+	metadata, err := o.Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{
+		"mtime":         "2021-02-03T04:05:06Z",
+		"publication":   api.PublicationPublished,
+		"user.Category": "Red",
+		"user.Rating":   "3",
+	}, metadata)
+
+	// Unchanged values aren't sent, absent ones are cleared and
+	// ones which aren't columns are ignored
+	err = o.SetMetadata(ctx, fs.Metadata{
+		"mtime":         "2021-02-03T04:05:06Z",
+		"user.Category": `["Red","Blue"]`,
+		"user.Title":    "Hello",
+		"user.Created":  "2000-01-01T00:00:00Z",
+		"user.Unknown":  "ignored",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Category":            []interface{}{"Red", "Blue"},
+		"Category@odata.type": "Collection(Edm.String)",
+		"Title":               "Hello",
+		"Rating":              nil,
+	}, g.patched)
+
+	err = o.SetMetadata(ctx, fs.Metadata{
+		"user.Category": "Red",
+		"user.Rating":   "potato",
+	})
+	assert.Error(t, err)
+}
+
+func TestCheckout(t *testing.T) {
+	ctx := context.Background()
+	f, g, cleanup := newTestFs(t)
+	defer cleanup()
+	stub := &stubObject{locked: g}
+	o := f.newObject(&library{name: "Documents", driveID: "d1"}, stub, "Documents/file.txt", "file.txt")
+	src := mockobject.New("file.txt")
+
+	// Checked out, updated then checked in
+	err := o.Update(ctx, bytes.NewBufferString("hello"), src)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stub.updates)
+	assert.Equal(t, api.PublicationPublished, g.publication)
+	assert.Equal(t, []string{
+		"POST /drives/d1/items/item1/checkout",
+		"POST /drives/d1/items/item1/checkin",
+	}, g.calls)
+
+	// Left checked out if asked
+	f.opt.Checkin = false
+	g.publication = api.PublicationPublished
+	err = o.Update(ctx, bytes.NewBufferString("hello"), src)
+	require.NoError(t, err)
+	assert.Equal(t, api.PublicationCheckout, g.publication)
+
+	// New files left checked out are checked in
+	f.opt.Checkin = true
+	g.calls = nil
+	require.NoError(t, o.checkinNew(ctx))
+	assert.Equal(t, api.PublicationPublished, g.publication)
+	assert.Equal(t, 2, len(g.calls))
+	g.calls = nil
+	require.NoError(t, o.checkinNew(ctx))
+	assert.Equal(t, []string{"GET /drives/d1/items/item1"}, g.calls)
+}
+
+func TestLockedError(t *testing.T) {
+	locked := &odapi.Error{}
+	locked.ErrorInfo.Code = "resourceLocked"
+	err := lockedError(locked)
+	assert.True(t, strings.Contains(err.Error(), "checked out"))
+	assert.Nil(t, lockedError(nil))
+	other := errors.New("boom")
+	assert.Equal(t, other, lockedError(other))
+}
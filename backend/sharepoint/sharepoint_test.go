@@ -0,0 +1,19 @@
+// Test SharePoint filesystem interface
+package sharepoint
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+//
+// The remote should have a site_url whose site has a document library
+// called "Documents".
+func TestIntegration(t *testing.T) {
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestSharePoint:Documents",
+		NilObject:  (*Object)(nil),
+	})
+}
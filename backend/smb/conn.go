@@ -0,0 +1,575 @@
+package smb
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// This is a minimal SMB2/3 client [MS-SMB2] which runs one request at
+// a time on each connection. Connections are pooled by the Fs so
+// concurrent operations use different connections.
+
+const (
+	maxFrameSize  = 16 * 1024 * 1024 // largest frame accepted from the server
+	creditSize    = 64 * 1024        // bytes of I/O covered by each credit
+	maxIOSize     = 1024 * 1024      // largest read or write issued
+	creditRequest = 256              // credits asked for in each request
+)
+
+// conn is a connection to an SMB server with a single session
+type conn struct {
+	c       net.Conn
+	br      *bufio.Reader
+	server  string        // the name of the server as used in paths
+	timeout time.Duration // IO timeout, 0 for none
+
+	dialect     uint16
+	secMode     uint16 // security mode of the server
+	caps        uint32 // capabilities of the server
+	maxRead     uint32
+	maxWrite    uint32
+	maxTransact uint32
+	cipherID    uint16 // the cipher negotiated, 0 for none
+	preauthHash []byte // preauth integrity hash of the connection
+
+	messageID uint64
+	credits   uint32 // credits available
+
+	sessionID  uint64
+	signingKey []byte      // set if messages are signed
+	encryptAll bool        // set if all messages must be encrypted
+	encrypter  cipher.AEAD // encrypts messages to the server
+	decrypter  cipher.AEAD // decrypts messages from the server
+	nonce      uint64      // counter for the nonces of encrypted messages
+	noncePfx   []byte      // random prefix for the nonces
+
+	trees map[string]*tree // connected trees by lower case share name
+	held  bool             // set while an open file is using the connection
+	err   error            // set if the connection has failed
+}
+
+// tree is a connected share
+type tree struct {
+	id        uint32
+	share     string
+	shareType uint8
+	dfs       bool // set if the share is in a DFS namespace
+	encrypt   bool // set if messages for the share must be encrypted
+}
+
+// dial connects to the server at addr, calling it server in paths,
+// and negotiates the dialect
+func dial(ctx context.Context, addr, server string) (*conn, error) {
+	ci := fs.GetConfig(ctx)
+	nc, err := fshttp.NewDialer(ctx).Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		c:       nc,
+		br:      bufio.NewReader(nc),
+		server:  server,
+		timeout: ci.Timeout,
+		credits: 1,
+		trees:   map[string]*tree{},
+	}
+	err = c.negotiate()
+	if err != nil {
+		_ = nc.Close()
+		return nil, errors.Wrapf(err, "failed to negotiate with %s", addr)
+	}
+	return c, nil
+}
+
+// close logs off and closes the connection
+func (c *conn) close() error {
+	if c.err == nil && c.sessionID != 0 {
+		var w smb2.Writer
+		w.Uint16(4)
+		w.Uint16(0)
+		_, _, _ = c.call(cmdLogoff, nil, 0, 1, w.Bytes())
+	}
+	return c.c.Close()
+}
+
+// ioSize returns the size of a read or write of up to max bytes
+// which can be done with the credits available
+func (c *conn) ioSize(max uint32) uint32 {
+	if max > maxIOSize {
+		max = maxIOSize
+	}
+	if c.dialect == dialect202 || c.caps&capLargeMTU == 0 {
+		if max > creditSize {
+			max = creditSize
+		}
+		return max
+	}
+	if credits := c.credits * creditSize; max > credits {
+		max = credits
+	}
+	return max
+}
+
+// creditCharge returns the credits charged for size bytes of I/O
+func creditCharge(size int) uint16 {
+	if size <= 0 {
+		return 1
+	}
+	return uint16((size-1)/creditSize + 1)
+}
+
+// preauth returns hash updated with msg
+func preauth(hash, msg []byte) []byte {
+	h := sha512.New()
+	_, _ = h.Write(hash)
+	_, _ = h.Write(msg)
+	return h.Sum(nil)
+}
+
+// negotiate the dialect, and for 3.1.1 the preauth integrity and
+// the cipher
+func (c *conn) negotiate() error {
+	guid := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, guid); err != nil {
+		return err
+	}
+	var w smb2.Writer
+	w.Uint16(36)
+	w.Uint16(uint16(len(dialects)))
+	w.Uint16(negotiateSigningEnabled)
+	w.Uint16(0)
+	w.Uint32(capDFS | capLargeMTU | capEncryption)
+	w.Append(guid)
+	ctxOffsetPos := w.Len()
+	w.Zero(8) // negotiate contexts or client start time
+	for _, d := range dialects {
+		w.Uint16(d)
+	}
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	w.Align(8)
+	w.PutUint32(ctxOffsetPos, uint32(headerSize+w.Len()))
+	w.PutUint16(ctxOffsetPos+4, 2)
+	w.Uint16(ctxPreauthIntegrity)
+	w.Uint16(uint16(6 + len(salt)))
+	w.Uint32(0)
+	w.Uint16(1) // hash algorithm count
+	w.Uint16(uint16(len(salt)))
+	w.Uint16(hashSHA512)
+	w.Append(salt)
+	w.Align(8)
+	w.Uint16(ctxEncryption)
+	w.Uint16(6)
+	w.Uint32(0)
+	w.Uint16(2) // cipher count
+	w.Uint16(cipherAES128GCM)
+	w.Uint16(cipherAES128CCM)
+
+	req, resp, status, err := c.exchange(cmdNegotiate, nil, 0, 0, w.Bytes())
+	if err != nil {
+		return err
+	}
+	if status != statusSuccess {
+		return status
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 2)
+	c.secMode = r.Uint16()
+	c.dialect = r.Uint16()
+	ctxCount := int(r.Uint16())
+	r.Skip(16) // server guid
+	c.caps = r.Uint32()
+	c.maxTransact = r.Uint32()
+	c.maxRead = r.Uint32()
+	c.maxWrite = r.Uint32()
+	r.Skip(16) // system time and server start time
+	r.Skip(4)  // security buffer
+	ctxOffset := int(r.Uint32())
+	if r.Err() != nil {
+		return r.Err()
+	}
+	switch c.dialect {
+	case dialect202, dialect210, dialect300, dialect302:
+	case dialect311:
+		c.preauthHash = preauth(preauth(make([]byte, sha512.Size), req), resp)
+		r.Seek(ctxOffset)
+		for i := 0; i < ctxCount; i++ {
+			r.Align(8)
+			ctxType := r.Uint16()
+			data := smb2.NewReader(r.Next(int(r.Uint16()) + 4))
+			data.Skip(4)
+			if ctxType == ctxEncryption && data.Uint16() == 1 {
+				c.cipherID = data.Uint16()
+			}
+		}
+		if r.Err() != nil {
+			return r.Err()
+		}
+	default:
+		return errors.Errorf("server chose unknown dialect 0x%04X", c.dialect)
+	}
+	if c.dialect >= dialect300 && c.dialect < dialect311 && c.caps&capEncryption != 0 {
+		c.cipherID = cipherAES128CCM
+	}
+	fs.Debugf(nil, "SMB negotiated dialect %x with %s", c.dialect, c.server)
+	return nil
+}
+
+// sessionSetup authenticates with a
+//
+// If encrypt is set then all messages are encrypted, and it is an
+// error if the server doesn't support encryption.
+func (c *conn) sessionSetup(a authenticator, encrypt bool) error {
+	token, err := a.initial()
+	if err != nil {
+		return err
+	}
+	hash := c.preauthHash
+	for {
+		var w smb2.Writer
+		w.Uint16(25)
+		w.Uint8(0) // flags
+		w.Uint8(negotiateSigningEnabled)
+		w.Uint32(capDFS)
+		w.Uint32(0) // channel
+		w.Uint16(headerSize + 24)
+		w.Uint16(uint16(len(token)))
+		w.Uint64(0) // previous session id
+		w.Append(token)
+		req, resp, status, err := c.exchange(cmdSessionSetup, nil, 0, 1, w.Bytes())
+		if err != nil {
+			return err
+		}
+		if status != statusSuccess && status != statusMoreProcessingRequired {
+			if status == statusLogonFailure {
+				return errors.Wrap(status, "login failed - check the user name and password")
+			}
+			return errors.Wrap(status, "login failed")
+		}
+		c.sessionID = binary.LittleEndian.Uint64(resp[offSessionID:])
+		r := smb2.NewReader(resp)
+		r.Seek(headerSize + 2)
+		sessionFlags := r.Uint16()
+		offset := int(r.Uint16())
+		length := int(r.Uint16())
+		serverToken := r.At(offset, length)
+		if r.Err() != nil {
+			return r.Err()
+		}
+		if hash != nil {
+			hash = preauth(hash, req)
+		}
+		if status == statusMoreProcessingRequired {
+			if hash != nil {
+				hash = preauth(hash, resp)
+			}
+			token, err = a.next(serverToken)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// authentication has succeeded
+		if len(serverToken) > 0 {
+			if _, err = a.next(serverToken); err != nil {
+				return err
+			}
+		}
+		if sessionFlags&(sessionFlagIsGuest|sessionFlagIsNull) != 0 {
+			if encrypt || sessionFlags&sessionFlagEncryptData != 0 {
+				return errors.New("can't encrypt a guest session - check the user name and password")
+			}
+			fs.Debugf(nil, "SMB logged in to %s as guest", c.server)
+			return nil
+		}
+		sessionKey := a.sessionKey()
+		if len(sessionKey) == 0 {
+			return errors.New("authentication didn't make a session key")
+		}
+		keys := deriveKeys(c.dialect, sessionKey, hash)
+		if binary.LittleEndian.Uint32(resp[offFlags:])&flagsSigned != 0 && !verify(c.dialect, keys.signing, resp) {
+			return errors.New("bad signature on login response - the server may not be who it claims")
+		}
+		c.signingKey = keys.signing
+		if encrypt || sessionFlags&sessionFlagEncryptData != 0 {
+			err = c.startEncryption(keys)
+			if err != nil {
+				return err
+			}
+			c.encryptAll = true
+		} else if c.cipherID != 0 {
+			// shares may need encryption later
+			_ = c.startEncryption(keys)
+		}
+		return nil
+	}
+}
+
+// startEncryption sets up the ciphers for encrypting messages
+func (c *conn) startEncryption(keys sessionKeys) (err error) {
+	if c.dialect < dialect300 || c.cipherID == 0 {
+		return errors.New("server doesn't support SMB3 encryption")
+	}
+	c.encrypter, err = newCipher(c.cipherID, keys.encryption)
+	if err != nil {
+		return err
+	}
+	c.decrypter, err = newCipher(c.cipherID, keys.decryption)
+	if err != nil {
+		return err
+	}
+	c.noncePfx = make([]byte, c.encrypter.NonceSize()-8)
+	_, err = io.ReadFull(rand.Reader, c.noncePfx)
+	return err
+}
+
+// treeConnect connects to share, returning the connected tree if
+// it is already connected
+func (c *conn) treeConnect(share string) (*tree, error) {
+	key := strings.ToLower(share)
+	if t, ok := c.trees[key]; ok {
+		return t, nil
+	}
+	path := smb2.EncodeUTF16(`\\` + c.server + `\` + share)
+	var w smb2.Writer
+	w.Uint16(9)
+	w.Uint16(0)
+	w.Uint16(headerSize + 8)
+	w.Uint16(uint16(len(path)))
+	w.Append(path)
+	resp, status, err := c.call(cmdTreeConnect, nil, 0, 1, w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status != statusSuccess {
+		return nil, status
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 2)
+	t := &tree{
+		id:        binary.LittleEndian.Uint32(resp[offTreeID:]),
+		share:     share,
+		shareType: r.Uint8(),
+	}
+	r.Skip(1)
+	shareFlags := r.Uint32()
+	shareCaps := r.Uint32()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	t.dfs = shareFlags&(shareFlagDFS|shareFlagDFSRoot) != 0 || shareCaps&shareCapDFS != 0
+	if shareFlags&shareFlagEncryptData != 0 && !c.encryptAll {
+		if c.encrypter == nil {
+			return nil, errors.Errorf("share %q needs encryption which the server doesn't support on this connection", share)
+		}
+		t.encrypt = true
+	}
+	c.trees[key] = t
+	return t, nil
+}
+
+// call sends a request and waits for the response, returning the
+// whole response message and its status
+//
+// charge is the number of credits the request needs and flags are
+// set in the header.
+func (c *conn) call(cmd uint16, t *tree, flags uint32, charge uint16, body []byte) (resp []byte, status ntStatus, err error) {
+	_, resp, status, err = c.exchange(cmd, t, flags, charge, body)
+	return resp, status, err
+}
+
+// request sends a request returning the whole response message, or
+// an ntStatus error if it didn't succeed
+func (c *conn) request(cmd uint16, t *tree, flags uint32, charge uint16, body []byte) ([]byte, error) {
+	resp, status, err := c.call(cmd, t, flags, charge, body)
+	if err != nil {
+		return nil, err
+	}
+	if status != statusSuccess {
+		return nil, status
+	}
+	return resp, nil
+}
+
+// exchange sends a request and waits for the response returning
+// the request message sent as well as the response
+func (c *conn) exchange(cmd uint16, t *tree, flags uint32, charge uint16, body []byte) (req, resp []byte, status ntStatus, err error) {
+	if c.err != nil {
+		return nil, nil, 0, c.err
+	}
+	if c.dialect == dialect202 || cmd == cmdNegotiate {
+		charge = 0
+	}
+	var treeID uint32
+	encrypt := c.encryptAll && cmd != cmdSessionSetup && cmd != cmdNegotiate
+	if t != nil {
+		treeID = t.id
+		encrypt = encrypt || t.encrypt
+	}
+	var w smb2.Writer
+	w.Append([]byte("\xfeSMB"))
+	w.Uint16(headerSize)
+	w.Uint16(charge)
+	w.Uint32(0) // channel sequence
+	w.Uint16(cmd)
+	w.Uint16(creditRequest)
+	w.Uint32(flags)
+	w.Uint32(0) // next command
+	messageID := c.messageID
+	w.Uint64(messageID)
+	w.Uint32(0xFEFF) // process id
+	w.Uint32(treeID)
+	w.Uint64(c.sessionID)
+	w.Zero(signatureLength)
+	w.Append(body)
+	req = w.Bytes()
+	if charge == 0 {
+		c.messageID++
+	} else {
+		c.messageID += uint64(charge)
+	}
+	if uint32(charge) > c.credits {
+		c.credits = 0
+	} else {
+		c.credits -= uint32(charge)
+	}
+
+	frame := req
+	switch {
+	case encrypt:
+		c.nonce++
+		nonce := make([]byte, 0, c.encrypter.NonceSize())
+		nonce = append(nonce, c.noncePfx...)
+		var counter [8]byte
+		binary.LittleEndian.PutUint64(counter[:], c.nonce)
+		nonce = append(nonce, counter[:]...)
+		frame = encryptMessage(c.encrypter, nonce, c.sessionID, req)
+	case c.signingKey != nil && cmd != cmdSessionSetup:
+		sign(c.dialect, c.signingKey, req)
+	}
+	if err = c.writeFrame(frame); err != nil {
+		c.err = err
+		return nil, nil, 0, err
+	}
+	for {
+		resp, err = c.readResponse(messageID)
+		if err != nil {
+			c.err = err
+			return nil, nil, 0, err
+		}
+		status = ntStatus(binary.LittleEndian.Uint32(resp[offStatus:]))
+		c.credits += uint32(binary.LittleEndian.Uint16(resp[offCredit:]))
+		if status == statusPending && binary.LittleEndian.Uint32(resp[offFlags:])&flagsAsyncCommand != 0 {
+			// an interim response so wait for the real one
+			continue
+		}
+		return req, resp, status, nil
+	}
+}
+
+// writeFrame sends msg with its length prefix
+func (c *conn) writeFrame(msg []byte) error {
+	if c.timeout > 0 {
+		_ = c.c.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	header := []byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}
+	_, err := c.c.Write(append(header, msg...))
+	return err
+}
+
+// readFrame reads a length prefixed frame
+func (c *conn) readFrame() ([]byte, error) {
+	if c.timeout > 0 {
+		_ = c.c.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	var header [4]byte
+	_, err := io.ReadFull(c.br, header[:])
+	if err != nil {
+		return nil, err
+	}
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if header[0] != 0 || size > maxFrameSize {
+		return nil, errors.New("bad frame from SMB server")
+	}
+	frame := make([]byte, size)
+	_, err = io.ReadFull(c.br, frame)
+	return frame, err
+}
+
+// readResponse reads the response with messageID decrypting it and
+// checking its signature
+func (c *conn) readResponse(messageID uint64) ([]byte, error) {
+	for {
+		msg, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		encrypted := false
+		if len(msg) >= 4 && string(msg[:4]) == string(transformProtocolID) {
+			if c.decrypter == nil {
+				return nil, errors.New("unexpected encrypted message")
+			}
+			var sessionID uint64
+			sessionID, msg, err = decryptMessage(c.decrypter, msg)
+			if err != nil {
+				return nil, err
+			}
+			if sessionID != c.sessionID {
+				return nil, errors.New("encrypted message for the wrong session")
+			}
+			encrypted = true
+		}
+		if len(msg) < headerSize || string(msg[:4]) != "\xfeSMB" {
+			return nil, smb2.ErrBadMessage
+		}
+		if next := binary.LittleEndian.Uint32(msg[offNextCommand:]); next != 0 && int(next) <= len(msg) {
+			// only single responses are expected
+			msg = msg[:next]
+		}
+		flags := binary.LittleEndian.Uint32(msg[offFlags:])
+		id := binary.LittleEndian.Uint64(msg[offMessageID:])
+		if flags&flagsServerToRedir == 0 {
+			return nil, smb2.ErrBadMessage
+		}
+		if id == unsolicitedMessageID {
+			// ignore oplock breaks as no oplocks are taken
+			continue
+		}
+		if id != messageID {
+			return nil, errors.Errorf("unexpected response to message %d while waiting for %d", id, messageID)
+		}
+		if c.signingKey != nil && !encrypted {
+			status := ntStatus(binary.LittleEndian.Uint32(msg[offStatus:]))
+			switch {
+			case flags&flagsSigned != 0:
+				if !verify(c.dialect, c.signingKey, msg) {
+					return nil, errors.New("bad signature on response")
+				}
+			case status == statusSuccess:
+				return nil, errors.New("unsigned response on signed session")
+			}
+		} else if c.encryptAll && !encrypted {
+			status := ntStatus(binary.LittleEndian.Uint32(msg[offStatus:]))
+			if status == statusSuccess {
+				return nil, errors.New("unencrypted response on encrypted session")
+			}
+		}
+		return msg, nil
+	}
+}
@@ -0,0 +1,262 @@
+package smb
+
+import "fmt"
+
+// Constants from [MS-SMB2], [MS-FSCC] and [MS-DFSC]
+
+// SMB2 commands
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdLogoff         = 0x0002
+	cmdTreeConnect    = 0x0003
+	cmdTreeDisconnect = 0x0004
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdFlush          = 0x0007
+	cmdRead           = 0x0008
+	cmdWrite          = 0x0009
+	cmdIoctl          = 0x000B
+	cmdEcho           = 0x000D
+	cmdQueryDirectory = 0x000E
+	cmdQueryInfo      = 0x0010
+	cmdSetInfo        = 0x0011
+)
+
+// header sizes and offsets
+const (
+	headerSize      = 64
+	offStatus       = 8
+	offCommand      = 12
+	offCredit       = 14
+	offFlags        = 16
+	offNextCommand  = 20
+	offMessageID    = 24
+	offAsyncID      = 32
+	offTreeID       = 36
+	offSessionID    = 40
+	offSignature    = 48
+	signatureLength = 16
+)
+
+// header flags
+const (
+	flagsServerToRedir = 0x00000001
+	flagsAsyncCommand  = 0x00000002
+	flagsSigned        = 0x00000008
+	flagsDFSOperations = 0x10000000
+)
+
+// the message id of unsolicited messages such as oplock breaks
+const unsolicitedMessageID = 0xFFFFFFFFFFFFFFFF
+
+// transform header used for encrypted messages
+const (
+	transformHeaderSize = 52
+	transformEncrypted  = 0x0001
+)
+
+var transformProtocolID = []byte("\xfdSMB")
+
+// dialects
+const (
+	dialect202 = 0x0202
+	dialect210 = 0x0210
+	dialect300 = 0x0300
+	dialect302 = 0x0302
+	dialect311 = 0x0311
+)
+
+// the dialects offered, most preferred first
+var dialects = []uint16{dialect311, dialect302, dialect300, dialect210, dialect202}
+
+// security modes
+const (
+	negotiateSigningEnabled  = 0x0001
+	negotiateSigningRequired = 0x0002
+)
+
+// capabilities
+const (
+	capDFS        = 0x00000001
+	capLargeMTU   = 0x00000004
+	capEncryption = 0x00000040
+)
+
+// negotiate contexts
+const (
+	ctxPreauthIntegrity = 0x0001
+	ctxEncryption       = 0x0002
+	hashSHA512          = 0x0001
+	cipherAES128CCM     = 0x0001
+	cipherAES128GCM     = 0x0002
+)
+
+// session flags
+const (
+	sessionFlagIsGuest     = 0x0001
+	sessionFlagIsNull      = 0x0002
+	sessionFlagEncryptData = 0x0004
+)
+
+// share types, flags and capabilities
+const (
+	shareTypeDisk        = 0x01
+	shareTypePipe        = 0x02
+	shareFlagDFS         = 0x00000001
+	shareFlagDFSRoot     = 0x00000002
+	shareFlagEncryptData = 0x00008000
+	shareCapDFS          = 0x00000008
+)
+
+// access masks
+const (
+	fileReadData        = 0x00000001
+	fileWriteData       = 0x00000002
+	fileListDirectory   = 0x00000001
+	fileAddFile         = 0x00000002
+	fileReadAttributes  = 0x00000080
+	fileWriteAttributes = 0x00000100
+	accessDelete        = 0x00010000
+	readControl         = 0x00020000
+	synchronize         = 0x00100000
+)
+
+// share access
+const (
+	fileShareRead   = 0x00000001
+	fileShareWrite  = 0x00000002
+	fileShareDelete = 0x00000004
+	fileShareAll    = fileShareRead | fileShareWrite | fileShareDelete
+)
+
+// create dispositions
+const (
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOverwriteIf = 5
+)
+
+// create options
+const (
+	fileDirectoryFile    = 0x00000001
+	fileNonDirectoryFile = 0x00000040
+)
+
+// impersonation levels
+const impersonationImpersonation = 2
+
+// file attributes
+const (
+	fileAttributeHidden    = 0x00000002
+	fileAttributeSystem    = 0x00000004
+	fileAttributeDirectory = 0x00000010
+	fileAttributeNormal    = 0x00000080
+)
+
+// info types
+const (
+	infoFile       = 0x01
+	infoFilesystem = 0x02
+)
+
+// file information classes
+const (
+	fileDirectoryInformation        = 1
+	fileBasicInformation            = 4
+	fileRenameInformation           = 10
+	fileDispositionInformation      = 13
+	fileEndOfFileInformation        = 20
+	fileSystemFsFullSizeInformation = 7
+)
+
+// query directory flags
+const restartScans = 0x01
+
+// FSCTL codes
+const (
+	fsctlDfsGetReferrals = 0x00060194
+	fsctlPipeTransceive  = 0x0011C017
+	ioctlIsFsctl         = 0x00000001
+)
+
+// ntStatus is an NTSTATUS code returned in the header of responses
+type ntStatus uint32
+
+// NTSTATUS codes
+const (
+	statusSuccess                = ntStatus(0x00000000)
+	statusPending                = ntStatus(0x00000103)
+	statusBufferOverflow         = ntStatus(0x80000005)
+	statusNoMoreFiles            = ntStatus(0x80000006)
+	statusInvalidParameter       = ntStatus(0xC000000D)
+	statusNoSuchFile             = ntStatus(0xC000000F)
+	statusEndOfFile              = ntStatus(0xC0000011)
+	statusMoreProcessingRequired = ntStatus(0xC0000016)
+	statusAccessDenied           = ntStatus(0xC0000022)
+	statusObjectNameInvalid      = ntStatus(0xC0000033)
+	statusObjectNameNotFound     = ntStatus(0xC0000034)
+	statusObjectNameCollision    = ntStatus(0xC0000035)
+	statusObjectPathNotFound     = ntStatus(0xC000003A)
+	statusSharingViolation       = ntStatus(0xC0000043)
+	statusDeletePending          = ntStatus(0xC0000056)
+	statusLogonFailure           = ntStatus(0xC000006D)
+	statusInsufficientResources  = ntStatus(0xC000009A)
+	statusFileIsADirectory       = ntStatus(0xC00000BA)
+	statusNotSupported           = ntStatus(0xC00000BB)
+	statusNetworkNameDeleted     = ntStatus(0xC00000C9)
+	statusBadNetworkName         = ntStatus(0xC00000CC)
+	statusDirectoryNotEmpty      = ntStatus(0xC0000101)
+	statusNotADirectory          = ntStatus(0xC0000103)
+	statusFsDriverRequired       = ntStatus(0xC000019C)
+	statusUserSessionDeleted     = ntStatus(0xC0000203)
+	statusNotFound               = ntStatus(0xC0000225)
+	statusPathNotCovered         = ntStatus(0xC0000257)
+	statusNetworkSessionExpired  = ntStatus(0xC000035C)
+)
+
+// statusNames are the names of the NTSTATUS codes for error messages
+var statusNames = map[ntStatus]string{
+	statusSuccess:                "STATUS_SUCCESS",
+	statusPending:                "STATUS_PENDING",
+	statusBufferOverflow:         "STATUS_BUFFER_OVERFLOW",
+	statusNoMoreFiles:            "STATUS_NO_MORE_FILES",
+	statusInvalidParameter:       "STATUS_INVALID_PARAMETER",
+	statusNoSuchFile:             "STATUS_NO_SUCH_FILE",
+	statusEndOfFile:              "STATUS_END_OF_FILE",
+	statusMoreProcessingRequired: "STATUS_MORE_PROCESSING_REQUIRED",
+	statusAccessDenied:           "STATUS_ACCESS_DENIED",
+	statusObjectNameInvalid:      "STATUS_OBJECT_NAME_INVALID",
+	statusObjectNameNotFound:     "STATUS_OBJECT_NAME_NOT_FOUND",
+	statusObjectNameCollision:    "STATUS_OBJECT_NAME_COLLISION",
+	statusObjectPathNotFound:     "STATUS_OBJECT_PATH_NOT_FOUND",
+	statusSharingViolation:       "STATUS_SHARING_VIOLATION",
+	statusDeletePending:          "STATUS_DELETE_PENDING",
+	statusLogonFailure:           "STATUS_LOGON_FAILURE",
+	statusInsufficientResources:  "STATUS_INSUFFICIENT_RESOURCES",
+	statusFileIsADirectory:       "STATUS_FILE_IS_A_DIRECTORY",
+	statusNotSupported:           "STATUS_NOT_SUPPORTED",
+	statusNetworkNameDeleted:     "STATUS_NETWORK_NAME_DELETED",
+	statusBadNetworkName:         "STATUS_BAD_NETWORK_NAME",
+	statusDirectoryNotEmpty:      "STATUS_DIRECTORY_NOT_EMPTY",
+	statusNotADirectory:          "STATUS_NOT_A_DIRECTORY",
+	statusFsDriverRequired:       "STATUS_FS_DRIVER_REQUIRED",
+	statusUserSessionDeleted:     "STATUS_USER_SESSION_DELETED",
+	statusNotFound:               "STATUS_NOT_FOUND",
+	statusPathNotCovered:         "STATUS_PATH_NOT_COVERED",
+	statusNetworkSessionExpired:  "STATUS_NETWORK_SESSION_EXPIRED",
+}
+
+// Error satisfies the error interface
+func (s ntStatus) Error() string {
+	if name, ok := statusNames[s]; ok {
+		return fmt.Sprintf("smb: %s (0x%08X)", name, uint32(s))
+	}
+	return fmt.Sprintf("smb: NTSTATUS 0x%08X", uint32(s))
+}
+
+// isError returns whether status is a warning or an error rather
+// than success or information
+func isError(status ntStatus) bool {
+	return status&0x80000000 != 0
+}
@@ -0,0 +1,348 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// aesCMAC computes the AES-CMAC of msg with key as described in RFC 4493
+func aesCMAC(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// the key is always 16 bytes so this can't happen
+		panic(err)
+	}
+	const size = aes.BlockSize
+
+	// make the subkeys
+	shift := func(in []byte) []byte {
+		out := make([]byte, size)
+		var carry byte
+		for i := size - 1; i >= 0; i-- {
+			out[i] = in[i]<<1 | carry
+			carry = in[i] >> 7
+		}
+		if in[0]&0x80 != 0 {
+			out[size-1] ^= 0x87
+		}
+		return out
+	}
+	l := make([]byte, size)
+	block.Encrypt(l, l)
+	k1 := shift(l)
+	k2 := shift(k1)
+
+	// pad the last block if needed and xor it with the subkey
+	n := (len(msg) + size - 1) / size
+	last := make([]byte, size)
+	if n > 0 && len(msg)%size == 0 {
+		copy(last, msg[(n-1)*size:])
+		for i := range last {
+			last[i] ^= k1[i]
+		}
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*size:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+
+	x := make([]byte, size)
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < size; j++ {
+			x[j] ^= msg[i*size+j]
+		}
+		block.Encrypt(x, x)
+	}
+	for j := 0; j < size; j++ {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x, x)
+	return x
+}
+
+// kdf is the SP800-108 counter mode key derivation function with
+// HMAC-SHA256 used by SMB 3 to make a 128 bit key
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], 1)
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write(label)
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(context)
+	binary.BigEndian.PutUint32(buf[:], 128)
+	_, _ = h.Write(buf[:])
+	return h.Sum(nil)[:16]
+}
+
+// sessionKeys are the keys derived from the session key
+type sessionKeys struct {
+	signing    []byte // key to sign messages with
+	encryption []byte // key to encrypt messages to the server with
+	decryption []byte // key to decrypt messages from the server with
+}
+
+// deriveKeys makes the keys for a session with the dialect given
+//
+// The encryption keys are only set for SMB 3.
+func deriveKeys(dialect uint16, sessionKey, preauthHash []byte) (keys sessionKeys) {
+	// only the first 16 bytes are used, eg of a Kerberos AES256 key
+	if len(sessionKey) > 16 {
+		sessionKey = sessionKey[:16]
+	}
+	switch {
+	case dialect >= dialect311:
+		keys.signing = kdf(sessionKey, []byte("SMBSigningKey\x00"), preauthHash)
+		keys.encryption = kdf(sessionKey, []byte("SMBC2SCipherKey\x00"), preauthHash)
+		keys.decryption = kdf(sessionKey, []byte("SMBS2CCipherKey\x00"), preauthHash)
+	case dialect >= dialect300:
+		keys.signing = kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+		keys.encryption = kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerIn \x00"))
+		keys.decryption = kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerOut\x00"))
+	default:
+		keys.signing = sessionKey
+	}
+	return keys
+}
+
+// signature computes the signature of msg, which must have the
+// signature field zeroed
+func signature(dialect uint16, key, msg []byte) []byte {
+	if dialect >= dialect300 {
+		return aesCMAC(key, msg)
+	}
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write(msg)
+	return h.Sum(nil)[:signatureLength]
+}
+
+// sign signs msg in place
+func sign(dialect uint16, key, msg []byte) {
+	binary.LittleEndian.PutUint32(msg[offFlags:], binary.LittleEndian.Uint32(msg[offFlags:])|flagsSigned)
+	sig := msg[offSignature : offSignature+signatureLength]
+	for i := range sig {
+		sig[i] = 0
+	}
+	copy(sig, signature(dialect, key, msg))
+}
+
+// verify checks the signature of msg without modifying it
+func verify(dialect uint16, key, msg []byte) bool {
+	if len(msg) < headerSize {
+		return false
+	}
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	sig := buf[offSignature : offSignature+signatureLength]
+	for i := range sig {
+		sig[i] = 0
+	}
+	return subtle.ConstantTimeCompare(signature(dialect, key, buf), msg[offSignature:offSignature+signatureLength]) == 1
+}
+
+// ccm implements AES-CCM as described in RFC 3610 and NIST SP800-38C
+// as Go doesn't have it
+type ccm struct {
+	block     cipher.Block
+	nonceSize int
+	tagSize   int
+}
+
+// newCCM returns AES-CCM with the nonce and tag sizes given
+func newCCM(block cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if block.BlockSize() != aes.BlockSize {
+		return nil, errors.New("ccm: need a 128 bit block cipher")
+	}
+	if nonceSize < 7 || nonceSize > 13 {
+		return nil, errors.New("ccm: bad nonce size")
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		return nil, errors.New("ccm: bad tag size")
+	}
+	return &ccm{block: block, nonceSize: nonceSize, tagSize: tagSize}, nil
+}
+
+// NonceSize returns the size of the nonce which must be passed
+func (c *ccm) NonceSize() int {
+	return c.nonceSize
+}
+
+// Overhead returns the difference between the lengths of a
+// plaintext and its ciphertext
+func (c *ccm) Overhead() int {
+	return c.tagSize
+}
+
+// mac computes the CBC-MAC of the plaintext and additional data
+func (c *ccm) mac(nonce, plaintext, data []byte) []byte {
+	const size = aes.BlockSize
+	l := 15 - c.nonceSize
+	x := make([]byte, size)
+	x[0] = byte((c.tagSize-2)/2<<3 | (l - 1))
+	if len(data) > 0 {
+		x[0] |= 0x40
+	}
+	copy(x[1:], nonce)
+	n := uint64(len(plaintext))
+	for i := size - 1; i > c.nonceSize; i-- {
+		x[i] = byte(n)
+		n >>= 8
+	}
+	c.block.Encrypt(x, x)
+
+	// mix in blocks of buf zero padding the last one
+	mix := func(buf []byte) {
+		for len(buf) > 0 {
+			n := len(buf)
+			if n > size {
+				n = size
+			}
+			for i := 0; i < n; i++ {
+				x[i] ^= buf[i]
+			}
+			c.block.Encrypt(x, x)
+			buf = buf[n:]
+		}
+	}
+	if len(data) > 0 {
+		var header []byte
+		if len(data) < 0xFF00 {
+			header = []byte{byte(len(data) >> 8), byte(len(data))}
+		} else {
+			header = []byte{0xFF, 0xFE, byte(len(data) >> 24), byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}
+		}
+		aad := append(header, data...)
+		mix(aad)
+	}
+	mix(plaintext)
+	return x[:c.tagSize]
+}
+
+// ctr xors src with the key stream into dst starting with counter 1
+// returning the encrypted counter block 0 used to encrypt the tag
+func (c *ccm) ctr(nonce, dst, src []byte) []byte {
+	const size = aes.BlockSize
+	l := 15 - c.nonceSize
+	a := make([]byte, size)
+	a[0] = byte(l - 1)
+	copy(a[1:], nonce)
+	s0 := make([]byte, size)
+	c.block.Encrypt(s0, a)
+	a[size-1] = 1
+	cipher.NewCTR(c.block, a).XORKeyStream(dst, src)
+	return s0
+}
+
+// Seal encrypts and authenticates plaintext, authenticates the
+// additional data and appends the result to dst
+func (c *ccm) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if len(nonce) != c.nonceSize {
+		panic("ccm: incorrect nonce length")
+	}
+	tag := c.mac(nonce, plaintext, data)
+	out := make([]byte, len(plaintext)+c.tagSize)
+	s0 := c.ctr(nonce, out, plaintext)
+	for i := range tag {
+		out[len(plaintext)+i] = tag[i] ^ s0[i]
+	}
+	return append(dst, out...)
+}
+
+// errOpen is returned when a message fails to authenticate
+var errOpen = errors.New("ccm: message authentication failed")
+
+// Open decrypts and authenticates ciphertext, authenticates the
+// additional data and, if successful, appends the resulting
+// plaintext to dst
+func (c *ccm) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(nonce) != c.nonceSize {
+		panic("ccm: incorrect nonce length")
+	}
+	if len(ciphertext) < c.tagSize {
+		return nil, errOpen
+	}
+	n := len(ciphertext) - c.tagSize
+	plaintext := make([]byte, n)
+	s0 := c.ctr(nonce, plaintext, ciphertext[:n])
+	tag := c.mac(nonce, plaintext, data)
+	for i := range tag {
+		tag[i] ^= s0[i]
+	}
+	if subtle.ConstantTimeCompare(tag, ciphertext[n:]) != 1 {
+		return nil, errOpen
+	}
+	return append(dst, plaintext...), nil
+}
+
+// newCipher makes the AEAD for the SMB 3 cipher id with key
+func newCipher(id uint16, key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	switch id {
+	case cipherAES128CCM:
+		return newCCM(block, 11, 16)
+	case cipherAES128GCM:
+		return cipher.NewGCM(block)
+	}
+	return nil, errors.Errorf("unknown cipher %d", id)
+}
+
+// encryptMessage wraps msg in a transform header encrypting it with
+// aead and the nonce given
+func encryptMessage(aead cipher.AEAD, nonce []byte, sessionID uint64, msg []byte) []byte {
+	var w smb2.Writer
+	w.Append(transformProtocolID)
+	w.Zero(16) // signature
+	w.Append(nonce)
+	w.Zero(16 - len(nonce))
+	w.Uint32(uint32(len(msg)))
+	w.Uint16(0)
+	w.Uint16(transformEncrypted)
+	w.Uint64(sessionID)
+	sealed := aead.Seal(nil, nonce, msg, w.Bytes()[20:transformHeaderSize])
+	n := len(sealed) - aead.Overhead()
+	copy(w.Bytes()[4:20], sealed[n:])
+	return append(w.Bytes(), sealed[:n]...)
+}
+
+// decryptMessage decrypts a message in a transform header with aead
+// returning the session id it is for
+func decryptMessage(aead cipher.AEAD, frame []byte) (sessionID uint64, msg []byte, err error) {
+	if len(frame) < transformHeaderSize {
+		return 0, nil, smb2.ErrBadMessage
+	}
+	r := smb2.NewReader(frame)
+	r.Skip(4)
+	tag := r.Next(16)
+	nonce := r.Next(16)[:aead.NonceSize()]
+	size := int(r.Uint32())
+	r.Skip(2)
+	flags := r.Uint16()
+	sessionID = r.Uint64()
+	ciphertext := frame[transformHeaderSize:]
+	if r.Err() != nil || flags != transformEncrypted || size != len(ciphertext) {
+		return 0, nil, smb2.ErrBadMessage
+	}
+	sealed := make([]byte, 0, len(ciphertext)+len(tag))
+	sealed = append(append(sealed, ciphertext...), tag...)
+	msg, err = aead.Open(nil, nonce, sealed, frame[20:transformHeaderSize])
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to decrypt message")
+	}
+	return sessionID, msg, nil
+}
@@ -0,0 +1,239 @@
+package smb
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// Following referrals in DFS namespaces [MS-DFSC].
+//
+// Paths in the namespace are written as \server\share\path. When a
+// server says a path isn't covered by it, it is asked for a referral
+// which maps a prefix of the path to one or more targets. These are
+// cached and used to rewrite later paths.
+
+const (
+	maxReferralLevel = 4
+	maxReferralHops  = 8 // maximum number of referrals followed for a path
+	referralNameList = 0x0002
+	minReferralTTL   = 60 * time.Second
+)
+
+// location is a path on a share of a server
+type location struct {
+	server string
+	share  string
+	path   string // share relative path using \ as separator
+}
+
+// String returns the location as \server\share\path
+func (l location) String() string {
+	s := `\` + l.server + `\` + l.share
+	if l.path != "" {
+		s += `\` + l.path
+	}
+	return s
+}
+
+// parseLocation parses \server\share\path returning false if the
+// share is missing
+func parseLocation(s string) (l location, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(s, `\`), `\`, 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return l, false
+	}
+	l.server, l.share = parts[0], parts[1]
+	if len(parts) == 3 {
+		l.path = strings.Trim(parts[2], `\`)
+	}
+	return l, true
+}
+
+// referral is a DFS referral for a path prefix
+type referral struct {
+	prefix  string   // the path prefix referred, \server\share\path
+	targets []string // where the prefix is found, \server\share\path
+	expires time.Time
+}
+
+// parseReferrals parses a RESP_GET_DFS_REFERRAL returning the
+// referral for the path requested
+func parseReferrals(requested string, data []byte, now time.Time) (*referral, error) {
+	r := smb2.NewReader(data)
+	pathConsumed := int(r.Uint16()) / 2 // in bytes of UTF-16
+	count := int(r.Uint16())
+	r.Skip(4) // header flags
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "bad DFS referral")
+	}
+	// PathConsumed counts UTF-16 code units so this is approximate
+	// for names outside the BMP
+	prefix := requested
+	if runes := []rune(requested); pathConsumed < len(runes) {
+		prefix = string(runes[:pathConsumed])
+	}
+	ref := &referral{
+		prefix: strings.TrimSuffix(prefix, `\`),
+	}
+	ttl := time.Duration(0)
+	for i := 0; i < count; i++ {
+		start := r.Offset()
+		version := r.Uint16()
+		size := int(r.Uint16())
+		r.Skip(2) // server type
+		entryFlags := r.Uint16()
+		if r.Err() != nil || size < 8 {
+			return nil, errors.New("bad DFS referral entry")
+		}
+		var entryTTL uint32
+		var addressOffset int
+		switch version {
+		case 2:
+			r.Skip(4) // proximity
+			entryTTL = r.Uint32()
+			r.Skip(4) // DFS path and alternate path offsets
+			addressOffset = int(r.Uint16())
+		case 3, 4:
+			entryTTL = r.Uint32()
+			if entryFlags&referralNameList != 0 {
+				// domain referrals aren't followed
+				r.Seek(start + size)
+				continue
+			}
+			r.Skip(4) // DFS path and alternate path offsets
+			addressOffset = int(r.Uint16())
+		default:
+			return nil, errors.Errorf("unsupported DFS referral version %d", version)
+		}
+		if r.Err() != nil {
+			return nil, errors.New("bad DFS referral entry")
+		}
+		address := readNullTerminatedUTF16(data, start+addressOffset)
+		if address != "" {
+			ref.targets = append(ref.targets, strings.TrimSuffix(address, `\`))
+		}
+		if ttl == 0 || time.Duration(entryTTL)*time.Second < ttl {
+			ttl = time.Duration(entryTTL) * time.Second
+		}
+		r.Seek(start + size)
+	}
+	if len(ref.targets) == 0 {
+		return nil, errors.Errorf("no DFS targets for %q", requested)
+	}
+	if ttl < minReferralTTL {
+		ttl = minReferralTTL
+	}
+	ref.expires = now.Add(ttl)
+	return ref, nil
+}
+
+// readNullTerminatedUTF16 reads a null terminated UTF-16 string at
+// offset in data
+func readNullTerminatedUTF16(data []byte, offset int) string {
+	if offset < 0 || offset >= len(data) {
+		return ""
+	}
+	end := offset
+	for end+1 < len(data) && (data[end] != 0 || data[end+1] != 0) {
+		end += 2
+	}
+	return smb2.DecodeUTF16(data[offset:end])
+}
+
+// dfsCache caches DFS referrals
+type dfsCache struct {
+	mu        sync.Mutex
+	referrals map[string]*referral // by lower case prefix
+}
+
+// newDFSCache makes an empty cache
+func newDFSCache() *dfsCache {
+	return &dfsCache{
+		referrals: map[string]*referral{},
+	}
+}
+
+// add adds ref to the cache
+func (d *dfsCache) add(ref *referral) {
+	d.mu.Lock()
+	d.referrals[strings.ToLower(ref.prefix)] = ref
+	d.mu.Unlock()
+}
+
+// failed moves the target in use for the prefix referring l to
+// the end of its list so the next one is tried
+func (d *dfsCache) failed(l location) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ref := range d.referrals {
+		if len(ref.targets) > 1 && hasPathPrefix(l.String(), ref.targets[0]) {
+			ref.targets = append(ref.targets[1:], ref.targets[0])
+		}
+	}
+}
+
+// hasPathPrefix returns whether prefix is a prefix of p on a path
+// element boundary ignoring case
+func hasPathPrefix(p, prefix string) bool {
+	if len(p) < len(prefix) || !strings.EqualFold(p[:len(prefix)], prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || p[len(prefix)] == '\\'
+}
+
+// resolve rewrites l using the cached referrals
+func (d *dfsCache) resolve(l location, now time.Time) location {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := l.String()
+	for hop := 0; hop < maxReferralHops; hop++ {
+		var best *referral
+		for key, ref := range d.referrals {
+			if now.After(ref.expires) {
+				delete(d.referrals, key)
+				continue
+			}
+			if hasPathPrefix(p, ref.prefix) && (best == nil || len(ref.prefix) > len(best.prefix)) {
+				best = ref
+			}
+		}
+		if best == nil {
+			break
+		}
+		newP := best.targets[0] + p[len(best.prefix):]
+		if strings.EqualFold(newP, p) {
+			break
+		}
+		p = newP
+	}
+	resolved, ok := parseLocation(p)
+	if !ok {
+		return l
+	}
+	return resolved
+}
+
+// getReferral asks the server for the referral for l
+func (c *conn) getReferral(l location) (*referral, error) {
+	t, err := c.treeConnect("IPC$")
+	if err != nil {
+		return nil, err
+	}
+	requested := l.String()
+	var w smb2.Writer
+	w.Uint16(maxReferralLevel)
+	w.Append(smb2.EncodeUTF16(requested + "\x00"))
+	var id fileID
+	for i := range id {
+		id[i] = 0xFF
+	}
+	output, err := c.ioctl(t, id, fsctlDfsGetReferrals, w.Bytes(), creditSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseReferrals(requested, output, time.Now())
+}
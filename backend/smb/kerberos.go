@@ -0,0 +1,209 @@
+package smb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"os/user"
+	"strings"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/pkg/errors"
+)
+
+// Kerberos authentication with a ticket for the cifs service of the
+// server, wrapped in SPNEGO as described in RFC 4121 and [MS-KILE].
+
+var (
+	oidKRB5   = []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x12, 0x01, 0x02, 0x02}
+	oidMSKRB5 = []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x82, 0xf7, 0x12, 0x01, 0x02, 0x02}
+)
+
+// GSS-API token ids
+var (
+	tokIDAPReq    = []byte{0x01, 0x00}
+	tokIDAPRep    = []byte{0x02, 0x00}
+	tokIDKRBError = []byte{0x03, 0x00}
+)
+
+// newKerberosClient makes a Kerberos client from the keytab if set
+// or the credential cache if not
+func newKerberosClient(opt *Options) (*krb.Client, error) {
+	cfgPath := os.Getenv("KRB5_CONFIG")
+	if cfgPath == "" {
+		cfgPath = "/etc/krb5.conf"
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Kerberos config %q", cfgPath)
+	}
+
+	if opt.KerberosKeytab != "" {
+		at := strings.LastIndex(opt.KerberosPrincipal, "@")
+		if at <= 0 {
+			return nil, errors.New("kerberos_principal must be set as user@REALM to use a keytab")
+		}
+		kt, err := keytab.Load(opt.KerberosKeytab)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load keytab %q", opt.KerberosKeytab)
+		}
+		cl := krb.NewWithKeytab(opt.KerberosPrincipal[:at], opt.KerberosPrincipal[at+1:], kt, cfg, krb.DisablePAFXFAST(true))
+		err = cl.Login()
+		if err != nil {
+			return nil, errors.Wrap(err, "Kerberos login failed")
+		}
+		return cl, nil
+	}
+
+	ccachePath := strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+	if ccachePath == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		ccachePath = "/tmp/krb5cc_" + u.Uid
+	}
+	ccache, err := credentials.LoadCCache(ccachePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Kerberos credential cache %q - run kinit or set kerberos_keytab", ccachePath)
+	}
+	cl, err := krb.NewFromCCache(ccache, cfg, krb.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make Kerberos client from credential cache")
+	}
+	return cl, nil
+}
+
+// krbAuth authenticates with a Kerberos service ticket
+type krbAuth struct {
+	cl     *krb.Client
+	spn    string
+	tktKey types.EncryptionKey // the session key of the ticket
+	subkey types.EncryptionKey // the subkey sent in the authenticator
+	key    []byte              // the session key
+}
+
+// newKerberosAuth makes an authenticator for the service principal
+// name spn using the tickets of cl
+func newKerberosAuth(cl *krb.Client, spn string) *krbAuth {
+	return &krbAuth{
+		cl:  cl,
+		spn: spn,
+	}
+}
+
+// initial returns a SPNEGO NegTokenInit with an AP-REQ
+func (a *krbAuth) initial() ([]byte, error) {
+	tkt, key, err := a.cl.GetServiceTicket(a.spn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Kerberos ticket for %q", a.spn)
+	}
+	a.tktKey = key
+	apReq, err := a.apReq(tkt)
+	if err != nil {
+		return nil, err
+	}
+	mechToken := derTLV(0x60, append(append(append([]byte{}, oidKRB5...), tokIDAPReq...), apReq...))
+	mechTypes := derTLV(0x30, append(append([]byte{}, oidMSKRB5...), oidKRB5...))
+	fields := append(derTLV(0xa0, mechTypes), derTLV(0xa2, derTLV(0x04, mechToken))...)
+	body := append(append([]byte{}, oidSPNEGO...), derTLV(0xa0, derTLV(0x30, fields))...)
+	return derTLV(0x60, body), nil
+}
+
+// apReq makes the AP-REQ for tkt asking for mutual authentication
+// with a new subkey
+func (a *krbAuth) apReq(tkt messages.Ticket) ([]byte, error) {
+	auth, err := types.NewAuthenticator(a.cl.Credentials.Domain(), a.cl.Credentials.CName())
+	if err != nil {
+		return nil, err
+	}
+	// the GSS-API checksum from RFC 4121 section 4.1.1
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum, 16)
+	binary.LittleEndian.PutUint32(checksum[20:], gssapi.ContextFlagMutual|gssapi.ContextFlagInteg|gssapi.ContextFlagConf)
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  checksum,
+	}
+	etype, err := crypto.GetEtype(a.tktKey.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	err = auth.GenerateSeqNumberAndSubKey(a.tktKey.KeyType, etype.GetKeyByteSize())
+	if err != nil {
+		return nil, err
+	}
+	a.subkey = auth.SubKey
+	apReq, err := messages.NewAPReq(tkt, a.tktKey, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make AP-REQ")
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	return apReq.Marshal()
+}
+
+// next processes the AP-REP from the server
+func (a *krbAuth) next(token []byte) ([]byte, error) {
+	negState, msg, err := parseNegTokenResp(token)
+	if err != nil {
+		return nil, err
+	}
+	if negState == negStateReject {
+		return nil, errors.New("server rejected Kerberos authentication")
+	}
+	if msg == nil {
+		return nil, nil
+	}
+	tag, body, _, ok := parseDER(msg)
+	if !ok || tag != 0x60 || !bytes.HasPrefix(body, oidKRB5) || len(body) < len(oidKRB5)+2 {
+		return nil, errors.New("bad Kerberos response token")
+	}
+	body = body[len(oidKRB5):]
+	tokID, body := body[:2], body[2:]
+	switch {
+	case bytes.Equal(tokID, tokIDKRBError):
+		var krbErr messages.KRBError
+		if err := krbErr.Unmarshal(body); err != nil {
+			return nil, errors.Wrap(err, "bad Kerberos error")
+		}
+		return nil, errors.Wrap(krbErr, "server rejected Kerberos ticket")
+	case !bytes.Equal(tokID, tokIDAPRep):
+		return nil, errors.New("expecting Kerberos AP-REP")
+	}
+	var apRep messages.APRep
+	if err := apRep.Unmarshal(body); err != nil {
+		return nil, errors.Wrap(err, "bad Kerberos AP-REP")
+	}
+	plain, err := crypto.DecryptEncPart(apRep.EncPart, a.tktKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt Kerberos AP-REP - the server may not be who it claims")
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(plain); err != nil {
+		return nil, errors.Wrap(err, "bad Kerberos AP-REP")
+	}
+	// use the acceptor's subkey if it sent one
+	if len(part.Subkey.KeyValue) > 0 {
+		a.key = part.Subkey.KeyValue
+	}
+	return nil, nil
+}
+
+// sessionKey returns the acceptor subkey if the server sent one or
+// the subkey the client sent
+func (a *krbAuth) sessionKey() []byte {
+	if a.key != nil {
+		return a.key
+	}
+	return a.subkey.KeyValue
+}
@@ -0,0 +1,339 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/smb2"
+	"golang.org/x/crypto/md4"
+)
+
+// NTLMv2 authentication as described in [MS-NLMP] wrapped in SPNEGO
+// (RFC 4178) tokens.
+
+// authenticator makes the security tokens for SESSION_SETUP
+type authenticator interface {
+	// initial returns the first token to send
+	initial() ([]byte, error)
+	// next processes a token from the server returning the token
+	// to send back, or nil if there isn't one
+	next(token []byte) ([]byte, error)
+	// sessionKey returns the session key once authentication is
+	// complete
+	sessionKey() []byte
+}
+
+// NTLM message types
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLM negotiate flags
+const (
+	ntlmNegotiateUnicode     = 0x00000001
+	ntlmRequestTarget        = 0x00000004
+	ntlmNegotiateSign        = 0x00000010
+	ntlmNegotiateNTLM        = 0x00000200
+	ntlmNegotiateAlwaysSign  = 0x00008000
+	ntlmNegotiateExtendedSec = 0x00080000
+	ntlmNegotiateTargetInfo  = 0x00800000
+	ntlmNegotiate128         = 0x20000000
+	ntlmNegotiateKeyExch     = 0x40000000
+	ntlmNegotiate56          = 0x80000000
+
+	// the flags the client asks for
+	ntlmClientFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSec |
+		ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiateKeyExch | ntlmNegotiate56
+)
+
+// NTLM AV pair ids
+const (
+	avEOL       = 0
+	avTimestamp = 7
+)
+
+// SPNEGO states
+const (
+	negStateAcceptCompleted = 0
+	negStateReject          = 2
+	negStateRequestMIC      = 3
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+	oidSPNEGO     = []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP    = []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// ntlmAuth authenticates with NTLMv2
+type ntlmAuth struct {
+	user      string
+	domain    string
+	pass      string
+	mechTypes []byte // DER of the mechTypes sent
+	key       []byte // the exported session key
+	done      bool   // set once the AUTHENTICATE has been sent
+}
+
+// newNTLMAuth makes an authenticator for user in domain with pass
+func newNTLMAuth(user, domain, pass string) *ntlmAuth {
+	return &ntlmAuth{
+		user:   user,
+		domain: domain,
+		pass:   pass,
+	}
+}
+
+// initial returns a SPNEGO NegTokenInit with the NTLM NEGOTIATE
+func (a *ntlmAuth) initial() ([]byte, error) {
+	var negotiate smb2.Writer
+	negotiate.Append(ntlmSignature)
+	negotiate.Uint32(ntlmNegotiate)
+	negotiate.Uint32(ntlmClientFlags)
+	negotiate.Zero(16) // domain and workstation
+	a.mechTypes = derTLV(0x30, oidNTLMSSP)
+	fields := append(derTLV(0xa0, a.mechTypes), derTLV(0xa2, derTLV(0x04, negotiate.Bytes()))...)
+	body := append(append([]byte{}, oidSPNEGO...), derTLV(0xa0, derTLV(0x30, fields))...)
+	return derTLV(0x60, body), nil
+}
+
+// next processes the CHALLENGE returning the AUTHENTICATE
+func (a *ntlmAuth) next(token []byte) ([]byte, error) {
+	negState, msg, err := parseNegTokenResp(token)
+	if err != nil {
+		return nil, err
+	}
+	if negState == negStateReject {
+		return nil, errors.New("server rejected NTLM authentication")
+	}
+	if a.done {
+		// the final token from the server needs no reply
+		return nil, nil
+	}
+	if len(msg) < 48 || !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != ntlmChallenge {
+		return nil, errors.New("expecting NTLM CHALLENGE")
+	}
+	auth, err := a.authenticate(msg)
+	if err != nil {
+		return nil, err
+	}
+	a.done = true
+	fields := derTLV(0xa2, derTLV(0x04, auth))
+	if negState == negStateRequestMIC {
+		fields = append(fields, derTLV(0xa3, derTLV(0x04, ntlmClientMAC(a.key, a.mechTypes)))...)
+	}
+	return derTLV(0xa1, derTLV(0x30, fields)), nil
+}
+
+// sessionKey returns the exported session key
+func (a *ntlmAuth) sessionKey() []byte {
+	return a.key
+}
+
+// authenticate makes the AUTHENTICATE message replying to challenge
+func (a *ntlmAuth) authenticate(challenge []byte) ([]byte, error) {
+	r := smb2.NewReader(challenge)
+	r.Seek(20)
+	flags := r.Uint32() & ntlmClientFlags
+	serverChallenge := r.Next(8)
+	r.Seek(40)
+	infoLength := int(r.Uint16())
+	r.Skip(2)
+	targetInfo := r.At(int(r.Uint32()), infoLength)
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "bad NTLM CHALLENGE")
+	}
+	if flags&ntlmNegotiateExtendedSec == 0 {
+		return nil, errors.New("server doesn't support NTLMv2")
+	}
+
+	// use the server's timestamp if it sent one
+	timestamp := smb2.ToFiletime(time.Now())
+	haveTimestamp := false
+	info := smb2.NewReader(targetInfo)
+	for info.Err() == nil {
+		id := info.Uint16()
+		value := info.Next(int(info.Uint16()))
+		if id == avEOL {
+			break
+		}
+		if id == avTimestamp && len(value) == 8 {
+			timestamp = binary.LittleEndian.Uint64(value)
+			haveTimestamp = true
+		}
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, clientChallenge); err != nil {
+		return nil, err
+	}
+	var blob smb2.Writer
+	blob.Uint16(0x0101)
+	blob.Zero(6)
+	blob.Uint64(timestamp)
+	blob.Append(clientChallenge)
+	blob.Zero(4)
+	blob.Append(targetInfo)
+	blob.Zero(4)
+	ntowf := ntowfv2(a.user, a.domain, a.pass)
+	proof := hmacMD5(ntowf, serverChallenge, blob.Bytes())
+	nt := append(proof, blob.Bytes()...)
+	lm := make([]byte, 24)
+	if !haveTimestamp {
+		lm = append(hmacMD5(ntowf, serverChallenge, clientChallenge), clientChallenge...)
+	}
+	baseKey := hmacMD5(ntowf, proof)
+	a.key = baseKey
+	var encryptedKey []byte
+	if flags&ntlmNegotiateKeyExch != 0 {
+		a.key = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, a.key); err != nil {
+			return nil, err
+		}
+		encryptedKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(baseKey)
+		cipher.XORKeyStream(encryptedKey, a.key)
+	}
+
+	fields := [][]byte{
+		lm,
+		nt,
+		smb2.EncodeUTF16(a.domain),
+		smb2.EncodeUTF16(a.user),
+		smb2.EncodeUTF16("RCLONE"),
+		encryptedKey,
+	}
+	var w smb2.Writer
+	w.Append(ntlmSignature)
+	w.Uint32(ntlmAuthenticate)
+	offset := 64
+	for _, field := range fields {
+		w.Uint16(uint16(len(field)))
+		w.Uint16(uint16(len(field)))
+		w.Uint32(uint32(offset))
+		offset += len(field)
+	}
+	w.Uint32(flags)
+	for _, field := range fields {
+		w.Append(field)
+	}
+	return w.Bytes(), nil
+}
+
+// hmacMD5 returns the HMAC-MD5 of the data with key
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 returns the NTLMv2 hash of the password
+func ntowfv2(user, domain, pass string) []byte {
+	h := md4.New()
+	_, _ = h.Write(smb2.EncodeUTF16(pass))
+	return hmacMD5(h.Sum(nil), smb2.EncodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// ntlmClientMAC returns the NTLM signature the client makes for the
+// first message it signs, used for the SPNEGO mechListMIC
+func ntlmClientMAC(sessionKey []byte, msg []byte) []byte {
+	signKey := md5.Sum(append(append([]byte{}, sessionKey...), "session key to client-to-server signing key magic constant\x00"...))
+	sealKey := md5.Sum(append(append([]byte{}, sessionKey...), "session key to client-to-server sealing key magic constant\x00"...))
+	var seq [4]byte
+	checksum := hmacMD5(signKey[:], seq[:], msg)[:8]
+	cipher, _ := rc4.NewCipher(sealKey[:])
+	cipher.XORKeyStream(checksum, checksum)
+	var w smb2.Writer
+	w.Uint32(1)
+	w.Append(checksum)
+	w.Append(seq[:])
+	return w.Bytes()
+}
+
+// parseNegTokenResp parses a SPNEGO NegTokenResp returning its state
+// and response token
+func parseNegTokenResp(token []byte) (negState int, msg []byte, err error) {
+	tag, body, _, ok := parseDER(token)
+	if !ok || tag != 0xa1 {
+		return 0, nil, errors.New("expecting SPNEGO NegTokenResp")
+	}
+	tag, body, _, ok = parseDER(body)
+	if !ok || tag != 0x30 {
+		return 0, nil, errors.New("bad SPNEGO NegTokenResp")
+	}
+	negState = negStateAcceptCompleted
+	for len(body) > 0 {
+		var field []byte
+		tag, field, body, ok = parseDER(body)
+		if !ok {
+			return 0, nil, errors.New("bad SPNEGO NegTokenResp field")
+		}
+		switch tag {
+		case 0xa0:
+			tag, state, _, ok := parseDER(field)
+			if !ok || tag != 0x0a || len(state) != 1 {
+				return 0, nil, errors.New("bad SPNEGO negState")
+			}
+			negState = int(state[0])
+		case 0xa2:
+			tag, msg, _, ok = parseDER(field)
+			if !ok || tag != 0x04 {
+				return 0, nil, errors.New("bad SPNEGO responseToken")
+			}
+		}
+	}
+	return negState, msg, nil
+}
+
+// parseDER parses a DER element returning its tag, its contents and
+// the data following it
+func parseDER(data []byte) (tag byte, body, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = data[0]
+	length := int(data[1])
+	data = data[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(data) < n {
+			return 0, nil, nil, false
+		}
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<8 | int(b)
+		}
+		data = data[n:]
+	}
+	if length > len(data) {
+		return 0, nil, nil, false
+	}
+	return tag, data[:length], data[length:], true
+}
+
+// derTLV encodes a DER element with the tag and contents given
+func derTLV(tag byte, body []byte) []byte {
+	out := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
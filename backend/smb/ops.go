@@ -0,0 +1,370 @@
+package smb
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// fileID is the handle of an open file
+type fileID [16]byte
+
+// fileInfo describes a file or directory
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	attrs   uint32
+}
+
+// isDir returns whether the info is for a directory
+func (fi *fileInfo) isDir() bool {
+	return fi.attrs&fileAttributeDirectory != 0
+}
+
+// fsSize is the size of a file system in bytes
+type fsSize struct {
+	total int64
+	free  int64
+}
+
+// pathName returns the name to use for the share relative path p
+// in requests for t
+//
+// Names on DFS shares include the server and share.
+func (c *conn) pathName(t *tree, p string) (name string, flags uint32) {
+	if !t.dfs {
+		return p, 0
+	}
+	name = c.server + `\` + t.share
+	if p != "" {
+		name += `\` + p
+	}
+	return name, flagsDFSOperations
+}
+
+// create opens or creates the file at the share relative path p
+// returning its handle and information
+func (c *conn) create(t *tree, p string, access, disposition, options uint32) (id fileID, fi fileInfo, err error) {
+	name, flags := c.pathName(t, p)
+	nameBytes := smb2.EncodeUTF16(name)
+	var w smb2.Writer
+	w.Uint16(57)
+	w.Uint8(0) // security flags
+	w.Uint8(0) // oplock level
+	w.Uint32(impersonationImpersonation)
+	w.Zero(16) // create flags and reserved
+	w.Uint32(access | synchronize)
+	w.Uint32(0) // file attributes
+	w.Uint32(fileShareAll)
+	w.Uint32(disposition)
+	w.Uint32(options)
+	w.Uint16(headerSize + 56)
+	w.Uint16(uint16(len(nameBytes)))
+	w.Uint32(0) // create contexts
+	w.Uint32(0)
+	w.Append(nameBytes)
+	if len(nameBytes) == 0 {
+		w.Uint8(0)
+	}
+	resp, err := c.request(cmdCreate, t, flags, 1, w.Bytes())
+	if err != nil {
+		return id, fi, err
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 8)
+	r.Skip(16) // creation and last access times
+	fi.modTime = smb2.FromFiletime(r.Uint64())
+	r.Skip(8) // change time
+	r.Skip(8) // allocation size
+	fi.size = int64(r.Uint64())
+	fi.attrs = r.Uint32()
+	r.Skip(4)
+	copy(id[:], r.Next(16))
+	if r.Err() != nil {
+		return id, fi, r.Err()
+	}
+	return id, fi, nil
+}
+
+// close closes the file with id
+func (c *conn) closeFile(t *tree, id fileID) error {
+	var w smb2.Writer
+	w.Uint16(24)
+	w.Uint16(0) // flags
+	w.Uint32(0)
+	w.Append(id[:])
+	_, err := c.request(cmdClose, t, 0, 1, w.Bytes())
+	return err
+}
+
+// read reads into p from offset returning the number of bytes read
+//
+// It returns io.EOF at the end of the file, and statusBufferOverflow
+// along with the data if there is more to read from a pipe.
+func (c *conn) read(t *tree, id fileID, offset int64, p []byte) (n int, err error) {
+	size := c.ioSize(c.maxRead)
+	if uint32(len(p)) < size {
+		size = uint32(len(p))
+	}
+	var w smb2.Writer
+	w.Uint16(49)
+	w.Uint8(0) // padding
+	w.Uint8(0) // flags
+	w.Uint32(size)
+	w.Uint64(uint64(offset))
+	w.Append(id[:])
+	w.Uint32(0) // minimum count
+	w.Uint32(0) // channel
+	w.Uint32(0) // remaining bytes
+	w.Uint16(0) // read channel info
+	w.Uint16(0)
+	w.Uint8(0)
+	resp, status, err := c.call(cmdRead, t, 0, creditCharge(int(size)), w.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	switch status {
+	case statusSuccess, statusBufferOverflow:
+	case statusEndOfFile:
+		return 0, io.EOF
+	default:
+		return 0, status
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 2)
+	dataOffset := int(r.Uint8())
+	r.Skip(1)
+	data := r.At(dataOffset, int(r.Uint32()))
+	if r.Err() != nil {
+		return 0, r.Err()
+	}
+	n = copy(p, data)
+	if status == statusBufferOverflow {
+		// more data to read from a pipe
+		return n, status
+	}
+	return n, nil
+}
+
+// write writes as much of p as fits in one request at offset
+// returning the number of bytes written
+func (c *conn) write(t *tree, id fileID, offset int64, p []byte) (n int, err error) {
+	size := c.ioSize(c.maxWrite)
+	if uint32(len(p)) < size {
+		size = uint32(len(p))
+	}
+	var w smb2.Writer
+	w.Uint16(49)
+	w.Uint16(headerSize + 48)
+	w.Uint32(size)
+	w.Uint64(uint64(offset))
+	w.Append(id[:])
+	w.Uint32(0) // channel
+	w.Uint32(0) // remaining bytes
+	w.Uint16(0) // write channel info
+	w.Uint16(0)
+	w.Uint32(0) // flags
+	w.Append(p[:size])
+	resp, err := c.request(cmdWrite, t, 0, creditCharge(int(size)), w.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 4)
+	n = int(r.Uint32())
+	if r.Err() != nil {
+		return 0, r.Err()
+	}
+	if n > int(size) {
+		return 0, errors.New("server wrote more than asked")
+	}
+	return n, nil
+}
+
+// queryDirectory lists the directory with id calling fn for each
+// entry other than "." and ".."
+func (c *conn) queryDirectory(t *tree, id fileID, fn func(fi fileInfo) error) error {
+	pattern := smb2.EncodeUTF16("*")
+	flags := uint8(restartScans)
+	for {
+		outputLength := c.maxTransact
+		if outputLength > creditSize {
+			outputLength = creditSize
+		}
+		var w smb2.Writer
+		w.Uint16(33)
+		w.Uint8(fileDirectoryInformation)
+		w.Uint8(flags)
+		w.Uint32(0) // file index
+		w.Append(id[:])
+		w.Uint16(headerSize + 32)
+		w.Uint16(uint16(len(pattern)))
+		w.Uint32(outputLength)
+		w.Append(pattern)
+		flags = 0
+		resp, status, err := c.call(cmdQueryDirectory, t, 0, creditCharge(int(outputLength)), w.Bytes())
+		if err != nil {
+			return err
+		}
+		switch status {
+		case statusSuccess:
+		case statusNoMoreFiles, statusNoSuchFile:
+			return nil
+		default:
+			return status
+		}
+		r := smb2.NewReader(resp)
+		r.Seek(headerSize + 2)
+		data := r.At(int(r.Uint16()), int(r.Uint32()))
+		if r.Err() != nil {
+			return r.Err()
+		}
+		for len(data) > 0 {
+			e := smb2.NewReader(data)
+			next := int(e.Uint32())
+			e.Skip(4)  // file index
+			e.Skip(16) // creation and last access times
+			fi := fileInfo{modTime: smb2.FromFiletime(e.Uint64())}
+			e.Skip(8) // change time
+			fi.size = int64(e.Uint64())
+			e.Skip(8) // allocation size
+			fi.attrs = e.Uint32()
+			fi.name = smb2.DecodeUTF16(e.Next(int(e.Uint32())))
+			if e.Err() != nil {
+				return e.Err()
+			}
+			if fi.name != "." && fi.name != ".." {
+				if err := fn(fi); err != nil {
+					return err
+				}
+			}
+			if next == 0 || next > len(data) {
+				break
+			}
+			data = data[next:]
+		}
+	}
+}
+
+// setInfo sets the file information class for id to info
+func (c *conn) setInfo(t *tree, id fileID, class uint8, info []byte) error {
+	var w smb2.Writer
+	w.Uint16(33)
+	w.Uint8(infoFile)
+	w.Uint8(class)
+	w.Uint32(uint32(len(info)))
+	w.Uint16(headerSize + 32)
+	w.Uint16(0)
+	w.Uint32(0) // additional information
+	w.Append(id[:])
+	w.Append(info)
+	_, err := c.request(cmdSetInfo, t, 0, 1, w.Bytes())
+	return err
+}
+
+// setModTime sets the modification time of id
+func (c *conn) setModTime(t *tree, id fileID, modTime time.Time) error {
+	var w smb2.Writer
+	w.Zero(16) // don't change creation and last access time
+	w.Uint64(smb2.ToFiletime(modTime))
+	w.Zero(8) // don't change change time
+	w.Uint32(0)
+	w.Uint32(0)
+	return c.setInfo(t, id, fileBasicInformation, w.Bytes())
+}
+
+// rename renames id to the share relative path p replacing any
+// existing file
+func (c *conn) rename(t *tree, id fileID, p string) error {
+	name := smb2.EncodeUTF16(p)
+	var w smb2.Writer
+	w.Uint8(1) // replace if exists
+	w.Zero(7)
+	w.Uint64(0) // root directory
+	w.Uint32(uint32(len(name)))
+	w.Append(name)
+	return c.setInfo(t, id, fileRenameInformation, w.Bytes())
+}
+
+// setDeleteOnClose marks id to be deleted when it is closed
+func (c *conn) setDeleteOnClose(t *tree, id fileID) error {
+	return c.setInfo(t, id, fileDispositionInformation, []byte{1})
+}
+
+// queryFsSize returns the size of the file system containing id
+func (c *conn) queryFsSize(t *tree, id fileID) (size fsSize, err error) {
+	var w smb2.Writer
+	w.Uint16(41)
+	w.Uint8(infoFilesystem)
+	w.Uint8(fileSystemFsFullSizeInformation)
+	w.Uint32(32) // output length
+	w.Uint16(0)  // input offset
+	w.Uint16(0)
+	w.Uint32(0) // input length
+	w.Uint32(0) // additional information
+	w.Uint32(0) // flags
+	w.Append(id[:])
+	resp, err := c.request(cmdQueryInfo, t, 0, 1, w.Bytes())
+	if err != nil {
+		return size, err
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 2)
+	info := smb2.NewReader(r.At(int(r.Uint16()), int(r.Uint32())))
+	totalUnits := info.Uint64()
+	freeUnits := info.Uint64()
+	_ = info.Uint64() // actually available
+	unitSize := int64(info.Uint32()) * int64(info.Uint32())
+	if r.Err() != nil {
+		return size, r.Err()
+	}
+	if info.Err() != nil {
+		return size, info.Err()
+	}
+	size.total = int64(totalUnits) * unitSize
+	size.free = int64(freeUnits) * unitSize
+	return size, nil
+}
+
+// ioctl runs the FSCTL ctlCode on id with input returning the
+// output
+//
+// It returns statusBufferOverflow along with the output if there is
+// more output to read.
+func (c *conn) ioctl(t *tree, id fileID, ctlCode uint32, input []byte, maxOutput uint32) ([]byte, error) {
+	var w smb2.Writer
+	w.Uint16(57)
+	w.Uint16(0)
+	w.Uint32(ctlCode)
+	w.Append(id[:])
+	w.Uint32(headerSize + 56) // input offset
+	w.Uint32(uint32(len(input)))
+	w.Uint32(0) // max input response
+	w.Uint32(0) // output offset
+	w.Uint32(0) // output count
+	w.Uint32(maxOutput)
+	w.Uint32(ioctlIsFsctl)
+	w.Uint32(0)
+	w.Append(input)
+	resp, status, err := c.call(cmdIoctl, t, 0, creditCharge(len(input)+int(maxOutput)), w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status != statusSuccess && status != statusBufferOverflow {
+		return nil, status
+	}
+	r := smb2.NewReader(resp)
+	r.Seek(headerSize + 32)
+	outputOffset := int(r.Uint32())
+	output := r.At(outputOffset, int(r.Uint32()))
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if status == statusBufferOverflow {
+		return output, status
+	}
+	return output, nil
+}
@@ -0,0 +1,1082 @@
+// Package smb provides an interface to SMB servers
+package smb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/bucket"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/env"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+const (
+	minSleep      = 10 * time.Millisecond
+	maxSleep      = 2 * time.Second
+	decayConstant = 2 // bigger for slower decay, exponential
+)
+
+var (
+	currentUser = env.CurrentUser()
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "smb",
+		Description: "SMB / CIFS",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "host",
+			Help:     "SMB server hostname to connect to, or the name of a domain based DFS namespace",
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "example.com",
+				Help:  "Connect to example.com",
+			}},
+		}, {
+			Name: "user",
+			Help: "SMB username, leave blank for current username, " + currentUser,
+		}, {
+			Name:    "port",
+			Help:    "SMB port number",
+			Default: 445,
+		}, {
+			Name:       "pass",
+			Help:       "SMB password",
+			IsPassword: true,
+		}, {
+			Name:    "domain",
+			Help:    "Domain name for NTLM authentication",
+			Default: "WORKGROUP",
+		}, {
+			Name: "kerberos",
+			Help: `Use Kerberos authentication
+
+If set then rclone authenticates with a Kerberos ticket for the
+cifs service of the server instead of with the user and password.
+
+The ticket is got using the credential cache from KRB5CCNAME or
+/tmp/krb5cc_UID, so run "kinit" first, unless kerberos_keytab is set.
+The Kerberos config is read from KRB5_CONFIG or /etc/krb5.conf.`,
+			Default: false,
+		}, {
+			Name: "spn",
+			Help: `Service principal name
+
+Rclone asks for a Kerberos ticket for this name. Leave blank to use
+cifs/HOST where HOST is the name of the server being connected to,
+which is what most servers expect.`,
+			Advanced: true,
+		}, {
+			Name:     "kerberos_principal",
+			Help:     "Kerberos principal to log in as with the keytab, as user@REALM",
+			Advanced: true,
+		}, {
+			Name:     "kerberos_keytab",
+			Help:     "Path to a keytab to log in with instead of the credential cache",
+			Advanced: true,
+		}, {
+			Name: "encrypt",
+			Help: `Require SMB3 encryption
+
+If set then all messages on the connection are encrypted, and it is
+an error if the server doesn't support SMB 3 encryption. Shares which
+require encryption are always encrypted.`,
+			Default: false,
+		}, {
+			Name: "idle_timeout",
+			Help: `Max time before closing idle connections
+
+If no connections have been returned to the connection pool in the time
+given, rclone will empty the connection pool.
+
+Set to 0 to keep connections indefinitely.`,
+			Default:  fs.Duration(60 * time.Second),
+			Advanced: true,
+		}, {
+			Name:     "hide_special_share",
+			Help:     "Hide special shares (e.g. print$) which users aren't supposed to access",
+			Default:  true,
+			Advanced: true,
+		}, {
+			Name: "case_insensitive",
+			Help: `Whether the server is configured to be case insensitive
+
+Always true on Windows shares.`,
+			Default:  true,
+			Advanced: true,
+		}, {
+			Name:     config.ConfigEncoding,
+			Help:     config.ConfigEncodingHelp,
+			Advanced: true,
+			Default: (encoder.Base |
+				encoder.EncodeWin |
+				encoder.EncodeBackSlash |
+				encoder.EncodeCtl |
+				encoder.EncodeRightSpace |
+				encoder.EncodeRightPeriod |
+				encoder.EncodeInvalidUtf8),
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Host              string               `config:"host"`
+	User              string               `config:"user"`
+	Port              int                  `config:"port"`
+	Pass              string               `config:"pass"`
+	Domain            string               `config:"domain"`
+	Kerberos          bool                 `config:"kerberos"`
+	SPN               string               `config:"spn"`
+	KerberosPrincipal string               `config:"kerberos_principal"`
+	KerberosKeytab    string               `config:"kerberos_keytab"`
+	Encrypt           bool                 `config:"encrypt"`
+	IdleTimeout       fs.Duration          `config:"idle_timeout"`
+	HideSpecialShare  bool                 `config:"hide_special_share"`
+	CaseInsensitive   bool                 `config:"case_insensitive"`
+	Enc               encoder.MultiEncoder `config:"encoding"`
+}
+
+// Fs represents a remote SMB server
+type Fs struct {
+	name          string       // name of this remote
+	root          string       // the path we are working on if any
+	rootShare     string       // share part of root (if any)
+	rootDirectory string       // directory part of root (if any)
+	opt           Options      // parsed options
+	features      *fs.Features // optional features
+	pacer         *fs.Pacer    // pacer for operations
+	pass          string       // revealed password
+	dfs           *dfsCache    // cached DFS referrals
+
+	krbMu sync.Mutex
+	krb   *krb.Client // Kerberos client, made when first needed
+
+	poolMu sync.Mutex
+	pool   map[string][]*conn // idle connections by lower case server name
+	drain  *time.Timer        // used to drain the pool when we stop using the connections
+}
+
+// Object describes an SMB file
+type Object struct {
+	fs      *Fs    // what this object is part of
+	remote  string // the remote path
+	size    int64
+	modTime time.Time
+}
+
+// ------------------------------------------------------------
+
+// Name of this fs
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	if f.rootShare == "" {
+		return fmt.Sprintf("smb://%s", f.opt.Host)
+	}
+	return fmt.Sprintf("smb://%s/%s", f.opt.Host, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// setRoot changes the root of the Fs
+func (f *Fs) setRoot(root string) {
+	f.root = strings.Trim(root, "/")
+	f.rootShare, f.rootDirectory = bucket.Split(f.root)
+}
+
+// NewFs constructs an Fs from the path, share/path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.User == "" {
+		opt.User = currentUser
+	}
+	f := &Fs{
+		name:  name,
+		opt:   *opt,
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+		dfs:   newDFSCache(),
+		pool:  map[string][]*conn{},
+	}
+	if opt.Pass != "" {
+		f.pass, err = obscure.Reveal(opt.Pass)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewFs decrypt password")
+		}
+	}
+	f.setRoot(root)
+	f.features = (&fs.Features{
+		CaseInsensitive:         opt.CaseInsensitive,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+		BucketBasedRootOK:       true,
+	}).Fill(ctx, f)
+	if f.opt.IdleTimeout > 0 {
+		f.drain = time.AfterFunc(time.Duration(opt.IdleTimeout), func() { _ = f.drainPool(ctx) })
+	}
+
+	// Make a connection to check the config works
+	c, err := f.getConn(ctx, f.opt.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewFs")
+	}
+	f.putConn(&c, nil)
+
+	if f.rootShare != "" && f.rootDirectory != "" {
+		// Check to see if the root is actually an existing file
+		oldRoot := f.root
+		newRoot, leaf := path.Split(oldRoot)
+		f.setRoot(newRoot)
+		_, err := f.NewObject(ctx, leaf)
+		if err != nil {
+			if err == fs.ErrorObjectNotFound || errors.Cause(err) == fs.ErrorNotAFile {
+				// File doesn't exist so return old f
+				f.setRoot(oldRoot)
+				return f, nil
+			}
+			return nil, err
+		}
+		// return an error with an fs which points to the parent
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// newAuthenticator makes an authenticator for server
+func (f *Fs) newAuthenticator(server string) (authenticator, error) {
+	if !f.opt.Kerberos {
+		return newNTLMAuth(f.opt.User, f.opt.Domain, f.pass), nil
+	}
+	f.krbMu.Lock()
+	defer f.krbMu.Unlock()
+	if f.krb == nil {
+		cl, err := newKerberosClient(&f.opt)
+		if err != nil {
+			return nil, err
+		}
+		f.krb = cl
+	}
+	spn := "cifs/" + server
+	if f.opt.SPN != "" && strings.EqualFold(server, f.opt.Host) {
+		spn = f.opt.SPN
+	}
+	return newKerberosAuth(f.krb, spn), nil
+}
+
+// Open a new connection to server and log in
+func (f *Fs) newConn(ctx context.Context, server string) (c *conn, err error) {
+	fs.Debugf(f, "Connecting to SMB server %s", server)
+	c, err = dial(ctx, net.JoinHostPort(server, fmt.Sprint(f.opt.Port)), server)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't connect SMB")
+	}
+	a, err := f.newAuthenticator(server)
+	if err == nil {
+		err = c.sessionSetup(a, f.opt.Encrypt)
+	}
+	if err != nil {
+		_ = c.close()
+		return nil, errors.Wrapf(err, "couldn't log in to %s", server)
+	}
+	return c, nil
+}
+
+// Get an SMB connection to server from the pool, or open a new one
+func (f *Fs) getConn(ctx context.Context, server string) (c *conn, err error) {
+	key := strings.ToLower(server)
+	f.poolMu.Lock()
+	if pool := f.pool[key]; len(pool) > 0 {
+		c = pool[len(pool)-1]
+		f.pool[key] = pool[:len(pool)-1]
+	}
+	f.poolMu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		c, err = f.newConn(ctx, server)
+		return shouldRetry(ctx, err), err
+	})
+	return c, err
+}
+
+// Return an SMB connection to the pool
+//
+// It nils the pointed to connection out so it can't be reused
+//
+// If the connection has failed or the session has ended then it is
+// closed instead.
+func (f *Fs) putConn(pc **conn, err error) {
+	c := *pc
+	*pc = nil
+	if c.err == nil {
+		switch errors.Cause(err) {
+		case statusNetworkSessionExpired, statusUserSessionDeleted:
+			c.err = err
+		}
+	}
+	if c.err != nil {
+		fs.Debugf(f, "Closing failed SMB connection: %v", c.err)
+		_ = c.close()
+		return
+	}
+	f.poolMu.Lock()
+	key := strings.ToLower(c.server)
+	f.pool[key] = append(f.pool[key], c)
+	if f.drain != nil {
+		f.drain.Reset(time.Duration(f.opt.IdleTimeout)) // nudge on the pool emptying timer
+	}
+	f.poolMu.Unlock()
+}
+
+// Drain the pool of any connections
+func (f *Fs) drainPool(ctx context.Context) (err error) {
+	f.poolMu.Lock()
+	defer f.poolMu.Unlock()
+	if f.drain != nil {
+		f.drain.Stop()
+	}
+	for key, pool := range f.pool {
+		for _, c := range pool {
+			if cErr := c.close(); cErr != nil {
+				err = cErr
+			}
+		}
+		delete(f.pool, key)
+	}
+	return err
+}
+
+// retryStatuses are the NTSTATUS codes which are worth retrying
+var retryStatuses = map[ntStatus]bool{
+	statusInsufficientResources: true,
+	statusNetworkNameDeleted:    true,
+	statusNetworkSessionExpired: true,
+	statusUserSessionDeleted:    true,
+}
+
+// shouldRetry returns a boolean as to whether this err deserves to be
+// retried
+func shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if status, ok := errors.Cause(err).(ntStatus); ok {
+		return retryStatuses[status]
+	}
+	return fserrors.ShouldRetry(err)
+}
+
+// location returns where the root relative path is
+func (f *Fs) location(rootRelativePath string) location {
+	return f.absLocation(path.Join(f.root, rootRelativePath))
+}
+
+// absLocation returns where abspath, which is share/path, is
+func (f *Fs) absLocation(abspath string) location {
+	share, sharePath := bucket.Split(abspath)
+	return location{
+		server: f.opt.Host,
+		share:  f.opt.Enc.FromStandardName(share),
+		path:   strings.Replace(f.opt.Enc.FromStandardPath(sharePath), "/", `\`, -1),
+	}
+}
+
+// do runs fn with a connection and tree for the share holding l
+// following DFS referrals as needed
+//
+// fn is passed the share relative path on the server actually used.
+// If fn leaves the connection held it isn't returned to the pool.
+func (f *Fs) do(ctx context.Context, l location, fn func(c *conn, t *tree, p string) error) error {
+	for hop := 0; ; hop++ {
+		target := f.dfs.resolve(l, time.Now())
+		c, err := f.getConn(ctx, target.server)
+		if err != nil {
+			if target.server != l.server && hop < maxReferralHops {
+				// try the next DFS target
+				fs.Debugf(f, "DFS target %v failed: %v", target, err)
+				f.dfs.failed(target)
+				continue
+			}
+			return err
+		}
+		t, err := c.treeConnect(target.share)
+		if err == nil {
+			err = fn(c, t, target.path)
+		}
+		if c.held {
+			return err
+		}
+		cause := errors.Cause(err)
+		if (cause == statusPathNotCovered || cause == statusBadNetworkName) && c.err == nil && hop < maxReferralHops {
+			ref, refErr := c.getReferral(target)
+			if refErr == nil {
+				fs.Debugf(f, "DFS referral for %q to %q", ref.prefix, ref.targets)
+				f.dfs.add(ref)
+				f.putConn(&c, nil)
+				continue
+			}
+			fs.Debugf(f, "DFS referral for %v failed: %v", target, refErr)
+		}
+		f.putConn(&c, err)
+		return err
+	}
+}
+
+// call runs do with the pacer retrying errors which are worth it
+func (f *Fs) call(ctx context.Context, l location, fn func(c *conn, t *tree, p string) error) error {
+	return f.pacer.Call(func() (bool, error) {
+		err := f.do(ctx, l, fn)
+		return shouldRetry(ctx, err), err
+	})
+}
+
+// isNotFound returns whether err means the file or directory
+// doesn't exist
+func isNotFound(err error) bool {
+	switch errors.Cause(err) {
+	case statusObjectNameNotFound, statusObjectPathNotFound, statusNoSuchFile, statusNotFound, statusBadNetworkName:
+		return true
+	}
+	return false
+}
+
+// translateErrorFile turns SMB errors into rclone errors for files
+func translateErrorFile(err error) error {
+	if isNotFound(err) {
+		return fs.ErrorObjectNotFound
+	}
+	return err
+}
+
+// translateErrorDir turns SMB errors into rclone errors for directories
+func translateErrorDir(err error) error {
+	if isNotFound(err) || errors.Cause(err) == statusNotADirectory {
+		return fs.ErrorDirNotFound
+	}
+	return err
+}
+
+// stat returns the info for the root relative path
+func (f *Fs) stat(ctx context.Context, remote string) (fi fileInfo, err error) {
+	err = f.call(ctx, f.location(remote), func(c *conn, t *tree, p string) error {
+		id, info, err := c.create(t, p, fileReadAttributes, fileOpen, 0)
+		if err != nil {
+			return err
+		}
+		fi = info
+		return c.closeFile(t, id)
+	})
+	return fi, err
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	share, sharePath := bucket.Split(path.Join(f.root, remote))
+	if share == "" || sharePath == "" {
+		return nil, fs.ErrorObjectNotFound
+	}
+	fi, err := f.stat(ctx, remote)
+	if err != nil {
+		return nil, translateErrorFile(err)
+	}
+	if fi.isDir() {
+		return nil, errors.Wrapf(fs.ErrorNotAFile, "%q", remote)
+	}
+	return f.newObject(remote, fi), nil
+}
+
+// newObject makes an Object from remote and fi
+func (f *Fs) newObject(remote string, fi fileInfo) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		size:    fi.size,
+		modTime: fi.modTime,
+	}
+}
+
+// listShares lists the shares on the server as directories
+func (f *Fs) listShares(ctx context.Context) (entries fs.DirEntries, err error) {
+	c, err := f.getConn(ctx, f.opt.Host)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := c.listShares()
+	f.putConn(&c, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list shares")
+	}
+	for _, share := range shares {
+		if f.opt.HideSpecialShare && strings.HasSuffix(share.name, "$") {
+			continue
+		}
+		entries = append(entries, fs.NewDir(f.opt.Enc.ToStandardName(share.name), time.Time{}))
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.rootShare == "" && dir == "" {
+		return f.listShares(ctx)
+	}
+	err = f.call(ctx, f.location(dir), func(c *conn, t *tree, p string) error {
+		entries = nil
+		id, _, err := c.create(t, p, fileListDirectory|fileReadAttributes, fileOpen, fileDirectoryFile)
+		if err != nil {
+			return err
+		}
+		err = c.queryDirectory(t, id, func(fi fileInfo) error {
+			remote := path.Join(dir, f.opt.Enc.ToStandardName(fi.name))
+			if fi.isDir() {
+				entries = append(entries, fs.NewDir(remote, fi.modTime))
+			} else {
+				entries = append(entries, f.newObject(remote, fi))
+			}
+			return nil
+		})
+		closeErr := c.closeFile(t, id)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+	if err != nil {
+		return nil, translateErrorDir(err)
+	}
+	return entries, nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	err := o.Update(ctx, in, src, options...)
+	if err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// mkdir makes the directory at abspath, which is share/path, and
+// its parents if needed
+func (f *Fs) mkdir(ctx context.Context, abspath string) error {
+	if abspath == "." {
+		abspath = ""
+	}
+	share, sharePath := bucket.Split(abspath)
+	if share == "" {
+		return nil
+	}
+	if sharePath == "" {
+		// shares can't be made so check it exists
+		err := f.call(ctx, f.absLocation(abspath), func(c *conn, t *tree, p string) error {
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "can't use share %q", share)
+		}
+		return nil
+	}
+	err := f.makeDir(ctx, abspath)
+	if errors.Cause(err) == statusObjectPathNotFound {
+		// make the parent and try again
+		err = f.mkdir(ctx, path.Dir(abspath))
+		if err != nil {
+			return err
+		}
+		err = f.makeDir(ctx, abspath)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "mkdir %q failed", abspath)
+	}
+	return nil
+}
+
+// makeDir makes the directory at abspath returning no error if it
+// exists already
+func (f *Fs) makeDir(ctx context.Context, abspath string) error {
+	err := f.call(ctx, f.absLocation(abspath), func(c *conn, t *tree, p string) error {
+		id, _, err := c.create(t, p, fileListDirectory|fileReadAttributes, fileCreate, fileDirectoryFile)
+		if err != nil {
+			return err
+		}
+		return c.closeFile(t, id)
+	})
+	if errors.Cause(err) == statusObjectNameCollision {
+		return nil
+	}
+	return err
+}
+
+// mkParentDir makes the parent of remote if necessary and any
+// directories above that
+func (f *Fs) mkParentDir(ctx context.Context, remote string) error {
+	return f.mkdir(ctx, path.Dir(path.Join(f.root, remote)))
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.mkdir(ctx, path.Join(f.root, dir))
+}
+
+// remove deletes the file or directory at l
+func (f *Fs) remove(ctx context.Context, l location, options uint32) error {
+	return f.call(ctx, l, func(c *conn, t *tree, p string) error {
+		id, _, err := c.create(t, p, accessDelete, fileOpen, options)
+		if err != nil {
+			return err
+		}
+		err = c.setDeleteOnClose(t, id)
+		closeErr := c.closeFile(t, id)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+}
+
+// Rmdir removes the directory if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	share, sharePath := bucket.Split(path.Join(f.root, dir))
+	if share == "" || sharePath == "" {
+		// shares can't be removed so check it is there
+		_, err := f.List(ctx, dir)
+		return err
+	}
+	err := f.remove(ctx, f.location(dir), fileDirectoryFile)
+	if err != nil {
+		return translateErrorDir(err)
+	}
+	return nil
+}
+
+// Precision is the precision of file times
+func (f *Fs) Precision() time.Duration {
+	return 100 * time.Nanosecond
+}
+
+// Hashes are not supported
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// rename renames the file or directory at src to dst which must be
+// on the same share
+func (f *Fs) rename(ctx context.Context, src, dst location, options uint32) error {
+	srcTarget := f.dfs.resolve(src, time.Now())
+	dstTarget := f.dfs.resolve(dst, time.Now())
+	if !strings.EqualFold(srcTarget.server, dstTarget.server) || !strings.EqualFold(srcTarget.share, dstTarget.share) {
+		return errCrossShare
+	}
+	return f.call(ctx, src, func(c *conn, t *tree, p string) error {
+		id, _, err := c.create(t, p, accessDelete|fileReadAttributes, fileOpen, options)
+		if err != nil {
+			return err
+		}
+		err = c.rename(t, id, dstTarget.path)
+		closeErr := c.closeFile(t, id)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+}
+
+// errCrossShare is returned when renaming between shares
+var errCrossShare = errors.New("can't rename between shares")
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	srcLocation := srcObj.fs.location(srcObj.remote)
+	dstLocation := f.location(remote)
+	if !strings.EqualFold(srcLocation.server, dstLocation.server) {
+		return nil, fs.ErrorCantMove
+	}
+	err := f.mkParentDir(ctx, remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "Move mkParentDir failed")
+	}
+	err = f.rename(ctx, srcLocation, dstLocation, fileNonDirectoryFile)
+	if err == errCrossShare {
+		return nil, fs.ErrorCantMove
+	} else if err != nil {
+		return nil, errors.Wrap(translateErrorFile(err), "Move rename failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcLocation := srcFs.location(srcRemote)
+	dstLocation := f.location(dstRemote)
+	if !strings.EqualFold(srcLocation.server, dstLocation.server) || srcLocation.path == "" || dstLocation.path == "" {
+		return fs.ErrorCantDirMove
+	}
+
+	// Check if destination exists
+	_, err := f.stat(ctx, dstRemote)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if !isNotFound(err) {
+		return errors.Wrap(err, "DirMove getInfo failed")
+	}
+
+	// Make sure the parent directory exists
+	err = f.mkParentDir(ctx, dstRemote)
+	if err != nil {
+		return errors.Wrap(err, "DirMove mkParentDir dst failed")
+	}
+
+	err = f.rename(ctx, srcLocation, dstLocation, fileDirectoryFile)
+	if err == errCrossShare {
+		return fs.ErrorCantDirMove
+	} else if err != nil {
+		return errors.Wrapf(translateErrorDir(err), "DirMove rename(%q,%q) failed", srcRemote, dstRemote)
+	}
+	return nil
+}
+
+// About gets quota information
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if f.rootShare == "" {
+		return nil, errors.New("about needs a share to be set in the path")
+	}
+	var size fsSize
+	err := f.call(ctx, f.location(""), func(c *conn, t *tree, p string) (err error) {
+		id, _, err := c.create(t, p, fileReadAttributes, fileOpen, fileDirectoryFile)
+		if err != nil {
+			return err
+		}
+		size, err = c.queryFsSize(t, id)
+		closeErr := c.closeFile(t, id)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "about failed")
+	}
+	return &fs.Usage{
+		Total: fs.NewUsageValue(size.total),
+		Used:  fs.NewUsageValue(size.total - size.free),
+		Free:  fs.NewUsageValue(size.free),
+	}, nil
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	return f.drainPool(ctx)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// String version of o
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the hash of an object returning a lowercase hex string
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	err := o.fs.call(ctx, o.fs.location(o.remote), func(c *conn, t *tree, p string) error {
+		id, _, err := c.create(t, p, fileWriteAttributes, fileOpen, fileNonDirectoryFile)
+		if err != nil {
+			return err
+		}
+		err = c.setModTime(t, id, modTime)
+		closeErr := c.closeFile(t, id)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+	if err != nil {
+		return errors.Wrap(translateErrorFile(err), "SetModTime failed")
+	}
+	o.modTime = modTime
+	return nil
+}
+
+// Storable returns a boolean as to whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// file is an open file holding its connection until it is closed
+type file struct {
+	f      *Fs
+	c      *conn
+	t      *tree
+	id     fileID
+	offset int64
+	closed bool
+}
+
+// openFile opens the file at remote holding the connection for it
+func (f *Fs) openFile(ctx context.Context, remote string, access, disposition uint32) (fh *file, err error) {
+	err = f.do(ctx, f.location(remote), func(c *conn, t *tree, p string) error {
+		id, _, err := c.create(t, p, access, disposition, fileNonDirectoryFile)
+		if err != nil {
+			return err
+		}
+		c.held = true
+		fh = &file{f: f, c: c, t: t, id: id}
+		return nil
+	})
+	return fh, err
+}
+
+// Read reads from the file at the current offset
+func (fh *file) Read(p []byte) (n int, err error) {
+	if fh.closed {
+		return 0, errors.New("read on closed file")
+	}
+	for n < len(p) && err == nil {
+		var nn int
+		nn, err = fh.c.read(fh.t, fh.id, fh.offset, p[n:])
+		if nn == 0 && err == nil {
+			err = io.EOF
+		}
+		n += nn
+		fh.offset += int64(nn)
+	}
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes p at the current offset
+func (fh *file) Write(p []byte) (n int, err error) {
+	if fh.closed {
+		return 0, errors.New("write on closed file")
+	}
+	for n < len(p) {
+		var nn int
+		nn, err = fh.c.write(fh.t, fh.id, fh.offset, p[n:])
+		if err != nil {
+			return n, err
+		}
+		if nn == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += nn
+		fh.offset += int64(nn)
+	}
+	return n, nil
+}
+
+// Close the file returning the connection to the pool
+func (fh *file) Close() error {
+	if fh.closed {
+		return nil
+	}
+	fh.closed = true
+	err := fh.c.closeFile(fh.t, fh.id)
+	fh.c.held = false
+	fh.f.putConn(&fh.c, err)
+	return err
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	fh, err := o.fs.openFile(ctx, o.remote, fileReadData|fileReadAttributes, fileOpen)
+	if err != nil {
+		return nil, errors.Wrap(translateErrorFile(err), "open")
+	}
+	fh.offset = offset
+	return readers.NewLimitedReadCloser(fh, limit), nil
+}
+
+// Update the already existing object
+//
+// Copy the reader into the object updating modTime and size
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	const access = fileWriteData | fileReadAttributes | fileWriteAttributes | accessDelete
+	fh, err := o.fs.openFile(ctx, o.remote, access, fileOverwriteIf)
+	if isNotFound(err) {
+		err = o.fs.mkParentDir(ctx, o.remote)
+		if err != nil {
+			return errors.Wrap(err, "Update mkParentDir failed")
+		}
+		fh, err = o.fs.openFile(ctx, o.remote, access, fileOverwriteIf)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Update create failed")
+	}
+	_, err = io.CopyBuffer(fh, in, make([]byte, maxIOSize))
+	if err == nil {
+		err = fh.c.setModTime(fh.t, fh.id, src.ModTime(ctx))
+	}
+	if err != nil {
+		// remove the file if the upload failed
+		if fh.c.err == nil {
+			if removeErr := fh.c.setDeleteOnClose(fh.t, fh.id); removeErr != nil {
+				fs.Debugf(o, "Failed to remove: %v", removeErr)
+			} else {
+				fs.Debugf(o, "Removed after failed upload: %v", err)
+			}
+		}
+		_ = fh.Close()
+		return errors.Wrap(err, "Update write failed")
+	}
+	err = fh.Close()
+	if err != nil {
+		return errors.Wrap(err, "Update close failed")
+	}
+	fi, err := o.fs.stat(ctx, o.remote)
+	if err != nil {
+		return errors.Wrap(err, "Update stat failed")
+	}
+	o.size = fi.size
+	o.modTime = fi.modTime
+	return nil
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	err := o.fs.remove(ctx, o.fs.location(o.remote), fileNonDirectoryFile)
+	if err != nil {
+		return translateErrorFile(err)
+	}
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = &Fs{}
+	_ fs.Mover       = &Fs{}
+	_ fs.DirMover    = &Fs{}
+	_ fs.PutStreamer = &Fs{}
+	_ fs.Abouter     = &Fs{}
+	_ fs.Shutdowner  = &Fs{}
+	_ fs.Object      = &Object{}
+)
@@ -0,0 +1,212 @@
+package smb
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// Test the CCM implementation against RFC 3610 packet vector #1
+func TestCCM(t *testing.T) {
+	key := mustDecodeHex(t, "C0C1C2C3C4C5C6C7C8C9CACBCCCDCECF")
+	nonce := mustDecodeHex(t, "00000003020100A0A1A2A3A4A5")
+	data := mustDecodeHex(t, "0001020304050607")
+	plaintext := mustDecodeHex(t, "08090A0B0C0D0E0F101112131415161718191A1B1C1D1E")
+	want := mustDecodeHex(t, "588C979A61C663D2F066D0C2C0F989806D5F6B61DAC38417E8D12CFDF926E0")
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := newCCM(block, len(nonce), 8)
+	require.NoError(t, err)
+
+	sealed := aead.Seal(nil, nonce, plaintext, data)
+	assert.Equal(t, want, sealed)
+
+	opened, err := aead.Open(nil, nonce, sealed, data)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	sealed[0] ^= 1
+	_, err = aead.Open(nil, nonce, sealed, data)
+	assert.Error(t, err)
+}
+
+func TestEncryptMessage(t *testing.T) {
+	key := mustDecodeHex(t, "000102030405060708090A0B0C0D0E0F")
+	msg := []byte("\xfeSMB the message to encrypt")
+	for _, id := range []uint16{cipherAES128CCM, cipherAES128GCM} {
+		aead, err := newCipher(id, key)
+		require.NoError(t, err)
+		nonce := make([]byte, aead.NonceSize())
+		nonce[0] = 42
+		frame := encryptMessage(aead, nonce, 0x1234, msg)
+		assert.Equal(t, transformHeaderSize+len(msg), len(frame))
+
+		sessionID, got, err := decryptMessage(aead, frame)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0x1234), sessionID)
+		assert.Equal(t, msg, got)
+
+		// the header is authenticated
+		frame[transformHeaderSize-1] ^= 1
+		_, _, err = decryptMessage(aead, frame)
+		assert.Error(t, err)
+	}
+	_, err := newCipher(99, key)
+	assert.Error(t, err)
+}
+
+// makeReferral makes a v3 RESP_GET_DFS_REFERRAL for requested
+// consuming prefix and referring it to targets
+func makeReferral(prefix string, ttl uint32, targets ...string) []byte {
+	const entrySize = 18
+	var w smb2.Writer
+	w.Uint16(uint16(len(smb2.EncodeUTF16(prefix))))
+	w.Uint16(uint16(len(targets)))
+	w.Uint32(0)
+	var addresses []byte
+	for i, target := range targets {
+		w.Uint16(3)
+		w.Uint16(entrySize)
+		w.Uint16(0) // server type
+		w.Uint16(0) // entry flags
+		w.Uint32(ttl)
+		w.Uint32(0) // DFS path and alternate path offsets
+		// offset of the address from the start of this entry
+		w.Uint16(uint16((len(targets)-i)*entrySize + len(addresses)))
+		addresses = append(addresses, smb2.EncodeUTF16(target+"\x00")...)
+	}
+	w.Append(addresses)
+	return w.Bytes()
+}
+
+func TestParseReferrals(t *testing.T) {
+	now := time.Now()
+	data := makeReferral(`\domain\dfs\docs`, 300, `\server1\docs`, `\server2\docs\`)
+	ref, err := parseReferrals(`\domain\dfs\docs\file.txt`, data, now)
+	require.NoError(t, err)
+	assert.Equal(t, `\domain\dfs\docs`, ref.prefix)
+	assert.Equal(t, []string{`\server1\docs`, `\server2\docs`}, ref.targets)
+	assert.Equal(t, now.Add(300*time.Second), ref.expires)
+
+	// short TTLs are raised to the minimum
+	ref, err = parseReferrals(`\domain\dfs\docs`, makeReferral(`\domain\dfs\docs`, 1, `\server1\docs`), now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(minReferralTTL), ref.expires)
+
+	_, err = parseReferrals(`\domain\dfs`, makeReferral(`\domain\dfs`, 300), now)
+	assert.Error(t, err)
+	_, err = parseReferrals(`\domain\dfs`, []byte{1, 2}, now)
+	assert.Error(t, err)
+}
+
+func TestParseLocation(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want location
+		ok   bool
+	}{
+		{`\server\share`, location{server: "server", share: "share"}, true},
+		{`\server\share\a\b\`, location{server: "server", share: "share", path: `a\b`}, true},
+		{`server\share\a`, location{server: "server", share: "share", path: "a"}, true},
+		{`\server`, location{}, false},
+		{`\server\`, location{}, false},
+	} {
+		got, ok := parseLocation(test.in)
+		assert.Equal(t, test.ok, ok, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+	assert.Equal(t, `\server\share\a\b`, location{server: "server", share: "share", path: `a\b`}.String())
+}
+
+func TestDFSCacheResolve(t *testing.T) {
+	now := time.Now()
+	d := newDFSCache()
+	l := location{server: "domain", share: "dfs", path: `docs\a\file.txt`}
+	assert.Equal(t, l, d.resolve(l, now))
+
+	d.add(&referral{prefix: `\domain\dfs`, targets: []string{`\root\dfs`}, expires: now.Add(time.Hour)})
+	d.add(&referral{prefix: `\DOMAIN\dfs\docs`, targets: []string{`\server1\docs`, `\server2\docs`}, expires: now.Add(time.Hour)})
+	d.add(&referral{prefix: `\server1\docs\a`, targets: []string{`\server3\a`}, expires: now.Add(time.Hour)})
+
+	// longest prefix is used and referrals are followed
+	assert.Equal(t, location{server: "server3", share: "a", path: "file.txt"}, d.resolve(l, now))
+	assert.Equal(t, location{server: "root", share: "dfs", path: "other"}, d.resolve(location{server: "domain", share: "dfs", path: "other"}, now))
+
+	// prefixes only match whole path elements
+	l2 := location{server: "domain", share: "dfs", path: `docsx`}
+	assert.Equal(t, location{server: "root", share: "dfs", path: "docsx"}, d.resolve(l2, now))
+
+	// failing a target moves on to the next one
+	d.failed(location{server: "server1", share: "docs", path: "b"})
+	assert.Equal(t, location{server: "server2", share: "docs", path: "b"}, d.resolve(location{server: "domain", share: "dfs", path: `docs\b`}, now))
+
+	// expired referrals are dropped
+	assert.Equal(t, l, d.resolve(l, now.Add(2*time.Hour)))
+	assert.Len(t, d.referrals, 0)
+
+	// referral loops terminate
+	d.add(&referral{prefix: `\a\x`, targets: []string{`\b\x`}, expires: now.Add(time.Hour)})
+	d.add(&referral{prefix: `\b\x`, targets: []string{`\a\x`}, expires: now.Add(time.Hour)})
+	got := d.resolve(location{server: "a", share: "x"}, now)
+	assert.Equal(t, "x", got.share)
+}
+
+func TestParseShareEnum(t *testing.T) {
+	type share struct {
+		name      string
+		shareType uint32
+		remark    string
+	}
+	in := []share{
+		{"files", shareTypeDiskTree, "Some files"},
+		{"IPC$", 0x80000003, "IPC Service"},
+		{"ADMIN$", 0x80000000, ""},
+		{"printer", 0x00000001, "A printer"},
+	}
+	var w smb2.NDRWriter
+	w.Uint32(1) // level
+	w.Uint32(1) // union discriminant
+	w.Pointer() // container
+	w.Uint32(uint32(len(in)))
+	w.Pointer() // array
+	w.Uint32(uint32(len(in)))
+	for _, s := range in {
+		w.Pointer()
+		w.Uint32(s.shareType)
+		w.Pointer()
+	}
+	for _, s := range in {
+		w.String(s.name)
+		w.String(s.remark)
+	}
+	w.Uint32(uint32(len(in))) // total entries
+	w.Uint32(0)               // null resume handle
+	w.Uint32(0)               // WERROR
+
+	shares, err := parseShareEnum(w.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, []shareInfo{
+		{name: "files", shareType: shareTypeDiskTree},
+		{name: "ADMIN$", shareType: 0x80000000},
+	}, shares)
+
+	// errors are returned
+	w.PutUint32(w.Len()-4, 5)
+	_, err = parseShareEnum(w.Bytes())
+	assert.Error(t, err)
+
+	_, err = parseShareEnum(w.Bytes()[:20])
+	assert.Error(t, err)
+}
@@ -0,0 +1,243 @@
+package smb
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// Listing the shares on a server with NetrShareEnum [MS-SRVS] using
+// DCE/RPC [C706] over the srvsvc named pipe.
+
+// DCE/RPC constants
+const (
+	rpcRequest          = 0
+	rpcResponse         = 2
+	rpcFault            = 3
+	rpcBind             = 11
+	rpcBindAck          = 12
+	rpcHeaderSize       = 16
+	rpcFirstFrag        = 0x01
+	rpcLastFrag         = 0x02
+	rpcMaxFrag          = 4280
+	rpcAcceptance       = 0
+	srvsvcNetrShareEnum = 15
+	shareTypeMask       = 0x0000000F
+	shareTypeDiskTree   = 0x00000000
+)
+
+var (
+	// NDR transfer syntax 8a885d04-1ceb-11c9-9fe8-08002b104860 v2
+	ndrSyntax = []byte{0x04, 0x5d, 0x88, 0x8a, 0xeb, 0x1c, 0xc9, 0x11, 0x9f, 0xe8, 0x08, 0x00, 0x2b, 0x10, 0x48, 0x60, 2, 0, 0, 0}
+	// srvsvc interface 4b324fc8-1670-01d3-1278-5a47bf6ee188 v3
+	srvsvcSyntax = []byte{0xc8, 0x4f, 0x32, 0x4b, 0x70, 0x16, 0xd3, 0x01, 0x12, 0x78, 0x5a, 0x47, 0xbf, 0x6e, 0xe1, 0x88, 3, 0, 0, 0}
+)
+
+// shareInfo is a share listed by srvsvc
+type shareInfo struct {
+	name      string
+	shareType uint32
+}
+
+// rpcPipe is an open named pipe
+type rpcPipe struct {
+	c      *conn
+	t      *tree
+	id     fileID
+	callID uint32
+}
+
+// listShares returns the disk shares on the server
+func (c *conn) listShares() (shares []shareInfo, err error) {
+	t, err := c.treeConnect("IPC$")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to IPC$")
+	}
+	id, _, err := c.create(t, "srvsvc", fileReadData|fileWriteData, fileOpen, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open srvsvc pipe")
+	}
+	defer func() {
+		closeErr := c.closeFile(t, id)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	p := &rpcPipe{c: c, t: t, id: id}
+	err = p.bind()
+	if err != nil {
+		return nil, err
+	}
+
+	var stub smb2.NDRWriter
+	stub.Pointer()
+	stub.String(c.server)
+	stub.Uint32(1) // level
+	stub.Uint32(1) // union discriminant
+	stub.Pointer() // container
+	stub.Uint32(0) // entries read
+	stub.Uint32(0) // null buffer
+	stub.Uint32(0xFFFFFFFF)
+	stub.Uint32(0) // null resume handle
+	var request smb2.Writer
+	request.Uint32(uint32(stub.Len()))
+	request.Uint16(0) // context id
+	request.Uint16(srvsvcNetrShareEnum)
+	request.Append(stub.Bytes())
+	ptype, reply, err := p.call(rpcRequest, request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if ptype != rpcResponse || len(reply) < 8 {
+		return nil, errors.New("NetrShareEnum failed")
+	}
+	return parseShareEnum(reply[8:])
+}
+
+// parseShareEnum parses the stub data of a level 1 NetrShareEnum
+// reply returning the disk shares
+func parseShareEnum(stub []byte) (shares []shareInfo, err error) {
+	r := smb2.NewReader(stub)
+	r.Skip(8) // level and union discriminant
+	if r.Uint32() == 0 {
+		return nil, errors.New("NetrShareEnum returned no container")
+	}
+	count := int(r.Uint32())
+	r.Skip(4) // array pointer
+	r.Skip(4) // max count
+	if r.Err() != nil || count > len(stub)/12 {
+		return nil, errors.New("bad NetrShareEnum reply")
+	}
+	type entry struct {
+		name, remark bool
+		shareType    uint32
+	}
+	entries := make([]entry, count)
+	for i := range entries {
+		entries[i].name = r.Uint32() != 0
+		entries[i].shareType = r.Uint32()
+		entries[i].remark = r.Uint32() != 0
+	}
+	for _, e := range entries {
+		var name string
+		if e.name {
+			name = smb2.ReadNDRString(r)
+		}
+		if e.remark {
+			_ = smb2.ReadNDRString(r)
+		}
+		if e.shareType&shareTypeMask == shareTypeDiskTree && name != "" {
+			shares = append(shares, shareInfo{name: name, shareType: e.shareType})
+		}
+	}
+	r.Skip(4) // total entries
+	if r.Uint32() != 0 {
+		r.Skip(4) // resume handle
+	}
+	werror := r.Uint32()
+	if r.Err() != nil {
+		return nil, errors.New("bad NetrShareEnum reply")
+	}
+	if werror != 0 {
+		return nil, errors.Errorf("NetrShareEnum failed with error 0x%08X", werror)
+	}
+	return shares, nil
+}
+
+// bind binds the pipe to the srvsvc interface
+func (p *rpcPipe) bind() error {
+	var bind smb2.Writer
+	bind.Uint16(rpcMaxFrag)
+	bind.Uint16(rpcMaxFrag)
+	bind.Uint32(0) // association group
+	bind.Uint8(1)  // contexts
+	bind.Zero(3)
+	bind.Uint16(0) // context id
+	bind.Uint8(1)  // transfer syntaxes
+	bind.Uint8(0)
+	bind.Append(srvsvcSyntax)
+	bind.Append(ndrSyntax)
+	ptype, ack, err := p.call(rpcBind, bind.Bytes())
+	if err != nil {
+		return err
+	}
+	if ptype != rpcBindAck {
+		return errors.New("srvsvc bind rejected")
+	}
+	r := smb2.NewReader(ack)
+	r.Seek(8)
+	r.Skip(int(r.Uint16())) // secondary address
+	r.Align(4)
+	if r.Uint8() < 1 {
+		return errors.New("bad srvsvc bind ack")
+	}
+	r.Skip(3)
+	result := r.Uint16()
+	if r.Err() != nil {
+		return errors.New("bad srvsvc bind ack")
+	}
+	if result != rpcAcceptance {
+		return errors.New("srvsvc bind not accepted")
+	}
+	return nil
+}
+
+// call sends a DCE/RPC packet of type ptype with body returning the
+// type and the body of the reply, joining the body of fragments
+func (p *rpcPipe) call(ptype uint8, body []byte) (replyType uint8, reply []byte, err error) {
+	p.callID++
+	var w smb2.Writer
+	w.Uint8(5)
+	w.Uint8(0)
+	w.Uint8(ptype)
+	w.Uint8(rpcFirstFrag | rpcLastFrag)
+	w.Uint32(0x10) // little endian, ASCII, IEEE floats
+	w.Uint16(uint16(rpcHeaderSize + len(body)))
+	w.Uint16(0) // auth length
+	w.Uint32(p.callID)
+	w.Append(body)
+	out, err := p.c.ioctl(p.t, p.id, fsctlPipeTransceive, w.Bytes(), rpcMaxFrag)
+	more := err == statusBufferOverflow
+	if err != nil && !more {
+		return 0, nil, err
+	}
+	for first := true; ; first = false {
+		// read more until there is a whole fragment
+		for len(out) < rpcHeaderSize || len(out) < int(out[8])|int(out[9])<<8 {
+			if !more && len(out) == 0 {
+				return 0, nil, errors.New("srvsvc pipe closed")
+			}
+			buf := make([]byte, rpcMaxFrag)
+			n, err := p.c.read(p.t, p.id, 0, buf)
+			if err == statusBufferOverflow {
+				more = true
+			} else if err != nil {
+				return 0, nil, errors.Wrap(err, "failed to read srvsvc pipe")
+			} else {
+				more = false
+			}
+			out = append(out, buf[:n]...)
+		}
+		r := smb2.NewReader(out)
+		r.Skip(2)
+		replyType = r.Uint8()
+		flags := r.Uint8()
+		r.Skip(4)
+		fragLength := int(r.Uint16())
+		if fragLength < rpcHeaderSize {
+			return 0, nil, errors.New("bad DCE/RPC packet")
+		}
+		if replyType == rpcFault {
+			return 0, nil, errors.New("srvsvc call failed")
+		}
+		frag := out[rpcHeaderSize:fragLength]
+		if !first && len(frag) >= 8 {
+			// only keep the stub data of later fragments
+			frag = frag[8:]
+		}
+		reply = append(reply, frag...)
+		out = out[fragLength:]
+		if flags&rpcLastFrag != 0 {
+			return replyType, reply, nil
+		}
+	}
+}
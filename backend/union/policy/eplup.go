@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"context"
+	"math"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+)
+
+func init() {
+	registerPolicy("eplup", &EpLup{})
+}
+
+// EpLup stands for existing path, least used percentage
+// Of all the candidates on which the path exists choose the one with the least percentage of its space used.
+// Create category: upstreams with less free space than min_free_space aren't used.
+type EpLup struct {
+	EpAll
+}
+
+func (p *EpLup) lup(upstreams []*upstream.Fs) (*upstream.Fs, error) {
+	var minUsedPercent = math.Inf(1)
+	var lupupstream *upstream.Fs
+	for _, u := range upstreams {
+		percent, err := u.GetUsedPercent()
+		if err != nil {
+			fs.LogPrintf(fs.LogLevelNotice, nil,
+				"Used and Free Space are not supported for upstream %s, treating as empty", u.Name())
+		}
+		if percent < minUsedPercent {
+			minUsedPercent = percent
+			lupupstream = u
+		}
+	}
+	if lupupstream == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return lupupstream, nil
+}
+
+func (p *EpLup) lupEntries(entries []upstream.Entry) (upstream.Entry, error) {
+	var minUsedPercent = math.Inf(1)
+	var lupEntry upstream.Entry
+	for _, e := range entries {
+		percent, err := e.UpstreamFs().GetUsedPercent()
+		if err != nil {
+			fs.LogPrintf(fs.LogLevelNotice, nil,
+				"Used and Free Space are not supported for upstream %s, treating as empty", e.UpstreamFs().Name())
+		}
+		if percent < minUsedPercent {
+			minUsedPercent = percent
+			lupEntry = e
+		}
+	}
+	if lupEntry == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return lupEntry, nil
+}
+
+// Action category policy, governing the modification of files and directories
+func (p *EpLup) Action(ctx context.Context, upstreams []*upstream.Fs, path string) ([]*upstream.Fs, error) {
+	upstreams, err := p.EpAll.Action(ctx, upstreams, path)
+	if err != nil {
+		return nil, err
+	}
+	u, err := p.lup(upstreams)
+	return []*upstream.Fs{u}, err
+}
+
+// ActionEntries is ACTION category policy but receiving a set of candidate entries
+func (p *EpLup) ActionEntries(entries ...upstream.Entry) ([]upstream.Entry, error) {
+	entries, err := p.EpAll.ActionEntries(entries...)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.lupEntries(entries)
+	return []upstream.Entry{e}, err
+}
+
+// Create category policy, governing the creation of files and directories
+func (p *EpLup) Create(ctx context.Context, upstreams []*upstream.Fs, path string) ([]*upstream.Fs, error) {
+	upstreams, err := p.EpAll.Create(ctx, upstreams, path)
+	if err != nil {
+		return nil, err
+	}
+	upstreams = filterFull(upstreams)
+	if len(upstreams) == 0 {
+		return nil, errAllFull
+	}
+	u, err := p.lup(upstreams)
+	return []*upstream.Fs{u}, err
+}
+
+// CreateEntries is CREATE category policy but receiving a set of candidate entries
+func (p *EpLup) CreateEntries(entries ...upstream.Entry) ([]upstream.Entry, error) {
+	entries, err := p.EpAll.CreateEntries(entries...)
+	if err != nil {
+		return nil, err
+	}
+	entries = filterFullEntries(entries)
+	if len(entries) == 0 {
+		return nil, errAllFull
+	}
+	e, err := p.lupEntries(entries)
+	return []upstream.Entry{e}, err
+}
+
+// Search category policy, governing the access to files and directories
+func (p *EpLup) Search(ctx context.Context, upstreams []*upstream.Fs, path string) (*upstream.Fs, error) {
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	upstreams, err := p.epall(ctx, upstreams, path)
+	if err != nil {
+		return nil, err
+	}
+	return p.lup(upstreams)
+}
+
+// SearchEntries is SEARCH category policy but receiving a set of candidate entries
+func (p *EpLup) SearchEntries(entries ...upstream.Entry) (upstream.Entry, error) {
+	if len(entries) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return p.lupEntries(entries)
+}
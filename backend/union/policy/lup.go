@@ -0,0 +1,38 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+)
+
+func init() {
+	registerPolicy("lup", &Lup{})
+}
+
+// Lup stands for least used percentage
+// Search category: same as eplup.
+// Action category: same as eplup.
+// Create category: Pick the drive with the least percentage of its space used,
+// ignoring drives with less free space than min_free_space.
+type Lup struct {
+	EpLup
+}
+
+// Create category policy, governing the creation of files and directories
+func (p *Lup) Create(ctx context.Context, upstreams []*upstream.Fs, path string) ([]*upstream.Fs, error) {
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	upstreams = filterNC(upstreams)
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorPermissionDenied
+	}
+	upstreams = filterFull(upstreams)
+	if len(upstreams) == 0 {
+		return nil, errAllFull
+	}
+	u, err := p.lup(upstreams)
+	return []*upstream.Fs{u}, err
+}
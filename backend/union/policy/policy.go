@@ -14,6 +14,10 @@ import (
 
 var policies = make(map[string]Policy)
 
+// errAllFull is returned by the policies which respect min_free_space
+// when there isn't an upstream with enough free space
+var errAllFull = errors.New("all upstreams have less free space than min_free_space")
+
 // Policy is the interface of a set of defined behavior choosing
 // the upstream Fs to operate on
 type Policy interface {
@@ -85,6 +89,24 @@ func filterNCEntries(ue []upstream.Entry) (wue []upstream.Entry) {
 	return wue
 }
 
+func filterFull(ufs []*upstream.Fs) (wufs []*upstream.Fs) {
+	for _, u := range ufs {
+		if !u.IsFull() {
+			wufs = append(wufs, u)
+		}
+	}
+	return wufs
+}
+
+func filterFullEntries(ue []upstream.Entry) (wue []upstream.Entry) {
+	for _, e := range ue {
+		if !e.UpstreamFs().IsFull() {
+			wue = append(wue, e)
+		}
+	}
+	return wue
+}
+
 func parentDir(absPath string) string {
 	parent := path.Dir(strings.TrimRight(absPath, "/"))
 	if parent == "." {
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+)
+
+func init() {
+	registerPolicy("rr", &Rr{})
+}
+
+// Rr stands for round robin
+// Search category: same as epall.
+// Action category: same as epall.
+// Create category: Pick each of the drives in turn, skipping drives with
+// less free space than min_free_space.
+type Rr struct {
+	EpAll
+	next uint32 // count of the upstreams picked so far - accessed with atomic
+}
+
+// pick returns the index of the next of n candidates to use
+func (p *Rr) pick(n int) int {
+	return int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+}
+
+// Create category policy, governing the creation of files and directories
+func (p *Rr) Create(ctx context.Context, upstreams []*upstream.Fs, path string) ([]*upstream.Fs, error) {
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	upstreams = filterNC(upstreams)
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorPermissionDenied
+	}
+	upstreams = filterFull(upstreams)
+	if len(upstreams) == 0 {
+		return nil, errAllFull
+	}
+	i := p.pick(len(upstreams))
+	return upstreams[i : i+1], nil
+}
+
+// CreateEntries is CREATE category policy but receiving a set of candidate entries
+func (p *Rr) CreateEntries(entries ...upstream.Entry) ([]upstream.Entry, error) {
+	entries, err := p.EpAll.CreateEntries(entries...)
+	if err != nil {
+		return nil, err
+	}
+	entries = filterFullEntries(entries)
+	if len(entries) == 0 {
+		return nil, errAllFull
+	}
+	i := p.pick(len(entries))
+	return entries[i : i+1], nil
+}
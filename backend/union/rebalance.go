@@ -0,0 +1,167 @@
+// Rebalancing the files between the upstreams
+
+package union
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// errRebalanceDone is used to stop the listing when enough has been moved
+var errRebalanceDone = errors.New("rebalance done")
+
+// balance is the space on an upstream being rebalanced
+type balance struct {
+	u     *upstream.Fs
+	used  int64
+	total int64
+}
+
+// percent returns the percentage of the upstream which is used
+func (b *balance) percent() float64 {
+	return 100 * float64(b.used) / float64(b.total)
+}
+
+// RebalanceStats is returned by the rebalance command
+type RebalanceStats struct {
+	Files int64 `json:"files"` // number of files moved
+	Bytes int64 `json:"bytes"` // total size of the files moved
+}
+
+// getBalances reads the space used on the upstreams which can be
+// rebalanced. These are the upstreams files can be created on which
+// support About.
+func (f *Fs) getBalances(ctx context.Context) (balances []*balance, err error) {
+	for _, u := range f.upstreams {
+		if !u.IsCreatable() {
+			continue
+		}
+		about := u.RootFs.Features().About
+		if about == nil {
+			continue
+		}
+		usage, err := about(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read usage of %s", fs.ConfigString(u.RootFs))
+		}
+		if usage.Used == nil || usage.Free == nil || *usage.Used+*usage.Free <= 0 {
+			continue
+		}
+		balances = append(balances, &balance{
+			u:     u,
+			used:  *usage.Used,
+			total: *usage.Used + *usage.Free,
+		})
+	}
+	return balances, nil
+}
+
+// rebalance moves files from the upstreams using the largest
+// percentage of their space to the ones using the smallest until the
+// percentages are within opt.RebalanceThreshold of each other, or no
+// more files can be moved.
+func (f *Fs) rebalance(ctx context.Context) (stats RebalanceStats, err error) {
+	f.rebalanceMu.Lock()
+	defer f.rebalanceMu.Unlock()
+	balances, err := f.getBalances(ctx)
+	if err != nil {
+		return stats, err
+	}
+	// upstreams with nothing left which can be moved
+	exhausted := make(map[*upstream.Fs]bool)
+	for {
+		var src, dst *balance
+		for _, b := range balances {
+			if !exhausted[b.u] && (src == nil || b.percent() > src.percent()) {
+				src = b
+			}
+			if dst == nil || b.percent() < dst.percent() {
+				dst = b
+			}
+		}
+		if src == nil || src == dst || src.percent()-dst.percent() <= float64(f.opt.RebalanceThreshold) {
+			break
+		}
+		// The number of bytes to move to make the percentages equal
+		want := int64((float64(src.used)*float64(dst.total) - float64(dst.used)*float64(src.total)) / float64(src.total+dst.total))
+		files, err := f.rebalanceMove(ctx, src, dst, want, &stats)
+		if err != nil {
+			return stats, err
+		}
+		if files == 0 {
+			exhausted[src.u] = true
+		}
+	}
+	return stats, nil
+}
+
+// rebalanceMove moves up to want bytes of files from src to dst,
+// returning the number of files moved.
+//
+// Files which are bigger than what is left to move, or which already
+// exist on dst, are skipped.
+func (f *Fs) rebalanceMove(ctx context.Context, src, dst *balance, want int64, stats *RebalanceStats) (files int64, err error) {
+	var moved int64
+	fs.Infof(f, "rebalance: moving up to %v from %s to %s", fs.SizeSuffix(want), fs.ConfigString(src.u.RootFs), fs.ConfigString(dst.u.RootFs))
+	err = walk.ListR(ctx, src.u, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			size := o.Size()
+			if size < 0 || size > want-moved || dst.total-dst.used-size < int64(f.opt.MinFreeSpace) {
+				continue
+			}
+			if _, err := dst.u.NewObject(ctx, o.Remote()); err == nil {
+				continue
+			}
+			_, err := operations.Move(ctx, dst.u, nil, o.Remote(), o)
+			if err != nil {
+				return errors.Wrapf(err, "rebalance: failed to move %q", o.Remote())
+			}
+			src.used -= size
+			dst.used += size
+			moved += size
+			files++
+			stats.Files++
+			stats.Bytes += size
+			if moved >= want {
+				return errRebalanceDone
+			}
+		}
+		return nil
+	})
+	if err == errRebalanceDone {
+		err = nil
+	}
+	return files, err
+}
+
+// rebalancer runs rebalance every opt.RebalanceInterval until ctx is
+// cancelled, closing done when it returns.
+func (f *Fs) rebalancer(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Duration(f.opt.RebalanceInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := f.rebalance(ctx)
+			if err != nil {
+				fs.Errorf(f, "rebalance failed: %v", err)
+			}
+			if stats.Files > 0 {
+				fs.Infof(f, "rebalance: moved %d files of total size %v", stats.Files, fs.SizeSuffix(stats.Bytes))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package union
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestUnion makes a union of two local directories with the files
+// passed in created in the first
+func makeTestUnion(t *testing.T, files ...string) (f *Fs, dir1, dir2 string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-union-rebalance")
+	require.NoError(t, err)
+	dir1 = filepath.Join(dir, "1")
+	dir2 = filepath.Join(dir, "2")
+	require.NoError(t, os.MkdirAll(dir1, 0777))
+	require.NoError(t, os.MkdirAll(dir2, 0777))
+	for _, file := range files {
+		path := filepath.Join(dir1, filepath.FromSlash(file))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, ioutil.WriteFile(path, []byte("0123456789"), 0666))
+	}
+	fsys, err := NewFs(context.Background(), "TestUnionRebalance", "", configmap.Simple{
+		"upstreams":     dir1 + " " + dir2,
+		"action_policy": "epall",
+		"create_policy": "epmfs",
+		"search_policy": "ff",
+	})
+	require.NoError(t, err)
+	return fsys.(*Fs), dir1, dir2, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestWhere(t *testing.T) {
+	ctx := context.Background()
+	f, dir1, dir2, cleanup := makeTestUnion(t, "file", "dir/file")
+	defer cleanup()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir2, "file"), []byte("0123456789"), 0666))
+
+	out, err := f.Command(ctx, "where", []string{"file", "dir/file", "missing"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]whereInfo{
+		"file":     {Upstreams: []string{dir1, dir2}, Search: dir1},
+		"dir/file": {Upstreams: []string{dir1}, Search: dir1},
+		"missing":  {Upstreams: []string{}},
+	}, out)
+}
+
+func TestRebalanceMove(t *testing.T) {
+	ctx := context.Background()
+	f, dir1, dir2, cleanup := makeTestUnion(t, "a", "dir/b", "dir/c", "d")
+	defer cleanup()
+	// "d" is already on the destination so shouldn't be moved
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir2, "d"), []byte("0123456789"), 0666))
+
+	src := &balance{u: f.upstreams[0], used: 90, total: 100}
+	dst := &balance{u: f.upstreams[1], used: 10, total: 100}
+	var stats RebalanceStats
+
+	// Move 2 files of 10 bytes - the others should be left
+	files, err := f.rebalanceMove(ctx, src, dst, 25, &stats)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), files)
+	assert.Equal(t, RebalanceStats{Files: 2, Bytes: 20}, stats)
+	assert.Equal(t, int64(70), src.used)
+	assert.Equal(t, int64(30), dst.used)
+
+	count := func(dir string) (n int) {
+		require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				n++
+			}
+			return err
+		}))
+		return n
+	}
+	assert.Equal(t, 2, count(dir1))
+	assert.Equal(t, 3, count(dir2))
+
+	// Check the files are still all visible in the union
+	for _, remote := range []string{"a", "dir/b", "dir/c", "d"} {
+		_, err := f.NewObject(ctx, remote)
+		assert.NoError(t, err, remote)
+	}
+
+	// Nothing else can be moved
+	files, err = f.rebalanceMove(ctx, src, dst, 100, &stats)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), files)
+	files, err = f.rebalanceMove(ctx, src, dst, 100, &stats)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), files)
+	assert.Equal(t, 1, count(dir1))
+}
@@ -28,6 +28,7 @@ func init() {
 		Name:        "union",
 		Description: "Union merges the contents of several upstream fs",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:     "upstreams",
 			Help:     "List of space separated upstreams.\nCan be 'upstreama:test/dir upstreamb:', '\"upstreama:test/space:ro dir\" upstreamb:', etc.\n",
@@ -52,6 +53,33 @@ func init() {
 			Help:     "Cache time of usage and free space (in seconds). This option is only useful when a path preserving policy is used.",
 			Required: true,
 			Default:  120,
+		}, {
+			Name: "min_free_space",
+			Help: `Minimum free space an upstream must have to create files on it.
+
+This is only used by the lup, eplup and rr create policies, and by
+the rebalancer which won't move files to an upstream if it would leave
+less than this free.`,
+			Default:  fs.SizeSuffix(1024 * 1024 * 1024),
+			Advanced: true,
+		}, {
+			Name: "rebalance_interval",
+			Help: `Interval between rebalancing the upstreams, 0 to disable.
+
+If set, rclone moves files from the upstreams using the largest
+percentage of their space to the ones using the smallest, every
+interval, until they are within rebalance_threshold of each other.
+
+Only upstreams which files can be created on and which support "rclone
+about" are rebalanced. Files are moved while the union is in use, so
+don't use this if the upstreams are also changed outside this union.`,
+			Default:  fs.Duration(0),
+			Advanced: true,
+		}, {
+			Name:     "rebalance_threshold",
+			Help:     "Difference in the percentage of space used on the upstreams at which to rebalance them.",
+			Default:  5,
+			Advanced: true,
 		}},
 	}
 	fs.Register(fsi)
@@ -65,6 +93,10 @@ type Options struct {
 	CreatePolicy string          `config:"create_policy"`
 	SearchPolicy string          `config:"search_policy"`
 	CacheTime    int             `config:"cache_time"`
+	MinFreeSpace fs.SizeSuffix   `config:"min_free_space"`
+	// Rebalancing
+	RebalanceInterval  fs.Duration `config:"rebalance_interval"`
+	RebalanceThreshold int         `config:"rebalance_threshold"`
 }
 
 // Fs represents a union of upstreams
@@ -78,6 +110,10 @@ type Fs struct {
 	actionPolicy policy.Policy  // policy for ACTION
 	createPolicy policy.Policy  // policy for CREATE
 	searchPolicy policy.Policy  // policy for SEARCH
+
+	rebalanceMu     sync.Mutex         // only one rebalance at once
+	rebalanceCancel context.CancelFunc // stop the rebalancer if set
+	rebalanceDone   chan struct{}      // closed when the rebalancer has stopped
 }
 
 // Wrap candidate objects in to a union Object
@@ -757,6 +793,10 @@ func (f *Fs) mergeDirEntries(entriesList [][]upstream.Entry) (fs.DirEntries, err
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
+	if f.rebalanceCancel != nil {
+		f.rebalanceCancel()
+		<-f.rebalanceDone
+	}
 	errs := Errors(make([]error, len(f.upstreams)))
 	multithread(len(f.upstreams), func(i int) {
 		u := f.upstreams[i]
@@ -768,6 +808,105 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 	return errs.Err()
 }
 
+var commandHelp = []fs.CommandHelp{{
+	Name:  "where",
+	Short: "Show which upstreams files are on",
+	Long: `This shows which upstreams each of the files passed in are on and
+which of those the search policy reads the file from.
+
+Usage Example:
+
+    rclone backend where union: path/to/file1 [path/to/file2...]
+    rclone rc backend/command command=where fs=union: path/to/file1 [path/to/file2...]
+
+The result is a JSON object keyed by the file name with the upstreams
+the file is on and the upstream it is read from, eg
+
+    {
+        "path/to/file1": {
+            "upstreams": ["remote1:dir", "remote2:dir"],
+            "search": "remote1:dir"
+        }
+    }
+
+Files which don't exist have no upstreams.
+`,
+}, {
+	Name:  "rebalance",
+	Short: "Rebalance the files between the upstreams",
+	Long: `This moves files from the upstreams using the largest percentage of
+their space to the ones using the smallest until they are within
+rebalance_threshold percent of each other. It is the same as the
+rebalancing done every rebalance_interval but runs straight away.
+
+Usage Example:
+
+    rclone backend rebalance union:
+    rclone rc backend/command command=rebalance fs=union:
+
+It returns the number of files moved and their total size. Use
+--dry-run to see what would be moved.
+`,
+}}
+
+// whereInfo is returned by the where command for each file
+type whereInfo struct {
+	Upstreams []string `json:"upstreams"` // the upstreams the file is on
+	Search    string   `json:"search"`    // the upstream the search policy reads the file from
+}
+
+// where finds which upstreams the file at remote is on
+func (f *Fs) where(ctx context.Context, remote string) (info whereInfo, err error) {
+	info.Upstreams = []string{}
+	var entries []upstream.Entry
+	for _, u := range f.upstreams {
+		o, err := u.NewObject(ctx, remote)
+		if err == fs.ErrorObjectNotFound || err == fs.ErrorNotAFile {
+			continue
+		} else if err != nil {
+			return info, errors.Wrapf(err, "failed to find %q on %s", remote, fs.ConfigString(u.RootFs))
+		}
+		entries = append(entries, u.WrapObject(o))
+		info.Upstreams = append(info.Upstreams, fs.ConfigString(u.RootFs))
+	}
+	if len(entries) == 0 {
+		return info, nil
+	}
+	e, err := f.searchEntries(entries...)
+	if err != nil {
+		return info, err
+	}
+	info.Search = fs.ConfigString(e.UpstreamFs().RootFs)
+	return info, nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "where":
+		out := make(map[string]whereInfo, len(arg))
+		for _, remote := range arg {
+			out[remote], err = f.where(ctx, remote)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case "rebalance":
+		return f.rebalance(ctx)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
 // NewFs constructs an Fs from the path.
 //
 // The returned Fs is the actual Fs, referenced by remote in the config
@@ -801,7 +940,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	errs := Errors(make([]error, len(opt.Upstreams)))
 	multithread(len(opt.Upstreams), func(i int) {
 		u := opt.Upstreams[i]
-		upstreams[i], errs[i] = upstream.New(ctx, u, root, time.Duration(opt.CacheTime)*time.Second)
+		upstreams[i], errs[i] = upstream.New(ctx, u, root, time.Duration(opt.CacheTime)*time.Second, int64(opt.MinFreeSpace))
 	})
 	var usedUpstreams []*upstream.Fs
 	var fserr error
@@ -874,6 +1013,14 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	}
 	f.hashSet = hashSet
 
+	if opt.RebalanceInterval > 0 && fserr == nil {
+		// Run in the background so not cancelled with ctx
+		var rebalanceCtx context.Context
+		rebalanceCtx, f.rebalanceCancel = context.WithCancel(context.Background())
+		f.rebalanceDone = make(chan struct{})
+		go f.rebalancer(rebalanceCtx, f.rebalanceDone)
+	}
+
 	return f, fserr
 }
 
@@ -911,4 +1058,5 @@ var (
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 )
@@ -24,16 +24,17 @@ var (
 // Fs is a wrap of any fs and its configs
 type Fs struct {
 	fs.Fs
-	RootFs      fs.Fs
-	RootPath    string
-	writable    bool
-	creatable   bool
-	usage       *fs.Usage     // Cache the usage
-	cacheTime   time.Duration // cache duration
-	cacheExpiry int64         // usage cache expiry time
-	cacheMutex  sync.RWMutex
-	cacheOnce   sync.Once
-	cacheUpdate bool // if the cache is updating
+	RootFs       fs.Fs
+	RootPath     string
+	writable     bool
+	creatable    bool
+	minFreeSpace int64         // the upstream is full when it has less free space than this
+	usage        *fs.Usage     // Cache the usage
+	cacheTime    time.Duration // cache duration
+	cacheExpiry  int64         // usage cache expiry time
+	cacheMutex   sync.RWMutex
+	cacheOnce    sync.Once
+	cacheUpdate  bool // if the cache is updating
 }
 
 // Directory describes a wrapped Directory
@@ -61,18 +62,19 @@ type Entry interface {
 
 // New creates a new Fs based on the
 // string formatted `type:root_path(:ro/:nc)`
-func New(ctx context.Context, remote, root string, cacheTime time.Duration) (*Fs, error) {
+func New(ctx context.Context, remote, root string, cacheTime time.Duration, minFreeSpace int64) (*Fs, error) {
 	_, configName, fsPath, err := fs.ParseRemote(remote)
 	if err != nil {
 		return nil, err
 	}
 	f := &Fs{
-		RootPath:    root,
-		writable:    true,
-		creatable:   true,
-		cacheExpiry: time.Now().Unix(),
-		cacheTime:   cacheTime,
-		usage:       &fs.Usage{},
+		RootPath:     root,
+		writable:     true,
+		creatable:    true,
+		minFreeSpace: minFreeSpace,
+		cacheExpiry:  time.Now().Unix(),
+		cacheTime:    cacheTime,
+		usage:        &fs.Usage{},
 	}
 	if strings.HasSuffix(fsPath, ":ro") {
 		f.writable = false
@@ -289,6 +291,30 @@ func (f *Fs) GetUsedSpace() (int64, error) {
 	return *f.usage.Used, nil
 }
 
+// GetUsedPercent gets the percentage of the space of the fs which is
+// used
+func (f *Fs) GetUsedPercent() (float64, error) {
+	used, err := f.GetUsedSpace()
+	if err != nil {
+		return 0, err
+	}
+	free, err := f.GetFreeSpace()
+	if err != nil {
+		return 0, err
+	}
+	if used+free <= 0 {
+		return 0, nil
+	}
+	return 100 * float64(used) / float64(used+free), nil
+}
+
+// IsFull returns true if the fs is known to have less free space
+// than the minimum free space
+func (f *Fs) IsFull() bool {
+	free, err := f.GetFreeSpace()
+	return err == nil && free < f.minFreeSpace
+}
+
 // GetNumObjects get the number of objects of the fs
 func (f *Fs) GetNumObjects() (int64, error) {
 	if atomic.LoadInt64(&f.cacheExpiry) <= time.Now().Unix() {
@@ -0,0 +1,136 @@
+// Package api contains definitions for using the WebHDFS REST API
+//
+// This is served by HDFS namenodes and datanodes and by HttpFS
+// gateways, including the one for Apache Ozone.
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// RemoteException is the body of an error response
+type RemoteException struct {
+	RemoteException struct {
+		Exception     string `json:"exception"`
+		JavaClassName string `json:"javaClassName"`
+		Message       string `json:"message"`
+	} `json:"RemoteException"`
+	StatusCode int `json:"-"`
+}
+
+// Error satisfies the error interface
+func (e *RemoteException) Error() string {
+	return fmt.Sprintf("%s: %s (%d)", e.RemoteException.Exception, e.RemoteException.Message, e.StatusCode)
+}
+
+// Exception returns the short name of the Java exception
+func (e *RemoteException) Exception() string {
+	return e.RemoteException.Exception
+}
+
+// Types of a FileStatus
+const (
+	TypeFile      = "FILE"
+	TypeDirectory = "DIRECTORY"
+	TypeSymlink   = "SYMLINK"
+)
+
+// ECPolicy describes an erasure coding policy
+type ECPolicy struct {
+	Name              string `json:"name"`
+	CellSize          int64  `json:"cellSize"`
+	NumDataUnits      int    `json:"numDataUnits"`
+	NumParityUnits    int    `json:"numParityUnits"`
+	CodecName         string `json:"codecName"`
+	ReplicationPolicy bool   `json:"replicationPolicy"`
+}
+
+// FileStatus describes a file or directory
+type FileStatus struct {
+	AccessTime       int64     `json:"accessTime"`
+	BlockSize        int64     `json:"blockSize"`
+	ChildrenNum      int64     `json:"childrenNum"`
+	FileID           int64     `json:"fileId"`
+	Group            string    `json:"group"`
+	Length           int64     `json:"length"`
+	ModificationTime int64     `json:"modificationTime"` // milliseconds since the epoch
+	Owner            string    `json:"owner"`
+	PathSuffix       string    `json:"pathSuffix"`
+	Permission       string    `json:"permission"`
+	Replication      int       `json:"replication"`
+	Type             string    `json:"type"`
+	ECBit            bool      `json:"ecBit"`
+	ECPolicy         string    `json:"ecPolicy"`
+	ECPolicyObj      *ECPolicy `json:"ecPolicyObj"`
+}
+
+// ModTime returns the modification time as a time.Time
+func (f *FileStatus) ModTime() time.Time {
+	return time.Unix(0, f.ModificationTime*int64(time.Millisecond))
+}
+
+// FileStatusResponse is returned by GETFILESTATUS
+type FileStatusResponse struct {
+	FileStatus FileStatus `json:"FileStatus"`
+}
+
+// FileStatuses is a list of FileStatus
+type FileStatuses struct {
+	FileStatus []FileStatus `json:"FileStatus"`
+}
+
+// ListStatusResponse is returned by LISTSTATUS
+type ListStatusResponse struct {
+	FileStatuses FileStatuses `json:"FileStatuses"`
+}
+
+// ListStatusBatchResponse is returned by LISTSTATUS_BATCH
+type ListStatusBatchResponse struct {
+	DirectoryListing struct {
+		PartialListing struct {
+			FileStatuses FileStatuses `json:"FileStatuses"`
+		} `json:"partialListing"`
+		RemainingEntries int64 `json:"remainingEntries"`
+	} `json:"DirectoryListing"`
+}
+
+// BooleanResponse is returned by operations which succeed or fail
+type BooleanResponse struct {
+	Boolean bool `json:"boolean"`
+}
+
+// LongResponse is returned by RENEWDELEGATIONTOKEN
+type LongResponse struct {
+	Long int64 `json:"long"`
+}
+
+// Token is a delegation token
+type Token struct {
+	URLString string `json:"urlString"`
+}
+
+// TokenResponse is returned by GETDELEGATIONTOKEN
+type TokenResponse struct {
+	Token Token `json:"Token"`
+}
+
+// ContentSummary is the usage of a directory tree
+type ContentSummary struct {
+	DirectoryCount int64 `json:"directoryCount"`
+	FileCount      int64 `json:"fileCount"`
+	Length         int64 `json:"length"`
+	Quota          int64 `json:"quota"`
+	SpaceConsumed  int64 `json:"spaceConsumed"`
+	SpaceQuota     int64 `json:"spaceQuota"`
+}
+
+// ContentSummaryResponse is returned by GETCONTENTSUMMARY
+type ContentSummaryResponse struct {
+	ContentSummary ContentSummary `json:"ContentSummary"`
+}
+
+// LocationResponse is returned by CREATE and OPEN with noredirect=true
+type LocationResponse struct {
+	Location string `json:"Location"`
+}
@@ -0,0 +1,206 @@
+package webhdfs
+
+// This does the authentication - Kerberos SPNEGO, delegation tokens
+// or the user.name parameter for clusters without security.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/webhdfs/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/atexit"
+)
+
+const (
+	minRenewInterval = time.Minute // shortest time to wait between renewing tokens
+)
+
+// newKerberosClient makes a Kerberos client from the keytab if set
+// or the credential cache if not
+func newKerberosClient(opt *Options) (*krb.Client, error) {
+	cfgPath := os.Getenv("KRB5_CONFIG")
+	if cfgPath == "" {
+		cfgPath = "/etc/krb5.conf"
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Kerberos config %q", cfgPath)
+	}
+
+	if opt.KerberosKeytab != "" {
+		at := strings.LastIndex(opt.KerberosPrincipal, "@")
+		if at <= 0 {
+			return nil, errors.New("kerberos_principal must be set as user@REALM to use a keytab")
+		}
+		kt, err := keytab.Load(opt.KerberosKeytab)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load keytab %q", opt.KerberosKeytab)
+		}
+		cl := krb.NewWithKeytab(opt.KerberosPrincipal[:at], opt.KerberosPrincipal[at+1:], kt, cfg, krb.DisablePAFXFAST(true))
+		err = cl.Login()
+		if err != nil {
+			return nil, errors.Wrap(err, "Kerberos login failed")
+		}
+		return cl, nil
+	}
+
+	ccachePath := strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+	if ccachePath == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		ccachePath = "/tmp/krb5cc_" + u.Uid
+	}
+	ccache, err := credentials.LoadCCache(ccachePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Kerberos credential cache %q - run kinit or set kerberos_keytab", ccachePath)
+	}
+	cl, err := krb.NewFromCCache(ccache, cfg, krb.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make Kerberos client from credential cache")
+	}
+	return cl, nil
+}
+
+// setupAuth configures how requests are authenticated
+func (f *Fs) setupAuth(ctx context.Context) error {
+	if f.opt.Kerberos {
+		cl, err := newKerberosClient(&f.opt)
+		if err != nil {
+			return err
+		}
+		// Only sign requests to the namenode or gateway -
+		// datanodes get a delegation token in the redirect
+		host := f.endpoint.Host
+		spn := f.opt.ServicePrincipalName
+		f.srv.SetSigner(func(req *http.Request) error {
+			if req.URL.Host != host || req.URL.Query().Get("delegation") != "" {
+				return nil
+			}
+			return spnego.SetSPNEGOHeader(cl, req, spn)
+		})
+		if f.opt.User != "" {
+			f.doAs = f.opt.User
+		}
+	} else if f.opt.User != "" {
+		f.userName = f.opt.User
+	} else {
+		u, err := user.Current()
+		if err != nil {
+			return errors.Wrap(err, "failed to read user name - set user")
+		}
+		f.userName = u.Username
+	}
+
+	if f.opt.DelegationToken != "" {
+		f.token = f.opt.DelegationToken
+	} else if f.opt.FetchDelegationToken {
+		return f.fetchToken(ctx)
+	}
+	return nil
+}
+
+// authParams adds the authentication parameters to params, using the
+// delegation token if set and useToken is true
+func (f *Fs) authParams(params url.Values, useToken bool) {
+	if useToken && f.token != "" {
+		params.Set("delegation", f.token)
+		return
+	}
+	if f.userName != "" {
+		params.Set("user.name", f.userName)
+	}
+	if f.doAs != "" {
+		params.Set("doas", f.doAs)
+	}
+}
+
+// tokenCall makes a delegation token request which must be
+// authenticated without the token
+func (f *Fs) tokenCall(ctx context.Context, method, op string, params url.Values, result interface{}) error {
+	return f.doCall(ctx, method, "/", op, params, result, false)
+}
+
+// fetchToken gets a delegation token, renews it in the background
+// and cancels it when rclone exits
+func (f *Fs) fetchToken(ctx context.Context) error {
+	var result api.TokenResponse
+	err := f.tokenCall(ctx, "GET", "GETDELEGATIONTOKEN", nil, &result)
+	if err != nil {
+		return errors.Wrap(err, "failed to get delegation token")
+	}
+	if result.Token.URLString == "" {
+		return errors.New("no delegation token returned - is security enabled on the cluster?")
+	}
+	token := result.Token.URLString
+	f.token = token
+	fs.Debugf(f, "Got delegation token")
+
+	stop := make(chan struct{})
+	var once sync.Once
+	atexit.Register(func() {
+		once.Do(func() {
+			close(stop)
+			// use a fresh context as ctx may be cancelled by now
+			err := f.tokenCall(context.Background(), "PUT", "CANCELDELEGATIONTOKEN", url.Values{"token": {token}}, nil)
+			if err != nil {
+				fs.Errorf(f, "Failed to cancel delegation token: %v", err)
+			} else {
+				fs.Debugf(f, "Cancelled delegation token")
+			}
+		})
+	})
+	go f.renewToken(token, stop)
+	return nil
+}
+
+// renewToken renews the token until stop is closed, waiting half the
+// remaining lifetime of the token each time
+func (f *Fs) renewToken(token string, stop <-chan struct{}) {
+	ctx := context.Background()
+	for {
+		wait := minRenewInterval
+		var result api.LongResponse
+		err := f.tokenCall(ctx, "PUT", "RENEWDELEGATIONTOKEN", url.Values{"token": {token}}, &result)
+		if err != nil {
+			fs.Errorf(f, "Failed to renew delegation token: %v", err)
+		} else {
+			expires := time.Unix(0, result.Long*int64(time.Millisecond))
+			fs.Debugf(f, "Renewed delegation token - expires %v", expires)
+			if half := time.Until(expires) / 2; half > wait {
+				wait = half
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// authString returns a description of the authentication in use
+func (f *Fs) authString() string {
+	switch {
+	case f.token != "":
+		return "delegation token"
+	case f.opt.Kerberos:
+		return "Kerberos"
+	}
+	return fmt.Sprintf("user %q", f.userName)
+}
@@ -0,0 +1,953 @@
+// Package webhdfs provides an interface to Hadoop HDFS using the
+// WebHDFS REST API as served by namenodes and HttpFS gateways.
+//
+// Reads and writes are redirected by the namenode to a datanode which
+// the data is streamed to or from.
+package webhdfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/webhdfs/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	minSleep          = 10 * time.Millisecond
+	apiPath           = "/webhdfs/v1"
+	bytesPerChecksum  = 512 // block sizes must be a multiple of this
+	octetStreamHeader = "application/octet-stream"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "webhdfs",
+		Description: "Hadoop HDFS using WebHDFS or HttpFS",
+		NewFs:       NewFs,
+		Options: append([]fs.Option{{
+			Name: "url",
+			Help: `URL of the namenode or HttpFS gateway
+
+Use an https:// URL for a namenode or gateway with HTTPS enabled.`,
+			Required: true,
+			Examples: []fs.OptionExample{{
+				Value: "http://namenode:9870",
+				Help:  "Namenode using HTTP (port 50070 before Hadoop 3)",
+			}, {
+				Value: "https://namenode:9871",
+				Help:  "Namenode using HTTPS (port 50470 before Hadoop 3)",
+			}, {
+				Value: "http://httpfs:14000",
+				Help:  "HttpFS gateway",
+			}},
+		}}, SharedOptions...),
+	})
+}
+
+// SharedOptions are shared between webhdfs and backends which use it
+var SharedOptions = []fs.Option{{
+	Name: "user",
+	Help: `User name
+
+Without Kerberos this is the user to access the files as, which
+defaults to the current user. With Kerberos it is the user to proxy as
+using doas, which needs the Kerberos user to be allowed to
+impersonate it, and is normally left blank.`,
+}, {
+	Name: "kerberos",
+	Help: `Use Kerberos SPNEGO authentication
+
+This uses the credential cache made by kinit unless a keytab is set.`,
+	Default: false,
+}, {
+	Name: "service_principal_name",
+	Help: `Kerberos service principal name of the namenode or gateway
+
+Leave blank to use HTTP/host where host is from the url, which is the
+normal setting.`,
+	Advanced: true,
+}, {
+	Name: "kerberos_principal",
+	Help: `Kerberos principal to log in as with kerberos_keytab
+
+For example user@EXAMPLE.COM.`,
+	Advanced: true,
+}, {
+	Name: "kerberos_keytab",
+	Help: `Path of a keytab to log in to Kerberos with
+
+If blank the credential cache from KRB5CCNAME or /tmp/krb5cc_UID is
+used instead.`,
+	Advanced: true,
+}, {
+	Name: "delegation_token",
+	Help: `Delegation token to authenticate with
+
+This is the URL safe string form of a token, for example from the
+GETDELEGATIONTOKEN operation. It is used instead of Kerberos or the
+user name.`,
+	Advanced: true,
+}, {
+	Name: "fetch_delegation_token",
+	Help: `Get a delegation token after authenticating and use that
+
+The token is renewed while rclone runs and cancelled when it exits.
+With Kerberos this saves a Kerberos authentication for every request.`,
+	Default:  false,
+	Advanced: true,
+}, {
+	Name: "block_size",
+	Help: `Block size to create files with
+
+Leave as 0 to use the default of the cluster. This must be a multiple
+of 512 bytes.
+
+In erasure coded directories it is the size of each block in a block
+group and is rounded up to a multiple of the cell size of the policy.`,
+	Default:  fs.SizeSuffix(0),
+	Advanced: true,
+}, {
+	Name: "replication",
+	Help: `Replication factor to create files with
+
+Leave as 0 to use the default of the cluster. This is ignored in
+erasure coded directories.`,
+	Default:  0,
+	Advanced: true,
+}, {
+	Name:     config.ConfigEncoding,
+	Help:     config.ConfigEncodingHelp,
+	Advanced: true,
+	Default: (encoder.Display |
+		encoder.EncodeInvalidUtf8 |
+		encoder.EncodeColon),
+}}
+
+// Options defines the configuration for this backend
+type Options struct {
+	URL                  string               `config:"url"`
+	User                 string               `config:"user"`
+	Kerberos             bool                 `config:"kerberos"`
+	ServicePrincipalName string               `config:"service_principal_name"`
+	KerberosPrincipal    string               `config:"kerberos_principal"`
+	KerberosKeytab       string               `config:"kerberos_keytab"`
+	DelegationToken      string               `config:"delegation_token"`
+	FetchDelegationToken bool                 `config:"fetch_delegation_token"`
+	BlockSize            fs.SizeSuffix        `config:"block_size"`
+	Replication          int                  `config:"replication"`
+	Enc                  encoder.MultiEncoder `config:"encoding"`
+}
+
+// Fs represents a path in HDFS
+type Fs struct {
+	name     string       // name of this remote
+	root     string       // the path we are working on
+	opt      Options      // parsed options
+	features *fs.Features // optional features
+	srv      *rest.Client // the connection to the server
+	pacer    *fs.Pacer    // pacer for API calls
+	endpoint *url.URL     // URL of the API
+	userName string       // user.name to send if set
+	doAs     string       // user to proxy as if set
+	token    string       // delegation token to use if set
+
+	ecMu    sync.Mutex
+	ecCache map[string]*api.ECPolicy // effective EC policy by directory
+}
+
+// Object describes a file
+type Object struct {
+	fs      *Fs       // what this object is part of
+	remote  string    // The remote path
+	size    int64     // size of the object
+	modTime time.Time // modification time of the object
+	id      int64     // inode number of the file
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("HDFS at %s path %q", f.opt.URL, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+func shouldRetry(resp *http.Response, err error) (bool, error) {
+	if isException(err, "RetriableException") {
+		return true, err
+	}
+	if e, ok := errors.Cause(err).(*api.RemoteException); ok && e.StatusCode < 500 {
+		// The namenode returns 500 for some exceptions which
+		// won't succeed if retried
+		return false, err
+	}
+	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	body, err := rest.ReadBody(resp)
+	if err != nil {
+		body = nil
+	}
+	e := &api.RemoteException{StatusCode: resp.StatusCode}
+	if body == nil || json.Unmarshal(body, e) != nil || e.RemoteException.Exception == "" {
+		e.RemoteException.Exception = resp.Status
+		e.RemoteException.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// isException returns true if err is one of the Java exceptions
+func isException(err error, exceptions ...string) bool {
+	if e, ok := errors.Cause(err).(*api.RemoteException); ok {
+		for _, exception := range exceptions {
+			if e.Exception() == exception {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNotFound returns true if err is because the path doesn't exist
+func isNotFound(err error) bool {
+	return isException(err, "FileNotFoundException")
+}
+
+// NewFs constructs an Fs from the path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	return NewFsWithOptions(ctx, name, root, opt)
+}
+
+// NewFsWithOptions constructs an Fs from the path and parsed options
+func NewFsWithOptions(ctx context.Context, name, root string, opt *Options) (fs.Fs, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("url must start with http:// or https:// not %q", opt.URL)
+	}
+	if !strings.HasSuffix(u.Path, apiPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + apiPath
+	}
+	if opt.BlockSize < 0 || opt.BlockSize%bytesPerChecksum != 0 {
+		return nil, errors.Errorf("block_size must be a multiple of %d bytes not %v", bytesPerChecksum, opt.BlockSize)
+	}
+	if opt.Replication < 0 {
+		return nil, errors.Errorf("replication can't be negative")
+	}
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:     name,
+		root:     root,
+		opt:      *opt,
+		srv:      rest.NewClient(fshttp.NewClient(ctx)).SetRoot(u.String()),
+		pacer:    fs.NewPacer(ctx, pacer.NewS3(pacer.MinSleep(minSleep))),
+		endpoint: u,
+		ecCache:  map[string]*api.ECPolicy{},
+	}
+	f.srv.SetErrorHandler(errorHandler)
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	err = f.setupAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fs.Debugf(f, "Using %s", f.authString())
+
+	// Check to see if the root is a file
+	if root != "" {
+		info, err := f.stat(ctx, f.absPath(""))
+		if err == nil && info.Type != api.TypeDirectory {
+			newRoot := path.Dir(root)
+			if newRoot == "." {
+				newRoot = ""
+			}
+			f.root = newRoot
+			return f, fs.ErrorIsFile
+		} else if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// absPath returns the HDFS path of remote
+func (f *Fs) absPath(remote string) string {
+	return "/" + f.opt.Enc.FromStandardPath(path.Join(f.root, remote))
+}
+
+// doCall runs op on the HDFS path p decoding the result into result
+// if set. The delegation token is used if useToken is set.
+func (f *Fs) doCall(ctx context.Context, method, p, op string, params url.Values, result interface{}, useToken bool) error {
+	opts := rest.Opts{
+		Method:     method,
+		Path:       rest.URLPathEscape(p),
+		Parameters: f.params(op, params, useToken),
+		NoResponse: result == nil,
+	}
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.CallJSON(ctx, &opts, nil, result)
+		return shouldRetry(resp, err)
+	})
+}
+
+// call runs op on the HDFS path p decoding the result into result if
+// set
+func (f *Fs) call(ctx context.Context, method, p, op string, params url.Values, result interface{}) error {
+	return f.doCall(ctx, method, p, op, params, result, true)
+}
+
+// params returns a copy of params with the op and authentication set
+func (f *Fs) params(op string, params url.Values, useToken bool) url.Values {
+	out := url.Values{}
+	for k, v := range params {
+		out[k] = v
+	}
+	out.Set("op", op)
+	f.authParams(out, useToken)
+	return out
+}
+
+// redirect runs op on the HDFS path p returning the location the
+// namenode redirects to, or the response if it wasn't redirected
+//
+// The namenode redirects reads and writes to a datanode. An HttpFS
+// gateway serves reads itself but redirects writes to itself.
+func (f *Fs) redirect(ctx context.Context, method, p, op string, params url.Values) (location string, resp *http.Response, err error) {
+	opts := rest.Opts{
+		Method:       method,
+		Path:         rest.URLPathEscape(p),
+		Parameters:   f.params(op, params, true),
+		NoRedirect:   true,
+		IgnoreStatus: true,
+	}
+	resp, err = f.srv.Call(ctx, &opts)
+	if err != nil {
+		return "", resp, err
+	}
+	switch resp.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusFound, http.StatusSeeOther:
+		location = resp.Header.Get("Location")
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if location == "" {
+			return "", nil, errors.New("redirect without a location")
+		}
+		return location, nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", resp, errorHandler(resp)
+	}
+	return "", resp, nil
+}
+
+// stat returns the status of the HDFS path p
+func (f *Fs) stat(ctx context.Context, p string) (*api.FileStatus, error) {
+	var result api.FileStatusResponse
+	err := f.call(ctx, "GET", p, "GETFILESTATUS", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.FileStatus, nil
+}
+
+// newObjectWithInfo makes an Object from the status of a file
+func (f *Fs) newObjectWithInfo(remote string, info *api.FileStatus) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		size:    info.Length,
+		modTime: info.ModTime(),
+		id:      info.FileID,
+	}
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	var result api.ListStatusResponse
+	err = f.call(ctx, "GET", f.absPath(dir), "LISTSTATUS", nil, &result)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fs.ErrorDirNotFound
+		}
+		return nil, errors.Wrap(err, "list failed")
+	}
+	for i := range result.FileStatuses.FileStatus {
+		info := &result.FileStatuses.FileStatus[i]
+		if info.PathSuffix == "" {
+			// listing a file returns just the file
+			return nil, fs.ErrorDirNotFound
+		}
+		remote := path.Join(dir, f.opt.Enc.ToStandardName(info.PathSuffix))
+		switch info.Type {
+		case api.TypeDirectory:
+			d := fs.NewDir(remote, info.ModTime()).SetID(strconv.FormatInt(info.FileID, 10))
+			entries = append(entries, d)
+		case api.TypeFile:
+			entries = append(entries, f.newObjectWithInfo(remote, info))
+		default:
+			fs.Debugf(f, "Ignoring %q of type %q", remote, info.Type)
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	info, err := f.stat(ctx, f.absPath(remote))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return nil, err
+	}
+	if info.Type != api.TypeFile {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return f.newObjectWithInfo(remote, info), nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// mkdir makes the directory at the HDFS path p and any parents
+func (f *Fs) mkdir(ctx context.Context, p string) error {
+	var result api.BooleanResponse
+	err := f.call(ctx, "PUT", p, "MKDIRS", nil, &result)
+	if err != nil {
+		return err
+	}
+	if !result.Boolean {
+		return errors.Errorf("failed to make directory %q", p)
+	}
+	return nil
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	err := f.mkdir(ctx, f.absPath(dir))
+	if err != nil {
+		return errors.Wrap(err, "mkdir failed")
+	}
+	return nil
+}
+
+// remove deletes the HDFS path p returning false if it wasn't found
+func (f *Fs) remove(ctx context.Context, p string, recursive bool) (bool, error) {
+	var result api.BooleanResponse
+	err := f.call(ctx, "DELETE", p, "DELETE", url.Values{
+		"recursive": {strconv.FormatBool(recursive)},
+	}, &result)
+	return result.Boolean, err
+}
+
+// removeDir removes the directory dir, recursively if set
+func (f *Fs) removeDir(ctx context.Context, dir string, recursive bool) error {
+	p := f.absPath(dir)
+	info, err := f.stat(ctx, p)
+	if err != nil {
+		if isNotFound(err) {
+			return fs.ErrorDirNotFound
+		}
+		return err
+	}
+	if info.Type != api.TypeDirectory {
+		return fs.ErrorIsFile
+	}
+	if p == "/" {
+		return errors.New("can't remove the root directory")
+	}
+	found, err := f.remove(ctx, p, recursive)
+	if err != nil {
+		if isException(err, "PathIsNotEmptyDirectoryException") {
+			return fs.ErrorDirectoryNotEmpty
+		}
+		return err
+	}
+	if !found {
+		return fs.ErrorDirNotFound
+	}
+	return nil
+}
+
+// Rmdir deletes the directory if empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.removeDir(ctx, dir, false)
+}
+
+// Purge deletes all the files and directories including the old versions.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	return f.removeDir(ctx, dir, true)
+}
+
+// Precision of the remote
+func (f *Fs) Precision() time.Duration {
+	return time.Millisecond
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// sameCluster returns true if src uses the same namenode as f
+func (f *Fs) sameCluster(src *Fs) bool {
+	return src.endpoint.String() == f.endpoint.String() && src.userName == f.userName && src.doAs == f.doAs
+}
+
+// rename renames the HDFS path src to dst making the parent of dst
+func (f *Fs) rename(ctx context.Context, src, dst string) error {
+	err := f.mkdir(ctx, path.Dir(dst))
+	if err != nil {
+		return errors.Wrap(err, "failed to make parent directory")
+	}
+	var result api.BooleanResponse
+	err = f.call(ctx, "PUT", src, "RENAME", url.Values{
+		"destination": {dst},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if !result.Boolean {
+		return errors.Errorf("failed to rename %q to %q", src, dst)
+	}
+	return nil
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameCluster(srcObj.fs) {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	dstPath := f.absPath(remote)
+	// RENAME won't overwrite so remove any existing file first
+	_, err := f.remove(ctx, dstPath, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "move: failed to remove old file")
+	}
+	err = f.rename(ctx, srcObj.fs.absPath(srcObj.remote), dstPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "move failed")
+	}
+	return f.NewObject(ctx, remote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameCluster(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcPath := srcFs.absPath(srcRemote)
+	dstPath := f.absPath(dstRemote)
+	_, err := f.stat(ctx, dstPath)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if !isNotFound(err) {
+		return err
+	}
+	_, err = f.stat(ctx, srcPath)
+	if err != nil {
+		if isNotFound(err) {
+			return fs.ErrorDirNotFound
+		}
+		return err
+	}
+	err = f.rename(ctx, srcPath, dstPath)
+	if err != nil {
+		return errors.Wrap(err, "dirmove failed")
+	}
+	return nil
+}
+
+// ecPolicyRe matches the names of the built in EC policies, for
+// example RS-6-3-1024k
+var ecPolicyRe = regexp.MustCompile(`^([A-Za-z0-9]+(?:-LEGACY)?)-(\d+)-(\d+)-(\d+)k$`)
+
+// ecPolicyOf returns the erasure coding policy of info or nil if it
+// isn't erasure coded
+func ecPolicyOf(info *api.FileStatus) *api.ECPolicy {
+	if p := info.ECPolicyObj; p != nil {
+		if p.ReplicationPolicy || p.CellSize <= 0 {
+			return nil
+		}
+		return p
+	}
+	if !info.ECBit {
+		return nil
+	}
+	// Older namenodes only send the name of the policy
+	m := ecPolicyRe.FindStringSubmatch(info.ECPolicy)
+	if m == nil {
+		fs.Debugf(nil, "Couldn't parse EC policy %q", info.ECPolicy)
+		return nil
+	}
+	dataUnits, _ := strconv.Atoi(m[2])
+	parityUnits, _ := strconv.Atoi(m[3])
+	cellSize, _ := strconv.ParseInt(m[4], 10, 64)
+	return &api.ECPolicy{
+		Name:           info.ECPolicy,
+		CellSize:       cellSize * 1024,
+		NumDataUnits:   dataUnits,
+		NumParityUnits: parityUnits,
+		CodecName:      strings.ToLower(m[1]),
+	}
+}
+
+// ecPolicy returns the erasure coding policy files created in the
+// HDFS directory dir will get, or nil if they will be replicated
+//
+// This is the policy of the nearest existing directory as new
+// directories inherit it.
+func (f *Fs) ecPolicy(ctx context.Context, dir string) (*api.ECPolicy, error) {
+	f.ecMu.Lock()
+	policy, found := f.ecCache[dir]
+	f.ecMu.Unlock()
+	if found {
+		return policy, nil
+	}
+	dirs := []string{dir}
+	for p := dir; ; p = path.Dir(p) {
+		info, err := f.stat(ctx, p)
+		if err == nil {
+			policy = ecPolicyOf(info)
+			break
+		} else if !isNotFound(err) || p == "/" {
+			return nil, err
+		}
+		dirs = append(dirs, path.Dir(p))
+	}
+	f.ecMu.Lock()
+	for _, d := range dirs {
+		f.ecCache[d] = policy
+	}
+	f.ecMu.Unlock()
+	return policy, nil
+}
+
+// createParams returns the parameters to create a file in the HDFS
+// directory dir with
+//
+// The block size and replication are only sent if set. In an erasure
+// coded directory the replication is left out and the block size is
+// rounded up to a multiple of the cell size as HDFS requires.
+func (f *Fs) createParams(ctx context.Context, dir string) (url.Values, error) {
+	params := url.Values{"overwrite": {"true"}}
+	if f.opt.BlockSize == 0 && f.opt.Replication == 0 {
+		return params, nil
+	}
+	policy, err := f.ecPolicy(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read erasure coding policy")
+	}
+	blockSize := int64(f.opt.BlockSize)
+	if policy != nil {
+		if cell := policy.CellSize; blockSize%cell != 0 {
+			blockSize += cell - blockSize%cell
+			fs.Debugf(f, "Rounding block size up to %v for EC policy %s of %q", fs.SizeSuffix(blockSize), policy.Name, dir)
+		}
+	} else if f.opt.Replication > 0 {
+		params.Set("replication", strconv.Itoa(f.opt.Replication))
+	}
+	if blockSize > 0 {
+		params.Set("blocksize", strconv.FormatInt(blockSize, 10))
+	}
+	return params, nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the hash of an object returning a lowercase hex string
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// ID returns the inode number of the file
+func (o *Object) ID() string {
+	return strconv.FormatInt(o.id, 10)
+}
+
+// setTimes sets the modification time of the HDFS path p
+func (f *Fs) setTimes(ctx context.Context, p string, modTime time.Time) error {
+	return f.call(ctx, "PUT", p, "SETTIMES", url.Values{
+		"modificationtime": {strconv.FormatInt(modTime.UnixNano()/int64(time.Millisecond), 10)},
+		"accesstime":       {"-1"},
+	}, nil)
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	err := o.fs.setTimes(ctx, o.fs.absPath(o.remote), modTime)
+	if err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+	o.modTime = modTime.Truncate(time.Millisecond)
+	return nil
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if limit == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	params := url.Values{}
+	if offset > 0 {
+		params.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	if limit > 0 {
+		params.Set("length", strconv.FormatInt(limit, 10))
+	}
+	var resp *http.Response
+	err = o.fs.pacer.Call(func() (bool, error) {
+		var location string
+		location, resp, err = o.fs.redirect(ctx, "GET", o.fs.absPath(o.remote), "OPEN", params)
+		if err == nil && location != "" {
+			resp, err = o.fs.srv.Call(ctx, &rest.Opts{
+				Method:  "GET",
+				RootURL: location,
+			})
+		}
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return nil, errors.Wrap(err, "open failed")
+	}
+	return resp.Body, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	p := o.fs.absPath(o.remote)
+	params, err := o.fs.createParams(ctx, path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	// Ask the namenode where to write the data
+	var location string
+	err = o.fs.pacer.Call(func() (bool, error) {
+		var resp *http.Response
+		location, resp, err = o.fs.redirect(ctx, "PUT", p, "CREATE", params)
+		if err == nil && location == "" {
+			fs.CheckClose(resp.Body, &err)
+			err = errors.New("CREATE wasn't redirected")
+		}
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+
+	// Send the data - this can't be retried as in is consumed
+	size := src.Size()
+	opts := rest.Opts{
+		Method:      "PUT",
+		RootURL:     location,
+		Body:        in,
+		ContentType: octetStreamHeader,
+		NoResponse:  true,
+		Options:     options,
+	}
+	if size >= 0 {
+		opts.ContentLength = &size
+	}
+	err = o.fs.pacer.CallNoRetry(func() (bool, error) {
+		resp, err := o.fs.srv.Call(ctx, &opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		// don't leave a partial file
+		if _, removeErr := o.fs.remove(ctx, p, false); removeErr != nil {
+			fs.Debugf(o, "Failed to remove partial file: %v", removeErr)
+		}
+		return errors.Wrap(err, "failed to upload")
+	}
+
+	err = o.fs.setTimes(ctx, p, src.ModTime(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+	info, err := o.fs.stat(ctx, p)
+	if err != nil {
+		return errors.Wrap(err, "failed to read uploaded file")
+	}
+	o.size = info.Length
+	o.modTime = info.ModTime()
+	o.id = info.FileID
+	return nil
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	found, err := o.fs.remove(ctx, o.fs.absPath(o.remote), false)
+	if err != nil {
+		return errors.Wrap(err, "remove failed")
+	}
+	if !found {
+		return fs.ErrorObjectNotFound
+	}
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.Purger      = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.DirMover    = (*Fs)(nil)
+	_ fs.Object      = (*Object)(nil)
+	_ fs.IDer        = (*Object)(nil)
+)
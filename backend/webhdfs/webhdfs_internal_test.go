@@ -0,0 +1,557 @@
+package webhdfs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/webhdfs/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// node is a file or directory in the fake namespace
+type node struct {
+	dir         bool
+	data        []byte
+	modTime     int64 // milliseconds
+	id          int64
+	ec          *api.ECPolicy // EC policy if erasure coded
+	blockSize   string        // blocksize it was created with
+	replication string        // replication it was created with
+}
+
+// fakeServer is a fake namenode and datanode serving the parts of
+// WebHDFS the backend uses
+type fakeServer struct {
+	t        *testing.T
+	nameNode *httptest.Server
+	dataNode *httptest.Server
+	user     string // user.name to require
+
+	mu        sync.Mutex
+	nodes     map[string]*node // by absolute path
+	nextID    int64
+	legacyEC  bool            // only send the name of EC policies
+	tokens    map[string]bool // valid delegation tokens
+	renewed   int             // number of renewals
+	cancelled []string        // cancelled tokens
+	failOpens int             // number of OPENs to fail with RetriableException
+}
+
+// newFakeServer starts a fake cluster
+func newFakeServer(t *testing.T) *fakeServer {
+	srv := &fakeServer{
+		t:      t,
+		user:   "hdfs",
+		nodes:  map[string]*node{"/": {dir: true, id: 16385}},
+		nextID: 16386,
+		tokens: map[string]bool{},
+	}
+	srv.nameNode = httptest.NewServer(http.HandlerFunc(srv.handleNameNode))
+	srv.dataNode = httptest.NewServer(http.HandlerFunc(srv.handleDataNode))
+	return srv
+}
+
+// close shuts the servers down
+func (srv *fakeServer) close() {
+	srv.nameNode.Close()
+	srv.dataNode.Close()
+}
+
+// config returns the config for a remote using the server
+func (srv *fakeServer) config() configmap.Simple {
+	return configmap.Simple{
+		"url":                    srv.nameNode.URL,
+		"user":                   srv.user,
+		"kerberos":               "false",
+		"service_principal_name": "",
+		"kerberos_principal":     "",
+		"kerberos_keytab":        "",
+		"delegation_token":       "",
+		"fetch_delegation_token": "false",
+		"block_size":             "0",
+		"replication":            "0",
+	}
+}
+
+// newFs makes an Fs for root on the server
+func (srv *fakeServer) newFs(root string) (*Fs, error) {
+	return srv.newFsWithConfig(root, srv.config())
+}
+
+// newFsWithConfig makes an Fs for root with config m
+func (srv *fakeServer) newFsWithConfig(root string, m configmap.Simple) (*Fs, error) {
+	f, err := NewFs(context.Background(), "TestWebHDFS", root, m)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// remoteException writes a RemoteException error response
+func remoteException(w http.ResponseWriter, status int, exception, message string) {
+	var e api.RemoteException
+	e.RemoteException.Exception = exception
+	e.RemoteException.JavaClassName = "org.apache.hadoop.fs." + exception
+	e.RemoteException.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&e)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// status returns the FileStatus of n
+func (srv *fakeServer) status(suffix string, n *node) api.FileStatus {
+	info := api.FileStatus{
+		FileID:           n.id,
+		Length:           int64(len(n.data)),
+		ModificationTime: n.modTime,
+		PathSuffix:       suffix,
+		Type:             api.TypeFile,
+	}
+	if n.dir {
+		info.Type = api.TypeDirectory
+	}
+	if n.ec != nil {
+		info.ECBit = true
+		info.ECPolicy = n.ec.Name
+		if !srv.legacyEC {
+			info.ECPolicyObj = n.ec
+		}
+	}
+	return info
+}
+
+// children returns the sorted names of the children of p
+func (srv *fakeServer) children(p string) (names []string) {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for k := range srv.nodes {
+		if k != "/" && strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			names = append(names, k[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mkdirs makes p and its parents returning false if a file is in the way
+func (srv *fakeServer) mkdirs(p string) bool {
+	if n, ok := srv.nodes[p]; ok {
+		return n.dir
+	}
+	if !srv.mkdirs(path.Dir(p)) {
+		return false
+	}
+	srv.nodes[p] = &node{
+		dir:     true,
+		id:      srv.nextID,
+		modTime: time.Now().UnixNano() / 1e6,
+		ec:      srv.nodes[path.Dir(p)].ec,
+	}
+	srv.nextID++
+	return true
+}
+
+// handleNameNode serves the namenode API
+func (srv *fakeServer) handleNameNode(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !strings.HasPrefix(r.URL.Path, apiPath+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	p := path.Clean(strings.TrimPrefix(r.URL.Path, apiPath))
+	q := r.URL.Query()
+	op := q.Get("op")
+	if token := q.Get("delegation"); token != "" {
+		if !srv.tokens[token] {
+			remoteException(w, 403, "InvalidToken", "token is cancelled or unknown")
+			return
+		}
+	} else if q.Get("user.name") != srv.user {
+		remoteException(w, 401, "AuthenticationException", "Anonymous requests are disallowed")
+		return
+	}
+	n := srv.nodes[p]
+	switch op {
+	case "GETFILESTATUS":
+		if n == nil {
+			remoteException(w, 404, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		writeJSON(w, api.FileStatusResponse{FileStatus: srv.status("", n)})
+	case "LISTSTATUS":
+		if n == nil {
+			remoteException(w, 404, "FileNotFoundException", "File "+p+" does not exist.")
+			return
+		}
+		var result api.ListStatusResponse
+		result.FileStatuses.FileStatus = []api.FileStatus{}
+		if !n.dir {
+			result.FileStatuses.FileStatus = append(result.FileStatuses.FileStatus, srv.status("", n))
+		}
+		for _, name := range srv.children(p) {
+			result.FileStatuses.FileStatus = append(result.FileStatuses.FileStatus, srv.status(name, srv.nodes[path.Join(p, name)]))
+		}
+		writeJSON(w, result)
+	case "MKDIRS":
+		if !srv.mkdirs(p) {
+			remoteException(w, 403, "ParentNotDirectoryException", "Parent path is not a directory: "+p)
+			return
+		}
+		writeJSON(w, api.BooleanResponse{Boolean: true})
+	case "CREATE", "OPEN":
+		if op == "OPEN" {
+			if n == nil || n.dir {
+				remoteException(w, 404, "FileNotFoundException", "File does not exist: "+p)
+				return
+			}
+			if srv.failOpens > 0 {
+				srv.failOpens--
+				remoteException(w, 403, "RetriableException", "namenode is busy")
+				return
+			}
+		}
+		q.Del("user.name")
+		q.Del("delegation")
+		q.Set("path", p)
+		http.Redirect(w, r, srv.dataNode.URL+"/webhdfs/v1"+r.URL.EscapedPath()[len(apiPath):]+"?"+q.Encode(), http.StatusTemporaryRedirect)
+	case "DELETE":
+		if n == nil {
+			writeJSON(w, api.BooleanResponse{Boolean: false})
+			return
+		}
+		children := srv.children(p)
+		if n.dir && len(children) > 0 && q.Get("recursive") != "true" {
+			remoteException(w, 403, "PathIsNotEmptyDirectoryException", "`"+p+" is non empty': Directory is not empty")
+			return
+		}
+		for k := range srv.nodes {
+			if k == p || strings.HasPrefix(k, p+"/") {
+				delete(srv.nodes, k)
+			}
+		}
+		writeJSON(w, api.BooleanResponse{Boolean: true})
+	case "RENAME":
+		dst := q.Get("destination")
+		if n == nil || srv.nodes[dst] != nil || srv.nodes[path.Dir(dst)] == nil {
+			writeJSON(w, api.BooleanResponse{Boolean: false})
+			return
+		}
+		for k, v := range srv.nodes {
+			if k == p || strings.HasPrefix(k, p+"/") {
+				delete(srv.nodes, k)
+				srv.nodes[dst+k[len(p):]] = v
+			}
+		}
+		writeJSON(w, api.BooleanResponse{Boolean: true})
+	case "SETTIMES":
+		if n == nil {
+			remoteException(w, 404, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		assert.Equal(srv.t, "-1", q.Get("accesstime"))
+		modTime, err := strconv.ParseInt(q.Get("modificationtime"), 10, 64)
+		require.NoError(srv.t, err)
+		n.modTime = modTime
+	case "GETDELEGATIONTOKEN":
+		token := "token" + strconv.Itoa(len(srv.tokens))
+		srv.tokens[token] = true
+		writeJSON(w, api.TokenResponse{Token: api.Token{URLString: token}})
+	case "RENEWDELEGATIONTOKEN":
+		assert.Equal(srv.t, "", q.Get("delegation"))
+		srv.renewed++
+		writeJSON(w, api.LongResponse{Long: time.Now().Add(time.Hour).UnixNano() / 1e6})
+	case "CANCELDELEGATIONTOKEN":
+		assert.Equal(srv.t, "", q.Get("delegation"))
+		token := q.Get("token")
+		delete(srv.tokens, token)
+		srv.cancelled = append(srv.cancelled, token)
+	default:
+		remoteException(w, 400, "IllegalArgumentException", "Invalid value for webhdfs parameter \"op\": "+op)
+	}
+}
+
+// handleDataNode serves the redirected reads and writes
+func (srv *fakeServer) handleDataNode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	assert.Equal(srv.t, "", q.Get("user.name"))
+	p := q.Get("path")
+	switch q.Get("op") {
+	case "CREATE":
+		assert.Equal(srv.t, "PUT", r.Method)
+		assert.Equal(srv.t, "application/octet-stream", r.Header.Get("Content-Type"))
+		assert.Equal(srv.t, "true", q.Get("overwrite"))
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		if !srv.mkdirs(path.Dir(p)) {
+			remoteException(w, 403, "ParentNotDirectoryException", "Parent path is not a directory: "+p)
+			return
+		}
+		ec := srv.nodes[path.Dir(p)].ec
+		if ec != nil {
+			if q.Get("replication") != "" {
+				remoteException(w, 400, "IllegalArgumentException", "replication set for EC file")
+				return
+			}
+			if bs, _ := strconv.ParseInt(q.Get("blocksize"), 10, 64); bs%ec.CellSize != 0 {
+				remoteException(w, 400, "IllegalArgumentException", "blockSize must be a multiple of the cell size")
+				return
+			}
+		}
+		if n := srv.nodes[p]; n != nil && n.dir {
+			remoteException(w, 403, "FileAlreadyExistsException", p+" already exists as a directory")
+			return
+		}
+		srv.nodes[p] = &node{
+			data:        data,
+			id:          srv.nextID,
+			modTime:     time.Now().UnixNano() / 1e6,
+			ec:          ec,
+			blockSize:   q.Get("blocksize"),
+			replication: q.Get("replication"),
+		}
+		srv.nextID++
+		w.WriteHeader(http.StatusCreated)
+	case "OPEN":
+		srv.mu.Lock()
+		n := srv.nodes[p]
+		srv.mu.Unlock()
+		if n == nil {
+			remoteException(w, 404, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		data := n.data
+		if offset, err := strconv.Atoi(q.Get("offset")); err == nil {
+			data = data[offset:]
+		}
+		if length, err := strconv.Atoi(q.Get("length")); err == nil && length < len(data) {
+			data = data[:length]
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(data)
+	default:
+		http.Error(w, "bad op", http.StatusBadRequest)
+	}
+}
+
+// put uploads a file
+func put(t *testing.T, f fs.Fs, remote, contents string) fs.Object {
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(contents)), true, nil, nil)
+	o, err := f.Put(context.Background(), strings.NewReader(contents), src)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads a file
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) string {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+
+	// Wrong user
+	m := srv.config()
+	m["user"] = "nobody"
+	_, err := srv.newFsWithConfig("dir", m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "AuthenticationException")
+
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	put(t, f, "dir/file.txt", "hello")
+
+	// Root pointing at a file
+	g, err := srv.newFs("dir/file.txt")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "dir", g.Root())
+
+	_, err = f.List(ctx, "dir/file.txt")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+	_, err = f.NewObject(ctx, "dir")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	assert.Equal(t, fs.ErrorDirectoryNotEmpty, f.Rmdir(ctx, "dir"))
+	assert.Equal(t, fs.ErrorIsFile, f.Rmdir(ctx, "dir/file.txt"))
+	assert.Equal(t, fs.ErrorDirNotFound, f.Rmdir(ctx, "missing"))
+	err = f.Mkdir(ctx, "dir/file.txt/sub")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ParentNotDirectoryException")
+
+	// Retriable errors are retried
+	srv.failOpens = 2
+	o, err := f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", read(t, o))
+	assert.Equal(t, "ell", read(t, o, &fs.RangeOption{Start: 1, End: 3}))
+	assert.Equal(t, "llo", read(t, o, &fs.SeekOption{Offset: 2}))
+
+	// Errors which aren't retriable
+	e := &api.RemoteException{StatusCode: 500}
+	e.RemoteException.Exception = "AccessControlException"
+	retry, _ := shouldRetry(&http.Response{StatusCode: 500}, e)
+	assert.True(t, retry, "500 without an exception")
+	e.StatusCode = 403
+	retry, _ = shouldRetry(&http.Response{StatusCode: 403}, e)
+	assert.False(t, retry)
+	e.RemoteException.Exception = "RetriableException"
+	retry, _ = shouldRetry(&http.Response{StatusCode: 403}, e)
+	assert.True(t, retry)
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	m := srv.config()
+	m["block_size"] = "1M"
+	m["replication"] = "2"
+	f, err := srv.newFs("")
+	require.NoError(t, err)
+	require.NoError(t, f.Mkdir(ctx, "ec"))
+	require.NoError(t, f.Mkdir(ctx, "plain"))
+	srv.nodes["/ec"].ec = &api.ECPolicy{
+		Name:           "RS-3-2-768k",
+		CellSize:       768 * 1024,
+		NumDataUnits:   3,
+		NumParityUnits: 2,
+		CodecName:      "rs",
+	}
+
+	for _, legacy := range []bool{false, true} {
+		srv.legacyEC = legacy
+		f, err := srv.newFsWithConfig("", m)
+		require.NoError(t, err)
+
+		put(t, f, "plain/file.txt", "replicated")
+		n := srv.nodes["/plain/file.txt"]
+		assert.Equal(t, "1048576", n.blockSize)
+		assert.Equal(t, "2", n.replication)
+
+		// Blocks of EC files are rounded up to the cell size
+		// and replication is left out
+		put(t, f, "ec/new/file.txt", "erasure coded")
+		n = srv.nodes["/ec/new/file.txt"]
+		assert.Equal(t, "1572864", n.blockSize)
+		assert.Equal(t, "", n.replication)
+		require.NotNil(t, n.ec)
+
+		o, err := f.NewObject(ctx, "ec/new/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "erasure coded", read(t, o))
+	}
+
+	_, err = srv.newFsWithConfig("", configmap.Simple{"url": srv.nameNode.URL, "block_size": "1000b"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "multiple of 512")
+}
+
+func TestECPolicyOf(t *testing.T) {
+	assert.Nil(t, ecPolicyOf(&api.FileStatus{}))
+	assert.Nil(t, ecPolicyOf(&api.FileStatus{ECPolicyObj: &api.ECPolicy{Name: "REPLICATION", ReplicationPolicy: true}}))
+	assert.Nil(t, ecPolicyOf(&api.FileStatus{ECBit: true, ECPolicy: "potato"}))
+	assert.Equal(t, &api.ECPolicy{
+		Name:           "RS-LEGACY-6-3-1024k",
+		CellSize:       1024 * 1024,
+		NumDataUnits:   6,
+		NumParityUnits: 3,
+		CodecName:      "rs-legacy",
+	}, ecPolicyOf(&api.FileStatus{ECBit: true, ECPolicy: "RS-LEGACY-6-3-1024k"}))
+	assert.Equal(t, int64(64*1024), ecPolicyOf(&api.FileStatus{ECBit: true, ECPolicy: "XOR-2-1-64k"}).CellSize)
+}
+
+func TestDelegationToken(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer(t)
+	defer srv.close()
+	m := srv.config()
+	m["fetch_delegation_token"] = "true"
+	f, err := srv.newFsWithConfig("", m)
+	require.NoError(t, err)
+	assert.Equal(t, "token0", f.token)
+	assert.Equal(t, "delegation token", f.authString())
+
+	// The token is renewed straight away using the user
+	for i := 0; i < 100; i++ {
+		srv.mu.Lock()
+		renewed := srv.renewed
+		srv.mu.Unlock()
+		if renewed > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.mu.Lock()
+	assert.Equal(t, 1, srv.renewed)
+
+	// Requests use the token not the user
+	srv.user = "someone else"
+	srv.mu.Unlock()
+	put(t, f, "file.txt", "hello")
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", read(t, o))
+	srv.mu.Lock()
+	srv.user = "hdfs"
+	srv.mu.Unlock()
+
+	// A configured token is used without fetching
+	m = srv.config()
+	m["delegation_token"] = "token0"
+	g, err := srv.newFsWithConfig("", m)
+	require.NoError(t, err)
+	_, err = g.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(srv.tokens))
+
+	require.NoError(t, f.tokenCall(ctx, "PUT", "CANCELDELEGATIONTOKEN", url.Values{"token": {"token0"}}, nil))
+	assert.Equal(t, []string{"token0"}, srv.cancelled)
+	_, err = g.List(ctx, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "InvalidToken")
+}
+
+func TestIntegrationFake(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.close()
+	for key, value := range srv.config() {
+		require.NoError(t, os.Setenv(fs.ConfigToEnv("TestWebHDFSFake", key), value))
+	}
+	require.NoError(t, os.Setenv(fs.ConfigToEnv("TestWebHDFSFake", "type"), "webhdfs"))
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestWebHDFSFake:",
+		NilObject:  (*Object)(nil),
+	})
+}
@@ -32,33 +32,47 @@ docs = [
     "b2.md",
     "box.md",
     "cache.md",
+    "cas.md",
     "chunker.md",
     "sharefile.md",
     "crypt.md",
     "compress.md",
+    "dedup.md",
+    "erasure.md",
     "dropbox.md",
     "filefabric.md",
     "ftp.md",
+    "git.md",
     "googlecloudstorage.md",
     "drive.md",
     "googlephotos.md",
+    "webhdfs.md",
     "http.md",
     "hubic.md",
+    "ipfs.md",
     "jottacloud.md",
     "koofr.md",
+    "kubernetes.md",
+    "ltfs.md",
     "mailru.md",
     "mega.md",
     "memory.md",
     "azureblob.md",
     "onedrive.md",
+    "sharepoint.md",
+    "nfs.md",
     "opendrive.md",
     "qingstor.md",
     "swift.md",
+    "ozone.md",
     "pcloud.md",
     "premiumizeme.md",
+    "protondrive.md",
     "putio.md",
+    "rsync.md",
     "seafile.md",
     "sftp.md",
+    "smb.md",
     "sugarsync.md",
     "tardigrade.md",
     "union.md",
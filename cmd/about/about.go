@@ -4,18 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/list"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/spf13/cobra"
 )
 
 var (
-	jsonOutput bool
-	fullOutput bool
+	jsonOutput   bool
+	fullOutput   bool
+	latencyCalls int
 )
 
 func init() {
@@ -23,6 +30,7 @@ func init() {
 	cmdFlags := commandDefinition.Flags()
 	flags.BoolVarP(cmdFlags, &jsonOutput, "json", "", false, "Format output as JSON")
 	flags.BoolVarP(cmdFlags, &fullOutput, "full", "", false, "Full numbers instead of SI units")
+	flags.IntVarP(cmdFlags, &latencyCalls, "latency", "", 0, "Time this many list and download calls and show their latency")
 }
 
 // printValue formats uv to be output
@@ -40,6 +48,44 @@ func printValue(what string, uv *int64) {
 	fmt.Printf("%-9s%v\n", what, val)
 }
 
+// measureLatency times n listings of the root of f and n downloads of
+// the first byte of the smallest file in it, returning the percentiles
+// of their durations as for the stats.
+func measureLatency(ctx context.Context, f fs.Fs, n int) (out rc.Params, text string, err error) {
+	var smallest fs.Object
+	for i := 0; i < n; i++ {
+		entries, err := list.DirSorted(ctx, f, true, "")
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to list")
+		}
+		if i == 0 {
+			entries.ForObject(func(o fs.Object) {
+				if o.Size() > 0 && (smallest == nil || o.Size() < smallest.Size()) {
+					smallest = o
+				}
+			})
+		}
+	}
+	if smallest != nil {
+		for i := 0; i < n; i++ {
+			in, err := operations.NewReOpen(ctx, smallest, 1, &fs.RangeOption{Start: 0, End: 0})
+			if err != nil {
+				return nil, "", errors.Wrap(err, "failed to download")
+			}
+			_, err = io.Copy(ioutil.Discard, in)
+			closeErr := in.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, "", errors.Wrap(err, "failed to download")
+			}
+		}
+	}
+	out, text = accounting.Stats(ctx).CallDurations(f)
+	return out, text, nil
+}
+
 var commandDefinition = &cobra.Command{
 	Use:   "about remote:",
 	Short: `Get quota information from the remote.`,
@@ -85,6 +131,23 @@ A ` + "`--json`" + `flag generates conveniently computer readable output, e.g.
         "free": 1411001220
     }
 
+Applying a ` + "`--latency N`" + ` flag times N listings of the root of the
+remote and N downloads of the first byte of the smallest file in it,
+and shows the median, 95th and 99th percentile of their durations
+as in the stats, e.g.
+
+    Latency: list 120ms/450ms/1.2s, download 95ms/210ms/300ms (p50/p95/p99)
+
+With ` + "`--json`" + ` these are shown in seconds as "latency", e.g.
+
+    "latency": {
+        "list": {"count": 10, "p50": 0.12, "p95": 0.45, "p99": 1.2},
+        "download": {"count": 10, "p50": 0.095, "p95": 0.21, "p99": 0.3}
+    }
+
+This helps tell whether a remote is slow to respond or the network is
+slow to transfer the data.
+
 Not all backends support the ` + "`rclone about`" + ` command.
 
 See [List of backends that do not support about](https://rclone.org/overview/#optional-features)
@@ -104,10 +167,23 @@ See [List of backends that do not support about](https://rclone.org/overview/#op
 			if u == nil {
 				return errors.New("nil usage returned")
 			}
+			var (
+				latency     rc.Params
+				latencyText string
+			)
+			if latencyCalls > 0 {
+				latency, latencyText, err = measureLatency(context.Background(), f, latencyCalls)
+				if err != nil {
+					return errors.Wrap(err, "latency measurement failed")
+				}
+			}
 			if jsonOutput {
 				out := json.NewEncoder(os.Stdout)
 				out.SetIndent("", "\t")
-				return out.Encode(u)
+				return out.Encode(struct {
+					*fs.Usage
+					Latency rc.Params `json:"latency,omitempty"`
+				}{u, latency})
 			}
 			printValue("Total", u.Total)
 			printValue("Used", u.Used)
@@ -115,6 +191,9 @@ See [List of backends that do not support about](https://rclone.org/overview/#op
 			printValue("Trashed", u.Trashed)
 			printValue("Other", u.Other)
 			printValue("Objects", u.Objects)
+			if latencyText != "" {
+				fmt.Printf("%-9s%s (p50/p95/p99)\n", "Latency:", latencyText)
+			}
 			return nil
 		})
 	},
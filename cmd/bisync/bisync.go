@@ -0,0 +1,78 @@
+package bisync
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/sync"
+	"github.com/spf13/cobra"
+)
+
+var (
+	opt = sync.BisyncOpt{}
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	cmdFlags := commandDefinition.Flags()
+	flags.FVarP(cmdFlags, &opt.ConflictMode, "conflict-resolve", "", "How to resolve files changed in both paths rename|newer|larger|interactive")
+	flags.BoolVarP(cmdFlags, &opt.Force, "force", "", opt.Force, "Carry on even if one of the paths has become empty")
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "bisync path1 path2",
+	Short: `Make two paths the same by copying changes in either direction.`,
+	Long: `
+Bisync makes path1 and path2 the same by copying the files changed in
+either of them since the last run to the other one and deleting the
+files deleted from either of them since the last run from the other
+one.  This makes it suitable for keeping a laptop and a cloud copy in
+step when both are changed.
+
+To know what has changed bisync keeps a snapshot of both paths as they
+were when they last agreed in the ` + "`bisync`" + ` directory of the cache
+directory (see ` + "`--cache-dir`" + `).  The first run has no snapshot so
+it copies the files which are only in one of the paths to the other
+one, keeps the newer of the files which are in both paths but differ
+(the one in path1 if their modification times are the same) and
+deletes nothing.
+
+The snapshot is made from the listings of the paths taken before the
+files are synced, updated with the changes bisync made, so a file
+changed while bisync is running is synced on the next run.
+
+A file which has changed in both paths since the last run is a
+conflict and is resolved with ` + "`--conflict-resolve`" + `
+
+  * ` + "`rename`" + ` - (default) rename the file in path1 to ` + "`file.conflict1`" + ` and the file in path2 to ` + "`file.conflict2`" + ` and copy both of them to the other path so nothing is lost.
+  * ` + "`newer`" + ` - keep the file with the newer modification time.
+  * ` + "`larger`" + ` - keep the larger file.
+  * ` + "`interactive`" + ` - ask which file to keep.
+
+If the modification times or sizes are the same then ` + "`newer`" + ` and
+` + "`larger`" + ` rename the files.  A file which has changed in one path
+and been deleted in the other is copied back so changes are never lost.
+
+Files which fail to sync, or conflicts which are skipped interactively,
+are tried again on the next run.
+
+If one of the paths is empty but wasn't last time bisync stops rather
+than deleting everything in the other path, as this usually means
+that the path is wrong or a drive isn't mounted.  Use ` + "`--force`" + ` if
+the files really should be deleted.
+
+Filters apply to both paths.  Empty directories aren't synced.
+
+**Important**: Since this can cause data loss, test first with the
+` + "`--dry-run` or the `--interactive`/`-i`" + ` flag.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		f1 := cmd.NewFsDir(args[:1])
+		f2 := cmd.NewFsDir(args[1:])
+		cmd.Run(true, true, command, func() error {
+			return sync.Bisync(context.Background(), f1, f2, opt)
+		})
+	},
+}
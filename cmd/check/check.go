@@ -142,6 +142,11 @@ If you supply the --download flag, it will download the data from
 both remotes and check them against each other on the fly.  This can
 be useful for remotes that don't support hashes or if you really want
 to check all the data.
+
+If there is no hash in common and one of the remotes is a crypt
+remote, files in crypt format v3 are checked against the HMAC stored
+at the end of them, which only reads the start and end of them from
+the crypt remote.
 ` + FlagsHelp,
 	Run: func(command *cobra.Command, args []string) {
 		cmd.CheckArgs(2, 2, command, args)
@@ -24,6 +24,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/audit"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config/configflags"
 	"github.com/rclone/rclone/fs/config/flags"
@@ -32,8 +33,12 @@ import (
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fspath"
 	fslog "github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/metrics"
+	"github.com/rclone/rclone/fs/notify"
 	"github.com/rclone/rclone/fs/rc/rcflags"
 	"github.com/rclone/rclone/fs/rc/rcserver"
+	"github.com/rclone/rclone/fs/tracing"
+	"github.com/rclone/rclone/fs/watchdog"
 	"github.com/rclone/rclone/lib/atexit"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/rclone/rclone/lib/terminal"
@@ -69,8 +74,21 @@ const (
 	exitCodeFatalError
 	exitCodeTransferExceeded
 	exitCodeNoFilesTransferred
+	exitCodeAuthError
+	exitCodeQuotaError
+	exitCodeChecksumError
+	exitCodeRateLimitError
 )
 
+// exitCodeByErrorCode is the exit code for the error codes which
+// have their own
+var exitCodeByErrorCode = map[fserrors.Code]int{
+	fserrors.CodeAuth:      exitCodeAuthError,
+	fserrors.CodeQuota:     exitCodeQuotaError,
+	fserrors.CodeChecksum:  exitCodeChecksumError,
+	fserrors.CodeRateLimit: exitCodeRateLimitError,
+}
+
 // ShowVersion prints the version to stdout
 func ShowVersion() {
 	fmt.Printf("rclone %s\n", fs.Version)
@@ -235,12 +253,15 @@ func ShowStats() bool {
 // Run the function with stats and retries if required
 func Run(Retry bool, showStats bool, cmd *cobra.Command, f func() error) {
 	ci := fs.GetConfig(context.Background())
+	startTime := time.Now()
 	var cmdErr error
 	stopStats := func() {}
 	if !showStats && ShowStats() {
 		showStats = true
 	}
-	if ci.Progress {
+	if ci.ProgressJSON {
+		stopStats = startProgressJSON()
+	} else if ci.Progress {
 		stopStats = startProgress()
 	} else if showStats {
 		stopStats = StartStats()
@@ -292,7 +313,7 @@ func Run(Retry bool, showStats bool, cmd *cobra.Command, f func() error) {
 	}
 	fs.Debugf(nil, "%d go routines active\n", runtime.NumGoroutine())
 
-	if ci.Progress && ci.ProgressTerminalTitle {
+	if ci.Progress && !ci.ProgressJSON && ci.ProgressTerminalTitle {
 		// Clear terminal title
 		terminal.WriteTerminalTitle("")
 	}
@@ -325,6 +346,10 @@ func Run(Retry bool, showStats bool, cmd *cobra.Command, f func() error) {
 			log.Printf("Failed to %s with %d errors: last error was: %v", cmd.Name(), nerrs, cmdErr)
 		}
 	}
+
+	// Tell the user the command has finished if required
+	notify.Finished(context.Background(), notify.NewSummary(cmd.Name(), startTime, cmdErr))
+
 	resolveExitCode(cmdErr)
 
 }
@@ -388,6 +413,17 @@ func initConfig() {
 		log.Fatalf("Failed to load filters: %v", err)
 	}
 
+	// Open the audit log if configured
+	err = audit.Init()
+	if err != nil {
+		log.Fatalf("Failed to start audit log: %v", err)
+	}
+	atexit.Register(audit.Close)
+
+	// Start exporting traces if configured
+	tracing.InitTracing()
+	atexit.Register(tracing.Shutdown)
+
 	// Write the args for debug purposes
 	fs.Debugf("rclone", "Version %q starting with parameters %q", fs.Version, os.Args)
 
@@ -402,6 +438,18 @@ func initConfig() {
 		log.Fatalf("Failed to start remote control: %v", err)
 	}
 
+	// Start the metrics server if configured
+	err = metrics.Start(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to start metrics: %v", err)
+	}
+
+	// Start the watchdog if configured
+	watchdog.Start(context.Background(), func() {
+		atexit.Run()
+		os.Exit(exitCodeFatalError)
+	})
+
 	// Setup CPU profiling if desired
 	if *cpuProfile != "" {
 		fs.Infof(nil, "Creating CPU profile %q\n", *cpuProfile)
@@ -463,6 +511,7 @@ func resolveExitCode(err error) {
 	}
 
 	_, unwrapped := fserrors.Cause(err)
+	exitCode, hasExitCode := exitCodeByErrorCode[fserrors.ErrorCode(err)]
 
 	switch {
 	case unwrapped == fs.ErrorDirNotFound:
@@ -473,6 +522,8 @@ func resolveExitCode(err error) {
 		os.Exit(exitCodeUncategorizedError)
 	case unwrapped == accounting.ErrorMaxTransferLimitReached:
 		os.Exit(exitCodeTransferExceeded)
+	case hasExitCode:
+		os.Exit(exitCode)
 	case fserrors.ShouldRetry(err):
 		os.Exit(exitCodeRetryError)
 	case fserrors.IsNoRetryError(err):
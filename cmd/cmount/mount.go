@@ -142,6 +142,10 @@ func waitFor(fn func() bool) (ok bool) {
 //
 // returns an error, and an error channel for the serve process to
 // report an error when fusermount is called.
+//
+// Unlike mount and mount2 this doesn't subscribe to VFS.ChangeNotify
+// as cgofuse v1.4.0 has no FileSystemHost.Notify to pass changes on
+// the remote to WinFsp or macFUSE with.
 func mount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (<-chan error, func() error, error) {
 	f := VFS.Fs()
 	fs.Debugf(f, "Mounting on %q", mountpoint)
@@ -161,7 +165,7 @@ func mount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (<-chan error
 	fsys := NewFS(VFS)
 	host := fuse.NewFileSystemHost(fsys)
 	host.SetCapReaddirPlus(true) // only works on Windows
-	host.SetCapCaseInsensitive(f.Features().CaseInsensitive)
+	host.SetCapCaseInsensitive(f.Features().CaseInsensitive || VFS.Opt.FoldNames)
 
 	// Create options
 	options := mountOptions(VFS, f.Name()+":"+f.Root(), mountpoint, opt)
@@ -28,7 +28,7 @@ the equivalent of running rclone check, but able to check the
 checksums of the crypted remote.
 
 For it to work the underlying remote of the cryptedremote must support
-some kind of checksum.
+some kind of checksum, or the files must be in crypt format v3.
 
 It works by reading the nonce from each file on the cryptedremote: and
 using that to encrypt each file on the remote:.  It then checks the
@@ -44,6 +44,10 @@ the files in remote:path.
 
     rclone cryptcheck remote:path encryptedremote:path
 
+Files in crypt format v3 are checked against the HMAC stored at the end
+of them instead, so only the start and end of them are read from the
+cryptedremote: whatever hashes it supports.
+
 After it has run it will log the status of the encryptedremote:.
 ` + check.FlagsHelp,
 	Run: func(command *cobra.Command, args []string) {
@@ -66,9 +70,10 @@ func cryptCheck(ctx context.Context, fdst, fsrc fs.Fs) error {
 	funderlying := fcrypt.UnWrap()
 	hashType := funderlying.Hashes().GetOne()
 	if hashType == hash.None {
-		return errors.Errorf("%s:%s does not support any hashes", funderlying.Name(), funderlying.Root())
+		fs.Logf(nil, "%s:%s does not support any hashes so only files in format v3 can be checked", funderlying.Name(), funderlying.Root())
+	} else {
+		fs.Infof(nil, "Using %v for hash comparisons", hashType)
 	}
-	fs.Infof(nil, "Using %v for hash comparisons", hashType)
 
 	opt, close, err := check.GetCheckOpt(fsrc, fcrypt)
 	if err != nil {
@@ -82,6 +87,20 @@ func cryptCheck(ctx context.Context, fdst, fsrc fs.Fs) error {
 	// it also returns whether it couldn't be hashed
 	opt.Check = func(ctx context.Context, dst, src fs.Object) (differ bool, noHash bool, err error) {
 		cryptDst := dst.(*crypt.Object)
+		differ, noHMAC, err := cryptDst.CheckHMAC(ctx, src)
+		if err != nil {
+			return true, false, errors.Wrap(err, "error checking HMAC")
+		}
+		if !noHMAC {
+			if differ {
+				err = errors.Errorf("HMAC of (%s:%s) doesn't match contents of (%s:%s)", fdst.Name(), fdst.Root(), fsrc.Name(), fsrc.Root())
+				fs.Errorf(src, err.Error())
+			}
+			return differ, false, nil
+		}
+		if hashType == hash.None {
+			return false, true, nil
+		}
 		underlyingDst := cryptDst.UnWrap()
 		underlyingHash, err := underlyingDst.Hash(ctx, hashType)
 		if err != nil {
@@ -9,10 +9,15 @@ import (
 	"strings"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/audit/auditflags"
 	"github.com/rclone/rclone/fs/config/configflags"
 	"github.com/rclone/rclone/fs/filter/filterflags"
 	"github.com/rclone/rclone/fs/log/logflags"
+	"github.com/rclone/rclone/fs/metrics/metricsflags"
+	"github.com/rclone/rclone/fs/notify/notifyflags"
 	"github.com/rclone/rclone/fs/rc/rcflags"
+	"github.com/rclone/rclone/fs/tracing/tracingflags"
+	"github.com/rclone/rclone/fs/watchdog/watchdogflags"
 	"github.com/rclone/rclone/lib/atexit"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -173,6 +178,11 @@ func setupRootCommand(rootCmd *cobra.Command) {
 	filterflags.AddFlags(pflag.CommandLine)
 	rcflags.AddFlags(pflag.CommandLine)
 	logflags.AddFlags(pflag.CommandLine)
+	tracingflags.AddFlags(pflag.CommandLine)
+	metricsflags.AddFlags(pflag.CommandLine)
+	auditflags.AddFlags(pflag.CommandLine)
+	watchdogflags.AddFlags(pflag.CommandLine)
+	notifyflags.AddFlags(pflag.CommandLine)
 
 	Root.Run = runRoot
 	Root.Flags().BoolVarP(&version, "version", "V", false, "Print the version number")
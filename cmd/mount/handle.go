@@ -69,6 +69,8 @@ var _ fusefs.HandleFlusher = (*FileHandle)(nil)
 // some writes, or that if will be called at all.
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// POSIX locks are released when any file descriptor is closed
+	fh.releaseLocks(req.LockOwner)
 	return translateError(fh.Handle.Flush())
 }
 
@@ -80,5 +82,8 @@ var _ fusefs.HandleReleaser = (*FileHandle)(nil)
 // the kernel
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		fh.releaseLocks(req.LockOwner)
+	}
 	return translateError(fh.Handle.Release())
 }
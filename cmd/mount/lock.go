@@ -0,0 +1,96 @@
+// +build linux,go1.13 freebsd,go1.13
+
+package mount
+
+import (
+	"context"
+	"math"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+)
+
+// lockOwner is the owner of the locks taken through the mount.
+//
+// It is the lock owner passed in by the kernel which identifies the
+// process for POSIX locks and the open file for flock locks.
+type lockOwner fuse.LockOwner
+
+// toVFSLock converts the lock from the kernel into a vfs.Lock
+func toVFSLock(owner fuse.LockOwner, lk *fuse.FileLock) vfs.Lock {
+	l := vfs.Lock{
+		Owner: lockOwner(owner),
+		Write: lk.Type == fuse.LockWrite,
+		Start: int64(lk.Start),
+		End:   vfs.LockEOF,
+	}
+	// lk.End is inclusive
+	if lk.End < math.MaxInt64 {
+		l.End = int64(lk.End) + 1
+	}
+	return l
+}
+
+// Check interface satisfied
+var _ fusefs.HandleLocker = (*FileHandle)(nil)
+
+// Lock tries to take a lock, returning EAGAIN if a conflicting lock
+// is held.
+func (fh *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v, flags=%v", req.LockOwner, req.Lock, req.LockFlags)("err=%v", &err)
+	node := fh.Handle.Node()
+	return translateError(node.VFS().Lock(node.Path(), toVFSLock(req.LockOwner, &req.Lock)))
+}
+
+// LockWait takes a lock, waiting for any conflicting locks to be
+// released.
+func (fh *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v, flags=%v", req.LockOwner, req.Lock, req.LockFlags)("err=%v", &err)
+	node := fh.Handle.Node()
+	err = node.VFS().LockWait(ctx, node.Path(), toVFSLock(req.LockOwner, &req.Lock))
+	if err != nil && ctx.Err() != nil {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return translateError(err)
+}
+
+// Unlock releases a lock
+func (fh *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v, flags=%v", req.LockOwner, req.Lock, req.LockFlags)("err=%v", &err)
+	node := fh.Handle.Node()
+	l := toVFSLock(req.LockOwner, &req.Lock)
+	node.VFS().Unlock(node.Path(), l.Owner, l.Start, l.End)
+	return nil
+}
+
+// QueryLock returns a lock which conflicts with the lock given, if
+// there is one.
+func (fh *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v, flags=%v", req.LockOwner, req.Lock, req.LockFlags)("lock=%+v, err=%v", &resp.Lock, &err)
+	node := fh.Handle.Node()
+	conflict := node.VFS().TestLock(node.Path(), toVFSLock(req.LockOwner, &req.Lock))
+	if conflict == nil {
+		return nil
+	}
+	resp.Lock = fuse.FileLock{
+		Start: uint64(conflict.Start),
+		End:   math.MaxInt64,
+		Type:  fuse.LockRead,
+	}
+	if conflict.End != vfs.LockEOF {
+		resp.Lock.End = uint64(conflict.End) - 1
+	}
+	if conflict.Write {
+		resp.Lock.Type = fuse.LockWrite
+	}
+	return nil
+}
+
+// releaseLocks releases all the locks held by owner on the file
+func (fh *FileHandle) releaseLocks(owner fuse.LockOwner) {
+	node := fh.Handle.Node()
+	node.VFS().Unlock(node.Path(), lockOwner(owner), 0, vfs.LockEOF)
+}
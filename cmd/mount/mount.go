@@ -27,6 +27,8 @@ func mountOptions(VFS *vfs.VFS, device string, opt *mountlib.Options) (options [
 		fuse.Subtype("rclone"),
 		fuse.FSName(device),
 		fuse.VolumeName(opt.VolumeName),
+		fuse.LockingFlock(),
+		fuse.LockingPOSIX(),
 
 		// Options from benchmarking in the fuse module
 		//fuse.MaxReadahead(64 * 1024 * 1024),
@@ -116,7 +118,13 @@ func mount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (<-chan error
 		return nil, nil, err
 	}
 
+	// Tell the kernel about changes on the remote
+	stopNotify := VFS.ChangeNotify(func(changedPath string, _ fs.EntryType) {
+		filesys.notify(server, changedPath)
+	})
+
 	unmount := func() error {
+		stopNotify()
 		// Shutdown the VFS
 		filesys.VFS.Shutdown()
 		return fuse.Unmount(mountpoint)
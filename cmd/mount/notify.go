@@ -0,0 +1,50 @@
+// Telling the kernel about changes on the remote
+
+// +build linux,go1.13 freebsd,go1.13
+
+package mount
+
+import (
+	"path"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// notify tells the kernel that changedPath has changed on the remote
+// so it drops the directory entry and any data it has cached for it.
+//
+// Nothing needs doing for paths the kernel hasn't looked up, so only
+// the nodes in the directory cache are looked at.
+func (f *FS) notify(server *fusefs.Server, changedPath string) {
+	if changedPath == "" {
+		return
+	}
+	dirPath, leaf := path.Split(changedPath)
+	dir, ok := f.VFS.CachedNode(dirPath).(*vfs.Dir)
+	if !ok {
+		return
+	}
+	parent, ok := dir.Sys().(fusefs.Node)
+	if !ok {
+		return
+	}
+	if child := f.VFS.CachedNode(changedPath); child != nil {
+		if node, ok := child.Sys().(*File); ok {
+			err := server.InvalidateNodeData(node)
+			if err != nil && err != fuse.ErrNotCached {
+				fs.Debugf(changedPath, "Failed to invalidate kernel data cache: %v", err)
+			}
+		}
+	}
+	err := server.InvalidateEntry(parent, leaf)
+	switch err {
+	case nil:
+		fs.Debugf(changedPath, "Told the kernel about change on the remote")
+	case fuse.ErrNotCached:
+	default:
+		fs.Debugf(changedPath, "Failed to tell the kernel about change on the remote: %v", err)
+	}
+}
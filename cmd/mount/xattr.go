@@ -0,0 +1,61 @@
+// Extended attributes
+
+// +build linux,go1.13 freebsd,go1.13
+
+package mount
+
+import (
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/rclone/rclone/vfs"
+)
+
+// flags for setxattr as used by Linux
+const (
+	xattrCreate  = 1
+	xattrReplace = 2
+)
+
+// getxattr gets the extended attribute of node for req
+func getxattr(node vfs.Node, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, err := vfs.GetXattr(node, req.Name)
+	if err != nil {
+		return translateError(err)
+	}
+	if req.Size != 0 && uint32(len(value)) > req.Size {
+		return fuse.Errno(syscall.ERANGE)
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// listxattr lists the extended attributes of node for req
+func listxattr(node vfs.Node, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	names, err := vfs.ListXattr(node)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Append(names...)
+	if req.Size != 0 && uint32(len(resp.Xattr)) > req.Size {
+		return fuse.Errno(syscall.ERANGE)
+	}
+	return nil
+}
+
+// setxattr sets the extended attribute of node in req
+func setxattr(node vfs.Node, req *fuse.SetxattrRequest) error {
+	flags := 0
+	if req.Flags&xattrCreate != 0 {
+		flags |= vfs.XattrCreate
+	}
+	if req.Flags&xattrReplace != 0 {
+		flags |= vfs.XattrReplace
+	}
+	return translateError(vfs.SetXattr(node, req.Name, req.Xattr, flags))
+}
+
+// removexattr removes the extended attribute of node in req
+func removexattr(node vfs.Node, req *fuse.RemovexattrRequest) error {
+	return translateError(vfs.RemoveXattr(node, req.Name))
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"syscall"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
@@ -35,6 +36,10 @@ import (
 type FileHandle struct {
 	h    vfs.Handle
 	fsys *FS
+
+	mu          sync.Mutex
+	posixOwners []lockOwner // owners of POSIX locks taken through this handle
+	flockOwners []lockOwner // owners of flock locks taken through this handle
 }
 
 // Create a new FileHandle
@@ -85,6 +90,7 @@ var _ fusefs.FileWriter = (*FileHandle)(nil)
 // of a descriptor that was duplicated using dup(2), it may be called
 // more than once for the same FileHandle.
 func (f *FileHandle) Flush(ctx context.Context) syscall.Errno {
+	f.releaseLocks(false)
 	return translateError(f.h.Flush())
 }
 
@@ -95,6 +101,7 @@ var _ fusefs.FileFlusher = (*FileHandle)(nil)
 // so any cleanup that requires specific synchronization or
 // could fail with I/O errors should happen in Flush instead.
 func (f *FileHandle) Release(ctx context.Context) syscall.Errno {
+	f.releaseLocks(true)
 	return translateError(f.h.Release())
 }
 
@@ -149,3 +156,40 @@ func (f *FileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.
 }
 
 var _ fusefs.FileSetattrer = (*FileHandle)(nil)
+
+// Modes for fallocate(2)
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// Allocate preallocates or deallocates space for the file.
+//
+// Only punching holes and allocating space are supported. Since the
+// cache file is sparse allocating space only needs to extend the
+// file.
+func (f *FileHandle) Allocate(ctx context.Context, off uint64, size uint64, mode uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "off=%d, size=%d, mode=%#x", off, size, mode)("errno=%v", &errno)
+	switch mode {
+	case fallocKeepSize | fallocPunchHole:
+		err := f.h.PunchHole(int64(off), int64(size))
+		if err == vfs.ENOSYS {
+			// Handles without a cache file can't punch holes.
+			// Don't return ENOSYS as the kernel would never
+			// call Allocate again.
+			return syscall.EOPNOTSUPP
+		}
+		return translateError(err)
+	case fallocKeepSize:
+		return 0
+	case 0:
+		end := int64(off + size)
+		if end > f.h.Node().Size() {
+			return translateError(f.h.Truncate(end))
+		}
+		return 0
+	}
+	return syscall.EOPNOTSUPP
+}
+
+var _ fusefs.FileAllocater = (*FileHandle)(nil)
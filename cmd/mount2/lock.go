@@ -0,0 +1,143 @@
+// +build linux darwin,amd64
+
+package mount2
+
+import (
+	"context"
+	"math"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+)
+
+// lockOwner is the owner of the locks taken through the mount.
+//
+// It is the lock owner passed in by the kernel which identifies the
+// process for POSIX locks and the open file for flock locks.
+type lockOwner uint64
+
+// toVFSLock converts the lock from the kernel into a vfs.Lock
+func toVFSLock(owner uint64, lk *fuse.FileLock) vfs.Lock {
+	l := vfs.Lock{
+		Owner: lockOwner(owner),
+		Write: lk.Typ == syscall.F_WRLCK,
+		Start: int64(lk.Start),
+		End:   vfs.LockEOF,
+	}
+	// lk.End is inclusive
+	if lk.End < math.MaxInt64 {
+		l.End = int64(lk.End) + 1
+	}
+	return l
+}
+
+// Getlk returns a lock that would conflict with the lock given, or a
+// lock of type F_UNLCK if there isn't one.
+func (f *FileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%#x, lk=%+v", owner, lk)("out=%+v, errno=%v", out, &errno)
+	node := f.h.Node()
+	conflict := node.VFS().TestLock(node.Path(), toVFSLock(owner, lk))
+	if conflict == nil {
+		*out = *lk
+		out.Typ = syscall.F_UNLCK
+		return 0
+	}
+	out.Start = uint64(conflict.Start)
+	out.End = math.MaxInt64
+	if conflict.End != vfs.LockEOF {
+		out.End = uint64(conflict.End) - 1
+	}
+	out.Typ = syscall.F_RDLCK
+	if conflict.Write {
+		out.Typ = syscall.F_WRLCK
+	}
+	out.Pid = 0
+	return 0
+}
+
+// Setlk takes or releases a lock, failing with EAGAIN if a
+// conflicting lock is held.
+func (f *FileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	return f.setlk(ctx, owner, lk, flags, false)
+}
+
+// Setlkw takes or releases a lock, waiting for any conflicting locks
+// to be released.
+func (f *FileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	return f.setlk(ctx, owner, lk, flags, true)
+}
+
+// setlk implements Setlk and Setlkw
+func (f *FileHandle) setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, wait bool) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%#x, lk=%+v, flags=%#x, wait=%v", owner, lk, flags, wait)("errno=%v", &errno)
+	node := f.h.Node()
+	l := toVFSLock(owner, lk)
+	if lk.Typ == syscall.F_UNLCK {
+		node.VFS().Unlock(node.Path(), l.Owner, l.Start, l.End)
+		return 0
+	}
+	f.addLockOwner(l.Owner.(lockOwner), flags&fuse.FUSE_LK_FLOCK != 0)
+	if !wait {
+		return translateError(node.VFS().Lock(node.Path(), l))
+	}
+	err := node.VFS().LockWait(ctx, node.Path(), l)
+	if err != nil && ctx.Err() != nil {
+		return syscall.EINTR
+	}
+	return translateError(err)
+}
+
+// addLockOwner records that owner has taken locks through f so they
+// can be released when the file is closed.
+func (f *FileHandle) addLockOwner(owner lockOwner, flock bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if flock {
+		f.flockOwners = appendOwner(f.flockOwners, owner)
+	} else {
+		f.posixOwners = appendOwner(f.posixOwners, owner)
+	}
+}
+
+// appendOwner adds owner to owners if it isn't already there
+func appendOwner(owners []lockOwner, owner lockOwner) []lockOwner {
+	for _, o := range owners {
+		if o == owner {
+			return owners
+		}
+	}
+	return append(owners, owner)
+}
+
+// releaseLocks releases the POSIX locks taken through f, and the
+// flock locks too if flock is set.
+//
+// The kernel expects the POSIX locks of the process to be released
+// when any of its file descriptors for the file are closed and the
+// flock locks when the open file is released, but it doesn't say
+// which lock owner is closing the file, so all the owners which have
+// locked through f are released.
+func (f *FileHandle) releaseLocks(flock bool) {
+	f.mu.Lock()
+	owners := f.posixOwners
+	f.posixOwners = nil
+	if flock {
+		owners = append(owners, f.flockOwners...)
+		f.flockOwners = nil
+	}
+	f.mu.Unlock()
+	node := f.h.Node()
+	for _, owner := range owners {
+		node.VFS().Unlock(node.Path(), owner, 0, vfs.LockEOF)
+	}
+}
+
+// Check interfaces satisfied
+var (
+	_ fusefs.FileGetlker  = (*FileHandle)(nil)
+	_ fusefs.FileSetlker  = (*FileHandle)(nil)
+	_ fusefs.FileSetlkwer = (*FileHandle)(nil)
+)
@@ -27,12 +27,12 @@ func init() {
 func mountOptions(fsys *FS, f fs.Fs) (mountOpts *fuse.MountOptions) {
 	device := f.Name() + ":" + f.Root()
 	mountOpts = &fuse.MountOptions{
-		AllowOther:    fsys.opt.AllowOther,
-		FsName:        device,
-		Name:          "rclone",
-		DisableXAttrs: true,
-		Debug:         fsys.opt.DebugFUSE,
-		MaxReadAhead:  int(fsys.opt.MaxReadAhead),
+		AllowOther:   fsys.opt.AllowOther,
+		FsName:       device,
+		Name:         "rclone",
+		Debug:        fsys.opt.DebugFUSE,
+		MaxReadAhead: int(fsys.opt.MaxReadAhead),
+		EnableLocks:  true,
 
 		// RememberInodes: true,
 		// SingleThreaded: true,
@@ -195,7 +195,13 @@ func mount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (<-chan error
 	// 	return nil, nil, err
 	// }
 
+	// Tell the kernel about changes on the remote
+	stopNotify := VFS.ChangeNotify(func(changedPath string, _ fs.EntryType) {
+		fsys.notify(root.EmbeddedInode(), changedPath)
+	})
+
 	umount := func() error {
+		stopNotify()
 		// Shutdown the VFS
 		fsys.VFS.Shutdown()
 		return server.Unmount()
@@ -14,6 +14,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
+	"golang.org/x/sys/unix"
 )
 
 // Node represents a directory or file
@@ -404,3 +405,66 @@ func (n *Node) Rename(ctx context.Context, oldName string, newParent fusefs.Inod
 }
 
 var _ = (fusefs.NodeRenamer)((*Node)(nil))
+
+// Getxattr should read data for the given attribute into
+// `dest` and return the number of bytes. If `dest` is too
+// small, it should return ERANGE and the size of the attribute.
+func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("size=%d, errno=%v", &size, &errno)
+	value, err := vfs.GetXattr(n.node, attr)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	if len(value) > len(dest) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+var _ = (fusefs.NodeGetxattrer)((*Node)(nil))
+
+// Setxattr should store data for the given attribute.  See
+// setxattr(2) for information about flags.
+func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q, flags=%d", attr, flags)("errno=%v", &errno)
+	vfsFlags := 0
+	if flags&unix.XATTR_CREATE != 0 {
+		vfsFlags |= vfs.XattrCreate
+	}
+	if flags&unix.XATTR_REPLACE != 0 {
+		vfsFlags |= vfs.XattrReplace
+	}
+	return translateError(vfs.SetXattr(n.node, attr, data, vfsFlags))
+}
+
+var _ = (fusefs.NodeSetxattrer)((*Node)(nil))
+
+// Removexattr should delete the given attribute.
+func (n *Node) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	return translateError(vfs.RemoveXattr(n.node, attr))
+}
+
+var _ = (fusefs.NodeRemovexattrer)((*Node)(nil))
+
+// Listxattr should read all attributes (null terminated) into
+// `dest`. If the `dest` buffer is too small, it should return ERANGE
+// and the correct size.
+func (n *Node) Listxattr(ctx context.Context, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "")("size=%d, errno=%v", &size, &errno)
+	names, err := vfs.ListXattr(n.node)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	var buf []byte
+	for _, name := range names {
+		buf = append(buf, name...)
+		buf = append(buf, 0)
+	}
+	if len(buf) > len(dest) {
+		return uint32(len(buf)), syscall.ERANGE
+	}
+	return uint32(copy(dest, buf)), 0
+}
+
+var _ = (fusefs.NodeListxattrer)((*Node)(nil))
@@ -0,0 +1,64 @@
+// Telling the kernel about changes on the remote
+
+// +build linux darwin,amd64
+
+package mount2
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/rclone/rclone/fs"
+)
+
+// notify tells the kernel that changedPath has changed on the remote
+// so it drops the directory entry and any data it has cached for it.
+//
+// If a file the kernel has looked up has been deleted the kernel is
+// told that too so programs watching the directory with inotify see
+// the deletion. Nothing needs doing for paths the kernel hasn't
+// looked up, so nothing is read from the remote for them.
+func (f *FS) notify(root *fusefs.Inode, changedPath string) {
+	if changedPath == "" {
+		return
+	}
+	dir, leaf := path.Split(changedPath)
+	parent := root
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		parent = parent.GetChild(name)
+		if parent == nil {
+			return
+		}
+	}
+	child := parent.GetChild(leaf)
+	var errno syscall.Errno
+	if child != nil && !child.IsDir() && f.isDeleted(changedPath) {
+		errno = parent.NotifyDelete(leaf, child)
+	} else {
+		errno = parent.NotifyEntry(leaf)
+		if errno == 0 && child != nil && !child.IsDir() {
+			errno = child.NotifyContent(0, 0)
+		}
+	}
+	switch errno {
+	case 0:
+		fs.Debugf(changedPath, "Told the kernel about change on the remote")
+	case syscall.ENOENT:
+		// the kernel had already forgotten about it
+	default:
+		fs.Debugf(changedPath, "Failed to tell the kernel about change on the remote: %v", errno)
+	}
+}
+
+// isDeleted returns true if the file at remote has been deleted from
+// the remote, looking it up directly rather than reading its directory
+func (f *FS) isDeleted(remote string) bool {
+	_, err := f.VFS.Fs().NewObject(context.TODO(), remote)
+	return err == fs.ErrorObjectNotFound
+}
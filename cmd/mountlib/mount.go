@@ -313,6 +313,47 @@ then there is no chance of corruption.
 
 This is the same as setting the attr_timeout option in mount.fuse.
 
+### Change notification
+
+If the remote supports change notifications (see ` + "`--poll-interval`" + `),
+rclone ` + commandName + ` tells the kernel about the files and directories
+which change on the remote, so the kernel drops anything it has cached
+for them straight away rather than waiting for ` + "`--attr-timeout`" + ` to
+expire. This means file managers and other programs see the changes
+as soon as rclone hears about them.
+
+Only the files and directories the kernel has looked up are told
+about, and nothing is read from the remote to do it except to check
+whether a file the kernel knows about has been deleted.
+
+On Linux deleted files are reported to programs watching the directory
+with inotify, but the kernel doesn't generate inotify events for other
+changes made on the remote, so programs which rely on inotify will
+only see them when they next look.
+
+This isn't supported by ` + "`rclone cmount`" + `, which is what is used on
+Windows and usually on macOS, as the version of the cgofuse library it
+is built with has no way of telling WinFsp or macFUSE about changes.
+There changes on the remote are only seen once ` + "`--attr-timeout`" + ` and
+` + "`--dir-cache-time`" + ` expire, and Windows directory change
+notifications and FSEvents aren't generated.
+
+### Extended attributes
+
+Extended attributes in the "user." namespace are mapped onto the
+metadata the backend stores with each file, so tools such as
+` + "`getfattr`" + `, ` + "`setfattr`" + ` and ` + "`rsync -X`" + ` can read and write them.
+At the moment this means extended attributes on the local backend,
+user metadata on S3 and custom file properties on Google Drive.
+
+Extended attributes in other namespaces aren't supported and neither
+are extended attributes on backends which can't store metadata - these
+return "Operation not supported" when set.  Nothing can be set if
+` + "`--read-only`" + ` is in use.
+
+If an extended attribute is set on a file which is being written then
+it will be applied once the file has been uploaded.
+
 ### Filters
 
 Note that all the rclone filters can be used to select a subset of the
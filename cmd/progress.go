@@ -31,7 +31,7 @@ func startProgress() func() {
 	if !log.Redirected() {
 		// Intercept the log calls if not logging to file or syslog
 		fs.LogPrint = func(level fs.LogLevel, text string) {
-			printProgress(fmt.Sprintf("%s %-6s: %s", time.Now().Format(logTimeFormat), level, text))
+			printProgress(fmt.Sprintf("%s %s: %s", time.Now().Format(logTimeFormat), log.LevelString(level), text))
 
 		}
 	}
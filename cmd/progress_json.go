@@ -0,0 +1,64 @@
+// Show the progress as newline delimited JSON
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+)
+
+// startProgressJSON starts printing the progress as a line of JSON
+// on stdout each progress interval
+//
+// Each line is the output of core/stats so it contains the overall
+// ETA as well as the progress of each transfer.
+//
+// It returns a func which should be called to stop the progress.
+func startProgressJSON() func() {
+	stopStats := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		progressInterval := defaultProgressInterval
+		if ShowStats() && *statsInterval > 0 {
+			progressInterval = *statsInterval
+		}
+		ticker := time.NewTicker(progressInterval)
+		for {
+			select {
+			case <-ticker.C:
+				printProgressJSON()
+			case <-stopStats:
+				ticker.Stop()
+				printProgressJSON()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopStats)
+		wg.Wait()
+	}
+}
+
+// printProgressJSON prints the progress as a line of JSON
+func printProgressJSON() {
+	out, err := accounting.GlobalStats().RemoteStats()
+	if err != nil {
+		fs.Errorf(nil, "Failed to read progress: %v", err)
+		return
+	}
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	// Encode writes a trailing newline
+	err = json.NewEncoder(os.Stdout).Encode(out)
+	if err != nil {
+		fs.Errorf(nil, "Failed to write progress: %v", err)
+	}
+}
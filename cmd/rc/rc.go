@@ -6,12 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/fshttp"
@@ -27,6 +29,7 @@ var (
 	authUser  = ""
 	authPass  = ""
 	loopback  = false
+	socket    = "" // set if url is a Unix domain socket or named pipe
 	options   []string
 	arguments []string
 )
@@ -54,6 +57,10 @@ specify an non default URL to connect on.  This can be either a
 ":port" which is taken to mean "http://localhost:port" or a
 "host:port" which is taken to mean "http://host:port"
 
+To connect to an rclone listening on a Unix domain socket use
+--url unix:/path/to/socket, or on Windows use --url
+npipe://./pipe/NAME to connect to a named pipe.
+
 A username and password can be passed in with --user and --pass.
 
 Note that --rc-addr, --rc-user, --rc-pass will be read also for --url,
@@ -116,6 +123,12 @@ func parseFlags() {
 	setAlternateFlag("rc-addr", &url)
 	setAlternateFlag("rc-user", &authUser)
 	setAlternateFlag("rc-pass", &authPass)
+	// if url is a socket then talk HTTP over it
+	if httplib.IsSocketAddr(url) {
+		socket = url
+		url = "http://localhost/"
+		return
+	}
 	// If url is just :port then fix it up
 	if strings.HasPrefix(url, ":") {
 		url = "localhost" + url
@@ -178,6 +191,15 @@ func doCall(ctx context.Context, path string, in rc.Params) (out rc.Params, err
 
 	// Do HTTP request
 	client := fshttp.NewClient(ctx)
+	if socket != "" {
+		client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return httplib.DialSocket(ctx, socket)
+				},
+			},
+		}
+	}
 	url += path
 	data, err := json.Marshal(in)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/rc/jobs"
 	"github.com/rclone/rclone/fs/rc/rcflags"
 	"github.com/rclone/rclone/fs/rc/rcserver"
 	"github.com/spf13/cobra"
@@ -26,6 +27,16 @@ If you pass in a path to a directory, rclone will serve that directory
 for GET requests on the URL passed in.  It will also open the URL in
 the browser when rclone is run.
 
+Any schedules added with the job/schedule rc command are run while
+rclone rcd is running.
+
+If --rc-job-state-file is set then the async sync, copy and move jobs
+which are running are saved in that file and if rclone rcd is stopped
+before they finish they are started again when it next runs.
+
+If --rc-grpc-addr is set then the rc is served over gRPC on that
+address as well.
+
 See the [rc documentation](/rc/) for more info on the rc flags.
 `,
 	Run: func(command *cobra.Command, args []string) {
@@ -48,6 +59,15 @@ See the [rc documentation](/rc/) for more info on the rc flags.
 			log.Fatal("rc server not configured")
 		}
 
+		// Resume any jobs which were running when rclone stopped
+		err = jobs.ResumeJobs(context.Background())
+		if err != nil {
+			log.Printf("Failed to resume jobs: %v", err)
+		}
+
+		// Run the scheduled jobs
+		jobs.StartScheduler(context.Background())
+
 		s.Wait()
 	},
 }
@@ -0,0 +1,53 @@
+package retryfailed
+
+import (
+	"context"
+	"log"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "retry-failed report.json",
+	Short: `Retry the files which failed in a sync, copy or move.`,
+	Long: `
+Retry exactly the files listed in a report written by a ` + "`sync`, `copy`" + `
+or ` + "`move`" + ` with ` + "`--error-report`" + `. The source and destination are
+read from the report so only the report needs to be given, eg
+
+    rclone sync --error-report errors.json source:path dest:path
+    rclone retry-failed errors.json
+
+Only the files in the report are looked at, so this is much quicker
+than running the sync again when only a few files failed. Files which
+failed to copy or move are copied or moved again unless they have
+gone from the source since. Files which failed to be deleted from the
+destination are deleted again unless they have appeared in the source
+since.
+
+Use ` + "`--error-report`" + ` with this command to write the files which
+fail again to a report, which may be the same file, eg
+
+    rclone retry-failed --error-report errors.json errors.json
+
+The usual flags such as ` + "`--backup-dir`" + ` apply to the retries.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		report, err := sync.ReadErrorReport(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fsrc := cmd.NewFsDir([]string{report.Src})
+		fdst := cmd.NewFsDir([]string{report.Dst})
+		cmd.Run(true, true, command, func() error {
+			return sync.RetryFailed(context.Background(), fdst, fsrc, report)
+		})
+	},
+}
@@ -0,0 +1,530 @@
+// Package auth implements a user database shared by the rclone serve
+// commands
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	libcache "github.com/rclone/rclone/lib/cache"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/md4"
+	"golang.org/x/time/rate"
+)
+
+// Help contains text describing the user database
+var Help = strings.Replace(`
+### User database
+
+Instead of a single |--user| and |--pass| the users can be checked
+against a user database. Users may be authenticated by any of
+
+- |--auth-htpasswd FILE| - an htpasswd file of users and password
+  hashes. The bcrypt, SHA1 and MD5 hashes made by |htpasswd| are
+  supported, as are NT hashes written as |{NT}| followed by the hash
+  in hex and passwords stored in clear as |{PLAIN}| followed by the
+  password. The file is read again if it changes.
+- |--auth-ldap-url URL| - an LDAP server (|ldap://host:389| or
+  |ldaps://host:636|) checked with a simple bind as the DN given by
+  |--auth-ldap-bind-dn|, where |%u| is replaced by the user name, e.g.
+  |uid=%u,ou=people,dc=example,dc=com|. Users in the htpasswd file are
+  checked there first.
+- |--auth-oidc-issuer URL| - OpenID Connect ID or access tokens (JWTs
+  signed with RS256) from this issuer. The token's signing keys are
+  read from the issuer's discovery document. If |--auth-oidc-audience|
+  is set the token must be for that audience. The user name is read
+  from the claim named by |--auth-oidc-user-claim|. HTTP clients send
+  the token as an |Authorization: Bearer| header, other clients can use
+  the token as their password.
+
+Password logins are cached for 5 minutes so changes to the passwords
+may take that long to be noticed.
+
+Settings for each user can be given with |--auth-users FILE|. This has
+a line for each user of the form
+
+    USER [root=REMOTE:PATH] [read-only] [bwlimit=RATE] [uid=UID]
+
+where |root| is the remote the user is served (|%u| is replaced by the
+user name) instead of the one on the command line, |read-only| stops
+the user changing anything, |bwlimit| limits the bandwidth used by
+all of the user's connections to RATE bytes per second, e.g. |10M|,
+and |uid| is the numeric user ID of the user for |rclone serve nfs|. A
+line with a USER of |*| gives the defaults for users without a line of
+their own. Lines starting with |#| are ignored and settings with
+spaces in can be quoted with double quotes, e.g.
+|"root=drive:Shared Files"|. For example
+
+    # everyone gets their own home directory
+    *     root=remote:home/%u bwlimit=1M
+    # apart from the backup user who can read everything
+    backup root=remote: read-only
+
+The user database can be used with all the |rclone serve| commands
+and can't be used with |--auth-proxy|. The logins of some protocols
+can only be checked with more than a password hash:
+
+- |rclone serve s3| checks the AWS signatures of requests with the
+  secret access key, so the access key ID is the user name and the
+  secret access key must be stored in clear with |{PLAIN}| in the
+  htpasswd file.
+- |rclone serve smb| checks NTLMv2 logins with the NT hash of the
+  password, so the password must be stored as a |{NT}| hash or with
+  |{PLAIN}| in the htpasswd file. The NT hash of a password can be
+  made with |printf %s PASSWORD | iconv -t utf-16le | openssl md4|.
+- |rclone serve nfs| has no logins. The user ID the client sends
+  with each call is looked up in the |uid| settings of the
+  |--auth-users| file, so any client which can reach the server can
+  act as any of those users.
+- |rclone serve dlna| has no logins either, so it serves the user
+  given with its |--auth-user| flag.
+
+As the |{PLAIN}| passwords are stored in clear the htpasswd file
+should only be readable by rclone.
+`, "|", "`", -1)
+
+// Options is options for the user database
+type Options struct {
+	Htpasswd      string // htpasswd file to check passwords with
+	Users         string // file of per user settings
+	LDAPURL       string // LDAP server to check passwords with
+	LDAPBindDN    string // DN to bind to the LDAP server as with %u for the user
+	OIDCIssuer    string // OpenID Connect issuer of bearer tokens
+	OIDCAudience  string // audience the bearer tokens must be for
+	OIDCUserClaim string // claim holding the user name in the tokens
+}
+
+// DefaultOpt is the default values for Options
+var DefaultOpt = Options{
+	OIDCUserClaim: "preferred_username",
+}
+
+// Enabled returns true if the user database is configured
+func (opt *Options) Enabled() bool {
+	return opt.Htpasswd != "" || opt.LDAPURL != "" || opt.OIDCIssuer != ""
+}
+
+// userSettings are the settings for a user from the --auth-users file
+type userSettings struct {
+	root     string        // remote:path to serve, may contain %u
+	readOnly bool          // set if the user can't write
+	bwLimit  fs.SizeSuffix // bandwidth limit in bytes/s or 0
+	uid      *uint32       // numeric user ID for NFS if set
+}
+
+// DB is a user database
+type DB struct {
+	ctx      context.Context // for global config
+	f        fs.Fs           // default remote to serve, may be nil
+	opt      Options
+	htpasswd httpauth.SecretProvider // nil if not configured
+	settings map[string]userSettings // per user settings, "*" for defaults
+	oidc     *oidcVerifier           // nil if not configured
+	logins   *libcache.Cache         // cache of password logins
+
+	mu    sync.Mutex
+	vfses map[string]*vfs.VFS // VFS for each root and read only flag
+	users map[string]*User    // users by name
+}
+
+// User is a user who has logged in
+type User struct {
+	Name     string   // name of the user
+	VFS      *vfs.VFS // what the user is served
+	ReadOnly bool     // set if the user can't write
+	limiter  *rate.Limiter
+}
+
+// New makes a user database from opt. The remote f is served to
+// users who don't have a root set in the --auth-users file and may
+// be nil if they should all have one.
+func New(ctx context.Context, f fs.Fs, opt *Options) (*DB, error) {
+	db := &DB{
+		ctx:    ctx,
+		f:      f,
+		opt:    *opt,
+		logins: libcache.New(),
+		vfses:  make(map[string]*vfs.VFS),
+		users:  make(map[string]*User),
+	}
+	if opt.Htpasswd != "" {
+		fs.Infof(nil, "Using %q as htpasswd storage", opt.Htpasswd)
+		db.htpasswd = httpauth.HtpasswdFileProvider(opt.Htpasswd)
+	}
+	if opt.LDAPURL != "" {
+		if !strings.Contains(opt.LDAPBindDN, "%u") {
+			return nil, errors.New("--auth-ldap-bind-dn must be set and contain %u")
+		}
+		if _, _, err := parseLDAPURL(opt.LDAPURL); err != nil {
+			return nil, err
+		}
+	}
+	if opt.OIDCIssuer != "" {
+		if opt.OIDCUserClaim == "" {
+			return nil, errors.New("--auth-oidc-user-claim must be set")
+		}
+		db.oidc = newOIDCVerifier(opt.OIDCIssuer, opt.OIDCAudience, opt.OIDCUserClaim)
+	}
+	db.settings = map[string]userSettings{}
+	if opt.Users != "" {
+		data, err := ioutil.ReadFile(opt.Users)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read --auth-users")
+		}
+		db.settings, err = parseUserSettings(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse --auth-users %q", opt.Users)
+		}
+	}
+	return db, nil
+}
+
+// parseUserSettings parses lines of "USER [root=REMOTE:PATH] [read-only] [bwlimit=RATE] [uid=UID]"
+func parseUserSettings(data []byte) (map[string]userSettings, error) {
+	// read the lines first so the defaults are known
+	var lines [][]string
+	var lineNumbers []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var list fs.SpaceSepList
+		err := list.Set(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNumber)
+		}
+		// allow more than one space between the fields
+		var fields []string
+		for _, field := range list {
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+		lines = append(lines, fields)
+		lineNumbers = append(lineNumbers, lineNumber)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	settings := make(map[string]userSettings)
+	parse := func(i int, defaults userSettings) error {
+		fields, lineNumber := lines[i], lineNumbers[i]
+		user := fields[0]
+		if _, found := settings[user]; found {
+			return errors.Errorf("line %d: duplicate user %q", lineNumber, user)
+		}
+		s := defaults
+		for _, field := range fields[1:] {
+			key, value := field, ""
+			if equals := strings.IndexRune(field, '='); equals >= 0 {
+				key, value = field[:equals], field[equals+1:]
+			}
+			var err error
+			switch key {
+			case "root":
+				s.root = value
+			case "read-only":
+				s.readOnly = true
+				if value != "" {
+					s.readOnly, err = strconv.ParseBool(value)
+				}
+			case "bwlimit":
+				err = s.bwLimit.Set(value)
+			case "uid":
+				var uid uint64
+				uid, err = strconv.ParseUint(value, 10, 32)
+				if err == nil {
+					uid32 := uint32(uid)
+					s.uid = &uid32
+				}
+			default:
+				err = errors.Errorf("unknown setting %q", key)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "line %d", lineNumber)
+			}
+		}
+		settings[user] = s
+		return nil
+	}
+	for i := range lines {
+		if lines[i][0] == "*" {
+			if err := parse(i, userSettings{}); err != nil {
+				return nil, err
+			}
+			if settings["*"].uid != nil {
+				return nil, errors.Errorf("line %d: uid can't be set for all users", lineNumbers[i])
+			}
+		}
+	}
+	uids := make(map[uint32]string)
+	for i := range lines {
+		if lines[i][0] != "*" {
+			if err := parse(i, settings["*"]); err != nil {
+				return nil, err
+			}
+			user := lines[i][0]
+			if uid := settings[user].uid; uid != nil {
+				if other, found := uids[*uid]; found {
+					return nil, errors.Errorf("line %d: uid %d is used by %q already", lineNumbers[i], *uid, other)
+				}
+				uids[*uid] = user
+			}
+		}
+	}
+	return settings, nil
+}
+
+// checkUserName checks the user name is safe to use in a root
+func checkUserName(user string) error {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, `/\:%`) || strings.IndexFunc(user, unicode.IsControl) >= 0 {
+		return errors.Errorf("invalid user name %q", user)
+	}
+	return nil
+}
+
+// checkHash checks pass against an htpasswd hash
+func checkHash(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$apr1$") || strings.HasPrefix(hash, "$1$"):
+		parts := strings.SplitN(hash, "$", 4)
+		if len(parts) != 4 {
+			return false
+		}
+		magic := "$" + parts[1] + "$"
+		return subtle.ConstantTimeCompare([]byte(hash), httpauth.MD5Crypt([]byte(pass), []byte(parts[2]), []byte(magic))) == 1
+	case strings.HasPrefix(hash, "{NT}"):
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(hash[4:])), []byte(hex.EncodeToString(NTHash(pass)))) == 1
+	case strings.HasPrefix(hash, "{PLAIN}"):
+		return subtle.ConstantTimeCompare([]byte(hash[7:]), []byte(pass)) == 1
+	}
+	return false
+}
+
+// NTHash returns the NT hash of pass, the MD4 of its UTF-16LE encoding
+func NTHash(pass string) []byte {
+	h := md4.New()
+	_, _ = h.Write(smb2.EncodeUTF16(pass))
+	return h.Sum(nil)
+}
+
+// Login checks the user name and password returning the User
+//
+// If OpenID Connect tokens are configured then pass may be a token
+// for user. If user is empty then the user is taken from the token.
+func (db *DB) Login(user, pass string) (*User, error) {
+	if pass == "" {
+		return nil, errors.New("empty password")
+	}
+	if db.oidc != nil && looksLikeJWT(pass) {
+		name, err := db.oidc.verify(db.ctx, pass)
+		if err != nil {
+			return nil, err
+		}
+		if user != "" && user != name {
+			return nil, errors.Errorf("token is for user %q not %q", name, user)
+		}
+		return db.User(name)
+	}
+	if err := checkUserName(user); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + pass))
+	_, err := db.logins.Get(hex.EncodeToString(sum[:]), func(key string) (value interface{}, ok bool, err error) {
+		err = db.checkPassword(user, pass)
+		return user, err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.User(user)
+}
+
+// checkPassword checks the password for user with htpasswd and LDAP
+func (db *DB) checkPassword(user, pass string) error {
+	if db.htpasswd != nil {
+		if hash := db.htpasswd(user, ""); hash != "" {
+			if !checkHash(hash, pass) {
+				return errors.Errorf("bad password for user %q", user)
+			}
+			return nil
+		}
+	}
+	if db.opt.LDAPURL != "" {
+		return ldapBind(db.ctx, db.opt.LDAPURL, expandUser(db.opt.LDAPBindDN, ldapEscapeDN(user)), pass)
+	}
+	return errors.Errorf("unknown user %q", user)
+}
+
+// htpasswdEntry returns the entry for user in the htpasswd file or
+// an error if there isn't one
+func (db *DB) htpasswdEntry(user string) (string, error) {
+	if err := checkUserName(user); err != nil {
+		return "", err
+	}
+	if db.htpasswd != nil {
+		if hash := db.htpasswd(user, ""); hash != "" {
+			return hash, nil
+		}
+	}
+	return "", errors.Errorf("unknown user %q", user)
+}
+
+// Secret returns the secret of user stored in clear with {PLAIN} in
+// the htpasswd file. This is for protocols which need the secret
+// itself to check a login, e.g. the AWS signatures of serve s3.
+func (db *DB) Secret(user string) (string, error) {
+	hash, err := db.htpasswdEntry(user)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(hash, "{PLAIN}") {
+		return "", errors.Errorf("no {PLAIN} secret for user %q in htpasswd file", user)
+	}
+	return hash[7:], nil
+}
+
+// NTHash returns the NT hash of the password of user from a {NT} or
+// {PLAIN} entry in the htpasswd file. This is for checking NTLM
+// logins.
+func (db *DB) NTHash(user string) ([]byte, error) {
+	hash, err := db.htpasswdEntry(user)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(hash, "{NT}"):
+		ntHash, err := hex.DecodeString(hash[4:])
+		if err != nil || len(ntHash) != md4.Size {
+			return nil, errors.Errorf("bad {NT} hash for user %q in htpasswd file", user)
+		}
+		return ntHash, nil
+	case strings.HasPrefix(hash, "{PLAIN}"):
+		return NTHash(hash[7:]), nil
+	}
+	return nil, errors.Errorf("no {NT} hash or {PLAIN} password for user %q in htpasswd file", user)
+}
+
+// UserByUID returns the User with uid set to uid in the --auth-users
+// file. This is for protocols which identify users by their numeric
+// ID only, e.g. NFS.
+func (db *DB) UserByUID(uid uint32) (*User, error) {
+	for name, settings := range db.settings {
+		if settings.uid != nil && *settings.uid == uid {
+			return db.User(name)
+		}
+	}
+	return nil, errors.Errorf("no user with uid %d", uid)
+}
+
+// expandUser replaces %u in s with user and %% with %
+func expandUser(s, user string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'u':
+				out.WriteString(user)
+				i++
+				continue
+			case '%':
+				out.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// User returns the User called name without checking a password.
+// This is for users who have been authenticated some other way, e.g.
+// with an SSH key.
+func (db *DB) User(name string) (*User, error) {
+	if err := checkUserName(name); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if u, ok := db.users[name]; ok {
+		return u, nil
+	}
+	settings, ok := db.settings[name]
+	if !ok {
+		settings = db.settings["*"]
+	}
+	VFS, err := db.getVFS(expandUser(settings.root, name), settings.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{
+		Name:     name,
+		VFS:      VFS,
+		ReadOnly: settings.readOnly,
+	}
+	if settings.bwLimit > 0 {
+		u.limiter = newLimiter(settings.bwLimit)
+	}
+	db.users[name] = u
+	return u, nil
+}
+
+// getVFS returns the VFS for root, making it if necessary. The
+// remote passed to New is used if root is empty.
+//
+// Call with db.mu held
+func (db *DB) getVFS(root string, readOnly bool) (*vfs.VFS, error) {
+	key := root
+	if readOnly {
+		key += "\x00ro"
+	}
+	if VFS, ok := db.vfses[key]; ok {
+		return VFS, nil
+	}
+	f := db.f
+	if root == "" && f == nil {
+		return nil, errors.New("no root set for user in --auth-users")
+	} else if root != "" {
+		var err error
+		f, err = fs.NewFs(db.ctx, root)
+		if err == fs.ErrorIsFile {
+			return nil, errors.Errorf("user root %q is a file", root)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to make user root %q", root)
+		}
+	}
+	opt := vfsflags.Opt
+	if readOnly {
+		opt.ReadOnly = true
+	}
+	VFS := vfs.New(f, &opt)
+	db.vfses[key] = VFS
+	return VFS, nil
+}
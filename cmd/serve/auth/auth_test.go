@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	httpauth "github.com/abbot/go-http-auth"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseUserSettings(t *testing.T) {
+	settings, err := parseUserSettings([]byte(`
+# defaults
+*     root=remote:home/%u bwlimit=1M
+backup root=remote: read-only
+  alice   read-only=false
+"bob smith" "root=drive:Shared Files" read-only=true   bwlimit=off
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]userSettings{
+		"*":         {root: "remote:home/%u", bwLimit: 1024 * 1024},
+		"backup":    {root: "remote:", readOnly: true, bwLimit: 1024 * 1024},
+		"alice":     {root: "remote:home/%u", bwLimit: 1024 * 1024},
+		"bob smith": {root: "drive:Shared Files", readOnly: true, bwLimit: -1},
+	}, settings)
+
+	for _, test := range []struct {
+		in      string
+		wantErr string
+	}{
+		{"alice\nalice", `line 2: duplicate user "alice"`},
+		{"alice potato=1", `line 1: unknown setting "potato"`},
+		{"alice read-only=maybe", "line 1: strconv.ParseBool"},
+		{"alice bwlimit=fast", "line 1:"},
+		{"alice uid=-1", "line 1:"},
+		{"* uid=1", "line 1: uid can't be set for all users"},
+		{"alice uid=1\nbob uid=1", `line 2: uid 1 is used by "alice" already`},
+		{`alice "root`, "line 1:"},
+	} {
+		_, err := parseUserSettings([]byte(test.in))
+		require.Error(t, err, test.in)
+		assert.Contains(t, err.Error(), test.wantErr, test.in)
+	}
+}
+
+func TestCheckUserName(t *testing.T) {
+	for _, test := range []struct {
+		user string
+		ok   bool
+	}{
+		{"alice", true},
+		{"bob smith", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"a:b", false},
+		{"a%u", false},
+		{"a\nb", false},
+	} {
+		err := checkUserName(test.user)
+		assert.Equal(t, test.ok, err == nil, test.user)
+	}
+}
+
+func TestExpandUser(t *testing.T) {
+	assert.Equal(t, "remote:home/alice/100%", expandUser("remote:home/%u/100%%", "alice"))
+	assert.Equal(t, "%x%", expandUser("%x%", "alice"))
+}
+
+func TestCheckHash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	sum := sha1.Sum([]byte("secret"))
+	shaHash := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	apr1Hash := string(httpauth.MD5Crypt([]byte("secret"), []byte("saltsalt"), []byte("$apr1$")))
+	md5Hash := string(httpauth.MD5Crypt([]byte("secret"), []byte("saltsalt"), []byte("$1$")))
+
+	// NT hash of "secret" in upper case hex as made by openssl
+	ntHash := "{NT}878D8014606CDA29677A44EFA1353FC7"
+	plainHash := "{PLAIN}secret"
+
+	for _, hash := range []string{string(bcryptHash), shaHash, apr1Hash, md5Hash, ntHash, plainHash} {
+		assert.True(t, checkHash(hash, "secret"), hash)
+		assert.False(t, checkHash(hash, "wrong"), hash)
+		assert.False(t, checkHash(hash, ""), hash)
+	}
+	assert.False(t, checkHash("secret", "secret"))
+	assert.False(t, checkHash("$apr1$bad", "secret"))
+}
+
+// writeFile writes data to a file called name in dir
+func writeFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-auth-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	for _, user := range []string{"alice", "bob", "shared"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, user), 0777))
+	}
+	writeFile(t, dir, "shared/file.txt", "hello")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("alicepass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	sum := sha1.Sum([]byte("bobpass"))
+	htpasswd := writeFile(t, dir, "htpasswd", "alice:"+string(bcryptHash)+"\n"+
+		"bob:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"+
+		"carol:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n")
+	users := writeFile(t, dir, "users", `
+*   root=`+filepath.Join(dir, "%u")+` bwlimit=1M
+bob read-only
+carol root=`+filepath.Join(dir, "shared")+` bwlimit=off
+`)
+
+	opt := DefaultOpt
+	assert.False(t, opt.Enabled())
+	opt.Htpasswd = htpasswd
+	opt.Users = users
+	assert.True(t, opt.Enabled())
+	db, err := New(ctx, nil, &opt)
+	require.NoError(t, err)
+
+	alice, err := db.Login("alice", "alicepass")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", alice.Name)
+	assert.False(t, alice.ReadOnly)
+	assert.Equal(t, filepath.Join(dir, "alice"), alice.VFS.Fs().Root())
+	assert.False(t, alice.VFS.Opt.ReadOnly)
+	assert.NotNil(t, alice.limiter)
+
+	// logging in again gives the same user
+	alice2, err := db.Login("alice", "alicepass")
+	require.NoError(t, err)
+	assert.True(t, alice == alice2)
+
+	bob, err := db.Login("bob", "bobpass")
+	require.NoError(t, err)
+	assert.True(t, bob.ReadOnly)
+	assert.True(t, bob.VFS.Opt.ReadOnly)
+	assert.Equal(t, filepath.Join(dir, "bob"), bob.VFS.Fs().Root())
+
+	carol, err := db.Login("carol", "bobpass")
+	require.NoError(t, err)
+	assert.Nil(t, carol.limiter)
+	_, err = carol.VFS.Stat("file.txt")
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		user, pass string
+	}{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"bob", "alicepass"},
+		{"dave", "alicepass"},
+		{"../alice", "alicepass"},
+		{"", "alicepass"},
+	} {
+		_, err := db.Login(test.user, test.pass)
+		assert.Error(t, err, test.user+":"+test.pass)
+	}
+
+	// A user who hasn't got a root with no remote
+	dir2 := filepath.Join(dir, "noroot")
+	require.NoError(t, os.Mkdir(dir2, 0777))
+	opt.Users = ""
+	db, err = New(ctx, nil, &opt)
+	require.NoError(t, err)
+	_, err = db.Login("alice", "alicepass")
+	assert.EqualError(t, err, "no root set for user in --auth-users")
+
+	// Using the default remote
+	f, err := fs.NewFs(ctx, dir2)
+	require.NoError(t, err)
+	db, err = New(ctx, f, &opt)
+	require.NoError(t, err)
+	alice, err = db.Login("alice", "alicepass")
+	require.NoError(t, err)
+	assert.Equal(t, dir2, alice.VFS.Fs().Root())
+	bob, err = db.User("bob")
+	require.NoError(t, err)
+	assert.True(t, alice.VFS == bob.VFS)
+}
+
+func TestSecrets(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-auth-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	sum := sha1.Sum([]byte("bobpass"))
+	htpasswd := writeFile(t, dir, "htpasswd", "alice:{PLAIN}alicepass\n"+
+		"bob:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"+
+		"carol:{NT}878d8014606cda29677a44efa1353fc7\n"+
+		"dave:{NT}potato\n")
+	users := writeFile(t, dir, "users", "alice uid=1000\nbob uid=1001\n")
+	opt := DefaultOpt
+	opt.Htpasswd = htpasswd
+	opt.Users = users
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	db, err := New(ctx, f, &opt)
+	require.NoError(t, err)
+
+	secret, err := db.Secret("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alicepass", secret)
+	_, err = db.Secret("bob")
+	assert.EqualError(t, err, `no {PLAIN} secret for user "bob" in htpasswd file`)
+	_, err = db.Secret("eve")
+	assert.EqualError(t, err, `unknown user "eve"`)
+
+	ntHash, err := db.NTHash("alice")
+	require.NoError(t, err)
+	assert.Equal(t, NTHash("alicepass"), ntHash)
+	ntHash, err = db.NTHash("carol")
+	require.NoError(t, err)
+	assert.Equal(t, NTHash("secret"), ntHash)
+	_, err = db.NTHash("bob")
+	assert.Error(t, err)
+	_, err = db.NTHash("dave")
+	assert.EqualError(t, err, `bad {NT} hash for user "dave" in htpasswd file`)
+
+	// the passwords can be used to log in too
+	_, err = db.Login("alice", "alicepass")
+	require.NoError(t, err)
+	_, err = db.Login("carol", "secret")
+	require.NoError(t, err)
+
+	u, err := db.UserByUID(1001)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", u.Name)
+	_, err = db.UserByUID(0)
+	assert.EqualError(t, err, "no user with uid 0")
+}
+
+func TestNewErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		opt     Options
+		wantErr string
+	}{
+		{Options{LDAPURL: "ldap://localhost"}, "--auth-ldap-bind-dn must be set and contain %u"},
+		{Options{LDAPURL: "http://localhost", LDAPBindDN: "uid=%u"}, "must start with ldap:// or ldaps://"},
+		{Options{OIDCIssuer: "https://example.com"}, "--auth-oidc-user-claim must be set"},
+		{Options{Htpasswd: "htpasswd", Users: "/does/not/exist"}, "failed to read --auth-users"},
+	} {
+		_, err := New(ctx, nil, &test.opt)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.wantErr)
+	}
+}
@@ -0,0 +1,24 @@
+// Package authflags implements command line flags to set up a user database
+package authflags
+
+import (
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/spf13/pflag"
+)
+
+// Options set by command line flags
+var (
+	Opt = auth.DefaultOpt
+)
+
+// AddFlags adds the non filing system specific flags to the command
+func AddFlags(flagSet *pflag.FlagSet) {
+	flags.StringVarP(flagSet, &Opt.Htpasswd, "auth-htpasswd", "", Opt.Htpasswd, "htpasswd file of users to allow.")
+	flags.StringVarP(flagSet, &Opt.Users, "auth-users", "", Opt.Users, "File of per user settings (root, read-only, bwlimit).")
+	flags.StringVarP(flagSet, &Opt.LDAPURL, "auth-ldap-url", "", Opt.LDAPURL, "LDAP server to check passwords with, e.g. ldaps://ldap.example.com")
+	flags.StringVarP(flagSet, &Opt.LDAPBindDN, "auth-ldap-bind-dn", "", Opt.LDAPBindDN, "DN to bind to the LDAP server as, %u is replaced by the user name.")
+	flags.StringVarP(flagSet, &Opt.OIDCIssuer, "auth-oidc-issuer", "", Opt.OIDCIssuer, "OpenID Connect issuer URL to accept bearer tokens from.")
+	flags.StringVarP(flagSet, &Opt.OIDCAudience, "auth-oidc-audience", "", Opt.OIDCAudience, "Audience the OpenID Connect tokens must be for.")
+	flags.StringVarP(flagSet, &Opt.OIDCUserClaim, "auth-oidc-user-claim", "", Opt.OIDCUserClaim, "Claim in the OpenID Connect tokens holding the user name.")
+}
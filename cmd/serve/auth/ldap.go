@@ -0,0 +1,253 @@
+package auth
+
+// This implements just enough of LDAP (RFC 4511) to check a password
+// with a simple bind.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ldapTimeout is the time allowed for the whole bind
+const ldapTimeout = 30 * time.Second
+
+// BER tags used in the LDAP messages
+const (
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berEnumerated     = 0x0a
+	berSequence       = 0x30
+	ldapBindRequest   = 0x60 // [APPLICATION 0] constructed
+	ldapBindResponse  = 0x61 // [APPLICATION 1] constructed
+	ldapUnbindRequest = 0x42 // [APPLICATION 2] primitive
+	ldapSimpleAuth    = 0x80 // [0] primitive
+)
+
+// LDAP result codes
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+// parseLDAPURL returns the host:port and whether to use TLS for an
+// ldap:// or ldaps:// URL
+func parseLDAPURL(ldapURL string) (addr string, useTLS bool, err error) {
+	u, err := url.Parse(ldapURL)
+	if err != nil {
+		return "", false, errors.Wrap(err, "bad --auth-ldap-url")
+	}
+	port := ""
+	switch u.Scheme {
+	case "ldap":
+		port = "389"
+	case "ldaps":
+		port, useTLS = "636", true
+	default:
+		return "", false, errors.Errorf("bad --auth-ldap-url %q: must start with ldap:// or ldaps://", ldapURL)
+	}
+	if u.Hostname() == "" {
+		return "", false, errors.Errorf("bad --auth-ldap-url %q: no host", ldapURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// ldapEscapeDN escapes s for use as an attribute value in a DN as
+// described in RFC 4514
+func ldapEscapeDN(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0:
+			out.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			i == 0 && (c == '#' || c == ' '),
+			i == len(s)-1 && c == ' ':
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// berEncode encodes content with tag
+func berEncode(tag byte, content ...[]byte) []byte {
+	length := 0
+	for _, c := range content {
+		length += len(c)
+	}
+	out := []byte{tag}
+	if length < 0x80 {
+		out = append(out, byte(length))
+	} else {
+		var lengthBytes []byte
+		for l := length; l > 0; l >>= 8 {
+			lengthBytes = append([]byte{byte(l)}, lengthBytes...)
+		}
+		out = append(out, 0x80|byte(len(lengthBytes)))
+		out = append(out, lengthBytes...)
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+// berInt encodes a small non negative integer with tag
+func berInt(tag byte, i int) []byte {
+	var content []byte
+	for ; i > 0; i >>= 8 {
+		content = append([]byte{byte(i)}, content...)
+	}
+	if len(content) == 0 || content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berEncode(tag, content)
+}
+
+// berRead reads a tag and its content from in
+func berRead(in *bufio.Reader) (tag byte, content []byte, err error) {
+	tag, err = in.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	b, err := in.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(b)
+	if b&0x80 != 0 {
+		n := int(b & 0x7f)
+		if n == 0 || n > 3 {
+			return 0, nil, errors.New("LDAP: unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err = in.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	content = make([]byte, length)
+	_, err = io.ReadFull(in, content)
+	return tag, content, err
+}
+
+// berSplit splits content into its tags and contents
+func berSplit(content []byte) (tags []byte, contents [][]byte, err error) {
+	in := bufio.NewReader(bytes.NewReader(content))
+	for {
+		tag, c, err := berRead(in)
+		if err == io.EOF {
+			return tags, contents, nil
+		} else if err != nil {
+			return nil, nil, errors.Wrap(err, "LDAP: bad response")
+		}
+		tags = append(tags, tag)
+		contents = append(contents, c)
+	}
+}
+
+// berToInt decodes the content of a small integer
+func berToInt(content []byte) int {
+	i := 0
+	for _, b := range content {
+		i = i<<8 | int(b)
+	}
+	return i
+}
+
+// ldapBind checks the password for dn with a simple bind to the
+// server at ldapURL
+func ldapBind(ctx context.Context, ldapURL, dn, password string) (err error) {
+	if password == "" {
+		// an empty password would be an unauthenticated bind
+		return errors.New("LDAP: empty password")
+	}
+	addr, useTLS, err := parseLDAPURL(ldapURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "LDAP: failed to connect")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err = tlsConn.Handshake(); err != nil {
+			return errors.Wrap(err, "LDAP: TLS handshake failed")
+		}
+		conn = tlsConn
+	}
+
+	const messageID = 1
+	request := berEncode(berSequence,
+		berInt(berInteger, messageID),
+		berEncode(ldapBindRequest,
+			berInt(berInteger, 3), // version
+			berEncode(berOctetString, []byte(dn)),
+			berEncode(ldapSimpleAuth, []byte(password)),
+		),
+	)
+	if _, err = conn.Write(request); err != nil {
+		return errors.Wrap(err, "LDAP: failed to send bind request")
+	}
+	tag, content, err := berRead(bufio.NewReader(conn))
+	if err != nil {
+		return errors.Wrap(err, "LDAP: failed to read bind response")
+	}
+	if tag != berSequence {
+		return errors.New("LDAP: bad response")
+	}
+	tags, contents, err := berSplit(content)
+	if err != nil {
+		return err
+	}
+	if len(tags) < 2 || tags[0] != berInteger || berToInt(contents[0]) != messageID || tags[1] != ldapBindResponse {
+		return errors.New("LDAP: unexpected response")
+	}
+	tags, contents, err = berSplit(contents[1])
+	if err != nil {
+		return err
+	}
+	if len(tags) < 3 || tags[0] != berEnumerated {
+		return errors.New("LDAP: bad bind response")
+	}
+
+	// Say goodbye politely
+	_, _ = conn.Write(berEncode(berSequence, berInt(berInteger, messageID+1), berEncode(ldapUnbindRequest)))
+
+	switch resultCode := berToInt(contents[0]); resultCode {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return errors.Errorf("LDAP: invalid credentials for %q", dn)
+	default:
+		return errors.Errorf("LDAP: bind as %q failed with result code %d: %s", dn, resultCode, contents[2])
+	}
+}
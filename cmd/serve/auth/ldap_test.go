@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLDAPURL(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		addr    string
+		useTLS  bool
+		wantErr bool
+	}{
+		{"ldap://example.com", "example.com:389", false, false},
+		{"ldaps://example.com", "example.com:636", true, false},
+		{"ldap://example.com:1389/", "example.com:1389", false, false},
+		{"ldap://[::1]", "[::1]:389", false, false},
+		{"http://example.com", "", false, true},
+		{"ldap://", "", false, true},
+		{"ldap://%zz", "", false, true},
+	} {
+		addr, useTLS, err := parseLDAPURL(test.in)
+		assert.Equal(t, test.wantErr, err != nil, test.in)
+		assert.Equal(t, test.addr, addr, test.in)
+		assert.Equal(t, test.useTLS, useTLS, test.in)
+	}
+}
+
+func TestLDAPEscapeDN(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"smith, bob", `smith\, bob`},
+		{`a+b"c\d<e>f;g=h`, `a\+b\"c\\d\<e\>f\;g\=h`},
+		{"#alice", `\#alice`},
+		{"a#b", "a#b"},
+		{" alice ", `\ alice\ `},
+		{"a\x00b", `a\00b`},
+	} {
+		assert.Equal(t, test.want, ldapEscapeDN(test.in), test.in)
+	}
+}
+
+func TestBER(t *testing.T) {
+	for _, i := range []int{0, 1, 127, 128, 255, 256, 65535, 1 << 20} {
+		tags, contents, err := berSplit(berInt(berInteger, i))
+		require.NoError(t, err)
+		require.Equal(t, []byte{berInteger}, tags)
+		assert.Equal(t, i, berToInt(contents[0]), i)
+	}
+	long := make([]byte, 300)
+	encoded := berEncode(berOctetString, long[:100], long[100:])
+	assert.Equal(t, []byte{berOctetString, 0x82, 0x01, 0x2c}, encoded[:4])
+	tags, contents, err := berSplit(encoded)
+	require.NoError(t, err)
+	require.Equal(t, []byte{berOctetString}, tags)
+	assert.Equal(t, long, contents[0])
+
+	_, _, err = berSplit([]byte{berOctetString, 0x05, 0x00})
+	assert.Error(t, err)
+}
+
+// startLDAPServer starts an LDAP server which accepts a simple bind
+// as dn with password returning its URL
+func startLDAPServer(t *testing.T, dn, password string) (ldapURL string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				in := bufio.NewReader(conn)
+				tag, content, err := berRead(in)
+				if err != nil || tag != berSequence {
+					return
+				}
+				_, message, err := berSplit(content)
+				if err != nil || len(message) != 2 {
+					return
+				}
+				_, bind, err := berSplit(message[1])
+				if err != nil || len(bind) != 3 {
+					return
+				}
+				resultCode := ldapInvalidCredentials
+				if string(bind[1]) == dn && string(bind[2]) == password {
+					resultCode = ldapSuccess
+				}
+				_, _ = conn.Write(berEncode(berSequence,
+					berInt(berInteger, berToInt(message[0])),
+					berEncode(ldapBindResponse,
+						berInt(berEnumerated, resultCode),
+						berEncode(berOctetString),
+						berEncode(berOctetString),
+					),
+				))
+				// wait for the unbind
+				_, _, _ = berRead(in)
+			}()
+		}
+	}()
+	return "ldap://" + listener.Addr().String(), func() {
+		_ = listener.Close()
+	}
+}
+
+func TestLDAPBind(t *testing.T) {
+	ctx := context.Background()
+	ldapURL, stop := startLDAPServer(t, `uid=smith\, bob,dc=example,dc=com`, "secret")
+	defer stop()
+
+	opt := DefaultOpt
+	opt.LDAPURL = ldapURL
+	opt.LDAPBindDN = "uid=%u,dc=example,dc=com"
+	db, err := New(ctx, nil, &opt)
+	require.NoError(t, err)
+
+	assert.NoError(t, db.checkPassword("smith, bob", "secret"))
+	err = db.checkPassword("smith, bob", "wrong")
+	assert.EqualError(t, err, `LDAP: invalid credentials for "uid=smith\\, bob,dc=example,dc=com"`)
+	assert.Error(t, db.checkPassword("alice", "secret"))
+	assert.EqualError(t, db.checkPassword("smith, bob", ""), "LDAP: empty password")
+
+	err = ldapBind(ctx, "ldap://127.0.0.1:1", "uid=alice", "secret")
+	assert.Error(t, err)
+}
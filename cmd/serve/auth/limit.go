@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// maxBurst is the largest burst allowed by the bandwidth limiters
+const maxBurst = 64 * 1024
+
+// newLimiter makes a limiter for bwLimit bytes/s
+func newLimiter(bwLimit fs.SizeSuffix) *rate.Limiter {
+	burst := maxBurst
+	if int64(bwLimit) < int64(burst) {
+		burst = int(bwLimit)
+	}
+	return rate.NewLimiter(rate.Limit(bwLimit), burst)
+}
+
+// limitedReader is an io.Reader limited by a rate.Limiter
+type limitedReader struct {
+	ctx     context.Context
+	in      io.Reader
+	limiter *rate.Limiter
+}
+
+// Read reads up to the burst size then waits for the limiter
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// limitedWriter is an io.Writer limited by a rate.Limiter
+type limitedWriter struct {
+	ctx     context.Context
+	out     io.Writer
+	limiter *rate.Limiter
+}
+
+// Write waits for the limiter before writing each burst of p
+func (w *limitedWriter) Write(p []byte) (n int, err error) {
+	burst := w.limiter.Burst()
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err = w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return n, err
+		}
+		written, err := w.out.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+	return n, nil
+}
+
+// Wait waits until n bytes may be transferred within the user's
+// bandwidth limit. This is for protocols which transfer blocks of
+// data rather than streams.
+//
+// ctx is used to stop waiting for the limiter
+func (u *User) Wait(ctx context.Context, n int) error {
+	if u.limiter == nil {
+		return nil
+	}
+	burst := u.limiter.Burst()
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		if err := u.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// LimitReader returns in limited to the user's bandwidth limit
+//
+// ctx is used to stop waiting for the limiter
+func (u *User) LimitReader(ctx context.Context, in io.Reader) io.Reader {
+	if u.limiter == nil {
+		return in
+	}
+	return &limitedReader{ctx: ctx, in: in, limiter: u.limiter}
+}
+
+// LimitWriter returns out limited to the user's bandwidth limit
+//
+// ctx is used to stop waiting for the limiter
+func (u *User) LimitWriter(ctx context.Context, out io.Writer) io.Writer {
+	if u.limiter == nil {
+		return out
+	}
+	return &limitedWriter{ctx: ctx, out: out, limiter: u.limiter}
+}
+
+// limitedResponseWriter is an http.ResponseWriter limited by a rate.Limiter
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	out io.Writer
+}
+
+// Write writes p via the limiter
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+// LimitHTTP limits the response w and the body of the request r to
+// the user's bandwidth limit
+func (u *User) LimitHTTP(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if u.limiter == nil {
+		return w, r
+	}
+	if r.Body != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{u.LimitReader(r.Context(), r.Body), r.Body}
+	}
+	return &limitedResponseWriter{ResponseWriter: w, out: u.LimitWriter(r.Context(), w)}, r
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter(t *testing.T) {
+	assert.Equal(t, maxBurst, newLimiter(10*fs.MebiByte).Burst())
+	assert.Equal(t, 1024, newLimiter(1024).Burst())
+}
+
+func TestLimit(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("x"), 32*1024)
+
+	// No limit
+	u := &User{}
+	in := bytes.NewReader(data)
+	assert.True(t, u.LimitReader(ctx, in) == io.Reader(in))
+	var out bytes.Buffer
+	assert.True(t, u.LimitWriter(ctx, &out) == io.Writer(&out))
+
+	// 16k/s should take 1s for 32k as the first 16k is the burst
+	u = &User{limiter: newLimiter(16 * 1024)}
+	start := time.Now()
+	got, err := ioutil.ReadAll(u.LimitReader(ctx, bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.True(t, time.Since(start) > 800*time.Millisecond, time.Since(start))
+
+	u = &User{limiter: newLimiter(16 * 1024)}
+	start = time.Now()
+	out.Reset()
+	n, err := u.LimitWriter(ctx, &out).Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, data, out.Bytes())
+	assert.True(t, time.Since(start) > 800*time.Millisecond, time.Since(start))
+
+	// A cancelled context stops the wait
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = u.LimitWriter(ctx, &out).Write(data)
+	assert.Error(t, err)
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	u := &User{}
+	require.NoError(t, u.Wait(ctx, 1024*1024))
+
+	// more than the burst is allowed and takes 1s at 16k/s
+	u = &User{limiter: newLimiter(16 * 1024)}
+	start := time.Now()
+	require.NoError(t, u.Wait(ctx, 32*1024))
+	assert.True(t, time.Since(start) > 800*time.Millisecond, time.Since(start))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, u.Wait(ctx, 32*1024))
+}
+
+func TestLimitHTTP(t *testing.T) {
+	u := &User{limiter: newLimiter(1024 * 1024)}
+	r := httptest.NewRequest("PUT", "/file.txt", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+	lw, lr := u.LimitHTTP(w, r)
+	body, err := ioutil.ReadAll(lr.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	require.NoError(t, lr.Body.Close())
+	lw.Header().Set("X-Test", "potato")
+	_, err = io.WriteString(lw, "world")
+	require.NoError(t, err)
+	assert.Equal(t, "world", w.Body.String())
+	assert.Equal(t, "potato", w.Header().Get("X-Test"))
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"golang.org/x/oauth2/jws"
+)
+
+// minKeyRefresh is the minimum time between fetches of the signing
+// keys so unknown key IDs can't be used to hammer the issuer
+const minKeyRefresh = time.Minute
+
+// oidcVerifier checks OpenID Connect bearer tokens
+type oidcVerifier struct {
+	issuer    string // issuer the tokens must be from
+	audience  string // audience the tokens must be for if set
+	userClaim string // claim with the user name in
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey // signing keys by key ID
+	fetchedAt time.Time                 // when keys were fetched
+}
+
+// newOIDCVerifier makes a verifier for tokens from issuer
+func newOIDCVerifier(issuer, audience, userClaim string) *oidcVerifier {
+	return &oidcVerifier{
+		issuer:    strings.TrimSuffix(issuer, "/"),
+		audience:  audience,
+		userClaim: userClaim,
+	}
+}
+
+// looksLikeJWT returns true if token could be a JWT
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// getJSON reads the JSON at url into result
+func getJSON(ctx context.Context, url string, result interface{}) (err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	resp, err := fshttp.NewClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("HTTP error %s fetching %q", resp.Status, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// fetchKeys reads the signing keys from the issuer
+//
+// Call with v.mu held
+func (v *oidcVerifier) fetchKeys(ctx context.Context) error {
+	v.fetchedAt = time.Now()
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err := getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return errors.Wrap(err, "OIDC: failed to read discovery document")
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != v.issuer || discovery.JWKSURI == "" {
+		return errors.Errorf("OIDC: bad discovery document for issuer %q", v.issuer)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = getJSON(ctx, discovery.JWKSURI, &jwks)
+	if err != nil {
+		return errors.Wrap(err, "OIDC: failed to read signing keys")
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			fs.Errorf(nil, "OIDC: ignoring bad signing key %q", key.Kid)
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("OIDC: no RSA signing keys found")
+	}
+	v.keys = keys
+	return nil
+}
+
+// getKey returns the signing key with the key ID given, fetching
+// the keys if necessary
+func (v *oidcVerifier) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	if ok {
+		return key, nil
+	}
+	if time.Since(v.fetchedAt) < minKeyRefresh {
+		return nil, errors.Errorf("OIDC: unknown signing key %q", kid)
+	}
+	if err := v.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("OIDC: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// verify checks the token returning the user name from it
+func (v *oidcVerifier) verify(ctx context.Context, token string) (user string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("OIDC: malformed token")
+	}
+	var header jws.Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Algorithm != "RS256" {
+		return "", errors.Errorf("OIDC: unsupported signing algorithm %q", header.Algorithm)
+	}
+	key, err := v.getKey(ctx, header.KeyID)
+	if err != nil {
+		return "", err
+	}
+	if err := jws.Verify(token, key); err != nil {
+		return "", errors.Wrap(err, "OIDC: bad token signature")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return "", errors.Errorf("OIDC: token is from issuer %q", iss)
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || exp < now {
+		return "", errors.New("OIDC: token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && nbf > now {
+		return "", errors.New("OIDC: token is not valid yet")
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return "", errors.Errorf("OIDC: token is not for audience %q", v.audience)
+	}
+	user, _ = claims[v.userClaim].(string)
+	if user == "" {
+		return "", errors.Errorf("OIDC: token has no %q claim", v.userClaim)
+	}
+	return user, nil
+}
+
+// decodeSegment decodes a base64 encoded JSON part of a JWT into result
+func decodeSegment(segment string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.Wrap(err, "OIDC: malformed token")
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.Wrap(err, "OIDC: malformed token")
+	}
+	return nil
+}
+
+// hasAudience returns true if the aud claim, which may be a string or
+// a list of strings, contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/jws"
+)
+
+// startOIDCServer starts an OpenID Connect issuer which signs with key
+func startOIDCServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":   server.URL,
+			"jwks_uri": server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	return server
+}
+
+// makeToken makes a signed token
+func makeToken(t *testing.T, key *rsa.PrivateKey, kid string, claims *jws.ClaimSet) string {
+	token, err := jws.Encode(&jws.Header{Algorithm: "RS256", Typ: "JWT", KeyID: kid}, claims, key)
+	require.NoError(t, err)
+	return token
+}
+
+func TestOIDC(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := startOIDCServer(t, key)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "rclone-auth-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	opt := DefaultOpt
+	opt.OIDCIssuer = server.URL + "/"
+	opt.OIDCAudience = "rclone"
+	db, err := New(ctx, nil, &opt)
+	require.NoError(t, err)
+	db.settings["*"] = userSettings{root: dir}
+
+	now := time.Now().Unix()
+	claims := func(modify func(c *jws.ClaimSet)) *jws.ClaimSet {
+		c := &jws.ClaimSet{
+			Iss: server.URL,
+			Aud: "rclone",
+			Exp: now + 3600,
+			Iat: now,
+			PrivateClaims: map[string]interface{}{
+				"preferred_username": "alice",
+			},
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	token := makeToken(t, key, "key1", claims(nil))
+	assert.True(t, looksLikeJWT(token))
+	assert.False(t, looksLikeJWT("password"))
+	name, err := db.oidc.verify(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	// Login with the token as the password
+	u, err := db.Login("", token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", u.Name)
+	u, err = db.Login("alice", token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", u.Name)
+	_, err = db.Login("bob", token)
+	assert.EqualError(t, err, `token is for user "alice" not "bob"`)
+
+	for _, test := range []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"expired", makeToken(t, key, "key1", claims(func(c *jws.ClaimSet) { c.Iat, c.Exp = now-3600, now-10 })), "OIDC: token has expired"},
+		{"issuer", makeToken(t, key, "key1", claims(func(c *jws.ClaimSet) { c.Iss = "https://example.com" })), `OIDC: token is from issuer "https://example.com"`},
+		{"audience", makeToken(t, key, "key1", claims(func(c *jws.ClaimSet) { c.Aud = "other" })), `OIDC: token is not for audience "rclone"`},
+		{"no user", makeToken(t, key, "key1", claims(func(c *jws.ClaimSet) { c.PrivateClaims = nil })), `OIDC: token has no "preferred_username" claim`},
+		{"signature", makeToken(t, otherKey, "key1", claims(nil)), "OIDC: bad token signature"},
+		{"key ID", makeToken(t, key, "key2", claims(nil)), `OIDC: unknown signing key "key2"`},
+		{"malformed", "eyJ.eyJ.sig", "OIDC: malformed token"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := db.Login("", test.token)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestHasAudience(t *testing.T) {
+	assert.True(t, hasAudience("rclone", "rclone"))
+	assert.False(t, hasAudience("other", "rclone"))
+	assert.True(t, hasAudience([]interface{}{"other", "rclone"}, "rclone"))
+	assert.False(t, hasAudience([]interface{}{"other"}, "rclone"))
+	assert.False(t, hasAudience(nil, "rclone"))
+}
@@ -125,6 +125,22 @@ func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fi
 		Size: uint64(fileInfo.Size()),
 	})
 
+	// Offer the transcoded versions of the media too
+	for _, t := range cds.transcoders {
+		if t.class != mediaType[1] {
+			continue
+		}
+		item.Res = append(item.Res, upnpav.Resource{
+			URL: (&url.URL{
+				Scheme:   "http",
+				Host:     host,
+				Path:     path.Join(resPath, cdsObject.Path),
+				RawQuery: url.Values{transcodeParam: {t.name}}.Encode(),
+			}).String(),
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", t.mimeType, t.contentFeatures()),
+		})
+	}
+
 	for _, resource := range resources {
 		subtitleURL := (&url.URL{
 			Scheme: "http",
@@ -134,6 +150,11 @@ func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fi
 		item.Res = append(item.Res, upnpav.Resource{
 			URL:          subtitleURL,
 			ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", "text/srt"),
+			Size:         uint64(resource.Size()),
+		})
+		item.CaptionInfo = append(item.CaptionInfo, upnpav.CaptionInfo{
+			Type: "srt",
+			URL:  subtitleURL,
 		})
 	}
 
@@ -227,6 +248,29 @@ func mediaWithResources(nodes vfs.Nodes) (vfs.Nodes, map[vfs.Node]vfs.Nodes) {
 	return media, mediaResources
 }
 
+// subtitlesFor finds the external subtitles of the media file node
+// at remotePath by looking through its directory.
+func (s *server) subtitlesFor(remotePath string, node vfs.Node) vfs.Nodes {
+	if !node.IsFile() {
+		return nil
+	}
+	parent, err := s.vfs.Stat(path.Dir(path.Join("/", remotePath)))
+	if err != nil || !parent.IsDir() {
+		return nil
+	}
+	dirEntries, err := parent.(*vfs.Dir).ReadDirAll()
+	if err != nil {
+		return nil
+	}
+	media, mediaResources := mediaWithResources(dirEntries)
+	for _, mediaNode := range media {
+		if mediaNode.Name() == node.Name() {
+			return mediaResources[mediaNode]
+		}
+	}
+	return nil
+}
+
 type browse struct {
 	ObjectID       string
 	BrowseFlag     string
@@ -305,8 +349,7 @@ func (cds *contentDirectoryService) Handle(action string, argsXML []byte, r *htt
 			if err != nil {
 				return nil, err
 			}
-			// TODO: External subtitles won't appear in the metadata here, but probably should.
-			upnpObject, err := cds.cdsObjectToUpnpavObject(obj, node, vfs.Nodes{}, host)
+			upnpObject, err := cds.cdsObjectToUpnpavObject(obj, node, cds.subtitlesFor(obj.Path, node), host)
 			if err != nil {
 				return nil, err
 			}
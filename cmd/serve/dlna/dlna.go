@@ -2,12 +2,14 @@ package dlna
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -16,7 +18,10 @@ import (
 	"github.com/anacrolix/dms/soap"
 	"github.com/anacrolix/dms/ssdp"
 	"github.com/anacrolix/dms/upnp"
+	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/dlna/data"
 	"github.com/rclone/rclone/cmd/serve/dlna/dlnaflags"
 	"github.com/rclone/rclone/fs"
@@ -28,6 +33,7 @@ import (
 func init() {
 	dlnaflags.AddFlags(Command.Flags())
 	vfsflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
 }
 
 // Command definition for cobra.
@@ -40,16 +46,20 @@ and play audio/video from it. VLC is also supported. Service discovery uses UDP
 packets (SSDP) and will thus only work on LANs.
 
 Rclone will list all files present in the remote, without filtering based on media formats or
-file extensions. Additionally, there is no media transcoding support. This means that some
-players might show files that they are not able to play back correctly.
+file extensions. Media is served as it is unless transcoding is configured with --transcode
+(see below). This means that some players might show files that they are not able to play
+back correctly.
 
-` + dlnaflags.Help + vfs.Help,
+` + dlnaflags.Help + auth.Help + vfs.Help,
 	Run: func(command *cobra.Command, args []string) {
 		cmd.CheckArgs(1, 1, command, args)
 		f := cmd.NewFsSrc(args)
 
 		cmd.Run(false, false, command, func() error {
-			s := newServer(f, &dlnaflags.Opt)
+			s, err := newServer(f, &dlnaflags.Opt, &authflags.Opt)
+			if err != nil {
+				return err
+			}
 			if err := s.Serve(); err != nil {
 				return err
 			}
@@ -86,11 +96,15 @@ type server struct {
 	// Time interval between SSPD announces
 	AnnounceInterval time.Duration
 
-	f   fs.Fs
-	vfs *vfs.VFS
+	// External commands to transcode media with
+	transcoders []*transcoder
+
+	f    fs.Fs
+	vfs  *vfs.VFS
+	user *auth.User // user from the user database being served if set
 }
 
-func newServer(f fs.Fs, opt *dlnaflags.Options) *server {
+func newServer(f fs.Fs, opt *dlnaflags.Options, authOpt *auth.Options) (*server, error) {
 	friendlyName := opt.FriendlyName
 	if friendlyName == "" {
 		friendlyName = makeDefaultFriendlyName()
@@ -104,8 +118,38 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) *server {
 
 		httpListenAddr: opt.ListenAddr,
 
-		f:   f,
-		vfs: vfs.New(f, &vfsflags.Opt),
+		f: f,
+	}
+
+	if authOpt != nil && authOpt.Enabled() {
+		return nil, errors.New("serve dlna has no logins so only --auth-users can be used")
+	}
+	if opt.AuthUser != "" {
+		if authOpt == nil || authOpt.Users == "" {
+			return nil, errors.New("--auth-user needs --auth-users")
+		}
+		db, err := auth.New(context.Background(), f, authOpt)
+		if err != nil {
+			return nil, err
+		}
+		s.user, err = db.User(opt.AuthUser)
+		if err != nil {
+			return nil, err
+		}
+		s.vfs = s.user.VFS
+	} else {
+		s.vfs = vfs.New(f, &vfsflags.Opt)
+	}
+
+	for _, spec := range opt.Transcode {
+		t, err := parseTranscoder(spec)
+		if err != nil {
+			return nil, err
+		}
+		if s.transcoder(t.name) != nil {
+			return nil, errors.Errorf("transcoder %q: duplicate name %q", spec, t.name)
+		}
+		s.transcoders = append(s.transcoders, t)
 	}
 
 	s.services = map[string]UPnPService{
@@ -136,7 +180,7 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) *server {
 			http.FileServer(data.Assets))))
 	s.handler = logging(withHeader("Server", serverField, r))
 
-	return s
+	return s, nil
 }
 
 // UPnPService is the interface for the SOAP service.
@@ -225,6 +269,9 @@ func (s *server) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte,
 
 // Serves actual resources (media files).
 func (s *server) resourceHandler(w http.ResponseWriter, r *http.Request) {
+	if s.user != nil {
+		w, r = s.user.LimitHTTP(w, r)
+	}
 	remotePath := r.URL.Path
 	node, err := s.vfs.Stat(r.URL.Path)
 	if err != nil {
@@ -232,6 +279,27 @@ func (s *server) resourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Samsung devices ask for the subtitles like this
+	if r.Header.Get("getCaptionInfo.sec") != "" {
+		if subtitles := s.subtitlesFor(remotePath, node); len(subtitles) > 0 {
+			w.Header().Set("CaptionInfo.sec", (&url.URL{
+				Scheme: "http",
+				Host:   r.Host,
+				Path:   path.Join(resPath, subtitles[0].Path()),
+			}).String())
+		}
+	}
+
+	if name := r.URL.Query().Get(transcodeParam); name != "" {
+		t := s.transcoder(name)
+		if t == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveTranscoded(w, r, node, t)
+		return
+	}
+
 	w.Header().Set("Content-Length", strconv.FormatInt(node.Size(), 10))
 
 	// add some DLNA specific headers
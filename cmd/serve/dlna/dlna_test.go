@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/rclone/rclone/vfs"
 
 	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/auth"
 	"github.com/rclone/rclone/cmd/serve/dlna/dlnaflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
@@ -35,11 +38,30 @@ const (
 func startServer(t *testing.T, f fs.Fs) {
 	opt := dlnaflags.DefaultOpt
 	opt.ListenAddr = testBindAddress
-	dlnaServer = newServer(f, &opt)
+	var err error
+	dlnaServer, err = newServer(f, &opt, nil)
+	require.NoError(t, err)
 	assert.NoError(t, dlnaServer.Serve())
 	baseURL = "http://" + dlnaServer.HTTPConn.Addr().String()
 }
 
+// startTranscodeServer starts a separate server of the test files
+// with a transcoder which copies the files, returning its URL and a
+// function to stop it
+func startTranscodeServer(t *testing.T) (string, func()) {
+	f, err := fs.NewFs(context.Background(), "testdata/files")
+	require.NoError(t, err)
+	opt := dlnaflags.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	opt.Transcode = []string{"copy=video/mpeg;MPEG_PS_PAL cat"}
+	s, err := newServer(f, &opt, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	return "http://" + s.HTTPConn.Addr().String(), func() {
+		_ = s.HTTPConn.Close()
+	}
+}
+
 func TestInit(t *testing.T) {
 	config.LoadConfig(context.Background())
 
@@ -203,3 +225,191 @@ func TestContentDirectoryBrowseDirectChildren(t *testing.T) {
 	require.Contains(t, string(body), "/r/subdir/video.mp4")
 	require.Contains(t, string(body), "/r/subdir/video.srt")
 }
+
+func TestParseTranscoder(t *testing.T) {
+	tr, err := parseTranscoder(`mp4=video/mp4;AVC_MP4_BL_CIF15_AAC_520 ffmpeg -i {url} -f "mp4" -`)
+	require.NoError(t, err)
+	assert.Equal(t, "mp4", tr.name)
+	assert.Equal(t, "video/mp4", tr.mimeType)
+	assert.Equal(t, "AVC_MP4_BL_CIF15_AAC_520", tr.profile)
+	assert.Equal(t, "video", tr.class)
+	assert.Equal(t, []string{"ffmpeg", "-i", "{url}", "-f", "mp4", "-"}, tr.args)
+	assert.True(t, tr.useURL)
+	assert.Contains(t, tr.contentFeatures(), "DLNA.ORG_PN=AVC_MP4_BL_CIF15_AAC_520")
+	assert.Contains(t, tr.contentFeatures(), "DLNA.ORG_CI=1")
+
+	tr, err = parseTranscoder(`mp3=audio/mpeg lame - -`)
+	require.NoError(t, err)
+	assert.Equal(t, "", tr.profile)
+	assert.Equal(t, "audio", tr.class)
+	assert.False(t, tr.useURL)
+
+	for _, spec := range []string{
+		"",
+		"mp4",
+		"mp4=video/mp4",
+		"=video/mp4 ffmpeg",
+		"a/b=video/mp4 ffmpeg",
+		"mp4=text/plain cat",
+		"mp4=video/mp4;bad-profile ffmpeg",
+		`mp4=video/mp4 "ffmpeg`,
+	} {
+		_, err := parseTranscoder(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// Check that the transcoded versions of media are offered and served.
+func TestServeTranscoded(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat not found")
+	}
+	transcodeURL, stop := startTranscodeServer(t)
+	defer stop()
+
+	req, err := http.NewRequest("GET", transcodeURL+resPath+"video.mp4?transcode=copy", nil)
+	require.NoError(t, err)
+	req.Header.Set("getContentFeatures.dlna.org", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "video/mpeg", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("contentFeatures.dlna.org"), "DLNA.ORG_PN=MPEG_PS_PAL")
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	golden, err := ioutil.ReadFile("testdata/files/video.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, golden, body)
+
+	// Unknown transcoders aren't found
+	resp, err = http.Get(transcodeURL + resPath + "video.mp4?transcode=missing")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// browseObject makes a ContentDirectory#Browse request to the server
+// at baseURL returning the body
+func browseObject(t *testing.T, baseURL, objectID, flag string) string {
+	req, err := http.NewRequest("POST", baseURL+serviceControlURL, strings.NewReader(`
+<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"
+            s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+    <s:Body>
+        <u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+            <ObjectID>`+objectID+`</ObjectID>
+            <BrowseFlag>`+flag+`</BrowseFlag>
+            <Filter>*</Filter>
+            <StartingIndex>0</StartingIndex>
+            <RequestedCount>0</RequestedCount>
+            <SortCriteria></SortCriteria>
+        </u:Browse>
+    </s:Body>
+</s:Envelope>`))
+	require.NoError(t, err)
+	req.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return html.UnescapeString(string(body))
+}
+
+// Check that media items offer the transcoded versions and subtitles.
+func TestContentDirectoryBrowseMedia(t *testing.T) {
+	// without transcoders only the subtitles are offered
+	body := browseObject(t, baseURL, "%2Fvideo.mp4", "BrowseMetadata")
+	assert.Contains(t, body, "/r/video.srt</res>")
+	assert.NotContains(t, body, "transcode=")
+
+	transcodeURL, stop := startTranscodeServer(t)
+	defer stop()
+
+	for _, flag := range []string{"BrowseDirectChildren", "BrowseMetadata"} {
+		objectID := "0"
+		if flag == "BrowseMetadata" {
+			objectID = "%2Fvideo.mp4"
+		}
+		body := browseObject(t, transcodeURL, objectID, flag)
+		assert.Contains(t, body, "/r/video.mp4?transcode=copy</res>", flag)
+		assert.Contains(t, body, `protocolInfo="http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_PAL;`, flag)
+		assert.Contains(t, body, "/r/video.srt</res>", flag)
+		assert.Contains(t, body, "/r/video.en.srt</res>", flag)
+		assert.Contains(t, body, `<sec:CaptionInfoEx sec:type="srt">`, flag)
+		assert.Contains(t, body, `xmlns:sec="http://www.sec.co.kr/"`, flag)
+	}
+
+	// images don't get the video transcoder
+	body = browseObject(t, transcodeURL, "%2Fsmall_jpeg.jpg", "BrowseMetadata")
+	assert.Contains(t, body, "/r/small_jpeg.jpg</res>")
+	assert.NotContains(t, body, "transcode=")
+}
+
+// Check that Samsung devices are told where the subtitles are.
+func TestCaptionInfo(t *testing.T) {
+	req, err := http.NewRequest("HEAD", baseURL+resPath+"subdir/video.mp4", nil)
+	require.NoError(t, err)
+	req.Header.Set("getCaptionInfo.sec", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, baseURL+resPath+"subdir/video.srt", resp.Header.Get("CaptionInfo.sec"))
+
+	// Not without the request header
+	resp, err = http.Head(baseURL + resPath + "subdir/video.mp4")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "", resp.Header.Get("CaptionInfo.sec"))
+}
+
+// TestAuthUser checks the root of the user given with --auth-user is
+// served
+func TestAuthUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-dlna-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	subdir, err := filepath.Abs("testdata/files/subdir")
+	require.NoError(t, err)
+	users := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte(`alice "root=`+subdir+`"`+"\n"), 0600))
+
+	f, err := fs.NewFs(context.Background(), "testdata/files")
+	require.NoError(t, err)
+	opt := dlnaflags.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	opt.AuthUser = "alice"
+	authOpt := auth.DefaultOpt
+	_, err = newServer(f, &opt, &authOpt)
+	assert.Error(t, err, "--auth-user needs --auth-users")
+
+	authOpt.Users = users
+	s, err := newServer(f, &opt, &authOpt)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	defer func() {
+		_ = s.HTTPConn.Close()
+	}()
+	url := "http://" + s.HTTPConn.Addr().String() + resPath
+
+	// the subdirectory is the root
+	resp, err := http.Get(url + "video.srt")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	want, err := ioutil.ReadFile("testdata/files/subdir/video.srt")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(body))
+
+	resp, err = http.Get(url + "small_jpeg.jpg")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
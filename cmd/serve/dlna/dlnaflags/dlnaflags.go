@@ -19,6 +19,43 @@ default "rclone (hostname)".
 
 Use ` + "`--log-trace` in conjunction with `-vv`" + ` to enable additional debug
 logging of all UPNP traffic.
+
+### Users
+
+DLNA devices don't log in, so the server can only serve one user of
+the user database. Use ` + "`--auth-user NAME`" + ` with ` + "`--auth-users FILE`" + ` to
+serve the root of user NAME from the file instead of the remote on the
+command line, with that user's bandwidth limit.
+
+### Subtitles
+
+External subtitles in ` + "`.srt`" + ` files are served along with the media
+file with the same name, so ` + "`video.srt` and `video.en.srt`" + ` are used for
+` + "`video.mp4`" + `. They are listed as resources of the media and also
+advertised in the way Samsung TVs expect.
+
+### Transcoding
+
+Use ` + "`--transcode`" + ` to give devices which can't play the original
+files another version of them made on the fly by an external command,
+such as ffmpeg. It can be given more than once to offer several media
+profiles, which are listed as extra resources of each media file so
+the device can choose one it can play. Each is of the form
+
+    NAME=MIME-TYPE[;DLNA-PROFILE] COMMAND ARGS...
+
+NAME is a short name for the profile, MIME-TYPE is the type of media
+the command makes and DLNA-PROFILE is an optional DLNA.ORG_PN profile
+name for it. A profile is offered for the media of the same type, so a
+` + "`video/...`" + ` profile is offered for videos. Any argument containing
+` + "`{url}`" + ` has it replaced with a URL the command can read the original
+file from, with seeking, otherwise the file is supplied on standard
+input. The command should write the result to standard output and is
+stopped if the device disconnects. For example
+
+    --transcode "mp4=video/mp4 ffmpeg -i {url} -c:v libx264 -c:a aac -f mp4 -movflags frag_keyframe+empty_moov -"
+
+Transcoded streams don't support seeking.
 `
 
 // Options is the type for DLNA serving options.
@@ -26,6 +63,8 @@ type Options struct {
 	ListenAddr   string
 	FriendlyName string
 	LogTrace     bool
+	Transcode    []string
+	AuthUser     string // user of the --auth-users file to serve
 }
 
 // DefaultOpt contains the defaults options for DLNA serving.
@@ -45,6 +84,8 @@ func addFlagsPrefix(flagSet *pflag.FlagSet, prefix string, Opt *Options) {
 	flags.StringVarP(flagSet, &Opt.ListenAddr, prefix+"addr", "", Opt.ListenAddr, "ip:port or :port to bind the DLNA http server to.")
 	flags.StringVarP(flagSet, &Opt.FriendlyName, prefix+"name", "", Opt.FriendlyName, "name of DLNA server")
 	flags.BoolVarP(flagSet, &Opt.LogTrace, prefix+"log-trace", "", Opt.LogTrace, "enable trace logging of SOAP traffic")
+	flags.StringArrayVarP(flagSet, &Opt.Transcode, prefix+"transcode", "", Opt.Transcode, "NAME=MIME-TYPE COMMAND to transcode media for devices (can be repeated)")
+	flags.StringVarP(flagSet, &Opt.AuthUser, prefix+"auth-user", "", Opt.AuthUser, "User from --auth-users to serve")
 }
 
 // AddFlags add the command line flags for DLNA serving.
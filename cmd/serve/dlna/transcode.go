@@ -0,0 +1,194 @@
+package dlna
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	dms_dlna "github.com/anacrolix/dms/dlna"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// transcodeParam is the query parameter naming the transcoder for a resource
+const transcodeParam = "transcode"
+
+// maxTranscodeStderr is how much of the output of a failed
+// transcoder is logged
+const maxTranscodeStderr = 4096
+
+// transcoder is an external command which converts media into
+// another format as it is served
+type transcoder struct {
+	name     string   // name in the resource URL
+	mimeType string   // mime type of the output
+	profile  string   // DLNA.ORG_PN profile of the output if known
+	class    string   // media class it applies to, eg "video"
+	args     []string // the command and its arguments
+	useURL   bool     // set if the arguments contain {url}
+}
+
+var (
+	transcoderNameRegexp    = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	transcoderProfileRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// parseTranscoder parses a --transcode flag of the form
+//
+//     NAME=MIME-TYPE[;DLNA-PROFILE] COMMAND ARGS...
+func parseTranscoder(spec string) (*transcoder, error) {
+	equals := strings.IndexRune(spec, '=')
+	if equals < 0 {
+		return nil, errors.Errorf("transcoder %q: need NAME=MIME-TYPE COMMAND", spec)
+	}
+	t := &transcoder{name: spec[:equals]}
+	if !transcoderNameRegexp.MatchString(t.name) {
+		return nil, errors.Errorf("transcoder %q: bad name %q", spec, t.name)
+	}
+	var words fs.SpaceSepList
+	err := words.Set(strings.TrimSpace(spec[equals+1:]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "transcoder %q", spec)
+	}
+	if len(words) < 2 {
+		return nil, errors.Errorf("transcoder %q: need NAME=MIME-TYPE COMMAND", spec)
+	}
+	t.mimeType, t.args = words[0], words[1:]
+	if semicolon := strings.IndexRune(t.mimeType, ';'); semicolon >= 0 {
+		t.mimeType, t.profile = t.mimeType[:semicolon], t.mimeType[semicolon+1:]
+		if !transcoderProfileRegexp.MatchString(t.profile) {
+			return nil, errors.Errorf("transcoder %q: bad DLNA profile %q", spec, t.profile)
+		}
+	}
+	mediaType := mediaMimeTypeRegexp.FindStringSubmatch(t.mimeType)
+	if mediaType == nil {
+		return nil, errors.Errorf("transcoder %q: mime type %q must be video, audio or image", spec, t.mimeType)
+	}
+	t.class = mediaType[1]
+	for _, arg := range t.args {
+		if strings.Contains(arg, "{url}") {
+			t.useURL = true
+		}
+	}
+	return t, nil
+}
+
+// contentFeatures returns the DLNA content features of the output
+func (t *transcoder) contentFeatures() string {
+	return dms_dlna.ContentFeatures{
+		ProfileName: t.profile,
+		Transcoded:  true,
+	}.String()
+}
+
+// transcoder finds the transcoder called name or returns nil
+func (s *server) transcoder(name string) *transcoder {
+	for _, t := range s.transcoders {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// localResourceURL returns a URL for the resource at remotePath
+// which the transcoders can use to read it from this server
+func (s *server) localResourceURL(remotePath string) string {
+	host := "localhost"
+	port := 0
+	if addr, ok := s.HTTPConn.Addr().(*net.TCPAddr); ok {
+		port = addr.Port
+		switch {
+		case addr.IP == nil || addr.IP.IsUnspecified():
+			host = "127.0.0.1"
+			if addr.IP != nil && addr.IP.To4() == nil {
+				host = "::1"
+			}
+		default:
+			host = addr.IP.String()
+		}
+	}
+	return (&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(host, fmt.Sprint(port)),
+		Path:   path.Join(resPath, remotePath),
+	}).String()
+}
+
+// serveTranscoded serves the file in node converted by the transcoder t
+func (s *server) serveTranscoded(w http.ResponseWriter, r *http.Request, node vfs.Node, t *transcoder) {
+	file, ok := node.(*vfs.File)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", t.mimeType)
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	if r.Header.Get("getContentFeatures.dlna.org") != "" {
+		w.Header().Set("contentFeatures.dlna.org", t.contentFeatures())
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+
+	args := make([]string, len(t.args))
+	inURL := s.localResourceURL(node.Path())
+	for i, arg := range t.args {
+		args[i] = strings.Replace(arg, "{url}", inURL, -1)
+	}
+	cmd := exec.CommandContext(r.Context(), args[0], args[1:]...)
+	if !t.useURL {
+		in, err := file.Open(os.O_RDONLY)
+		if err != nil {
+			serveError(node, w, "Could not open resource", err)
+			return
+		}
+		defer func() {
+			if err := in.Close(); err != nil {
+				fs.Errorf(node, "Failed to close file: %v", err)
+			}
+		}()
+		cmd.Stdin = in
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxTranscodeStderr}
+	cmd.Stdout = w
+	fs.Infof(node, "Transcoding with %q", t.name)
+	err := cmd.Run()
+	if r.Context().Err() != nil {
+		fs.Debugf(node, "Transcoding with %q stopped as the client went away", t.name)
+	} else if err != nil {
+		fs.Errorf(node, "Transcoding with %q failed: %v: %s", t.name, err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// limitedWriter writes the first n bytes to w and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write writes p to the underlying writer until the limit is reached
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	if len(p) > 0 {
+		n, err := l.w.Write(p)
+		l.n -= n
+		if err != nil {
+			return n, err
+		}
+	}
+	return written, nil
+}
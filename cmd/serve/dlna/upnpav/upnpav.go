@@ -21,6 +21,13 @@ type Resource struct {
 	Resolution   string   `xml:"resolution,attr,omitempty"`
 }
 
+// CaptionInfo describes external subtitles in the Samsung extension
+type CaptionInfo struct {
+	XMLName xml.Name `xml:"sec:CaptionInfoEx"`
+	Type    string   `xml:"sec:type,attr"`
+	URL     string   `xml:",chardata"`
+}
+
 // Container description
 type Container struct {
 	Object
@@ -31,9 +38,10 @@ type Container struct {
 // Item description
 type Item struct {
 	Object
-	XMLName  xml.Name `xml:"item"`
-	Res      []Resource
-	InnerXML string `xml:",innerxml"`
+	XMLName     xml.Name `xml:"item"`
+	Res         []Resource
+	CaptionInfo []CaptionInfo
+	InnerXML    string `xml:",innerxml"`
 }
 
 // Object description
@@ -12,10 +12,13 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
@@ -40,6 +43,8 @@ type Options struct {
 	BasicPass    string // password for BasicUser
 	TLSCert      string // TLS PEM key (concatenation of certificate and CA certificate)
 	TLSKey       string // TLS PEM Private key
+	ExplicitTLS  bool   // use explicit FTPS (AUTH TLS) rather than implicit
+	UsersFile    string // file of users with their passwords and remotes
 }
 
 // DefaultOpt is the default values used for Options
@@ -64,11 +69,14 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &Opt.BasicPass, "pass", "", Opt.BasicPass, "Password for authentication. (empty value allow every password)")
 	flags.StringVarP(flagSet, &Opt.TLSCert, "cert", "", Opt.TLSCert, "TLS PEM key (concatenation of certificate and CA certificate)")
 	flags.StringVarP(flagSet, &Opt.TLSKey, "key", "", Opt.TLSKey, "TLS PEM Private key")
+	flags.BoolVarP(flagSet, &Opt.ExplicitTLS, "explicit-tls", "", Opt.ExplicitTLS, "Use explicit FTPS (AUTH TLS) rather than implicit FTPS.")
+	flags.StringVarP(flagSet, &Opt.UsersFile, "users-file", "", Opt.UsersFile, "File of users with their passwords and the remotes to serve them.")
 }
 
 func init() {
 	vfsflags.AddFlags(Command.Flags())
 	proxyflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
 	AddFlags(Command.Flags())
 }
 
@@ -90,16 +98,51 @@ IPs.  By default it only listens on localhost.  You can use port
 
 If you set --addr to listen on a public or LAN accessible IP address
 then using Authentication is advised - see the next section for info.
+IPv6 addresses should be given in brackets, e.g. --addr [::]:2121.
+
+Data connections are made in passive mode on a port chosen from
+--passive-port (default 30000-32000), so open this range in any
+firewall. When the server is behind NAT use --public-ip to give the
+IPv4 address clients should connect to for PASV. Clients connecting
+over IPv6 must use EPSV, which all modern clients do.
+
+#### TLS (FTPS)
+
+Use --cert and --key to serve FTP over TLS. By default this is
+implicit FTPS, where the connection is TLS from the start, usually on
+port 990. Add --explicit-tls to use explicit FTPS instead, where the
+client connects without TLS, usually on port 21, and upgrades the
+connection with AUTH TLS. In this mode logins are refused until the
+connection has been upgraded.
 
 #### Authentication
 
 By default this will serve files without needing a login.
 
 You can set a single username and password with the --user and --pass flags.
-` + vfs.Help + proxy.Help,
+
+To serve many users, each with their own remote or part of a remote,
+use --users-file to give a file with a line for each user of the form
+
+    USER PASSWORD REMOTE:PATH
+
+Lines starting with # are ignored and the fields may be quoted with
+double quotes if they contain spaces. PASSWORD is either a bcrypt
+hash, as made by "htpasswd -nB", or a password obscured with "rclone
+obscure". The remote:path argument isn't used with --users-file, e.g.
+
+    rclone serve ftp --users-file users.txt --addr :2121
+
+with users.txt containing
+
+    alice $2y$05$X...   s3:bucket/alice
+    bob   Ob5cUr3dpA55  "drive:Shared Files"
+
+Users can also be checked against the user database described below.
+` + vfs.Help + proxy.Help + auth.Help,
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if proxyflags.Opt.AuthProxy == "" && Opt.UsersFile == "" {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -123,7 +166,12 @@ type server struct {
 	opt    Options
 	vfs    *vfs.VFS
 	proxy  *proxy.Proxy
+	users  map[string]ftpUser // users from --users-file if set
+	authDB *auth.DB           // user database if set
 	useTLS bool
+
+	mu      sync.Mutex          // protects userVFS
+	userVFS map[string]*vfs.VFS // VFS for each remote in users
 }
 
 // Make a new FTP to serve the remote
@@ -136,15 +184,51 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (*server, error) {
 	if err != nil {
 		return nil, errors.New("Failed to parse host:port")
 	}
+	err = checkPassivePorts(opt.PassivePorts)
+	if err != nil {
+		return nil, err
+	}
+	if opt.PublicIP != "" {
+		if ip := net.ParseIP(opt.PublicIP); ip == nil || ip.To4() == nil {
+			return nil, errors.Errorf("--public-ip %q must be an IPv4 address", opt.PublicIP)
+		}
+	}
+	if (opt.TLSCert == "") != (opt.TLSKey == "") {
+		return nil, errors.New("--cert and --key must be used together")
+	}
+	if opt.ExplicitTLS && opt.TLSKey == "" {
+		return nil, errors.New("--explicit-tls needs --cert and --key")
+	}
 
 	s := &server{
 		f:   f,
 		ctx: ctx,
 		opt: *opt,
 	}
-	if proxyflags.Opt.AuthProxy != "" {
+	switch {
+	case proxyflags.Opt.AuthProxy != "":
+		if opt.UsersFile != "" {
+			return nil, errors.New("can't use --users-file and --auth-proxy together")
+		}
+		if authflags.Opt.Enabled() {
+			return nil, errors.New("can't use --auth-proxy with the user database")
+		}
 		s.proxy = proxy.New(ctx, &proxyflags.Opt)
-	} else {
+	case authflags.Opt.Enabled():
+		if opt.UsersFile != "" {
+			return nil, errors.New("can't use --users-file with the user database")
+		}
+		s.authDB, err = auth.New(ctx, f, &authflags.Opt)
+		if err != nil {
+			return nil, err
+		}
+	case opt.UsersFile != "":
+		s.users, err = loadUsers(opt.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		s.userVFS = make(map[string]*vfs.VFS)
+	default:
 		s.vfs = vfs.New(f, &vfsflags.Opt)
 	}
 	s.useTLS = s.opt.TLSKey != ""
@@ -162,21 +246,38 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (*server, error) {
 		TLS:            s.useTLS,
 		CertFile:       s.opt.TLSCert,
 		KeyFile:        s.opt.TLSKey,
+		ExplicitFTPS:   s.opt.ExplicitTLS,
 		//TODO implement a maximum of https://godoc.org/goftp.io/server#ServerOpts
 	}
 	s.srv = ftp.NewServer(ftpopt)
 	return s, nil
 }
 
+// checkPassivePorts checks the --passive-port range is valid
+func checkPassivePorts(passivePorts string) error {
+	if passivePorts == "" {
+		return nil
+	}
+	portRange := strings.Split(passivePorts, "-")
+	if len(portRange) == 2 {
+		minPort, minErr := strconv.Atoi(strings.TrimSpace(portRange[0]))
+		maxPort, maxErr := strconv.Atoi(strings.TrimSpace(portRange[1]))
+		if minErr == nil && maxErr == nil && 0 < minPort && minPort < maxPort && maxPort <= 65535 {
+			return nil
+		}
+	}
+	return errors.Errorf("--passive-port %q must be a range of ports like 30000-32000", passivePorts)
+}
+
 // serve runs the ftp server
 func (s *server) serve() error {
-	fs.Logf(s.f, "Serving FTP on %s", s.srv.Hostname+":"+strconv.Itoa(s.srv.Port))
+	fs.Logf(s.f, "Serving FTP on %s", net.JoinHostPort(s.srv.Hostname, strconv.Itoa(s.srv.Port)))
 	return s.srv.ListenAndServe()
 }
 
 // serve runs the ftp server
 func (s *server) close() error {
-	fs.Logf(s.f, "Stopping FTP on %s", s.srv.Hostname+":"+strconv.Itoa(s.srv.Port))
+	fs.Logf(s.f, "Stopping FTP on %s", net.JoinHostPort(s.srv.Hostname, strconv.Itoa(s.srv.Port)))
 	return s.srv.Shutdown()
 }
 
@@ -230,6 +331,7 @@ func (s *server) NewDriver() (ftp.Driver, error) {
 type Driver struct {
 	s    *server
 	vfs  *vfs.VFS
+	user *auth.User // user from the user database if set
 	lock sync.Mutex
 }
 
@@ -244,6 +346,21 @@ func (d *Driver) CheckPasswd(user, pass string) (ok bool, err error) {
 			return false, nil
 		}
 		d.vfs = VFS
+	} else if s.users != nil {
+		VFS, err := s.checkUser(user, pass)
+		if err != nil {
+			fs.Infof(nil, "login failed: %v", err)
+			return false, nil
+		}
+		d.vfs = VFS
+	} else if s.authDB != nil {
+		u, err := s.authDB.Login(user, pass)
+		if err != nil {
+			fs.Infof(nil, "login failed: %v", err)
+			return false, nil
+		}
+		d.vfs = u.VFS
+		d.user = u
 	} else {
 		ok = s.opt.BasicUser == user && (s.opt.BasicPass == "" || s.opt.BasicPass == pass)
 		if !ok {
@@ -403,6 +520,13 @@ func (d *Driver) GetFile(path string, offset int64) (size int64, fr io.ReadClose
 	tr := accounting.GlobalStats().NewTransferRemoteSize(path, node.Size())
 	defer tr.Done(d.s.ctx, nil)
 
+	if d.user != nil {
+		fr = struct {
+			io.Reader
+			io.Closer
+		}{d.user.LimitReader(d.s.ctx, handle), handle}
+		return node.Size(), fr, nil
+	}
 	return node.Size(), handle, nil
 }
 
@@ -411,6 +535,9 @@ func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64,
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	defer log.Trace(path, "append=%v", appendData)("err = %v", &err)
+	if d.user != nil {
+		data = d.user.LimitReader(d.s.ctx, data)
+	}
 	var isExist bool
 	node, err := d.vfs.Stat(path)
 	if err == nil {
@@ -0,0 +1,136 @@
+//+build !plan9,go1.13
+
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ftpUser is a user read from the --users-file
+type ftpUser struct {
+	password string // bcrypt hash or obscured password
+	remote   string // remote:path to serve to the user
+}
+
+// loadUsers reads the --users-file
+func loadUsers(usersFile string) (map[string]ftpUser, error) {
+	data, err := ioutil.ReadFile(usersFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read --users-file")
+	}
+	users, err := parseUsers(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse --users-file %q", usersFile)
+	}
+	fs.Infof(nil, "Loaded %d users from %q", len(users), usersFile)
+	return users, nil
+}
+
+// parseUsers parses lines of "USER PASSWORD REMOTE:PATH"
+func parseUsers(data []byte) (map[string]ftpUser, error) {
+	users := make(map[string]ftpUser)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNumber)
+		}
+		if len(fields) != 3 {
+			return nil, errors.Errorf("line %d: need USER PASSWORD REMOTE:PATH", lineNumber)
+		}
+		user, password, remote := fields[0], fields[1], fields[2]
+		if _, found := users[user]; found {
+			return nil, errors.Errorf("line %d: duplicate user %q", lineNumber, user)
+		}
+		if !isBcrypt(password) {
+			if _, err := obscure.Reveal(password); err != nil {
+				return nil, errors.Wrapf(err, "line %d: password must be a bcrypt hash or obscured", lineNumber)
+			}
+		}
+		users[user] = ftpUser{
+			password: password,
+			remote:   remote,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// splitFields splits line into space separated fields which may be
+// quoted, allowing more than one space between them
+func splitFields(line string) (fields []string, err error) {
+	var list fs.SpaceSepList
+	err = list.Set(line)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range list {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// isBcrypt returns true if password is a bcrypt hash
+func isBcrypt(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$")
+}
+
+// checkPassword checks pass is the password for the user
+func (u ftpUser) checkPassword(pass string) bool {
+	if isBcrypt(u.password) {
+		return bcrypt.CompareHashAndPassword([]byte(u.password), []byte(pass)) == nil
+	}
+	password, err := obscure.Reveal(u.password)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1
+}
+
+// checkUser checks the user and password against the --users-file
+// returning the VFS to serve the user
+func (s *server) checkUser(user, pass string) (*vfs.VFS, error) {
+	u, found := s.users[user]
+	if !found {
+		return nil, errors.Errorf("unknown user %q", user)
+	}
+	if !u.checkPassword(pass) {
+		return nil, errors.Errorf("bad password for user %q", user)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if VFS, ok := s.userVFS[u.remote]; ok {
+		return VFS, nil
+	}
+	f, err := fs.NewFs(s.ctx, u.remote)
+	if err == fs.ErrorIsFile {
+		return nil, errors.Errorf("remote %q for user %q is a file", u.remote, user)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to make remote %q for user %q", u.remote, user)
+	}
+	fs.Debugf(f, "Serving user %q", user)
+	VFS := vfs.New(f, &vfsflags.Opt)
+	s.userVFS[u.remote] = VFS
+	return VFS, nil
+}
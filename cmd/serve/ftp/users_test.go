@@ -0,0 +1,187 @@
+//+build !windows,!darwin,!plan9,go1.13
+
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ftpclient "github.com/jlaffaye/ftp"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ftp "goftp.io/server/core"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	data := "# comment\n\nalice " + string(hash) + "   remote:alice\n" +
+		`bob   ` + obscure.MustObscure("potato") + ` "remote:with space"` + "\n"
+	users, err := parseUsers([]byte(data))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(users))
+	assert.Equal(t, "remote:alice", users["alice"].remote)
+	assert.True(t, users["alice"].checkPassword("secret"))
+	assert.False(t, users["alice"].checkPassword("potato"))
+	assert.Equal(t, "remote:with space", users["bob"].remote)
+	assert.True(t, users["bob"].checkPassword("potato"))
+	assert.False(t, users["bob"].checkPassword("secret"))
+	assert.False(t, users["bob"].checkPassword(""))
+
+	for _, bad := range []string{
+		"alice",
+		"alice " + string(hash),
+		"alice " + string(hash) + " remote: extra",
+		"alice notobscured remote:",
+		"alice " + string(hash) + " remote:\nalice " + string(hash) + " remote:",
+		`alice "unterminated remote:`,
+	} {
+		_, err := parseUsers([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCheckPassivePorts(t *testing.T) {
+	for _, good := range []string{"", "30000-32000", "1-2", " 1000 - 2000 "} {
+		assert.NoError(t, checkPassivePorts(good), good)
+	}
+	for _, bad := range []string{"30000", "2000-1000", "1000-1000", "0-10", "1-65536", "a-b", "1-2-3"} {
+		assert.Error(t, checkPassivePorts(bad), bad)
+	}
+}
+
+// writeTestCert writes a self signed certificate and key for
+// localhost into dir returning their file names
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+// TestUsersFileExplicitTLS runs a server with a users file and
+// explicit TLS and checks the users get their own remotes
+func TestUsersFileExplicitTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-ftp")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, user := range []string{"alice", "bob"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, user), 0777))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("alicepass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	usersFile := filepath.Join(dir, "users.txt")
+	require.NoError(t, ioutil.WriteFile(usersFile, []byte(
+		"alice "+string(hash)+" "+filepath.Join(dir, "alice")+"\n"+
+			"bob "+obscure.MustObscure("bobpass")+" "+filepath.Join(dir, "bob")+"\n"), 0600))
+	certFile, keyFile := writeTestCert(t, dir)
+
+	const addr = testHOST + ":51781"
+	opt := DefaultOpt
+	opt.ListenAddr = addr
+	opt.PassivePorts = testPASSIVEPORTRANGE
+	opt.UsersFile = usersFile
+	opt.TLSCert = certFile
+	opt.TLSKey = keyFile
+	opt.ExplicitTLS = true
+	s, err := newServer(context.Background(), nil, &opt)
+	require.NoError(t, err)
+	quit := make(chan struct{})
+	go func() {
+		err := s.serve()
+		close(quit)
+		if err != ftp.ErrServerClosed {
+			assert.NoError(t, err)
+		}
+	}()
+	defer func() {
+		assert.NoError(t, s.close())
+		<-quit
+	}()
+
+	// dial connects to the server waiting for it to start
+	dial := func(options ...ftpclient.DialOption) (c *ftpclient.ServerConn) {
+		options = append(options, ftpclient.DialWithTimeout(10*time.Second))
+		for i := 0; i < 50; i++ {
+			c, err = ftpclient.Dial(addr, options...)
+			if err == nil {
+				return c
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		require.NoError(t, err)
+		return nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	for _, test := range []struct {
+		user, pass string
+	}{
+		{"alice", "alicepass"},
+		{"bob", "bobpass"},
+	} {
+		c := dial(ftpclient.DialWithExplicitTLS(tlsConfig))
+		require.NoError(t, c.Login(test.user, test.pass))
+		require.NoError(t, c.Stor("hello.txt", bytes.NewBufferString("hello "+test.user)))
+		entries, err := c.List("/")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(entries))
+		assert.Equal(t, "hello.txt", entries[0].Name)
+		require.NoError(t, c.Quit())
+		data, err := ioutil.ReadFile(filepath.Join(dir, test.user, "hello.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello "+test.user, string(data))
+	}
+
+	// Bad passwords and unknown users are refused
+	for _, test := range []struct {
+		user, pass string
+	}{
+		{"alice", "bobpass"},
+		{"bob", "alicepass"},
+		{"carol", "alicepass"},
+	} {
+		c := dial(ftpclient.DialWithExplicitTLS(tlsConfig))
+		assert.Error(t, c.Login(test.user, test.pass), test.user)
+		_ = c.Quit()
+	}
+
+	// Logins without TLS are refused
+	c := dial()
+	assert.Error(t, c.Login("alice", "alicepass"))
+	_ = c.Quit()
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"path"
@@ -8,7 +9,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/cmd/serve/httplib/httpflags"
 	"github.com/rclone/rclone/cmd/serve/httplib/serve"
@@ -22,6 +26,7 @@ import (
 func init() {
 	httpflags.AddFlags(Command.Flags())
 	vfsflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
 }
 
 // Command definition for cobra
@@ -39,13 +44,16 @@ The server will log errors.  Use -v to see access logs.
 
 --bwlimit will be respected for file transfers.  Use --stats to
 control the stats printing.
-` + httplib.Help + vfs.Help,
+` + httplib.Help + serve.ArchiveHelp + vfs.Help + auth.Help,
 	Run: func(command *cobra.Command, args []string) {
 		cmd.CheckArgs(1, 1, command, args)
 		f := cmd.NewFsSrc(args)
 		cmd.Run(false, true, command, func() error {
-			s := newServer(f, &httpflags.Opt)
-			err := s.Serve()
+			s, err := newServer(f, &httpflags.Opt)
+			if err != nil {
+				return err
+			}
+			err = s.Serve()
 			if err != nil {
 				return err
 			}
@@ -58,19 +66,49 @@ control the stats printing.
 // server contains everything to run the server
 type server struct {
 	*httplib.Server
-	f   fs.Fs
-	vfs *vfs.VFS
+	f      fs.Fs
+	vfs    *vfs.VFS // don't use directly, use getVFS
+	authDB *auth.DB // user database if set
 }
 
-func newServer(f fs.Fs, opt *httplib.Options) *server {
+func newServer(f fs.Fs, opt *httplib.Options) (*server, error) {
 	mux := http.NewServeMux()
 	s := &server{
-		Server: httplib.NewServer(mux, opt),
-		f:      f,
-		vfs:    vfs.New(f, &vfsflags.Opt),
+		f: f,
+	}
+	if authflags.Opt.Enabled() {
+		var err error
+		s.authDB, err = auth.New(context.Background(), f, &authflags.Opt)
+		if err != nil {
+			return nil, err
+		}
+		// override auth
+		copyOpt := *opt
+		copyOpt.Auth = s.auth
+		opt = &copyOpt
+	} else {
+		s.vfs = vfs.New(f, &vfsflags.Opt)
 	}
+	s.Server = httplib.NewServer(mux, opt)
 	mux.HandleFunc(s.Opt.BaseURL+"/", s.handler)
-	return s
+	return s, nil
+}
+
+// auth checks the user with the user database
+func (s *server) auth(user, pass string) (value interface{}, err error) {
+	return s.authDB.Login(user, pass)
+}
+
+// getVFS returns the VFS in use for this request
+func (s *server) getVFS(ctx context.Context) (*vfs.VFS, error) {
+	if s.vfs != nil {
+		return s.vfs, nil
+	}
+	u, ok := ctx.Value(httplib.ContextAuthKey).(*auth.User)
+	if !ok {
+		return nil, errors.New("no user found in context")
+	}
+	return u.VFS, nil
 }
 
 // Serve runs the http server in the background.
@@ -98,9 +136,14 @@ func (s *server) handler(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if u, ok := r.Context().Value(httplib.ContextAuthKey).(*auth.User); ok {
+		w, r = u.LimitHTTP(w, r)
+	}
 	isDir := strings.HasSuffix(urlPath, "/")
 	remote := strings.Trim(urlPath, "/")
-	if isDir {
+	if format := r.URL.Query().Get("archive"); format != "" {
+		s.serveArchive(w, r, remote, format)
+	} else if isDir {
 		s.serveDir(w, r, remote)
 	} else {
 		s.serveFile(w, r, remote)
@@ -109,8 +152,14 @@ func (s *server) handler(w http.ResponseWriter, r *http.Request) {
 
 // serveDir serves a directory index at dirRemote
 func (s *server) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string) {
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to serve directory: %v", err)
+		return
+	}
 	// List the directory
-	node, err := s.vfs.Stat(dirRemote)
+	node, err := VFS.Stat(dirRemote)
 	if err == vfs.ENOENT {
 		http.Error(w, "Directory not found", http.StatusNotFound)
 		return
@@ -149,9 +198,38 @@ func (s *server) serveDir(w http.ResponseWriter, r *http.Request, dirRemote stri
 	directory.Serve(w, r)
 }
 
+// serveArchive serves the directory at dirRemote as an archive
+func (s *server) serveArchive(w http.ResponseWriter, r *http.Request, dirRemote string, format string) {
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to serve archive: %v", err)
+		return
+	}
+	node, err := VFS.Stat(dirRemote)
+	if err == vfs.ENOENT {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		serve.Error(dirRemote, w, "Failed to find directory", err)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(w, "Not a directory", http.StatusNotFound)
+		return
+	}
+	serve.Archive(w, r, node.(*vfs.Dir), format)
+}
+
 // serveFile serves a file object at remote
 func (s *server) serveFile(w http.ResponseWriter, r *http.Request, remote string) {
-	node, err := s.vfs.Stat(remote)
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to serve file: %v", err)
+		return
+	}
+	node, err := VFS.Stat(remote)
 	if err == vfs.ENOENT {
 		fs.Infof(remote, "%s: File not found", r.RemoteAddr)
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -1,15 +1,24 @@
 package http
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"flag"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
@@ -33,7 +42,9 @@ func startServer(t *testing.T, f fs.Fs) {
 	opt := httplib.DefaultOpt
 	opt.ListenAddr = testBindAddress
 	opt.Template = testTemplate
-	httpServer = newServer(f, &opt)
+	var err error
+	httpServer, err = newServer(f, &opt)
+	require.NoError(t, err)
 	assert.NoError(t, httpServer.Serve())
 	testURL = httpServer.Server.URL()
 
@@ -226,6 +237,95 @@ func TestGET(t *testing.T) {
 	}
 }
 
+func TestArchive(t *testing.T) {
+	// The whole remote as a tar without the hidden files
+	resp, err := http.Get(testURL + "?archive=tar")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "attachment; filename=files.tar", resp.Header.Get("Content-Disposition"))
+	var names []string
+	tr := tar.NewReader(resp.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{"files/", "files/one%.txt", "files/three/", "files/three/a.txt", "files/three/b.txt", "files/two.txt"}, names)
+
+	// A directory as a zip
+	resp, err = http.Get(testURL + "three?archive=zip")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(zr.File))
+
+	// Files can't be archived
+	resp, err = http.Get(testURL + "two.txt?archive=zip")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestUserDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-http-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	sum := sha1.Sum([]byte("secret"))
+	htpasswd := filepath.Join(dir, "htpasswd")
+	require.NoError(t, ioutil.WriteFile(htpasswd, []byte("alice:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"), 0600))
+	users := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte("alice root=testdata/files/three\n"), 0600))
+	oldOpt := authflags.Opt
+	defer func() {
+		authflags.Opt = oldOpt
+	}()
+	authflags.Opt.Htpasswd = htpasswd
+	authflags.Opt.Users = users
+
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	s, err := newServer(httpServer.f, &opt)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	get := func(user, pass string) (int, string) {
+		req, err := http.NewRequest("GET", s.Server.URL()+"a.txt", nil)
+		require.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(body)
+	}
+	status, body := get("alice", "secret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "three\n", body)
+	status, _ = get("alice", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = get("bob", "secret")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = get("", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
 func TestFinalise(t *testing.T) {
 	httpServer.Close()
 	httpServer.Wait()
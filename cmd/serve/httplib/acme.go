@@ -0,0 +1,128 @@
+package httplib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEHelp contains text describing the automatic certificates to add
+// to the SSL/TLS section of the help
+var ACMEHelp = `
+##### Automatic certificates with ACME
+
+Instead of --cert and --key, use --acme-domain to fetch a certificate
+for a domain from Let's Encrypt, or another ACME certificate
+authority, when it is first needed and renew it automatically before
+it expires, e.g.
+
+    rclone serve webdav remote: --acme-domain files.example.com
+
+The domain must resolve to the machine rclone is running on. Repeat
+the flag to serve more than one domain. If --addr isn't set then
+rclone listens on :443 as the certificate authority checks the domain
+by connecting to port 443 (the TLS-ALPN-01 challenge). If rclone can't
+listen on port 443 then set --acme-http-addr, e.g. to :80, to answer
+the checks made over plain http on port 80 instead (the HTTP-01
+challenge). This also redirects plain http requests to https.
+
+The certificates and the account key are stored in --acme-cache-dir,
+which is in the rclone cache directory by default. Use --acme-email to
+give the certificate authority an address to warn you about problems
+with the certificates. By using --acme-domain you agree to the terms
+of service of the certificate authority.
+
+Use --acme-directory to use a different certificate authority, e.g.
+the Let's Encrypt staging server
+https://acme-staging-v02.api.letsencrypt.org/directory for testing.
+`
+
+// DefaultACMECacheDir returns the directory to keep the ACME
+// certificates in if --acme-cache-dir isn't set. It is set by
+// httpflags as this package can't import the config.
+var DefaultACMECacheDir = func() string { return "" }
+
+// acmeTimeout is the time allowed for the HTTP-01 challenge server
+// to read a request
+const acmeTimeout = 10 * time.Second
+
+// checkDomain checks domain is a plain domain name
+func checkDomain(domain string) error {
+	if domain == "" || strings.ContainsAny(domain, ":/ ") || net.ParseIP(domain) != nil {
+		return errors.Errorf("bad --acme-domain %q: must be a domain name without a port", domain)
+	}
+	return nil
+}
+
+// newACMEManager makes the ACME certificate manager from opt or
+// returns nil if ACME isn't configured
+func newACMEManager(opt *Options) (*autocert.Manager, error) {
+	if len(opt.ACMEDomains) == 0 {
+		if opt.ACMEHTTPAddr != "" {
+			return nil, errors.New("--acme-http-addr needs --acme-domain")
+		}
+		return nil, nil
+	}
+	if opt.SslCert != "" || opt.SslKey != "" {
+		return nil, errors.New("can't use --acme-domain with --cert and --key")
+	}
+	domains := make([]string, len(opt.ACMEDomains))
+	for i, domain := range opt.ACMEDomains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if err := checkDomain(domain); err != nil {
+			return nil, err
+		}
+		domains[i] = domain
+	}
+	cacheDir := opt.ACMECacheDir
+	if cacheDir == "" {
+		cacheDir = DefaultACMECacheDir()
+	}
+	if cacheDir == "" {
+		return nil, errors.New("--acme-domain needs --acme-cache-dir")
+	}
+	fs.Infof(nil, "Using ACME certificates for %s stored in %q", strings.Join(domains, ", "), cacheDir)
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      opt.ACMEEmail,
+		Client: &acme.Client{
+			DirectoryURL: opt.ACMEDirectory,
+			UserAgent:    fs.GetConfig(context.Background()).UserAgent,
+		},
+	}, nil
+}
+
+// serveACMEChallenges starts the server answering the HTTP-01
+// challenges on --acme-http-addr if set
+func (s *Server) serveACMEChallenges() error {
+	if s.acmeManager == nil || s.Opt.ACMEHTTPAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.Opt.ACMEHTTPAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen on --acme-http-addr")
+	}
+	s.acmeServer = &http.Server{
+		Handler:           s.acmeManager.HTTPHandler(nil),
+		ReadHeaderTimeout: acmeTimeout,
+		ReadTimeout:       acmeTimeout,
+		WriteTimeout:      acmeTimeout,
+	}
+	go func() {
+		err := s.acmeServer.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			fs.Errorf(nil, "Error on serving ACME challenges: %v", err)
+		}
+	}()
+	fs.Infof(nil, "Answering ACME challenges on %s", ln.Addr())
+	return nil
+}
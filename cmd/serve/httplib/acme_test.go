@@ -0,0 +1,146 @@
+package httplib
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewACMEManager(t *testing.T) {
+	// Not configured
+	m, err := newACMEManager(&Options{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	for _, test := range []struct {
+		opt     Options
+		wantErr string
+	}{
+		{Options{ACMEHTTPAddr: ":80"}, "--acme-http-addr needs --acme-domain"},
+		{Options{ACMEDomains: []string{"files.example.com"}, SslCert: "cert.pem", SslKey: "key.pem"}, "can't use --acme-domain with --cert and --key"},
+		{Options{ACMEDomains: []string{"files.example.com:443"}}, "bad --acme-domain"},
+		{Options{ACMEDomains: []string{"https://files.example.com"}}, "bad --acme-domain"},
+		{Options{ACMEDomains: []string{"192.168.1.1"}}, "bad --acme-domain"},
+		{Options{ACMEDomains: []string{""}}, "bad --acme-domain"},
+	} {
+		_, err := newACMEManager(&test.opt)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.wantErr)
+	}
+
+	oldDefaultACMECacheDir := DefaultACMECacheDir
+	defer func() {
+		DefaultACMECacheDir = oldDefaultACMECacheDir
+	}()
+	DefaultACMECacheDir = func() string { return "" }
+	_, err = newACMEManager(&Options{ACMEDomains: []string{"files.example.com"}})
+	assert.EqualError(t, err, "--acme-domain needs --acme-cache-dir")
+	DefaultACMECacheDir = func() string { return "/tmp/acme" }
+
+	m, err = newACMEManager(&Options{
+		ACMEDomains:   []string{"Files.Example.com.", "www.example.com"},
+		ACMEEmail:     "admin@example.com",
+		ACMEDirectory: "https://acme.example.com/directory",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, "admin@example.com", m.Email)
+	assert.Equal(t, "https://acme.example.com/directory", m.Client.DirectoryURL)
+	ctx := context.Background()
+	assert.NoError(t, m.HostPolicy(ctx, "files.example.com"))
+	assert.NoError(t, m.HostPolicy(ctx, "www.example.com"))
+	assert.Error(t, m.HostPolicy(ctx, "other.example.com"))
+}
+
+// writeACMECache writes a self signed certificate for domain into the
+// autocert cache in dir so no certificate authority is needed
+func writeACMECache(t *testing.T, dir, domain string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	require.NoError(t, pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, domain), buf.Bytes(), 0600))
+}
+
+func TestACMEServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-acme-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	const domain = "files.example.com"
+	writeACMECache(t, dir, domain)
+
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	opt.ACMEDomains = []string{domain}
+	opt.ACMECacheDir = dir
+	opt.ACMEDirectory = "https://127.0.0.1:1/directory"
+	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}), &opt)
+	require.NoError(t, s.Serve())
+	defer s.Close()
+
+	_, port, err := net.SplitHostPort(s.listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, "https://"+domain+":"+port+"/", s.URL())
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         domain,
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	resp, err := client.Get("https://" + s.listener.Addr().String() + "/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "hello", string(body))
+	require.Len(t, resp.TLS.PeerCertificates, 1)
+	assert.Equal(t, domain, resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// Other domains are refused
+	client.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			ServerName:         "other.example.com",
+			InsecureSkipVerify: true,
+		},
+	}
+	_, err = client.Get("https://" + s.listener.Addr().String() + "/")
+	assert.Error(t, err)
+}
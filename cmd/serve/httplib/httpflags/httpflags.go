@@ -1,9 +1,13 @@
 package httpflags
 
 import (
+	"path/filepath"
+
 	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/spf13/pflag"
 )
 
@@ -12,6 +16,12 @@ var (
 	Opt = httplib.DefaultOpt
 )
 
+func init() {
+	httplib.DefaultACMECacheDir = func() string {
+		return filepath.Join(config.CacheDir, "acme")
+	}
+}
+
 // AddFlagsPrefix adds flags for the httplib
 func AddFlagsPrefix(flagSet *pflag.FlagSet, prefix string, Opt *httplib.Options) {
 	rc.AddOption(prefix+"http", &Opt)
@@ -28,6 +38,12 @@ func AddFlagsPrefix(flagSet *pflag.FlagSet, prefix string, Opt *httplib.Options)
 	flags.StringVarP(flagSet, &Opt.BasicPass, prefix+"pass", "", Opt.BasicPass, "Password for authentication.")
 	flags.StringVarP(flagSet, &Opt.BaseURL, prefix+"baseurl", "", Opt.BaseURL, "Prefix for URLs - leave blank for root.")
 	flags.StringVarP(flagSet, &Opt.Template, prefix+"template", "", Opt.Template, "User Specified Template.")
+	flags.StringArrayVarP(flagSet, &Opt.ACMEDomains, prefix+"acme-domain", "", Opt.ACMEDomains, "Domain to get a TLS certificate for with ACME, may be repeated.")
+	flags.StringVarP(flagSet, &Opt.ACMEEmail, prefix+"acme-email", "", Opt.ACMEEmail, "Contact email for the ACME account.")
+	flags.StringVarP(flagSet, &Opt.ACMEDirectory, prefix+"acme-directory", "", Opt.ACMEDirectory, "URL of the ACME certificate authority's directory.")
+	flags.StringVarP(flagSet, &Opt.ACMECacheDir, prefix+"acme-cache-dir", "", Opt.ACMECacheDir, "Directory to store the ACME certificates in (default in the cache dir).")
+	flags.FVarP(flagSet, &vfsflags.FileMode{Mode: &Opt.SocketMode}, prefix+"socket-mode", "", "File permissions for the socket if the address is unix:PATH")
+	flags.StringVarP(flagSet, &Opt.ACMEHTTPAddr, prefix+"acme-http-addr", "", Opt.ACMEHTTPAddr, "IPaddress:Port or :Port to answer ACME HTTP-01 challenges on, e.g. :80.")
 
 }
 
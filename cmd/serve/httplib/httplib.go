@@ -12,6 +12,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -19,6 +20,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/cmd/serve/httplib/serve/data"
 	"github.com/rclone/rclone/fs"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Globals
@@ -36,7 +39,7 @@ IPs.  By default it only listens on localhost.  You can use port
 
 If you set --addr to listen on a public or LAN accessible IP address
 then using Authentication is advised - see the next section for info.
-
+` + SocketHelp + `
 --server-read-timeout and --server-write-timeout can be used to
 control the timeouts on the server.  Note that this is the total time
 for a transfer.
@@ -107,7 +110,7 @@ supply --client-ca also.
 of that with the CA certificate.  --key should be the PEM encoded
 private key and --client-ca should be the PEM encoded client
 certificate authority certificate.
-`
+` + ACMEHelp
 
 // Options contains options for the http Server
 type Options struct {
@@ -124,15 +127,36 @@ type Options struct {
 	BasicUser          string        // single username for basic auth if not using Htpasswd
 	BasicPass          string        // password for BasicUser
 	Auth               AuthFn        `json:"-"` // custom Auth (not set by command line flags)
+	TokenAuth          TokenAuthFn   `json:"-"` // check Bearer tokens (not set by command line flags)
 	Template           string        // User specified template
+	ACMEDomains        []string      // domains to get certificates for with ACME
+	ACMEEmail          string        // contact email for the ACME account
+	ACMEDirectory      string        // URL of the ACME directory
+	ACMECacheDir       string        // directory to keep the ACME certificates in
+	ACMEHTTPAddr       string        // address to answer ACME HTTP-01 challenges on if set
+	SocketMode         os.FileMode   // permissions for the socket if ListenAddr is a Unix socket
 }
 
 // AuthFn if used will be used to authenticate user, pass. If an error
 // is returned then the user is not authenticated.
 //
+// If the request has a Bearer token then it is passed as pass with
+// an empty user.
+//
 // If a non nil value is returned then it is added to the context under the key
 type AuthFn func(user, pass string) (value interface{}, err error)
 
+// TokenAuthFn if used will be used to authenticate requests with a
+// Bearer token before any other authentication.  If an error is
+// returned then the request is not authenticated.
+//
+// Requests without a Bearer token are passed to the other
+// authentication if configured, or through unauthenticated if not.
+//
+// If a non nil value is returned then it is added to the context under
+// ContextAuthKey.
+type TokenAuthFn func(token string) (value interface{}, err error)
+
 // DefaultOpt is the default values used for Options
 var DefaultOpt = Options{
 	ListenAddr:         "localhost:8080",
@@ -140,6 +164,8 @@ var DefaultOpt = Options{
 	ServerReadTimeout:  1 * time.Hour,
 	ServerWriteTimeout: 1 * time.Hour,
 	MaxHeaderBytes:     4096,
+	ACMEDirectory:      acme.LetsEncryptURL,
+	SocketMode:         0600,
 }
 
 // Server contains info about the running http server
@@ -151,7 +177,11 @@ type Server struct {
 	httpServer      *http.Server
 	basicPassHashed string
 	useSSL          bool               // if server is configured for SSL/TLS
+	useSocket       bool               // if server is listening on a socket rather than TCP
+	acmeManager     *autocert.Manager  // ACME certificate manager if set
+	acmeServer      *http.Server       // server for ACME HTTP-01 challenges if set
 	usingAuth       bool               // set if authentication is configured
+	authenticator   *auth.BasicAuth    // checks htpasswd or --user and --pass if set
 	HTMLTemplate    *template.Template // HTML template for web interface
 }
 
@@ -175,10 +205,15 @@ func (s *Server) singleUserProvider(user, realm string) string {
 
 // parseAuthorization parses the Authorization header into user, pass
 // it returns a boolean as to whether the parse was successful
+//
+// A Bearer token is returned as the pass with an empty user.
 func parseAuthorization(r *http.Request) (user, pass string, ok bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" {
 		s := strings.SplitN(authHeader, " ", 2)
+		if len(s) == 2 && s[0] == "Bearer" && s[1] != "" {
+			return "", s[1], true
+		}
 		if len(s) == 2 && s[0] == "Basic" {
 			b, err := base64.StdEncoding.DecodeString(s[1])
 			if err == nil {
@@ -194,6 +229,51 @@ func parseAuthorization(r *http.Request) (user, pass string, ok bool) {
 	return
 }
 
+// Authenticate checks the Authorization header of r against the
+// authentication configured.  It returns the request with the
+// authentication info added to the context and true if it is allowed
+// or false if not.
+//
+// Requests are always allowed if no authentication is configured.
+func (s *Server) Authenticate(r *http.Request) (*http.Request, bool) {
+	user, pass, authValid := parseAuthorization(r)
+	if s.Opt.TokenAuth != nil && authValid && user == "" {
+		value, err := s.Opt.TokenAuth(pass)
+		if err != nil {
+			fs.Infof(r.URL.Path, "%s: Token auth failed: %v", r.RemoteAddr, err)
+			return r, false
+		}
+		if value != nil {
+			r = r.WithContext(context.WithValue(r.Context(), ContextAuthKey, value))
+		}
+		return r, true
+	}
+	if !s.usingAuth {
+		// Only tokens are checked
+		return r, true
+	}
+	if !authValid {
+		return r, false
+	}
+	if s.Opt.Auth == nil {
+		if username := s.authenticator.CheckAuth(r); username == "" {
+			fs.Infof(r.URL.Path, "%s: Unauthorized request from %s", r.RemoteAddr, user)
+			return r, false
+		}
+	} else {
+		// Custom Auth
+		value, err := s.Opt.Auth(user, pass)
+		if err != nil {
+			fs.Infof(r.URL.Path, "%s: Auth failed from %s: %v", r.RemoteAddr, user, err)
+			return r, false
+		}
+		if value != nil {
+			r = r.WithContext(context.WithValue(r.Context(), ContextAuthKey, value))
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), ContextUserKey, user)), true
+}
+
 // NewServer creates an http server.  The opt can be nil in which case
 // the default options will be used.
 func NewServer(handler http.Handler, opt *Options) *Server {
@@ -209,9 +289,9 @@ func NewServer(handler http.Handler, opt *Options) *Server {
 	}
 
 	// Use htpasswd if required on everything
-	if s.Opt.HtPasswd != "" || s.Opt.BasicUser != "" || s.Opt.Auth != nil {
-		var authenticator *auth.BasicAuth
-		if s.Opt.Auth == nil {
+	usingAuth := s.Opt.HtPasswd != "" || s.Opt.BasicUser != "" || s.Opt.Auth != nil
+	if usingAuth || s.Opt.TokenAuth != nil {
+		if usingAuth && s.Opt.Auth == nil {
 			var secretProvider auth.SecretProvider
 			if s.Opt.HtPasswd != "" {
 				fs.Infof(nil, "Using %q as htpasswd storage", s.Opt.HtPasswd)
@@ -221,8 +301,9 @@ func NewServer(handler http.Handler, opt *Options) *Server {
 				s.basicPassHashed = string(auth.MD5Crypt([]byte(s.Opt.BasicPass), []byte("dlPL2MqE"), []byte("$1$")))
 				secretProvider = s.singleUserProvider
 			}
-			authenticator = auth.NewBasicAuthenticator(s.Opt.Realm, secretProvider)
+			s.authenticator = auth.NewBasicAuthenticator(s.Opt.Realm, secretProvider)
 		}
+		s.usingAuth = usingAuth
 		oldHandler := handler
 		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// No auth wanted for OPTIONS method
@@ -230,44 +311,35 @@ func NewServer(handler http.Handler, opt *Options) *Server {
 				oldHandler.ServeHTTP(w, r)
 				return
 			}
-			unauthorized := func() {
+			r, ok := s.Authenticate(r)
+			if !ok {
 				w.Header().Set("Content-Type", "text/plain")
 				w.Header().Set("WWW-Authenticate", `Basic realm="`+s.Opt.Realm+`"`)
 				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			}
-			user, pass, authValid := parseAuthorization(r)
-			if !authValid {
-				unauthorized()
 				return
 			}
-			if s.Opt.Auth == nil {
-				if username := authenticator.CheckAuth(r); username == "" {
-					fs.Infof(r.URL.Path, "%s: Unauthorized request from %s", r.RemoteAddr, user)
-					unauthorized()
-					return
-				}
-			} else {
-				// Custom Auth
-				value, err := s.Opt.Auth(user, pass)
-				if err != nil {
-					fs.Infof(r.URL.Path, "%s: Auth failed from %s: %v", r.RemoteAddr, user, err)
-					unauthorized()
-					return
-				}
-				if value != nil {
-					r = r.WithContext(context.WithValue(r.Context(), ContextAuthKey, value))
-				}
-			}
-			r = r.WithContext(context.WithValue(r.Context(), ContextUserKey, user))
 			oldHandler.ServeHTTP(w, r)
 		})
-		s.usingAuth = true
 	}
 
 	s.useSSL = s.Opt.SslKey != ""
 	if (s.Opt.SslCert != "") != s.useSSL {
 		log.Fatalf("Need both -cert and -key to use SSL")
 	}
+	var err error
+	s.acmeManager, err = newACMEManager(&s.Opt)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if s.acmeManager != nil {
+		s.useSSL = true
+		// The certificate authority connects to port 443
+		if s.Opt.ListenAddr == DefaultOpt.ListenAddr {
+			s.Opt.ListenAddr = ":443"
+		}
+	}
+
+	s.useSocket = IsSocketAddr(s.Opt.ListenAddr)
 
 	// If a Base URL is set then serve from there
 	s.Opt.BaseURL = strings.Trim(s.Opt.BaseURL, "/")
@@ -288,6 +360,10 @@ func NewServer(handler http.Handler, opt *Options) *Server {
 			MinVersion: tls.VersionTLS10, // disable SSL v3.0 and earlier
 		},
 	}
+	if s.acmeManager != nil {
+		s.httpServer.TLSConfig = s.acmeManager.TLSConfig()
+		s.httpServer.TLSConfig.MinVersion = tls.VersionTLS10
+	}
 
 	if s.Opt.ClientCA != "" {
 		if !s.useSSL {
@@ -318,15 +394,29 @@ func NewServer(handler http.Handler, opt *Options) *Server {
 // the listener was not started; does not block, so
 // use s.Wait() to block on the listener indefinitely.
 func (s *Server) Serve() error {
-	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	var ln net.Listener
+	var err error
+	if s.useSocket {
+		ln, err = listenSocket(s.Opt.ListenAddr, s.Opt.SocketMode)
+	} else {
+		ln, err = net.Listen("tcp", s.httpServer.Addr)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "start server failed")
 	}
 	s.listener = ln
 	s.waitChan = make(chan struct{})
+	err = s.serveACMEChallenges()
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
 	go func() {
 		var err error
-		if s.useSSL {
+		if s.acmeManager != nil {
+			// the certificates come from the TLSConfig
+			err = s.httpServer.ServeTLS(s.listener, "", "")
+		} else if s.useSSL {
 			// hacky hack to get this to work with old Go versions, which
 			// don't have ServeTLS on http.Server; see PR #2194.
 			type tlsServer interface {
@@ -364,6 +454,12 @@ func (s *Server) Wait() {
 
 // Close shuts the running server down
 func (s *Server) Close() {
+	if s.acmeServer != nil {
+		err := s.acmeServer.Close()
+		if err != nil {
+			log.Printf("Error on closing ACME challenge server: %v", err)
+		}
+	}
 	err := s.httpServer.Close()
 	if err != nil {
 		log.Printf("Error on closing HTTP server: %v", err)
@@ -373,7 +469,12 @@ func (s *Server) Close() {
 }
 
 // URL returns the serving address of this server
+//
+// If the server is listening on a socket this is the socket address.
 func (s *Server) URL() string {
+	if s.useSocket {
+		return s.Opt.ListenAddr
+	}
 	proto := "http"
 	if s.useSSL {
 		proto = "https"
@@ -386,6 +487,14 @@ func (s *Server) URL() string {
 		// (i.e. port assigned by operating system)
 		addr = s.listener.Addr().String()
 	}
+	if s.acmeManager != nil {
+		// the certificate is only valid for the domain
+		_, port, _ := net.SplitHostPort(addr)
+		addr = s.Opt.ACMEDomains[0]
+		if port != "443" {
+			addr = net.JoinHostPort(addr, port)
+		}
+	}
 	return fmt.Sprintf("%s://%s%s/", proto, addr, s.Opt.BaseURL)
 }
 
@@ -394,6 +503,13 @@ func (s *Server) UsingAuth() bool {
 	return s.usingAuth
 }
 
+// UsingSocket returns true if the server is listening on a Unix
+// domain socket or named pipe so access is controlled by its
+// permissions
+func (s *Server) UsingSocket() bool {
+	return s.useSocket
+}
+
 // Path returns the current path with the Prefix stripped
 //
 // If it returns false, then the path was invalid and the handler
@@ -0,0 +1,701 @@
+package serve
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/vfs"
+)
+
+// ArchiveHelp describes the archive downloads for the command help
+var ArchiveHelp = `
+### Archive downloads
+
+Any directory can be downloaded as a single archive by adding
+"?archive=zip" or "?archive=tar" to its URL, eg
+
+    curl -O -J "http://localhost:8080/photos?archive=zip"
+
+The archive is made on the fly from the remote as it is sent so no
+space is needed on the server for it. The files are stored without
+compression so the size of the archive is known in advance and
+interrupted downloads can be resumed with Range requests. Resuming a
+tar archive only reads the files still to be sent, but resuming a zip
+archive may need to read the files already sent to checksum them.
+`
+
+// archive formats
+const (
+	archiveZip = "zip"
+	archiveTar = "tar"
+)
+
+const (
+	tarBlockSize     = 512                 // size of tar blocks
+	archiveETagLabel = "rclone-archive-v1" // change if the layout changes
+)
+
+// zip constants
+const (
+	zipLocalHeaderSig   = 0x04034b50
+	zipDescriptorSig    = 0x08074b50
+	zipCentralHeaderSig = 0x02014b50
+	zipEnd64Sig         = 0x06064b50
+	zipEnd64LocatorSig  = 0x07064b50
+	zipEndSig           = 0x06054b50
+	zipVersion20        = 20
+	zipVersion45        = 45 // needed for zip64
+	zipCreatorUnix      = 3
+	zipFlagDescriptor   = 0x8
+	zipFlagUTF8         = 0x800
+	zipExtraTimeID      = 0x5455
+	zipExtraZip64ID     = 0x0001
+	zipExtraTimeLen     = 9
+	zipExtraZip64Len    = 28
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEnd64Len         = 56
+	zipMax16            = math.MaxUint16
+	zipMax32            = math.MaxUint32
+)
+
+// archiveEntry is a file or directory in an archive
+type archiveEntry struct {
+	name    string   // path in the archive, ending in "/" for directories
+	node    vfs.Node // the file or directory
+	isDir   bool
+	size    int64
+	modTime time.Time
+	offset  int64 // offset of the zip local header
+
+	// CRC-32 of the file for zip archives
+	crc      uint32
+	crcValid bool  // set if crc is for the whole file
+	crcPos   int64 // how much of the file has been added to crc
+}
+
+// archivePart is a section of an archive
+type archivePart struct {
+	size  int64
+	data  []byte                 // fixed contents
+	fn    func() ([]byte, error) // contents which are worked out when needed
+	entry *archiveEntry          // contents of this file
+}
+
+// archive is an archive of a directory which reads as an
+// io.ReadSeeker without being stored anywhere.
+//
+// It is made of a list of parts whose sizes are worked out in advance
+// so the reads for a range of the archive only read the files in it.
+type archive struct {
+	ctx     context.Context
+	entries []*archiveEntry
+	parts   []archivePart
+	offsets []int64 // offset of the start of each part
+	size    int64
+	pos     int64
+	err     error // the first error reading the archive
+
+	// the file being read
+	in      vfs.Handle
+	inEntry *archiveEntry
+	inPos   int64
+}
+
+// addData adds fixed contents to the archive
+func (a *archive) addData(data []byte) {
+	if len(data) > 0 {
+		a.add(archivePart{size: int64(len(data)), data: data})
+	}
+}
+
+// add adds the part to the archive
+func (a *archive) add(part archivePart) {
+	a.offsets = append(a.offsets, a.size)
+	a.parts = append(a.parts, part)
+	a.size += part.size
+}
+
+// walkArchive returns the entries for dir and everything under it
+// sorted so directories come before their contents
+func walkArchive(dir *vfs.Dir, prefix string) (entries []*archiveEntry, err error) {
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %q", dir.Path())
+	}
+	entries = append(entries, &archiveEntry{
+		name:    prefix,
+		node:    dir,
+		isDir:   true,
+		modTime: dir.ModTime(),
+	})
+	for _, node := range nodes {
+		name := prefix + node.Name()
+		if subDir, ok := node.(*vfs.Dir); ok {
+			subEntries, err := walkArchive(subDir, name+"/")
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, subEntries...)
+		} else {
+			entries = append(entries, &archiveEntry{
+				name:    name,
+				node:    node,
+				size:    node.Size(),
+				modTime: node.ModTime(),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// newArchive makes the archive of dir in format with everything in
+// it under the directory name
+func newArchive(ctx context.Context, dir *vfs.Dir, name, format string) (a *archive, err error) {
+	a = &archive{ctx: ctx}
+	a.entries, err = walkArchive(dir, name+"/")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case archiveTar:
+		err = a.layoutTar()
+	case archiveZip:
+		a.layoutZip()
+	default:
+		err = errors.Errorf("unknown archive format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// etag returns an ETag for the archive which changes if the files in
+// it change
+func (a *archive) etag(format string) string {
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00", archiveETagLabel, format)
+	for _, entry := range a.entries {
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d\x00", entry.name, entry.size, entry.modTime.UnixNano())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// layoutTar lays out the archive as a tar file
+func (a *archive) layoutTar() error {
+	for _, entry := range a.entries {
+		hdr := &tar.Header{
+			Name:    entry.name,
+			ModTime: entry.modTime,
+			Mode:    0644,
+			Size:    entry.size,
+		}
+		if entry.isDir {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+			hdr.Size = 0
+		} else {
+			hdr.Typeflag = tar.TypeReg
+		}
+		// Write the header on its own to find its bytes - the
+		// writer isn't closed as that would add the trailer
+		var buf bytes.Buffer
+		err := tar.NewWriter(&buf).WriteHeader(hdr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to make tar header for %q", entry.name)
+		}
+		a.addData(buf.Bytes())
+		if !entry.isDir && entry.size > 0 {
+			a.add(archivePart{size: entry.size, entry: entry})
+			if pad := (tarBlockSize - entry.size%tarBlockSize) % tarBlockSize; pad != 0 {
+				a.addData(make([]byte, pad))
+			}
+		}
+	}
+	// the end of the archive is two zero blocks
+	a.addData(make([]byte, 2*tarBlockSize))
+	return nil
+}
+
+// zipWriter builds little endian zip records
+type zipWriter []byte
+
+// uint16 adds v to the record
+func (w *zipWriter) uint16(v uint16) { *w = append(*w, byte(v), byte(v>>8)) }
+
+// uint32 adds v to the record
+func (w *zipWriter) uint32(v uint32) {
+	*w = append(*w, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// uint64 adds v to the record
+func (w *zipWriter) uint64(v uint64) {
+	w.uint32(uint32(v))
+	w.uint32(uint32(v >> 32))
+}
+
+// zipTime returns the MS-DOS time and date of t
+func zipTime(t time.Time) (dosTime, dosDate uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	dosDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	dosTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return dosTime, dosDate
+}
+
+// zipUnixTime returns t as an unsigned 32 bit unix time as used in
+// the extended timestamp field
+func zipUnixTime(t time.Time) uint32 {
+	unix := t.Unix()
+	if unix < 0 {
+		return 0
+	} else if unix > zipMax32 {
+		return zipMax32
+	}
+	return uint32(unix)
+}
+
+// isZip64 returns whether the entry needs zip64 records
+func (entry *archiveEntry) isZip64() bool {
+	return entry.size >= zipMax32 || entry.offset >= zipMax32
+}
+
+// zipFlags returns the general purpose flags of the entry
+func (entry *archiveEntry) zipFlags() uint16 {
+	var flags uint16
+	if !entry.isDir {
+		flags |= zipFlagDescriptor
+	}
+	if !isASCII(entry.name) && utf8.ValidString(entry.name) {
+		flags |= zipFlagUTF8
+	}
+	return flags
+}
+
+// zipVersion returns the version needed to extract the entry
+func (entry *archiveEntry) zipVersion() uint16 {
+	if entry.isZip64() {
+		return zipVersion45
+	}
+	return zipVersion20
+}
+
+// isASCII returns whether s is all ASCII
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// zipLocalHeader makes the local file header for the entry
+//
+// The CRC and sizes of files follow the data in a data descriptor
+// so the CRC doesn't need to be known when sending the header.
+func (entry *archiveEntry) zipLocalHeader() []byte {
+	dosTime, dosDate := zipTime(entry.modTime)
+	w := make(zipWriter, 0, zipLocalHeaderLen+len(entry.name)+zipExtraTimeLen)
+	w.uint32(zipLocalHeaderSig)
+	w.uint16(entry.zipVersion())
+	w.uint16(entry.zipFlags())
+	w.uint16(0) // stored
+	w.uint16(dosTime)
+	w.uint16(dosDate)
+	w.uint32(0) // CRC - in data descriptor
+	w.uint32(0) // compressed size - in data descriptor
+	w.uint32(0) // uncompressed size - in data descriptor
+	w.uint16(uint16(len(entry.name)))
+	w.uint16(zipExtraTimeLen)
+	w = append(w, entry.name...)
+	w.extraTime(entry.modTime)
+	return w
+}
+
+// extraTime adds an extended timestamp field with the modification time
+func (w *zipWriter) extraTime(t time.Time) {
+	w.uint16(zipExtraTimeID)
+	w.uint16(zipExtraTimeLen - 4)
+	*w = append(*w, 1) // modification time present
+	w.uint32(zipUnixTime(t))
+}
+
+// zipDescriptorLen returns the length of the data descriptor
+func (entry *archiveEntry) zipDescriptorLen() int64 {
+	if entry.isZip64() {
+		return 24
+	}
+	return 16
+}
+
+// zipDescriptor makes the data descriptor with the CRC and sizes
+func (entry *archiveEntry) zipDescriptor(crc uint32) []byte {
+	w := make(zipWriter, 0, entry.zipDescriptorLen())
+	w.uint32(zipDescriptorSig)
+	w.uint32(crc)
+	if entry.isZip64() {
+		w.uint64(uint64(entry.size))
+		w.uint64(uint64(entry.size))
+	} else {
+		w.uint32(uint32(entry.size))
+		w.uint32(uint32(entry.size))
+	}
+	return w
+}
+
+// zipCentralHeaderLen returns the length of the central directory
+// header for the entry
+func (entry *archiveEntry) zipCentralHeaderLen() int64 {
+	n := int64(zipCentralHeaderLen + len(entry.name) + zipExtraTimeLen)
+	if entry.isZip64() {
+		n += zipExtraZip64Len
+	}
+	return n
+}
+
+// zipCentralHeader makes the central directory header for the entry
+func (entry *archiveEntry) zipCentralHeader(crc uint32) []byte {
+	dosTime, dosDate := zipTime(entry.modTime)
+	extraLen := zipExtraTimeLen
+	size, offset := uint32(entry.size), uint32(entry.offset)
+	if entry.isZip64() {
+		extraLen += zipExtraZip64Len
+		size, offset = zipMax32, zipMax32
+	}
+	mode, attr := uint32(0100644), uint32(0)
+	if entry.isDir {
+		mode, attr = 040755, 0x10
+	}
+	w := make(zipWriter, 0, entry.zipCentralHeaderLen())
+	w.uint32(zipCentralHeaderSig)
+	w.uint16(zipCreatorUnix<<8 | entry.zipVersion())
+	w.uint16(entry.zipVersion())
+	w.uint16(entry.zipFlags())
+	w.uint16(0) // stored
+	w.uint16(dosTime)
+	w.uint16(dosDate)
+	w.uint32(crc)
+	w.uint32(size) // compressed size
+	w.uint32(size) // uncompressed size
+	w.uint16(uint16(len(entry.name)))
+	w.uint16(uint16(extraLen))
+	w.uint16(0) // comment length
+	w.uint16(0) // disk number
+	w.uint16(0) // internal attributes
+	w.uint32(mode<<16 | attr)
+	w.uint32(offset)
+	w = append(w, entry.name...)
+	w.extraTime(entry.modTime)
+	if entry.isZip64() {
+		w.uint16(zipExtraZip64ID)
+		w.uint16(zipExtraZip64Len - 4)
+		w.uint64(uint64(entry.size))
+		w.uint64(uint64(entry.size))
+		w.uint64(uint64(entry.offset))
+	}
+	return w
+}
+
+// zipEnd makes the end of central directory records
+func zipEnd(records int, cdOffset, cdSize int64) []byte {
+	var w zipWriter
+	if records >= zipMax16 || cdOffset >= zipMax32 || cdSize >= zipMax32 {
+		end64Offset := cdOffset + cdSize
+		w.uint32(zipEnd64Sig)
+		w.uint64(zipEnd64Len - 12) // size of the rest of the record
+		w.uint16(zipCreatorUnix<<8 | zipVersion45)
+		w.uint16(zipVersion45)
+		w.uint32(0) // disk number
+		w.uint32(0) // disk with the central directory
+		w.uint64(uint64(records))
+		w.uint64(uint64(records))
+		w.uint64(uint64(cdSize))
+		w.uint64(uint64(cdOffset))
+		w.uint32(zipEnd64LocatorSig)
+		w.uint32(0) // disk with the zip64 end record
+		w.uint64(uint64(end64Offset))
+		w.uint32(1) // number of disks
+		if records > zipMax16 {
+			records = zipMax16
+		}
+		if cdOffset > zipMax32 {
+			cdOffset = zipMax32
+		}
+		if cdSize > zipMax32 {
+			cdSize = zipMax32
+		}
+	}
+	w.uint32(zipEndSig)
+	w.uint16(0) // disk number
+	w.uint16(0) // disk with the central directory
+	w.uint16(uint16(records))
+	w.uint16(uint16(records))
+	w.uint32(uint32(cdSize))
+	w.uint32(uint32(cdOffset))
+	w.uint16(0) // comment length
+	return w
+}
+
+// layoutZip lays out the archive as a zip file with the files stored
+// without compression
+func (a *archive) layoutZip() {
+	for _, entry := range a.entries {
+		entry := entry
+		entry.offset = a.size
+		a.addData(entry.zipLocalHeader())
+		if entry.isDir {
+			continue
+		}
+		if entry.size > 0 {
+			a.add(archivePart{size: entry.size, entry: entry})
+		}
+		a.add(archivePart{
+			size: entry.zipDescriptorLen(),
+			fn: func() ([]byte, error) {
+				crc, err := a.crc(entry)
+				if err != nil {
+					return nil, err
+				}
+				return entry.zipDescriptor(crc), nil
+			},
+		})
+	}
+	cdOffset := a.size
+	for _, entry := range a.entries {
+		entry := entry
+		a.add(archivePart{
+			size: entry.zipCentralHeaderLen(),
+			fn: func() ([]byte, error) {
+				crc, err := a.crc(entry)
+				if err != nil {
+					return nil, err
+				}
+				return entry.zipCentralHeader(crc), nil
+			},
+		})
+	}
+	a.addData(zipEnd(len(a.entries), cdOffset, a.size-cdOffset))
+}
+
+// crc returns the CRC-32 of the file in entry.
+//
+// This will have been worked out already if the file was sent in
+// full, otherwise it is read from the remote if it supports CRC-32
+// or the file is read to calculate it.
+func (a *archive) crc(entry *archiveEntry) (uint32, error) {
+	if entry.isDir || entry.crcValid {
+		return entry.crc, nil
+	}
+	if entry.crcPos == entry.size {
+		entry.crcValid = true
+		return entry.crc, nil
+	}
+	if o, ok := entry.node.DirEntry().(fs.Object); ok && o.Size() == entry.size && o.Fs().Hashes().Contains(hash.CRC32) {
+		sum, err := o.Hash(a.ctx, hash.CRC32)
+		if err == nil && len(sum) == 8 {
+			crc, err := strconv.ParseUint(sum, 16, 32)
+			if err == nil {
+				entry.crc, entry.crcValid = uint32(crc), true
+				return entry.crc, nil
+			}
+		}
+	}
+	fs.Debugf(entry.node.Path(), "Reading file to calculate CRC-32 for zip archive")
+	in, err := a.open(entry)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, io.LimitReader(in, entry.size))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %q", entry.node.Path())
+	}
+	if n != entry.size {
+		return 0, errors.Errorf("%q changed size while being archived", entry.node.Path())
+	}
+	entry.crc, entry.crcValid = h.Sum32(), true
+	return entry.crc, nil
+}
+
+// open opens the file in entry checking it hasn't changed size
+func (a *archive) open(entry *archiveEntry) (vfs.Handle, error) {
+	file, ok := entry.node.(*vfs.File)
+	if !ok {
+		return nil, errors.Errorf("%q is not a file", entry.node.Path())
+	}
+	if file.Size() != entry.size {
+		return nil, errors.Errorf("%q changed size while being archived", entry.node.Path())
+	}
+	in, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %q", entry.node.Path())
+	}
+	return in, nil
+}
+
+// closeFile closes the file being read if any
+func (a *archive) closeFile() {
+	if a.in == nil {
+		return
+	}
+	err := a.in.Close()
+	if err != nil {
+		fs.Errorf(a.inEntry.node.Path(), "Failed to close file: %v", err)
+	}
+	a.in, a.inEntry = nil, nil
+}
+
+// readFile reads the file in entry at offset into p
+func (a *archive) readFile(entry *archiveEntry, offset int64, p []byte) (n int, err error) {
+	if a.inEntry != entry {
+		a.closeFile()
+		a.in, err = a.open(entry)
+		if err != nil {
+			return 0, err
+		}
+		a.inEntry, a.inPos = entry, 0
+	}
+	if a.inPos != offset {
+		_, err = a.in.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to seek %q", entry.node.Path())
+		}
+		a.inPos = offset
+	}
+	n, err = io.ReadFull(a.in, p)
+	a.inPos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, errors.Errorf("%q changed size while being archived", entry.node.Path())
+	} else if err != nil {
+		return n, errors.Wrapf(err, "failed to read %q", entry.node.Path())
+	}
+	// keep the CRC up to date while the file is read in order
+	if offset == entry.crcPos && !entry.crcValid {
+		entry.crc = crc32.Update(entry.crc, crc32.IEEETable, p[:n])
+		entry.crcPos += int64(n)
+	}
+	return n, nil
+}
+
+// Read reads from the archive at the current position
+func (a *archive) Read(p []byte) (n int, err error) {
+	if a.pos >= a.size {
+		a.closeFile()
+		return 0, io.EOF
+	}
+	i := sort.Search(len(a.offsets), func(i int) bool { return a.offsets[i] > a.pos }) - 1
+	part := &a.parts[i]
+	offset := a.pos - a.offsets[i]
+	if remaining := part.size - offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	switch {
+	case part.entry != nil:
+		n, err = a.readFile(part.entry, offset, p)
+	case part.fn != nil:
+		if part.data == nil {
+			part.data, err = part.fn()
+			if err != nil {
+				if a.err == nil {
+					a.err = err
+				}
+				return 0, err
+			}
+		}
+		n = copy(p, part.data[offset:])
+	default:
+		n = copy(p, part.data[offset:])
+	}
+	a.pos += int64(n)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read
+func (a *archive) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.pos
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return a.pos, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return a.pos, errors.New("negative position")
+	}
+	a.pos = offset
+	return a.pos, nil
+}
+
+// Archive serves dir and everything in it as an archive in format,
+// which is "zip" or "tar", via HEAD or GET.
+//
+// The archive is made as it is read and Range requests are supported.
+func Archive(w http.ResponseWriter, r *http.Request, dir *vfs.Dir, format string) {
+	if r.Method != "HEAD" && r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if format != archiveZip && format != archiveTar {
+		http.Error(w, "Unknown archive format", http.StatusBadRequest)
+		return
+	}
+	name := path.Base(dir.Path())
+	if dir.Path() == "" {
+		name = path.Base(dir.VFS().Fs().Root())
+		if name == "." || name == "/" || name == "" {
+			name = dir.VFS().Fs().Name()
+		}
+	}
+	a, err := newArchive(r.Context(), dir, name, format)
+	if err != nil {
+		Error(dir.Path(), w, "Failed to make archive", err)
+		return
+	}
+	defer a.closeFile()
+	fileName := name + "." + format
+	w.Header().Set("ETag", a.etag(format))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	if format == archiveZip {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	if r.Method == "GET" {
+		tr := accounting.Stats(r.Context()).NewTransferRemoteSize(path.Join(dir.Path(), fileName), a.size)
+		defer tr.Done(r.Context(), nil)
+	}
+	http.ServeContent(w, r, fileName, time.Time{}, a)
+	if a.err != nil {
+		fs.Errorf(dir.Path(), "Failed to send archive: %v", a.err)
+	}
+}
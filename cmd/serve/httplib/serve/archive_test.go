@@ -0,0 +1,217 @@
+package serve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveFiles are the files used in the archive tests
+var archiveFiles = map[string]string{
+	"empty.txt":       "",
+	"small.txt":       "hello",
+	"dir/big.bin":     string(bytes.Repeat([]byte("0123456789abcdef"), 1000)),
+	"dir/sub/ünï.txt": "unicode",
+	"dir/sub/a.txt":   string(bytes.Repeat([]byte{'a'}, 511)),
+}
+
+// newArchiveDir makes the archive test files returning the VFS
+// directory with them in and a function to tidy up
+func newArchiveDir(t *testing.T) (*vfs.Dir, time.Time, func()) {
+	dir, err := ioutil.TempDir("", "rclone-archive")
+	require.NoError(t, err)
+	root := filepath.Join(dir, "root")
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for name, contents := range archiveFiles {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+		require.NoError(t, ioutil.WriteFile(p, []byte(contents), 0666))
+		require.NoError(t, os.Chtimes(p, modTime, modTime))
+	}
+	f, err := fs.NewFs(context.Background(), root)
+	require.NoError(t, err)
+	VFS := vfs.New(f, nil)
+	rootDir, err := VFS.Root()
+	require.NoError(t, err)
+	return rootDir, modTime, func() {
+		VFS.Shutdown()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// getArchive gets the archive of dir with the headers given
+func getArchive(t *testing.T, dir *vfs.Dir, method, format string, headers ...string) *http.Response {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "http://example.com/root?archive="+format, nil)
+	for i := 0; i < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	Archive(w, r, dir, format)
+	return w.Result()
+}
+
+// readBody reads the body of the response
+func readBody(t *testing.T, resp *http.Response) []byte {
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return body
+}
+
+func TestArchiveZip(t *testing.T) {
+	dir, modTime, cleanup := newArchiveDir(t)
+	defer cleanup()
+
+	resp := getArchive(t, dir, "GET", "zip")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=root.zip`, resp.Header.Get("Content-Disposition"))
+	body := readBody(t, resp)
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	got := map[string]string{}
+	var dirs []string
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			dirs = append(dirs, file.Name)
+			continue
+		}
+		assert.True(t, file.Modified.Equal(modTime), file.Modified)
+		in, err := file.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(in) // checks the CRC too
+		require.NoError(t, err, file.Name)
+		require.NoError(t, in.Close())
+		got[file.Name] = string(data)
+	}
+	want := map[string]string{}
+	for name, contents := range archiveFiles {
+		want["root/"+name] = contents
+	}
+	assert.Equal(t, want, got)
+	assert.Equal(t, []string{"root/", "root/dir/", "root/dir/sub/"}, dirs)
+}
+
+func TestArchiveTar(t *testing.T) {
+	dir, modTime, cleanup := newArchiveDir(t)
+	defer cleanup()
+
+	resp := getArchive(t, dir, "GET", "tar")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-tar", resp.Header.Get("Content-Type"))
+	body := readBody(t, resp)
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	assert.Equal(t, 0, len(body)%512)
+
+	tr := tar.NewReader(bytes.NewReader(body))
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		assert.True(t, hdr.ModTime.Equal(modTime), hdr.ModTime)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		got[hdr.Name] = string(data)
+	}
+	want := map[string]string{}
+	for name, contents := range archiveFiles {
+		want["root/"+name] = contents
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestArchiveRange(t *testing.T) {
+	dir, _, cleanup := newArchiveDir(t)
+	defer cleanup()
+
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			resp := getArchive(t, dir, "GET", format)
+			full := readBody(t, resp)
+			etag := resp.Header.Get("ETag")
+			require.NotEqual(t, "", etag)
+			size := len(full)
+
+			// HEAD gives the same headers
+			resp = getArchive(t, dir, "HEAD", format)
+			assert.Equal(t, strconv.Itoa(size), resp.Header.Get("Content-Length"))
+			assert.Equal(t, etag, resp.Header.Get("ETag"))
+			assert.Equal(t, 0, len(readBody(t, resp)))
+
+			// Ranges starting in each part of the archive
+			for start := 0; start < size; start += 397 {
+				for _, end := range []int{start, start + 100, start + 5000, size - 1} {
+					if end >= size || end < start {
+						continue
+					}
+					resp := getArchive(t, dir, "GET", format, "Range", fmt.Sprintf("bytes=%d-%d", start, end))
+					assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+					assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", start, end, size), resp.Header.Get("Content-Range"))
+					assert.Equal(t, full[start:end+1], readBody(t, resp), "%d-%d", start, end)
+				}
+			}
+
+			// If-Range with the current ETag gives the range
+			resp = getArchive(t, dir, "GET", format, "Range", "bytes=10-19", "If-Range", etag)
+			assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+			assert.Equal(t, full[10:20], readBody(t, resp))
+
+			// But the whole archive with an old ETag
+			resp = getArchive(t, dir, "GET", format, "Range", "bytes=10-19", "If-Range", `"old"`)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, full, readBody(t, resp))
+		})
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	dir, _, cleanup := newArchiveDir(t)
+	defer cleanup()
+
+	resp := getArchive(t, dir, "GET", "rar")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = getArchive(t, dir, "POST", "zip")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestZipEnd(t *testing.T) {
+	// small archives just have the end of central directory record
+	end := zipEnd(3, 100, 200)
+	assert.Equal(t, 22, len(end))
+
+	// big ones have the zip64 records too
+	end = zipEnd(70000, 1<<33, 200)
+	require.Equal(t, 56+20+22, len(end))
+	zr, err := zip.NewReader(bytes.NewReader(end), int64(len(end)))
+	assert.Nil(t, zr)
+	assert.Error(t, err) // as the central directory isn't there
+	assert.Equal(t, []byte{0x50, 0x4b, 0x06, 0x06}, end[:4])
+	assert.Equal(t, []byte{0x50, 0x4b, 0x06, 0x07}, end[56:60])
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff}, end[56+20+16:56+20+20])
+}
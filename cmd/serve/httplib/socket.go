@@ -0,0 +1,112 @@
+package httplib
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SocketHelp contains text describing listening on sockets to add to
+// the server options section of the help
+var SocketHelp = `
+To listen on a Unix domain socket instead of a TCP port use --addr
+unix:/path/to/socket. The socket is made with the permissions given by
+--socket-mode (default 0600) so only the user running rclone, or the
+users given access with --socket-mode, can connect to it, and no other
+authentication is needed. On Windows use --addr npipe://./pipe/NAME to
+listen on a named pipe which only the user running rclone can connect
+to.
+`
+
+// Prefixes of addresses which are sockets rather than TCP ports
+const (
+	unixPrefix  = "unix:"
+	npipePrefix = "npipe:"
+)
+
+// IsSocketAddr returns true if addr is a Unix domain socket or a
+// named pipe rather than a TCP address
+func IsSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix) || strings.HasPrefix(addr, npipePrefix)
+}
+
+// parseSocketAddr returns the network and path of addr which should
+// be a socket address
+//
+// Unix sockets may be given as unix:/path or unix:///path and named
+// pipes as npipe:\\.\pipe\name or npipe:////./pipe/name.
+func parseSocketAddr(addr string) (network, path string, err error) {
+	switch {
+	case strings.HasPrefix(addr, unixPrefix):
+		network, path = "unix", strings.TrimPrefix(addr, unixPrefix)
+		if strings.HasPrefix(path, "//") {
+			path = path[2:]
+		}
+	case strings.HasPrefix(addr, npipePrefix):
+		network, path = "npipe", strings.TrimPrefix(addr, npipePrefix)
+		if strings.HasPrefix(path, "////") {
+			path = path[2:]
+		}
+		path = strings.Replace(path, "/", `\`, -1)
+	default:
+		return "", "", errors.Errorf("%q is not a socket address", addr)
+	}
+	if path == "" {
+		return "", "", errors.Errorf("no path in socket address %q", addr)
+	}
+	return network, path, nil
+}
+
+// listenSocket listens on the socket at addr. Unix sockets are made
+// with mode.
+func listenSocket(addr string, mode os.FileMode) (net.Listener, error) {
+	network, path, err := parseSocketAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "npipe" {
+		return listenPipe(path)
+	}
+	// Remove a socket left behind by a server which didn't shut
+	// down cleanly, but not one which is still in use
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("%q exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, errors.Errorf("socket %q is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "failed to remove old socket")
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, errors.Wrap(err, "failed to set socket permissions")
+	}
+	return ln, nil
+}
+
+// DialSocket connects to the Unix domain socket or named pipe at addr
+//
+// This can be used as the DialContext of an http.Transport to talk to
+// a server listening on a socket.
+func DialSocket(ctx context.Context, addr string) (net.Conn, error) {
+	network, path, err := parseSocketAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "npipe" {
+		return dialPipe(ctx, path)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", path)
+}
@@ -0,0 +1,24 @@
+// +build !windows
+
+package httplib
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// errNoPipes is returned when named pipes are used on a platform
+// without them
+var errNoPipes = errors.New("named pipes are only supported on Windows")
+
+// listenPipe listens on the named pipe at path
+func listenPipe(path string) (net.Listener, error) {
+	return nil, errNoPipes
+}
+
+// dialPipe connects to the named pipe at path
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errNoPipes
+}
@@ -0,0 +1,100 @@
+package httplib
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSocketAddr(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		network string
+		path    string
+		wantErr bool
+	}{
+		{"unix:/tmp/rclone.sock", "unix", "/tmp/rclone.sock", false},
+		{"unix:///tmp/rclone.sock", "unix", "/tmp/rclone.sock", false},
+		{"unix:rclone.sock", "unix", "rclone.sock", false},
+		{`npipe:\\.\pipe\rclone`, "npipe", `\\.\pipe\rclone`, false},
+		{"npipe:////./pipe/rclone", "npipe", `\\.\pipe\rclone`, false},
+		{"npipe://./pipe/rclone", "npipe", `\\.\pipe\rclone`, false},
+		{"unix:", "", "", true},
+		{"localhost:5572", "", "", true},
+	} {
+		network, path, err := parseSocketAddr(test.in)
+		assert.Equal(t, test.wantErr, err != nil, test.in)
+		assert.Equal(t, test.network, network, test.in)
+		assert.Equal(t, test.path, path, test.in)
+		assert.Equal(t, test.in != "localhost:5572", IsSocketAddr(test.in), test.in)
+	}
+}
+
+func TestServeSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix sockets not tested on Windows")
+	}
+	dir, err := ioutil.TempDir("", "rclone-socket-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "rclone.sock")
+	addr := "unix:" + path
+
+	// Leave a stale socket behind
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, ln.Close())
+
+	opt := DefaultOpt
+	opt.ListenAddr = addr
+	opt.SocketMode = 0660
+	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}), &opt)
+	assert.True(t, s.UsingSocket())
+	require.NoError(t, s.Serve())
+	assert.Equal(t, addr, s.URL())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return DialSocket(ctx, addr)
+			},
+		},
+	}
+	resp, err := client.Get("http://localhost/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "hello", string(body))
+
+	// Can't listen on a socket which is in use
+	_, err = listenSocket(addr, 0600)
+	assert.EqualError(t, err, `socket "`+path+`" is in use`)
+
+	// The socket is removed when the server closes
+	s.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Won't overwrite files which aren't sockets
+	require.NoError(t, ioutil.WriteFile(path, []byte("potato"), 0600))
+	_, err = listenSocket(addr, 0600)
+	assert.EqualError(t, err, `"`+path+`" exists and is not a socket`)
+}
@@ -0,0 +1,32 @@
+// +build windows
+
+package httplib
+
+import (
+	"context"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// listenPipe listens on the named pipe at path which only the current
+// user, administrators and the system can connect to
+func listenPipe(path string) (net.Listener, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read current user")
+	}
+	// Protected DACL giving full access to SYSTEM, administrators
+	// and the current user only
+	sddl := "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GA;;;" + user.User.Sid.String() + ")"
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		SecurityDescriptor: sddl,
+	})
+}
+
+// dialPipe connects to the named pipe at path
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
@@ -0,0 +1,221 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+)
+
+// supportedAttrs is the attributes the server can return
+var supportedAttrs = xdr.NewBitmap(
+	attrSupportedAttrs, attrType, attrFhExpireType, attrChange,
+	attrSize, attrLinkSupport, attrSymlinkSupport, attrNamedAttr,
+	attrFsid, attrUniqueHandles, attrLeaseTime, attrRdattrError,
+	attrCansettime, attrCaseInsensitive, attrCasePreserving,
+	attrChownRestricted, attrFilehandle, attrFileid, attrFilesAvail,
+	attrFilesFree, attrFilesTotal, attrHomogeneous, attrMaxfilesize,
+	attrMaxlink, attrMaxname, attrMaxread, attrMaxwrite, attrMode,
+	attrNoTrunc, attrNumlinks, attrOwner, attrOwnerGroup, attrRawdev,
+	attrSpaceAvail, attrSpaceFree, attrSpaceTotal, attrSpaceUsed,
+	attrTimeAccess, attrTimeAccessSet, attrTimeDelta, attrTimeMetadata,
+	attrTimeModify, attrTimeModifySet, attrMountedOnFileid,
+	attrSuppattrExclcreat,
+)
+
+// settableAttrs is the attributes which can be set
+var settableAttrs = xdr.NewBitmap(
+	attrSize, attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet,
+	attrTimeModifySet,
+)
+
+// writeTime writes an nfstime4
+func writeTime(w *xdr.Writer, t time.Time) {
+	w.Uint64(uint64(t.Unix()))
+	w.Uint32(uint32(t.Nanosecond()))
+}
+
+// readTime reads an nfstime4
+func readTime(r *xdr.Reader) time.Time {
+	secs := int64(r.Uint64())
+	nsecs := r.Uint32()
+	return time.Unix(secs, int64(nsecs))
+}
+
+// change returns the change attribute of the node at path
+func (s *server) change(node vfs.Node, p string) uint64 {
+	change := uint64(node.ModTime().UnixNano())
+	if node.IsDir() {
+		// The modification time of a directory doesn't change
+		// when its contents do so count the changes made.
+		s.mu.Lock()
+		change += s.dirGen[p] + uint64(s.bootTime)
+		s.mu.Unlock()
+	} else {
+		change ^= uint64(node.Size())
+	}
+	return change
+}
+
+// dirChanged records that the directory at path has changed
+func (s *server) dirChanged(p string) {
+	s.mu.Lock()
+	s.dirGen[p]++
+	s.mu.Unlock()
+}
+
+// writeAttrs writes the fattr4 for the attributes requested of the
+// node at path in VFS
+func (s *server) writeAttrs(w *xdr.Writer, VFS *vfs.VFS, node vfs.Node, p string, req xdr.Bitmap) {
+	var (
+		set  xdr.Bitmap
+		vals = &xdr.Writer{}
+		opt  = &VFS.Opt
+	)
+	var total, used, free int64
+	if req.IsSet(attrSpaceAvail) || req.IsSet(attrSpaceFree) || req.IsSet(attrSpaceTotal) {
+		total, used, free = VFS.Statfs()
+		if total < 0 {
+			total = 1 << 50
+		}
+		if free < 0 {
+			free = total - used
+			if free < 0 {
+				free = 0
+			}
+		}
+	}
+	for _, bit := range req.Bits() {
+		// skip unsupported and write only attributes
+		if !supportedAttrs.IsSet(bit) || bit == attrTimeAccessSet || bit == attrTimeModifySet {
+			continue
+		}
+		set.Set(bit)
+		switch bit {
+		case attrSupportedAttrs:
+			vals.Bitmap(supportedAttrs)
+		case attrType:
+			if node.IsDir() {
+				vals.Uint32(nf4Dir)
+			} else {
+				vals.Uint32(nf4Reg)
+			}
+		case attrFhExpireType:
+			vals.Uint32(fh4VolRename)
+		case attrChange:
+			vals.Uint64(s.change(node, p))
+		case attrSize:
+			vals.Uint64(uint64(node.Size()))
+		case attrLinkSupport, attrSymlinkSupport, attrNamedAttr:
+			vals.Bool(false)
+		case attrFsid:
+			vals.Uint64(fsidMajor)
+			vals.Uint64(0)
+		case attrUniqueHandles:
+			vals.Bool(true)
+		case attrLeaseTime:
+			vals.Uint32(leaseTime)
+		case attrRdattrError:
+			vals.Uint32(uint32(nfs4OK))
+		case attrCansettime, attrCasePreserving, attrChownRestricted, attrHomogeneous, attrNoTrunc:
+			vals.Bool(true)
+		case attrCaseInsensitive:
+			vals.Bool(opt.CaseInsensitive || opt.FoldNames)
+		case attrFilehandle:
+			vals.Opaque(s.handles.toHandle(p))
+		case attrFileid, attrMountedOnFileid:
+			vals.Uint64(fileID(p))
+		case attrFilesAvail, attrFilesFree, attrFilesTotal:
+			vals.Uint64(1 << 40)
+		case attrMaxfilesize:
+			vals.Uint64(1 << 62)
+		case attrMaxlink:
+			vals.Uint32(1)
+		case attrMaxname:
+			vals.Uint32(maxNameLength)
+		case attrMaxread, attrMaxwrite:
+			vals.Uint64(maxIO)
+		case attrMode:
+			vals.Uint32(uint32(node.Mode().Perm()))
+		case attrNumlinks:
+			if node.IsDir() {
+				vals.Uint32(2)
+			} else {
+				vals.Uint32(1)
+			}
+		case attrOwner:
+			vals.String(strconv.FormatUint(uint64(opt.UID), 10))
+		case attrOwnerGroup:
+			vals.String(strconv.FormatUint(uint64(opt.GID), 10))
+		case attrRawdev:
+			vals.Uint32(0)
+			vals.Uint32(0)
+		case attrSpaceAvail, attrSpaceFree:
+			vals.Uint64(uint64(free))
+		case attrSpaceTotal:
+			vals.Uint64(uint64(total))
+		case attrSpaceUsed:
+			vals.Uint64(uint64(node.Size()))
+		case attrTimeAccess, attrTimeMetadata, attrTimeModify:
+			writeTime(vals, node.ModTime())
+		case attrTimeDelta:
+			writeTime(vals, time.Unix(0, 1))
+		case attrSuppattrExclcreat:
+			vals.Bitmap(settableAttrs)
+		}
+	}
+	w.Bitmap(set)
+	w.Opaque(vals.Bytes())
+}
+
+// setAttrs are the attributes decoded from a fattr4 for setting
+type setAttrs struct {
+	mask       xdr.Bitmap // attributes given
+	size       uint64
+	mtime      time.Time
+	mtimeIsNow bool // set the modification time to the server time
+}
+
+// readAttrs reads a fattr4 of attributes to set
+func readAttrs(r *xdr.Reader) (a setAttrs, status nfsStatus) {
+	a.mask = r.Bitmap()
+	vals := xdr.NewReader(r.Opaque(maxRecordSize))
+	if r.Err() != nil {
+		return a, nfs4errBadxdr
+	}
+	for _, bit := range a.mask.Bits() {
+		if !settableAttrs.IsSet(bit) {
+			if supportedAttrs.IsSet(bit) {
+				return a, nfs4errInval
+			}
+			return a, nfs4errAttrnotsupp
+		}
+		switch bit {
+		case attrSize:
+			a.size = vals.Uint64()
+		case attrMode:
+			_ = vals.Uint32() // permissions can't be set
+		case attrOwner, attrOwnerGroup:
+			_ = vals.String(1024) // owners can't be set
+		case attrTimeAccessSet, attrTimeModifySet:
+			how := vals.Uint32()
+			isNow := how == setToServerTime
+			var t time.Time
+			if how == setToClientTime {
+				t = readTime(vals)
+			} else if how != setToServerTime {
+				return a, nfs4errInval
+			}
+			if bit == attrTimeModifySet {
+				a.mtime, a.mtimeIsNow = t, isNow
+			}
+		}
+	}
+	if vals.Err() != nil {
+		return a, nfs4errBadxdr
+	}
+	return a, nfs4OK
+}
@@ -0,0 +1,262 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"path"
+
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+)
+
+// compound is the state of a COMPOUND being run
+type compound struct {
+	s        *server
+	vfs      *vfs.VFS   // what the call is served from - nil if the user isn't allowed
+	user     *auth.User // user from the user database if set
+	sess     *session   // set by SEQUENCE
+	slot     *slot      // slot used by SEQUENCE
+	cache    bool       // set if the reply should be cached in the slot
+	cur      string     // path of the current filehandle
+	haveCur  bool       // set if there is a current filehandle
+	saved    string     // path of the saved filehandle
+	haveSave bool       // set if there is a saved filehandle
+	curSid   stateid    // the current stateid
+}
+
+// opFunc runs an operation reading its arguments from r and writing
+// its result after the status to w.
+//
+// The result should only be written if the status is nfs4OK unless
+// the operation returns a result with errors too.
+type opFunc func(c *compound, r *xdr.Reader, w *xdr.Writer) nfsStatus
+
+// ops is the operations implemented by the server
+var ops map[uint32]opFunc
+
+func init() {
+	ops = map[uint32]opFunc{
+		opAccess:            (*compound).opAccess,
+		opClose:             (*compound).opClose,
+		opCommit:            (*compound).opCommit,
+		opCreate:            (*compound).opCreate,
+		opGetattr:           (*compound).opGetattr,
+		opGetfh:             (*compound).opGetfh,
+		opLock:              (*compound).opLock,
+		opLockt:             (*compound).opLockt,
+		opLocku:             (*compound).opLocku,
+		opLookup:            (*compound).opLookup,
+		opLookupp:           (*compound).opLookupp,
+		opOpen:              (*compound).opOpen,
+		opOpenDowngrade:     (*compound).opOpenDowngrade,
+		opPutfh:             (*compound).opPutfh,
+		opPutpubfh:          (*compound).opPutrootfh,
+		opPutrootfh:         (*compound).opPutrootfh,
+		opRead:              (*compound).opRead,
+		opReaddir:           (*compound).opReaddir,
+		opReadlink:          (*compound).opReadlink,
+		opRemove:            (*compound).opRemove,
+		opRename:            (*compound).opRename,
+		opRestorefh:         (*compound).opRestorefh,
+		opSavefh:            (*compound).opSavefh,
+		opSecinfo:           (*compound).opSecinfo,
+		opSetattr:           (*compound).opSetattr,
+		opWrite:             (*compound).opWrite,
+		opBindConnToSession: (*compound).opBindConnToSession,
+		opExchangeID:        (*compound).opExchangeID,
+		opCreateSession:     (*compound).opCreateSession,
+		opDestroySession:    (*compound).opDestroySession,
+		opFreeStateid:       (*compound).opFreeStateid,
+		opSecinfoNoName:     (*compound).opSecinfoNoName,
+		opTestStateid:       (*compound).opTestStateid,
+		opDestroyClientid:   (*compound).opDestroyClientid,
+		opReclaimComplete:   (*compound).opReclaimComplete,
+	}
+}
+
+// notSupported are the operations which are valid but not supported
+var notSupported = map[uint32]bool{
+	opDelegreturn:      true,
+	opLink:             true,
+	opNverify:          true,
+	opOpenattr:         true,
+	opVerify:           true,
+	opReleaseLockowner: true,
+	opBackchannelCtl:   true,
+}
+
+// sessionless are the operations which may be used without a
+// SEQUENCE as long as they are the only operation
+var sessionless = map[uint32]bool{
+	opExchangeID:        true,
+	opCreateSession:     true,
+	opDestroySession:    true,
+	opBindConnToSession: true,
+	opDestroyClientid:   true,
+}
+
+// compound runs a COMPOUND procedure for user reading the arguments
+// from r and writing the reply to w.
+//
+// user is the user of the user database the call is from or nil if
+// there isn't one.
+func (s *server) compound(r *xdr.Reader, w *xdr.Writer, user *auth.User) {
+	tag := r.Opaque(maxNameLength)
+	minorVersion := r.Uint32()
+	nOps := r.Uint32()
+	reply := func(status nfsStatus, results []byte, n uint32) {
+		w.Uint32(uint32(status))
+		w.Opaque(tag)
+		w.Uint32(n)
+		w.Fixed(results)
+	}
+	if r.Err() != nil {
+		reply(nfs4errBadxdr, nil, 0)
+		return
+	}
+	if minorVersion != nfsMinor {
+		reply(nfs4errMinorVersMismatch, nil, 0)
+		return
+	}
+	if nOps > maxOps {
+		reply(nfs4errTooManyOps, nil, 0)
+		return
+	}
+
+	c := &compound{s: s, vfs: s.vfs, user: user}
+	if s.authDB != nil {
+		c.vfs = nil
+		if user != nil {
+			c.vfs = user.VFS
+		}
+	}
+	res := &xdr.Writer{}
+	status := nfs4OK
+	n := uint32(0)
+	for i := uint32(0); i < nOps && status == nfs4OK; i++ {
+		op := r.Uint32()
+		if r.Err() != nil {
+			status = nfs4errBadxdr
+			break
+		}
+		n++
+		if op == opSequence {
+			if i != 0 {
+				res.Uint32(op)
+				status = nfs4errSequencePos
+				res.Uint32(uint32(status))
+				break
+			}
+			var replay []byte
+			replay, status = c.sequence(r, res, nOps)
+			if replay != nil {
+				// retry of a request so send the cached reply
+				w.Fixed(replay)
+				return
+			}
+			continue
+		}
+		res.Uint32(op)
+		fn := ops[op]
+		switch {
+		case fn == nil && notSupported[op]:
+			status = nfs4errNotsupp
+		case fn == nil:
+			res.Truncate(res.Len() - 4)
+			res.Uint32(opIllegal)
+			status = nfs4errOpIllegal
+		case i == 0 && !sessionless[op]:
+			status = nfs4errOpNotInSession
+		case i == 0 && nOps != 1:
+			status = nfs4errNotOnlyOp
+		}
+		if status != nfs4OK {
+			res.Uint32(uint32(status))
+			break
+		}
+		opRes := &xdr.Writer{}
+		status = fn(c, r, opRes)
+		if r.Err() != nil && status == nfs4OK {
+			status = nfs4errBadxdr
+			opRes.Truncate(0)
+		}
+		if status != nfs4OK {
+			fs.Debugf(c.cur, "serve nfs: op %d failed: %d", op, status)
+		}
+		res.Uint32(uint32(status))
+		res.Fixed(opRes.Bytes())
+	}
+
+	start := w.Len()
+	reply(status, res.Bytes(), n)
+	c.finishSlot(w.Bytes()[start:])
+}
+
+// finishSlot stores the reply in the slot if the compound used one
+// and releases it
+func (c *compound) finishSlot(reply []byte) {
+	if c.slot == nil {
+		return
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.cache {
+		c.slot.reply = append([]byte(nil), reply...)
+	} else {
+		c.slot.reply = nil
+	}
+	c.slot.cached = c.cache
+	c.slot.inUse = false
+}
+
+// curPath returns the path of the current filehandle
+func (c *compound) curPath() (string, nfsStatus) {
+	if !c.haveCur {
+		return "", nfs4errNofilehandle
+	}
+	return c.cur, nfs4OK
+}
+
+// setCur sets the current filehandle to path
+func (c *compound) setCur(p string) {
+	c.cur = p
+	c.haveCur = true
+}
+
+// childPath returns the path of name in the directory which is the
+// current filehandle, checking the name is valid
+func (c *compound) childPath(name string) (string, nfsStatus) {
+	status := checkName(name)
+	if status != nfs4OK {
+		return "", status
+	}
+	dirPath, status := c.curPath()
+	if status != nfs4OK {
+		return "", status
+	}
+	_, status = c.dir(dirPath)
+	if status != nfs4OK {
+		return "", status
+	}
+	return path.Join(dirPath, name), nfs4OK
+}
+
+// checkName checks a component4 is a valid file name
+func checkName(name string) nfsStatus {
+	switch {
+	case name == "":
+		return nfs4errInval
+	case len(name) > maxNameLength:
+		return nfs4errNametoolong
+	case name == "." || name == "..":
+		return nfs4errBadname
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' || name[i] == 0 {
+			return nfs4errBadname
+		}
+	}
+	return nfs4OK
+}
@@ -0,0 +1,243 @@
+// +build !plan9,!js
+
+package nfs
+
+// ONC RPC constants from RFC 5531
+const (
+	rpcVersion = 2
+
+	rpcCall  = 0
+	rpcReply = 1
+
+	rpcMsgAccepted = 0
+	rpcMsgDenied   = 1
+
+	rpcSuccess      = 0
+	rpcProgUnavail  = 1
+	rpcProgMismatch = 2
+	rpcProcUnavail  = 3
+	rpcGarbageArgs  = 4
+
+	rpcMismatch  = 0
+	rpcAuthError = 1
+
+	rpcAuthBadCred = 1
+
+	authNone = 0
+	authSys  = 1
+)
+
+// NFS program and procedure numbers
+const (
+	nfsProgram   = 100003
+	nfsVersion   = 4
+	nfsMinor     = 1
+	procNull     = 0
+	procCompound = 1
+)
+
+// nfsStatus is an nfsstat4 from RFC 8881
+type nfsStatus uint32
+
+// NFS status codes used by the server
+const (
+	nfs4OK                   nfsStatus = 0
+	nfs4errPerm              nfsStatus = 1
+	nfs4errNoent             nfsStatus = 2
+	nfs4errIO                nfsStatus = 5
+	nfs4errAccess            nfsStatus = 13
+	nfs4errExist             nfsStatus = 17
+	nfs4errNotdir            nfsStatus = 20
+	nfs4errIsdir             nfsStatus = 21
+	nfs4errInval             nfsStatus = 22
+	nfs4errNospc             nfsStatus = 28
+	nfs4errRofs              nfsStatus = 30
+	nfs4errNametoolong       nfsStatus = 63
+	nfs4errNotempty          nfsStatus = 66
+	nfs4errStale             nfsStatus = 70
+	nfs4errBadhandle         nfsStatus = 10001
+	nfs4errBadCookie         nfsStatus = 10003
+	nfs4errNotsupp           nfsStatus = 10004
+	nfs4errToosmall          nfsStatus = 10005
+	nfs4errBadtype           nfsStatus = 10007
+	nfs4errDelay             nfsStatus = 10008
+	nfs4errDenied            nfsStatus = 10010
+	nfs4errNofilehandle      nfsStatus = 10020
+	nfs4errMinorVersMismatch nfsStatus = 10021
+	nfs4errStaleClientid     nfsStatus = 10022
+	nfs4errBadStateid        nfsStatus = 10025
+	nfs4errRestorefh         nfsStatus = 10030
+	nfs4errAttrnotsupp       nfsStatus = 10032
+	nfs4errBadxdr            nfsStatus = 10036
+	nfs4errLocksHeld         nfsStatus = 10037
+	nfs4errOpenmode          nfsStatus = 10038
+	nfs4errBadname           nfsStatus = 10041
+	nfs4errOpIllegal         nfsStatus = 10044
+	nfs4errBadsession        nfsStatus = 10052
+	nfs4errBadslot           nfsStatus = 10053
+	nfs4errCompleteAlready   nfsStatus = 10054
+	nfs4errSeqMisordered     nfsStatus = 10063
+	nfs4errSequencePos       nfsStatus = 10064
+	nfs4errRetryUncachedRep  nfsStatus = 10068
+	nfs4errTooManyOps        nfsStatus = 10070
+	nfs4errOpNotInSession    nfsStatus = 10071
+	nfs4errClientidBusy      nfsStatus = 10074
+	nfs4errNotOnlyOp         nfsStatus = 10081
+	nfs4errEncrAlgUnsupp     nfsStatus = 10079
+)
+
+// NFS operation numbers
+const (
+	opAccess            = 3
+	opClose             = 4
+	opCommit            = 5
+	opCreate            = 6
+	opDelegreturn       = 8
+	opGetattr           = 9
+	opGetfh             = 10
+	opLink              = 11
+	opLock              = 12
+	opLockt             = 13
+	opLocku             = 14
+	opLookup            = 15
+	opLookupp           = 16
+	opNverify           = 17
+	opOpen              = 18
+	opOpenattr          = 19
+	opOpenDowngrade     = 21
+	opPutfh             = 22
+	opPutpubfh          = 23
+	opPutrootfh         = 24
+	opRead              = 25
+	opReaddir           = 26
+	opReadlink          = 27
+	opRemove            = 28
+	opRename            = 29
+	opRestorefh         = 31
+	opSavefh            = 32
+	opSecinfo           = 33
+	opSetattr           = 34
+	opVerify            = 37
+	opWrite             = 38
+	opReleaseLockowner  = 39
+	opBackchannelCtl    = 40
+	opBindConnToSession = 41
+	opExchangeID        = 42
+	opCreateSession     = 43
+	opDestroySession    = 44
+	opFreeStateid       = 45
+	opSecinfoNoName     = 52
+	opSequence          = 53
+	opTestStateid       = 55
+	opDestroyClientid   = 57
+	opReclaimComplete   = 58
+	opIllegal           = 10044
+)
+
+// File types
+const (
+	nf4Reg = 1
+	nf4Dir = 2
+)
+
+// Attribute numbers
+const (
+	attrSupportedAttrs    = 0
+	attrType              = 1
+	attrFhExpireType      = 2
+	attrChange            = 3
+	attrSize              = 4
+	attrLinkSupport       = 5
+	attrSymlinkSupport    = 6
+	attrNamedAttr         = 7
+	attrFsid              = 8
+	attrUniqueHandles     = 9
+	attrLeaseTime         = 10
+	attrRdattrError       = 11
+	attrCansettime        = 15
+	attrCaseInsensitive   = 16
+	attrCasePreserving    = 17
+	attrChownRestricted   = 18
+	attrFilehandle        = 19
+	attrFileid            = 20
+	attrFilesAvail        = 21
+	attrFilesFree         = 22
+	attrFilesTotal        = 23
+	attrHomogeneous       = 26
+	attrMaxfilesize       = 27
+	attrMaxlink           = 28
+	attrMaxname           = 29
+	attrMaxread           = 30
+	attrMaxwrite          = 31
+	attrMode              = 33
+	attrNoTrunc           = 34
+	attrNumlinks          = 35
+	attrOwner             = 36
+	attrOwnerGroup        = 37
+	attrRawdev            = 41
+	attrSpaceAvail        = 42
+	attrSpaceFree         = 43
+	attrSpaceTotal        = 44
+	attrSpaceUsed         = 45
+	attrTimeAccess        = 47
+	attrTimeAccessSet     = 48
+	attrTimeDelta         = 51
+	attrTimeMetadata      = 52
+	attrTimeModify        = 53
+	attrTimeModifySet     = 54
+	attrMountedOnFileid   = 55
+	attrSuppattrExclcreat = 75
+)
+
+// Access bits for ACCESS
+const (
+	access4Read    = 0x01
+	access4Lookup  = 0x02
+	access4Modify  = 0x04
+	access4Extend  = 0x08
+	access4Delete  = 0x10
+	access4Execute = 0x20
+)
+
+// OPEN constants
+const (
+	open4ShareAccessWrite = 2
+	open4ShareAccessBoth  = 3
+
+	open4Create = 1
+
+	createUnchecked  = 0
+	createGuarded    = 1
+	createExclusive  = 2
+	createExclusive1 = 3
+
+	claimNull     = 0
+	claimPrevious = 1
+	claimFH       = 4
+
+	open4ResultLocktypePosix = 0x04
+
+	openDelegateNone = 0
+)
+
+// Lock types
+const (
+	readLt   = 1
+	writeLt  = 2
+	writewLt = 4
+)
+
+// Other constants
+const (
+	fileSync4       = 2 // WRITE stable_how FILE_SYNC4
+	fh4VolRename    = 0x08
+	setToServerTime = 0
+	setToClientTime = 1
+
+	exchgid4FlagUseNonPNFS = 0x00010000
+	exchgid4FlagConfirmedR = 0x80000000
+
+	sp4None = 0
+
+	cdfs4Fore = 0x1
+)
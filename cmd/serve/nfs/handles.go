@@ -0,0 +1,160 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/file"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	handleMaxSize  = 128 // NFS4_FHSIZE
+	handlePath     = 1   // handle is handlePath followed by the path
+	handleHash     = 2   // handle is handleHash followed by the SHA256 of the path
+	handlesBucket  = "handles"
+	handlesVersion = "1"
+)
+
+// handleStore makes NFS file handles from the paths in the VFS and
+// turns them back into paths.
+//
+// The handles have to stay the same across restarts of the server so
+// the clients don't get stale file handle errors. Paths short enough
+// to fit in a handle are put in it directly. Longer paths are
+// replaced with their hash and remembered in a bolt database in the
+// --cache-dir so they can be found again after a restart.
+type handleStore struct {
+	mu    sync.Mutex
+	db    *bolt.DB          // database of the hashed handles
+	paths map[string]string // hashed handle to path
+}
+
+// newHandleStore opens the handle database for f
+func newHandleStore(f fs.Fs) (*handleStore, error) {
+	hs := &handleStore{
+		paths: make(map[string]string),
+	}
+	dbPath, err := handleStorePath(f)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(dbPath), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create file handle directory")
+	}
+	hs.db, err = bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file handle database %q - is another rclone using it?", dbPath)
+	}
+	fs.Debugf(f, "serve nfs: file handle database is %q", dbPath)
+	err = hs.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(handlesBucket))
+		return err
+	})
+	if err != nil {
+		_ = hs.db.Close()
+		return nil, errors.Wrap(err, "failed to create file handle bucket")
+	}
+	return hs, nil
+}
+
+// handleStorePath returns the path of the database for f in the --cache-dir
+func handleStorePath(f fs.Fs) (string, error) {
+	fRoot := filepath.FromSlash(f.Root())
+	if runtime.GOOS == "windows" {
+		if strings.HasPrefix(fRoot, `\\?`) {
+			fRoot = fRoot[3:]
+		}
+		fRoot = strings.Replace(fRoot, ":", "", -1)
+	}
+	cacheDir, err := filepath.Abs(config.CacheDir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make --cache-dir absolute")
+	}
+	return file.UNCPath(filepath.Join(cacheDir, "serve-nfs", f.Name(), fRoot, "handles.v"+handlesVersion+".db")), nil
+}
+
+// toHandle returns the file handle for path
+func (hs *handleStore) toHandle(path string) []byte {
+	if len(path) < handleMaxSize {
+		return append([]byte{handlePath}, path...)
+	}
+	sum := sha256.Sum256([]byte(path))
+	handle := append([]byte{handleHash}, sum[:]...)
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if _, found := hs.paths[string(handle)]; found {
+		return handle
+	}
+	hs.paths[string(handle)] = path
+	err := hs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(handlesBucket)).Put(handle, []byte(path))
+	})
+	if err != nil {
+		fs.Errorf(path, "serve nfs: failed to save file handle: %v", err)
+	}
+	return handle
+}
+
+// toPath returns the path for handle or false if it isn't known
+func (hs *handleStore) toPath(handle []byte) (path string, ok bool) {
+	if len(handle) == 0 {
+		return "", false
+	}
+	switch handle[0] {
+	case handlePath:
+		return string(handle[1:]), true
+	case handleHash:
+	default:
+		return "", false
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	path, ok = hs.paths[string(handle)]
+	if ok {
+		return path, true
+	}
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket([]byte(handlesBucket)).Get(handle); value != nil {
+			path, ok = string(value), true
+		}
+		return nil
+	})
+	if err != nil {
+		fs.Errorf(nil, "serve nfs: failed to read file handle: %v", err)
+	}
+	if ok {
+		hs.paths[string(handle)] = path
+	}
+	return path, ok
+}
+
+// close the database
+func (hs *handleStore) close() {
+	err := hs.db.Close()
+	if err != nil {
+		fs.Errorf(nil, "serve nfs: failed to close file handle database: %v", err)
+	}
+}
+
+// fileID returns the fileid for path which is stable across restarts
+func fileID(path string) uint64 {
+	sum := sha256.Sum256([]byte(path))
+	id := binary.BigEndian.Uint64(sum[:8])
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
@@ -0,0 +1,135 @@
+// Package nfs implements an NFSv4.1 server to serve an rclone VFS
+
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Options contains options for the NFS Server
+type Options struct {
+	ListenAddr string // Port to listen on
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	ListenAddr: "localhost:2049",
+}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// AddFlags adds flags for the nfs
+func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
+	rc.AddOption("nfs", &Opt)
+	flags.StringVarP(flagSet, &Opt.ListenAddr, "addr", "", Opt.ListenAddr, "IPaddress:Port or :Port to bind server to.")
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags(), &Opt)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "nfs remote:path",
+	Short: `Serve the remote as an NFSv4.1 server.`,
+	Long: `rclone serve nfs implements an NFS version 4.1 server to serve the
+remote.  This lets the remote be mounted by any NFS client which
+supports NFSv4.1, for example Linux and VMware ESXi, without needing
+FUSE on the client.
+
+You can use the filter flags (e.g. --include, --exclude) to control what
+is served.
+
+The server will log errors.  Use -v to see access logs.
+
+--bwlimit will be respected for file transfers.  Use --stats to
+control the stats printing.
+
+By default the server binds to localhost:2049 - if you want it to be
+reachable externally then supply "--addr :2049" for example.  The
+server doesn't do any authentication, so only make it reachable on
+networks you trust.
+
+To mount the server on Linux use something like
+
+    mount -t nfs -o vers=4.1,port=2049 localhost:/ /mnt/rclone
+
+Only NFS version 4.1 is supported - there is no MOUNT protocol or
+portmapper so the client must be told to use version 4.1.
+
+### Users
+
+NFS clients send the numeric user ID of the user making each call
+without a password, so the server can't check who they are.  If
+--auth-users is set the user ID of each call is looked up in the
+"uid" settings of the user database and the call is served from the
+root of that user, read only users can't change anything, and the
+bandwidth limits of the users are respected.  Calls from user IDs
+which aren't in the file are refused, which includes the calls the
+client makes as root when mounting unless root has a "uid=0" line.
+This only stops users of a trusted client seeing each other's files -
+anyone who can reach the server can still claim to be any user.
+
+### File handles
+
+NFS clients refer to files by file handles which they expect to stay
+valid for as long as the file exists, including across restarts of
+the server.  rclone makes the file handle from the path of the file so
+handles stay the same when the server is restarted.  Handles for long
+paths are stored in a database in the cache directory (set with
+--cache-dir) so the same cache directory should be used each time the
+remote is served.
+
+A handle refers to the path of the file, so if a file is renamed by
+something other than the NFS server, clients will see the old handle
+as stale and look the file up again.
+
+### Caching
+
+NFS clients write files in blocks which may arrive out of order and
+may set the size of a file after opening it, so it is recommended that
+--vfs-cache-mode writes (or full) is used.  With --vfs-cache-mode off
+files can only be written sequentially.
+
+### Limitations
+
+Byte range locks are supported. They are held in the VFS so they only
+work between clients of the same server and anything else using the
+same VFS in the same rclone process - see the VFS Locking section
+below.  Symlinks, hard links, named attributes and delegations aren't
+supported.  Permissions and owners can't be changed and attempts to
+change them are ignored.
+
+` + auth.Help + vfs.Help,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt, &authflags.Opt)
+			if err != nil {
+				return err
+			}
+			err = s.Serve()
+			if err != nil {
+				return err
+			}
+			s.Wait()
+			return nil
+		})
+	},
+}
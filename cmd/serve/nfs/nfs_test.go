@@ -0,0 +1,608 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a minimal NFSv4.1 client for testing the server
+type testClient struct {
+	t         *testing.T
+	c         net.Conn
+	br        *bufio.Reader
+	xid       uint32
+	clientID  uint64
+	sessionID [16]byte
+	seqid     uint32
+	cred      []byte // AUTH_SYS credentials to send or nil for AUTH_NONE
+}
+
+// newTestClient connects to the server and makes a session
+func newTestClient(t *testing.T, addr string) *testClient {
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	tc := &testClient{t: t, c: c, br: bufio.NewReader(c)}
+
+	// EXCHANGE_ID
+	r := tc.compound(false, 1, func(w *xdr.Writer) {
+		w.Uint32(opExchangeID)
+		w.Fixed([]byte("verifier"))
+		w.String("test client")
+		w.Uint32(0) // flags
+		w.Uint32(sp4None)
+		w.Uint32(0) // no client_impl_id
+	})
+	tc.expectOp(r, opExchangeID, nfs4OK)
+	tc.clientID = r.Uint64()
+	seq := r.Uint32()
+
+	// CREATE_SESSION
+	r = tc.compound(false, 1, func(w *xdr.Writer) {
+		w.Uint32(opCreateSession)
+		w.Uint64(tc.clientID)
+		w.Uint32(seq)
+		w.Uint32(0) // flags
+		fore := channelAttrs{maxRequestSize: 1 << 20, maxResponseSize: 1 << 20, maxOperations: 16, maxRequests: 4}
+		fore.write(w)
+		back := channelAttrs{maxRequests: 1}
+		back.write(w)
+		w.Uint32(0) // cb_program
+		w.Uint32(0) // no callback security
+	})
+	tc.expectOp(r, opCreateSession, nfs4OK)
+	copy(tc.sessionID[:], r.Fixed(16))
+	require.NoError(t, r.Err())
+	return tc
+}
+
+// close the connection
+func (tc *testClient) close() {
+	require.NoError(tc.t, tc.c.Close())
+}
+
+// compound sends a COMPOUND with nOps operations written by args,
+// prefixed with a SEQUENCE if sequence is set, and returns a reader
+// positioned at the result of the first operation after the
+// SEQUENCE.
+func (tc *testClient) compound(sequence bool, nOps uint32, args func(w *xdr.Writer)) *xdr.Reader {
+	t := tc.t
+	tc.xid++
+	w := &xdr.Writer{}
+	w.Uint32(tc.xid)
+	w.Uint32(rpcCall)
+	w.Uint32(rpcVersion)
+	w.Uint32(nfsProgram)
+	w.Uint32(nfsVersion)
+	w.Uint32(procCompound)
+	if tc.cred != nil {
+		w.Uint32(authSys)
+		w.Opaque(tc.cred)
+	} else {
+		w.Uint32(authNone)
+		w.Opaque(nil)
+	}
+	w.Uint32(authNone)
+	w.Opaque(nil)
+	w.String("tag")
+	w.Uint32(nfsMinor)
+	if sequence {
+		tc.seqid++
+		w.Uint32(nOps + 1)
+		w.Uint32(opSequence)
+		w.Fixed(tc.sessionID[:])
+		w.Uint32(tc.seqid)
+		w.Uint32(0) // slot
+		w.Uint32(0) // highest slot
+		w.Bool(false)
+	} else {
+		w.Uint32(nOps)
+	}
+	args(w)
+
+	record := &xdr.Writer{}
+	record.Uint32(0x80000000 | uint32(w.Len()))
+	record.Fixed(w.Bytes())
+	_, err := tc.c.Write(record.Bytes())
+	require.NoError(t, err)
+
+	reply, err := readRecord(tc.br)
+	require.NoError(t, err)
+	r := xdr.NewReader(reply)
+	assert.Equal(t, tc.xid, r.Uint32())
+	assert.Equal(t, uint32(rpcReply), r.Uint32())
+	assert.Equal(t, uint32(rpcMsgAccepted), r.Uint32())
+	_ = r.Uint32() // verifier
+	_ = r.Opaque(rpcCredMax)
+	require.Equal(t, uint32(rpcSuccess), r.Uint32())
+	_ = r.Uint32() // status
+	assert.Equal(t, "tag", r.String(maxNameLength))
+	_ = r.Uint32() // number of results
+	if sequence {
+		tc.expectOp(r, opSequence, nfs4OK)
+		_ = r.Fixed(16 + 5*4)
+	}
+	require.NoError(t, r.Err())
+	return r
+}
+
+// expectOp reads the op and status of a result checking them
+func (tc *testClient) expectOp(r *xdr.Reader, op uint32, status nfsStatus) {
+	require.Equal(tc.t, op, r.Uint32())
+	require.Equal(tc.t, status, nfsStatus(r.Uint32()))
+}
+
+// putPath writes a PUTROOTFH followed by a LOOKUP for each element of p
+// returning the number of operations written
+func putPath(w *xdr.Writer, p string) uint32 {
+	w.Uint32(opPutrootfh)
+	n := uint32(1)
+	if p == "" {
+		return n
+	}
+	for _, name := range strings.Split(p, "/") {
+		w.Uint32(opLookup)
+		w.String(name)
+		n++
+	}
+	return n
+}
+
+// skipPutPath reads the results written by putPath
+func (tc *testClient) skipPutPath(r *xdr.Reader, p string) {
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	if p == "" {
+		return
+	}
+	for range strings.Split(p, "/") {
+		tc.expectOp(r, opLookup, nfs4OK)
+	}
+}
+
+// writeOpen writes an OPEN of name in the current directory
+func (tc *testClient) writeOpen(w *xdr.Writer, name string, access uint32, create bool) {
+	w.Uint32(opOpen)
+	w.Uint32(0) // seqid
+	w.Uint32(access)
+	w.Uint32(0) // deny
+	w.Uint64(tc.clientID)
+	w.String("owner")
+	if create {
+		w.Uint32(open4Create)
+		w.Uint32(createUnchecked)
+		w.Bitmap(nil)
+		w.Opaque(nil)
+	} else {
+		w.Uint32(0)
+	}
+	w.Uint32(claimNull)
+	w.String(name)
+}
+
+// readOpen reads the result of an OPEN returning the stateid
+func (tc *testClient) readOpen(r *xdr.Reader) stateid {
+	tc.expectOp(r, opOpen, nfs4OK)
+	sid := readStateid(r)
+	_ = r.Fixed(4 + 8 + 8) // cinfo
+	_ = r.Uint32()         // rflags
+	_ = r.Bitmap()         // attrset
+	assert.Equal(tc.t, uint32(openDelegateNone), r.Uint32())
+	require.NoError(tc.t, r.Err())
+	return sid
+}
+
+// writeFile creates the file at dir/name containing contents
+func (tc *testClient) writeFile(dir, name, contents string) {
+	r := tc.compound(true, putPath(&xdr.Writer{}, dir)+3, func(w *xdr.Writer) {
+		putPath(w, dir)
+		tc.writeOpen(w, name, open4ShareAccessBoth, true)
+		w.Uint32(opWrite)
+		writeStateid(w, currentStateid)
+		w.Uint64(0)
+		w.Uint32(fileSync4)
+		w.Opaque([]byte(contents))
+		w.Uint32(opClose)
+		w.Uint32(0)
+		writeStateid(w, currentStateid)
+	})
+	tc.skipPutPath(r, dir)
+	tc.readOpen(r)
+	tc.expectOp(r, opWrite, nfs4OK)
+	assert.Equal(tc.t, uint32(len(contents)), r.Uint32())
+	assert.Equal(tc.t, uint32(fileSync4), r.Uint32())
+	_ = r.Fixed(8)
+	tc.expectOp(r, opClose, nfs4OK)
+}
+
+// readFile reads the file at p using the anonymous stateid
+func (tc *testClient) readFile(p string) string {
+	r := tc.compound(true, putPath(&xdr.Writer{}, p)+1, func(w *xdr.Writer) {
+		putPath(w, p)
+		w.Uint32(opRead)
+		writeStateid(w, anonymousStateid)
+		w.Uint64(0)
+		w.Uint32(1024)
+	})
+	tc.skipPutPath(r, p)
+	tc.expectOp(r, opRead, nfs4OK)
+	assert.True(tc.t, r.Bool())
+	data := r.Opaque(1024)
+	require.NoError(tc.t, r.Err())
+	return string(data)
+}
+
+// listDir returns the names in the directory at p
+func (tc *testClient) listDir(p string) (names []string) {
+	r := tc.compound(true, putPath(&xdr.Writer{}, p)+1, func(w *xdr.Writer) {
+		putPath(w, p)
+		w.Uint32(opReaddir)
+		w.Uint64(0)
+		w.Fixed(make([]byte, 8))
+		w.Uint32(8192)
+		w.Uint32(8192)
+		w.Bitmap(xdr.NewBitmap(attrType, attrSize))
+	})
+	tc.skipPutPath(r, p)
+	tc.expectOp(r, opReaddir, nfs4OK)
+	_ = r.Fixed(8)
+	for r.Bool() {
+		_ = r.Uint64() // cookie
+		names = append(names, r.String(maxNameLength))
+		_ = r.Bitmap()
+		_ = r.Opaque(1024)
+	}
+	assert.True(tc.t, r.Bool())
+	require.NoError(tc.t, r.Err())
+	return names
+}
+
+// getfh returns the filehandle of p
+func (tc *testClient) getfh(p string) []byte {
+	r := tc.compound(true, putPath(&xdr.Writer{}, p)+1, func(w *xdr.Writer) {
+		putPath(w, p)
+		w.Uint32(opGetfh)
+	})
+	tc.skipPutPath(r, p)
+	tc.expectOp(r, opGetfh, nfs4OK)
+	fh := r.Opaque(handleMaxSize)
+	require.NoError(tc.t, r.Err())
+	return fh
+}
+
+// setUID makes the client send AUTH_SYS credentials for uid
+func (tc *testClient) setUID(uid uint32) {
+	w := &xdr.Writer{}
+	w.Uint32(0) // stamp
+	w.String("test machine")
+	w.Uint32(uid)
+	w.Uint32(uid) // gid
+	w.Uint32(0)   // no gids
+	tc.cred = w.Bytes()
+}
+
+// newTestServer makes a server serving a local directory
+func newTestServer(t *testing.T, dir string) *server {
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	s, err := newServer(context.Background(), f, &opt, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.serve())
+	return s
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-nfs")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0777))
+
+	s := newTestServer(t, root)
+	tc := newTestClient(t, s.Addr())
+
+	// make a directory
+	r := tc.compound(true, 2, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+		w.Uint32(opCreate)
+		w.Uint32(nf4Dir)
+		w.String("dir")
+		w.Bitmap(nil)
+		w.Opaque(nil)
+	})
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	tc.expectOp(r, opCreate, nfs4OK)
+	assert.Equal(t, []string{"dir"}, tc.listDir(""))
+
+	// write and read files
+	tc.writeFile("dir", "file.txt", "hello world")
+	assert.Equal(t, "hello world", tc.readFile("dir/file.txt"))
+	contents, err := ioutil.ReadFile(filepath.Join(root, "dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(contents))
+	assert.Equal(t, []string{"file.txt"}, tc.listDir("dir"))
+
+	// missing files
+	r = tc.compound(true, 2, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+		w.Uint32(opLookup)
+		w.String("missing")
+	})
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	tc.expectOp(r, opLookup, nfs4errNoent)
+
+	// rename the file
+	r = tc.compound(true, 4, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+		w.Uint32(opLookup)
+		w.String("dir")
+		w.Uint32(opSavefh)
+		w.Uint32(opRename)
+		w.String("file.txt")
+		w.String("renamed.txt")
+	})
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	tc.expectOp(r, opLookup, nfs4OK)
+	tc.expectOp(r, opSavefh, nfs4OK)
+	tc.expectOp(r, opRename, nfs4OK)
+	assert.Equal(t, []string{"renamed.txt"}, tc.listDir("dir"))
+
+	// a long path which needs its handle stored
+	long := strings.Repeat("x", 200)
+	tc.writeFile("dir", long, "long")
+	longHandle := tc.getfh("dir/" + long)
+	assert.Equal(t, byte(handleHash), longHandle[0])
+	tc.close()
+	s.Close()
+	s.Wait()
+
+	// restart the server and check the handles still work
+	s = newTestServer(t, root)
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	tc = newTestClient(t, s.Addr())
+	defer tc.close()
+	r = tc.compound(true, 2, func(w *xdr.Writer) {
+		w.Uint32(opPutfh)
+		w.Opaque(longHandle)
+		w.Uint32(opRead)
+		writeStateid(w, anonymousStateid)
+		w.Uint64(0)
+		w.Uint32(1024)
+	})
+	tc.expectOp(r, opPutfh, nfs4OK)
+	tc.expectOp(r, opRead, nfs4OK)
+	assert.True(t, r.Bool())
+	assert.Equal(t, "long", string(r.Opaque(1024)))
+
+	// remove the files
+	r = tc.compound(true, 4, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+		w.Uint32(opLookup)
+		w.String("dir")
+		w.Uint32(opRemove)
+		w.String("renamed.txt")
+		w.Uint32(opRemove)
+		w.String(long)
+	})
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	tc.expectOp(r, opLookup, nfs4OK)
+	tc.expectOp(r, opRemove, nfs4OK)
+	_ = r.Fixed(4 + 8 + 8)
+	tc.expectOp(r, opRemove, nfs4OK)
+	assert.Equal(t, []string(nil), tc.listDir("dir"))
+
+	// the handle is now stale
+	r = tc.compound(true, 2, func(w *xdr.Writer) {
+		w.Uint32(opPutfh)
+		w.Opaque(longHandle)
+		w.Uint32(opGetattr)
+		w.Bitmap(xdr.NewBitmap(attrSize))
+	})
+	tc.expectOp(r, opPutfh, nfs4OK)
+	tc.expectOp(r, opGetattr, nfs4errStale)
+}
+
+func TestSequenceReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-nfs")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+
+	s := newTestServer(t, dir)
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	tc := newTestClient(t, s.Addr())
+	defer tc.close()
+
+	// sequence sends a compound containing only a SEQUENCE
+	sequence := func(seqid uint32) *xdr.Reader {
+		return tc.compound(false, 1, func(w *xdr.Writer) {
+			w.Uint32(opSequence)
+			w.Fixed(tc.sessionID[:])
+			w.Uint32(seqid)
+			w.Uint32(0) // slot
+			w.Uint32(0) // highest slot
+			w.Bool(false)
+		})
+	}
+
+	// a request which wasn't cached can't be retried
+	tc.compound(true, 1, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+	})
+	tc.expectOp(sequence(tc.seqid), opSequence, nfs4errRetryUncachedRep)
+
+	// skipping a sequence id is an error
+	tc.expectOp(sequence(tc.seqid+2), opSequence, nfs4errSeqMisordered)
+
+	// the next sequence id is fine
+	tc.expectOp(sequence(tc.seqid+1), opSequence, nfs4OK)
+
+	// a bad session is an error
+	tc.sessionID[0] ^= 0xFF
+	tc.expectOp(sequence(1), opSequence, nfs4errBadsession)
+}
+
+func TestLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-nfs")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0777))
+	s := newTestServer(t, root)
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	o := &openState{path: "file", vfs: s.vfs}
+	a := &lockState{open: o, clientID: 1, owner: "a"}
+	b := &lockState{open: o, clientID: 1, owner: "b"}
+
+	assert.Nil(t, s.lock(a, 0, 100, true))
+	assert.NotNil(t, s.conflictingLock(s.vfs, "file", 1, "b", 50, 60, false))
+	assert.Nil(t, s.conflictingLock(s.vfs, "file", 1, "a", 50, 60, true))
+	assert.Nil(t, s.conflictingLock(s.vfs, "file", 1, "b", 100, vfs.LockEOF, true))
+	assert.Nil(t, s.conflictingLock(s.vfs, "other", 1, "b", 0, vfs.LockEOF, true))
+
+	// unlocking the middle leaves two locks
+	s.unlock(a, 40, 60)
+	assert.Len(t, s.vfs.Locks("file"), 2)
+	assert.Nil(t, s.conflictingLock(s.vfs, "file", 1, "b", 40, 60, true))
+	assert.NotNil(t, s.conflictingLock(s.vfs, "file", 1, "b", 30, 50, true))
+
+	// read locks only conflict with write locks
+	assert.Nil(t, s.lock(b, 200, vfs.LockEOF, false))
+	assert.Nil(t, s.conflictingLock(s.vfs, "file", 1, "c", 300, 400, false))
+	assert.NotNil(t, s.conflictingLock(s.vfs, "file", 1, "c", 300, 400, true))
+	assert.NotNil(t, s.lock(a, 300, 400, true))
+	assert.True(t, s.holdsLocks(b))
+	s.unlock(b, 0, vfs.LockEOF)
+	assert.False(t, s.holdsLocks(b))
+
+	// locks taken by other users of the VFS conflict too
+	require.NoError(t, s.vfs.Lock("file", vfs.Lock{Owner: "mount", Write: true, Start: 500, End: 600}))
+	conflict := s.lock(b, 550, vfs.LockEOF, false)
+	require.NotNil(t, conflict)
+	w := &xdr.Writer{}
+	writeDenied(w, conflict)
+	r := xdr.NewReader(w.Bytes())
+	assert.Equal(t, uint64(500), r.Uint64())
+	assert.Equal(t, uint64(100), r.Uint64())
+	assert.Equal(t, uint32(writeLt), r.Uint32())
+	assert.Equal(t, uint64(0), r.Uint64())
+	assert.Equal(t, "", r.String(1024))
+
+	_, _, status := lockRangeOf(10, 0)
+	assert.Equal(t, nfs4errInval, status)
+	start, end, status := lockRangeOf(10, ^uint64(0))
+	assert.Equal(t, nfs4OK, status)
+	assert.Equal(t, int64(10), start)
+	assert.Equal(t, int64(vfs.LockEOF), end)
+}
+
+
+func TestUserDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-nfs")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0777))
+	aliceRoot := filepath.Join(dir, "alice")
+	require.NoError(t, os.Mkdir(aliceRoot, 0777))
+	users := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte(`alice "root=`+aliceRoot+`" uid=1000`+"\n"+`reader "root=`+aliceRoot+`" read-only uid=1001`+"\n"), 0600))
+
+	f, err := fs.NewFs(context.Background(), root)
+	require.NoError(t, err)
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	authOpt := auth.DefaultOpt
+	authOpt.Users = users
+	s, err := newServer(context.Background(), f, &opt, &authOpt)
+	require.NoError(t, err)
+	require.NoError(t, s.serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	// alice's files are written to her root
+	tc := newTestClient(t, s.Addr())
+	tc.setUID(1000)
+	tc.writeFile("", "alice.txt", "alice data")
+	contents, err := ioutil.ReadFile(filepath.Join(aliceRoot, "alice.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice data", string(contents))
+
+	// the read only user can read them but not write
+	tc.setUID(1001)
+	assert.Equal(t, "alice data", tc.readFile("alice.txt"))
+	r := tc.compound(true, 2, func(w *xdr.Writer) {
+		w.Uint32(opPutrootfh)
+		tc.writeOpen(w, "new.txt", open4ShareAccessBoth, true)
+	})
+	tc.expectOp(r, opPutrootfh, nfs4OK)
+	tc.expectOp(r, opOpen, nfs4errRofs)
+
+	// unknown users and AUTH_NONE can't use any files
+	putRoot := func() {
+		r := tc.compound(true, 1, func(w *xdr.Writer) {
+			w.Uint32(opPutrootfh)
+		})
+		tc.expectOp(r, opPutrootfh, nfs4errAccess)
+	}
+	tc.setUID(2000)
+	putRoot()
+	tc.cred = nil
+	putRoot()
+	tc.close()
+}
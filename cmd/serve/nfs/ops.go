@@ -0,0 +1,1100 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"io"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+)
+
+// toStatus converts a VFS error into an NFS status
+func toStatus(err error) nfsStatus {
+	if err == nil {
+		return nfs4OK
+	}
+	switch errors.Cause(err) {
+	case vfs.ENOENT:
+		return nfs4errNoent
+	case vfs.EEXIST:
+		return nfs4errExist
+	case vfs.ENOTEMPTY:
+		return nfs4errNotempty
+	case vfs.EPERM:
+		return nfs4errPerm
+	case vfs.EROFS:
+		return nfs4errRofs
+	case vfs.EINVAL, vfs.ESPIPE:
+		return nfs4errInval
+	case vfs.ENOSYS:
+		return nfs4errNotsupp
+	}
+	if fserrors.IsErrNoSpace(err) {
+		return nfs4errNospc
+	}
+	return nfs4errIO
+}
+
+// node returns the node and path of the current filehandle
+func (c *compound) node() (vfs.Node, string, nfsStatus) {
+	p, status := c.curPath()
+	if status != nfs4OK {
+		return nil, "", status
+	}
+	node, err := c.vfs.Stat(p)
+	if err == vfs.ENOENT {
+		// the object the filehandle refers to has gone
+		return nil, "", nfs4errStale
+	} else if err != nil {
+		return nil, "", toStatus(err)
+	}
+	return node, p, nfs4OK
+}
+
+// file returns the node and path of the current filehandle checking
+// it is a file
+func (c *compound) file() (vfs.Node, string, nfsStatus) {
+	node, p, status := c.node()
+	if status == nfs4OK && node.IsDir() {
+		return nil, "", nfs4errIsdir
+	}
+	return node, p, status
+}
+
+// dir returns the directory at path
+func (c *compound) dir(p string) (*vfs.Dir, nfsStatus) {
+	node, err := c.vfs.Stat(p)
+	if err == vfs.ENOENT {
+		return nil, nfs4errStale
+	} else if err != nil {
+		return nil, toStatus(err)
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return nil, nfs4errNotdir
+	}
+	return dir, nfs4OK
+}
+
+// writeChangeInfo writes a change_info4 for a directory
+func writeChangeInfo(w *xdr.Writer, before, after uint64) {
+	w.Bool(false) // atomic
+	w.Uint64(before)
+	w.Uint64(after)
+}
+
+// isSpecial returns whether the stateid is the anonymous or bypass
+// stateid which may be used to read and write without an OPEN
+func isSpecial(sid stateid) bool {
+	return sid.other == anonymousStateid.other || sid.other == bypassStateid.other
+}
+
+// resolveStateid replaces the current stateid with the one set by a
+// previous operation
+func (c *compound) resolveStateid(sid stateid) stateid {
+	if sid == currentStateid {
+		return c.curSid
+	}
+	return sid
+}
+
+// openFor returns the open state the stateid refers to, which may be
+// the stateid of an open or of a lock
+func (c *compound) openFor(sid stateid) (*openState, nfsStatus) {
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var o *openState
+	switch state := s.states[sid.other].(type) {
+	case *openState:
+		o = state
+	case *lockState:
+		o = state.open
+	}
+	if o == nil || o.vfs != c.vfs {
+		return nil, nfs4errBadStateid
+	}
+	return o, nfs4OK
+}
+
+// ioHandle returns a handle to read or write the file at path using
+// the stateid given and a function to call when finished with it.
+func (c *compound) ioHandle(sid stateid, p string, write bool) (h vfs.Handle, done func() error, status nfsStatus) {
+	sid = c.resolveStateid(sid)
+	if isSpecial(sid) {
+		// I/O without an OPEN so use a temporary handle
+		flags := os.O_RDONLY
+		if write {
+			flags = os.O_WRONLY
+		}
+		h, err := c.vfs.OpenFile(p, flags, 0777)
+		if err != nil {
+			return nil, nil, toStatus(err)
+		}
+		return h, h.Close, nfs4OK
+	}
+	o, status := c.openFor(sid)
+	if status != nfs4OK {
+		return nil, nil, status
+	}
+	if write && o.access&open4ShareAccessWrite == 0 {
+		return nil, nil, nfs4errOpenmode
+	}
+	h, _, err := o.handle(false)
+	if err != nil {
+		return nil, nil, toStatus(err)
+	}
+	return h, func() error { return nil }, nfs4OK
+}
+
+// wait waits until n bytes may be transferred within the bandwidth
+// limit of the user if any
+func (c *compound) wait(n int) {
+	if c.user != nil {
+		_ = c.user.Wait(c.s.ctx, n)
+	}
+}
+
+// opAccess runs ACCESS
+func (c *compound) opAccess(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	req := r.Uint32()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	node, _, status := c.node()
+	if status != nfs4OK {
+		return status
+	}
+	supported := uint32(access4Read | access4Lookup | access4Modify | access4Extend | access4Delete | access4Execute)
+	allowed := supported
+	if c.vfs.Opt.ReadOnly {
+		allowed &^= access4Modify | access4Extend | access4Delete
+	}
+	if !node.IsDir() {
+		allowed &^= access4Lookup | access4Delete
+	}
+	w.Uint32(req & supported)
+	w.Uint32(req & allowed)
+	return nfs4OK
+}
+
+// opClose runs CLOSE
+func (c *compound) opClose(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	_ = r.Uint32() // seqid
+	sid := c.resolveStateid(readStateid(r))
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	o, ok := s.states[sid.other].(*openState)
+	if !ok {
+		s.mu.Unlock()
+		return nfs4errBadStateid
+	}
+	s.removeOpen(o)
+	delete(s.exclusive, o.path)
+	s.mu.Unlock()
+	err := o.close()
+	if err != nil {
+		fs.Errorf(o.path, "serve nfs: failed to close file: %v", err)
+		return toStatus(err)
+	}
+	o.mu.Lock()
+	sid = stateid{seqid: o.seqid + 1, other: o.other}
+	o.mu.Unlock()
+	c.curSid = sid
+	writeStateid(w, sid)
+	return nfs4OK
+}
+
+// opCommit runs COMMIT
+//
+// Writes are always returned as FILE_SYNC4 so this has nothing to do.
+func (c *compound) opCommit(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	_ = r.Uint64() // offset
+	_ = r.Uint32() // count
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if _, _, status := c.file(); status != nfs4OK {
+		return status
+	}
+	w.Fixed(c.s.verifier[:])
+	return nfs4OK
+}
+
+// opCreate runs CREATE which makes objects other than files
+func (c *compound) opCreate(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	objType := r.Uint32()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if objType != nf4Dir {
+		return nfs4errBadtype
+	}
+	name := r.String(maxNameLength + 1)
+	attrs, status := readAttrs(r)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if status != nfs4OK {
+		return status
+	}
+	p, status := c.childPath(name)
+	if status != nfs4OK {
+		return status
+	}
+	dirPath := c.cur
+	parent, status := c.dir(dirPath)
+	if status != nfs4OK {
+		return status
+	}
+	if _, err := parent.Stat(name); err == nil {
+		return nfs4errExist
+	}
+	before := c.s.change(parent, dirPath)
+	dir, err := parent.Mkdir(name)
+	if err != nil {
+		return toStatus(err)
+	}
+	c.s.dirChanged(dirPath)
+	after := c.s.change(parent, dirPath)
+	var set xdr.Bitmap
+	if attrs.mask.IsSet(attrTimeModifySet) {
+		set.Set(attrTimeModifySet)
+		if !attrs.mtimeIsNow {
+			err = dir.SetModTime(attrs.mtime)
+			if err != nil {
+				fs.Debugf(p, "serve nfs: failed to set modification time: %v", err)
+			}
+		}
+	}
+	writeChangeInfo(w, before, after)
+	w.Bitmap(set)
+	c.setCur(p)
+	return nfs4OK
+}
+
+// opGetattr runs GETATTR
+func (c *compound) opGetattr(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	req := r.Bitmap()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	node, p, status := c.node()
+	if status != nfs4OK {
+		return status
+	}
+	c.s.writeAttrs(w, c.vfs, node, p, req)
+	return nfs4OK
+}
+
+// opGetfh runs GETFH
+func (c *compound) opGetfh(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	p, status := c.curPath()
+	if status != nfs4OK {
+		return status
+	}
+	w.Opaque(c.s.handles.toHandle(p))
+	return nfs4OK
+}
+
+// opLookup runs LOOKUP
+func (c *compound) opLookup(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	name := r.String(maxNameLength + 1)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	p, status := c.childPath(name)
+	if status != nfs4OK {
+		return status
+	}
+	node, err := c.vfs.Stat(p)
+	if err != nil {
+		return toStatus(err)
+	}
+	c.setCur(node.Path())
+	return nfs4OK
+}
+
+// opLookupp runs LOOKUPP
+func (c *compound) opLookupp(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	p, status := c.curPath()
+	if status != nfs4OK {
+		return status
+	}
+	if _, status = c.dir(p); status != nfs4OK {
+		return status
+	}
+	if p == "" {
+		return nfs4errNoent
+	}
+	parent := path.Dir(p)
+	if parent == "." {
+		parent = ""
+	}
+	c.setCur(parent)
+	return nfs4OK
+}
+
+// findOpen returns the open state of the owner on path in VFS or nil
+//
+// Call with s.mu held
+func (s *server) findOpen(client *nfsClient, owner string, VFS *vfs.VFS, p string) *openState {
+	for _, state := range s.states {
+		if o, ok := state.(*openState); ok && o.client == client && o.owner == owner && o.vfs == VFS && o.path == p {
+			return o
+		}
+	}
+	return nil
+}
+
+// opOpen runs OPEN
+func (c *compound) opOpen(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	_ = r.Uint32() // seqid
+	access := r.Uint32() & open4ShareAccessBoth
+	_ = r.Uint32() // deny - share reservations aren't enforced
+	clientID := r.Uint64()
+	owner := r.String(1024)
+	openType := r.Uint32()
+	var (
+		createMode uint32
+		attrs      setAttrs
+		verf       [8]byte
+		status     = nfs4OK
+	)
+	if openType == open4Create {
+		createMode = r.Uint32()
+		switch createMode {
+		case createUnchecked, createGuarded:
+			attrs, status = readAttrs(r)
+		case createExclusive:
+			copy(verf[:], r.Fixed(8))
+		case createExclusive1:
+			copy(verf[:], r.Fixed(8))
+			attrs, status = readAttrs(r)
+		default:
+			return nfs4errInval
+		}
+	}
+	claim := r.Uint32()
+	var name string
+	switch claim {
+	case claimNull:
+		name = r.String(maxNameLength + 1)
+	case claimPrevious:
+		_ = r.Uint32() // delegation type
+	case claimFH:
+	default:
+		// there are no delegations to claim
+		return nfs4errNotsupp
+	}
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if status != nfs4OK {
+		return status
+	}
+	if access == 0 {
+		return nfs4errInval
+	}
+	s := c.s
+	client := c.sess.client
+	if clientID != client.id {
+		return nfs4errStaleClientid
+	}
+
+	// Find the path being opened
+	var p string
+	if claim == claimNull {
+		p, status = c.childPath(name)
+	} else if openType == open4Create {
+		status = nfs4errInval
+	} else {
+		p, status = c.curPath()
+	}
+	if status != nfs4OK {
+		return status
+	}
+	dirPath := path.Dir(p)
+	if dirPath == "." {
+		dirPath = ""
+	}
+	parent, status := c.dir(dirPath)
+	if status != nfs4OK {
+		return status
+	}
+	node, err := c.vfs.Stat(p)
+	exists := err == nil
+	if err != nil && err != vfs.ENOENT {
+		return toStatus(err)
+	}
+	if exists && node.IsDir() {
+		return nfs4errIsdir
+	}
+	if !exists && openType != open4Create {
+		if claim == claimNull {
+			return nfs4errNoent
+		}
+		return nfs4errStale
+	}
+	if access&open4ShareAccessWrite != 0 && c.vfs.Opt.ReadOnly {
+		return nfs4errRofs
+	}
+
+	// Create or truncate the file if required
+	var (
+		h       vfs.Handle
+		created bool
+		trunc   bool
+		set     xdr.Bitmap
+	)
+	before := s.change(parent, dirPath)
+	if openType == open4Create {
+		exclusive := createMode == createExclusive || createMode == createExclusive1
+		if exists {
+			switch {
+			case createMode == createGuarded:
+				return nfs4errExist
+			case exclusive:
+				// only a retry of the same create may succeed
+				s.mu.Lock()
+				v, ok := s.exclusive[p]
+				s.mu.Unlock()
+				if !ok || v != verf {
+					return nfs4errExist
+				}
+			case attrs.mask.IsSet(attrSize) && attrs.size == 0:
+				trunc = true
+				set.Set(attrSize)
+			}
+		} else {
+			h, err = c.vfs.OpenFile(p, os.O_RDWR|os.O_CREATE, 0777)
+			if err != nil {
+				return toStatus(err)
+			}
+			created = true
+			if exclusive {
+				s.mu.Lock()
+				s.exclusive[p] = verf
+				s.mu.Unlock()
+			}
+			s.dirChanged(dirPath)
+			if attrs.mask.IsSet(attrSize) {
+				set.Set(attrSize)
+			}
+		}
+		if attrs.mask.IsSet(attrTimeModifySet) && !attrs.mtimeIsNow && created {
+			err = h.Node().SetModTime(attrs.mtime)
+			if err != nil {
+				fs.Debugf(p, "serve nfs: failed to set modification time: %v", err)
+			}
+			set.Set(attrTimeModifySet)
+		}
+	}
+	after := s.change(parent, dirPath)
+
+	// Make or upgrade the open state
+	s.mu.Lock()
+	o := s.findOpen(client, owner, c.vfs, p)
+	if o == nil {
+		o = &openState{
+			other:  s.newOther(),
+			client: client,
+			owner:  owner,
+			path:   p,
+			vfs:    c.vfs,
+			access: access,
+			h:      h,
+		}
+		h = nil
+		s.states[o.other] = o
+	}
+	s.mu.Unlock()
+	if h != nil {
+		// The file was recreated while open by this owner so use
+		// the existing handle
+		err = h.Close()
+		if err != nil {
+			fs.Debugf(p, "serve nfs: failed to close file: %v", err)
+		}
+	}
+	sid, err := o.upgrade(access)
+	if err != nil {
+		fs.Errorf(p, "serve nfs: failed to reopen file: %v", err)
+		return toStatus(err)
+	}
+	if trunc {
+		if status = c.truncate(o, 0); status != nfs4OK {
+			return status
+		}
+	}
+
+	writeStateid(w, sid)
+	writeChangeInfo(w, before, after)
+	w.Uint32(open4ResultLocktypePosix)
+	w.Bitmap(set)
+	w.Uint32(openDelegateNone)
+	c.setCur(p)
+	c.curSid = sid
+	return nfs4OK
+}
+
+// truncate sets the size of the file open with o
+func (c *compound) truncate(o *openState, size uint64) nfsStatus {
+	if o.access&open4ShareAccessWrite == 0 {
+		return nfs4errOpenmode
+	}
+	h, opened, err := o.handle(size == 0)
+	if err != nil {
+		return toStatus(err)
+	}
+	if opened && size == 0 {
+		// truncated by opening with O_TRUNC
+		return nfs4OK
+	}
+	return toStatus(h.Truncate(int64(size)))
+}
+
+// opOpenDowngrade runs OPEN_DOWNGRADE
+func (c *compound) opOpenDowngrade(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	sid := c.resolveStateid(readStateid(r))
+	_ = r.Uint32() // seqid
+	access := r.Uint32() & open4ShareAccessBoth
+	_ = r.Uint32() // deny
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	o, ok := s.states[sid.other].(*openState)
+	s.mu.Unlock()
+	if !ok {
+		return nfs4errBadStateid
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if access == 0 || access&^o.access != 0 {
+		return nfs4errInval
+	}
+	// Keep the handle as it is as it has at least the access needed
+	o.seqid++
+	sid = stateid{seqid: o.seqid, other: o.other}
+	c.curSid = sid
+	writeStateid(w, sid)
+	return nfs4OK
+}
+
+// opPutfh runs PUTFH
+func (c *compound) opPutfh(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	handle := r.Opaque(128)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	p, ok := c.s.handles.toPath(handle)
+	if !ok {
+		return nfs4errBadhandle
+	}
+	if c.vfs == nil {
+		return nfs4errAccess
+	}
+	c.setCur(p)
+	return nfs4OK
+}
+
+// opPutrootfh runs PUTROOTFH and PUTPUBFH
+func (c *compound) opPutrootfh(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	if c.vfs == nil {
+		return nfs4errAccess
+	}
+	c.setCur("")
+	return nfs4OK
+}
+
+// opRead runs READ
+func (c *compound) opRead(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	sid := readStateid(r)
+	offset := r.Uint64()
+	count := r.Uint32()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if offset > math.MaxInt64 {
+		return nfs4errInval
+	}
+	if count > maxIO {
+		count = maxIO
+	}
+	_, p, status := c.file()
+	if status != nfs4OK {
+		return status
+	}
+	h, done, status := c.ioHandle(sid, p, false)
+	if status != nfs4OK {
+		return status
+	}
+	buf := make([]byte, count)
+	n, err := h.ReadAt(buf, int64(offset))
+	c.wait(n)
+	eof := err == io.EOF
+	if eof {
+		err = nil
+	}
+	if err == nil && int64(offset)+int64(n) >= h.Node().Size() {
+		eof = true
+	}
+	closeErr := done()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.Errorf(p, "serve nfs: read failed: %v", err)
+		return toStatus(err)
+	}
+	w.Bool(eof)
+	w.Opaque(buf[:n])
+	return nfs4OK
+}
+
+// opReaddir runs READDIR
+//
+// The cookie of an entry is its index in the directory listing plus
+// 3 as cookies 1 and 2 are reserved.
+func (c *compound) opReaddir(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	cookie := r.Uint64()
+	_ = r.Fixed(8) // cookieverf
+	_ = r.Uint32() // dircount
+	maxCount := r.Uint32()
+	req := r.Bitmap()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if cookie == 1 || cookie == 2 {
+		return nfs4errBadCookie
+	}
+	p, status := c.curPath()
+	if status != nfs4OK {
+		return status
+	}
+	dir, status := c.dir(p)
+	if status != nfs4OK {
+		return status
+	}
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return toStatus(err)
+	}
+	start := uint64(0)
+	if cookie != 0 {
+		start = cookie - 2
+	}
+
+	// the verifier, the end of the list and eof take 16 bytes
+	const overhead = 16
+	entries := &xdr.Writer{}
+	n := 0
+	eof := true
+	for i := start; i < uint64(len(nodes)); i++ {
+		node := nodes[i]
+		e := &xdr.Writer{}
+		e.Bool(true) // another entry follows
+		e.Uint64(i + 3)
+		e.String(node.Name())
+		c.s.writeAttrs(e, c.vfs, node, node.Path(), req)
+		if overhead+entries.Len()+e.Len() > int(maxCount) {
+			eof = false
+			break
+		}
+		entries.Fixed(e.Bytes())
+		n++
+	}
+	if n == 0 && !eof {
+		return nfs4errToosmall
+	}
+	w.Fixed(make([]byte, 8)) // cookieverf
+	w.Fixed(entries.Bytes())
+	w.Bool(false) // no more entries
+	w.Bool(eof)
+	return nfs4OK
+}
+
+// opReadlink runs READLINK
+func (c *compound) opReadlink(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	if _, _, status := c.node(); status != nfs4OK {
+		return status
+	}
+	// symlinks aren't supported
+	return nfs4errInval
+}
+
+// opRemove runs REMOVE
+func (c *compound) opRemove(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	name := r.String(maxNameLength + 1)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if _, status := c.childPath(name); status != nfs4OK {
+		return status
+	}
+	dirPath := c.cur
+	dir, status := c.dir(dirPath)
+	if status != nfs4OK {
+		return status
+	}
+	before := c.s.change(dir, dirPath)
+	err := dir.RemoveName(name)
+	if err != nil {
+		return toStatus(err)
+	}
+	c.s.dirChanged(dirPath)
+	writeChangeInfo(w, before, c.s.change(dir, dirPath))
+	return nfs4OK
+}
+
+// opRename runs RENAME from the saved filehandle directory to the
+// current one
+func (c *compound) opRename(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	oldName := r.String(maxNameLength + 1)
+	newName := r.String(maxNameLength + 1)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if !c.haveSave {
+		return nfs4errNofilehandle
+	}
+	for _, name := range []string{oldName, newName} {
+		if status := checkName(name); status != nfs4OK {
+			return status
+		}
+	}
+	newPath, status := c.childPath(newName)
+	if status != nfs4OK {
+		return status
+	}
+	oldDirPath, newDirPath := c.saved, c.cur
+	oldDir, status := c.dir(oldDirPath)
+	if status != nfs4OK {
+		return status
+	}
+	newDir, status := c.dir(newDirPath)
+	if status != nfs4OK {
+		return status
+	}
+	s := c.s
+	oldPath := path.Join(oldDirPath, oldName)
+	sourceBefore, targetBefore := s.change(oldDir, oldDirPath), s.change(newDir, newDirPath)
+	if oldPath != newPath {
+		err := c.vfs.Rename(oldPath, newPath)
+		if err != nil {
+			return toStatus(err)
+		}
+		s.dirChanged(oldDirPath)
+		if newDirPath != oldDirPath {
+			s.dirChanged(newDirPath)
+		}
+	} else if _, err := c.vfs.Stat(oldPath); err != nil {
+		return toStatus(err)
+	}
+	writeChangeInfo(w, sourceBefore, s.change(oldDir, oldDirPath))
+	writeChangeInfo(w, targetBefore, s.change(newDir, newDirPath))
+	return nfs4OK
+}
+
+// opRestorefh runs RESTOREFH
+func (c *compound) opRestorefh(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	if !c.haveSave {
+		return nfs4errRestorefh
+	}
+	c.setCur(c.saved)
+	return nfs4OK
+}
+
+// opSavefh runs SAVEFH
+func (c *compound) opSavefh(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	p, status := c.curPath()
+	if status != nfs4OK {
+		return status
+	}
+	c.saved, c.haveSave = p, true
+	return nfs4OK
+}
+
+// opSetattr runs SETATTR which returns the attributes set even if it
+// fails
+func (c *compound) opSetattr(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	var set xdr.Bitmap
+	status := c.setattr(r, &set)
+	w.Bitmap(set)
+	return status
+}
+
+// setattr does the work for SETATTR noting the attributes set in set
+func (c *compound) setattr(r *xdr.Reader, set *xdr.Bitmap) nfsStatus {
+	sid := c.resolveStateid(readStateid(r))
+	attrs, status := readAttrs(r)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if status != nfs4OK {
+		return status
+	}
+	node, p, status := c.node()
+	if status != nfs4OK {
+		return status
+	}
+	if c.vfs.Opt.ReadOnly {
+		return nfs4errRofs
+	}
+	if attrs.mask.IsSet(attrSize) {
+		if node.IsDir() {
+			return nfs4errIsdir
+		}
+		if isSpecial(sid) {
+			status = toStatus(node.Truncate(int64(attrs.size)))
+		} else {
+			var o *openState
+			o, status = c.openFor(sid)
+			if status == nfs4OK {
+				status = c.truncate(o, attrs.size)
+			}
+		}
+		if status != nfs4OK {
+			fs.Debugf(p, "serve nfs: failed to set size: %d", status)
+			return status
+		}
+		set.Set(attrSize)
+	}
+	if attrs.mask.IsSet(attrTimeModifySet) {
+		mtime := attrs.mtime
+		if attrs.mtimeIsNow {
+			mtime = time.Now()
+		}
+		err := node.SetModTime(mtime)
+		if err != nil {
+			return toStatus(err)
+		}
+		set.Set(attrTimeModifySet)
+	}
+	// These are accepted but can't be stored
+	for _, bit := range []int{attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet} {
+		if attrs.mask.IsSet(bit) {
+			set.Set(bit)
+		}
+	}
+	return nfs4OK
+}
+
+// opWrite runs WRITE
+//
+// Writes are always FILE_SYNC4 as the data is handed to the VFS
+// which will upload it when the file is closed.
+func (c *compound) opWrite(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	sid := readStateid(r)
+	offset := r.Uint64()
+	_ = r.Uint32() // stable
+	data := r.Opaque(maxIO)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if offset > math.MaxInt64 {
+		return nfs4errInval
+	}
+	_, p, status := c.file()
+	if status != nfs4OK {
+		return status
+	}
+	h, done, status := c.ioHandle(sid, p, true)
+	if status != nfs4OK {
+		return status
+	}
+	c.wait(len(data))
+	n, err := h.WriteAt(data, int64(offset))
+	closeErr := done()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.Errorf(p, "serve nfs: write failed: %v", err)
+		return toStatus(err)
+	}
+	w.Uint32(uint32(n))
+	w.Uint32(fileSync4)
+	w.Fixed(c.s.verifier[:])
+	return nfs4OK
+}
+
+// lockRangeOf converts an offset and length into a lock range
+func lockRangeOf(offset, length uint64) (start, end int64, status nfsStatus) {
+	switch {
+	case length == 0:
+		return 0, 0, nfs4errInval
+	case offset >= vfs.LockEOF:
+		return 0, 0, nfs4errInval
+	case length == math.MaxUint64 || offset+length >= vfs.LockEOF:
+		return int64(offset), vfs.LockEOF, nfs4OK
+	}
+	return int64(offset), int64(offset + length), nfs4OK
+}
+
+// writeDenied writes a LOCK4denied for the conflicting lock
+//
+// Locks taken by other users of the VFS, such as a mount, are shown
+// with an empty owner.
+func writeDenied(w *xdr.Writer, l *vfs.Lock) {
+	w.Uint64(uint64(l.Start))
+	if l.End == vfs.LockEOF {
+		w.Uint64(math.MaxUint64)
+	} else {
+		w.Uint64(uint64(l.End - l.Start))
+	}
+	if l.Write {
+		w.Uint32(writeLt)
+	} else {
+		w.Uint32(readLt)
+	}
+	owner, _ := l.Owner.(lockOwner)
+	w.Uint64(owner.clientID)
+	w.String(owner.owner)
+}
+
+// isWriteLock returns whether the nfs_lock_type4 is a write lock
+func isWriteLock(lockType uint32) bool {
+	return lockType == writeLt || lockType == writewLt
+}
+
+// opLock runs LOCK
+func (c *compound) opLock(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	lockType := r.Uint32()
+	_ = r.Bool() // reclaim
+	offset := r.Uint64()
+	length := r.Uint64()
+	newOwner := r.Bool()
+	var (
+		openSid, lockSid stateid
+		clientID         uint64
+		owner            string
+	)
+	if newOwner {
+		_ = r.Uint32() // open seqid
+		openSid = c.resolveStateid(readStateid(r))
+		_ = r.Uint32() // lock seqid
+		clientID = r.Uint64()
+		owner = r.String(1024)
+	} else {
+		lockSid = c.resolveStateid(readStateid(r))
+		_ = r.Uint32() // lock seqid
+	}
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	start, end, status := lockRangeOf(offset, length)
+	if status != nfs4OK {
+		return status
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var l *lockState
+	if newOwner {
+		o, ok := s.states[openSid.other].(*openState)
+		if !ok {
+			return nfs4errBadStateid
+		}
+		for _, state := range s.states {
+			if x, ok := state.(*lockState); ok && x.open == o && x.clientID == clientID && x.owner == owner {
+				l = x
+				break
+			}
+		}
+		if l == nil {
+			l = &lockState{
+				other:    s.newOther(),
+				open:     o,
+				clientID: clientID,
+				owner:    owner,
+			}
+			s.states[l.other] = l
+		}
+	} else {
+		var ok bool
+		l, ok = s.states[lockSid.other].(*lockState)
+		if !ok {
+			return nfs4errBadStateid
+		}
+	}
+	if conflict := s.lock(l, start, end, isWriteLock(lockType)); conflict != nil {
+		writeDenied(w, conflict)
+		return nfs4errDenied
+	}
+	l.seqid++
+	sid := stateid{seqid: l.seqid, other: l.other}
+	c.curSid = sid
+	writeStateid(w, sid)
+	return nfs4OK
+}
+
+// opLockt runs LOCKT
+func (c *compound) opLockt(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	lockType := r.Uint32()
+	offset := r.Uint64()
+	length := r.Uint64()
+	clientID := r.Uint64()
+	owner := r.String(1024)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	start, end, status := lockRangeOf(offset, length)
+	if status != nfs4OK {
+		return status
+	}
+	_, p, status := c.file()
+	if status != nfs4OK {
+		return status
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if conflict := s.conflictingLock(c.vfs, p, clientID, owner, start, end, isWriteLock(lockType)); conflict != nil {
+		writeDenied(w, conflict)
+		return nfs4errDenied
+	}
+	return nfs4OK
+}
+
+// opLocku runs LOCKU
+func (c *compound) opLocku(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	_ = r.Uint32() // lock type
+	_ = r.Uint32() // seqid
+	sid := c.resolveStateid(readStateid(r))
+	offset := r.Uint64()
+	length := r.Uint64()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	start, end, status := lockRangeOf(offset, length)
+	if status != nfs4OK {
+		return status
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.states[sid.other].(*lockState)
+	if !ok {
+		return nfs4errBadStateid
+	}
+	s.unlock(l, start, end)
+	l.seqid++
+	sid = stateid{seqid: l.seqid, other: l.other}
+	c.curSid = sid
+	writeStateid(w, sid)
+	return nfs4OK
+}
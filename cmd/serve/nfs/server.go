@@ -0,0 +1,351 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+)
+
+const (
+	maxIO         = 1024 * 1024            // largest READ or WRITE
+	maxRecordSize = maxIO + 64*1024        // largest RPC record accepted
+	maxSlots      = 64                     // largest slot table given to a session
+	maxOps        = 32                     // most operations in a COMPOUND
+	leaseTime     = 90                     // lease time in seconds
+	maxNameLength = 255                    // longest file name
+	idleTimeout   = 15 * time.Minute       // close connections idle for this long
+	rpcCredMax    = 400                    // MAX_AUTH_BYTES
+	writeTimeout  = 5 * time.Minute        // time allowed to write a reply
+	fsidMajor     = uint64(0x72636c6f6e65) // "rclone"
+)
+
+// server contains everything to run the server
+type server struct {
+	f        fs.Fs
+	opt      Options
+	vfs      *vfs.VFS
+	authDB   *auth.DB        // user database if set
+	ctx      context.Context // for global config
+	handles  *handleStore
+	listener net.Listener
+	waitChan chan struct{} // for waiting on the listener to close
+	bootTime uint32        // time the server started
+	bootID   [4]byte       // random id for this run of the server
+	verifier [8]byte       // write verifier
+
+	mu             sync.Mutex               // protects the following
+	nextID         uint64                   // for making unique ids
+	clients        map[uint64]*nfsClient    // clients by clientid
+	clientsByOwner map[string]*nfsClient    // clients by co_ownerid
+	sessions       map[[16]byte]*session    // sessions by sessionid
+	states         map[[12]byte]interface{} // *openState or *lockState by stateid other
+	dirGen         map[string]uint64        // count of changes made to a directory
+	exclusive      map[string][8]byte       // verifiers of files created with EXCLUSIVE4
+	conns          map[*conn]struct{}       // open connections
+}
+
+// newServer makes a new server serving f, serving the users of the
+// --auth-users file by their uid if authOpt sets it
+func newServer(ctx context.Context, f fs.Fs, opt *Options, authOpt *auth.Options) (*server, error) {
+	handles, err := newHandleStore(f)
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		f:              f,
+		ctx:            ctx,
+		opt:            *opt,
+		vfs:            vfs.New(f, &vfsflags.Opt),
+		handles:        handles,
+		waitChan:       make(chan struct{}),
+		bootTime:       uint32(time.Now().Unix()),
+		clients:        make(map[uint64]*nfsClient),
+		clientsByOwner: make(map[string]*nfsClient),
+		sessions:       make(map[[16]byte]*session),
+		states:         make(map[[12]byte]interface{}),
+		dirGen:         make(map[string]uint64),
+		exclusive:      make(map[string][8]byte),
+		conns:          make(map[*conn]struct{}),
+	}
+	_, err = io.ReadFull(rand.Reader, s.bootID[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make server id")
+	}
+	binary.BigEndian.PutUint64(s.verifier[:], uint64(time.Now().UnixNano()))
+	if authOpt != nil && authOpt.Enabled() {
+		return nil, errors.New("serve nfs has no logins so only --auth-users can be used")
+	}
+	if authOpt != nil && authOpt.Users != "" {
+		s.authDB, err = auth.New(ctx, f, authOpt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// serve starts the server listening
+func (s *server) serve() (err error) {
+	s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for connection")
+	}
+	fs.Logf(nil, "NFS server listening on %v\n", s.listener.Addr())
+	go s.acceptConnections()
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Serve runs the NFS server in the background.
+//
+// Use s.Close() and s.Wait() to shutdown server
+func (s *server) Serve() error {
+	return s.serve()
+}
+
+// Wait blocks while the listener is open.
+func (s *server) Wait() {
+	<-s.waitChan
+}
+
+// Close shuts the running server down
+func (s *server) Close() {
+	err := s.listener.Close()
+	if err != nil {
+		fs.Errorf(nil, "Error on closing NFS server: %v", err)
+	}
+}
+
+// acceptConnections accepts connections until the listener is closed
+// then shuts everything down
+func (s *server) acceptConnections() {
+	var wg sync.WaitGroup
+	for {
+		nConn, err := s.listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			fs.Errorf(nil, "Failed to accept incoming connection: %v", err)
+			continue
+		}
+		c := &conn{
+			s:    s,
+			c:    nConn,
+			what: nConn.RemoteAddr().String(),
+			sem:  make(chan struct{}, maxSlots),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		fs.Infof(c.what, "NFS client connected")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+
+	// Close the connections and wait for them to finish
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.c.Close()
+	}
+	s.mu.Unlock()
+	wg.Wait()
+
+	// Close any files left open
+	s.mu.Lock()
+	var opens []*openState
+	for _, client := range s.clients {
+		opens = append(opens, s.removeClient(client)...)
+	}
+	s.mu.Unlock()
+	closeOpens(opens)
+	s.handles.close()
+	close(s.waitChan)
+}
+
+// conn is a connection from an NFS client
+type conn struct {
+	s    *server
+	c    net.Conn
+	what string
+	sem  chan struct{} // limits the number of calls being run
+	wmu  sync.Mutex    // serialises writing replies
+}
+
+// serve reads the RPC calls from the connection and runs them in the
+// background, writing the replies as they finish
+func (c *conn) serve() {
+	var wg sync.WaitGroup
+	br := bufio.NewReader(c.c)
+	for {
+		_ = c.c.SetReadDeadline(time.Now().Add(idleTimeout))
+		record, err := readRecord(br)
+		if err != nil {
+			if err != io.EOF {
+				fs.Debugf(c.what, "NFS connection closed: %v", err)
+			}
+			break
+		}
+		c.sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-c.sem
+				wg.Done()
+			}()
+			reply := c.s.handleCall(record)
+			if reply == nil {
+				return
+			}
+			err := c.writeRecord(reply)
+			if err != nil {
+				fs.Debugf(c.what, "NFS failed to write reply: %v", err)
+				_ = c.c.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	_ = c.c.Close()
+	fs.Infof(c.what, "NFS client disconnected")
+}
+
+// readRecord reads an RPC record made of one or more fragments
+func readRecord(r io.Reader) (record []byte, err error) {
+	var header [4]byte
+	for {
+		_, err = io.ReadFull(r, header[:])
+		if err != nil {
+			return nil, err
+		}
+		x := binary.BigEndian.Uint32(header[:])
+		last := x&0x80000000 != 0
+		size := int(x & 0x7FFFFFFF)
+		if len(record)+size > maxRecordSize {
+			return nil, errors.Errorf("RPC record too big (%d bytes)", len(record)+size)
+		}
+		start := len(record)
+		record = append(record, make([]byte, size)...)
+		_, err = io.ReadFull(r, record[start:])
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes reply as a single fragment RPC record
+func (c *conn) writeRecord(reply []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	buf := make([]byte, 4, 4+len(reply))
+	binary.BigEndian.PutUint32(buf, 0x80000000|uint32(len(reply)))
+	buf = append(buf, reply...)
+	_ = c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.c.Write(buf)
+	return err
+}
+
+// credUser returns the user of the user database with the uid in the
+// AUTH_SYS credentials cred or nil if there isn't one
+func (s *server) credUser(credFlavor uint32, cred []byte) *auth.User {
+	if s.authDB == nil || credFlavor != authSys {
+		return nil
+	}
+	r := xdr.NewReader(cred)
+	_ = r.Uint32()              // stamp
+	_ = r.String(maxNameLength) // machine name
+	uid := r.Uint32()
+	if r.Err() != nil {
+		return nil
+	}
+	u, err := s.authDB.UserByUID(uid)
+	if err != nil {
+		fs.Debugf(nil, "serve nfs: %v", err)
+		return nil
+	}
+	return u
+}
+
+// handleCall decodes an RPC call, runs it and returns the reply or
+// nil if there should be no reply
+func (s *server) handleCall(record []byte) []byte {
+	r := xdr.NewReader(record)
+	xid := r.Uint32()
+	msgType := r.Uint32()
+	if r.Err() != nil || msgType != rpcCall {
+		return nil
+	}
+	rpcVers := r.Uint32()
+	prog := r.Uint32()
+	vers := r.Uint32()
+	proc := r.Uint32()
+	credFlavor := r.Uint32()
+	cred := r.Opaque(rpcCredMax)
+	_ = r.Uint32() // verifier flavor
+	_ = r.Opaque(rpcCredMax)
+
+	w := &xdr.Writer{}
+	w.Uint32(xid)
+	w.Uint32(rpcReply)
+	if rpcVers != rpcVersion {
+		w.Uint32(rpcMsgDenied)
+		w.Uint32(rpcMismatch)
+		w.Uint32(rpcVersion)
+		w.Uint32(rpcVersion)
+		return w.Bytes()
+	}
+	if r.Err() == nil && credFlavor != authNone && credFlavor != authSys {
+		w.Uint32(rpcMsgDenied)
+		w.Uint32(rpcAuthError)
+		w.Uint32(rpcAuthBadCred)
+		return w.Bytes()
+	}
+	w.Uint32(rpcMsgAccepted)
+	w.Uint32(authNone) // verifier
+	w.Opaque(nil)
+	switch {
+	case r.Err() != nil:
+		w.Uint32(rpcGarbageArgs)
+	case prog != nfsProgram:
+		w.Uint32(rpcProgUnavail)
+	case vers != nfsVersion:
+		w.Uint32(rpcProgMismatch)
+		w.Uint32(nfsVersion)
+		w.Uint32(nfsVersion)
+	case proc == procNull:
+		w.Uint32(rpcSuccess)
+	case proc == procCompound:
+		w.Uint32(rpcSuccess)
+		s.compound(r, w, s.credUser(credFlavor, cred))
+	default:
+		w.Uint32(rpcProcUnavail)
+	}
+	return w.Bytes()
+}
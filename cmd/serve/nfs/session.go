@@ -0,0 +1,443 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/rclone/rclone/lib/xdr"
+)
+
+// sequence runs a SEQUENCE which must be the first operation. It
+// writes its own result to w.
+//
+// If the request is a retry of the last one on the slot the cached
+// reply is returned instead.
+func (c *compound) sequence(r *xdr.Reader, w *xdr.Writer, nOps uint32) (replay []byte, status nfsStatus) {
+	var sessionID [16]byte
+	copy(sessionID[:], r.Fixed(16))
+	seqid := r.Uint32()
+	slotID := r.Uint32()
+	highestSlotID := r.Uint32()
+	cacheThis := r.Bool()
+	w.Uint32(opSequence)
+	if r.Err() != nil {
+		w.Uint32(uint32(nfs4errBadxdr))
+		return nil, nfs4errBadxdr
+	}
+	status, replay = c.s.useSlot(c, sessionID, seqid, slotID, cacheThis, nOps)
+	if replay != nil {
+		return replay, status
+	}
+	w.Uint32(uint32(status))
+	if status != nfs4OK {
+		return nil, status
+	}
+	w.Fixed(sessionID[:])
+	w.Uint32(seqid)
+	w.Uint32(slotID)
+	w.Uint32(highestSlotID)
+	w.Uint32(uint32(len(c.sess.slots) - 1)) // target highest slot
+	w.Uint32(0)                             // status flags
+	return nil, nfs4OK
+}
+
+// useSlot checks the sequence id of the slot and marks it in use
+func (s *server) useSlot(c *compound, sessionID [16]byte, seqid, slotID uint32, cacheThis bool, nOps uint32) (nfsStatus, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[sessionID]
+	if sess == nil {
+		return nfs4errBadsession, nil
+	}
+	if slotID >= uint32(len(sess.slots)) {
+		return nfs4errBadslot, nil
+	}
+	sl := sess.slots[slotID]
+	switch {
+	case seqid == sl.seqid:
+		// A retry of the last request
+		if sl.inUse {
+			return nfs4errDelay, nil
+		}
+		if !sl.cached {
+			return nfs4errRetryUncachedRep, nil
+		}
+		return nfs4OK, sl.reply
+	case seqid != sl.seqid+1:
+		return nfs4errSeqMisordered, nil
+	case sl.inUse:
+		return nfs4errDelay, nil
+	}
+	if nOps > sess.maxOps {
+		return nfs4errTooManyOps, nil
+	}
+	sl.seqid = seqid
+	sl.inUse = true
+	sl.reply = nil
+	sl.cached = false
+	c.sess = sess
+	c.slot = sl
+	c.cache = cacheThis
+	return nfs4OK, nil
+}
+
+// opExchangeID runs EXCHANGE_ID
+func (c *compound) opExchangeID(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	var verifier [8]byte
+	copy(verifier[:], r.Fixed(8))
+	owner := r.String(1024)
+	_ = r.Uint32() // flags
+	stateProtect := r.Uint32()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if stateProtect != sp4None {
+		return nfs4errEncrAlgUnsupp
+	}
+	// ignore the client_impl_id which is the last argument
+	nImpl := r.Uint32()
+	for i := uint32(0); i < nImpl && r.Err() == nil; i++ {
+		_ = r.String(1024) // nii_domain
+		_ = r.String(1024) // nii_name
+		_ = r.Uint64()     // nii_date seconds
+		_ = r.Uint32()     // nii_date nseconds
+	}
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+
+	s := c.s
+	s.mu.Lock()
+	client := s.clientsByOwner[owner]
+	var opens []*openState
+	if client != nil && client.verifier != verifier {
+		// The client has rebooted so forget its state
+		opens = s.removeClient(client)
+		client = nil
+	}
+	if client == nil {
+		s.nextID++
+		client = &nfsClient{
+			id:       uint64(s.bootTime)<<32 | (s.nextID & 0xFFFFFFFF),
+			owner:    owner,
+			verifier: verifier,
+			seq:      1,
+		}
+		s.clients[client.id] = client
+		s.clientsByOwner[owner] = client
+	}
+	flags := uint32(exchgid4FlagUseNonPNFS)
+	if client.confirmed {
+		flags |= exchgid4FlagConfirmedR
+	}
+	id, seq := client.id, client.seq
+	s.mu.Unlock()
+	closeOpens(opens)
+
+	hostname, _ := os.Hostname()
+	w.Uint64(id)
+	w.Uint32(seq)
+	w.Uint32(flags)
+	w.Uint32(sp4None)
+	w.Uint64(0)                    // so_minor_id
+	w.String("rclone " + hostname) // so_major_id
+	w.String("rclone " + hostname) // server scope
+	w.Uint32(0)                    // no server_impl_id
+	return nfs4OK
+}
+
+// channelAttrs are the channel_attrs4 of a session
+type channelAttrs struct {
+	headerPadSize         uint32
+	maxRequestSize        uint32
+	maxResponseSize       uint32
+	maxResponseSizeCached uint32
+	maxOperations         uint32
+	maxRequests           uint32
+	rdmaIrd               []uint32
+}
+
+// read the channel attributes
+func (a *channelAttrs) read(r *xdr.Reader) {
+	a.headerPadSize = r.Uint32()
+	a.maxRequestSize = r.Uint32()
+	a.maxResponseSize = r.Uint32()
+	a.maxResponseSizeCached = r.Uint32()
+	a.maxOperations = r.Uint32()
+	a.maxRequests = r.Uint32()
+	n := r.Uint32()
+	if n > 1 {
+		r.SetErr(xdr.ErrBadXDR)
+		return
+	}
+	for i := uint32(0); i < n; i++ {
+		a.rdmaIrd = append(a.rdmaIrd, r.Uint32())
+	}
+}
+
+// write the channel attributes
+func (a *channelAttrs) write(w *xdr.Writer) {
+	w.Uint32(a.headerPadSize)
+	w.Uint32(a.maxRequestSize)
+	w.Uint32(a.maxResponseSize)
+	w.Uint32(a.maxResponseSizeCached)
+	w.Uint32(a.maxOperations)
+	w.Uint32(a.maxRequests)
+	w.Uint32(uint32(len(a.rdmaIrd)))
+	for _, x := range a.rdmaIrd {
+		w.Uint32(x)
+	}
+}
+
+// min returns the smallest of a and b
+func min(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// opCreateSession runs CREATE_SESSION
+func (c *compound) opCreateSession(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	clientID := r.Uint64()
+	seq := r.Uint32()
+	_ = r.Uint32() // flags
+	var fore, back channelAttrs
+	fore.read(r)
+	back.read(r)
+	_ = r.Uint32() // cb_program
+	// ignore the callback security parameters which are the
+	// last argument as the back channel isn't used
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil {
+		return nfs4errStaleClientid
+	}
+	if seq+1 == client.seq && client.lastCreateSession != nil {
+		// A retry of the last CREATE_SESSION
+		w.Fixed(client.lastCreateSession)
+		return nfs4OK
+	}
+	if seq != client.seq {
+		return nfs4errSeqMisordered
+	}
+
+	// Negotiate the fore channel
+	fore.headerPadSize = 0
+	fore.maxRequestSize = min(fore.maxRequestSize, maxRecordSize)
+	fore.maxResponseSize = min(fore.maxResponseSize, maxRecordSize)
+	fore.maxOperations = min(fore.maxOperations, maxOps)
+	fore.maxRequests = min(fore.maxRequests, maxSlots)
+	if fore.maxRequests == 0 {
+		fore.maxRequests = 1
+	}
+	fore.rdmaIrd = nil
+	back.headerPadSize = 0
+	back.maxRequests = min(back.maxRequests, 1)
+	back.rdmaIrd = nil
+
+	sess := &session{
+		client: client,
+		maxOps: fore.maxOperations,
+		slots:  make([]*slot, fore.maxRequests),
+	}
+	for i := range sess.slots {
+		sess.slots[i] = &slot{}
+	}
+	binary.BigEndian.PutUint64(sess.id[:8], clientID)
+	s.nextID++
+	binary.BigEndian.PutUint64(sess.id[8:], s.nextID)
+	s.sessions[sess.id] = sess
+	client.confirmed = true
+
+	res := &xdr.Writer{}
+	res.Fixed(sess.id[:])
+	res.Uint32(seq)
+	res.Uint32(0) // flags - no persistence or back channel
+	fore.write(res)
+	back.write(res)
+	client.seq++
+	client.lastCreateSession = res.Bytes()
+	w.Fixed(res.Bytes())
+	return nfs4OK
+}
+
+// opDestroySession runs DESTROY_SESSION
+func (c *compound) opDestroySession(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	var sessionID [16]byte
+	copy(sessionID[:], r.Fixed(16))
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[sessionID] == nil {
+		return nfs4errBadsession
+	}
+	delete(s.sessions, sessionID)
+	return nfs4OK
+}
+
+// opBindConnToSession runs BIND_CONN_TO_SESSION
+func (c *compound) opBindConnToSession(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	var sessionID [16]byte
+	copy(sessionID[:], r.Fixed(16))
+	_ = r.Uint32() // direction
+	_ = r.Bool()   // use RDMA
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[sessionID] == nil {
+		return nfs4errBadsession
+	}
+	// Any connection can be used with any session so there is
+	// nothing to do
+	w.Fixed(sessionID[:])
+	w.Uint32(cdfs4Fore)
+	w.Bool(false)
+	return nfs4OK
+}
+
+// opDestroyClientid runs DESTROY_CLIENTID
+func (c *compound) opDestroyClientid(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	clientID := r.Uint64()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	client := s.clients[clientID]
+	if client == nil {
+		s.mu.Unlock()
+		return nfs4errStaleClientid
+	}
+	for _, sess := range s.sessions {
+		if sess.client == client {
+			s.mu.Unlock()
+			return nfs4errClientidBusy
+		}
+	}
+	opens := s.removeClient(client)
+	s.mu.Unlock()
+	closeOpens(opens)
+	return nfs4OK
+}
+
+// opReclaimComplete runs RECLAIM_COMPLETE
+func (c *compound) opReclaimComplete(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	oneFS := r.Bool()
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if oneFS {
+		return nfs4OK
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.sess.client.reclaimComplete {
+		return nfs4errCompleteAlready
+	}
+	c.sess.client.reclaimComplete = true
+	return nfs4OK
+}
+
+// opSecinfoNoName runs SECINFO_NO_NAME
+func (c *compound) opSecinfoNoName(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	_ = r.Uint32() // style
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	if _, status := c.curPath(); status != nfs4OK {
+		return status
+	}
+	c.haveCur = false
+	writeSecinfo(w)
+	return nfs4OK
+}
+
+// opSecinfo runs SECINFO
+func (c *compound) opSecinfo(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	name := r.String(maxNameLength + 1)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	p, status := c.childPath(name)
+	if status != nfs4OK {
+		return status
+	}
+	if _, err := c.vfs.Stat(p); err != nil {
+		return toStatus(err)
+	}
+	c.haveCur = false
+	writeSecinfo(w)
+	return nfs4OK
+}
+
+// writeSecinfo writes the security flavors supported
+func writeSecinfo(w *xdr.Writer) {
+	w.Uint32(2)
+	w.Uint32(authSys)
+	w.Uint32(authNone)
+}
+
+// opTestStateid runs TEST_STATEID
+func (c *compound) opTestStateid(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	n := r.Uint32()
+	if n > 1024 {
+		return nfs4errBadxdr
+	}
+	sids := make([]stateid, 0, n)
+	for i := uint32(0); i < n && r.Err() == nil; i++ {
+		sids = append(sids, readStateid(r))
+	}
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Uint32(n)
+	for _, sid := range sids {
+		if s.states[sid.other] != nil {
+			w.Uint32(uint32(nfs4OK))
+		} else {
+			w.Uint32(uint32(nfs4errBadStateid))
+		}
+	}
+	return nfs4OK
+}
+
+// opFreeStateid runs FREE_STATEID
+func (c *compound) opFreeStateid(r *xdr.Reader, w *xdr.Writer) nfsStatus {
+	sid := readStateid(r)
+	if r.Err() != nil {
+		return nfs4errBadxdr
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch state := s.states[sid.other].(type) {
+	case *lockState:
+		if s.holdsLocks(state) {
+			return nfs4errLocksHeld
+		}
+		delete(s.states, sid.other)
+		return nfs4OK
+	case *openState:
+		return nfs4errLocksHeld
+	}
+	return nfs4errBadStateid
+}
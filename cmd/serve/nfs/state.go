@@ -0,0 +1,258 @@
+// +build !plan9,!js
+
+package nfs
+
+import (
+	"os"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/xdr"
+	"github.com/rclone/rclone/vfs"
+)
+
+// stateid is a stateid4 identifying an open file or a set of locks
+type stateid struct {
+	seqid uint32
+	other [12]byte
+}
+
+var (
+	anonymousStateid = stateid{}
+	bypassStateid    = stateid{seqid: 0xFFFFFFFF, other: [12]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}
+	currentStateid   = stateid{seqid: 1}
+	invalidStateid   = stateid{seqid: 0xFFFFFFFF}
+)
+
+// readStateid reads a stateid4
+func readStateid(r *xdr.Reader) (sid stateid) {
+	sid.seqid = r.Uint32()
+	copy(sid.other[:], r.Fixed(12))
+	return sid
+}
+
+// writeStateid writes a stateid4
+func writeStateid(w *xdr.Writer, sid stateid) {
+	w.Uint32(sid.seqid)
+	w.Fixed(sid.other[:])
+}
+
+// nfsClient is a client which has done an EXCHANGE_ID
+type nfsClient struct {
+	id                uint64
+	owner             string  // co_ownerid the client identifies itself with
+	verifier          [8]byte // changes when the client reboots
+	confirmed         bool    // set by the first CREATE_SESSION
+	seq               uint32  // sequence number of the next CREATE_SESSION
+	lastCreateSession []byte  // reply to the last CREATE_SESSION for replays
+	reclaimComplete   bool    // set by RECLAIM_COMPLETE
+}
+
+// session is an NFSv4.1 session
+type session struct {
+	id     [16]byte
+	client *nfsClient
+	maxOps uint32
+	slots  []*slot
+}
+
+// slot is an entry in the slot table of a session which holds the
+// reply of the last request using it so retries can be answered.
+type slot struct {
+	seqid  uint32
+	inUse  bool   // set while a request is using the slot
+	cached bool   // set if reply is the cached reply
+	reply  []byte // the reply to the last request
+}
+
+// openState is the state for a file opened with OPEN
+type openState struct {
+	other  [12]byte
+	client *nfsClient
+	owner  string   // open owner
+	path   string   // path of the file when opened
+	vfs    *vfs.VFS // VFS the file was opened in
+
+	mu     sync.Mutex // protects the following
+	seqid  uint32
+	access uint32     // OPEN4_SHARE_ACCESS_*
+	h      vfs.Handle // opened on first use unless the file was created
+}
+
+// handle returns the VFS handle for the open file, opening it if
+// necessary.
+//
+// If trunc is set the file is opened with O_TRUNC if it isn't
+// already open.
+func (o *openState) handle(trunc bool) (h vfs.Handle, opened bool, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.h != nil {
+		return o.h, false, nil
+	}
+	flags := os.O_RDONLY
+	if o.access&open4ShareAccessWrite != 0 {
+		flags = os.O_RDWR
+		if trunc {
+			flags |= os.O_TRUNC
+		}
+	}
+	o.h, err = o.vfs.OpenFile(o.path, flags, 0777)
+	if err != nil {
+		return nil, false, err
+	}
+	return o.h, true, nil
+}
+
+// upgrade adds access to the open file. The handle is closed if it
+// was opened with less access so it is opened again when next used.
+func (o *openState) upgrade(access uint32) (sid stateid, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if access&^o.access != 0 {
+		o.access |= access
+		if o.h != nil {
+			err = o.h.Close()
+			o.h = nil
+		}
+	}
+	o.seqid++
+	return stateid{seqid: o.seqid, other: o.other}, err
+}
+
+// close the VFS handle if open
+func (o *openState) close() (err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.h != nil {
+		err = o.h.Close()
+		o.h = nil
+	}
+	return err
+}
+
+// lockState is the state for the locks held by a lock owner on an
+// open file
+type lockState struct {
+	other    [12]byte
+	seqid    uint32
+	open     *openState
+	clientID uint64 // clientid of the lock owner
+	owner    string // lock owner
+}
+
+// lockOwner is the owner of the locks taken through the server
+type lockOwner struct {
+	clientID uint64
+	owner    string
+}
+
+// lockOwner returns the owner of the locks held by l
+func (l *lockState) lockOwner() lockOwner {
+	return lockOwner{clientID: l.clientID, owner: l.owner}
+}
+
+// newOther returns a unique stateid other field
+//
+// Call with s.mu held
+func (s *server) newOther() (other [12]byte) {
+	s.nextID++
+	copy(other[:4], s.bootID[:])
+	for i := 0; i < 8; i++ {
+		other[4+i] = byte(s.nextID >> uint(56-8*i))
+	}
+	return other
+}
+
+// removeOpen removes the open state and its locks. The caller should
+// close it without s.mu held as closing may upload the file.
+//
+// Call with s.mu held
+func (s *server) removeOpen(o *openState) {
+	delete(s.states, o.other)
+	for other, state := range s.states {
+		if l, ok := state.(*lockState); ok && l.open == o {
+			s.unlock(l, 0, vfs.LockEOF)
+			delete(s.states, other)
+		}
+	}
+}
+
+// removeClient removes the client and all its state, returning the
+// open files which should be closed without s.mu held.
+//
+// Call with s.mu held
+func (s *server) removeClient(client *nfsClient) (opens []*openState) {
+	for id, sess := range s.sessions {
+		if sess.client == client {
+			delete(s.sessions, id)
+		}
+	}
+	for _, state := range s.states {
+		if o, ok := state.(*openState); ok && o.client == client {
+			s.removeOpen(o)
+			opens = append(opens, o)
+		}
+	}
+	delete(s.clients, client.id)
+	if s.clientsByOwner[client.owner] == client {
+		delete(s.clientsByOwner, client.owner)
+	}
+	return opens
+}
+
+// closeOpens closes the open files passed in, logging any errors
+func closeOpens(opens []*openState) {
+	for _, o := range opens {
+		err := o.close()
+		if err != nil {
+			fs.Errorf(o.path, "serve nfs: failed to close file: %v", err)
+		}
+	}
+}
+
+// conflictingLock returns the first lock on path in VFS which
+// conflicts with the range given or nil if there isn't one
+func (s *server) conflictingLock(VFS *vfs.VFS, path string, clientID uint64, owner string, start, end int64, write bool) *vfs.Lock {
+	return VFS.TestLock(path, vfs.Lock{
+		Owner: lockOwner{clientID: clientID, owner: owner},
+		Write: write,
+		Start: start,
+		End:   end,
+	})
+}
+
+// lock adds the range to the locks held by l, replacing any locks it
+// already holds in the range, or returns the lock which stops it
+func (s *server) lock(l *lockState, start, end int64, write bool) (conflict *vfs.Lock) {
+	lock := vfs.Lock{
+		Owner: l.lockOwner(),
+		Write: write,
+		Start: start,
+		End:   end,
+	}
+	for l.open.vfs.Lock(l.open.path, lock) != nil {
+		conflict = l.open.vfs.TestLock(l.open.path, lock)
+		if conflict != nil {
+			return conflict
+		}
+		// the conflicting lock has just been released so try again
+	}
+	return nil
+}
+
+// unlock removes the range from the locks held by l, splitting locks
+// which are partly in it
+func (s *server) unlock(l *lockState, start, end int64) {
+	l.open.vfs.Unlock(l.open.path, l.lockOwner(), start, end)
+}
+
+// holdsLocks returns whether l holds any locks
+func (s *server) holdsLocks(l *lockState) bool {
+	for _, held := range l.open.vfs.Locks(l.open.path) {
+		if held.Owner == l.lockOwner() {
+			return true
+		}
+	}
+	return false
+}
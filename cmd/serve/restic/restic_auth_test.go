@@ -0,0 +1,89 @@
+package restic
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResticUserDatabase checks the users from the user database are
+// served from their own roots
+func TestResticUserDatabase(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "rclone-restic-test-")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tempdir))
+	}()
+
+	sum := sha1.Sum([]byte("secret"))
+	hash := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	htpasswd := filepath.Join(tempdir, "htpasswd")
+	require.NoError(t, ioutil.WriteFile(htpasswd, []byte("alice:"+hash+"\nreader:"+hash+"\n"), 0600))
+	aliceRoot := filepath.Join(tempdir, "alice")
+	users := filepath.Join(tempdir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte(`alice "root=`+aliceRoot+`"`+"\n"+`reader "root=`+aliceRoot+`" read-only`+"\n"), 0600))
+
+	authOpt := auth.DefaultOpt
+	authOpt.Htpasswd = htpasswd
+	authOpt.Users = users
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	f := cmd.NewFsSrc([]string{filepath.Join(tempdir, "main")})
+	s, err := newServer(f, &opt, &authOpt)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	do := func(user, method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, s.Server.URL()+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Add("Accept", resticAPIV2)
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(data)
+	}
+
+	// alice's repository is made in her root
+	status, _ := do("alice", "POST", "?create=true", "")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = do("alice", "POST", "config", "alice config")
+	assert.Equal(t, http.StatusOK, status)
+	data, err := ioutil.ReadFile(filepath.Join(aliceRoot, "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice config", string(data))
+
+	// the read only user can read it but not change it
+	status, body := do("reader", "GET", "config", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "alice config", body)
+	status, _ = do("reader", "POST", "locks/0123456789", "lock")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = do("reader", "DELETE", "config", "")
+	assert.Equal(t, http.StatusForbidden, status)
+	_, err = os.Stat(filepath.Join(aliceRoot, "config"))
+	assert.NoError(t, err)
+
+	// users must log in
+	status, _ = do("", "GET", "config", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,114 @@
+package restic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/cmd/serve/httplib/httpflags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapUserPath(t *testing.T) {
+	for _, test := range []struct {
+		userPath, user, path string
+		want                 string
+		wantOK               bool
+	}{
+		{"%u", "alice", "/", "/alice/", true},
+		{"%u", "alice", "/config", "/alice/config", true},
+		{"backups/%u/", "alice", "/repo/data/12345", "/backups/alice/repo/data/12345", true},
+		{"100%%/%u", "alice", "/repo/", "/100%/alice/repo/", true},
+		{"%u", "alice", "/../bob/config", "/alice/bob/config", true},
+		{"%u", "", "/config", "", false},
+		{"%u", "..", "/config", "", false},
+		{"%u", "a/b", "/config", "", false},
+		{"%u", "a%u", "/config", "", false},
+	} {
+		got, gotOK := mapUserPath(test.userPath, test.user, test.path)
+		assert.Equal(t, test.wantOK, gotOK, test)
+		assert.Equal(t, test.want, got, test)
+	}
+}
+
+// newUserRequest returns a new HTTP request authenticated as user
+func newUserRequest(t testing.TB, user, method, path string, body string) *http.Request {
+	req := newRequest(t, method, path, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), httplib.ContextUserKey, user))
+	req.Header.Add("Accept", resticAPIV2)
+	return req
+}
+
+// TestResticUserPath runs tests on the restic handler code for per
+// user repositories in append-only mode
+func TestResticUserPath(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "rclone-restic-test-")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	// globally set user path and append-only mode
+	prevUserPath, prevAppendOnly := userPath, appendOnly
+	userPath, appendOnly = "backups/%u", true
+	defer func() {
+		userPath, appendOnly = prevUserPath, prevAppendOnly
+	}()
+
+	f := cmd.NewFsSrc([]string{tempdir})
+	srv := NewServer(f, &httpflags.Opt)
+
+	// Each user gets their own repository
+	for _, user := range []string{"alice", "bob"} {
+		for _, req := range []*http.Request{
+			newUserRequest(t, user, "POST", "/?create=true", ""),
+			newUserRequest(t, user, "POST", "/config", user+" config"),
+			newUserRequest(t, user, "POST", "/data/0123456789", user+" data"),
+			newUserRequest(t, user, "POST", "/locks/0123456789", user+" lock"),
+		} {
+			checkRequest(t, srv.ServeHTTP, req, []wantFunc{wantCode(http.StatusOK)})
+		}
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, "backups", user, "config"))
+		require.NoError(t, err)
+		assert.Equal(t, user+" config", string(data))
+		data, err = ioutil.ReadFile(filepath.Join(tempdir, "backups", user, "data", "01", "0123456789"))
+		require.NoError(t, err)
+		assert.Equal(t, user+" data", string(data))
+		checkRequest(t, srv.ServeHTTP, newUserRequest(t, user, "GET", "/config", ""), []wantFunc{
+			wantCode(http.StatusOK),
+			wantBody(user + " config"),
+		})
+		checkRequest(t, srv.ServeHTTP, newUserRequest(t, user, "GET", "/data/", ""), []wantFunc{
+			wantCode(http.StatusOK),
+			wantBody(`[{"name":"0123456789","size":` + map[string]string{"alice": "10", "bob": "8"}[user] + "}]\n"),
+		})
+	}
+
+	// Data can't be deleted or overwritten but locks can be deleted
+	for _, test := range []struct {
+		req  *http.Request
+		code int
+	}{
+		{newUserRequest(t, "alice", "DELETE", "/data/0123456789", ""), http.StatusForbidden},
+		{newUserRequest(t, "alice", "POST", "/data/0123456789", "overwritten"), http.StatusForbidden},
+		{newUserRequest(t, "alice", "DELETE", "/config", ""), http.StatusForbidden},
+		{newUserRequest(t, "alice", "DELETE", "/locks/0123456789", ""), http.StatusOK},
+		{newUserRequest(t, "alice", "GET", "/locks/0123456789", ""), http.StatusNotFound},
+		{newUserRequest(t, "bob", "GET", "/locks/0123456789", ""), http.StatusOK},
+		{newUserRequest(t, "..", "GET", "/alice/config", ""), http.StatusForbidden},
+		{newUserRequest(t, "", "GET", "/backups/alice/config", ""), http.StatusForbidden},
+	} {
+		checkRequest(t, srv.ServeHTTP, test.req, []wantFunc{wantCode(test.code)})
+	}
+	data, err := ioutil.ReadFile(filepath.Join(tempdir, "backups", "alice", "data", "01", "0123456789"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice data", string(data))
+}
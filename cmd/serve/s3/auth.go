@@ -0,0 +1,447 @@
+// Checking AWS signature version 4 on requests
+
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	signV4Algorithm  = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+	maxClockSkew     = 15 * time.Minute   // max difference between the request time and now
+	maxPresignExpiry = 7 * 24 * time.Hour // max lifetime of a presigned URL
+	maxChunkSize     = 16 * 1024 * 1024   // largest chunk accepted in aws-chunked bodies
+)
+
+// the SHA256 of no data
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signature is the signature of a request parsed from the
+// Authorization header or the query of a presigned URL
+type signature struct {
+	accessKey     string   // access key ID of the signer
+	amzDate       string   // time of the request in amzDateFormat
+	scope         string   // date/region/service/aws4_request
+	signedHeaders []string // lower case names of the signed headers in order
+	signature     string   // the hex signature
+	payloadHash   string   // hex SHA256 of the body or unsignedPayload or streamingPayload
+	presigned     bool     // set if this came from the query
+}
+
+// authenticate checks the signature on the request if any keys or a
+// user database are configured.
+//
+// It returns the body to read the request data from, which checks it
+// against its signed hash if possible, and decodes it if it was sent
+// with aws-chunked encoding. If the request was signed by a user from
+// the user database it returns the user too.
+func (s *Server) authenticate(r *http.Request) (body io.ReadCloser, u *auth.User, err error) {
+	body = r.Body
+	chunked := r.Header.Get("x-amz-content-sha256") == streamingPayload
+	if len(s.keys) == 0 && s.authDB == nil {
+		if chunked {
+			body = newChunkedReader(body, nil)
+		}
+		return body, nil, nil
+	}
+	var sig *signature
+	if r.URL.Query().Get("X-Amz-Algorithm") != "" {
+		sig, err = parsePresigned(r)
+	} else {
+		sig, err = parseAuthorization(r)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, ok := s.keys[sig.accessKey]
+	if !ok && s.authDB != nil {
+		secret, err = s.authDB.Secret(sig.accessKey)
+		if err != nil {
+			fs.Debugf(nil, "S3 access key %q: %v", sig.accessKey, err)
+			return nil, nil, errInvalidAccessKeyID
+		}
+		u, err = s.authDB.User(sig.accessKey)
+		if err != nil {
+			fs.Errorf(nil, "S3 access key %q: %v", sig.accessKey, err)
+			return nil, nil, errAccessDenied
+		}
+	} else if !ok {
+		return nil, nil, errInvalidAccessKeyID
+	}
+	key := signingKey(secret, sig.scope)
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign(sig.amzDate, sig.scope, canonicalRequest(r, sig))))
+	if !hmac.Equal([]byte(want), []byte(sig.signature)) {
+		return nil, nil, errSignatureDoesNotMatch
+	}
+	switch sig.payloadHash {
+	case unsignedPayload:
+	case streamingPayload:
+		body = newChunkedReader(body, &chunkSigner{
+			key:     key,
+			amzDate: sig.amzDate,
+			scope:   sig.scope,
+			prev:    sig.signature,
+		})
+	default:
+		body = &sha256Reader{
+			in:     body,
+			hasher: sha256.New(),
+			want:   strings.ToLower(sig.payloadHash),
+		}
+	}
+	return body, u, nil
+}
+
+// parseAuthorization reads the signature from the Authorization header
+func parseAuthorization(r *http.Request) (*signature, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, errAccessDenied
+	}
+	if !strings.HasPrefix(auth, signV4Algorithm+" ") {
+		return nil, errAuthorizationMalformed
+	}
+	sig := &signature{}
+	var credential, signedHeaders string
+	for _, field := range strings.Split(auth[len(signV4Algorithm)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errAuthorizationMalformed
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signedHeaders = kv[1]
+		case "Signature":
+			sig.signature = kv[1]
+		}
+	}
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	if sig.amzDate == "" {
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return nil, errAccessDenied
+		}
+		sig.amzDate = date.UTC().Format(amzDateFormat)
+	}
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, errInvalidArgument
+	}
+	err := sig.setCredential(credential, signedHeaders)
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil {
+		return nil, errAuthorizationMalformed
+	}
+	skew := time.Since(date)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errTimeTooSkewed
+	}
+	return sig, nil
+}
+
+// parsePresigned reads the signature from the query of a presigned URL
+func parsePresigned(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, errAuthorizationMalformed
+	}
+	sig := &signature{
+		amzDate:     query.Get("X-Amz-Date"),
+		signature:   query.Get("X-Amz-Signature"),
+		payloadHash: unsignedPayload,
+		presigned:   true,
+	}
+	if hash := query.Get("X-Amz-Content-Sha256"); hash != "" {
+		sig.payloadHash = hash
+	}
+	err := sig.setCredential(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"))
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil {
+		return nil, errAuthorizationMalformed
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, errAuthorizationMalformed
+	}
+	now := time.Now()
+	if now.Before(date.Add(-maxClockSkew)) {
+		return nil, errTimeTooSkewed
+	}
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredRequest
+	}
+	return sig, nil
+}
+
+// setCredential sets the access key, scope and signed headers from
+// their values in the Authorization header or query
+//
+// The credential is accessKey/date/region/service/aws4_request
+func (sig *signature) setCredential(credential, signedHeaders string) error {
+	parts := strings.SplitN(credential, "/", 2)
+	if len(parts) != 2 || signedHeaders == "" || sig.signature == "" {
+		return errAuthorizationMalformed
+	}
+	sig.accessKey, sig.scope = parts[0], parts[1]
+	scope := strings.Split(sig.scope, "/")
+	if len(scope) != 4 || scope[3] != "aws4_request" || !strings.HasPrefix(sig.amzDate, scope[0]) {
+		return errAuthorizationMalformed
+	}
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+	return nil
+}
+
+// signingKey derives the key to sign requests with from the secret
+// access key and the scope
+func signingKey(secret, scope string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// hexSHA256 returns the hex SHA256 of data
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stringToSign makes the string which is signed for a request
+func stringToSign(amzDate, scope, canonicalRequest string) string {
+	return signV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+}
+
+// canonicalRequest makes the canonical form of the request which is
+// hashed for the signature
+func canonicalRequest(r *http.Request, sig *signature) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString("\n")
+	b.WriteString(uriEncode(r.URL.Path, false))
+	b.WriteString("\n")
+	b.WriteString(canonicalQuery(r, sig.presigned))
+	b.WriteString("\n")
+	for _, name := range sig.signedHeaders {
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(canonicalHeader(r, name))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(sig.signedHeaders, ";"))
+	b.WriteString("\n")
+	b.WriteString(sig.payloadHash)
+	return b.String()
+}
+
+// canonicalQuery makes the sorted and encoded query string, leaving
+// out the signature of presigned URLs
+func canonicalQuery(r *http.Request, presigned bool) string {
+	query := r.URL.Query()
+	var params []string
+	for key, values := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeader returns the value of the header name in canonical
+// form, with the values joined by commas and spaces trimmed
+func canonicalHeader(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values(name)
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// uriEncode encodes s as AWS signature version 4 requires, leaving
+// only the unreserved characters and, if encodeSlash isn't set, "/"
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// sha256Reader checks the data read matches the SHA256 it was signed
+// with, returning errContentSHA256Mismatch at the end if it doesn't
+type sha256Reader struct {
+	in     io.ReadCloser
+	hasher hash.Hash
+	want   string
+}
+
+// Read data checking the hash at the end
+func (s *sha256Reader) Read(p []byte) (n int, err error) {
+	n, err = s.in.Read(p)
+	_, _ = s.hasher.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(s.hasher.Sum(nil)) != s.want {
+		err = errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// Close the underlying reader
+func (s *sha256Reader) Close() error {
+	return s.in.Close()
+}
+
+// chunkSigner checks the signatures of the chunks of aws-chunked bodies
+type chunkSigner struct {
+	key     []byte // signing key of the request
+	amzDate string // time of the request
+	scope   string // scope of the request
+	prev    string // signature of the previous chunk or the request
+}
+
+// check the signature of the chunk of data
+func (cs *chunkSigner) check(data []byte, sig string) error {
+	toSign := signV4Algorithm + "-PAYLOAD\n" + cs.amzDate + "\n" + cs.scope + "\n" + cs.prev + "\n" + emptySHA256 + "\n" + hexSHA256(data)
+	want := hex.EncodeToString(hmacSHA256(cs.key, toSign))
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return errSignatureDoesNotMatch
+	}
+	cs.prev = sig
+	return nil
+}
+
+// chunkedReader decodes bodies sent with aws-chunked encoding, where
+// each chunk is preceded by its hex size and its signature
+//
+//	<hex size>;chunk-signature=<signature>\r\n<data>\r\n
+//
+// and the last chunk is empty.
+type chunkedReader struct {
+	in     *bufio.Reader
+	closer io.Closer
+	signer *chunkSigner // nil if not checking the signatures
+	buf    []byte       // unread data of the current chunk
+	err    error        // error to return when buf is empty
+}
+
+// newChunkedReader decodes in, checking the signatures of the chunks
+// if signer is set
+func newChunkedReader(in io.ReadCloser, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{
+		in:     bufio.NewReader(in),
+		closer: in,
+		signer: signer,
+	}
+}
+
+// Read the decoded data
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readChunk()
+	}
+	n = copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readChunk reads the next chunk into buf, returning io.EOF after
+// the last one
+func (c *chunkedReader) readChunk() error {
+	line, err := c.in.ReadString('\n')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	parts := strings.SplitN(strings.TrimRight(line, "\r\n"), ";", 2)
+	size, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errInvalidArgument
+	}
+	var sig string
+	if len(parts) == 2 {
+		sig = strings.TrimPrefix(parts[1], "chunk-signature=")
+	}
+	data := make([]byte, size+2)
+	_, err = io.ReadFull(c.in, data)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if string(data[size:]) != "\r\n" {
+		return errInvalidArgument
+	}
+	data = data[:size]
+	if c.signer != nil {
+		err = c.signer.check(data, sig)
+		if err != nil {
+			return err
+		}
+	}
+	if size == 0 {
+		return io.EOF
+	}
+	c.buf = data
+	return nil
+}
+
+// Close the underlying reader
+func (c *chunkedReader) Close() error {
+	return c.closer.Close()
+}
@@ -0,0 +1,330 @@
+// Bucket operations and listing
+
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// the most keys returned in one listing
+const maxListKeys = 1000
+
+// listRoot lists the root of the remote for ctx which holds the
+// buckets. The root of a user mightn't exist until they make a bucket.
+func (s *Server) listRoot(ctx context.Context) (fs.DirEntries, error) {
+	entries, err := s.remote(ctx).List(ctx, "")
+	if err == fs.ErrorDirNotFound {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// checkBucket returns errNoSuchBucket if bucket doesn't exist
+func (s *Server) checkBucket(ctx context.Context, bucket string) error {
+	entries, err := s.listRoot(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Directory); ok && entry.Remote() == bucket {
+			return nil
+		}
+	}
+	return errNoSuchBucket
+}
+
+// listBuckets serves ListBuckets
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	entries, err := s.listRoot(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sort.Sort(entries)
+	result := listBucketsResult{
+		Owner:   rcloneOwner,
+		Buckets: []bucketInfo{},
+	}
+	entries.ForDir(func(dir fs.Directory) {
+		result.Buckets = append(result.Buckets, bucketInfo{
+			Name:         dir.Remote(),
+			CreationDate: s3Time(dir.ModTime(ctx)),
+		})
+	})
+	writeXML(w, http.StatusOK, result)
+}
+
+// getBucketLocation serves GetBucketLocation
+func (s *Server) getBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := s.checkBucket(r.Context(), bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeXML(w, http.StatusOK, locationResult{})
+}
+
+// headBucket serves HeadBucket
+func (s *Server) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := s.checkBucket(r.Context(), bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// createBucket serves CreateBucket
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	if _, err := remotePath(bucket, ""); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := s.checkBucket(ctx, bucket)
+	if err == nil {
+		writeError(w, r, errBucketAlreadyExists)
+		return
+	} else if err != errNoSuchBucket {
+		writeError(w, r, err)
+		return
+	}
+	err = s.remote(ctx).Mkdir(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucket serves DeleteBucket
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	err := s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	entries, err := s.remote(ctx).List(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(entries) > 0 {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+	err = s.remote(ctx).Rmdir(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listEntry is an object or a common prefix found when listing
+type listEntry struct {
+	key string
+	o   fs.Object // nil for a common prefix
+}
+
+// list returns the objects in bucket whose keys start with prefix,
+// sorted by key.
+//
+// If delimiter is set, the keys which have it after the prefix are
+// returned as a single common prefix up to and including it.
+func (s *Server) list(ctx context.Context, bucket, prefix, delimiter string) (entries []listEntry, err error) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	root := path.Join(bucket, dir)
+	prefixes := map[string]struct{}{}
+	add := func(key string, o fs.Object) {
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				if _, found := prefixes[commonPrefix]; !found {
+					prefixes[commonPrefix] = struct{}{}
+					entries = append(entries, listEntry{key: commonPrefix})
+				}
+				return
+			}
+		}
+		entries = append(entries, listEntry{key: key, o: o})
+	}
+	if delimiter == "/" {
+		// Only the directory of the prefix needs reading
+		var dirEntries fs.DirEntries
+		dirEntries, err = s.remote(ctx).List(ctx, root)
+		for _, entry := range dirEntries {
+			key := strings.TrimPrefix(entry.Remote(), bucket+"/")
+			switch x := entry.(type) {
+			case fs.Object:
+				add(key, x)
+			case fs.Directory:
+				add(key+"/", nil)
+			}
+		}
+	} else {
+		err = walk.ListR(ctx, s.remote(ctx), root, true, -1, walk.ListObjects, func(dirEntries fs.DirEntries) error {
+			dirEntries.ForObject(func(o fs.Object) {
+				add(strings.TrimPrefix(o.Remote(), bucket+"/"), o)
+			})
+			return nil
+		})
+	}
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
+}
+
+// listObjects serves ListObjects or ListObjectsV2 if v2 is set
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string, v2 bool) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		// S3 leaves "/" unencoded
+		encode = func(s string) string {
+			return strings.Replace(url.QueryEscape(s), "%2F", "/", -1)
+		}
+	}
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	result := listObjectsResult{
+		Name:         bucket,
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		EncodingType: query.Get("encoding-type"),
+		MaxKeys:      maxKeys,
+	}
+	var after string
+	if v2 {
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, errInvalidArgument)
+				return
+			}
+			after = string(decoded)
+			result.ContinuationToken = token
+		} else {
+			after = query.Get("start-after")
+			result.StartAfter = encode(after)
+		}
+	} else {
+		after = query.Get("marker")
+		marker := encode(after)
+		result.Marker = &marker
+	}
+
+	err := s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	entries, err := s.list(ctx, bucket, prefix, delimiter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	count := 0
+	last := ""
+	for _, entry := range entries {
+		if entry.key <= after {
+			continue
+		}
+		if count >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if entry.o == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(entry.key)})
+		} else {
+			info := objectInfo{
+				Key:          encode(entry.key),
+				LastModified: s3Time(entry.o.ModTime(ctx)),
+				ETag:         s.etag(ctx, entry.o),
+				Size:         entry.o.Size(),
+				StorageClass: "STANDARD",
+			}
+			if !v2 {
+				info.Owner = &rcloneOwner
+			}
+			result.Contents = append(result.Contents, info)
+		}
+		count++
+		last = entry.key
+	}
+	if v2 {
+		result.KeyCount = &count
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		}
+	} else if result.IsTruncated {
+		result.NextMarker = encode(last)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// deleteObjects serves DeleteObjects
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	var req deleteRequest
+	err := readXML(r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var result deleteResult
+	for _, object := range req.Objects {
+		err = s.deleteKey(ctx, bucket, object.Key)
+		if err != nil {
+			apiErr := toAPIError(r, err)
+			result.Errors = append(result.Errors, deleteError{
+				Key:     object.Key,
+				Code:    apiErr.Code,
+				Message: apiErr.Message,
+			})
+		} else if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
@@ -0,0 +1,376 @@
+// Multipart uploads
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// the most parts a multipart upload may have
+const maxParts = 10000
+
+// multipartUpload is a multipart upload in progress.
+//
+// Its parts are stored as files named by their part number in dir.
+type multipartUpload struct {
+	bucket    string
+	key       string
+	id        string
+	dir       string     // where the parts are stored
+	initiated time.Time  // when the upload was created
+	modTime   time.Time  // modification time to set on the object
+	owner     *auth.User // user who started the upload if any
+
+	mu    sync.Mutex
+	parts map[int]*part // uploaded parts by part number
+}
+
+// part is an uploaded part of a multipart upload
+type part struct {
+	md5     []byte // MD5 hash of the data
+	size    int64
+	modTime time.Time // when the part was uploaded
+}
+
+// etag returns the ETag of the part
+func (p *part) etag() string {
+	return `"` + hex.EncodeToString(p.md5) + `"`
+}
+
+// getUpload finds the upload with id for key in bucket if it
+// belongs to the user of ctx
+func (s *Server) getUpload(ctx context.Context, bucket, key, id string) (*multipartUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, found := s.uploads[id]
+	if !found || u.bucket != bucket || u.key != key || u.owner != getUser(ctx) {
+		return nil, errNoSuchUpload
+	}
+	return u, nil
+}
+
+// removeUpload stops the upload with id for key in bucket being
+// found and returns it if it belongs to the user of ctx
+func (s *Server) removeUpload(ctx context.Context, bucket, key, id string) (*multipartUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, found := s.uploads[id]
+	if !found || u.bucket != bucket || u.key != key || u.owner != getUser(ctx) {
+		return nil, errNoSuchUpload
+	}
+	delete(s.uploads, id)
+	return u, nil
+}
+
+// createMultipartUpload serves CreateMultipartUpload
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	_, err := remotePath(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	u := &multipartUpload{
+		bucket:    bucket,
+		key:       key,
+		id:        newID(),
+		initiated: time.Now(),
+		owner:     getUser(ctx),
+		parts:     make(map[int]*part),
+	}
+	if r.Header.Get("X-Amz-Meta-Mtime") != "" {
+		u.modTime = modTime(r)
+	}
+	u.dir = filepath.Join(s.tempDir, u.id)
+	err = os.Mkdir(u.dir, 0700)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.mu.Lock()
+	s.uploads[u.id] = u
+	s.mu.Unlock()
+	fs.Debugf(key, "Started multipart upload %s", u.id)
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: u.id,
+	})
+}
+
+// uploadPart serves UploadPart
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxParts {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	u, err := s.getUpload(r.Context(), bucket, key, uploadID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Write the part to a temporary file so parts being uploaded
+	// again don't overwrite each other
+	out, err := ioutil.TempFile(u.dir, "upload-")
+	if err != nil {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	hasher := md5.New()
+	size, err := io.Copy(io.MultiWriter(out, hasher), r.Body)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkMD5(r, hasher)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), filepath.Join(u.dir, strconv.Itoa(partNumber)))
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		writeError(w, r, err)
+		return
+	}
+
+	p := &part{
+		md5:     hasher.Sum(nil),
+		size:    size,
+		modTime: time.Now(),
+	}
+	u.mu.Lock()
+	u.parts[partNumber] = p
+	u.mu.Unlock()
+	w.Header().Set("ETag", p.etag())
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload serves CompleteMultipartUpload
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	ctx := r.Context()
+	var req completeMultipartUploadRequest
+	err := readXML(r, &req)
+	if err == nil && len(req.Parts) == 0 {
+		err = errMalformedXML
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	remote, err := remotePath(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Take the upload so it can't be completed or aborted twice
+	u, err := s.removeUpload(ctx, bucket, key, uploadID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.mu.Lock()
+			s.uploads[u.id] = u
+			s.mu.Unlock()
+		}
+	}()
+
+	// Check the parts and open them in order
+	var (
+		readers []io.Reader
+		size    int64
+		sums    = md5.New()
+	)
+	u.mu.Lock()
+	for i, reqPart := range req.Parts {
+		if i > 0 && reqPart.PartNumber <= req.Parts[i-1].PartNumber {
+			err = errInvalidPartOrder
+			break
+		}
+		p, found := u.parts[reqPart.PartNumber]
+		if !found || strings.Trim(reqPart.ETag, `"`) != hex.EncodeToString(p.md5) {
+			err = errInvalidPart
+			break
+		}
+		var in *os.File
+		in, err = os.Open(filepath.Join(u.dir, strconv.Itoa(reqPart.PartNumber)))
+		if err != nil {
+			break
+		}
+		defer fs.CheckClose(in, &err)
+		readers = append(readers, in)
+		size += p.size
+		_, _ = sums.Write(p.md5)
+	}
+	u.mu.Unlock()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	modTime := u.modTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	_, err = operations.RcatSize(ctx, s.remote(ctx), remote, ioutil.NopCloser(io.MultiReader(readers...)), size, modTime)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if removeErr := os.RemoveAll(u.dir); removeErr != nil {
+		fs.Errorf(key, "Failed to remove parts of multipart upload: %v", removeErr)
+	}
+	fs.Debugf(key, "Completed multipart upload %s with %d parts", u.id, len(req.Parts))
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: "http://" + r.Host + r.URL.Path,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     `"` + hex.EncodeToString(sums.Sum(nil)) + "-" + strconv.Itoa(len(req.Parts)) + `"`,
+	})
+}
+
+// abortMultipartUpload serves AbortMultipartUpload
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	u, err := s.removeUpload(r.Context(), bucket, key, uploadID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = os.RemoveAll(u.dir)
+	if err != nil {
+		fs.Errorf(key, "Failed to remove parts of multipart upload: %v", err)
+	}
+	fs.Debugf(key, "Aborted multipart upload %s", u.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listParts serves ListParts
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	query := r.URL.Query()
+	marker, limit := 0, maxListKeys
+	var err error
+	if value := query.Get("part-number-marker"); value != "" {
+		marker, err = strconv.Atoi(value)
+	}
+	if value := query.Get("max-parts"); value != "" && err == nil {
+		var n int
+		n, err = strconv.Atoi(value)
+		if n < limit {
+			limit = n
+		}
+	}
+	if err != nil || marker < 0 || limit < 0 {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	u, err := s.getUpload(r.Context(), bucket, key, uploadID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	result := listPartsResult{
+		Bucket:           bucket,
+		Key:              key,
+		UploadID:         uploadID,
+		StorageClass:     "STANDARD",
+		PartNumberMarker: marker,
+		MaxParts:         limit,
+	}
+	u.mu.Lock()
+	numbers := make([]int, 0, len(u.parts))
+	for number := range u.parts {
+		if number > marker {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		if len(result.Parts) >= limit {
+			result.IsTruncated = true
+			break
+		}
+		p := u.parts[number]
+		result.Parts = append(result.Parts, partInfo{
+			PartNumber:   number,
+			LastModified: s3Time(p.modTime),
+			ETag:         p.etag(),
+			Size:         p.size,
+		})
+		result.NextPartNumberMarker = number
+	}
+	u.mu.Unlock()
+	writeXML(w, http.StatusOK, result)
+}
+
+// listMultipartUploads serves ListMultipartUploads
+func (s *Server) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	err := s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	var uploads []*multipartUpload
+	s.mu.Lock()
+	for _, u := range s.uploads {
+		if u.bucket == bucket && strings.HasPrefix(u.key, prefix) && u.owner == getUser(ctx) {
+			uploads = append(uploads, u)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].key != uploads[j].key {
+			return uploads[i].key < uploads[j].key
+		}
+		return uploads[i].initiated.Before(uploads[j].initiated)
+	})
+	result := listMultipartUploadsResult{
+		Bucket:     bucket,
+		Prefix:     prefix,
+		MaxUploads: maxListKeys,
+	}
+	for _, u := range uploads {
+		if len(result.Uploads) >= maxListKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, uploadInfo{
+			Key:          u.key,
+			UploadID:     u.id,
+			Initiated:    s3Time(u.initiated),
+			StorageClass: "STANDARD",
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
@@ -0,0 +1,287 @@
+// Object operations
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncw/swift"
+	"github.com/rclone/rclone/cmd/serve/httplib/serve"
+	"github.com/rclone/rclone/fs"
+	fshash "github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// the ETag of an empty object
+const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+// remotePath returns the path on the remote of key in bucket, or an
+// error if either can't be mapped onto the remote
+func remotePath(bucket, key string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." {
+		return "", errInvalidBucketName
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "." || segment == ".." || (segment == "" && i != len(segments)-1) {
+			return "", errInvalidArgument
+		}
+	}
+	return path.Join(bucket, key), nil
+}
+
+// etag returns the ETag for o.
+//
+// This is its MD5 hash if the remote can read it quickly, otherwise
+// it is made from the size and modification time.
+func (s *Server) etag(ctx context.Context, o fs.Object) string {
+	f := s.remote(ctx)
+	if !f.Features().SlowHash && f.Hashes().Contains(fshash.MD5) {
+		sum, err := o.Hash(ctx, fshash.MD5)
+		if err == nil && sum != "" {
+			return `"` + sum + `"`
+		}
+	}
+	h := md5.New()
+	_, _ = fmt.Fprintf(h, "%d:%d", o.Size(), o.ModTime(ctx).UnixNano())
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `-1"`
+}
+
+// modTime returns the modification time set in the metadata of the
+// request or now if it hasn't got one
+func modTime(r *http.Request) time.Time {
+	if mtime := r.Header.Get("X-Amz-Meta-Mtime"); mtime != "" {
+		t, err := swift.FloatStringToTime(mtime)
+		if err == nil {
+			return t
+		}
+		fs.Debugf(r.URL.Path, "Failed to parse X-Amz-Meta-Mtime %q: %v", mtime, err)
+	}
+	return time.Now()
+}
+
+// contentLength returns the length of the data in the request,
+// allowing for aws-chunked encoding
+func contentLength(r *http.Request) (int64, error) {
+	if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+		size, err := strconv.ParseInt(decoded, 10, 64)
+		if err != nil || size < 0 {
+			return -1, errInvalidArgument
+		}
+		return size, nil
+	}
+	if r.ContentLength < 0 {
+		return -1, errMissingContentLength
+	}
+	return r.ContentLength, nil
+}
+
+// checkMD5 checks the MD5 of the data received in hasher matches the
+// Content-MD5 header if the request has one
+func checkMD5(r *http.Request, hasher hash.Hash) error {
+	contentMD5 := r.Header.Get("Content-MD5")
+	if contentMD5 == "" {
+		return nil
+	}
+	want, err := base64.StdEncoding.DecodeString(contentMD5)
+	if err != nil || string(want) != string(hasher.Sum(nil)) {
+		return errBadDigest
+	}
+	return nil
+}
+
+// setObjectHeaders sets the headers describing o in the response
+func (s *Server) setObjectHeaders(ctx context.Context, w http.ResponseWriter, o fs.Object) {
+	modTime := o.ModTime(ctx)
+	w.Header().Set("ETag", s.etag(ctx, o))
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Amz-Meta-Mtime", swift.TimeToFloatString(modTime))
+}
+
+// getObject serves GetObject and HeadObject
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	remote, err := remotePath(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		// Show the directory as an empty object
+		_, err = s.remote(ctx).List(ctx, remote)
+		if err != nil {
+			if err == fs.ErrorDirNotFound {
+				err = errNoSuchKey
+			}
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", emptyETag)
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	o, err := s.remote(ctx).NewObject(ctx, remote)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.setObjectHeaders(ctx, w, o)
+	serve.Object(w, r, o)
+}
+
+// putObject serves PutObject
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	remote, err := remotePath(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	size, err := contentLength(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") && size == 0 {
+		err = s.remote(ctx).Mkdir(ctx, remote)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", emptyETag)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	hasher := md5.New()
+	in := ioutil.NopCloser(io.TeeReader(r.Body, hasher))
+	o, err := operations.RcatSize(ctx, s.remote(ctx), remote, in, size, modTime(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = checkMD5(r, hasher)
+	if err != nil {
+		if removeErr := o.Remove(ctx); removeErr != nil {
+			fs.Errorf(o, "Failed to remove object with bad MD5: %v", removeErr)
+		}
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hasher.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyObject serves CopyObject
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	remote, err := remotePath(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	if i := strings.IndexRune(source, '?'); i >= 0 {
+		source = source[:i] // ignore versionId
+	}
+	srcRemote, err := remotePath(splitPath(source))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = s.checkBucket(ctx, bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o, err := s.remote(ctx).NewObject(ctx, srcRemote)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if srcRemote != remote {
+		o, err = operations.Copy(ctx, s.remote(ctx), nil, remote, o)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" && r.Header.Get("X-Amz-Meta-Mtime") != "" {
+		err = o.SetModTime(ctx, modTime(r))
+		if err != nil && err != fs.ErrorCantSetModTime && err != fs.ErrorCantSetModTimeWithoutDelete {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeXML(w, http.StatusOK, copyObjectResult{
+		ETag:         s.etag(ctx, o),
+		LastModified: s3Time(o.ModTime(ctx)),
+	})
+}
+
+// deleteObject serves DeleteObject
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	err := s.deleteKey(r.Context(), bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteKey deletes the object key in bucket.
+//
+// It isn't an error if the object doesn't exist. The empty
+// directories left behind are removed so they don't show up in
+// listings.
+func (s *Server) deleteKey(ctx context.Context, bucket, key string) error {
+	remote, err := remotePath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		err = s.remote(ctx).Rmdir(ctx, remote)
+		if err != nil {
+			fs.Debugf(remote, "Failed to remove directory: %v", err)
+		}
+		return nil
+	}
+	o, err := s.remote(ctx).NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound || err == fs.ErrorNotAFile || err == fs.ErrorDirNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = operations.DeleteFile(ctx, o)
+	if err != nil {
+		return err
+	}
+	for dir := path.Dir(remote); dir != bucket && dir != "."; dir = path.Dir(dir) {
+		if s.remote(ctx).Rmdir(ctx, dir) != nil {
+			break
+		}
+	}
+	return nil
+}
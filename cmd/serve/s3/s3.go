@@ -0,0 +1,378 @@
+// Package s3 serves a remote over the S3 protocol
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/cmd/serve/httplib/httpflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Options contains options for the S3 server
+type Options struct {
+	AuthKeys []string // access key ID and secret access key pairs separated by ","
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// AddFlags adds flags for the s3 server
+func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
+	rc.AddOption("s3", &Opt)
+	flags.StringArrayVarP(flagSet, &Opt.AuthKeys, "auth-key", "", Opt.AuthKeys, "Set key pair for v4 authorization, split by comma. Can be repeated.")
+}
+
+func init() {
+	httpflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags(), &Opt)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "s3 remote:path",
+	Short: `Serve the remote over the S3 protocol.`,
+	Long: `rclone serve s3 implements a basic S3 server that serves the
+remote, so applications which only speak S3 can store their objects on
+any remote rclone supports.
+
+The directories at the top level of remote:path are the buckets and
+the files below them are the objects, with the path of a file inside
+its bucket as its key.
+
+    rclone serve s3 --auth-key ACCESS_KEY_ID,SECRET_ACCESS_KEY remote:path
+
+By default this will serve on "localhost:8080" you can change this
+with use of the "--addr" flag.
+
+The server will log errors.  Use -v to see access logs.
+
+--bwlimit will be respected for file transfers.  Use --stats to
+control the stats printing.
+
+### Authentication
+
+Requests are authenticated with AWS signature version 4, either in
+the Authorization header or in the query string of a presigned URL.
+Use --auth-key with an access key ID and secret access key separated
+by a comma to set the keys which are allowed. It can be repeated to
+allow more than one pair of keys. Every key can access all the
+buckets.
+
+If no --auth-key is given, no authentication is done and anyone who
+can reach the server has full access to the remote.
+
+The region in the signature isn't checked, so clients can be set to
+use any region, "us-east-1" is usual.
+
+The user database can be used as well as or instead of --auth-key.
+Checking a signature needs the secret access key itself, so the
+access key ID is the user name and the secret access key is the
+password stored with {PLAIN} in the --auth-htpasswd file. Each user
+is served their own root from --auth-users as buckets, read only
+users can only GET and HEAD, and the bandwidth limits of the users
+are respected. LDAP and OpenID Connect can't be used as they don't
+provide the secret.
+
+### Using the server
+
+The server only supports path style addressing, where the bucket is
+the first part of the path of the URL, so clients must be configured
+to use it. For example with rclone:
+
+    [serves3]
+    type = s3
+    provider = Other
+    access_key_id = ACCESS_KEY_ID
+    secret_access_key = SECRET_ACCESS_KEY
+    endpoint = http://127.0.0.1:8080/
+    force_path_style = true
+
+These operations are supported
+
+- ListBuckets, CreateBucket, HeadBucket, DeleteBucket, GetBucketLocation
+- ListObjects, ListObjectsV2
+- GetObject (with ranges), HeadObject, PutObject, CopyObject
+- DeleteObject, DeleteObjects
+- CreateMultipartUpload, UploadPart, CompleteMultipartUpload,
+  AbortMultipartUpload, ListParts, ListMultipartUploads
+
+### Limitations
+
+The ETag of an object is its MD5 hash if the remote supports MD5 and
+can read it quickly. Otherwise it is made from the size and
+modification time of the object and looks like the ETag of a
+multipart upload, so clients don't check it against the MD5 of the
+data.
+
+The modification time is set from the "X-Amz-Meta-Mtime" metadata if
+it is given, as rclone does, otherwise it is the time of the upload.
+Other metadata, versioning, ACLs, tagging and object locking aren't
+supported.
+
+Empty directories are listed as common prefixes when listing with
+the "/" delimiter. Uploading an empty object with a key ending in "/"
+creates a directory.
+
+The parts of multipart uploads are stored in a temporary directory on
+the local disk until the upload is completed, so it needs enough free
+space for the largest upload. Uploads which haven't been completed
+are lost if the server is restarted.
+` + httplib.Help + auth.Help,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := NewServer(f, &Opt, &httpflags.Opt, &authflags.Opt)
+			if err != nil {
+				return err
+			}
+			defer s.CleanUp()
+			err = s.Serve()
+			if err != nil {
+				return err
+			}
+			s.Wait()
+			return nil
+		})
+	},
+}
+
+// Server contains everything to run the Server
+type Server struct {
+	*httplib.Server
+	f       fs.Fs
+	opt     Options
+	keys    map[string]string // secret access keys by access key ID
+	authDB  *auth.DB          // user database if set
+	tempDir string            // where the parts of multipart uploads are kept
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload // multipart uploads in progress by upload ID
+}
+
+// NewServer returns an HTTP server that speaks the S3 protocol
+//
+// The users of the user database are served too if authOpt enables
+// it.
+//
+// Call CleanUp when finished with it.
+func NewServer(f fs.Fs, opt *Options, httpOpt *httplib.Options, authOpt *auth.Options) (*Server, error) {
+	s := &Server{
+		f:       f,
+		opt:     *opt,
+		keys:    make(map[string]string),
+		uploads: make(map[string]*multipartUpload),
+	}
+	for _, pair := range opt.AuthKeys {
+		parts := strings.SplitN(pair, ",", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("bad --auth-key %q: need access key ID and secret access key separated by a comma", pair)
+		}
+		s.keys[parts[0]] = parts[1]
+	}
+	var err error
+	if authOpt != nil && authOpt.Enabled() {
+		if authOpt.LDAPURL != "" || authOpt.OIDCIssuer != "" {
+			return nil, errors.New("can't use --auth-ldap-url or --auth-oidc-issuer with serve s3 as they don't provide the secret access key")
+		}
+		s.authDB, err = auth.New(context.Background(), f, authOpt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(s.keys) == 0 && s.authDB == nil {
+		fs.Logf(f, "No --auth-key set - serving without authentication")
+	}
+	s.tempDir, err = ioutil.TempDir("", "rclone-serve-s3")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make directory for multipart uploads")
+	}
+	mux := http.NewServeMux()
+	s.Server = httplib.NewServer(mux, httpOpt)
+	mux.HandleFunc(s.Opt.BaseURL+"/", s.ServeHTTP)
+	return s, nil
+}
+
+// Serve runs the http server in the background.
+//
+// Use s.Close() and s.Wait() to shutdown server
+func (s *Server) Serve() error {
+	err := s.Server.Serve()
+	if err != nil {
+		return err
+	}
+	fs.Logf(s.f, "Serving S3 on %s", s.URL())
+	return nil
+}
+
+// CleanUp removes the parts of any multipart uploads in progress
+func (s *Server) CleanUp() {
+	err := os.RemoveAll(s.tempDir)
+	if err != nil {
+		fs.Errorf(nil, "Failed to remove multipart upload directory: %v", err)
+	}
+}
+
+// newID returns a new random ID for requests and uploads
+func newID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// ServeHTTP reads incoming requests and dispatches them
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", "rclone/"+fs.Version)
+	w.Header().Set("x-amz-request-id", newID())
+
+	urlPath, ok := s.Path(w, r)
+	if !ok {
+		return
+	}
+	fs.Debugf(s.f, "%s %s?%s", r.Method, urlPath, r.URL.RawQuery)
+
+	body, u, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	r.Body = body
+	if u != nil {
+		if u.ReadOnly && r.Method != "GET" && r.Method != "HEAD" {
+			fs.Infof(s.f, "%s: refusing %s for read only user %q", r.RemoteAddr, r.Method, u.Name)
+			writeError(w, r, errAccessDenied)
+			return
+		}
+		w, r = u.LimitHTTP(w, r)
+		r = r.WithContext(context.WithValue(r.Context(), httplib.ContextAuthKey, u))
+	}
+
+	bucket, key := splitPath(urlPath)
+	query := r.URL.Query()
+	switch {
+	case bucket == "":
+		if r.Method == "GET" {
+			s.listBuckets(w, r)
+			return
+		}
+	case key == "":
+		switch r.Method {
+		case "GET":
+			switch {
+			case has(query, "location"):
+				s.getBucketLocation(w, r, bucket)
+			case has(query, "uploads"):
+				s.listMultipartUploads(w, r, bucket)
+			case query.Get("list-type") == "2":
+				s.listObjects(w, r, bucket, true)
+			default:
+				s.listObjects(w, r, bucket, false)
+			}
+			return
+		case "HEAD":
+			s.headBucket(w, r, bucket)
+			return
+		case "PUT":
+			s.createBucket(w, r, bucket)
+			return
+		case "DELETE":
+			s.deleteBucket(w, r, bucket)
+			return
+		case "POST":
+			if has(query, "delete") {
+				s.deleteObjects(w, r, bucket)
+				return
+			}
+		}
+	default:
+		uploadID := query.Get("uploadId")
+		switch r.Method {
+		case "GET", "HEAD":
+			if uploadID != "" && r.Method == "GET" {
+				s.listParts(w, r, bucket, key, uploadID)
+			} else {
+				s.getObject(w, r, bucket, key)
+			}
+			return
+		case "PUT":
+			switch {
+			case uploadID != "":
+				s.uploadPart(w, r, bucket, key, uploadID)
+			case r.Header.Get("x-amz-copy-source") != "":
+				s.copyObject(w, r, bucket, key)
+			default:
+				s.putObject(w, r, bucket, key)
+			}
+			return
+		case "POST":
+			switch {
+			case has(query, "uploads"):
+				s.createMultipartUpload(w, r, bucket, key)
+				return
+			case uploadID != "":
+				s.completeMultipartUpload(w, r, bucket, key, uploadID)
+				return
+			}
+		case "DELETE":
+			if uploadID != "" {
+				s.abortMultipartUpload(w, r, bucket, key, uploadID)
+			} else {
+				s.deleteObject(w, r, bucket, key)
+			}
+			return
+		}
+	}
+	writeError(w, r, errNotImplemented)
+}
+
+// getUser returns the user of the user database the request with ctx
+// was authenticated as or nil if there isn't one
+func getUser(ctx context.Context) *auth.User {
+	u, _ := ctx.Value(httplib.ContextAuthKey).(*auth.User)
+	return u
+}
+
+// remote returns the remote to serve the request with ctx from, which
+// is the root of its user if it has one
+func (s *Server) remote(ctx context.Context) fs.Fs {
+	if u := getUser(ctx); u != nil {
+		return u.VFS.Fs()
+	}
+	return s.f
+}
+
+// has returns whether the query has key, even if it has no value
+func has(query map[string][]string, key string) bool {
+	_, found := query[key]
+	return found
+}
+
+// splitPath splits the path of the URL into the bucket and the key
+func splitPath(urlPath string) (bucket, key string) {
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	i := strings.IndexRune(urlPath, '/')
+	if i < 0 {
+		return urlPath, ""
+	}
+	return urlPath[:i], urlPath[i+1:]
+}
@@ -0,0 +1,640 @@
+// Serve s3 tests set up a server and run the AWS SDK against it
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBindAddress = "localhost:0"
+	testAccessKey   = "AKIDRCLONE"
+	testSecretKey   = "rclone-secret-key"
+)
+
+// start a server serving a temporary directory
+func startServer(t *testing.T, authKeys []string) (s *Server, dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-serve-s3-test")
+	require.NoError(t, err)
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	s, err = NewServer(f, &Options{AuthKeys: authKeys}, &opt, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	return s, dir, func() {
+		s.Close()
+		s.Wait()
+		s.CleanUp()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// make an S3 client for the server using the keys given
+func newClient(t *testing.T, s *Server, accessKey, secretKey string) *awss3.S3 {
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		Endpoint:         aws.String(s.URL()),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	})
+	require.NoError(t, err)
+	return awss3.New(sess)
+}
+
+// check err is an S3 error with code
+func assertCode(t *testing.T, code string, err error) {
+	require.Error(t, err)
+	awsErr, ok := err.(awserr.Error)
+	require.True(t, ok, "want awserr.Error, got %T: %v", err, err)
+	assert.Equal(t, code, awsErr.Code())
+}
+
+// get the contents of key in bucket
+func get(t *testing.T, c *awss3.S3, bucket, key string) string {
+	return getRange(t, c, bucket, key, nil)
+}
+
+// get the contents of a range of key in bucket
+func getRange(t *testing.T, c *awss3.S3, bucket, key string, rangeHeader *string) string {
+	out, err := c.GetObject(&awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  rangeHeader,
+	})
+	require.NoError(t, err)
+	defer func() { _ = out.Body.Close() }()
+	data, err := ioutil.ReadAll(out.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func put(t *testing.T, c *awss3.S3, bucket, key, contents string) {
+	_, err := c.PutObject(&awss3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(contents),
+	})
+	require.NoError(t, err)
+}
+
+func TestBuckets(t *testing.T) {
+	s, dir, cleanup := startServer(t, []string{testAccessKey + "," + testSecretKey})
+	defer cleanup()
+	c := newClient(t, s, testAccessKey, testSecretKey)
+
+	_, err := c.HeadBucket(&awss3.HeadBucketInput{Bucket: aws.String("bucket")})
+	assertCode(t, "NotFound", err)
+
+	_, err = c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	fi, err := os.Stat(dir + "/bucket")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	_, err = c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	assertCode(t, "BucketAlreadyOwnedByYou", err)
+
+	_, err = c.HeadBucket(&awss3.HeadBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	buckets, err := c.ListBuckets(&awss3.ListBucketsInput{})
+	require.NoError(t, err)
+	require.Len(t, buckets.Buckets, 1)
+	assert.Equal(t, "bucket", *buckets.Buckets[0].Name)
+
+	location, err := c.GetBucketLocation(&awss3.GetBucketLocationInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	assert.Nil(t, location.LocationConstraint)
+
+	put(t, c, "bucket", "file.txt", "hello")
+	_, err = c.DeleteBucket(&awss3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	assertCode(t, "BucketNotEmpty", err)
+
+	_, err = c.DeleteObject(&awss3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("file.txt")})
+	require.NoError(t, err)
+	_, err = c.DeleteBucket(&awss3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = os.Stat(dir + "/bucket")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = c.PutObject(&awss3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file.txt"),
+		Body:   strings.NewReader("hello"),
+	})
+	assertCode(t, "NoSuchBucket", err)
+}
+
+func TestObjects(t *testing.T) {
+	s, _, cleanup := startServer(t, []string{testAccessKey + "," + testSecretKey})
+	defer cleanup()
+	c := newClient(t, s, testAccessKey, testSecretKey)
+	_, err := c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	// Put with a modification time
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	_, err = c.PutObject(&awss3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("dir/file.txt"),
+		Body:     strings.NewReader("hello world"),
+		Metadata: map[string]*string{"Mtime": aws.String("981173106")},
+	})
+	require.NoError(t, err)
+
+	head, err := c.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/file.txt")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), *head.ContentLength)
+	assert.Equal(t, modTime, head.LastModified.UTC())
+	assert.NotEmpty(t, *head.ETag)
+	assert.NotNil(t, head.Metadata["Mtime"])
+
+	_, err = c.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("missing")})
+	assertCode(t, "NotFound", err)
+
+	// Get all and a range
+	assert.Equal(t, "hello world", get(t, c, "bucket", "dir/file.txt"))
+	assert.Equal(t, "world", getRange(t, c, "bucket", "dir/file.txt", aws.String("bytes=6-")))
+	_, err = c.GetObject(&awss3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("missing")})
+	assertCode(t, "NoSuchKey", err)
+
+	// Keys escaping the bucket are refused
+	_, err = c.GetObject(&awss3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/../../x")})
+	assert.Error(t, err)
+
+	// A bad Content-MD5 is refused and nothing is left behind
+	_, err = c.PutObject(&awss3.PutObjectInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("bad.txt"),
+		Body:       strings.NewReader("hello"),
+		ContentMD5: aws.String("AAAAAAAAAAAAAAAAAAAAAA=="),
+	})
+	assertCode(t, "BadDigest", err)
+	_, err = c.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("bad.txt")})
+	assertCode(t, "NotFound", err)
+
+	// Copy
+	copied, err := c.CopyObject(&awss3.CopyObjectInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("copy.txt"),
+		CopySource: aws.String("bucket/dir/file.txt"),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, *copied.CopyObjectResult.ETag)
+	assert.Equal(t, "hello world", get(t, c, "bucket", "copy.txt"))
+
+	// Directory markers
+	put(t, c, "bucket", "empty/", "")
+	_, err = c.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("empty/")})
+	require.NoError(t, err)
+
+	// Delete several
+	deleted, err := c.DeleteObjects(&awss3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &awss3.Delete{Objects: []*awss3.ObjectIdentifier{
+			{Key: aws.String("copy.txt")},
+			{Key: aws.String("dir/file.txt")},
+			{Key: aws.String("missing")},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted.Deleted, 3)
+	assert.Len(t, deleted.Errors, 0)
+
+	list, err := c.ListObjectsV2(&awss3.ListObjectsV2Input{Bucket: aws.String("bucket"), Delimiter: aws.String("/")})
+	require.NoError(t, err)
+	assert.Len(t, list.Contents, 0)
+	require.Len(t, list.CommonPrefixes, 1)
+	assert.Equal(t, "empty/", *list.CommonPrefixes[0].Prefix)
+}
+
+func TestListObjects(t *testing.T) {
+	s, _, cleanup := startServer(t, nil)
+	defer cleanup()
+	c := newClient(t, s, testAccessKey, testSecretKey)
+	_, err := c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	for _, key := range []string{"a.txt", "b/c.txt", "b/d/e.txt", "b/f.txt", "g h.txt"} {
+		put(t, c, "bucket", key, key)
+	}
+
+	keys := func(contents []*awss3.Object) (keys []string) {
+		for _, o := range contents {
+			keys = append(keys, *o.Key)
+		}
+		return keys
+	}
+	prefixes := func(commonPrefixes []*awss3.CommonPrefix) (prefixes []string) {
+		for _, p := range commonPrefixes {
+			prefixes = append(prefixes, *p.Prefix)
+		}
+		return prefixes
+	}
+
+	// Recursive
+	list, err := c.ListObjectsV2(&awss3.ListObjectsV2Input{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b/c.txt", "b/d/e.txt", "b/f.txt", "g h.txt"}, keys(list.Contents))
+	assert.Equal(t, int64(5), *list.KeyCount)
+	assert.False(t, *list.IsTruncated)
+
+	// With delimiter and prefix
+	list, err = c.ListObjectsV2(&awss3.ListObjectsV2Input{
+		Bucket:    aws.String("bucket"),
+		Prefix:    aws.String("b/"),
+		Delimiter: aws.String("/"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/c.txt", "b/f.txt"}, keys(list.Contents))
+	assert.Equal(t, []string{"b/d/"}, prefixes(list.CommonPrefixes))
+
+	// With a delimiter which isn't "/"
+	list, err = c.ListObjectsV2(&awss3.ListObjectsV2Input{
+		Bucket:    aws.String("bucket"),
+		Delimiter: aws.String("."),
+	})
+	require.NoError(t, err)
+	assert.Nil(t, keys(list.Contents))
+	assert.Equal(t, []string{"a.", "b/c.", "b/d/e.", "b/f.", "g h."}, prefixes(list.CommonPrefixes))
+
+	// Paging with continuation tokens
+	var got []string
+	var token *string
+	for {
+		list, err = c.ListObjectsV2(&awss3.ListObjectsV2Input{
+			Bucket:            aws.String("bucket"),
+			MaxKeys:           aws.Int64(2),
+			ContinuationToken: token,
+		})
+		require.NoError(t, err)
+		got = append(got, keys(list.Contents)...)
+		if !*list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	assert.Equal(t, []string{"a.txt", "b/c.txt", "b/d/e.txt", "b/f.txt", "g h.txt"}, got)
+
+	// Paging with markers using V1
+	got = nil
+	var marker *string
+	for {
+		list, err := c.ListObjects(&awss3.ListObjectsInput{
+			Bucket:       aws.String("bucket"),
+			MaxKeys:      aws.Int64(3),
+			Marker:       marker,
+			EncodingType: aws.String("url"),
+		})
+		require.NoError(t, err)
+		got = append(got, keys(list.Contents)...)
+		if !*list.IsTruncated {
+			break
+		}
+		// The marker is URL encoded too
+		decoded, err := url.QueryUnescape(*list.NextMarker)
+		require.NoError(t, err)
+		marker = &decoded
+	}
+	assert.Equal(t, []string{"a.txt", "b/c.txt", "b/d/e.txt", "b/f.txt", "g+h.txt"}, got)
+
+	_, err = c.ListObjectsV2(&awss3.ListObjectsV2Input{Bucket: aws.String("missing")})
+	assertCode(t, "NoSuchBucket", err)
+}
+
+func TestMultipart(t *testing.T) {
+	s, _, cleanup := startServer(t, []string{testAccessKey + "," + testSecretKey})
+	defer cleanup()
+	c := newClient(t, s, testAccessKey, testSecretKey)
+	_, err := c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	create := func() *string {
+		upload, err := c.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("big.bin"),
+		})
+		require.NoError(t, err)
+		return upload.UploadId
+	}
+	uploadPart := func(id *string, n int64, data string) *awss3.CompletedPart {
+		part, err := c.UploadPart(&awss3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("big.bin"),
+			UploadId:   id,
+			PartNumber: aws.Int64(n),
+			Body:       strings.NewReader(data),
+		})
+		require.NoError(t, err)
+		return &awss3.CompletedPart{ETag: part.ETag, PartNumber: aws.Int64(n)}
+	}
+
+	// Abort an upload
+	id := create()
+	uploadPart(id, 1, "aborted")
+	_, err = c.AbortMultipartUpload(&awss3.AbortMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("big.bin"),
+		UploadId: id,
+	})
+	require.NoError(t, err)
+	_, err = c.ListParts(&awss3.ListPartsInput{Bucket: aws.String("bucket"), Key: aws.String("big.bin"), UploadId: id})
+	assertCode(t, "NoSuchUpload", err)
+
+	// Upload the parts out of order, one of them twice
+	id = create()
+	part2 := uploadPart(id, 2, "world")
+	uploadPart(id, 1, "goodbye ")
+	part1 := uploadPart(id, 1, "hello ")
+
+	uploads, err := c.ListMultipartUploads(&awss3.ListMultipartUploadsInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	require.Len(t, uploads.Uploads, 1)
+	assert.Equal(t, *id, *uploads.Uploads[0].UploadId)
+
+	parts, err := c.ListParts(&awss3.ListPartsInput{Bucket: aws.String("bucket"), Key: aws.String("big.bin"), UploadId: id})
+	require.NoError(t, err)
+	require.Len(t, parts.Parts, 2)
+	assert.Equal(t, int64(1), *parts.Parts[0].PartNumber)
+	assert.Equal(t, int64(6), *parts.Parts[0].Size)
+	assert.Equal(t, int64(2), *parts.Parts[1].PartNumber)
+
+	// Bad completions leave the upload in place
+	_, err = c.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("big.bin"),
+		UploadId:        id,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: []*awss3.CompletedPart{part2, part1}},
+	})
+	assertCode(t, "InvalidPartOrder", err)
+	_, err = c.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("big.bin"),
+		UploadId: id,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: []*awss3.CompletedPart{
+			part1,
+			{ETag: aws.String(`"00000000000000000000000000000000"`), PartNumber: aws.Int64(2)},
+		}},
+	})
+	assertCode(t, "InvalidPart", err)
+
+	complete, err := c.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("big.bin"),
+		UploadId:        id,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: []*awss3.CompletedPart{part1, part2}},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(*complete.ETag, `-2"`))
+	assert.Equal(t, "hello world", get(t, c, "bucket", "big.bin"))
+
+	uploads, err = c.ListMultipartUploads(&awss3.ListMultipartUploadsInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	assert.Len(t, uploads.Uploads, 0)
+}
+
+func TestAuth(t *testing.T) {
+	s, _, cleanup := startServer(t, []string{testAccessKey + "," + testSecretKey})
+	defer cleanup()
+
+	_, err := newClient(t, s, testAccessKey, "wrong").ListBuckets(&awss3.ListBucketsInput{})
+	assertCode(t, "SignatureDoesNotMatch", err)
+
+	_, err = newClient(t, s, "unknown", testSecretKey).ListBuckets(&awss3.ListBucketsInput{})
+	assertCode(t, "InvalidAccessKeyId", err)
+
+	resp, err := http.Get(s.URL())
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, err = NewServer(nil, &Options{AuthKeys: []string{"nocomma"}}, &httplib.DefaultOpt, nil)
+	assert.Error(t, err)
+}
+
+func TestUserDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-s3-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	htpasswd := filepath.Join(dir, "htpasswd")
+	require.NoError(t, ioutil.WriteFile(htpasswd, []byte("alice:{PLAIN}alice-secret\nreader:{PLAIN}reader-secret\nhashed:{SHA}x\n"), 0600))
+	aliceRoot := filepath.Join(dir, "alice")
+	users := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte(`alice "root=`+aliceRoot+`"`+"\n"+`reader "root=`+aliceRoot+`" read-only`+"\n"), 0600))
+	f, err := fs.NewFs(context.Background(), filepath.Join(dir, "main"))
+	require.NoError(t, err)
+
+	authOpt := auth.DefaultOpt
+	authOpt.Htpasswd = htpasswd
+	authOpt.Users = users
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	s, err := NewServer(f, &Options{}, &opt, &authOpt)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+		s.CleanUp()
+	}()
+
+	// alice's buckets are made in her root
+	alice := newClient(t, s, "alice", "alice-secret")
+	_, err = alice.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	put(t, alice, "bucket", "file.txt", "hello")
+	data, err := ioutil.ReadFile(filepath.Join(aliceRoot, "bucket", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	upload, err := alice.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("big.bin"),
+	})
+	require.NoError(t, err)
+
+	// the read only user can read them but not change them or see
+	// alice's uploads
+	reader := newClient(t, s, "reader", "reader-secret")
+	assert.Equal(t, "hello", get(t, reader, "bucket", "file.txt"))
+	_, err = reader.PutObject(&awss3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("new.txt"),
+		Body:   strings.NewReader("potato"),
+	})
+	assertCode(t, "AccessDenied", err)
+	uploads, err := reader.ListMultipartUploads(&awss3.ListMultipartUploadsInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	assert.Len(t, uploads.Uploads, 0)
+	_, err = reader.ListParts(&awss3.ListPartsInput{Bucket: aws.String("bucket"), Key: aws.String("big.bin"), UploadId: upload.UploadId})
+	assertCode(t, "NoSuchUpload", err)
+
+	// users need the right secret and a {PLAIN} entry
+	_, err = newClient(t, s, "alice", "wrong").ListBuckets(&awss3.ListBucketsInput{})
+	assertCode(t, "SignatureDoesNotMatch", err)
+	_, err = newClient(t, s, "hashed", "x").ListBuckets(&awss3.ListBucketsInput{})
+	assertCode(t, "InvalidAccessKeyId", err)
+
+	// LDAP can't provide the secrets
+	authOpt.LDAPURL = "ldap://localhost"
+	_, err = NewServer(f, &Options{}, &opt, &authOpt)
+	assert.Error(t, err)
+}
+
+func TestPresigned(t *testing.T) {
+	s, _, cleanup := startServer(t, []string{testAccessKey + "," + testSecretKey})
+	defer cleanup()
+	c := newClient(t, s, testAccessKey, testSecretKey)
+	_, err := c.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	// Upload with a presigned PUT
+	req, _ := c.PutObjectRequest(&awss3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file with spaces.txt"),
+	})
+	putURL, err := req.Presign(time.Minute)
+	require.NoError(t, err)
+	putReq, err := http.NewRequest("PUT", putURL, bytes.NewBufferString("presigned"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(putReq)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Download with a presigned GET
+	req, _ = c.GetObjectRequest(&awss3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file with spaces.txt"),
+	})
+	getURL, err := req.Presign(time.Minute)
+	require.NoError(t, err)
+	resp, err = http.Get(getURL)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "presigned", string(data))
+
+	// Tampering with the URL breaks the signature
+	resp, err = http.Get(strings.Replace(getURL, "spaces", "SPACES", 1))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Expired URLs are refused
+	r := httptest.NewRequest("GET", getURL, nil)
+	query := r.URL.Query()
+	query.Set("X-Amz-Date", time.Now().Add(-time.Hour).UTC().Format(amzDateFormat))
+	r.URL.RawQuery = query.Encode()
+	_, err = parsePresigned(r)
+	assert.Equal(t, errExpiredRequest, err)
+}
+
+// Check the signature of the example GET request in the AWS
+// signature version 4 documentation
+func TestSignatureExample(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://examplebucket.s3.amazonaws.com/test.txt", nil)
+	r.Header.Set("Range", "bytes=0-9")
+	r.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	r.Header.Set("X-Amz-Date", "20130524T000000Z")
+	sig := &signature{
+		amzDate:       "20130524T000000Z",
+		scope:         "20130524/us-east-1/s3/aws4_request",
+		signedHeaders: []string{"host", "range", "x-amz-content-sha256", "x-amz-date"},
+		payloadHash:   emptySHA256,
+	}
+	key := signingKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", sig.scope)
+	got := hex.EncodeToString(hmacSHA256(key, stringToSign(sig.amzDate, sig.scope, canonicalRequest(r, sig))))
+	assert.Equal(t, "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41", got)
+}
+
+// Decode the example chunked upload in the AWS signature version 4
+// documentation
+func TestChunkedReaderExample(t *testing.T) {
+	signer := func() *chunkSigner {
+		return &chunkSigner{
+			key:     signingKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "20130524/us-east-1/s3/aws4_request"),
+			amzDate: "20130524T000000Z",
+			scope:   "20130524/us-east-1/s3/aws4_request",
+			prev:    "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9",
+		}
+	}
+	chunk1 := strings.Repeat("a", 65536)
+	chunk2 := strings.Repeat("a", 1024)
+	body := "10000;chunk-signature=ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648\r\n" + chunk1 + "\r\n" +
+		"400;chunk-signature=0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497\r\n" + chunk2 + "\r\n" +
+		"0;chunk-signature=b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9\r\n\r\n"
+
+	data, err := ioutil.ReadAll(newChunkedReader(ioutil.NopCloser(strings.NewReader(body)), signer()))
+	require.NoError(t, err)
+	assert.Equal(t, chunk1+chunk2, string(data))
+
+	// Without checking the signatures
+	data, err = ioutil.ReadAll(newChunkedReader(ioutil.NopCloser(strings.NewReader(body)), nil))
+	require.NoError(t, err)
+	assert.Equal(t, chunk1+chunk2, string(data))
+
+	// With a changed chunk
+	bad := strings.Replace(body, "aaaa\r\n400", "aaab\r\n400", 1)
+	_, err = ioutil.ReadAll(newChunkedReader(ioutil.NopCloser(strings.NewReader(bad)), signer()))
+	assert.Equal(t, errSignatureDoesNotMatch, err)
+
+	// Truncated
+	_, err = ioutil.ReadAll(newChunkedReader(ioutil.NopCloser(strings.NewReader(body[:1000])), signer()))
+	assert.Error(t, err)
+}
+
+func TestRemotePath(t *testing.T) {
+	for _, test := range []struct {
+		bucket, key string
+		want        string
+		wantErr     bool
+	}{
+		{"bucket", "key", "bucket/key", false},
+		{"bucket", "dir/key", "bucket/dir/key", false},
+		{"bucket", "dir/", "bucket/dir", false},
+		{"bucket", "", "bucket", false},
+		{"", "key", "", true},
+		{"..", "key", "", true},
+		{"bucket", "../key", "", true},
+		{"bucket", "dir/./key", "", true},
+		{"bucket", "dir//key", "", true},
+	} {
+		got, err := remotePath(test.bucket, test.key)
+		if test.wantErr {
+			assert.Error(t, err, test)
+		} else {
+			assert.NoError(t, err, test)
+			assert.Equal(t, test.want, got, test)
+		}
+	}
+}
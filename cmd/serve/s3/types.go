@@ -0,0 +1,287 @@
+// The S3 XML requests and responses
+
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+)
+
+// timeFormat is the format of the times in the XML responses
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+// s3Time is a time which marshals in the S3 format
+type s3Time time.Time
+
+// MarshalXML turns a s3Time into XML
+func (t s3Time) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(time.Time(t).UTC().Format(timeFormat), start)
+}
+
+// apiError is an error returned to the client in the S3 XML format
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+// Error satisfies the error interface
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// The errors returned to the client
+var (
+	errAccessDenied           = &apiError{"AccessDenied", "Access Denied.", http.StatusForbidden}
+	errAuthorizationMalformed = &apiError{"AuthorizationHeaderMalformed", "The authorization header is malformed.", http.StatusBadRequest}
+	errBadDigest              = &apiError{"BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest}
+	errBucketAlreadyExists    = &apiError{"BucketAlreadyOwnedByYou", "The bucket you tried to create already exists.", http.StatusConflict}
+	errBucketNotEmpty         = &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty.", http.StatusConflict}
+	errContentSHA256Mismatch  = &apiError{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errExpiredRequest         = &apiError{"AccessDenied", "Request has expired.", http.StatusForbidden}
+	errInternal               = &apiError{"InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError}
+	errInvalidAccessKeyID     = &apiError{"InvalidAccessKeyId", "The access key ID you provided does not exist in our records.", http.StatusForbidden}
+	errInvalidArgument        = &apiError{"InvalidArgument", "Invalid argument.", http.StatusBadRequest}
+	errInvalidBucketName      = &apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errInvalidPart            = &apiError{"InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errInvalidPartOrder       = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order.", http.StatusBadRequest}
+	errMalformedXML           = &apiError{"MalformedXML", "The XML you provided was not well-formed.", http.StatusBadRequest}
+	errMissingContentLength   = &apiError{"MissingContentLength", "You must provide the Content-Length HTTP header.", http.StatusLengthRequired}
+	errNoSuchBucket           = &apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound}
+	errNoSuchKey              = &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload           = &apiError{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errNotImplemented         = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errSignatureDoesNotMatch  = &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errTimeTooSkewed          = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.", http.StatusForbidden}
+)
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+// toAPIError converts err into the S3 error to return to the client
+func toAPIError(r *http.Request, err error) *apiError {
+	_, cause := fserrors.Cause(err)
+	if apiErr, ok := cause.(*apiError); ok {
+		return apiErr
+	}
+	switch cause {
+	case fs.ErrorObjectNotFound, fs.ErrorNotAFile:
+		return errNoSuchKey
+	case fs.ErrorDirNotFound:
+		return errNoSuchBucket
+	}
+	fs.Errorf(r.URL.Path, "%s request failed: %v", r.Method, err)
+	return &apiError{Code: errInternal.Code, Message: err.Error(), Status: errInternal.Status}
+}
+
+// writeError writes err to the client as an S3 error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(r, err)
+	fs.Debugf(r.URL.Path, "%s request error: %v", r.Method, apiErr)
+	if r.Method == "HEAD" {
+		w.WriteHeader(apiErr.Status)
+		return
+	}
+	writeXML(w, apiErr.Status, errorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+	})
+}
+
+// writeXML writes v to the client as XML with the status given
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		fs.Errorf(nil, "Failed to marshal XML response: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, err = w.Write(append([]byte(xml.Header), data...))
+	if err != nil {
+		fs.Debugf(nil, "Failed to write XML response: %v", err)
+	}
+}
+
+// readXML reads the XML request body into v
+func readXML(r *http.Request, v interface{}) error {
+	err := xml.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+// owner is the owner of the buckets and objects
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+// the owner of everything
+var rcloneOwner = owner{ID: "rclone", DisplayName: "rclone"}
+
+// bucketInfo is a bucket in listBucketsResult
+type bucketInfo struct {
+	Name         string
+	CreationDate s3Time
+}
+
+// listBucketsResult is the response to ListBuckets
+type listBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+// locationResult is the response to GetBucketLocation
+type locationResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+// objectInfo is an object in listObjectsResult
+type objectInfo struct {
+	Key          string
+	LastModified s3Time
+	ETag         string
+	Size         int64
+	StorageClass string
+	Owner        *owner `xml:",omitempty"`
+}
+
+// commonPrefix is a prefix in listObjectsResult
+type commonPrefix struct {
+	Prefix string
+}
+
+// listObjectsResult is the response to ListObjects and ListObjectsV2
+type listObjectsResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	Marker                *string `xml:",omitempty"`
+	NextMarker            string  `xml:",omitempty"`
+	KeyCount              *int    `xml:",omitempty"`
+	ContinuationToken     string  `xml:",omitempty"`
+	NextContinuationToken string  `xml:",omitempty"`
+	StartAfter            string  `xml:",omitempty"`
+	Contents              []objectInfo
+	CommonPrefixes        []commonPrefix
+}
+
+// deleteRequest is the request body of DeleteObjects
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+// deletedObject is a deleted object in deleteResult
+type deletedObject struct {
+	Key string
+}
+
+// deleteError is an object which couldn't be deleted in deleteResult
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+// deleteResult is the response to DeleteObjects
+type deleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+// copyObjectResult is the response to CopyObject
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	ETag         string
+	LastModified s3Time
+}
+
+// initiateMultipartUploadResult is the response to CreateMultipartUpload
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+// completeMultipartUploadRequest is the request body of CompleteMultipartUpload
+type completeMultipartUploadRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+// completeMultipartUploadResult is the response to CompleteMultipartUpload
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// partInfo is a part in listPartsResult
+type partInfo struct {
+	PartNumber   int
+	LastModified s3Time
+	ETag         string
+	Size         int64
+}
+
+// listPartsResult is the response to ListParts
+type listPartsResult struct {
+	XMLName              xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
+	Bucket               string
+	Key                  string
+	UploadID             string `xml:"UploadId"`
+	StorageClass         string
+	PartNumberMarker     int
+	NextPartNumberMarker int
+	MaxParts             int
+	IsTruncated          bool
+	Parts                []partInfo `xml:"Part"`
+}
+
+// uploadInfo is an upload in listMultipartUploadsResult
+type uploadInfo struct {
+	Key          string
+	UploadID     string `xml:"UploadId"`
+	Initiated    s3Time
+	StorageClass string
+}
+
+// listMultipartUploadsResult is the response to ListMultipartUploads
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
+	Bucket      string
+	Prefix      string
+	MaxUploads  int
+	IsTruncated bool
+	Uploads     []uploadInfo `xml:"Upload"`
+}
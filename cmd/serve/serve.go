@@ -7,8 +7,11 @@ import (
 	"github.com/rclone/rclone/cmd/serve/dlna"
 	"github.com/rclone/rclone/cmd/serve/ftp"
 	"github.com/rclone/rclone/cmd/serve/http"
+	"github.com/rclone/rclone/cmd/serve/nfs"
 	"github.com/rclone/rclone/cmd/serve/restic"
+	"github.com/rclone/rclone/cmd/serve/s3"
 	"github.com/rclone/rclone/cmd/serve/sftp"
+	"github.com/rclone/rclone/cmd/serve/smb"
 	"github.com/rclone/rclone/cmd/serve/webdav"
 	"github.com/spf13/cobra"
 )
@@ -30,6 +33,15 @@ func init() {
 	if sftp.Command != nil {
 		Command.AddCommand(sftp.Command)
 	}
+	if nfs.Command != nil {
+		Command.AddCommand(nfs.Command)
+	}
+	if s3.Command != nil {
+		Command.AddCommand(s3.Command)
+	}
+	if smb.Command != nil {
+		Command.AddCommand(smb.Command)
+	}
 	cmd.Root.AddCommand(Command)
 }
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/pkg/sftp"
+	"github.com/rclone/rclone/cmd/serve/auth"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/vfs"
@@ -47,20 +48,21 @@ func shellUnEscape(str string) string {
 // Info about the current connection
 type conn struct {
 	vfs      *vfs.VFS
+	user     *auth.User // user from the user database, or nil
 	handlers sftp.Handlers
 	what     string
 }
 
 // execCommand implements an extremely limited number of commands to
 // interoperate with the rclone sftp backend
-func (c *conn) execCommand(ctx context.Context, out io.Writer, command string) (err error) {
-	binary, args := command, ""
+func (c *conn) execCommand(ctx context.Context, in io.Reader, out io.Writer, command string) (err error) {
+	binary, rawArgs := command, ""
 	space := strings.Index(command, " ")
 	if space >= 0 {
 		binary = command[:space]
-		args = strings.TrimLeft(command[space+1:], " ")
+		rawArgs = strings.TrimLeft(command[space+1:], " ")
 	}
-	args = shellUnEscape(args)
+	args := shellUnEscape(rawArgs)
 	fs.Debugf(c.what, "exec command: binary = %q, args = %q", binary, args)
 	switch binary {
 	case "df":
@@ -127,9 +129,18 @@ func (c *conn) execCommand(ctx context.Context, out io.Writer, command string) (
 		if err != nil {
 			return errors.Wrap(err, "send output failed")
 		}
+	case "gzip", "zstd":
+		return c.transferCommand(binary, rawArgs, in, out)
+	case "scp":
+		return c.scp(in, out, rawArgs)
 	case "echo":
 		// special cases for rclone command detection
 		switch args {
+		case "'rclone' | gzip -c | gzip -d -c", "'rclone' | zstd -q -c | zstd -q -d -c":
+			_, err = fmt.Fprintf(out, "rclone\n")
+			if err != nil {
+				return errors.Wrap(err, "send output failed")
+			}
 		case "'abc' | md5sum":
 			if c.vfs.Fs().Hashes().Contains(hash.MD5) {
 				_, err = fmt.Fprintf(out, "0bee89b07a248e27c83fc3d5951213c1  -\n")
@@ -183,6 +194,7 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 		}
 	}()
 	fs.Debugf(c.what, "Channel accepted\n")
+	rw := c.limit(channel)
 
 	isSFTP := make(chan bool, 1)
 	var command execCommand
@@ -226,7 +238,7 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 	// Wait for either subsystem "sftp" or "exec" request
 	if <-isSFTP {
 		fs.Debugf(c.what, "Starting SFTP server")
-		server := sftp.NewRequestServer(channel, c.handlers)
+		server := sftp.NewRequestServer(rw, c.handlers)
 		defer func() {
 			err := server.Close()
 			if err != nil && err != io.EOF {
@@ -241,7 +253,7 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 		}
 	} else {
 		var rc = uint32(0)
-		err := c.execCommand(context.TODO(), channel, command.Command)
+		err := c.execCommand(context.TODO(), rw, rw, command.Command)
 		if err != nil {
 			rc = 1
 			_, errPrint := fmt.Fprintf(channel.Stderr(), "%v\n", err)
@@ -257,6 +269,19 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 	}
 }
 
+// limit returns channel limited to the user's bandwidth limit
+func (c *conn) limit(channel ssh.Channel) io.ReadWriteCloser {
+	if c.user == nil {
+		return channel
+	}
+	ctx := context.Background()
+	return struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{c.user.LimitReader(ctx, channel), c.user.LimitWriter(ctx, channel), channel}
+}
+
 // Service the incoming Channel channel in go routine
 func (c *conn) handleChannels(chans <-chan ssh.NewChannel) {
 	for newChannel := range chans {
@@ -0,0 +1,524 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	scpMaxLine = 64 * 1024 // longest control message accepted
+	scpOK      = 0         // response for success
+	scpWarning = 1         // response for an error which doesn't stop the transfer
+	scpFatal   = 2         // response for an error which does
+)
+
+// shellSplit splits a command line into words in the same way as a
+// POSIX shell, understanding single and double quotes and backslash
+// escapes but nothing else.
+func shellSplit(command string) (words []string, err error) {
+	var (
+		word    strings.Builder
+		inWord  bool
+		quote   byte
+		escaped bool
+	)
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' && c != '$' && c != '`' && c != '\n' {
+				word.WriteByte('\\')
+			}
+			if c != '\n' {
+				word.WriteByte(c)
+			}
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// scpPath converts a path given to scp into a VFS path
+func scpPath(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	return name
+}
+
+// scpSession is a single run of the scp protocol
+type scpSession struct {
+	c         *conn
+	in        *bufio.Reader
+	out       io.Writer
+	recursive bool // -r: copy directories
+	preserve  bool // -p: preserve modification times
+	targetDir bool // -d: the target must be a directory
+	failed    bool // set if any errors were reported
+}
+
+// scp runs the server side of the legacy scp protocol for the "scp"
+// command with args.
+//
+// "scp -t" receives files into the target and "scp -f" sends the
+// files named. Both modes are driven by the client so this works with
+// the scp clients which don't use the SFTP subsystem.
+func (c *conn) scp(in io.Reader, out io.Writer, args string) error {
+	words, err := shellSplit(args)
+	if err != nil {
+		return errors.Wrap(err, "scp: bad arguments")
+	}
+	s := &scpSession{
+		c:   c,
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+	var sink, source bool
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		word := words[0]
+		words = words[1:]
+		if word == "--" {
+			break
+		}
+		for _, flag := range word[1:] {
+			switch flag {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				s.recursive = true
+			case 'p':
+				s.preserve = true
+			case 'd':
+				s.targetDir = true
+			case 'v', 'q':
+			default:
+				return errors.Errorf("scp: unsupported option -%c", flag)
+			}
+		}
+	}
+	switch {
+	case sink == source:
+		return errors.New("scp: exactly one of -t and -f must be used")
+	case len(words) == 0:
+		return errors.New("scp: no files given")
+	case sink && len(words) > 1:
+		return errors.New("scp: only one target allowed")
+	case sink:
+		err = s.sink(scpPath(words[0]))
+	default:
+		err = s.source(words)
+	}
+	if err != nil {
+		return err
+	}
+	if s.failed {
+		return errors.New("scp: some files could not be transferred")
+	}
+	return nil
+}
+
+// ack sends the success response
+func (s *scpSession) ack() error {
+	_, err := s.out.Write([]byte{scpOK})
+	return err
+}
+
+// warn sends an error which doesn't stop the transfer
+func (s *scpSession) warn(err error) error {
+	fs.Debugf(s.c.what, "scp: %v", err)
+	s.failed = true
+	msg := strings.Replace(err.Error(), "\n", " ", -1)
+	_, err = fmt.Fprintf(s.out, "%cscp: %s\n", scpWarning, msg)
+	return err
+}
+
+// readLine reads a control message without its newline
+func (s *scpSession) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := s.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return string(line), nil
+		}
+		if len(line) >= scpMaxLine {
+			return "", errors.New("scp: control message too long")
+		}
+		line = append(line, b)
+	}
+}
+
+// readResponse reads the response to something sent to the client
+func (s *scpSession) readResponse() error {
+	b, err := s.in.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == scpOK {
+		return nil
+	}
+	msg, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if b == scpWarning {
+		fs.Debugf(s.c.what, "scp: client reported: %s", msg)
+		s.failed = true
+		return nil
+	}
+	return errors.Errorf("scp: client failed: %s", msg)
+}
+
+// checkName checks the name of a file the client is sending is a
+// single path element
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return errors.Errorf("bad file name %q", name)
+	}
+	return nil
+}
+
+// parseTimes parses the body of a T message
+func parseTimes(line string) (mtime, atime time.Time, err error) {
+	var mSec, mUsec, aSec, aUsec int64
+	_, err = fmt.Sscanf(line, "%d %d %d %d", &mSec, &mUsec, &aSec, &aUsec)
+	if err != nil {
+		return mtime, atime, errors.Wrapf(err, "bad time message %q", line)
+	}
+	return time.Unix(mSec, mUsec*1000), time.Unix(aSec, aUsec*1000), nil
+}
+
+// parseEntry parses the body of a C or D message
+func parseEntry(line string) (mode os.FileMode, size int64, name string, err error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.Errorf("bad message %q", line)
+	}
+	perm, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", errors.Wrapf(err, "bad mode in %q", line)
+	}
+	size, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", errors.Errorf("bad size in %q", line)
+	}
+	name = parts[2]
+	err = checkName(name)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return os.FileMode(perm) & os.ModePerm, size, name, nil
+}
+
+// sink receives files from the client into target
+func (s *scpSession) sink(target string) (err error) {
+	VFS := s.c.vfs
+	isDir := false
+	if node, err := VFS.Stat(target); err == nil && node.IsDir() {
+		isDir = true
+	}
+	if s.targetDir && !isDir {
+		return errors.Errorf("scp: %q is not a directory", target)
+	}
+	var (
+		dirs         []string // the directories being received into
+		mtime, atime time.Time
+		haveTimes    bool
+	)
+	// destination returns the path to store name in
+	destination := func(name string) string {
+		if len(dirs) > 0 {
+			return path.Join(dirs[len(dirs)-1], name)
+		}
+		if isDir {
+			return path.Join(target, name)
+		}
+		return target
+	}
+	err = s.ack()
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := s.readLine()
+		if err == io.EOF {
+			if len(dirs) != 0 {
+				return errors.New("scp: unexpected end of input")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if line == "" {
+			return errors.New("scp: empty control message")
+		}
+		kind, body := line[0], line[1:]
+		switch kind {
+		case scpWarning, scpFatal:
+			fs.Debugf(s.c.what, "scp: client reported: %s", body)
+			s.failed = true
+			if kind == scpFatal {
+				return nil
+			}
+			continue
+		case 'T':
+			mtime, atime, err = parseTimes(body)
+			if err != nil {
+				return err
+			}
+			haveTimes = true
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("scp: unexpected end of directory")
+			}
+			dirs = dirs[:len(dirs)-1]
+		case 'D':
+			_, _, name, err := parseEntry(body)
+			if err != nil {
+				return err
+			}
+			if !s.recursive {
+				return errors.New("scp: received directory without -r")
+			}
+			dir := destination(name)
+			if node, err := VFS.Stat(dir); err != nil || !node.IsDir() {
+				err = VFS.Mkdir(dir, 0777)
+				if err != nil {
+					return errors.Wrapf(err, "scp: failed to make directory %q", dir)
+				}
+			}
+			if haveTimes {
+				s.setTimes(dir, atime, mtime)
+				haveTimes = false
+			}
+			dirs = append(dirs, dir)
+		case 'C':
+			_, size, name, err := parseEntry(body)
+			if err != nil {
+				return err
+			}
+			err = s.ack()
+			if err != nil {
+				return err
+			}
+			remote := destination(name)
+			fs.Debugf(s.c.what, "scp: receiving %q (%d bytes)", remote, size)
+			writeErr := s.receiveFile(remote, size)
+			if err = s.readResponse(); err != nil {
+				return err
+			}
+			if writeErr == nil && haveTimes {
+				s.setTimes(remote, atime, mtime)
+			}
+			haveTimes = false
+			if writeErr != nil {
+				err = s.warn(writeErr)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		default:
+			return errors.Errorf("scp: unknown control message %q", line)
+		}
+		err = s.ack()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile writes size bytes from the client into remote.
+//
+// If the file can't be written the data is read and discarded so the
+// transfer can carry on.
+func (s *scpSession) receiveFile(remote string, size int64) (err error) {
+	file, err := s.c.vfs.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		_, discardErr := io.CopyN(ioutil.Discard, s.in, size)
+		if discardErr != nil {
+			return discardErr
+		}
+		return errors.Wrapf(err, "failed to create %q", remote)
+	}
+	n, err := io.CopyN(file, s.in, size)
+	if err != nil {
+		_ = file.Close()
+		if n < size {
+			_, _ = io.CopyN(ioutil.Discard, s.in, size-n)
+		}
+		return errors.Wrapf(err, "failed to write %q", remote)
+	}
+	err = file.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %q", remote)
+	}
+	return nil
+}
+
+// setTimes sets the modification time of remote from a T message
+func (s *scpSession) setTimes(remote string, atime, mtime time.Time) {
+	err := s.c.vfs.Chtimes(remote, atime, mtime)
+	if err != nil {
+		fs.Debugf(s.c.what, "scp: failed to set modification time of %q: %v", remote, err)
+	}
+}
+
+// source sends the files named to the client
+func (s *scpSession) source(names []string) error {
+	err := s.readResponse()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = s.send(scpPath(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the file or directory remote to the client
+func (s *scpSession) send(remote string) (err error) {
+	node, err := s.c.vfs.Stat(remote)
+	if err != nil {
+		return s.warn(errors.Wrapf(err, "failed to find %q", remote))
+	}
+	name := path.Base(remote)
+	if remote == "" {
+		name = "."
+	}
+	if s.preserve {
+		modTime := node.ModTime()
+		_, err = fmt.Fprintf(s.out, "T%d %d %d %d\n", modTime.Unix(), modTime.Nanosecond()/1000, modTime.Unix(), modTime.Nanosecond()/1000)
+		if err != nil {
+			return err
+		}
+		err = s.readResponse()
+		if err != nil {
+			return err
+		}
+	}
+	if node.IsDir() {
+		if !s.recursive {
+			return s.warn(errors.Errorf("%q is a directory", remote))
+		}
+		entries, err := s.c.vfs.ReadDir(remote)
+		if err != nil {
+			return s.warn(errors.Wrapf(err, "failed to list %q", remote))
+		}
+		_, err = fmt.Fprintf(s.out, "D%04o 0 %s\n", node.Mode()&os.ModePerm, name)
+		if err != nil {
+			return err
+		}
+		err = s.readResponse()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = s.send(path.Join(remote, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(s.out, "E\n")
+		if err != nil {
+			return err
+		}
+		return s.readResponse()
+	}
+	file, err := s.c.vfs.OpenFile(remote, os.O_RDONLY, 0777)
+	if err != nil {
+		return s.warn(errors.Wrapf(err, "failed to open %q", remote))
+	}
+	defer fs.CheckClose(file, &err)
+	size := node.Size()
+	fs.Debugf(s.c.what, "scp: sending %q (%d bytes)", remote, size)
+	_, err = fmt.Fprintf(s.out, "C%04o %d %s\n", node.Mode()&os.ModePerm, size, name)
+	if err != nil {
+		return err
+	}
+	err = s.readResponse()
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyN(s.out, file, size)
+	if err != nil {
+		// The size has been promised so pad the file out
+		// then report the error
+		readErr := err
+		_, err = io.CopyN(s.out, zeroReader{}, size-n)
+		if err != nil {
+			return err
+		}
+		err = s.warn(errors.Wrapf(readErr, "failed to read %q", remote))
+		if err != nil {
+			return err
+		}
+		return s.readResponse()
+	}
+	err = s.ack()
+	if err != nil {
+		return err
+	}
+	return s.readResponse()
+}
+
+// zeroReader reads an endless stream of zeros
+type zeroReader struct{}
+
+// Read fills p with zeros
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
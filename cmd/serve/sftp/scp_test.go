@@ -0,0 +1,186 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellSplit(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"-t -- file", []string{"-t", "--", "file"}, false},
+		{"  -f   a  b ", []string{"-f", "a", "b"}, false},
+		{`'with space' "double $x" back\ slash`, []string{"with space", "double $x", "back slash"}, false},
+		{`'it'\''s'`, []string{"it's"}, false},
+		{`"a\"b\c"`, []string{`a"b\c`}, false},
+		{`''`, []string{""}, false},
+		{`'unterminated`, nil, true},
+		{`trailing\`, nil, true},
+	} {
+		got, err := shellSplit(test.in)
+		if test.err {
+			assert.Error(t, err, test.in)
+		} else {
+			require.NoError(t, err, test.in)
+			assert.Equal(t, test.want, got, test.in)
+		}
+	}
+}
+
+// runSCP runs the scp command with args on c while client talks to it
+// over pipes, returning the error from the command
+func runSCP(t *testing.T, c *conn, args string, client func(in *bufio.Reader, out io.Writer)) error {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	errChan := make(chan error, 1)
+	go func() {
+		err := c.scp(serverIn, serverOut, args)
+		_ = serverOut.Close()
+		_ = serverIn.Close()
+		errChan <- err
+	}()
+	client(bufio.NewReader(clientIn), clientOut)
+	_ = clientOut.Close()
+	_, _ = io.Copy(ioutil.Discard, clientIn)
+	return <-errChan
+}
+
+// expectAck reads a response from the server checking it is an ack
+func expectAck(t *testing.T, in *bufio.Reader) {
+	b, err := in.ReadByte()
+	require.NoError(t, err)
+	if b != 0 {
+		line, _ := in.ReadString('\n')
+		t.Fatalf("expecting ack but got %d %q", b, line)
+	}
+}
+
+func TestSCP(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-serve-sftp")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	c := &conn{vfs: vfs.New(f, nil), what: "test"}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	// Upload a file and a directory
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "up"), 0777))
+	err = runSCP(t, c, "-r -p -t -- up", func(in *bufio.Reader, out io.Writer) {
+		send := func(msg string) {
+			_, err := io.WriteString(out, msg)
+			require.NoError(t, err)
+			expectAck(t, in)
+		}
+		expectAck(t, in)
+		send(fmt.Sprintf("T%d 0 %d 0\n", mtime.Unix(), mtime.Unix()))
+		send("C0644 5 hello.txt\n")
+		send("hello\x00")
+		send("D0755 0 sub\n")
+		send("C0644 3 a.txt\n")
+		send("abc\x00")
+		send("E\n")
+	})
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "up", "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	fi, err := os.Stat(filepath.Join(dir, "up", "hello.txt"))
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(mtime), fi.ModTime())
+	data, err = ioutil.ReadFile(filepath.Join(dir, "up", "sub", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+
+	// Upload to a new file name
+	err = runSCP(t, c, "-t up/renamed.txt", func(in *bufio.Reader, out io.Writer) {
+		expectAck(t, in)
+		_, err := io.WriteString(out, "C0644 3 ignored.txt\n")
+		require.NoError(t, err)
+		expectAck(t, in)
+		_, err = io.WriteString(out, "new\x00")
+		require.NoError(t, err)
+		expectAck(t, in)
+	})
+	require.NoError(t, err)
+	data, err = ioutil.ReadFile(filepath.Join(dir, "up", "renamed.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	// Names which escape the target are refused
+	err = runSCP(t, c, "-t up", func(in *bufio.Reader, out io.Writer) {
+		expectAck(t, in)
+		_, _ = io.WriteString(out, "C0644 3 ../x\n")
+	})
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "x"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Directories need -r
+	err = runSCP(t, c, "-t up", func(in *bufio.Reader, out io.Writer) {
+		expectAck(t, in)
+		_, _ = io.WriteString(out, "D0755 0 sub2\n")
+	})
+	assert.Error(t, err)
+
+	// Download the directory
+	var got []string
+	err = runSCP(t, c, "-r -f up/sub missing", func(in *bufio.Reader, out io.Writer) {
+		_, err := out.Write([]byte{0})
+		require.NoError(t, err)
+		for {
+			kind, err := in.ReadByte()
+			if err == io.EOF {
+				return
+			}
+			require.NoError(t, err)
+			line, err := in.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			if kind == 1 {
+				got = append(got, "warning")
+				continue
+			}
+			got = append(got, string(kind)+line)
+			_, err = out.Write([]byte{0})
+			require.NoError(t, err)
+			if kind == 'C' {
+				size, err := strconv.Atoi(strings.Split(line, " ")[1])
+				require.NoError(t, err)
+				buf := make([]byte, size+1)
+				_, err = io.ReadFull(in, buf)
+				require.NoError(t, err)
+				assert.Equal(t, byte(0), buf[size])
+				got = append(got, string(buf[:size]))
+				_, err = out.Write([]byte{0})
+				require.NoError(t, err)
+			}
+		}
+	})
+	assert.Error(t, err) // because missing doesn't exist
+	assert.Equal(t, []string{"D0777 0 sub", "C0666 3 a.txt", "abc", "E", "warning"}, got)
+}
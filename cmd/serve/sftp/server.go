@@ -17,8 +17,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
@@ -39,6 +43,13 @@ type server struct {
 	listener net.Listener
 	waitChan chan struct{} // for waiting on the listener to close
 	proxy    *proxy.Proxy
+	authDB   *auth.DB        // user database, or nil
+	keysFs   fs.Fs           // where the --authorized-keys-dir files are, or nil
+	keys     *authorizedKeys // keys from --authorized-keys, or nil
+	caKeys   *authorizedKeys // keys from --trusted-user-ca-keys, or nil
+
+	mu      sync.Mutex
+	userVFS map[string]*vfs.VFS // VFS for each user with --user-root
 }
 
 func newServer(ctx context.Context, f fs.Fs, opt *Options) *server {
@@ -47,17 +58,80 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) *server {
 		ctx:      ctx,
 		opt:      *opt,
 		waitChan: make(chan struct{}),
+		userVFS:  make(map[string]*vfs.VFS),
 	}
 	if proxyflags.Opt.AuthProxy != "" {
 		s.proxy = proxy.New(ctx, &proxyflags.Opt)
-	} else {
+	} else if s.opt.UserRoot == "" && !authflags.Opt.Enabled() {
 		s.vfs = vfs.New(f, &vfsflags.Opt)
 	}
 	return s
 }
 
-// getVFS gets the vfs from s or the proxy
+// checkUserName checks the user name is safe to use as a file name
+func checkUserName(user string) error {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, `/\:`) || strings.IndexFunc(user, unicode.IsControl) >= 0 {
+		return errors.Errorf("invalid user name %q", user)
+	}
+	return nil
+}
+
+// expandUserRoot replaces %u in root with user and %% with %
+func expandUserRoot(root, user string) string {
+	var out strings.Builder
+	for i := 0; i < len(root); i++ {
+		if root[i] == '%' && i+1 < len(root) {
+			switch root[i+1] {
+			case 'u':
+				out.WriteString(user)
+				i++
+				continue
+			case '%':
+				out.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		out.WriteByte(root[i])
+	}
+	return out.String()
+}
+
+// getUserVFS gets the VFS for the --user-root of user, making it if
+// necessary
+func (s *server) getUserVFS(user string) (*vfs.VFS, error) {
+	err := checkUserName(user)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if VFS, ok := s.userVFS[user]; ok {
+		return VFS, nil
+	}
+	root := expandUserRoot(s.opt.UserRoot, user)
+	f, err := fs.NewFs(s.ctx, root)
+	if err == fs.ErrorIsFile {
+		return nil, errors.Errorf("user root %q is a file", root)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to make user root %q", root)
+	}
+	fs.Debugf(f, "Serving user %q", user)
+	VFS := vfs.New(f, &vfsflags.Opt)
+	s.userVFS[user] = VFS
+	return VFS, nil
+}
+
+// getVFS gets the vfs from s, the --user-root or the proxy
 func (s *server) getVFS(what string, sshConn *ssh.ServerConn) (VFS *vfs.VFS) {
+	if s.opt.UserRoot != "" {
+		VFS, err := s.getUserVFS(sshConn.User())
+		if err != nil {
+			fs.Errorf(what, "Failed to get VFS: %v", err)
+			return nil
+		}
+		return VFS
+	}
 	if s.proxy == nil {
 		return s.vfs
 	}
@@ -104,7 +178,16 @@ func (s *server) acceptConnections() {
 
 		c := &conn{
 			what: what,
-			vfs:  s.getVFS(what, sshConn),
+		}
+		if s.authDB != nil {
+			c.user, err = s.authDB.User(sshConn.User())
+			if err != nil {
+				fs.Errorf(what, "Failed to get user: %v", err)
+			} else {
+				c.vfs = c.user.VFS
+			}
+		} else {
+			c.vfs = s.getVFS(what, sshConn)
 		}
 		if c.vfs == nil {
 			fs.Infof(what, "Closing unauthenticated connection (couldn't find VFS)")
@@ -120,26 +203,52 @@ func (s *server) acceptConnections() {
 
 // Based on example server code from golang.org/x/crypto/ssh and server_standalone
 func (s *server) serve() (err error) {
-	var authorizedKeysMap map[string]struct{}
-
 	// ensure the user isn't trying to use conflicting flags
 	if proxyflags.Opt.AuthProxy != "" && s.opt.AuthorizedKeys != "" && s.opt.AuthorizedKeys != DefaultOpt.AuthorizedKeys {
 		return errors.New("--auth-proxy and --authorized-keys cannot be used at the same time")
 	}
+	if proxyflags.Opt.AuthProxy != "" && (s.opt.AuthorizedKeysDir != "" || s.opt.TrustedUserCAKeys != "" || s.opt.UserRoot != "") {
+		return errors.New("--auth-proxy can't be used with --authorized-keys-dir, --trusted-user-ca-keys or --user-root")
+	}
+	if authflags.Opt.Enabled() {
+		if proxyflags.Opt.AuthProxy != "" || s.opt.UserRoot != "" {
+			return errors.New("the user database can't be used with --auth-proxy or --user-root")
+		}
+		s.authDB, err = auth.New(s.ctx, s.f, &authflags.Opt)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Load the authorized keys
 	if s.opt.AuthorizedKeys != "" && proxyflags.Opt.AuthProxy == "" {
 		authKeysFile := env.ShellExpand(s.opt.AuthorizedKeys)
-		authorizedKeysMap, err = loadAuthorizedKeys(authKeysFile)
+		s.keys, err = loadAuthorizedKeys(authKeysFile)
 		// If user set the flag away from the default then report an error
 		if err != nil && s.opt.AuthorizedKeys != DefaultOpt.AuthorizedKeys {
 			return err
 		}
-		fs.Logf(nil, "Loaded %d authorized keys from %q", len(authorizedKeysMap), authKeysFile)
+		fs.Logf(nil, "Loaded %d authorized keys from %q", s.keys.len(), authKeysFile)
+	}
+	if s.opt.AuthorizedKeysDir != "" {
+		s.keysFs, err = fs.NewFs(s.ctx, env.ShellExpand(s.opt.AuthorizedKeysDir))
+		if err == fs.ErrorIsFile {
+			return errors.Errorf("--authorized-keys-dir %q must be a directory", s.opt.AuthorizedKeysDir)
+		} else if err != nil {
+			return errors.Wrap(err, "failed to open --authorized-keys-dir")
+		}
+	}
+	if s.opt.TrustedUserCAKeys != "" {
+		caKeysFile := env.ShellExpand(s.opt.TrustedUserCAKeys)
+		s.caKeys, err = loadAuthorizedKeys(caKeysFile)
+		if err != nil {
+			return err
+		}
+		fs.Logf(nil, "Loaded %d trusted user CA keys from %q", s.caKeys.len(), caKeysFile)
 	}
 
-	if !s.opt.NoAuth && len(authorizedKeysMap) == 0 && s.opt.User == "" && s.opt.Pass == "" && s.proxy == nil {
-		return errors.New("no authorization found, use --user/--pass or --authorized-keys or --no-auth or --auth-proxy")
+	if !s.opt.NoAuth && s.keys.len() == 0 && s.keysFs == nil && s.caKeys.len() == 0 && s.opt.User == "" && s.opt.Pass == "" && s.proxy == nil && s.authDB == nil {
+		return errors.New("no authorization found, use --user/--pass, --authorized-keys, --authorized-keys-dir, --trusted-user-ca-keys, --no-auth, --auth-proxy or the user database")
 	}
 
 	// An SSH server is represented by a ServerConfig, which holds
@@ -160,6 +269,13 @@ func (s *server) serve() (err error) {
 						"_vfsKey": vfsKey,
 					},
 				}, nil
+			} else if s.authDB != nil {
+				// the user is looked up again by name once logged in
+				_, err := s.authDB.Login(c.User(), string(pass))
+				if err != nil {
+					return nil, err
+				}
+				return nil, nil
 			} else if s.opt.User != "" && s.opt.Pass != "" {
 				userOK := subtle.ConstantTimeCompare([]byte(c.User()), []byte(s.opt.User))
 				passOK := subtle.ConstantTimeCompare(pass, []byte(s.opt.Pass))
@@ -188,15 +304,7 @@ func (s *server) serve() (err error) {
 					},
 				}, nil
 			}
-			if _, ok := authorizedKeysMap[string(pubKey.Marshal())]; ok {
-				return &ssh.Permissions{
-					// Record the public key used for authentication.
-					Extensions: map[string]string{
-						"pubkey-fp": ssh.FingerprintSHA256(pubKey),
-					},
-				}, nil
-			}
-			return nil, fmt.Errorf("unknown public key for %q", c.User())
+			return s.checkPublicKey(c, pubKey)
 		},
 		AuthLogCallback: func(conn ssh.ConnMetadata, method string, err error) {
 			status := "OK"
@@ -296,24 +404,135 @@ func loadPrivateKey(keyPath string) (ssh.Signer, error) {
 	return private, nil
 }
 
+// authorizedKeys are the keys from an authorized_keys file
+type authorizedKeys struct {
+	keys map[string]struct{} // keys which can log in
+	cas  map[string]struct{} // keys marked cert-authority which can sign user certificates
+}
+
+// len returns the number of keys, which may be called on a nil keys
+func (keys *authorizedKeys) len() int {
+	if keys == nil {
+		return 0
+	}
+	return len(keys.keys) + len(keys.cas)
+}
+
+// canLogin returns whether pubKey can log in, which may be called on
+// a nil keys
+func (keys *authorizedKeys) canLogin(pubKey ssh.PublicKey) bool {
+	if keys == nil {
+		return false
+	}
+	_, ok := keys.keys[string(pubKey.Marshal())]
+	return ok
+}
+
+// isCA returns whether pubKey is marked cert-authority, which may be
+// called on a nil keys
+func (keys *authorizedKeys) isCA(pubKey ssh.PublicKey) bool {
+	if keys == nil {
+		return false
+	}
+	_, ok := keys.cas[string(pubKey.Marshal())]
+	return ok
+}
+
 // Public key authentication is done by comparing
 // the public key of a received connection
 // with the entries in the authorized_keys file.
-func loadAuthorizedKeys(authorizedKeysPath string) (authorizedKeysMap map[string]struct{}, err error) {
+func loadAuthorizedKeys(authorizedKeysPath string) (keys *authorizedKeys, err error) {
 	authorizedKeysBytes, err := ioutil.ReadFile(authorizedKeysPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load authorized keys")
 	}
-	authorizedKeysMap = make(map[string]struct{})
+	return parseAuthorizedKeys(authorizedKeysBytes)
+}
+
+// parseAuthorizedKeys parses the keys in an authorized_keys file
+func parseAuthorizedKeys(authorizedKeysBytes []byte) (keys *authorizedKeys, err error) {
+	keys = &authorizedKeys{
+		keys: make(map[string]struct{}),
+		cas:  make(map[string]struct{}),
+	}
 	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		pubKey, _, options, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to parse authorized keys")
 		}
-		authorizedKeysMap[string(pubKey.Marshal())] = struct{}{}
+		isCA := false
+		for _, option := range options {
+			if strings.EqualFold(option, "cert-authority") {
+				isCA = true
+			}
+		}
+		if isCA {
+			keys.cas[string(pubKey.Marshal())] = struct{}{}
+		} else {
+			keys.keys[string(pubKey.Marshal())] = struct{}{}
+		}
 		authorizedKeysBytes = bytes.TrimSpace(rest)
 	}
-	return authorizedKeysMap, nil
+	return keys, nil
+}
+
+// loadUserAuthorizedKeys loads the authorized keys of user from
+// --authorized-keys-dir returning nil if there aren't any
+func (s *server) loadUserAuthorizedKeys(user string) (keys *authorizedKeys, err error) {
+	err = checkUserName(user)
+	if err != nil {
+		return nil, err
+	}
+	o, err := s.keysFs.NewObject(s.ctx, user)
+	if err == fs.ErrorObjectNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to find authorized keys for %q", user)
+	}
+	in, err := o.Open(s.ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open authorized keys for %q", user)
+	}
+	defer fs.CheckClose(in, &err)
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read authorized keys for %q", user)
+	}
+	return parseAuthorizedKeys(data)
+}
+
+// checkPublicKey checks the public key or certificate the user is
+// logging in with against the authorized keys and the trusted CAs
+func (s *server) checkPublicKey(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	var userKeys *authorizedKeys
+	if s.keysFs != nil {
+		var err error
+		userKeys, err = s.loadUserAuthorizedKeys(c.User())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		checker := ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return s.caKeys.canLogin(auth) || s.caKeys.isCA(auth) || s.keys.isCA(auth) || userKeys.isCA(auth)
+			},
+		}
+		perms, err := checker.Authenticate(c, pubKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "certificate rejected for %q", c.User())
+		}
+		return perms, nil
+	}
+	if s.keys.canLogin(pubKey) || userKeys.canLogin(pubKey) {
+		return &ssh.Permissions{
+			// Record the public key used for authentication.
+			Extensions: map[string]string{
+				"pubkey-fp": ssh.FingerprintSHA256(pubKey),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown public key for %q", c.User())
 }
 
 // makeSSHKeyPair make a pair of public and private keys for SSH access.
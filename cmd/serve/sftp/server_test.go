@@ -0,0 +1,191 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCheckUserName(t *testing.T) {
+	for _, user := range []string{"alice", "bob.smith", "user@example.com", "a b"} {
+		assert.NoError(t, checkUserName(user), user)
+	}
+	for _, user := range []string{"", ".", "..", "a/b", `a\b`, "c:", "a\nb"} {
+		assert.Error(t, checkUserName(user), user)
+	}
+}
+
+func TestExpandUserRoot(t *testing.T) {
+	for _, test := range []struct {
+		root, want string
+	}{
+		{"remote:", "remote:"},
+		{"remote:home/%u", "remote:home/alice"},
+		{"%u:%u", "alice:alice"},
+		{"100%%/%u", "100%/alice"},
+		{"%x%", "%x%"},
+	} {
+		assert.Equal(t, test.want, expandUserRoot(test.root, "alice"), test.root)
+	}
+}
+
+// newTestKey makes a new ssh signer for tests
+func newTestKey(t *testing.T) ssh.Signer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	key, ca := newTestKey(t).PublicKey(), newTestKey(t).PublicKey()
+	data := "# comment\n" + string(ssh.MarshalAuthorizedKey(key)) + "\ncert-authority " + string(ssh.MarshalAuthorizedKey(ca))
+	keys, err := parseAuthorizedKeys([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, 2, keys.len())
+	assert.True(t, keys.canLogin(key))
+	assert.False(t, keys.isCA(key))
+	assert.False(t, keys.canLogin(ca))
+	assert.True(t, keys.isCA(ca))
+
+	var nilKeys *authorizedKeys
+	assert.Equal(t, 0, nilKeys.len())
+	assert.False(t, nilKeys.canLogin(key))
+	assert.False(t, nilKeys.isCA(key))
+
+	_, err = parseAuthorizedKeys([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+// newTestCert makes a user certificate for key signed by ca
+func newTestCert(t *testing.T, ca, key ssh.Signer, principals ...string) ssh.Signer {
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+	signer, err := ssh.NewCertSigner(cert, key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestPublicKeyAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-serve-sftp")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	mkdir := func(name string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(p, 0777))
+		return p
+	}
+	keysDir := mkdir("keys")
+	homes := mkdir("homes")
+	mkdir("homes/alice")
+	mkdir("homes/bob")
+
+	aliceKey := newTestKey(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(keysDir, "alice"), ssh.MarshalAuthorizedKey(aliceKey.PublicKey()), 0600))
+	ca := newTestKey(t)
+	caFile := filepath.Join(dir, "ca.pub")
+	require.NoError(t, ioutil.WriteFile(caFile, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0600))
+
+	opt := DefaultOpt
+	opt.ListenAddr = testBindAddress
+	opt.AuthorizedKeys = ""
+	opt.AuthorizedKeysDir = keysDir
+	opt.TrustedUserCAKeys = caFile
+	opt.UserRoot = filepath.Join(homes, "%u")
+	s := newServer(context.Background(), nil, &opt)
+	require.NoError(t, s.serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	// login connects as user with signer
+	login := func(user string, signer ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+	// upload uses scp to upload a file called name
+	upload := func(client *ssh.Client, name, contents string) {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer func() {
+			_ = session.Close()
+		}()
+		var out bytes.Buffer
+		session.Stdout = &out
+		session.Stdin = bytes.NewBufferString("C0644 " + strconv.Itoa(len(contents)) + " " + name + "\n" + contents + "\x00")
+		require.NoError(t, session.Run("scp -t ."))
+		assert.Equal(t, "\x00\x00\x00", out.String())
+	}
+
+	// Alice logs in with the key in the keys directory and
+	// uploads into her own root
+	client, err := login("alice", aliceKey)
+	require.NoError(t, err)
+	upload(client, "file.txt", "alice")
+	require.NoError(t, client.Close())
+	data, err := ioutil.ReadFile(filepath.Join(homes, "alice", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", string(data))
+
+	// Bob logs in with a certificate signed by the CA and
+	// uploads into his own root
+	bobKey := newTestKey(t)
+	bobCert := newTestCert(t, ca, bobKey, "bob")
+	client, err = login("bob", bobCert)
+	require.NoError(t, err)
+	upload(client, "file.txt", "bob")
+	require.NoError(t, client.Close())
+	data, err = ioutil.ReadFile(filepath.Join(homes, "bob", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", string(data))
+
+	// Bob's certificate isn't valid for Alice
+	_, err = login("alice", bobCert)
+	assert.Error(t, err)
+
+	// Alice's key isn't valid for Bob
+	_, err = login("bob", aliceKey)
+	assert.Error(t, err)
+
+	// Certificates signed by other CAs aren't accepted
+	_, err = login("bob", newTestCert(t, newTestKey(t), bobKey, "bob"))
+	assert.Error(t, err)
+
+	// Nor are bad user names
+	_, err = login("../alice", aliceKey)
+	assert.Error(t, err)
+}
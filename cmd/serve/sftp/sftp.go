@@ -8,6 +8,8 @@ import (
 	"context"
 
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
@@ -21,12 +23,15 @@ import (
 
 // Options contains options for the http Server
 type Options struct {
-	ListenAddr     string   // Port to listen on
-	HostKeys       []string // Paths to private host keys
-	AuthorizedKeys string   // Path to authorized keys file
-	User           string   // single username
-	Pass           string   // password for user
-	NoAuth         bool     // allow no authentication on connections
+	ListenAddr        string   // Port to listen on
+	HostKeys          []string // Paths to private host keys
+	AuthorizedKeys    string   // Path to authorized keys file
+	AuthorizedKeysDir string   // Directory or remote path of per user authorized keys files
+	TrustedUserCAKeys string   // Path to the keys of the CAs trusted to sign user certificates
+	UserRoot          string   // Remote path to serve for each user with %u for the user name
+	User              string   // single username
+	Pass              string   // password for user
+	NoAuth            bool     // allow no authentication on connections
 }
 
 // DefaultOpt is the default values used for Options
@@ -44,6 +49,9 @@ func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
 	flags.StringVarP(flagSet, &Opt.ListenAddr, "addr", "", Opt.ListenAddr, "IPaddress:Port or :Port to bind server to.")
 	flags.StringArrayVarP(flagSet, &Opt.HostKeys, "key", "", Opt.HostKeys, "SSH private host key file (Can be multi-valued, leave blank to auto generate)")
 	flags.StringVarP(flagSet, &Opt.AuthorizedKeys, "authorized-keys", "", Opt.AuthorizedKeys, "Authorized keys file")
+	flags.StringVarP(flagSet, &Opt.AuthorizedKeysDir, "authorized-keys-dir", "", Opt.AuthorizedKeysDir, "Directory or remote path with an authorized keys file for each user")
+	flags.StringVarP(flagSet, &Opt.TrustedUserCAKeys, "trusted-user-ca-keys", "", Opt.TrustedUserCAKeys, "File of CA public keys trusted to sign user certificates")
+	flags.StringVarP(flagSet, &Opt.UserRoot, "user-root", "", Opt.UserRoot, "Remote path to serve to each user instead of remote:path - %u is replaced with the user name")
 	flags.StringVarP(flagSet, &Opt.User, "user", "", Opt.User, "User name for authentication.")
 	flags.StringVarP(flagSet, &Opt.Pass, "pass", "", Opt.Pass, "Password for authentication.")
 	flags.BoolVarP(flagSet, &Opt.NoAuth, "no-auth", "", Opt.NoAuth, "Allow connections with no authentication if set.")
@@ -52,6 +60,7 @@ func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
 func init() {
 	vfsflags.AddFlags(Command.Flags())
 	proxyflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
 	AddFlags(Command.Flags(), &Opt)
 }
 
@@ -73,27 +82,69 @@ control the stats printing.
 
 You must provide some means of authentication, either with --user/--pass,
 an authorized keys file (specify location with --authorized-keys - the
-default is the same as ssh), an --auth-proxy, or set the --no-auth flag for no
-authentication when logging in.
+default is the same as ssh), an --auth-proxy, the user database
+described below, or set the --no-auth flag for no authentication when
+logging in.
 
 Note that this also implements a small number of shell commands so
 that it can provide md5sum/sha1sum/df information for the rclone sftp
 backend.  This means that is can support SHA1SUMs, MD5SUMs and the
 about command when paired with the rclone sftp backend.
 
+It also implements the gzip and zstd commands the rclone sftp backend
+uses to compress data in transit when it is run with
+--transfer-compression.
+
 If you don't supply a --key then rclone will generate one and cache it
 for later use.
 
+### Per user keys and certificates
+
+Use --authorized-keys-dir to give each user their own authorized keys
+file, like the AuthorizedKeysFile setting of sshd. The directory can
+be a local path or a remote path, eg "remote:keys", and should have a
+file named after each user in the same format as an authorized_keys
+file. The files are read each time a user logs in so they can be
+changed while the server is running.
+
+Use --trusted-user-ca-keys to accept OpenSSH user certificates signed
+by the CA public keys in the file given, like the TrustedUserCAKeys
+setting of sshd. The user name must be one of the principals of the
+certificate, the certificate must be valid at the time of the login
+and a source-address option in it is enforced. Keys marked
+"cert-authority" in an authorized keys file are also trusted as CAs
+for the users which that file is for.
+
+### Per user roots
+
+Use --user-root to serve each user a different remote path instead of
+remote:path, which isn't needed then. "%u" in it is replaced with the
+name of the user, so for example
+
+    rclone serve sftp --user-root "s3:home-bucket/%u" --authorized-keys-dir /etc/rclone/keys
+
+gives each user a directory of their own in the bucket which they
+can't get out of, and "--user-root %u:" serves each user the remote
+with their name from the config file. The user names must be valid
+file names, so they can't contain "/", "\" or ":".
+
+### SCP
+
+As well as SFTP the server supports the older SCP protocol, so files
+can be copied with "scp -O" and old scp clients which don't use SFTP.
+Recursive copies with -r and preserving modification times with -p are
+supported.
+
 By default the server binds to localhost:2022 - if you want it to be
 reachable externally then supply "--addr :2022" for example.
 
 Note that the default of "--vfs-cache-mode off" is fine for the rclone
 sftp backend, but it may not be with other SFTP clients.
 
-` + vfs.Help + proxy.Help,
+` + vfs.Help + proxy.Help + auth.Help,
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if proxyflags.Opt.AuthProxy == "" && Opt.UserRoot == "" {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
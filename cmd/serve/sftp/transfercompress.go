@@ -0,0 +1,71 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/transfercompress"
+)
+
+// transferCommands are the argument prefixes of the gzip and zstd
+// commands the sftp backend runs to compress data in transit with
+// --transfer-compression.
+var transferCommands = map[string]struct {
+	read  string // compress the file named after this to stdout
+	write string // decompress stdin to the file named after this
+}{
+	transfercompress.Gzip: {read: "-c -1 < ", write: "-d -c > "},
+	transfercompress.Zstd: {read: "-q -c -1 < ", write: "-q -d -c > "},
+}
+
+// transferCommand runs the gzip or zstd command binary with args
+// which haven't been unescaped yet, reading from in and writing to
+// out.
+func (c *conn) transferCommand(binary, args string, in io.Reader, out io.Writer) (err error) {
+	method := binary
+	commands := transferCommands[method]
+	switch {
+	case strings.HasPrefix(args, commands.read):
+		name := shellUnEscape(args[len(commands.read):])
+		fs.Debugf(c.what, "sending %q compressed with %s", name, method)
+		file, err := c.vfs.OpenFile(name, os.O_RDONLY, 0777)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(file, &err)
+		w, err := transfercompress.NewWriter(method, out)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		if err != nil {
+			_ = w.Close()
+			return errors.Wrap(err, "send output failed")
+		}
+		return w.Close()
+	case strings.HasPrefix(args, commands.write):
+		name := shellUnEscape(args[len(commands.write):])
+		fs.Debugf(c.what, "receiving %q compressed with %s", name, method)
+		r, err := transfercompress.NewReader(method, in)
+		if err != nil {
+			return errors.Wrap(err, "read input failed")
+		}
+		defer fs.CheckClose(r, &err)
+		file, err := c.vfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, r)
+		if err != nil {
+			_ = file.Close()
+			return errors.Wrap(err, "read input failed")
+		}
+		return file.Close()
+	}
+	return errors.Errorf("%s %q not implemented", binary, args)
+}
@@ -0,0 +1,73 @@
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/transfercompress"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferCommand(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-serve-sftp")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	c := &conn{vfs: vfs.New(f, nil), what: "test"}
+	defer c.vfs.Shutdown()
+
+	data := bytes.Repeat([]byte("hello world "), 1000)
+	for _, method := range transfercompress.Methods {
+		t.Run(method, func(t *testing.T) {
+			// check the probe works
+			var out bytes.Buffer
+			probe := "echo 'rclone' | " + method + " -c | " + method + " -d -c"
+			if method == transfercompress.Zstd {
+				probe = "echo 'rclone' | zstd -q -c | zstd -q -d -c"
+			}
+			require.NoError(t, c.execCommand(ctx, nil, &out, probe))
+			assert.Equal(t, "rclone\n", out.String())
+
+			// upload a file
+			var compressed bytes.Buffer
+			w, err := transfercompress.NewWriter(method, &compressed)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			commands := transferCommands[method]
+			err = c.execCommand(ctx, &compressed, ioutil.Discard, method+" "+commands.write+`file\ name`)
+			require.NoError(t, err)
+			got, err := ioutil.ReadFile(filepath.Join(dir, "file name"))
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			// download it again
+			out.Reset()
+			err = c.execCommand(ctx, nil, &out, method+" "+commands.read+`file\ name`)
+			require.NoError(t, err)
+			r, err := transfercompress.NewReader(method, &out)
+			require.NoError(t, err)
+			got, err = ioutil.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			err = c.execCommand(ctx, nil, &out, method+" -9 file")
+			assert.Error(t, err)
+		})
+	}
+}
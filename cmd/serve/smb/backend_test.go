@@ -0,0 +1,59 @@
+// Serve smb tests set up a server and run the integration tests
+// for the smb remote against it.
+
+// +build !plan9,!js
+
+package smb
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	smbbackend "github.com/rclone/rclone/backend/smb"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBackend runs the server then runs the integration tests for
+// the smb remote against it.
+func TestBackend(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("skipping as -remote is set")
+	}
+	dir, cleanup := setup(t)
+	defer cleanup()
+	s := newTestServer(t, dir, testUser)
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	host, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+
+	// Configure the backend with environment variables
+	const remote = "TestSMBServe"
+	config := map[string]string{
+		"type":             "smb",
+		"host":             host,
+		"port":             port,
+		"user":             testUser,
+		"pass":             obscure.MustObscure(testPass),
+		"case_insensitive": "false",
+	}
+	for k, v := range config {
+		key := fs.ConfigToEnv(remote, k)
+		require.NoError(t, os.Setenv(key, v))
+		defer func(key string) {
+			_ = os.Unsetenv(key)
+		}(key)
+	}
+
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: remote + ":" + DefaultOpt.ShareName,
+		NilObject:  (*smbbackend.Object)(nil),
+	})
+}
@@ -0,0 +1,284 @@
+// +build !plan9,!js
+
+package smb
+
+// Constants from [MS-SMB2] and [MS-FSCC]
+
+// SMB2 commands
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdLogoff         = 0x0002
+	cmdTreeConnect    = 0x0003
+	cmdTreeDisconnect = 0x0004
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdFlush          = 0x0007
+	cmdRead           = 0x0008
+	cmdWrite          = 0x0009
+	cmdLock           = 0x000A
+	cmdIoctl          = 0x000B
+	cmdCancel         = 0x000C
+	cmdEcho           = 0x000D
+	cmdQueryDirectory = 0x000E
+	cmdChangeNotify   = 0x000F
+	cmdQueryInfo      = 0x0010
+	cmdSetInfo        = 0x0011
+	cmdOplockBreak    = 0x0012
+)
+
+// header sizes and offsets
+const (
+	headerSize      = 64
+	offStatus       = 8
+	offCommand      = 12
+	offCredit       = 14
+	offFlags        = 16
+	offNextCommand  = 20
+	offMessageID    = 24
+	offTreeID       = 36
+	offSessionID    = 40
+	offSignature    = 48
+	signatureLength = 16
+)
+
+// header flags
+const (
+	flagsServerToRedir     = 0x00000001
+	flagsAsyncCommand      = 0x00000002
+	flagsRelatedOperations = 0x00000004
+	flagsSigned            = 0x00000008
+)
+
+// dialects
+const (
+	dialect202      = 0x0202
+	dialect210      = 0x0210
+	dialect300      = 0x0300
+	dialect302      = 0x0302
+	dialect311      = 0x0311
+	dialectWildcard = 0x02FF
+)
+
+// the dialects supported, most preferred first
+var dialects = []uint16{dialect311, dialect302, dialect300, dialect210, dialect202}
+
+// security modes
+const (
+	negotiateSigningEnabled  = 0x0001
+	negotiateSigningRequired = 0x0002
+)
+
+// capabilities
+const (
+	capLargeMTU = 0x00000004
+)
+
+// negotiate contexts
+const (
+	ctxPreauthIntegrity = 0x0001
+	ctxSigning          = 0x0008
+	hashSHA512          = 0x0001
+	signingAESCMAC      = 0x0001
+)
+
+// session flags
+const (
+	sessionFlagIsGuest = 0x0001
+	sessionFlagIsNull  = 0x0002
+)
+
+// share types
+const (
+	shareTypeDisk = 0x01
+	shareTypePipe = 0x02
+)
+
+// access masks
+const (
+	fileReadData        = 0x00000001
+	fileWriteData       = 0x00000002
+	fileAppendData      = 0x00000004
+	fileReadEA          = 0x00000008
+	fileWriteEA         = 0x00000010
+	fileExecute         = 0x00000020
+	fileDeleteChild     = 0x00000040
+	fileReadAttributes  = 0x00000080
+	fileWriteAttributes = 0x00000100
+	accessDelete        = 0x00010000
+	readControl         = 0x00020000
+	writeDAC            = 0x00040000
+	writeOwner          = 0x00080000
+	synchronize         = 0x00100000
+	maximumAllowed      = 0x02000000
+	genericAll          = 0x10000000
+	genericExecute      = 0x20000000
+	genericWrite        = 0x40000000
+	genericRead         = 0x80000000
+
+	fileAllAccess   = 0x001F01FF
+	fileReadAccess  = fileReadData | fileReadEA | fileExecute | fileReadAttributes | readControl | synchronize
+	fileWriteAccess = fileWriteData | fileAppendData | fileWriteEA | fileWriteAttributes | fileDeleteChild | accessDelete | writeDAC | writeOwner
+)
+
+// create dispositions
+const (
+	fileSupersede   = 0
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOpenIf      = 3
+	fileOverwrite   = 4
+	fileOverwriteIf = 5
+)
+
+// create options
+const (
+	fileDirectoryFile    = 0x00000001
+	fileNonDirectoryFile = 0x00000040
+	fileDeleteOnClose    = 0x00001000
+)
+
+// create actions
+const (
+	fileSuperseded  = 0
+	fileOpened      = 1
+	fileCreated     = 2
+	fileOverwritten = 3
+)
+
+// file attributes
+const (
+	fileAttributeReadonly  = 0x00000001
+	fileAttributeDirectory = 0x00000010
+	fileAttributeArchive   = 0x00000020
+)
+
+// close flags
+const (
+	closeFlagPostqueryAttrib = 0x0001
+)
+
+// query directory flags
+const (
+	restartScans      = 0x01
+	returnSingleEntry = 0x02
+	indexSpecified    = 0x04
+	reopen            = 0x10
+)
+
+// info types
+const (
+	infoFile       = 0x01
+	infoFilesystem = 0x02
+	infoSecurity   = 0x03
+)
+
+// file information classes
+const (
+	fileDirectoryInformation          = 1
+	fileFullDirectoryInformation      = 2
+	fileBothDirectoryInformation      = 3
+	fileBasicInformation              = 4
+	fileStandardInformation           = 5
+	fileInternalInformation           = 6
+	fileEaInformation                 = 7
+	fileAccessInformation             = 8
+	fileRenameInformation             = 10
+	fileNamesInformation              = 12
+	fileDispositionInformation        = 13
+	filePositionInformation           = 14
+	fileModeInformation               = 16
+	fileAlignmentInformation          = 17
+	fileAllInformation                = 18
+	fileAllocationInformation         = 19
+	fileEndOfFileInformation          = 20
+	fileStreamInformation             = 22
+	fileCompressionInformation        = 28
+	fileNetworkOpenInformation        = 34
+	fileAttributeTagInformation       = 35
+	fileIDBothDirectoryInformation    = 37
+	fileIDFullDirectoryInformation    = 38
+	fileValidDataLengthInformation    = 39
+	fileNormalizedNameInformation     = 48
+	fileDispositionInformationEx      = 64
+	fileDispositionFlagDelete         = 0x00000001
+	fileWriteToEndOfFile              = 0xFFFFFFFFFFFFFFFF
+	fileSystemFsVolumeInformation     = 1
+	fileSystemFsSizeInformation       = 3
+	fileSystemFsDeviceInformation     = 4
+	fileSystemFsAttributeInformation  = 5
+	fileSystemFsFullSizeInformation   = 7
+	fileSystemFsSectorSizeInformation = 11
+)
+
+// file system attributes
+const (
+	fileCaseSensitiveSearch = 0x00000001
+	fileCasePreservedNames  = 0x00000002
+	fileUnicodeOnDisk       = 0x00000004
+	fileReadOnlyVolume      = 0x00080000
+)
+
+// security information
+const (
+	ownerSecurityInformation = 0x00000001
+	groupSecurityInformation = 0x00000002
+	daclSecurityInformation  = 0x00000004
+)
+
+// lock flags
+const (
+	lockFlagShared          = 0x00000001
+	lockFlagExclusive       = 0x00000002
+	lockFlagUnlock          = 0x00000004
+	lockFlagFailImmediately = 0x00000010
+)
+
+// FSCTL codes
+const (
+	fsctlDfsGetReferrals       = 0x00060194
+	fsctlPipeTransceive        = 0x0011C017
+	fsctlValidateNegotiateInfo = 0x00140204
+	ioctlIsFsctl               = 0x00000001
+)
+
+// ntStatus is an NTSTATUS code returned in the header of responses
+type ntStatus uint32
+
+// NTSTATUS codes
+const (
+	statusSuccess                = ntStatus(0x00000000)
+	statusBufferOverflow         = ntStatus(0x80000005)
+	statusNoMoreFiles            = ntStatus(0x80000006)
+	statusNotImplemented         = ntStatus(0xC0000002)
+	statusInvalidInfoClass       = ntStatus(0xC0000003)
+	statusInfoLengthMismatch     = ntStatus(0xC0000004)
+	statusInvalidHandle          = ntStatus(0xC0000008)
+	statusInvalidParameter       = ntStatus(0xC000000D)
+	statusNoSuchFile             = ntStatus(0xC000000F)
+	statusInvalidDeviceRequest   = ntStatus(0xC0000010)
+	statusEndOfFile              = ntStatus(0xC0000011)
+	statusMoreProcessingRequired = ntStatus(0xC0000016)
+	statusAccessDenied           = ntStatus(0xC0000022)
+	statusBufferTooSmall         = ntStatus(0xC0000023)
+	statusObjectNameInvalid      = ntStatus(0xC0000033)
+	statusObjectNameNotFound     = ntStatus(0xC0000034)
+	statusObjectNameCollision    = ntStatus(0xC0000035)
+	statusObjectPathNotFound     = ntStatus(0xC000003A)
+	statusLockNotGranted         = ntStatus(0xC0000055)
+	statusDeletePending          = ntStatus(0xC0000056)
+	statusLogonFailure           = ntStatus(0xC000006D)
+	statusDiskFull               = ntStatus(0xC000007F)
+	statusMediaWriteProtected    = ntStatus(0xC00000A2)
+	statusFileIsADirectory       = ntStatus(0xC00000BA)
+	statusNotSupported           = ntStatus(0xC00000BB)
+	statusBadNetworkName         = ntStatus(0xC00000CC)
+	statusRequestNotAccepted     = ntStatus(0xC00000D0)
+	statusUnexpectedIOError      = ntStatus(0xC00000E9)
+	statusDirectoryNotEmpty      = ntStatus(0xC0000101)
+	statusNotADirectory          = ntStatus(0xC0000103)
+	statusFileClosed             = ntStatus(0xC0000128)
+	statusFsDriverRequired       = ntStatus(0xC000019C)
+	statusUserSessionDeleted     = ntStatus(0xC0000203)
+	statusNetworkNameDeleted     = ntStatus(0xC00000C9)
+)
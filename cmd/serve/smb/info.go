@@ -0,0 +1,543 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs"
+)
+
+const (
+	clusterSize    = 4096 // size of an allocation unit
+	sectorSize     = 512  // size of a sector
+	unknownSize    = 1 << 50
+	fileDeviceDisk = 0x00000007
+)
+
+// attributes returns the file attributes of node
+func attributes(node vfs.Node) uint32 {
+	if node.IsDir() {
+		return fileAttributeDirectory
+	}
+	attrs := uint32(fileAttributeArchive)
+	if node.VFS().Opt.ReadOnly {
+		attrs |= fileAttributeReadonly
+	}
+	return attrs
+}
+
+// allocationSize returns the space node takes on disk
+func allocationSize(node vfs.Node) uint64 {
+	if node.IsDir() {
+		return 0
+	}
+	return (uint64(node.Size()) + clusterSize - 1) &^ (clusterSize - 1)
+}
+
+// endOfFile returns the size of node
+func endOfFile(node vfs.Node) uint64 {
+	if node.IsDir() {
+		return 0
+	}
+	return uint64(node.Size())
+}
+
+// writeTimes writes the creation, last access, last write and change
+// times of node which are all its modification time
+func writeTimes(w *smb2.Writer, node vfs.Node) {
+	t := smb2.ToFiletime(node.ModTime())
+	for i := 0; i < 4; i++ {
+		w.Uint64(t)
+	}
+}
+
+// writeNetworkOpenInfo writes FILE_NETWORK_OPEN_INFORMATION for node
+func writeNetworkOpenInfo(w *smb2.Writer, node vfs.Node) {
+	writeTimes(w, node)
+	w.Uint64(allocationSize(node))
+	w.Uint64(endOfFile(node))
+	w.Uint32(attributes(node))
+	w.Uint32(0)
+}
+
+// validDirClass returns whether class is supported by QUERY_DIRECTORY
+func validDirClass(class uint8) bool {
+	switch class {
+	case fileDirectoryInformation, fileFullDirectoryInformation, fileBothDirectoryInformation,
+		fileNamesInformation, fileIDBothDirectoryInformation, fileIDFullDirectoryInformation:
+		return true
+	}
+	return false
+}
+
+// writeDirEntry writes the directory entry e in the format of class
+// with a zero NextEntryOffset
+func writeDirEntry(w *smb2.Writer, class uint8, e dirEntry) {
+	name := smb2.EncodeUTF16(e.name)
+	w.Uint32(0) // next entry offset
+	w.Uint32(0) // file index
+	if class == fileNamesInformation {
+		w.Uint32(uint32(len(name)))
+		w.Append(name)
+		return
+	}
+	writeTimes(w, e.node)
+	w.Uint64(endOfFile(e.node))
+	w.Uint64(allocationSize(e.node))
+	w.Uint32(attributes(e.node))
+	w.Uint32(uint32(len(name)))
+	switch class {
+	case fileFullDirectoryInformation:
+		w.Uint32(0) // EA size
+	case fileIDFullDirectoryInformation:
+		w.Uint32(0) // EA size
+		w.Uint32(0)
+		w.Uint64(e.node.Inode())
+	case fileBothDirectoryInformation, fileIDBothDirectoryInformation:
+		w.Uint32(0) // EA size
+		w.Uint8(0)  // short name length
+		w.Uint8(0)
+		w.Zero(24) // short name
+		if class == fileIDBothDirectoryInformation {
+			w.Uint16(0)
+			w.Uint64(e.node.Inode())
+		}
+	}
+	w.Append(name)
+}
+
+// queryInfo runs QUERY_INFO
+func (req *request) queryInfo() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	infoType := r.Uint8()
+	class := r.Uint8()
+	outputLength := int(r.Uint32())
+	r.Seek(headerSize + 16)
+	additional := r.Uint32()
+	r.Skip(4) // flags
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	var node vfs.Node
+	if o.pipe == nil {
+		var err error
+		node, err = req.sess.vfs.Stat(o.getPath())
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	} else if infoType != infoFile || class != fileStandardInformation {
+		return nil, statusNotSupported
+	}
+
+	var w smb2.Writer
+	variable := false // set if the information can be truncated
+	switch infoType {
+	case infoFile:
+		variable, status = req.fileInfo(&w, o, node, class)
+	case infoFilesystem:
+		variable, status = req.fsInfo(&w, class)
+	case infoSecurity:
+		writeSecurityDescriptor(&w, node, additional)
+		if w.Len() > outputLength {
+			var size smb2.Writer
+			size.Uint32(uint32(w.Len()))
+			return errorBody(size.Bytes()), statusBufferTooSmall
+		}
+	default:
+		status = statusInvalidParameter
+	}
+	if status != statusSuccess {
+		return nil, status
+	}
+	out := w.Bytes()
+	if len(out) > outputLength {
+		if !variable {
+			return nil, statusInfoLengthMismatch
+		}
+		out = out[:outputLength]
+		status = statusBufferOverflow
+	}
+	var resp smb2.Writer
+	resp.Uint16(9)
+	resp.Uint16(headerSize + 8)
+	resp.Uint32(uint32(len(out)))
+	resp.Append(out)
+	return resp.Bytes(), status
+}
+
+// fileInfo writes the file information class for node
+func (req *request) fileInfo(w *smb2.Writer, o *openFile, node vfs.Node, class uint8) (variable bool, status ntStatus) {
+	writeBasic := func() {
+		writeTimes(w, node)
+		w.Uint32(attributes(node))
+		w.Uint32(0)
+	}
+	writeStandard := func() {
+		if node == nil {
+			// a named pipe
+			w.Uint64(clusterSize)
+			w.Uint64(0)
+			w.Uint32(1)
+			w.Uint8(0)
+			w.Uint8(0)
+			w.Uint16(0)
+			return
+		}
+		o.mu.RLock()
+		deletePending := o.deleteOnClose
+		o.mu.RUnlock()
+		w.Uint64(allocationSize(node))
+		w.Uint64(endOfFile(node))
+		w.Uint32(1) // number of links
+		w.Uint8(boolToUint8(deletePending))
+		w.Uint8(boolToUint8(node.IsDir()))
+		w.Uint16(0)
+	}
+	switch class {
+	case fileBasicInformation:
+		writeBasic()
+	case fileStandardInformation:
+		writeStandard()
+	case fileInternalInformation:
+		w.Uint64(node.Inode())
+	case fileEaInformation:
+		w.Uint32(0)
+	case fileAccessInformation:
+		w.Uint32(o.grant)
+	case filePositionInformation:
+		w.Uint64(0)
+	case fileModeInformation:
+		w.Uint32(0)
+	case fileAlignmentInformation:
+		w.Uint32(0)
+	case fileAllInformation:
+		writeBasic()
+		writeStandard()
+		w.Uint64(node.Inode())
+		w.Uint32(0)       // EA size
+		w.Uint32(o.grant) // access flags
+		w.Uint64(0)       // position
+		w.Uint32(0)       // mode
+		w.Uint32(0)       // alignment
+		name := smb2.EncodeUTF16(`\` + strings.Replace(node.Path(), "/", `\`, -1))
+		w.Uint32(uint32(len(name)))
+		w.Append(name)
+		variable = true
+	case fileStreamInformation:
+		if !node.IsDir() {
+			name := smb2.EncodeUTF16("::$DATA")
+			w.Uint32(0) // next entry offset
+			w.Uint32(uint32(len(name)))
+			w.Uint64(endOfFile(node))
+			w.Uint64(allocationSize(node))
+			w.Append(name)
+		}
+		variable = true
+	case fileCompressionInformation:
+		w.Uint64(endOfFile(node))
+		w.Uint16(0) // compression format
+		w.Zero(6)
+	case fileNetworkOpenInformation:
+		writeNetworkOpenInfo(w, node)
+	case fileAttributeTagInformation:
+		w.Uint32(attributes(node))
+		w.Uint32(0) // reparse tag
+	case fileNormalizedNameInformation:
+		name := smb2.EncodeUTF16(strings.Replace(node.Path(), "/", `\`, -1))
+		w.Uint32(uint32(len(name)))
+		w.Append(name)
+		variable = true
+	default:
+		return false, statusInvalidInfoClass
+	}
+	return variable, statusSuccess
+}
+
+// boolToUint8 converts b to 1 or 0
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fsInfo writes the file system information class
+func (req *request) fsInfo(w *smb2.Writer, class uint8) (variable bool, status ntStatus) {
+	s := req.c.s
+	total, _, free := req.sess.vfs.Statfs()
+	if total < 0 {
+		total = unknownSize
+	}
+	if free < 0 {
+		free = total
+	}
+	totalUnits := uint64(total) / clusterSize
+	freeUnits := uint64(free) / clusterSize
+	switch class {
+	case fileSystemFsVolumeInformation:
+		label := smb2.EncodeUTF16(s.opt.ShareName)
+		w.Uint64(smb2.ToFiletime(s.startTime))
+		w.Uint32(0x72636c6e) // serial number
+		w.Uint32(uint32(len(label)))
+		w.Uint8(0) // supports objects
+		w.Uint8(0)
+		w.Append(label)
+		variable = true
+	case fileSystemFsSizeInformation:
+		w.Uint64(totalUnits)
+		w.Uint64(freeUnits)
+		w.Uint32(clusterSize / sectorSize)
+		w.Uint32(sectorSize)
+	case fileSystemFsDeviceInformation:
+		w.Uint32(fileDeviceDisk)
+		w.Uint32(0) // characteristics
+	case fileSystemFsAttributeInformation:
+		attrs := uint32(fileCasePreservedNames | fileUnicodeOnDisk)
+		if !req.sess.vfs.Opt.CaseInsensitive {
+			attrs |= fileCaseSensitiveSearch
+		}
+		if req.sess.vfs.Opt.ReadOnly {
+			attrs |= fileReadOnlyVolume
+		}
+		name := smb2.EncodeUTF16("NTFS")
+		w.Uint32(attrs)
+		w.Uint32(maxNameLength)
+		w.Uint32(uint32(len(name)))
+		w.Append(name)
+		variable = true
+	case fileSystemFsFullSizeInformation:
+		w.Uint64(totalUnits)
+		w.Uint64(freeUnits) // available to the caller
+		w.Uint64(freeUnits) // actually available
+		w.Uint32(clusterSize / sectorSize)
+		w.Uint32(sectorSize)
+	case fileSystemFsSectorSizeInformation:
+		for i := 0; i < 4; i++ {
+			w.Uint32(sectorSize)
+		}
+		w.Uint32(0) // flags
+		w.Uint32(0) // byte offset for sector alignment
+		w.Uint32(0) // byte offset for partition alignment
+	default:
+		return false, statusInvalidInfoClass
+	}
+	return variable, statusSuccess
+}
+
+// everyoneSID is the SID S-1-1-0 for Everyone
+var everyoneSID = []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+// writeSecurityDescriptor writes a self relative security descriptor
+// giving Everyone full access with the parts asked for in additional
+func writeSecurityDescriptor(w *smb2.Writer, node vfs.Node, additional uint32) {
+	const (
+		seDaclPresent  = 0x0004
+		seSelfRelative = 0x8000
+		headerLength   = 20
+	)
+	start := w.Len()
+	w.Uint8(1) // revision
+	w.Uint8(0)
+	w.Uint16(seDaclPresent | seSelfRelative)
+	w.Zero(16) // offsets of owner, group, SACL and DACL
+	if additional&ownerSecurityInformation != 0 {
+		w.PutUint32(start+4, uint32(w.Len()-start))
+		w.Append(everyoneSID)
+	}
+	if additional&groupSecurityInformation != 0 {
+		w.PutUint32(start+8, uint32(w.Len()-start))
+		w.Append(everyoneSID)
+	}
+	if additional&daclSecurityInformation != 0 {
+		w.PutUint32(start+16, uint32(w.Len()-start))
+		var aceFlags uint8
+		if node != nil && node.IsDir() {
+			aceFlags = 0x03 // object and container inherit
+		}
+		aceSize := 8 + len(everyoneSID)
+		w.Uint8(2) // ACL revision
+		w.Uint8(0)
+		w.Uint16(uint16(8 + aceSize))
+		w.Uint16(1) // ACE count
+		w.Uint16(0)
+		w.Uint8(0) // ACCESS_ALLOWED_ACE_TYPE
+		w.Uint8(aceFlags)
+		w.Uint16(uint16(aceSize))
+		w.Uint32(fileAllAccess)
+		w.Append(everyoneSID)
+	}
+}
+
+// setInfo runs SET_INFO
+func (req *request) setInfo() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	infoType := r.Uint8()
+	class := r.Uint8()
+	bufferLength := int(r.Uint32())
+	bufferOffset := int(r.Uint16())
+	r.Skip(2)
+	r.Skip(4) // additional information
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	buf := r.At(bufferOffset, bufferLength)
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	switch {
+	case o.pipe != nil:
+		status = statusNotSupported
+	case infoType == infoSecurity:
+		// permissions can't be changed so ignore them
+		status = statusSuccess
+	case infoType != infoFile:
+		status = statusNotSupported
+	case req.sess.vfs.Opt.ReadOnly:
+		status = statusMediaWriteProtected
+	default:
+		status = req.setFileInfo(o, class, smb2.NewReader(buf))
+	}
+	if status != statusSuccess {
+		return nil, status
+	}
+	var w smb2.Writer
+	w.Uint16(2)
+	return w.Bytes(), statusSuccess
+}
+
+// setFileInfo sets the file information class from r
+func (req *request) setFileInfo(o *openFile, class uint8, r *smb2.Reader) ntStatus {
+	VFS := req.sess.vfs
+	p := o.getPath()
+	switch class {
+	case fileBasicInformation:
+		r.Skip(16) // creation and last access time
+		lastWrite := r.Uint64()
+		if r.Err() != nil {
+			return statusInfoLengthMismatch
+		}
+		// 0 means don't change and -1 and -2 control
+		// automatic updates which are ignored
+		if lastWrite == 0 || lastWrite >= 0xFFFFFFFFFFFFFFFE {
+			return statusSuccess
+		}
+		if o.grant&fileWriteAttributes == 0 {
+			return statusAccessDenied
+		}
+		node, err := VFS.Stat(p)
+		if err != nil {
+			return toStatus(err)
+		}
+		err = node.SetModTime(smb2.FromFiletime(lastWrite))
+		if err != nil {
+			fs.Errorf(p, "serve smb: failed to set modification time: %v", err)
+			return toStatus(err)
+		}
+	case fileRenameInformation:
+		replace := r.Uint8() != 0
+		r.Seek(16)
+		nameLength := int(r.Uint32())
+		name := smb2.DecodeUTF16(r.Next(nameLength))
+		if r.Err() != nil {
+			return statusInfoLengthMismatch
+		}
+		if o.grant&accessDelete == 0 {
+			return statusAccessDenied
+		}
+		return req.rename(o, name, replace)
+	case fileDispositionInformation, fileDispositionInformationEx:
+		var deletePending bool
+		if class == fileDispositionInformation {
+			deletePending = r.Uint8() != 0
+		} else {
+			deletePending = r.Uint32()&fileDispositionFlagDelete != 0
+		}
+		if r.Err() != nil {
+			return statusInfoLengthMismatch
+		}
+		if deletePending {
+			if o.grant&accessDelete == 0 {
+				return statusAccessDenied
+			}
+			if o.isDir {
+				nodes, err := listDir(VFS, p, "*")
+				if err != nil {
+					return toStatus(err)
+				}
+				if len(nodes) > 2 {
+					return statusDirectoryNotEmpty
+				}
+			}
+		}
+		o.mu.Lock()
+		o.deleteOnClose = deletePending
+		o.mu.Unlock()
+	case fileEndOfFileInformation:
+		size := r.Uint64()
+		if r.Err() != nil {
+			return statusInfoLengthMismatch
+		}
+		if o.isDir || size > 1<<62 {
+			return statusInvalidParameter
+		}
+		if o.grant&(fileWriteData|fileAppendData) == 0 {
+			return statusAccessDenied
+		}
+		err := o.withHandle(VFS, true, func(h vfs.Handle) error {
+			return h.Truncate(int64(size))
+		})
+		if err != nil {
+			fs.Errorf(p, "serve smb: failed to set size: %v", err)
+			return toStatus(err)
+		}
+	case fileAllocationInformation, filePositionInformation, fileModeInformation, fileValidDataLengthInformation:
+		// these don't mean anything for the VFS
+	default:
+		return statusInvalidInfoClass
+	}
+	return statusSuccess
+}
+
+// rename the open file o to name
+func (req *request) rename(o *openFile, name string, replace bool) ntStatus {
+	VFS := req.sess.vfs
+	newPath, status := smbPath(name)
+	if status != statusSuccess {
+		return status
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if newPath == o.path {
+		return statusSuccess
+	}
+	existing, err := VFS.Stat(newPath)
+	if err == nil {
+		// renaming to a name differing only in case finds the
+		// file itself in a case insensitive VFS
+		same := strings.EqualFold(newPath, o.path) && existing.Path() == o.path
+		switch {
+		case same:
+		case !replace:
+			return statusObjectNameCollision
+		case existing.IsDir():
+			return statusAccessDenied
+		}
+	} else if err != vfs.ENOENT {
+		return toStatus(err)
+	}
+	err = VFS.Rename(o.path, newPath)
+	if err != nil {
+		fs.Errorf(o.path, "serve smb: failed to rename to %q: %v", newPath, err)
+		return toStatus(err)
+	}
+	fs.Debugf(o.path, "SMB renamed to %q", newPath)
+	o.path = newPath
+	return statusSuccess
+}
@@ -0,0 +1,481 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// Authentication is done with NTLMv2 as described in [MS-NLMP]. The
+// NTLM messages are wrapped in SPNEGO (RFC 4178) tokens unless the
+// client sends them raw.
+
+// NTLM message types
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLM negotiate flags
+const (
+	ntlmNegotiateUnicode     = 0x00000001
+	ntlmRequestTarget        = 0x00000004
+	ntlmNegotiateSign        = 0x00000010
+	ntlmNegotiateSeal        = 0x00000020
+	ntlmNegotiateNTLM        = 0x00000200
+	ntlmNegotiateAlwaysSign  = 0x00008000
+	ntlmTargetTypeServer     = 0x00020000
+	ntlmNegotiateExtendedSec = 0x00080000
+	ntlmNegotiateTargetInfo  = 0x00800000
+	ntlmNegotiateVersion     = 0x02000000
+	ntlmNegotiate128         = 0x20000000
+	ntlmNegotiateKeyExch     = 0x40000000
+	ntlmNegotiate56          = 0x80000000
+
+	// flags which are granted if the client asks for them
+	ntlmOptionalFlags = ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSec | ntlmNegotiateVersion | ntlmNegotiate128 |
+		ntlmNegotiateKeyExch | ntlmNegotiate56
+)
+
+// NTLM AV pair ids
+const (
+	avEOL          = 0
+	avNbComputer   = 1
+	avNbDomain     = 2
+	avDNSComputer  = 3
+	avDNSDomain    = 4
+	avTimestamp    = 7
+	ntlmServerName = "RCLONE"
+)
+
+// SPNEGO states
+const (
+	negStateAcceptCompleted  = 0
+	negStateAcceptIncomplete = 1
+	negStateReject           = 2
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+	oidSPNEGO     = []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP    = []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+	ntlmVersion   = []byte{6, 1, 0xb1, 0x1d, 0, 0, 0, 15} // Windows 7 SP1, NTLMSSP revision 15
+)
+
+// authResult is the outcome of a successful authentication
+type authResult struct {
+	user       string
+	guest      bool   // logged on as guest
+	anonymous  bool   // logged on anonymously
+	sessionKey []byte // nil for guest and anonymous logons
+}
+
+// ntlmAuth holds the state of an authentication in progress
+type ntlmAuth struct {
+	ntHash     func(user string) ([]byte, error) // finds the NT hash of a user - nil means guest access
+	started    bool                              // set once the first token has been seen
+	spnego     bool                              // set if the tokens are wrapped in SPNEGO
+	sentMech   bool                              // set once supportedMech has been sent
+	needMIC    bool                              // set if a mechListMIC must be sent
+	mechTypes  []byte                            // DER of the mechTypes the client sent
+	challenge  [8]byte                           // the server challenge
+	flags      uint32                            // the negotiated flags
+	challenged bool                              // set once the CHALLENGE has been sent
+}
+
+// newNTLMAuth starts a new authentication checking the users with
+// ntHash, or allowing guests if it is nil
+func newNTLMAuth(ntHash func(user string) ([]byte, error)) *ntlmAuth {
+	return &ntlmAuth{
+		ntHash: ntHash,
+	}
+}
+
+// step processes a security token from the client returning the
+// token to send back.
+//
+// It returns statusMoreProcessingRequired while authentication is in
+// progress and statusSuccess with res set when it is complete.
+func (a *ntlmAuth) step(token []byte) (out []byte, res *authResult, status ntStatus) {
+	msg, err := a.unwrap(token)
+	if err != nil {
+		fs.Debugf(nil, "SMB bad security token: %v", err)
+		return a.wrap(negStateReject, nil, nil), nil, statusLogonFailure
+	}
+	if msg == nil {
+		// the client sent a token for a mechanism other than NTLM
+		// so ask for an NTLM one
+		return a.wrap(negStateAcceptIncomplete, nil, nil), nil, statusMoreProcessingRequired
+	}
+	if len(msg) < 12 || !bytes.Equal(msg[:8], ntlmSignature) {
+		return a.wrap(negStateReject, nil, nil), nil, statusLogonFailure
+	}
+	switch binary.LittleEndian.Uint32(msg[8:]) {
+	case ntlmNegotiate:
+		if a.challenged || len(msg) < 16 {
+			break
+		}
+		challenge, err := a.challengeMessage(binary.LittleEndian.Uint32(msg[12:]))
+		if err != nil {
+			fs.Errorf(nil, "SMB failed to make challenge: %v", err)
+			break
+		}
+		a.challenged = true
+		return a.wrap(negStateAcceptIncomplete, challenge, nil), nil, statusMoreProcessingRequired
+	case ntlmAuthenticate:
+		if !a.challenged {
+			break
+		}
+		res, err = a.authenticate(msg)
+		if err != nil {
+			fs.Infof(nil, "SMB authentication failed: %v", err)
+			break
+		}
+		var mic []byte
+		if a.needMIC && res.sessionKey != nil {
+			mic = ntlmMAC(res.sessionKey, a.flags, a.mechTypes)
+		}
+		return a.wrap(negStateAcceptCompleted, nil, mic), res, statusSuccess
+	}
+	return a.wrap(negStateReject, nil, nil), nil, statusLogonFailure
+}
+
+// unwrap returns the NTLM message from token, or nil if it has none
+func (a *ntlmAuth) unwrap(token []byte) (msg []byte, err error) {
+	if !a.started {
+		a.started = true
+		if bytes.HasPrefix(token, ntlmSignature) {
+			return token, nil
+		}
+		a.spnego = true
+		return a.parseNegTokenInit(token)
+	}
+	if !a.spnego {
+		return token, nil
+	}
+	return a.parseNegTokenResp(token)
+}
+
+// parseNegTokenInit parses the first SPNEGO token from the client
+func (a *ntlmAuth) parseNegTokenInit(token []byte) (msg []byte, err error) {
+	tag, body, _, ok := parseDER(token)
+	if !ok || tag != 0x60 || !bytes.HasPrefix(body, oidSPNEGO) {
+		return nil, errors.New("not a SPNEGO token")
+	}
+	tag, body, _, ok = parseDER(body[len(oidSPNEGO):])
+	if !ok || tag != 0xa0 {
+		return nil, errors.New("expecting NegTokenInit")
+	}
+	tag, body, _, ok = parseDER(body)
+	if !ok || tag != 0x30 {
+		return nil, errors.New("bad NegTokenInit")
+	}
+	var mechToken []byte
+	for len(body) > 0 {
+		var field []byte
+		tag, field, body, ok = parseDER(body)
+		if !ok {
+			return nil, errors.New("bad NegTokenInit field")
+		}
+		switch tag {
+		case 0xa0:
+			a.mechTypes = field
+		case 0xa2:
+			tag, mechToken, _, ok = parseDER(field)
+			if !ok || tag != 0x04 {
+				return nil, errors.New("bad mechToken")
+			}
+		case 0xa3:
+			a.needMIC = true
+		}
+	}
+	tag, mechs, _, ok := parseDER(a.mechTypes)
+	if !ok || tag != 0x30 {
+		return nil, errors.New("bad mechTypes")
+	}
+	for first := true; len(mechs) > 0; first = false {
+		var oid []byte
+		tag, oid, mechs, ok = parseDER(mechs)
+		if !ok || tag != 0x06 {
+			return nil, errors.New("bad mechType")
+		}
+		if bytes.Equal(oid, oidNTLMSSP[2:]) {
+			if !first {
+				// the client's preferred mechanism wasn't chosen so
+				// the mechList must be protected
+				a.needMIC = true
+				mechToken = nil
+			}
+			return mechToken, nil
+		}
+	}
+	return nil, errors.New("client doesn't support NTLMSSP")
+}
+
+// parseNegTokenResp parses the subsequent SPNEGO tokens from the client
+func (a *ntlmAuth) parseNegTokenResp(token []byte) (msg []byte, err error) {
+	tag, body, _, ok := parseDER(token)
+	if !ok || tag != 0xa1 {
+		return nil, errors.New("expecting NegTokenResp")
+	}
+	tag, body, _, ok = parseDER(body)
+	if !ok || tag != 0x30 {
+		return nil, errors.New("bad NegTokenResp")
+	}
+	for len(body) > 0 {
+		var field []byte
+		tag, field, body, ok = parseDER(body)
+		if !ok {
+			return nil, errors.New("bad NegTokenResp field")
+		}
+		switch tag {
+		case 0xa2:
+			tag, msg, _, ok = parseDER(field)
+			if !ok || tag != 0x04 {
+				return nil, errors.New("bad responseToken")
+			}
+		case 0xa3:
+			a.needMIC = true
+		}
+	}
+	if msg == nil {
+		return nil, errors.New("no responseToken")
+	}
+	return msg, nil
+}
+
+// wrap msg in a SPNEGO NegTokenResp if needed
+func (a *ntlmAuth) wrap(negState byte, msg, mic []byte) []byte {
+	if !a.spnego {
+		return msg
+	}
+	var fields []byte
+	fields = append(fields, derTLV(0xa0, []byte{0x0a, 0x01, negState})...)
+	if !a.sentMech && negState != negStateReject {
+		fields = append(fields, derTLV(0xa1, oidNTLMSSP)...)
+		a.sentMech = true
+	}
+	if msg != nil {
+		fields = append(fields, derTLV(0xa2, derTLV(0x04, msg))...)
+	}
+	if mic != nil {
+		fields = append(fields, derTLV(0xa3, derTLV(0x04, mic))...)
+	}
+	return derTLV(0xa1, derTLV(0x30, fields))
+}
+
+// negTokenInit returns the SPNEGO token sent in the NEGOTIATE
+// response to advertise NTLMSSP
+func negTokenInit() []byte {
+	mechTypes := derTLV(0xa0, derTLV(0x30, oidNTLMSSP))
+	body := append(append([]byte{}, oidSPNEGO...), derTLV(0xa0, derTLV(0x30, mechTypes))...)
+	return derTLV(0x60, body)
+}
+
+// challengeMessage makes the CHALLENGE message in reply to a
+// NEGOTIATE with the client flags given
+func (a *ntlmAuth) challengeMessage(clientFlags uint32) ([]byte, error) {
+	_, err := io.ReadFull(rand.Reader, a.challenge[:])
+	if err != nil {
+		return nil, err
+	}
+	a.flags = clientFlags&ntlmOptionalFlags | ntlmNegotiateUnicode | ntlmRequestTarget |
+		ntlmNegotiateNTLM | ntlmTargetTypeServer | ntlmNegotiateTargetInfo
+
+	name := smb2.EncodeUTF16(ntlmServerName)
+	var info smb2.Writer
+	for _, id := range []uint16{avNbDomain, avNbComputer, avDNSDomain, avDNSComputer} {
+		info.Uint16(id)
+		info.Uint16(uint16(len(name)))
+		info.Append(name)
+	}
+	info.Uint16(avTimestamp)
+	info.Uint16(8)
+	info.Uint64(smb2.ToFiletime(time.Now()))
+	info.Uint16(avEOL)
+	info.Uint16(0)
+
+	const payload = 56
+	var w smb2.Writer
+	w.Append(ntlmSignature)
+	w.Uint32(ntlmChallenge)
+	w.Uint16(uint16(len(name)))
+	w.Uint16(uint16(len(name)))
+	w.Uint32(payload)
+	w.Uint32(a.flags)
+	w.Append(a.challenge[:])
+	w.Zero(8)
+	w.Uint16(uint16(info.Len()))
+	w.Uint16(uint16(info.Len()))
+	w.Uint32(uint32(payload + len(name)))
+	w.Append(ntlmVersion)
+	w.Append(name)
+	w.Append(info.Bytes())
+	return w.Bytes(), nil
+}
+
+// authenticate checks the AUTHENTICATE message
+func (a *ntlmAuth) authenticate(msg []byte) (*authResult, error) {
+	r := smb2.NewReader(msg)
+	field := func(offset int) []byte {
+		r.Seek(offset)
+		length := int(r.Uint16())
+		r.Skip(2)
+		return r.At(int(r.Uint32()), length)
+	}
+	lm := field(12)
+	nt := field(20)
+	domainBytes := field(28)
+	userBytes := field(36)
+	encryptedKey := field(52)
+	r.Seek(60)
+	flags := r.Uint32()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	decode := func(b []byte) string {
+		if flags&ntlmNegotiateUnicode != 0 {
+			return smb2.DecodeUTF16(b)
+		}
+		return string(b)
+	}
+	domain := decode(domainBytes)
+	user := decode(userBytes)
+
+	if len(nt) == 0 && user == "" && len(lm) <= 1 {
+		if a.ntHash != nil {
+			return nil, errors.New("anonymous logon not allowed")
+		}
+		return &authResult{anonymous: true}, nil
+	}
+	if a.ntHash == nil {
+		return &authResult{user: user, guest: true}, nil
+	}
+	ntHash, err := a.ntHash(user)
+	if err != nil {
+		return nil, err
+	}
+	if len(nt) <= 24 {
+		return nil, errors.Errorf("user %q tried to use NTLMv1 which isn't supported", user)
+	}
+	proof, blob := nt[:16], nt[16:]
+	var baseKey []byte
+	for _, tryDomain := range []string{domain, ""} {
+		ntowf := ntowfv2(ntHash, user, tryDomain)
+		expected := ntlmv2Proof(ntowf, a.challenge[:], blob)
+		if subtle.ConstantTimeCompare(proof, expected) == 1 {
+			baseKey = hmacMD5(ntowf, proof)
+			break
+		}
+	}
+	if baseKey == nil {
+		return nil, errors.Errorf("bad password for user %q", user)
+	}
+	sessionKey := baseKey
+	if a.flags&ntlmNegotiateKeyExch != 0 && len(encryptedKey) == 16 {
+		sessionKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(baseKey)
+		cipher.XORKeyStream(sessionKey, encryptedKey)
+	}
+	return &authResult{user: user, sessionKey: sessionKey}, nil
+}
+
+// hmacMD5 returns the HMAC-MD5 of the data with key
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 returns the NTLMv2 hash of the password from its NT hash
+func ntowfv2(ntHash []byte, user, domain string) []byte {
+	return hmacMD5(ntHash, smb2.EncodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// ntlmv2Proof returns the NTProofStr the client should have sent
+func ntlmv2Proof(ntowf, challenge, blob []byte) []byte {
+	return hmacMD5(ntowf, challenge, blob)
+}
+
+// ntlmMAC returns the NTLM signature the server makes for the first
+// message it signs, used for the SPNEGO mechListMIC
+func ntlmMAC(sessionKey []byte, flags uint32, msg []byte) []byte {
+	signKey := md5.Sum(append(append([]byte{}, sessionKey...), "session key to server-to-client signing key magic constant\x00"...))
+	sealBase := sessionKey
+	if flags&ntlmNegotiate128 == 0 {
+		if flags&ntlmNegotiate56 != 0 {
+			sealBase = sessionKey[:7]
+		} else {
+			sealBase = sessionKey[:5]
+		}
+	}
+	sealKey := md5.Sum(append(append([]byte{}, sealBase...), "session key to server-to-client sealing key magic constant\x00"...))
+	var seq [4]byte
+	checksum := hmacMD5(signKey[:], seq[:], msg)[:8]
+	if flags&ntlmNegotiateKeyExch != 0 {
+		cipher, _ := rc4.NewCipher(sealKey[:])
+		cipher.XORKeyStream(checksum, checksum)
+	}
+	var w smb2.Writer
+	w.Uint32(1)
+	w.Append(checksum)
+	w.Append(seq[:])
+	return w.Bytes()
+}
+
+// parseDER parses a DER element returning its tag, its contents and
+// the data following it
+func parseDER(data []byte) (tag byte, body, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = data[0]
+	length := int(data[1])
+	data = data[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(data) < n {
+			return 0, nil, nil, false
+		}
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<8 | int(b)
+		}
+		data = data[n:]
+	}
+	if length > len(data) {
+		return 0, nil, nil, false
+	}
+	return tag, data[:length], data[length:], true
+}
+
+// derTLV encodes a DER element with the tag and contents given
+func derTLV(tag byte, body []byte) []byte {
+	out := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
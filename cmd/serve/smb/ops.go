@@ -0,0 +1,865 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs"
+)
+
+// toStatus converts an error from the VFS into an NTSTATUS
+func toStatus(err error) ntStatus {
+	if err == nil {
+		return statusSuccess
+	}
+	switch errors.Cause(err) {
+	case vfs.ENOENT:
+		return statusObjectNameNotFound
+	case vfs.EEXIST:
+		return statusObjectNameCollision
+	case vfs.ENOTEMPTY:
+		return statusDirectoryNotEmpty
+	case vfs.EPERM:
+		return statusAccessDenied
+	case vfs.EROFS:
+		return statusMediaWriteProtected
+	case vfs.EINVAL, vfs.ESPIPE:
+		return statusInvalidParameter
+	case vfs.ENOSYS, vfs.ENOTSUP:
+		return statusNotSupported
+	}
+	if fserrors.IsErrNoSpace(err) {
+		return statusDiskFull
+	}
+	return statusUnexpectedIOError
+}
+
+// openFile is a file or directory opened with CREATE
+type openFile struct {
+	id    uint64
+	vfs   *vfs.VFS
+	tree  *tree
+	isDir bool
+	pipe  *pipe  // set if this is a named pipe
+	grant uint32 // access granted
+
+	mu            sync.RWMutex // protects the following
+	path          string       // path of the file in the VFS
+	h             vfs.Handle   // opened on first use unless the file was created
+	hWrite        bool         // set if h was opened for writing
+	deleteOnClose bool
+	search        *search // directory search in progress
+}
+
+// search is the state of a QUERY_DIRECTORY on an open directory
+type search struct {
+	nodes []dirEntry // the matching entries
+	pos   int        // index of the next entry to return
+}
+
+// dirEntry is an entry in a directory listing
+type dirEntry struct {
+	name string
+	node vfs.Node
+}
+
+// getPath returns the current path of the file
+func (o *openFile) getPath() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.path
+}
+
+// withHandle calls fn with the VFS handle for the open file, opening
+// it first if necessary.
+//
+// If write is set the handle is opened for writing, replacing any
+// read only handle.
+func (o *openFile) withHandle(VFS *vfs.VFS, write bool, fn func(h vfs.Handle) error) error {
+	for {
+		o.mu.RLock()
+		if o.h != nil && (o.hWrite || !write) {
+			defer o.mu.RUnlock()
+			return fn(o.h)
+		}
+		o.mu.RUnlock()
+
+		o.mu.Lock()
+		if o.h == nil || (write && !o.hWrite) {
+			if o.h != nil {
+				err := o.h.Close()
+				o.h = nil
+				if err != nil {
+					o.mu.Unlock()
+					return err
+				}
+			}
+			flags := os.O_RDONLY
+			if write {
+				flags = os.O_RDWR
+			}
+			h, err := VFS.OpenFile(o.path, flags, 0777)
+			if err != nil {
+				o.mu.Unlock()
+				return err
+			}
+			o.h, o.hWrite = h, write
+		}
+		o.mu.Unlock()
+	}
+}
+
+// close the open file, releasing its locks and deleting it if it was
+// marked for deletion
+func (o *openFile) close() (err error) {
+	if o.pipe != nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.h != nil {
+		err = o.h.Close()
+		o.h = nil
+	}
+	o.vfs.UnlockAll(o)
+	if o.deleteOnClose {
+		node, statErr := o.vfs.Stat(o.path)
+		if statErr == nil {
+			statErr = node.Remove()
+		}
+		if statErr != nil && err == nil {
+			err = statErr
+		}
+	}
+	return err
+}
+
+// smbPath converts a name in an SMB request into a VFS path
+func smbPath(name string) (string, ntStatus) {
+	name = strings.Replace(name, `\`, "/", -1)
+	if len(name) >= 7 && strings.EqualFold(name[len(name)-7:], "::$DATA") {
+		name = name[:len(name)-7]
+	}
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "" || part == ".":
+			continue
+		case part == ".." || len(part) > maxNameLength || strings.ContainsAny(part, `:*?"<>|`):
+			return "", statusObjectNameInvalid
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "/"), statusSuccess
+}
+
+// grantedAccess works out the access granted to a file from the
+// access requested
+func grantedAccess(access uint32, readOnly bool) uint32 {
+	if access&(maximumAllowed|genericAll) != 0 {
+		access |= fileAllAccess
+	}
+	if access&genericRead != 0 {
+		access |= fileReadAccess
+	}
+	if access&genericWrite != 0 {
+		access |= fileWriteData | fileAppendData | fileWriteEA | fileWriteAttributes | readControl | synchronize
+	}
+	if access&genericExecute != 0 {
+		access |= fileExecute | fileReadAttributes | readControl | synchronize
+	}
+	access &= fileAllAccess
+	if readOnly {
+		access &= fileReadAccess
+	}
+	return access
+}
+
+// writeAccess is the access bits which need a writable file system
+const writeAccess = fileWriteData | fileAppendData | fileWriteEA | fileWriteAttributes | fileDeleteChild | accessDelete | genericWrite | genericAll
+
+// getFile returns the file open in the request's session and tree with
+// the file id read from r.
+//
+// A file id of all 0xFF bytes means the file opened by the last
+// request in a compound.
+func (req *request) getFile(r *smb2.Reader) (*openFile, ntStatus) {
+	fileID := r.Next(16)
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	var id [16]byte
+	copy(id[:], fileID)
+	if id == [16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff} {
+		id = *req.fileID
+	} else {
+		*req.fileID = id
+	}
+	sess := req.sess
+	sess.mu.Lock()
+	o := sess.files[leUint64(id[8:])]
+	sess.mu.Unlock()
+	if o == nil || o.tree != req.tree || leUint64(id[:8]) != o.id {
+		return nil, statusFileClosed
+	}
+	return o, statusSuccess
+}
+
+// leUint64 decodes a little endian uint64
+func leUint64(b []byte) uint64 {
+	return smb2.NewReader(b).Uint64()
+}
+
+// writeFileID writes the file id of o
+func writeFileID(w *smb2.Writer, o *openFile) {
+	w.Uint64(o.id) // persistent
+	w.Uint64(o.id) // volatile
+}
+
+// create runs CREATE
+func (req *request) create() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 24)
+	access := r.Uint32()
+	_ = r.Uint32() // file attributes
+	_ = r.Uint32() // share access
+	disposition := r.Uint32()
+	options := r.Uint32()
+	nameOffset := int(r.Uint16())
+	nameLength := int(r.Uint16())
+	var name string
+	if nameLength > 0 {
+		name = smb2.DecodeUTF16(r.At(nameOffset, nameLength))
+	}
+	if r.Err() != nil || disposition > fileOverwriteIf {
+		return nil, statusInvalidParameter
+	}
+	o := &openFile{
+		id:   req.c.newID(),
+		vfs:  req.sess.vfs,
+		tree: req.tree,
+	}
+	if req.tree.pipe {
+		o.pipe, status = newPipe(req.c.s, name)
+		if status != statusSuccess {
+			return nil, status
+		}
+		o.grant = fileReadData | fileWriteData
+		req.sess.addFile(o)
+		putFileID(req.fileID, o)
+		return createResponse(o, nil, fileOpened), statusSuccess
+	}
+	p, status := smbPath(name)
+	if status != statusSuccess {
+		return nil, status
+	}
+	readOnly := req.sess.vfs.Opt.ReadOnly
+	o.path = p
+	o.grant = grantedAccess(access, readOnly)
+	if options&fileDeleteOnClose != 0 {
+		if o.grant&accessDelete == 0 {
+			return nil, statusAccessDenied
+		}
+		o.deleteOnClose = true
+	}
+
+	node, err := req.sess.vfs.Stat(p)
+	exists := err == nil
+	if err != nil && err != vfs.ENOENT {
+		return nil, toStatus(err)
+	}
+	var action uint32
+	if exists {
+		o.isDir = node.IsDir()
+		switch {
+		case disposition == fileCreate:
+			return nil, statusObjectNameCollision
+		case o.isDir && options&fileNonDirectoryFile != 0:
+			return nil, statusFileIsADirectory
+		case !o.isDir && options&fileDirectoryFile != 0:
+			return nil, statusNotADirectory
+		case readOnly && (access&writeAccess != 0 || disposition != fileOpen && disposition != fileOpenIf):
+			return nil, statusAccessDenied
+		}
+		action = fileOpened
+		if disposition == fileSupersede || disposition == fileOverwrite || disposition == fileOverwriteIf {
+			if o.isDir {
+				return nil, statusInvalidParameter
+			}
+			o.h, err = req.sess.vfs.OpenFile(p, os.O_RDWR|os.O_TRUNC, 0777)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			o.hWrite = true
+			action = fileOverwritten
+			if disposition == fileSupersede {
+				action = fileSuperseded
+			}
+		}
+	} else {
+		if disposition == fileOpen || disposition == fileOverwrite {
+			if _, _, err := req.sess.vfs.StatParent(p); err != nil {
+				return nil, statusObjectPathNotFound
+			}
+			return nil, statusObjectNameNotFound
+		}
+		if readOnly {
+			return nil, statusAccessDenied
+		}
+		if options&fileDirectoryFile != 0 {
+			o.isDir = true
+			err = req.sess.vfs.Mkdir(p, 0777)
+		} else {
+			o.h, err = req.sess.vfs.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+			o.hWrite = true
+		}
+		if err == vfs.ENOENT {
+			return nil, statusObjectPathNotFound
+		} else if err != nil {
+			return nil, toStatus(err)
+		}
+		action = fileCreated
+		node, err = req.sess.vfs.Stat(p)
+		if err != nil {
+			if o.h != nil {
+				_ = o.h.Close()
+			}
+			return nil, toStatus(err)
+		}
+	}
+	fs.Debugf(p, "SMB create: access=0x%08X disposition=%d options=0x%08X action=%d", access, disposition, options, action)
+	req.sess.addFile(o)
+	putFileID(req.fileID, o)
+	return createResponse(o, node, action), statusSuccess
+}
+
+// putFileID stores the file id of o in id
+func putFileID(id *[16]byte, o *openFile) {
+	var w smb2.Writer
+	writeFileID(&w, o)
+	copy(id[:], w.Bytes())
+}
+
+// createResponse makes the response to CREATE
+func createResponse(o *openFile, node vfs.Node, action uint32) []byte {
+	var w smb2.Writer
+	w.Uint16(89)
+	w.Uint8(0) // oplock level
+	w.Uint8(0) // flags
+	w.Uint32(action)
+	if node != nil {
+		writeNetworkOpenInfo(&w, node)
+	} else {
+		w.Zero(56)
+	}
+	writeFileID(&w, o)
+	w.Uint32(0) // create contexts offset
+	w.Uint32(0) // create contexts length
+	return w.Bytes()
+}
+
+// close runs CLOSE
+func (req *request) close() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	flags := r.Uint16()
+	r.Skip(4)
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	if !req.sess.removeFile(o) {
+		return nil, statusFileClosed
+	}
+	VFS := req.sess.vfs
+	err := o.close()
+	if err != nil {
+		fs.Errorf(o.path, "serve smb: failed to close file: %v", err)
+		return nil, toStatus(err)
+	}
+	var w smb2.Writer
+	w.Uint16(60)
+	w.Uint16(flags & closeFlagPostqueryAttrib)
+	w.Uint32(0)
+	var node vfs.Node
+	if flags&closeFlagPostqueryAttrib != 0 && o.pipe == nil {
+		node, _ = VFS.Stat(o.getPath())
+	}
+	if node != nil {
+		writeNetworkOpenInfo(&w, node)
+	} else {
+		w.Zero(56)
+	}
+	// the response is the network open information without its
+	// trailing reserved field
+	return w.Bytes()[:60], statusSuccess
+}
+
+// flush runs FLUSH
+func (req *request) flush() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 8)
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	o.mu.RLock()
+	if o.h != nil {
+		err := o.h.Flush()
+		if err != nil {
+			o.mu.RUnlock()
+			fs.Errorf(o.path, "serve smb: flush failed: %v", err)
+			return nil, toStatus(err)
+		}
+	}
+	o.mu.RUnlock()
+	var w smb2.Writer
+	w.Uint16(4)
+	w.Uint16(0)
+	return w.Bytes(), statusSuccess
+}
+
+// maxIOSize returns the largest read or write allowed
+func (c *conn) maxIOSize() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dialect == dialect202 {
+		return maxSmallIO
+	}
+	return maxIO
+}
+
+// read runs READ
+func (req *request) read() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 4)
+	length := r.Uint32()
+	offset := r.Uint64()
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	if length > req.c.maxIOSize() {
+		return nil, statusInvalidParameter
+	}
+	var data []byte
+	switch {
+	case o.pipe != nil:
+		data = o.pipe.read(int(length))
+	case o.isDir:
+		return nil, statusInvalidDeviceRequest
+	case o.grant&(fileReadData|fileExecute) == 0:
+		return nil, statusAccessDenied
+	case offset > math.MaxInt64:
+		return nil, statusInvalidParameter
+	default:
+		buf := make([]byte, length)
+		var n int
+		err := o.withHandle(req.sess.vfs, false, func(h vfs.Handle) (err error) {
+			n, err = h.ReadAt(buf, int64(offset))
+			return err
+		})
+		if err == nil || err == io.EOF {
+			req.sess.wait(n)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if err != nil {
+			fs.Errorf(o.getPath(), "serve smb: read failed: %v", err)
+			return nil, toStatus(err)
+		}
+		data = buf[:n]
+	}
+	if len(data) == 0 && length > 0 {
+		return nil, statusEndOfFile
+	}
+	var w smb2.Writer
+	w.Uint16(17)
+	w.Uint8(headerSize + 16) // data offset
+	w.Uint8(0)
+	w.Uint32(uint32(len(data)))
+	w.Uint32(0) // data remaining
+	w.Uint32(0)
+	w.Append(data)
+	return w.Bytes(), statusSuccess
+}
+
+// write runs WRITE
+func (req *request) write() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	dataOffset := int(r.Uint16())
+	length := int(r.Uint32())
+	offset := r.Uint64()
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	data := r.At(dataOffset, length)
+	if r.Err() != nil || uint32(length) > req.c.maxIOSize() {
+		return nil, statusInvalidParameter
+	}
+	switch {
+	case o.pipe != nil:
+		o.pipe.write(data)
+	case o.isDir:
+		return nil, statusInvalidDeviceRequest
+	case o.grant&(fileWriteData|fileAppendData) == 0:
+		return nil, statusAccessDenied
+	case offset != fileWriteToEndOfFile && offset > math.MaxInt64-uint64(length):
+		return nil, statusInvalidParameter
+	default:
+		req.sess.wait(length)
+		err := o.withHandle(req.sess.vfs, true, func(h vfs.Handle) (err error) {
+			at := int64(offset)
+			if offset == fileWriteToEndOfFile {
+				at = h.Node().Size()
+			}
+			_, err = h.WriteAt(data, at)
+			return err
+		})
+		if err != nil {
+			fs.Errorf(o.getPath(), "serve smb: write failed: %v", err)
+			return nil, toStatus(err)
+		}
+	}
+	var w smb2.Writer
+	w.Uint16(17)
+	w.Uint16(0)
+	w.Uint32(uint32(length))
+	w.Uint32(0) // remaining
+	w.Uint16(0) // channel info offset
+	w.Uint16(0) // channel info length
+	return w.Bytes(), statusSuccess
+}
+
+// lock runs LOCK
+//
+// Locks are all taken or none of them are and the server never waits
+// for a lock to become free.
+func (req *request) lock() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	count := int(r.Uint16())
+	r.Skip(4) // lock sequence
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	if count == 0 || o.pipe != nil {
+		return nil, statusInvalidParameter
+	}
+	VFS := req.sess.vfs
+	p := o.getPath()
+	type lockRange struct {
+		start, end int64
+		flags      uint32
+	}
+	var locks []lockRange
+	for i := 0; i < count; i++ {
+		offset := r.Uint64()
+		length := r.Uint64()
+		flags := r.Uint32()
+		r.Skip(4)
+		if offset > math.MaxInt64 {
+			return nil, statusInvalidParameter
+		}
+		end := int64(vfs.LockEOF)
+		if length <= uint64(vfs.LockEOF)-offset {
+			end = int64(offset + length)
+		}
+		locks = append(locks, lockRange{start: int64(offset), end: end, flags: flags})
+	}
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	for i, l := range locks {
+		if l.flags&lockFlagUnlock != 0 {
+			VFS.Unlock(p, o, l.start, l.end)
+			continue
+		}
+		if l.start == l.end {
+			// zero length locks never conflict
+			continue
+		}
+		err := VFS.Lock(p, vfs.Lock{
+			Owner: o,
+			Write: l.flags&lockFlagExclusive != 0,
+			Start: l.start,
+			End:   l.end,
+		})
+		if err != nil {
+			// release the locks taken so far
+			for _, taken := range locks[:i] {
+				if taken.flags&lockFlagUnlock == 0 {
+					VFS.Unlock(p, o, taken.start, taken.end)
+				}
+			}
+			return nil, statusLockNotGranted
+		}
+	}
+	var w smb2.Writer
+	w.Uint16(4)
+	w.Uint16(0)
+	return w.Bytes(), statusSuccess
+}
+
+// ioctl runs IOCTL
+func (req *request) ioctl() (body []byte, status ntStatus) {
+	c := req.c
+	r := req.r
+	r.Seek(headerSize + 4)
+	ctlCode := r.Uint32()
+	fileIDPos := r.Offset()
+	r.Skip(16)
+	inputOffset := int(r.Uint32())
+	inputCount := int(r.Uint32())
+	r.Seek(headerSize + 44)
+	maxOutput := int(r.Uint32())
+	input := r.At(inputOffset, inputCount)
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	var output []byte
+	status = statusSuccess
+	switch ctlCode {
+	case fsctlValidateNegotiateInfo:
+		c.mu.Lock()
+		dialect := c.dialect
+		c.mu.Unlock()
+		var caps uint32
+		if dialect != dialect202 {
+			caps = capLargeMTU
+		}
+		secMode := uint16(negotiateSigningEnabled)
+		if c.s.opt.User != "" {
+			secMode |= negotiateSigningRequired
+		}
+		var w smb2.Writer
+		w.Uint32(caps)
+		w.Append(c.s.guid[:])
+		w.Uint16(secMode)
+		w.Uint16(dialect)
+		output = w.Bytes()
+	case fsctlDfsGetReferrals:
+		return nil, statusFsDriverRequired
+	case fsctlPipeTransceive:
+		r.Seek(fileIDPos)
+		var o *openFile
+		o, status = req.getFile(r)
+		if status != statusSuccess {
+			return nil, status
+		}
+		if o.pipe == nil {
+			return nil, statusInvalidDeviceRequest
+		}
+		o.pipe.write(input)
+		output = o.pipe.read(maxOutput)
+		if o.pipe.pending() {
+			status = statusBufferOverflow
+		}
+	default:
+		return nil, statusInvalidDeviceRequest
+	}
+	if len(output) > maxOutput {
+		return nil, statusBufferTooSmall
+	}
+	const bufferOffset = headerSize + 48
+	var w smb2.Writer
+	w.Uint16(49)
+	w.Uint16(0)
+	w.Uint32(ctlCode)
+	w.Append(req.msg[fileIDPos : fileIDPos+16])
+	w.Uint32(bufferOffset) // input offset
+	w.Uint32(0)            // input count
+	w.Uint32(bufferOffset) // output offset
+	w.Uint32(uint32(len(output)))
+	w.Uint32(0) // flags
+	w.Uint32(0)
+	w.Append(output)
+	return w.Bytes(), status
+}
+
+// queryDirectory runs QUERY_DIRECTORY
+func (req *request) queryDirectory() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 2)
+	class := r.Uint8()
+	flags := r.Uint8()
+	r.Skip(4) // file index
+	o, status := req.getFile(r)
+	if status != statusSuccess {
+		return nil, status
+	}
+	nameOffset := int(r.Uint16())
+	nameLength := int(r.Uint16())
+	outputLength := int(r.Uint32())
+	var pattern string
+	if nameLength > 0 {
+		pattern = smb2.DecodeUTF16(r.At(nameOffset, nameLength))
+	}
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	if !o.isDir {
+		return nil, statusInvalidParameter
+	}
+	if !validDirClass(class) {
+		return nil, statusInvalidInfoClass
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	first := o.search == nil || flags&(restartScans|reopen) != 0
+	if first {
+		if pattern == "" {
+			pattern = "*"
+		}
+		entries, err := listDir(req.sess.vfs, o.path, pattern)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		o.search = &search{nodes: entries}
+	}
+	search := o.search
+	if search.pos >= len(search.nodes) {
+		if first {
+			return nil, statusNoSuchFile
+		}
+		return nil, statusNoMoreFiles
+	}
+	var w smb2.Writer
+	last := -1
+	for search.pos < len(search.nodes) {
+		end := w.Len()
+		if last >= 0 {
+			// entries start on 8 byte boundaries
+			w.Align(8)
+		}
+		start := w.Len()
+		writeDirEntry(&w, class, search.nodes[search.pos])
+		if w.Len() > outputLength {
+			w.Truncate(end)
+			break
+		}
+		if last >= 0 {
+			w.PutUint32(last, uint32(start-last))
+		}
+		last = start
+		search.pos++
+		if flags&returnSingleEntry != 0 {
+			break
+		}
+	}
+	if last < 0 {
+		return nil, statusInfoLengthMismatch
+	}
+	var resp smb2.Writer
+	resp.Uint16(9)
+	resp.Uint16(headerSize + 8)
+	resp.Uint32(uint32(w.Len()))
+	resp.Append(w.Bytes())
+	return resp.Bytes(), statusSuccess
+}
+
+// listDir lists the directory at dirPath returning the entries
+// which match pattern, including "." and ".."
+func listDir(VFS *vfs.VFS, dirPath, pattern string) (entries []dirEntry, err error) {
+	node, err := VFS.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return nil, vfs.EINVAL
+	}
+	parent, err := VFS.Stat(path.Dir("/" + dirPath))
+	if err != nil {
+		return nil, err
+	}
+	add := func(name string, node vfs.Node) {
+		if matchPattern(pattern, name) {
+			entries = append(entries, dirEntry{name: name, node: node})
+		}
+	}
+	add(".", dir)
+	add("..", parent)
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		add(node.Name(), node)
+	}
+	return entries, nil
+}
+
+// matchPattern returns whether name matches the wildcard pattern
+// case insensitively.
+//
+// As well as "*" and "?" the DOS wildcards "<", ">" and '"' are
+// treated as "*", "?" and "." respectively which is close enough
+// for the way clients use them.
+func matchPattern(pattern, name string) bool {
+	if pattern == "*" {
+		return true
+	}
+	p := []rune(strings.ToLower(pattern))
+	n := []rune(strings.ToLower(name))
+	var match func(p, n []rune) bool
+	match = func(p, n []rune) bool {
+		for len(p) > 0 {
+			switch p[0] {
+			case '*', '<':
+				for len(p) > 0 && (p[0] == '*' || p[0] == '<') {
+					p = p[1:]
+				}
+				if len(p) == 0 {
+					return true
+				}
+				for i := 0; i <= len(n); i++ {
+					if match(p, n[i:]) {
+						return true
+					}
+				}
+				return false
+			case '?', '>':
+				if len(n) == 0 || (p[0] == '>' && n[0] == '.') {
+					// DOS_QM matches nothing at a dot or the end
+					// of the name
+					if p[0] == '>' {
+						p = p[1:]
+						continue
+					}
+					return false
+				}
+			case '"':
+				if len(n) == 0 {
+					p = p[1:]
+					continue
+				}
+				if n[0] != '.' {
+					return false
+				}
+			default:
+				if len(n) == 0 || p[0] != n[0] {
+					return false
+				}
+			}
+			p, n = p[1:], n[1:]
+		}
+		return len(n) == 0
+	}
+	return match(p, n)
+}
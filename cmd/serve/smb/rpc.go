@@ -0,0 +1,364 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/lib/smb2"
+)
+
+// A minimal DCE/RPC server [C706] for the srvsvc named pipe, which
+// clients use to list the shares on the server [MS-SRVS].
+
+// DCE/RPC packet types
+const (
+	rpcRequest      = 0
+	rpcResponse     = 2
+	rpcFault        = 3
+	rpcBind         = 11
+	rpcBindAck      = 12
+	rpcAlterContext = 14
+	rpcAlterResp    = 15
+)
+
+// DCE/RPC constants
+const (
+	rpcHeaderSize       = 16
+	rpcFirstLastFrag    = 0x03
+	rpcMaxFrag          = 4280
+	rpcAcceptance       = 0
+	rpcProviderReject   = 2
+	rpcNegotiateAck     = 3
+	rpcReasonBadSyntax  = 2
+	rpcFaultOpRngError  = 0x1c010002
+	rpcFaultProtoError  = 0x1c01000b
+	srvsvcNetrShareEnum = 15
+	srvsvcShareGetInfo  = 16
+	srvsvcServerGetInfo = 21
+	werrorInvalidLevel  = 0x0000007C
+	werrorNetNameNotFnd = 0x00000906
+	shareTypeDiskTree   = 0x00000000
+	shareTypeIPC        = 0x80000003
+	platformIDNT        = 500
+	svTypeServer        = 0x00000002 | 0x00000001 | 0x00001000 // server, workstation, NT
+)
+
+var (
+	// NDR transfer syntax 8a885d04-1ceb-11c9-9fe8-08002b104860 v2
+	ndrSyntax = []byte{0x04, 0x5d, 0x88, 0x8a, 0xeb, 0x1c, 0xc9, 0x11, 0x9f, 0xe8, 0x08, 0x00, 0x2b, 0x10, 0x48, 0x60, 2, 0, 0, 0}
+	// srvsvc interface 4b324fc8-1670-01d3-1278-5a47bf6ee188 v3
+	srvsvcSyntax = []byte{0xc8, 0x4f, 0x32, 0x4b, 0x70, 0x16, 0xd3, 0x01, 0x12, 0x78, 0x5a, 0x47, 0xbf, 0x6e, 0xe1, 0x88, 3, 0, 0, 0}
+	// the start of the bind time feature negotiation syntax 6cb71c2c-9812-4540
+	btfnPrefix = []byte{0x2c, 0x1c, 0xb7, 0x6c, 0x12, 0x98, 0x40, 0x45}
+)
+
+// pipe is an open named pipe
+type pipe struct {
+	s *server
+
+	mu  sync.Mutex
+	out []byte // data waiting to be read
+}
+
+// newPipe opens the named pipe called name
+func newPipe(s *server, name string) (*pipe, ntStatus) {
+	name = strings.TrimPrefix(strings.Replace(name, "/", `\`, -1), `\`)
+	if !strings.EqualFold(name, "srvsvc") {
+		return nil, statusObjectNameNotFound
+	}
+	return &pipe{s: s}, statusSuccess
+}
+
+// write a DCE/RPC packet to the pipe, queueing the reply to be read
+func (p *pipe) write(data []byte) {
+	reply := p.s.rpcCall(data)
+	p.mu.Lock()
+	p.out = append(p.out, reply...)
+	p.mu.Unlock()
+}
+
+// read up to n bytes of the queued replies
+func (p *pipe) read(n int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > len(p.out) {
+		n = len(p.out)
+	}
+	data := p.out[:n]
+	p.out = p.out[n:]
+	return data
+}
+
+// pending returns whether there is reply data still to be read
+func (p *pipe) pending() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.out) > 0
+}
+
+// rpcCall runs the DCE/RPC packet in data returning the reply or nil
+func (s *server) rpcCall(data []byte) []byte {
+	r := smb2.NewReader(data)
+	version := r.Uint8()
+	r.Skip(1) // minor version
+	ptype := r.Uint8()
+	flags := r.Uint8()
+	drep := r.Uint32()
+	fragLength := int(r.Uint16())
+	r.Skip(2) // auth length
+	callID := r.Uint32()
+	if r.Err() != nil || version != 5 || drep&0xF0 != 0x10 || fragLength > len(data) || flags&rpcFirstLastFrag != rpcFirstLastFrag {
+		// only little endian single fragment packets are supported
+		return rpcPacket(rpcFault, callID, faultBody(rpcFaultProtoError))
+	}
+	r.Limit(fragLength)
+	switch ptype {
+	case rpcBind, rpcAlterContext:
+		return rpcBindReply(r, ptype, callID)
+	case rpcRequest:
+		r.Skip(4) // alloc hint
+		contextID := r.Uint16()
+		opnum := r.Uint16()
+		if r.Err() != nil {
+			break
+		}
+		stub, ok := s.srvsvc(opnum, smb2.NewReader(r.Rest()))
+		if !ok {
+			return rpcPacket(rpcFault, callID, faultBody(rpcFaultOpRngError))
+		}
+		var w smb2.Writer
+		w.Uint32(uint32(len(stub)))
+		w.Uint16(contextID)
+		w.Uint8(0) // cancel count
+		w.Uint8(0)
+		w.Append(stub)
+		return rpcPacket(rpcResponse, callID, w.Bytes())
+	}
+	return rpcPacket(rpcFault, callID, faultBody(rpcFaultProtoError))
+}
+
+// rpcPacket makes a DCE/RPC packet with body
+func rpcPacket(ptype uint8, callID uint32, body []byte) []byte {
+	var w smb2.Writer
+	w.Uint8(5)
+	w.Uint8(0)
+	w.Uint8(ptype)
+	w.Uint8(rpcFirstLastFrag)
+	w.Uint32(0x10) // little endian, ASCII, IEEE floats
+	w.Uint16(uint16(rpcHeaderSize + len(body)))
+	w.Uint16(0) // auth length
+	w.Uint32(callID)
+	w.Append(body)
+	return w.Bytes()
+}
+
+// faultBody makes the body of a fault packet
+func faultBody(status uint32) []byte {
+	var w smb2.Writer
+	w.Uint32(0) // alloc hint
+	w.Uint16(0) // context id
+	w.Uint8(0)  // cancel count
+	w.Uint8(0)
+	w.Uint32(status)
+	w.Uint32(0)
+	return w.Bytes()
+}
+
+// rpcBindReply replies to a BIND or ALTER_CONTEXT accepting the
+// srvsvc interface with the NDR transfer syntax
+func rpcBindReply(r *smb2.Reader, ptype uint8, callID uint32) []byte {
+	r.Skip(4) // max xmit and recv frag
+	assocGroup := r.Uint32()
+	count := int(r.Uint8())
+	r.Skip(3)
+	var results smb2.Writer
+	for i := 0; i < count; i++ {
+		r.Skip(2) // context id
+		syntaxes := int(r.Uint8())
+		r.Skip(1)
+		abstract := r.Next(20)
+		result, reason := uint16(rpcProviderReject), uint16(rpcReasonBadSyntax)
+		syntax := make([]byte, 20)
+		for j := 0; j < syntaxes; j++ {
+			transfer := r.Next(20)
+			switch {
+			case bytes.HasPrefix(transfer, btfnPrefix) && result != rpcAcceptance:
+				result, reason = rpcNegotiateAck, 0
+			case bytes.Equal(transfer, ndrSyntax) && bytes.Equal(abstract, srvsvcSyntax):
+				result, reason = rpcAcceptance, 0
+				copy(syntax, ndrSyntax)
+			}
+		}
+		results.Uint16(result)
+		results.Uint16(reason)
+		results.Append(syntax)
+	}
+	if r.Err() != nil {
+		return rpcPacket(rpcFault, callID, faultBody(rpcFaultProtoError))
+	}
+	if assocGroup == 0 {
+		assocGroup = 0x12345
+	}
+	var w smb2.Writer
+	w.Uint16(rpcMaxFrag)
+	w.Uint16(rpcMaxFrag)
+	w.Uint32(assocGroup)
+	replyType := uint8(rpcAlterResp)
+	if ptype == rpcBind {
+		replyType = rpcBindAck
+		port := []byte(`\PIPE\srvsvc` + "\x00")
+		w.Uint16(uint16(len(port)))
+		w.Append(port)
+	} else {
+		w.Uint16(0)
+	}
+	// align from the start of the packet
+	for (rpcHeaderSize+w.Len())%4 != 0 {
+		w.Uint8(0)
+	}
+	w.Uint8(uint8(count))
+	w.Zero(3)
+	w.Append(results.Bytes())
+	return rpcPacket(replyType, callID, w.Bytes())
+}
+
+// share is a share listed by srvsvc
+type share struct {
+	name      string
+	shareType uint32
+	remark    string
+}
+
+// shares returns the shares on the server
+func (s *server) shares() []share {
+	return []share{
+		{name: s.opt.ShareName, shareType: shareTypeDiskTree, remark: "rclone " + s.f.Name() + ":" + s.f.Root()},
+		{name: "IPC$", shareType: shareTypeIPC, remark: "IPC Service"},
+	}
+}
+
+// writeShareInfo writes the fixed part of a SHARE_INFO_0 or _1
+func writeShareInfo(w *smb2.NDRWriter, level uint32, sh share) {
+	w.Pointer()
+	if level == 1 {
+		w.Uint32(sh.shareType)
+		w.Pointer()
+	}
+}
+
+// writeShareStrings writes the deferred strings of a SHARE_INFO_0 or _1
+func writeShareStrings(w *smb2.NDRWriter, level uint32, sh share) {
+	w.String(sh.name)
+	if level == 1 {
+		w.String(sh.remark)
+	}
+}
+
+// srvsvc runs the srvsvc call opnum with the arguments in r
+// returning the reply stub data
+func (s *server) srvsvc(opnum uint16, r *smb2.Reader) (stub []byte, ok bool) {
+	var w smb2.NDRWriter
+	switch opnum {
+	case srvsvcNetrShareEnum:
+		if r.Uint32() != 0 {
+			_ = smb2.ReadNDRString(r) // server name
+		}
+		level := r.Uint32()
+		if r.Err() != nil {
+			return nil, false
+		}
+		shares := s.shares()
+		w.Uint32(level)
+		w.Uint32(level) // union discriminant
+		if level != 0 && level != 1 {
+			w.Uint32(0) // null container
+			w.Uint32(0) // total entries
+			w.Uint32(0) // null resume handle
+			w.Uint32(werrorInvalidLevel)
+			return w.Bytes(), true
+		}
+		w.Pointer() // container
+		w.Uint32(uint32(len(shares)))
+		w.Pointer() // array
+		w.Uint32(uint32(len(shares)))
+		for _, sh := range shares {
+			writeShareInfo(&w, level, sh)
+		}
+		for _, sh := range shares {
+			writeShareStrings(&w, level, sh)
+		}
+		w.Uint32(uint32(len(shares))) // total entries
+		w.Pointer()                   // resume handle
+		w.Uint32(0)
+		w.Uint32(0) // WERROR
+	case srvsvcShareGetInfo:
+		if r.Uint32() != 0 {
+			_ = smb2.ReadNDRString(r) // server name
+		}
+		name := smb2.ReadNDRString(r)
+		level := r.Uint32()
+		if r.Err() != nil {
+			return nil, false
+		}
+		var found *share
+		for _, sh := range s.shares() {
+			if strings.EqualFold(sh.name, name) {
+				sh := sh
+				found = &sh
+				break
+			}
+		}
+		w.Uint32(level)
+		switch {
+		case found == nil:
+			w.Uint32(0)
+			w.Uint32(werrorNetNameNotFnd)
+		case level == 0 || level == 1:
+			w.Pointer()
+			writeShareInfo(&w, level, *found)
+			writeShareStrings(&w, level, *found)
+			w.Uint32(0)
+		case level == 1005:
+			w.Pointer()
+			w.Uint32(0) // flags
+			w.Uint32(0)
+		default:
+			w.Uint32(0)
+			w.Uint32(werrorInvalidLevel)
+		}
+	case srvsvcServerGetInfo:
+		if r.Uint32() != 0 {
+			_ = smb2.ReadNDRString(r) // server name
+		}
+		level := r.Uint32()
+		if r.Err() != nil {
+			return nil, false
+		}
+		w.Uint32(level)
+		if level != 100 && level != 101 {
+			w.Uint32(0)
+			w.Uint32(werrorInvalidLevel)
+			break
+		}
+		w.Pointer()
+		w.Uint32(platformIDNT)
+		w.Pointer() // name
+		if level == 101 {
+			w.Uint32(6) // major version
+			w.Uint32(1) // minor version
+			w.Uint32(svTypeServer)
+			w.Pointer() // comment
+		}
+		w.String(ntlmServerName)
+		if level == 101 {
+			w.String("rclone")
+		}
+		w.Uint32(0)
+	default:
+		return nil, false
+	}
+	return w.Bytes(), true
+}
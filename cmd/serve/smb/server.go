@@ -0,0 +1,770 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+)
+
+const (
+	maxIO         = 1024 * 1024     // largest READ, WRITE or transaction with LARGE_MTU
+	maxSmallIO    = 64 * 1024       // largest READ, WRITE or transaction without
+	maxFrameSize  = maxIO + 64*1024 // largest message accepted
+	maxCredits    = 512             // most credits granted in one response
+	maxInFlight   = 64              // most requests run at once on a connection
+	writeTimeout  = 5 * time.Minute // time allowed to write a response
+	maxNameLength = 255             // longest file name
+)
+
+// server contains everything to run the server
+type server struct {
+	f         fs.Fs
+	opt       Options
+	vfs       *vfs.VFS
+	authDB    *auth.DB                          // user database if set
+	ntHash    func(user string) ([]byte, error) // finds the NT hash of a user logging in - nil for guests
+	listener  net.Listener
+	waitChan  chan struct{} // for waiting on the listener to close
+	guid      [16]byte      // server GUID
+	startTime time.Time
+
+	mu    sync.Mutex         // protects the following
+	conns map[*conn]struct{} // open connections
+}
+
+// newServer makes a new server serving f, checking its users with the
+// user database if authOpt enables it
+func newServer(ctx context.Context, f fs.Fs, opt *Options, authOpt *auth.Options) (*server, error) {
+	s := &server{
+		f:         f,
+		opt:       *opt,
+		vfs:       vfs.New(f, &vfsflags.Opt),
+		waitChan:  make(chan struct{}),
+		startTime: time.Now(),
+		conns:     make(map[*conn]struct{}),
+	}
+	_, err := io.ReadFull(rand.Reader, s.guid[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make server GUID")
+	}
+	switch {
+	case authOpt != nil && authOpt.Enabled():
+		if opt.User != "" {
+			return nil, errors.New("can't use --user with the user database")
+		}
+		if authOpt.LDAPURL != "" || authOpt.OIDCIssuer != "" {
+			return nil, errors.New("can't use --auth-ldap-url or --auth-oidc-issuer with serve smb as they don't provide the NT hash")
+		}
+		s.authDB, err = auth.New(ctx, f, authOpt)
+		if err != nil {
+			return nil, err
+		}
+		s.ntHash = s.authDB.NTHash
+	case opt.User != "":
+		ntHash := auth.NTHash(opt.Pass)
+		s.ntHash = func(user string) ([]byte, error) {
+			if !strings.EqualFold(user, opt.User) {
+				return nil, errors.Errorf("unknown user %q", user)
+			}
+			return ntHash, nil
+		}
+	}
+	return s, nil
+}
+
+// serve starts the server listening
+func (s *server) serve() (err error) {
+	s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for connection")
+	}
+	fs.Logf(nil, "SMB server listening on %v\n", s.listener.Addr())
+	go s.acceptConnections()
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Serve runs the SMB server in the background.
+//
+// Use s.Close() and s.Wait() to shutdown server
+func (s *server) Serve() error {
+	return s.serve()
+}
+
+// Wait blocks while the listener is open.
+func (s *server) Wait() {
+	<-s.waitChan
+}
+
+// Close shuts the running server down
+func (s *server) Close() {
+	err := s.listener.Close()
+	if err != nil {
+		fs.Errorf(nil, "Error on closing SMB server: %v", err)
+	}
+}
+
+// acceptConnections accepts connections until the listener is closed
+// then shuts everything down
+func (s *server) acceptConnections() {
+	var wg sync.WaitGroup
+	for {
+		nConn, err := s.listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			fs.Errorf(nil, "Failed to accept incoming connection: %v", err)
+			continue
+		}
+		c := &conn{
+			s:        s,
+			c:        nConn,
+			what:     nConn.RemoteAddr().String(),
+			sem:      make(chan struct{}, maxInFlight),
+			sessions: make(map[uint64]*session),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		fs.Infof(c.what, "SMB client connected")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+
+	// Close the connections and wait for them to finish
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.c.Close()
+	}
+	s.mu.Unlock()
+	wg.Wait()
+	close(s.waitChan)
+}
+
+// conn is a connection from an SMB client
+type conn struct {
+	s    *server
+	c    net.Conn
+	what string
+	sem  chan struct{} // limits the number of requests being run
+	wmu  sync.Mutex    // serialises writing responses
+
+	mu            sync.Mutex // protects the following
+	dialect       uint16     // dialect negotiated or 0
+	clientGUID    [16]byte
+	clientSecMode uint16
+	clientCaps    uint32
+	preauthHash   []byte              // preauth integrity hash of the negotiate for 3.1.1
+	sessions      map[uint64]*session // sessions by id
+	nextID        uint64              // for making unique ids
+}
+
+// newID returns a new id unique on the connection
+func (c *conn) newID() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return c.nextID
+}
+
+// serve reads the messages from the connection and runs them,
+// writing the responses as they finish.
+//
+// NEGOTIATE and SESSION_SETUP change the state of the connection so
+// they are run before reading the next message, the others are run in
+// the background.
+func (c *conn) serve() {
+	var wg sync.WaitGroup
+	br := bufio.NewReader(c.c)
+	for {
+		frame, err := readFrame(br)
+		if err != nil {
+			if err != io.EOF {
+				fs.Debugf(c.what, "SMB connection closed: %v", err)
+			}
+			break
+		}
+		if len(frame) >= 4 && string(frame[:4]) == "\xffSMB" {
+			err = c.smb1Negotiate(frame)
+			if err != nil {
+				fs.Debugf(c.what, "SMB1 negotiate failed: %v", err)
+				break
+			}
+			continue
+		}
+		if len(frame) < headerSize || string(frame[:4]) != "\xfeSMB" {
+			fs.Debugf(c.what, "SMB unsupported message - closing connection")
+			break
+		}
+		command := binary.LittleEndian.Uint16(frame[offCommand:])
+		if command == cmdNegotiate || command == cmdSessionSetup {
+			c.handleFrame(frame)
+			continue
+		}
+		c.sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-c.sem
+				wg.Done()
+			}()
+			c.handleFrame(frame)
+		}()
+	}
+	wg.Wait()
+	_ = c.c.Close()
+	c.closeSessions()
+	fs.Infof(c.what, "SMB client disconnected")
+}
+
+// closeSessions closes all the sessions on the connection
+func (c *conn) closeSessions() {
+	c.mu.Lock()
+	sessions := c.sessions
+	c.sessions = make(map[uint64]*session)
+	c.mu.Unlock()
+	for _, sess := range sessions {
+		sess.close()
+	}
+}
+
+// readFrame reads a message framed by the Direct TCP transport
+func readFrame(r io.Reader) (frame []byte, err error) {
+	var header [4]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.Errorf("bad frame type %d", header[0])
+	}
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if size > maxFrameSize {
+		return nil, errors.Errorf("message too big (%d bytes)", size)
+	}
+	frame = make([]byte, size)
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// writeFrame writes the message framed by the Direct TCP transport
+func (c *conn) writeFrame(msg []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	buf := make([]byte, 4, 4+len(msg))
+	buf[1] = byte(len(msg) >> 16)
+	buf[2] = byte(len(msg) >> 8)
+	buf[3] = byte(len(msg))
+	buf = append(buf, msg...)
+	_ = c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.c.Write(buf)
+	return err
+}
+
+// request is a single SMB2 message from the client
+type request struct {
+	c         *conn
+	msg       []byte // the whole message including the header
+	r         *smb2.Reader
+	command   uint16
+	flags     uint32
+	messageID uint64
+	sessionID uint64
+	treeID    uint32
+	sess      *session  // session if the request is in one
+	tree      *tree     // tree connect if the request is in one
+	fileID    *[16]byte // file id used by the last related request
+	signed    bool      // set if the request was signed
+
+	// called with the response before it is signed
+	onResponse func(resp []byte)
+	// set to sign the response even if the request wasn't signed
+	alwaysSign bool
+}
+
+// handler is a function which runs a request returning the body of
+// the response and its status.
+//
+// If the status is an error and body is nil then an error response is
+// made.
+type handler func(req *request) (body []byte, status ntStatus)
+
+// handlers indexed by command
+var handlers map[uint16]handler
+
+func init() {
+	handlers = map[uint16]handler{
+		cmdNegotiate:      (*request).negotiate,
+		cmdSessionSetup:   (*request).sessionSetup,
+		cmdLogoff:         (*request).logoff,
+		cmdTreeConnect:    (*request).treeConnect,
+		cmdTreeDisconnect: (*request).treeDisconnect,
+		cmdCreate:         (*request).create,
+		cmdClose:          (*request).close,
+		cmdFlush:          (*request).flush,
+		cmdRead:           (*request).read,
+		cmdWrite:          (*request).write,
+		cmdLock:           (*request).lock,
+		cmdIoctl:          (*request).ioctl,
+		cmdEcho:           (*request).echo,
+		cmdQueryDirectory: (*request).queryDirectory,
+		cmdQueryInfo:      (*request).queryInfo,
+		cmdSetInfo:        (*request).setInfo,
+	}
+}
+
+// noSession is the commands which don't need a session
+var noSession = map[uint16]bool{
+	cmdNegotiate:    true,
+	cmdSessionSetup: true,
+	cmdEcho:         true,
+}
+
+// needsTree is the commands which need a tree connect
+var needsTree = map[uint16]bool{
+	cmdTreeDisconnect: true,
+	cmdCreate:         true,
+	cmdClose:          true,
+	cmdFlush:          true,
+	cmdRead:           true,
+	cmdWrite:          true,
+	cmdLock:           true,
+	cmdIoctl:          true,
+	cmdQueryDirectory: true,
+	cmdQueryInfo:      true,
+	cmdSetInfo:        true,
+}
+
+// handleFrame runs the chain of compounded requests in frame and
+// writes the responses
+func (c *conn) handleFrame(frame []byte) {
+	var (
+		out        []byte
+		prev       *request
+		prevStatus = statusSuccess
+		lastFileID [16]byte
+	)
+	for len(frame) > 0 {
+		if len(frame) < headerSize || string(frame[:4]) != "\xfeSMB" {
+			fs.Debugf(c.what, "SMB badly formed compound request")
+			_ = c.c.Close()
+			return
+		}
+		msg := frame
+		next := binary.LittleEndian.Uint32(frame[offNextCommand:])
+		if next != 0 {
+			if next < headerSize || int(next) > len(frame) || next%8 != 0 {
+				fs.Debugf(c.what, "SMB bad next command offset %d", next)
+				_ = c.c.Close()
+				return
+			}
+			msg = frame[:next]
+		}
+		frame = frame[len(msg):]
+
+		req := &request{
+			c:         c,
+			msg:       msg,
+			r:         smb2.NewReader(msg),
+			command:   binary.LittleEndian.Uint16(msg[offCommand:]),
+			flags:     binary.LittleEndian.Uint32(msg[offFlags:]),
+			messageID: binary.LittleEndian.Uint64(msg[offMessageID:]),
+			sessionID: binary.LittleEndian.Uint64(msg[offSessionID:]),
+			treeID:    binary.LittleEndian.Uint32(msg[offTreeID:]),
+			fileID:    &lastFileID,
+		}
+		req.r.Seek(headerSize)
+		related := req.flags&flagsRelatedOperations != 0
+		if related && prev != nil {
+			req.sessionID = prev.sessionID
+			req.treeID = prev.treeID
+		}
+
+		var body []byte
+		var status ntStatus
+		if related && prev == nil {
+			status = statusInvalidParameter
+		} else if related && isError(prevStatus) {
+			// related requests fail if the one before them did
+			req.sess = prev.sess
+			status = prevStatus
+		} else {
+			body, status = c.dispatch(req)
+		}
+		if req.command == cmdCancel {
+			// CANCEL has no response
+			prev = req
+			continue
+		}
+		resp := c.response(req, body, status)
+		if next != 0 && len(frame) > 0 {
+			// pad to 8 bytes and link to the next response
+			for len(resp)%8 != 0 {
+				resp = append(resp, 0)
+			}
+		}
+		if len(frame) > 0 {
+			binary.LittleEndian.PutUint32(resp[offNextCommand:], uint32(len(resp)))
+		}
+		if req.onResponse != nil {
+			req.onResponse(resp)
+		}
+		if req.sess != nil && req.sess.signingKey != nil && (req.signed || req.alwaysSign || req.sess.signingRequired) {
+			sign(c.dialect, req.sess.signingKey, resp)
+		}
+		out = append(out, resp...)
+		prev = req
+		prevStatus = status
+	}
+	if len(out) == 0 {
+		return
+	}
+	err := c.writeFrame(out)
+	if err != nil {
+		fs.Debugf(c.what, "SMB failed to write response: %v", err)
+		_ = c.c.Close()
+	}
+}
+
+// isError returns whether status is an error rather than success or
+// a warning
+func isError(status ntStatus) bool {
+	return status>>30 == 3
+}
+
+// dispatch checks the session and tree of the request and runs it
+func (c *conn) dispatch(req *request) (body []byte, status ntStatus) {
+	if req.command == cmdCancel {
+		return nil, statusSuccess
+	}
+	h, ok := handlers[req.command]
+	if !ok {
+		return nil, statusNotSupported
+	}
+	c.mu.Lock()
+	dialect := c.dialect
+	c.mu.Unlock()
+	if dialect == 0 && req.command != cmdNegotiate {
+		return nil, statusInvalidParameter
+	}
+	if !noSession[req.command] || (req.command == cmdEcho && req.sessionID != 0) {
+		c.mu.Lock()
+		sess := c.sessions[req.sessionID]
+		c.mu.Unlock()
+		if sess == nil || !sess.isValid() {
+			return nil, statusUserSessionDeleted
+		}
+		req.sess = sess
+		if status := req.checkSignature(); status != statusSuccess {
+			return nil, status
+		}
+	}
+	if needsTree[req.command] {
+		req.tree = req.sess.getTree(req.treeID)
+		if req.tree == nil {
+			return nil, statusNetworkNameDeleted
+		}
+	}
+	return h(req)
+}
+
+// checkSignature verifies the signature of a request in a session
+func (req *request) checkSignature() ntStatus {
+	sess := req.sess
+	if req.flags&flagsSigned != 0 {
+		if sess.signingKey == nil {
+			return statusSuccess
+		}
+		if !verify(req.c.dialect, sess.signingKey, req.msg) {
+			fs.Debugf(req.c.what, "SMB bad signature on message %d", req.messageID)
+			return statusAccessDenied
+		}
+		req.signed = true
+	} else if sess.signingRequired {
+		fs.Debugf(req.c.what, "SMB unsigned message %d in session which requires signing", req.messageID)
+		return statusAccessDenied
+	}
+	return statusSuccess
+}
+
+// response makes the response to req
+func (c *conn) response(req *request, body []byte, status ntStatus) []byte {
+	if body == nil && status != statusSuccess {
+		body = errorBody(nil)
+	}
+	w := smb2.NewWriter(headerSize + len(body))
+	w.Append([]byte("\xfeSMB"))
+	w.Uint16(headerSize)
+	w.Uint16(binary.LittleEndian.Uint16(req.msg[6:])) // credit charge
+	w.Uint32(uint32(status))
+	w.Uint16(req.command)
+	credits := binary.LittleEndian.Uint16(req.msg[offCredit:])
+	if credits == 0 {
+		credits = 1
+	} else if credits > maxCredits {
+		credits = maxCredits
+	}
+	w.Uint16(credits)
+	w.Uint32(flagsServerToRedir | req.flags&flagsRelatedOperations)
+	w.Uint32(0) // next command
+	w.Uint64(req.messageID)
+	w.Uint32(0) // reserved
+	w.Uint32(req.treeID)
+	w.Uint64(req.sessionID)
+	w.Zero(signatureLength)
+	w.Append(body)
+	return w.Bytes()
+}
+
+// errorBody makes an error response body with data
+func errorBody(data []byte) []byte {
+	var w smb2.Writer
+	w.Uint16(9)
+	w.Uint8(0) // context count
+	w.Uint8(0)
+	w.Uint32(uint32(len(data)))
+	if len(data) == 0 {
+		w.Uint8(0)
+	} else {
+		w.Append(data)
+	}
+	return w.Bytes()
+}
+
+// smb1Negotiate answers an SMB1 NEGOTIATE by negotiating SMB2
+// instead, which clients offering both use to upgrade the connection
+func (c *conn) smb1Negotiate(frame []byte) error {
+	const smb1HeaderSize = 32
+	if len(frame) < smb1HeaderSize+3 || frame[4] != 0x72 {
+		return errors.New("not an SMB1 NEGOTIATE")
+	}
+	c.mu.Lock()
+	negotiated := c.dialect != 0
+	c.mu.Unlock()
+	if negotiated {
+		return errors.New("SMB1 NEGOTIATE after dialect negotiated")
+	}
+	r := smb2.NewReader(frame)
+	r.Seek(smb1HeaderSize)
+	r.Skip(int(r.Uint8()) * 2) // words
+	data := r.Next(int(r.Uint16()))
+	if r.Err() != nil {
+		return r.Err()
+	}
+	var dialect uint16
+	for _, name := range strings.Split(string(data), "\x00") {
+		name = strings.TrimPrefix(name, "\x02")
+		switch name {
+		case "SMB 2.???":
+			dialect = dialectWildcard
+		case "SMB 2.002":
+			if dialect == 0 {
+				dialect = dialect202
+			}
+		}
+	}
+	if dialect == 0 {
+		return errors.New("client doesn't support SMB2")
+	}
+	if dialect == dialect202 {
+		c.mu.Lock()
+		c.dialect = dialect
+		c.mu.Unlock()
+	}
+	req := &request{
+		c:   c,
+		msg: make([]byte, headerSize),
+	}
+	body := c.negotiateResponse(dialect, 0, nil)
+	return c.writeFrame(c.response(req, body, statusSuccess))
+}
+
+// negotiate runs NEGOTIATE
+func (req *request) negotiate() (body []byte, status ntStatus) {
+	c := req.c
+	r := req.r
+	r.Skip(2) // structure size
+	dialectCount := int(r.Uint16())
+	secMode := r.Uint16()
+	r.Skip(2)
+	caps := r.Uint32()
+	guid := r.Next(16)
+	ctxOffset := int(r.Uint32())
+	clientCtxCount := int(r.Uint16())
+	r.Skip(2)
+	var clientDialects []uint16
+	for i := 0; i < dialectCount; i++ {
+		clientDialects = append(clientDialects, r.Uint16())
+	}
+	if r.Err() != nil || dialectCount == 0 {
+		return nil, statusInvalidParameter
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dialect != 0 {
+		// renegotiation isn't allowed
+		_ = c.c.Close()
+		return nil, statusInvalidParameter
+	}
+	var dialect uint16
+found:
+	for _, d := range dialects {
+		for _, cd := range clientDialects {
+			if d == cd {
+				dialect = d
+				break found
+			}
+		}
+	}
+	if dialect == 0 {
+		return nil, statusNotSupported
+	}
+	c.dialect = dialect
+	c.clientSecMode = secMode
+	c.clientCaps = caps
+	copy(c.clientGUID[:], guid)
+
+	var (
+		ctxCount uint16
+		contexts []byte
+	)
+	if dialect == dialect311 {
+		var sentSigning bool
+		r.Seek(ctxOffset)
+		for i := 0; i < clientCtxCount; i++ {
+			r.Align(8)
+			ctxType := r.Uint16()
+			length := int(r.Uint16())
+			r.Skip(4)
+			r.Skip(length)
+			if ctxType == ctxSigning {
+				sentSigning = true
+			}
+		}
+		if r.Err() != nil {
+			return nil, statusInvalidParameter
+		}
+		ctxCount, contexts = negotiateContexts(sentSigning)
+		c.preauthHash = preauthHash(make([]byte, sha512.Size), req.msg)
+		req.onResponse = func(resp []byte) {
+			c.mu.Lock()
+			c.preauthHash = preauthHash(c.preauthHash, resp)
+			c.mu.Unlock()
+		}
+	}
+	return c.negotiateResponse(dialect, ctxCount, contexts), statusSuccess
+}
+
+// negotiateContexts makes the negotiate contexts for a 3.1.1
+// NEGOTIATE response
+func negotiateContexts(signing bool) (count uint16, contexts []byte) {
+	var w smb2.Writer
+	count = 1
+	salt := make([]byte, 32)
+	_, _ = io.ReadFull(rand.Reader, salt)
+	w.Uint16(ctxPreauthIntegrity)
+	w.Uint16(uint16(6 + len(salt)))
+	w.Uint32(0)
+	w.Uint16(1) // hash algorithm count
+	w.Uint16(uint16(len(salt)))
+	w.Uint16(hashSHA512)
+	w.Append(salt)
+	if signing {
+		w.Align(8)
+		w.Uint16(ctxSigning)
+		w.Uint16(4)
+		w.Uint32(0)
+		w.Uint16(1) // signing algorithm count
+		w.Uint16(signingAESCMAC)
+		count++
+	}
+	return count, w.Bytes()
+}
+
+// negotiateResponse makes the body of a NEGOTIATE response for
+// dialect with the negotiate contexts given
+func (c *conn) negotiateResponse(dialect uint16, ctxCount uint16, contexts []byte) []byte {
+	s := c.s
+	secMode := uint16(negotiateSigningEnabled)
+	if s.ntHash != nil {
+		secMode |= negotiateSigningRequired
+	}
+	var caps uint32
+	maxSize := uint32(maxSmallIO)
+	if dialect != dialect202 {
+		caps |= capLargeMTU
+		maxSize = maxIO
+	}
+	token := negTokenInit()
+	const bufferOffset = headerSize + 64
+	var w smb2.Writer
+	w.Uint16(65)
+	w.Uint16(secMode)
+	w.Uint16(dialect)
+	w.Uint16(ctxCount)
+	w.Append(s.guid[:])
+	w.Uint32(caps)
+	w.Uint32(maxSize) // max transact
+	w.Uint32(maxSize) // max read
+	w.Uint32(maxSize) // max write
+	w.Uint64(smb2.ToFiletime(time.Now()))
+	w.Uint64(0) // server start time
+	w.Uint16(bufferOffset)
+	w.Uint16(uint16(len(token)))
+	ctxOffsetPos := w.Len()
+	w.Uint32(0)
+	w.Append(token)
+	if ctxCount != 0 {
+		w.Align(8)
+		w.PutUint32(ctxOffsetPos, uint32(headerSize+w.Len()))
+		w.Append(contexts)
+	}
+	return w.Bytes()
+}
+
+// preauthHash returns the preauth integrity hash of msg following on
+// from hash
+func preauthHash(hash, msg []byte) []byte {
+	h := sha512.New()
+	_, _ = h.Write(hash)
+	_, _ = h.Write(msg)
+	return h.Sum(nil)
+}
+
+// echo runs ECHO
+func (req *request) echo() (body []byte, status ntStatus) {
+	var w smb2.Writer
+	w.Uint16(4)
+	w.Uint16(0)
+	return w.Bytes(), statusSuccess
+}
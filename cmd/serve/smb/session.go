@@ -0,0 +1,301 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs"
+)
+
+// session is an authenticated user on a connection
+type session struct {
+	id uint64
+	c  *conn
+
+	mu              sync.Mutex           // protects the following
+	auth            *ntlmAuth            // authentication in progress
+	preauthHash     []byte               // preauth integrity hash of the session setup for 3.1.1
+	valid           bool                 // set once authenticated
+	user            string               // user logged in
+	authUser        *auth.User           // user from the user database if set
+	vfs             *vfs.VFS             // what the session is served
+	guest           bool                 // set for guest and anonymous sessions
+	signingKey      []byte               // key for signing messages, nil if not signing
+	signingRequired bool                 // set if all messages must be signed
+	trees           map[uint32]*tree     // tree connects by id
+	files           map[uint64]*openFile // open files by id
+}
+
+// tree is a connection to a share in a session
+type tree struct {
+	id   uint32
+	pipe bool // set for the IPC$ share
+}
+
+// isValid returns whether the session has been authenticated
+func (sess *session) isValid() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.valid
+}
+
+// wait waits until n bytes may be transferred within the bandwidth
+// limit of the user of the session if any
+func (sess *session) wait(n int) {
+	if sess.authUser != nil {
+		_ = sess.authUser.Wait(context.Background(), n)
+	}
+}
+
+// getTree returns the tree connect with id or nil if not found
+func (sess *session) getTree(id uint32) *tree {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.trees[id]
+}
+
+// addFile adds o to the files open in the session
+func (sess *session) addFile(o *openFile) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.files[o.id] = o
+}
+
+// removeFile removes o from the files open in the session returning
+// false if it wasn't found
+func (sess *session) removeFile(o *openFile) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.files[o.id] != o {
+		return false
+	}
+	delete(sess.files, o.id)
+	return true
+}
+
+// removeTree removes the tree connect t and returns the files which
+// were open on it
+func (sess *session) removeTree(t *tree) (files []*openFile) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	delete(sess.trees, t.id)
+	for id, o := range sess.files {
+		if o.tree == t {
+			files = append(files, o)
+			delete(sess.files, id)
+		}
+	}
+	return files
+}
+
+// close the session, closing all its open files
+func (sess *session) close() {
+	sess.mu.Lock()
+	var files []*openFile
+	for _, o := range sess.files {
+		files = append(files, o)
+	}
+	sess.files = make(map[uint64]*openFile)
+	sess.trees = make(map[uint32]*tree)
+	sess.valid = false
+	sess.mu.Unlock()
+	closeFiles(files)
+}
+
+// closeFiles closes the files, logging any errors
+func closeFiles(files []*openFile) {
+	for _, o := range files {
+		err := o.close()
+		if err != nil {
+			fs.Errorf(o.path, "serve smb: failed to close file: %v", err)
+		}
+	}
+}
+
+// removeSession removes the session with id from the connection
+func (c *conn) removeSession(id uint64) *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sess := c.sessions[id]
+	delete(c.sessions, id)
+	return sess
+}
+
+// sessionSetup runs SESSION_SETUP
+func (req *request) sessionSetup() (body []byte, status ntStatus) {
+	c := req.c
+	s := c.s
+	r := req.r
+	r.Seek(headerSize + 12)
+	bufferOffset := int(r.Uint16())
+	bufferLength := int(r.Uint16())
+	token := r.At(bufferOffset, bufferLength)
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+
+	c.mu.Lock()
+	dialect := c.dialect
+	var sess *session
+	if req.sessionID == 0 {
+		c.nextID++
+		sess = &session{
+			id:    c.nextID,
+			c:     c,
+			trees: make(map[uint32]*tree),
+			files: make(map[uint64]*openFile),
+		}
+		if dialect == dialect311 {
+			sess.preauthHash = c.preauthHash
+		}
+		c.sessions[sess.id] = sess
+		req.sessionID = sess.id
+	} else {
+		sess = c.sessions[req.sessionID]
+	}
+	c.mu.Unlock()
+	if sess == nil {
+		return nil, statusUserSessionDeleted
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.auth == nil {
+		// a new session or the re-authentication of an existing one
+		sess.auth = newNTLMAuth(s.ntHash)
+	}
+	if dialect == dialect311 && !sess.valid {
+		sess.preauthHash = preauthHash(sess.preauthHash, req.msg)
+	}
+	out, res, status := sess.auth.step(token)
+	var sessionFlags uint16
+	switch status {
+	case statusMoreProcessingRequired:
+		if dialect == dialect311 && !sess.valid {
+			req.onResponse = func(resp []byte) {
+				sess.mu.Lock()
+				sess.preauthHash = preauthHash(sess.preauthHash, resp)
+				sess.mu.Unlock()
+			}
+		}
+	case statusSuccess:
+		sess.auth = nil
+		if res.anonymous {
+			sessionFlags = sessionFlagIsNull
+		} else if res.guest {
+			sessionFlags = sessionFlagIsGuest
+		}
+		if !sess.valid {
+			sess.user = res.user
+			sess.guest = res.guest || res.anonymous
+			sess.vfs = s.vfs
+			if s.authDB != nil {
+				u, err := s.authDB.User(res.user)
+				if err != nil {
+					fs.Errorf(c.what, "SMB session setup for user %q failed: %v", res.user, err)
+					c.removeSession(sess.id)
+					return nil, statusLogonFailure
+				}
+				sess.authUser = u
+				sess.vfs = u.VFS
+			}
+			if res.sessionKey != nil {
+				sess.signingKey = signingKey(dialect, res.sessionKey, sess.preauthHash)
+				sess.signingRequired = true
+				req.alwaysSign = true
+			}
+			sess.valid = true
+			if sess.guest {
+				fs.Infof(c.what, "SMB guest session started")
+			} else {
+				fs.Infof(c.what, "SMB session started for user %q", sess.user)
+			}
+		}
+		req.sess = sess
+	default:
+		sess.auth = nil
+		if !sess.valid {
+			c.removeSession(sess.id)
+		}
+	}
+	if status != statusSuccess && status != statusMoreProcessingRequired {
+		return nil, status
+	}
+	var w smb2.Writer
+	w.Uint16(9)
+	w.Uint16(sessionFlags)
+	w.Uint16(headerSize + 8)
+	w.Uint16(uint16(len(out)))
+	w.Append(out)
+	return w.Bytes(), status
+}
+
+// logoff runs LOGOFF
+func (req *request) logoff() (body []byte, status ntStatus) {
+	sess := req.c.removeSession(req.sess.id)
+	if sess != nil {
+		fs.Infof(req.c.what, "SMB session logged off")
+		sess.close()
+	}
+	var w smb2.Writer
+	w.Uint16(4)
+	w.Uint16(0)
+	return w.Bytes(), statusSuccess
+}
+
+// treeConnect runs TREE_CONNECT
+func (req *request) treeConnect() (body []byte, status ntStatus) {
+	r := req.r
+	r.Seek(headerSize + 4)
+	pathOffset := int(r.Uint16())
+	pathLength := int(r.Uint16())
+	sharePath := smb2.DecodeUTF16(r.At(pathOffset, pathLength))
+	if r.Err() != nil {
+		return nil, statusInvalidParameter
+	}
+	share := sharePath[strings.LastIndex(sharePath, `\`)+1:]
+	t := &tree{id: uint32(req.c.newID())}
+	shareType := uint8(shareTypeDisk)
+	maxAccess := uint32(fileAllAccess)
+	switch {
+	case strings.EqualFold(share, req.c.s.opt.ShareName):
+		if req.sess.vfs.Opt.ReadOnly {
+			maxAccess = fileReadAccess
+		}
+	case strings.EqualFold(share, "IPC$"):
+		t.pipe = true
+		shareType = shareTypePipe
+	default:
+		fs.Debugf(req.c.what, "SMB tree connect to unknown share %q", sharePath)
+		return nil, statusBadNetworkName
+	}
+	sess := req.sess
+	sess.mu.Lock()
+	sess.trees[t.id] = t
+	sess.mu.Unlock()
+	req.treeID = t.id
+
+	var w smb2.Writer
+	w.Uint16(16)
+	w.Uint8(shareType)
+	w.Uint8(0)
+	w.Uint32(0) // share flags
+	w.Uint32(0) // capabilities
+	w.Uint32(maxAccess)
+	return w.Bytes(), statusSuccess
+}
+
+// treeDisconnect runs TREE_DISCONNECT
+func (req *request) treeDisconnect() (body []byte, status ntStatus) {
+	closeFiles(req.sess.removeTree(req.tree))
+	var w smb2.Writer
+	w.Uint16(4)
+	w.Uint16(0)
+	return w.Bytes(), statusSuccess
+}
@@ -0,0 +1,134 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// aesCMAC computes the AES-CMAC of msg with key as described in RFC 4493
+func aesCMAC(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// the key is always 16 bytes so this can't happen
+		panic(err)
+	}
+	const size = aes.BlockSize
+
+	// make the subkeys
+	shift := func(in []byte) []byte {
+		out := make([]byte, size)
+		var carry byte
+		for i := size - 1; i >= 0; i-- {
+			out[i] = in[i]<<1 | carry
+			carry = in[i] >> 7
+		}
+		if in[0]&0x80 != 0 {
+			out[size-1] ^= 0x87
+		}
+		return out
+	}
+	l := make([]byte, size)
+	block.Encrypt(l, l)
+	k1 := shift(l)
+	k2 := shift(k1)
+
+	// pad the last block if needed and xor it with the subkey
+	n := (len(msg) + size - 1) / size
+	last := make([]byte, size)
+	if n > 0 && len(msg)%size == 0 {
+		copy(last, msg[(n-1)*size:])
+		for i := range last {
+			last[i] ^= k1[i]
+		}
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*size:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+
+	x := make([]byte, size)
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < size; j++ {
+			x[j] ^= msg[i*size+j]
+		}
+		block.Encrypt(x, x)
+	}
+	for j := 0; j < size; j++ {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x, x)
+	return x
+}
+
+// kdf is the SP800-108 counter mode key derivation function with
+// HMAC-SHA256 used by SMB 3 to make a 128 bit key
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], 1)
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write(label)
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(context)
+	binary.BigEndian.PutUint32(buf[:], 128)
+	_, _ = h.Write(buf[:])
+	return h.Sum(nil)[:16]
+}
+
+// signingKey returns the key used to sign messages in a session with
+// the dialect given
+func signingKey(dialect uint16, sessionKey, preauthHash []byte) []byte {
+	switch {
+	case dialect >= dialect311:
+		return kdf(sessionKey, []byte("SMBSigningKey\x00"), preauthHash)
+	case dialect >= dialect300:
+		return kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	}
+	return sessionKey
+}
+
+// signature computes the signature of msg, which must have the
+// signature field zeroed
+func signature(dialect uint16, key, msg []byte) []byte {
+	if dialect >= dialect300 {
+		return aesCMAC(key, msg)
+	}
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write(msg)
+	return h.Sum(nil)[:signatureLength]
+}
+
+// sign signs msg in place
+func sign(dialect uint16, key, msg []byte) {
+	binary.LittleEndian.PutUint32(msg[offFlags:], binary.LittleEndian.Uint32(msg[offFlags:])|flagsSigned)
+	sig := msg[offSignature : offSignature+signatureLength]
+	for i := range sig {
+		sig[i] = 0
+	}
+	copy(sig, signature(dialect, key, msg))
+}
+
+// verify checks the signature of msg without modifying it
+func verify(dialect uint16, key, msg []byte) bool {
+	if len(msg) < headerSize {
+		return false
+	}
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	sig := buf[offSignature : offSignature+signatureLength]
+	for i := range sig {
+		sig[i] = 0
+	}
+	return subtle.ConstantTimeCompare(signature(dialect, key, buf), msg[offSignature:offSignature+signatureLength]) == 1
+}
@@ -0,0 +1,147 @@
+// Package smb implements an SMB2/3 server to serve an rclone VFS
+
+// +build !plan9,!js
+
+package smb
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Options contains options for the SMB Server
+type Options struct {
+	ListenAddr string // Port to listen on
+	ShareName  string // name of the share
+	User       string // user name clients must log in with
+	Pass       string // password for User
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	ListenAddr: "localhost:445",
+	ShareName:  "rclone",
+}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// AddFlags adds flags for the smb
+func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
+	rc.AddOption("smb", &Opt)
+	flags.StringVarP(flagSet, &Opt.ListenAddr, "addr", "", Opt.ListenAddr, "IPaddress:Port or :Port to bind server to.")
+	flags.StringVarP(flagSet, &Opt.ShareName, "share-name", "", Opt.ShareName, "Name of the share to serve the remote as.")
+	flags.StringVarP(flagSet, &Opt.User, "user", "", Opt.User, "User name for authentication. If not set guests are allowed.")
+	flags.StringVarP(flagSet, &Opt.Pass, "pass", "", Opt.Pass, "Password for authentication.")
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	authflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags(), &Opt)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "smb remote:path",
+	Short: `Serve the remote as an SMB2/3 server.`,
+	Long: `rclone serve smb implements an SMB server to serve the remote as a
+Windows file share.  This lets Windows, macOS and Linux clients, and
+devices such as TVs and media players, access the remote without
+needing WinFsp or FUSE.
+
+SMB dialects 2.0.2, 2.1, 3.0, 3.0.2 and 3.1.1 are supported.  SMB1 is
+not supported.
+
+You can use the filter flags (e.g. --include, --exclude) to control what
+is served.
+
+The server will log errors.  Use -v to see access logs.
+
+--bwlimit will be respected for file transfers.  Use --stats to
+control the stats printing.
+
+By default the server binds to localhost:445 - if you want it to be
+reachable externally then supply "--addr :445" for example.  Port 445
+is a privileged port on most systems, and Windows clients can only
+connect to shares on port 445, so rclone will need to be run with the
+permissions to bind to it, and on Windows the built in file sharing
+will need to be stopped first.
+
+The remote is served as a single share called "rclone" - use
+--share-name to change it.  On Windows map it with something like
+
+    net use R: \\server\rclone /user:user pass
+
+On Linux mount it with something like
+
+    mount -t cifs -o vers=3.0,username=user,password=pass //server/rclone /mnt/rclone
+
+### Authentication
+
+Set the user name and password clients must log in with with --user
+and --pass.  Users are authenticated with NTLMv2 and once logged in
+all the messages on the connection are signed so they can't be
+tampered with.  The connection isn't encrypted so don't use it over
+networks you don't trust.
+
+If --user isn't set then any user name and password are accepted and
+clients are logged in as guests.  Recent versions of Windows refuse to
+log in to servers as a guest unless the "Enable insecure guest logons"
+group policy is set, so it is recommended that --user is set.
+
+The user database can be used instead of --user and --pass.  NTLMv2
+needs the NT hash of the password to check a login, so the users must
+be stored in the --auth-htpasswd file with {NT} or {PLAIN} entries.
+Each user is served their own root from --auth-users as the share,
+read only users can't change it, and the bandwidth limits of the
+users are respected.  LDAP and OpenID Connect can't be used as they
+don't provide the NT hash.
+
+### Caching
+
+SMB clients write files in blocks which may arrive out of order and
+open files for reading and writing, so it is recommended that
+--vfs-cache-mode writes (or full) is used.  With --vfs-cache-mode off
+files can only be written sequentially.
+
+### Limitations
+
+Byte range locks are supported. They are held in the VFS so they only
+work between clients of the same server and anything else using the
+same VFS in the same rclone process - see the VFS Locking section
+below.  The server never waits for a lock - if a lock can't be taken
+straight away the request fails.
+
+Oplocks and leases, change notification, durable handles, alternate
+data streams, encryption and DFS aren't supported.  All files appear to
+be owned by Everyone who has full access to them and attempts to
+change permissions are ignored.
+
+` + auth.Help + vfs.Help,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt, &authflags.Opt)
+			if err != nil {
+				return err
+			}
+			err = s.Serve()
+			if err != nil {
+				return err
+			}
+			s.Wait()
+			return nil
+		})
+	},
+}
@@ -0,0 +1,1168 @@
+// +build !plan9,!js
+
+package smb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/smb2"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUser = "user"
+	testPass = "secret"
+)
+
+// testClient is a minimal SMB2/3 client for testing the server
+type testClient struct {
+	t           *testing.T
+	c           net.Conn
+	br          *bufio.Reader
+	dialect     uint16
+	messageID   uint64
+	sessionID   uint64
+	treeID      uint32
+	signingKey  []byte
+	preauthHash []byte
+}
+
+// testMessage is a request to send in a compound
+type testMessage struct {
+	command uint16
+	body    []byte
+	related bool
+}
+
+// testResponse is a response to a testMessage
+type testResponse struct {
+	status ntStatus
+	msg    []byte // the whole message
+	body   []byte
+}
+
+// newTestClient connects to the server and negotiates dialect
+func newTestClient(t *testing.T, addr string, dialect uint16) *testClient {
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	tc := &testClient{t: t, c: c, br: bufio.NewReader(c)}
+
+	var w smb2.Writer
+	w.Uint16(36)
+	w.Uint16(1) // dialect count
+	w.Uint16(negotiateSigningEnabled)
+	w.Uint16(0)
+	w.Uint32(0) // capabilities
+	w.Zero(16)  // client GUID
+	ctxOffsetPos := w.Len()
+	w.Uint32(0)
+	w.Uint16(0)
+	w.Uint16(0)
+	w.Uint16(dialect)
+	if dialect == dialect311 {
+		w.Align(8)
+		w.PutUint32(ctxOffsetPos, uint32(headerSize+w.Len()))
+		w.PutUint16(ctxOffsetPos+4, 2)
+		w.Uint16(ctxPreauthIntegrity)
+		w.Uint16(38)
+		w.Uint32(0)
+		w.Uint16(1)
+		w.Uint16(32)
+		w.Uint16(hashSHA512)
+		w.Zero(32)
+		w.Align(8)
+		w.Uint16(ctxSigning)
+		w.Uint16(6)
+		w.Uint32(0)
+		w.Uint16(2)
+		w.Uint16(2) // AES-GMAC
+		w.Uint16(signingAESCMAC)
+	}
+	resps := tc.send(testMessage{command: cmdNegotiate, body: w.Bytes()})
+	require.Equal(t, statusSuccess, resps[0].status)
+	r := smb2.NewReader(resps[0].body)
+	r.Skip(4)
+	tc.dialect = r.Uint16()
+	require.Equal(t, dialect, tc.dialect)
+	if dialect == dialect311 {
+		tc.preauthHash = preauthHash(tc.preauthHash, resps[0].msg)
+		ctxCount := int(r.Uint16())
+		require.Equal(t, 2, ctxCount)
+		r = smb2.NewReader(resps[0].msg)
+		r.Seek(headerSize + 60)
+		r.Seek(int(r.Uint32()))
+		var signing uint16
+		for i := 0; i < ctxCount; i++ {
+			r.Align(8)
+			ctxType := r.Uint16()
+			length := int(r.Uint16())
+			r.Skip(4)
+			data := smb2.NewReader(r.Next(length))
+			if ctxType == ctxSigning {
+				assert.Equal(t, uint16(1), data.Uint16())
+				signing = data.Uint16()
+			}
+		}
+		require.NoError(t, r.Err())
+		assert.Equal(t, uint16(signingAESCMAC), signing)
+	}
+	return tc
+}
+
+// close the connection
+func (tc *testClient) close() {
+	require.NoError(tc.t, tc.c.Close())
+}
+
+// send the messages as a compound and return the responses
+func (tc *testClient) send(msgs ...testMessage) (resps []testResponse) {
+	var frame []byte
+	var requests [][]byte
+	for i, m := range msgs {
+		var w smb2.Writer
+		w.Append([]byte("\xfeSMB"))
+		w.Uint16(headerSize)
+		w.Uint16(1) // credit charge
+		w.Uint32(0) // status
+		w.Uint16(m.command)
+		w.Uint16(32) // credits requested
+		var flags uint32
+		if m.related {
+			flags |= flagsRelatedOperations
+		}
+		w.Uint32(flags)
+		w.Uint32(0) // next command
+		w.Uint64(tc.messageID)
+		tc.messageID++
+		w.Uint32(0)
+		w.Uint32(tc.treeID)
+		w.Uint64(tc.sessionID)
+		w.Zero(signatureLength)
+		w.Append(m.body)
+		if i < len(msgs)-1 {
+			w.Align(8)
+			w.PutUint32(offNextCommand, uint32(w.Len()))
+		}
+		if tc.signingKey != nil {
+			sign(tc.dialect, tc.signingKey, w.Bytes())
+		}
+		requests = append(requests, w.Bytes())
+		frame = append(frame, w.Bytes()...)
+	}
+	if len(msgs) == 1 && msgs[0].command == cmdSessionSetup && tc.preauthHash != nil {
+		tc.preauthHash = preauthHash(tc.preauthHash, requests[0])
+	}
+	header := []byte{0, byte(len(frame) >> 16), byte(len(frame) >> 8), byte(len(frame))}
+	_, err := tc.c.Write(append(header, frame...))
+	require.NoError(tc.t, err)
+	if len(msgs) == 1 && msgs[0].command == cmdNegotiate && tc.preauthHash == nil {
+		tc.preauthHash = preauthHash(make([]byte, sha512.Size), requests[0])
+	}
+
+	frame, err = readFrame(tc.br)
+	require.NoError(tc.t, err)
+	for len(frame) > 0 {
+		require.True(tc.t, len(frame) >= headerSize)
+		msg := frame
+		if next := binary.LittleEndian.Uint32(frame[offNextCommand:]); next != 0 {
+			msg = frame[:next]
+		}
+		frame = frame[len(msg):]
+		flags := binary.LittleEndian.Uint32(msg[offFlags:])
+		assert.NotEqual(tc.t, uint32(0), flags&flagsServerToRedir)
+		if tc.signingKey != nil {
+			assert.NotEqual(tc.t, uint32(0), flags&flagsSigned, "response not signed")
+			assert.True(tc.t, verify(tc.dialect, tc.signingKey, msg), "bad signature")
+		}
+		resps = append(resps, testResponse{
+			status: ntStatus(binary.LittleEndian.Uint32(msg[offStatus:])),
+			msg:    msg,
+			body:   msg[headerSize:],
+		})
+	}
+	require.Equal(tc.t, len(msgs), len(resps))
+	return resps
+}
+
+// call sends a single message returning its status and body
+func (tc *testClient) call(command uint16, body []byte) (ntStatus, []byte) {
+	resp := tc.send(testMessage{command: command, body: body})[0]
+	return resp.status, resp.body
+}
+
+// sessionSetup sends a SESSION_SETUP with token
+func (tc *testClient) sessionSetup(token []byte) testResponse {
+	var w smb2.Writer
+	w.Uint16(25)
+	w.Uint8(0) // flags
+	w.Uint8(negotiateSigningEnabled)
+	w.Uint32(0) // capabilities
+	w.Uint32(0) // channel
+	w.Uint16(headerSize + 24)
+	w.Uint16(uint16(len(token)))
+	w.Uint64(0) // previous session id
+	w.Append(token)
+	resp := tc.send(testMessage{command: cmdSessionSetup, body: w.Bytes()})[0]
+	tc.sessionID = binary.LittleEndian.Uint64(resp.msg[offSessionID:])
+	return resp
+}
+
+// securityBuffer returns the security buffer of a SESSION_SETUP response
+func securityBuffer(t *testing.T, resp testResponse) []byte {
+	r := smb2.NewReader(resp.msg)
+	r.Seek(headerSize + 4)
+	offset := int(r.Uint16())
+	length := int(r.Uint16())
+	data := r.At(offset, length)
+	require.NoError(t, r.Err())
+	return data
+}
+
+// login authenticates as user with pass using NTLMv2 in SPNEGO
+// returning the status of the final SESSION_SETUP
+func (tc *testClient) login(user, pass string) ntStatus {
+	t := tc.t
+	var negotiate smb2.Writer
+	negotiate.Append(ntlmSignature)
+	negotiate.Uint32(ntlmNegotiate)
+	negotiate.Uint32(ntlmNegotiateUnicode | ntlmNegotiateNTLM | ntlmNegotiateSign | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSec | ntlmNegotiate128 | ntlmNegotiateKeyExch | ntlmNegotiateTargetInfo)
+	negotiate.Zero(16)
+	mechTypes := derTLV(0x30, oidNTLMSSP)
+	init := derTLV(0x60, append(append([]byte{}, oidSPNEGO...), derTLV(0xa0, derTLV(0x30, append(
+		derTLV(0xa0, mechTypes),
+		derTLV(0xa2, derTLV(0x04, negotiate.Bytes()))...)))...))
+	resp := tc.sessionSetup(init)
+	require.Equal(t, statusMoreProcessingRequired, resp.status)
+	if tc.preauthHash != nil {
+		tc.preauthHash = preauthHash(tc.preauthHash, resp.msg)
+	}
+
+	// decode the challenge
+	a := &ntlmAuth{started: true, spnego: true}
+	tag, body, _, ok := parseDER(securityBuffer(t, resp))
+	require.True(t, ok)
+	require.Equal(t, byte(0xa1), tag)
+	challenge, err := a.parseNegTokenResp(derTLV(0xa1, body))
+	require.NoError(t, err)
+	r := smb2.NewReader(challenge)
+	r.Seek(20)
+	flags := r.Uint32()
+	serverChallenge := r.Next(8)
+	r.Seek(40)
+	infoLength := int(r.Uint16())
+	r.Skip(2)
+	targetInfo := r.At(int(r.Uint32()), infoLength)
+	require.NoError(t, r.Err())
+
+	// make the response
+	var blob smb2.Writer
+	blob.Uint16(0x0101)
+	blob.Zero(6)
+	blob.Uint64(smb2.ToFiletime(time.Now()))
+	clientChallenge := make([]byte, 8)
+	_, _ = rand.Read(clientChallenge)
+	blob.Append(clientChallenge)
+	blob.Zero(4)
+	blob.Append(targetInfo)
+	blob.Zero(4)
+	ntowf := ntowfv2(auth.NTHash(pass), user, "WORKGROUP")
+	proof := ntlmv2Proof(ntowf, serverChallenge, blob.Bytes())
+	nt := append(proof, blob.Bytes()...)
+	baseKey := hmacMD5(ntowf, proof)
+	sessionKey := make([]byte, 16)
+	_, _ = rand.Read(sessionKey)
+	encryptedKey := make([]byte, 16)
+	cipher, _ := rc4.NewCipher(baseKey)
+	cipher.XORKeyStream(encryptedKey, sessionKey)
+
+	fields := [][]byte{
+		make([]byte, 24), // LM
+		nt,
+		smb2.EncodeUTF16("WORKGROUP"),
+		smb2.EncodeUTF16(user),
+		smb2.EncodeUTF16("CLIENT"),
+		encryptedKey,
+	}
+	var auth smb2.Writer
+	auth.Append(ntlmSignature)
+	auth.Uint32(ntlmAuthenticate)
+	offset := 64
+	for _, field := range fields {
+		auth.Uint16(uint16(len(field)))
+		auth.Uint16(uint16(len(field)))
+		auth.Uint32(uint32(offset))
+		offset += len(field)
+	}
+	auth.Uint32(flags)
+	auth.Zero(64 - auth.Len())
+	for _, field := range fields {
+		auth.Append(field)
+	}
+	token := derTLV(0xa1, derTLV(0x30, derTLV(0xa2, derTLV(0x04, auth.Bytes()))))
+	resp = tc.sessionSetup(token)
+	if resp.status != statusSuccess {
+		return resp.status
+	}
+	tc.signingKey = signingKey(tc.dialect, sessionKey, tc.preauthHash)
+	assert.NotEqual(t, uint32(0), binary.LittleEndian.Uint32(resp.msg[offFlags:])&flagsSigned, "final session setup not signed")
+	assert.True(t, verify(tc.dialect, tc.signingKey, resp.msg), "bad signature on session setup")
+	return resp.status
+}
+
+// treeConnect connects to share
+func (tc *testClient) treeConnect(share string) ntStatus {
+	path := smb2.EncodeUTF16(`\\localhost\` + share)
+	var w smb2.Writer
+	w.Uint16(9)
+	w.Uint16(0)
+	w.Uint16(headerSize + 8)
+	w.Uint16(uint16(len(path)))
+	w.Append(path)
+	resp := tc.send(testMessage{command: cmdTreeConnect, body: w.Bytes()})[0]
+	if resp.status == statusSuccess {
+		tc.treeID = binary.LittleEndian.Uint32(resp.msg[offTreeID:])
+	}
+	return resp.status
+}
+
+// createBody makes the body of a CREATE request
+func createBody(name string, access, disposition, options uint32) []byte {
+	nameBytes := smb2.EncodeUTF16(name)
+	var w smb2.Writer
+	w.Uint16(57)
+	w.Uint8(0)
+	w.Uint8(0)  // oplock
+	w.Uint32(2) // impersonation
+	w.Zero(16)
+	w.Uint32(access)
+	w.Uint32(0)
+	w.Uint32(7) // share access
+	w.Uint32(disposition)
+	w.Uint32(options)
+	w.Uint16(headerSize + 56)
+	w.Uint16(uint16(len(nameBytes)))
+	w.Uint32(0)
+	w.Uint32(0)
+	w.Append(nameBytes)
+	if len(nameBytes) == 0 {
+		w.Uint8(0)
+	}
+	return w.Bytes()
+}
+
+// create opens name returning the status, the create action and the file id
+func (tc *testClient) create(name string, access, disposition, options uint32) (ntStatus, uint32, []byte) {
+	status, body := tc.call(cmdCreate, createBody(name, access, disposition, options))
+	if status != statusSuccess {
+		return status, 0, nil
+	}
+	return status, binary.LittleEndian.Uint32(body[4:]), body[64:80]
+}
+
+// relatedID is the file id meaning the last one in a compound
+var relatedID = bytes.Repeat([]byte{0xff}, 16)
+
+// closeBody makes the body of a CLOSE request
+func closeBody(fileID []byte, flags uint16) []byte {
+	var w smb2.Writer
+	w.Uint16(24)
+	w.Uint16(flags)
+	w.Uint32(0)
+	w.Append(fileID)
+	return w.Bytes()
+}
+
+// closeFile closes fileID
+func (tc *testClient) closeFile(fileID []byte) {
+	status, _ := tc.call(cmdClose, closeBody(fileID, 0))
+	require.Equal(tc.t, statusSuccess, status)
+}
+
+// writeBody makes the body of a WRITE request
+func writeBody(fileID []byte, offset uint64, data []byte) []byte {
+	var w smb2.Writer
+	w.Uint16(49)
+	w.Uint16(headerSize + 48)
+	w.Uint32(uint32(len(data)))
+	w.Uint64(offset)
+	w.Append(fileID)
+	w.Zero(16)
+	w.Append(data)
+	return w.Bytes()
+}
+
+// readBody makes the body of a READ request
+func readBody(fileID []byte, offset uint64, length uint32) []byte {
+	var w smb2.Writer
+	w.Uint16(49)
+	w.Uint8(0)
+	w.Uint8(0)
+	w.Uint32(length)
+	w.Uint64(offset)
+	w.Append(fileID)
+	w.Zero(17)
+	return w.Bytes()
+}
+
+// readData returns the data from a READ response
+func readData(body []byte) []byte {
+	length := binary.LittleEndian.Uint32(body[4:])
+	return body[16 : 16+length]
+}
+
+// writeFile creates name with contents
+func (tc *testClient) writeFile(name, contents string) {
+	status, action, fileID := tc.create(name, fileWriteData|fileReadData, fileOverwriteIf, fileNonDirectoryFile)
+	require.Equal(tc.t, statusSuccess, status)
+	assert.Contains(tc.t, []uint32{fileCreated, fileOverwritten}, action)
+	status, body := tc.call(cmdWrite, writeBody(fileID, 0, []byte(contents)))
+	require.Equal(tc.t, statusSuccess, status)
+	assert.Equal(tc.t, uint32(len(contents)), binary.LittleEndian.Uint32(body[4:]))
+	tc.closeFile(fileID)
+}
+
+// readFile reads the contents of name
+func (tc *testClient) readFile(name string) string {
+	status, _, fileID := tc.create(name, fileReadData, fileOpen, fileNonDirectoryFile)
+	require.Equal(tc.t, statusSuccess, status)
+	defer tc.closeFile(fileID)
+	var out []byte
+	for {
+		status, body := tc.call(cmdRead, readBody(fileID, uint64(len(out)), 65536))
+		if status == statusEndOfFile {
+			break
+		}
+		require.Equal(tc.t, statusSuccess, status)
+		out = append(out, readData(body)...)
+	}
+	return string(out)
+}
+
+// queryDirectoryBody makes the body of a QUERY_DIRECTORY request
+func queryDirectoryBody(fileID []byte, class, flags uint8, pattern string, outputLength uint32) []byte {
+	name := smb2.EncodeUTF16(pattern)
+	var w smb2.Writer
+	w.Uint16(33)
+	w.Uint8(class)
+	w.Uint8(flags)
+	w.Uint32(0)
+	w.Append(fileID)
+	w.Uint16(headerSize + 32)
+	w.Uint16(uint16(len(name)))
+	w.Uint32(outputLength)
+	w.Append(name)
+	return w.Bytes()
+}
+
+// listDir lists the directory name returning the names and sizes
+func (tc *testClient) listDir(name, pattern string) map[string]int64 {
+	status, _, fileID := tc.create(name, fileReadData, fileOpen, fileDirectoryFile)
+	require.Equal(tc.t, statusSuccess, status)
+	defer tc.closeFile(fileID)
+	entries := map[string]int64{}
+	for {
+		// use a small buffer so several calls are needed
+		status, body := tc.call(cmdQueryDirectory, queryDirectoryBody(fileID, fileIDBothDirectoryInformation, 0, pattern, 300))
+		if status == statusNoMoreFiles || status == statusNoSuchFile {
+			break
+		}
+		require.Equal(tc.t, statusSuccess, status)
+		length := int(binary.LittleEndian.Uint32(body[4:]))
+		data := body[8 : 8+length]
+		for {
+			next := int(binary.LittleEndian.Uint32(data))
+			size := int64(binary.LittleEndian.Uint64(data[40:]))
+			nameLength := int(binary.LittleEndian.Uint32(data[60:]))
+			entries[smb2.DecodeUTF16(data[104:104+nameLength])] = size
+			if next == 0 {
+				break
+			}
+			assert.Equal(tc.t, 0, next%8)
+			data = data[next:]
+		}
+	}
+	return entries
+}
+
+// setInfoBody makes the body of a SET_INFO request
+func setInfoBody(fileID []byte, infoType, class uint8, info []byte) []byte {
+	var w smb2.Writer
+	w.Uint16(33)
+	w.Uint8(infoType)
+	w.Uint8(class)
+	w.Uint32(uint32(len(info)))
+	w.Uint16(headerSize + 32)
+	w.Uint16(0)
+	w.Uint32(0)
+	w.Append(fileID)
+	w.Append(info)
+	return w.Bytes()
+}
+
+// queryInfoBody makes the body of a QUERY_INFO request
+func queryInfoBody(fileID []byte, infoType, class uint8, outputLength uint32) []byte {
+	var w smb2.Writer
+	w.Uint16(41)
+	w.Uint8(infoType)
+	w.Uint8(class)
+	w.Uint32(outputLength)
+	w.Uint16(0)
+	w.Uint16(0)
+	w.Uint32(0)
+	w.Uint32(daclSecurityInformation)
+	w.Uint32(0)
+	w.Append(fileID)
+	w.Uint8(0)
+	return w.Bytes()
+}
+
+// queryInfo returns the information asked for
+func (tc *testClient) queryInfo(fileID []byte, infoType, class uint8, outputLength uint32) (ntStatus, []byte) {
+	status, body := tc.call(cmdQueryInfo, queryInfoBody(fileID, infoType, class, outputLength))
+	if isError(status) {
+		return status, body
+	}
+	length := binary.LittleEndian.Uint32(body[4:])
+	return status, body[8 : 8+length]
+}
+
+// newTestServer makes a server serving a local directory
+func newTestServer(t *testing.T, dir string, user string) *server {
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	opt.User = user
+	opt.Pass = testPass
+	s, err := newServer(context.Background(), f, &opt, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.serve())
+	return s
+}
+
+// setup makes a temporary directory to serve using the VFS cache
+func setup(t *testing.T) (dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-serve-smb")
+	require.NoError(t, err)
+	oldCacheDir := config.CacheDir
+	oldCacheMode := vfsflags.Opt.CacheMode
+	config.CacheDir = filepath.Join(dir, "cache")
+	oldWriteBack := vfsflags.Opt.WriteBack
+	vfsflags.Opt.CacheMode = vfscommon.CacheModeWrites
+	vfsflags.Opt.WriteBack = 0
+	served := filepath.Join(dir, "served")
+	require.NoError(t, os.Mkdir(served, 0777))
+	return served, func() {
+		config.CacheDir = oldCacheDir
+		vfsflags.Opt.CacheMode = oldCacheMode
+		vfsflags.Opt.WriteBack = oldWriteBack
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, dialect := range []uint16{dialect202, dialect210, dialect300, dialect302, dialect311} {
+		t.Run(hex.EncodeToString([]byte{byte(dialect >> 8), byte(dialect)}), func(t *testing.T) {
+			testServer(t, dialect)
+		})
+	}
+}
+
+func testServer(t *testing.T, dialect uint16) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "existing.txt"), []byte("existing"), 0666))
+	s := newTestServer(t, dir, testUser)
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	// Wrong password
+	tc := newTestClient(t, s.Addr(), dialect)
+	assert.Equal(t, statusLogonFailure, tc.login(testUser, "wrong"))
+	tc.close()
+
+	tc = newTestClient(t, s.Addr(), dialect)
+	defer tc.close()
+	require.Equal(t, statusSuccess, tc.login(testUser, testPass))
+	assert.Equal(t, statusBadNetworkName, tc.treeConnect("missing"))
+	require.Equal(t, statusSuccess, tc.treeConnect("RCLONE"))
+
+	t.Run("ReadWrite", func(t *testing.T) {
+		assert.Equal(t, "existing", tc.readFile("existing.txt"))
+		tc.writeFile(`new.txt`, "hello world")
+		assert.Equal(t, "hello world", tc.readFile("new.txt"))
+		s.vfs.WaitForWriters(10 * time.Second)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "new.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+
+		status, _, _ := tc.create("new.txt", fileReadData, fileCreate, 0)
+		assert.Equal(t, statusObjectNameCollision, status)
+		status, _, _ = tc.create("missing.txt", fileReadData, fileOpen, 0)
+		assert.Equal(t, statusObjectNameNotFound, status)
+		status, _, _ = tc.create(`nodir\missing.txt`, fileReadData, fileOpen, 0)
+		assert.Equal(t, statusObjectPathNotFound, status)
+		status, _, _ = tc.create(`..\escape.txt`, fileReadData, fileOpen, 0)
+		assert.Equal(t, statusObjectNameInvalid, status)
+	})
+
+	t.Run("Directories", func(t *testing.T) {
+		status, action, fileID := tc.create(`dir`, fileReadData, fileCreate, fileDirectoryFile)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, uint32(fileCreated), action)
+		tc.closeFile(fileID)
+		for i := 0; i < 20; i++ {
+			tc.writeFile(`dir\file`+string(rune('a'+i))+".txt", string(make([]byte, i)))
+		}
+		entries := tc.listDir("dir", "*")
+		assert.Equal(t, 22, len(entries))
+		assert.Equal(t, int64(5), entries["filef.txt"])
+		assert.Contains(t, entries, ".")
+		assert.Contains(t, entries, "..")
+		entries = tc.listDir("dir", "FILE?.TXT")
+		assert.Equal(t, 20, len(entries))
+		entries = tc.listDir("dir", "fileb.txt")
+		assert.Equal(t, map[string]int64{"fileb.txt": 1}, entries)
+		entries = tc.listDir("dir", "nomatch*")
+		assert.Equal(t, 0, len(entries))
+
+		status, _, _ = tc.create(`dir`, fileReadData, fileOpen, fileNonDirectoryFile)
+		assert.Equal(t, statusFileIsADirectory, status)
+		status, _, _ = tc.create(`new.txt`, fileReadData, fileOpen, fileDirectoryFile)
+		assert.Equal(t, statusNotADirectory, status)
+	})
+
+	t.Run("Compound", func(t *testing.T) {
+		resps := tc.send(
+			testMessage{command: cmdCreate, body: createBody("compound.txt", fileWriteData|fileReadData, fileCreate, 0)},
+			testMessage{command: cmdWrite, body: writeBody(relatedID, 0, []byte("compounded")), related: true},
+			testMessage{command: cmdRead, body: readBody(relatedID, 0, 100), related: true},
+			testMessage{command: cmdClose, body: closeBody(relatedID, closeFlagPostqueryAttrib), related: true},
+		)
+		for _, resp := range resps {
+			assert.Equal(t, statusSuccess, resp.status)
+		}
+		assert.Equal(t, "compounded", string(readData(resps[2].body)))
+		assert.Equal(t, uint64(10), binary.LittleEndian.Uint64(resps[3].body[48:]))
+
+		// a failure is passed on to the related requests
+		resps = tc.send(
+			testMessage{command: cmdCreate, body: createBody("missing.txt", fileReadData, fileOpen, 0)},
+			testMessage{command: cmdRead, body: readBody(relatedID, 0, 100), related: true},
+			testMessage{command: cmdClose, body: closeBody(relatedID, 0), related: true},
+		)
+		for _, resp := range resps {
+			assert.Equal(t, statusObjectNameNotFound, resp.status)
+		}
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		status, _, fileID := tc.create("existing.txt", fileReadData|fileWriteAttributes|fileWriteData, fileOpen, 0)
+		require.Equal(t, statusSuccess, status)
+		defer tc.closeFile(fileID)
+
+		status, info := tc.queryInfo(fileID, infoFile, fileStandardInformation, 24)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, uint64(8), binary.LittleEndian.Uint64(info[8:]))
+		assert.Equal(t, uint8(0), info[21]) // not a directory
+
+		status, _ = tc.queryInfo(fileID, infoFile, fileStandardInformation, 8)
+		assert.Equal(t, statusInfoLengthMismatch, status)
+
+		status, info = tc.queryInfo(fileID, infoFile, fileAllInformation, 4096)
+		require.Equal(t, statusSuccess, status)
+		nameLength := int(binary.LittleEndian.Uint32(info[96:]))
+		assert.Equal(t, `\existing.txt`, smb2.DecodeUTF16(info[100:100+nameLength]))
+		status, _ = tc.queryInfo(fileID, infoFile, fileAllInformation, 100)
+		assert.Equal(t, statusBufferOverflow, status)
+
+		status, info = tc.queryInfo(fileID, infoFilesystem, fileSystemFsAttributeInformation, 4096)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, "NTFS", smb2.DecodeUTF16(info[12:]))
+		status, info = tc.queryInfo(fileID, infoFilesystem, fileSystemFsFullSizeInformation, 4096)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, 32, len(info))
+
+		status, body := tc.queryInfo(fileID, infoSecurity, 0, 4)
+		require.Equal(t, statusBufferTooSmall, status)
+		needed := binary.LittleEndian.Uint32(body[8:])
+		status, info = tc.queryInfo(fileID, infoSecurity, 0, needed)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, int(needed), len(info))
+
+		// set the modification time
+		modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		var basic smb2.Writer
+		basic.Zero(16)
+		basic.Uint64(smb2.ToFiletime(modTime))
+		basic.Zero(16)
+		status, _ = tc.call(cmdSetInfo, setInfoBody(fileID, infoFile, fileBasicInformation, basic.Bytes()))
+		require.Equal(t, statusSuccess, status)
+		status, info = tc.queryInfo(fileID, infoFile, fileBasicInformation, 40)
+		require.Equal(t, statusSuccess, status)
+		assert.True(t, modTime.Equal(smb2.FromFiletime(binary.LittleEndian.Uint64(info[16:]))))
+
+		// truncate the file
+		var eof smb2.Writer
+		eof.Uint64(3)
+		status, _ = tc.call(cmdSetInfo, setInfoBody(fileID, infoFile, fileEndOfFileInformation, eof.Bytes()))
+		require.Equal(t, statusSuccess, status)
+		status, info = tc.queryInfo(fileID, infoFile, fileStandardInformation, 24)
+		require.Equal(t, statusSuccess, status)
+		assert.Equal(t, uint64(3), binary.LittleEndian.Uint64(info[8:]))
+	})
+
+	t.Run("RenameDelete", func(t *testing.T) {
+		tc.writeFile("old.txt", "rename me")
+		rename := func(from, to string, replace bool) ntStatus {
+			status, _, fileID := tc.create(from, accessDelete, fileOpen, 0)
+			require.Equal(t, statusSuccess, status)
+			defer tc.closeFile(fileID)
+			name := smb2.EncodeUTF16(to)
+			var w smb2.Writer
+			w.Uint8(boolToUint8(replace))
+			w.Zero(15)
+			w.Uint32(uint32(len(name)))
+			w.Append(name)
+			status, _ = tc.call(cmdSetInfo, setInfoBody(fileID, infoFile, fileRenameInformation, w.Bytes()))
+			return status
+		}
+		assert.Equal(t, statusObjectNameCollision, rename("old.txt", "new.txt", false))
+		assert.Equal(t, statusSuccess, rename("old.txt", `dir\renamed.txt`, false))
+		assert.Equal(t, "rename me", tc.readFile(`dir\renamed.txt`))
+		s.vfs.WaitForWriters(10 * time.Second)
+		_, err := os.Stat(filepath.Join(dir, "old.txt"))
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, statusSuccess, rename(`dir\renamed.txt`, `new.txt`, true))
+		assert.Equal(t, "rename me", tc.readFile(`new.txt`))
+
+		// delete with a disposition
+		status, _, fileID := tc.create("new.txt", accessDelete, fileOpen, 0)
+		require.Equal(t, statusSuccess, status)
+		status, _ = tc.call(cmdSetInfo, setInfoBody(fileID, infoFile, fileDispositionInformation, []byte{1}))
+		require.Equal(t, statusSuccess, status)
+		tc.closeFile(fileID)
+		s.vfs.WaitForWriters(10 * time.Second)
+		_, err = os.Stat(filepath.Join(dir, "new.txt"))
+		assert.True(t, os.IsNotExist(err))
+
+		// a directory which isn't empty can't be deleted
+		status, _, fileID = tc.create("dir", accessDelete, fileOpen, fileDirectoryFile)
+		require.Equal(t, statusSuccess, status)
+		status, _ = tc.call(cmdSetInfo, setInfoBody(fileID, infoFile, fileDispositionInformation, []byte{1}))
+		assert.Equal(t, statusDirectoryNotEmpty, status)
+		tc.closeFile(fileID)
+
+		// delete on close
+		tc.writeFile("temp.txt", "temporary")
+		status, _, fileID = tc.create("temp.txt", accessDelete|fileReadData, fileOpen, fileDeleteOnClose)
+		require.Equal(t, statusSuccess, status)
+		tc.closeFile(fileID)
+		s.vfs.WaitForWriters(10 * time.Second)
+		_, err = os.Stat(filepath.Join(dir, "temp.txt"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		tc.writeFile("lock.txt", "lock me")
+		status, _, fileID1 := tc.create("lock.txt", fileReadData|fileWriteData, fileOpen, 0)
+		require.Equal(t, statusSuccess, status)
+		defer tc.closeFile(fileID1)
+		status, _, fileID2 := tc.create("lock.txt", fileReadData|fileWriteData, fileOpen, 0)
+		require.Equal(t, statusSuccess, status)
+		lock := func(fileID []byte, offset, length uint64, flags uint32) ntStatus {
+			var w smb2.Writer
+			w.Uint16(48)
+			w.Uint16(1)
+			w.Uint32(0)
+			w.Append(fileID)
+			w.Uint64(offset)
+			w.Uint64(length)
+			w.Uint32(flags)
+			w.Uint32(0)
+			status, _ := tc.call(cmdLock, w.Bytes())
+			return status
+		}
+		assert.Equal(t, statusSuccess, lock(fileID1, 0, 10, lockFlagExclusive|lockFlagFailImmediately))
+		assert.Equal(t, statusLockNotGranted, lock(fileID2, 5, 10, lockFlagShared|lockFlagFailImmediately))
+		assert.Equal(t, statusSuccess, lock(fileID2, 10, 10, lockFlagShared|lockFlagFailImmediately))
+		assert.Equal(t, statusSuccess, lock(fileID1, 0, 10, lockFlagUnlock))
+		assert.Equal(t, statusSuccess, lock(fileID2, 5, 10, lockFlagShared|lockFlagFailImmediately))
+		// closing releases the locks
+		tc.closeFile(fileID2)
+		assert.Equal(t, statusSuccess, lock(fileID1, 0, 100, lockFlagExclusive|lockFlagFailImmediately))
+	})
+
+	t.Run("Echo", func(t *testing.T) {
+		status, _ := tc.call(cmdEcho, []byte{4, 0, 0, 0})
+		assert.Equal(t, statusSuccess, status)
+	})
+
+	t.Run("ShareEnum", func(t *testing.T) {
+		testShareEnum(t, tc)
+	})
+}
+
+// testShareEnum lists the shares with srvsvc over the IPC$ share
+func testShareEnum(t *testing.T, tc *testClient) {
+	oldTree := tc.treeID
+	require.Equal(t, statusSuccess, tc.treeConnect("IPC$"))
+	defer func() {
+		status, _ := tc.call(cmdTreeDisconnect, []byte{4, 0, 0, 0})
+		assert.Equal(t, statusSuccess, status)
+		tc.treeID = oldTree
+	}()
+	status, _, fileID := tc.create("srvsvc", fileReadData|fileWriteData, fileOpen, 0)
+	require.Equal(t, statusSuccess, status)
+	defer tc.closeFile(fileID)
+
+	// bind with WRITE and READ
+	var bind smb2.Writer
+	bind.Uint16(rpcMaxFrag)
+	bind.Uint16(rpcMaxFrag)
+	bind.Uint32(0)
+	bind.Uint8(2) // contexts
+	bind.Zero(3)
+	bind.Uint16(0)
+	bind.Uint8(1)
+	bind.Uint8(0)
+	bind.Append(srvsvcSyntax)
+	bind.Append(ndrSyntax)
+	bind.Uint16(1)
+	bind.Uint8(1)
+	bind.Uint8(0)
+	bind.Append(srvsvcSyntax)
+	bind.Append(append(append([]byte{}, btfnPrefix...), 3, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0))
+	status, _ = tc.call(cmdWrite, writeBody(fileID, 0, rpcPacket(rpcBind, 1, bind.Bytes())))
+	require.Equal(t, statusSuccess, status)
+	status, body := tc.call(cmdRead, readBody(fileID, 0, 4096))
+	require.Equal(t, statusSuccess, status)
+	ack := readData(body)
+	require.Equal(t, uint8(rpcBindAck), ack[2])
+	r := smb2.NewReader(ack)
+	r.Seek(rpcHeaderSize + 8)
+	r.Skip(int(r.Uint16()))
+	r.Align(4)
+	assert.Equal(t, uint8(2), r.Uint8())
+	r.Skip(3)
+	assert.Equal(t, uint16(rpcAcceptance), r.Uint16())
+	r.Skip(22)
+	assert.Equal(t, uint16(rpcNegotiateAck), r.Uint16())
+	require.NoError(t, r.Err())
+
+	// NetrShareEnum level 1 with IOCTL
+	var stub smb2.NDRWriter
+	stub.Pointer()
+	stub.String("localhost")
+	stub.Uint32(1) // level
+	stub.Uint32(1) // union discriminant
+	stub.Pointer()
+	stub.Uint32(0) // entries read
+	stub.Uint32(0) // null buffer
+	stub.Uint32(0xFFFFFFFF)
+	stub.Uint32(0) // null resume handle
+	var request smb2.Writer
+	request.Uint32(uint32(stub.Len()))
+	request.Uint16(0)
+	request.Uint16(srvsvcNetrShareEnum)
+	request.Append(stub.Bytes())
+	input := rpcPacket(rpcRequest, 2, request.Bytes())
+	var w smb2.Writer
+	w.Uint16(57)
+	w.Uint16(0)
+	w.Uint32(fsctlPipeTransceive)
+	w.Append(fileID)
+	w.Uint32(headerSize + 56)
+	w.Uint32(uint32(len(input)))
+	w.Uint32(0)
+	w.Uint32(0)
+	w.Uint32(0)
+	w.Uint32(4096)
+	w.Uint32(ioctlIsFsctl)
+	w.Uint32(0)
+	w.Append(input)
+	status, body = tc.call(cmdIoctl, w.Bytes())
+	require.Equal(t, statusSuccess, status)
+	output := body[48:]
+	require.Equal(t, uint8(rpcResponse), output[2])
+	r = smb2.NewReader(output[rpcHeaderSize+8:])
+	assert.Equal(t, uint32(1), r.Uint32()) // level
+	assert.Equal(t, uint32(1), r.Uint32())
+	r.Skip(4) // container pointer
+	count := int(r.Uint32())
+	r.Skip(4) // buffer pointer
+	assert.Equal(t, count, int(r.Uint32()))
+	var types []uint32
+	for i := 0; i < count; i++ {
+		r.Skip(4)
+		types = append(types, r.Uint32())
+		r.Skip(4)
+	}
+	var names []string
+	for i := 0; i < count; i++ {
+		names = append(names, smb2.ReadNDRString(r))
+		_ = smb2.ReadNDRString(r) // remark
+	}
+	require.NoError(t, r.Err())
+	sort.Strings(names)
+	assert.Equal(t, []string{"IPC$", "rclone"}, names)
+	assert.Equal(t, []uint32{shareTypeDiskTree, shareTypeIPC}, types)
+}
+
+func TestGuest(t *testing.T) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+	s := newTestServer(t, dir, "")
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	tc := newTestClient(t, s.Addr(), dialect311)
+	defer tc.close()
+
+	// any user and password are accepted without signing
+	var negotiate smb2.Writer
+	negotiate.Append(ntlmSignature)
+	negotiate.Uint32(ntlmNegotiate)
+	negotiate.Uint32(ntlmNegotiateUnicode | ntlmNegotiateNTLM)
+	negotiate.Zero(16)
+	resp := tc.sessionSetup(negotiate.Bytes())
+	require.Equal(t, statusMoreProcessingRequired, resp.status)
+	challenge := securityBuffer(t, resp)
+	assert.True(t, bytes.HasPrefix(challenge, ntlmSignature), "expecting a raw NTLM token")
+
+	var auth smb2.Writer
+	auth.Append(ntlmSignature)
+	auth.Uint32(ntlmAuthenticate)
+	user := smb2.EncodeUTF16("anybody")
+	for i := 0; i < 6; i++ {
+		length := 0
+		if i == 3 {
+			length = len(user)
+		}
+		auth.Uint16(uint16(length))
+		auth.Uint16(uint16(length))
+		auth.Uint32(64)
+	}
+	auth.Uint32(ntlmNegotiateUnicode)
+	auth.Zero(64 - auth.Len())
+	auth.Append(user)
+	resp = tc.sessionSetup(auth.Bytes())
+	require.Equal(t, statusSuccess, resp.status)
+	assert.Equal(t, uint16(sessionFlagIsGuest), binary.LittleEndian.Uint16(resp.body[2:]))
+	assert.Equal(t, uint32(0), binary.LittleEndian.Uint32(resp.msg[offFlags:])&flagsSigned)
+
+	require.Equal(t, statusSuccess, tc.treeConnect("rclone"))
+	tc.writeFile("guest.txt", "guest data")
+	assert.Equal(t, "guest data", tc.readFile("guest.txt"))
+
+	// logging off ends the session
+	status, _ := tc.call(cmdLogoff, []byte{4, 0, 0, 0})
+	assert.Equal(t, statusSuccess, status)
+	status, _, _ = tc.create("guest.txt", fileReadData, fileOpen, 0)
+	assert.Equal(t, statusUserSessionDeleted, status)
+}
+
+func TestUserDatabase(t *testing.T) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	htpasswd := filepath.Join(dir, "htpasswd")
+	aliceHash := "{NT}" + hex.EncodeToString(auth.NTHash("alice-pass"))
+	require.NoError(t, ioutil.WriteFile(htpasswd, []byte("alice:"+aliceHash+"\nreader:{PLAIN}reader-pass\n"), 0600))
+	aliceRoot := filepath.Join(dir, "alice")
+	users := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(users, []byte(`alice "root=`+aliceRoot+`"`+"\n"+`reader "root=`+aliceRoot+`" read-only`+"\n"), 0600))
+
+	authOpt := auth.DefaultOpt
+	authOpt.Htpasswd = htpasswd
+	authOpt.Users = users
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	s, err := newServer(context.Background(), f, &opt, &authOpt)
+	require.NoError(t, err)
+	require.NoError(t, s.serve())
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	// alice's files are written to her root
+	tc := newTestClient(t, s.Addr(), dialect311)
+	require.Equal(t, statusSuccess, tc.login("alice", "alice-pass"))
+	require.Equal(t, statusSuccess, tc.treeConnect("rclone"))
+	tc.writeFile("alice.txt", "alice data")
+	tc.close()
+	data, err := ioutil.ReadFile(filepath.Join(aliceRoot, "alice.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice data", string(data))
+
+	// the read only user can read them but not write
+	tc = newTestClient(t, s.Addr(), dialect311)
+	require.Equal(t, statusSuccess, tc.login("reader", "reader-pass"))
+	require.Equal(t, statusSuccess, tc.treeConnect("rclone"))
+	assert.Equal(t, "alice data", tc.readFile("alice.txt"))
+	status, _, _ := tc.create("new.txt", fileWriteData, fileCreate, fileNonDirectoryFile)
+	assert.Equal(t, statusAccessDenied, status)
+	tc.close()
+
+	// a bad password or unknown user is refused
+	tc = newTestClient(t, s.Addr(), dialect311)
+	assert.Equal(t, statusLogonFailure, tc.login("alice", "wrong"))
+	tc.close()
+	tc = newTestClient(t, s.Addr(), dialect311)
+	assert.Equal(t, statusLogonFailure, tc.login("nobody", "alice-pass"))
+	tc.close()
+
+	// --user can't be used with the user database
+	opt.User = testUser
+	_, err = newServer(context.Background(), f, &opt, &authOpt)
+	assert.Error(t, err)
+}
+
+func TestSMB1Negotiate(t *testing.T) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+	s := newTestServer(t, dir, "")
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+	c, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	dialects := []byte("\x02NT LM 0.12\x00\x02SMB 2.002\x00\x02SMB 2.???\x00")
+	msg := append([]byte("\xffSMB\x72"), make([]byte, 27)...)
+	msg = append(msg, 0, byte(len(dialects)), 0)
+	msg = append(msg, dialects...)
+	_, err = c.Write(append([]byte{0, 0, 0, byte(len(msg))}, msg...))
+	require.NoError(t, err)
+	frame, err := readFrame(c)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(frame, []byte("\xfeSMB")))
+	assert.Equal(t, uint16(dialectWildcard), binary.LittleEndian.Uint16(frame[headerSize+4:]))
+}
+
+func TestAESCMAC(t *testing.T) {
+	// test vectors from RFC 4493
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411")
+	for _, test := range []struct {
+		length int
+		want   string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+	} {
+		assert.Equal(t, test.want, hex.EncodeToString(aesCMAC(key, msg[:test.length])), test.length)
+	}
+}
+
+func TestNTLMv2(t *testing.T) {
+	// example from [MS-NLMP] 4.2.4
+	ntowf := ntowfv2(auth.NTHash("Password"), "User", "Domain")
+	assert.Equal(t, "0c868a403bfd7a93a3001ef22ef02e3f", hex.EncodeToString(ntowf))
+	var blob smb2.Writer
+	blob.Uint16(0x0101)
+	blob.Zero(6)
+	blob.Uint64(0) // time
+	blob.Append(bytes.Repeat([]byte{0xaa}, 8))
+	blob.Zero(4)
+	for _, av := range []struct {
+		id    uint16
+		value string
+	}{{avNbDomain, "Domain"}, {avNbComputer, "Server"}} {
+		blob.Uint16(av.id)
+		blob.Uint16(uint16(2 * len(av.value)))
+		blob.Append(smb2.EncodeUTF16(av.value))
+	}
+	blob.Zero(8)
+	challenge, _ := hex.DecodeString("0123456789abcdef")
+	proof := ntlmv2Proof(ntowf, challenge, blob.Bytes())
+	assert.Equal(t, "68cd0ab851e51c96aabc927bebef6a1c", hex.EncodeToString(proof))
+	assert.Equal(t, "8de40ccadbc14a82f15cb0ad0de95ca3", hex.EncodeToString(hmacMD5(ntowf, proof)))
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*", "anything", true},
+		{"*.txt", "file.TXT", true},
+		{"*.txt", "file.txt.bak", false},
+		{"FILE?.txt", "file1.txt", true},
+		{"file?.txt", "file12.txt", false},
+		{"file.txt", "FILE.TXT", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{`<.txt`, "name.txt", true},
+		{`name"txt`, "name.txt", true},
+		{`name>>>.txt`, "name.txt", true},
+	} {
+		assert.Equal(t, test.want, matchPattern(test.pattern, test.name), "%q %q", test.pattern, test.name)
+	}
+}
+
+func TestSMBPath(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		want   string
+		status ntStatus
+	}{
+		{"", "", statusSuccess},
+		{`\`, "", statusSuccess},
+		{`dir\file.txt`, "dir/file.txt", statusSuccess},
+		{`\dir\\file.txt\`, "dir/file.txt", statusSuccess},
+		{`file.txt::$DATA`, "file.txt", statusSuccess},
+		{`file.txt:stream`, "", statusObjectNameInvalid},
+		{`dir\..\file.txt`, "", statusObjectNameInvalid},
+		{`wild*.txt`, "", statusObjectNameInvalid},
+	} {
+		got, status := smbPath(test.name)
+		assert.Equal(t, test.status, status, test.name)
+		assert.Equal(t, test.want, got, test.name)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	_, err := readFrame(bytes.NewReader([]byte{1, 0, 0, 0}))
+	assert.Error(t, err)
+	_, err = readFrame(bytes.NewReader([]byte{0, 0xff, 0xff, 0xff}))
+	assert.Error(t, err)
+	frame, err := readFrame(bytes.NewReader([]byte{0, 0, 0, 2, 'h', 'i'}))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(frame))
+	_, err = readFrame(bytes.NewReader([]byte{0, 0, 0, 2, 'h'}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -0,0 +1,256 @@
+// WebDAV locking backed by the VFS
+
+package webdav
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/net/webdav"
+)
+
+// lockToken is the owner of the VFS locks taken for WebDAV locks
+type lockToken string
+
+// lockSystem is a webdav.LockSystem which takes a write lock in the
+// VFS for each WebDAV lock so that the WebDAV locks conflict with the
+// locks taken by the other users of the VFS, such as mounts.
+//
+// The WebDAV locks themselves are managed by webdav.NewMemLS. This
+// also evaluates the ETag and Not conditions of the If header which
+// webdav.NewMemLS doesn't support.
+type lockSystem struct {
+	webdav.LockSystem
+	vfs  *vfs.VFS
+	mu   sync.Mutex
+	held map[string]heldLock // the VFS locks by token
+}
+
+// heldLock describes a WebDAV lock which holds a VFS lock
+type heldLock struct {
+	root      string    // cleaned name of the locked resource
+	zeroDepth bool      // set if the lock doesn't cover the children of root
+	expiry    time.Time // zero for never
+}
+
+// covers returns whether the lock applies to the cleaned name
+func (l heldLock) covers(name string) bool {
+	if name == l.root {
+		return true
+	}
+	if l.zeroDepth {
+		return false
+	}
+	return l.root == "/" || strings.HasPrefix(name, l.root+"/")
+}
+
+// check interface
+var _ webdav.LockSystem = (*lockSystem)(nil)
+
+// newLockSystem makes a lock system for the VFS passed in
+func newLockSystem(VFS *vfs.VFS) *lockSystem {
+	return &lockSystem{
+		LockSystem: webdav.NewMemLS(),
+		vfs:        VFS,
+		held:       make(map[string]heldLock),
+	}
+}
+
+// vfsPath converts a WebDAV lock name into a VFS path
+func vfsPath(name string) string {
+	return strings.Trim(name, "/")
+}
+
+// lockName cleans a WebDAV lock name in the same way as the webdav library
+func lockName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// expiryOf returns the expiry of a lock for duration from now
+func expiryOf(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+// _expire releases the VFS locks of the WebDAV locks which have
+// expired by now.
+//
+// Call with mu held
+func (ls *lockSystem) _expire(now time.Time) {
+	for token, l := range ls.held {
+		if !l.expiry.IsZero() && !l.expiry.After(now) {
+			// The file may have been renamed so release the
+			// locks wherever they are
+			ls.vfs.UnlockAll(lockToken(token))
+			delete(ls.held, token)
+		}
+	}
+}
+
+// _evaluate returns whether the condition from an If header holds
+// for the resource name, ignoring condition.Not.
+//
+// A lock token matches if it is for a lock which covers the resource
+// and an ETag matches if it is the current ETag of the resource.
+//
+// Call with mu held
+func (ls *lockSystem) _evaluate(name string, condition webdav.Condition) bool {
+	if condition.Token != "" {
+		l, ok := ls.held[condition.Token]
+		return ok && l.covers(lockName(name))
+	}
+	node, err := ls.vfs.Stat(vfsPath(name))
+	if err != nil {
+		return false
+	}
+	return etagOf(context.Background(), FileInfo{node}) == condition.ETag
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions, and that holding the union of
+// all of those locks gives exclusive access to all of the named
+// resources.
+//
+// The conditions are a list from an If header which must all be true
+// for name0. The named resources which aren't covered by the lock
+// tokens in the conditions are locked for the duration of the
+// request, as the webdav library does for requests without an If
+// header, so this fails if anyone else has them locked.
+//
+// As well as the WebDAV locks this checks there are no VFS locks on
+// the named resources other than those of the conditions.
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (release func(), err error) {
+	ls.mu.Lock()
+	ls._expire(now)
+	var tokenConditions []webdav.Condition
+	tokens := map[interface{}]struct{}{}
+	for _, condition := range conditions {
+		if ls._evaluate(name0, condition) == condition.Not {
+			ls.mu.Unlock()
+			return nil, webdav.ErrConfirmationFailed
+		}
+		if condition.Token != "" && !condition.Not {
+			tokenConditions = append(tokenConditions, webdav.Condition{Token: condition.Token})
+			tokens[lockToken(condition.Token)] = struct{}{}
+		}
+	}
+	// Find which of the names the lock tokens cover
+	names := [2]string{name0, name1}
+	var covered [2]bool
+	for i, name := range names {
+		for _, condition := range tokenConditions {
+			if name != "" && ls.held[condition.Token].covers(lockName(name)) {
+				covered[i] = true
+			}
+		}
+	}
+	ls.mu.Unlock()
+
+	// Lock the names which aren't covered by the tokens
+	var tempTokens []string
+	unlockTemp := func() {
+		for _, token := range tempTokens {
+			_ = ls.Unlock(now, token)
+		}
+	}
+	for i, name := range names {
+		if name == "" || covered[i] {
+			continue
+		}
+		token, err := ls.Create(now, webdav.LockDetails{Root: name, Duration: -1, ZeroDepth: true})
+		if err != nil {
+			unlockTemp()
+			return nil, webdav.ErrConfirmationFailed
+		}
+		tempTokens = append(tempTokens, token)
+		names[i] = ""
+	}
+
+	// Check there are no other VFS locks on the names which are covered
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, l := range ls.vfs.Locks(vfsPath(name)) {
+			if _, ok := tokens[l.Owner]; !ok {
+				unlockTemp()
+				return nil, webdav.ErrConfirmationFailed
+			}
+		}
+	}
+	releaseLocks := func() {}
+	if names[0] != "" || names[1] != "" {
+		releaseLocks, err = ls.LockSystem.Confirm(now, names[0], names[1], tokenConditions...)
+		if err != nil {
+			unlockTemp()
+			return nil, err
+		}
+	}
+	return func() {
+		releaseLocks()
+		unlockTemp()
+	}, nil
+}
+
+// Create creates a lock with the given depth, duration, owner and
+// root (name).
+//
+// It fails with webdav.ErrLocked if the resource is locked in the VFS.
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (token string, err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls._expire(now)
+	token, err = ls.LockSystem.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	err = ls.vfs.Lock(vfsPath(details.Root), vfs.Lock{Owner: lockToken(token), Write: true, Start: 0, End: vfs.LockEOF})
+	if err != nil {
+		_ = ls.LockSystem.Unlock(now, token)
+		return "", webdav.ErrLocked
+	}
+	ls.held[token] = heldLock{
+		root:      lockName(details.Root),
+		zeroDepth: details.ZeroDepth,
+		expiry:    expiryOf(now, details.Duration),
+	}
+	return token, nil
+}
+
+// Refresh refreshes the lock with the given token.
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls._expire(now)
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err != nil {
+		return details, err
+	}
+	if l, ok := ls.held[token]; ok {
+		l.expiry = expiryOf(now, duration)
+		ls.held[token] = l
+	}
+	return details, nil
+}
+
+// Unlock unlocks the lock with the given token.
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls._expire(now)
+	err := ls.LockSystem.Unlock(now, token)
+	if err != nil && err != webdav.ErrNoSuchLock {
+		return err
+	}
+	if _, ok := ls.held[token]; ok {
+		ls.vfs.UnlockAll(lockToken(token))
+		delete(ls.held, token)
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package webdav
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+type testLockOwner string
+
+func TestLockSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-webdav-lock")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, nil)
+	defer VFS.Shutdown()
+	ls := newLockSystem(VFS)
+	now := time.Now()
+	details := webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true}
+
+	// A WebDAV lock takes a VFS lock
+	token, err := ls.Create(now, details)
+	require.NoError(t, err)
+	assert.Equal(t, []vfs.Lock{{Owner: lockToken(token), Write: true, Start: 0, End: vfs.LockEOF}}, VFS.Locks("file"))
+	assert.Equal(t, vfs.EAGAIN, VFS.Lock("file", vfs.Lock{Owner: testLockOwner("mount"), Start: 0, End: 1}))
+
+	// Which is released on unlock
+	require.NoError(t, ls.Unlock(now, token))
+	assert.Len(t, VFS.Locks("file"), 0)
+
+	// A VFS lock stops WebDAV locks, which the handler also
+	// takes for writes without an If header
+	owner := testLockOwner("mount")
+	require.NoError(t, VFS.Lock("file", vfs.Lock{Owner: owner, Start: 0, End: 1}))
+	_, err = ls.Create(now, details)
+	assert.Equal(t, webdav.ErrLocked, err)
+	VFS.Unlock("file", owner, 0, vfs.LockEOF)
+
+	// A WebDAV lock on a directory doesn't give access to files
+	// locked in the VFS
+	dirToken, err := ls.Create(now, webdav.LockDetails{Root: "/dir", Duration: -1})
+	require.NoError(t, err)
+	release, err := ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: dirToken})
+	require.NoError(t, err)
+	release()
+	require.NoError(t, VFS.Lock("dir/file", vfs.Lock{Owner: owner, Start: 0, End: 1}))
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: dirToken})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	require.NoError(t, ls.Unlock(now, dirToken))
+
+	// The holder of the WebDAV lock can write
+	token, err = ls.Create(now, details)
+	require.NoError(t, err)
+	release, err = ls.Confirm(now, "/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	release()
+
+	// The VFS lock is released when the WebDAV lock expires
+	_, err = ls.Refresh(now, token, time.Second)
+	require.NoError(t, err)
+	_, err = ls.Confirm(now.Add(2*time.Second), "/file", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	assert.Len(t, VFS.Locks("file"), 0)
+}
+
+func TestLockSystemConditions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-webdav-lock")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	require.NoError(t, ioutil.WriteFile(dir+"/file", []byte("contents"), 0666))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, nil)
+	defer VFS.Shutdown()
+	ls := newLockSystem(VFS)
+	now := time.Now()
+	node, err := VFS.Stat("file")
+	require.NoError(t, err)
+	etag := etagOf(context.Background(), FileInfo{node})
+
+	confirm := func(name0, name1 string, conditions ...webdav.Condition) error {
+		release, err := ls.Confirm(now, name0, name1, conditions...)
+		if err == nil {
+			release()
+		}
+		return err
+	}
+
+	// ETags are checked on unlocked resources
+	assert.NoError(t, confirm("/file", "", webdav.Condition{ETag: etag}))
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/file", "", webdav.Condition{ETag: `"wrong"`}))
+	assert.NoError(t, confirm("/file", "", webdav.Condition{Not: true, ETag: `"wrong"`}))
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/missing", "", webdav.Condition{ETag: etag}))
+
+	// The DAV:no-lock idiom
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/file", "", webdav.Condition{Token: "DAV:no-lock"}))
+	assert.NoError(t, confirm("/file", "", webdav.Condition{Not: true, Token: "DAV:no-lock"}))
+
+	// The temporary locks are released afterwards
+	assert.Len(t, VFS.Locks("file"), 0)
+
+	// A locked resource needs the token as well as the ETag
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	require.NoError(t, err)
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/file", "", webdav.Condition{ETag: etag}))
+	assert.NoError(t, confirm("/file", "", webdav.Condition{Token: token}, webdav.Condition{ETag: etag}))
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/file", "", webdav.Condition{Token: token}, webdav.Condition{ETag: `"wrong"`}))
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/file", "", webdav.Condition{Not: true, Token: token}))
+
+	// The token for one resource can be used with an unlocked destination
+	assert.NoError(t, confirm("/file", "/other", webdav.Condition{Token: token}))
+	assert.Len(t, VFS.Locks("other"), 0)
+
+	// But not for a different one
+	assert.Equal(t, webdav.ErrConfirmationFailed, confirm("/other", "", webdav.Condition{Token: token}))
+	require.NoError(t, ls.Unlock(now, token))
+}
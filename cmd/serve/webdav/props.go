@@ -0,0 +1,264 @@
+// Dead properties persisted in the --cache-dir
+
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/file"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	propsBucket  = "props"
+	propsVersion = "1"
+	// namespace of the properties Windows sets with PROPPATCH
+	win32Namespace = "urn:schemas-microsoft-com:"
+)
+
+// propStore stores the dead properties set with PROPPATCH.
+//
+// Remotes can't store arbitrary properties on their objects so they
+// are kept in a bolt database in the --cache-dir alongside the
+// remote, keyed by the path of the file or directory. They are moved
+// and deleted along with the files by the WebDAV server, so changes
+// made to the remote by other means may leave stale properties.
+type propStore struct {
+	db *bolt.DB
+}
+
+// newPropStore opens the property database at dbPath
+func newPropStore(dbPath string) (*propStore, error) {
+	err := os.MkdirAll(filepath.Dir(dbPath), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create property directory")
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open property database %q - is another rclone using it?", dbPath)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(propsBucket))
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create property bucket")
+	}
+	return &propStore{db: db}, nil
+}
+
+// propStorePath returns the path of the database for f in the --cache-dir
+func propStorePath(f fs.Fs) (string, error) {
+	fRoot := filepath.FromSlash(f.Root())
+	if runtime.GOOS == "windows" {
+		if strings.HasPrefix(fRoot, `\\?`) {
+			fRoot = fRoot[3:]
+		}
+		fRoot = strings.Replace(fRoot, ":", "", -1)
+	}
+	cacheDir, err := filepath.Abs(config.CacheDir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make --cache-dir absolute")
+	}
+	return file.UNCPath(filepath.Join(cacheDir, "serve-webdav", f.Name(), fRoot, "props.v"+propsVersion+".db")), nil
+}
+
+// propKey returns the database key for the WebDAV name
+func propKey(name string) []byte {
+	return []byte(vfsPath(name))
+}
+
+// get returns the dead properties of name
+func (ps *propStore) get(name string) (props map[xml.Name]webdav.Property, err error) {
+	err = ps.db.View(func(tx *bolt.Tx) error {
+		props, err = decodeProps(tx.Bucket([]byte(propsBucket)).Get(propKey(name)))
+		return err
+	})
+	return props, err
+}
+
+// decodeProps decodes the properties stored in value
+func decodeProps(value []byte) (map[xml.Name]webdav.Property, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var list []webdav.Property
+	err := json.Unmarshal(value, &list)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode properties")
+	}
+	props := make(map[xml.Name]webdav.Property, len(list))
+	for _, prop := range list {
+		props[prop.XMLName] = prop
+	}
+	return props, nil
+}
+
+// patch applies the patches to the dead properties of name
+func (ps *propStore) patch(name string, patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	err := ps.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(propsBucket))
+		key := propKey(name)
+		props, err := decodeProps(bucket.Get(key))
+		if err != nil {
+			return err
+		}
+		if props == nil {
+			props = make(map[xml.Name]webdav.Property)
+		}
+		for _, patch := range patches {
+			for _, prop := range patch.Props {
+				pstat.Props = append(pstat.Props, webdav.Property{XMLName: prop.XMLName})
+				if patch.Remove {
+					delete(props, prop.XMLName)
+				} else {
+					props[prop.XMLName] = prop
+				}
+			}
+		}
+		if len(props) == 0 {
+			return bucket.Delete(key)
+		}
+		list := make([]webdav.Property, 0, len(props))
+		for _, prop := range props {
+			list = append(list, prop)
+		}
+		value, err := json.Marshal(list)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode properties")
+		}
+		return bucket.Put(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+// forEach calls fn with the key of name and the keys of everything
+// under it
+func forEach(bucket *bolt.Bucket, name string, fn func(key []byte) error) error {
+	key := propKey(name)
+	var keys [][]byte
+	if len(key) == 0 {
+		// the root contains everything
+		err := bucket.ForEach(func(k, _ []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		if bucket.Get(key) != nil {
+			keys = append(keys, key)
+		}
+		prefix := append(append([]byte(nil), key...), '/')
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range keys {
+		err := fn(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the properties of name and everything under it
+func (ps *propStore) remove(name string) error {
+	return ps.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(propsBucket))
+		return forEach(bucket, name, bucket.Delete)
+	})
+}
+
+// rename moves the properties of oldName and everything under it to
+// newName replacing any there already
+func (ps *propStore) rename(oldName, newName string) error {
+	oldKey, newKey := propKey(oldName), propKey(newName)
+	return ps.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(propsBucket))
+		err := forEach(bucket, newName, bucket.Delete)
+		if err != nil {
+			return err
+		}
+		return forEach(bucket, oldName, func(key []byte) error {
+			value := append([]byte(nil), bucket.Get(key)...)
+			err := bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(append(append([]byte(nil), newKey...), key[len(oldKey):]...), value)
+		})
+	})
+}
+
+// close the database
+func (ps *propStore) close() {
+	err := ps.db.Close()
+	if err != nil {
+		fs.Errorf(nil, "serve webdav: failed to close property database: %v", err)
+	}
+}
+
+// DeadProps returns a copy of the dead properties held.
+func (h Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
+	if h.props == nil {
+		return nil, nil
+	}
+	return h.props.get(h.Node().Path())
+}
+
+// Patch patches the dead properties held.
+//
+// If Windows sets the Win32LastModifiedTime property the modification
+// time of the file is set too.
+func (h Handle) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	node := h.Node()
+	if h.props == nil || node.VFS().Opt.ReadOnly {
+		pstat := webdav.Propstat{Status: http.StatusForbidden}
+		for _, patch := range patches {
+			for _, prop := range patch.Props {
+				pstat.Props = append(pstat.Props, webdav.Property{XMLName: prop.XMLName})
+			}
+		}
+		return []webdav.Propstat{pstat}, nil
+	}
+	for _, patch := range patches {
+		if patch.Remove {
+			continue
+		}
+		for _, prop := range patch.Props {
+			if prop.XMLName != (xml.Name{Space: win32Namespace, Local: "Win32LastModifiedTime"}) {
+				continue
+			}
+			modTime, err := http.ParseTime(string(prop.InnerXML))
+			if err != nil {
+				fs.Debugf(node.Path(), "Ignoring bad Win32LastModifiedTime %q: %v", prop.InnerXML, err)
+				continue
+			}
+			err = node.SetModTime(modTime)
+			if err != nil {
+				fs.Debugf(node.Path(), "Failed to set modification time from Win32LastModifiedTime: %v", err)
+			}
+		}
+	}
+	return h.props.patch(node.Path(), patches)
+}
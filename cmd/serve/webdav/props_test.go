@@ -0,0 +1,231 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestPropStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-webdav-props")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dbPath := filepath.Join(dir, "props.db")
+	ps, err := newPropStore(dbPath)
+	require.NoError(t, err)
+
+	author := xml.Name{Space: "http://example.com/", Local: "author"}
+	colour := xml.Name{Space: "http://example.com/", Local: "colour"}
+	set := func(name string, props ...webdav.Property) {
+		pstats, err := ps.patch(name, []webdav.Proppatch{{Props: props}})
+		require.NoError(t, err)
+		require.Len(t, pstats, 1)
+		assert.Equal(t, http.StatusOK, pstats[0].Status)
+	}
+	get := func(name string) map[xml.Name]webdav.Property {
+		props, err := ps.get(name)
+		require.NoError(t, err)
+		return props
+	}
+
+	set("/dir/file", webdav.Property{XMLName: author, InnerXML: []byte("Nick")}, webdav.Property{XMLName: colour, Lang: "en", InnerXML: []byte("red")})
+	set("/dir/sub/file2", webdav.Property{XMLName: author, InnerXML: []byte("Sam")})
+	set("/dirx", webdav.Property{XMLName: author, InnerXML: []byte("Alex")})
+	assert.Equal(t, map[xml.Name]webdav.Property{
+		author: {XMLName: author, InnerXML: []byte("Nick")},
+		colour: {XMLName: colour, Lang: "en", InnerXML: []byte("red")},
+	}, get("/dir/file"))
+	assert.Nil(t, get("/dir"))
+
+	// removing properties
+	pstats, err := ps.patch("/dir/file", []webdav.Proppatch{{Remove: true, Props: []webdav.Property{{XMLName: colour}}}})
+	require.NoError(t, err)
+	assert.Equal(t, []webdav.Propstat{{Status: http.StatusOK, Props: []webdav.Property{{XMLName: colour}}}}, pstats)
+	assert.Len(t, get("/dir/file"), 1)
+
+	// renaming a directory moves its children but not similar names
+	require.NoError(t, ps.rename("/dir", "/new"))
+	assert.Nil(t, get("/dir/file"))
+	assert.Len(t, get("/new/file"), 1)
+	assert.Len(t, get("/new/sub/file2"), 1)
+	assert.Len(t, get("/dirx"), 1)
+
+	// the properties persist
+	ps.close()
+	ps, err = newPropStore(dbPath)
+	require.NoError(t, err)
+	defer ps.close()
+	assert.Len(t, get("/new/file"), 1)
+
+	// removing a directory removes its children
+	require.NoError(t, ps.remove("/new"))
+	assert.Nil(t, get("/new/file"))
+	assert.Nil(t, get("/new/sub/file2"))
+	assert.Len(t, get("/dirx"), 1)
+	require.NoError(t, ps.remove("/"))
+	assert.Nil(t, get("/dirx"))
+}
+
+// davRequest makes a WebDAV request returning the status and body
+func davRequest(t *testing.T, method, url, body string, headers ...string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode, string(data)
+}
+
+func TestDeadProps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-webdav-props")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	served := filepath.Join(dir, "served")
+	require.NoError(t, os.Mkdir(served, 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(served, "file.txt"), []byte("contents"), 0666))
+
+	f, err := fs.NewFs(context.Background(), served)
+	require.NoError(t, err)
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	w, err := newWebDAV(context.Background(), f, &opt)
+	require.NoError(t, err)
+	require.NoError(t, w.serve())
+	defer func() {
+		w.Close()
+		w.Wait()
+	}()
+	url := w.Server.URL()
+
+	// Set a custom property and the Windows modification time
+	status, body := davRequest(t, "PROPPATCH", url+"file.txt", `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:" xmlns:E="http://example.com/">
+<D:set><D:prop>
+<E:author>Nick</E:author>
+<Z:Win32LastModifiedTime>Sat, 03 Feb 2001 04:05:06 GMT</Z:Win32LastModifiedTime>
+</D:prop></D:set>
+</D:propertyupdate>`)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "200 OK")
+	assert.NotContains(t, body, "403")
+
+	// The file wasn't changed apart from its modification time
+	data, err := ioutil.ReadFile(filepath.Join(served, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "contents", string(data))
+	fi, err := os.Stat(filepath.Join(served, "file.txt"))
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)), fi.ModTime())
+
+	// The property is returned by PROPFIND
+	propfind := `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:" xmlns:E="http://example.com/"><D:prop><E:author/></D:prop></D:propfind>`
+	status, body = davRequest(t, "PROPFIND", url+"file.txt", propfind, "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, ">Nick</author>")
+
+	// And follows the file when it is moved
+	status, _ = davRequest(t, "MOVE", url+"file.txt", "", "Destination", url+"moved.txt")
+	assert.Equal(t, http.StatusCreated, status)
+	status, body = davRequest(t, "PROPFIND", url+"moved.txt", propfind, "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, ">Nick</author>")
+
+	// And is copied with it
+	status, _ = davRequest(t, "COPY", url+"moved.txt", "", "Destination", url+"copied.txt")
+	assert.Equal(t, http.StatusCreated, status)
+	status, body = davRequest(t, "PROPFIND", url+"copied.txt", propfind, "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, ">Nick</author>")
+
+	// And is deleted with it
+	status, _ = davRequest(t, "DELETE", url+"moved.txt", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = davRequest(t, "PUT", url+"moved.txt", "new")
+	assert.Equal(t, http.StatusCreated, status)
+	status, body = davRequest(t, "PROPFIND", url+"moved.txt", propfind, "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.NotContains(t, body, ">Nick</author>")
+	assert.Contains(t, body, "404 Not Found")
+}
+
+func TestIfHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-webdav-if")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+	served := filepath.Join(dir, "served")
+	require.NoError(t, os.Mkdir(served, 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(served, "file.txt"), []byte("contents"), 0666))
+	f, err := fs.NewFs(context.Background(), served)
+	require.NoError(t, err)
+	opt := httplib.DefaultOpt
+	opt.ListenAddr = testBindAddress
+	w, err := newWebDAV(context.Background(), f, &opt)
+	require.NoError(t, err)
+	require.NoError(t, w.serve())
+	defer func() {
+		w.Close()
+		w.Wait()
+	}()
+	url := w.Server.URL()
+
+	req, err := http.NewRequest("HEAD", url+"file.txt", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	etag := resp.Header.Get("ETag")
+	require.NotEqual(t, "", etag)
+
+	// A PUT conditional on a stale ETag fails
+	status, _ := davRequest(t, "PUT", url+"file.txt", "stale", "If", `(["wrong"])`)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+
+	// But succeeds with the current one
+	status, _ = davRequest(t, "PUT", url+"file.txt", "updated", "If", `([`+etag+`])`)
+	assert.Equal(t, http.StatusCreated, status)
+	data, err := ioutil.ReadFile(filepath.Join(served, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "updated", string(data))
+
+	// And the old one is no longer current
+	status, _ = davRequest(t, "PUT", url+"file.txt", "again", "If", `([`+etag+`])`)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	status, _ = davRequest(t, "PUT", url+"file.txt", "again", "If", `(Not [`+etag+`])`)
+	assert.Equal(t, http.StatusCreated, status)
+}
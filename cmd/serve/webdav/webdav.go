@@ -3,12 +3,16 @@ package webdav
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/auth"
+	"github.com/rclone/rclone/cmd/serve/auth/authflags"
 	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/cmd/serve/httplib/httpflags"
 	"github.com/rclone/rclone/cmd/serve/httplib/serve"
@@ -35,6 +39,7 @@ func init() {
 	httpflags.AddFlags(flagSet)
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
+	authflags.AddFlags(flagSet)
 	flags.StringVarP(flagSet, &hashName, "etag-hash", "", "", "Which hash to use for the ETag, or auto or blank for off")
 	flags.BoolVarP(flagSet, &disableGETDir, "disable-dir-list", "", false, "Disable HTML directory list on GET request for a directory")
 }
@@ -62,7 +67,25 @@ supported hash on the backend or you can use a named hash such as
 
 Use "rclone hashsum" to see the full list.
 
-` + httplib.Help + vfs.Help + proxy.Help,
+### Locking and properties
+
+The server supports WebDAV class 2 locking with LOCK and UNLOCK,
+which Windows Explorer and Microsoft Office need to edit files. The
+locks are also taken in the VFS so they conflict with the locks taken
+by other users of it, such as the other rclone servers.
+
+The If header is evaluated for lock tokens, ETags and "Not"
+conditions, so clients can make their requests conditional on the
+locks they hold or on the version of the resource they last saw.
+
+Remotes can't store arbitrary properties so the dead properties set
+with PROPPATCH are kept in a database in the --cache-dir and moved
+and deleted along with their files. Changes made to the remote by
+other means, eg with a mount, may leave stale properties behind.
+When Windows sets the Win32LastModifiedTime property the
+modification time of the file is set too.
+
+` + httplib.Help + serve.ArchiveHelp + vfs.Help + proxy.Help + auth.Help,
 	RunE: func(command *cobra.Command, args []string) error {
 		var f fs.Fs
 		if proxyflags.Opt.AuthProxy == "" {
@@ -84,8 +107,11 @@ Use "rclone hashsum" to see the full list.
 			fs.Debugf(f, "Using hash %v for ETag", hashType)
 		}
 		cmd.Run(false, false, command, func() error {
-			s := newWebDAV(context.Background(), f, &httpflags.Opt)
-			err := s.serve()
+			s, err := newWebDAV(context.Background(), f, &httpflags.Opt)
+			if err != nil {
+				return err
+			}
+			err = s.serve()
 			if err != nil {
 				return err
 			}
@@ -114,24 +140,44 @@ type WebDAV struct {
 	_vfs          *vfs.VFS // don't use directly, use getVFS
 	webdavhandler *webdav.Handler
 	proxy         *proxy.Proxy
+	authDB        *auth.DB        // user database if set
 	ctx           context.Context // for global config
+
+	mu          sync.Mutex
+	lockSystems map[*vfs.VFS]*lockSystem // lock system for each VFS
+	propStores  map[string]*propStore    // property store by database path, nil if it failed to open
 }
 
 // check interface
 var _ webdav.FileSystem = (*WebDAV)(nil)
 
 // Make a new WebDAV to serve the remote
-func newWebDAV(ctx context.Context, f fs.Fs, opt *httplib.Options) *WebDAV {
+func newWebDAV(ctx context.Context, f fs.Fs, opt *httplib.Options) (*WebDAV, error) {
 	w := &WebDAV{
-		f:   f,
-		ctx: ctx,
+		f:           f,
+		ctx:         ctx,
+		lockSystems: make(map[*vfs.VFS]*lockSystem),
+		propStores:  make(map[string]*propStore),
 	}
 	if proxyflags.Opt.AuthProxy != "" {
+		if authflags.Opt.Enabled() {
+			return nil, errors.New("can't use --auth-proxy with the user database")
+		}
 		w.proxy = proxy.New(ctx, &proxyflags.Opt)
 		// override auth
 		copyOpt := *opt
 		copyOpt.Auth = w.auth
 		opt = &copyOpt
+	} else if authflags.Opt.Enabled() {
+		var err error
+		w.authDB, err = auth.New(ctx, f, &authflags.Opt)
+		if err != nil {
+			return nil, err
+		}
+		// override auth
+		copyOpt := *opt
+		copyOpt.Auth = w.auth
+		opt = &copyOpt
 	} else {
 		w._vfs = vfs.New(f, &vfsflags.Opt)
 	}
@@ -139,11 +185,10 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *httplib.Options) *WebDAV {
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.Server.Opt.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
-	return w
+	return w, nil
 }
 
 // Gets the VFS in use for this request
@@ -155,15 +200,69 @@ func (w *WebDAV) getVFS(ctx context.Context) (VFS *vfs.VFS, err error) {
 	if value == nil {
 		return nil, errors.New("no VFS found in context")
 	}
-	VFS, ok := value.(*vfs.VFS)
+	switch value := value.(type) {
+	case *vfs.VFS:
+		return value, nil
+	case *auth.User:
+		return value.VFS, nil
+	}
+	return nil, errors.Errorf("context value is not VFS: %#v", value)
+}
+
+// Gets the lock system for the VFS, making it if necessary
+func (w *WebDAV) getLockSystem(VFS *vfs.VFS) *lockSystem {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ls, ok := w.lockSystems[VFS]
 	if !ok {
-		return nil, errors.Errorf("context value is not VFS: %#v", value)
+		ls = newLockSystem(VFS)
+		w.lockSystems[VFS] = ls
 	}
-	return VFS, nil
+	return ls
 }
 
-// auth does proxy authorization
+// Gets the property store for the VFS, opening it if necessary
+//
+// This returns nil if the store couldn't be opened.
+func (w *WebDAV) getPropStore(VFS *vfs.VFS) *propStore {
+	dbPath, err := propStorePath(VFS.Fs())
+	if err != nil {
+		fs.Errorf(nil, "serve webdav: dead properties disabled: %v", err)
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ps, ok := w.propStores[dbPath]
+	if !ok {
+		ps, err = newPropStore(dbPath)
+		if err != nil {
+			fs.Errorf(nil, "serve webdav: dead properties disabled: %v", err)
+		} else {
+			fs.Debugf(VFS.Fs(), "serve webdav: property database is %q", dbPath)
+		}
+		w.propStores[dbPath] = ps
+	}
+	return ps
+}
+
+// Close shuts the server down and closes the property stores
+func (w *WebDAV) Close() {
+	w.Server.Close()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dbPath, ps := range w.propStores {
+		if ps != nil {
+			ps.close()
+		}
+		delete(w.propStores, dbPath)
+	}
+}
+
+// auth does proxy or user database authorization
 func (w *WebDAV) auth(user, pass string) (value interface{}, err error) {
+	if w.authDB != nil {
+		return w.authDB.Login(user, pass)
+	}
 	VFS, _, err := w.proxy.Call(user, pass, false)
 	if err != nil {
 		return nil, err
@@ -176,13 +275,32 @@ func (w *WebDAV) handler(rw http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if u, ok := r.Context().Value(httplib.ContextAuthKey).(*auth.User); ok {
+		rw, r = u.LimitHTTP(rw, r)
+	}
 	isDir := strings.HasSuffix(urlPath, "/")
 	remote := strings.Trim(urlPath, "/")
+	if r.Method == "GET" || r.Method == "HEAD" {
+		if format := r.URL.Query().Get("archive"); format != "" {
+			w.serveArchive(rw, r, remote, format)
+			return
+		}
+	}
 	if !disableGETDir && (r.Method == "GET" || r.Method == "HEAD") && isDir {
 		w.serveDir(rw, r, remote)
 		return
 	}
-	w.webdavhandler.ServeHTTP(rw, r)
+	VFS, err := w.getVFS(r.Context())
+	if err != nil {
+		http.Error(rw, "VFS not found", http.StatusInternalServerError)
+		fs.Errorf(nil, "Failed to serve: %v", err)
+		return
+	}
+	// Use the lock system for this VFS so the WebDAV locks are
+	// shared with the other users of it
+	webdavHandler := *w.webdavhandler
+	webdavHandler.LockSystem = w.getLockSystem(VFS)
+	webdavHandler.ServeHTTP(rw, r)
 }
 
 // serveDir serves a directory index at dirRemote
@@ -232,6 +350,29 @@ func (w *WebDAV) serveDir(rw http.ResponseWriter, r *http.Request, dirRemote str
 	directory.Serve(rw, r)
 }
 
+// serveArchive serves the directory at dirRemote as an archive
+func (w *WebDAV) serveArchive(rw http.ResponseWriter, r *http.Request, dirRemote string, format string) {
+	VFS, err := w.getVFS(r.Context())
+	if err != nil {
+		http.Error(rw, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to serve archive: %v", err)
+		return
+	}
+	node, err := VFS.Stat(dirRemote)
+	if err == vfs.ENOENT {
+		http.Error(rw, "Directory not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		serve.Error(dirRemote, rw, "Failed to find directory", err)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(rw, "Not a directory", http.StatusNotFound)
+		return
+	}
+	serve.Archive(rw, r, node.(*vfs.Dir), format)
+}
+
 // serve runs the http server in the background.
 //
 // Use s.Close() and s.Wait() to shutdown server
@@ -271,11 +412,17 @@ func (w *WebDAV) OpenFile(ctx context.Context, name string, flags int, perm os.F
 	if err != nil {
 		return nil, err
 	}
+	// The webdav library only opens files for writing without
+	// O_TRUNC to patch their properties, so open them read only to
+	// stop the VFS uploading them again.
+	if flags&(os.O_WRONLY|os.O_RDWR) != 0 && flags&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		flags = os.O_RDONLY
+	}
 	f, err := VFS.OpenFile(name, flags, perm)
 	if err != nil {
 		return nil, err
 	}
-	return Handle{f}, nil
+	return Handle{Handle: f, props: w.getPropStore(VFS)}, nil
 }
 
 // RemoveAll removes a file or a directory and its contents
@@ -293,6 +440,12 @@ func (w *WebDAV) RemoveAll(ctx context.Context, name string) (err error) {
 	if err != nil {
 		return err
 	}
+	if ps := w.getPropStore(VFS); ps != nil {
+		err = ps.remove(name)
+		if err != nil {
+			fs.Errorf(name, "serve webdav: failed to remove properties: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -303,7 +456,17 @@ func (w *WebDAV) Rename(ctx context.Context, oldName, newName string) (err error
 	if err != nil {
 		return err
 	}
-	return VFS.Rename(oldName, newName)
+	err = VFS.Rename(oldName, newName)
+	if err != nil {
+		return err
+	}
+	if ps := w.getPropStore(VFS); ps != nil {
+		err = ps.rename(oldName, newName)
+		if err != nil {
+			fs.Errorf(oldName, "serve webdav: failed to move properties to %q: %v", newName, err)
+		}
+	}
+	return nil
 }
 
 // Stat returns info about the file or directory
@@ -321,8 +484,12 @@ func (w *WebDAV) Stat(ctx context.Context, name string) (fi os.FileInfo, err err
 }
 
 // Handle represents an open file
+//
+// It implements webdav.DeadPropsHolder to store the dead properties
+// in the property store.
 type Handle struct {
 	vfs.Handle
+	props *propStore // nil if dead properties aren't available
 }
 
 // Readdir reads directory entries from the handle
@@ -376,6 +543,16 @@ func (fi FileInfo) ETag(ctx context.Context) (etag string, err error) {
 	return `"` + hash + `"`, nil
 }
 
+// etagOf returns the ETag the webdav library sends for fi
+func etagOf(ctx context.Context, fi FileInfo) string {
+	etag, err := fi.ETag(ctx)
+	if err == nil {
+		return etag
+	}
+	// This is the fallback the webdav library uses
+	return fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
 // ContentType returns a content type for the FileInfo
 func (fi FileInfo) ContentType(ctx context.Context) (contentType string, err error) {
 	// defer log.Trace(fi, "")("etag=%q, err=%v", &contentType, &err)
@@ -21,6 +21,7 @@ import (
 	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/cmd/serve/servetest"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/filter"
@@ -47,6 +48,18 @@ var (
 // TestWebDav runs the webdav server then runs the unit tests for the
 // webdav remote against it.
 func TestWebDav(t *testing.T) {
+	// Keep the property databases out of the real --cache-dir
+	cacheDir, err := ioutil.TempDir("", "rclone-webdav-cache")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(cacheDir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = cacheDir
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+
 	// Configure and start the server
 	start := func(f fs.Fs) (configmap.Simple, func()) {
 		opt := httplib.DefaultOpt
@@ -57,7 +70,8 @@ func TestWebDav(t *testing.T) {
 		hashType = hash.MD5
 
 		// Start the server
-		w := newWebDAV(context.Background(), f, &opt)
+		w, err := newWebDAV(context.Background(), f, &opt)
+		require.NoError(t, err)
 		assert.NoError(t, w.serve())
 
 		// Config for the backend we'll use to connect to the server
@@ -102,7 +116,8 @@ func TestHTTPFunction(t *testing.T) {
 	opt.Template = testTemplate
 
 	// Start the server
-	w := newWebDAV(context.Background(), f, &opt)
+	w, err := newWebDAV(context.Background(), f, &opt)
+	require.NoError(t, err)
 	assert.NoError(t, w.serve())
 	defer func() {
 		w.Close()
@@ -126,6 +141,13 @@ func TestHTTPFunction(t *testing.T) {
 	}
 
 	HelpTestGET(t, testURL)
+
+	// Directories can be downloaded as archives
+	status, body := davRequest(t, "GET", testURL+"three/?archive=tar", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "three/a.txt")
+	status, _ = davRequest(t, "GET", testURL+"two.txt?archive=tar", "")
+	assert.Equal(t, http.StatusNotFound, status)
 }
 
 // check body against the file, or re-write body if -updategolden is
@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	commandDefinition.AddCommand(createCommand)
+	commandDefinition.AddCommand(listCommand)
+	commandDefinition.AddCommand(restoreCommand)
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "snapshot",
+	Short: `Make, list and restore point in time snapshots of a path.`,
+	Long: `
+Keep incremental, timestamped snapshots of a path on any remote.
+
+Each snapshot is stored as a directory named by the time it was made,
+eg ` + "`2021-01-02T030405Z`" + `, holding the files which were new or changed
+since the last snapshot as plain files, and a manifest
+` + "`2021-01-02T030405Z.json`" + ` listing every file in the snapshot and
+which snapshot directory it is stored in.  Unchanged files aren't
+copied again, so each snapshot only takes up the space of what
+changed, and all the files can still be browsed and read on the
+remote without rclone.
+
+    rclone snapshot create /home/user remote:backups
+    rclone snapshot list remote:backups
+    rclone snapshot restore remote:backups latest /tmp/restored
+
+Files are compared by size and modification time, or by hash with
+` + "`--checksum`" + `.
+
+Note that deleting a snapshot directory will lose the files in later
+snapshots which refer to it.
+`,
+}
+
+var createCommand = &cobra.Command{
+	Use:   "create source:path dest:path",
+	Short: `Make a snapshot of source:path in dest:path.`,
+	Long: `
+Make a new snapshot of source:path in dest:path copying only the files
+which are new or have changed since the last snapshot.  The ID of the
+snapshot is printed when it is done.
+
+If any files fail to copy the snapshot isn't saved, so the next run
+copies them again.
+
+Filters apply to the files in source:path.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc, fdst := cmd.NewFsSrcDst(args)
+		cmd.Run(true, true, command, func() error {
+			id, err := snapshot.Create(context.Background(), fdst, fsrc)
+			if err != nil {
+				return err
+			}
+			fmt.Println(id)
+			return nil
+		})
+	},
+}
+
+var listCommand = &cobra.Command{
+	Use:   "list dest:path",
+	Short: `List the snapshots in dest:path.`,
+	Long: `
+List the snapshots in dest:path oldest first, showing the number and
+total size of the files in each and how many of them were stored in
+it because they were new or changed.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsDir(args)
+		cmd.Run(false, false, command, func() error {
+			infos, err := snapshot.List(context.Background(), f)
+			if err != nil {
+				return err
+			}
+			for _, info := range infos {
+				fmt.Printf("%s %8d files %9v %8d changed %9v\n", info.ID, info.Files, fs.SizeSuffix(info.Size), info.Stored, fs.SizeSuffix(info.StoredSize))
+			}
+			return nil
+		})
+	},
+}
+
+var restoreCommand = &cobra.Command{
+	Use:   "restore dest:path ID target:path",
+	Short: `Restore a snapshot from dest:path to target:path.`,
+	Long: `
+Copy the files in snapshot ID of dest:path to target:path.  Use
+` + "`latest`" + ` as the ID to restore the newest snapshot.
+
+Files in target:path which are the same as in the snapshot aren't
+copied and files which aren't in the snapshot are left alone.  Use
+filters to restore only some of the files, eg
+
+    rclone snapshot restore remote:backups latest /tmp/restored --include "/docs/**"
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(3, 3, command, args)
+		fsnap := cmd.NewFsDir(args[:1])
+		fdst := cmd.NewFsDir(args[2:])
+		cmd.Run(true, true, command, func() error {
+			return snapshot.Restore(context.Background(), fdst, fsnap, args[1])
+		})
+	},
+}
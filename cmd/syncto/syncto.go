@@ -0,0 +1,53 @@
+package syncto
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "syncto source:path dest:path [dest:path...]",
+	Short: `Make several destinations identical to the source, reading it once.`,
+	Long: `
+Sync the source to each of the destinations, changing the destinations
+only. This works like running ` + "`rclone sync`" + ` once for each destination,
+but the source is only listed once and each file which needs
+transferring is read from the source once and uploaded to all the
+destinations which need it in parallel, eg to replicate a bucket to
+three regions
+
+    rclone syncto -i s3:bucket s3-eu:bucket s3-us:bucket s3-ap:bucket
+
+Errors are tracked for each destination. A destination with errors
+doesn't have files deleted from it, but the other destinations are
+synced as usual. The destinations which failed are listed at the end.
+
+Destinations which can copy from the source server-side do so rather
+than sharing the upload.
+
+**Important**: Since this can cause data loss, test first with the
+` + "`--dry-run` or the `--interactive`/`-i`" + ` flag.
+
+` + "`--backup-dir`, `--suffix` and `--backup-versioning`" + ` can't be used with
+this command.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 1e6, command, args)
+		fsrc := cmd.NewFsSrc(args)
+		fdsts := make([]fs.Fs, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			fdsts = append(fdsts, cmd.NewFsDir([]string{arg}))
+		}
+		cmd.Run(true, true, command, func() error {
+			return sync.SyncTo(context.Background(), fdsts, fsrc)
+		})
+	},
+}
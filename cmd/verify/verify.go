@@ -0,0 +1,93 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sample   = 100.0
+	hashType = hash.None
+	sumFile  = ""
+	reportOK = false
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	cmdFlags := commandDefinition.Flags()
+	flags.Float64VarP(cmdFlags, &sample, "sample", "", sample, "Percentage of the objects to read, chosen at random")
+	flags.FVarP(cmdFlags, &hashType, "hash-type", "", "Hash to verify with, eg MD5 or SHA-1")
+	flags.StringVarP(cmdFlags, &sumFile, "sum-file", "", sumFile, "Verify against the hashes in this local sum file")
+	flags.BoolVarP(cmdFlags, &reportOK, "report-ok", "", reportOK, "Report objects which are OK as well as problems")
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "verify remote:path",
+	Short: `Read back the objects in remote:path to check they aren't corrupt.`,
+	Long: `
+Reads the data of every object in remote:path and checks its hash
+against the hash stored on the remote, to find objects which have
+been damaged since they were uploaded ("bit rot").
+
+Remotes which calculate their hashes from the data, such as local
+disks, can't be checked against their own hashes. Verify these
+against a sum file as made by ` + "`rclone hashsum`" + ` or md5sum with
+` + "`--sum-file`" + `, or against the hashes kept with ` + "`--checksum-db`" + `.
+
+    rclone hashsum MD5 /data > data.md5
+    rclone verify --sum-file data.md5 /data
+
+Objects in the sum file which aren't found are reported as missing.
+
+Use ` + "`--sample`" + ` to read a random percentage of the objects each
+run, eg ` + "`--sample 5`" + ` to scrub a big remote a little at a time.
+
+Each problem found is written to standard output as a line of JSON
+like this
+
+    {"path":"dir/file.txt","status":"corrupt","hashType":"MD5","expected":"...","actual":"..."}
+
+where status is one of ` + "`corrupt`, `missing`, `error` or `unverifiable`" + `.
+Use ` + "`--report-ok`" + ` to write a line for objects which are ` + "`ok`" + ` too.
+
+The exit code is non zero if any objects were corrupt, missing or
+couldn't be read, and is the checksum exit code if any were corrupt.
+
+To scrub a remote regularly, run the ` + "`operations/verify`" + ` rc call
+as an async job against ` + "`rclone rcd`" + `.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fsrc := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			opt := operations.VerifyOpt{
+				HashType: hashType,
+				Sample:   sample,
+				ReportOK: reportOK,
+			}
+			if sumFile != "" {
+				var err error
+				opt.Sums, err = operations.ReadSumFile(sumFile)
+				if err != nil {
+					return err
+				}
+				if opt.HashType == hash.None {
+					opt.HashType = hash.MD5
+				}
+			}
+			out := json.NewEncoder(os.Stdout)
+			opt.Report = func(result operations.VerifyResult) {
+				_ = out.Encode(result)
+			}
+			_, err := operations.Verify(context.Background(), fsrc, &opt)
+			return err
+		})
+	},
+}
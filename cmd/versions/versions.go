@@ -0,0 +1,144 @@
+package versions
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	at        = fs.DurationOff
+	keep      = 0
+	olderThan = fs.DurationOff
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	commandDefinition.AddCommand(listCommand)
+	commandDefinition.AddCommand(restoreCommand)
+	restoreFlags := restoreCommand.Flags()
+	flags.FVarP(restoreFlags, &at, "at", "", "Restore the file as it was at this time or this long ago")
+	commandDefinition.AddCommand(purgeCommand)
+	purgeFlags := purgeCommand.Flags()
+	flags.IntVarP(purgeFlags, &keep, "keep", "", keep, "Number of the newest versions of each file to keep")
+	flags.FVarP(purgeFlags, &olderThan, "older-than", "", "Only delete versions replaced longer ago than this")
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "versions",
+	Short: `Manage the versions of files kept by --backup-versioning.`,
+	Long: `
+When ` + "`--backup-versioning`" + ` is used with ` + "`sync`, `copy` or `move`" + `
+files in the destination which would have been overwritten or deleted
+are instead renamed in place with the time they were replaced added
+before the extension, so ` + "`file.txt`" + ` becomes
+` + "`file-v2021-01-02-150405-000.txt`" + `. The time is in UTC.
+
+These commands list, restore and delete those versions.
+`,
+}
+
+var listCommand = &cobra.Command{
+	Use:   "list remote:path",
+	Short: `List the versions of the files in remote:path.`,
+	Long: `
+List the versions of the files in remote:path, grouped by the file
+they are a version of, oldest first. Each version is shown with the
+time it was replaced, its size and its name.
+
+    $ rclone versions list remote:path
+    file.txt
+      2021-01-02 15:04:05.000        1234 file-v2021-01-02-150405-000.txt
+      2021-01-03 09:00:00.123        1240 file-v2021-01-03-090000-123.txt
+
+The times are shown in local time.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fsrc := cmd.NewFsSrc(args)
+		cmd.Run(false, false, command, func() error {
+			return list(context.Background(), fsrc)
+		})
+	},
+}
+
+// list prints the versions of the files in f
+func list(ctx context.Context, f fs.Fs) error {
+	versions, err := operations.ListVersions(ctx, f, "", true)
+	if err != nil {
+		return err
+	}
+	remotes := make([]string, 0, len(versions))
+	for remote := range versions {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	for _, remote := range remotes {
+		fmt.Fprintln(os.Stdout, remote)
+		for _, v := range versions[remote] {
+			fmt.Fprintf(os.Stdout, "  %s %11d %s\n", v.Time.Local().Format("2006-01-02 15:04:05.000"), v.Object.Size(), v.Object.Remote())
+		}
+	}
+	return nil
+}
+
+var restoreCommand = &cobra.Command{
+	Use:   "restore remote:path/file",
+	Short: `Restore a file from one of its versions.`,
+	Long: `
+Restore remote:path/file from one of its versions, which also brings
+back a file which has been deleted.
+
+Without ` + "`--at`" + ` the newest version is restored, which undoes the last
+change to the file. With ` + "`--at`" + ` the file is restored to how it was
+at that time, which may be given as a time ago, eg ` + "`2d`" + `, or as a date,
+eg ` + "`2021-01-02 15:04:05`" + `.
+
+The file being replaced is kept as a new version so a restore can
+itself be undone.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fdst, fileName := cmd.NewFsDstFile(args)
+		cmd.Run(true, false, command, func() error {
+			var atTime time.Time
+			if at.IsSet() {
+				atTime = time.Now().Add(-time.Duration(at))
+			}
+			return operations.RestoreVersion(context.Background(), fdst, fileName, atTime)
+		})
+	},
+}
+
+var purgeCommand = &cobra.Command{
+	Use:   "purge remote:path",
+	Short: `Delete the versions of the files in remote:path.`,
+	Long: `
+Delete the versions of the files in remote:path. The files themselves
+are not touched.
+
+Use ` + "`--keep N`" + ` to keep the newest N versions of each file and
+` + "`--older-than`" + ` to only delete versions replaced longer ago than
+the time given, eg
+
+    rclone versions purge --keep 3 --older-than 30d remote:path
+
+**Important**: Since this can cause data loss, test first with the
+` + "`--dry-run` or the `--interactive`/`-i`" + ` flag.
+`,
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fsrc := cmd.NewFsSrc(args)
+		cmd.Run(true, false, command, func() error {
+			return operations.PurgeVersions(context.Background(), fsrc, keep, olderThan)
+		})
+	},
+}
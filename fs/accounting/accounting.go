@@ -12,7 +12,6 @@ import (
 	"github.com/rclone/rclone/fs/rc"
 	"golang.org/x/time/rate"
 
-	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/asyncreader"
 	"github.com/rclone/rclone/fs/fserrors"
@@ -20,7 +19,7 @@ import (
 
 // ErrorMaxTransferLimitReached defines error when transfer limit is reached.
 // Used for checking on exit and matching to correct exit code.
-var ErrorMaxTransferLimitReached = errors.New("Max transfer limit reached as set by --max-transfer")
+var ErrorMaxTransferLimitReached = fserrors.NewCodedError("Max transfer limit reached as set by --max-transfer", fserrors.CodeMaxTransfer)
 
 // ErrorMaxTransferLimitReachedFatal is returned from Read when the max
 // transfer limit is reached.
@@ -48,10 +47,17 @@ type Account struct {
 	name    string
 	closed  bool          // set if the file is closed
 	exit    chan struct{} // channel that will be closed when transfer is finished
+	done    chan struct{} // channel that is closed when averageLoop has finished
 	withBuf bool          // is using a buffered in
 
 	tokenBucket *rate.Limiter // per file bandwidth limiter (may be nil)
 
+	src string // name of the remote read from (may be "")
+	dst string // name of the remote written to (may be "")
+
+	srcLimit *remoteLimit // bandwidth limit of the remote read from (may be nil)
+	dstLimit *remoteLimit // bandwidth limit of the remote written to (may be nil)
+
 	values accountValues
 }
 
@@ -81,6 +87,7 @@ func newAccountSizeName(ctx context.Context, stats *StatsInfo, in io.ReadCloser,
 		size:   size,
 		name:   name,
 		exit:   make(chan struct{}),
+		done:   make(chan struct{}),
 		values: accountValues{
 			avg:    0,
 			lpTime: time.Now(),
@@ -196,6 +203,7 @@ func (acc *Account) UpdateReader(ctx context.Context, in io.ReadCloser) {
 func (acc *Account) averageLoop() {
 	tick := time.NewTicker(time.Second)
 	var period float64
+	defer close(acc.done)
 	defer tick.Stop()
 	for {
 		select {
@@ -303,9 +311,15 @@ func (acc *Account) accountRead(n int) {
 	acc.values.mu.Unlock()
 
 	acc.stats.Bytes(int64(n))
+	acc.stats.remotes.addBytes(acc.src, int64(n), false)
+	acc.stats.remotes.addBytes(acc.dst, int64(n), true)
 
 	limitBandwidth(n)
 	acc.limitPerFileBandwidth(n)
+	acc.srcLimit.wait(n)
+	if acc.dstLimit != acc.srcLimit {
+		acc.dstLimit.wait(n)
+	}
 }
 
 // read bytes from the io.Reader passed in and account them
@@ -394,9 +408,12 @@ func (acc *Account) Close() error {
 // Done with accounting - must be called to free accounting goroutine
 func (acc *Account) Done() {
 	acc.mu.Lock()
-	defer acc.mu.Unlock()
 	close(acc.exit)
 	acc.stats.inProgress.clear(acc.name)
+	acc.mu.Unlock()
+	// Wait for the accounting goroutine so it doesn't keep the
+	// Account alive after we return
+	<-acc.done
 }
 
 // progress returns bytes read as well as the size.
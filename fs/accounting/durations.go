@@ -0,0 +1,215 @@
+// Histograms of the durations of the calls made to the backends
+
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// Kinds of call whose duration is measured
+//
+// This is the duration of the whole call to the backend so it may be
+// made up of several requests to the remote and for uploads includes
+// the time to transfer the data.
+const (
+	CallList     = "list"
+	CallUpload   = "upload"
+	CallDownload = "download"
+	CallDelete   = "delete"
+)
+
+// durationOrder is the order the kinds of call are shown in
+var durationOrder = []string{CallList, CallUpload, CallDownload, CallDelete}
+
+// The histogram buckets grow exponentially by durationBucketGrowth
+// from durationBucketMin so the percentiles are accurate to within
+// about 20%.
+const (
+	durationBucketMin    = 100 * time.Microsecond
+	durationBucketGrowth = 1.2
+	durationBuckets      = 120 // up to about 3 days
+)
+
+// durationBucketLimits are the upper limits of the buckets
+var durationBucketLimits = func() (limits [durationBuckets]time.Duration) {
+	limit := float64(durationBucketMin)
+	for i := range limits {
+		limits[i] = time.Duration(limit)
+		limit *= durationBucketGrowth
+	}
+	return limits
+}()
+
+// durationHistogram is a histogram of the durations of one kind of
+// call to one remote.
+type durationHistogram struct {
+	count   int64
+	max     time.Duration
+	buckets [durationBuckets]int64
+}
+
+// add d to the histogram
+func (h *durationHistogram) add(d time.Duration) {
+	i := sort.Search(durationBuckets, func(i int) bool {
+		return durationBucketLimits[i] >= d
+	})
+	if i >= durationBuckets {
+		i = durationBuckets - 1
+	}
+	h.buckets[i]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// merge adds the durations in other to h
+func (h *durationHistogram) merge(other *durationHistogram) {
+	for i, n := range other.buckets {
+		h.buckets[i] += n
+	}
+	h.count += other.count
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// percentile returns the duration which p of the calls were faster
+// than, with p between 0 and 1.
+func (h *durationHistogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	want := int64(math.Ceil(p * float64(h.count)))
+	var total int64
+	for i, n := range h.buckets {
+		total += n
+		if total >= want {
+			// the last bucket has no upper limit
+			if i == durationBuckets-1 || durationBucketLimits[i] > h.max {
+				return h.max
+			}
+			return durationBucketLimits[i]
+		}
+	}
+	return h.max
+}
+
+// The number of timed calls in progress and finished
+var (
+	callsInFlight int64
+	callsDone     int64
+)
+
+// StartCall records the start of a call to a backend so the watchdog
+// can see it is in progress. It returns a func which should be called
+// when the call is done.
+//
+// Use this rather than TimeCall for calls whose duration isn't
+// comparable with others of their kind.
+func StartCall() (done func()) {
+	atomic.AddInt64(&callsInFlight, 1)
+	return func() {
+		atomic.AddInt64(&callsInFlight, -1)
+		atomic.AddInt64(&callsDone, 1)
+	}
+}
+
+// TimeCall records the start of a call of kind, e.g. CallList, to
+// the backend of f.  It returns a func which should be called when the
+// call is done to record its duration in the stats of ctx.
+func TimeCall(ctx context.Context, f fs.Info, kind string) (done func()) {
+	start := time.Now()
+	doneCall := StartCall()
+	return func() {
+		Stats(ctx).remotes.addDuration(remoteName(f), kind, time.Since(start))
+		doneCall()
+	}
+}
+
+// Calls returns the number of calls started with StartCall or
+// TimeCall which are in progress and which are done.
+func Calls() (inFlight, done int64) {
+	return atomic.LoadInt64(&callsInFlight), atomic.LoadInt64(&callsDone)
+}
+
+// durationPercentiles are the percentiles of the durations of one kind
+// of call to one remote.
+type durationPercentiles struct {
+	kind  string
+	count int64
+	p50   time.Duration
+	p95   time.Duration
+	p99   time.Duration
+}
+
+// percentiles returns the percentiles of the histogram of kind
+func (h *durationHistogram) percentiles(kind string) durationPercentiles {
+	return durationPercentiles{
+		kind:  kind,
+		count: h.count,
+		p50:   h.percentile(0.50),
+		p95:   h.percentile(0.95),
+		p99:   h.percentile(0.99),
+	}
+}
+
+// sortDurations sorts stats by kind of call in durationOrder
+func sortDurations(stats []durationPercentiles) {
+	order := func(kind string) int {
+		for i, k := range durationOrder {
+			if k == kind {
+				return i
+			}
+		}
+		return len(durationOrder)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return order(stats[i].kind) < order(stats[j].kind)
+	})
+}
+
+// durationsRcStats returns the duration percentiles for core/stats in
+// seconds.
+func durationsRcStats(stats []durationPercentiles) rc.Params {
+	out := rc.Params{}
+	for _, stat := range stats {
+		out[stat.kind] = rc.Params{
+			"count": stat.count,
+			"p50":   stat.p50.Seconds(),
+			"p95":   stat.p95.Seconds(),
+			"p99":   stat.p99.Seconds(),
+		}
+	}
+	return out
+}
+
+// formatDuration formats d for the stats
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.3gs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.3gms", d.Seconds()*1e3)
+	}
+	return fmt.Sprintf("%.3gµs", d.Seconds()*1e6)
+}
+
+// durationString returns the duration percentiles of one remote for
+// the stats, e.g. "list 120ms/450ms/1.2s, delete 35ms/80ms/110ms"
+func durationString(stats []durationPercentiles) string {
+	parts := make([]string, 0, len(stats))
+	for _, stat := range stats {
+		parts = append(parts, fmt.Sprintf("%s %s/%s/%s", stat.kind, formatDuration(stat.p50), formatDuration(stat.p95), formatDuration(stat.p99)))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,104 @@
+package accounting
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationHistogramPercentile(t *testing.T) {
+	var h durationHistogram
+	assert.Equal(t, time.Duration(0), h.percentile(0.5))
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, int64(100), h.count)
+	for _, test := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1.00, 100 * time.Millisecond},
+	} {
+		got := h.percentile(test.p)
+		// The buckets are 20% wide
+		assert.True(t, got >= test.want && got <= test.want*12/10, "p=%v: got %v want %v", test.p, got, test.want)
+	}
+
+	// Check out of range durations
+	h = durationHistogram{}
+	h.add(0)
+	h.add(1000 * time.Hour)
+	assert.Equal(t, durationBucketMin, h.percentile(0.5))
+	assert.Equal(t, 1000*time.Hour, h.percentile(0.99))
+}
+
+func TestTimeCallDurations(t *testing.T) {
+	ctx := WithStatsGroup(context.Background(), "durationtest")
+	defer groups.delete("durationtest")
+	f := mockfs.NewFs(ctx, "durationtest", "")
+	s := Stats(ctx)
+	s.remotes.addDuration("", CallList, time.Second)
+	s.remotes.addDuration("durationtest", CallDelete, 2*time.Millisecond)
+	s.remotes.addDuration("durationtest", CallList, time.Second)
+	TimeCall(ctx, nil, CallList)()
+	TimeCall(ctx, f, CallList)()
+
+	got := findRemoteSnapshot(t, s, "durationtest").durations
+	require.Equal(t, 2, len(got))
+	assert.Equal(t, CallList, got[0].kind)
+	assert.Equal(t, int64(2), got[0].count)
+	assert.Equal(t, time.Second, got[0].p99)
+	assert.Equal(t, CallDelete, got[1].kind)
+	assert.Equal(t, int64(1), got[1].count)
+
+	out := s.remotes.rcStats()["durationtest"].(rc.Params)["callDurations"].(rc.Params)
+	list := out[CallList].(rc.Params)
+	assert.Equal(t, int64(2), list["count"])
+	assert.Equal(t, 1.0, list["p99"])
+
+	durations := s.remotes.durationsString()
+	assert.True(t, strings.HasPrefix(durations, " * durationtest: list "), durations)
+	assert.True(t, strings.HasSuffix(durations, ", delete 2ms/2ms/2ms"), durations)
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "1.5s", formatDuration(1500*time.Millisecond))
+	assert.Equal(t, "120ms", formatDuration(120*time.Millisecond))
+	assert.Equal(t, "250µs", formatDuration(250*time.Microsecond))
+}
+
+func TestTimeCall(t *testing.T) {
+	ctx := context.Background()
+	f := mockfs.NewFs(ctx, "timecalltest", "")
+	inFlight0, done0 := Calls()
+	done := TimeCall(ctx, f, CallUpload)
+	inFlight, doneCount := Calls()
+	assert.Equal(t, inFlight0+1, inFlight)
+	assert.Equal(t, done0, doneCount)
+	done()
+	inFlight, doneCount = Calls()
+	assert.Equal(t, inFlight0, inFlight)
+	assert.Equal(t, done0+1, doneCount)
+	assert.Contains(t, GlobalStats().remotes.durationsString(), " * timecalltest: upload ")
+}
+
+func TestStartCall(t *testing.T) {
+	inFlight0, done0 := Calls()
+	done := StartCall()
+	inFlight, doneCount := Calls()
+	assert.Equal(t, inFlight0+1, inFlight)
+	assert.Equal(t, done0, doneCount)
+	done()
+	inFlight, doneCount = Calls()
+	assert.Equal(t, inFlight0, inFlight)
+	assert.Equal(t, done0+1, doneCount)
+}
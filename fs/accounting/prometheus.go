@@ -21,6 +21,9 @@ type RcloneCollector struct {
 	renames          *prometheus.Desc
 	fatalError       *prometheus.Desc
 	retryError       *prometheus.Desc
+	backendCalls     *prometheus.Desc
+	remoteBytes      *prometheus.Desc
+	remoteErrors     *prometheus.Desc
 }
 
 // NewRcloneCollector make a new RcloneCollector
@@ -67,6 +70,18 @@ func NewRcloneCollector(ctx context.Context) *RcloneCollector {
 			"Whether there has been an error that will be retried",
 			nil, nil,
 		),
+		backendCalls: prometheus.NewDesc(namespace+"backend_calls_total",
+			"Number of calls made to the API of each remote by operation",
+			[]string{"remote", "operation"}, nil,
+		),
+		remoteBytes: prometheus.NewDesc(namespace+"remote_bytes_total",
+			"Number of bytes read from and written to each remote",
+			[]string{"remote", "direction"}, nil,
+		),
+		remoteErrors: prometheus.NewDesc(namespace+"remote_errors_total",
+			"Number of calls made to the API of each remote which failed",
+			[]string{"remote"}, nil,
+		),
 	}
 }
 
@@ -82,6 +97,9 @@ func (c *RcloneCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.renames
 	ch <- c.fatalError
 	ch <- c.retryError
+	ch <- c.backendCalls
+	ch <- c.remoteBytes
+	ch <- c.remoteErrors
 }
 
 // Collect is part of the Collector interface: https://godoc.org/github.com/prometheus/client_golang/prometheus#Collector
@@ -101,6 +119,15 @@ func (c *RcloneCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(c.retryError, prometheus.GaugeValue, bool2Float(s.retryError))
 
 	s.mu.RUnlock()
+
+	for _, remote := range s.remotes.snapshots() {
+		for operation, count := range remote.calls {
+			ch <- prometheus.MustNewConstMetric(c.backendCalls, prometheus.CounterValue, float64(count), remote.name, operation)
+		}
+		ch <- prometheus.MustNewConstMetric(c.remoteBytes, prometheus.CounterValue, float64(remote.bytesRead), remote.name, "read")
+		ch <- prometheus.MustNewConstMetric(c.remoteBytes, prometheus.CounterValue, float64(remote.bytesWritten), remote.name, "written")
+		ch <- prometheus.MustNewConstMetric(c.remoteErrors, prometheus.CounterValue, float64(remote.errors), remote.name)
+	}
 }
 
 // bool2Float is a small function to convert a boolean into a float64 value that can be used for Prometheus
@@ -0,0 +1,207 @@
+// Per remote bandwidth limits
+
+package accounting
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"golang.org/x/time/rate"
+)
+
+// remoteBwLimitKey is the key in the config section of a remote which
+// holds its bandwidth timetable
+const remoteBwLimitKey = "bwlimit"
+
+// remoteLimit is the bandwidth limit for one remote
+type remoteLimit struct {
+	mu        sync.Mutex
+	name      string         // name of the remote
+	timetable fs.BwTimetable // schedule of limits - may be empty
+	bandwidth fs.SizeSuffix  // bandwidth the bucket was made with
+	checked   time.Time      // time the timetable was last checked
+	bucket    *rate.Limiter  // nil if unlimited
+}
+
+// remoteLimits holds the bandwidth limit for each remote by name
+var remoteLimits = struct {
+	mu     sync.Mutex
+	limits map[string]*remoteLimit
+}{
+	limits: make(map[string]*remoteLimit),
+}
+
+// getRemoteLimit returns the bandwidth limit for the remote called
+// name, reading its timetable from the config file the first time
+// the remote is seen.
+func getRemoteLimit(name string) *remoteLimit {
+	remoteLimits.mu.Lock()
+	defer remoteLimits.mu.Unlock()
+	rl := remoteLimits.limits[name]
+	if rl == nil {
+		rl = &remoteLimit{
+			name:      name,
+			bandwidth: -1,
+		}
+		if value, ok := fs.ConfigFileGet(name, remoteBwLimitKey); ok && value != "" {
+			err := rl.timetable.Set(value)
+			if err != nil {
+				fs.Errorf(nil, "Ignoring bad %s for remote %q: %v", remoteBwLimitKey, name, err)
+				rl.timetable = nil
+			}
+		}
+		remoteLimits.limits[name] = rl
+	}
+	return rl
+}
+
+// getRemoteLimitFs returns the bandwidth limit for the remote of f or
+// nil if f is nil.
+func getRemoteLimitFs(f fs.Info) *remoteLimit {
+	if f == nil {
+		return nil
+	}
+	return getRemoteLimit(f.Name())
+}
+
+// update sets the bucket from the timetable if it hasn't been checked
+// for a minute or force is set.
+//
+// Call with rl.mu held
+func (rl *remoteLimit) update(now time.Time, force bool) {
+	if !force && now.Sub(rl.checked) < time.Minute {
+		return
+	}
+	rl.checked = now
+	limitNow := rl.timetable.LimitAt(now)
+	if limitNow.Bandwidth <= 0 {
+		limitNow.Bandwidth = -1
+	}
+	if limitNow.Bandwidth == rl.bandwidth {
+		return
+	}
+	rl.bandwidth = limitNow.Bandwidth
+	if rl.bandwidth > 0 {
+		rl.bucket = newTokenBucket(rl.bandwidth)
+		fs.Logf(nil, "Bandwidth limit for remote %q set to %vBytes/s", rl.name, &rl.bandwidth)
+	} else {
+		rl.bucket = nil
+		fs.Logf(nil, "Bandwidth limit for remote %q disabled", rl.name)
+	}
+}
+
+// setTimetable replaces the timetable of the remote and applies it
+// straight away.
+func (rl *remoteLimit) setTimetable(timetable fs.BwTimetable) {
+	rl.mu.Lock()
+	rl.timetable = timetable
+	rl.update(time.Now(), true)
+	rl.mu.Unlock()
+}
+
+// current returns the bandwidth limit in force, -1 if unlimited.
+func (rl *remoteLimit) current() fs.SizeSuffix {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.update(time.Now(), false)
+	return rl.bandwidth
+}
+
+// wait sleeps for the correct amount of time for the passage of n
+// bytes according to the current limit of the remote.  It may be
+// called on a nil *remoteLimit which does nothing.
+func (rl *remoteLimit) wait(n int) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	rl.update(time.Now(), false)
+	bucket := rl.bucket
+	rl.mu.Unlock()
+	if bucket != nil {
+		err := bucket.WaitN(context.Background(), n)
+		if err != nil {
+			fs.Errorf(nil, "Token bucket error for remote %q: %v", rl.name, err)
+		}
+	}
+}
+
+// Remote control for the per remote bandwidth limits
+func init() {
+	rc.Add(rc.Call{
+		Path: "core/bwlimit/set",
+		Fn: func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+			remote, err := in.GetString("remote")
+			if err != nil {
+				return out, err
+			}
+			remote = strings.TrimSuffix(remote, ":")
+			if remote == "" {
+				return out, errors.New("remote must not be empty")
+			}
+			rl := getRemoteLimit(remote)
+			if in["rate"] != nil {
+				bwlimit, err := in.GetString("rate")
+				if err != nil {
+					return out, err
+				}
+				var timetable fs.BwTimetable
+				err = timetable.Set(bwlimit)
+				if err != nil {
+					return out, errors.Wrap(err, "bad bwlimit")
+				}
+				rl.setTimetable(timetable)
+			}
+			bytesPerSecond := int64(rl.current())
+			out = rc.Params{
+				"remote":         remote,
+				"rate":           fs.SizeSuffix(bytesPerSecond).String(),
+				"bytesPerSecond": bytesPerSecond,
+			}
+			return out, nil
+		},
+		Title: "Set the bandwidth limit for a remote.",
+		Help: `
+This sets the bandwidth limit for the remote passed in, replacing the
+limit read from the ` + "`bwlimit`" + ` key of its config section until
+rclone is restarted. The limit applies to all the data read from or
+written to the remote in addition to the global --bwlimit.
+
+Parameters
+
+- remote - name of the remote, eg "drive" or "drive:"
+- rate - bandwidth limit or timetable, optional
+
+Eg
+
+    rclone rc core/bwlimit/set remote=drive: rate=8M
+    {
+        "bytesPerSecond": 8388608,
+        "rate": "8M",
+        "remote": "drive"
+    }
+    rclone rc core/bwlimit/set remote=drive: rate=off
+    {
+        "bytesPerSecond": -1,
+        "rate": "off",
+        "remote": "drive"
+    }
+
+If the rate parameter is not supplied then the bandwidth limit in force
+for the remote is queried.
+
+The format of the rate parameter is exactly the same as passed to
+--bwlimit and may be a full timetable, eg
+
+    rclone rc core/bwlimit/set remote=drive: rate="Mon-09:00,8M Mon-18:00,off"
+
+In either case "rate" is returned as a human readable string, and
+"bytesPerSecond" is returned as a number.
+`,
+	})
+}
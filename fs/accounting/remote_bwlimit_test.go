@@ -0,0 +1,116 @@
+package accounting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestGetRemoteLimit(t *testing.T) {
+	oldConfigFileGet := fs.ConfigFileGet
+	fs.ConfigFileGet = func(section, key string) (string, bool) {
+		if key != remoteBwLimitKey {
+			return "", false
+		}
+		switch section {
+		case "limited":
+			return "Mon-09:00,8M Mon-18:00,off", true
+		case "bad":
+			return "potato", true
+		}
+		return "", false
+	}
+	defer func() {
+		fs.ConfigFileGet = oldConfigFileGet
+	}()
+
+	rl := getRemoteLimit("limited")
+	assert.Equal(t, rl, getRemoteLimit("limited"))
+	assert.Len(t, rl.timetable, 2)
+
+	// Monday 2021-01-04
+	monday := func(hh, mm int) time.Time {
+		return time.Date(2021, 1, 4, hh, mm, 0, 0, time.Local)
+	}
+	rl.mu.Lock()
+	rl.update(monday(10, 0), true)
+	assert.Equal(t, fs.SizeSuffix(8*1024*1024), rl.bandwidth)
+	require.NotNil(t, rl.bucket)
+	assert.Equal(t, rate.Limit(8*1024*1024), rl.bucket.Limit())
+
+	// Not checked again within the minute
+	rl.update(monday(10, 0).Add(30*time.Second), false)
+	assert.NotNil(t, rl.bucket)
+
+	rl.update(monday(18, 1), false)
+	assert.Equal(t, fs.SizeSuffix(-1), rl.bandwidth)
+	assert.Nil(t, rl.bucket)
+	rl.mu.Unlock()
+
+	assert.Len(t, getRemoteLimit("bad").timetable, 0)
+	assert.Len(t, getRemoteLimit("unlimited").timetable, 0)
+	assert.Equal(t, fs.SizeSuffix(-1), getRemoteLimit("unlimited").current())
+	assert.Nil(t, getRemoteLimitFs(nil))
+
+	var nilLimit *remoteLimit
+	nilLimit.wait(1)
+}
+
+func TestRcBwLimitSet(t *testing.T) {
+	call := rc.Calls.Get("core/bwlimit/set")
+	assert.NotNil(t, call)
+
+	// Set
+	in := rc.Params{
+		"remote": "rcremote:",
+		"rate":   "1M",
+	}
+	out, err := call.Fn(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{
+		"remote":         "rcremote",
+		"bytesPerSecond": int64(1048576),
+		"rate":           "1M",
+	}, out)
+	rl := getRemoteLimit("rcremote")
+	require.NotNil(t, rl.bucket)
+	assert.Equal(t, rate.Limit(1048576), rl.bucket.Limit())
+
+	// Query
+	in = rc.Params{
+		"remote": "rcremote",
+	}
+	out, err = call.Fn(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{
+		"remote":         "rcremote",
+		"bytesPerSecond": int64(1048576),
+		"rate":           "1M",
+	}, out)
+
+	// Reset
+	in = rc.Params{
+		"remote": "rcremote",
+		"rate":   "off",
+	}
+	out, err = call.Fn(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{
+		"remote":         "rcremote",
+		"bytesPerSecond": int64(-1),
+		"rate":           "off",
+	}, out)
+	assert.Nil(t, rl.bucket)
+
+	// Errors
+	_, err = call.Fn(context.Background(), rc.Params{})
+	assert.Error(t, err)
+	_, err = call.Fn(context.Background(), rc.Params{"remote": "rcremote", "rate": "potato"})
+	assert.Error(t, err)
+}
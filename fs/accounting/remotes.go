@@ -0,0 +1,285 @@
+// Per remote bandwidth, calls, errors and call durations
+
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// remoteStats are the statistics for one remote so the source and
+// the destination of a transfer can be told apart.
+type remoteStats struct {
+	bytesRead    int64                         // bytes read from the remote
+	bytesWritten int64                         // bytes written to the remote
+	start        time.Time                     // time the first byte was read or written
+	last         time.Time                     // time the last byte was read or written
+	calls        map[string]int64              // calls made to the API of the remote by operation
+	errors       int64                         // number of calls to the API of the remote which failed
+	durations    map[string]*durationHistogram // durations of the calls to the backend by kind
+}
+
+// remotesStats holds the statistics of a StatsInfo for each remote
+// by name
+type remotesStats struct {
+	mu    sync.Mutex
+	stats map[string]*remoteStats
+}
+
+// newRemotesStats makes an empty remotesStats
+func newRemotesStats() *remotesStats {
+	return &remotesStats{
+		stats: make(map[string]*remoteStats),
+	}
+}
+
+// get returns the statistics for the remote called name, creating
+// them if necessary - call with the lock held
+func (rss *remotesStats) get(name string) *remoteStats {
+	rs := rss.stats[name]
+	if rs == nil {
+		rs = &remoteStats{
+			calls:     make(map[string]int64),
+			durations: make(map[string]*durationHistogram),
+		}
+		rss.stats[name] = rs
+	}
+	return rs
+}
+
+// remoteName returns the name of the remote of f or "" if f is nil
+func remoteName(f fs.Info) string {
+	if f == nil {
+		return ""
+	}
+	return f.Name()
+}
+
+// addBytes records n bytes read from or written to the remote called
+// name. It does nothing if name is "".
+func (rss *remotesStats) addBytes(name string, n int64, written bool) {
+	if name == "" || n <= 0 {
+		return
+	}
+	now := time.Now()
+	rss.mu.Lock()
+	rs := rss.get(name)
+	if written {
+		rs.bytesWritten += n
+	} else {
+		rs.bytesRead += n
+	}
+	if rs.start.IsZero() {
+		rs.start = now
+	}
+	rs.last = now
+	rss.mu.Unlock()
+}
+
+// addCall records a call of operation made to the API of the remote
+// called name and whether it failed. It does nothing if name is "".
+func (rss *remotesStats) addCall(name, operation string, failed bool) {
+	if name == "" {
+		return
+	}
+	rss.mu.Lock()
+	rs := rss.get(name)
+	rs.calls[operation]++
+	if failed {
+		rs.errors++
+	}
+	rss.mu.Unlock()
+}
+
+// countCall counts a call made to the API of the remote called name in
+// the stats of ctx. It is installed as fs.CountCall so fshttp can
+// count the HTTP requests the backends make.
+func countCall(ctx context.Context, name, operation string, failed bool) {
+	Stats(ctx).remotes.addCall(name, operation, failed)
+}
+
+// addDuration records that a call of kind, e.g. CallList, to the
+// backend of the remote called name took d. It does nothing if name
+// is "".
+func (rss *remotesStats) addDuration(name, kind string, d time.Duration) {
+	if name == "" {
+		return
+	}
+	rss.mu.Lock()
+	rs := rss.get(name)
+	h := rs.durations[kind]
+	if h == nil {
+		h = new(durationHistogram)
+		rs.durations[kind] = h
+	}
+	h.add(d)
+	rss.mu.Unlock()
+}
+
+// merge adds the statistics of other into rss
+func (rss *remotesStats) merge(other *remotesStats) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	for name, ors := range other.stats {
+		rs := rss.get(name)
+		rs.bytesRead += ors.bytesRead
+		rs.bytesWritten += ors.bytesWritten
+		if !ors.start.IsZero() && (rs.start.IsZero() || ors.start.Before(rs.start)) {
+			rs.start = ors.start
+		}
+		if ors.last.After(rs.last) {
+			rs.last = ors.last
+		}
+		for operation, count := range ors.calls {
+			rs.calls[operation] += count
+		}
+		rs.errors += ors.errors
+		for kind, oh := range ors.durations {
+			h := rs.durations[kind]
+			if h == nil {
+				h = new(durationHistogram)
+				rs.durations[kind] = h
+			}
+			h.merge(oh)
+		}
+	}
+}
+
+// reset clears the statistics
+func (rss *remotesStats) reset() {
+	rss.mu.Lock()
+	rss.stats = make(map[string]*remoteStats)
+	rss.mu.Unlock()
+}
+
+// remoteSnapshot is the state of the statistics of one remote
+type remoteSnapshot struct {
+	name         string
+	bytesRead    int64
+	bytesWritten int64
+	readSpeed    float64          // average bytes/s read while active
+	writeSpeed   float64          // average bytes/s written while active
+	calls        map[string]int64 // calls by operation
+	totalCalls   int64
+	errors       int64
+	durations    []durationPercentiles // sorted in durationOrder
+}
+
+// snapshots returns the statistics of all the remotes which have been
+// used sorted by name.
+//
+// The speeds are averaged over the time between the first and the
+// last byte read or written so idle time before and after the
+// transfers doesn't count.
+func (rss *remotesStats) snapshots() []remoteSnapshot {
+	rss.mu.Lock()
+	snaps := make([]remoteSnapshot, 0, len(rss.stats))
+	for name, rs := range rss.stats {
+		snap := remoteSnapshot{
+			name:         name,
+			bytesRead:    rs.bytesRead,
+			bytesWritten: rs.bytesWritten,
+			calls:        make(map[string]int64, len(rs.calls)),
+			errors:       rs.errors,
+		}
+		if dt := rs.last.Sub(rs.start).Seconds(); dt > 0 {
+			snap.readSpeed = float64(rs.bytesRead) / dt
+			snap.writeSpeed = float64(rs.bytesWritten) / dt
+		}
+		for operation, count := range rs.calls {
+			snap.calls[operation] = count
+			snap.totalCalls += count
+		}
+		for kind, h := range rs.durations {
+			snap.durations = append(snap.durations, h.percentiles(kind))
+		}
+		sortDurations(snap.durations)
+		snaps = append(snaps, snap)
+	}
+	rss.mu.Unlock()
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].name < snaps[j].name
+	})
+	return snaps
+}
+
+// rcStats returns the statistics of each remote for core/stats
+func (rss *remotesStats) rcStats() rc.Params {
+	out := rc.Params{}
+	for _, snap := range rss.snapshots() {
+		remote := rc.Params{
+			"bytesRead":    snap.bytesRead,
+			"bytesWritten": snap.bytesWritten,
+			"readSpeed":    snap.readSpeed,
+			"writeSpeed":   snap.writeSpeed,
+			"calls":        snap.totalCalls,
+			"errors":       snap.errors,
+		}
+		if len(snap.durations) > 0 {
+			remote["callDurations"] = durationsRcStats(snap.durations)
+		}
+		out[snap.name] = remote
+	}
+	return out
+}
+
+// String returns the statistics of each remote for the stats, one
+// line per remote, or "" if there are none.
+func (rss *remotesStats) String(ci *fs.ConfigInfo) string {
+	snaps := rss.snapshots()
+	if len(snaps) == 0 {
+		return ""
+	}
+	rateUnit := strings.Title(ci.DataRateUnit) + "/s"
+	displaySpeed := func(speed float64) string {
+		if ci.DataRateUnit == "bits" {
+			speed *= 8
+		}
+		return fs.SizeSuffix(speed).Unit(rateUnit)
+	}
+	lines := make([]string, 0, len(snaps))
+	for _, snap := range snaps {
+		lines = append(lines, fmt.Sprintf(" * %s: read %s (%s), written %s (%s), %d calls, %d errors",
+			snap.name,
+			fs.SizeSuffix(snap.bytesRead).Unit("Bytes"), displaySpeed(snap.readSpeed),
+			fs.SizeSuffix(snap.bytesWritten).Unit("Bytes"), displaySpeed(snap.writeSpeed),
+			snap.totalCalls, snap.errors))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// durationsString returns the duration percentiles of each remote for
+// the stats, one line per remote, or "" if there are none.
+func (rss *remotesStats) durationsString() string {
+	var lines []string
+	for _, snap := range rss.snapshots() {
+		if len(snap.durations) > 0 {
+			lines = append(lines, fmt.Sprintf(" * %s: %s", snap.name, durationString(snap.durations)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CallDurations returns the percentiles of the durations of the calls
+// made to the backend of f in the same format as the callDurations of
+// core/stats, and as a string as shown in the stats, e.g.
+// "list 120ms/450ms/1.2s, download 95ms/210ms/300ms". It returns nil
+// and "" if no calls have been timed.
+func (s *StatsInfo) CallDurations(f fs.Info) (out rc.Params, text string) {
+	name := remoteName(f)
+	for _, snap := range s.remotes.snapshots() {
+		if snap.name == name && len(snap.durations) > 0 {
+			return durationsRcStats(snap.durations), durationString(snap.durations)
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,105 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findRemoteSnapshot(t *testing.T, s *StatsInfo, name string) remoteSnapshot {
+	for _, snap := range s.remotes.snapshots() {
+		if snap.name == name {
+			return snap
+		}
+	}
+	t.Fatalf("remote %q not found", name)
+	return remoteSnapshot{}
+}
+
+func TestRemoteStatsTransfer(t *testing.T) {
+	ctx := context.Background()
+	fsrc := mockfs.NewFs(ctx, "remotestatssrc", "")
+	fdst := mockfs.NewFs(ctx, "remotestatsdst", "")
+	content := []byte("hello world")
+	src := mockobject.New("file.txt").WithContent(content, mockobject.SeekModeNone)
+	src.SetFs(fsrc)
+
+	s := NewStats(ctx)
+	tr := s.NewTransfer(src)
+	tr.SetDst(fdst)
+	in := tr.Account(ctx, ioutil.NopCloser(bytes.NewReader(content)))
+	_, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	tr.Done(ctx, nil)
+
+	snap := findRemoteSnapshot(t, s, "remotestatssrc")
+	assert.Equal(t, int64(len(content)), snap.bytesRead)
+	assert.Equal(t, int64(0), snap.bytesWritten)
+
+	snap = findRemoteSnapshot(t, s, "remotestatsdst")
+	assert.Equal(t, int64(0), snap.bytesRead)
+	assert.Equal(t, int64(len(content)), snap.bytesWritten)
+
+	rcStats := s.remotes.rcStats()
+	require.Contains(t, rcStats, "remotestatsdst")
+
+	ci := fs.GetConfig(ctx)
+	assert.Contains(t, s.remotes.String(ci), " * remotestatsdst: read 0 Bytes (0 Bytes/s), written 11 Bytes (")
+}
+
+func TestRemoteStatsCallsAndErrors(t *testing.T) {
+	ctx := WithStatsGroup(context.Background(), "remotestatserrors")
+	defer groups.delete("remotestatserrors")
+	fs.CountCall(ctx, "remote", "PUT", false)
+	fs.CountCall(ctx, "remote", "PUT", true)
+	fs.CountCall(ctx, "remote", "GET", false)
+	fs.CountCall(ctx, "", "GET", false)
+
+	s := Stats(ctx)
+	snap := findRemoteSnapshot(t, s, "remote")
+	assert.Equal(t, int64(3), snap.totalCalls)
+	assert.Equal(t, map[string]int64{"GET": 1, "PUT": 2}, snap.calls)
+	assert.Equal(t, int64(1), snap.errors)
+	assert.Equal(t, " * remote: read 0 Bytes (0 Bytes/s), written 0 Bytes (0 Bytes/s), 3 calls, 1 errors", s.remotes.String(fs.GetConfig(ctx)))
+
+	// The calls are counted in the stats of their group only
+	for _, snap := range GlobalStats().remotes.snapshots() {
+		assert.NotEqual(t, "remote", snap.name)
+	}
+
+	s.ResetCounters()
+	assert.Equal(t, "", s.remotes.String(fs.GetConfig(ctx)))
+}
+
+func TestRemoteStatsSum(t *testing.T) {
+	ctx := context.Background()
+	ctx1 := WithStatsGroup(ctx, "remotestatssum1")
+	defer groups.delete("remotestatssum1")
+	ctx2 := WithStatsGroup(ctx, "remotestatssum2")
+	defer groups.delete("remotestatssum2")
+	f := mockfs.NewFs(ctx, "remotestatssum", "")
+	fs.CountCall(ctx1, "remotestatssum", "GET", false)
+	fs.CountCall(ctx2, "remotestatssum", "GET", true)
+	Stats(ctx1).remotes.addDuration("remotestatssum", CallList, time.Second)
+	Stats(ctx2).remotes.addDuration("remotestatssum", CallList, time.Second)
+
+	sum := groups.sum(ctx)
+	snap := findRemoteSnapshot(t, sum, "remotestatssum")
+	assert.Equal(t, map[string]int64{"GET": 2}, snap.calls)
+	assert.Equal(t, int64(1), snap.errors)
+	require.Equal(t, 1, len(snap.durations))
+	assert.Equal(t, int64(2), snap.durations[0].count)
+
+	out, text := Stats(ctx1).CallDurations(f)
+	assert.Equal(t, int64(1), out[CallList].(rc.Params)["count"])
+	assert.Equal(t, "list 1s/1s/1s", text)
+}
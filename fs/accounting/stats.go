@@ -28,6 +28,7 @@ type StatsInfo struct {
 	bytes             int64
 	errors            int64
 	lastError         error
+	errorCounts       map[string]int64 // number of times each error message occurred
 	fatalError        bool
 	retryError        bool
 	retryAfter        time.Time
@@ -45,6 +46,7 @@ type StatsInfo struct {
 	deletes           int64
 	deletedDirs       int64
 	inProgress        *inProgress
+	remotes           *remotesStats // statistics of each remote used
 	startedTransfers  []*Transfer   // currently active transfers
 	oldTimeRanges     timeRanges    // a merged list of time ranges for the transfers
 	oldDuration       time.Duration // duration of transfers we have culled
@@ -60,15 +62,33 @@ func NewStats(ctx context.Context) *StatsInfo {
 		checking:     newTransferMap(ci.Checkers, "checking"),
 		transferring: newTransferMap(ci.Transfers, "transferring"),
 		inProgress:   newInProgress(ctx),
+		remotes:      newRemotesStats(),
 	}
 }
 
 // RemoteStats returns stats for rc
 func (s *StatsInfo) RemoteStats() (out rc.Params, err error) {
+	// checking and transferring have their own locking so read
+	// here before lock to prevent deadlock on GetBytes
+	transferring, checking := s.transferring.count(), s.checking.count()
+	transferringBytesDone, transferringBytesTotal := s.transferring.progress(s)
+
 	out = make(rc.Params)
 	s.mu.RLock()
-	out["speed"] = s.Speed()
+	speed := s.Speed()
+	// note that s.bytes already includes transferringBytesDone so
+	// we take it off here to avoid double counting
+	totalSize := s.transferQueueSize + s.bytes + transferringBytesTotal - transferringBytesDone
+	out["speed"] = speed
 	out["bytes"] = s.bytes
+	out["totalBytes"] = totalSize
+	out["totalChecks"] = int64(s.checkQueue) + s.checks + int64(checking)
+	out["totalTransfers"] = int64(s.transferQueue) + s.transfers + int64(transferring)
+	if d, ok := eta(s.bytes, totalSize, speed); ok {
+		out["eta"] = d.Seconds()
+	} else {
+		out["eta"] = nil
+	}
 	out["errors"] = s.errors
 	out["fatalError"] = s.fatalError
 	out["retryError"] = s.retryError
@@ -88,6 +108,10 @@ func (s *StatsInfo) RemoteStats() (out rc.Params, err error) {
 	}
 	if s.errors > 0 {
 		out["lastError"] = s.lastError.Error()
+		out["lastErrorCode"] = string(fserrors.ErrorCode(s.lastError))
+	}
+	if remotes := s.remotes.rcStats(); len(remotes) > 0 {
+		out["remotes"] = remotes
 	}
 	return out, nil
 }
@@ -343,6 +367,12 @@ func (s *StatsInfo) String() string {
 		if !s.transferring.empty() {
 			_, _ = fmt.Fprintf(buf, "Transferring:\n%s\n", s.transferring.String(s.ctx, s.inProgress, nil))
 		}
+		if remotes := s.remotes.String(s.ci); remotes != "" {
+			_, _ = fmt.Fprintf(buf, "Remotes:\n%s\n", remotes)
+		}
+		if durations := s.remotes.durationsString(); durations != "" {
+			_, _ = fmt.Fprintf(buf, "Call durations p50/p95/p99:\n%s\n", durations)
+		}
 	}
 
 	return buf.String()
@@ -433,6 +463,19 @@ func (s *StatsInfo) GetChecks() int64 {
 	return s.checks
 }
 
+// GetDeletes returns the number of deletes
+func (s *StatsInfo) GetDeletes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.deletes
+}
+
+// InProgress returns true if there are any transfers or checks in
+// progress
+func (s *StatsInfo) InProgress() bool {
+	return !s.transferring.empty() || !s.checking.empty()
+}
+
 // FatalError sets the fatalError flag
 func (s *StatsInfo) FatalError() {
 	s.mu.Lock()
@@ -492,6 +535,7 @@ func (s *StatsInfo) ResetCounters() {
 	s.bytes = 0
 	s.errors = 0
 	s.lastError = nil
+	s.errorCounts = nil
 	s.fatalError = false
 	s.retryError = false
 	s.retryAfter = time.Time{}
@@ -502,6 +546,7 @@ func (s *StatsInfo) ResetCounters() {
 	s.renames = 0
 	s.startedTransfers = nil
 	s.oldDuration = 0
+	s.remotes.reset()
 }
 
 // ResetErrors sets the errors count to 0 and resets lastError, fatalError and retryError
@@ -510,6 +555,7 @@ func (s *StatsInfo) ResetErrors() {
 	defer s.mu.Unlock()
 	s.errors = 0
 	s.lastError = nil
+	s.errorCounts = nil
 	s.fatalError = false
 	s.retryError = false
 	s.retryAfter = time.Time{}
@@ -531,6 +577,7 @@ func (s *StatsInfo) Error(err error) error {
 	defer s.mu.Unlock()
 	s.errors++
 	s.lastError = err
+	s.countErrorMessage(err.Error())
 	err = fserrors.FsError(err)
 	fserrors.Count(err)
 	switch {
@@ -548,6 +595,49 @@ func (s *StatsInfo) Error(err error) error {
 	return err
 }
 
+// maxErrorMessages is the maximum number of different error messages
+// counted for TopErrors
+const maxErrorMessages = 1000
+
+// countErrorMessage counts an occurrence of the error message - call
+// with the lock held
+func (s *StatsInfo) countErrorMessage(message string) {
+	if s.errorCounts == nil {
+		s.errorCounts = make(map[string]int64)
+	}
+	if _, found := s.errorCounts[message]; !found && len(s.errorCounts) >= maxErrorMessages {
+		return
+	}
+	s.errorCounts[message]++
+}
+
+// ErrorCount is the number of times an error message occurred
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int64  `json:"count"`
+}
+
+// TopErrors returns up to n of the most frequent error messages, most
+// frequent first.
+func (s *StatsInfo) TopErrors(n int) []ErrorCount {
+	s.mu.RLock()
+	counts := make([]ErrorCount, 0, len(s.errorCounts))
+	for message, count := range s.errorCounts {
+		counts = append(counts, ErrorCount{Error: message, Count: count})
+	}
+	s.mu.RUnlock()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Error < counts[j].Error
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
 // RetryAfter returns the time to retry after if it is set.  It will
 // be Zero if it isn't set.
 func (s *StatsInfo) RetryAfter() time.Time {
@@ -587,7 +677,7 @@ func (s *StatsInfo) NewTransfer(obj fs.Object) *Transfer {
 
 // NewTransferRemoteSize adds a transfer to the stats based on remote and size.
 func (s *StatsInfo) NewTransferRemoteSize(remote string, size int64) *Transfer {
-	tr := newTransferRemoteSize(s, remote, size, false)
+	tr := newTransferRemoteSize(s, remote, size, false, nil)
 	s.transferring.add(tr)
 	return tr
 }
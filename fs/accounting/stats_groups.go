@@ -19,6 +19,7 @@ func init() {
 
 	// Set the function pointer up in fs
 	fs.CountError = GlobalStats().Error
+	fs.CountCall = countCall
 }
 
 func rcListStats(ctx context.Context, in rc.Params) (rc.Params, error) {
@@ -88,6 +89,10 @@ Returns the following values:
 {
 	"speed": average speed in bytes/sec since start of the process,
 	"bytes": total transferred bytes since the start of the process,
+	"totalBytes": total bytes to be transferred including those already transferred,
+	"totalChecks": total number of checks to be done including those already done,
+	"totalTransfers": total number of transfers to be done including those already done,
+	"eta": estimated time in seconds until all the transfers are complete,
 	"errors": number of errors,
 	"fatalError": whether there has been at least one FatalError,
 	"retryError": whether there has been at least one non-NoRetryError,
@@ -98,6 +103,7 @@ Returns the following values:
 	"transferTime" : total time spent on running jobs,
 	"elapsedTime": time in seconds since the start of the process,
 	"lastError": last occurred error,
+	"lastErrorCode": code for the class of the last occurred error, e.g. "ERR_AUTH",
 	"transferring": an array of currently active file transfers:
 		[
 			{
@@ -111,11 +117,31 @@ Returns the following values:
 			}
 		],
 	"checking": an array of names of currently active file checks
-		[]
+		[],
+	"remotes": bandwidth, calls, errors and call durations of each remote since the stats were reset:
+		{
+			"remote name": {
+				"bytesRead": bytes read from the remote,
+				"bytesWritten": bytes written to the remote,
+				"readSpeed": average read speed in bytes/sec while transferring,
+				"writeSpeed": average write speed in bytes/sec while transferring,
+				"calls": number of HTTP requests made to the API of the remote,
+				"errors": number of those requests which failed,
+				"callDurations": duration of the calls to the backend:
+					{
+						"list", "upload", "download" or "delete": {
+							"count": number of calls,
+							"p50": median duration in seconds,
+							"p95": 95th percentile duration in seconds,
+							"p99": 99th percentile duration in seconds
+						}
+					}
+			}
+		}
 }
 ` + "```" + `
-Values for "transferring", "checking" and "lastError" are only assigned if data is available.
-The value for "eta" is null if an eta cannot be determined.
+Values for "transferring", "checking", "lastError", "lastErrorCode" and "remotes" are only assigned if data is available.
+The values for "eta" are null if an eta cannot be determined.
 `,
 	})
 }
@@ -364,6 +390,7 @@ func (sg *statsGroups) sum(ctx context.Context) *StatsInfo {
 			sum.checking.merge(stats.checking)
 			sum.transferring.merge(stats.transferring)
 			sum.inProgress.merge(stats.inProgress)
+			sum.remotes.merge(stats.remotes)
 			if sum.lastError == nil && stats.lastError != nil {
 				sum.lastError = stats.lastError
 			}
@@ -133,6 +133,26 @@ func TestStatsError(t *testing.T) {
 	assert.Equal(t, time.Time{}, s.RetryAfter())
 }
 
+func TestStatsTopErrors(t *testing.T) {
+	ctx := context.Background()
+	s := NewStats(ctx)
+	assert.Equal(t, []ErrorCount{}, s.TopErrors(2))
+
+	_ = s.Error(errors.New("one"))
+	_ = s.Error(errors.New("two"))
+	_ = s.Error(errors.New("two"))
+	_ = s.Error(errors.New("three"))
+	_ = s.Error(errors.New("three"))
+	_ = s.Error(errors.New("three"))
+	assert.Equal(t, []ErrorCount{
+		{Error: "three", Count: 3},
+		{Error: "two", Count: 2},
+	}, s.TopErrors(2))
+
+	s.ResetErrors()
+	assert.Equal(t, []ErrorCount{}, s.TopErrors(2))
+}
+
 func TestStatsTotalDuration(t *testing.T) {
 	ctx := context.Background()
 	startTime := time.Now()
@@ -436,3 +456,20 @@ func TestPruneTransfers(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoteStatsTotals(t *testing.T) {
+	ctx := context.Background()
+	s := NewStats(ctx)
+	s.SetTransferQueue(3, 300)
+	s.SetCheckQueue(2, 0)
+	s.Bytes(100)
+
+	out, err := s.RemoteStats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), out["bytes"])
+	assert.Equal(t, int64(400), out["totalBytes"])
+	assert.Equal(t, int64(3), out["totalTransfers"])
+	assert.Equal(t, int64(2), out["totalChecks"])
+	// no transfers have been timed so the ETA is unknown
+	assert.Nil(t, out["eta"])
+}
@@ -50,6 +50,7 @@ type Transfer struct {
 	size      int64
 	startedAt time.Time
 	checking  bool
+	src       fs.Info // remote read from, may be nil
 
 	// Protects all below
 	//
@@ -57,6 +58,7 @@ type Transfer struct {
 	// calling any methods on Transfer.stats.  This is because
 	// StatsInfo calls back into Transfer.
 	mu          sync.RWMutex
+	dst         fs.Info // remote written to, may be nil
 	acc         *Account
 	err         error
 	completedAt time.Time
@@ -64,21 +66,22 @@ type Transfer struct {
 
 // newCheckingTransfer instantiates new checking of the object.
 func newCheckingTransfer(stats *StatsInfo, obj fs.Object) *Transfer {
-	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), true)
+	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), true, obj.Fs())
 }
 
 // newTransfer instantiates new transfer.
 func newTransfer(stats *StatsInfo, obj fs.Object) *Transfer {
-	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), false)
+	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), false, obj.Fs())
 }
 
-func newTransferRemoteSize(stats *StatsInfo, remote string, size int64, checking bool) *Transfer {
+func newTransferRemoteSize(stats *StatsInfo, remote string, size int64, checking bool, src fs.Info) *Transfer {
 	tr := &Transfer{
 		stats:     stats,
 		remote:    remote,
 		size:      size,
 		startedAt: time.Now(),
 		checking:  checking,
+		src:       src,
 	}
 	stats.AddTransfer(tr)
 	return tr
@@ -143,6 +146,10 @@ func (tr *Transfer) Account(ctx context.Context, in io.ReadCloser) *Account {
 	tr.mu.Lock()
 	if tr.acc == nil {
 		tr.acc = newAccountSizeName(ctx, tr.stats, in, tr.size, tr.remote)
+		tr.acc.src = remoteName(tr.src)
+		tr.acc.dst = remoteName(tr.dst)
+		tr.acc.srcLimit = getRemoteLimitFs(tr.src)
+		tr.acc.dstLimit = getRemoteLimitFs(tr.dst)
 	} else {
 		tr.acc.UpdateReader(ctx, in)
 	}
@@ -150,6 +157,16 @@ func (tr *Transfer) Account(ctx context.Context, in io.ReadCloser) *Account {
 	return tr.acc
 }
 
+// SetDst sets the remote the transfer is written to so the bytes
+// transferred can be accounted to it as well as to the source.
+//
+// It must be called before Account.
+func (tr *Transfer) SetDst(f fs.Info) {
+	tr.mu.Lock()
+	tr.dst = f
+	tr.mu.Unlock()
+}
+
 // TimeRange returns the time transfer started and ended at. If not completed
 // it will return zero time for end time.
 func (tr *Transfer) TimeRange() (time.Time, time.Time) {
@@ -0,0 +1,206 @@
+// Package adaptive tunes the number of transfers and checkers run at
+// once from how the remotes respond, as set by --adaptive-concurrency.
+//
+// A Controller limits the operations running at once.  The HTTP
+// requests made for an operation are reported to the Controller in
+// its context, and every interval the Controller
+//
+//   - halves the limit if any requests were throttled with 429 or 503
+//   - lowers it by one if the latency has doubled from the best seen
+//   - raises it by one if all the operations allowed were running
+//
+// so it finds the most the remote will take without throttling.
+package adaptive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// DefaultInterval is how often a Controller adjusts its limit
+const DefaultInterval = 5 * time.Second
+
+// Controller limits the number of operations running at once to a
+// limit tuned from the responses to their requests
+type Controller struct {
+	name     string
+	max      int
+	interval time.Duration
+	timeNow  func() time.Time // for testing
+
+	mu      sync.Mutex
+	limit   int           // number of operations allowed at once
+	inUse   int           // number of operations running
+	changed chan struct{} // closed when an operation may be able to start
+
+	// the responses seen since windowStart
+	windowStart time.Time
+	requests    int
+	throttled   int
+	latency     time.Duration // total latency of the requests not throttled
+	saturated   bool          // set if all the operations allowed were running
+	baseline    time.Duration // the best average latency seen
+}
+
+// New makes a Controller called name which allows up to max
+// operations at once, starting at half that.
+func New(name string, max int) *Controller {
+	if max < 1 {
+		max = 1
+	}
+	c := &Controller{
+		name:     name,
+		max:      max,
+		interval: DefaultInterval,
+		timeNow:  time.Now,
+		limit:    (max + 1) / 2,
+		changed:  make(chan struct{}),
+	}
+	c.windowStart = c.timeNow()
+	return c
+}
+
+// Limit returns the number of operations currently allowed at once
+func (c *Controller) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// signal wakes up anything waiting in Acquire - call with mu held
+func (c *Controller) signal() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Acquire waits until another operation is allowed to run. It
+// returns an error if ctx is cancelled first.
+//
+// Release must be called when the operation is finished.  A nil
+// Controller doesn't limit the operations.
+func (c *Controller) Acquire(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		c.adjust()
+		if c.inUse < c.limit {
+			c.inUse++
+			if c.inUse == c.limit {
+				c.saturated = true
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release marks an operation started with Acquire as finished
+func (c *Controller) Release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.inUse--
+	c.signal()
+	c.mu.Unlock()
+}
+
+// Observe records a response to a request which took latency and
+// whether it was throttled
+func (c *Controller) Observe(throttled bool, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if throttled {
+		c.throttled++
+	} else {
+		c.latency += latency
+	}
+	c.adjust()
+}
+
+// adjust the limit if the interval has passed - call with mu held
+func (c *Controller) adjust() {
+	now := c.timeNow()
+	if now.Sub(c.windowStart) < c.interval {
+		return
+	}
+	var average time.Duration
+	if ok := c.requests - c.throttled; ok > 0 {
+		average = c.latency / time.Duration(ok)
+	}
+	oldLimit := c.limit
+	reason := ""
+	switch {
+	case c.throttled > 0:
+		c.limit /= 2
+		reason = "throttled"
+	case average > 0 && c.baseline > 0 && average > 2*c.baseline:
+		c.limit--
+		reason = "latency up"
+	case c.saturated:
+		c.limit++
+		reason = "all in use"
+	}
+	if c.limit < 1 {
+		c.limit = 1
+	} else if c.limit > c.max {
+		c.limit = c.max
+	}
+	if c.limit != oldLimit {
+		fs.Infof(nil, "Adaptive concurrency: %s %d -> %d (%s: %d of %d requests throttled, latency %v, best %v)",
+			c.name, oldLimit, c.limit, reason, c.throttled, c.requests, average.Round(time.Millisecond), c.baseline.Round(time.Millisecond))
+	}
+	if c.limit > oldLimit {
+		c.signal()
+	}
+
+	// Track the best latency, letting it drift up slowly so a remote
+	// which gets slower for good isn't throttled down to one
+	if average > 0 {
+		if c.baseline == 0 || average < c.baseline {
+			c.baseline = average
+		} else {
+			c.baseline += (average - c.baseline) / 8
+		}
+	}
+
+	c.windowStart = now
+	c.requests = 0
+	c.throttled = 0
+	c.latency = 0
+	c.saturated = c.inUse >= c.limit
+}
+
+type contextKeyType struct{}
+
+// Context key for the Controller
+var contextKey = contextKeyType{}
+
+// WithController returns a context whose requests are reported to c
+// if it isn't nil
+func WithController(ctx context.Context, c *Controller) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey, c)
+}
+
+// FromContext returns the Controller the requests made with ctx
+// should be reported to or nil if there isn't one
+func FromContext(ctx context.Context) *Controller {
+	c, _ := ctx.Value(contextKey).(*Controller)
+	return c
+}
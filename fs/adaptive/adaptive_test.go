@@ -0,0 +1,101 @@
+package adaptive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestController makes a Controller with a clock which the
+// returned function moves on by one interval
+func newTestController(max int) (*Controller, func()) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New("test", max)
+	c.timeNow = func() time.Time { return now }
+	c.windowStart = now
+	return c, func() { now = now.Add(c.interval) }
+}
+
+func TestControllerAdjust(t *testing.T) {
+	c, tick := newTestController(8)
+	assert.Equal(t, 4, c.Limit())
+
+	// saturated so goes up by one each interval
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.Acquire(context.Background()))
+	}
+	tick()
+	c.Observe(false, 100*time.Millisecond)
+	assert.Equal(t, 5, c.Limit())
+	tick()
+	c.Observe(false, 100*time.Millisecond)
+	assert.Equal(t, 5, c.Limit(), "not saturated so stays the same")
+
+	// throttled so halves
+	c.Observe(true, 0)
+	tick()
+	c.Observe(false, 100*time.Millisecond)
+	assert.Equal(t, 2, c.Limit())
+
+	// latency doubled so goes down by one
+	tick()
+	c.Observe(false, 300*time.Millisecond)
+	assert.Equal(t, 1, c.Limit())
+
+	// doesn't go below one
+	c.Observe(true, 0)
+	tick()
+	c.Observe(true, 0)
+	assert.Equal(t, 1, c.Limit())
+
+	// doesn't go above max
+	c.limit = 8
+	tick()
+	c.Observe(false, 100*time.Millisecond)
+	assert.Equal(t, 8, c.Limit())
+}
+
+func TestControllerAcquire(t *testing.T) {
+	ctx := context.Background()
+	c, tick := newTestController(2)
+	assert.Equal(t, 1, c.Limit())
+	require.NoError(t, c.Acquire(ctx))
+
+	// a second Acquire waits until the first is released
+	acquired := make(chan error)
+	go func() {
+		acquired <- c.Acquire(ctx)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Acquire didn't wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Release()
+	require.NoError(t, <-acquired)
+
+	// or until the limit is raised
+	go func() {
+		acquired <- c.Acquire(ctx)
+	}()
+	tick()
+	c.Observe(false, time.Millisecond)
+	require.NoError(t, <-acquired)
+	assert.Equal(t, 2, c.Limit())
+
+	// or the context is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, c.Acquire(ctx))
+
+	// nil Controllers don't limit
+	var nilController *Controller
+	assert.NoError(t, nilController.Acquire(ctx))
+	nilController.Release()
+	assert.Equal(t, ctx, WithController(ctx, nil))
+	assert.Nil(t, FromContext(ctx))
+	assert.Equal(t, c, FromContext(WithController(ctx, c)))
+}
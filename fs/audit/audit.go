@@ -0,0 +1,264 @@
+// Package audit records the changes rclone makes to remotes in an
+// append-only JSON log separate from the normal log.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// Options contains options for controlling the audit log
+type Options struct {
+	File string // Append the audit log to this file
+}
+
+// DefaultOpt is the default values used for Opt
+var DefaultOpt = Options{}
+
+// Opt is the options for the audit log
+var Opt = DefaultOpt
+
+// Actions recorded in the audit log
+const (
+	ActionDelete    = "delete"    // a file was deleted
+	ActionOverwrite = "overwrite" // an existing file was replaced
+	ActionMove      = "move"      // a file was moved or renamed
+	ActionDirMove   = "dirmove"   // a directory was moved or renamed
+	ActionRmdir     = "rmdir"     // a directory was removed
+	ActionPurge     = "purge"     // a directory and its contents were removed
+	ActionLink      = "link"      // a public link was made for a file or directory
+	ActionUnlink    = "unlink"    // a public link was removed
+)
+
+// Triggers of the changes recorded in the audit log
+const (
+	TriggerCLI = "cli" // the command line
+	TriggerRC  = "rc"  // a remote control request
+)
+
+// Source describes what triggered a change
+type Source struct {
+	Trigger string // TriggerCLI or TriggerRC
+	JobID   int64  // the ID of the rc job if set
+}
+
+type sourceContextKey struct{}
+
+// WithSource returns a context which records the changes made with it
+// as triggered by source.
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceContextKey{}, source)
+}
+
+// sourceFromContext returns the Source in ctx defaulting to the
+// command line.
+func sourceFromContext(ctx context.Context) Source {
+	source, ok := ctx.Value(sourceContextKey{}).(Source)
+	if !ok {
+		source.Trigger = TriggerCLI
+	}
+	return source
+}
+
+type suppressContextKey struct{}
+
+// Suppress returns a context in which changes aren't recorded.
+//
+// This is used when a change is made out of others, for example a
+// move done as a copy then a delete, so only the overall change is
+// recorded.
+func Suppress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, suppressContextKey{}, true)
+}
+
+// suppressed returns true if changes made with ctx shouldn't be
+// recorded.
+func suppressed(ctx context.Context) bool {
+	suppress, _ := ctx.Value(suppressContextKey{}).(bool)
+	return suppress
+}
+
+// Entry is a single change in the audit log
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Src     string            `json:"src,omitempty"`
+	Dst     string            `json:"dst,omitempty"`
+	Size    int64             `json:"size"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+	Trigger string            `json:"trigger"`
+	JobID   int64             `json:"jobid,omitempty"`
+	User    string            `json:"user,omitempty"`
+	Host    string            `json:"host,omitempty"`
+	PID     int               `json:"pid"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// auditLog is the open audit log
+type auditLog struct {
+	mu   sync.Mutex
+	out  *os.File // nil once closed
+	user string
+	host string
+}
+
+var (
+	auditorMu sync.Mutex
+	auditor   *auditLog // the open audit log or nil if auditing is disabled
+)
+
+// getAuditor returns the open audit log or nil if auditing is disabled
+func getAuditor() *auditLog {
+	auditorMu.Lock()
+	defer auditorMu.Unlock()
+	return auditor
+}
+
+// Enabled returns true if the audit log is enabled
+func Enabled() bool {
+	return getAuditor() != nil
+}
+
+// Init opens the audit log if configured
+func Init() error {
+	if Opt.File == "" {
+		return nil
+	}
+	out, err := os.OpenFile(Opt.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	l := &auditLog{
+		out: out,
+	}
+	if u, err := user.Current(); err == nil {
+		l.user = u.Username
+	}
+	l.host, _ = os.Hostname()
+	auditorMu.Lock()
+	auditor = l
+	auditorMu.Unlock()
+	return nil
+}
+
+// Close the audit log
+func Close() {
+	auditorMu.Lock()
+	l := auditor
+	auditor = nil
+	auditorMu.Unlock()
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.out.Close()
+	// Records in progress when the log was closed are dropped
+	l.out = nil
+	if err != nil {
+		fs.Errorf(nil, "Failed to close audit log: %v", err)
+	}
+}
+
+// Name returns the name of an object or directory for the audit log
+// as remote:path.
+func Name(f fs.Info, remote string) string {
+	root := fs.ConfigString(f)
+	if remote == "" || strings.HasSuffix(root, ":") || strings.HasSuffix(root, "/") {
+		return root + remote
+	}
+	return root + "/" + remote
+}
+
+// Hashes returns the hashes of o for the audit log.
+//
+// To avoid reading the file, hashes are only returned for backends
+// which can supply them without extra work.
+func Hashes(ctx context.Context, o fs.ObjectInfo) map[string]string {
+	if o == nil || !Enabled() {
+		return nil
+	}
+	features := o.Fs().Features()
+	if features.IsLocal || features.SlowHash {
+		return nil
+	}
+	hashes := map[string]string{}
+	for _, ht := range o.Fs().Hashes().Array() {
+		sum, err := o.Hash(ctx, ht)
+		if err == nil && sum != "" {
+			hashes[ht.String()] = sum
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	return hashes
+}
+
+// Record writes entry to the audit log if enabled filling in the time
+// and what triggered the change from ctx.
+func Record(ctx context.Context, entry Entry) {
+	l := getAuditor()
+	if l == nil || suppressed(ctx) {
+		return
+	}
+	source := sourceFromContext(ctx)
+	entry.Time = time.Now()
+	entry.Trigger = source.Trigger
+	entry.JobID = source.JobID
+	entry.User = l.user
+	entry.Host = l.host
+	entry.PID = os.Getpid()
+	out, err := json.Marshal(entry)
+	if err != nil {
+		fs.Errorf(nil, "Failed to encode audit log entry: %v", err)
+		return
+	}
+	out = append(out, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == nil {
+		return
+	}
+	_, err = l.out.Write(out)
+	if err != nil {
+		fs.Errorf(nil, "Failed to write audit log: %v", err)
+	}
+}
+
+// RecordObject writes an entry about action on src and/or dst with
+// the error if any to the audit log.
+//
+// The size and hashes are those of dst if set, otherwise those of
+// src.
+func RecordObject(ctx context.Context, action string, src, dst fs.ObjectInfo, err error) {
+	if !Enabled() || suppressed(ctx) {
+		return
+	}
+	entry := Entry{
+		Action: action,
+	}
+	if src != nil {
+		entry.Src = Name(src.Fs(), src.Remote())
+		entry.Size = src.Size()
+	}
+	if dst != nil {
+		entry.Dst = Name(dst.Fs(), dst.Remote())
+		entry.Size = dst.Size()
+		entry.Hashes = Hashes(ctx, dst)
+	} else {
+		entry.Hashes = Hashes(ctx, src)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	Record(ctx, entry)
+}
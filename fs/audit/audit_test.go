@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEntries reads the audit log entries from path
+func readEntries(t *testing.T, path string) (entries []Entry) {
+	in, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, in.Close())
+	}()
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var entry Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestName(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "potato:file.txt", Name(mockfs.NewFs(ctx, "potato", ""), "file.txt"))
+	assert.Equal(t, "potato:dir/file.txt", Name(mockfs.NewFs(ctx, "potato", "dir"), "file.txt"))
+	assert.Equal(t, "potato:dir", Name(mockfs.NewFs(ctx, "potato", "dir"), ""))
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-audit-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "audit.log")
+
+	// Disabled so nothing recorded
+	assert.False(t, Enabled())
+	Record(ctx, Entry{Action: ActionDelete})
+
+	Opt.File = path
+	defer func() {
+		Opt = DefaultOpt
+	}()
+	require.NoError(t, Init())
+	assert.True(t, Enabled())
+
+	f := mockfs.NewFs(ctx, "potato", "dir")
+	o := mockobject.New("file.txt").WithContent([]byte("hello"), mockobject.SeekModeNone)
+	o.SetFs(f)
+	RecordObject(ctx, ActionDelete, o, nil, nil)
+	rcCtx := WithSource(ctx, Source{Trigger: TriggerRC, JobID: 42})
+	Record(rcCtx, Entry{Action: ActionRmdir, Src: Name(f, "sub"), Error: errors.New("boom").Error()})
+	// Suppressed so nothing recorded
+	suppressCtx := Suppress(ctx)
+	RecordObject(suppressCtx, ActionDelete, o, nil, nil)
+	Record(suppressCtx, Entry{Action: ActionPurge})
+	Close()
+	assert.False(t, Enabled())
+
+	entries := readEntries(t, path)
+	require.Equal(t, 2, len(entries))
+
+	assert.Equal(t, ActionDelete, entries[0].Action)
+	assert.Equal(t, "potato:dir/file.txt", entries[0].Src)
+	assert.Equal(t, "", entries[0].Dst)
+	assert.Equal(t, int64(5), entries[0].Size)
+	assert.Equal(t, TriggerCLI, entries[0].Trigger)
+	assert.Equal(t, int64(0), entries[0].JobID)
+	assert.Equal(t, os.Getpid(), entries[0].PID)
+	assert.False(t, entries[0].Time.IsZero())
+
+	assert.Equal(t, ActionRmdir, entries[1].Action)
+	assert.Equal(t, "potato:dir/sub", entries[1].Src)
+	assert.Equal(t, TriggerRC, entries[1].Trigger)
+	assert.Equal(t, int64(42), entries[1].JobID)
+	assert.Equal(t, "boom", entries[1].Error)
+
+	// Check the log is appended to
+	require.NoError(t, Init())
+	Record(ctx, Entry{Action: ActionPurge})
+	Close()
+	assert.Equal(t, 3, len(readEntries(t, path)))
+}
+
+func TestRecordWhileClosing(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-audit-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "audit.log")
+	Opt.File = path
+	defer func() {
+		Opt = DefaultOpt
+	}()
+	require.NoError(t, Init())
+
+	// Record from several goroutines while the log is closed which
+	// the race detector checks
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Record(ctx, Entry{Action: ActionDelete, Src: "potato:file.txt"})
+			}
+		}()
+	}
+	Close()
+	wg.Wait()
+	assert.False(t, Enabled())
+
+	// Every entry written is complete
+	for _, entry := range readEntries(t, path) {
+		assert.Equal(t, ActionDelete, entry.Action)
+	}
+}
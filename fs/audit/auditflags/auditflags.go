@@ -0,0 +1,15 @@
+// Package auditflags implements command line flags to set up the audit log
+package auditflags
+
+import (
+	"github.com/rclone/rclone/fs/audit"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the audit log flags to the flagSet
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("audit", &audit.Opt)
+	flags.StringVarP(flagSet, &audit.Opt.File, "audit-log", "", audit.Opt.File, "Append a JSON record of every change made to remotes to this file")
+}
@@ -35,6 +35,14 @@ var (
 	// implementation from the fs
 	CountError = func(err error) error { return nil }
 
+	// CountCall counts a call made to the API of the backend of
+	// remote, e.g. an HTTP request with operation "GET", and whether
+	// it failed.
+	//
+	// This is a function pointer to decouple the accounting
+	// implementation from the fs
+	CountCall = func(ctx context.Context, remote, operation string, failed bool) {}
+
 	// ConfigProvider is the config key used for provider options
 	ConfigProvider = "provider"
 )
@@ -44,28 +52,41 @@ type ConfigInfo struct {
 	LogLevel               LogLevel
 	StatsLogLevel          LogLevel
 	UseJSONLog             bool
+	LogRepeatInterval      time.Duration // Collapse repeated log messages within this interval
+	LogRedact              []string      // Regexps of secrets to redact from the log
 	DryRun                 bool
 	Interactive            bool
 	CheckSum               bool
+	ChecksumDB             bool // Keep the hashes of objects on remotes without them in a local database
 	SizeOnly               bool
 	IgnoreTimes            bool
 	IgnoreExisting         bool
 	IgnoreErrors           bool
 	ModifyWindow           time.Duration
 	Checkers               int
+	ListWorkers            int // number of directory listings to run in parallel, 0 for Checkers
 	Transfers              int
 	ConnectTimeout         time.Duration // Connect timeout
 	Timeout                time.Duration // Data channel timeout
 	ExpectContinueTimeout  time.Duration
 	Dump                   DumpFlags
-	InsecureSkipVerify     bool // Skip server certificate verification
+	DumpDir                string // Dump the HTTP bodies to files in this directory
+	InsecureSkipVerify     bool   // Skip server certificate verification
 	DeleteMode             DeleteMode
 	MaxDelete              int64
 	TrackRenames           bool   // Track file renames.
 	TrackRenamesStrategy   string // Comma separated list of strategies used to track renames
+	UseChangeListing       bool   // Only sync the paths the source says have changed since the last sync
+	Resume                 string // Checkpoint file to resume the sync from and write the progress to
+	CheckpointInterval     time.Duration
+	DiffFormat             string // Format to print the plan of a --dry-run in, text or json
+	ApplyPlan              string // Plan file to carry out instead of syncing
+	ErrorReport            string // File to write the objects which failed to as JSON
+	SyncAtomic             bool   // Upload to a staging directory then move the files into place
 	LowLevelRetries        int
-	UpdateOlder            bool // Skip files that are newer on the destination
-	NoGzip                 bool // Disable compression
+	UpdateOlder            bool   // Skip files that are newer on the destination
+	NoGzip                 bool   // Disable compression
+	TransferCompression    string // Compress data in transit to remotes which support it
 	MaxDepth               int
 	IgnoreSize             bool
 	IgnoreChecksum         bool
@@ -81,6 +102,7 @@ type ConfigInfo struct {
 	BackupDir              string
 	Suffix                 string
 	SuffixKeepExtension    bool
+	BackupVersioning       bool
 	UseListR               bool
 	BufferSize             SizeSuffix
 	BwLimit                BwTimetable
@@ -91,11 +113,16 @@ type ConfigInfo struct {
 	DisableFeatures        []string
 	UserAgent              string
 	Immutable              bool
+	ImmutableDest          bool   // write new versions and tombstones instead of overwriting and deleting
+	ImmutableDestPattern   string // how to name the new versions for --immutable-dest
+	DirsMetadata           bool   // sync the modification times and metadata of directories
 	AutoConfirm            bool
 	StreamingUploadCutoff  SizeSuffix
 	StatsFileNameLength    int
 	AskPassword            bool
 	PasswordCommand        SpaceSepList
+	PreTransferCmd         SpaceSepList // run before each file is transferred
+	PostTransferCmd        SpaceSepList // run after each file is transferred
 	UseServerModTime       bool
 	MaxTransfer            SizeSuffix
 	MaxDuration            time.Duration
@@ -108,15 +135,19 @@ type ConfigInfo struct {
 	ErrorOnNoTransfer      bool   // Set appropriate exit code if no files transferred
 	Progress               bool
 	ProgressTerminalTitle  bool
+	ProgressJSON           bool
 	Cookie                 bool
 	UseMmap                bool
 	CaCert                 string // Client Side CA
 	ClientCert             string // Client Side Cert
 	ClientKey              string // Client Side Key
+	AdaptiveConcurrency    bool   // tune the number of transfers and checkers from the responses
 	MultiThreadCutoff      SizeSuffix
 	MultiThreadStreams     int
-	MultiThreadSet         bool   // whether MultiThreadStreams was set (set in fs/config/configflags)
-	OrderBy                string // instructions on how to order the transfer
+	MultiThreadSet         bool     // whether MultiThreadStreams was set (set in fs/config/configflags)
+	DeltaTransfers         bool     // update changed files in place by writing only the changed blocks
+	OrderBy                string   // instructions on how to order the transfer
+	OrderByInclude         []string // globs of files to transfer first, in priority order
 	UploadHeaders          []*HTTPOption
 	DownloadHeaders        []*HTTPOption
 	Headers                []*HTTPOption
@@ -158,6 +189,8 @@ func NewConfig() *ConfigInfo {
 	c.MultiThreadStreams = 4
 
 	c.TrackRenamesStrategy = "hash"
+	c.ImmutableDestPattern = "{name}.v{time}{ext}"
+	c.CheckpointInterval = 30 * time.Second
 
 	return c
 }
@@ -167,6 +200,15 @@ type configContextKeyType struct{}
 // Context key for config
 var configContextKey = configContextKeyType{}
 
+// ListWorkerCount returns the number of directory listings to run in
+// parallel when traversing a directory tree.
+func (c *ConfigInfo) ListWorkerCount() int {
+	if c.ListWorkers > 0 {
+		return c.ListWorkers
+	}
+	return c.Checkers
+}
+
 // GetConfig returns the global or context sensitive context
 func GetConfig(ctx context.Context) *ConfigInfo {
 	if ctx == nil {
@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -449,6 +450,25 @@ func getConfigPassword(q string) {
 	}
 }
 
+// ErrorConfigNotEncrypted is returned by DeriveKey if the config
+// file isn't encrypted so there is no password to derive a key from
+var ErrorConfigNotEncrypted = errors.New("config file isn't encrypted")
+
+// DeriveKey returns a 32 byte key for purpose derived from the
+// configuration password, so other data can be encrypted with it
+// without revealing the key of the config file.
+//
+// It returns ErrorConfigNotEncrypted if the config file isn't
+// encrypted.
+func DeriveKey(purpose string) ([]byte, error) {
+	if len(configKey) == 0 {
+		return nil, ErrorConfigNotEncrypted
+	}
+	mac := hmac.New(sha256.New, configKey)
+	_, _ = mac.Write([]byte("[" + purpose + "][rclone-derived-key]"))
+	return mac.Sum(nil), nil
+}
+
 // setConfigPassword will set the configKey to the hash of
 // the password. If the length of the password is
 // zero after trimming+normalization, an error is returned.
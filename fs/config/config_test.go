@@ -398,6 +398,29 @@ func TestPassword(t *testing.T) {
 
 }
 
+func TestDeriveKey(t *testing.T) {
+	defer func() {
+		configKey = nil // reset password
+	}()
+	configKey = nil
+	_, err := DeriveKey("potato")
+	assert.Equal(t, ErrorConfigNotEncrypted, err)
+
+	require.NoError(t, setConfigPassword("password"))
+	k1, err := DeriveKey("potato")
+	require.NoError(t, err)
+	assert.Len(t, k1, 32)
+	assert.NotEqual(t, configKey, k1)
+
+	k2, err := DeriveKey("potato")
+	require.NoError(t, err)
+	assert.Equal(t, k1, k2)
+
+	k3, err := DeriveKey("sausage")
+	require.NoError(t, err)
+	assert.NotEqual(t, k1, k3)
+}
+
 func hashedKeyCompare(t *testing.T, a, b string, shouldMatch bool) {
 	err := setConfigPassword(a)
 	require.NoError(t, err)
@@ -453,3 +476,30 @@ func TestFileRefresh(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"refreshed_test"}, configFile.GetSectionList())
 }
+
+func TestValidateParameters(t *testing.T) {
+	ri := &fs.RegInfo{
+		Name: "validate",
+		Options: fs.Options{{
+			Name:    "size",
+			Default: fs.SizeSuffix(1024),
+		}, {
+			Name:     "needed",
+			Required: true,
+		}, {
+			Name:     "s3only",
+			Required: true,
+			Provider: "S3",
+		}},
+	}
+	assert.NoError(t, ValidateParameters(ri, rc.Params{"size": "10M", "needed": "x"}, true))
+	assert.NoError(t, ValidateParameters(ri, rc.Params{"size": "10M"}, false))
+	assert.NoError(t, ValidateParameters(ri, rc.Params{"needed": "x", "unknown": "y"}, true))
+	err := ValidateParameters(ri, rc.Params{"size": "potato"}, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "needed: value is required")
+	assert.Contains(t, err.Error(), "size: ")
+	err = ValidateParameters(ri, rc.Params{"needed": "x", "provider": "S3"}, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "s3only: value is required")
+}
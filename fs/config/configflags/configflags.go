@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/rclone/rclone/fs"
@@ -14,7 +15,7 @@ import (
 	"github.com/rclone/rclone/fs/config/flags"
 	fsLog "github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/rc"
-	"github.com/sirupsen/logrus"
+	"github.com/rclone/rclone/lib/transfercompress"
 	"github.com/spf13/pflag"
 )
 
@@ -43,9 +44,12 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.DurationVarP(flagSet, &ci.ModifyWindow, "modify-window", "", ci.ModifyWindow, "Max time diff to be considered the same")
 	flags.IntVarP(flagSet, &ci.Checkers, "checkers", "", ci.Checkers, "Number of checkers to run in parallel.")
 	flags.IntVarP(flagSet, &ci.Transfers, "transfers", "", ci.Transfers, "Number of file transfers to run in parallel.")
+	flags.BoolVarP(flagSet, &ci.AdaptiveConcurrency, "adaptive-concurrency", "", ci.AdaptiveConcurrency, "Tune the number of checkers and transfers, up to --checkers and --transfers, from how the remote responds.")
+	flags.IntVarP(flagSet, &ci.ListWorkers, "list-workers", "", ci.ListWorkers, "Number of directory listings to run in parallel, 0 to use --checkers.")
 	flags.StringVarP(flagSet, &config.ConfigPath, "config", "", config.ConfigPath, "Config file.")
 	flags.StringVarP(flagSet, &config.CacheDir, "cache-dir", "", config.CacheDir, "Directory rclone will use for caching.")
 	flags.BoolVarP(flagSet, &ci.CheckSum, "checksum", "c", ci.CheckSum, "Skip based on checksum (if available) & size, not mod-time & size")
+	flags.BoolVarP(flagSet, &ci.ChecksumDB, "checksum-db", "", ci.ChecksumDB, "Keep hashes of files on remotes without them in a local database for --checksum")
 	flags.BoolVarP(flagSet, &ci.SizeOnly, "size-only", "", ci.SizeOnly, "Skip based on size only, not mod-time or checksum")
 	flags.BoolVarP(flagSet, &ci.IgnoreTimes, "ignore-times", "I", ci.IgnoreTimes, "Don't skip files that match size and time - transfer all files")
 	flags.BoolVarP(flagSet, &ci.IgnoreExisting, "ignore-existing", "", ci.IgnoreExisting, "Skip all files that exist on destination")
@@ -60,16 +64,26 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &ci.InsecureSkipVerify, "no-check-certificate", "", ci.InsecureSkipVerify, "Do not verify the server SSL certificate. Insecure.")
 	flags.BoolVarP(flagSet, &ci.AskPassword, "ask-password", "", ci.AskPassword, "Allow prompt for password for encrypted configuration.")
 	flags.FVarP(flagSet, &ci.PasswordCommand, "password-command", "", "Command for supplying password for encrypted configuration.")
+	flags.FVarP(flagSet, &ci.PreTransferCmd, "pre-transfer-cmd", "", "Command to run before each file is transferred, failing the transfer if it fails.")
+	flags.FVarP(flagSet, &ci.PostTransferCmd, "post-transfer-cmd", "", "Command to run after each file is transferred.")
 	flags.BoolVarP(flagSet, &deleteBefore, "delete-before", "", false, "When synchronizing, delete files on destination before transferring")
 	flags.BoolVarP(flagSet, &deleteDuring, "delete-during", "", false, "When synchronizing, delete files during transfer")
 	flags.BoolVarP(flagSet, &deleteAfter, "delete-after", "", false, "When synchronizing, delete files on destination after transferring (default)")
 	flags.Int64VarP(flagSet, &ci.MaxDelete, "max-delete", "", -1, "When synchronizing, limit the number of deletes")
 	flags.BoolVarP(flagSet, &ci.TrackRenames, "track-renames", "", ci.TrackRenames, "When synchronizing, track file renames and do a server-side move if possible")
-	flags.StringVarP(flagSet, &ci.TrackRenamesStrategy, "track-renames-strategy", "", ci.TrackRenamesStrategy, "Strategies to use when synchronizing using track-renames hash|modtime|leaf")
+	flags.StringVarP(flagSet, &ci.TrackRenamesStrategy, "track-renames-strategy", "", ci.TrackRenamesStrategy, "Strategies to use when synchronizing using track-renames hash|modtime|leaf|inode|similar")
+	flags.BoolVarP(flagSet, &ci.UseChangeListing, "use-change-notify-listing", "", ci.UseChangeListing, "When synchronizing, only check the paths the source says have changed since the last run")
+	flags.StringVarP(flagSet, &ci.Resume, "resume", "", ci.Resume, "Checkpoint file to resume a sync or copy from, which is written as it runs")
+	flags.DurationVarP(flagSet, &ci.CheckpointInterval, "checkpoint-interval", "", ci.CheckpointInterval, "How often to write the --resume checkpoint")
+	flags.StringVarP(flagSet, &ci.DiffFormat, "diff-format", "", ci.DiffFormat, "Print the changes a --dry-run would make as a plan: text or json")
+	flags.StringVarP(flagSet, &ci.ApplyPlan, "apply-plan", "", ci.ApplyPlan, "Make exactly the changes in a plan written by --dry-run --diff-format json")
+	flags.StringVarP(flagSet, &ci.ErrorReport, "error-report", "", ci.ErrorReport, "Write the objects which failed to this file as JSON for retry-failed")
+	flags.BoolVarP(flagSet, &ci.SyncAtomic, "sync-atomic", "", ci.SyncAtomic, "Upload to a staging directory on the destination and move the files into place at the end")
 	flags.IntVarP(flagSet, &ci.LowLevelRetries, "low-level-retries", "", ci.LowLevelRetries, "Number of low level retries to do.")
 	flags.BoolVarP(flagSet, &ci.UpdateOlder, "update", "u", ci.UpdateOlder, "Skip files that are newer on the destination.")
 	flags.BoolVarP(flagSet, &ci.UseServerModTime, "use-server-modtime", "", ci.UseServerModTime, "Use server modified time instead of object metadata")
 	flags.BoolVarP(flagSet, &ci.NoGzip, "no-gzip-encoding", "", ci.NoGzip, "Don't set Accept-Encoding: gzip.")
+	flags.StringVarP(flagSet, &ci.TransferCompression, "transfer-compression", "", ci.TransferCompression, "Compress data in transit to remotes which support it: off, auto, gzip or zstd.")
 	flags.IntVarP(flagSet, &ci.MaxDepth, "max-depth", "", ci.MaxDepth, "If set limits the recursion depth to this.")
 	flags.BoolVarP(flagSet, &ci.IgnoreSize, "ignore-size", "", false, "Ignore size when skipping use mod-time or checksum.")
 	flags.BoolVarP(flagSet, &ci.IgnoreChecksum, "ignore-checksum", "", ci.IgnoreChecksum, "Skip post copy check of checksums.")
@@ -84,6 +98,8 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &ci.BackupDir, "backup-dir", "", ci.BackupDir, "Make backups into hierarchy based in DIR.")
 	flags.StringVarP(flagSet, &ci.Suffix, "suffix", "", ci.Suffix, "Suffix to add to changed files.")
 	flags.BoolVarP(flagSet, &ci.SuffixKeepExtension, "suffix-keep-extension", "", ci.SuffixKeepExtension, "Preserve the extension when using --suffix.")
+	flags.BoolVarP(flagSet, &ci.DirsMetadata, "dirs-metadata", "", ci.DirsMetadata, "Sync the modification times and metadata of directories after their contents.")
+	flags.BoolVarP(flagSet, &ci.BackupVersioning, "backup-versioning", "", ci.BackupVersioning, "Keep changed files as versions named with a timestamp instead of deleting them.")
 	flags.BoolVarP(flagSet, &ci.UseListR, "fast-list", "", ci.UseListR, "Use recursive list if available. Uses more memory but fewer transactions.")
 	flags.Float64VarP(flagSet, &ci.TPSLimit, "tpslimit", "", ci.TPSLimit, "Limit HTTP transactions per second to this.")
 	flags.IntVarP(flagSet, &ci.TPSLimitBurst, "tpslimit-burst", "", ci.TPSLimitBurst, "Max burst of transactions for --tpslimit.")
@@ -91,6 +107,8 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &disableFeatures, "disable", "", "", "Disable a comma separated list of features.  Use help to see a list.")
 	flags.StringVarP(flagSet, &ci.UserAgent, "user-agent", "", ci.UserAgent, "Set the user-agent to a specified string. The default is rclone/ version")
 	flags.BoolVarP(flagSet, &ci.Immutable, "immutable", "", ci.Immutable, "Do not modify files. Fail if existing files have been modified.")
+	flags.BoolVarP(flagSet, &ci.ImmutableDest, "immutable-dest", "", ci.ImmutableDest, "Write changed files as new versions and deletions as tombstones, for write once destinations.")
+	flags.StringVarP(flagSet, &ci.ImmutableDestPattern, "immutable-dest-pattern", "", ci.ImmutableDestPattern, "How to name the new versions with --immutable-dest using {name}, {time} and {ext}.")
 	flags.BoolVarP(flagSet, &ci.AutoConfirm, "auto-confirm", "", ci.AutoConfirm, "If enabled, do not request console confirmation.")
 	flags.IntVarP(flagSet, &ci.StatsFileNameLength, "stats-file-name-length", "", ci.StatsFileNameLength, "Max file name length in stats. 0 for no limit")
 	flags.FVarP(flagSet, &ci.LogLevel, "log-level", "", "Log level DEBUG|INFO|NOTICE|ERROR")
@@ -100,9 +118,10 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.FVarP(flagSet, &ci.BufferSize, "buffer-size", "", "In memory buffer size when reading files for each --transfer.")
 	flags.FVarP(flagSet, &ci.StreamingUploadCutoff, "streaming-upload-cutoff", "", "Cutoff for switching to chunked upload if file size is unknown. Upload starts after reaching cutoff or when file ends.")
 	flags.FVarP(flagSet, &ci.Dump, "dump", "", "List of items to dump from: "+fs.DumpFlagsList)
+	flags.StringVarP(flagSet, &ci.DumpDir, "dump-dir", "", ci.DumpDir, "Dump the HTTP bodies to files in this directory instead of the log")
 	flags.FVarP(flagSet, &ci.MaxTransfer, "max-transfer", "", "Maximum size of data to transfer.")
 	flags.DurationVarP(flagSet, &ci.MaxDuration, "max-duration", "", 0, "Maximum duration rclone will transfer data for.")
-	flags.FVarP(flagSet, &ci.CutoffMode, "cutoff-mode", "", "Mode to stop transfers when reaching the max transfer limit HARD|SOFT|CAUTIOUS")
+	flags.FVarP(flagSet, &ci.CutoffMode, "cutoff-mode", "", "Mode to stop transfers when reaching the max transfer limit or duration HARD|SOFT|CAUTIOUS")
 	flags.IntVarP(flagSet, &ci.MaxBacklog, "max-backlog", "", ci.MaxBacklog, "Maximum number of objects in sync or check backlog.")
 	flags.IntVarP(flagSet, &ci.MaxStatsGroups, "max-stats-groups", "", ci.MaxStatsGroups, "Maximum number of stats groups to keep in memory. On max oldest is discarded.")
 	flags.BoolVarP(flagSet, &ci.StatsOneLine, "stats-one-line", "", ci.StatsOneLine, "Make the stats fit on one line.")
@@ -111,6 +130,7 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &ci.ErrorOnNoTransfer, "error-on-no-transfer", "", ci.ErrorOnNoTransfer, "Sets exit code 9 if no files are transferred, useful in scripts")
 	flags.BoolVarP(flagSet, &ci.Progress, "progress", "P", ci.Progress, "Show progress during transfer.")
 	flags.BoolVarP(flagSet, &ci.ProgressTerminalTitle, "progress-terminal-title", "", ci.ProgressTerminalTitle, "Show progress on the terminal title. Requires -P/--progress.")
+	flags.BoolVarP(flagSet, &ci.ProgressJSON, "progress-json", "", ci.ProgressJSON, "Show progress as newline delimited JSON on stdout instead of the progress display.")
 	flags.BoolVarP(flagSet, &ci.Cookie, "use-cookies", "", ci.Cookie, "Enable session cookiejar.")
 	flags.BoolVarP(flagSet, &ci.UseMmap, "use-mmap", "", ci.UseMmap, "Use mmap allocator (see docs).")
 	flags.StringVarP(flagSet, &ci.CaCert, "ca-cert", "", ci.CaCert, "CA certificate used to verify servers")
@@ -118,8 +138,12 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &ci.ClientKey, "client-key", "", ci.ClientKey, "Client SSL private key (PEM) for mutual TLS auth")
 	flags.FVarP(flagSet, &ci.MultiThreadCutoff, "multi-thread-cutoff", "", "Use multi-thread downloads for files above this size.")
 	flags.IntVarP(flagSet, &ci.MultiThreadStreams, "multi-thread-streams", "", ci.MultiThreadStreams, "Max number of streams to use for multi-thread downloads.")
+	flags.BoolVarP(flagSet, &ci.DeltaTransfers, "delta-transfers", "", ci.DeltaTransfers, "Update changed files in place by only writing the blocks which have changed.")
 	flags.BoolVarP(flagSet, &ci.UseJSONLog, "use-json-log", "", ci.UseJSONLog, "Use json log format.")
+	flags.DurationVarP(flagSet, &ci.LogRepeatInterval, "log-repeat-interval", "", ci.LogRepeatInterval, "Collapse repeated log messages into a summary this often (0 to disable).")
+	flags.StringArrayVarP(flagSet, &ci.LogRedact, "log-redact", "", ci.LogRedact, "Redact text matching this regexp from the log (repeat as required).")
 	flags.StringVarP(flagSet, &ci.OrderBy, "order-by", "", ci.OrderBy, "Instructions on how to order the transfers, e.g. 'size,descending'")
+	flags.StringArrayVarP(flagSet, &ci.OrderByInclude, "order-by-include", "", ci.OrderByInclude, "Transfer files matching this glob before the others (repeat in priority order).")
 	flags.StringArrayVarP(flagSet, &uploadHeaders, "header-upload", "", nil, "Set HTTP header for upload transactions")
 	flags.StringArrayVarP(flagSet, &downloadHeaders, "header-download", "", nil, "Set HTTP header for download transactions")
 	flags.StringArrayVarP(flagSet, &headers, "header", "", nil, "Set HTTP header for all transactions")
@@ -165,25 +189,11 @@ func SetFlags(ci *fs.ConfigInfo) {
 			log.Fatalf("Can't set -q and --log-level")
 		}
 	}
-	if ci.UseJSONLog {
-		logrus.AddHook(fsLog.NewCallerHook())
-		logrus.SetFormatter(&logrus.JSONFormatter{
-			TimestampFormat: "2006-01-02T15:04:05.999999-07:00",
-		})
-		logrus.SetLevel(logrus.DebugLevel)
-		switch ci.LogLevel {
-		case fs.LogLevelEmergency, fs.LogLevelAlert:
-			logrus.SetLevel(logrus.PanicLevel)
-		case fs.LogLevelCritical:
-			logrus.SetLevel(logrus.FatalLevel)
-		case fs.LogLevelError:
-			logrus.SetLevel(logrus.ErrorLevel)
-		case fs.LogLevelWarning, fs.LogLevelNotice:
-			logrus.SetLevel(logrus.WarnLevel)
-		case fs.LogLevelInfo:
-			logrus.SetLevel(logrus.InfoLevel)
-		case fs.LogLevelDebug:
-			logrus.SetLevel(logrus.DebugLevel)
+	fsLog.SetupJSONLog(ci)
+
+	for _, pattern := range ci.LogRedact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			log.Fatalf("--log-redact: Failed to parse %q as regexp: %v", pattern, err)
 		}
 	}
 
@@ -195,6 +205,9 @@ func SetFlags(ci *fs.ConfigInfo) {
 		ci.Dump |= fs.DumpBodies
 		fs.Logf(nil, "--dump-bodies is obsolete - please use --dump bodies instead")
 	}
+	if ci.DumpDir != "" && ci.Dump&(fs.DumpBodies|fs.DumpRequests|fs.DumpResponses) == 0 {
+		ci.Dump |= fs.DumpBodies
+	}
 
 	switch {
 	case deleteBefore && (deleteDuring || deleteAfter),
@@ -210,6 +223,10 @@ func SetFlags(ci *fs.ConfigInfo) {
 		ci.DeleteMode = fs.DeleteModeDefault
 	}
 
+	if err := transfercompress.Check(ci.TransferCompression); err != nil {
+		log.Fatalf("--transfer-compression: %v", err)
+	}
+
 	if ci.CompareDest != "" && ci.CopyDest != "" {
 		log.Fatalf(`Can't use --compare-dest with --copy-dest.`)
 	}
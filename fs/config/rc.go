@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 
+	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
 )
@@ -104,6 +105,63 @@ func rcProviders(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	return out, nil
 }
 
+func init() {
+	rc.Add(rc.Call{
+		Path:         "config/schema",
+		Fn:           rcSchema,
+		Title:        "Shows the JSON schema for configuring the providers.",
+		AuthRequired: true,
+		Help: `
+This returns a machine readable description of the parameters which
+can be used with config/create and config/update for each provider.
+
+Parameters:
+
+- type - optional name of the provider, e.g. "drive" - all if not set
+
+Returns a JSON object:
+- schemas - object with a JSON schema (draft-07) for each provider
+
+Each schema has a "properties" object with an entry for each option
+and a "required" list.  Each option has "type", "default" and
+"description" and "examples" if the option has suggested values.  The
+rclone specific parts are in fields starting with "x-rclone-"
+
+- x-rclone-type - the rclone type of the option, e.g. "SizeSuffix"
+- x-rclone-examples - the examples with "value", "help" and "provider"
+- x-rclone-provider - the option only applies to these providers
+- x-rclone-advanced - true if this is an advanced option
+- x-rclone-hide - true if the option shouldn't be shown in a config wizard
+
+The schema itself also has "x-rclone-order" which is the order to
+show the options in, "x-rclone-prefix" which is the prefix for the
+command line flags and "x-rclone-oauth" which is true if the provider
+needs an OAuth flow to be run with config/oauth/start.
+`,
+	})
+}
+
+// Return the JSON schema for the providers
+func rcSchema(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	remoteType, err := in.GetString("type")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	schemas := rc.Params{}
+	if remoteType != "" {
+		ri, err := fs.Find(remoteType)
+		if err != nil {
+			return nil, err
+		}
+		schemas[ri.Name] = ProviderSchema(ri)
+	} else {
+		for _, ri := range fs.Registry {
+			schemas[ri.Name] = ProviderSchema(ri)
+		}
+	}
+	return rc.Params{"schemas": schemas}, nil
+}
+
 func init() {
 	for _, name := range []string{"create", "update", "password"} {
 		name := name
@@ -127,6 +185,13 @@ func init() {
 - name - name of remote
 - parameters - a map of \{ "key": "value" \} pairs
 ` + extraHelp + `
+The parameters are checked against the schema of the provider (see
+config/schema) and an error is returned if a value can't be parsed or
+a required value is missing when creating the remote.  The remote must
+exist already unless it is being created.
+
+If the provider needs an OAuth flow it isn't run - use
+config/oauth/start afterwards to run it.
 
 See the [config ` + name + ` command](/commands/rclone_config_` + name + `/) command for more information on the above.`,
 		})
@@ -146,21 +211,53 @@ func rcConfig(ctx context.Context, in rc.Params, what string) (out rc.Params, er
 	}
 	doObscure, _ := in.GetBool("obscure")
 	noObscure, _ := in.GetBool("noObscure")
+	// The OAuth flow can't be run from here - use config/oauth/start
+	ctx = WithOAuthDeferred(ctx)
 	switch what {
 	case "create":
 		remoteType, err := in.GetString("type")
 		if err != nil {
 			return nil, err
 		}
+		ri, err := fs.Find(remoteType)
+		if err != nil {
+			return nil, err
+		}
+		err = ValidateParameters(ri, parameters, true)
+		if err != nil {
+			return nil, err
+		}
 		return nil, CreateRemote(ctx, name, remoteType, parameters, doObscure, noObscure)
 	case "update":
+		ri, err := findRemote(name)
+		if err != nil {
+			return nil, err
+		}
+		err = ValidateParameters(ri, parameters, false)
+		if err != nil {
+			return nil, err
+		}
 		return nil, UpdateRemote(ctx, name, parameters, doObscure, noObscure)
 	case "password":
+		_, err := findRemote(name)
+		if err != nil {
+			return nil, err
+		}
 		return nil, PasswordRemote(ctx, name, parameters)
 	}
 	panic("unknown rcConfig type")
 }
 
+// findRemote returns the provider of the remote called name or an
+// error if the remote isn't in the config file
+func findRemote(name string) (*fs.RegInfo, error) {
+	remoteType := FileGet(name, "type")
+	if remoteType == "" {
+		return nil, errors.Errorf("remote %q not found", name)
+	}
+	return fs.Find(remoteType)
+}
+
 func init() {
 	rc.Add(rc.Call{
 		Path:         "config/delete",
@@ -183,6 +280,9 @@ func rcDelete(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if FileGet(name, "type") == "" {
+		return nil, errors.Errorf("remote %q not found", name)
+	}
 	DeleteRemote(name)
 	return nil, nil
 }
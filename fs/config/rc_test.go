@@ -150,3 +150,55 @@ func TestRcProviders(t *testing.T) {
 	}
 	assert.True(t, foundLocal, "didn't find local provider")
 }
+
+func TestRcSchema(t *testing.T) {
+	call := rc.Calls.Get("config/schema")
+	assert.NotNil(t, call)
+	out, err := call.Fn(context.Background(), rc.Params{"type": "local"})
+	require.NoError(t, err)
+	schemas := out["schemas"].(rc.Params)
+	require.Equal(t, 1, len(schemas))
+	schema := schemas["local"].(rc.Params)
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, false, schema["x-rclone-oauth"])
+	properties := schema["properties"].(rc.Params)
+	copyLinks := properties["copy_links"].(rc.Params)
+	assert.Equal(t, "boolean", copyLinks["type"])
+	assert.Equal(t, false, copyLinks["default"])
+	assert.Equal(t, true, copyLinks["x-rclone-advanced"])
+
+	_, err = call.Fn(context.Background(), rc.Params{"type": "potato"})
+	assert.Error(t, err)
+
+	out, err = call.Fn(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	assert.Contains(t, out["schemas"], "local")
+}
+
+func TestRcValidate(t *testing.T) {
+	ctx := context.Background()
+
+	// Bad values are rejected
+	_, err := rc.Calls.Get("config/create").Fn(ctx, rc.Params{
+		"name":       testName,
+		"type":       "local",
+		"parameters": rc.Params{"copy_links": "potato"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "", config.FileGet(testName, "type"))
+	_, err = rc.Calls.Get("config/create").Fn(ctx, rc.Params{
+		"name": testName,
+		"type": "potato",
+	})
+	assert.Error(t, err)
+
+	// Remotes which don't exist can't be updated or deleted
+	for _, name := range []string{"update", "password", "delete"} {
+		_, err = rc.Calls.Get("config/"+name).Fn(ctx, rc.Params{
+			"name":       testName,
+			"parameters": rc.Params{"copy_links": "true"},
+		})
+		assert.Error(t, err, name)
+	}
+	assert.Equal(t, "", config.FileGet(testName, "type"))
+}
@@ -0,0 +1,201 @@
+// Machine readable schemas for the providers and validation of
+// remote parameters against them
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// deferOAuthKey is the context key for WithOAuthDeferred
+type deferOAuthKey struct{}
+
+// WithOAuthDeferred returns a context which tells the backend config
+// not to run the OAuth flow as it will be run later, e.g. with
+// "config/oauth/start" over the rc.
+func WithOAuthDeferred(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deferOAuthKey{}, true)
+}
+
+// OAuthDeferred returns true if the OAuth flow shouldn't be run by
+// the backend config as it will be run later.
+func OAuthDeferred(ctx context.Context) bool {
+	deferred, _ := ctx.Value(deferOAuthKey{}).(bool)
+	return deferred
+}
+
+// UsesOAuth returns true if the provider is configured with an OAuth
+// flow
+func UsesOAuth(ri *fs.RegInfo) bool {
+	if ri.Config == nil {
+		return false
+	}
+	for _, opt := range ri.Options {
+		if opt.Name == ConfigToken {
+			return true
+		}
+	}
+	return false
+}
+
+// optionSchema returns the JSON schema for a single option
+func optionSchema(opt *fs.Option) rc.Params {
+	schema := rc.Params{
+		"description": opt.Help,
+	}
+	value := opt.Default
+	if value == nil {
+		value = ""
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+		schema["default"] = value
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+		schema["default"] = value
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+		schema["default"] = value
+	default:
+		// Everything else is set as a string in the config file
+		schema["type"] = "string"
+		schema["default"] = fmt.Sprint(value)
+	}
+	schema["x-rclone-type"] = opt.Type()
+	if opt.IsPassword {
+		schema["format"] = "password"
+	}
+	if len(opt.Examples) > 0 {
+		var examples []interface{}
+		var rcloneExamples []rc.Params
+		for _, example := range opt.Examples {
+			examples = append(examples, example.Value)
+			rcloneExample := rc.Params{
+				"value": example.Value,
+				"help":  example.Help,
+			}
+			if example.Provider != "" {
+				rcloneExample["provider"] = example.Provider
+			}
+			rcloneExamples = append(rcloneExamples, rcloneExample)
+		}
+		schema["examples"] = examples
+		schema["x-rclone-examples"] = rcloneExamples
+	}
+	if opt.Provider != "" {
+		schema["x-rclone-provider"] = opt.Provider
+	}
+	if opt.Advanced {
+		schema["x-rclone-advanced"] = true
+	}
+	if opt.Hide&fs.OptionHideConfigurator != 0 {
+		schema["x-rclone-hide"] = true
+	}
+	return schema
+}
+
+// ProviderSchema returns a JSON schema describing the parameters
+// which can be used to configure a remote of the provider.
+//
+// The rclone specific parts of the options are in fields starting
+// with "x-rclone-".
+func ProviderSchema(ri *fs.RegInfo) rc.Params {
+	properties := rc.Params{}
+	var order []string
+	required := []string{}
+	for i := range ri.Options {
+		opt := &ri.Options[i]
+		properties[opt.Name] = optionSchema(opt)
+		order = append(order, opt.Name)
+		if opt.Required {
+			required = append(required, opt.Name)
+		}
+	}
+	prefix := ri.Prefix
+	if prefix == "" {
+		prefix = ri.Name
+	}
+	return rc.Params{
+		"$schema":         "http://json-schema.org/draft-07/schema#",
+		"title":           ri.Name,
+		"description":     ri.Description,
+		"type":            "object",
+		"properties":      properties,
+		"required":        required,
+		"x-rclone-prefix": prefix,
+		"x-rclone-order":  order,
+		"x-rclone-oauth":  UsesOAuth(ri),
+	}
+}
+
+// findOption returns the option called name or nil if not found
+func findOption(ri *fs.RegInfo, name string) *fs.Option {
+	for i := range ri.Options {
+		if ri.Options[i].Name == name {
+			return &ri.Options[i]
+		}
+	}
+	return nil
+}
+
+// ValidateParameters checks the keyValues are valid for the
+// provider.  Each value must parse as the type of its option and if
+// isNew is set then all the required options must be present.
+//
+// Keys which aren't options of the provider are allowed as some
+// backends store extra values in the config.
+func ValidateParameters(ri *fs.RegInfo, keyValues rc.Params, isNew bool) error {
+	var problems []string
+	for key, value := range keyValues {
+		opt := findOption(ri, key)
+		if opt == nil || opt.IsPassword {
+			continue
+		}
+		valueStr := fmt.Sprint(value)
+		if valueStr == "" {
+			continue
+		}
+		defaultValue := opt.Default
+		if defaultValue == nil {
+			defaultValue = ""
+		}
+		if _, err := configstruct.StringToInterface(defaultValue, valueStr); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	if isNew {
+		provider := ""
+		if value, ok := keyValues[fs.ConfigProvider]; ok {
+			provider = fmt.Sprint(value)
+		}
+		for i := range ri.Options {
+			opt := &ri.Options[i]
+			if !opt.Required || (opt.Default != nil && fmt.Sprint(opt.Default) != "") {
+				continue
+			}
+			// Options for other providers aren't needed
+			if opt.Provider != "" && (provider == "" || !matchProvider(opt.Provider, provider)) {
+				continue
+			}
+			if value, ok := keyValues[opt.Name]; !ok || fmt.Sprint(value) == "" {
+				problems = append(problems, fmt.Sprintf("%s: value is required", opt.Name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.Errorf("invalid parameters for %q: %s", ri.Name, strings.Join(problems, ", "))
+	}
+	return nil
+}
@@ -81,21 +81,22 @@ type FilesMap map[string]struct{}
 
 // Opt configures the filter
 type Opt struct {
-	DeleteExcluded bool
-	FilterRule     []string
-	FilterFrom     []string
-	ExcludeRule    []string
-	ExcludeFrom    []string
-	ExcludeFile    string
-	IncludeRule    []string
-	IncludeFrom    []string
-	FilesFrom      []string
-	FilesFromRaw   []string
-	MinAge         fs.Duration
-	MaxAge         fs.Duration
-	MinSize        fs.SizeSuffix
-	MaxSize        fs.SizeSuffix
-	IgnoreCase     bool
+	DeleteExcluded    bool
+	FilterRule        []string
+	FilterFrom        []string
+	ExcludeRule       []string
+	ExcludeFrom       []string
+	ExcludeFile       string
+	IncludeRule       []string
+	IncludeFrom       []string
+	FilesFrom         []string
+	FilesFromRaw      []string
+	FilesFromManifest []string
+	MinAge            fs.Duration
+	MaxAge            fs.Duration
+	MinSize           fs.SizeSuffix
+	MaxSize           fs.SizeSuffix
+	IgnoreCase        bool
 }
 
 // DefaultOpt is the default config for the filter
@@ -113,8 +114,9 @@ type Filter struct {
 	ModTimeTo   time.Time
 	fileRules   rules
 	dirRules    rules
-	files       FilesMap // files if filesFrom
-	dirs        FilesMap // dirs from filesFrom
+	files       FilesMap                 // files if filesFrom
+	dirs        FilesMap                 // dirs from filesFrom
+	manifest    map[string]*ManifestFile // expected files if --files-from-manifest
 }
 
 // NewFilter parses the command line options and creates a Filter
@@ -225,6 +227,17 @@ func NewFilter(opt *Opt) (f *Filter, err error) {
 		}
 	}
 
+	for _, rule := range f.Opt.FilesFromManifest {
+		if !inActive {
+			return nil, fmt.Errorf("The usage of --files-from-manifest overrides all other filters, it should be used alone or with --files-from")
+		}
+		f.initAddFile() // init to show --files-from set even if no files within
+		err := f.addManifest(rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if addImplicitExclude {
 		err = f.Add(false, "/**")
 		if err != nil {
@@ -10,6 +10,15 @@ import (
 	"github.com/pkg/errors"
 )
 
+// GlobToRegexp converts an rsync style glob to a regexp which matches
+// paths as used in the filters.
+//
+// If the glob starts with / it is anchored to the root, otherwise it
+// can match at any directory level.
+func GlobToRegexp(glob string, ignoreCase bool) (*regexp.Regexp, error) {
+	return globToRegexp(glob, ignoreCase)
+}
+
 // globToRegexp converts an rsync style glob to a regexp
 //
 // documented in filtering.md
@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Manifest is the format of the file read by --files-from-manifest
+type Manifest struct {
+	Files []*ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file listed in a manifest with the size and
+// hashes it is expected to have
+type ManifestFile struct {
+	Path   string            `json:"path"`
+	Size   *int64            `json:"size,omitempty"`
+	Hashes map[string]string `json:"hashes,omitempty"` // hash sums by hash name, eg "md5"
+	sums   map[hash.Type]string
+}
+
+// Sums returns the expected hash sums of the file by type
+func (mf *ManifestFile) Sums() map[hash.Type]string {
+	return mf.sums
+}
+
+// parseHashName returns the hash type for name ignoring case and
+// dashes so "md5", "MD5", "sha1" and "SHA-1" are all understood
+func parseHashName(name string) (hash.Type, error) {
+	normalise := func(s string) string {
+		return strings.ToLower(strings.Replace(s, "-", "", -1))
+	}
+	for _, ht := range hash.Supported().Array() {
+		if normalise(ht.String()) == normalise(name) {
+			return ht, nil
+		}
+	}
+	return hash.None, errors.Errorf("unknown hash type %q", name)
+}
+
+// ReadManifest reads a manifest from in
+func ReadManifest(in io.Reader) (*Manifest, error) {
+	var m Manifest
+	err := json.NewDecoder(in).Decode(&m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	for i, mf := range m.Files {
+		if mf == nil || mf.Path == "" {
+			return nil, errors.Errorf("manifest file %d has no path", i+1)
+		}
+		mf.sums = make(map[hash.Type]string, len(mf.Hashes))
+		for name, sum := range mf.Hashes {
+			ht, err := parseHashName(name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "manifest file %q", mf.Path)
+			}
+			mf.sums[ht] = strings.ToLower(sum)
+		}
+	}
+	return &m, nil
+}
+
+// addManifest adds the files in the manifest at path to the files
+// from list
+func (f *Filter) addManifest(path string) (err error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(fd, &err)
+		in = fd
+	}
+	m, err := ReadManifest(in)
+	if err != nil {
+		return errors.Wrapf(err, "--files-from-manifest %q", path)
+	}
+	if f.manifest == nil {
+		f.manifest = make(map[string]*ManifestFile, len(m.Files))
+	}
+	for _, mf := range m.Files {
+		mf.Path = strings.Trim(mf.Path, "/")
+		f.manifest[mf.Path] = mf
+		err = f.AddFile(mf.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HaveManifest returns true if --files-from-manifest has been supplied
+func (f *Filter) HaveManifest() bool {
+	return f.manifest != nil
+}
+
+// Manifest returns the files read by --files-from-manifest by path.
+//
+// It is nil if --files-from-manifest wasn't supplied.
+func (f *Filter) Manifest() map[string]*ManifestFile {
+	return f.manifest
+}
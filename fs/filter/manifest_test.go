@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadManifest(t *testing.T) {
+	m, err := ReadManifest(strings.NewReader(`{"files": [
+		{"path": "a.txt", "size": 3, "hashes": {"MD5": "ABC", "sha1": "def"}},
+		{"path": "dir/b.txt"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, m.Files, 2)
+	assert.Equal(t, int64(3), *m.Files[0].Size)
+	assert.Equal(t, map[hash.Type]string{hash.MD5: "abc", hash.SHA1: "def"}, m.Files[0].Sums())
+	assert.Nil(t, m.Files[1].Size)
+	assert.Len(t, m.Files[1].Sums(), 0)
+
+	_, err = ReadManifest(strings.NewReader(`{"files": [{"path": "a", "hashes": {"potato": "abc"}}]}`))
+	assert.EqualError(t, err, `manifest file "a": unknown hash type "potato"`)
+	_, err = ReadManifest(strings.NewReader(`{"files": [{"size": 1}]}`))
+	assert.EqualError(t, err, `manifest file 1 has no path`)
+	_, err = ReadManifest(strings.NewReader(`[`))
+	assert.Error(t, err)
+}
+
+func TestNewFilterWithFilesFromManifest(t *testing.T) {
+	Opt := DefaultOpt
+	Opt.FilesFromManifest = []string{testFile(t, `{"files": [{"path": "/dir/file1", "size": 1}, {"path": "file2"}]}`)}
+	defer func() {
+		require.NoError(t, os.Remove(Opt.FilesFromManifest[0]))
+	}()
+
+	f, err := NewFilter(&Opt)
+	require.NoError(t, err)
+	assert.True(t, f.HaveFilesFrom())
+	assert.True(t, f.HaveManifest())
+	assert.Equal(t, FilesMap{"dir/file1": {}, "file2": {}}, f.files)
+	assert.Equal(t, FilesMap{"dir": {}}, f.dirs)
+	require.Contains(t, f.Manifest(), "dir/file1")
+	assert.Equal(t, int64(1), *f.Manifest()["dir/file1"].Size)
+
+	Opt.ExcludeRule = []string{"*.jpg"}
+	_, err = NewFilter(&Opt)
+	assert.Error(t, err)
+}
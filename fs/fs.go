@@ -55,8 +55,8 @@ var (
 	ErrorDirExists                   = errors.New("can't copy directory - destination already exists")
 	ErrorCantSetModTime              = errors.New("can't set modified time")
 	ErrorCantSetModTimeWithoutDelete = errors.New("can't set modified time without deleting existing object")
-	ErrorDirNotFound                 = errors.New("directory not found")
-	ErrorObjectNotFound              = errors.New("object not found")
+	ErrorDirNotFound                 = fserrors.NewCodedError("directory not found", fserrors.CodeNotFound)
+	ErrorObjectNotFound              = fserrors.NewCodedError("object not found", fserrors.CodeNotFound)
 	ErrorLevelNotSupported           = errors.New("level value not supported")
 	ErrorListAborted                 = errors.New("list aborted")
 	ErrorListBucketRequired          = errors.New("bucket or container name is needed in remote")
@@ -67,11 +67,12 @@ var (
 	ErrorOverlapping                 = errors.New("can't sync or move files on overlapping remotes")
 	ErrorDirectoryNotEmpty           = errors.New("directory not empty")
 	ErrorImmutableModified           = errors.New("immutable file modified")
-	ErrorPermissionDenied            = errors.New("permission denied")
+	ErrorPermissionDenied            = fserrors.NewCodedError("permission denied", fserrors.CodePermission)
 	ErrorCantShareDirectories        = errors.New("this backend can't share directories with link")
 	ErrorNotImplemented              = errors.New("optional feature not implemented")
 	ErrorCommandNotFound             = errors.New("command not found")
 	ErrorFileNameTooLong             = errors.New("file name too long")
+	ErrorChangesUnavailable          = errors.New("changes since the last change token aren't available")
 )
 
 // RegInfo provides information about a filesystem
@@ -396,6 +397,13 @@ type IDer interface {
 	ID() string
 }
 
+// Inoder is an optional interface for Object
+type Inoder interface {
+	// Inode returns an identifier for the file holding the Object
+	// which is kept when it is renamed, or "" if not known
+	Inode() string
+}
+
 // ObjectUnWrapper is an optional interface for Object
 type ObjectUnWrapper interface {
 	// UnWrap returns the Object that this Object is wrapping or
@@ -416,6 +424,21 @@ type GetTierer interface {
 	GetTier() string
 }
 
+// Metadataer is an optional interface for Object
+type Metadataer interface {
+	// Metadata returns the metadata of the Object, for example
+	// its modification time and user metadata
+	Metadata(ctx context.Context) (Metadata, error)
+}
+
+// SetMetadataer is an optional interface for Object
+type SetMetadataer interface {
+	// SetMetadata sets the metadata of the Object to metadata,
+	// ignoring any keys it doesn't support. Extended attributes
+	// which aren't in metadata are removed.
+	SetMetadata(ctx context.Context, metadata Metadata) error
+}
+
 // FullObjectInfo contains all the read-only optional interfaces
 //
 // Use for checking making wrapping ObjectInfos implement everything
@@ -549,6 +572,15 @@ type Features struct {
 	// If it isn't possible then return fs.ErrorCantMove
 	Move func(ctx context.Context, src Object, remote string) (Object, error)
 
+	// CanServerSideFrom returns true if objects in src, which is a
+	// different remote of the same type as this one, can be copied
+	// and moved to this remote with Copy and Move.
+	//
+	// This is used to negotiate server-side copies between remotes
+	// with different configs, for example between buckets in
+	// different accounts of the same provider.
+	CanServerSideFrom func(ctx context.Context, src Info) bool
+
 	// DirMove moves src, srcRemote to this remote at dstRemote
 	// using server-side move operations.
 	//
@@ -559,11 +591,41 @@ type Features struct {
 	// If destination exists then return fs.ErrorDirExists
 	DirMove func(ctx context.Context, src Fs, srcRemote, dstRemote string) error
 
+	// DirSetModTime sets the modification time of the directory
+	// dir.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirSetModTime func(ctx context.Context, dir string, modTime time.Time) error
+
+	// DirMetadata returns the metadata of the directory dir, for
+	// example its permissions.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirMetadata func(ctx context.Context, dir string) (Metadata, error)
+
+	// DirSetMetadata sets the metadata of the directory dir to
+	// metadata, ignoring any keys it doesn't support.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirSetMetadata func(ctx context.Context, dir string, metadata Metadata) error
+
 	// ChangeNotify calls the passed function with a path
 	// that has had changes. If the implementation
 	// uses polling, it should adhere to the given interval.
 	ChangeNotify func(context.Context, func(string, EntryType), <-chan time.Duration)
 
+	// ChangeToken returns a token for the current state of the
+	// remote which can be passed to Changes later.
+	ChangeToken func(ctx context.Context) (string, error)
+
+	// Changes returns the paths of the objects and directories
+	// which have changed since token was returned and a new token
+	// to use next time.
+	//
+	// If the changes can't be found then it should return
+	// ErrorChangesUnavailable.
+	Changes func(ctx context.Context, token string) (paths []string, newToken string, err error)
+
 	// UnWrap returns the Fs that this Fs is wrapping
 	UnWrap func() Fs
 
@@ -635,6 +697,12 @@ type Features struct {
 	// It truncates any existing object
 	OpenWriterAt func(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
 
+	// UpdateWriterAt opens an existing object with a handle for
+	// random access writes without truncating it
+	//
+	// The object is truncated or extended to size.
+	UpdateWriterAt func(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
+
 	// UserInfo returns info about the connected user
 	UserInfo func(ctx context.Context) (map[string]string, error)
 
@@ -730,12 +798,26 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(Mover); ok {
 		ft.Move = do.Move
 	}
+	if do, ok := f.(ServerSideNegotiator); ok {
+		ft.CanServerSideFrom = do.CanServerSideFrom
+	}
 	if do, ok := f.(DirMover); ok {
 		ft.DirMove = do.DirMove
 	}
+	if do, ok := f.(DirSetModTimer); ok {
+		ft.DirSetModTime = do.DirSetModTime
+	}
+	if do, ok := f.(DirMetadataer); ok {
+		ft.DirMetadata = do.DirMetadata
+		ft.DirSetMetadata = do.DirSetMetadata
+	}
 	if do, ok := f.(ChangeNotifier); ok {
 		ft.ChangeNotify = do.ChangeNotify
 	}
+	if do, ok := f.(ChangeLister); ok {
+		ft.ChangeToken = do.ChangeToken
+		ft.Changes = do.Changes
+	}
 	if do, ok := f.(UnWrapper); ok {
 		ft.UnWrap = do.UnWrap
 	}
@@ -770,6 +852,9 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(OpenWriterAter); ok {
 		ft.OpenWriterAt = do.OpenWriterAt
 	}
+	if do, ok := f.(UpdateWriterAter); ok {
+		ft.UpdateWriterAt = do.UpdateWriterAt
+	}
 	if do, ok := f.(UserInfoer); ok {
 		ft.UserInfo = do.UserInfo
 	}
@@ -816,12 +901,26 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.Move == nil {
 		ft.Move = nil
 	}
+	if mask.CanServerSideFrom == nil {
+		ft.CanServerSideFrom = nil
+	}
 	if mask.DirMove == nil {
 		ft.DirMove = nil
 	}
+	if mask.DirSetModTime == nil {
+		ft.DirSetModTime = nil
+	}
+	if mask.DirMetadata == nil || mask.DirSetMetadata == nil {
+		ft.DirMetadata = nil
+		ft.DirSetMetadata = nil
+	}
 	if mask.ChangeNotify == nil {
 		ft.ChangeNotify = nil
 	}
+	if mask.ChangeToken == nil || mask.Changes == nil {
+		ft.ChangeToken = nil
+		ft.Changes = nil
+	}
 	// if mask.UnWrap == nil {
 	// 	ft.UnWrap = nil
 	// }
@@ -855,6 +954,9 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.OpenWriterAt == nil {
 		ft.OpenWriterAt = nil
 	}
+	if mask.UpdateWriterAt == nil {
+		ft.UpdateWriterAt = nil
+	}
 	if mask.UserInfo == nil {
 		ft.UserInfo = nil
 	}
@@ -931,6 +1033,14 @@ type Mover interface {
 	Move(ctx context.Context, src Object, remote string) (Object, error)
 }
 
+// ServerSideNegotiator is an optional interface for Fs
+type ServerSideNegotiator interface {
+	// CanServerSideFrom returns true if objects in src, which is a
+	// different remote of the same type as this one, can be copied
+	// and moved to this remote with Copy and Move.
+	CanServerSideFrom(ctx context.Context, src Info) bool
+}
+
 // DirMover is an optional interface for Fs
 type DirMover interface {
 	// DirMove moves src, srcRemote to this remote at dstRemote
@@ -944,6 +1054,30 @@ type DirMover interface {
 	DirMove(ctx context.Context, src Fs, srcRemote, dstRemote string) error
 }
 
+// DirSetModTimer is an optional interface for Fs
+type DirSetModTimer interface {
+	// DirSetModTime sets the modification time of the directory
+	// dir.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirSetModTime(ctx context.Context, dir string, modTime time.Time) error
+}
+
+// DirMetadataer is an optional interface for Fs
+type DirMetadataer interface {
+	// DirMetadata returns the metadata of the directory dir, for
+	// example its permissions.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirMetadata(ctx context.Context, dir string) (Metadata, error)
+
+	// DirSetMetadata sets the metadata of the directory dir to
+	// metadata, ignoring any keys it doesn't support.
+	//
+	// If the directory doesn't exist then return fs.ErrorDirNotFound
+	DirSetMetadata(ctx context.Context, dir string, metadata Metadata) error
+}
+
 // ChangeNotifier is an optional interface for Fs
 type ChangeNotifier interface {
 	// ChangeNotify calls the passed function with a path
@@ -958,6 +1092,25 @@ type ChangeNotifier interface {
 	ChangeNotify(context.Context, func(string, EntryType), <-chan time.Duration)
 }
 
+// ChangeLister is an optional interface for Fs
+type ChangeLister interface {
+	// ChangeToken returns a token for the current state of the
+	// remote which can be passed to Changes later.
+	ChangeToken(ctx context.Context) (string, error)
+
+	// Changes returns the paths of the objects and directories
+	// which have changed since token was returned and a new token
+	// to use next time.
+	//
+	// The paths are relative to the root of the Fs and changes
+	// outside the root are ignored.  A path may be returned for an
+	// object or directory which has been deleted.
+	//
+	// If the changes can't be found, for example because the token
+	// has expired, then it should return ErrorChangesUnavailable.
+	Changes(ctx context.Context, token string) (paths []string, newToken string, err error)
+}
+
 // UnWrapper is an optional interfaces for Fs
 type UnWrapper interface {
 	// UnWrap returns the Fs that this Fs is wrapping
@@ -1074,6 +1227,15 @@ type OpenWriterAter interface {
 	OpenWriterAt(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
 }
 
+// UpdateWriterAter is an optional interface for Fs
+type UpdateWriterAter interface {
+	// UpdateWriterAt opens an existing object with a handle for
+	// random access writes without truncating it
+	//
+	// The object is truncated or extended to size.
+	UpdateWriterAt(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
+}
+
 // UserInfoer is an optional interface for Fs
 type UserInfoer interface {
 	// UserInfo returns info about the connected user
@@ -1363,12 +1525,28 @@ func NewFs(ctx context.Context, path string) (Fs, error) {
 	if err != nil {
 		return nil, err
 	}
+	ctx = context.WithValue(ctx, remoteNameKey{}, configName)
 	return fsInfo.NewFs(ctx, configName, fsPath, config)
 }
 
+// remoteNameKey is the context key for the name of the remote whose
+// backend NewFs is making
+type remoteNameKey struct{}
+
+// RemoteName returns the name of the remote whose backend is being
+// made by NewFs from the ctx passed to the backend, or "" if there
+// isn't one.
+//
+// This is used to count the calls made by the clients the backend
+// makes against the remote.
+func RemoteName(ctx context.Context) string {
+	name, _ := ctx.Value(remoteNameKey{}).(string)
+	return name
+}
+
 // ConfigString returns a canonical version of the config string used
 // to configure the Fs as passed to fs.NewFs
-func ConfigString(f Fs) string {
+func ConfigString(f Info) string {
 	name := f.Name()
 	root := f.Root()
 	if name == "local" && f.Features().IsLocal {
@@ -0,0 +1,177 @@
+// Stable codes for the classes of errors
+
+package fserrors
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/rclone/rclone/lib/errors"
+)
+
+// Code is a stable code for the class of an error so that automation
+// can act on the class of failure without matching error messages
+// from the backends.
+type Code string
+
+// Error codes
+//
+// These are part of the API of rclone so must not be changed.
+const (
+	CodeAuth        Code = "ERR_AUTH"         // authentication failed or credentials expired
+	CodePermission  Code = "ERR_PERMISSION"   // not allowed to do the operation
+	CodeNotFound    Code = "ERR_NOT_FOUND"    // file or directory not found
+	CodeQuota       Code = "ERR_QUOTA"        // out of storage space or over quota
+	CodeRateLimit   Code = "ERR_RATE_LIMIT"   // too many requests
+	CodeChecksum    Code = "ERR_CHECKSUM"     // data corrupted in transfer
+	CodeNetwork     Code = "ERR_NETWORK"      // network error
+	CodeTimeout     Code = "ERR_TIMEOUT"      // operation timed out
+	CodeCancelled   Code = "ERR_CANCELLED"    // operation cancelled
+	CodeMaxTransfer Code = "ERR_MAX_TRANSFER" // --max-transfer reached
+	CodeUnknown     Code = "ERR_UNKNOWN"      // any other error
+)
+
+// Coder is an optional interface for error to give the class of the
+// error.
+type Coder interface {
+	error
+	Code() Code
+}
+
+// codedError is an error with a code
+type codedError struct {
+	msg  string
+	code Code
+}
+
+// Error interface
+func (err *codedError) Error() string {
+	return err.msg
+}
+
+// Code interface
+func (err *codedError) Code() Code {
+	return err.code
+}
+
+// Check interface
+var _ Coder = (*codedError)(nil)
+
+// NewCodedError makes a new error with text and code, for use in
+// sentinel errors such as fs.ErrorObjectNotFound.
+func NewCodedError(text string, code Code) error {
+	return &codedError{msg: text, code: code}
+}
+
+// wrappedCodedError is an error wrapped so it will satisfy the Coder
+// interface
+type wrappedCodedError struct {
+	error
+	code Code
+}
+
+// Code interface
+func (err wrappedCodedError) Code() Code {
+	return err.code
+}
+
+func (err wrappedCodedError) Cause() error {
+	return err.error
+}
+
+// Check interface
+var _ Coder = wrappedCodedError{error(nil), CodeUnknown}
+
+// WithCode wraps err so it has code.  It returns nil if err is nil.
+func WithCode(err error, code Code) error {
+	if err == nil {
+		return nil
+	}
+	return wrappedCodedError{error: err, code: code}
+}
+
+// errorCodeStrings are phrases in error messages from the backends
+// which show the class of the error, checked in order, for errors
+// which haven't been given a code.
+var errorCodeStrings = []struct {
+	code    Code
+	phrases []string
+}{
+	{CodeRateLimit, []string{"too many requests", "rate limit", "ratelimit", "rate_limit", "throttl", "slow down", "slowdown"}},
+	{CodeAuth, []string{"unauthorized", "unauthenticated", "invalid_grant", "invalid_client", "invalid credentials", "authentication failed", "token expired", "expired token"}},
+	{CodeQuota, []string{"quota", "insufficient storage", "insufficient space", "storage limit", "no space left", "not enough space"}},
+	{CodePermission, []string{"forbidden", "permission denied", "access denied", "accessdenied"}},
+}
+
+// ErrorCode returns the code for the class of err or "" if err is
+// nil.
+//
+// Errors with a code from WithCode or NewCodedError have that code,
+// otherwise the code is worked out from the type of err and as a last
+// resort from the error message.
+func ErrorCode(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var code, netCode Code
+	errors.Walk(err, func(err error) bool {
+		if c, ok := err.(Coder); ok {
+			code = c.Code()
+			return true
+		}
+		switch {
+		case err == context.Canceled:
+			code = CodeCancelled
+		case err == context.DeadlineExceeded:
+			code = CodeTimeout
+		case os.IsPermission(err):
+			code = CodePermission
+		case os.IsNotExist(err):
+			code = CodeNotFound
+		case IsErrNoSpace(err):
+			code = CodeQuota
+		}
+		if code != "" {
+			return true
+		}
+		// Carry on looking as net errors such as *url.Error can
+		// wrap errors with a more specific code
+		if netErr, ok := err.(net.Error); ok && netCode == "" {
+			if netErr.Timeout() {
+				netCode = CodeTimeout
+			} else {
+				netCode = CodeNetwork
+			}
+		}
+		return false
+	})
+	if code != "" {
+		return code
+	}
+	if netCode != "" {
+		return netCode
+	}
+	errString := strings.ToLower(err.Error())
+	for _, item := range errorCodeStrings {
+		for _, phrase := range item.phrases {
+			if strings.Contains(errString, phrase) {
+				return item.code
+			}
+		}
+	}
+	// Check for the networking errors which are retried
+	_, cause := Cause(err)
+	for _, retriableErr := range retriableErrors {
+		if cause == retriableErr {
+			return CodeNetwork
+		}
+	}
+	for _, phrase := range retriableErrorStrings {
+		if strings.Contains(err.Error(), phrase) {
+			return CodeNetwork
+		}
+	}
+	return CodeUnknown
+}
@@ -0,0 +1,61 @@
+package fserrors
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	sentinel := NewCodedError("object not found", CodeNotFound)
+	for _, test := range []struct {
+		err  error
+		want Code
+	}{
+		{nil, ""},
+		{errors.New("potato"), CodeUnknown},
+		{sentinel, CodeNotFound},
+		{errors.Wrap(sentinel, "failed"), CodeNotFound},
+		{WithCode(errors.New("corrupted"), CodeChecksum), CodeChecksum},
+		{RetryError(WithCode(errors.New("corrupted"), CodeChecksum)), CodeChecksum},
+		{context.Canceled, CodeCancelled},
+		{errors.Wrap(context.DeadlineExceeded, "reading"), CodeTimeout},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: context.Canceled}, CodeCancelled},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, CodeNetwork},
+		{&os.PathError{Op: "open", Path: "/potato", Err: syscall.ENOENT}, CodeNotFound},
+		{&os.PathError{Op: "open", Path: "/potato", Err: syscall.EACCES}, CodePermission},
+		{&os.PathError{Op: "write", Path: "/potato", Err: syscall.ENOSPC}, CodeQuota},
+		{io.ErrUnexpectedEOF, CodeNetwork},
+		{errors.New("write: use of closed network connection"), CodeNetwork},
+		{errors.New("HTTP error 429 (429 Too Many Requests)"), CodeRateLimit},
+		{errors.New("userRateLimitExceeded: Rate of requests for user exceed configured project quota"), CodeRateLimit},
+		{errors.New("HTTP error 401 (401 Unauthorized)"), CodeAuth},
+		{errors.New("The user's Drive storage quota has been exceeded"), CodeQuota},
+		{errors.New("HTTP error 403 (403 Forbidden)"), CodePermission},
+	} {
+		got := ErrorCode(test.err)
+		assert.Equal(t, test.want, got, "%v", test.err)
+	}
+}
+
+func TestWithCode(t *testing.T) {
+	assert.Nil(t, WithCode(nil, CodeAuth))
+	inner := errors.New("potato")
+	err := WithCode(inner, CodeAuth)
+	assert.Equal(t, "potato", err.Error())
+	assert.Equal(t, inner, errors.Cause(err))
+}
+
+func TestNewCodedError(t *testing.T) {
+	err := NewCodedError("potato", CodeNotFound)
+	assert.Equal(t, "potato", err.Error())
+	// The sentinel must be its own cause so it can be compared
+	assert.Equal(t, err, errors.Cause(errors.Wrap(err, "wrapped")))
+}
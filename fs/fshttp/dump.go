@@ -0,0 +1,177 @@
+// Dump the HTTP bodies to files in a directory
+
+package fshttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dumpIndexName is the name of the index of the dumped bodies
+const dumpIndexName = "index.json"
+
+// dumpDir writes the bodies of the HTTP requests and responses to
+// individual files in a directory as set by --dump-dir along with an
+// index of them.
+type dumpDir struct {
+	dir   string
+	mu    sync.Mutex
+	id    int      // ID of the last round trip
+	index *os.File // index.json with a line of JSON per round trip
+}
+
+// The dumpDirs in use indexed by directory so that transports using
+// the same directory share the IDs and the index.
+var (
+	dumpDirsMu sync.Mutex
+	dumpDirs   = map[string]*dumpDir{}
+)
+
+// getDumpDir returns the dumpDir for dir making it if necessary
+func getDumpDir(dir string) (*dumpDir, error) {
+	dumpDirsMu.Lock()
+	defer dumpDirsMu.Unlock()
+	if d := dumpDirs[dir]; d != nil {
+		return d, nil
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make --dump-dir")
+	}
+	index, err := os.OpenFile(filepath.Join(dir, dumpIndexName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open --dump-dir index")
+	}
+	d := &dumpDir{
+		dir:   dir,
+		index: index,
+	}
+	dumpDirs[dir] = d
+	return d, nil
+}
+
+// dumpEntry describes one round trip in the index
+type dumpEntry struct {
+	ID           int       `json:"id"`
+	Req          string    `json:"req"` // as in the "(req %p)" of the log
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	Status       int       `json:"status,omitempty"`
+	Duration     float64   `json:"duration"`
+	RequestFile  string    `json:"requestFile,omitempty"`
+	RequestSize  int64     `json:"requestSize"`
+	RequestType  string    `json:"requestType,omitempty"`
+	ResponseFile string    `json:"responseFile,omitempty"`
+	ResponseSize int64     `json:"responseSize"`
+	ResponseType string    `json:"responseType,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// drainBody reads all of b returning its contents and a ReadCloser
+// which reads the same contents to replace it with.
+func drainBody(b io.ReadCloser) (body []byte, r io.ReadCloser, err error) {
+	if b == nil || b == http.NoBody {
+		return nil, b, nil
+	}
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(b); err != nil {
+		return nil, b, err
+	}
+	if err = b.Close(); err != nil {
+		return nil, b, err
+	}
+	return buf.Bytes(), ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+}
+
+// writeBody writes body to the file for id and kind returning its
+// name or "" if body is empty.
+func (d *dumpDir) writeBody(id int, kind string, body []byte) (name string, err error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	name = fmt.Sprintf("%06d-%s.body", id, kind)
+	err = ioutil.WriteFile(filepath.Join(d.dir, name), body, 0600)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write %s body", kind)
+	}
+	return name, nil
+}
+
+// dumpRequest starts the index entry for req writing its body to a
+// file if dumpBody is set.
+//
+// The body of req is replaced so it can still be sent.
+func (d *dumpDir) dumpRequest(req *http.Request, dumpBody bool) (e *dumpEntry, err error) {
+	d.mu.Lock()
+	d.id++
+	id := d.id
+	d.mu.Unlock()
+	e = &dumpEntry{
+		ID:          id,
+		Req:         fmt.Sprintf("%p", req),
+		Time:        time.Now(),
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestSize: req.ContentLength,
+		RequestType: req.Header.Get("Content-Type"),
+	}
+	if dumpBody {
+		var body []byte
+		body, req.Body, err = drainBody(req.Body)
+		if err != nil {
+			return e, errors.Wrap(err, "failed to read request body")
+		}
+		e.RequestSize = int64(len(body))
+		e.RequestFile, err = d.writeBody(id, "request", body)
+	}
+	return e, err
+}
+
+// dumpResponse finishes the index entry e with resp or err writing
+// the body of resp to a file if dumpBody is set and adding e to the
+// index.
+//
+// The body of resp is replaced so it can still be read.
+func (d *dumpDir) dumpResponse(e *dumpEntry, resp *http.Response, respErr error, dumpBody bool) (err error) {
+	e.Duration = time.Since(e.Time).Seconds()
+	if respErr != nil {
+		e.Error = respErr.Error()
+	} else {
+		e.Status = resp.StatusCode
+		e.ResponseSize = resp.ContentLength
+		e.ResponseType = resp.Header.Get("Content-Type")
+		if dumpBody {
+			var body []byte
+			body, resp.Body, err = drainBody(resp.Body)
+			if err != nil {
+				e.Error = err.Error()
+				err = errors.Wrap(err, "failed to read response body")
+			} else {
+				e.ResponseSize = int64(len(body))
+				e.ResponseFile, err = d.writeBody(e.ID, "response", body)
+			}
+		}
+	}
+	line, jsonErr := json.Marshal(e)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	d.mu.Lock()
+	_, writeErr := d.index.Write(append(line, '\n'))
+	d.mu.Unlock()
+	if writeErr != nil && err == nil {
+		err = errors.Wrap(writeErr, "failed to write --dump-dir index")
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package fshttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(append([]byte("echo: "), body...))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "rclone-dump-dir")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.Dump = fs.DumpBodies
+	ci.DumpDir = dir
+	tr := newTransport(ci, http.DefaultTransport.(*http.Transport).Clone())
+	require.NotNil(t, tr.dumpDir)
+	defer func() {
+		require.NoError(t, tr.dumpDir.index.Close())
+		dumpDirsMu.Lock()
+		delete(dumpDirs, dir)
+		dumpDirsMu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", server.URL+"/potato", bytes.NewBufferString("hello"))
+	require.NoError(t, err)
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The bodies still go to the server and the caller
+	assert.Equal(t, "echo: hello", string(body))
+
+	// The bodies are in the files
+	reqBody, err := ioutil.ReadFile(filepath.Join(dir, "000001-request.body"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(reqBody))
+	respBody, err := ioutil.ReadFile(filepath.Join(dir, "000001-response.body"))
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello", string(respBody))
+
+	// The round trip is in the index
+	index, err := ioutil.ReadFile(filepath.Join(dir, dumpIndexName))
+	require.NoError(t, err)
+	var entry dumpEntry
+	require.NoError(t, json.Unmarshal(index, &entry))
+	assert.Equal(t, 1, entry.ID)
+	assert.Equal(t, "PUT", entry.Method)
+	assert.Equal(t, server.URL+"/potato", entry.URL)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, "000001-request.body", entry.RequestFile)
+	assert.Equal(t, int64(5), entry.RequestSize)
+	assert.Equal(t, "000001-response.body", entry.ResponseFile)
+	assert.Equal(t, int64(11), entry.ResponseSize)
+	assert.Equal(t, "text/plain", entry.ResponseType)
+}
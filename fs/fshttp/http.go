@@ -12,10 +12,13 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httputil"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/adaptive"
+	"github.com/rclone/rclone/fs/tracing"
 	"github.com/rclone/rclone/lib/structs"
 	"golang.org/x/net/publicsuffix"
 	"golang.org/x/time/rate"
@@ -27,7 +30,7 @@ const (
 )
 
 var (
-	transport    http.RoundTripper
+	transport    *Transport
 	noTransport  = new(sync.Once)
 	tpsBucket    *rate.Limiter // for limiting number of http transactions per second
 	cookieJar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
@@ -113,6 +116,12 @@ func ResetTransport() {
 // The customize function is called if set to give the caller an opportunity to
 // customize any defaults in the Transport.
 func NewTransportCustom(ctx context.Context, customize func(*http.Transport)) http.RoundTripper {
+	return newTransportCustom(ctx, customize).forRemote(fs.RemoteName(ctx))
+}
+
+// newTransportCustom returns a Transport as NewTransportCustom which
+// doesn't count the requests it makes
+func newTransportCustom(ctx context.Context, customize func(*http.Transport)) *Transport {
 	ci := fs.GetConfig(ctx)
 	// Start with a sensible set of defaults then override.
 	// This also means we get new stuff when it gets added to go
@@ -182,9 +191,9 @@ func NewTransportCustom(ctx context.Context, customize func(*http.Transport)) ht
 // NewTransport returns an http.RoundTripper with the correct timeouts
 func NewTransport(ctx context.Context) http.RoundTripper {
 	(*noTransport).Do(func() {
-		transport = NewTransportCustom(ctx, nil)
+		transport = newTransportCustom(ctx, nil)
 	})
-	return transport
+	return transport.forRemote(fs.RemoteName(ctx))
 }
 
 // NewClient returns an http.Client with the correct timeouts
@@ -202,9 +211,12 @@ func NewClient(ctx context.Context) *http.Client {
 // Transport is our http Transport which wraps an http.Transport
 // * Sets the User Agent
 // * Does logging
+// * Counts the requests made for each remote
 type Transport struct {
 	*http.Transport
+	remote        string // name of the remote the requests are counted against
 	dump          fs.DumpFlags
+	dumpDir       *dumpDir // set if dumping the bodies to files
 	filterRequest func(req *http.Request)
 	userAgent     string
 	headers       []*fs.HTTPOption
@@ -213,12 +225,43 @@ type Transport struct {
 // newTransport wraps the http.Transport passed in and logs all
 // roundtrips including the body if logBody is set.
 func newTransport(ci *fs.ConfigInfo, transport *http.Transport) *Transport {
-	return &Transport{
+	t := &Transport{
 		Transport: transport,
 		dump:      ci.Dump,
 		userAgent: ci.UserAgent,
 		headers:   ci.Headers,
 	}
+	if ci.DumpDir != "" && ci.Dump&(fs.DumpBodies|fs.DumpRequests|fs.DumpResponses) != 0 {
+		d, err := getDumpDir(ci.DumpDir)
+		if err != nil {
+			log.Fatalf("Failed to start --dump-dir: %v", err)
+		}
+		t.dumpDir = d
+	}
+	return t
+}
+
+// forRemote returns a copy of t which counts the requests it makes
+// against remote, sharing the connections of t. It returns t if remote
+// is "".
+func (t *Transport) forRemote(remote string) *Transport {
+	if remote == "" || remote == t.remote {
+		return t
+	}
+	newT := *t
+	newT.remote = remote
+	return &newT
+}
+
+// countCall counts the request made for the remote of t. Requests
+// which failed to get a response or got a server error or were
+// throttled count as failed.
+func (t *Transport) countCall(req *http.Request, resp *http.Response, err error) {
+	if t.remote == "" {
+		return
+	}
+	failed := err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	fs.CountCall(req.Context(), t.remote, req.Method, failed)
 }
 
 // SetRequestFilter sets a filter to be used on each request
@@ -326,27 +369,73 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		t.filterRequest(req)
 	}
 	// Logf request
+	dumpRequestBody := t.dump&(fs.DumpBodies|fs.DumpRequests) != 0
+	dumpResponseBody := t.dump&(fs.DumpBodies|fs.DumpResponses) != 0
+	var entry *dumpEntry
 	if t.dump&(fs.DumpHeaders|fs.DumpBodies|fs.DumpAuth|fs.DumpRequests|fs.DumpResponses) != 0 {
-		buf, _ := httputil.DumpRequestOut(req, t.dump&(fs.DumpBodies|fs.DumpRequests) != 0)
+		// Write the bodies to --dump-dir instead of the log
+		if t.dumpDir != nil {
+			var dumpErr error
+			entry, dumpErr = t.dumpDir.dumpRequest(req, dumpRequestBody)
+			if dumpErr != nil {
+				fs.Errorf(nil, "Failed to dump request to --dump-dir: %v", dumpErr)
+			}
+		}
+		buf, _ := httputil.DumpRequestOut(req, dumpRequestBody && entry == nil)
 		if t.dump&fs.DumpAuth == 0 {
 			buf = cleanAuths(buf)
 		}
 		fs.Debugf(nil, "%s", separatorReq)
 		fs.Debugf(nil, "%s (req %p)", "HTTP REQUEST", req)
 		fs.Debugf(nil, "%s", string(buf))
+		if entry != nil && entry.RequestFile != "" {
+			fs.Debugf(nil, "Request body dumped to %q", filepath.Join(t.dumpDir.dir, entry.RequestFile))
+		}
 		fs.Debugf(nil, "%s", separatorReq)
 	}
+	// Trace the request if tracing is enabled
+	_, span := tracing.Start(req.Context(), "HTTP "+req.Method, tracing.KindClient)
+	if span != nil {
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.host", req.URL.Host)
+		span.SetAttribute("http.path", req.URL.Path)
+		defer func() {
+			if resp != nil {
+				span.SetAttribute("http.status_code", resp.StatusCode)
+				if resp.StatusCode >= 400 {
+					span.SetError(resp.Status)
+				}
+			}
+			span.End(err)
+		}()
+	}
 	// Do round trip
+	start := time.Now()
 	resp, err = t.Transport.RoundTrip(req)
+	t.countCall(req, resp, err)
+	// Report the response for --adaptive-concurrency
+	if controller := adaptive.FromContext(req.Context()); controller != nil && err == nil {
+		throttled := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		controller.Observe(throttled, time.Since(start))
+	}
 	// Logf response
 	if t.dump&(fs.DumpHeaders|fs.DumpBodies|fs.DumpAuth|fs.DumpRequests|fs.DumpResponses) != 0 {
 		fs.Debugf(nil, "%s", separatorResp)
 		fs.Debugf(nil, "%s (req %p)", "HTTP RESPONSE", req)
+		if entry != nil {
+			dumpErr := t.dumpDir.dumpResponse(entry, resp, err, dumpResponseBody)
+			if dumpErr != nil {
+				fs.Errorf(nil, "Failed to dump response to --dump-dir: %v", dumpErr)
+			}
+		}
 		if err != nil {
 			fs.Debugf(nil, "Error: %v", err)
 		} else {
-			buf, _ := httputil.DumpResponse(resp, t.dump&(fs.DumpBodies|fs.DumpResponses) != 0)
+			buf, _ := httputil.DumpResponse(resp, dumpResponseBody && entry == nil)
 			fs.Debugf(nil, "%s", string(buf))
+			if entry != nil && entry.ResponseFile != "" {
+				fs.Debugf(nil, "Response body dumped to %q", filepath.Join(t.dumpDir.dir, entry.ResponseFile))
+			}
 		}
 		fs.Debugf(nil, "%s", separatorResp)
 	}
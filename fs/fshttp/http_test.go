@@ -1,9 +1,14 @@
 package fshttp
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/rclone/rclone/fs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCleanAuth(t *testing.T) {
@@ -45,3 +50,46 @@ func TestCleanAuths(t *testing.T) {
 		assert.Equal(t, test.want, got, test.in)
 	}
 }
+
+func TestTransportCountCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	type call struct {
+		remote    string
+		operation string
+		failed    bool
+	}
+	var calls []call
+	oldCountCall := fs.CountCall
+	defer func() {
+		fs.CountCall = oldCountCall
+	}()
+	fs.CountCall = func(ctx context.Context, remote, operation string, failed bool) {
+		calls = append(calls, call{remote, operation, failed})
+	}
+
+	ctx := context.Background()
+	shared := newTransportCustom(ctx, nil)
+	tr := shared.forRemote("potato")
+	assert.Equal(t, shared, shared.forRemote(""))
+	assert.Equal(t, shared.Transport, tr.Transport)
+	client := &http.Client{Transport: tr}
+	for _, path := range []string{"/", "/fail"} {
+		resp, err := client.Head(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	resp, err := (&http.Client{Transport: shared}).Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, []call{
+		{"potato", "HEAD", false},
+		{"potato", "HEAD", true},
+	}, calls)
+}
@@ -0,0 +1,188 @@
+// Package hashdb implements a persistent local database of the hashes
+// of objects on remotes which can't supply them.
+//
+// Hashes are stored by remote name and path along with the size and
+// modification time of the object they were calculated for, so a
+// hash is only returned while the object is unchanged.
+package hashdb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/atexit"
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTimeout is how long to wait for another rclone to release the
+// database
+const openTimeout = time.Second
+
+// DB is a database of hashes
+type DB struct {
+	db *bolt.DB
+}
+
+// record is the value stored for each object
+type record struct {
+	Size    int64             `json:"size"`
+	ModTime int64             `json:"modtime"` // in ns since the epoch
+	Hashes  map[string]string `json:"hashes"`
+}
+
+// Open opens the database at dbPath, creating it if necessary
+func Open(dbPath string) (*DB, error) {
+	err := os.MkdirAll(filepath.Dir(dbPath), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make checksum database directory")
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open checksum database %q", dbPath)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// keys returns the bucket and key o is stored under
+func keys(o fs.ObjectInfo) (bucket, key []byte) {
+	f := o.Fs()
+	return []byte(f.Name()), []byte(path.Join(f.Root(), o.Remote()))
+}
+
+// matches returns true if rec was made for o as it is now
+func (rec *record) matches(ctx context.Context, o fs.ObjectInfo) bool {
+	return rec.Size == o.Size() && rec.ModTime == o.ModTime(ctx).UnixNano()
+}
+
+// get reads the record for o returning nil if not found
+func get(tx *bolt.Tx, o fs.ObjectInfo) (*record, error) {
+	bucket, key := keys(o)
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	var rec record
+	err := json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "corrupted checksum database entry for %q", o.Remote())
+	}
+	return &rec, nil
+}
+
+// Get returns the hash of type ht stored for o.  It returns false if
+// there isn't one or o has changed since it was stored.
+func (d *DB) Get(ctx context.Context, o fs.ObjectInfo, ht hash.Type) (sum string, found bool) {
+	if o.Size() < 0 {
+		return "", false
+	}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		rec, err := get(tx, o)
+		if err != nil || rec == nil || !rec.matches(ctx, o) {
+			return err
+		}
+		sum, found = rec.Hashes[ht.String()]
+		return nil
+	})
+	if err != nil {
+		fs.Debugf(o, "Ignoring checksum database: %v", err)
+		return "", false
+	}
+	return sum, found
+}
+
+// Put stores sum as the hash of type ht for o as it is now.  Any
+// other hashes stored for o are kept if o hasn't changed.
+func (d *DB) Put(ctx context.Context, o fs.ObjectInfo, ht hash.Type, sum string) error {
+	if o.Size() < 0 || sum == "" {
+		return nil
+	}
+	return d.db.Batch(func(tx *bolt.Tx) error {
+		rec, err := get(tx, o)
+		if err != nil || rec == nil || !rec.matches(ctx, o) {
+			rec = &record{
+				Size:    o.Size(),
+				ModTime: o.ModTime(ctx).UnixNano(),
+				Hashes:  make(map[string]string, 1),
+			}
+		}
+		rec.Hashes[ht.String()] = sum
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		bucket, key := keys(o)
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+// Delete removes the hashes stored for o
+func (d *DB) Delete(o fs.ObjectInfo) error {
+	return d.db.Batch(func(tx *bolt.Tx) error {
+		bucket, key := keys(o)
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultOpened bool
+	defaultDB     *DB
+)
+
+// Path returns the path of the database shared by everything in
+// rclone which keeps hashes
+func Path() string {
+	return filepath.Join(config.CacheDir, "hashdb", "hashes.db")
+}
+
+// Default returns the shared database if --checksum-db is in use,
+// opening it the first time it is called.
+//
+// It returns nil if --checksum-db isn't set or the database couldn't
+// be opened, in which case the error is logged once.
+func Default(ctx context.Context) *DB {
+	if !fs.GetConfig(ctx).ChecksumDB {
+		return nil
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if !defaultOpened {
+		defaultOpened = true
+		db, err := Open(Path())
+		if err != nil {
+			fs.Errorf(nil, "Not using --checksum-db: %v", err)
+			return nil
+		}
+		defaultDB = db
+		atexit.Register(func() {
+			_ = db.Close()
+		})
+	}
+	return defaultDB
+}
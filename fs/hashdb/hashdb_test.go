@@ -0,0 +1,83 @@
+package hashdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rclone-hashdb-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dbPath := filepath.Join(dir, "sub", "hashes.db")
+
+	db, err := Open(dbPath)
+	require.NoError(t, err)
+
+	when := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	f1 := mockfs.NewFs(ctx, "remote1", "root")
+	f2 := mockfs.NewFs(ctx, "remote2", "root")
+	o := object.NewStaticObjectInfo("dir/file.txt", when, 100, true, nil, f1)
+
+	_, found := db.Get(ctx, o, hash.MD5)
+	assert.False(t, found)
+
+	require.NoError(t, db.Put(ctx, o, hash.MD5, "md5sum"))
+	require.NoError(t, db.Put(ctx, o, hash.SHA1, "sha1sum"))
+	sum, found := db.Get(ctx, o, hash.MD5)
+	assert.True(t, found)
+	assert.Equal(t, "md5sum", sum)
+	sum, found = db.Get(ctx, o, hash.SHA1)
+	assert.True(t, found)
+	assert.Equal(t, "sha1sum", sum)
+
+	// Same path on another remote or seen from another root
+	_, found = db.Get(ctx, object.NewStaticObjectInfo("dir/file.txt", when, 100, true, nil, f2), hash.MD5)
+	assert.False(t, found)
+	sum, found = db.Get(ctx, object.NewStaticObjectInfo("root/dir/file.txt", when, 100, true, nil, mockfs.NewFs(ctx, "remote1", "")), hash.MD5)
+	assert.True(t, found)
+	assert.Equal(t, "md5sum", sum)
+
+	// Changed objects don't match
+	_, found = db.Get(ctx, object.NewStaticObjectInfo("dir/file.txt", when, 101, true, nil, f1), hash.MD5)
+	assert.False(t, found)
+	changed := object.NewStaticObjectInfo("dir/file.txt", when.Add(time.Second), 100, true, nil, f1)
+	_, found = db.Get(ctx, changed, hash.MD5)
+	assert.False(t, found)
+
+	// Storing for the changed object replaces the old hashes
+	require.NoError(t, db.Put(ctx, changed, hash.MD5, "newsum"))
+	_, found = db.Get(ctx, changed, hash.SHA1)
+	assert.False(t, found)
+	sum, found = db.Get(ctx, changed, hash.MD5)
+	assert.True(t, found)
+	assert.Equal(t, "newsum", sum)
+
+	// Persists when reopened
+	require.NoError(t, db.Close())
+	db, err = Open(dbPath)
+	require.NoError(t, err)
+	sum, found = db.Get(ctx, changed, hash.MD5)
+	assert.True(t, found)
+	assert.Equal(t, "newsum", sum)
+
+	require.NoError(t, db.Delete(changed))
+	_, found = db.Get(ctx, changed, hash.MD5)
+	assert.False(t, found)
+	require.NoError(t, db.Delete(object.NewStaticObjectInfo("potato", when, 1, true, nil, f2)))
+
+	require.NoError(t, db.Close())
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/filter"
 )
 
@@ -21,7 +22,9 @@ import (
 // Files will be returned in sorted order
 func DirSorted(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entries fs.DirEntries, err error) {
 	// Get unfiltered entries from the fs
+	done := accounting.TimeCall(ctx, f, accounting.CallList)
 	entries, err = f.List(ctx, dir)
+	done()
 	if err != nil {
 		return nil, err
 	}
@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -72,7 +73,7 @@ func (l *LogLevel) Type() string {
 // LogPrint sends the text to the logger of level
 var LogPrint = func(level LogLevel, text string) {
 	text = fmt.Sprintf("%-6s: %s", level, text)
-	_ = log.Output(4, text)
+	_ = log.Output(6, text)
 }
 
 // LogValueItem describes keyed item for a JSON log entry
@@ -96,23 +97,78 @@ func (j LogValueItem) String() string {
 	return ""
 }
 
+// LogOutput, if set, is called by LogPrintf with the formatted log
+// message and its structured fields instead of writing to the
+// default text or JSON log.
+var LogOutput func(level LogLevel, o interface{}, text string, fields logrus.Fields)
+
+// logFields returns the structured fields for the JSON log from the
+// object and any LogValueItem arguments.
+func logFields(o interface{}, args []interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+	if o != nil {
+		fields = logrus.Fields{
+			"object":     fmt.Sprintf("%+v", o),
+			"objectType": fmt.Sprintf("%T", o),
+		}
+	}
+	ci := GetConfig(context.TODO())
+	var errorCode fserrors.Code
+	for _, arg := range args {
+		switch x := arg.(type) {
+		case LogValueItem:
+			if value, ok := x.value.(string); ok {
+				x.value = redactLog(ci, value)
+			}
+			fields[x.key] = x.value
+		case error:
+			if errorCode == "" {
+				errorCode = fserrors.ErrorCode(x)
+			}
+		}
+	}
+	// Add the code of the first error logged unless set already
+	if _, found := fields["errorCode"]; !found && errorCode != "" {
+		fields["errorCode"] = string(errorCode)
+	}
+	return fields
+}
+
 // LogPrintf produces a log string from the arguments passed in
 func LogPrintf(level LogLevel, o interface{}, text string, args ...interface{}) {
-	out := fmt.Sprintf(text, args...)
+	ci := GetConfig(context.TODO())
+	out := redactLog(ci, fmt.Sprintf(text, args...))
 
-	if GetConfig(context.TODO()).UseJSONLog {
-		fields := logrus.Fields{}
-		if o != nil {
-			fields = logrus.Fields{
-				"object":     fmt.Sprintf("%+v", o),
-				"objectType": fmt.Sprintf("%T", o),
-			}
+	if interval := ci.LogRepeatInterval; interval > 0 {
+		suppressed, summary := logRepeat.suppress(level, o, out, interval)
+		if summary != nil {
+			logOutput(summary.level, summary.o, summary.text, nil)
 		}
-		for _, arg := range args {
-			if item, ok := arg.(LogValueItem); ok {
-				fields[item.key] = item.value
-			}
+		if suppressed {
+			return
 		}
+	}
+	logOutput(level, o, out, args)
+}
+
+// logOutput writes the log message out about o with fields from args
+// to the log
+func logOutput(level LogLevel, o interface{}, out string, args []interface{}) {
+	if LogOutput != nil {
+		LogOutput(level, o, out, logFields(o, args))
+		return
+	}
+	var fields logrus.Fields
+	if GetConfig(context.TODO()).UseJSONLog {
+		fields = logFields(o, args)
+	}
+	LogDefaultOutput(level, o, out, fields)
+}
+
+// LogDefaultOutput writes the log message out about o to the default
+// text or JSON log.  fields are only used for the JSON log.
+func LogDefaultOutput(level LogLevel, o interface{}, out string, fields logrus.Fields) {
+	if GetConfig(context.TODO()).UseJSONLog {
 		switch level {
 		case LogLevelDebug:
 			logrus.WithFields(fields).Debug(out)
@@ -137,7 +193,7 @@ func LogPrintf(level LogLevel, o interface{}, text string, args ...interface{})
 
 // LogLevelPrintf writes logs at the given level
 func LogLevelPrintf(level LogLevel, o interface{}, text string, args ...interface{}) {
-	if GetConfig(context.TODO()).LogLevel >= level {
+	if LogLevelFor(o) >= level {
 		LogPrintf(level, o, text, args...)
 	}
 }
@@ -145,7 +201,7 @@ func LogLevelPrintf(level LogLevel, o interface{}, text string, args ...interfac
 // Errorf writes error log output for this Object or Fs.  It
 // should always be seen by the user.
 func Errorf(o interface{}, text string, args ...interface{}) {
-	if GetConfig(context.TODO()).LogLevel >= LogLevelError {
+	if LogLevelFor(o) >= LogLevelError {
 		LogPrintf(LogLevelError, o, text, args...)
 	}
 }
@@ -156,7 +212,7 @@ func Errorf(o interface{}, text string, args ...interface{}) {
 // important things the user should see.  The user can filter these
 // out with the -q flag.
 func Logf(o interface{}, text string, args ...interface{}) {
-	if GetConfig(context.TODO()).LogLevel >= LogLevelNotice {
+	if LogLevelFor(o) >= LogLevelNotice {
 		LogPrintf(LogLevelNotice, o, text, args...)
 	}
 }
@@ -165,7 +221,7 @@ func Logf(o interface{}, text string, args ...interface{}) {
 // level for logging transfers, deletions and things which should
 // appear with the -v flag.
 func Infof(o interface{}, text string, args ...interface{}) {
-	if GetConfig(context.TODO()).LogLevel >= LogLevelInfo {
+	if LogLevelFor(o) >= LogLevelInfo {
 		LogPrintf(LogLevelInfo, o, text, args...)
 	}
 }
@@ -173,7 +229,7 @@ func Infof(o interface{}, text string, args ...interface{}) {
 // Debugf writes debugging output for this Object or Fs.  Use this for
 // debug only.  The user must have to specify -vv to see this.
 func Debugf(o interface{}, text string, args ...interface{}) {
-	if GetConfig(context.TODO()).LogLevel >= LogLevelDebug {
+	if LogLevelFor(o) >= LogLevelDebug {
 		LogPrintf(LogLevelDebug, o, text, args...)
 	}
 }
@@ -0,0 +1,151 @@
+// Colors for the log levels in the terminal
+
+package log
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	colorable "github.com/mattn/go-colorable"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/terminal"
+)
+
+// logColorNames maps the names of colors for --log-colors to their
+// escape codes
+var logColorNames = map[string]string{
+	"default":        "",
+	"bold":           terminal.Bright,
+	"dim":            terminal.Dim,
+	"underline":      terminal.Underscore,
+	"black":          terminal.BlackFg,
+	"red":            terminal.RedFg,
+	"green":          terminal.GreenFg,
+	"yellow":         terminal.YellowFg,
+	"blue":           terminal.BlueFg,
+	"magenta":        terminal.MagentaFg,
+	"cyan":           terminal.CyanFg,
+	"white":          terminal.WhiteFg,
+	"bright-black":   terminal.HiBlackFg,
+	"bright-red":     terminal.HiRedFg,
+	"bright-green":   terminal.HiGreenFg,
+	"bright-yellow":  terminal.HiYellowFg,
+	"bright-blue":    terminal.HiBlueFg,
+	"bright-magenta": terminal.HiMagentaFg,
+	"bright-cyan":    terminal.HiCyanFg,
+	"bright-white":   terminal.HiWhiteFg,
+}
+
+// logColorLevels maps the level names for --log-colors to log levels
+var logColorLevels = map[string][]fs.LogLevel{
+	"emergency": {fs.LogLevelEmergency},
+	"alert":     {fs.LogLevelAlert},
+	"critical":  {fs.LogLevelCritical},
+	"error":     {fs.LogLevelError},
+	"warning":   {fs.LogLevelWarning},
+	"warn":      {fs.LogLevelWarning},
+	"notice":    {fs.LogLevelNotice},
+	"info":      {fs.LogLevelInfo},
+	"debug":     {fs.LogLevelDebug},
+}
+
+// defaultLogColors is the palette used for levels which aren't set
+// in --log-colors
+var defaultLogColors = "emergency=bold+red,alert=bold+red,critical=bold+red,error=red,warning=yellow,notice=default,info=default,debug=dim"
+
+// logColors are the escape codes for each log level or nil if the
+// log isn't colored
+var logColors map[fs.LogLevel]string
+
+// parseLogColors parses a --log-colors spec such as
+// "error=red,warn=bright-yellow,info=default" into escape codes for
+// each level on top of the default palette.
+//
+// Colors may be combined with "+", e.g. "error=bold+red".
+func parseLogColors(spec string) (map[fs.LogLevel]string, error) {
+	colors := map[fs.LogLevel]string{}
+	for _, s := range []string{defaultLogColors, spec} {
+		for _, item := range strings.Split(s, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || item == "default" {
+				continue
+			}
+			equals := strings.IndexRune(item, '=')
+			if equals < 0 {
+				return nil, errors.Errorf("invalid log color %q - expecting LEVEL=COLOR", item)
+			}
+			levelName := strings.ToLower(strings.TrimSpace(item[:equals]))
+			levels, ok := logColorLevels[levelName]
+			if !ok {
+				return nil, errors.Errorf("unknown log level %q in log color %q", levelName, item)
+			}
+			code := ""
+			for _, colorName := range strings.Split(item[equals+1:], "+") {
+				colorName = strings.ToLower(strings.TrimSpace(colorName))
+				colorCode, ok := logColorNames[colorName]
+				if !ok {
+					return nil, errors.Errorf("unknown color %q in log color %q", colorName, item)
+				}
+				code += colorCode
+			}
+			for _, level := range levels {
+				colors[level] = code
+			}
+		}
+	}
+	return colors, nil
+}
+
+// colorsWanted returns true if the log should be colored on the
+// terminal.
+//
+// Colors are disabled if the NO_COLOR environment variable is set
+// (see https://no-color.org/) or stdout isn't a terminal.
+func colorsWanted() bool {
+	if Opt.Colors == "" {
+		return false
+	}
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor {
+		return false
+	}
+	return terminal.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// LevelString returns level padded for the text log colored as
+// configured with --log-colors.
+func LevelString(level fs.LogLevel) string {
+	levelString := fmt.Sprintf("%-6s", level)
+	if code := logColors[level]; code != "" {
+		levelString = code + levelString + terminal.Reset
+	}
+	return levelString
+}
+
+// startColors starts coloring the log levels in the terminal if
+// configured.
+func startColors() error {
+	if Opt.Colors == "" {
+		return nil
+	}
+	colors, err := parseLogColors(Opt.Colors)
+	if err != nil {
+		return err
+	}
+	if !colorsWanted() {
+		return nil
+	}
+	logColors = colors
+	// Only color the text log if it is going to the terminal
+	if Redirected() || len(Opt.Sinks) != 0 || Opt.LogSystemdSupport || !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	log.SetOutput(colorable.NewColorable(os.Stderr))
+	fs.LogPrint = func(level fs.LogLevel, text string) {
+		text = fmt.Sprintf("%s: %s", LevelString(level), text)
+		_ = log.Output(6, text)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/terminal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogColors(t *testing.T) {
+	colors, err := parseLogColors("default")
+	require.NoError(t, err)
+	assert.Equal(t, terminal.RedFg, colors[fs.LogLevelError])
+	assert.Equal(t, terminal.YellowFg, colors[fs.LogLevelWarning])
+	assert.Equal(t, "", colors[fs.LogLevelInfo])
+	assert.Equal(t, terminal.Dim, colors[fs.LogLevelDebug])
+
+	colors, err = parseLogColors("error=bold+bright-red, WARN=bright-yellow,debug=default")
+	require.NoError(t, err)
+	assert.Equal(t, terminal.Bright+terminal.HiRedFg, colors[fs.LogLevelError])
+	assert.Equal(t, terminal.HiYellowFg, colors[fs.LogLevelWarning])
+	assert.Equal(t, "", colors[fs.LogLevelNotice])
+	assert.Equal(t, "", colors[fs.LogLevelDebug])
+
+	for _, spec := range []string{"error", "potato=red", "error=potato", "error=red+"} {
+		_, err = parseLogColors(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	oldLogColors := logColors
+	defer func() {
+		logColors = oldLogColors
+	}()
+
+	logColors = nil
+	assert.Equal(t, "ERROR ", LevelString(fs.LogLevelError))
+
+	logColors = map[fs.LogLevel]string{fs.LogLevelError: terminal.RedFg}
+	assert.Equal(t, terminal.RedFg+"ERROR "+terminal.Reset, LevelString(fs.LogLevelError))
+	assert.Equal(t, "INFO  ", LevelString(fs.LogLevelInfo))
+}
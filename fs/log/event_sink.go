@@ -0,0 +1,38 @@
+// Send the log to the rc event stream
+
+package log
+
+import (
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/sirupsen/logrus"
+)
+
+// eventSink publishes the log messages as rc events while anything
+// is subscribed to them.
+type eventSink struct{}
+
+func (eventSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if !rc.HasEventSubscribers() {
+		return
+	}
+	data := make(rc.Params, len(fields)+2)
+	for k, v := range fields {
+		data[k] = v
+	}
+	data["level"] = level.String()
+	data["msg"] = text
+	rc.PublishEvent(rc.EventLog, data)
+}
+
+var startEventSinkOnce sync.Once
+
+// StartEventSink sends the log to the rc event stream as well as the
+// other log outputs.  It is safe to call more than once.
+func StartEventSink() {
+	startEventSinkOnce.Do(func() {
+		addSink(eventSink{})
+	})
+}
@@ -0,0 +1,25 @@
+// Windows Event Log interface for non-Windows variants only
+
+// +build !windows
+
+package log
+
+import (
+	"log"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// Starts the Windows Event Log if configured, returns true if it was
+// started
+func startWindowsEventLog() bool {
+	log.Fatalf("--log-windows-eventlog not supported on %s platform", runtime.GOOS)
+	return false
+}
+
+// newEventLogSink makes a sink which writes to the Windows Event Log
+// in format
+func newEventLogSink(format string) (sink, error) {
+	return nil, errors.Errorf("eventlog log sink not supported on %s platform", runtime.GOOS)
+}
@@ -0,0 +1,144 @@
+// Log to the Windows Event Log
+
+// +build windows
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventLogSource returns the source the events are logged with
+func eventLogSource() string {
+	if Opt.SyslogTag != "" {
+		return Opt.SyslogTag
+	}
+	base := filepath.Base(os.Args[0])
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// eventLogID returns the event ID for level.
+//
+// The event IDs are the level numbers plus one, so Emergency is 1 and
+// Debug is 8, which means events can be filtered by the rclone log
+// level as well as by the event type.
+func eventLogID(level fs.LogLevel) uint32 {
+	return uint32(level) + 1
+}
+
+// eventLogPrint writes text to l with the event type of level
+func eventLogPrint(l *eventlog.Log, level fs.LogLevel, text string) {
+	eid := eventLogID(level)
+	switch {
+	case level <= fs.LogLevelError:
+		_ = l.Error(eid, text)
+	case level == fs.LogLevelWarning:
+		_ = l.Warning(eid, text)
+	default:
+		_ = l.Info(eid, text)
+	}
+}
+
+// openEventLog opens the event log, registering the event source in
+// the registry if possible.
+func openEventLog() (*eventlog.Log, error) {
+	source := eventLogSource()
+	// Registering the source makes the messages show properly in the
+	// Event Viewer.  It needs admin rights and fails if the source
+	// exists already so ignore the error - the events are logged
+	// without it.
+	_ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open event log source %q", source)
+	}
+	return l, nil
+}
+
+// eventLogWriter writes the standard log to the event log
+type eventLogWriter struct {
+	l *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (n int, err error) {
+	eventLogPrint(w.l, fs.LogLevelNotice, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Starts the Windows Event Log
+//
+// The log is sent via an eventLogSink so that JSON formatting and the
+// LogValue fields are preserved if --use-json-log is in effect.
+func startWindowsEventLog() bool {
+	format := sinkFormatText
+	if fs.GetConfig(context.Background()).UseJSONLog {
+		format = sinkFormatJSON
+	}
+	s, err := openEventLogSink(format)
+	if err != nil {
+		log.Fatalf("Failed to start Windows Event Log: %v", err)
+	}
+	w := eventLogWriter{l: s.l}
+	log.SetFlags(0)
+	log.SetOutput(w)
+	logrus.SetOutput(w)
+	fs.LogPrint = func(level fs.LogLevel, text string) {
+		eventLogPrint(s.l, level, text)
+	}
+	setSinks([]sink{s})
+	return true
+}
+
+// eventLogSink writes logs to the Windows Event Log
+type eventLogSink struct {
+	l         *eventlog.Log
+	formatter logrus.Formatter // set if the sink outputs JSON
+}
+
+// newEventLogSink makes a sink which writes to the Windows Event Log
+// in format
+func newEventLogSink(format string) (sink, error) {
+	return openEventLogSink(format)
+}
+
+// openEventLogSink opens the event log returning an eventLogSink
+// writing in format
+func openEventLogSink(format string) (*eventLogSink, error) {
+	l, err := openEventLog()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start Windows Event Log sink")
+	}
+	s := &eventLogSink{l: l}
+	if format == sinkFormatJSON {
+		s.formatter = newJSONFormatter()
+	}
+	atexit.Register(func() {
+		_ = l.Close()
+	})
+	return s, nil
+}
+
+func (s *eventLogSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if s.formatter != nil {
+		out, err := formatEntry(s.formatter, level, text, fields)
+		if err == nil {
+			eventLogPrint(s.l, level, out)
+			return
+		}
+	}
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	eventLogPrint(s.l, level, text)
+}
@@ -0,0 +1,16 @@
+// +build windows
+
+package log
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLogID(t *testing.T) {
+	assert.Equal(t, uint32(1), eventLogID(fs.LogLevelEmergency))
+	assert.Equal(t, uint32(4), eventLogID(fs.LogLevelError))
+	assert.Equal(t, uint32(8), eventLogID(fs.LogLevelDebug))
+}
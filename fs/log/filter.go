@@ -0,0 +1,61 @@
+// Filter the log by the path of the object being logged about
+
+package log
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+)
+
+// logFilter is the compiled --log-filter or nil if not in use
+var logFilter *regexp.Regexp
+
+// startFilter starts filtering the log with --log-filter if set
+func startFilter() error {
+	if Opt.Filter == "" {
+		return nil
+	}
+	re, err := filter.GlobToRegexp(Opt.Filter, false)
+	if err != nil {
+		return errors.Wrapf(err, "invalid --log-filter %q", Opt.Filter)
+	}
+	logFilter = re
+	// The filtering is done by the sinks so send the log to the
+	// default output via them if there aren't any
+	if fs.LogOutput == nil {
+		setSinks([]sink{defaultSink{}})
+	}
+	return nil
+}
+
+// logFilterPath returns the path of o to match against --log-filter
+// or false if o doesn't have one.
+func logFilterPath(o interface{}) (string, bool) {
+	switch x := o.(type) {
+	case fs.DirEntry:
+		return x.Remote(), true
+	case string:
+		return x, x != ""
+	}
+	return "", false
+}
+
+// filtered returns true if the log message at level about o should
+// be dropped because of --log-filter.
+//
+// Messages about objects which don't match are only logged at ERROR
+// level and above.  Messages which aren't about an object with a
+// path, e.g. the stats, are never dropped.
+func filtered(level fs.LogLevel, o interface{}) bool {
+	if logFilter == nil || level <= fs.LogLevelError {
+		return false
+	}
+	path, ok := logFilterPath(o)
+	if !ok {
+		return false
+	}
+	return !logFilter.MatchString(path)
+}
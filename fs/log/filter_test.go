@@ -0,0 +1,43 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiltered(t *testing.T) {
+	oldLogFilter := logFilter
+	defer func() {
+		logFilter = oldLogFilter
+	}()
+
+	logFilter = nil
+	assert.False(t, filtered(fs.LogLevelDebug, "potato"))
+
+	var err error
+	logFilter, err = filter.GlobToRegexp("backup/2024/**", false)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		level fs.LogLevel
+		o     interface{}
+		want  bool
+	}{
+		{fs.LogLevelInfo, "backup/2024/file.txt", false},
+		{fs.LogLevelDebug, mockobject.Object("backup/2024/dir/file.txt"), false},
+		{fs.LogLevelInfo, "backup/2023/file.txt", true},
+		{fs.LogLevelNotice, mockobject.Object("backup/2023/file.txt"), true},
+		{fs.LogLevelError, "backup/2023/file.txt", false},
+		{fs.LogLevelCritical, mockobject.Object("backup/2023/file.txt"), false},
+		{fs.LogLevelInfo, nil, false},
+		{fs.LogLevelInfo, "", false},
+		{fs.LogLevelInfo, 42, false},
+	} {
+		got := filtered(test.level, test.o)
+		assert.Equal(t, test.want, got, "%v %v", test.level, test.o)
+	}
+}
@@ -0,0 +1,283 @@
+// Ship the log to an HTTP endpoint
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats for the body of the requests made by the httpSink
+const (
+	httpFormatJSON   = "json"   // a JSON array of log entries
+	httpFormatNDJSON = "ndjson" // one JSON log entry per line
+	httpFormatLoki   = "loki"   // the Loki push API
+)
+
+const (
+	httpSinkQueue        = 10000            // maximum log entries waiting to be sent
+	httpSinkBatch        = 1000             // maximum log entries to send in one request
+	httpSinkInterval     = time.Second      // send log entries this often
+	httpSinkBlockTimeout = time.Second      // how long logging blocks when the queue is full
+	httpSinkTimeout      = 30 * time.Second // timeout for each request
+	httpSinkRetries      = 5                // number of tries for each request
+)
+
+// httpEntry is a log entry waiting to be sent
+type httpEntry struct {
+	time time.Time
+	line string // the log entry formatted as JSON
+}
+
+// httpSink batches up the log in JSON format and POSTs it to an
+// HTTP endpoint in the background.
+//
+// If the endpoint can't keep up then logging blocks for a short time
+// when the queue is full before dropping log entries.
+type httpSink struct {
+	url       string
+	format    string
+	headers   http.Header
+	client    *http.Client
+	formatter logrus.Formatter
+	entries   chan httpEntry
+	flush     chan chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	dropped   int // number of log entries dropped since last reported
+}
+
+// newHTTPSink makes a sink which sends the log to url in format with
+// the extra headers passed in as "Key: Value"
+func newHTTPSink(url, format string, headers []string) (*httpSink, error) {
+	switch format {
+	case httpFormatJSON, httpFormatNDJSON, httpFormatLoki:
+	default:
+		return nil, errors.Errorf("unknown log HTTP format %q", format)
+	}
+	s := &httpSink{
+		url:       url,
+		format:    format,
+		headers:   make(http.Header),
+		formatter: newJSONFormatter(),
+		// Don't use fshttp here as it logs and traces requests
+		client: &http.Client{
+			Timeout: httpSinkTimeout,
+		},
+		entries: make(chan httpEntry, httpSinkQueue),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid log HTTP header %q - expecting \"Key: Value\"", header)
+		}
+		s.headers.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// startHTTPSink starts sending the log to url as well as the other
+// log outputs, sending any queued log entries on exit.
+func startHTTPSink(url, format string, headers []string) error {
+	s, err := newHTTPSink(url, format, headers)
+	if err != nil {
+		return err
+	}
+	addSink(s)
+	atexit.Register(s.shutdown)
+	return nil
+}
+
+func (s *httpSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	line, err := formatEntry(s.formatter, level, text, fields)
+	if err != nil {
+		return
+	}
+	entry := httpEntry{time: time.Now(), line: line}
+	select {
+	case s.entries <- entry:
+		return
+	default:
+	}
+	// Queue is full so apply back pressure for a while
+	timer := time.NewTimer(httpSinkBlockTimeout)
+	defer timer.Stop()
+	select {
+	case s.entries <- entry:
+	case <-timer.C:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+// run the sender until shutdown
+func (s *httpSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(httpSinkInterval)
+	defer ticker.Stop()
+	var batch []httpEntry
+	send := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) >= httpSinkBatch {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-s.flush:
+			for len(s.entries) > 0 {
+				batch = append(batch, <-s.entries)
+				if len(batch) >= httpSinkBatch {
+					send()
+				}
+			}
+			send()
+			close(flushed)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// shutdown sends any queued log entries and stops the sender
+func (s *httpSink) shutdown() {
+	flushed := make(chan struct{})
+	s.flush <- flushed
+	<-flushed
+	close(s.done)
+	s.wg.Wait()
+}
+
+// droppedEntry returns a log entry reporting the number of dropped
+// log entries if there were any.
+func (s *httpSink) droppedEntry() (entry httpEntry, ok bool) {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped == 0 {
+		return entry, false
+	}
+	text := fmt.Sprintf("Dropped %d log entries as the log HTTP endpoint couldn't keep up", dropped)
+	line, err := formatEntry(s.formatter, fs.LogLevelError, text, logrus.Fields{"dropped": dropped})
+	if err != nil {
+		return entry, false
+	}
+	return httpEntry{time: time.Now(), line: line}, true
+}
+
+// encode the batch into the body of a request
+func (s *httpSink) encode(batch []httpEntry) (body []byte, contentType string, err error) {
+	var buf bytes.Buffer
+	switch s.format {
+	case httpFormatNDJSON:
+		for _, entry := range batch {
+			buf.WriteString(entry.line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	case httpFormatLoki:
+		values := make([][2]string, len(batch))
+		for i, entry := range batch {
+			values[i] = [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line}
+		}
+		body, err = json.Marshal(map[string]interface{}{
+			"streams": []interface{}{
+				map[string]interface{}{
+					"stream": map[string]string{"job": "rclone"},
+					"values": values,
+				},
+			},
+		})
+		return body, "application/json", err
+	default:
+		buf.WriteByte('[')
+		for i, entry := range batch {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(entry.line)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), "application/json", nil
+	}
+}
+
+// send the batch of log entries retrying on failure
+func (s *httpSink) send(batch []httpEntry) {
+	if entry, ok := s.droppedEntry(); ok {
+		batch = append(batch, entry)
+	}
+	body, contentType, err := s.encode(batch)
+	if err != nil {
+		log.Printf("Failed to encode log entries for HTTP: %v", err)
+		return
+	}
+	sleep := time.Second
+	for try := 1; try <= httpSinkRetries; try++ {
+		var retry bool
+		retry, err = s.post(body, contentType)
+		if err == nil || !retry {
+			break
+		}
+		if try < httpSinkRetries {
+			time.Sleep(sleep)
+			sleep *= 2
+		}
+	}
+	if err != nil {
+		// Don't use fs.Errorf here as it would log to this sink
+		log.Printf("Failed to send %d log entries to HTTP: %v", len(batch), err)
+	}
+}
+
+// post body to the endpoint returning whether it is worth retrying
+// if there was an error.
+func (s *httpSink) post(body []byte, contentType string) (retry bool, err error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range s.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.Errorf("HTTP error %s", resp.Status)
+}
@@ -0,0 +1,105 @@
+package log
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logServer records the requests made to it failing the first fail
+// of them.
+type logServer struct {
+	mu     sync.Mutex
+	fail   int
+	bodies []string
+	header http.Header
+}
+
+func (ls *logServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.fail > 0 {
+		ls.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	ls.bodies = append(ls.bodies, string(body))
+	ls.header = r.Header
+}
+
+func TestNewHTTPSinkErrors(t *testing.T) {
+	_, err := newHTTPSink("http://localhost/", "potato", nil)
+	assert.Error(t, err)
+	_, err = newHTTPSink("http://localhost/", httpFormatJSON, []string{"NoColon"})
+	assert.Error(t, err)
+}
+
+func TestHTTPSink(t *testing.T) {
+	ls := &logServer{fail: 1}
+	server := httptest.NewServer(ls)
+	defer server.Close()
+
+	s, err := newHTTPSink(server.URL, httpFormatJSON, []string{"Authorization: Bearer potato"})
+	require.NoError(t, err)
+	s.print(fs.LogLevelInfo, nil, "one", logrus.Fields{"size": 1})
+	s.print(fs.LogLevelError, nil, "two", logrus.Fields{"size": 2})
+	s.shutdown()
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	require.Equal(t, 1, len(ls.bodies))
+	assert.Equal(t, "Bearer potato", ls.header.Get("Authorization"))
+	assert.Equal(t, "application/json", ls.header.Get("Content-Type"))
+	var entries []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(ls.bodies[0]), &entries))
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, "one", entries[0]["msg"])
+	assert.Equal(t, "info", entries[0]["level"])
+	assert.Equal(t, float64(1), entries[0]["size"])
+	assert.Equal(t, "two", entries[1]["msg"])
+	assert.Equal(t, "error", entries[1]["level"])
+}
+
+func TestHTTPSinkEncode(t *testing.T) {
+	batch := []httpEntry{
+		{time: time.Unix(1, 0), line: `{"msg":"one"}`},
+		{time: time.Unix(2, 0), line: `{"msg":"two"}`},
+	}
+	for _, test := range []struct {
+		format          string
+		wantBody        string
+		wantContentType string
+	}{
+		{httpFormatJSON, `[{"msg":"one"},{"msg":"two"}]`, "application/json"},
+		{httpFormatNDJSON, "{\"msg\":\"one\"}\n{\"msg\":\"two\"}\n", "application/x-ndjson"},
+		{httpFormatLoki, `{"streams":[{"stream":{"job":"rclone"},"values":[["1000000000","{\"msg\":\"one\"}"],["2000000000","{\"msg\":\"two\"}"]]}]}`, "application/json"},
+	} {
+		s := &httpSink{format: test.format}
+		body, contentType, err := s.encode(batch)
+		require.NoError(t, err, test.format)
+		assert.Equal(t, test.wantBody, string(body), test.format)
+		assert.Equal(t, test.wantContentType, contentType, test.format)
+	}
+}
+
+func TestHTTPSinkDropped(t *testing.T) {
+	s := &httpSink{formatter: newJSONFormatter()}
+	_, ok := s.droppedEntry()
+	assert.False(t, ok)
+	s.dropped = 3
+	entry, ok := s.droppedEntry()
+	require.True(t, ok)
+	assert.True(t, strings.Contains(entry.line, "Dropped 3 log entries"), entry.line)
+	assert.Equal(t, 0, s.dropped)
+}
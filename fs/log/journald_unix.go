@@ -0,0 +1,150 @@
+// Log to systemd journald using its native protocol
+
+// +build !windows,!nacl,!plan9
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+// journaldSocket is where journald listens for native protocol messages
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink writes logs to journald preserving the structured
+// fields of the log as journal fields.
+//
+// See https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+type journaldSink struct {
+	conn     *net.UnixConn
+	addr     *net.UnixAddr
+	tag      string
+	facility int
+}
+
+// newJournaldSink makes a sink which writes to journald
+func newJournaldSink() (sink, error) {
+	facility, err := syslogFacility()
+	if err != nil {
+		return nil, err
+	}
+	// Sending to a missing socket fails silently so check first
+	if _, err := os.Stat(journaldSocket); err != nil {
+		return nil, errors.Wrap(err, "journald socket not found")
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open journald socket")
+	}
+	return &journaldSink{
+		conn:     conn,
+		addr:     &net.UnixAddr{Name: journaldSocket, Net: "unixgram"},
+		tag:      syslogTag(),
+		facility: facility,
+	}, nil
+}
+
+// journaldFieldNames are the journal field names for the fields which
+// aren't converted with the usual rules
+var journaldFieldNames = map[string]string{
+	"jobid": "RCLONE_JOB",
+}
+
+// journaldFieldName converts key into a valid journal field name, so
+// "objectType" becomes "OBJECT_TYPE".
+//
+// These may only contain upper case letters, digits and underscores
+// and must not start with an underscore or a digit.
+func journaldFieldName(key string) string {
+	if name, found := journaldFieldNames[key]; found {
+		return name
+	}
+	var out strings.Builder
+	lower := false
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z':
+			out.WriteRune(unicode.ToUpper(c))
+			lower = true
+			continue
+		case c >= 'A' && c <= 'Z':
+			if lower {
+				out.WriteRune('_')
+			}
+			out.WriteRune(c)
+		case c >= '0' && c <= '9':
+			out.WriteRune(c)
+		default:
+			out.WriteRune('_')
+		}
+		lower = false
+	}
+	name := strings.TrimLeft(out.String(), "_0123456789")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+// writeJournaldField writes a single journal field to buf
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		// Values with newlines are written with an explicit length
+		buf.WriteByte('\n')
+		_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journaldValue converts a field value into a string
+func journaldValue(value interface{}) string {
+	switch x := value.(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	case int, int64, int32, uint, uint64, uint32, float64, float32, bool:
+		return fmt.Sprint(x)
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+// format the log message as a journald native protocol datagram
+func (s *journaldSink) format(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) []byte {
+	var buf bytes.Buffer
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	writeJournaldField(&buf, "MESSAGE", text)
+	// rclone's log levels are the syslog priorities
+	writeJournaldField(&buf, "PRIORITY", fmt.Sprint(int(level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", s.tag)
+	writeJournaldField(&buf, "SYSLOG_FACILITY", fmt.Sprint(s.facility))
+	for key, value := range fields {
+		writeJournaldField(&buf, journaldFieldName(key), journaldValue(value))
+	}
+	return buf.Bytes()
+}
+
+func (s *journaldSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	_, _ = s.conn.WriteToUnix(s.format(level, o, text, fields), s.addr)
+}
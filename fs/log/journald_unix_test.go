@@ -0,0 +1,39 @@
+// +build !windows,!nacl,!plan9
+
+package log
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournaldFieldName(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"object", "OBJECT"},
+		{"objectType", "OBJECT_TYPE"},
+		{"dstHash", "DST_HASH"},
+		{"jobid", "RCLONE_JOB"},
+		{"HTTP", "HTTP"},
+		{"http.status_code", "HTTP_STATUS_CODE"},
+		{"_private", "PRIVATE"},
+		{"2fa", "FA"},
+		{"", "FIELD"},
+	} {
+		assert.Equal(t, test.want, journaldFieldName(test.in), test.in)
+	}
+}
+
+func TestJournaldFormat(t *testing.T) {
+	s := &journaldSink{tag: "rclone", facility: 3}
+	got := string(s.format(fs.LogLevelError, "potato", "hello", logrus.Fields{"size": 42}))
+	assert.Equal(t, "MESSAGE=potato: hello\nPRIORITY=3\nSYSLOG_IDENTIFIER=rclone\nSYSLOG_FACILITY=3\nSIZE=42\n", got)
+
+	got = string(s.format(fs.LogLevelDebug, nil, "two\nlines", nil))
+	assert.Equal(t, "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=7\nSYSLOG_IDENTIFIER=rclone\nSYSLOG_FACILITY=3\n", got)
+}
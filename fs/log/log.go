@@ -4,12 +4,11 @@ package log
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	systemd "github.com/iguanesolutions/go-systemd/v5"
 	sysdjournald "github.com/iguanesolutions/go-systemd/v5/journald"
@@ -19,17 +18,32 @@ import (
 
 // Options contains options for controlling the logging
 type Options struct {
-	File              string // Log everything to this file
-	Format            string // Comma separated list of log format options
-	UseSyslog         bool   // Use Syslog for logging
-	SyslogFacility    string // Facility for syslog, e.g. KERN,USER,...
-	LogSystemdSupport bool   // set if using systemd logging
+	File              string        // Log everything to this file
+	Format            string        // Comma separated list of log format options
+	UseSyslog         bool          // Use Syslog for logging
+	SyslogFacility    string        // Facility for syslog, e.g. KERN,USER,...
+	SyslogTag         string        // Tag for syslog, defaults to the program name
+	SyslogAddress     string        // Send syslog to this [udp://|tcp://]host[:port] instead of the local syslog
+	LogSystemdSupport bool          // set if using systemd logging
+	SystemdStructured bool          // log to journald with structured fields when using systemd logging
+	Sinks             []string      // Log to each of these as [FORMAT:]TARGET
+	FileMaxSize       fs.SizeSuffix // Rotate log files bigger than this
+	FileMaxAge        time.Duration // Rotate log files older than this
+	FileMaxBackups    int           // Number of rotated log files to keep
+	HTTPURL           string        // POST the log in JSON format to this URL
+	HTTPHeaders       []string      // Extra headers for the log HTTP requests as "Key: Value"
+	HTTPFormat        string        // Format of the log HTTP requests: json, ndjson or loki
+	Colors            string        // Colors for the log levels in the terminal, e.g. "error=red"
+	WindowsEventLog   bool          // Use the Windows Event Log for logging
+	Filter            string        // Only log about objects matching this glob below ERROR level
 }
 
 // DefaultOpt is the default values used for Opt
 var DefaultOpt = Options{
 	Format:         "date,time",
 	SyslogFacility: "DAEMON",
+	FileMaxSize:    -1,
+	HTTPFormat:     httpFormatJSON,
 }
 
 // Opt is the options for the logger
@@ -117,17 +131,12 @@ func InitLogging() {
 
 	// Log file output
 	if Opt.File != "" {
-		f, err := os.OpenFile(Opt.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		f, err := openLogFile(Opt.File, redirectStderr)
 		if err != nil {
 			log.Fatalf("Failed to open log file: %v", err)
 		}
-		_, err = f.Seek(0, io.SeekEnd)
-		if err != nil {
-			fs.Errorf(nil, "Failed to seek log file to end: %v", err)
-		}
 		log.SetOutput(f)
 		logrus.SetOutput(f)
-		redirectStderr(f)
 	}
 
 	// Syslog output
@@ -138,6 +147,36 @@ func InitLogging() {
 		startSysLog()
 	}
 
+	// Windows Event Log output
+	if Opt.WindowsEventLog {
+		if Opt.File != "" || Opt.UseSyslog {
+			log.Fatalf("Can't use --log-windows-eventlog with --log-file or --syslog")
+		}
+		startWindowsEventLog()
+	}
+
+	// Log sinks output
+	if len(Opt.Sinks) != 0 {
+		if Opt.SystemdStructured {
+			log.Fatalf("Can't use --log-sink with --log-systemd-structured")
+		}
+		if Opt.File != "" || Opt.UseSyslog || Opt.WindowsEventLog {
+			log.Fatalf("Can't use --log-sink with --log-file, --syslog or --log-windows-eventlog")
+		}
+		err := startSinks(Opt.Sinks)
+		if err != nil {
+			log.Fatalf("Failed to start log sinks: %v", err)
+		}
+	}
+
+	// HTTP output as well as the other log outputs
+	if Opt.HTTPURL != "" {
+		err := startHTTPSink(Opt.HTTPURL, Opt.HTTPFormat, Opt.HTTPHeaders)
+		if err != nil {
+			log.Fatalf("Failed to start log HTTP output: %v", err)
+		}
+	}
+
 	// Activate systemd logger support if systemd invocation ID is
 	// detected and output is going to stderr (not logging to a file or syslog)
 	if !Redirected() {
@@ -148,13 +187,33 @@ func InitLogging() {
 
 	// Systemd logging output
 	if Opt.LogSystemdSupport {
-		startSystemdLog()
+		if Opt.SystemdStructured {
+			err := startSystemdStructuredLog()
+			if err != nil {
+				startSystemdLog()
+				fs.Errorf(nil, "Failed to start structured systemd logging - using stderr: %v", err)
+			}
+		} else {
+			startSystemdLog()
+		}
+	}
+
+	// Filter the log by object path
+	err := startFilter()
+	if err != nil {
+		log.Fatalf("Failed to start log filter: %v", err)
+	}
+
+	// Colors in the terminal
+	err = startColors()
+	if err != nil {
+		log.Fatalf("Failed to set log colors: %v", err)
 	}
 }
 
 // Redirected returns true if the log has been redirected from stdout
 func Redirected() bool {
-	return Opt.UseSyslog || Opt.File != ""
+	return Opt.UseSyslog || Opt.WindowsEventLog || Opt.File != ""
 }
 
 var logLevelToStringSystemd = []string{
@@ -168,6 +227,17 @@ var logLevelToStringSystemd = []string{
 	fs.LogLevelDebug:     sysdjournald.DebugPrefix,
 }
 
+// Starts systemd logging to journald with the fields of the log as
+// journal fields
+func startSystemdStructuredLog() error {
+	s, err := newJournaldSink()
+	if err != nil {
+		return err
+	}
+	setSinks([]sink{s})
+	return nil
+}
+
 // Starts systemd logging
 func startSystemdLog() {
 	log.SetFlags(0)
@@ -177,6 +247,6 @@ func startSystemdLog() {
 			prefix = logLevelToStringSystemd[level]
 		}
 		text = fmt.Sprintf("%s%-6s: %s", prefix, level, text)
-		_ = log.Output(4, text)
+		_ = log.Output(6, text)
 	}
 }
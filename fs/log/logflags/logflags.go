@@ -13,8 +13,21 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	rc.AddOption("log", &log.Opt)
 
 	flags.StringVarP(flagSet, &log.Opt.File, "log-file", "", log.Opt.File, "Log everything to this file")
+	flags.FVarP(flagSet, &log.Opt.FileMaxSize, "log-file-max-size", "", "Rotate the log file when it is bigger than this")
+	flags.DurationVarP(flagSet, &log.Opt.FileMaxAge, "log-file-max-age", "", log.Opt.FileMaxAge, "Rotate the log file when it is older than this")
+	flags.IntVarP(flagSet, &log.Opt.FileMaxBackups, "log-file-max-backups", "", log.Opt.FileMaxBackups, "Number of rotated log files to keep (0 = keep all)")
 	flags.StringVarP(flagSet, &log.Opt.Format, "log-format", "", log.Opt.Format, "Comma separated list of log format options")
 	flags.BoolVarP(flagSet, &log.Opt.UseSyslog, "syslog", "", log.Opt.UseSyslog, "Use Syslog for logging")
 	flags.StringVarP(flagSet, &log.Opt.SyslogFacility, "syslog-facility", "", log.Opt.SyslogFacility, "Facility for syslog, e.g. KERN,USER,...")
+	flags.StringArrayVarP(flagSet, &log.Opt.Sinks, "log-sink", "", log.Opt.Sinks, "Log to this [FORMAT:]TARGET as well, e.g. json:/var/log/rclone.json (repeat as required)")
+	flags.StringVarP(flagSet, &log.Opt.HTTPURL, "log-http-url", "", log.Opt.HTTPURL, "POST the log in JSON format to this URL as well")
+	flags.StringArrayVarP(flagSet, &log.Opt.HTTPHeaders, "log-http-header", "", log.Opt.HTTPHeaders, "Set HTTP header for the log HTTP requests as \"Key: Value\" (repeat as required)")
+	flags.StringVarP(flagSet, &log.Opt.HTTPFormat, "log-http-format", "", log.Opt.HTTPFormat, "Format of the log HTTP requests: json, ndjson or loki")
+	flags.StringVarP(flagSet, &log.Opt.SyslogAddress, "syslog-address", "", log.Opt.SyslogAddress, "Send syslog messages to this server, e.g. udp://host:514 or tcp://host:514, instead of the local syslog")
+	flags.StringVarP(flagSet, &log.Opt.SyslogTag, "syslog-tag", "", log.Opt.SyslogTag, "Tag for syslog, journald and Windows Event Log messages (default the program name)")
+	flags.StringVarP(flagSet, &log.Opt.Filter, "log-filter", "", log.Opt.Filter, "Only log about objects matching this glob, e.g. \"backup/2024/**\", other objects are only logged at ERROR")
+	flags.StringVarP(flagSet, &log.Opt.Colors, "log-colors", "", log.Opt.Colors, "Color the log levels in the terminal, e.g. \"error=red,warn=bright-yellow,info=default\" or \"default\"")
+	flags.BoolVarP(flagSet, &log.Opt.WindowsEventLog, "log-windows-eventlog", "", log.Opt.WindowsEventLog, "Use the Windows Event Log for logging")
 	flags.BoolVarP(flagSet, &log.Opt.LogSystemdSupport, "log-systemd", "", log.Opt.LogSystemdSupport, "Activate systemd integration for the logger.")
+	flags.BoolVarP(flagSet, &log.Opt.SystemdStructured, "log-systemd-structured", "", log.Opt.SystemdStructured, "Log to journald with structured fields when running under systemd")
 }
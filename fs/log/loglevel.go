@@ -0,0 +1,174 @@
+// Change the log level of a running rclone
+
+package log
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/sirupsen/logrus"
+)
+
+// jsonLogHookOnce makes sure the caller hook is only added once
+var jsonLogHookOnce sync.Once
+
+// SetupJSONLog sets up logrus for the JSON log if it is in use in ci.
+//
+// It may be called again if the log level or the log level overrides
+// change.
+func SetupJSONLog(ci *fs.ConfigInfo) {
+	if !ci.UseJSONLog {
+		return
+	}
+	jsonLogHookOnce.Do(func() {
+		logrus.AddHook(NewCallerHook())
+	})
+	logrus.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: "2006-01-02T15:04:05.999999-07:00",
+	})
+	logrus.SetLevel(logrus.DebugLevel)
+	switch fs.MaxLogLevel(ci.LogLevel) {
+	case fs.LogLevelEmergency, fs.LogLevelAlert:
+		logrus.SetLevel(logrus.PanicLevel)
+	case fs.LogLevelCritical:
+		logrus.SetLevel(logrus.FatalLevel)
+	case fs.LogLevelError:
+		logrus.SetLevel(logrus.ErrorLevel)
+	case fs.LogLevelWarning, fs.LogLevelNotice:
+		logrus.SetLevel(logrus.WarnLevel)
+	case fs.LogLevelInfo:
+		logrus.SetLevel(logrus.InfoLevel)
+	case fs.LogLevelDebug:
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+}
+
+// rcLogLevelStatus returns the current log levels for core/loglevel
+func rcLogLevelStatus(ci *fs.ConfigInfo) rc.Params {
+	overrides := fs.LogLevelOverrides()
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	outOverrides := rc.Params{}
+	for _, name := range names {
+		outOverrides[name] = overrides[name].String()
+	}
+	return rc.Params{
+		"level":     ci.LogLevel.String(),
+		"json":      ci.UseJSONLog,
+		"overrides": outOverrides,
+	}
+}
+
+// rcLogLevel gets and sets the log levels
+func rcLogLevel(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	ci := fs.GetConfig(context.Background())
+	var level fs.LogLevel
+	levelString, err := in.GetString("level")
+	if err == nil {
+		if err = level.Set(levelString); err != nil {
+			return nil, err
+		}
+	} else if !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	useJSON, err := in.GetBool("json")
+	jsonFound := err == nil
+	if err != nil && !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	overrides := map[string]string{}
+	err = in.GetStructMissingOK("overrides", &overrides)
+	if err != nil {
+		return nil, err
+	}
+	overrideLevels := make(map[string]fs.LogLevel, len(overrides))
+	for name, levelString := range overrides {
+		if levelString == "" {
+			continue
+		}
+		var overrideLevel fs.LogLevel
+		if err = overrideLevel.Set(levelString); err != nil {
+			return nil, errors.Wrapf(err, "bad override for %q", name)
+		}
+		overrideLevels[name] = overrideLevel
+	}
+
+	// Only make changes once all the parameters have been checked
+	if levelString != "" {
+		ci.LogLevel = level
+		fs.Logf(nil, "Log level set to %v", level)
+	}
+	if jsonFound && useJSON != ci.UseJSONLog {
+		ci.UseJSONLog = useJSON
+		fs.Logf(nil, "JSON log set to %v", useJSON)
+	}
+	for name, levelString := range overrides {
+		if levelString == "" {
+			fs.RemoveLogLevelOverride(name)
+			fs.Logf(nil, "Log level override for %q removed", name)
+		} else {
+			fs.SetLogLevelOverride(name, overrideLevels[name])
+			fs.Logf(nil, "Log level for %q set to %v", name, overrideLevels[name])
+		}
+	}
+	SetupJSONLog(ci)
+	return rcLogLevelStatus(ci), nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "core/loglevel",
+		Fn:    rcLogLevel,
+		Title: "Get or set the log level.",
+		Help: `
+This gets or sets the log level of the running rclone without
+restarting it, which is useful for long running mounts and serve
+commands.
+
+Parameters - all optional
+
+- level - the log level DEBUG|INFO|NOTICE|ERROR, as for --log-level
+- json - true to use the JSON log, false for the text log, as for --use-json-log
+- overrides - an object of remote names to log levels
+
+The overrides set the log level of the messages about a remote and its
+objects, using the name of the remote in the config file, in place of
+the global log level.  An empty log level removes the override.  For
+example to debug just the "s3" remote and quieten "drive"
+
+    rclone rc --json '{"overrides": {"s3": "DEBUG", "drive": "ERROR"}}' core/loglevel
+
+and to remove the override for "drive"
+
+    rclone rc --json '{"overrides": {"drive": ""}}' core/loglevel
+
+With no parameters the log levels are returned without changing them.
+In either case this returns
+
+- level - the global log level
+- json - whether the JSON log is in use
+- overrides - an object of remote names to log levels
+
+Eg
+
+    rclone rc core/loglevel level=INFO
+    {
+        "json": false,
+        "level": "INFO",
+        "overrides": {
+            "s3": "DEBUG"
+        }
+    }
+
+Note that messages which aren't about a remote or one of its objects,
+such as those about directories, use the global log level.
+`,
+	})
+}
@@ -0,0 +1,62 @@
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRcLogLevel(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	oldLogLevel := ci.LogLevel
+	defer func() {
+		ci.LogLevel = oldLogLevel
+		fs.RemoveLogLevelOverride("s3")
+	}()
+	ci.LogLevel = fs.LogLevelNotice
+
+	call := rc.Calls.Get("core/loglevel")
+	require.NotNil(t, call)
+
+	out, err := call.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{
+		"level":     "NOTICE",
+		"json":      false,
+		"overrides": rc.Params{},
+	}, out)
+
+	out, err = call.Fn(ctx, rc.Params{
+		"level":     "INFO",
+		"overrides": map[string]interface{}{"s3": "DEBUG"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{
+		"level":     "INFO",
+		"json":      false,
+		"overrides": rc.Params{"s3": "DEBUG"},
+	}, out)
+	assert.Equal(t, fs.LogLevelInfo, ci.LogLevel)
+
+	out, err = call.Fn(ctx, rc.Params{
+		"overrides": `{"s3": ""}`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{}, out["overrides"])
+
+	// Nothing changes if any of the parameters are bad
+	_, err = call.Fn(ctx, rc.Params{
+		"level":     "DEBUG",
+		"overrides": map[string]interface{}{"s3": "LOUD"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, fs.LogLevelInfo, ci.LogLevel)
+
+	_, err = call.Fn(ctx, rc.Params{"level": "POTATO"})
+	assert.Error(t, err)
+}
@@ -13,13 +13,18 @@ import (
 )
 
 // redirectStderr to the file passed in
+//
+// This may be called more than once, e.g. when the log file is
+// rotated.
 func redirectStderr(f *os.File) {
-	passPromptFd, err := unix.Dup(int(os.Stderr.Fd()))
-	if err != nil {
-		log.Fatalf("Failed to duplicate stderr: %v", err)
+	if config.PasswordPromptOutput == os.Stderr {
+		passPromptFd, err := unix.Dup(int(os.Stderr.Fd()))
+		if err != nil {
+			log.Fatalf("Failed to duplicate stderr: %v", err)
+		}
+		config.PasswordPromptOutput = os.NewFile(uintptr(passPromptFd), "passPrompt")
 	}
-	config.PasswordPromptOutput = os.NewFile(uintptr(passPromptFd), "passPrompt")
-	err = unix.Dup2(int(f.Fd()), int(os.Stderr.Fd()))
+	err := unix.Dup2(int(f.Fd()), int(os.Stderr.Fd()))
 	if err != nil {
 		log.Fatalf("Failed to redirect stderr to file: %v", err)
 	}
@@ -0,0 +1,193 @@
+// Rotation of log files
+
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// backupTimeFormat is the time format used in the names of rotated
+// log files. It sorts in time order.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is an io.Writer which appends to a log file and
+// rotates it when it gets too big or too old.
+//
+// It is safe to use from multiple goroutines.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string           // path of the log file
+	maxSize    int64            // rotate when the file is bigger than this if > 0
+	maxAge     time.Duration    // rotate when the file is older than this if > 0
+	maxBackups int              // keep this many rotated files if > 0
+	onOpen     func(f *os.File) // if set, called each time a log file is opened
+	f          *os.File         // the current log file
+	size       int64            // size of the current log file
+	opened     time.Time        // when the current log file was opened
+}
+
+// openLogFile opens path for appending log output to using the
+// rotation settings in Opt.
+//
+// onOpen is called, if set, with each log file opened.
+func openLogFile(path string, onOpen func(f *os.File)) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(Opt.FileMaxSize),
+		maxAge:     Opt.FileMaxAge,
+		maxBackups: Opt.FileMaxBackups,
+		onOpen:     onOpen,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open the log file for appending
+//
+// Call with the mutex held
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = fi.Size()
+	r.opened = time.Now()
+	if r.onOpen != nil {
+		r.onOpen(f)
+	}
+	return nil
+}
+
+// needsRotate returns true if the file needs rotating before writing
+// n bytes to it.
+//
+// Call with the mutex held
+func (r *rotatingFile) needsRotate(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+int64(n) > r.maxSize {
+		return true
+	}
+	if r.maxAge > 0 && time.Since(r.opened) > r.maxAge {
+		return true
+	}
+	return false
+}
+
+// backupName returns the name the log file is rotated to at t
+func (r *rotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	return base + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// rotate renames the current log file out of the way and starts a
+// new one.
+//
+// The current file is closed before renaming as that is required on
+// Windows.
+//
+// Call with the mutex held
+func (r *rotatingFile) rotate() error {
+	_ = r.f.Close()
+	r.f = nil
+	renameErr := os.Rename(r.path, r.backupName(time.Now()))
+	// Open the log file whether the rename worked or not so we
+	// can carry on logging
+	err := r.open()
+	if err != nil {
+		return errors.Wrap(err, "failed to open new log file")
+	}
+	if renameErr != nil {
+		return errors.Wrap(renameErr, "failed to rename log file")
+	}
+	return r.prune()
+}
+
+// prune removes the oldest rotated log files leaving maxBackups
+//
+// Call with the mutex held
+func (r *rotatingFile) prune() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list rotated log files")
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	if len(backups) <= r.maxBackups {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-r.maxBackups] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return errors.Wrap(err, "failed to remove old log file")
+		}
+	}
+	return nil
+}
+
+// Write p to the log file rotating it first if necessary
+func (r *rotatingFile) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.needsRotate(len(p)) {
+		// If rotation fails carry on writing to the log file if
+		// we still have one rather than losing the log
+		if rotateErr := r.rotate(); rotateErr != nil && r.f == nil {
+			return 0, rotateErr
+		}
+	}
+	if r.f == nil {
+		return 0, errors.New("log file is not open")
+	}
+	n, err = r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close the log file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
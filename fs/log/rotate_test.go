@@ -0,0 +1,83 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-log-rotate")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "rclone.log")
+
+	opened := 0
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    10,
+		maxBackups: 2,
+		onOpen:     func(*os.File) { opened++ },
+	}
+	require.NoError(t, r.open())
+
+	for i := 0; i < 5; i++ {
+		_, err = r.Write([]byte("0123456\n"))
+		require.NoError(t, err)
+		// make sure the backups get distinct names
+		time.Sleep(2 * time.Millisecond)
+	}
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, 5, opened)
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(entries), "log file plus 2 backups")
+	for _, entry := range entries {
+		assert.Equal(t, int64(8), entry.Size(), entry.Name())
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-log-rotate")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "rclone.log")
+
+	r := &rotatingFile{
+		path:   path,
+		maxAge: time.Hour,
+	}
+	require.NoError(t, r.open())
+	_, err = r.Write([]byte("one\n"))
+	require.NoError(t, err)
+	assert.False(t, r.needsRotate(4))
+
+	r.opened = r.opened.Add(-2 * time.Hour)
+	assert.True(t, r.needsRotate(4))
+	_, err = r.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(data))
+}
+
+func TestRotatingFileBackupName(t *testing.T) {
+	r := &rotatingFile{path: filepath.Join("dir", "rclone.log")}
+	when := time.Date(2021, 1, 2, 15, 4, 5, 6000000, time.UTC)
+	assert.Equal(t, filepath.Join("dir", "rclone-2021-01-02T15-04-05.006.log"), r.backupName(when))
+}
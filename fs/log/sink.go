@@ -0,0 +1,245 @@
+// Log sinks - extra destinations for the log each with their own format
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+// sink is a destination for log messages
+type sink interface {
+	// print the log message text about o with the given fields
+	print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields)
+}
+
+// Log formats a sink can be configured with
+const (
+	sinkFormatText = "text"
+	sinkFormatJSON = "json"
+)
+
+// jsonTimestampFormat is the timestamp format used in JSON logs
+const jsonTimestampFormat = "2006-01-02T15:04:05.999999-07:00"
+
+// fsToLogrusLevel maps rclone log levels onto logrus log levels
+//
+// Note that we don't use logrus.PanicLevel as logging at that level
+// panics.
+var fsToLogrusLevel = []logrus.Level{
+	fs.LogLevelEmergency: logrus.FatalLevel,
+	fs.LogLevelAlert:     logrus.FatalLevel,
+	fs.LogLevelCritical:  logrus.FatalLevel,
+	fs.LogLevelError:     logrus.ErrorLevel,
+	fs.LogLevelWarning:   logrus.WarnLevel,
+	fs.LogLevelNotice:    logrus.WarnLevel,
+	fs.LogLevelInfo:      logrus.InfoLevel,
+	fs.LogLevelDebug:     logrus.DebugLevel,
+}
+
+// logrusLevel returns the logrus log level for level
+func logrusLevel(level fs.LogLevel) logrus.Level {
+	if level < fs.LogLevel(len(fsToLogrusLevel)) {
+		return fsToLogrusLevel[level]
+	}
+	return logrus.DebugLevel
+}
+
+// textLine formats the log message as used in text logs
+func textLine(level fs.LogLevel, o interface{}, text string) string {
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	return fmt.Sprintf("%-6s: %s", level, text)
+}
+
+// consoleSink prints text logs via fs.LogPrint so they interoperate
+// with the progress display and the systemd log prefixes.
+type consoleSink struct{}
+
+func (consoleSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	fs.LogPrint(level, text)
+}
+
+// defaultSink prints logs to the default text or JSON log output as
+// used when there are no other sinks.
+type defaultSink struct{}
+
+func (defaultSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	fs.LogDefaultOutput(level, o, text, fields)
+}
+
+// textSink writes text logs to an io.Writer
+type textSink struct {
+	logger *log.Logger
+}
+
+func newTextSink(out io.Writer) *textSink {
+	return &textSink{
+		logger: log.New(out, "", log.Flags()),
+	}
+}
+
+func (s *textSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	_ = s.logger.Output(6, textLine(level, o, text))
+}
+
+// jsonSink writes JSON logs to an io.Writer
+type jsonSink struct {
+	logger *logrus.Logger
+}
+
+// newJSONFormatter makes the formatter used for JSON logs
+func newJSONFormatter() logrus.Formatter {
+	return &logrus.JSONFormatter{
+		TimestampFormat: jsonTimestampFormat,
+	}
+}
+
+// formatLogger is used to make the entries for formatEntry
+var formatLogger = logrus.New()
+
+// formatEntry formats the log message text with fields using
+// formatter returning it without a trailing newline.
+func formatEntry(formatter logrus.Formatter, level fs.LogLevel, text string, fields logrus.Fields) (string, error) {
+	entry := logrus.NewEntry(formatLogger).WithFields(fields)
+	entry.Time = time.Now()
+	entry.Level = logrusLevel(level)
+	entry.Message = text
+	out, err := formatter.Format(entry)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func newJSONSink(out io.Writer) *jsonSink {
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetFormatter(newJSONFormatter())
+	// Filtering by level is done by rclone before we get here
+	logger.SetLevel(logrus.DebugLevel)
+	return &jsonSink{
+		logger: logger,
+	}
+}
+
+func (s *jsonSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	s.logger.WithFields(fields).Log(logrusLevel(level), text)
+}
+
+// parseSink parses a sink description of the form [FORMAT:]TARGET
+// returning the format and the target.
+func parseSink(description string) (format, target string, err error) {
+	format = sinkFormatText
+	target = description
+	if i := strings.IndexRune(description, ':'); i >= 0 {
+		switch prefix := description[:i]; prefix {
+		case sinkFormatText, sinkFormatJSON:
+			format, target = prefix, description[i+1:]
+		}
+	}
+	if target == "" {
+		return "", "", errors.Errorf("no target in log sink %q", description)
+	}
+	return format, target, nil
+}
+
+// newSink makes a sink from a description as passed to --log-sink
+func newSink(description string) (sink, error) {
+	format, target, err := parseSink(description)
+	if err != nil {
+		return nil, err
+	}
+	var out io.Writer
+	switch target {
+	case "stderr":
+		if format == sinkFormatText {
+			return consoleSink{}, nil
+		}
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	case "syslog":
+		return newSyslogSink(format)
+	case "journald":
+		return newJournaldSink()
+	case "eventlog":
+		return newEventLogSink(format)
+	default:
+		f, err := openLogFile(target, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open log sink file")
+		}
+		out = f
+	}
+	if format == sinkFormatJSON {
+		return newJSONSink(out), nil
+	}
+	return newTextSink(out), nil
+}
+
+// The active sinks
+var (
+	sinksMu sync.RWMutex
+	sinks   []sink
+)
+
+// logToSinks sends the log message to all the configured sinks
+func logToSinks(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if filtered(level, o) {
+		return
+	}
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, s := range sinks {
+		s.print(level, o, text, fields)
+	}
+}
+
+// startSinks starts logging to the sinks described by descriptions
+// instead of the default log output.
+func startSinks(descriptions []string) error {
+	newSinks := make([]sink, 0, len(descriptions))
+	for _, description := range descriptions {
+		s, err := newSink(description)
+		if err != nil {
+			return err
+		}
+		newSinks = append(newSinks, s)
+	}
+	setSinks(newSinks)
+	return nil
+}
+
+// addSink sends the log output to s as well as to the current
+// outputs.
+func addSink(s sink) {
+	sinksMu.RLock()
+	newSinks := append([]sink(nil), sinks...)
+	sinksMu.RUnlock()
+	if fs.LogOutput == nil {
+		newSinks = append(newSinks, defaultSink{})
+	}
+	setSinks(append(newSinks, s))
+}
+
+// setSinks sends all the log output to newSinks
+func setSinks(newSinks []sink) {
+	sinksMu.Lock()
+	sinks = newSinks
+	sinksMu.Unlock()
+	fs.LogOutput = logToSinks
+}
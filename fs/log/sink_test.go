@@ -0,0 +1,73 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSink(t *testing.T) {
+	for _, test := range []struct {
+		in         string
+		wantFormat string
+		wantTarget string
+		wantErr    bool
+	}{
+		{"stderr", "text", "stderr", false},
+		{"text:stderr", "text", "stderr", false},
+		{"json:/var/log/rclone.json", "json", "/var/log/rclone.json", false},
+		{"json:C:\\rclone.log", "json", "C:\\rclone.log", false},
+		{"C:\\rclone.log", "text", "C:\\rclone.log", false},
+		{"json:", "", "", true},
+		{"", "", "", true},
+	} {
+		gotFormat, gotTarget, err := parseSink(test.in)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.wantFormat, gotFormat, test.in)
+		assert.Equal(t, test.wantTarget, gotTarget, test.in)
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTextSink(&buf)
+	s.logger.SetFlags(0)
+	s.print(fs.LogLevelInfo, "potato", "hello %s", logrus.Fields{})
+	assert.Equal(t, "INFO  : potato: hello %s\n", buf.String())
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := newJSONSink(&buf)
+	s.print(fs.LogLevelError, "potato", "hello", logrus.Fields{"object": "potato", "size": 42})
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "hello", entry["msg"])
+	assert.Equal(t, "potato", entry["object"])
+	assert.Equal(t, float64(42), entry["size"])
+}
+
+func TestEventSink(t *testing.T) {
+	events, unsubscribe := rc.SubscribeEvents()
+	defer unsubscribe()
+	eventSink{}.print(fs.LogLevelError, "potato", "hello", logrus.Fields{"object": "potato", "size": 42})
+	event := <-events
+	assert.Equal(t, rc.EventLog, event.Type)
+	assert.Equal(t, rc.Params{
+		"level":  "ERROR",
+		"msg":    "hello",
+		"object": "potato",
+		"size":   42,
+	}, event.Data)
+}
@@ -7,10 +7,37 @@ package log
 import (
 	"log"
 	"runtime"
+
+	"github.com/pkg/errors"
 )
 
 // Starts syslog if configured, returns true if it was started
+//
+// Without --syslog-address the log is sent to the Windows Event Log
+// on Windows as there is no local syslog.
 func startSysLog() bool {
-	log.Fatalf("--syslog not supported on %s platform", runtime.GOOS)
+	if Opt.SyslogAddress != "" {
+		return startNetSysLog()
+	}
+	if runtime.GOOS == "windows" {
+		return startWindowsEventLog()
+	}
+	log.Fatalf("--syslog needs --syslog-address on %s platform", runtime.GOOS)
 	return false
 }
+
+// newSyslogSink makes a sink which writes to syslog in format
+func newSyslogSink(format string) (sink, error) {
+	if Opt.SyslogAddress != "" {
+		return newNetSyslogSink(format)
+	}
+	if runtime.GOOS == "windows" {
+		return newEventLogSink(format)
+	}
+	return nil, errors.Errorf("syslog log sink needs --syslog-address on %s platform", runtime.GOOS)
+}
+
+// newJournaldSink makes a sink which writes to journald
+func newJournaldSink() (sink, error) {
+	return nil, errors.Errorf("journald log sink not supported on %s platform", runtime.GOOS)
+}
@@ -0,0 +1,217 @@
+// Syslog over the network which works on all platforms
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+// syslogFacilityMap maps the facility names onto their numbers
+var syslogFacilityMap = map[string]int{
+	"KERN":     0,
+	"USER":     1,
+	"MAIL":     2,
+	"DAEMON":   3,
+	"AUTH":     4,
+	"SYSLOG":   5,
+	"LPR":      6,
+	"NEWS":     7,
+	"UUCP":     8,
+	"CRON":     9,
+	"AUTHPRIV": 10,
+	"FTP":      11,
+	"LOCAL0":   16,
+	"LOCAL1":   17,
+	"LOCAL2":   18,
+	"LOCAL3":   19,
+	"LOCAL4":   20,
+	"LOCAL5":   21,
+	"LOCAL6":   22,
+	"LOCAL7":   23,
+}
+
+// syslogTag returns the tag to use for syslog messages
+func syslogTag() string {
+	if Opt.SyslogTag != "" {
+		return Opt.SyslogTag
+	}
+	return path.Base(os.Args[0])
+}
+
+// syslogFacility returns the number of the configured syslog facility
+func syslogFacility() (int, error) {
+	facility, ok := syslogFacilityMap[Opt.SyslogFacility]
+	if !ok {
+		return 0, errors.Errorf("unknown syslog facility %q - man syslog for list", Opt.SyslogFacility)
+	}
+	return facility, nil
+}
+
+// parseSyslogAddress parses a --syslog-address of the form
+// [udp://|tcp://]host[:port] returning the network and the address
+// with the port defaulting to 514.
+func parseSyslogAddress(address string) (network, hostPort string, err error) {
+	network = "udp"
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = address[:i], address[i+3:]
+	}
+	if network != "udp" && network != "tcp" {
+		return "", "", errors.Errorf("syslog address %q must be udp:// or tcp://", network+"://"+address)
+	}
+	if address == "" {
+		return "", "", errors.New("no host in syslog address")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "514")
+	}
+	return network, address, nil
+}
+
+// netSyslogWriter sends messages to a syslog server in the same
+// format as the log/syslog package does
+type netSyslogWriter struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	conn     net.Conn // nil if not connected
+	facility int
+	tag      string
+	hostname string
+}
+
+// dialNetSyslog connects to the syslog server at --syslog-address
+func dialNetSyslog() (*netSyslogWriter, error) {
+	network, address, err := parseSyslogAddress(Opt.SyslogAddress)
+	if err != nil {
+		return nil, err
+	}
+	facility, err := syslogFacility()
+	if err != nil {
+		return nil, err
+	}
+	w := &netSyslogWriter{
+		network:  network,
+		address:  address,
+		facility: facility,
+		tag:      syslogTag(),
+	}
+	w.hostname, _ = os.Hostname()
+	err = w.connect()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect to the syslog server - call with the mutex held
+func (w *netSyslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.address, 10*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to syslog server")
+	}
+	w.conn = conn
+	return nil
+}
+
+// write sends text at level to the syslog server, reconnecting once if
+// the send fails.
+func (w *netSyslogWriter) write(level fs.LogLevel, text string) error {
+	// The rclone log levels are the syslog severities
+	priority := w.facility<<3 | int(level)
+	msg := fmt.Sprintf("<%d>%s %s %s[%d]: %s\n", priority, time.Now().Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), strings.TrimSuffix(text, "\n"))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	for try := 0; try < 2; try++ {
+		if w.conn == nil {
+			err = w.connect()
+			if err != nil {
+				continue
+			}
+		}
+		_, err = w.conn.Write([]byte(msg))
+		if err == nil {
+			return nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// Write sends p at NOTICE level so the standard log can be sent to
+// the syslog server
+func (w *netSyslogWriter) Write(p []byte) (n int, err error) {
+	err = w.write(fs.LogLevelNotice, string(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startNetSysLog sends the log to the syslog server at
+// --syslog-address
+func startNetSysLog() bool {
+	format := sinkFormatText
+	if fs.GetConfig(context.Background()).UseJSONLog {
+		format = sinkFormatJSON
+	}
+	s, err := newNetSyslogSink(format)
+	if err != nil {
+		log.Fatalf("Failed to start syslog: %v", err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(s.w)
+	logrus.SetOutput(s.w)
+	fs.LogPrint = func(level fs.LogLevel, text string) {
+		_ = s.w.write(level, text)
+	}
+	setSinks([]sink{s})
+	return true
+}
+
+// netSyslogSink writes logs to a syslog server over the network
+type netSyslogSink struct {
+	w         *netSyslogWriter
+	formatter logrus.Formatter // set if the sink outputs JSON
+}
+
+// newNetSyslogSink connects to the syslog server at --syslog-address
+// returning a netSyslogSink writing in format
+func newNetSyslogSink(format string) (*netSyslogSink, error) {
+	w, err := dialNetSyslog()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start syslog sink")
+	}
+	s := &netSyslogSink{w: w}
+	if format == sinkFormatJSON {
+		s.formatter = newJSONFormatter()
+	}
+	return s, nil
+}
+
+func (s *netSyslogSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if s.formatter != nil {
+		out, err := formatEntry(s.formatter, level, text, fields)
+		if err == nil {
+			_ = s.w.write(level, out)
+			return
+		}
+	}
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	_ = s.w.write(level, text)
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyslogAddress(t *testing.T) {
+	for _, test := range []struct {
+		in          string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{"loghost", "udp", "loghost:514", false},
+		{"loghost:1514", "udp", "loghost:1514", false},
+		{"udp://loghost", "udp", "loghost:514", false},
+		{"tcp://loghost:601", "tcp", "loghost:601", false},
+		{"tcp://[::1]", "tcp", "[::1]:514", false},
+		{"tcp://[::1]:601", "tcp", "[::1]:601", false},
+		{"http://loghost", "", "", true},
+		{"udp://", "", "", true},
+	} {
+		network, address, err := parseSyslogAddress(test.in)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.wantNetwork, network, test.in)
+		assert.Equal(t, test.wantAddress, address, test.in)
+	}
+}
+
+func TestNetSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	oldOpt := Opt
+	defer func() {
+		Opt = oldOpt
+	}()
+	Opt.SyslogAddress = "udp://" + conn.LocalAddr().String()
+	Opt.SyslogFacility = "LOCAL0"
+	Opt.SyslogTag = "rclone-test"
+
+	s, err := newNetSyslogSink(sinkFormatText)
+	require.NoError(t, err)
+	s.print(fs.LogLevelError, "file.txt", "potato", nil)
+
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// LOCAL0 is 16 and ERROR is 3
+	assert.True(t, strings.HasPrefix(msg, "<131>"), msg)
+	assert.Contains(t, msg, " rclone-test[")
+	assert.True(t, strings.HasSuffix(msg, "]: file.txt: potato\n"), msg)
+}
+
+func TestNetSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	oldOpt := Opt
+	defer func() {
+		Opt = oldOpt
+	}()
+	Opt.SyslogAddress = "tcp://" + listener.Addr().String()
+	Opt.SyslogTag = "rclone-test"
+
+	s, err := newNetSyslogSink(sinkFormatJSON)
+	require.NoError(t, err)
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	s.print(fs.LogLevelInfo, nil, "potato", logrus.Fields{"size": 42})
+	s.print(fs.LogLevelDebug, nil, "second", nil)
+
+	in := bufio.NewReader(conn)
+	line, err := in.ReadString('\n')
+	require.NoError(t, err)
+	// DAEMON is 3 and INFO is 6
+	assert.True(t, strings.HasPrefix(line, "<30>"), line)
+	assert.Contains(t, line, `"msg":"potato"`)
+	assert.Contains(t, line, `"size":42`)
+	line, err = in.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "<31>"), line)
+	assert.Contains(t, line, `"msg":"second"`)
+
+	hostname, _ := os.Hostname()
+	assert.Contains(t, line, " "+hostname+" rclone-test[")
+}
@@ -5,71 +5,111 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"log/syslog"
-	"os"
-	"path"
 
+	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
 )
 
-var (
-	syslogFacilityMap = map[string]syslog.Priority{
-		"KERN":     syslog.LOG_KERN,
-		"USER":     syslog.LOG_USER,
-		"MAIL":     syslog.LOG_MAIL,
-		"DAEMON":   syslog.LOG_DAEMON,
-		"AUTH":     syslog.LOG_AUTH,
-		"SYSLOG":   syslog.LOG_SYSLOG,
-		"LPR":      syslog.LOG_LPR,
-		"NEWS":     syslog.LOG_NEWS,
-		"UUCP":     syslog.LOG_UUCP,
-		"CRON":     syslog.LOG_CRON,
-		"AUTHPRIV": syslog.LOG_AUTHPRIV,
-		"FTP":      syslog.LOG_FTP,
-		"LOCAL0":   syslog.LOG_LOCAL0,
-		"LOCAL1":   syslog.LOG_LOCAL1,
-		"LOCAL2":   syslog.LOG_LOCAL2,
-		"LOCAL3":   syslog.LOG_LOCAL3,
-		"LOCAL4":   syslog.LOG_LOCAL4,
-		"LOCAL5":   syslog.LOG_LOCAL5,
-		"LOCAL6":   syslog.LOG_LOCAL6,
-		"LOCAL7":   syslog.LOG_LOCAL7,
+// openSysLog opens the syslog writer with the configured facility
+// and tag
+func openSysLog() (*syslog.Writer, error) {
+	facility, err := syslogFacility()
+	if err != nil {
+		return nil, err
 	}
-)
+	return syslog.New(syslog.LOG_NOTICE|syslog.Priority(facility<<3), syslogTag())
+}
+
+// sysLogPrint writes text to w at the syslog priority of level
+func sysLogPrint(w *syslog.Writer, level fs.LogLevel, text string) {
+	switch level {
+	case fs.LogLevelEmergency:
+		_ = w.Emerg(text)
+	case fs.LogLevelAlert:
+		_ = w.Alert(text)
+	case fs.LogLevelCritical:
+		_ = w.Crit(text)
+	case fs.LogLevelError:
+		_ = w.Err(text)
+	case fs.LogLevelWarning:
+		_ = w.Warning(text)
+	case fs.LogLevelNotice:
+		_ = w.Notice(text)
+	case fs.LogLevelInfo:
+		_ = w.Info(text)
+	case fs.LogLevelDebug:
+		_ = w.Debug(text)
+	}
+}
 
 // Starts syslog
+//
+// The log is sent via a syslogSink so that JSON formatting and the
+// LogValue fields are preserved if --use-json-log is in effect.
 func startSysLog() bool {
-	facility, ok := syslogFacilityMap[Opt.SyslogFacility]
-	if !ok {
-		log.Fatalf("Unknown syslog facility %q - man syslog for list", Opt.SyslogFacility)
+	if Opt.SyslogAddress != "" {
+		return startNetSysLog()
 	}
-	Me := path.Base(os.Args[0])
-	w, err := syslog.New(syslog.LOG_NOTICE|facility, Me)
+	format := sinkFormatText
+	if fs.GetConfig(context.Background()).UseJSONLog {
+		format = sinkFormatJSON
+	}
+	s, err := openSyslogSink(format)
 	if err != nil {
 		log.Fatalf("Failed to start syslog: %v", err)
 	}
 	log.SetFlags(0)
-	log.SetOutput(w)
+	log.SetOutput(s.w)
+	logrus.SetOutput(s.w)
 	fs.LogPrint = func(level fs.LogLevel, text string) {
-		switch level {
-		case fs.LogLevelEmergency:
-			_ = w.Emerg(text)
-		case fs.LogLevelAlert:
-			_ = w.Alert(text)
-		case fs.LogLevelCritical:
-			_ = w.Crit(text)
-		case fs.LogLevelError:
-			_ = w.Err(text)
-		case fs.LogLevelWarning:
-			_ = w.Warning(text)
-		case fs.LogLevelNotice:
-			_ = w.Notice(text)
-		case fs.LogLevelInfo:
-			_ = w.Info(text)
-		case fs.LogLevelDebug:
-			_ = w.Debug(text)
-		}
+		sysLogPrint(s.w, level, text)
 	}
+	setSinks([]sink{s})
 	return true
 }
+
+// syslogSink writes logs to syslog
+type syslogSink struct {
+	w         *syslog.Writer
+	formatter logrus.Formatter // set if the sink outputs JSON
+}
+
+// newSyslogSink makes a sink which writes to syslog in format
+func newSyslogSink(format string) (sink, error) {
+	if Opt.SyslogAddress != "" {
+		return newNetSyslogSink(format)
+	}
+	return openSyslogSink(format)
+}
+
+// openSyslogSink opens syslog returning a syslogSink writing in format
+func openSyslogSink(format string) (*syslogSink, error) {
+	w, err := openSysLog()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start syslog sink")
+	}
+	s := &syslogSink{w: w}
+	if format == sinkFormatJSON {
+		s.formatter = newJSONFormatter()
+	}
+	return s, nil
+}
+
+func (s *syslogSink) print(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+	if s.formatter != nil {
+		out, err := formatEntry(s.formatter, level, text, fields)
+		if err == nil {
+			sysLogPrint(s.w, level, out)
+			return
+		}
+	}
+	if o != nil {
+		text = fmt.Sprintf("%v: %s", o, text)
+	}
+	sysLogPrint(s.w, level, text)
+}
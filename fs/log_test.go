@@ -1,6 +1,26 @@
 package fs
 
-import "github.com/spf13/pflag"
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
 
 // Check it satisfies the interface
 var _ pflag.Value = (*LogLevel)(nil)
+
+func TestLogFieldsErrorCode(t *testing.T) {
+	fields := logFields(nil, []interface{}{errors.Wrap(ErrorObjectNotFound, "failed"), LogValue("size", 1)})
+	assert.Equal(t, logrus.Fields{"errorCode": "ERR_NOT_FOUND", "size": 1}, fields)
+
+	// A LogValue takes precedence
+	fields = logFields(nil, []interface{}{ErrorObjectNotFound, LogValue("errorCode", "")})
+	assert.Equal(t, logrus.Fields{"errorCode": ""}, fields)
+
+	// No error no code
+	fields = logFields(nil, []interface{}{"potato"})
+	assert.Equal(t, logrus.Fields{}, fields)
+}
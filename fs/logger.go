@@ -0,0 +1,136 @@
+// Context scoped loggers
+
+package fs
+
+import (
+	"context"
+	"strings"
+)
+
+// Logger logs with extra fields, such as a request ID, attached to
+// each log message.  The fields appear in the JSON log only.
+//
+// Get one for a context with GetLogger.  All the methods may be
+// called on a nil *Logger which logs without any extra fields.
+type Logger struct {
+	values []LogValueItem
+}
+
+type loggerContextKeyType struct{}
+
+var loggerContextKey = loggerContextKeyType{}
+
+// GetLogger returns the Logger attached to ctx with WithLogger or nil
+// if there isn't one.
+func GetLogger(ctx context.Context) *Logger {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(loggerContextKey).(*Logger)
+	return l
+}
+
+// WithLogger returns a new context with l attached so all the log
+// messages made with GetLogger from it have l's fields.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, l)
+}
+
+// WithLogValue returns a new context with a Logger which has key set
+// to value as well as the fields of the Logger in ctx if any.
+//
+// For example to give all the log messages of a request a request ID
+//
+//     ctx = fs.WithLogValue(ctx, "requestID", id)
+//     ...
+//     fs.GetLogger(ctx).Infof(o, "Processing request")
+func WithLogValue(ctx context.Context, key string, value interface{}) context.Context {
+	return WithLogger(ctx, GetLogger(ctx).With(key, value))
+}
+
+// With returns a new Logger with key set to value as well as the
+// fields of l.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	newL := &Logger{}
+	if l != nil {
+		for _, item := range l.values {
+			if item.key != key {
+				newL.values = append(newL.values, item)
+			}
+		}
+	}
+	newL.values = append(newL.values, LogValue(key, value))
+	return newL
+}
+
+// Value returns the value of key in l or nil if not found
+func (l *Logger) Value(key string) interface{} {
+	if l == nil {
+		return nil
+	}
+	for _, item := range l.values {
+		if item.key == key {
+			return item.value
+		}
+	}
+	return nil
+}
+
+// addFields returns text and args with the fields of l added
+//
+// The Logger methods call LogPrintf themselves with the result so
+// they are the same number of frames from the caller as Debugf and
+// friends, which the file and line numbers in the log rely on.
+func (l *Logger) addFields(text string, args []interface{}) (string, []interface{}) {
+	if l == nil || len(l.values) == 0 {
+		return text, args
+	}
+	// LogValueItems format as "" so need a verb each
+	text += strings.Repeat("%v", len(l.values))
+	newArgs := make([]interface{}, 0, len(args)+len(l.values))
+	newArgs = append(newArgs, args...)
+	for _, item := range l.values {
+		newArgs = append(newArgs, item)
+	}
+	return text, newArgs
+}
+
+// LogLevelPrintf writes logs at the given level with the fields of l
+func (l *Logger) LogLevelPrintf(level LogLevel, o interface{}, text string, args ...interface{}) {
+	if LogLevelFor(o) >= level {
+		text, args = l.addFields(text, args)
+		LogPrintf(level, o, text, args...)
+	}
+}
+
+// Errorf writes error log output with the fields of l, see Errorf
+func (l *Logger) Errorf(o interface{}, text string, args ...interface{}) {
+	if LogLevelFor(o) >= LogLevelError {
+		text, args = l.addFields(text, args)
+		LogPrintf(LogLevelError, o, text, args...)
+	}
+}
+
+// Logf writes log output with the fields of l, see Logf
+func (l *Logger) Logf(o interface{}, text string, args ...interface{}) {
+	if LogLevelFor(o) >= LogLevelNotice {
+		text, args = l.addFields(text, args)
+		LogPrintf(LogLevelNotice, o, text, args...)
+	}
+}
+
+// Infof writes info output with the fields of l, see Infof
+func (l *Logger) Infof(o interface{}, text string, args ...interface{}) {
+	if LogLevelFor(o) >= LogLevelInfo {
+		text, args = l.addFields(text, args)
+		LogPrintf(LogLevelInfo, o, text, args...)
+	}
+}
+
+// Debugf writes debugging output with the fields of l, see Debugf
+func (l *Logger) Debugf(o interface{}, text string, args ...interface{}) {
+	if LogLevelFor(o) >= LogLevelDebug {
+		text, args = l.addFields(text, args)
+		LogPrintf(LogLevelDebug, o, text, args...)
+	}
+}
@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	var (
+		gotText   string
+		gotFields logrus.Fields
+	)
+	oldLogOutput := LogOutput
+	LogOutput = func(level LogLevel, o interface{}, text string, fields logrus.Fields) {
+		gotText, gotFields = text, fields
+	}
+	defer func() {
+		LogOutput = oldLogOutput
+	}()
+
+	// No logger in the context
+	ctx := context.Background()
+	assert.Nil(t, GetLogger(ctx))
+	GetLogger(ctx).Errorf(nil, "hello %s", "potato")
+	assert.Equal(t, "hello potato", gotText)
+	assert.Equal(t, logrus.Fields{}, gotFields)
+
+	// Logger with values
+	ctx = WithLogValue(ctx, "requestID", "abc")
+	ctx2 := WithLogValue(ctx, "jobid", 42)
+	GetLogger(ctx2).Errorf(nil, "hello %s", "potato")
+	assert.Equal(t, "hello potato", gotText)
+	assert.Equal(t, logrus.Fields{"requestID": "abc", "jobid": 42}, gotFields)
+	assert.Equal(t, 42, GetLogger(ctx2).Value("jobid"))
+	assert.Nil(t, GetLogger(ctx2).Value("potato"))
+
+	// Parent is unchanged
+	GetLogger(ctx).Errorf(nil, "hello")
+	assert.Equal(t, logrus.Fields{"requestID": "abc"}, gotFields)
+
+	// Overriding a value
+	ctx = WithLogValue(ctx, "requestID", "def")
+	GetLogger(ctx).Errorf(nil, "hello")
+	assert.Equal(t, logrus.Fields{"requestID": "def"}, gotFields)
+}
+
+// Check the file and line of the caller are logged whichever way the
+// log is written
+func TestLoggerCallDepth(t *testing.T) {
+	var buf bytes.Buffer
+	oldFlags, oldWriter := log.Flags(), log.Writer()
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetFlags(oldFlags)
+		log.SetOutput(oldWriter)
+	}()
+	ctx := WithLogValue(context.Background(), "requestID", "abc")
+
+	Errorf(nil, "one")
+	GetLogger(ctx).Errorf(nil, "two")
+	GetLogger(context.Background()).Errorf(nil, "three")
+	GetLogger(ctx).LogLevelPrintf(LogLevelError, nil, "four")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "logger_test.go:"), line)
+	}
+}
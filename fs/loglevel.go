@@ -0,0 +1,98 @@
+// Per remote log level overrides
+
+package fs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// logLevelOverrides holds the log levels for remotes which override
+// the global log level
+var logLevelOverrides = struct {
+	mu     sync.RWMutex
+	n      int32 // number of overrides, read atomically
+	levels map[string]LogLevel
+}{
+	levels: make(map[string]LogLevel),
+}
+
+// SetLogLevelOverride sets the log level for the messages about the
+// remote called name, e.g. "s3", overriding the global log level.
+func SetLogLevelOverride(name string, level LogLevel) {
+	logLevelOverrides.mu.Lock()
+	logLevelOverrides.levels[name] = level
+	atomic.StoreInt32(&logLevelOverrides.n, int32(len(logLevelOverrides.levels)))
+	logLevelOverrides.mu.Unlock()
+}
+
+// RemoveLogLevelOverride removes the log level override for the
+// remote called name if any.
+func RemoveLogLevelOverride(name string) {
+	logLevelOverrides.mu.Lock()
+	delete(logLevelOverrides.levels, name)
+	atomic.StoreInt32(&logLevelOverrides.n, int32(len(logLevelOverrides.levels)))
+	logLevelOverrides.mu.Unlock()
+}
+
+// LogLevelOverrides returns a copy of the log level overrides by
+// remote name.
+func LogLevelOverrides() map[string]LogLevel {
+	logLevelOverrides.mu.RLock()
+	defer logLevelOverrides.mu.RUnlock()
+	levels := make(map[string]LogLevel, len(logLevelOverrides.levels))
+	for name, level := range logLevelOverrides.levels {
+		levels[name] = level
+	}
+	return levels
+}
+
+// logRemoteName returns the name of the remote o belongs to or "" if
+// it can't be found.
+func logRemoteName(o interface{}) string {
+	switch x := o.(type) {
+	case Info:
+		return x.Name()
+	case ObjectInfo:
+		if f := x.Fs(); f != nil {
+			return f.Name()
+		}
+	}
+	return ""
+}
+
+// LogLevelFor returns the log level in use for the messages about o.
+//
+// This is the global log level unless o belongs to a remote with a
+// log level override.
+func LogLevelFor(o interface{}) LogLevel {
+	level := GetConfig(context.TODO()).LogLevel
+	if atomic.LoadInt32(&logLevelOverrides.n) == 0 || o == nil {
+		return level
+	}
+	name := logRemoteName(o)
+	if name == "" {
+		return level
+	}
+	logLevelOverrides.mu.RLock()
+	override, found := logLevelOverrides.levels[name]
+	logLevelOverrides.mu.RUnlock()
+	if found {
+		return override
+	}
+	return level
+}
+
+// MaxLogLevel returns the most verbose of level and the log level
+// overrides.
+func MaxLogLevel(level LogLevel) LogLevel {
+	logLevelOverrides.mu.RLock()
+	for _, override := range logLevelOverrides.levels {
+		if override > level {
+			level = override
+		}
+	}
+	logLevelOverrides.mu.RUnlock()
+	return level
+}
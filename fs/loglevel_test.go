@@ -0,0 +1,58 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logLevelTestInfo is an Info with just a name for testing
+type logLevelTestInfo struct {
+	Info
+	name string
+}
+
+func (i logLevelTestInfo) Name() string   { return i.name }
+func (i logLevelTestInfo) String() string { return i.name }
+
+func TestLogLevelFor(t *testing.T) {
+	ci := GetConfig(context.Background())
+	oldLogLevel := ci.LogLevel
+	defer func() {
+		ci.LogLevel = oldLogLevel
+		RemoveLogLevelOverride("loud")
+		RemoveLogLevelOverride("quiet")
+	}()
+	ci.LogLevel = LogLevelNotice
+
+	loud := logLevelTestInfo{name: "loud"}
+	quiet := logLevelTestInfo{name: "quiet"}
+	other := logLevelTestInfo{name: "other"}
+
+	assert.Equal(t, LogLevelNotice, LogLevelFor(loud))
+	assert.Equal(t, LogLevelNotice, MaxLogLevel(ci.LogLevel))
+
+	SetLogLevelOverride("loud", LogLevelDebug)
+	SetLogLevelOverride("quiet", LogLevelError)
+	assert.Equal(t, map[string]LogLevel{"loud": LogLevelDebug, "quiet": LogLevelError}, LogLevelOverrides())
+	assert.Equal(t, LogLevelDebug, LogLevelFor(loud))
+	assert.Equal(t, LogLevelError, LogLevelFor(quiet))
+	assert.Equal(t, LogLevelNotice, LogLevelFor(other))
+	assert.Equal(t, LogLevelNotice, LogLevelFor("dir/file.txt"))
+	assert.Equal(t, LogLevelNotice, LogLevelFor(nil))
+	assert.Equal(t, LogLevelDebug, MaxLogLevel(ci.LogLevel))
+
+	var gotText string
+	oldLogPrint := LogPrint
+	defer func() { LogPrint = oldLogPrint }()
+	LogPrint = func(level LogLevel, text string) { gotText = text }
+	Debugf(loud, "heard")
+	assert.Equal(t, "loud: heard", gotText)
+	gotText = ""
+	Logf(quiet, "not heard")
+	assert.Equal(t, "", gotText)
+
+	RemoveLogLevelOverride("loud")
+	assert.Equal(t, LogLevelNotice, LogLevelFor(loud))
+}
@@ -0,0 +1,105 @@
+// Redact secrets from log messages
+
+package fs
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// logRedacted is what secrets are replaced with in the log
+const logRedacted = "XXXX"
+
+// logRedaction is a secret to redact from the log
+type logRedaction struct {
+	re      *regexp.Regexp
+	replace string // replacement text in regexp.Expand format
+}
+
+// logRedactions are the secrets always redacted from the log unless
+// --dump auth is in use.
+var logRedactions = []logRedaction{
+	// Auth headers as dumped by --dump headers
+	{regexp.MustCompile(`(?im)^((?:Proxy-)?Authorization|X-Auth-Token|X-Amz-Security-Token|X-Goog-Api-Key|X-Api-Key|Cookie|Set-Cookie):[ \t]*[^\r\n]+`), "${1}: " + logRedacted},
+	// Bearer and Basic tokens wherever they appear
+	{regexp.MustCompile(`(?i)\b(Bearer|Basic)[ \t]+[A-Za-z0-9\-._~+/]+=*`), "${1} " + logRedacted},
+	// Signatures, credentials and tokens in URL query parameters,
+	// e.g. Azure SAS signatures and S3 presigned URLs
+	{regexp.MustCompile(`(?i)([?&](?:sig|signature|x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential|access_token|refresh_token|id_token|client_secret|api_key|apikey|token|code|password)=)[^&\s"'#]+`), "${1}" + logRedacted},
+	// Tokens and secrets in JSON
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret|password|secret|token)"[ \t]*:[ \t]*")[^"]*(")`), "${1}" + logRedacted + "${2}"},
+	// Passwords in URLs
+	{regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+(@)`), "${1}" + logRedacted + "${2}"},
+}
+
+// logRedactCache caches the compiled regexps for ConfigInfo.LogRedact
+var logRedactCache struct {
+	mu      sync.Mutex
+	key     string
+	regexps []*regexp.Regexp
+}
+
+// logRedactRegexps returns the compiled regexps for patterns.
+//
+// Patterns which don't compile are ignored - they are checked when the
+// flags are parsed.
+func logRedactRegexps(patterns []string) []*regexp.Regexp {
+	if len(patterns) == 0 {
+		return nil
+	}
+	key := strings.Join(patterns, "\x00")
+	logRedactCache.mu.Lock()
+	defer logRedactCache.mu.Unlock()
+	if logRedactCache.key != key || logRedactCache.regexps == nil {
+		regexps := make([]*regexp.Regexp, 0, len(patterns))
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err == nil {
+				regexps = append(regexps, re)
+			}
+		}
+		logRedactCache.key = key
+		logRedactCache.regexps = regexps
+	}
+	return logRedactCache.regexps
+}
+
+// redactLog removes secrets from the log message text using the
+// built-in redactions and those in --log-redact.
+func redactLog(ci *ConfigInfo, text string) string {
+	if ci.Dump&DumpAuth == 0 {
+		for _, redaction := range logRedactions {
+			text = redaction.re.ReplaceAllString(text, redaction.replace)
+		}
+	}
+	for _, re := range logRedactRegexps(ci.LogRedact) {
+		text = redactRegexp(re, text)
+	}
+	return text
+}
+
+// redactRegexp replaces the text matched by re with logRedacted.
+//
+// If re has capture groups then only the text matched by them is
+// replaced so the context can be kept, e.g. `apikey=(\w+)`.
+func redactRegexp(re *regexp.Regexp, text string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(text, logRedacted)
+	}
+	var out strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(text, -1) {
+		for i := 2; i < len(match); i += 2 {
+			start, end := match[i], match[i+1]
+			if start < last || start < 0 {
+				continue
+			}
+			out.WriteString(text[last:start])
+			out.WriteString(logRedacted)
+			last = end
+		}
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
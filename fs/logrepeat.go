@@ -0,0 +1,137 @@
+// Collapse repeated log messages
+
+package fs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// logRepeatSweepInterval is how often expired repeats are looked for
+const logRepeatSweepInterval = time.Second
+
+// logRepeatKey identifies messages which count as repeats of each
+// other.
+//
+// Messages are repeats if they have the same level and object and
+// their formatted text is the same.
+type logRepeatKey struct {
+	level  LogLevel
+	object string
+	text   string
+}
+
+// logRepeatEntry tracks the repeats of a message within an interval
+type logRepeatEntry struct {
+	start    time.Time     // when the first message of this interval was logged
+	interval time.Duration // the repeat interval when it was logged
+	count    int           // number of messages suppressed
+	o        interface{}   // object of the last message suppressed
+}
+
+// logRepeats collapses repeated log messages
+type logRepeats struct {
+	mu      sync.Mutex
+	entries map[logRepeatKey]*logRepeatEntry
+	once    sync.Once
+}
+
+var logRepeat = &logRepeats{
+	entries: make(map[logRepeatKey]*logRepeatEntry),
+}
+
+// logRepeatSummary is a summary of suppressed messages to be logged
+type logRepeatSummary struct {
+	level LogLevel
+	o     interface{}
+	text  string
+}
+
+// summary makes the summary of the messages suppressed in entry
+func (entry *logRepeatEntry) summary(key logRepeatKey) logRepeatSummary {
+	return logRepeatSummary{
+		level: key.level,
+		o:     entry.o,
+		text:  fmt.Sprintf("%s (message repeated %d times in the last %v)", key.text, entry.count, entry.interval),
+	}
+}
+
+// suppress returns true if the message should not be logged as it
+// repeats a message logged within interval.
+//
+// It returns a summary of the messages suppressed in the previous
+// interval if there are any to log first.
+func (r *logRepeats) suppress(level LogLevel, o interface{}, out string, interval time.Duration) (suppressed bool, summary *logRepeatSummary) {
+	r.once.Do(func() {
+		go r.sweeper()
+	})
+	key := logRepeatKey{level: level, text: out}
+	if o != nil {
+		key.object = fmt.Sprint(o)
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, found := r.entries[key]
+	if found && now.Sub(entry.start) < interval {
+		entry.count++
+		entry.o = o
+		return true, nil
+	}
+	if found && entry.count > 0 {
+		s := entry.summary(key)
+		summary = &s
+	}
+	r.entries[key] = &logRepeatEntry{start: now, interval: interval}
+	return false, summary
+}
+
+// sweep removes expired entries returning summaries of any suppressed
+// messages.
+//
+// Entries older than their interval or interval, or all of them if it
+// is 0, have expired.
+func (r *logRepeats) sweep(interval time.Duration) (summaries []logRepeatSummary) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, entry := range r.entries {
+		expire := entry.interval
+		if interval < expire {
+			expire = interval
+			if interval > 0 {
+				entry.interval = interval
+			}
+		}
+		if now.Sub(entry.start) < expire {
+			continue
+		}
+		if entry.count > 0 {
+			summaries = append(summaries, entry.summary(key))
+		}
+		delete(r.entries, key)
+	}
+	return summaries
+}
+
+// sweeper logs the summaries of suppressed messages once their
+// interval has passed so they appear even if the message isn't logged
+// again.
+//
+// The interval is read from the config each time so changes to it,
+// for example from the rc, take effect.
+func (r *logRepeats) sweeper() {
+	ticker := time.NewTicker(logRepeatSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		interval := GetConfig(context.TODO()).LogRepeatInterval
+		if interval < 0 {
+			interval = 0
+		}
+		for _, summary := range r.sweep(interval) {
+			logOutput(summary.level, summary.o, summary.text, nil)
+		}
+	}
+}
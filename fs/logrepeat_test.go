@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRepeatSuppress(t *testing.T) {
+	r := &logRepeats{
+		entries: make(map[logRepeatKey]*logRepeatEntry),
+	}
+	// don't start the sweeper
+	r.once.Do(func() {})
+	const interval = time.Hour
+
+	suppressed, summary := r.suppress(LogLevelDebug, nil, "retry", interval)
+	assert.False(t, suppressed)
+	assert.Nil(t, summary)
+
+	suppressed, summary = r.suppress(LogLevelDebug, nil, "retry", interval)
+	assert.True(t, suppressed)
+	assert.Nil(t, summary)
+
+	suppressed, _ = r.suppress(LogLevelDebug, nil, "retry", interval)
+	assert.True(t, suppressed)
+
+	// Different level, object or text aren't repeats
+	suppressed, _ = r.suppress(LogLevelInfo, nil, "retry", interval)
+	assert.False(t, suppressed)
+	suppressed, _ = r.suppress(LogLevelDebug, "potato", "retry", interval)
+	assert.False(t, suppressed)
+	suppressed, _ = r.suppress(LogLevelDebug, nil, "other", interval)
+	assert.False(t, suppressed)
+
+	// Messages made with the same format but different arguments
+	// aren't repeats either
+	suppressed, _ = r.suppress(LogLevelError, nil, "failed: file one", interval)
+	assert.False(t, suppressed)
+	suppressed, _ = r.suppress(LogLevelError, nil, "failed: file two", interval)
+	assert.False(t, suppressed)
+
+	// Nothing has expired yet
+	assert.Equal(t, 0, len(r.sweep(interval)))
+
+	// Expire the interval and check the next message gets a summary
+	key := logRepeatKey{level: LogLevelDebug, text: "retry"}
+	r.entries[key].start = time.Now().Add(-2 * interval)
+	suppressed, summary = r.suppress(LogLevelDebug, nil, "retry", interval)
+	assert.False(t, suppressed)
+	require.NotNil(t, summary)
+	assert.Equal(t, LogLevelDebug, summary.level)
+	assert.Equal(t, "retry (message repeated 2 times in the last 1h0m0s)", summary.text)
+
+	// Check the sweep finds suppressed messages and removes expired entries
+	suppressed, _ = r.suppress(LogLevelDebug, nil, "retry", interval)
+	assert.True(t, suppressed)
+	for _, entry := range r.entries {
+		entry.start = time.Now().Add(-2 * interval)
+	}
+	summaries := r.sweep(interval)
+	require.Equal(t, 1, len(summaries))
+	assert.Equal(t, "retry (message repeated 1 times in the last 1h0m0s)", summaries[0].text)
+	assert.Equal(t, 0, len(r.entries))
+
+	// A shorter interval than the one logged with expires entries sooner
+	r.suppress(LogLevelDebug, nil, "retry", interval)
+	r.suppress(LogLevelDebug, nil, "retry", interval)
+	r.entries[key].start = time.Now().Add(-time.Minute)
+	assert.Equal(t, 0, len(r.sweep(interval)))
+	summaries = r.sweep(time.Second)
+	require.Equal(t, 1, len(summaries))
+	assert.Equal(t, "retry (message repeated 1 times in the last 1s)", summaries[0].text)
+
+	// An interval of 0 expires everything
+	r.suppress(LogLevelDebug, nil, "retry", interval)
+	r.suppress(LogLevelDebug, nil, "retry", interval)
+	summaries = r.sweep(0)
+	require.Equal(t, 1, len(summaries))
+	assert.Equal(t, "retry (message repeated 1 times in the last 1h0m0s)", summaries[0].text)
+	assert.Equal(t, 0, len(r.entries))
+}
@@ -32,12 +32,17 @@ type March struct {
 	Callback               Marcher         // object to call with results
 	NoCheckDest            bool            // transfer all objects regardless without checking dst
 	NoUnicodeNormalization bool            // don't normalize unicode characters in filenames
+	DstTransform           DirTransformFn  // if set, transform each listing of the destination
 	// internal state
 	srcListDir listDirFn // function to call to list a directory in the src
 	dstListDir listDirFn // function to call to list a directory in the dst
 	transforms []matchTransformFn
 }
 
+// DirTransformFn is called with each directory listing and returns
+// the entries to march over instead
+type DirTransformFn func(entries fs.DirEntries) fs.DirEntries
+
 // Marcher is called on each match
 type Marcher interface {
 	// SrcOnly is called for a DirEntry found only in the source
@@ -54,6 +59,16 @@ func (m *March) init(ctx context.Context) {
 	m.srcListDir = m.makeListDir(ctx, m.Fsrc, m.SrcIncludeAll)
 	if !m.NoTraverse {
 		m.dstListDir = m.makeListDir(ctx, m.Fdst, m.DstIncludeAll)
+		if m.DstTransform != nil {
+			listDir := m.dstListDir
+			m.dstListDir = func(dir string) (entries fs.DirEntries, err error) {
+				entries, err = listDir(dir)
+				if err != nil {
+					return nil, err
+				}
+				return m.DstTransform(entries), nil
+			}
+		}
 	}
 	// Now create the matching transform
 	// ..normalise the UTF8 first
@@ -146,9 +161,9 @@ func (m *March) Run(ctx context.Context) error {
 	// Start some directory listing go routines
 	var wg sync.WaitGroup         // sync closing of go routines
 	var traversing sync.WaitGroup // running directory traversals
-	checkers := ci.Checkers
-	in := make(chan listDirJob, checkers)
-	for i := 0; i < checkers; i++ {
+	listWorkers := ci.ListWorkerCount()
+	in := make(chan listDirJob, listWorkers)
+	for i := 0; i < listWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -0,0 +1,59 @@
+package fs
+
+import (
+	"context"
+	"strings"
+)
+
+// Metadata is the metadata of a directory or object as key value
+// pairs, as read and written by the DirMetadata and DirSetMetadata
+// features and the Metadataer and SetMetadataer interfaces.
+//
+// These keys are used where the backend supports them
+//
+//   mtime - the modification time in RFC 3339 format
+//   mode  - the permissions in octal, eg "755"
+//   uid   - the numeric user ID of the owner
+//   gid   - the numeric group ID of the owner
+//
+// and extended attributes are stored under their own names, eg
+// "user.comment". Backends with user metadata, eg S3, return it as
+// extended attributes.
+type Metadata map[string]string
+
+// XattrPrefix is the prefix of the keys in Metadata which are
+// extended attributes
+const XattrPrefix = "user."
+
+// Equal returns true if m and other have the same keys and values
+func (m Metadata) Equal(other Metadata) bool {
+	if len(m) != len(other) {
+		return false
+	}
+	for k, v := range m {
+		if otherV, ok := other[k]; !ok || otherV != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Xattrs returns the extended attributes in m
+func (m Metadata) Xattrs() Metadata {
+	xattrs := Metadata{}
+	for k, v := range m {
+		if strings.HasPrefix(k, XattrPrefix) {
+			xattrs[k] = v
+		}
+	}
+	return xattrs
+}
+
+// GetMetadata returns the metadata of o or nil if it doesn't support
+// it
+func GetMetadata(ctx context.Context, o ObjectInfo) (Metadata, error) {
+	if do, ok := o.(Metadataer); ok {
+		return do.Metadata(ctx)
+	}
+	return nil, nil
+}
@@ -0,0 +1,63 @@
+// Package metrics serves the accounting stats as Prometheus metrics
+// so they can be scraped from any rclone command.
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+)
+
+// Options contains options for controlling the metrics
+type Options struct {
+	Addr string // serve the metrics on http://Addr/metrics
+}
+
+// DefaultOpt is the default values used for Opt
+var DefaultOpt = Options{}
+
+// Opt is the options for the metrics
+var Opt = DefaultOpt
+
+var handler http.Handler
+
+func init() {
+	rcloneCollector := accounting.NewRcloneCollector(context.Background())
+	prometheus.MustRegister(rcloneCollector)
+	handler = promhttp.Handler()
+}
+
+// Handler returns the handler which serves the metrics
+func Handler() http.Handler {
+	return handler
+}
+
+// Start serving the metrics on Opt.Addr if set
+func Start(ctx context.Context) error {
+	if Opt.Addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", Opt.Addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to start metrics server")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{
+		Handler: mux,
+	}
+	fs.Logf(nil, "Serving metrics on http://%s/metrics", listener.Addr())
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			fs.Errorf(nil, "Metrics server failed: %v", err)
+		}
+	}()
+	return nil
+}
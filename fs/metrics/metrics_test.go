@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	fs.CountCall(ctx, "metrics", "PUT", false)
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "rclone_bytes_transferred_total")
+	assert.Contains(t, string(body), `rclone_backend_calls_total{operation="PUT",remote="metrics"} 1`)
+}
+
+func TestStartDisabled(t *testing.T) {
+	assert.NoError(t, Start(context.Background()))
+}
@@ -0,0 +1,15 @@
+// Package metricsflags implements command line flags to set up the metrics
+package metricsflags
+
+import (
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/metrics"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the metrics flags to the flagSet
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("metrics", &metrics.Opt)
+	flags.StringVarP(flagSet, &metrics.Opt.Addr, "metrics-addr", "", metrics.Opt.Addr, "Serve Prometheus metrics on http://IPaddress:Port/metrics, e.g. localhost:9090")
+}
@@ -0,0 +1,180 @@
+// Package notify tells the user when a command has finished by
+// calling a webhook or running a local command with a summary of what
+// was done.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+)
+
+// Options contains options for the notifications
+type Options struct {
+	FinishWebhook string          // POST the summary to this URL when the command finishes
+	ErrorCommand  fs.SpaceSepList // Run this with the summary on stdin if the command fails
+}
+
+// DefaultOpt is the default values used for Opt
+var DefaultOpt = Options{}
+
+// Opt is the options for the notifications
+var Opt = DefaultOpt
+
+const (
+	topErrors      = 10          // number of the most frequent errors in the summary
+	webhookTimeout = time.Minute // timeout for each webhook request
+	webhookRetries = 3           // number of tries for the webhook
+	webhookSleep   = time.Second // time to sleep between tries
+)
+
+// Summary is what the command did, sent as JSON to the webhook and
+// the command.
+type Summary struct {
+	Command   string                  `json:"command"`             // name of the command, e.g. "sync"
+	Success   bool                    `json:"success"`             // true if the command succeeded
+	Error     string                  `json:"error,omitempty"`     // the error the command failed with
+	ErrorCode string                  `json:"errorCode,omitempty"` // code for the class of the error, e.g. "ERR_AUTH"
+	Bytes     int64                   `json:"bytes"`               // bytes transferred
+	Transfers int64                   `json:"transfers"`           // files transferred
+	Checks    int64                   `json:"checks"`              // files checked
+	Deletes   int64                   `json:"deletes"`             // files deleted
+	Errors    int64                   `json:"errors"`              // number of errors
+	StartTime time.Time               `json:"startTime"`           // when the command started
+	EndTime   time.Time               `json:"endTime"`             // when the command finished
+	Duration  float64                 `json:"duration"`            // how long the command took in seconds
+	TopErrors []accounting.ErrorCount `json:"topErrors"`           // the most frequent errors, most frequent first
+}
+
+// NewSummary makes a Summary of the command called name which started
+// at startTime and finished now with err from the global stats.
+func NewSummary(name string, startTime time.Time, err error) *Summary {
+	stats := accounting.GlobalStats()
+	endTime := time.Now()
+	s := &Summary{
+		Command:   name,
+		Success:   err == nil,
+		Bytes:     stats.GetBytes(),
+		Transfers: stats.GetTransfers(),
+		Checks:    stats.GetChecks(),
+		Deletes:   stats.GetDeletes(),
+		Errors:    stats.GetErrors(),
+		StartTime: startTime,
+		EndTime:   endTime,
+		Duration:  endTime.Sub(startTime).Seconds(),
+		TopErrors: stats.TopErrors(topErrors),
+	}
+	if err != nil {
+		s.Error = err.Error()
+		s.ErrorCode = string(fserrors.ErrorCode(err))
+	}
+	return s
+}
+
+// Enabled returns true if any notifications are configured
+func Enabled() bool {
+	return Opt.FinishWebhook != "" || len(Opt.ErrorCommand) != 0
+}
+
+// Finished sends the notifications configured for the command
+// finishing as described by s.
+//
+// Failures are logged but otherwise ignored so they don't change the
+// outcome of the command.
+func Finished(ctx context.Context, s *Summary) {
+	if !Enabled() {
+		return
+	}
+	body, err := json.Marshal(s)
+	if err != nil {
+		fs.Errorf(nil, "Failed to make notification summary: %v", err)
+		return
+	}
+	if Opt.FinishWebhook != "" {
+		err = postWebhook(ctx, Opt.FinishWebhook, body)
+		if err != nil {
+			fs.Errorf(nil, "Failed to call --on-finish-webhook: %v", err)
+		} else {
+			fs.Debugf(nil, "Called --on-finish-webhook")
+		}
+	}
+	if len(Opt.ErrorCommand) != 0 && !s.Success {
+		err = runCommand(ctx, Opt.ErrorCommand, body)
+		if err != nil {
+			fs.Errorf(nil, "Failed to run --on-error-command: %v", err)
+		} else {
+			fs.Debugf(nil, "Ran --on-error-command")
+		}
+	}
+}
+
+// postWebhook POSTs body to url retrying if necessary
+func postWebhook(ctx context.Context, url string, body []byte) (err error) {
+	client := fshttp.NewClient(ctx)
+	for try := 1; try <= webhookRetries; try++ {
+		var retry bool
+		retry, err = post(ctx, client, url, body)
+		if err == nil || !retry {
+			break
+		}
+		if try < webhookRetries {
+			fs.Debugf(nil, "Retrying --on-finish-webhook %d/%d after error: %v", try, webhookRetries, err)
+			time.Sleep(webhookSleep)
+		}
+	}
+	return err
+}
+
+// post body to url returning whether it is worth retrying if there
+// was an error.
+func post(ctx context.Context, client *http.Client, url string, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.Errorf("HTTP error %s", resp.Status)
+}
+
+// runCommand runs the command in args with body on its stdin
+func runCommand(ctx context.Context, args []string, body []byte) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		if output != "" {
+			return errors.Wrapf(err, "%s", output)
+		}
+		return err
+	}
+	if output != "" {
+		fs.Debugf(nil, "--on-error-command output: %s", output)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSummary(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	s := NewSummary("sync", start, nil)
+	assert.Equal(t, "sync", s.Command)
+	assert.True(t, s.Success)
+	assert.Equal(t, "", s.Error)
+	assert.True(t, s.Duration >= 60)
+
+	s = NewSummary("copy", start, fs.ErrorObjectNotFound)
+	assert.False(t, s.Success)
+	assert.Equal(t, "object not found", s.Error)
+	assert.Equal(t, "ERR_NOT_FOUND", s.ErrorCode)
+}
+
+func TestFinishedWebhook(t *testing.T) {
+	oldOpt := Opt
+	defer func() {
+		Opt = oldOpt
+	}()
+
+	var (
+		got   Summary
+		tries int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if tries == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer ts.Close()
+
+	Opt = Options{FinishWebhook: ts.URL}
+	Finished(context.Background(), &Summary{Command: "sync", Success: true, Bytes: 42})
+	assert.Equal(t, 2, tries)
+	assert.Equal(t, "sync", got.Command)
+	assert.Equal(t, int64(42), got.Bytes)
+}
+
+func TestFinishedErrorCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	oldOpt := Opt
+	defer func() {
+		Opt = oldOpt
+	}()
+	dir, err := ioutil.TempDir("", "rclone-notify-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	out := filepath.Join(dir, "summary.json")
+
+	Opt = Options{ErrorCommand: fs.SpaceSepList{"sh", "-c", "cat > " + out}}
+
+	// Not run if the command succeeded
+	Finished(context.Background(), &Summary{Command: "sync", Success: true})
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+
+	Finished(context.Background(), NewSummary("sync", time.Now(), errors.New("boom")))
+	data, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	var got Summary
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.False(t, got.Success)
+	assert.Equal(t, "boom", got.Error)
+}
@@ -0,0 +1,16 @@
+// Package notifyflags implements command line flags to set up the notifications
+package notifyflags
+
+import (
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/notify"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the notification flags to the flagSet
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("notify", &notify.Opt)
+	flags.StringVarP(flagSet, &notify.Opt.FinishWebhook, "on-finish-webhook", "", notify.Opt.FinishWebhook, "POST a JSON summary to this URL when the command finishes")
+	flags.FVarP(flagSet, &notify.Opt.ErrorCommand, "on-error-command", "", "Run this command with a JSON summary on stdin if the command fails")
+}
@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 
 	"github.com/pkg/errors"
+	"github.com/rclone/rclone/backend/crypt"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/fserrors"
@@ -262,7 +263,7 @@ func Check(ctx context.Context, opt *CheckOpt) error {
 			return true, false, err
 		}
 		if ht == hash.None {
-			return false, true, nil
+			return checkCryptHMAC(ctx, dst, src)
 		}
 		if !same {
 			err = errors.Errorf("%v differ", ht)
@@ -275,6 +276,30 @@ func Check(ctx context.Context, opt *CheckOpt) error {
 	return CheckFn(ctx, &optCopy)
 }
 
+// checkCryptHMAC checks dst and src against each other with the HMAC
+// stored in one of them if it is in a crypt remote and has one
+func checkCryptHMAC(ctx context.Context, dst, src fs.Object) (differ bool, noHash bool, err error) {
+	o, other := dst, src
+	cryptObject, ok := o.(*crypt.Object)
+	if !ok {
+		o, other = src, dst
+		cryptObject, ok = o.(*crypt.Object)
+		if !ok {
+			return false, true, nil
+		}
+	}
+	differ, noHash, err = cryptObject.CheckHMAC(ctx, other)
+	if err != nil {
+		return true, false, err
+	}
+	if differ {
+		err = errors.New("HMAC differs")
+		fs.Errorf(src, "%v", err)
+		return true, false, nil
+	}
+	return false, noHash, nil
+}
+
 // CheckEqualReaders checks to see if in1 and in2 have the same
 // content when read.
 //
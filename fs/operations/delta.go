@@ -0,0 +1,127 @@
+package operations
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/lib/delta"
+)
+
+// Return a boolean as to whether we should use a delta transfer to
+// update dst
+func doDeltaCopy(ctx context.Context, f fs.Fs, dst fs.Object, src fs.Object) bool {
+	ci := fs.GetConfig(ctx)
+
+	// Disable delta transfers if...
+
+	// ...they aren't configured
+	if !ci.DeltaTransfers {
+		return false
+	}
+	// ...there isn't an existing object to update
+	if dst == nil || dst.Size() <= 0 {
+		return false
+	}
+	// ...size of source isn't known
+	if src.Size() < 0 {
+		return false
+	}
+	// ...destination doesn't support updating objects in place
+	if f.Features().UpdateWriterAt == nil {
+		return false
+	}
+	return true
+}
+
+// Update dst at (f, remote) to be the same as src by only writing
+// the blocks of src which aren't already in place in dst.
+//
+// This reads all of dst to work out the checksums of its blocks then
+// all of src to find the blocks which differ using the rsync rolling
+// checksum algorithm.  Note that dst is updated in place so will be
+// corrupted if the transfer fails.
+func deltaCopy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Object, tr *accounting.Transfer) (newDst fs.Object, err error) {
+	ci := fs.GetConfig(ctx)
+	updateWriterAt := f.Features().UpdateWriterAt
+	if updateWriterAt == nil {
+		return nil, errors.New("delta copy: UpdateWriterAt not supported")
+	}
+	if src.Size() < 0 {
+		return nil, errors.New("delta copy: can't copy unknown sized file")
+	}
+
+	// Checksum the blocks of the destination
+	blockSize := delta.BlockSize(dst.Size())
+	fs.Debugf(src, "Starting delta copy with block size %v", fs.SizeSuffix(blockSize))
+	dstIn, err := NewReOpen(ctx, dst, ci.LowLevelRetries)
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy: failed to open destination")
+	}
+	sig, err := delta.NewSignature(dstIn, blockSize)
+	closeErr := dstIn.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy: failed to read destination")
+	}
+
+	// Open the source and the destination for writing
+	srcIn, err := NewReOpen(ctx, src, ci.LowLevelRetries)
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy: failed to open source")
+	}
+	in := tr.Account(ctx, srcIn).WithBuffer()
+	defer func() {
+		closeErr := in.Close()
+		if err == nil && closeErr != nil {
+			newDst, err = nil, errors.Wrap(closeErr, "delta copy: failed to close source")
+		}
+	}()
+	wc, err := updateWriterAt(ctx, remote, src.Size())
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy: failed to open destination for writing")
+	}
+
+	// Write the blocks which aren't in place
+	var written int64
+	err = sig.Diff(in, func(op delta.Op) error {
+		if sig.InPlace(op) {
+			return nil
+		}
+		n, err := wc.WriteAt(op.Data, op.Offset)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+		if n != len(op.Data) {
+			return io.ErrShortWrite
+		}
+		return nil
+	})
+	closeErr = wc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy")
+	}
+	if closeErr != nil {
+		return nil, errors.Wrap(closeErr, "delta copy: failed to close object after copy")
+	}
+
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "delta copy: failed to find object after copy")
+	}
+
+	err = obj.SetModTime(ctx, src.ModTime(ctx))
+	switch err {
+	case nil, fs.ErrorCantSetModTime, fs.ErrorCantSetModTimeWithoutDelete:
+	default:
+		return nil, errors.Wrap(err, "delta copy: failed to set modification time")
+	}
+
+	fs.Debugf(src, "Finished delta copy: wrote %v of %v", fs.SizeSuffix(written), fs.SizeSuffix(src.Size()))
+	return obj, nil
+}
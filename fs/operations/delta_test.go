@@ -0,0 +1,90 @@
+package operations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoDeltaCopy(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	f := mockfs.NewFs(ctx, "potato", "")
+	src := mockobject.New("file.txt").WithContent([]byte(random.String(100)), mockobject.SeekModeNone)
+	dst := mockobject.New("file.txt").WithContent([]byte(random.String(100)), mockobject.SeekModeNone)
+
+	oldDeltaTransfers := ci.DeltaTransfers
+	defer func() {
+		ci.DeltaTransfers = oldDeltaTransfers
+	}()
+
+	ci.DeltaTransfers = false
+	assert.False(t, doDeltaCopy(ctx, f, dst, src))
+
+	ci.DeltaTransfers = true
+	assert.False(t, doDeltaCopy(ctx, f, dst, src))
+	assert.False(t, doDeltaCopy(ctx, f, nil, src))
+
+	f.Features().UpdateWriterAt = func(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+		return nil, nil
+	}
+	assert.True(t, doDeltaCopy(ctx, f, dst, src))
+	assert.False(t, doDeltaCopy(ctx, f, nil, src))
+
+	empty := mockobject.New("file.txt").WithContent(nil, mockobject.SeekModeNone)
+	assert.False(t, doDeltaCopy(ctx, f, empty, src))
+}
+
+func TestDeltaCopy(t *testing.T) {
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	ctx := context.Background()
+	if r.Flocal.Features().UpdateWriterAt == nil {
+		t.Skip("UpdateWriterAt not supported")
+	}
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 := fstest.Time("2011-12-25T12:59:59.123456789Z")
+	old := random.String(100000)
+
+	for _, test := range []struct {
+		name     string
+		contents string
+	}{
+		{name: "same", contents: old},
+		{name: "changed", contents: old[:50000] + "potato" + old[50006:]},
+		{name: "longer", contents: old + "potato"},
+		{name: "shorter", contents: old[:60000]},
+		{name: "inserted", contents: "potato" + old},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			r.WriteFile("file1", old, t1)
+			file2 := r.WriteObject(ctx, "file1", test.contents, t2)
+
+			src, err := r.Fremote.NewObject(ctx, "file1")
+			require.NoError(t, err)
+			dst, err := r.Flocal.NewObject(ctx, "file1")
+			require.NoError(t, err)
+			accounting.GlobalStats().ResetCounters()
+			tr := accounting.GlobalStats().NewTransfer(src)
+			defer func() {
+				tr.Done(ctx, err)
+			}()
+			newDst, err := deltaCopy(ctx, r.Flocal, dst, "file1", src, tr)
+			require.NoError(t, err)
+			assert.Equal(t, src.Size(), newDst.Size())
+			assert.Equal(t, "file1", newDst.Remote())
+
+			fstest.CheckListingWithPrecision(t, r.Flocal, []fstest.Item{file2}, nil, fs.GetModifyWindow(ctx, r.Flocal, r.Fremote))
+			require.NoError(t, newDst.Remove(ctx))
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Transfer lifecycle events
+
+package operations
+
+import (
+	"context"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Transfer lifecycle events.
+//
+// These are logged with a stable set of fields, see transferEvent,
+// so that they can be parsed from the JSON log.
+const (
+	EventTransferStarted   = "transfer_started"
+	EventTransferCompleted = "transfer_completed"
+	EventTransferFailed    = "transfer_failed"
+	EventChecksumMismatch  = "checksum_mismatch"
+)
+
+// transferEvent collects the information logged with the lifecycle
+// events of a single transfer.
+type transferEvent struct {
+	logger   *fs.Logger
+	tr       *accounting.Transfer
+	size     int64     // size of the source object
+	start    time.Time // when the transfer started
+	hashType hash.Type // type of the hashes if known
+	srcHash  string    // hash of the source if known
+	dstHash  string    // hash of the destination if known
+	retries  int       // number of low level retries
+}
+
+// newTransferEvent starts collecting the lifecycle event information
+// for tr copying src logging with the logger in ctx.
+func newTransferEvent(ctx context.Context, tr *accounting.Transfer, src fs.ObjectInfo) *transferEvent {
+	return &transferEvent{
+		logger: fs.GetLogger(ctx),
+		tr:     tr,
+		size:   src.Size(),
+		start:  time.Now(),
+	}
+}
+
+// logf logs text and args about o at level and attaches the fields
+// for event to the JSON log.
+//
+// Every event has all the fields so that consumers can rely on them
+// being present.
+func (e *transferEvent) logf(level fs.LogLevel, o interface{}, event string, err error, text string, args ...interface{}) {
+	errString := ""
+	if err != nil {
+		errString = err.Error()
+	}
+	hashType := ""
+	if e.hashType != hash.None {
+		hashType = e.hashType.String()
+	}
+	args = append(args,
+		fs.LogValue("event", event),
+		fs.LogValue("size", e.size),
+		fs.LogValue("bytes", e.tr.Snapshot().Bytes),
+		fs.LogValue("duration", time.Since(e.start).Seconds()),
+		fs.LogValue("hashType", hashType),
+		fs.LogValue("hash", e.srcHash),
+		fs.LogValue("dstHash", e.dstHash),
+		fs.LogValue("retries", e.retries),
+		fs.LogValue("error", errString),
+		fs.LogValue("errorCode", string(fserrors.ErrorCode(err))),
+	)
+	e.logger.LogLevelPrintf(level, o, text+"%v%v%v%v%v%v%v%v%v%v", args...)
+}
@@ -0,0 +1,59 @@
+// Internal tests for transfer lifecycle events
+
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferEventLogf(t *testing.T) {
+	ctx := fs.WithLogValue(context.Background(), "requestID", "abc123")
+	var (
+		gotLevel  fs.LogLevel
+		gotText   string
+		gotFields logrus.Fields
+	)
+	oldLogOutput := fs.LogOutput
+	fs.LogOutput = func(level fs.LogLevel, o interface{}, text string, fields logrus.Fields) {
+		gotLevel, gotText, gotFields = level, text, fields
+	}
+	defer func() {
+		fs.LogOutput = oldLogOutput
+	}()
+
+	src := object.NewMemoryObject("potato", time.Now(), []byte("hello"))
+	tr := accounting.Stats(ctx).NewTransfer(src)
+	defer tr.Done(ctx, nil)
+	event := newTransferEvent(ctx, tr, src)
+	event.hashType = hash.MD5
+	event.srcHash = "5d41402abc4b2a76b9719d911017c592"
+	event.dstHash = "00000000000000000000000000000000"
+	event.retries = 2
+
+	event.logf(fs.LogLevelError, src, EventChecksumMismatch, errors.New("hash differ"), "corrupted: %s", "potato")
+
+	assert.Equal(t, fs.LogLevelError, gotLevel)
+	assert.Equal(t, "corrupted: potato", gotText)
+	require.NotNil(t, gotFields)
+	assert.Equal(t, EventChecksumMismatch, gotFields["event"])
+	assert.Equal(t, int64(5), gotFields["size"])
+	assert.Equal(t, int64(0), gotFields["bytes"])
+	assert.IsType(t, float64(0), gotFields["duration"])
+	assert.Equal(t, "MD5", gotFields["hashType"])
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", gotFields["hash"])
+	assert.Equal(t, "00000000000000000000000000000000", gotFields["dstHash"])
+	assert.Equal(t, 2, gotFields["retries"])
+	assert.Equal(t, "hash differ", gotFields["error"])
+	assert.Equal(t, "abc123", gotFields["requestID"])
+}
@@ -0,0 +1,214 @@
+// Copy one source object to several destinations reading it once
+
+package operations
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// errFanOutFailed is returned when every destination of a fan out
+// copy has stopped reading
+var errFanOutFailed = errors.New("all destinations failed")
+
+// fanOutWriter writes to each of its writers in parallel, dropping
+// any which return an error so the others can carry on.
+type fanOutWriter struct {
+	ws []*io.PipeWriter
+}
+
+// Write p to all the writers still working
+func (w *fanOutWriter) Write(p []byte) (n int, err error) {
+	var wg sync.WaitGroup
+	for i := range w.ws {
+		if w.ws[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.ws[i].Write(p); err != nil {
+				w.ws[i] = nil
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, pw := range w.ws {
+		if pw != nil {
+			return len(p), nil
+		}
+	}
+	return 0, errFanOutFailed
+}
+
+// CopyFanOut copies src to each of fdsts reading src only once.
+//
+// dsts are the existing objects in each of fdsts to be updated, with
+// nil where there isn't one. src is stored under src.Remote() in
+// each destination.
+//
+// It returns the new object and the error for each destination, so
+// a failure to upload to one destination doesn't stop the others.
+//
+// Destinations which can copy src server-side, and all destinations
+// when the size of src isn't known, are copied with Copy instead.
+func CopyFanOut(ctx context.Context, fdsts []fs.Fs, dsts []fs.Object, src fs.Object) (newDsts []fs.Object, errs []error) {
+	newDsts = make([]fs.Object, len(fdsts))
+	errs = make([]error, len(fdsts))
+	var (
+		wg     sync.WaitGroup
+		shared []int
+	)
+	for i, f := range fdsts {
+		if len(fdsts) > 1 && src.Size() >= 0 && !(f.Features().Copy != nil && ServerSideCompatible(ctx, f, src.Fs())) {
+			shared = append(shared, i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, f fs.Fs) {
+			defer wg.Done()
+			newDsts[i], errs[i] = Copy(ctx, f, dsts[i], src.Remote(), src)
+		}(i, f)
+	}
+	if len(shared) == 1 {
+		i := shared[0]
+		newDsts[i], errs[i] = Copy(ctx, fdsts[i], dsts[i], src.Remote(), src)
+	} else if len(shared) > 1 {
+		copyShared(ctx, fdsts, dsts, src, shared, newDsts, errs)
+	}
+	wg.Wait()
+	return newDsts, errs
+}
+
+// copyShared uploads src to the destinations indexed by shared from
+// a single read of src, filling in newDsts and errs for them.
+func copyShared(ctx context.Context, fdsts []fs.Fs, dsts []fs.Object, src fs.Object, shared []int, newDsts []fs.Object, errs []error) {
+	ci := fs.GetConfig(ctx)
+	for _, i := range shared {
+		newDsts[i] = dsts[i]
+	}
+	if SkipDestructive(ctx, src, "copy") {
+		return
+	}
+	tr := accounting.Stats(ctx).NewTransfer(src)
+	var err error
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	var options []fs.OpenOption
+	for _, option := range ci.DownloadHeaders {
+		options = append(options, option)
+	}
+	in0, err := NewReOpen(ctx, src, ci.LowLevelRetries, options...)
+	if err != nil {
+		err = fs.CountError(errors.Wrap(err, "failed to open source object"))
+		fs.GetLogger(ctx).Errorf(src, "Failed to copy: %v", err)
+		for _, i := range shared {
+			errs[i] = err
+		}
+		return
+	}
+	in := tr.Account(ctx, in0).WithBuffer() // account and buffer the transfer
+
+	// Start an upload reading from a pipe for each destination
+	var (
+		wg  sync.WaitGroup
+		out = &fanOutWriter{ws: make([]*io.PipeWriter, len(shared))}
+	)
+	options = nil
+	for _, option := range ci.UploadHeaders {
+		options = append(options, option)
+	}
+	wg.Add(len(shared))
+	for j, i := range shared {
+		pr, pw := io.Pipe()
+		out.ws[j] = pw
+		go func(i int, pr *io.PipeReader) {
+			defer wg.Done()
+			f, dst := fdsts[i], dsts[i]
+			var err error
+			done := accounting.TimeCall(ctx, f, accounting.CallUpload)
+			if dst != nil {
+				err = dst.Update(ctx, pr, src, options...)
+			} else {
+				dst, err = f.Put(ctx, pr, src, options...)
+			}
+			done()
+			// Stop the source being written to this upload
+			_ = pr.CloseWithError(err)
+			newDsts[i], errs[i] = dst, err
+		}(i, pr)
+	}
+
+	// Copy the source into all the uploads
+	_, err = io.Copy(out, in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	for _, pw := range out.ws {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	wg.Wait()
+	if err == errFanOutFailed {
+		err = nil
+	}
+
+	// Check each upload
+	failed := 0
+	for _, i := range shared {
+		errs[i] = checkFanOut(ctx, fdsts[i], src, newDsts[i], err, errs[i])
+		if errs[i] != nil {
+			failed++
+			if err == nil {
+				err = errs[i]
+			}
+		}
+	}
+	if failed < len(shared) {
+		err = nil
+	}
+}
+
+// checkFanOut checks the upload of src to dst in f which returned
+// uploadErr after reading src returned readErr.
+func checkFanOut(ctx context.Context, f fs.Fs, src fs.Object, dst fs.Object, readErr, uploadErr error) (err error) {
+	defer func() {
+		if err != nil {
+			err = fs.CountError(err)
+			fs.GetLogger(ctx).Errorf(src, "Failed to copy to %v: %v", f, err)
+		}
+	}()
+	if readErr != nil {
+		if dst != nil && uploadErr == nil {
+			removeFailedCopy(ctx, dst)
+		}
+		return errors.Wrap(readErr, "failed to read source object")
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if sizeDiffers(ctx, src, dst) {
+		removeFailedCopy(ctx, dst)
+		return fserrors.WithCode(errors.Errorf("corrupted on transfer: sizes differ %d vs %d", src.Size(), dst.Size()), fserrors.CodeChecksum)
+	}
+	if hashType, _ := CommonHash(ctx, f, src.Fs()); hashType != hash.None {
+		equal, _, srcSum, dstSum, _ := checkHashes(ctx, src, dst, hashType)
+		if !equal {
+			removeFailedCopy(ctx, dst)
+			return fserrors.WithCode(errors.Errorf("corrupted on transfer: %v hash differ %q vs %q", hashType, srcSum, dstSum), fserrors.CodeChecksum)
+		}
+	}
+	rememberHash(ctx, src, dst)
+	fs.GetLogger(ctx).Infof(src, "Copied (fan out) to: %v", dst)
+	return nil
+}
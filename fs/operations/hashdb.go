@@ -0,0 +1,118 @@
+// Hashes for remotes without them kept in the --checksum-db
+
+package operations
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/hashdb"
+)
+
+// dbHashType returns the hash type to keep in the --checksum-db when
+// comparing objects on src and dst which have no hashes in common.
+//
+// This is a hash the source supports if possible so only the
+// destination needs hashing.
+func dbHashType(src, dst fs.Info) hash.Type {
+	if ht := src.Hashes().GetOne(); ht != hash.None {
+		return ht
+	}
+	if ht := dst.Hashes().GetOne(); ht != hash.None {
+		return ht
+	}
+	return hash.MD5
+}
+
+// readHash reads o to calculate its hash of type ht
+func readHash(ctx context.Context, o fs.Object, ht hash.Type) (sum string, err error) {
+	hasher, err := hash.NewMultiHasherTypes(hash.NewHashSet(ht))
+	if err != nil {
+		return "", err
+	}
+	in, err := NewReOpen(ctx, o, fs.GetConfig(ctx).LowLevelRetries)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open object to calculate hash")
+	}
+	defer fs.CheckClose(in, &err)
+	_, err = io.Copy(hasher, in)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read object to calculate hash")
+	}
+	return hasher.Sums()[ht], nil
+}
+
+// dbHash returns the hash of type ht of o.
+//
+// If o's remote doesn't support ht then the hash is looked up in the
+// --checksum-db, and if not found there o is read to calculate it
+// which is then stored for next time.
+func dbHash(ctx context.Context, o fs.ObjectInfo, ht hash.Type) (string, error) {
+	if o.Fs().Hashes().Contains(ht) {
+		return o.Hash(ctx, ht)
+	}
+	db := hashdb.Default(ctx)
+	obj, ok := o.(fs.Object)
+	if db == nil || !ok {
+		return o.Hash(ctx, ht)
+	}
+	if sum, found := db.Get(ctx, o, ht); found {
+		return sum, nil
+	}
+	fs.GetLogger(ctx).Debugf(o, "Reading to calculate %v for --checksum-db", ht)
+	sum, err := readHash(ctx, obj, ht)
+	if err != nil {
+		return "", err
+	}
+	err = db.Put(ctx, o, ht, sum)
+	if err != nil {
+		fs.GetLogger(ctx).Errorf(o, "Failed to save hash in --checksum-db: %v", err)
+	}
+	return sum, nil
+}
+
+// rememberHash stores the hash of src in the --checksum-db as the hash
+// of dst which has just been copied from it, so dst won't need to be
+// read when it is next checked.
+//
+// Nothing is read to do this - if the hash of src isn't known cheaply
+// it is left until it is needed.
+func rememberHash(ctx context.Context, src fs.ObjectInfo, dst fs.Object) {
+	db := hashdb.Default(ctx)
+	if db == nil || dst == nil {
+		return
+	}
+	ht := dbHashType(src.Fs(), dst.Fs())
+	if dst.Fs().Hashes().Contains(ht) {
+		return
+	}
+	var sum string
+	if src.Fs().Hashes().Contains(ht) {
+		sum, _ = src.Hash(ctx, ht)
+	} else {
+		sum, _ = db.Get(ctx, src, ht)
+	}
+	if sum == "" {
+		return
+	}
+	err := db.Put(ctx, dst, ht, sum)
+	if err != nil {
+		fs.GetLogger(ctx).Errorf(dst, "Failed to save hash in --checksum-db: %v", err)
+	}
+}
+
+// forgetHash removes the hashes of o from the --checksum-db after it
+// has been deleted or moved
+func forgetHash(ctx context.Context, o fs.Object) {
+	db := hashdb.Default(ctx)
+	if db == nil {
+		return
+	}
+	err := db.Delete(o)
+	if err != nil {
+		fs.GetLogger(ctx).Errorf(o, "Failed to remove hash from --checksum-db: %v", err)
+	}
+}
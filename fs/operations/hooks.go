@@ -0,0 +1,139 @@
+// Run --pre-transfer-cmd and --post-transfer-cmd for each file
+
+package operations
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Names of the transfer hooks as passed in RCLONE_HOOK
+const (
+	HookPreTransfer  = "pre_transfer"
+	HookPostTransfer = "post_transfer"
+)
+
+// transferHook describes a file being transferred to the transfer
+// hook commands
+type transferHook struct {
+	src      fs.ObjectInfo
+	f        fs.Fs  // destination Fs
+	remote   string // destination path
+	hashType hash.Type
+	hash     string
+}
+
+// newTransferHook returns a transferHook for copying src to remote
+// on f, or nil if neither hook command is set
+func newTransferHook(ctx context.Context, f fs.Fs, remote string, src fs.Object, hashType hash.Type) *transferHook {
+	ci := fs.GetConfig(ctx)
+	if len(ci.PreTransferCmd) == 0 && len(ci.PostTransferCmd) == 0 {
+		return nil
+	}
+	h := &transferHook{
+		src:      src,
+		f:        f,
+		remote:   remote,
+		hashType: hashType,
+	}
+	if len(ci.PreTransferCmd) != 0 && hashType != hash.None {
+		sum, err := src.Hash(ctx, hashType)
+		if err != nil {
+			fs.GetLogger(ctx).Debugf(src, "Failed to read %v hash for --pre-transfer-cmd: %v", hashType, err)
+		}
+		h.hash = sum
+	}
+	return h
+}
+
+// environ returns the environment to run a hook command with
+func (h *transferHook) environ(hook string, err error) []string {
+	hashType := ""
+	if h.hashType != hash.None && h.hash != "" {
+		hashType = h.hashType.String()
+	}
+	errString := ""
+	if err != nil {
+		errString = err.Error()
+	}
+	return append(os.Environ(),
+		"RCLONE_HOOK="+hook,
+		"RCLONE_SRC="+fs.ConfigString(h.src.Fs()),
+		"RCLONE_SRC_REMOTE="+h.src.Remote(),
+		"RCLONE_DST="+fs.ConfigString(h.f),
+		"RCLONE_DST_REMOTE="+h.remote,
+		"RCLONE_SIZE="+strconv.FormatInt(h.src.Size(), 10),
+		"RCLONE_HASH_TYPE="+hashType,
+		"RCLONE_HASH="+h.hash,
+		"RCLONE_ERROR="+errString,
+		"RCLONE_ERROR_CODE="+string(fserrors.ErrorCode(err)),
+	)
+}
+
+// run runs the command in args for hook
+func (h *transferHook) run(ctx context.Context, args []string, hook string, err error) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = h.environ(hook, err)
+	out, runErr := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if runErr != nil {
+		if output != "" {
+			return errors.Wrapf(runErr, "%s", output)
+		}
+		return runErr
+	}
+	if output != "" {
+		fs.GetLogger(ctx).Debugf(h.src, "%s command output: %s", hook, output)
+	}
+	return nil
+}
+
+// pre runs the --pre-transfer-cmd if set.
+//
+// If it fails the file mustn't be transferred so it returns a
+// NoRetryError.
+func (h *transferHook) pre(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	args := fs.GetConfig(ctx).PreTransferCmd
+	if len(args) == 0 {
+		return nil
+	}
+	err := h.run(ctx, args, HookPreTransfer, nil)
+	if err != nil {
+		return fserrors.NoRetryError(errors.Wrap(err, "--pre-transfer-cmd failed"))
+	}
+	return nil
+}
+
+// post runs the --post-transfer-cmd if set with the result of the
+// transfer in transferErr and the hash of the transferred file.
+//
+// If it fails the error is logged and counted, but as the file has
+// been transferred the transfer doesn't fail.
+func (h *transferHook) post(ctx context.Context, hashType hash.Type, sum string, transferErr error) {
+	if h == nil {
+		return
+	}
+	args := fs.GetConfig(ctx).PostTransferCmd
+	if len(args) == 0 {
+		return
+	}
+	if sum != "" {
+		h.hashType, h.hash = hashType, sum
+	}
+	err := h.run(ctx, args, HookPostTransfer, transferErr)
+	if err != nil {
+		err = fs.CountError(err)
+		fs.GetLogger(ctx).Errorf(h.src, "--post-transfer-cmd failed: %v", err)
+	}
+}
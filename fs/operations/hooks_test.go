@@ -0,0 +1,65 @@
+package operations_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyFileTransferHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	defer accounting.GlobalStats().ResetCounters()
+
+	dir, err := ioutil.TempDir("", "rclone-hooks")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	logFile := filepath.Join(dir, "hooks.log")
+	logEnv := `echo "$RCLONE_HOOK $RCLONE_SRC_REMOTE $RCLONE_DST_REMOTE $RCLONE_SIZE $RCLONE_HASH_TYPE $RCLONE_HASH $RCLONE_ERROR" >> ` + logFile
+	ci.PreTransferCmd = fs.SpaceSepList{"sh", "-c", logEnv + `; [ "$RCLONE_SRC_REMOTE" != "virus" ]`}
+	ci.PostTransferCmd = fs.SpaceSepList{"sh", "-c", logEnv}
+
+	file1 := r.WriteFile("file1", "hello world", t1)
+	file2 := r.WriteFile("virus", "hello world", t1)
+
+	err = operations.CopyFile(ctx, r.Fremote, r.Flocal, "sub/file1", file1.Path)
+	require.NoError(t, err)
+
+	// the pre transfer command stops this being copied
+	accounting.GlobalStats().ResetCounters()
+	err = operations.CopyFile(ctx, r.Fremote, r.Flocal, file2.Path, file2.Path)
+	require.Error(t, err)
+	assert.True(t, fserrors.IsNoRetryError(err))
+	assert.Contains(t, err.Error(), "--pre-transfer-cmd failed")
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetErrors())
+
+	file1.Path = "sub/file1"
+	fstest.CheckItems(t, r.Fremote, file1)
+
+	out, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "pre_transfer file1 sub/file1 11 MD5 5eb63bbbe01eeed093cb22bb8f5acdc3", strings.TrimSpace(lines[0]))
+	assert.Equal(t, "post_transfer file1 sub/file1 11 MD5 5eb63bbbe01eeed093cb22bb8f5acdc3", strings.TrimSpace(lines[1]))
+	assert.Equal(t, "pre_transfer virus virus 11 MD5 5eb63bbbe01eeed093cb22bb8f5acdc3", strings.TrimSpace(lines[2]))
+}
@@ -0,0 +1,214 @@
+// immutable - names and tombstones for --immutable-dest
+
+package operations
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// TombstoneSuffix is added to the name of a new version to make the
+// name of a tombstone marking a file as deleted with --immutable-dest
+const TombstoneSuffix = ".rclone-deleted"
+
+// immutableTimeFormat is the format {time} is written in - the "." is
+// replaced with a "-" so the extension isn't changed
+const immutableTimeFormat = "2006-01-02-150405.000"
+
+// immutablePattern is a compiled --immutable-dest-pattern
+type immutablePattern struct {
+	pattern string
+	re      *regexp.Regexp // matches names made with pattern
+}
+
+var immutablePatternRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// compileImmutablePattern checks and compiles pattern
+func compileImmutablePattern(pattern string) (*immutablePattern, error) {
+	if strings.Contains(pattern, "/") {
+		return nil, errors.Errorf("--immutable-dest-pattern %q mustn't contain a /", pattern)
+	}
+	counts := map[string]int{}
+	var re strings.Builder
+	re.WriteString("^")
+	last := 0
+	for _, loc := range immutablePatternRe.FindAllStringIndex(pattern, -1) {
+		re.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		last = loc[1]
+		placeholder := pattern[loc[0]:loc[1]]
+		switch placeholder {
+		case "{name}":
+			re.WriteString(`(?P<name>.*?)`)
+		case "{ext}":
+			re.WriteString(`(?P<ext>\.[^.]*)?`)
+		case "{time}":
+			re.WriteString(`(?P<time>\d{4}-\d{2}-\d{2}-\d{6}-\d{3})`)
+		default:
+			return nil, errors.Errorf("--immutable-dest-pattern %q: unknown placeholder %s", pattern, placeholder)
+		}
+		counts[placeholder]++
+	}
+	re.WriteString(regexp.QuoteMeta(pattern[last:]))
+	re.WriteString("$")
+	for _, placeholder := range []string{"{name}", "{ext}", "{time}"} {
+		if counts[placeholder] != 1 {
+			return nil, errors.Errorf("--immutable-dest-pattern %q must contain %s once", pattern, placeholder)
+		}
+	}
+	return &immutablePattern{
+		pattern: pattern,
+		re:      regexp.MustCompile(re.String()),
+	}, nil
+}
+
+// name returns the name of the version of remote made at t
+func (p *immutablePattern) name(remote string, t time.Time) string {
+	dir, leaf := path.Split(remote)
+	ext := path.Ext(leaf)
+	timestamp := strings.Replace(t.UTC().Format(immutableTimeFormat), ".", "-", -1)
+	leaf = strings.NewReplacer(
+		"{name}", leaf[:len(leaf)-len(ext)],
+		"{ext}", ext,
+		"{time}", timestamp,
+	).Replace(p.pattern)
+	return dir + leaf
+}
+
+// parse returns the remote which versionRemote is a version of and
+// the time it was made, or ok false if it isn't a version
+func (p *immutablePattern) parse(versionRemote string) (remote string, t time.Time, ok bool) {
+	dir, leaf := path.Split(versionRemote)
+	match := p.re.FindStringSubmatch(leaf)
+	if match == nil {
+		return "", t, false
+	}
+	var name, ext, timestamp string
+	for i, group := range p.re.SubexpNames() {
+		switch group {
+		case "name":
+			name = match[i]
+		case "ext":
+			ext = match[i]
+		case "time":
+			timestamp = match[i]
+		}
+	}
+	// Replace the last '-' with '.' for parsing
+	timestamp = timestamp[:len(timestamp)-4] + "." + timestamp[len(timestamp)-3:]
+	t, err := time.Parse(immutableTimeFormat, timestamp)
+	if err != nil {
+		return "", t, false
+	}
+	remote = dir + name + ext
+	// Check the name round trips as the parse may be ambiguous
+	if p.name(remote, t) != versionRemote {
+		return "", time.Time{}, false
+	}
+	return remote, t, true
+}
+
+var (
+	immutablePatternMu   sync.Mutex
+	immutablePatternLast *immutablePattern
+)
+
+// getImmutablePattern returns the compiled --immutable-dest-pattern
+func getImmutablePattern(ctx context.Context) (*immutablePattern, error) {
+	pattern := fs.GetConfig(ctx).ImmutableDestPattern
+	immutablePatternMu.Lock()
+	defer immutablePatternMu.Unlock()
+	if immutablePatternLast != nil && immutablePatternLast.pattern == pattern {
+		return immutablePatternLast, nil
+	}
+	p, err := compileImmutablePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	immutablePatternLast = p
+	return p, nil
+}
+
+// CheckImmutableDestPattern returns an error if pattern isn't a valid
+// --immutable-dest-pattern
+func CheckImmutableDestPattern(pattern string) error {
+	_, err := compileImmutablePattern(pattern)
+	return err
+}
+
+// immutableName returns a name made with the --immutable-dest-pattern
+// for a new version of remote in f plus suffix which isn't in use
+func immutableName(ctx context.Context, f fs.Fs, remote, suffix string) (string, error) {
+	p, err := getImmutablePattern(ctx)
+	if err != nil {
+		return "", err
+	}
+	t := time.Now()
+	for tries := 0; tries < 100; tries++ {
+		name := p.name(remote, t) + suffix
+		_, err := f.NewObject(ctx, name)
+		if err == fs.ErrorObjectNotFound {
+			return name, nil
+		} else if err != nil {
+			return "", errors.Wrap(err, "failed to check for existing version")
+		}
+		// Try the next millisecond
+		t = t.Add(time.Millisecond)
+	}
+	return "", errors.Errorf("couldn't find an unused version name for %q", remote)
+}
+
+// ImmutableName returns the name to write a new version of remote to
+// in f with --immutable-dest, made with the --immutable-dest-pattern
+func ImmutableName(ctx context.Context, f fs.Fs, remote string) (string, error) {
+	return immutableName(ctx, f, remote, "")
+}
+
+// ParseImmutableName returns the remote which versionRemote is a new
+// version or a tombstone of and the time it was written.
+//
+// If versionRemote wasn't named with the --immutable-dest-pattern it
+// returns versionRemote and a zero time.
+func ParseImmutableName(ctx context.Context, versionRemote string) (remote string, t time.Time, tombstone bool) {
+	p, err := getImmutablePattern(ctx)
+	if err != nil {
+		return versionRemote, t, false
+	}
+	name := versionRemote
+	if strings.HasSuffix(name, TombstoneSuffix) {
+		name = name[:len(name)-len(TombstoneSuffix)]
+		tombstone = true
+	}
+	remote, t, ok := p.parse(name)
+	if !ok {
+		return versionRemote, time.Time{}, false
+	}
+	return remote, t, tombstone
+}
+
+// writeTombstone marks dst as deleted with --immutable-dest by
+// writing an empty tombstone object next to it
+func writeTombstone(ctx context.Context, dst fs.Object) (err error) {
+	f, ok := dst.Fs().(fs.Fs)
+	if !ok {
+		return errors.Errorf("internal error: can't write tombstone in %v", dst.Fs())
+	}
+	remote, err := immutableName(ctx, f, dst.Remote(), TombstoneSuffix)
+	if err != nil {
+		return err
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), 0, true, nil, f)
+	_, err = f.Put(ctx, bytes.NewReader(nil), info)
+	if err != nil {
+		return errors.Wrap(err, "failed to write tombstone")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImmutablePattern(t *testing.T) {
+	when := time.Date(2021, 1, 2, 15, 4, 5, 123e6, time.UTC)
+	for _, test := range []struct {
+		pattern string
+		remote  string
+		want    string
+	}{
+		{"{name}.v{time}{ext}", "file.txt", "file.v2021-01-02-150405-123.txt"},
+		{"{name}.v{time}{ext}", "dir/file.tar.gz", "dir/file.tar.v2021-01-02-150405-123.gz"},
+		{"{name}.v{time}{ext}", "dir/file", "dir/file.v2021-01-02-150405-123"},
+		{"{name}.v{time}{ext}", ".bashrc", ".v2021-01-02-150405-123.bashrc"},
+		{"{time}_{name}{ext}", "a/b/file.txt", "a/b/2021-01-02-150405-123_file.txt"},
+	} {
+		p, err := compileImmutablePattern(test.pattern)
+		require.NoError(t, err)
+		got := p.name(test.remote, when)
+		assert.Equal(t, test.want, got, test.pattern)
+		remote, gotWhen, ok := p.parse(got)
+		assert.True(t, ok, got)
+		assert.Equal(t, test.remote, remote, got)
+		assert.True(t, when.Equal(gotWhen), got)
+
+		_, _, ok = p.parse(test.remote)
+		assert.False(t, ok, test.remote)
+	}
+
+	for _, pattern := range []string{"", "{name}{ext}", "{name}{time}", "{name}{ext}{time}{time}", "{name}{ext}{time}{potato}", "old/{name}{time}{ext}"} {
+		assert.Error(t, CheckImmutableDestPattern(pattern), pattern)
+	}
+}
+
+func TestParseImmutableName(t *testing.T) {
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.ImmutableDestPattern = "{name}.v{time}{ext}"
+
+	remote, when, tombstone := ParseImmutableName(ctx, "dir/file.v2021-01-02-150405-123.txt")
+	assert.Equal(t, "dir/file.txt", remote)
+	assert.False(t, when.IsZero())
+	assert.False(t, tombstone)
+
+	remote, when, tombstone = ParseImmutableName(ctx, "dir/file.v2021-01-02-150405-123.txt"+TombstoneSuffix)
+	assert.Equal(t, "dir/file.txt", remote)
+	assert.False(t, when.IsZero())
+	assert.True(t, tombstone)
+
+	remote, when, tombstone = ParseImmutableName(ctx, "dir/file.txt"+TombstoneSuffix)
+	assert.Equal(t, "dir/file.txt"+TombstoneSuffix, remote)
+	assert.True(t, when.IsZero())
+	assert.False(t, tombstone)
+}
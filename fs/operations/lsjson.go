@@ -142,9 +142,13 @@ func ListJSON(ctx context.Context, fsrc fs.Fs, remote string, opt *ListJSONOpt,
 				item.MimeType = fs.MimeTypeDirEntry(ctx, entry)
 			}
 			if cipher != nil {
-				switch entry.(type) {
+				switch x := entry.(type) {
 				case fs.Directory:
 					item.EncryptedPath = cipher.EncryptDirName(entry.Remote())
+				case *crypt.Object:
+					// use the name the file is stored under as
+					// it depends on the format of the file
+					item.EncryptedPath = x.UnWrap().Remote()
 				case fs.Object:
 					item.EncryptedPath = cipher.EncryptFileName(entry.Remote())
 				default:
@@ -24,12 +24,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/audit"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/hashdb"
 	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/tracing"
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/lib/atexit"
 	"github.com/rclone/rclone/lib/random"
@@ -53,10 +56,14 @@ import (
 func CheckHashes(ctx context.Context, src fs.ObjectInfo, dst fs.Object) (equal bool, ht hash.Type, err error) {
 	common := src.Fs().Hashes().Overlap(dst.Fs().Hashes())
 	// fs.Debugf(nil, "Shared hashes: %v", common)
+	ht = common.GetOne()
 	if common.Count() == 0 {
-		return true, hash.None, nil
+		if hashdb.Default(ctx) == nil {
+			return true, hash.None, nil
+		}
+		ht = dbHashType(src.Fs(), dst.Fs())
 	}
-	equal, ht, _, _, err = checkHashes(ctx, src, dst, common.GetOne())
+	equal, ht, _, _, err = checkHashes(ctx, src, dst, ht)
 	return equal, ht, err
 }
 
@@ -66,18 +73,18 @@ func checkHashes(ctx context.Context, src fs.ObjectInfo, dst fs.Object, ht hash.
 	// Calculate hashes in parallel
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() (err error) {
-		srcHash, err = src.Hash(ctx, ht)
+		srcHash, err = dbHash(ctx, src, ht)
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(src, "Failed to calculate src hash: %v", err)
+			fs.GetLogger(ctx).Errorf(src, "Failed to calculate src hash: %v", err)
 		}
 		return err
 	})
 	g.Go(func() (err error) {
-		dstHash, err = dst.Hash(ctx, ht)
+		dstHash, err = dbHash(ctx, dst, ht)
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(dst, "Failed to calculate dst hash: %v", err)
+			fs.GetLogger(ctx).Errorf(dst, "Failed to calculate dst hash: %v", err)
 		}
 		return err
 	})
@@ -92,10 +99,10 @@ func checkHashes(ctx context.Context, src fs.ObjectInfo, dst fs.Object, ht hash.
 		return true, hash.None, srcHash, dstHash, nil
 	}
 	if srcHash != dstHash {
-		fs.Debugf(src, "%v = %s (%v)", ht, srcHash, src.Fs())
-		fs.Debugf(dst, "%v = %s (%v)", ht, dstHash, dst.Fs())
+		fs.GetLogger(ctx).Debugf(src, "%v = %s (%v)", ht, srcHash, src.Fs())
+		fs.GetLogger(ctx).Debugf(dst, "%v = %s (%v)", ht, dstHash, dst.Fs())
 	} else {
-		fs.Debugf(src, "%v = %s OK", ht, srcHash)
+		fs.GetLogger(ctx).Debugf(src, "%v = %s OK", ht, srcHash)
 	}
 	return srcHash == dstHash, ht, srcHash, dstHash, nil
 }
@@ -148,7 +155,7 @@ func defaultEqualOpt(ctx context.Context) equalOpt {
 	return equalOpt{
 		sizeOnly:          ci.SizeOnly,
 		checkSum:          ci.CheckSum,
-		updateModTime:     !ci.NoUpdateModTime,
+		updateModTime:     !ci.NoUpdateModTime && !ci.ImmutableDest,
 		forceModTimeMatch: false,
 	}
 }
@@ -165,11 +172,11 @@ func logModTimeUpload(dst fs.Object) {
 func equal(ctx context.Context, src fs.ObjectInfo, dst fs.Object, opt equalOpt) bool {
 	ci := fs.GetConfig(ctx)
 	if sizeDiffers(ctx, src, dst) {
-		fs.Debugf(src, "Sizes differ (src %d vs dst %d)", src.Size(), dst.Size())
+		fs.GetLogger(ctx).Debugf(src, "Sizes differ (src %d vs dst %d)", src.Size(), dst.Size())
 		return false
 	}
 	if opt.sizeOnly {
-		fs.Debugf(src, "Sizes identical")
+		fs.GetLogger(ctx).Debugf(src, "Sizes identical")
 		return true
 	}
 
@@ -180,19 +187,19 @@ func equal(ctx context.Context, src fs.ObjectInfo, dst fs.Object, opt equalOpt)
 		// Check the hash
 		same, ht, _ := CheckHashes(ctx, src, dst)
 		if !same {
-			fs.Debugf(src, "%v differ", ht)
+			fs.GetLogger(ctx).Debugf(src, "%v differ", ht)
 			return false
 		}
 		if ht == hash.None {
 			common := src.Fs().Hashes().Overlap(dst.Fs().Hashes())
 			if common.Count() == 0 {
 				checksumWarning.Do(func() {
-					fs.Logf(dst.Fs(), "--checksum is in use but the source and destination have no hashes in common; falling back to --size-only")
+					fs.GetLogger(ctx).Logf(dst.Fs(), "--checksum is in use but the source and destination have no hashes in common; falling back to --size-only")
 				})
 			}
-			fs.Debugf(src, "Size of src and dst objects identical")
+			fs.GetLogger(ctx).Debugf(src, "Size of src and dst objects identical")
 		} else {
-			fs.Debugf(src, "Size and %v of src and dst objects identical", ht)
+			fs.GetLogger(ctx).Debugf(src, "Size and %v of src and dst objects identical", ht)
 		}
 		return true
 	}
@@ -202,23 +209,23 @@ func equal(ctx context.Context, src fs.ObjectInfo, dst fs.Object, opt equalOpt)
 		// Sizes the same so check the mtime
 		modifyWindow := fs.GetModifyWindow(ctx, src.Fs(), dst.Fs())
 		if modifyWindow == fs.ModTimeNotSupported {
-			fs.Debugf(src, "Sizes identical")
+			fs.GetLogger(ctx).Debugf(src, "Sizes identical")
 			return true
 		}
 		dstModTime := dst.ModTime(ctx)
 		dt := dstModTime.Sub(srcModTime)
 		if dt < modifyWindow && dt > -modifyWindow {
-			fs.Debugf(src, "Size and modification time the same (differ by %s, within tolerance %s)", dt, modifyWindow)
+			fs.GetLogger(ctx).Debugf(src, "Size and modification time the same (differ by %s, within tolerance %s)", dt, modifyWindow)
 			return true
 		}
 
-		fs.Debugf(src, "Modification times differ by %s: %v, %v", dt, srcModTime, dstModTime)
+		fs.GetLogger(ctx).Debugf(src, "Modification times differ by %s: %v, %v", dt, srcModTime, dstModTime)
 	}
 
 	// Check if the hashes are the same
 	same, ht, _ := CheckHashes(ctx, src, dst)
 	if !same {
-		fs.Debugf(src, "%v differ", ht)
+		fs.GetLogger(ctx).Debugf(src, "%v differ", ht)
 		return false
 	}
 	if ht == hash.None && !ci.RefreshTimes {
@@ -232,32 +239,32 @@ func equal(ctx context.Context, src fs.ObjectInfo, dst fs.Object, opt equalOpt)
 			// Size and hash the same but mtime different
 			// Error if objects are treated as immutable
 			if ci.Immutable {
-				fs.Errorf(dst, "StartedAt mismatch between immutable objects")
+				fs.GetLogger(ctx).Errorf(dst, "StartedAt mismatch between immutable objects")
 				return false
 			}
 			// Update the mtime of the dst object here
 			err := dst.SetModTime(ctx, srcModTime)
 			if err == fs.ErrorCantSetModTime {
 				logModTimeUpload(dst)
-				fs.Infof(dst, "src and dst identical but can't set mod time without re-uploading")
+				fs.GetLogger(ctx).Infof(dst, "src and dst identical but can't set mod time without re-uploading")
 				return false
 			} else if err == fs.ErrorCantSetModTimeWithoutDelete {
 				logModTimeUpload(dst)
-				fs.Infof(dst, "src and dst identical but can't set mod time without deleting and re-uploading")
+				fs.GetLogger(ctx).Infof(dst, "src and dst identical but can't set mod time without deleting and re-uploading")
 				// Remove the file if BackupDir isn't set.  If BackupDir is set we would rather have the old file
 				// put in the BackupDir than deleted which is what will happen if we don't delete it.
 				if ci.BackupDir == "" {
 					err = dst.Remove(ctx)
 					if err != nil {
-						fs.Errorf(dst, "failed to delete before re-upload: %v", err)
+						fs.GetLogger(ctx).Errorf(dst, "failed to delete before re-upload: %v", err)
 					}
 				}
 				return false
 			} else if err != nil {
 				err = fs.CountError(err)
-				fs.Errorf(dst, "Failed to set modification time: %v", err)
+				fs.GetLogger(ctx).Errorf(dst, "Failed to set modification time: %v", err)
 			} else {
-				fs.Infof(src, "Updated modification time in destination")
+				fs.GetLogger(ctx).Infof(src, "Updated modification time in destination")
 			}
 		}
 	}
@@ -271,10 +278,10 @@ func removeFailedCopy(ctx context.Context, dst fs.Object) bool {
 	if dst == nil {
 		return false
 	}
-	fs.Infof(dst, "Removing failed copy")
+	fs.GetLogger(ctx).Infof(dst, "Removing failed copy")
 	removeErr := dst.Remove(ctx)
 	if removeErr != nil {
-		fs.Infof(dst, "Failed to remove failed copy: %s", removeErr)
+		fs.GetLogger(ctx).Infof(dst, "Failed to remove failed copy: %s", removeErr)
 		return false
 	}
 	return true
@@ -363,6 +370,7 @@ func CommonHash(ctx context.Context, fa, fb fs.Info) (hash.Type, *fs.HashesOptio
 func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Object, err error) {
 	ci := fs.GetConfig(ctx)
 	tr := accounting.Stats(ctx).NewTransfer(src)
+	tr.SetDst(f)
 	defer func() {
 		tr.Done(ctx, err)
 	}()
@@ -370,10 +378,44 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 	if SkipDestructive(ctx, src, "copy") {
 		return newDst, nil
 	}
+	if ci.ImmutableDest && dst != nil {
+		// Write a new version instead of overwriting dst
+		remote, err = ImmutableName(ctx, f, remote)
+		if err != nil {
+			return newDst, fs.CountError(err)
+		}
+		dst = nil
+	}
 	maxTries := ci.LowLevelRetries
 	tries := 0
+	event := newTransferEvent(ctx, tr, src)
+	event.logf(fs.LogLevelDebug, src, EventTransferStarted, nil, "Transfer started")
+	ctx, span := tracing.Start(ctx, "operations.Copy", tracing.KindInternal)
+	span.SetAttribute("remote", fs.ConfigString(f))
+	span.SetAttribute("object", remote)
+	span.SetAttribute("size", src.Size())
+	defer func() {
+		if tries > 1 {
+			span.SetAttribute("retries", tries-1)
+		}
+		span.End(err)
+	}()
 	doUpdate := dst != nil
+	if doUpdate {
+		defer func() {
+			audit.RecordObject(ctx, audit.ActionOverwrite, src, dst, err)
+		}()
+	}
 	hashType, hashOption := CommonHash(ctx, f, src.Fs())
+	hook := newTransferHook(ctx, f, remote, src, hashType)
+	if err = hook.pre(ctx); err != nil {
+		err = fs.CountError(err)
+		event.logf(fs.LogLevelError, src, EventTransferFailed, err, "Not transferring: %v", err)
+		return newDst, err
+	}
+	defer func() {
+		hook.post(ctx, event.hashType, event.srcHash, err)
+	}()
 
 	var actionTaken string
 	for {
@@ -394,7 +436,7 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 				return nil, accounting.ErrorMaxTransferLimitReachedGraceful
 			}
 		}
-		if doCopy := f.Features().Copy; doCopy != nil && (SameConfig(src.Fs(), f) || (SameRemoteType(src.Fs(), f) && f.Features().ServerSideAcrossConfigs)) {
+		if doCopy := f.Features().Copy; doCopy != nil && ServerSideCompatible(ctx, f, src.Fs()) {
 			in := tr.Account(ctx, nil) // account the transfer
 			in.ServerSideCopyStart()
 			newDst, err = doCopy(ctx, src, remote)
@@ -413,7 +455,14 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 		}
 		// If can't server-side copy, do it manually
 		if err == fs.ErrorCantCopy {
-			if doMultiThreadCopy(ctx, f, src) {
+			if doDeltaCopy(ctx, f, dst, src) {
+				var deltaDst fs.Object
+				deltaDst, err = deltaCopy(ctx, f, dst, remote, src, tr)
+				if err == nil {
+					dst, newDst = deltaDst, deltaDst
+				}
+				actionTaken = "Delta Copied (replaced existing)"
+			} else if doMultiThreadCopy(ctx, f, src) {
 				// Number of streams proportional to size
 				streams := src.Size() / int64(ci.MultiThreadCutoff)
 				// With maximum
@@ -460,6 +509,7 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 						for _, option := range ci.UploadHeaders {
 							options = append(options, option)
 						}
+						done := accounting.TimeCall(ctx, f, accounting.CallUpload)
 						if doUpdate {
 							actionTaken = "Copied (replaced existing)"
 							err = dst.Update(ctx, in, wrappedSrc, options...)
@@ -467,6 +517,7 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 							actionTaken = "Copied (new)"
 							dst, err = f.Put(ctx, in, wrappedSrc, options...)
 						}
+						done()
 						closeErr := in.Close()
 						if err == nil {
 							newDst = dst
@@ -482,23 +533,26 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 		}
 		// Retry if err returned a retry error
 		if fserrors.IsRetryError(err) || fserrors.ShouldRetry(err) {
-			fs.Debugf(src, "Received error: %v - low level retry %d/%d", err, tries, maxTries)
+			fs.GetLogger(ctx).Debugf(src, "Received error: %v - low level retry %d/%d", err, tries, maxTries)
 			tr.Reset(ctx) // skip incomplete accounting - will be overwritten by retry
 			continue
 		}
 		// otherwise finish
 		break
 	}
+	if tries > 1 {
+		event.retries = tries - 1
+	}
 	if err != nil {
 		err = fs.CountError(err)
-		fs.Errorf(src, "Failed to copy: %v", err)
+		event.logf(fs.LogLevelError, src, EventTransferFailed, err, "Failed to copy: %v", err)
 		return newDst, err
 	}
 
 	// Verify sizes are the same after transfer
 	if sizeDiffers(ctx, src, dst) {
-		err = errors.Errorf("corrupted on transfer: sizes differ %d vs %d", src.Size(), dst.Size())
-		fs.Errorf(dst, "%v", err)
+		err = fserrors.WithCode(errors.Errorf("corrupted on transfer: sizes differ %d vs %d", src.Size(), dst.Size()), fserrors.CodeChecksum)
+		event.logf(fs.LogLevelError, dst, EventTransferFailed, err, "%v", err)
 		err = fs.CountError(err)
 		removeFailedCopy(ctx, dst)
 		return newDst, err
@@ -507,19 +561,21 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 	// Verify hashes are the same after transfer - ignoring blank hashes
 	if hashType != hash.None {
 		// checkHashes has logged and counted errors
-		equal, _, srcSum, dstSum, _ := checkHashes(ctx, src, dst, hashType)
+		equal, htOut, srcSum, dstSum, _ := checkHashes(ctx, src, dst, hashType)
+		event.hashType, event.srcHash, event.dstHash = htOut, srcSum, dstSum
 		if !equal {
-			err = errors.Errorf("corrupted on transfer: %v hash differ %q vs %q", hashType, srcSum, dstSum)
-			fs.Errorf(dst, "%v", err)
+			err = fserrors.WithCode(errors.Errorf("corrupted on transfer: %v hash differ %q vs %q", hashType, srcSum, dstSum), fserrors.CodeChecksum)
+			event.logf(fs.LogLevelError, dst, EventChecksumMismatch, err, "%v", err)
 			err = fs.CountError(err)
 			removeFailedCopy(ctx, dst)
 			return newDst, err
 		}
 	}
+	rememberHash(ctx, src, newDst)
 	if newDst != nil && src.String() != newDst.String() {
-		fs.Infof(src, "%s to: %s", actionTaken, newDst.String())
+		event.logf(fs.LogLevelInfo, src, EventTransferCompleted, nil, "%s to: %s", actionTaken, newDst.String())
 	} else {
-		fs.Infof(src, actionTaken)
+		event.logf(fs.LogLevelInfo, src, EventTransferCompleted, nil, "%s", actionTaken)
 	}
 	return newDst, err
 }
@@ -561,8 +617,16 @@ func Move(ctx context.Context, fdst fs.Fs, dst fs.Object, remote string, src fs.
 	if SkipDestructive(ctx, src, "move") {
 		return newDst, nil
 	}
+	if fs.GetConfig(ctx).ImmutableDest && dst != nil {
+		// Write a new version instead of overwriting dst
+		remote, err = ImmutableName(ctx, fdst, remote)
+		if err != nil {
+			return newDst, fs.CountError(err)
+		}
+		dst = nil
+	}
 	// See if we have Move available
-	if doMove := fdst.Features().Move; doMove != nil && (SameConfig(src.Fs(), fdst) || (SameRemoteType(src.Fs(), fdst) && fdst.Features().ServerSideAcrossConfigs)) {
+	if doMove := fdst.Features().Move; doMove != nil && ServerSideCompatible(ctx, fdst, src.Fs()) {
 		// Delete destination if it exists and is not the same file as src (could be same file while seemingly different if the remote is case insensitive)
 		if dst != nil && !SameObject(src, dst) {
 			err = DeleteFile(ctx, dst)
@@ -575,28 +639,70 @@ func Move(ctx context.Context, fdst fs.Fs, dst fs.Object, remote string, src fs.
 		switch err {
 		case nil:
 			if newDst != nil && src.String() != newDst.String() {
-				fs.Infof(src, "Moved (server-side) to: %s", newDst.String())
+				fs.GetLogger(ctx).Infof(src, "Moved (server-side) to: %s", newDst.String())
 			} else {
-				fs.Infof(src, "Moved (server-side)")
+				fs.GetLogger(ctx).Infof(src, "Moved (server-side)")
 			}
-
+			auditMove(ctx, fdst, remote, src, nil)
+			rememberHash(ctx, src, newDst)
+			forgetHash(ctx, src)
 			return newDst, nil
 		case fs.ErrorCantMove:
-			fs.Debugf(src, "Can't move, switching to copy")
+			fs.GetLogger(ctx).Debugf(src, "Can't move, switching to copy")
 		default:
 			err = fs.CountError(err)
-			fs.Errorf(src, "Couldn't move: %v", err)
+			fs.GetLogger(ctx).Errorf(src, "Couldn't move: %v", err)
+			auditMove(ctx, fdst, remote, src, err)
 			return newDst, err
 		}
 	}
 	// Move not found or didn't work so copy dst <- src
-	newDst, err = Copy(ctx, fdst, dst, remote, src)
+	//
+	// The copy and delete are recorded in the audit log as a single move
+	copyCtx := audit.Suppress(ctx)
+	newDst, err = Copy(copyCtx, fdst, dst, remote, src)
 	if err != nil {
-		fs.Errorf(src, "Not deleting source as copy failed: %v", err)
+		fs.GetLogger(ctx).Errorf(src, "Not deleting source as copy failed: %v", err)
+		auditMove(ctx, fdst, remote, src, err)
 		return newDst, err
 	}
 	// Delete src if no error on copy
-	return newDst, DeleteFile(ctx, src)
+	err = DeleteFile(copyCtx, src)
+	auditMove(ctx, fdst, remote, src, err)
+	return newDst, err
+}
+
+// auditMove records the move of src to remote on fdst in the audit
+// log
+func auditMove(ctx context.Context, fdst fs.Fs, remote string, src fs.Object, err error) {
+	if !audit.Enabled() {
+		return
+	}
+	entry := audit.Entry{
+		Action: audit.ActionMove,
+		Src:    audit.Name(src.Fs(), src.Remote()),
+		Dst:    audit.Name(fdst, remote),
+		Size:   src.Size(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(ctx, entry)
+}
+
+// auditDir records action on dir in f in the audit log
+func auditDir(ctx context.Context, action string, f fs.Fs, dir string, err error) {
+	if !audit.Enabled() {
+		return
+	}
+	entry := audit.Entry{
+		Action: action,
+		Src:    audit.Name(f, dir),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(ctx, entry)
 }
 
 // CanServerSideMove returns true if fdst support server-side moves or
@@ -641,22 +747,33 @@ func DeleteFileWithBackupDir(ctx context.Context, dst fs.Object, backupDir fs.Fs
 		return fserrors.FatalError(errors.New("--max-delete threshold reached"))
 	}
 	action, actioned := "delete", "Deleted"
-	if backupDir != nil {
+	if ci.ImmutableDest {
+		action, actioned = "write tombstone for", "Wrote tombstone for"
+	} else if backupDir != nil {
 		action, actioned = "move into backup dir", "Moved into backup dir"
 	}
 	skip := SkipDestructive(ctx, dst, action)
 	if skip {
 		// do nothing
+	} else if ci.ImmutableDest {
+		err = writeTombstone(ctx, dst)
+		audit.RecordObject(ctx, audit.ActionDelete, dst, nil, err)
 	} else if backupDir != nil {
 		err = MoveBackupDir(ctx, backupDir, dst)
 	} else {
+		done := accounting.TimeCall(ctx, dst.Fs(), accounting.CallDelete)
 		err = dst.Remove(ctx)
+		done()
+		audit.RecordObject(ctx, audit.ActionDelete, dst, nil, err)
+		if err == nil {
+			forgetHash(ctx, dst)
+		}
 	}
 	if err != nil {
-		fs.Errorf(dst, "Couldn't %s: %v", action, err)
+		fs.GetLogger(ctx).Errorf(dst, "Couldn't %s: %v", action, err)
 		err = fs.CountError(err)
 	} else if !skip {
-		fs.Infof(dst, actioned)
+		fs.GetLogger(ctx).Infof(dst, actioned)
 	}
 	return err
 }
@@ -675,6 +792,13 @@ func DeleteFile(ctx context.Context, dst fs.Object) (err error) {
 // If backupDir is set the files will be placed into that directory
 // instead of being deleted.
 func DeleteFilesWithBackupDir(ctx context.Context, toBeDeleted fs.ObjectsChan, backupDir fs.Fs) error {
+	return DeleteFilesWithBackupDirFn(ctx, toBeDeleted, backupDir, nil)
+}
+
+// DeleteFilesWithBackupDirFn is like DeleteFilesWithBackupDir but
+// calls failed, if set, with each file which couldn't be deleted and
+// the error.  It may be called concurrently.
+func DeleteFilesWithBackupDirFn(ctx context.Context, toBeDeleted fs.ObjectsChan, backupDir fs.Fs, failed func(dst fs.Object, err error)) error {
 	var wg sync.WaitGroup
 	ci := fs.GetConfig(ctx)
 	wg.Add(ci.Transfers)
@@ -687,9 +811,12 @@ func DeleteFilesWithBackupDir(ctx context.Context, toBeDeleted fs.ObjectsChan, b
 			for dst := range toBeDeleted {
 				err := DeleteFileWithBackupDir(ctx, dst, backupDir)
 				if err != nil {
+					if failed != nil {
+						failed(dst, err)
+					}
 					atomic.AddInt32(&errorCount, 1)
 					if fserrors.IsFatalError(err) {
-						fs.Errorf(nil, "Got fatal error on delete: %s", err)
+						fs.GetLogger(ctx).Errorf(nil, "Got fatal error on delete: %s", err)
 						atomic.AddInt32(&fatalErrorCount, 1)
 						return
 					}
@@ -697,7 +824,7 @@ func DeleteFilesWithBackupDir(ctx context.Context, toBeDeleted fs.ObjectsChan, b
 			}
 		}()
 	}
-	fs.Debugf(nil, "Waiting for deletions to finish")
+	fs.GetLogger(ctx).Debugf(nil, "Waiting for deletions to finish")
 	wg.Wait()
 	if errorCount > 0 {
 		err := errors.Errorf("failed to delete %d files", errorCount)
@@ -725,6 +852,27 @@ func SameConfig(fdst, fsrc fs.Info) bool {
 	return fdst.Name() == fsrc.Name()
 }
 
+// ServerSideCompatible returns true if objects in fsrc can be copied
+// or moved to fdst with fdst's server-side Copy and Move.
+//
+// This is true if they use the same config file entry, or if they are
+// the same type of remote and fdst can copy from other remotes, either
+// always with ServerSideAcrossConfigs or as negotiated by
+// CanServerSideFrom.
+func ServerSideCompatible(ctx context.Context, fdst, fsrc fs.Info) bool {
+	if SameConfig(fdst, fsrc) {
+		return true
+	}
+	if !SameRemoteType(fdst, fsrc) {
+		return false
+	}
+	features := fdst.Features()
+	if features.ServerSideAcrossConfigs {
+		return true
+	}
+	return features.CanServerSideFrom != nil && features.CanServerSideFrom(ctx, fsrc)
+}
+
 // Same returns true if fdst and fsrc point to the same underlying Fs
 func Same(fdst, fsrc fs.Info) bool {
 	return SameConfig(fdst, fsrc) && strings.Trim(fdst.Root(), "/") == strings.Trim(fsrc.Root(), "/")
@@ -865,7 +1013,7 @@ func hashSum(ctx context.Context, ht hash.Type, o fs.Object) (string, error) {
 	if err == hash.ErrUnsupported {
 		sum = "UNSUPPORTED"
 	} else if err != nil {
-		fs.Debugf(o, "Failed to read %v: %v", ht, err)
+		fs.GetLogger(ctx).Debugf(o, "Failed to read %v: %v", ht, err)
 		sum = "ERROR"
 	}
 	return sum, err
@@ -933,7 +1081,7 @@ func Mkdir(ctx context.Context, f fs.Fs, dir string) error {
 	if SkipDestructive(ctx, fs.LogDirName(f, dir), "make directory") {
 		return nil
 	}
-	fs.Debugf(fs.LogDirName(f, dir), "Making directory")
+	fs.GetLogger(ctx).Debugf(fs.LogDirName(f, dir), "Making directory")
 	err := f.Mkdir(ctx, dir)
 	if err != nil {
 		err = fs.CountError(err)
@@ -949,8 +1097,10 @@ func TryRmdir(ctx context.Context, f fs.Fs, dir string) error {
 	if SkipDestructive(ctx, fs.LogDirName(f, dir), "remove directory") {
 		return nil
 	}
-	fs.Debugf(fs.LogDirName(f, dir), "Removing directory")
-	return f.Rmdir(ctx, dir)
+	fs.GetLogger(ctx).Debugf(fs.LogDirName(f, dir), "Removing directory")
+	err := f.Rmdir(ctx, dir)
+	auditDir(ctx, audit.ActionRmdir, f, dir, err)
+	return err
 }
 
 // Rmdir removes a container but not if not empty
@@ -975,6 +1125,8 @@ func Purge(ctx context.Context, f fs.Fs, dir string) (err error) {
 		err = doPurge(ctx, dir)
 		if err == fs.ErrorCantPurge {
 			doFallbackPurge = true
+		} else {
+			auditDir(ctx, audit.ActionPurge, f, dir, err)
 		}
 	}
 	if doFallbackPurge {
@@ -1032,7 +1184,7 @@ func listToChan(ctx context.Context, f fs.Fs, dir string) fs.ObjectsChan {
 		if err != nil && err != fs.ErrorDirNotFound {
 			err = errors.Wrap(err, "failed to list")
 			err = fs.CountError(err)
-			fs.Errorf(nil, "%v", err)
+			fs.GetLogger(ctx).Errorf(nil, "%v", err)
 		}
 	}()
 	return o
@@ -1091,7 +1243,7 @@ func Cat(ctx context.Context, f fs.Fs, w io.Writer, offset, count int64) error {
 		in, err := o.Open(ctx, options...)
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(o, "Failed to open: %v", err)
+			fs.GetLogger(ctx).Errorf(o, "Failed to open: %v", err)
 			return
 		}
 		if count >= 0 {
@@ -1104,7 +1256,7 @@ func Cat(ctx context.Context, f fs.Fs, w io.Writer, offset, count int64) error {
 		_, err = io.Copy(w, in)
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(o, "Failed to send to output: %v", err)
+			fs.GetLogger(ctx).Errorf(o, "Failed to send to output: %v", err)
 		}
 	})
 }
@@ -1113,6 +1265,7 @@ func Cat(ctx context.Context, f fs.Fs, w io.Writer, offset, count int64) error {
 func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser, modTime time.Time) (dst fs.Object, err error) {
 	ci := fs.GetConfig(ctx)
 	tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, -1)
+	tr.SetDst(fdst)
 	defer func() {
 		tr.Done(ctx, err)
 	}()
@@ -1145,9 +1298,9 @@ func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser,
 		}
 		src := object.NewStaticObjectInfo(dstFileName, modTime, int64(readCounter.BytesRead()), false, sums, fdst)
 		if !Equal(ctx, src, dst) {
-			err = errors.Errorf("corrupted on transfer")
+			err = fserrors.WithCode(errors.New("corrupted on transfer"), fserrors.CodeChecksum)
 			err = fs.CountError(err)
-			fs.Errorf(dst, "%v", err)
+			fs.GetLogger(ctx).Errorf(dst, "%v", err)
 			return err
 		}
 		return nil
@@ -1156,7 +1309,7 @@ func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser,
 	// check if file small enough for direct upload
 	buf := make([]byte, ci.StreamingUploadCutoff)
 	if n, err := io.ReadFull(trackingIn, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
-		fs.Debugf(fdst, "File to upload is small (%d bytes), uploading instead of streaming", n)
+		fs.GetLogger(ctx).Debugf(fdst, "File to upload is small (%d bytes), uploading instead of streaming", n)
 		src := object.NewMemoryObject(dstFileName, modTime, buf[:n])
 		return Copy(ctx, fdst, nil, dstFileName, src)
 	}
@@ -1170,7 +1323,7 @@ func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser,
 	fStreamTo := fdst
 	canStream := fdst.Features().PutStream != nil
 	if !canStream {
-		fs.Debugf(fdst, "Target remote doesn't support streaming uploads, creating temporary local FS to spool file")
+		fs.GetLogger(ctx).Debugf(fdst, "Target remote doesn't support streaming uploads, creating temporary local FS to spool file")
 		tmpLocalFs, err := fs.TemporaryLocalFs(ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to create temporary local FS to spool file")
@@ -1178,7 +1331,7 @@ func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser,
 		defer func() {
 			err := Purge(ctx, tmpLocalFs, "")
 			if err != nil {
-				fs.Infof(tmpLocalFs, "Failed to cleanup temporary FS: %v", err)
+				fs.GetLogger(ctx).Infof(tmpLocalFs, "Failed to cleanup temporary FS: %v", err)
 			}
 		}()
 		fStreamTo = tmpLocalFs
@@ -1210,7 +1363,13 @@ func PublicLink(ctx context.Context, f fs.Fs, remote string, expire fs.Duration,
 	if doPublicLink == nil {
 		return "", errors.Errorf("%v doesn't support public links", f)
 	}
-	return doPublicLink(ctx, remote, expire, unlink)
+	link, err := doPublicLink(ctx, remote, expire, unlink)
+	action := audit.ActionLink
+	if unlink {
+		action = audit.ActionUnlink
+	}
+	auditDir(ctx, action, f, remote, err)
+	return link, err
 }
 
 // Rmdirs removes any empty directories (or directories only
@@ -1222,7 +1381,7 @@ func Rmdirs(ctx context.Context, f fs.Fs, dir string, leaveRoot bool) error {
 	err := walk.Walk(ctx, f, dir, true, ci.MaxDepth, func(dirPath string, entries fs.DirEntries, err error) error {
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(f, "Failed to list %q: %v", dirPath, err)
+			fs.GetLogger(ctx).Errorf(f, "Failed to list %q: %v", dirPath, err)
 			return nil
 		}
 		for _, entry := range entries {
@@ -1269,7 +1428,7 @@ func Rmdirs(ctx context.Context, f fs.Fs, dir string, leaveRoot bool) error {
 		err := TryRmdir(ctx, f, dir)
 		if err != nil {
 			err = fs.CountError(err)
-			fs.Errorf(dir, "Failed to rmdir: %v", err)
+			fs.GetLogger(ctx).Errorf(dir, "Failed to rmdir: %v", err)
 			return err
 		}
 	}
@@ -1307,7 +1466,7 @@ func compareDest(ctx context.Context, dst, src fs.Object, CompareDest fs.Fs) (No
 		return false, err
 	}
 	if Equal(ctx, src, CompareDestFile) {
-		fs.Debugf(src, "Destination found in --compare-dest, skipping")
+		fs.GetLogger(ctx).Debugf(src, "Destination found in --compare-dest, skipping")
 		return true, nil
 	}
 	return false, nil
@@ -1363,16 +1522,16 @@ func copyDest(ctx context.Context, fdst fs.Fs, dst, src fs.Object, CopyDest, bac
 			}
 			_, err := Copy(ctx, fdst, dst, remote, CopyDestFile)
 			if err != nil {
-				fs.Errorf(src, "Destination found in --copy-dest, error copying")
+				fs.GetLogger(ctx).Errorf(src, "Destination found in --copy-dest, error copying")
 				return false, nil
 			}
-			fs.Debugf(src, "Destination found in --copy-dest, using server-side copy")
+			fs.GetLogger(ctx).Debugf(src, "Destination found in --copy-dest, using server-side copy")
 			return true, nil
 		}
-		fs.Debugf(src, "Unchanged skipping")
+		fs.GetLogger(ctx).Debugf(src, "Unchanged skipping")
 		return true, nil
 	}
-	fs.Debugf(src, "Destination not found in --copy-dest")
+	fs.GetLogger(ctx).Debugf(src, "Destination not found in --copy-dest")
 	return false, nil
 }
 
@@ -1398,17 +1557,17 @@ func CompareOrCopyDest(ctx context.Context, fdst fs.Fs, dst, src fs.Object, Comp
 func NeedTransfer(ctx context.Context, dst, src fs.Object) bool {
 	ci := fs.GetConfig(ctx)
 	if dst == nil {
-		fs.Debugf(src, "Need to transfer - File not found at Destination")
+		fs.GetLogger(ctx).Debugf(src, "Need to transfer - File not found at Destination")
 		return true
 	}
 	// If we should ignore existing files, don't transfer
 	if ci.IgnoreExisting {
-		fs.Debugf(src, "Destination exists, skipping")
+		fs.GetLogger(ctx).Debugf(src, "Destination exists, skipping")
 		return false
 	}
 	// If we should upload unconditionally
 	if ci.IgnoreTimes {
-		fs.Debugf(src, "Transferring unconditionally as --ignore-times is in use")
+		fs.GetLogger(ctx).Debugf(src, "Transferring unconditionally as --ignore-times is in use")
 		return true
 	}
 	// If UpdateOlder is in effect, skip if dst is newer than src
@@ -1426,14 +1585,14 @@ func NeedTransfer(ctx context.Context, dst, src fs.Object) bool {
 		}
 		switch {
 		case dt >= modifyWindow:
-			fs.Debugf(src, "Destination is newer than source, skipping")
+			fs.GetLogger(ctx).Debugf(src, "Destination is newer than source, skipping")
 			return false
 		case dt <= -modifyWindow:
 			// force --checksum on for the check and do update modtimes by default
 			opt := defaultEqualOpt(ctx)
 			opt.forceModTimeMatch = true
 			if equal(ctx, src, dst, opt) {
-				fs.Debugf(src, "Unchanged skipping")
+				fs.GetLogger(ctx).Debugf(src, "Unchanged skipping")
 				return false
 			}
 		default:
@@ -1441,21 +1600,49 @@ func NeedTransfer(ctx context.Context, dst, src fs.Object) bool {
 			opt := defaultEqualOpt(ctx)
 			opt.sizeOnly = !ci.CheckSum
 			if equal(ctx, src, dst, opt) {
-				fs.Debugf(src, "Destination mod time is within %v of source and files identical, skipping", modifyWindow)
+				fs.GetLogger(ctx).Debugf(src, "Destination mod time is within %v of source and files identical, skipping", modifyWindow)
 				return false
 			}
-			fs.Debugf(src, "Destination mod time is within %v of source but files differ, transferring", modifyWindow)
+			fs.GetLogger(ctx).Debugf(src, "Destination mod time is within %v of source but files differ, transferring", modifyWindow)
 		}
 	} else {
 		// Check to see if changed or not
 		if Equal(ctx, src, dst) {
-			fs.Debugf(src, "Unchanged skipping")
+			fs.GetLogger(ctx).Debugf(src, "Unchanged skipping")
 			return false
 		}
 	}
 	return true
 }
 
+// TransferReason returns why src needs to be copied to dst, assuming
+// NeedTransfer has said that it does.  This is one of "new" if dst
+// doesn't exist, "ignore-times", "size", "modtime" or "hash".
+//
+// It doesn't read the hashes again so returns "hash" if the sizes and
+// modification times are the same.
+func TransferReason(ctx context.Context, dst, src fs.Object) string {
+	ci := fs.GetConfig(ctx)
+	switch {
+	case dst == nil:
+		return "new"
+	case ci.IgnoreTimes:
+		return "ignore-times"
+	case sizeDiffers(ctx, src, dst):
+		return "size"
+	case ci.CheckSum:
+		return "hash"
+	}
+	modifyWindow := fs.GetModifyWindow(ctx, src.Fs(), dst.Fs())
+	if modifyWindow != fs.ModTimeNotSupported {
+		dt := dst.ModTime(ctx).Sub(src.ModTime(ctx))
+		if dt >= modifyWindow || dt <= -modifyWindow {
+			return "modtime"
+		}
+	}
+	return "hash"
+}
+
 // RcatSize reads data from the Reader until EOF and uploads it to a file on remote.
 // Pass in size >=0 if known, <0 if not known
 func RcatSize(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser, size int64, modTime time.Time) (dst fs.Object, err error) {
@@ -1465,6 +1652,7 @@ func RcatSize(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadClo
 		var err error
 		// Size known use Put
 		tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, size)
+		tr.SetDst(fdst)
 		defer func() {
 			tr.Done(ctx, err)
 		}()
@@ -1480,7 +1668,7 @@ func RcatSize(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadClo
 		info := object.NewStaticObjectInfo(dstFileName, modTime, size, true, nil, fdst)
 		obj, err = fdst.Put(ctx, in, info)
 		if err != nil {
-			fs.Errorf(dstFileName, "Post request put error: %v", err)
+			fs.GetLogger(ctx).Errorf(dstFileName, "Post request put error: %v", err)
 
 			return nil, err
 		}
@@ -1488,7 +1676,7 @@ func RcatSize(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadClo
 		// Size unknown use Rcat
 		obj, err = Rcat(ctx, fdst, dstFileName, in, modTime)
 		if err != nil {
-			fs.Errorf(dstFileName, "Post request rcat error: %v", err)
+			fs.GetLogger(ctx).Errorf(dstFileName, "Post request rcat error: %v", err)
 
 			return nil, err
 		}
@@ -1551,6 +1739,9 @@ func CopyURLToWriter(ctx context.Context, url string, out io.Writer) (err error)
 // BackupDir returns the correctly configured --backup-dir
 func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string) (backupDir fs.Fs, err error) {
 	ci := fs.GetConfig(ctx)
+	if ci.BackupVersioning && ci.Suffix != "" {
+		return nil, fserrors.FatalError(errors.New("can't use --backup-versioning with --suffix"))
+	}
 	if ci.BackupDir != "" {
 		backupDir, err = cache.Get(ctx, ci.BackupDir)
 		if err != nil {
@@ -1567,7 +1758,7 @@ func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string)
 				return nil, fserrors.FatalError(errors.New("source and parameter to --backup-dir mustn't overlap"))
 			}
 		} else {
-			if ci.Suffix == "" {
+			if ci.Suffix == "" && !ci.BackupVersioning {
 				if SameDir(fdst, backupDir) {
 					return nil, fserrors.FatalError(errors.New("destination and parameter to --backup-dir mustn't be the same"))
 				}
@@ -1576,11 +1767,12 @@ func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string)
 				}
 			}
 		}
-	} else if ci.Suffix != "" {
-		// --backup-dir is not set but --suffix is - use the destination as the backupDir
+	} else if ci.Suffix != "" || ci.BackupVersioning {
+		// --backup-dir is not set but --suffix or --backup-versioning
+		// is - use the destination as the backupDir
 		backupDir = fdst
 	} else {
-		return nil, fserrors.FatalError(errors.New("internal error: BackupDir called when --backup-dir and --suffix both empty"))
+		return nil, fserrors.FatalError(errors.New("internal error: BackupDir called without --backup-dir, --suffix or --backup-versioning"))
 	}
 	if !CanServerSideMove(backupDir) {
 		return nil, fserrors.FatalError(errors.New("can't use --backup-dir on a remote which doesn't support server-side move or copy"))
@@ -1590,7 +1782,16 @@ func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string)
 
 // MoveBackupDir moves a file to the backup dir
 func MoveBackupDir(ctx context.Context, backupDir fs.Fs, dst fs.Object) (err error) {
-	remoteWithSuffix := SuffixName(ctx, dst.Remote())
+	ci := fs.GetConfig(ctx)
+	var remoteWithSuffix string
+	if ci.BackupVersioning {
+		remoteWithSuffix, err = VersionName(ctx, backupDir, dst.Remote())
+		if err != nil {
+			return err
+		}
+	} else {
+		remoteWithSuffix = SuffixName(ctx, dst.Remote())
+	}
 	overwritten, _ := backupDir.NewObject(ctx, remoteWithSuffix)
 	_, err = Move(ctx, backupDir, overwritten, remoteWithSuffix, dst)
 	return err
@@ -1602,7 +1803,7 @@ func moveOrCopyFile(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, dstFileName str
 	dstFilePath := path.Join(fdst.Root(), dstFileName)
 	srcFilePath := path.Join(fsrc.Root(), srcFileName)
 	if fdst.Name() == fsrc.Name() && dstFilePath == srcFilePath {
-		fs.Debugf(fdst, "don't need to copy/move %s, it is already at target location", dstFileName)
+		fs.GetLogger(ctx).Debugf(fdst, "don't need to copy/move %s, it is already at target location", dstFileName)
 		return nil
 	}
 
@@ -1656,7 +1857,7 @@ func moveOrCopyFile(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, dstFileName str
 	}
 
 	var backupDir, copyDestDir fs.Fs
-	if ci.BackupDir != "" || ci.Suffix != "" {
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning {
 		backupDir, err = BackupDir(ctx, fdst, fsrc, srcFileName)
 		if err != nil {
 			return errors.Wrap(err, "creating Fs for --backup-dir failed")
@@ -1714,12 +1915,12 @@ func SetTier(ctx context.Context, fsrc fs.Fs, tier string) error {
 	return ListFn(ctx, fsrc, func(o fs.Object) {
 		objImpl, ok := o.(fs.SetTierer)
 		if !ok {
-			fs.Errorf(fsrc, "Remote object does not implement SetTier")
+			fs.GetLogger(ctx).Errorf(fsrc, "Remote object does not implement SetTier")
 			return
 		}
 		err := objImpl.SetTier(tier)
 		if err != nil {
-			fs.Errorf(fsrc, "Failed to do SetTier, %v", err)
+			fs.GetLogger(ctx).Errorf(fsrc, "Failed to do SetTier, %v", err)
 		}
 	})
 }
@@ -1881,6 +2082,17 @@ func DirMove(ctx context.Context, f fs.Fs, srcRemote, dstRemote string) (err err
 		if err == nil {
 			accounting.Stats(ctx).Renames(1)
 		}
+		if audit.Enabled() {
+			entry := audit.Entry{
+				Action: audit.ActionDirMove,
+				Src:    audit.Name(f, srcRemote),
+				Dst:    audit.Name(f, dstRemote),
+			}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			audit.Record(ctx, entry)
+		}
 		return err
 	}
 
@@ -2013,18 +2225,18 @@ func skipDestructiveChoose(ctx context.Context, subject interface{}, action stri
 	case 's':
 		skip = true
 		skipped[action] = true
-		fs.Logf(nil, "Skipping all %s operations from now on without asking", action)
+		fs.GetLogger(ctx).Logf(nil, "Skipping all %s operations from now on without asking", action)
 	case '!':
 		skip = false
 		skipped[action] = false
-		fs.Logf(nil, "Doing all %s operations from now on without asking", action)
+		fs.GetLogger(ctx).Logf(nil, "Doing all %s operations from now on without asking", action)
 	case 'q':
-		fs.Logf(nil, "Quitting rclone now")
+		fs.GetLogger(ctx).Logf(nil, "Quitting rclone now")
 		atexit.Run()
 		os.Exit(0)
 	default:
 		skip = true
-		fs.Errorf(nil, "Bad choice %c", i)
+		fs.GetLogger(ctx).Errorf(nil, "Bad choice %c", i)
 	}
 	return skip
 }
@@ -2059,7 +2271,7 @@ func SkipDestructive(ctx context.Context, subject interface{}, action string) (s
 		return false
 	}
 	if skip {
-		fs.Logf(subject, "Skipped %s as %s is set", action, flag)
+		fs.GetLogger(ctx).Logf(subject, "Skipped %s as %s is set", action, flag)
 	}
 	return skip
 }
@@ -5,12 +5,20 @@ package operations
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/hashdb"
 	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSizeDiffers(t *testing.T) {
@@ -41,3 +49,63 @@ func TestSizeDiffers(t *testing.T) {
 		assert.Equal(t, test.want, got, fmt.Sprintf("ignoreSize=%v, srcSize=%v, dstSize=%v", test.ignoreSize, test.srcSize, test.dstSize))
 	}
 }
+
+func TestCheckHashesChecksumDB(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	dir, err := ioutil.TempDir("", "rclone-checksum-db-test")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = dir
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+
+	// Remotes without hashes
+	fsrc := mockfs.NewFs(ctx, "src", "")
+	fdst := mockfs.NewFs(ctx, "dst", "")
+	newObject := func(f fs.Fs, remote, content string) fs.Object {
+		o := mockobject.New(remote).WithContent([]byte(content), mockobject.SeekModeNone)
+		o.SetFs(f)
+		return o
+	}
+	src, dst, other := newObject(fsrc, "file.txt", "hello"), newObject(fdst, "file.txt", "hello"), newObject(fdst, "other.txt", "world")
+
+	equal, ht, err := CheckHashes(ctx, src, dst)
+	require.NoError(t, err)
+	assert.True(t, equal)
+	assert.Equal(t, hash.None, ht)
+
+	ci.ChecksumDB = true
+	equal, ht, err = CheckHashes(ctx, src, dst)
+	require.NoError(t, err)
+	assert.True(t, equal)
+	assert.Equal(t, hash.MD5, ht)
+	equal, _, err = CheckHashes(ctx, src, other)
+	require.NoError(t, err)
+	assert.False(t, equal)
+
+	// The hashes are used from the database without reading
+	db := hashdb.Default(ctx)
+	require.NotNil(t, db)
+	sum, found := db.Get(ctx, dst, hash.MD5)
+	assert.True(t, found)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", sum)
+	require.NoError(t, db.Put(ctx, dst, hash.MD5, "potato"))
+	equal, _, err = CheckHashes(ctx, src, dst)
+	require.NoError(t, err)
+	assert.False(t, equal)
+
+	// A copy is remembered under the hash of its source
+	rememberHash(ctx, src, other)
+	equal, _, err = CheckHashes(ctx, src, other)
+	require.NoError(t, err)
+	assert.True(t, equal)
+
+	forgetHash(ctx, other)
+	_, found = db.Get(ctx, other, hash.MD5)
+	assert.False(t, found)
+}
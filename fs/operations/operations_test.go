@@ -42,6 +42,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1062,6 +1063,29 @@ func TestSameConfig(t *testing.T) {
 	}
 }
 
+func TestServerSideCompatible(t *testing.T) {
+	ctx := context.Background()
+	a := &testFsInfo{name: "name", root: "root"}
+	b := &testFsInfo{name: "namey", root: "root"}
+	assert.True(t, operations.ServerSideCompatible(ctx, a, a))
+	assert.False(t, operations.ServerSideCompatible(ctx, a, b))
+	assert.False(t, operations.ServerSideCompatible(ctx, a, mockfs.NewFs(ctx, "potato", "root")))
+
+	a.features.ServerSideAcrossConfigs = true
+	assert.True(t, operations.ServerSideCompatible(ctx, a, b))
+	a.features.ServerSideAcrossConfigs = false
+
+	var asked fs.Info
+	a.features.CanServerSideFrom = func(ctx context.Context, src fs.Info) bool {
+		asked = src
+		return src.Root() == "root"
+	}
+	assert.True(t, operations.ServerSideCompatible(ctx, a, b))
+	assert.Equal(t, b, asked)
+	b.root = "rooty"
+	assert.False(t, operations.ServerSideCompatible(ctx, a, b))
+}
+
 func TestSame(t *testing.T) {
 	a := &testFsInfo{name: "name", root: "root"}
 	for _, test := range []struct {
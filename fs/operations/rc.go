@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/rc"
 )
 
@@ -114,6 +115,7 @@ func init() {
 		rc.Add(rc.Call{
 			Path:         "operations/" + strings.ToLower(name) + "file",
 			AuthRequired: true,
+			Resumable:    true,
 			Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
 				return rcMoveOrCopyFile(ctx, in, copy)
 			},
@@ -509,3 +511,84 @@ func rcBackend(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	out["result"] = result
 	return out, nil
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "operations/verify",
+		AuthRequired: true,
+		Fn:           rcVerify,
+		Title:        "Read back the objects in a remote to check for corruption",
+		Help: `This takes the following parameters
+
+- fs - a remote name string e.g. "drive:"
+- hashType - the hash to check e.g. "MD5" (optional)
+- sumFile - path of a local sum file to check against (optional)
+- sample - percentage of the objects to read, default 100 (optional)
+- reportOK - if set list the objects which were OK too (optional)
+
+Returns
+
+- summary - the number of objects found in each state
+- results - a list of the objects which weren't OK
+
+This is the equivalent of the [verify command](/commands/rclone_verify/).
+
+Run this with _async=true to scrub a remote in the background, eg
+reading a random 5% of it each night
+
+    rclone rc operations/verify fs=remote: sample=5 _async=true
+`,
+	})
+}
+
+// Verify the objects in a remote
+func rcVerify(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rc.GetFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	var opt VerifyOpt
+	hashType, err := in.GetString("hashType")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if hashType != "" {
+		err = opt.HashType.Set(hashType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sumFile, err := in.GetString("sumFile")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if sumFile != "" {
+		opt.Sums, err = ReadSumFile(sumFile)
+		if err != nil {
+			return nil, err
+		}
+		if opt.HashType == hash.None {
+			opt.HashType = hash.MD5
+		}
+	}
+	opt.Sample, err = in.GetFloat64("sample")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	opt.ReportOK, err = in.GetBool("reportOK")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	results := []VerifyResult{}
+	opt.Report = func(result VerifyResult) {
+		results = append(results, result)
+	}
+	summary, err := Verify(ctx, f, &opt)
+	if err != nil && summary.Listed == 0 {
+		return nil, err
+	}
+	return rc.Params{
+		"summary": summary,
+		"results": results,
+	}, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/fserrors"
 )
 
@@ -93,7 +94,9 @@ func (h *ReOpen) open() error {
 	if h.tries > h.maxTries {
 		h.err = errorTooManyTries
 	} else {
+		done := accounting.TimeCall(h.ctx, h.src.Fs(), accounting.CallDownload)
 		h.rc, h.err = h.src.Open(h.ctx, opts...)
+		done()
 	}
 	if h.err != nil {
 		if h.tries > 1 {
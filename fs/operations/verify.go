@@ -0,0 +1,312 @@
+// Verify the data of objects against their stored hashes
+
+package operations
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/hashdb"
+)
+
+// Results of verifying an object
+const (
+	VerifyOK           = "ok"           // the data matches the expected hash
+	VerifyCorrupt      = "corrupt"      // the data doesn't match the expected hash
+	VerifyMissing      = "missing"      // the object is in the sum file but not the remote
+	VerifyError        = "error"        // the object couldn't be read
+	VerifyUnverifiable = "unverifiable" // there is no hash to check the object against
+)
+
+// VerifyOpt are the options for Verify
+type VerifyOpt struct {
+	HashType hash.Type          // hash to check, or hash.None to pick one the remote supports
+	Sums     map[string]string  // expected hashes by path, nil to use the hashes stored on the remote
+	Sample   float64            // percentage of the objects to read
+	ReportOK bool               // report objects which are OK as well as problems
+	Report   func(VerifyResult) // called with the result of each object if set
+}
+
+// VerifyResult is the result of verifying one object
+type VerifyResult struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	HashType string `json:"hashType,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerifySummary counts the results of Verify
+type VerifySummary struct {
+	Listed       int64 `json:"listed"`       // objects listed
+	Verified     int64 `json:"verified"`     // objects read and checked
+	OK           int64 `json:"ok"`           // objects which matched
+	Corrupt      int64 `json:"corrupt"`      // objects which didn't match
+	Missing      int64 `json:"missing"`      // objects in the sum file not found
+	Errors       int64 `json:"errors"`       // objects which couldn't be read
+	Unverifiable int64 `json:"unverifiable"` // objects with no hash to check against
+}
+
+// ParseSums reads a sum file in the format written by md5sum,
+// sha1sum and rclone hashsum returning the hashes by path.
+func ParseSums(in io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.IndexByte(line, ' ')
+		if space <= 0 || len(line) < space+2 || (line[space+1] != ' ' && line[space+1] != '*') {
+			return nil, errors.Errorf("line %d: malformed sum line %q", lineNumber, line)
+		}
+		sums[line[space+2:]] = strings.ToLower(line[:space])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read sum file")
+	}
+	return sums, nil
+}
+
+// ReadSumFile reads the sum file at the local path sumFile with
+// ParseSums
+func ReadSumFile(sumFile string) (sums map[string]string, err error) {
+	in, err := os.Open(sumFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open sum file")
+	}
+	defer fs.CheckClose(in, &err)
+	return ParseSums(in)
+}
+
+// verifier holds the state for Verify
+type verifier struct {
+	opt      VerifyOpt
+	ht       hash.Type
+	db       *hashdb.DB // hashes from the --checksum-db if used
+	mu       sync.Mutex
+	summary  VerifySummary
+	seen     map[string]struct{} // paths listed when using Sums
+	reportMu sync.Mutex
+}
+
+// expected returns the hash o should have or "" if not known
+func (v *verifier) expected(ctx context.Context, o fs.Object) (string, error) {
+	if v.opt.Sums != nil {
+		return v.opt.Sums[o.Remote()], nil
+	}
+	if v.db != nil {
+		sum, _ := v.db.Get(ctx, o, v.ht)
+		return sum, nil
+	}
+	sum, err := o.Hash(ctx, v.ht)
+	if err == hash.ErrUnsupported {
+		return "", nil
+	}
+	return sum, err
+}
+
+// read reads o returning its hash of type ht
+func (v *verifier) read(ctx context.Context, o fs.Object) (sum string, err error) {
+	tr := accounting.Stats(ctx).NewTransfer(o)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	hasher, err := hash.NewMultiHasherTypes(hash.NewHashSet(v.ht))
+	if err != nil {
+		return "", err
+	}
+	in, err := NewReOpen(ctx, o, fs.GetConfig(ctx).LowLevelRetries)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open")
+	}
+	in = tr.Account(ctx, in).WithBuffer() // account and buffer the transfer
+	defer fs.CheckClose(in, &err)
+	_, err = io.Copy(hasher, in)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read")
+	}
+	return hasher.Sums()[v.ht], nil
+}
+
+// verify checks the object o
+func (v *verifier) verify(ctx context.Context, o fs.Object) {
+	result := VerifyResult{
+		Path:     o.Remote(),
+		HashType: v.ht.String(),
+	}
+	expected, err := v.expected(ctx, o)
+	switch {
+	case err != nil:
+		result.Status = VerifyError
+		result.Error = err.Error()
+	case expected == "":
+		result.Status = VerifyUnverifiable
+	default:
+		result.Expected = expected
+		result.Actual, err = v.read(ctx, o)
+		if err != nil {
+			result.Status = VerifyError
+			result.Error = err.Error()
+		} else if result.Actual != expected {
+			result.Status = VerifyCorrupt
+		} else {
+			result.Status = VerifyOK
+		}
+	}
+	v.add(ctx, o, result)
+}
+
+// add records result for o which may be nil
+func (v *verifier) add(ctx context.Context, o fs.Object, result VerifyResult) {
+	v.mu.Lock()
+	switch result.Status {
+	case VerifyOK:
+		v.summary.OK++
+	case VerifyCorrupt:
+		v.summary.Corrupt++
+	case VerifyMissing:
+		v.summary.Missing++
+	case VerifyError:
+		v.summary.Errors++
+	case VerifyUnverifiable:
+		v.summary.Unverifiable++
+	}
+	if result.Status == VerifyOK || result.Status == VerifyCorrupt {
+		v.summary.Verified++
+	}
+	v.mu.Unlock()
+	var logObject interface{} = result.Path
+	if o != nil {
+		logObject = o
+	}
+	switch result.Status {
+	case VerifyOK:
+		fs.GetLogger(ctx).Debugf(logObject, "Verified %v OK", v.ht)
+	case VerifyCorrupt:
+		err := fs.CountError(fserrors.WithCode(errors.Errorf("corrupted: %v is %q but should be %q", v.ht, result.Actual, result.Expected), fserrors.CodeChecksum))
+		fs.GetLogger(ctx).Errorf(logObject, "%v", err)
+	case VerifyMissing:
+		fs.GetLogger(ctx).Errorf(logObject, "%v", fs.CountError(errors.New("in the sum file but not found")))
+	case VerifyError:
+		fs.GetLogger(ctx).Errorf(logObject, "%v", fs.CountError(errors.New(result.Error)))
+	case VerifyUnverifiable:
+		fs.GetLogger(ctx).Infof(logObject, "No %v to verify against", v.ht)
+	}
+	if v.opt.Report != nil && (v.opt.ReportOK || result.Status != VerifyOK) {
+		v.reportMu.Lock()
+		v.opt.Report(result)
+		v.reportMu.Unlock()
+	}
+}
+
+// Verify reads the objects in f and checks their data against the
+// hashes stored on the remote or in opt.Sums.
+//
+// Remotes which calculate their hashes from the data, such as local
+// disks, can only be verified against a sum file or the hashes kept by
+// --checksum-db.
+//
+// If opt.Sample is less than 100 then only that percentage of the
+// objects, chosen at random, is read.
+//
+// It returns an error if any objects were corrupt, missing or
+// couldn't be read.
+func Verify(ctx context.Context, f fs.Fs, opt *VerifyOpt) (summary VerifySummary, err error) {
+	ci := fs.GetConfig(ctx)
+	v := &verifier{
+		opt: *opt,
+		ht:  opt.HashType,
+	}
+	if v.opt.Sample <= 0 || v.opt.Sample > 100 {
+		v.opt.Sample = 100
+	}
+	if v.opt.Sums != nil {
+		if v.ht == hash.None {
+			return summary, errors.New("need a hash type to verify with a sum file")
+		}
+		v.seen = make(map[string]struct{}, len(v.opt.Sums))
+	} else {
+		if v.ht == hash.None {
+			v.ht = f.Hashes().GetOne()
+		}
+		if f.Features().SlowHash || v.ht == hash.None || !f.Hashes().Contains(v.ht) {
+			v.db = hashdb.Default(ctx)
+			if v.db == nil {
+				return summary, errors.Errorf("%v doesn't store hashes - verify it with a sum file or --checksum-db", f)
+			}
+			if v.ht == hash.None {
+				v.ht = hash.MD5
+			}
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		objects = make(chan fs.Object, ci.Checkers)
+	)
+	wg.Add(ci.Checkers)
+	for i := 0; i < ci.Checkers; i++ {
+		go func() {
+			defer wg.Done()
+			for o := range objects {
+				v.verify(ctx, o)
+			}
+		}()
+	}
+	err = ListFn(ctx, f, func(o fs.Object) {
+		v.mu.Lock()
+		v.summary.Listed++
+		if v.seen != nil {
+			v.seen[o.Remote()] = struct{}{}
+		}
+		v.mu.Unlock()
+		if v.opt.Sample < 100 && rand.Float64()*100 >= v.opt.Sample {
+			return
+		}
+		objects <- o
+	})
+	close(objects)
+	wg.Wait()
+	if err != nil {
+		return v.summary, errors.Wrap(err, "failed to list")
+	}
+
+	// Objects in the sum file which weren't listed are missing unless
+	// they might have been filtered out
+	if v.seen != nil && filter.GetConfig(ctx).InActive() {
+		for remote, sum := range v.opt.Sums {
+			if _, found := v.seen[remote]; !found {
+				v.add(ctx, nil, VerifyResult{Path: remote, Status: VerifyMissing, HashType: v.ht.String(), Expected: sum})
+			}
+		}
+	}
+
+	summary = v.summary
+	fs.GetLogger(ctx).Infof(f, "Verified %d of %d objects: %d OK, %d corrupt, %d missing, %d errors, %d unverifiable",
+		summary.Verified, summary.Listed, summary.OK, summary.Corrupt, summary.Missing, summary.Errors, summary.Unverifiable)
+	if summary.Corrupt > 0 || summary.Missing > 0 || summary.Errors > 0 {
+		err = errors.Errorf("%d corrupt, %d missing and %d unreadable objects found", summary.Corrupt, summary.Missing, summary.Errors)
+		if summary.Corrupt > 0 {
+			err = fserrors.WithCode(err, fserrors.CodeChecksum)
+		}
+		return summary, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,97 @@
+package operations_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSums(t *testing.T) {
+	sums, err := operations.ParseSums(strings.NewReader(`# comment
+D41D8CD98F00B204E9800998ECF8427E  empty file
+5d41402abc4b2a76b9719d911017c592 *dir/hello
+
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"empty file": "d41d8cd98f00b204e9800998ecf8427e",
+		"dir/hello":  "5d41402abc4b2a76b9719d911017c592",
+	}, sums)
+
+	_, err = operations.ParseSums(strings.NewReader("nospace\n"))
+	assert.EqualError(t, err, `line 1: malformed sum line "nospace"`)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	defer accounting.GlobalStats().ResetCounters()
+
+	r.WriteFile("ok", "hello", t1)
+	r.WriteFile("dir/corrupt", "hellO", t1)
+	r.WriteFile("unknown", "world", t1)
+
+	const helloMD5 = "5d41402abc4b2a76b9719d911017c592"
+	opt := operations.VerifyOpt{
+		HashType: hash.MD5,
+		Sums: map[string]string{
+			"ok":          helloMD5,
+			"dir/corrupt": helloMD5,
+			"missing":     helloMD5,
+		},
+		ReportOK: true,
+	}
+	var results []operations.VerifyResult
+	opt.Report = func(result operations.VerifyResult) {
+		results = append(results, result)
+	}
+	summary, err := operations.Verify(ctx, r.Flocal, &opt)
+	require.Error(t, err)
+	assert.Equal(t, fserrors.CodeChecksum, fserrors.ErrorCode(err))
+	assert.Equal(t, operations.VerifySummary{
+		Listed:       3,
+		Verified:     2,
+		OK:           1,
+		Corrupt:      1,
+		Missing:      1,
+		Unverifiable: 1,
+	}, summary)
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Path] = result.Status
+	}
+	assert.Equal(t, map[string]string{
+		"ok":          operations.VerifyOK,
+		"dir/corrupt": operations.VerifyCorrupt,
+		"missing":     operations.VerifyMissing,
+		"unknown":     operations.VerifyUnverifiable,
+	}, statuses)
+	assert.Equal(t, helloMD5, results[0].Expected)
+	assert.NotEqual(t, helloMD5, results[0].Actual)
+
+	// Only problems are reported without ReportOK and sampling
+	// nothing reads nothing
+	results = nil
+	opt.ReportOK = false
+	opt.Sample = 0.0001
+	opt.Sums = map[string]string{"ok": helloMD5}
+	summary, err = operations.Verify(ctx, r.Flocal, &opt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Listed)
+	assert.Equal(t, int64(0), summary.Verified)
+	assert.Len(t, results, 0)
+}
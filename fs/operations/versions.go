@@ -0,0 +1,169 @@
+// versions - manage the versions of files kept by --backup-versioning
+
+package operations
+
+import (
+	"context"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/version"
+)
+
+// VersionName returns a name for a new version of remote in f which
+// isn't in use, made by adding the current time to remote.
+func VersionName(ctx context.Context, f fs.Fs, remote string) (string, error) {
+	t := time.Now()
+	for tries := 0; tries < 100; tries++ {
+		name := version.Add(remote, t)
+		_, err := f.NewObject(ctx, name)
+		if err == fs.ErrorObjectNotFound {
+			return name, nil
+		} else if err != nil {
+			return "", errors.Wrap(err, "failed to check for existing version")
+		}
+		// Try the next millisecond
+		t = t.Add(time.Millisecond)
+	}
+	return "", errors.Errorf("couldn't find an unused version name for %q", remote)
+}
+
+// Version is a version of a file kept by --backup-versioning
+type Version struct {
+	Object fs.Object // the version
+	Time   time.Time // when the version was replaced or deleted
+}
+
+// Versions is the versions of one file, oldest first
+type Versions []Version
+
+// ListVersions returns the versions in f found in dir, or all of f if
+// dir is "", keyed by the name of the file they are a version of.
+//
+// If recurse is false only the versions in dir are returned.
+func ListVersions(ctx context.Context, f fs.Fs, dir string, recurse bool) (map[string]Versions, error) {
+	ci := fs.GetConfig(ctx)
+	maxLevel := ci.MaxDepth
+	if !recurse {
+		maxLevel = 1
+	}
+	var mu sync.Mutex
+	versions := make(map[string]Versions)
+	err := walk.ListR(ctx, f, dir, false, maxLevel, walk.ListObjects, func(entries fs.DirEntries) error {
+		mu.Lock()
+		defer mu.Unlock()
+		entries.ForObject(func(o fs.Object) {
+			t, remote := version.Remove(o.Remote())
+			if t.IsZero() {
+				return
+			}
+			versions[remote] = append(versions[remote], Version{Object: o, Time: t})
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list versions")
+	}
+	for _, vs := range versions {
+		sort.Slice(vs, func(i, j int) bool {
+			return vs[i].Time.Before(vs[j].Time)
+		})
+	}
+	return versions, nil
+}
+
+// RestoreVersion restores remote in f from one of its versions.
+//
+// If at is zero the newest version is restored, otherwise the file is
+// restored to how it was at that time.  The file being replaced is
+// kept as a new version so the restore can be undone.
+func RestoreVersion(ctx context.Context, f fs.Fs, remote string, at time.Time) error {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	versions, err := ListVersions(ctx, f, dir, false)
+	if err != nil {
+		return err
+	}
+	vs := versions[remote]
+	if len(vs) == 0 {
+		return errors.Errorf("no versions of %q found", remote)
+	}
+	var restore *Version
+	if at.IsZero() {
+		restore = &vs[len(vs)-1]
+	} else {
+		// A version holds the file as it was until it was
+		// replaced so find the first one replaced after at
+		for i := range vs {
+			if vs[i].Time.After(at) {
+				restore = &vs[i]
+				break
+			}
+		}
+		if restore == nil {
+			return errors.Errorf("no version of %q was replaced after %v", remote, at)
+		}
+	}
+	current, err := f.NewObject(ctx, remote)
+	if err == nil {
+		var versionName string
+		versionName, err = VersionName(ctx, f, remote)
+		if err != nil {
+			return err
+		}
+		_, err = Move(ctx, f, nil, versionName, current)
+		if err != nil {
+			return errors.Wrap(err, "failed to keep current file as a version")
+		}
+	} else if err != fs.ErrorObjectNotFound {
+		return err
+	}
+	fs.Infof(f, "Restoring %q from version %q", remote, restore.Object.Remote())
+	_, err = Copy(ctx, f, nil, remote, restore.Object)
+	return err
+}
+
+// PurgeVersions deletes the versions in f.
+//
+// The newest keep versions of each file are kept, and if olderThan is
+// set only versions replaced more than olderThan ago are deleted.
+func PurgeVersions(ctx context.Context, f fs.Fs, keep int, olderThan fs.Duration) error {
+	if keep < 0 {
+		return errors.New("number of versions to keep can't be negative")
+	}
+	versions, err := ListVersions(ctx, f, "", true)
+	if err != nil {
+		return err
+	}
+	remotes := make([]string, 0, len(versions))
+	for remote := range versions {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	now := time.Now()
+	toBeDeleted := make(fs.ObjectsChan, fs.GetConfig(ctx).Transfers)
+	go func() {
+		defer close(toBeDeleted)
+		for _, remote := range remotes {
+			vs := versions[remote]
+			for i := 0; i < len(vs)-keep; i++ {
+				if olderThan.IsSet() && now.Sub(vs[i].Time) < time.Duration(olderThan) {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case toBeDeleted <- vs[i].Object:
+				}
+			}
+		}
+	}()
+	return DeleteFiles(ctx, toBeDeleted)
+}
@@ -0,0 +1,108 @@
+package operations_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	versionTime1 = time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	versionTime2 = time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+)
+
+// makeVersions writes file.txt with two versions and returns them
+func makeVersions(ctx context.Context, r *fstest.Run) (current, v1, v2 fstest.Item) {
+	current = r.WriteObject(ctx, "dir/file.txt", "current", t3)
+	v1 = r.WriteObject(ctx, version.Add("dir/file.txt", versionTime1), "one", t1)
+	v2 = r.WriteObject(ctx, version.Add("dir/file.txt", versionTime2), "two!", t2)
+	return current, v1, v2
+}
+
+func TestListVersions(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	_, v1, v2 := makeVersions(ctx, r)
+	r.WriteObject(ctx, "other.txt", "other", t1)
+
+	versions, err := operations.ListVersions(ctx, r.Fremote, "", true)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	vs := versions["dir/file.txt"]
+	require.Len(t, vs, 2)
+	assert.Equal(t, v1.Path, vs[0].Object.Remote())
+	assert.True(t, versionTime1.Equal(vs[0].Time))
+	assert.Equal(t, v2.Path, vs[1].Object.Remote())
+	assert.True(t, versionTime2.Equal(vs[1].Time))
+
+	versions, err = operations.ListVersions(ctx, r.Fremote, "", false)
+	require.NoError(t, err)
+	assert.Len(t, versions, 0)
+}
+
+func TestRestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	_, v1, v2 := makeVersions(ctx, r)
+
+	// Restore the newest version
+	require.NoError(t, operations.RestoreVersion(ctx, r.Fremote, "dir/file.txt", time.Time{}))
+	obj, err := r.Fremote.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), obj.Size())
+
+	// The current file was kept as a version
+	versions, err := operations.ListVersions(ctx, r.Fremote, "dir", false)
+	require.NoError(t, err)
+	vs := versions["dir/file.txt"]
+	require.Len(t, vs, 3)
+	assert.Equal(t, v1.Path, vs[0].Object.Remote())
+	assert.Equal(t, v2.Path, vs[1].Object.Remote())
+	assert.Equal(t, int64(7), vs[2].Object.Size())
+
+	// Restore to how it was between the versions
+	require.NoError(t, operations.RestoreVersion(ctx, r.Fremote, "dir/file.txt", versionTime1.Add(time.Hour)))
+	obj, err = r.Fremote.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), obj.Size())
+
+	// Restore to before the first version
+	require.NoError(t, operations.RestoreVersion(ctx, r.Fremote, "dir/file.txt", versionTime1.Add(-time.Hour)))
+	obj, err = r.Fremote.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), obj.Size())
+
+	// Errors
+	assert.Error(t, operations.RestoreVersion(ctx, r.Fremote, "dir/file.txt", time.Now().Add(time.Hour)))
+	assert.Error(t, operations.RestoreVersion(ctx, r.Fremote, "dir/potato.txt", time.Time{}))
+}
+
+func TestPurgeVersions(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	current, _, v2 := makeVersions(ctx, r)
+
+	assert.Error(t, operations.PurgeVersions(ctx, r.Fremote, -1, fs.DurationOff))
+
+	// Nothing is old enough
+	require.NoError(t, operations.PurgeVersions(ctx, r.Fremote, 0, fs.Duration(100*365*24*time.Hour)))
+	versions, err := operations.ListVersions(ctx, r.Fremote, "", true)
+	require.NoError(t, err)
+	assert.Len(t, versions["dir/file.txt"], 2)
+
+	require.NoError(t, operations.PurgeVersions(ctx, r.Fremote, 1, fs.DurationOff))
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{current, v2}, nil, fs.ModTimeNotSupported)
+
+	require.NoError(t, operations.PurgeVersions(ctx, r.Fremote, 0, fs.DurationOff))
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{current}, nil, fs.ModTimeNotSupported)
+}
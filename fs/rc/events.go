@@ -0,0 +1,84 @@
+// Publish events to the clients of the rc event stream
+
+package rc
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of Event
+const (
+	EventStats = "stats" // the transfer stats
+	EventLog   = "log"   // a log message
+	EventJob   = "job"   // a job started or finished
+)
+
+// Event is something which happened which is sent to the clients of
+// the rc event stream
+type Event struct {
+	Type string    `json:"type"` // EventStats, EventLog or EventJob
+	Time time.Time `json:"time"`
+	Data Params    `json:"data"`
+}
+
+// eventBufferSize is the number of events which can be queued for a
+// subscriber before events are dropped
+const eventBufferSize = 256
+
+// events holds the subscribers to the events
+var events = struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}{
+	subscribers: make(map[chan Event]struct{}),
+}
+
+// HasEventSubscribers returns true if anything is subscribed to the
+// events.  Use it to avoid the work of making events nobody wants.
+func HasEventSubscribers() bool {
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	return len(events.subscribers) != 0
+}
+
+// PublishEvent sends an event of eventType with data to all the
+// subscribers.
+//
+// It never blocks - if a subscriber isn't keeping up then the event
+// is dropped for that subscriber.
+func PublishEvent(eventType string, data Params) {
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	if len(events.subscribers) == 0 {
+		return
+	}
+	event := Event{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+	for ch := range events.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents returns a channel which receives the events
+// published until unsubscribe is called.
+func SubscribeEvents() (ch <-chan Event, unsubscribe func()) {
+	c := make(chan Event, eventBufferSize)
+	events.mu.Lock()
+	events.subscribers[c] = struct{}{}
+	events.mu.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			events.mu.Lock()
+			delete(events.subscribers, c)
+			events.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package rc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	assert.False(t, HasEventSubscribers())
+	// Publishing with no subscribers does nothing
+	PublishEvent(EventLog, Params{"msg": "nobody"})
+
+	ch1, unsubscribe1 := SubscribeEvents()
+	ch2, unsubscribe2 := SubscribeEvents()
+	assert.True(t, HasEventSubscribers())
+
+	PublishEvent(EventJob, Params{"id": 1})
+	for _, ch := range []<-chan Event{ch1, ch2} {
+		event := <-ch
+		assert.Equal(t, EventJob, event.Type)
+		assert.Equal(t, Params{"id": 1}, event.Data)
+		assert.False(t, event.Time.IsZero())
+	}
+
+	unsubscribe1()
+	unsubscribe1() // check it is safe to call twice
+	PublishEvent(EventLog, Params{"msg": "two"})
+	assert.Equal(t, 0, len(ch1))
+	assert.Equal(t, "two", (<-ch2).Data["msg"])
+
+	// A subscriber which doesn't keep up loses events rather than
+	// blocking the publisher
+	for i := 0; i < eventBufferSize+10; i++ {
+		PublishEvent(EventLog, Params{"i": i})
+	}
+	assert.Equal(t, eventBufferSize, len(ch2))
+
+	unsubscribe2()
+	assert.False(t, HasEventSubscribers())
+}
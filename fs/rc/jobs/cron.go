@@ -0,0 +1,201 @@
+// Parse cron expressions for the job scheduler
+
+package jobs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSpec is a parsed cron expression.  Each field is a bitmap of
+// the values which match.
+type cronSpec struct {
+	minute uint64 // bits 0-59
+	hour   uint64 // bits 0-23
+	dom    uint64 // bits 1-31
+	month  uint64 // bits 1-12
+	dow    uint64 // bits 0-6, Sunday is 0
+	// set if the day of month or day of week field was "*" - if
+	// both are restricted a day matches if either matches
+	domStar bool
+	dowStar bool
+}
+
+// cronField describes one of the fields of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names for the values starting at min if set
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// day of week allows 7 for Sunday as well as 0
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cronMacros are the @ shortcuts for common expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a standard 5 field cron expression
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field may be "*", a number, a range "a-b", a list "a,b,c" or
+// any of these with a step "/n".  Months and days of the week may be
+// given as three letter names.  The @hourly, @daily, @weekly,
+// @monthly and @yearly shortcuts are also accepted.
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		macro, ok := cronMacros[strings.ToLower(expr)]
+		if !ok {
+			return nil, errors.Errorf("unknown cron shortcut %q", expr)
+		}
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression %q must have 5 fields but has %d", expr, len(fields))
+	}
+	c := &cronSpec{}
+	var err error
+	for i, p := range []struct {
+		field cronField
+		bits  *uint64
+	}{
+		{cronMinute, &c.minute},
+		{cronHour, &c.hour},
+		{cronDom, &c.dom},
+		{cronMonth, &c.month},
+		{cronDow, &c.dow},
+	} {
+		*p.bits, err = p.field.parse(fields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad cron expression %q", expr)
+		}
+	}
+	// Sunday may be 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow = (c.dow | 1) &^ (1 << 7)
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return c, nil
+}
+
+// parse the value of the field into a bitmap
+func (f cronField) parse(s string) (bits uint64, err error) {
+	for _, part := range strings.Split(s, ",") {
+		rangeStr, stepStr := part, ""
+		if i := strings.IndexRune(part, '/'); i >= 0 {
+			rangeStr, stepStr = part[:i], part[i+1:]
+		}
+		step := 1
+		if stepStr != "" {
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("bad step %q in %s field", stepStr, f.name)
+			}
+		}
+		var start, end int
+		switch {
+		case rangeStr == "*":
+			start, end = f.min, f.max
+		case strings.ContainsRune(rangeStr, '-'):
+			i := strings.IndexRune(rangeStr, '-')
+			start, err = f.value(rangeStr[:i])
+			if err != nil {
+				return 0, err
+			}
+			end, err = f.value(rangeStr[i+1:])
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, errors.Errorf("bad range %q in %s field", rangeStr, f.name)
+			}
+		default:
+			start, err = f.value(rangeStr)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			// "a/n" means from a to the end in steps of n
+			if stepStr != "" {
+				end = f.max
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single number or name in the field
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("bad value %q in %s field", s, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, errors.Errorf("value %d out of range %d-%d in %s field", v, f.min, f.max, f.name)
+	}
+	return v, nil
+}
+
+// dayMatches returns true if the day of t matches the day of month
+// and day of week fields
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t which matches the expression
+// or the zero time if there isn't one in the next 5 years.
+func (c *cronSpec) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	} {
+		_, err := parseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	start := time.Date(2020, 1, 15, 10, 30, 20, 0, time.UTC)
+	for _, test := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2020, 1, 15, 11, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2020, 1, 16, 3, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2020, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,20 * 5", time.Date(2020, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		c, err := parseCron(test.expr)
+		require.NoError(t, err, test.expr)
+		assert.Equal(t, test.want, c.next(start), test.expr)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/audit"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/rc"
 )
 
@@ -24,6 +26,7 @@ type Job struct {
 	StartTime time.Time `json:"startTime"`
 	EndTime   time.Time `json:"endTime"`
 	Error     string    `json:"error"`
+	ErrorCode string    `json:"errorCode"`
 	Finished  bool      `json:"finished"`
 	Success   bool      `json:"success"`
 	Duration  float64   `json:"duration"`
@@ -40,8 +43,10 @@ type Job struct {
 type Jobs struct {
 	mu            sync.RWMutex
 	jobs          map[int64]*Job
+	saved         map[int64]*SavedJob // jobs which can be resumed
 	opt           *rc.Options
 	expireRunning bool
+	stateMu       sync.Mutex // held while writing the state file
 }
 
 var (
@@ -52,8 +57,9 @@ var (
 // newJobs makes a new Jobs structure
 func newJobs() *Jobs {
 	return &Jobs{
-		jobs: map[int64]*Job{},
-		opt:  &rc.DefaultOpt,
+		jobs:  map[int64]*Job{},
+		saved: map[int64]*SavedJob{},
+		opt:   &rc.DefaultOpt,
 	}
 }
 
@@ -129,15 +135,35 @@ func (job *Job) finish(out rc.Params, err error) {
 	if err != nil {
 		job.realErr = err
 		job.Error = err.Error()
+		job.ErrorCode = string(fserrors.ErrorCode(err))
 		job.Success = false
 	} else {
 		job.realErr = nil
 		job.Error = ""
+		job.ErrorCode = ""
 		job.Success = true
 	}
 	job.Finished = true
+	job.publish()
 	job.mu.Unlock()
-	running.kickExpire() // make sure this job gets expired
+	running.unsave(job.ID) // it doesn't need resuming now
+	running.kickExpire()   // make sure this job gets expired
+}
+
+// publish sends the state of the job to the rc event stream
+//
+// Call with job.mu held
+func (job *Job) publish() {
+	if !rc.HasEventSubscribers() {
+		return
+	}
+	data := make(rc.Params)
+	err := rc.Reshape(&data, job)
+	if err != nil {
+		fs.Errorf(nil, "Failed to publish job %d event: %v", job.ID, err)
+		return
+	}
+	rc.PublishEvent(rc.EventJob, data)
 }
 
 // run the job until completion writing the return status
@@ -162,13 +188,21 @@ func getGroup(in rc.Params) string {
 
 // NewAsyncJob start a new asynchronous Job off
 func (jobs *Jobs) NewAsyncJob(fn rc.Func, in rc.Params) *Job {
-	id := atomic.AddInt64(&jobID, 1)
+	return jobs.newAsyncJob(atomic.AddInt64(&jobID, 1), fn, in, nil)
+}
 
+// newAsyncJob starts a new asynchronous Job off with the id given
+//
+// If saved is not nil then it is saved in the state file until the
+// job finishes.
+func (jobs *Jobs) newAsyncJob(id int64, fn rc.Func, in rc.Params, saved *SavedJob) *Job {
 	group := getGroup(in)
 	if group == "" {
 		group = fmt.Sprintf("job/%d", id)
 	}
 	ctx := accounting.WithStatsGroup(context.Background(), group)
+	ctx = audit.WithSource(ctx, audit.Source{Trigger: audit.TriggerRC, JobID: id})
+	ctx = fs.WithLogValue(ctx, "jobid", id)
 	ctx, cancel := context.WithCancel(ctx)
 	stop := func() {
 		cancel()
@@ -183,7 +217,19 @@ func (jobs *Jobs) NewAsyncJob(fn rc.Func, in rc.Params) *Job {
 	}
 	jobs.mu.Lock()
 	jobs.jobs[job.ID] = job
+	if saved != nil {
+		saved.ID = job.ID
+		saved.Group = group
+		saved.StartTime = job.StartTime
+		jobs.saved[job.ID] = saved
+	}
 	jobs.mu.Unlock()
+	if saved != nil {
+		jobs.writeState()
+	}
+	job.mu.Lock()
+	job.publish()
+	job.mu.Unlock()
 	go job.run(ctx, fn, in)
 	return job
 }
@@ -196,6 +242,8 @@ func (jobs *Jobs) NewSyncJob(ctx context.Context, in rc.Params) (*Job, context.C
 		group = fmt.Sprintf("job/%d", id)
 	}
 	ctxG := accounting.WithStatsGroup(ctx, fmt.Sprintf("job/%d", id))
+	ctxG = audit.WithSource(ctxG, audit.Source{Trigger: audit.TriggerRC, JobID: id})
+	ctxG = fs.WithLogValue(ctxG, "jobid", id)
 	ctx, cancel := context.WithCancel(ctxG)
 	stop := func() {
 		cancel()
@@ -211,6 +259,9 @@ func (jobs *Jobs) NewSyncJob(ctx context.Context, in rc.Params) (*Job, context.C
 	jobs.mu.Lock()
 	jobs.jobs[job.ID] = job
 	jobs.mu.Unlock()
+	job.mu.Lock()
+	job.publish()
+	job.mu.Unlock()
 	return job, ctx
 }
 
@@ -246,6 +297,7 @@ Results
 - duration - time in seconds that the job ran for
 - endTime - time the job finished (e.g. "2018-10-26T18:50:20.528746884+01:00")
 - error - error from the job or empty string for no error
+- errorCode - code for the class of the error, e.g. "ERR_AUTH", or empty string for no error
 - finished - boolean whether the job has finished or not
 - id - as passed in above
 - startTime - time the job started (e.g. "2018-10-26T18:50:20.528336039+01:00")
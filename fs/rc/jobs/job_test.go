@@ -346,3 +346,30 @@ func TestRcSyncJobStop(t *testing.T) {
 	assert.Equal(t, true, out["finished"])
 	assert.Equal(t, false, out["success"])
 }
+
+func TestJobEvents(t *testing.T) {
+	events, unsubscribe := rc.SubscribeEvents()
+	defer unsubscribe()
+	jobs := newJobs()
+	job := jobs.NewAsyncJob(func(ctx context.Context, in rc.Params) (rc.Params, error) {
+		return rc.Params{"a": 1}, nil
+	}, rc.Params{})
+
+	// Other jobs may be running so look for the events of this one
+	var got []rc.Event
+	timeout := time.After(10 * time.Second)
+	for len(got) < 2 {
+		select {
+		case event := <-events:
+			if event.Type == rc.EventJob && event.Data["id"] == float64(job.ID) {
+				got = append(got, event)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for job events")
+		}
+	}
+	assert.Equal(t, false, got[0].Data["finished"])
+	assert.Equal(t, true, got[1].Data["finished"])
+	assert.Equal(t, true, got[1].Data["success"])
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, got[1].Data["output"])
+}
@@ -0,0 +1,299 @@
+// Save the resumable jobs so they can be resumed after a restart
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// SavedJob is an async job of a resumable rc call which can be
+// started again with the same parameters
+type SavedJob struct {
+	ID        int64     `json:"id"`
+	Group     string    `json:"group"`
+	Command   string    `json:"command"`
+	Params    rc.Params `json:"params"`
+	StartTime time.Time `json:"startTime"`
+}
+
+// copyParams makes a deep copy of in which will survive being
+// written to disk, returning an error if it can't be
+func copyParams(in rc.Params) (rc.Params, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	out := rc.Params{}
+	err = json.Unmarshal(data, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// newSavedJob makes a SavedJob for call with the parameters in or
+// returns nil if the call can't be resumed
+func newSavedJob(call *rc.Call, in rc.Params) *SavedJob {
+	if !call.Resumable {
+		return nil
+	}
+	params, err := copyParams(in)
+	if err != nil {
+		fs.Debugf(nil, "rc: %q: can't save job so it won't be resumed: %v", call.Path, err)
+		return nil
+	}
+	delete(params, "_group")
+	return &SavedJob{
+		Command: call.Path,
+		Params:  params,
+	}
+}
+
+// StartAsyncCall starts a new job for the call asynchronously and
+// returns a Param suitable for output.
+//
+// If the call is resumable then the job is saved in the state file
+// until it has finished.
+func StartAsyncCall(call *rc.Call, in rc.Params) (rc.Params, error) {
+	job := running.newAsyncJob(atomic.AddInt64(&jobID, 1), call.Fn, in, newSavedJob(call, in))
+	return rc.Params{"jobid": job.ID}, nil
+}
+
+// unsave removes the job from the saved jobs
+func (jobs *Jobs) unsave(id int64) {
+	jobs.mu.Lock()
+	_, found := jobs.saved[id]
+	delete(jobs.saved, id)
+	jobs.mu.Unlock()
+	if found {
+		jobs.writeState()
+	}
+}
+
+// savedJobs returns a copy of the saved jobs sorted by ID
+func (jobs *Jobs) savedJobs() []SavedJob {
+	jobs.mu.RLock()
+	list := make([]SavedJob, 0, len(jobs.saved))
+	for _, saved := range jobs.saved {
+		list = append(list, *saved)
+	}
+	jobs.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// stateFile returns the name of the state file or "" if not set
+func (jobs *Jobs) stateFile() string {
+	jobs.mu.RLock()
+	defer jobs.mu.RUnlock()
+	return jobs.opt.JobStateFile
+}
+
+// writeState writes the saved jobs to the state file if there is one
+func (jobs *Jobs) writeState() {
+	stateFile := jobs.stateFile()
+	if stateFile == "" {
+		return
+	}
+	jobs.stateMu.Lock()
+	defer jobs.stateMu.Unlock()
+	err := writeStateFile(stateFile, jobs.savedJobs())
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to save jobs: %v", err)
+	}
+}
+
+// writeStateFile writes the jobs to stateFile atomically
+func writeStateFile(stateFile string, list []SavedJob) error {
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(stateFile), 0700)
+	if err != nil {
+		return err
+	}
+	tmpFile := stateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, stateFile)
+}
+
+// readStateFile reads the jobs from stateFile - it isn't an error
+// for it not to exist
+func readStateFile(stateFile string) (list []SavedJob, err error) {
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q", stateFile)
+	}
+	return list, nil
+}
+
+// findResumable returns the resumable call for the saved job
+func findResumable(saved *SavedJob) (*rc.Call, error) {
+	call := rc.Calls.Get(saved.Command)
+	if call == nil {
+		return nil, errors.Errorf("couldn't find command %q", saved.Command)
+	}
+	if !call.Resumable {
+		return nil, errors.Errorf("command %q can't be resumed", saved.Command)
+	}
+	return call, nil
+}
+
+// bumpJobID makes sure new jobs get IDs bigger than id
+func bumpJobID(id int64) {
+	for {
+		old := atomic.LoadInt64(&jobID)
+		if old >= id || atomic.CompareAndSwapInt64(&jobID, old, id) {
+			return
+		}
+	}
+}
+
+// start starts the saved job with the id given
+func (jobs *Jobs) start(id int64, saved SavedJob) (*Job, error) {
+	call, err := findResumable(&saved)
+	if err != nil {
+		return nil, err
+	}
+	in, err := copyParams(saved.Params)
+	if err != nil {
+		return nil, err
+	}
+	if saved.Group != "" {
+		in["_group"] = saved.Group
+	}
+	return jobs.newAsyncJob(id, call.Fn, in, newSavedJob(call, in)), nil
+}
+
+// ResumeJobs starts the jobs saved in the state file again with the
+// same IDs.  It should be called once when the rc server starts.
+func ResumeJobs(ctx context.Context) error {
+	stateFile := running.stateFile()
+	if stateFile == "" {
+		return nil
+	}
+	list, err := readStateFile(stateFile)
+	if err != nil {
+		return err
+	}
+	for _, saved := range list {
+		bumpJobID(saved.ID)
+	}
+	for _, saved := range list {
+		if running.Get(saved.ID) != nil {
+			continue
+		}
+		_, err := running.start(saved.ID, saved)
+		if err != nil {
+			fs.Errorf(nil, "rc: couldn't resume job %d: %v", saved.ID, err)
+			continue
+		}
+		fs.Logf(nil, "rc: resumed job %d %q started at %v", saved.ID, saved.Command, saved.StartTime)
+	}
+	if len(list) > 0 {
+		// write the state in case any jobs couldn't be resumed
+		running.writeState()
+	}
+	return nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/export",
+		AuthRequired: true,
+		Fn:           rcJobExport,
+		Title:        "Export the unfinished resumable jobs",
+		Help: `This returns the jobs which haven't finished yet and can be
+resumed, such as sync/copy.  Pass the output to job/import to start
+them again, e.g. on another rclone rcd.
+
+Parameters - None
+
+Results
+
+- jobs - array of jobs, each with
+    - id - id of the job
+    - group - stats group of the job
+    - command - the rc command, e.g. "sync/copy"
+    - params - the parameters the job was started with
+    - startTime - time the job started
+`,
+	})
+}
+
+// Exports the unfinished resumable jobs
+func rcJobExport(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	return rc.Params{"jobs": running.savedJobs()}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/import",
+		AuthRequired: true,
+		Fn:           rcJobImport,
+		Title:        "Import jobs exported with job/export and start them",
+		Help: `This starts the jobs exported with job/export as new async jobs.
+Only resumable commands, such as sync/copy, can be imported.
+
+Parameters
+
+- jobs - array of jobs as returned by job/export
+
+Results
+
+- jobids - array of the ids of the new jobs
+`,
+	})
+}
+
+// Imports jobs and starts them
+func rcJobImport(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	var list []SavedJob
+	err = in.GetStruct("jobs", &list)
+	if err != nil {
+		return nil, err
+	}
+	// Check all the jobs first so none are started if any are bad
+	for i := range list {
+		if _, err := findResumable(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	jobIDs := []int64{}
+	for _, saved := range list {
+		// Drop the default group as the job gets a new ID
+		if saved.Group == fmt.Sprintf("job/%d", saved.ID) {
+			saved.Group = ""
+		}
+		job, err := running.start(atomic.AddInt64(&jobID, 1), saved)
+		if err != nil {
+			return nil, err
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+	return rc.Params{"jobids": jobIDs}, nil
+}
@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResumeRelease releases the jobs/test-resume call with the
+// error sent
+var testResumeRelease = make(chan error)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:      "jobs/test-resume",
+		Resumable: true,
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			return rc.Params{"in": in["p"]}, <-testResumeRelease
+		},
+	})
+}
+
+// setStateFile points the job state file into a temporary directory
+// - call the returned function to undo it
+func setStateFile(t *testing.T) (stateFile string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-jobs")
+	require.NoError(t, err)
+	running.mu.Lock()
+	oldOpt := running.opt
+	opt := *running.opt
+	opt.JobStateFile = filepath.Join(dir, "sub", "jobs.json")
+	running.opt = &opt
+	running.mu.Unlock()
+	return opt.JobStateFile, func() {
+		running.mu.Lock()
+		running.opt = oldOpt
+		running.mu.Unlock()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// waitFinished waits for the job to finish
+func waitFinished(t *testing.T, job *Job) {
+	for i := 0; i < 100; i++ {
+		job.mu.Lock()
+		finished := job.Finished
+		job.mu.Unlock()
+		if finished {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %d didn't finish", job.ID)
+}
+
+func TestStartAsyncCallSaves(t *testing.T) {
+	stateFile, cleanup := setStateFile(t)
+	defer cleanup()
+
+	// Calls which can't be resumed aren't saved
+	out, err := StartAsyncCall(rc.Calls.Get("rc/noop"), rc.Params{"p": "x"})
+	require.NoError(t, err)
+	assert.NoFileExists(t, stateFile)
+	waitFinished(t, running.Get(out["jobid"].(int64)))
+
+	out, err = StartAsyncCall(rc.Calls.Get("jobs/test-resume"), rc.Params{"p": "x", "_group": "mygroup"})
+	require.NoError(t, err)
+	id := out["jobid"].(int64)
+	list, err := readStateFile(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, id, list[0].ID)
+	assert.Equal(t, "mygroup", list[0].Group)
+	assert.Equal(t, "jobs/test-resume", list[0].Command)
+	assert.Equal(t, rc.Params{"p": "x"}, list[0].Params)
+
+	out, err = rcJobExport(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	exported := out["jobs"].([]SavedJob)
+	require.Equal(t, 1, len(exported))
+	assert.Equal(t, id, exported[0].ID)
+	assert.Equal(t, list[0].Params, exported[0].Params)
+
+	// Finished jobs aren't saved
+	testResumeRelease <- nil
+	waitFinished(t, running.Get(id))
+	list, err = readStateFile(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestResumeJobs(t *testing.T) {
+	stateFile, cleanup := setStateFile(t)
+	defer cleanup()
+
+	id := atomic.LoadInt64(&jobID) + 1000
+	require.NoError(t, writeStateFile(stateFile, []SavedJob{{
+		ID:      id,
+		Group:   "resumed",
+		Command: "jobs/test-resume",
+		Params:  rc.Params{"p": "y"},
+	}, {
+		ID:      id + 1,
+		Command: "jobs/not-found",
+	}}))
+
+	require.NoError(t, ResumeJobs(context.Background()))
+	assert.True(t, atomic.LoadInt64(&jobID) >= id+1)
+	job := running.Get(id)
+	require.NotNil(t, job)
+	assert.Equal(t, "resumed", job.Group)
+	assert.Nil(t, running.Get(id+1))
+
+	// The job which couldn't be resumed is dropped
+	list, err := readStateFile(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, id, list[0].ID)
+
+	testResumeRelease <- nil
+	waitFinished(t, job)
+	assert.Equal(t, rc.Params{"in": "y"}, job.Output)
+}
+
+func TestJobImport(t *testing.T) {
+	_, err := rcJobImport(context.Background(), rc.Params{
+		"jobs": []SavedJob{{Command: "rc/noop"}},
+	})
+	assert.Error(t, err)
+
+	out, err := rcJobImport(context.Background(), rc.Params{
+		"jobs": `[{"id":3,"group":"job/3","command":"jobs/test-resume","params":{"p":"z"}}]`,
+	})
+	require.NoError(t, err)
+	jobIDs := out["jobids"].([]int64)
+	require.Equal(t, 1, len(jobIDs))
+	job := running.Get(jobIDs[0])
+	require.NotNil(t, job)
+	assert.NotEqual(t, "job/3", job.Group)
+
+	testResumeRelease <- nil
+	waitFinished(t, job)
+	assert.Equal(t, rc.Params{"in": "z"}, job.Output)
+}
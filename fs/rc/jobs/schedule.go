@@ -0,0 +1,523 @@
+// Run rc commands on a timetable
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+const (
+	// scheduleConfigSection is the section of the config file which
+	// holds the schedules
+	scheduleConfigSection = "rcd"
+	// scheduleConfigKey is the key in scheduleConfigSection which
+	// holds the schedules as JSON
+	scheduleConfigKey = "schedules"
+	// maxScheduleRuns is the number of runs remembered for each
+	// schedule
+	maxScheduleRuns = 10
+)
+
+// Schedule describes an rc command which is run on a timetable
+type Schedule struct {
+	Name    string    `json:"name"`              // unique name of the schedule
+	Cron    string    `json:"cron"`              // cron expression saying when to run
+	Command string    `json:"command"`           // rc command to run, e.g. "sync/sync"
+	Params  rc.Params `json:"params,omitempty"`  // parameters for the command
+	LogFile string    `json:"logFile,omitempty"` // append a JSON line for each run to this file if set
+}
+
+// ScheduleRun describes one run of a Schedule
+type ScheduleRun struct {
+	JobID     int64     `json:"jobid"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Finished  bool      `json:"finished"`
+	Skipped   bool      `json:"skipped"` // set if not run because the previous run was still going
+	Success   bool      `json:"success"`
+	Error     string    `json:"error"`
+	Output    rc.Params `json:"output"`
+}
+
+// scheduleEntry is a Schedule known to the Scheduler
+type scheduleEntry struct {
+	Schedule
+	spec *cronSpec
+	next time.Time      // time of the next run
+	job  *Job           // the running job or nil
+	runs []*ScheduleRun // most recent last
+}
+
+// Scheduler runs rc commands as jobs on a timetable read from the
+// config file.  A schedule is never run again while its previous
+// run is still going.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    *Jobs
+	entries map[string]*scheduleEntry
+	loaded  bool          // set if the schedules have been read from the config
+	wake    chan struct{} // kick the run loop to recalculate
+	now     func() time.Time
+}
+
+var scheduler = newScheduler(running)
+
+// newScheduler makes a new Scheduler which runs jobs in jobs
+func newScheduler(jobs *Jobs) *Scheduler {
+	return &Scheduler{
+		jobs:    jobs,
+		entries: map[string]*scheduleEntry{},
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+// StartScheduler reads the schedules from the config file and starts
+// running them until ctx is cancelled.
+func StartScheduler(ctx context.Context) {
+	scheduler.Start(ctx)
+}
+
+// newScheduleEntry checks the schedule and makes an entry for it
+func newScheduleEntry(schedule Schedule) (*scheduleEntry, error) {
+	if schedule.Name == "" {
+		return nil, errors.New("schedule needs a name")
+	}
+	spec, err := parseCron(schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	call := rc.Calls.Get(schedule.Command)
+	if call == nil {
+		return nil, errors.Errorf("couldn't find command %q to schedule", schedule.Command)
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return nil, errors.Errorf("command %q can't be scheduled", schedule.Command)
+	}
+	return &scheduleEntry{
+		Schedule: schedule,
+		spec:     spec,
+	}, nil
+}
+
+// load reads the schedules from the config file if not done already
+//
+// Call with s.mu held
+func (s *Scheduler) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	value, ok := fs.ConfigFileGet(scheduleConfigSection, scheduleConfigKey)
+	if !ok || value == "" {
+		return
+	}
+	var schedules []Schedule
+	err := json.Unmarshal([]byte(value), &schedules)
+	if err != nil {
+		fs.Errorf(nil, "Ignoring bad %s in [%s] section of config: %v", scheduleConfigKey, scheduleConfigSection, err)
+		return
+	}
+	now := s.now()
+	for _, schedule := range schedules {
+		e, err := newScheduleEntry(schedule)
+		if err != nil {
+			fs.Errorf(nil, "Ignoring schedule %q: %v", schedule.Name, err)
+			continue
+		}
+		e.next = e.spec.next(now)
+		s.entries[e.Name] = e
+	}
+}
+
+// save writes the schedules to the config file
+//
+// Call with s.mu held
+func (s *Scheduler) save() error {
+	schedules := make([]Schedule, 0, len(s.entries))
+	for _, e := range s.entries {
+		schedules = append(schedules, e.Schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	value, err := json.Marshal(schedules)
+	if err != nil {
+		return err
+	}
+	err = fs.ConfigFileSet(scheduleConfigSection, scheduleConfigKey, string(value))
+	if err != nil {
+		return errors.Wrap(err, "failed to save schedules")
+	}
+	return nil
+}
+
+// kick wakes up the run loop
+func (s *Scheduler) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Add adds or replaces the schedule and saves it to the config file
+func (s *Scheduler) Add(schedule Schedule) (next time.Time, err error) {
+	e, err := newScheduleEntry(schedule)
+	if err != nil {
+		return next, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	if old := s.entries[e.Name]; old != nil {
+		e.job = old.job
+		e.runs = old.runs
+	}
+	e.next = e.spec.next(s.now())
+	s.entries[e.Name] = e
+	err = s.save()
+	if err != nil {
+		return next, err
+	}
+	s.kick()
+	return e.next, nil
+}
+
+// Remove removes the schedule called name and saves the change to the
+// config file.  Any run in progress is left to finish.
+func (s *Scheduler) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	if s.entries[name] == nil {
+		return errors.Errorf("schedule %q not found", name)
+	}
+	delete(s.entries, name)
+	return s.save()
+}
+
+// Start runs the schedules until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.load()
+	s.mu.Unlock()
+	go s.loop(ctx)
+}
+
+// loop runs the schedules as they become due
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		now := s.now()
+		s.runDue(now)
+		var next time.Time
+		for _, e := range s.entries {
+			if !e.next.IsZero() && (next.IsZero() || e.next.Before(next)) {
+				next = e.next
+			}
+		}
+		s.mu.Unlock()
+		var (
+			timer  *time.Timer
+			timerC <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			timerC = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-timerC:
+		case <-s.wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// runDue starts the schedules which are due at now
+//
+// Call with s.mu held
+func (s *Scheduler) runDue(now time.Time) {
+	for _, e := range s.entries {
+		if e.next.IsZero() || now.Before(e.next) {
+			continue
+		}
+		s.run(e)
+		e.next = e.spec.next(now)
+	}
+}
+
+// addRun records run in the history of e
+//
+// Call with s.mu held
+func (e *scheduleEntry) addRun(run *ScheduleRun) {
+	e.runs = append(e.runs, run)
+	if len(e.runs) > maxScheduleRuns {
+		e.runs = e.runs[len(e.runs)-maxScheduleRuns:]
+	}
+}
+
+// run starts a job for e unless the previous one is still running
+//
+// Call with s.mu held
+func (s *Scheduler) run(e *scheduleEntry) {
+	run := &ScheduleRun{
+		StartTime: s.now(),
+	}
+	e.addRun(run)
+	if e.job != nil {
+		fs.Logf(nil, "Schedule %q: skipping run as job %d is still running", e.Name, e.job.ID)
+		run.EndTime = run.StartTime
+		run.Finished = true
+		run.Skipped = true
+		e.writeLog(run)
+		return
+	}
+	call := rc.Calls.Get(e.Command)
+	if call == nil {
+		run.EndTime = run.StartTime
+		run.Finished = true
+		run.Error = errors.Errorf("couldn't find command %q", e.Command).Error()
+		fs.Errorf(nil, "Schedule %q: %s", e.Name, run.Error)
+		e.writeLog(run)
+		return
+	}
+	in := rc.Params{}
+	for k, v := range e.Params {
+		in[k] = v
+	}
+	in["_group"] = "schedule/" + e.Name
+	var job *Job
+	fn := func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+		defer func() {
+			s.finish(e, job, run, out, err)
+		}()
+		return call.Fn(ctx, in)
+	}
+	// s.mu is held so finish can't run until job is set
+	job = s.jobs.NewAsyncJob(fn, in)
+	e.job = job
+	run.JobID = job.ID
+	fs.Infof(nil, "Schedule %q: started %s as job %d", e.Name, e.Command, run.JobID)
+}
+
+// finish records the result of the run of e in job
+func (s *Scheduler) finish(e *scheduleEntry, job *Job, run *ScheduleRun, out rc.Params, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the schedule may have been replaced since the run started
+	if cur := s.entries[e.Name]; cur != nil && cur.job == job {
+		cur.job = nil
+	}
+	run.EndTime = s.now()
+	run.Finished = true
+	run.Output = out
+	if err != nil {
+		run.Error = err.Error()
+		fs.Errorf(nil, "Schedule %q: job %d failed: %v", e.Name, run.JobID, err)
+	} else {
+		run.Success = true
+		fs.Infof(nil, "Schedule %q: job %d finished", e.Name, run.JobID)
+	}
+	e.writeLog(run)
+}
+
+// writeLog appends run to the log file of e if set
+func (e *scheduleEntry) writeLog(run *ScheduleRun) {
+	if e.LogFile == "" {
+		return
+	}
+	line, err := json.Marshal(struct {
+		Name string `json:"name"`
+		*ScheduleRun
+	}{
+		Name:        e.Name,
+		ScheduleRun: run,
+	})
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(e.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err == nil {
+			_, err = f.Write(append(line, '\n'))
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		fs.Errorf(nil, "Schedule %q: failed to write log file: %v", e.Name, err)
+	}
+}
+
+// List returns the schedules with their state suitable for output
+func (s *Scheduler) List() (out []rc.Params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	out = []rc.Params{}
+	for _, e := range s.entries {
+		runs := make([]ScheduleRun, len(e.runs))
+		for i, run := range e.runs {
+			runs[i] = *run
+		}
+		item := rc.Params{
+			"name":    e.Name,
+			"cron":    e.Cron,
+			"command": e.Command,
+			"params":  e.Params,
+			"logFile": e.LogFile,
+			"runs":    runs,
+		}
+		if !e.next.IsZero() {
+			item["next"] = e.next
+		}
+		if e.job != nil {
+			item["jobid"] = e.job.ID
+		}
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i]["name"].(string) < out[j]["name"].(string)
+	})
+	return out
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/schedule",
+		AuthRequired: true,
+		Fn:           rcJobSchedule,
+		Title:        "Adds or replaces a schedule to run an rc command",
+		Help: `This adds a schedule which runs an rc command as a job on a
+timetable given by a cron expression, or replaces the schedule with the
+same name.  The schedule is saved in the config file and the command is
+only run while "rclone rcd" is running.
+
+A run is skipped if the previous run of the same schedule is still going.
+
+Parameters
+
+- name - name of the schedule (string)
+- cron - cron expression, e.g. "30 2 * * *" for 02:30 every day
+- command - rc command to run, e.g. "sync/sync"
+- params - object of parameters to pass to the command (optional)
+- logFile - append a JSON line describing each run to this file (optional)
+
+The cron expression has 5 fields: minute, hour, day of month, month
+and day of week.  Each may be "*", a number, a range "1-5", a list
+"1,3,5" or any of these with a step, e.g. "*/15".  The shortcuts
+@hourly, @daily, @weekly, @monthly and @yearly may be used too.
+
+The stats for the runs are in the group "schedule/name".
+
+Results
+
+- next - time the command will next run
+
+Eg
+
+    rclone rc job/schedule name=nightly cron="0 3 * * *" command=sync/sync \
+        params='{"srcFs":"/home/user/docs","dstFs":"remote:docs"}'
+`,
+	})
+}
+
+// Adds a schedule
+func rcJobSchedule(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	var schedule Schedule
+	schedule.Name, err = in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	schedule.Cron, err = in.GetString("cron")
+	if err != nil {
+		return nil, err
+	}
+	schedule.Command, err = in.GetString("command")
+	if err != nil {
+		return nil, err
+	}
+	err = in.GetStructMissingOK("params", &schedule.Params)
+	if err != nil {
+		return nil, err
+	}
+	schedule.LogFile, err = in.GetString("logFile")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	next, err := scheduler.Add(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"next": next}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/unschedule",
+		AuthRequired: true,
+		Fn:           rcJobUnschedule,
+		Title:        "Removes a schedule",
+		Help: `This removes the schedule from the config file.  A run which is in
+progress is left to finish - use job/stop to stop it.
+
+Parameters
+
+- name - name of the schedule (string)
+`,
+	})
+}
+
+// Removes a schedule
+func rcJobUnschedule(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{}, scheduler.Remove(name)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "job/schedules",
+		Fn:    rcJobSchedules,
+		Title: "Lists the schedules and their recent runs",
+		Help: `Parameters - None
+
+Results
+
+- schedules - array of schedules, each with
+    - name, cron, command, params, logFile - as passed to job/schedule
+    - next - time the command will next run
+    - jobid - id of the running job if the command is running
+    - runs - array of the last 10 runs with
+        - jobid - id of the job
+        - startTime, endTime - times the run started and finished
+        - finished - boolean whether the run has finished
+        - skipped - boolean true if not run as the previous run was going
+        - success - boolean true for success false otherwise
+        - error - error from the run or empty string
+        - output - output of the command
+`,
+	})
+}
+
+// Lists the schedules
+func rcJobSchedules(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	return rc.Params{"schedules": scheduler.List()}, nil
+}
@@ -0,0 +1,198 @@
+package jobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testScheduleRelease releases the jobs/test-schedule call with the
+// error sent
+var testScheduleRelease = make(chan error)
+
+func init() {
+	rc.Add(rc.Call{
+		Path: "jobs/test-schedule",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			return rc.Params{"in": in["p"]}, <-testScheduleRelease
+		},
+	})
+}
+
+// mockScheduleConfig replaces the config file accessors with an in
+// memory store - call the returned function to restore them
+func mockScheduleConfig() (map[string]string, func()) {
+	store := map[string]string{}
+	oldGet, oldSet := fs.ConfigFileGet, fs.ConfigFileSet
+	fs.ConfigFileGet = func(section, key string) (string, bool) {
+		value, ok := store[section+"."+key]
+		return value, ok
+	}
+	fs.ConfigFileSet = func(section, key, value string) error {
+		store[section+"."+key] = value
+		return nil
+	}
+	return store, func() {
+		fs.ConfigFileGet, fs.ConfigFileSet = oldGet, oldSet
+	}
+}
+
+func TestSchedulerAddRemove(t *testing.T) {
+	store, cleanup := mockScheduleConfig()
+	defer cleanup()
+	s := newScheduler(newJobs())
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, err := s.Add(Schedule{Name: "bad", Cron: "* * *", Command: "jobs/test-schedule"})
+	assert.Error(t, err)
+	_, err = s.Add(Schedule{Name: "bad", Cron: "* * * * *", Command: "jobs/not-found"})
+	assert.Error(t, err)
+	_, err = s.Add(Schedule{Cron: "* * * * *", Command: "jobs/test-schedule"})
+	assert.Error(t, err)
+
+	next, err := s.Add(Schedule{Name: "one", Cron: "0 * * * *", Command: "jobs/test-schedule", Params: rc.Params{"p": "x"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 15, 11, 0, 0, 0, time.UTC), next)
+	assert.Contains(t, store["rcd.schedules"], `"name":"one"`)
+
+	// A new scheduler reads the schedules from the config
+	s2 := newScheduler(newJobs())
+	s2.now = s.now
+	list := s2.List()
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "one", list[0]["name"])
+	assert.Equal(t, "0 * * * *", list[0]["cron"])
+	assert.Equal(t, next, list[0]["next"])
+
+	require.NoError(t, s.Remove("one"))
+	assert.Error(t, s.Remove("one"))
+	assert.Equal(t, "[]", store["rcd.schedules"])
+}
+
+func TestSchedulerRun(t *testing.T) {
+	_, cleanup := mockScheduleConfig()
+	defer cleanup()
+	s := newScheduler(newJobs())
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	dir, err := ioutil.TempDir("", "rclone-schedule")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	logFile := filepath.Join(dir, "schedule.log")
+
+	_, err = s.Add(Schedule{Name: "one", Cron: "*/10 * * * *", Command: "jobs/test-schedule", Params: rc.Params{"p": "x"}, LogFile: logFile})
+	require.NoError(t, err)
+
+	// Not due yet
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	assert.Equal(t, 0, len(s.List()[0]["runs"].([]ScheduleRun)))
+
+	// Due so runs
+	now = now.Add(10 * time.Minute)
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	list := s.List()
+	runs := list[0]["runs"].([]ScheduleRun)
+	require.Equal(t, 1, len(runs))
+	jobID := runs[0].JobID
+	assert.NotEqual(t, int64(0), jobID)
+	assert.Equal(t, jobID, list[0]["jobid"])
+	assert.Equal(t, now.Add(10*time.Minute), list[0]["next"])
+
+	// Due again while still running so skipped
+	now = now.Add(10 * time.Minute)
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	runs = s.List()[0]["runs"].([]ScheduleRun)
+	require.Equal(t, 2, len(runs))
+	assert.True(t, runs[1].Skipped)
+	assert.Equal(t, int64(0), runs[1].JobID)
+
+	// Let the first run finish with an error
+	testScheduleRelease <- errors.New("potato")
+	job := s.jobs.Get(jobID)
+	require.NotNil(t, job)
+	for i := 0; i < 100; i++ {
+		if _, running := s.List()[0]["jobid"]; !running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	list = s.List()
+	_, running := list[0]["jobid"]
+	assert.False(t, running)
+	runs = list[0]["runs"].([]ScheduleRun)
+	assert.True(t, runs[0].Finished)
+	assert.False(t, runs[0].Success)
+	assert.Equal(t, "potato", runs[0].Error)
+	assert.Equal(t, rc.Params{"in": "x"}, runs[0].Output)
+
+	// Check the log
+	data, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `"skipped":true`)
+	assert.Contains(t, lines[1], `"error":"potato"`)
+	assert.Contains(t, lines[1], `"name":"one"`)
+
+	// Runs again when due
+	now = now.Add(10 * time.Minute)
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	runs = s.List()[0]["runs"].([]ScheduleRun)
+	require.Equal(t, 3, len(runs))
+	assert.False(t, runs[2].Skipped)
+	testScheduleRelease <- nil
+}
+
+func TestRcJobSchedule(t *testing.T) {
+	_, cleanup := mockScheduleConfig()
+	defer cleanup()
+	oldScheduler := scheduler
+	scheduler = newScheduler(newJobs())
+	defer func() { scheduler = oldScheduler }()
+
+	call := rc.Calls.Get("job/schedule")
+	require.NotNil(t, call)
+	out, err := call.Fn(context.Background(), rc.Params{
+		"name":    "nightly",
+		"cron":    "0 3 * * *",
+		"command": "jobs/test-schedule",
+		"params":  rc.Params{"p": "x"},
+	})
+	require.NoError(t, err)
+	assert.IsType(t, time.Time{}, out["next"])
+
+	call = rc.Calls.Get("job/schedules")
+	require.NotNil(t, call)
+	out, err = call.Fn(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	schedules := out["schedules"].([]rc.Params)
+	require.Equal(t, 1, len(schedules))
+	assert.Equal(t, "nightly", schedules[0]["name"])
+	assert.Equal(t, rc.Params{"p": "x"}, schedules[0]["params"])
+
+	call = rc.Calls.Get("job/unschedule")
+	require.NotNil(t, call)
+	_, err = call.Fn(context.Background(), rc.Params{"name": "nightly"})
+	require.NoError(t, err)
+	_, err = call.Fn(context.Background(), rc.Params{"name": "nightly"})
+	assert.Error(t, err)
+}
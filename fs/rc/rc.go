@@ -32,6 +32,8 @@ type Options struct {
 	EnableMetrics            bool   // set to disable prometheus metrics on /metrics
 	JobExpireDuration        time.Duration
 	JobExpireInterval        time.Duration
+	JobStateFile             string // set to save the resumable async jobs in this file
+	GRPCListenAddr           string // set to serve the rc over gRPC on this address
 }
 
 // DefaultOpt is the default values used for Options
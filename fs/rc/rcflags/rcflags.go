@@ -29,5 +29,7 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &Opt.EnableMetrics, "rc-enable-metrics", "", false, "Enable prometheus metrics on /metrics")
 	flags.DurationVarP(flagSet, &Opt.JobExpireDuration, "rc-job-expire-duration", "", Opt.JobExpireDuration, "expire finished async jobs older than this value")
 	flags.DurationVarP(flagSet, &Opt.JobExpireInterval, "rc-job-expire-interval", "", Opt.JobExpireInterval, "interval to check for expired async jobs")
+	flags.StringVarP(flagSet, &Opt.JobStateFile, "rc-job-state-file", "", "", "File to save the running sync jobs in so rcd can resume them after a restart")
+	flags.StringVarP(flagSet, &Opt.GRPCListenAddr, "rc-grpc-addr", "", "", "IPaddress:Port to serve the remote control over gRPC on")
 	httpflags.AddFlagsPrefix(flagSet, "rc-", &Opt.HTTPOptions)
 }
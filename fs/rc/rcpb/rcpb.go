@@ -0,0 +1,7 @@
+// Package rcpb contains the protocol buffer definitions for the rclone
+// remote control over gRPC.
+//
+// Use NewRCClient to make a client for "rclone rcd --rc-grpc-addr".
+package rcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rc.proto
@@ -0,0 +1,233 @@
+// Stream events to rc clients with server sent events or WebSockets
+
+package rcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/rcflags"
+	"golang.org/x/net/websocket"
+)
+
+// defaultStatsInterval is how often stats events are sent if the
+// client doesn't say
+const defaultStatsInterval = time.Second
+
+// eventOptions are the options a client can set in the URL query
+type eventOptions struct {
+	types    map[string]bool // types of event wanted
+	interval time.Duration   // how often to send the stats
+	group    string          // stats group to send - all if empty
+}
+
+// parseEventOptions reads the eventOptions from the query
+func parseEventOptions(q url.Values) (opt eventOptions, err error) {
+	opt.types = map[string]bool{}
+	types := q.Get("types")
+	if types == "" {
+		types = strings.Join([]string{rc.EventStats, rc.EventLog, rc.EventJob}, ",")
+	}
+	for _, eventType := range strings.Split(types, ",") {
+		switch eventType {
+		case rc.EventStats, rc.EventLog, rc.EventJob:
+			opt.types[eventType] = true
+		default:
+			return opt, errors.Errorf("unknown event type %q", eventType)
+		}
+	}
+	opt.interval = defaultStatsInterval
+	if interval := q.Get("interval"); interval != "" {
+		opt.interval, err = fs.ParseDuration(interval)
+		if err != nil {
+			return opt, errors.Wrap(err, "bad interval")
+		}
+		if opt.interval <= 0 {
+			return opt, errors.New("interval must be positive")
+		}
+	}
+	opt.group = q.Get("group")
+	return opt, nil
+}
+
+// statsEvent makes an event with the current stats
+func statsEvent(ctx context.Context, group string) (rc.Event, error) {
+	call := rc.Calls.Get("core/stats")
+	if call == nil {
+		return rc.Event{}, errors.New("core/stats not found")
+	}
+	in := rc.Params{}
+	if group != "" {
+		in["group"] = group
+	}
+	data, err := call.Fn(ctx, in)
+	if err != nil {
+		return rc.Event{}, err
+	}
+	return rc.Event{
+		Type: rc.EventStats,
+		Time: time.Now(),
+		Data: data,
+	}, nil
+}
+
+// streamEvents sends the events the client wants with send until ctx
+// is cancelled or send returns an error.
+func streamEvents(ctx context.Context, opt eventOptions, send func(rc.Event) error) error {
+	var events <-chan rc.Event
+	if opt.types[rc.EventLog] || opt.types[rc.EventJob] {
+		var unsubscribe func()
+		events, unsubscribe = rc.SubscribeEvents()
+		defer unsubscribe()
+	}
+	var tick <-chan time.Time
+	if opt.types[rc.EventStats] {
+		ticker := time.NewTicker(opt.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		// send the stats straight away so the client doesn't have to wait
+		event, err := statsEvent(ctx, opt.group)
+		if err != nil {
+			return err
+		}
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-events:
+			if !opt.types[event.Type] {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		case <-tick:
+			event, err := statsEvent(ctx, opt.group)
+			if err != nil {
+				return err
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkEventsAuth returns an error if the events can't be served
+// because the server isn't authenticating its clients.  The log and
+// job output can contain the same information as AuthRequired calls.
+func (s *Server) checkEventsAuth(r *http.Request) error {
+	if !s.opt.NoAuth && !s.UsingAuth() && !s.UsingSocket() && getToken(r) == nil {
+		return errors.New("authentication must be set up on the rc server to use \"events\" or the --rc-no-auth flag must be in use")
+	}
+	return nil
+}
+
+// serveEvents streams the events to the client as server sent
+// events or over a WebSocket if the client asks to upgrade.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, path string) {
+	if err := s.checkEventsAuth(r); err != nil {
+		writeError(path, nil, w, err, http.StatusForbidden)
+		return
+	}
+	opt, err := parseEventOptions(r.URL.Query())
+	if err != nil {
+		writeError(path, nil, w, err, http.StatusBadRequest)
+		return
+	}
+	if opt.types[rc.EventLog] {
+		log.StartEventSink()
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.serveEventsWebSocket(w, r, opt)
+		return
+	}
+	s.serveEventsSSE(w, r, opt)
+}
+
+// serveEventsSSE streams the events as server sent events
+func (s *Server) serveEventsSSE(w http.ResponseWriter, r *http.Request, opt eventOptions) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	err := streamEvents(r.Context(), opt, func(event rc.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		fs.Debugf(nil, "rc: events: stream finished: %v", err)
+	}
+}
+
+// checkOrigin makes sure WebSocket connections from browsers come
+// from an origin allowed to use the rc
+func (s *Server) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser
+		return nil
+	}
+	allowOrigin := rcflags.Opt.AccessControlAllowOrigin
+	if allowOrigin == "" {
+		allowOrigin = s.URL()
+	}
+	if allowOrigin != "*" && strings.TrimRight(origin, "/") != strings.TrimRight(allowOrigin, "/") {
+		return errors.Errorf("origin %q not allowed", origin)
+	}
+	return nil
+}
+
+// serveEventsWebSocket streams the events as JSON messages on a
+// WebSocket
+func (s *Server) serveEventsWebSocket(w http.ResponseWriter, r *http.Request, opt eventOptions) {
+	server := websocket.Server{
+		Handshake: s.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer func() { _ = ws.Close() }()
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			// The client doesn't send anything so read until the
+			// connection is closed to find out when it goes away
+			go func() {
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				cancel()
+			}()
+			err := streamEvents(ctx, opt, func(event rc.Event) error {
+				return websocket.JSON.Send(ws, event)
+			})
+			if err != nil {
+				fs.Debugf(nil, "rc: events: WebSocket finished: %v", err)
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
@@ -0,0 +1,158 @@
+package rcserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestParseEventOptions(t *testing.T) {
+	opt, err := parseEventOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"stats": true, "log": true, "job": true}, opt.types)
+	assert.Equal(t, defaultStatsInterval, opt.interval)
+	assert.Equal(t, "", opt.group)
+
+	opt, err = parseEventOptions(url.Values{
+		"types":    {"job,stats"},
+		"interval": {"250ms"},
+		"group":    {"job/1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"stats": true, "job": true}, opt.types)
+	assert.Equal(t, 250*time.Millisecond, opt.interval)
+	assert.Equal(t, "job/1", opt.group)
+
+	_, err = parseEventOptions(url.Values{"types": {"potato"}})
+	assert.Error(t, err)
+	_, err = parseEventOptions(url.Values{"interval": {"potato"}})
+	assert.Error(t, err)
+	_, err = parseEventOptions(url.Values{"interval": {"0s"}})
+	assert.Error(t, err)
+}
+
+func TestEventsAuthRequired(t *testing.T) {
+	tests := []testRun{{
+		Name:   "events",
+		URL:    "events",
+		Status: http.StatusForbidden,
+		Expected: `{
+	"error": "authentication must be set up on the rc server to use \"events\" or the --rc-no-auth flag must be in use",
+	"errorCode": "ERR_UNKNOWN",
+	"input": null,
+	"path": "events",
+	"status": 403
+}
+`,
+	}}
+	opt := newTestOpt()
+	opt.Serve = false
+	opt.Files = ""
+	opt.NoAuth = false
+	testServer(t, tests, &opt)
+}
+
+// startEventsServer starts an rc server with no auth for the events
+// tests returning its URL
+func startEventsServer(t *testing.T) (testURL string, stop func()) {
+	opt := newTestOpt()
+	opt.HTTPOptions.ListenAddr = testBindAddress
+	opt.NoAuth = true
+	mux := http.NewServeMux()
+	rcServer := newServer(context.Background(), &opt, mux)
+	require.NoError(t, rcServer.Serve())
+	return rcServer.Server.URL(), func() {
+		rcServer.Close()
+		rcServer.Wait()
+	}
+}
+
+// publishUntil publishes a job event every 10ms until done is closed
+// so the event arrives however long the client takes to subscribe
+func publishUntil(done <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rc.PublishEvent(rc.EventJob, rc.Params{"id": 42})
+		}
+	}
+}
+
+func TestEventsSSE(t *testing.T) {
+	testURL, stop := startEventsServer(t)
+	defer stop()
+
+	resp, err := http.Get(testURL + "events?types=stats,job&interval=10ms")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(done)
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(resp.Body)
+	var eventType string
+	for scanner.Scan() && !(seen[rc.EventStats] && seen[rc.EventJob]) {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event rc.Event
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+			assert.Equal(t, eventType, event.Type)
+			switch event.Type {
+			case rc.EventStats:
+				assert.Contains(t, event.Data, "bytes")
+			case rc.EventJob:
+				assert.Equal(t, float64(42), event.Data["id"])
+			default:
+				t.Errorf("unexpected event type %q", event.Type)
+			}
+			seen[event.Type] = true
+		}
+	}
+	require.NoError(t, scanner.Err())
+	assert.True(t, seen[rc.EventStats])
+	assert.True(t, seen[rc.EventJob])
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	testURL, stop := startEventsServer(t)
+	defer stop()
+
+	wsURL := "ws" + strings.TrimPrefix(testURL, "http") + "events?types=job"
+	ws, err := websocket.Dial(wsURL, "", testURL)
+	require.NoError(t, err)
+	defer func() { _ = ws.Close() }()
+
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(done)
+
+	var event rc.Event
+	require.NoError(t, websocket.JSON.Receive(ws, &event))
+	assert.Equal(t, rc.EventJob, event.Type)
+	assert.Equal(t, float64(42), event.Data["id"])
+
+	// A browser from another origin isn't allowed
+	_, err = websocket.Dial(wsURL, "", "http://evil.example.com/")
+	assert.Error(t, err)
+}
@@ -0,0 +1,473 @@
+// Serve the rc over gRPC
+
+package rcserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/fs/rc/rcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the rc over gRPC
+type grpcServer struct {
+	rcpb.UnimplementedRCServer
+	s        *Server
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// startGRPC starts the gRPC server on opt.GRPCListenAddr in the
+// background
+func (s *Server) startGRPC() error {
+	var options []grpc.ServerOption
+	if s.opt.HTTPOptions.SslCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.opt.HTTPOptions.SslCert, s.opt.HTTPOptions.SslKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to load certificate for gRPC")
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	g := &grpcServer{s: s}
+	options = append(options,
+		grpc.UnaryInterceptor(g.unaryAuth),
+		grpc.StreamInterceptor(g.streamAuth),
+	)
+	g.server = grpc.NewServer(options...)
+	rcpb.RegisterRCServer(g.server, g)
+	listener, err := net.Listen("tcp", s.opt.GRPCListenAddr)
+	if err != nil {
+		return errors.Wrap(err, "start gRPC server failed")
+	}
+	g.listener = listener
+	s.grpc = g
+	go func() {
+		err := g.server.Serve(listener)
+		if err != nil {
+			fs.Errorf(nil, "Error on serving gRPC server: %v", err)
+		}
+	}()
+	fs.Logf(nil, "Serving remote control over gRPC on %s", listener.Addr())
+	return nil
+}
+
+// GRPCAddr returns the address the gRPC server is listening on or ""
+// if it isn't running
+func (s *Server) GRPCAddr() string {
+	if s.grpc == nil {
+		return ""
+	}
+	return s.grpc.listener.Addr().String()
+}
+
+// authenticate checks the authorization in the metadata of the call
+// in the same way as the HTTP server returning a context with the
+// authentication info in.
+func (g *grpcServer) authenticate(ctx context.Context, method string) (context.Context, error) {
+	r, err := http.NewRequest("POST", method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			r.Header.Set("Authorization", auth[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	r, ok := g.s.Authenticate(r)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if value := r.Context().Value(httplib.ContextAuthKey); value != nil {
+		ctx = context.WithValue(ctx, httplib.ContextAuthKey, value)
+	}
+	return ctx, nil
+}
+
+// unaryAuth authenticates the unary calls
+func (g *grpcServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := g.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authServerStream replaces the context of a grpc.ServerStream
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream
+func (ss authServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// streamAuth authenticates the streaming calls
+func (g *grpcServer) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := g.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, authServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// tokenFromContext returns the API token the call was authenticated
+// with or nil
+func tokenFromContext(ctx context.Context) *rcToken {
+	t, _ := ctx.Value(httplib.ContextAuthKey).(*rcToken)
+	return t
+}
+
+// grpcError converts err into a gRPC status error
+func grpcError(path string, err error) error {
+	fs.Errorf(nil, "rc: gRPC %q: error: %v", path, err)
+	code := codes.Internal
+	errOrig := errors.Cause(err)
+	switch {
+	case errOrig == fs.ErrorDirNotFound || errOrig == fs.ErrorObjectNotFound:
+		code = codes.NotFound
+	case rc.IsErrParamInvalid(err) || rc.IsErrParamNotFound(err):
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, err.Error())
+}
+
+// checkCall finds the call for path and checks the caller may use it
+// with the parameters in
+func (g *grpcServer) checkCall(ctx context.Context, path string, in rc.Params) (*rc.Call, error) {
+	call := rc.Calls.Get(path)
+	if call == nil {
+		return nil, status.Errorf(codes.NotFound, "couldn't find method %q", path)
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return nil, status.Errorf(codes.InvalidArgument, "%q can't be called over gRPC", path)
+	}
+	token := tokenFromContext(ctx)
+	if token != nil {
+		if err := token.check(path, in); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	if !g.s.opt.NoAuth && call.AuthRequired && !g.s.UsingAuth() && token == nil {
+		return nil, status.Errorf(codes.PermissionDenied, "authentication must be set up on the rc server to use %q or the --rc-no-auth flag must be in use", path)
+	}
+	return call, nil
+}
+
+// toStruct converts the rc output into a protobuf Struct
+func toStruct(out rc.Params) (*structpb.Struct, error) {
+	if out == nil {
+		return &structpb.Struct{}, nil
+	}
+	// Go through JSON to turn the values into JSON types
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	var in map[string]interface{}
+	err = json.Unmarshal(data, &in)
+	if err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(in)
+}
+
+// Call runs an rc command
+func (g *grpcServer) Call(ctx context.Context, req *rcpb.CallRequest) (*rcpb.CallResponse, error) {
+	in := rc.Params(req.GetParams().AsMap())
+	if req.Group != "" {
+		in["_group"] = req.Group
+	}
+	call, err := g.checkCall(ctx, req.Command, in)
+	if err != nil {
+		return nil, err
+	}
+	fs.Debugf(nil, "rc: gRPC %q: with parameters %+v", req.Command, in)
+	var (
+		out   rc.Params
+		jobID int64
+	)
+	if req.Async {
+		out, err = jobs.StartAsyncCall(call, in)
+		if err == nil {
+			jobID, err = out.GetInt64("jobid")
+			out = nil
+		}
+	} else {
+		out, jobID, err = jobs.ExecuteJob(ctx, call.Fn, in)
+	}
+	if err != nil {
+		return nil, grpcError(req.Command, err)
+	}
+	output, err := toStruct(out)
+	if err != nil {
+		return nil, grpcError(req.Command, err)
+	}
+	return &rcpb.CallResponse{
+		Output: output,
+		JobId:  jobID,
+	}, nil
+}
+
+// ListCommands lists the rc commands
+func (g *grpcServer) ListCommands(ctx context.Context, req *rcpb.ListCommandsRequest) (*rcpb.ListCommandsResponse, error) {
+	resp := &rcpb.ListCommandsResponse{}
+	for _, call := range rc.Calls.List() {
+		resp.Commands = append(resp.Commands, &rcpb.Command{
+			Path:         call.Path,
+			Title:        call.Title,
+			Help:         call.Help,
+			AuthRequired: call.AuthRequired,
+		})
+	}
+	return resp, nil
+}
+
+// runCall runs the rc command at path if the caller may use it
+func (g *grpcServer) runCall(ctx context.Context, path string, in rc.Params) (rc.Params, error) {
+	call, err := g.checkCall(ctx, path, in)
+	if err != nil {
+		return nil, err
+	}
+	out, err := call.Fn(ctx, in)
+	if err != nil {
+		return nil, grpcError(path, err)
+	}
+	return out, nil
+}
+
+// timestamp converts t into a protobuf Timestamp or nil if it is zero
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// JobStatus reads the status of an async job
+func (g *grpcServer) JobStatus(ctx context.Context, req *rcpb.JobStatusRequest) (*rcpb.Job, error) {
+	out, err := g.runCall(ctx, "job/status", rc.Params{"jobid": req.JobId})
+	if err != nil {
+		return nil, err
+	}
+	var job struct {
+		ID        int64     `json:"id"`
+		Group     string    `json:"group"`
+		StartTime time.Time `json:"startTime"`
+		EndTime   time.Time `json:"endTime"`
+		Error     string    `json:"error"`
+		Finished  bool      `json:"finished"`
+		Success   bool      `json:"success"`
+		Duration  float64   `json:"duration"`
+		Output    rc.Params `json:"output"`
+	}
+	err = rc.Reshape(&job, out)
+	if err != nil {
+		return nil, grpcError("job/status", err)
+	}
+	output, err := toStruct(job.Output)
+	if err != nil {
+		return nil, grpcError("job/status", err)
+	}
+	return &rcpb.Job{
+		Id:        job.ID,
+		Group:     job.Group,
+		StartTime: timestamp(job.StartTime),
+		EndTime:   timestamp(job.EndTime),
+		Finished:  job.Finished,
+		Success:   job.Success,
+		Error:     job.Error,
+		Duration:  job.Duration,
+		Output:    output,
+	}, nil
+}
+
+// StopJob stops a running async job
+func (g *grpcServer) StopJob(ctx context.Context, req *rcpb.StopJobRequest) (*rcpb.StopJobResponse, error) {
+	_, err := g.runCall(ctx, "job/stop", rc.Params{"jobid": req.JobId})
+	if err != nil {
+		return nil, err
+	}
+	return &rcpb.StopJobResponse{}, nil
+}
+
+// grpcStats is the output of core/stats which is sent as rcpb.Stats
+type grpcStats struct {
+	Bytes          int64    `json:"bytes"`
+	TotalBytes     int64    `json:"totalBytes"`
+	Speed          float64  `json:"speed"`
+	Eta            *float64 `json:"eta"`
+	Errors         int64    `json:"errors"`
+	FatalError     bool     `json:"fatalError"`
+	RetryError     bool     `json:"retryError"`
+	LastError      string   `json:"lastError"`
+	Checks         int64    `json:"checks"`
+	TotalChecks    int64    `json:"totalChecks"`
+	Transfers      int64    `json:"transfers"`
+	TotalTransfers int64    `json:"totalTransfers"`
+	Deletes        int64    `json:"deletes"`
+	DeletedDirs    int64    `json:"deletedDirs"`
+	Renames        int64    `json:"renames"`
+	ElapsedTime    float64  `json:"elapsedTime"`
+	TransferTime   float64  `json:"transferTime"`
+	Checking       []string `json:"checking"`
+	Transferring   []struct {
+		Name       string   `json:"name"`
+		Size       int64    `json:"size"`
+		Bytes      int64    `json:"bytes"`
+		Speed      float64  `json:"speed"`
+		SpeedAvg   float64  `json:"speedAvg"`
+		Percentage int64    `json:"percentage"`
+		Eta        *float64 `json:"eta"`
+		Group      string   `json:"group"`
+	} `json:"transferring"`
+}
+
+// eta returns the eta or -1 if not known
+func eta(value *float64) float64 {
+	if value == nil {
+		return -1
+	}
+	return *value
+}
+
+// statsMessage converts the stats event into an rcpb.Stats
+func statsMessage(event rc.Event) (*rcpb.Stats, error) {
+	var stats grpcStats
+	err := rc.Reshape(&stats, event.Data)
+	if err != nil {
+		return nil, err
+	}
+	msg := &rcpb.Stats{
+		Time:           timestamp(event.Time),
+		Bytes:          stats.Bytes,
+		TotalBytes:     stats.TotalBytes,
+		Speed:          stats.Speed,
+		Eta:            eta(stats.Eta),
+		Errors:         stats.Errors,
+		FatalError:     stats.FatalError,
+		RetryError:     stats.RetryError,
+		LastError:      stats.LastError,
+		Checks:         stats.Checks,
+		TotalChecks:    stats.TotalChecks,
+		Transfers:      stats.Transfers,
+		TotalTransfers: stats.TotalTransfers,
+		Deletes:        stats.Deletes,
+		DeletedDirs:    stats.DeletedDirs,
+		Renames:        stats.Renames,
+		ElapsedTime:    stats.ElapsedTime,
+		TransferTime:   stats.TransferTime,
+		Checking:       stats.Checking,
+	}
+	for _, tr := range stats.Transferring {
+		msg.Transferring = append(msg.Transferring, &rcpb.Transfer{
+			Name:       tr.Name,
+			Size:       tr.Size,
+			Bytes:      tr.Bytes,
+			Speed:      tr.Speed,
+			SpeedAvg:   tr.SpeedAvg,
+			Percentage: tr.Percentage,
+			Eta:        eta(tr.Eta),
+			Group:      tr.Group,
+		})
+	}
+	return msg, nil
+}
+
+// StreamStats sends the stats until the client cancels
+func (g *grpcServer) StreamStats(req *rcpb.StreamStatsRequest, stream rcpb.RC_StreamStatsServer) error {
+	ctx := stream.Context()
+	in := rc.Params{}
+	if req.Group != "" {
+		in["group"] = req.Group
+	}
+	if _, err := g.checkCall(ctx, "core/stats", in); err != nil {
+		return err
+	}
+	opt := eventOptions{
+		types:    map[string]bool{rc.EventStats: true},
+		interval: defaultStatsInterval,
+		group:    req.Group,
+	}
+	if req.Interval != nil {
+		opt.interval = req.Interval.AsDuration()
+		if req.Interval.CheckValid() != nil || opt.interval <= 0 {
+			return status.Error(codes.InvalidArgument, "interval must be positive")
+		}
+	}
+	err := streamEvents(ctx, opt, func(event rc.Event) error {
+		msg, err := statsMessage(event)
+		if err != nil {
+			return err
+		}
+		return stream.Send(msg)
+	})
+	if err != nil {
+		return grpcError("core/stats", err)
+	}
+	return nil
+}
+
+// StreamLog sends the log messages until the client cancels
+func (g *grpcServer) StreamLog(req *rcpb.StreamLogRequest, stream rcpb.RC_StreamLogServer) error {
+	ctx := stream.Context()
+	// The log can contain the same information as AuthRequired calls
+	if !g.s.opt.NoAuth && !g.s.UsingAuth() && tokenFromContext(ctx) == nil {
+		return status.Error(codes.PermissionDenied, "authentication must be set up on the rc server to stream the log or the --rc-no-auth flag must be in use")
+	}
+	maxLevel := fs.LogLevelDebug
+	if req.Level != "" {
+		if err := maxLevel.Set(req.Level); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	log.StartEventSink()
+	opt := eventOptions{
+		types: map[string]bool{rc.EventLog: true},
+	}
+	return streamEvents(ctx, opt, func(event rc.Event) error {
+		levelName, _ := event.Data["level"].(string)
+		var level fs.LogLevel
+		if level.Set(levelName) == nil && level > maxLevel {
+			return nil
+		}
+		message, _ := event.Data["msg"].(string)
+		fields := rc.Params{}
+		for k, v := range event.Data {
+			if k != "level" && k != "msg" {
+				fields[k] = v
+			}
+		}
+		fieldsStruct, err := toStruct(fields)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&rcpb.LogEntry{
+			Time:    timestamp(event.Time),
+			Level:   levelName,
+			Message: message,
+			Fields:  fieldsStruct,
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package rcserver
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/rcpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// startGRPCServer starts an rc server with the gRPC server and
+// returns a client for it
+func startGRPCServer(t *testing.T, opt *rc.Options) (client rcpb.RCClient, stop func()) {
+	opt.HTTPOptions.ListenAddr = testBindAddress
+	opt.GRPCListenAddr = testBindAddress
+	rcServer := newServer(context.Background(), opt, http.NewServeMux())
+	require.NoError(t, rcServer.Serve())
+	conn, err := grpc.Dial(rcServer.GRPCAddr(), grpc.WithInsecure())
+	require.NoError(t, err)
+	return rcpb.NewRCClient(conn), func() {
+		_ = conn.Close()
+		rcServer.Close()
+		rcServer.Wait()
+	}
+}
+
+// withAuth returns a context which sends the authorization
+func withAuth(authorization string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", authorization)
+}
+
+// basicAuth returns the authorization for user and pass
+func basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+func TestGRPCCall(t *testing.T) {
+	opt := newTestOpt()
+	opt.HTTPOptions.BasicUser = "user"
+	opt.HTTPOptions.BasicPass = "pass"
+	client, stop := startGRPCServer(t, &opt)
+	defer stop()
+
+	params, err := structpb.NewStruct(map[string]interface{}{"potato": 1})
+	require.NoError(t, err)
+	req := &rcpb.CallRequest{Command: "rc/noopauth", Params: params}
+
+	_, err = client.Call(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Call(withAuth(basicAuth("user", "potato")), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := withAuth(basicAuth("user", "pass"))
+	resp, err := client.Call(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"potato": float64(1)}, resp.Output.AsMap())
+	assert.NotEqual(t, int64(0), resp.JobId)
+
+	_, err = client.Call(ctx, &rcpb.CallRequest{Command: "rc/notfound"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Call(ctx, &rcpb.CallRequest{Command: "rc/error"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// Run a job in the background and wait for it
+	resp, err = client.Call(ctx, &rcpb.CallRequest{Command: "rc/noop", Params: params, Async: true, Group: "grpcgroup"})
+	require.NoError(t, err)
+	jobID := resp.JobId
+	assert.NotEqual(t, int64(0), jobID)
+	var job *rcpb.Job
+	for i := 0; i < 100; i++ {
+		job, err = client.JobStatus(ctx, &rcpb.JobStatusRequest{JobId: jobID})
+		require.NoError(t, err)
+		if job.Finished {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, job.Finished)
+	assert.True(t, job.Success)
+	assert.Equal(t, "grpcgroup", job.Group)
+	assert.NotNil(t, job.StartTime)
+	assert.Equal(t, map[string]interface{}{"potato": float64(1)}, job.Output.AsMap())
+
+	_, err = client.JobStatus(ctx, &rcpb.JobStatusRequest{JobId: -1})
+	assert.Error(t, err)
+
+	list, err := client.ListCommands(ctx, &rcpb.ListCommandsRequest{})
+	require.NoError(t, err)
+	found := false
+	for _, command := range list.Commands {
+		if command.Path == "rc/noopauth" {
+			found = true
+			assert.True(t, command.AuthRequired)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestGRPCAuthRequired(t *testing.T) {
+	opt := newTestOpt()
+	client, stop := startGRPCServer(t, &opt)
+	defer stop()
+
+	_, err := client.Call(context.Background(), &rcpb.CallRequest{Command: "rc/noop"})
+	assert.NoError(t, err)
+	_, err = client.Call(context.Background(), &rcpb.CallRequest{Command: "rc/noopauth"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.StreamLog(context.Background(), &rcpb.StreamLogRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCToken(t *testing.T) {
+	_, cleanup := mockTokenConfig()
+	defer cleanup()
+	secret, err := tokens.create("grpc", nil, []string{"@read", "rc/noopauth"})
+	require.NoError(t, err)
+
+	opt := newTestOpt()
+	client, stop := startGRPCServer(t, &opt)
+	defer stop()
+
+	_, err = client.Call(withAuth("Bearer potato"), &rcpb.CallRequest{Command: "rc/noopauth"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := withAuth("Bearer " + secret)
+	_, err = client.Call(ctx, &rcpb.CallRequest{Command: "rc/noopauth"})
+	assert.NoError(t, err)
+	_, err = client.Call(ctx, &rcpb.CallRequest{Command: "core/quit"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCStreamStats(t *testing.T) {
+	opt := newTestOpt()
+	client, stop := startGRPCServer(t, &opt)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamStats(ctx, &rcpb.StreamStatsRequest{Interval: durationpb.New(10 * time.Millisecond)})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		stats, err := stream.Recv()
+		require.NoError(t, err)
+		assert.NotNil(t, stats.Time)
+		assert.True(t, stats.ElapsedTime > 0)
+	}
+	cancel()
+	for {
+		_, err = stream.Recv()
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	stream, err = client.StreamStats(context.Background(), &rcpb.StreamStatsRequest{Interval: durationpb.New(-time.Second)})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCStreamLog(t *testing.T) {
+	opt := newTestOpt()
+	opt.NoAuth = true
+	client, stop := startGRPCServer(t, &opt)
+	defer stop()
+
+	// Start the sink here as adding it races with logging
+	log.StartEventSink()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamLog(ctx, &rcpb.StreamLogRequest{Level: "NOTICE"})
+	require.NoError(t, err)
+
+	// Log until the message arrives however long the stream takes
+	// to start
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fs.Infof(nil, "grpc log test info")
+				fs.Logf("grpc object", "grpc log test")
+			}
+		}
+	}()
+	for {
+		entry, err := stream.Recv()
+		require.NotEqual(t, io.EOF, err)
+		require.NoError(t, err)
+		assert.NotEqual(t, "grpc log test info", entry.Message)
+		if entry.Message == "grpc log test" {
+			assert.Equal(t, "NOTICE", entry.Level)
+			assert.Equal(t, "grpc object", entry.Fields.AsMap()["object"])
+			break
+		}
+	}
+}
@@ -21,32 +21,24 @@ import (
 	"github.com/rclone/rclone/fs/rc/webgui"
 
 	"github.com/pkg/errors"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/skratchdot/open-golang/open"
 
 	"github.com/rclone/rclone/cmd/serve/httplib"
 	"github.com/rclone/rclone/cmd/serve/httplib/serve"
 	"github.com/rclone/rclone/fs"
-	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/list"
+	"github.com/rclone/rclone/fs/metrics"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/rc/jobs"
 	"github.com/rclone/rclone/fs/rc/rcflags"
 	"github.com/rclone/rclone/lib/random"
 )
 
-var promHandler http.Handler
 var onlyOnceWarningAllowOrigin sync.Once
 
-func init() {
-	rcloneCollector := accounting.NewRcloneCollector(context.Background())
-	prometheus.MustRegister(rcloneCollector)
-	promHandler = promhttp.Handler()
-}
-
 // Start the remote control server if configured
 //
 // If the server wasn't configured the *Server returned may be nil
@@ -67,6 +59,7 @@ type Server struct {
 	files          http.Handler
 	pluginsHandler http.Handler
 	opt            *rc.Options
+	grpc           *grpcServer // gRPC server if running
 }
 
 func newServer(ctx context.Context, opt *rc.Options, mux *http.ServeMux) *Server {
@@ -113,6 +106,9 @@ func newServer(ctx context.Context, opt *rc.Options, mux *http.ServeMux) *Server
 		pluginsHandler = http.FileServer(http.Dir(webgui.PluginsPath))
 	}
 
+	// Allow API tokens as well as any other authentication
+	opt.HTTPOptions.TokenAuth = checkToken
+
 	s := &Server{
 		Server:         httplib.NewServer(mux, &opt.HTTPOptions),
 		ctx:            ctx,
@@ -134,6 +130,13 @@ func (s *Server) Serve() error {
 		return err
 	}
 	fs.Logf(nil, "Serving remote control on %s", s.URL())
+	if s.opt.GRPCListenAddr != "" {
+		err = s.startGRPC()
+		if err != nil {
+			s.Server.Close()
+			return err
+		}
+	}
 	// Open the files in the browser if set
 	if s.files != nil {
 		openURL, err := url.Parse(s.URL())
@@ -166,6 +169,15 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+// Close shuts the running server down along with the gRPC server if
+// running
+func (s *Server) Close() {
+	if s.grpc != nil {
+		s.grpc.server.Stop()
+	}
+	s.Server.Close()
+}
+
 // writeError writes a formatted error to the output
 func writeError(path string, in rc.Params, w http.ResponseWriter, err error, status int) {
 	fs.Errorf(nil, "rc: %q: error: %v", path, err)
@@ -179,10 +191,11 @@ func writeError(path string, in rc.Params, w http.ResponseWriter, err error, sta
 	}
 	w.WriteHeader(status)
 	err = rc.WriteJSON(w, rc.Params{
-		"status": status,
-		"error":  err.Error(),
-		"input":  in,
-		"path":   path,
+		"status":    status,
+		"error":     err.Error(),
+		"errorCode": string(fserrors.ErrorCode(err)),
+		"input":     in,
+		"path":      path,
 	})
 	if err != nil {
 		// can't return the error at this point
@@ -265,8 +278,18 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string)
 		return
 	}
 
-	// Check to see if it requires authorisation
-	if !s.opt.NoAuth && call.AuthRequired && !s.UsingAuth() {
+	// Check the command and remotes are allowed for an API token
+	token := getToken(r)
+	if token != nil {
+		if err := token.check(path, in); err != nil {
+			writeError(path, in, w, err, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Check to see if it requires authorisation - the permissions
+	// of a socket do the authorisation for it
+	if !s.opt.NoAuth && call.AuthRequired && !s.UsingAuth() && !s.UsingSocket() && token == nil {
 		writeError(path, in, w, errors.Errorf("authentication must be set up on the rc server to use %q or the --rc-no-auth flag must be in use", path), http.StatusForbidden)
 		return
 	}
@@ -290,7 +313,7 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string)
 	fs.Debugf(nil, "rc: %q: with parameters %+v", path, in)
 	var out rc.Params
 	if isAsync {
-		out, err = jobs.StartAsyncJob(call.Fn, in)
+		out, err = jobs.StartAsyncCall(call, in)
 	} else {
 		var jobID int64
 		out, jobID, err = jobs.ExecuteJob(r.Context(), call.Fn, in)
@@ -376,14 +399,30 @@ var fsMatch = regexp.MustCompile(`^\[(.*?)\](.*)$`)
 func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string) {
 	// Look to see if this has an fs in the path
 	fsMatchResult := fsMatch.FindStringSubmatch(path)
+	token := getToken(r)
 
 	switch {
 	case fsMatchResult != nil && s.opt.Serve:
+		if token != nil {
+			err := token.checkServe(fsMatchResult[1])
+			if err != nil {
+				writeError(path, nil, w, err, http.StatusForbidden)
+				return
+			}
+		}
 		// Serve /[fs]/remote files
 		s.serveRemote(w, r, fsMatchResult[2], fsMatchResult[1])
 		return
 	case path == "metrics" && s.opt.EnableMetrics:
-		promHandler.ServeHTTP(w, r)
+		metrics.Handler().ServeHTTP(w, r)
+		return
+	case path == "events":
+		if token != nil && !token.allowCommand("events") {
+			writeError(path, nil, w, errors.Errorf("token %q may not use %q", token.Name, path), http.StatusForbidden)
+			return
+		}
+		// Stream the stats, log and job events
+		s.serveEvents(w, r, path)
 		return
 	case path == "*" && s.opt.Serve:
 		// Serve /* as the remote listing
@@ -224,6 +224,7 @@ func TestRemoteServing(t *testing.T) {
 			Status: http.StatusNotFound,
 			Expected: `{
 	"error": "failed to list directory: directory not found",
+	"errorCode": "ERR_NOT_FOUND",
 	"input": null,
 	"path": "",
 	"status": 404
@@ -235,6 +236,7 @@ func TestRemoteServing(t *testing.T) {
 			Status: http.StatusNotFound,
 			Expected: `{
 	"error": "failed to find object: object not found",
+	"errorCode": "ERR_NOT_FOUND",
 	"input": null,
 	"path": "notfound",
 	"status": 404
@@ -246,6 +248,7 @@ func TestRemoteServing(t *testing.T) {
 			Status: http.StatusNotFound,
 			Expected: `{
 	"error": "failed to list directory: directory not found",
+	"errorCode": "ERR_NOT_FOUND",
 	"input": null,
 	"path": "dirnotfound",
 	"status": 404
@@ -309,6 +312,7 @@ func TestRemoteServing(t *testing.T) {
 			Status: http.StatusInternalServerError,
 			Expected: `{
 	"error": "failed to make Fs: didn't find section in config file",
+	"errorCode": "ERR_UNKNOWN",
 	"input": null,
 	"path": "/",
 	"status": 500
@@ -329,6 +333,7 @@ func TestRC(t *testing.T) {
 		Status: http.StatusNotFound,
 		Expected: `{
 	"error": "couldn't find method \"\"",
+	"errorCode": "ERR_UNKNOWN",
 	"input": {},
 	"path": "",
 	"status": 404
@@ -347,6 +352,7 @@ func TestRC(t *testing.T) {
 		Status: http.StatusInternalServerError,
 		Expected: `{
 	"error": "arbitrary error on input map[]",
+	"errorCode": "ERR_UNKNOWN",
 	"input": {},
 	"path": "rc/error",
 	"status": 500
@@ -402,6 +408,7 @@ func TestRC(t *testing.T) {
 		Status:      http.StatusBadRequest,
 		Expected: `{
 	"error": "failed to read input JSON: invalid character 'p' looking for beginning of object key string",
+	"errorCode": "ERR_UNKNOWN",
 	"input": {
 		"param1": "potato",
 		"param2": "sausage"
@@ -444,6 +451,7 @@ func TestRC(t *testing.T) {
 		Status:      http.StatusBadRequest,
 		Expected: `{
 	"error": "failed to parse form/URL parameters: invalid URL escape \"%zz\"",
+	"errorCode": "ERR_UNKNOWN",
 	"input": null,
 	"path": "rc/noop",
 	"status": 400
@@ -475,6 +483,7 @@ func TestMethods(t *testing.T) {
 		Status: http.StatusMethodNotAllowed,
 		Expected: `{
 	"error": "method \"POTATO\" not allowed",
+	"errorCode": "ERR_UNKNOWN",
 	"input": null,
 	"path": "",
 	"status": 405
@@ -614,6 +623,7 @@ func TestAuthRequired(t *testing.T) {
 		Status:      http.StatusForbidden,
 		Expected: `{
 	"error": "authentication must be set up on the rc server to use \"rc/noopauth\" or the --rc-no-auth flag must be in use",
+	"errorCode": "ERR_UNKNOWN",
 	"input": {},
 	"path": "rc/noopauth",
 	"status": 403
@@ -663,6 +673,24 @@ func TestWithUserPass(t *testing.T) {
 	testServer(t, tests, &opt)
 }
 
+func TestWithSocket(t *testing.T) {
+	tests := []testRun{{
+		Name:        "auth",
+		URL:         "rc/noopauth",
+		Method:      "POST",
+		Body:        `{}`,
+		ContentType: "application/javascript",
+		Status:      http.StatusOK,
+		Expected:    "{}\n",
+	}}
+	opt := newTestOpt()
+	opt.Serve = false
+	opt.Files = ""
+	opt.NoAuth = false
+	opt.HTTPOptions.ListenAddr = "unix:/tmp/rclone-rc.sock"
+	testServer(t, tests, &opt)
+}
+
 func TestRCAsync(t *testing.T) {
 	tests := []testRun{{
 		Name:        "ok",
@@ -681,6 +709,7 @@ func TestRCAsync(t *testing.T) {
 		Status:      http.StatusBadRequest,
 		Expected: `{
 	"error": "couldn't parse key \"_async\" (truthy) as bool: strconv.ParseBool: parsing \"truthy\": invalid syntax",
+	"errorCode": "ERR_UNKNOWN",
 	"input": {
 		"_async": "truthy"
 	},
@@ -0,0 +1,469 @@
+// API tokens which give access to some of the rc
+
+package rcserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/serve/httplib"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/lib/random"
+)
+
+const (
+	// tokenConfigSection is the section of the config file which
+	// holds the tokens
+	tokenConfigSection = "rcd"
+	// tokenConfigKey is the key in tokenConfigSection which holds
+	// the tokens as JSON
+	tokenConfigKey = "tokens"
+	// tokenPrefix starts every token to make them easy to spot
+	tokenPrefix = "rclone_"
+	// localRemote is the remote name tokens use for local paths
+	localRemote = "local"
+)
+
+// tokenPresets are names for commonly used sets of commands
+var tokenPresets = map[string][]string{
+	// read only listing
+	"@read": {
+		"operations/list",
+		"operations/size",
+		"operations/about",
+		"operations/fsinfo",
+		"core/stats",
+		"core/transferred",
+		"core/version",
+		"job/list",
+		"job/status",
+	},
+	// everything needed to sync and copy
+	"@write": {
+		"sync/*",
+		"operations/*",
+		"core/stats",
+		"core/transferred",
+		"core/version",
+		"job/list",
+		"job/status",
+		"job/stop",
+	},
+}
+
+// rcToken is an API token which allows access to some commands on
+// some remotes
+type rcToken struct {
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`              // hex SHA-256 of the token
+	Remotes  []string  `json:"remotes,omitempty"` // patterns of remote names allowed - all if empty
+	Commands []string  `json:"commands"`          // patterns of commands or presets allowed
+	Created  time.Time `json:"created"`
+}
+
+// hashToken returns the hash of the token as stored in the config
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// matchAny returns true if name matches any of the glob patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowCommand returns true if the token may use the command
+func (t *rcToken) allowCommand(command string) bool {
+	for _, pattern := range t.Commands {
+		if preset, ok := tokenPresets[pattern]; ok {
+			if matchAny(preset, command) {
+				return true
+			}
+		} else if matchAny([]string{pattern}, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowRemote returns true if the token may use the remote called name
+func (t *rcToken) allowRemote(name string) bool {
+	if len(t.Remotes) == 0 {
+		return true
+	}
+	if name == "" {
+		name = localRemote
+	}
+	return matchAny(t.Remotes, name)
+}
+
+// checkFs returns an error if the token may not use the remote in fsString
+func (t *rcToken) checkFs(fsString string) error {
+	name, _, err := fspath.Parse(fsString)
+	if err != nil {
+		return err
+	}
+	if !t.allowRemote(name) {
+		return errors.Errorf("token %q may not use remote %q", t.Name, name)
+	}
+	return nil
+}
+
+// check returns an error if the token may not call command with in
+//
+// The remotes are read from the "fs" parameter and any parameter
+// ending in "Fs", e.g. "srcFs" and "dstFs".
+func (t *rcToken) check(command string, in rc.Params) error {
+	if !t.allowCommand(command) {
+		return errors.Errorf("token %q may not use %q", t.Name, command)
+	}
+	if command == "job/import" {
+		return t.checkImport(in)
+	}
+	for key, value := range in {
+		if key != "fs" && !strings.HasSuffix(key, "Fs") {
+			continue
+		}
+		fsString, ok := value.(string)
+		if !ok {
+			if len(t.Remotes) == 0 {
+				continue
+			}
+			return errors.Errorf("token %q may only use remotes given as strings in %q", t.Name, key)
+		}
+		if err := t.checkFs(fsString); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkImport returns an error if the token may not run any of the
+// jobs being imported
+func (t *rcToken) checkImport(in rc.Params) error {
+	var list []jobs.SavedJob
+	err := in.GetStruct("jobs", &list)
+	if err != nil {
+		return err
+	}
+	for _, job := range list {
+		if err := t.check(job.Command, job.Params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkServe returns an error if the token may not fetch files from
+// the remote in fsString
+func (t *rcToken) checkServe(fsString string) error {
+	if !t.allowCommand("serve") {
+		return errors.Errorf("token %q may not use %q", t.Name, "serve")
+	}
+	return t.checkFs(fsString)
+}
+
+// tokenStore holds the tokens, reading them from the config file
+// when first needed
+type tokenStore struct {
+	mu     sync.Mutex
+	loaded bool
+	tokens map[string]*rcToken // by name
+}
+
+var tokens = &tokenStore{
+	tokens: map[string]*rcToken{},
+}
+
+// load reads the tokens from the config file if not done already
+//
+// Call with ts.mu held
+func (ts *tokenStore) load() {
+	if ts.loaded {
+		return
+	}
+	ts.loaded = true
+	value, ok := fs.ConfigFileGet(tokenConfigSection, tokenConfigKey)
+	if !ok || value == "" {
+		return
+	}
+	var list []*rcToken
+	err := json.Unmarshal([]byte(value), &list)
+	if err != nil {
+		fs.Errorf(nil, "Ignoring bad %s in [%s] section of config: %v", tokenConfigKey, tokenConfigSection, err)
+		return
+	}
+	for _, t := range list {
+		ts.tokens[t.Name] = t
+	}
+}
+
+// save writes the tokens to the config file
+//
+// Call with ts.mu held
+func (ts *tokenStore) save() error {
+	value, err := json.Marshal(ts.sorted())
+	if err != nil {
+		return err
+	}
+	err = fs.ConfigFileSet(tokenConfigSection, tokenConfigKey, string(value))
+	if err != nil {
+		return errors.Wrap(err, "failed to save tokens")
+	}
+	return nil
+}
+
+// sorted returns the tokens sorted by name
+//
+// Call with ts.mu held
+func (ts *tokenStore) sorted() []*rcToken {
+	list := make([]*rcToken, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// create makes a new token returning the secret which is only known
+// to the caller
+func (ts *tokenStore) create(name string, remotes, commands []string) (secret string, err error) {
+	if name == "" {
+		return "", errors.New("token needs a name")
+	}
+	if len(commands) == 0 {
+		return "", errors.New("token needs some commands")
+	}
+	for _, pattern := range append(append([]string(nil), remotes...), commands...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", errors.Wrapf(err, "bad pattern %q", pattern)
+		}
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.load()
+	if ts.tokens[name] != nil {
+		return "", errors.Errorf("token %q already exists", name)
+	}
+	secret, err = random.Password(192)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make token")
+	}
+	// make it URL safe as it might be used in headers or URLs
+	secret = tokenPrefix + strings.NewReplacer("+", "-", "/", "_", "=", "").Replace(secret)
+	ts.tokens[name] = &rcToken{
+		Name:     name,
+		Hash:     hashToken(secret),
+		Remotes:  remotes,
+		Commands: commands,
+		Created:  time.Now(),
+	}
+	err = ts.save()
+	if err != nil {
+		delete(ts.tokens, name)
+		return "", err
+	}
+	return secret, nil
+}
+
+// delete removes the token called name
+func (ts *tokenStore) delete(name string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.load()
+	if ts.tokens[name] == nil {
+		return errors.Errorf("token %q not found", name)
+	}
+	delete(ts.tokens, name)
+	return ts.save()
+}
+
+// find returns the token matching secret
+func (ts *tokenStore) find(secret string) (*rcToken, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.load()
+	hash := []byte(hashToken(secret))
+	for _, t := range ts.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t, nil
+		}
+	}
+	return nil, errors.New("unknown token")
+}
+
+// checkToken is the httplib.TokenAuthFn for the rc server
+func checkToken(secret string) (value interface{}, err error) {
+	return tokens.find(secret)
+}
+
+// getToken returns the token the request was authenticated with or
+// nil if it wasn't authenticated with a token
+func getToken(r *http.Request) *rcToken {
+	t, _ := r.Context().Value(httplib.ContextAuthKey).(*rcToken)
+	return t
+}
+
+// getStringList gets a list of strings from key in the input which
+// may be a JSON list or a comma separated string
+func getStringList(in rc.Params, key string) ([]string, error) {
+	value, err := in.Get(key)
+	if rc.IsErrParamNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if s, ok := value.(string); ok && !strings.HasPrefix(s, "[") {
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+	var list []string
+	err = in.GetStruct(key, &list)
+	return list, err
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "rc/token/create",
+		AuthRequired: true,
+		Fn:           rcTokenCreate,
+		Title:        "Create an API token for the rc",
+		Help: `This creates an API token which allows access to some commands on
+some remotes.  The token is saved in the config file.
+
+Parameters
+
+- name - name of the token (string)
+- commands - list of commands the token may use
+- remotes - list of remote names the token may use (optional - all if not set)
+
+These can be JSON lists or comma separated strings.  The commands and
+remotes may use glob patterns, e.g. "sync/*".  The remote name "local"
+matches local paths.  The commands may also contain these presets
+
+- @read - listing and the stats, e.g. "operations/list" and "core/stats"
+- @write - as @read and "sync/*", "operations/*" and "job/stop"
+
+Use "events" to allow the token to use the event stream and "serve"
+to allow it to fetch files from the remotes with --rc-serve.
+
+Results
+
+- token - the token (string)
+
+Clients use the token by passing it in the Authorization header as
+"Authorization: Bearer TOKEN".  It isn't possible to read the token
+again so keep it safe.
+
+Eg
+
+    rclone rc rc/token/create name=backup remotes=s3,drive commands=@write
+`,
+	})
+}
+
+// Creates a token
+func rcTokenCreate(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	commands, err := getStringList(in, "commands")
+	if err != nil {
+		return nil, err
+	}
+	remotes, err := getStringList(in, "remotes")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := tokens.create(name, remotes, commands)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"token": secret}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "rc/token/list",
+		AuthRequired: true,
+		Fn:           rcTokenList,
+		Title:        "List the API tokens for the rc",
+		Help: `This lists the API tokens without the secret tokens themselves.
+
+Results
+
+- tokens - array of tokens, each with
+    - name - name of the token
+    - commands - list of commands the token may use
+    - remotes - list of remote names the token may use - all if empty
+    - created - time the token was created
+`,
+	})
+}
+
+// Lists the tokens
+func rcTokenList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+	tokens.load()
+	list := []rc.Params{}
+	for _, t := range tokens.sorted() {
+		list = append(list, rc.Params{
+			"name":     t.Name,
+			"commands": t.Commands,
+			"remotes":  t.Remotes,
+			"created":  t.Created,
+		})
+	}
+	return rc.Params{"tokens": list}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "rc/token/delete",
+		AuthRequired: true,
+		Fn:           rcTokenDelete,
+		Title:        "Delete an API token for the rc",
+		Help: `This deletes the token so it can't be used any more.
+
+Parameters
+
+- name - name of the token (string)
+`,
+	})
+}
+
+// Deletes a token
+func rcTokenDelete(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{}, tokens.delete(name)
+}
@@ -0,0 +1,207 @@
+package rcserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockTokenConfig replaces the config file accessors and the tokens
+// with empty ones - call the returned function to restore them
+func mockTokenConfig() (map[string]string, func()) {
+	store := map[string]string{}
+	oldGet, oldSet := fs.ConfigFileGet, fs.ConfigFileSet
+	oldTokens := tokens
+	fs.ConfigFileGet = func(section, key string) (string, bool) {
+		value, ok := store[section+"."+key]
+		return value, ok
+	}
+	fs.ConfigFileSet = func(section, key, value string) error {
+		store[section+"."+key] = value
+		return nil
+	}
+	tokens = &tokenStore{tokens: map[string]*rcToken{}}
+	return store, func() {
+		fs.ConfigFileGet, fs.ConfigFileSet = oldGet, oldSet
+		tokens = oldTokens
+	}
+}
+
+func TestTokenCheck(t *testing.T) {
+	token := &rcToken{
+		Name:     "test",
+		Remotes:  []string{"s3*", "local"},
+		Commands: []string{"@read", "sync/copy"},
+	}
+	for _, test := range []struct {
+		command string
+		in      rc.Params
+		wantErr bool
+	}{
+		{"operations/list", rc.Params{"fs": "s3:bucket", "remote": "dir"}, false},
+		{"operations/list", rc.Params{"fs": "s3-eu:bucket"}, false},
+		{"operations/list", rc.Params{"fs": "/home/user"}, false},
+		{"operations/list", rc.Params{"fs": "drive:"}, true},
+		{"operations/purge", rc.Params{"fs": "s3:bucket"}, true},
+		{"sync/copy", rc.Params{"srcFs": "/home/user", "dstFs": "s3:bucket"}, false},
+		{"sync/copy", rc.Params{"srcFs": "drive:", "dstFs": "s3:bucket"}, true},
+		{"sync/copy", rc.Params{"srcFs": rc.Params{"type": "drive"}, "dstFs": "s3:bucket"}, true},
+		{"sync/sync", rc.Params{"srcFs": "/home/user", "dstFs": "s3:bucket"}, true},
+		{"core/stats", rc.Params{}, false},
+		{"core/quit", rc.Params{}, true},
+		{"job/import", rc.Params{"jobs": `[{"command":"sync/copy","params":{"srcFs":"/tmp","dstFs":"s3:bucket"}}]`}, true},
+	} {
+		err := token.check(test.command, test.in)
+		assert.Equal(t, test.wantErr, err != nil, "%s %v: %v", test.command, test.in, err)
+	}
+
+	// Imported jobs are checked too
+	token.Commands = append(token.Commands, "job/import")
+	assert.NoError(t, token.check("job/import", rc.Params{"jobs": `[{"command":"sync/copy","params":{"srcFs":"/tmp","dstFs":"s3:bucket"}}]`}))
+	assert.Error(t, token.check("job/import", rc.Params{"jobs": `[{"command":"sync/sync","params":{"srcFs":"/tmp","dstFs":"s3:bucket"}}]`}))
+	assert.Error(t, token.check("job/import", rc.Params{"jobs": `[{"command":"sync/copy","params":{"srcFs":"/tmp","dstFs":"drive:"}}]`}))
+
+	// No remotes means all remotes
+	token.Remotes = nil
+	assert.NoError(t, token.check("operations/list", rc.Params{"fs": "drive:"}))
+	assert.Error(t, token.checkServe("drive:"))
+	token.Commands = append(token.Commands, "serve")
+	assert.NoError(t, token.checkServe("drive:"))
+}
+
+func TestTokenStore(t *testing.T) {
+	store, cleanup := mockTokenConfig()
+	defer cleanup()
+
+	_, err := tokens.create("", nil, []string{"@read"})
+	assert.Error(t, err)
+	_, err = tokens.create("test", nil, nil)
+	assert.Error(t, err)
+	_, err = tokens.create("test", []string{"["}, []string{"@read"})
+	assert.Error(t, err)
+
+	secret, err := tokens.create("test", []string{"s3"}, []string{"@read"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, tokenPrefix))
+	assert.NotContains(t, store["rcd.tokens"], secret)
+	assert.Contains(t, store["rcd.tokens"], hashToken(secret))
+	_, err = tokens.create("test", nil, []string{"@read"})
+	assert.Error(t, err)
+
+	// A new store reads the tokens from the config
+	tokens = &tokenStore{tokens: map[string]*rcToken{}}
+	token, err := tokens.find(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "test", token.Name)
+	assert.Equal(t, []string{"s3"}, token.Remotes)
+	_, err = tokens.find("potato")
+	assert.Error(t, err)
+
+	require.NoError(t, tokens.delete("test"))
+	assert.Error(t, tokens.delete("test"))
+	_, err = tokens.find(secret)
+	assert.Error(t, err)
+}
+
+func TestRcToken(t *testing.T) {
+	_, cleanup := mockTokenConfig()
+	defer cleanup()
+
+	call := rc.Calls.Get("rc/token/create")
+	require.NotNil(t, call)
+	out, err := call.Fn(context.Background(), rc.Params{
+		"name":     "test",
+		"remotes":  "s3, drive",
+		"commands": `["@write","events"]`,
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, "", out["token"])
+
+	call = rc.Calls.Get("rc/token/list")
+	require.NotNil(t, call)
+	out, err = call.Fn(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	list := out["tokens"].([]rc.Params)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "test", list[0]["name"])
+	assert.Equal(t, []string{"s3", "drive"}, list[0]["remotes"])
+	assert.Equal(t, []string{"@write", "events"}, list[0]["commands"])
+	assert.NotContains(t, list[0], "hash")
+
+	call = rc.Calls.Get("rc/token/delete")
+	require.NotNil(t, call)
+	_, err = call.Fn(context.Background(), rc.Params{"name": "test"})
+	require.NoError(t, err)
+}
+
+func TestTokenAuth(t *testing.T) {
+	_, cleanup := mockTokenConfig()
+	defer cleanup()
+	secret, err := tokens.create("test", []string{"local"}, []string{"rc/noop", "rc/noopauth"})
+	require.NoError(t, err)
+
+	for _, withUser := range []bool{false, true} {
+		opt := newTestOpt()
+		opt.HTTPOptions.ListenAddr = testBindAddress
+		if withUser {
+			opt.HTTPOptions.BasicUser = "user"
+			opt.HTTPOptions.BasicPass = "pass"
+		}
+		mux := http.NewServeMux()
+		rcServer := newServer(context.Background(), &opt, mux)
+		require.NoError(t, rcServer.Serve())
+		testURL := rcServer.Server.URL()
+
+		post := func(path, token, body string) (int, string) {
+			req, err := http.NewRequest("POST", testURL+path, strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			data, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			return resp.StatusCode, string(data)
+		}
+
+		// A good token can use its commands including ones
+		// which need auth
+		status, _ := post("rc/noop", secret, `{}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = post("rc/noopauth", secret, `{}`)
+		assert.Equal(t, http.StatusOK, status)
+
+		// but not other commands or remotes
+		status, body := post("rc/error", secret, `{}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, `may not use \"rc/error\"`)
+		status, body = post("rc/noop", secret, `{"fs":"drive:"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, `may not use remote \"drive\"`)
+
+		// A bad token is rejected
+		status, _ = post("rc/noop", "potato", `{}`)
+		assert.Equal(t, http.StatusUnauthorized, status)
+
+		// No token works as before
+		status, _ = post("rc/noopauth", "", `{}`)
+		if withUser {
+			assert.Equal(t, http.StatusUnauthorized, status)
+		} else {
+			assert.Equal(t, http.StatusForbidden, status)
+		}
+
+		rcServer.Close()
+		rcServer.Wait()
+	}
+}
@@ -24,6 +24,7 @@ type Call struct {
 	Help          string // multi-line markdown formatted help
 	NeedsRequest  bool   // if set then this call will be passed the original request object as _request
 	NeedsResponse bool   // if set then this call will be passed the original response object as _response
+	Resumable     bool   // if set then async jobs of this call are saved so they can be resumed
 }
 
 // Registry holds the list of all the registered remote control functions
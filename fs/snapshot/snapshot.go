@@ -0,0 +1,381 @@
+// Package snapshot makes incremental point in time copies of a remote
+// which stay browsable as plain files.
+//
+// Each snapshot is a directory named by its ID holding the files which
+// were new or changed when it was made, and a manifest ID.json listing
+// every file in the snapshot and the snapshot directory it is stored
+// in.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+const (
+	// Latest can be used in place of the ID of the newest snapshot
+	Latest = "latest"
+
+	idFormat    = "2006-01-02T150405Z"
+	manifestExt = ".json"
+)
+
+// timeNow is used to make the ID of new snapshots - overridden in tests
+var timeNow = time.Now
+
+// File is a file in a snapshot
+type File struct {
+	Path     string    `json:"path"`               // path of the file relative to the source
+	Snapshot string    `json:"snapshot"`           // ID of the snapshot the file is stored in
+	Size     int64     `json:"size"`               // size of the file
+	ModTime  time.Time `json:"modtime"`            // modification time of the file
+	Hash     string    `json:"hash,omitempty"`     // hash of the file if --checksum was used
+	HashType string    `json:"hashType,omitempty"` // type of Hash
+}
+
+// remote returns where the file is stored
+func (file *File) remote() string {
+	return path.Join(file.Snapshot, file.Path)
+}
+
+// unchanged returns true if file is the same as other
+func (file *File) unchanged(other *File, modifyWindow time.Duration) bool {
+	if file.Size != other.Size {
+		return false
+	}
+	if file.Hash != "" && file.HashType == other.HashType && other.Hash != "" {
+		return file.Hash == other.Hash
+	}
+	dt := file.ModTime.Sub(other.ModTime)
+	return dt < modifyWindow && dt > -modifyWindow
+}
+
+// Manifest lists the files in a snapshot
+type Manifest struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Files  []File    `json:"files"`
+}
+
+// Info summarises a snapshot
+type Info struct {
+	ID         string    // ID of the snapshot
+	Time       time.Time // when the snapshot was made
+	Files      int       // number of files in the snapshot
+	Size       int64     // total size of the files
+	Stored     int       // number of files stored in this snapshot's directory
+	StoredSize int64     // total size of the files stored in this snapshot's directory
+}
+
+// IDs returns the IDs of the snapshots in f oldest first
+func IDs(ctx context.Context, f fs.Fs) (ids []string, err error) {
+	entries, err := f.List(ctx, "")
+	if err == fs.ErrorDirNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries.ForObject(func(o fs.Object) {
+		id := strings.TrimSuffix(o.Remote(), manifestExt)
+		if id == o.Remote() {
+			return
+		}
+		if _, err := time.Parse(idFormat, id); err == nil {
+			ids = append(ids, id)
+		}
+	})
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ReadManifest reads the manifest of snapshot id in f.  id may be
+// Latest to read the newest snapshot.
+func ReadManifest(ctx context.Context, f fs.Fs, id string) (m *Manifest, err error) {
+	if id == Latest {
+		ids, err := IDs(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, errors.Errorf("no snapshots found in %v", f)
+		}
+		id = ids[len(ids)-1]
+	}
+	o, err := f.NewObject(ctx, id+manifestExt)
+	if err == fs.ErrorObjectNotFound {
+		return nil, errors.Errorf("snapshot %q not found in %v", id, f)
+	} else if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open snapshot manifest")
+	}
+	defer fs.CheckClose(in, &err)
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshot manifest")
+	}
+	m = new(Manifest)
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode snapshot manifest %q", o.Remote())
+	}
+	return m, nil
+}
+
+// writeManifest writes m to f
+func writeManifest(ctx context.Context, f fs.Fs, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = operations.Rcat(ctx, f, m.ID+manifestExt, ioutil.NopCloser(bytes.NewReader(data)), m.Time)
+	if err != nil {
+		return errors.Wrap(err, "failed to write snapshot manifest")
+	}
+	return nil
+}
+
+// Create makes a new snapshot of fsrc in fdst and returns its ID.
+//
+// Files which are unchanged since the last snapshot are referenced
+// from the snapshot they are stored in rather than copied again.
+// Files are compared by size and modification time, or by hash if
+// --checksum is set.
+//
+// If any files fail to copy the manifest isn't written so the
+// snapshot isn't made.
+func Create(ctx context.Context, fdst, fsrc fs.Fs) (id string, err error) {
+	ci := fs.GetConfig(ctx)
+	ids, err := IDs(ctx, fdst)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list snapshots")
+	}
+	prev := make(map[string]*File)
+	if len(ids) > 0 {
+		prevManifest, err := ReadManifest(ctx, fdst, ids[len(ids)-1])
+		if err != nil {
+			return "", err
+		}
+		for i := range prevManifest.Files {
+			file := &prevManifest.Files[i]
+			prev[file.Path] = file
+		}
+	}
+	now := timeNow()
+	id = now.UTC().Format(idFormat)
+	if len(ids) > 0 && ids[len(ids)-1] >= id {
+		return "", errors.Errorf("snapshot %q already exists", ids[len(ids)-1])
+	}
+	ht := hash.None
+	if ci.CheckSum {
+		ht = fsrc.Hashes().GetOne()
+	}
+	modifyWindow := fs.GetModifyWindow(ctx, fsrc, fdst)
+	m := &Manifest{
+		ID:     id,
+		Time:   now,
+		Source: fs.ConfigString(fsrc),
+		Files:  []File{},
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		errCount int32
+		stored   int
+		srcs     = make(chan fs.Object, ci.Transfers)
+	)
+	wg.Add(ci.Transfers)
+	for i := 0; i < ci.Transfers; i++ {
+		go func() {
+			defer wg.Done()
+			for src := range srcs {
+				file, copied, err := snapshotFile(ctx, fdst, id, src, prev[src.Remote()], ht, modifyWindow)
+				if err != nil {
+					atomic.AddInt32(&errCount, 1)
+					continue
+				}
+				mu.Lock()
+				m.Files = append(m.Files, file)
+				if copied {
+					stored++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	err = operations.ListFn(ctx, fsrc, func(o fs.Object) {
+		srcs <- o
+	})
+	close(srcs)
+	wg.Wait()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list source")
+	}
+	if errCount > 0 {
+		return "", errors.Errorf("failed to copy %d files - not saving snapshot %q", errCount, id)
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	if ci.DryRun {
+		fs.Logf(fdst, "Not saving snapshot %q as --dry-run is set", id)
+		return id, nil
+	}
+	err = writeManifest(ctx, fdst, m)
+	if err != nil {
+		return "", err
+	}
+	fs.Infof(fdst, "Created snapshot %q of %d files with %d new or changed", id, len(m.Files), stored)
+	return id, nil
+}
+
+// snapshotFile adds src to snapshot id in fdst returning its entry in
+// the manifest and whether it was copied.  prev is its entry in the
+// last snapshot or nil if it wasn't in it.
+func snapshotFile(ctx context.Context, fdst fs.Fs, id string, src fs.Object, prev *File, ht hash.Type, modifyWindow time.Duration) (file File, copied bool, err error) {
+	file = File{
+		Path:     src.Remote(),
+		Snapshot: id,
+		Size:     src.Size(),
+		ModTime:  src.ModTime(ctx),
+	}
+	if ht != hash.None {
+		file.Hash, err = src.Hash(ctx, ht)
+		if err != nil {
+			err = fs.CountError(err)
+			fs.Errorf(src, "Failed to read hash: %v", err)
+			return file, false, err
+		}
+		file.HashType = ht.String()
+	}
+	if prev != nil && file.unchanged(prev, modifyWindow) {
+		tr := accounting.Stats(ctx).NewCheckingTransfer(src)
+		tr.Done(ctx, nil)
+		fs.Debugf(src, "Unchanged since snapshot %q", prev.Snapshot)
+		file.Snapshot = prev.Snapshot
+		return file, false, nil
+	}
+	_, err = operations.Copy(ctx, fdst, nil, file.remote(), src)
+	return file, true, err
+}
+
+// List returns a summary of each snapshot in f oldest first
+func List(ctx context.Context, f fs.Fs) (infos []Info, err error) {
+	ids, err := IDs(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		m, err := ReadManifest(ctx, f, id)
+		if err != nil {
+			return nil, err
+		}
+		info := Info{
+			ID:    m.ID,
+			Time:  m.Time,
+			Files: len(m.Files),
+		}
+		for _, file := range m.Files {
+			info.Size += file.Size
+			if file.Snapshot == m.ID {
+				info.Stored++
+				info.StoredSize += file.Size
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Restore copies the files in snapshot id of fsnap to fdst.  id may be
+// Latest to restore the newest snapshot.
+//
+// Files in fdst which are the same as in the snapshot aren't copied
+// and files in fdst which aren't in the snapshot are left alone.
+// Filters apply to the files in the snapshot.
+func Restore(ctx context.Context, fdst, fsnap fs.Fs, id string) error {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	m, err := ReadManifest(ctx, fsnap, id)
+	if err != nil {
+		return err
+	}
+	var (
+		wg       sync.WaitGroup
+		errCount int32
+		files    = make(chan *File, ci.Transfers)
+	)
+	wg.Add(ci.Transfers)
+	for i := 0; i < ci.Transfers; i++ {
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				err := restoreFile(ctx, fdst, fsnap, file)
+				if err != nil {
+					atomic.AddInt32(&errCount, 1)
+				}
+			}
+		}()
+	}
+outer:
+	for i := range m.Files {
+		file := &m.Files[i]
+		if !fi.Include(file.Path, file.Size, file.ModTime) {
+			continue
+		}
+		select {
+		case files <- file:
+		case <-ctx.Done():
+			break outer
+		}
+	}
+	close(files)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errCount > 0 {
+		return errors.Errorf("failed to restore %d files from snapshot %q", errCount, m.ID)
+	}
+	return nil
+}
+
+// restoreFile copies file from the snapshot in fsnap to fdst
+func restoreFile(ctx context.Context, fdst, fsnap fs.Fs, file *File) error {
+	src, err := fsnap.NewObject(ctx, file.remote())
+	if err != nil {
+		err = fs.CountError(errors.Wrapf(err, "snapshot %q is missing", file.Snapshot))
+		fs.Errorf(file.Path, "Failed to restore: %v", err)
+		return err
+	}
+	dst, err := fdst.NewObject(ctx, file.Path)
+	if err == fs.ErrorObjectNotFound {
+		dst = nil
+	} else if err != nil {
+		return fs.CountError(err)
+	}
+	if dst != nil && !operations.NeedTransfer(ctx, dst, src) {
+		return nil
+	}
+	_, err = operations.Copy(ctx, fdst, dst, file.Path, src)
+	return err
+}
@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/all" // import all backends
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Some times used in the tests
+var (
+	t1 = fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 = fstest.Time("2011-12-25T12:59:59.123456789Z")
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	oldTimeNow := timeNow
+	defer func() {
+		timeNow = oldTimeNow
+	}()
+	setTime := func(s string) {
+		timeNow = func() time.Time {
+			return fstest.Time(s)
+		}
+	}
+
+	ids, err := IDs(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(ids))
+	_, err = ReadManifest(ctx, r.Fremote, Latest)
+	assert.Error(t, err)
+
+	// First snapshot stores everything
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	file2 := r.WriteFile("dir/file2", "file2 contents", t1)
+	setTime("2021-01-02T03:04:05Z")
+	id1, err := Create(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+	assert.Equal(t, "2021-01-02T030405Z", id1)
+
+	// Second snapshot only stores what changed
+	file2b := r.WriteFile("dir/file2", "file2 changed contents", t2)
+	file3 := r.WriteFile("file3", "file3 contents", t2)
+	setTime("2021-01-03T03:04:05Z")
+	id2, err := Create(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+
+	// Can't make another snapshot in the same second
+	_, err = Create(ctx, r.Fremote, r.Flocal)
+	assert.Error(t, err)
+
+	// Unchanged files are only stored in the first snapshot
+	for _, remote := range []string{
+		id1 + manifestExt,
+		id1 + "/file1",
+		id1 + "/dir/file2",
+		id2 + manifestExt,
+		id2 + "/dir/file2",
+		id2 + "/file3",
+	} {
+		_, err := r.Fremote.NewObject(ctx, remote)
+		assert.NoError(t, err, remote)
+	}
+	_, err = r.Fremote.NewObject(ctx, id2+"/file1")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	infos, err := List(ctx, r.Fremote)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(infos))
+	assert.Equal(t, Info{ID: id1, Time: infos[0].Time, Files: 2, Size: 28, Stored: 2, StoredSize: 28}, infos[0])
+	assert.Equal(t, Info{ID: id2, Time: infos[1].Time, Files: 3, Size: 50, Stored: 2, StoredSize: 36}, infos[1])
+
+	m, err := ReadManifest(ctx, r.Fremote, Latest)
+	require.NoError(t, err)
+	assert.Equal(t, id2, m.ID)
+	require.Equal(t, 3, len(m.Files))
+	assert.Equal(t, "file1", m.Files[1].Path)
+	assert.Equal(t, id1, m.Files[1].Snapshot)
+
+	// Restore the first snapshot over the changed files
+	r.Mkdir(ctx, r.Flocal)
+	require.NoError(t, Restore(ctx, r.Flocal, r.Fremote, id1))
+	fstest.CheckItems(t, r.Flocal, file1, file2, file3)
+
+	// And the latest one
+	require.NoError(t, Restore(ctx, r.Flocal, r.Fremote, Latest))
+	fstest.CheckItems(t, r.Flocal, file1, file2b, file3)
+}
@@ -0,0 +1,119 @@
+// Atomic syncs by uploading to a staging directory first
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// atomicStagePrefix starts the name of the staging directory used by
+// --sync-atomic in the root of the destination
+const atomicStagePrefix = ".rclone-atomic-"
+
+// stagedFile is a file uploaded to the staging directory
+type stagedFile struct {
+	staged fs.Object // the file in the staging directory
+	dst    fs.Object // the file it replaces, may be nil
+}
+
+// isStage returns true if remote is in a --sync-atomic staging
+// directory
+func isStage(remote string) bool {
+	return strings.HasPrefix(remote, atomicStagePrefix)
+}
+
+// makeStage makes the Fs for the staging directory for --sync-atomic
+func (s *syncCopyMove) makeStage(ctx context.Context) (err error) {
+	switch {
+	case s.DoMove:
+		return fserrors.FatalError(errors.New("can't use --sync-atomic when moving"))
+	case s.trackRenames:
+		return fserrors.FatalError(errors.New("can't use --sync-atomic with --track-renames"))
+	case s.ci.BackupDir != "" || s.ci.Suffix != "" || s.ci.BackupVersioning:
+		return fserrors.FatalError(errors.New("can't use --sync-atomic with --backup-dir, --suffix or --backup-versioning"))
+	case s.ci.CopyDest != "":
+		return fserrors.FatalError(errors.New("can't use --sync-atomic with --copy-dest"))
+	case s.fdst.Features().Move == nil:
+		return fserrors.FatalError(errors.New("can't use --sync-atomic as the destination doesn't support server-side moves"))
+	}
+	stageName := atomicStagePrefix + random.String(8)
+	s.stage, err = cache.Get(ctx, fspath.JoinRootPath(fs.ConfigString(s.fdst), stageName))
+	if err != nil {
+		return errors.Wrap(err, "failed to make --sync-atomic staging directory")
+	}
+	// Not cancelled with the sync so the commit and clean up finish
+	s.stageCtx = ctx
+	fs.GetLogger(ctx).Infof(s.fdst, "Uploading to staging directory %q", stageName)
+	return nil
+}
+
+// addStaged records that staged has been uploaded to replace dst
+func (s *syncCopyMove) addStaged(staged, dst fs.Object) {
+	s.stagedMu.Lock()
+	s.staged = append(s.staged, stagedFile{staged: staged, dst: dst})
+	s.stagedMu.Unlock()
+}
+
+// commitStage moves the staged files into place with server-side moves
+func (s *syncCopyMove) commitStage() error {
+	ctx := s.stageCtx
+	fs.GetLogger(ctx).Infof(s.fdst, "Committing %d staged files", len(s.staged))
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+		in       = make(chan stagedFile)
+	)
+	for i := 0; i < s.ci.Transfers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range in {
+				_, err := operations.Move(ctx, s.fdst, file.dst, file.staged.Remote(), file.staged)
+				if err != nil {
+					err = fs.CountError(err)
+					fs.GetLogger(ctx).Errorf(file.staged, "Failed to commit staged file: %v", err)
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, file := range s.staged {
+		in <- file
+	}
+	close(in)
+	wg.Wait()
+	return firstErr
+}
+
+// finishStage commits the staged files if there were no errors then
+// removes the staging directory.
+func (s *syncCopyMove) finishStage() {
+	ctx := s.stageCtx
+	failed := s.currentError() != nil || s.ctx.Err() != nil
+	if failed {
+		fs.GetLogger(ctx).Errorf(s.fdst, "Not committing the staged files as there were errors")
+	} else if len(s.staged) != 0 {
+		s.processError(s.commitStage())
+	}
+	if len(s.staged) != 0 || failed {
+		err := operations.Purge(ctx, s.stage, "")
+		if err != nil && err != fs.ErrorDirNotFound {
+			fs.GetLogger(ctx).Errorf(s.stage, "Failed to remove staging directory: %v", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkNoStage checks there is no staging directory left in f
+func checkNoStage(t *testing.T, f fs.Fs) {
+	entries, err := ioutil.ReadDir(f.Root())
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, isStage(entry.Name()), entry.Name())
+	}
+}
+
+func TestSyncAtomic(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	if r.Fremote.Name() != "local" {
+		t.Skip("This test only runs on local")
+	}
+	ci.SyncAtomic = true
+	defer func() {
+		ci.SyncAtomic = false
+	}()
+
+	file1 := r.WriteFile("sub dir/new", "new", t1)
+	file2 := r.WriteFile("changed", "changed", t2)
+	r.WriteObject(ctx, "changed", "old", t1)
+	r.WriteObject(ctx, "deleted", "deleted", t1)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+	checkNoStage(t, r.Fremote)
+}
+
+func TestSyncAtomicFailed(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	if r.Fremote.Name() != "local" {
+		t.Skip("This test only runs on local")
+	}
+	oldMaxTransfer, oldTransfers, oldCutoff := ci.MaxTransfer, ci.Transfers, ci.CutoffMode
+	ci.SyncAtomic = true
+	ci.MaxTransfer = 3 * 1024
+	ci.Transfers = 1
+	ci.CutoffMode = fs.CutoffModeHard
+	defer func() {
+		ci.SyncAtomic = false
+		ci.MaxTransfer, ci.Transfers, ci.CutoffMode = oldMaxTransfer, oldTransfers, oldCutoff
+		accounting.GlobalStats().ResetCounters()
+	}()
+
+	r.WriteFile("file1", string(make([]byte, 2*1024)), t1)
+	r.WriteFile("file2", string(make([]byte, 5*1024)), t1)
+	old := r.WriteObject(ctx, "old", "old", t1)
+
+	// Nothing changes in the destination if any transfer fails
+	accounting.GlobalStats().ResetCounters()
+	require.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, old)
+	checkNoStage(t, r.Fremote)
+}
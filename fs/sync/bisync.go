@@ -0,0 +1,548 @@
+// Three way sync between two paths
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// ConflictMode is how Bisync resolves a file which has been changed
+// in both paths
+type ConflictMode int
+
+// Conflict modes
+const (
+	ConflictRename      ConflictMode = iota // rename both versions so both are kept
+	ConflictNewer                           // keep the newer version
+	ConflictLarger                          // keep the larger version
+	ConflictInteractive                     // ask the user
+)
+
+func (x ConflictMode) String() string {
+	switch x {
+	case ConflictRename:
+		return "rename"
+	case ConflictNewer:
+		return "newer"
+	case ConflictLarger:
+		return "larger"
+	case ConflictInteractive:
+		return "interactive"
+	}
+	return "unknown"
+}
+
+// Set a ConflictMode from a string
+func (x *ConflictMode) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "rename":
+		*x = ConflictRename
+	case "newer":
+		*x = ConflictNewer
+	case "larger":
+		*x = ConflictLarger
+	case "interactive":
+		*x = ConflictInteractive
+	default:
+		return errors.Errorf("unknown conflict mode %q", s)
+	}
+	return nil
+}
+
+// Type of the value
+func (x *ConflictMode) Type() string {
+	return "string"
+}
+
+// BisyncOpt are the options for Bisync
+type BisyncOpt struct {
+	ConflictMode ConflictMode // how to resolve files changed in both paths
+	Force        bool         // carry on even if one of the paths is empty
+}
+
+// bisyncEntry is a file in the snapshot
+type bisyncEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// bisyncSnapshot is the state of both paths when they last agreed
+type bisyncSnapshot struct {
+	Path1  string                 `json:"path1"`
+	Path2  string                 `json:"path2"`
+	Time   time.Time              `json:"time"`
+	Files1 map[string]bisyncEntry `json:"files1"`
+	Files2 map[string]bisyncEntry `json:"files2"`
+}
+
+// bisyncChange is how a file has changed in one path since the
+// snapshot
+type bisyncChange int
+
+const (
+	bisyncAbsent    bisyncChange = iota // not in the snapshot or the path
+	bisyncUnchanged                     // the same as the snapshot
+	bisyncNew                           // not in the snapshot
+	bisyncChanged                       // different from the snapshot
+	bisyncDeleted                       // in the snapshot but not the path
+)
+
+// bisyncChangeOf returns how o has changed since the snapshot which
+// had entry if found was set
+func bisyncChangeOf(ctx context.Context, o fs.Object, entry bisyncEntry, found bool) bisyncChange {
+	switch {
+	case o == nil && !found:
+		return bisyncAbsent
+	case o == nil:
+		return bisyncDeleted
+	case !found:
+		return bisyncNew
+	case o.Size() == entry.Size && o.ModTime(ctx).Equal(entry.ModTime):
+		return bisyncUnchanged
+	}
+	return bisyncChanged
+}
+
+// bisyncAction is what to do with a file to make the paths agree
+type bisyncAction int
+
+const (
+	bisyncCopy1to2 bisyncAction = iota // copy the file in path1 to path2
+	bisyncCopy2to1                     // copy the file in path2 to path1
+	bisyncDelete1                      // delete the file in path1
+	bisyncDelete2                      // delete the file in path2
+	bisyncRename                       // rename both files and copy them across
+)
+
+// bisyncJob is an action to do on a file
+type bisyncJob struct {
+	remote string
+	action bisyncAction
+	o1     fs.Object // the file in path1, may be nil
+	o2     fs.Object // the file in path2, may be nil
+}
+
+// bisync holds the state of a run of Bisync
+type bisync struct {
+	ctx        context.Context
+	f1, f2     fs.Fs
+	opt        BisyncOpt
+	first      bool // set if there was no snapshot
+	mu         sync.Mutex
+	snap       *bisyncSnapshot     // the new snapshot, updated as jobs are done
+	unresolved map[string]struct{} // files left to do next time
+	failed     int                 // number of files which failed
+}
+
+// bisyncSnapshotPath returns the path of the snapshot for f1 and f2
+func bisyncSnapshotPath(f1, f2 fs.Fs) string {
+	return stateFilePath("bisync", f1, f2)
+}
+
+// listObjects returns the objects in f by remote.  A missing
+// directory is returned as empty.
+func listObjects(ctx context.Context, f fs.Fs) (map[string]fs.Object, error) {
+	ci := fs.GetConfig(ctx)
+	var mu sync.Mutex
+	objects := make(map[string]fs.Object)
+	err := walk.ListR(ctx, f, "", false, ci.MaxDepth, walk.ListObjects, func(entries fs.DirEntries) error {
+		mu.Lock()
+		defer mu.Unlock()
+		entries.ForObject(func(o fs.Object) {
+			objects[o.Remote()] = o
+		})
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		return objects, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %v", f)
+	}
+	return objects, nil
+}
+
+// snapshotEntry makes the snapshot entry for o
+func snapshotEntry(ctx context.Context, o fs.Object) bisyncEntry {
+	return bisyncEntry{
+		Size:    o.Size(),
+		ModTime: o.ModTime(ctx),
+	}
+}
+
+// snapshotEntries makes the snapshot entries for objects
+func snapshotEntries(ctx context.Context, objects map[string]fs.Object) map[string]bisyncEntry {
+	entries := make(map[string]bisyncEntry, len(objects))
+	for remote, o := range objects {
+		entries[remote] = snapshotEntry(ctx, o)
+	}
+	return entries
+}
+
+// Bisync makes the files in f1 and f2 the same by copying the files
+// changed since the last run to the other path and deleting the files
+// deleted since the last run from the other path.
+//
+// Files changed in both paths are resolved using opt.ConflictMode.
+//
+// The state of the paths when they last agreed is kept in a snapshot
+// in the cache directory.  If there is no snapshot then nothing is
+// deleted and of the files which are different in the two paths the
+// newer is kept.
+func Bisync(ctx context.Context, f1, f2 fs.Fs, opt BisyncOpt) error {
+	ci := fs.GetConfig(ctx)
+	if operations.Overlapping(f1, f2) {
+		return fserrors.FatalError(fs.ErrorOverlapping)
+	}
+	snapshotPath := bisyncSnapshotPath(f1, f2)
+	var snap bisyncSnapshot
+	found, err := readStateFile(snapshotPath, &snap)
+	if err != nil {
+		return errors.Wrap(err, "failed to read bisync snapshot")
+	}
+	if !found {
+		fs.Infof(nil, "No bisync snapshot found so not deleting anything and keeping the newer of files which differ this time")
+	}
+	objects1, err := listObjects(ctx, f1)
+	if err != nil {
+		return err
+	}
+	objects2, err := listObjects(ctx, f2)
+	if err != nil {
+		return err
+	}
+	b := newBisync(ctx, f1, f2, opt, !found, objects1, objects2)
+	if !opt.Force {
+		if len(objects1) == 0 && len(snap.Files1) != 0 {
+			return fserrors.FatalError(errors.Errorf("%v is empty but had %d files last time - use --force to delete them from %v", f1, len(snap.Files1), f2))
+		}
+		if len(objects2) == 0 && len(snap.Files2) != 0 {
+			return fserrors.FatalError(errors.Errorf("%v is empty but had %d files last time - use --force to delete them from %v", f2, len(snap.Files2), f1))
+		}
+	}
+
+	jobs := b.plan(objects1, objects2, &snap)
+	b.run(jobs, ci.Transfers)
+
+	if ci.DryRun {
+		fs.Logf(nil, "Not updating the bisync snapshot as --dry-run is set")
+	} else {
+		err = b.saveSnapshot(snapshotPath, &snap)
+		if err != nil {
+			return err
+		}
+	}
+	if b.failed != 0 {
+		return errors.Errorf("failed to bisync %d files", b.failed)
+	}
+	return nil
+}
+
+// newBisync makes the state for a run of Bisync with objects1 and
+// objects2 the files in f1 and f2 before it started.
+//
+// The new snapshot starts as these listings and is updated as the
+// jobs are done, so a file changed while the run is in progress isn't
+// recorded as agreed and its change is found next time.
+func newBisync(ctx context.Context, f1, f2 fs.Fs, opt BisyncOpt, first bool, objects1, objects2 map[string]fs.Object) *bisync {
+	return &bisync{
+		ctx:   ctx,
+		f1:    f1,
+		f2:    f2,
+		opt:   opt,
+		first: first,
+		snap: &bisyncSnapshot{
+			Path1:  fs.ConfigString(f1),
+			Path2:  fs.ConfigString(f2),
+			Files1: snapshotEntries(ctx, objects1),
+			Files2: snapshotEntries(ctx, objects2),
+		},
+		unresolved: make(map[string]struct{}),
+	}
+}
+
+// plan works out what to do with each file
+func (b *bisync) plan(objects1, objects2 map[string]fs.Object, snap *bisyncSnapshot) (jobs []bisyncJob) {
+	remotes := make(map[string]struct{}, len(objects1))
+	for _, m := range []map[string]fs.Object{objects1, objects2} {
+		for remote := range m {
+			remotes[remote] = struct{}{}
+		}
+	}
+	for _, m := range []map[string]bisyncEntry{snap.Files1, snap.Files2} {
+		for remote := range m {
+			remotes[remote] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(remotes))
+	for remote := range remotes {
+		sorted = append(sorted, remote)
+	}
+	sort.Strings(sorted)
+	for _, remote := range sorted {
+		o1, o2 := objects1[remote], objects2[remote]
+		entry1, found1 := snap.Files1[remote]
+		entry2, found2 := snap.Files2[remote]
+		c1 := bisyncChangeOf(b.ctx, o1, entry1, found1)
+		c2 := bisyncChangeOf(b.ctx, o2, entry2, found2)
+		job := bisyncJob{remote: remote, o1: o1, o2: o2}
+		switch {
+		case o1 == nil && o2 == nil:
+			continue
+		case o2 == nil:
+			if c2 == bisyncDeleted && c1 == bisyncUnchanged {
+				job.action = bisyncDelete1
+			} else {
+				job.action = bisyncCopy1to2
+			}
+		case o1 == nil:
+			if c1 == bisyncDeleted && c2 == bisyncUnchanged {
+				job.action = bisyncDelete2
+			} else {
+				job.action = bisyncCopy2to1
+			}
+		case c1 == bisyncUnchanged && c2 == bisyncUnchanged:
+			continue
+		case operations.Equal(b.ctx, o1, o2):
+			continue
+		case c2 == bisyncUnchanged:
+			job.action = bisyncCopy1to2
+		case c1 == bisyncUnchanged:
+			job.action = bisyncCopy2to1
+		case b.first:
+			job.action = b.resolveFirst(o1, o2)
+		default:
+			var ok bool
+			job.action, ok = b.resolveConflict(o1, o2)
+			if !ok {
+				fs.Logf(remote, "Skipping file changed in both paths")
+				b.unresolved[remote] = struct{}{}
+				continue
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// resolveFirst decides which of o1 and o2 to keep when they differ
+// and there is no snapshot, keeping the newer one or the one in path1
+// if their modification times are the same.
+func (b *bisync) resolveFirst(o1, o2 fs.Object) bisyncAction {
+	dt := o2.ModTime(b.ctx).Sub(o1.ModTime(b.ctx))
+	if dt > fs.GetModifyWindow(b.ctx, b.f1, b.f2) {
+		fs.Infof(o1, "Different in the paths with no snapshot - keeping the newer one in %v", b.f2)
+		return bisyncCopy2to1
+	}
+	fs.Infof(o1, "Different in the paths with no snapshot - keeping the one in %v", b.f1)
+	return bisyncCopy1to2
+}
+
+// resolveConflict decides what to do with o1 and o2 which have both
+// changed, returning false if they should be skipped.
+func (b *bisync) resolveConflict(o1, o2 fs.Object) (action bisyncAction, ok bool) {
+	remote := o1.Remote()
+	switch b.opt.ConflictMode {
+	case ConflictNewer:
+		t1, t2 := o1.ModTime(b.ctx), o2.ModTime(b.ctx)
+		if t1.After(t2) {
+			fs.Infof(remote, "Changed in both paths - keeping the newer one in %v", b.f1)
+			return bisyncCopy1to2, true
+		} else if t2.After(t1) {
+			fs.Infof(remote, "Changed in both paths - keeping the newer one in %v", b.f2)
+			return bisyncCopy2to1, true
+		}
+	case ConflictLarger:
+		if o1.Size() > o2.Size() {
+			fs.Infof(remote, "Changed in both paths - keeping the larger one in %v", b.f1)
+			return bisyncCopy1to2, true
+		} else if o2.Size() > o1.Size() {
+			fs.Infof(remote, "Changed in both paths - keeping the larger one in %v", b.f2)
+			return bisyncCopy2to1, true
+		}
+	case ConflictInteractive:
+		fmt.Printf("%s: changed in both paths\n", remote)
+		for i, o := range []fs.Object{o1, o2} {
+			fmt.Printf("  %d: %12d bytes, %s, %v\n", i+1, o.Size(), o.ModTime(b.ctx).Local().Format("2006-01-02 15:04:05.000000000"), o.Fs())
+		}
+		switch config.Command([]string{"1Keep the file in path1", "2Keep the file in path2", "rRename both to keep both", "sSkip and do nothing"}) {
+		case '1':
+			return bisyncCopy1to2, true
+		case '2':
+			return bisyncCopy2to1, true
+		case 's':
+			return 0, false
+		}
+		return bisyncRename, true
+	}
+	fs.Infof(remote, "Changed in both paths - renaming both")
+	return bisyncRename, true
+}
+
+// run does the jobs using transfers go routines
+func (b *bisync) run(jobs []bisyncJob, transfers int) {
+	if transfers < 1 {
+		transfers = 1
+	}
+	in := make(chan bisyncJob)
+	var wg sync.WaitGroup
+	for i := 0; i < transfers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range in {
+				err := b.do(job)
+				if err != nil {
+					err = fs.CountError(err)
+					fs.Errorf(job.remote, "Failed to bisync: %v", err)
+					b.mu.Lock()
+					b.unresolved[job.remote] = struct{}{}
+					b.failed++
+					b.mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		in <- job
+	}
+	close(in)
+	wg.Wait()
+}
+
+// setEntry records o, which may be nil if nothing was done, at
+// remote in files of the new snapshot
+func (b *bisync) setEntry(files map[string]bisyncEntry, remote string, o fs.Object) {
+	if o == nil {
+		return
+	}
+	entry := snapshotEntry(b.ctx, o)
+	b.mu.Lock()
+	files[remote] = entry
+	b.mu.Unlock()
+}
+
+// deleteEntry removes remote from files of the new snapshot
+func (b *bisync) deleteEntry(files map[string]bisyncEntry, remote string) {
+	b.mu.Lock()
+	delete(files, remote)
+	b.mu.Unlock()
+}
+
+// do a single job recording what was done in the new snapshot
+func (b *bisync) do(job bisyncJob) (err error) {
+	var newObj fs.Object
+	switch job.action {
+	case bisyncCopy1to2:
+		newObj, err = operations.Copy(b.ctx, b.f2, job.o2, job.remote, job.o1)
+		if err == nil {
+			b.setEntry(b.snap.Files2, job.remote, newObj)
+		}
+	case bisyncCopy2to1:
+		newObj, err = operations.Copy(b.ctx, b.f1, job.o1, job.remote, job.o2)
+		if err == nil {
+			b.setEntry(b.snap.Files1, job.remote, newObj)
+		}
+	case bisyncDelete1:
+		err = operations.DeleteFile(b.ctx, job.o1)
+		if err == nil {
+			b.deleteEntry(b.snap.Files1, job.remote)
+		}
+	case bisyncDelete2:
+		err = operations.DeleteFile(b.ctx, job.o2)
+		if err == nil {
+			b.deleteEntry(b.snap.Files2, job.remote)
+		}
+	case bisyncRename:
+		err = b.rename(job)
+	}
+	return err
+}
+
+// conflictName returns a name for the file at remote from path n
+// which isn't in use in either path
+func (b *bisync) conflictName(remote string, n int) string {
+	name := fmt.Sprintf("%s.conflict%d", remote, n)
+	for i := 2; ; i++ {
+		_, err1 := b.f1.NewObject(b.ctx, name)
+		_, err2 := b.f2.NewObject(b.ctx, name)
+		if err1 == fs.ErrorObjectNotFound && err2 == fs.ErrorObjectNotFound {
+			return name
+		}
+		name = fmt.Sprintf("%s.conflict%d-%d", remote, n, i)
+	}
+}
+
+// rename both files in job and copy them to the other path so both
+// paths have both of them
+func (b *bisync) rename(job bisyncJob) error {
+	name1 := b.conflictName(job.remote, 1)
+	name2 := b.conflictName(job.remote, 2)
+	new1, err := operations.Move(b.ctx, b.f1, nil, name1, job.o1)
+	if err != nil {
+		return err
+	}
+	if new1 != nil {
+		b.deleteEntry(b.snap.Files1, job.remote)
+		b.setEntry(b.snap.Files1, name1, new1)
+	}
+	new2, err := operations.Move(b.ctx, b.f2, nil, name2, job.o2)
+	if err != nil {
+		return err
+	}
+	if new2 != nil {
+		b.deleteEntry(b.snap.Files2, job.remote)
+		b.setEntry(b.snap.Files2, name2, new2)
+	}
+	if new1 == nil || new2 == nil {
+		// Not moved because of --dry-run or --interactive
+		return nil
+	}
+	copy1, err := operations.Copy(b.ctx, b.f2, nil, name1, new1)
+	if err != nil {
+		return err
+	}
+	b.setEntry(b.snap.Files2, name1, copy1)
+	copy2, err := operations.Copy(b.ctx, b.f1, nil, name2, new2)
+	if err != nil {
+		return err
+	}
+	b.setEntry(b.snap.Files1, name2, copy2)
+	return nil
+}
+
+// saveSnapshot saves the new snapshot made from the listings before
+// the run and the jobs which were done, keeping the old entries of
+// the files which weren't resolved so they are tried again next time.
+func (b *bisync) saveSnapshot(snapshotPath string, old *bisyncSnapshot) error {
+	snap := b.snap
+	snap.Time = time.Now()
+	for remote := range b.unresolved {
+		restore := func(files, oldFiles map[string]bisyncEntry) {
+			if entry, found := oldFiles[remote]; found {
+				files[remote] = entry
+			} else {
+				delete(files, remote)
+			}
+		}
+		restore(snap.Files1, old.Files1)
+		restore(snap.Files2, old.Files2)
+	}
+	err := writeStateFile(snapshotPath, snap)
+	if err != nil {
+		return errors.Wrap(err, "failed to write bisync snapshot")
+	}
+	return nil
+}
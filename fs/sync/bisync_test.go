@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictModeSet(t *testing.T) {
+	var mode ConflictMode
+	for _, want := range []ConflictMode{ConflictRename, ConflictNewer, ConflictLarger, ConflictInteractive} {
+		require.NoError(t, mode.Set(want.String()))
+		assert.Equal(t, want, mode)
+	}
+	assert.Error(t, mode.Set("potato"))
+}
+
+func TestBisync(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	cacheDir, err := ioutil.TempDir("", "rclone-bisync")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(cacheDir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = cacheDir
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+
+	// First run copies everything across keeping the newer of files
+	// which differ
+	a1 := r.WriteFile("a", "a", t1)
+	b2 := r.WriteObject(ctx, "b", "b", t1)
+	r.WriteFile("c", "c1", t1)
+	c2 := r.WriteObject(ctx, "c", "c2", t2)
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{}))
+	fstest.CheckItems(t, r.Flocal, a1, b2, c2)
+	fstest.CheckItems(t, r.Fremote, a1, b2, c2)
+
+	// Changes and deletes go both ways
+	a2 := r.WriteObject(ctx, "a", "a changed", t2)
+	require.NoError(t, os.Remove(filepath.Join(r.LocalName, "b")))
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{}))
+	fstest.CheckItems(t, r.Flocal, a2, c2)
+	fstest.CheckItems(t, r.Fremote, a2, c2)
+
+	// Files changed in both paths are renamed
+	c1 := r.WriteFile("c", "c changed in path1", t3)
+	c2 = r.WriteObject(ctx, "c", "c changed in path two", t2)
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{}))
+	c1.Path = "c.conflict1"
+	c2.Path = "c.conflict2"
+	fstest.CheckItems(t, r.Flocal, a2, c1, c2)
+	fstest.CheckItems(t, r.Fremote, a2, c1, c2)
+
+	// The newer file wins
+	a1 = r.WriteFile("a", "a changed in path1", t3)
+	r.WriteObject(ctx, "a", "a changed in path2", t2)
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{ConflictMode: ConflictNewer}))
+	fstest.CheckItems(t, r.Flocal, a1, c1, c2)
+	fstest.CheckItems(t, r.Fremote, a1, c1, c2)
+
+	// A file changed while the run is in progress isn't recorded as
+	// agreed so it is copied next time
+	snapshotPath := bisyncSnapshotPath(r.Flocal, r.Fremote)
+	var snap bisyncSnapshot
+	found, err := readStateFile(snapshotPath, &snap)
+	require.NoError(t, err)
+	require.True(t, found)
+	objects1, err := listObjects(ctx, r.Flocal)
+	require.NoError(t, err)
+	objects2, err := listObjects(ctx, r.Fremote)
+	require.NoError(t, err)
+	d1 := r.WriteFile("d", "d", t1)
+	b := newBisync(ctx, r.Flocal, r.Fremote, BisyncOpt{}, false, objects1, objects2)
+	b.run(b.plan(objects1, objects2, &snap), 1)
+	a1 = r.WriteFile("a", "a changed during the run", t1)
+	require.NoError(t, b.saveSnapshot(snapshotPath, &snap))
+	fstest.CheckItems(t, r.Fremote, fstest.NewItem("a", "a changed in path1", t3), c1, c2)
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{}))
+	fstest.CheckItems(t, r.Flocal, a1, c1, c2, d1)
+	fstest.CheckItems(t, r.Fremote, a1, c1, c2, d1)
+
+	// A path which has become empty stops the sync
+	for _, name := range []string{"a", "c.conflict1", "c.conflict2", "d"} {
+		require.NoError(t, os.Remove(filepath.Join(r.LocalName, name)))
+	}
+	err = Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--force")
+	fstest.CheckItems(t, r.Fremote, a1, c1, c2, d1)
+
+	require.NoError(t, Bisync(ctx, r.Flocal, r.Fremote, BisyncOpt{Force: true}))
+	fstest.CheckItems(t, r.Fremote)
+}
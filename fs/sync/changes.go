@@ -0,0 +1,308 @@
+// Sync only the paths which have changed using the change tokens of
+// the source
+
+package sync
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// changeState is what is saved between runs of
+// --use-change-notify-listing for each source and destination.
+type changeState struct {
+	Src   string    `json:"src"`   // the source of the sync
+	Dst   string    `json:"dst"`   // the destination of the sync
+	Token string    `json:"token"` // the change token of the source when the last sync started
+	Time  time.Time `json:"time"`  // when the token was saved
+}
+
+// changeStatePath returns the path of the state file for syncing
+// fsrc to fdst
+func changeStatePath(fdst, fsrc fs.Fs) string {
+	return stateFilePath("changes", fsrc, fdst)
+}
+
+// loadChangeState reads the state from statePath returning nil if it
+// doesn't exist.
+func loadChangeState(statePath string) (*changeState, error) {
+	state := new(changeState)
+	found, err := readStateFile(statePath, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read change state")
+	}
+	if !found || state.Token == "" {
+		return nil, nil
+	}
+	return state, nil
+}
+
+// saveChangeState writes state to statePath atomically
+func saveChangeState(statePath string, state *changeState) error {
+	err := writeStateFile(statePath, state)
+	if err != nil {
+		return errors.Wrap(err, "failed to write change state")
+	}
+	return nil
+}
+
+// changeListingUnsupported returns why the changes of fsrc can't be
+// used for this sync, or "" if they can.
+func changeListingUnsupported(ctx context.Context, fsrc fs.Fs, DoMove bool) string {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	switch {
+	case fsrc.Features().Changes == nil || fsrc.Features().ChangeToken == nil:
+		return "the source can't list its changes"
+	case DoMove:
+		return "it can't be used when moving"
+	case ci.TrackRenames:
+		return "it can't be used with --track-renames"
+	case ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning:
+		return "it can't be used with --backup-dir, --suffix or --backup-versioning"
+	case ci.CompareDest != "" || ci.CopyDest != "":
+		return "it can't be used with --compare-dest or --copy-dest"
+	case fi.HaveFilesFrom():
+		return "it can't be used with --files-from"
+	case ci.SyncAtomic:
+		return "it can't be used with --sync-atomic"
+	case ci.DiffFormat != "":
+		return "the plan for --diff-format must cover the whole sync"
+	}
+	return ""
+}
+
+// runChangeSync syncs fsrc to fdst using the changes of fsrc since
+// the last sync if possible, otherwise it calls fullSync.
+//
+// The change token is only saved if the sync succeeded without any
+// errors so failed paths are retried next time.
+func runChangeSync(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, fullSync func(dir string) error) error {
+	ci := fs.GetConfig(ctx)
+	if reason := changeListingUnsupported(ctx, fsrc, DoMove); reason != "" {
+		fs.Infof(fsrc, "Not using --use-change-notify-listing as %s", reason)
+		return fullSync("")
+	}
+	statePath := changeStatePath(fdst, fsrc)
+	state, err := loadChangeState(statePath)
+	if err != nil {
+		fs.Errorf(nil, "Doing a full sync: %v", err)
+		state = nil
+	}
+	stats := accounting.Stats(ctx)
+	errorsBefore := stats.GetErrors()
+	var newToken string
+	if state != nil {
+		var paths []string
+		paths, newToken, err = fsrc.Features().Changes(ctx, state.Token)
+		if err == fs.ErrorChangesUnavailable {
+			fs.Infof(fsrc, "Doing a full sync as the changes since the last sync at %v aren't available", state.Time)
+			state = nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to list changes")
+		} else {
+			fs.Infof(fsrc, "Syncing %d changed paths since the last sync at %v", len(paths), state.Time)
+			err = syncChanges(ctx, fdst, fsrc, deleteMode, paths, fullSync)
+		}
+	} else {
+		fs.Infof(fsrc, "Doing a full sync as there is no change token from a previous sync")
+	}
+	if state == nil {
+		// Read the token before the sync so changes made during
+		// the sync are picked up next time.
+		newToken, err = fsrc.Features().ChangeToken(ctx)
+		if err != nil {
+			fs.Errorf(fsrc, "Failed to read change token: %v", err)
+			newToken = ""
+		}
+		err = fullSync("")
+	}
+	if err != nil || newToken == "" {
+		return err
+	}
+	if stats.GetErrors() != errorsBefore {
+		fs.Infof(fsrc, "Not saving the change token as there were errors")
+		return nil
+	}
+	if ci.DryRun {
+		fs.Infof(fsrc, "Not saving the change token as --dry-run is set")
+		return nil
+	}
+	err = saveChangeState(statePath, &changeState{
+		Src:   fs.ConfigString(fsrc),
+		Dst:   fs.ConfigString(fdst),
+		Token: newToken,
+		Time:  time.Now(),
+	})
+	if err != nil {
+		fs.Errorf(nil, "Full sync needed next time: %v", err)
+	}
+	return nil
+}
+
+// cleanChangedPaths cleans paths and removes the duplicates, "" being
+// the root, and sorts them so parents come before their children.
+func cleanChangedPaths(paths []string) []string {
+	seen := make(map[string]struct{}, len(paths))
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = strings.Trim(path.Clean("/"+p), "/")
+		if _, found := seen[p]; found {
+			continue
+		}
+		seen[p] = struct{}{}
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// inDirs returns true if remote is one of dirs or inside one of them
+func inDirs(remote string, dirs []string) bool {
+	for _, dir := range dirs {
+		if dir == "" || remote == dir || strings.HasPrefix(remote, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// dirExists returns true if dir exists in f
+func dirExists(ctx context.Context, f fs.Fs, dir string) (bool, error) {
+	if dir == "" {
+		return true, nil
+	}
+	_, err := f.List(ctx, dir)
+	if err == fs.ErrorDirNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// syncChanges syncs the changed paths of fsrc to fdst.
+//
+// Changed files are copied, files which have gone are deleted from
+// fdst if deleting and changed directories are synced with dirSync.
+func syncChanges(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, paths []string, dirSync func(dir string) error) error {
+	fi := filter.GetConfig(ctx)
+	paths = cleanChangedPaths(paths)
+	var dirs []string
+	for _, remote := range paths {
+		if inDirs(remote, dirs) {
+			continue
+		}
+		if remote == "" {
+			dirs = append(dirs, remote)
+			continue
+		}
+		srcObj, err := fsrc.NewObject(ctx, remote)
+		if err == nil {
+			if !fi.IncludeObject(ctx, srcObj) {
+				fs.Debugf(srcObj, "Excluded")
+				continue
+			}
+			err = operations.CopyFile(ctx, fdst, fsrc, remote, remote)
+			if err != nil {
+				fs.Errorf(srcObj, "Failed to copy changed file: %v", err)
+			}
+			continue
+		}
+		// Backends return different errors for directories so
+		// look for one before deciding the error is real
+		objErr := err
+		isDir, err := dirExists(ctx, fsrc, remote)
+		if err != nil {
+			err = fs.CountError(err)
+			fs.Errorf(remote, "Failed to read changed directory: %v", err)
+			continue
+		}
+		if !isDir && objErr != fs.ErrorObjectNotFound {
+			objErr = fs.CountError(objErr)
+			fs.Errorf(remote, "Failed to read changed file: %v", objErr)
+			continue
+		}
+		if isDir {
+			include, err := fi.IncludeDirectory(ctx, fsrc)(remote)
+			if err != nil {
+				err = fs.CountError(err)
+				fs.Errorf(remote, "Failed to filter changed directory: %v", err)
+			} else if include {
+				dirs = append(dirs, remote)
+			}
+			continue
+		}
+		// The path has gone from the source
+		if deleteMode == fs.DeleteModeOff {
+			continue
+		}
+		dstObj, err := fdst.NewObject(ctx, remote)
+		if err == nil {
+			if !fi.IncludeObject(ctx, dstObj) && !fi.Opt.DeleteExcluded {
+				fs.Debugf(dstObj, "Excluded")
+				continue
+			}
+			err = operations.DeleteFile(ctx, dstObj)
+			if err != nil {
+				fs.Errorf(dstObj, "Failed to delete file removed from the source: %v", err)
+			}
+			continue
+		}
+		isDir, err = dirExists(ctx, fdst, remote)
+		if err != nil {
+			err = fs.CountError(err)
+			fs.Errorf(remote, "Failed to read destination directory: %v", err)
+			continue
+		}
+		if !isDir {
+			continue
+		}
+		// A directory has gone from the source so sync the
+		// nearest parent which still exists to remove it
+		parent := remote
+		for parent != "" {
+			parent = path.Dir(parent)
+			if parent == "." {
+				parent = ""
+			}
+			isDir, err = dirExists(ctx, fsrc, parent)
+			if err != nil || isDir {
+				break
+			}
+		}
+		if err != nil {
+			err = fs.CountError(err)
+			fs.Errorf(remote, "Failed to read source directory: %v", err)
+			continue
+		}
+		dirs = append(dirs, parent)
+	}
+
+	// Sync the directories which aren't inside another one
+	sort.Strings(dirs)
+	var synced []string
+	var firstErr error
+	for _, dir := range dirs {
+		if inDirs(dir, synced) {
+			continue
+		}
+		synced = append(synced, dir)
+		fs.Debugf(dir, "Syncing changed directory")
+		err := dirSync(dir)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
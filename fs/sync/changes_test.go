@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// changeFs wraps an Fs to return the changes it is given
+type changeFs struct {
+	fs.Fs
+	features *fs.Features
+	token    string   // the current change token
+	changes  []string // the changes to return
+	err      error    // the error to return from Changes
+}
+
+func newChangeFs(f fs.Fs) *changeFs {
+	c := &changeFs{Fs: f, token: "1"}
+	features := *f.Features()
+	features.ChangeToken = c.ChangeToken
+	features.Changes = c.Changes
+	c.features = &features
+	return c
+}
+
+func (c *changeFs) Features() *fs.Features {
+	return c.features
+}
+
+func (c *changeFs) ChangeToken(ctx context.Context) (string, error) {
+	return c.token, nil
+}
+
+func (c *changeFs) Changes(ctx context.Context, token string) ([]string, string, error) {
+	if c.err != nil {
+		return nil, "", c.err
+	}
+	return c.changes, c.token, nil
+}
+
+func TestCleanChangedPaths(t *testing.T) {
+	assert.Equal(t, []string{"", "a", "a/b", "c"}, cleanChangedPaths([]string{"c", "/a/b/", "a", "a/./b", "/", "c"}))
+}
+
+func TestSyncChangeListing(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	cacheDir, err := ioutil.TempDir("", "rclone-changes")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(cacheDir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = cacheDir
+	ci.UseChangeListing = true
+	defer func() {
+		config.CacheDir = oldCacheDir
+		ci.UseChangeListing = false
+	}()
+
+	fsrc := newChangeFs(r.Flocal)
+	file1 := r.WriteFile("a/one", "one", t1)
+	file2 := r.WriteFile("b/two", "two", t1)
+
+	// First sync is a full sync which saves the token
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, fsrc, false))
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+	state, err := loadChangeState(changeStatePath(r.Fremote, fsrc))
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, "1", state.Token)
+
+	// Only the changed paths are synced
+	file3 := r.WriteFile("a/three", "three", t1)
+	file4 := r.WriteFile("c/four", "four", t1)
+	require.NoError(t, os.Remove(filepath.Join(r.LocalName, "b", "two")))
+	fsrc.token = "2"
+	fsrc.changes = []string{"a/three", "b/two"}
+	require.NoError(t, Sync(ctx, r.Fremote, fsrc, false))
+	fstest.CheckItems(t, r.Fremote, file1, file3)
+	state, err = loadChangeState(changeStatePath(r.Fremote, fsrc))
+	require.NoError(t, err)
+	assert.Equal(t, "2", state.Token)
+
+	// A changed directory is synced
+	fsrc.changes = []string{"c"}
+	require.NoError(t, Sync(ctx, r.Fremote, fsrc, false))
+	fstest.CheckItems(t, r.Fremote, file1, file3, file4)
+
+	// If the changes aren't available a full sync is done
+	require.NoError(t, os.Remove(filepath.Join(r.LocalName, "a", "one")))
+	fsrc.token = "3"
+	fsrc.err = fs.ErrorChangesUnavailable
+	require.NoError(t, Sync(ctx, r.Fremote, fsrc, false))
+	fstest.CheckItems(t, r.Fremote, file3, file4)
+	state, err = loadChangeState(changeStatePath(r.Fremote, fsrc))
+	require.NoError(t, err)
+	assert.Equal(t, "3", state.Token)
+}
@@ -0,0 +1,163 @@
+// Checkpoints so an interrupted sync can be resumed
+
+package sync
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/atexit"
+)
+
+// checkpointEntry records a source file which has been done
+type checkpointEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// checkpoint records the source files which have been transferred or
+// found to be up to date so they needn't be checked again if the
+// sync is resumed.
+type checkpoint struct {
+	mu     sync.Mutex
+	logger *fs.Logger    // logger from the context of the sync
+	path   string        // file to write the checkpoint to
+	dirty  bool          // set if changed since the last save
+	stop   chan struct{} // close to stop the periodic saves
+	wg     sync.WaitGroup
+	atexit atexit.FnHandle
+
+	// These are saved in the file
+	Src  string                     `json:"src"`
+	Dst  string                     `json:"dst"`
+	Time time.Time                  `json:"time"`
+	Done map[string]checkpointEntry `json:"done"`
+}
+
+// newCheckpoint makes a checkpoint for syncing fsrc to fdst at
+// checkpointPath, loading the previous one if there is one. It logs
+// with the logger in ctx.
+func newCheckpoint(ctx context.Context, fdst, fsrc fs.Fs, checkpointPath string) (*checkpoint, error) {
+	c := &checkpoint{
+		logger: fs.GetLogger(ctx),
+		path:   checkpointPath,
+		Src:    fs.ConfigString(fsrc),
+		Dst:    fs.ConfigString(fdst),
+		Done:   make(map[string]checkpointEntry),
+	}
+	var old checkpoint
+	found, err := readStateFile(checkpointPath, &old)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	if !found {
+		return c, nil
+	}
+	if old.Src != c.Src || old.Dst != c.Dst {
+		return nil, fserrors.FatalError(errors.Errorf("checkpoint %q is for %q to %q not %q to %q", checkpointPath, old.Src, old.Dst, c.Src, c.Dst))
+	}
+	if old.Done != nil {
+		c.Done = old.Done
+	}
+	c.logger.Infof(nil, "Resuming from checkpoint written at %v with %d files done", old.Time, len(c.Done))
+	return c, nil
+}
+
+// isDone returns true if src has been done and hasn't changed since.
+// It may be called on a nil *checkpoint which returns false.
+func (c *checkpoint) isDone(ctx context.Context, src fs.Object) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	entry, found := c.Done[src.Remote()]
+	c.mu.Unlock()
+	return found && entry.Size == src.Size() && entry.ModTime.Equal(src.ModTime(ctx))
+}
+
+// done records that src has been done.  It may be called on a nil
+// *checkpoint which does nothing.
+func (c *checkpoint) done(ctx context.Context, src fs.Object) {
+	if c == nil {
+		return
+	}
+	entry := checkpointEntry{
+		Size:    src.Size(),
+		ModTime: src.ModTime(ctx),
+	}
+	c.mu.Lock()
+	c.Done[src.Remote()] = entry
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// save writes the checkpoint if it has changed
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	c.Time = time.Now()
+	err := writeStateFile(c.path, c)
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	c.dirty = false
+	return nil
+}
+
+// saveAndLog saves the checkpoint logging any errors
+func (c *checkpoint) saveAndLog() {
+	err := c.save()
+	if err != nil {
+		c.logger.Errorf(nil, "%v", err)
+	}
+}
+
+// start writes the checkpoint every interval and if rclone is
+// interrupted.
+func (c *checkpoint) start(interval time.Duration) {
+	c.stop = make(chan struct{})
+	c.atexit = atexit.Register(c.saveAndLog)
+	if interval <= 0 {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.saveAndLog()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish stops writing the checkpoint.  If the sync finished with
+// err == nil the checkpoint is removed as it is no longer needed,
+// otherwise it is written so the sync can be resumed.
+func (c *checkpoint) finish(err error) {
+	close(c.stop)
+	c.wg.Wait()
+	atexit.Unregister(c.atexit)
+	if err != nil {
+		c.saveAndLog()
+		c.logger.Infof(nil, "Resume the sync with --resume %q", c.path)
+		return
+	}
+	removeErr := os.Remove(c.path)
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		c.logger.Errorf(nil, "Failed to remove checkpoint: %v", removeErr)
+	}
+}
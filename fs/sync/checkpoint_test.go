@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	r.WriteFile("one", "one", t1)
+	r.WriteFile("two", "two", t1)
+	one, err := r.Flocal.NewObject(ctx, "one")
+	require.NoError(t, err)
+	two, err := r.Flocal.NewObject(ctx, "two")
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "rclone-checkpoint")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	checkpointPath := filepath.Join(dir, "checkpoint.json")
+
+	var nilCheckpoint *checkpoint
+	assert.False(t, nilCheckpoint.isDone(ctx, one))
+	nilCheckpoint.done(ctx, one)
+
+	c, err := newCheckpoint(ctx, r.Fremote, r.Flocal, checkpointPath)
+	require.NoError(t, err)
+	assert.False(t, c.isDone(ctx, one))
+	c.done(ctx, one)
+	assert.True(t, c.isDone(ctx, one))
+	require.NoError(t, c.save())
+
+	c, err = newCheckpoint(ctx, r.Fremote, r.Flocal, checkpointPath)
+	require.NoError(t, err)
+	assert.True(t, c.isDone(ctx, one))
+	assert.False(t, c.isDone(ctx, two))
+
+	// Changed since the checkpoint
+	r.WriteFile("one", "ONE!", t1)
+	one, err = r.Flocal.NewObject(ctx, "one")
+	require.NoError(t, err)
+	assert.False(t, c.isDone(ctx, one))
+
+	// Different sync
+	_, err = newCheckpoint(ctx, r.Flocal, r.Fremote, checkpointPath)
+	assert.Error(t, err)
+}
+
+func TestSyncResume(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	dir, err := ioutil.TempDir("", "rclone-checkpoint")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	checkpointPath := filepath.Join(dir, "checkpoint.json")
+	ci.Resume = checkpointPath
+	defer func() {
+		ci.Resume = ""
+	}()
+
+	file1 := r.WriteFile("one", "one", t1)
+	file2 := r.WriteFile("two", "two", t1)
+	// Different from the source but the checkpoint says it is done
+	file1dst := r.WriteObject(ctx, "one", "ONE", t2)
+
+	one, err := r.Flocal.NewObject(ctx, "one")
+	require.NoError(t, err)
+	c, err := newCheckpoint(ctx, r.Fremote, r.Flocal, checkpointPath)
+	require.NoError(t, err)
+	c.done(ctx, one)
+	require.NoError(t, c.save())
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetTransfers())
+	fstest.CheckItems(t, r.Flocal, file1, file2)
+	fstest.CheckItems(t, r.Fremote, file1dst, file2)
+
+	// The checkpoint is removed when the sync succeeds
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, os.IsNotExist(err))
+}
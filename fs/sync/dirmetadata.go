@@ -0,0 +1,184 @@
+// Sync the modification times and metadata of directories with --dirs-metadata
+
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// dirMetadata collects the directories synced so their modification
+// times and metadata can be set once their contents are done
+type dirMetadata struct {
+	fsrc        fs.Fs
+	fdst        fs.Fs
+	setModTime  bool // set if the modification times should be set
+	setMetadata bool // set if the metadata should be set
+	mu          sync.Mutex
+	dirs        map[string]fs.Directory // source directories found by remote
+}
+
+// newDirMetadata returns a dirMetadata for syncing fsrc to fdst
+// starting at dir, or nil if --dirs-metadata isn't set or the
+// destination can't set anything.
+func newDirMetadata(ctx context.Context, fdst, fsrc fs.Fs, dir string) *dirMetadata {
+	if !fs.GetConfig(ctx).DirsMetadata {
+		return nil
+	}
+	srcFeatures, dstFeatures := fsrc.Features(), fdst.Features()
+	dm := &dirMetadata{
+		fsrc: fsrc,
+		fdst: fdst,
+		// Directories on remotes which can't have empty ones don't
+		// have real modification times
+		setModTime:  dstFeatures.DirSetModTime != nil && srcFeatures.CanHaveEmptyDirectories,
+		setMetadata: dstFeatures.DirSetMetadata != nil && srcFeatures.DirMetadata != nil,
+		dirs:        make(map[string]fs.Directory),
+	}
+	if !dm.setModTime && !dm.setMetadata {
+		fs.GetLogger(ctx).Errorf(fdst, "Ignoring --dirs-metadata as the destination can't set directory modification times or metadata from the source")
+		return nil
+	}
+	// The top directory isn't listed so add it here
+	dm.dirs[dir] = nil
+	return dm
+}
+
+// add records the directory src in the source
+func (dm *dirMetadata) add(src fs.Directory) {
+	if dm == nil {
+		return
+	}
+	dm.mu.Lock()
+	dm.dirs[src.Remote()] = src
+	dm.mu.Unlock()
+}
+
+// apply sets the modification times and metadata of all the
+// directories found, deepest first so that setting them doesn't
+// change their parents.
+func (dm *dirMetadata) apply(ctx context.Context) error {
+	if dm == nil {
+		return nil
+	}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	remotes := make([]string, 0, len(dm.dirs))
+	for remote := range dm.dirs {
+		remotes = append(remotes, remote)
+	}
+	depth := func(remote string) int {
+		if remote == "" {
+			return 0
+		}
+		return strings.Count(remote, "/") + 1
+	}
+	sort.Slice(remotes, func(i, j int) bool {
+		di, dj := depth(remotes[i]), depth(remotes[j])
+		if di != dj {
+			return di > dj
+		}
+		return remotes[i] < remotes[j]
+	})
+	var errCount int
+	for _, remote := range remotes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := dm.applyDir(ctx, remote, dm.dirs[remote])
+		if err == fs.ErrorDirNotFound {
+			// the directory wasn't created, eg it was empty
+			fs.GetLogger(ctx).Debugf(dirName(remote), "Not setting directory metadata as it isn't in the destination")
+		} else if err != nil {
+			err = fs.CountError(err)
+			fs.GetLogger(ctx).Errorf(dirName(remote), "Failed to set directory metadata: %v", err)
+			errCount++
+		}
+	}
+	if errCount > 0 {
+		return errors.Errorf("failed to set the metadata of %d directories", errCount)
+	}
+	return nil
+}
+
+// dirName returns a name to log remote with
+func dirName(remote string) string {
+	if remote == "" {
+		return "/"
+	}
+	return remote + "/"
+}
+
+// applyDir sets the modification time and metadata of the directory
+// remote from the source directory src, which is nil for the top
+// directory.
+//
+// The modification time is always set as the one read from the
+// destination listing is out of date once the contents are synced.
+func (dm *dirMetadata) applyDir(ctx context.Context, remote string, src fs.Directory) error {
+	var (
+		modTime     time.Time
+		srcMetadata fs.Metadata
+		err         error
+	)
+	if src != nil {
+		modTime = src.ModTime(ctx)
+	}
+	if dm.setMetadata || (dm.setModTime && src == nil && dm.fsrc.Features().DirMetadata != nil) {
+		srcMetadata, err = dm.fsrc.Features().DirMetadata(ctx, remote)
+		if err != nil {
+			return errors.Wrap(err, "failed to read source directory metadata")
+		}
+		if src == nil {
+			// The modification time of the top directory can
+			// only be read from its metadata
+			modTime, _ = time.Parse(time.RFC3339Nano, srcMetadata["mtime"])
+		}
+	}
+	if dm.setMetadata {
+		// the modification time is set separately
+		srcMetadata = withoutMtime(srcMetadata)
+		dstMetadata, err := dm.fdst.Features().DirMetadata(ctx, remote)
+		if err == fs.ErrorDirNotFound {
+			return err
+		}
+		if err != nil || !withoutMtime(dstMetadata).Equal(srcMetadata) {
+			if !operations.SkipDestructive(ctx, dirName(remote), "set directory metadata") {
+				err = dm.fdst.Features().DirSetMetadata(ctx, remote, srcMetadata)
+				if err != nil {
+					return err
+				}
+				fs.GetLogger(ctx).Debugf(dirName(remote), "Set directory metadata")
+			}
+		}
+	}
+	if dm.setModTime && !modTime.IsZero() {
+		if operations.SkipDestructive(ctx, dirName(remote), "set directory modification time") {
+			return nil
+		}
+		err = dm.fdst.Features().DirSetModTime(ctx, remote, modTime)
+		if err != nil {
+			return err
+		}
+		fs.GetLogger(ctx).Debugf(dirName(remote), "Set directory modification time to %v", modTime)
+	}
+	return nil
+}
+
+// withoutMtime returns a copy of metadata without the "mtime"
+func withoutMtime(metadata fs.Metadata) fs.Metadata {
+	out := make(fs.Metadata, len(metadata))
+	for k, v := range metadata {
+		if k != "mtime" {
+			out[k] = v
+		}
+	}
+	return out
+}
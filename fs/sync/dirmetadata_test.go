@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncDirsMetadata(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	if r.Fremote.Features().DirSetModTime == nil {
+		t.Skip("Skipping test as remote can't set directory modification times")
+	}
+	ci.DirsMetadata = true
+
+	r.WriteFile("a/b/file1", "hello", t1)
+	r.WriteFile("a/file2", "hello", t1)
+	dirTimes := map[string]time.Time{
+		"a":   t2,
+		"a/b": t3,
+	}
+	for dir, modTime := range dirTimes {
+		require.NoError(t, r.Flocal.Features().DirSetModTime(ctx, dir, modTime))
+	}
+	if r.Fremote.Features().DirSetMetadata != nil {
+		md, err := r.Flocal.Features().DirMetadata(ctx, "a/b")
+		require.NoError(t, err)
+		md["mode"] = "700"
+		require.NoError(t, r.Flocal.Features().DirSetMetadata(ctx, "a/b", md))
+	}
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+
+	for dir, modTime := range dirTimes {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		entries, err := r.Fremote.List(ctx, parent)
+		require.NoError(t, err)
+		found := false
+		for _, entry := range entries {
+			if d, ok := entry.(fs.Directory); ok && d.Remote() == dir {
+				fstest.AssertTimeEqualWithPrecision(t, dir, modTime, d.ModTime(ctx), r.Fremote.Precision())
+				found = true
+			}
+		}
+		assert.True(t, found, dir)
+	}
+	if r.Fremote.Features().DirMetadata != nil {
+		md, err := r.Fremote.Features().DirMetadata(ctx, "a/b")
+		require.NoError(t, err)
+		assert.Equal(t, "700", md["mode"])
+	}
+}
@@ -0,0 +1,716 @@
+// Distribute a sync across several rclone rcd workers
+
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// Ways of partitioning the work
+const (
+	partitionPrefix = "prefix" // one shard per top level directory
+	partitionSize   = "size"   // top level entries packed into shards of equal size
+)
+
+// DistributeOpt are the options for Distribute
+type DistributeOpt struct {
+	Command      string        // "sync", "copy" or "move"
+	Workers      []string      // URLs of the rc of the workers
+	User         string        // user for basic auth on the workers
+	Pass         string        // password for basic auth on the workers
+	Token        string        // API token for the workers
+	Partition    string        // partitionPrefix or partitionSize
+	Shards       int           // number of shards for partitionSize - number of workers if 0
+	Retries      int           // number of times to retry a failed shard
+	PollInterval time.Duration // how often to poll the workers
+}
+
+// distTask is a single rc call a worker runs as part of a shard
+type distTask struct {
+	Command string
+	Params  rc.Params
+}
+
+// distShard is a part of the work run on a single worker
+type distShard struct {
+	Name     string // what the shard contains
+	Size     int64  // bytes in the source
+	Tasks    []distTask
+	Worker   string // worker which last ran the shard
+	Attempts int
+	Err      error
+	stats    map[string]rc.Params // last stats for each group run
+}
+
+// distWorker is a connection to the rc of a worker
+type distWorker struct {
+	url    string
+	opt    *DistributeOpt
+	client *http.Client
+}
+
+// errWorker is returned when the worker can't be contacted
+type errWorker struct {
+	error
+}
+
+// call calls the rc command on the worker
+func (w *distWorker) call(ctx context.Context, command string, in rc.Params) (out rc.Params, err error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", w.url+command, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, errWorker{err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.opt.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.opt.Token)
+	} else if w.opt.User != "" || w.opt.Pass != "" {
+		req.SetBasicAuth(w.opt.User, w.opt.Pass)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, errWorker{errors.Wrapf(err, "worker %s", w.url)}
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errWorker{errors.Wrapf(err, "worker %s", w.url)}
+	}
+	out = rc.Params{}
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		return nil, errWorker{errors.Wrapf(err, "worker %s: bad response %q", w.url, body)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := out["error"].(string)
+		err = errors.Errorf("worker %s: %s: %s", w.url, command, msg)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			// the worker won't be able to run anything
+			err = errWorker{err}
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// statKeys are the stats summed over the workers
+var statKeys = []string{"bytes", "transfers", "checks", "deletes", "errors"}
+
+// distributor runs the shards on the workers
+type distributor struct {
+	opt     *DistributeOpt
+	stats   *accounting.StatsInfo // stats of the coordinator
+	id      string                // unique for each run to make the worker groups
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*distShard
+	pending int // shards not finished yet
+	alive   int // workers still running
+}
+
+// next returns the next shard to run or nil if there are none left
+func (d *distributor) next() *distShard {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.queue) == 0 && d.pending > 0 {
+		d.cond.Wait()
+	}
+	if d.pending == 0 {
+		return nil
+	}
+	s := d.queue[0]
+	d.queue = d.queue[1:]
+	return s
+}
+
+// done marks the shard as finished with err, retrying it if possible
+func (d *distributor) done(ctx context.Context, s *distShard, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s.Err = err
+	if err != nil && s.Attempts <= d.opt.Retries && ctx.Err() == nil {
+		fs.Errorf(nil, "distribute: shard %q failed on %s - retrying: %v", s.Name, s.Worker, err)
+		d.queue = append(d.queue, s)
+	} else {
+		if err != nil {
+			fs.Errorf(nil, "distribute: shard %q failed on %s: %v", s.Name, s.Worker, err)
+		}
+		d.pending--
+	}
+	d.cond.Broadcast()
+}
+
+// workerDied removes a worker, failing the shards if there are no
+// workers left
+func (d *distributor) workerDied(w *distWorker, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fs.Errorf(nil, "distribute: not using worker %s any more: %v", w.url, err)
+	d.alive--
+	if d.alive == 0 {
+		for _, s := range d.queue {
+			s.Err = errors.New("no workers left")
+			d.pending--
+		}
+		d.queue = nil
+	}
+	d.cond.Broadcast()
+}
+
+// runWorker runs shards on the worker until there are none left
+func (d *distributor) runWorker(ctx context.Context, w *distWorker) {
+	for {
+		s := d.next()
+		if s == nil {
+			return
+		}
+		d.mu.Lock()
+		s.Worker = w.url
+		s.Attempts++
+		d.mu.Unlock()
+		err := d.runShard(ctx, w, s)
+		if _, ok := err.(errWorker); ok {
+			// Put the shard back without counting the attempt
+			d.mu.Lock()
+			s.Attempts--
+			d.queue = append(d.queue, s)
+			d.mu.Unlock()
+			d.workerDied(w, err)
+			return
+		}
+		d.done(ctx, s, err)
+	}
+}
+
+// runShard runs the tasks in the shard one after another on the worker
+func (d *distributor) runShard(ctx context.Context, w *distWorker, s *distShard) error {
+	fs.Infof(nil, "distribute: running shard %q on %s", s.Name, w.url)
+	for i, task := range s.Tasks {
+		group := fmt.Sprintf("distribute/%s/%s/%d", d.id, s.Name, i)
+		in := rc.Params{}
+		for k, v := range task.Params {
+			in[k] = v
+		}
+		in["_async"] = true
+		in["_group"] = group
+		out, err := w.call(ctx, task.Command, in)
+		if err != nil {
+			return err
+		}
+		jobID, err := out.GetInt64("jobid")
+		if err != nil {
+			return errors.Wrapf(err, "worker %s", w.url)
+		}
+		err = d.waitJob(ctx, w, s, jobID, group)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitJob polls the job on the worker until it finishes updating the
+// stats as it goes
+func (d *distributor) waitJob(ctx context.Context, w *distWorker, s *distShard, jobID int64, group string) error {
+	ticker := time.NewTicker(d.opt.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Stop the job on the worker - ctx is cancelled so don't use it
+			stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, _ = w.call(stopCtx, "job/stop", rc.Params{"jobid": jobID})
+			cancel()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		d.updateStats(ctx, w, s, group)
+		status, err := w.call(ctx, "job/status", rc.Params{"jobid": jobID})
+		if err != nil {
+			return err
+		}
+		finished, _ := status.GetBool("finished")
+		if !finished {
+			continue
+		}
+		d.updateStats(ctx, w, s, group)
+		if success, _ := status.GetBool("success"); !success {
+			msg, _ := status.GetString("error")
+			return errors.Errorf("job %d on %s: %s", jobID, w.url, msg)
+		}
+		return nil
+	}
+}
+
+// updateStats reads the stats for the group from the worker and adds
+// the change to the coordinator's stats
+func (d *distributor) updateStats(ctx context.Context, w *distWorker, s *distShard, group string) {
+	stats, err := w.call(ctx, "core/stats", rc.Params{"group": group})
+	if err != nil {
+		fs.Debugf(nil, "distribute: failed to read stats from %s: %v", w.url, err)
+		return
+	}
+	d.mu.Lock()
+	old := s.stats[group]
+	s.stats[group] = stats
+	d.mu.Unlock()
+	delta := func(key string) int64 {
+		newValue, _ := stats.GetInt64(key)
+		oldValue, _ := old.GetInt64(key)
+		return newValue - oldValue
+	}
+	d.stats.Bytes(delta("bytes"))
+	d.stats.Errors(delta("errors"))
+	d.stats.Deletes(delta("deletes"))
+}
+
+// result returns the output for the shard
+func (s *distShard) result() rc.Params {
+	out := rc.Params{
+		"name":     s.Name,
+		"size":     s.Size,
+		"tasks":    len(s.Tasks),
+		"worker":   s.Worker,
+		"attempts": s.Attempts,
+		"error":    "",
+	}
+	for _, key := range statKeys {
+		var total int64
+		for _, stats := range s.stats {
+			value, _ := stats.GetInt64(key)
+			total += value
+		}
+		out[key] = total
+	}
+	if s.Err != nil {
+		out["error"] = s.Err.Error()
+	}
+	return out
+}
+
+// distEntry is a top level entry in the source
+type distEntry struct {
+	name  string
+	isDir bool
+	size  int64
+}
+
+// listTop reads the top level entries of the source with the total
+// size of each
+func listTop(ctx context.Context, f fs.Fs, withSizes bool) (entries []*distEntry, err error) {
+	byName := map[string]*distEntry{}
+	add := func(name string, isDir bool, size int64) {
+		e := byName[name]
+		if e == nil {
+			e = &distEntry{name: name, isDir: isDir}
+			byName[name] = e
+			entries = append(entries, e)
+		}
+		if size > 0 {
+			e.size += size
+		}
+	}
+	if withSizes {
+		err = walk.ListR(ctx, f, "", false, -1, walk.ListObjects, func(dirEntries fs.DirEntries) error {
+			dirEntries.ForObject(func(o fs.Object) {
+				remote := o.Remote()
+				if i := strings.IndexRune(remote, '/'); i >= 0 {
+					add(remote[:i], true, o.Size())
+				} else {
+					add(remote, false, o.Size())
+				}
+			})
+			return nil
+		})
+	} else {
+		var dirEntries fs.DirEntries
+		dirEntries, err = f.List(ctx, "")
+		for _, entry := range dirEntries {
+			_, isDir := entry.(fs.Directory)
+			add(entry.Remote(), isDir, entry.Size())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+// task returns the rc call to run on a worker for the entry
+func (e *distEntry) task(command, srcFs, dstFs string) distTask {
+	if e.isDir {
+		return distTask{
+			Command: "sync/" + command,
+			Params: rc.Params{
+				"srcFs": fspath.JoinRootPath(srcFs, e.name),
+				"dstFs": fspath.JoinRootPath(dstFs, e.name),
+			},
+		}
+	}
+	fileCommand := "operations/copyfile"
+	if command == "move" {
+		fileCommand = "operations/movefile"
+	}
+	return distTask{
+		Command: fileCommand,
+		Params: rc.Params{
+			"srcFs":     srcFs,
+			"srcRemote": e.name,
+			"dstFs":     dstFs,
+			"dstRemote": e.name,
+		},
+	}
+}
+
+// makeShards partitions the entries into shards
+func makeShards(entries []*distEntry, opt *DistributeOpt, srcFs, dstFs string) (shards []*distShard) {
+	switch opt.Partition {
+	case partitionSize:
+		n := opt.Shards
+		if n <= 0 {
+			n = len(opt.Workers)
+		}
+		// Put the biggest entries first into the smallest shard
+		sorted := append([]*distEntry(nil), entries...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].size > sorted[j].size
+		})
+		for i := 0; i < n; i++ {
+			shards = append(shards, &distShard{Name: fmt.Sprintf("bucket%d", i)})
+		}
+		for _, e := range sorted {
+			smallest := shards[0]
+			for _, s := range shards[1:] {
+				if s.Size < smallest.Size {
+					smallest = s
+				}
+			}
+			smallest.Size += e.size
+			smallest.Tasks = append(smallest.Tasks, e.task(opt.Command, srcFs, dstFs))
+		}
+		// Drop empty shards
+		var nonEmpty []*distShard
+		for _, s := range shards {
+			if len(s.Tasks) > 0 {
+				nonEmpty = append(nonEmpty, s)
+			}
+		}
+		shards = nonEmpty
+	default:
+		var files *distShard
+		for _, e := range entries {
+			if e.isDir {
+				shards = append(shards, &distShard{
+					Name:  e.name,
+					Size:  e.size,
+					Tasks: []distTask{e.task(opt.Command, srcFs, dstFs)},
+				})
+				continue
+			}
+			if files == nil {
+				// All the files in the root go in one shard
+				files = &distShard{Name: "/"}
+				shards = append(shards, files)
+			}
+			files.Size += e.size
+			files.Tasks = append(files.Tasks, e.task(opt.Command, srcFs, dstFs))
+		}
+	}
+	for _, s := range shards {
+		s.stats = map[string]rc.Params{}
+	}
+	return shards
+}
+
+// deleteExtraTop deletes the top level entries in the destination
+// which aren't in the source.  The workers sync the contents of the
+// directories but not the root.
+func deleteExtraTop(ctx context.Context, fdst fs.Fs, entries []*distEntry) error {
+	inSrc := map[string]bool{}
+	for _, e := range entries {
+		inSrc[e.name] = true
+	}
+	dstEntries, err := fdst.List(ctx, "")
+	if err == fs.ErrorDirNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range dstEntries {
+		if inSrc[entry.Remote()] {
+			continue
+		}
+		switch x := entry.(type) {
+		case fs.Object:
+			err = operations.DeleteFile(ctx, x)
+		case fs.Directory:
+			err = operations.Purge(ctx, fdst, x.Remote())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Distribute runs the sync, copy or move from srcFs to dstFs on the
+// workers, splitting it into shards.
+//
+// srcFs and dstFs must be remotes the workers can use as well as the
+// coordinator.
+//
+// It returns a summary of the shards and the stats summed over the
+// workers.
+func Distribute(ctx context.Context, srcFs, dstFs string, opt *DistributeOpt) (out rc.Params, err error) {
+	switch opt.Command {
+	case "sync", "copy", "move":
+	default:
+		return nil, errors.Errorf("can't distribute %q - must be sync, copy or move", opt.Command)
+	}
+	switch opt.Partition {
+	case "":
+		opt.Partition = partitionPrefix
+	case partitionPrefix, partitionSize:
+	default:
+		return nil, errors.Errorf("unknown partition %q - must be prefix or size", opt.Partition)
+	}
+	if len(opt.Workers) == 0 {
+		return nil, errors.New("need at least one worker")
+	}
+	if opt.PollInterval <= 0 {
+		opt.PollInterval = time.Second
+	}
+	fsrc, err := fs.NewFs(ctx, srcFs)
+	if err != nil {
+		return nil, err
+	}
+	fdst, err := fs.NewFs(ctx, dstFs)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := listTop(ctx, fsrc, opt.Partition == partitionSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list source")
+	}
+	shards := makeShards(entries, opt, srcFs, dstFs)
+	fs.Infof(nil, "distribute: running %d shards on %d workers", len(shards), len(opt.Workers))
+
+	d := &distributor{
+		opt:     opt,
+		stats:   accounting.Stats(ctx),
+		id:      random.String(8),
+		queue:   append([]*distShard(nil), shards...),
+		pending: len(shards),
+		alive:   len(opt.Workers),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	client := fshttp.NewClient(ctx)
+	var wg sync.WaitGroup
+	for _, workerURL := range opt.Workers {
+		if !strings.HasSuffix(workerURL, "/") {
+			workerURL += "/"
+		}
+		w := &distWorker{url: workerURL, opt: opt, client: client}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runWorker(ctx, w)
+		}()
+	}
+	wg.Wait()
+
+	// Summarise the results
+	var results []rc.Params
+	failed := 0
+	totals := rc.Params{}
+	for _, s := range shards {
+		result := s.result()
+		results = append(results, result)
+		for _, key := range statKeys {
+			total, _ := totals.GetInt64(key)
+			value, _ := result.GetInt64(key)
+			totals[key] = total + value
+		}
+		if s.Err != nil {
+			failed++
+		}
+	}
+	out = rc.Params{"shards": results}
+	for k, v := range totals {
+		out[k] = v
+	}
+	if failed > 0 {
+		return out, errors.Errorf("%d of %d shards failed", failed, len(shards))
+	}
+	if opt.Command == "sync" {
+		err = deleteExtraTop(ctx, fdst, entries)
+		if err != nil {
+			return out, errors.Wrap(err, "failed to delete extra entries in the destination")
+		}
+	}
+	return out, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "sync/distribute",
+		AuthRequired: true,
+		Fn:           rcDistribute,
+		Title:        "Run a sync, copy or move on several rclone workers",
+		Help: `This splits a sync, copy or move into shards and runs them on
+several workers, which are other rclone instances running "rclone rcd".
+Use it to spread a big transfer over more machines than one.
+
+This takes the following parameters
+
+- srcFs - a remote name string e.g. "drive:src" for the source
+- dstFs - a remote name string e.g. "drive:dst" for the destination
+- command - "sync", "copy" or "move" (default "copy")
+- workers - list of URLs of the rc of the workers, e.g. "http://worker1:5572/"
+- partition - how to split the work (default "prefix")
+    - prefix - a shard for each top level directory and one for all the files in the root
+    - size - the top level entries are packed into shards of about the same size
+- shards - number of shards to make with partition=size (default the number of workers)
+- retries - number of times to retry a shard which fails (default 3)
+- user - user name to use with the workers (optional)
+- pass - password to use with the workers (optional)
+- token - API token to use with the workers (optional)
+- pollInterval - how often to poll the workers (default "1s")
+
+The workers must have the same remotes configured as this rclone as
+srcFs and dstFs are passed to them.
+
+Each worker runs one shard at a time as sync/copy, sync/sync or
+sync/move calls for the directories and operations/copyfile or
+operations/movefile for the files in the root.  If a worker can't be
+contacted its shards are run on the other workers.  With
+command=sync the top level entries in dstFs which aren't in srcFs are
+deleted once all the shards have succeeded.
+
+The bytes transferred, errors and deletes on the workers are added to
+the stats of this call so use _async=true and core/stats with the
+group of the job to see the progress.
+
+Results
+
+- shards - array of shards, each with
+    - name - the directory or "/" for the files in the root or "bucketN"
+    - size - size of the shard in bytes (only with partition=size)
+    - tasks - number of rc calls in the shard
+    - worker - URL of the worker which ran it
+    - attempts - number of times it was run
+    - error - the error if it failed
+    - bytes, transfers, checks, deletes, errors - stats from the worker
+- bytes, transfers, checks, deletes, errors - stats summed over the shards
+
+Eg
+
+    rclone rc sync/distribute srcFs=s3:src dstFs=gcs:dst command=sync \
+        workers=http://worker1:5572/,http://worker2:5572/ _async=true
+`,
+	})
+}
+
+// getList gets a list of strings from key which may be a JSON list or
+// a comma separated string
+func getList(in rc.Params, key string) ([]string, error) {
+	value, err := in.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := value.(string); ok && !strings.HasPrefix(s, "[") {
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+	var list []string
+	err = in.GetStruct(key, &list)
+	return list, err
+}
+
+// Run a distributed sync
+func rcDistribute(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	srcFs, err := in.GetString("srcFs")
+	if err != nil {
+		return nil, err
+	}
+	dstFs, err := in.GetString("dstFs")
+	if err != nil {
+		return nil, err
+	}
+	opt := DistributeOpt{
+		Command:      "copy",
+		Retries:      3,
+		PollInterval: time.Second,
+	}
+	opt.Workers, err = getList(in, "workers")
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range []struct {
+		key   string
+		value *string
+	}{
+		{"command", &opt.Command},
+		{"partition", &opt.Partition},
+		{"user", &opt.User},
+		{"pass", &opt.Pass},
+		{"token", &opt.Token},
+	} {
+		value, err := in.GetString(item.key)
+		if rc.NotErrParamNotFound(err) {
+			return nil, err
+		} else if err == nil {
+			*item.value = value
+		}
+	}
+	if shards, err := in.GetInt64("shards"); err == nil {
+		opt.Shards = int(shards)
+	} else if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if retries, err := in.GetInt64("retries"); err == nil {
+		opt.Retries = int(retries)
+	} else if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if interval, err := in.GetString("pollInterval"); err == nil {
+		opt.PollInterval, err = fs.ParseDuration(interval)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad pollInterval")
+		}
+	} else if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	return Distribute(ctx, srcFs, dstFs, &opt)
+}
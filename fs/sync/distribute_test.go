@@ -0,0 +1,129 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWorker makes a server which runs rc calls like rclone rcd
+// counting the calls in calls
+func newTestWorker(t *testing.T, calls *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			in  = rc.Params{}
+			out rc.Params
+			err error
+		)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		call := rc.Calls.Get(strings.Trim(r.URL.Path, "/"))
+		require.NotNil(t, call, r.URL.Path)
+		atomic.AddInt64(calls, 1)
+		if isAsync, _ := in.GetBool("_async"); isAsync {
+			delete(in, "_async")
+			out, err = jobs.StartAsyncCall(call, in)
+		} else {
+			out, err = call.Fn(context.Background(), in)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			out = rc.Params{"error": err.Error()}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(out))
+	}))
+}
+
+func TestRcDistribute(t *testing.T) {
+	for _, partition := range []string{"prefix", "size"} {
+		t.Run(partition, func(t *testing.T) {
+			r, call := rcNewRun(t, "sync/distribute")
+			defer r.Finalise()
+			ctx := context.Background()
+			r.Mkdir(ctx, r.Fremote)
+
+			file1 := r.WriteFile("file1", "file1 contents", t1)
+			file2 := r.WriteFile("dir1/file2", "file2 contents", t2)
+			file3 := r.WriteFile("dir2/sub/file3", "file3 contents", t3)
+			file4 := r.WriteObject(ctx, "dir3/file4", "file4 contents", t1)
+			file5 := r.WriteObject(ctx, "dir1/file5", "file5 contents", t1)
+
+			var calls1, calls2 int64
+			worker1 := newTestWorker(t, &calls1)
+			defer worker1.Close()
+			worker2 := newTestWorker(t, &calls2)
+			defer worker2.Close()
+
+			out, err := call.Fn(ctx, rc.Params{
+				"srcFs":        r.LocalName,
+				"dstFs":        r.FremoteName,
+				"command":      "sync",
+				"partition":    partition,
+				"workers":      worker1.URL + "," + worker2.URL,
+				"pollInterval": "10ms",
+			})
+			require.NoError(t, err)
+			fstest.CheckItems(t, r.Fremote, file1, file2, file3)
+			assert.NotNil(t, file4)
+			assert.NotNil(t, file5)
+
+			assert.True(t, atomic.LoadInt64(&calls1) > 0)
+			assert.True(t, atomic.LoadInt64(&calls2) > 0)
+			shards := out["shards"].([]rc.Params)
+			if partition == "prefix" {
+				require.Equal(t, 3, len(shards))
+				assert.Equal(t, "dir1", shards[0]["name"])
+				assert.Equal(t, "dir2", shards[1]["name"])
+				assert.Equal(t, "/", shards[2]["name"])
+			} else {
+				require.Equal(t, 2, len(shards))
+			}
+			for _, shard := range shards {
+				assert.Equal(t, "", shard["error"])
+				assert.Equal(t, 1, shard["attempts"])
+			}
+			assert.Equal(t, int64(3), out["transfers"])
+			assert.Equal(t, int64(1), out["deletes"])
+		})
+	}
+}
+
+func TestRcDistributeNoWorkers(t *testing.T) {
+	r, call := rcNewRun(t, "sync/distribute")
+	defer r.Finalise()
+	ctx := context.Background()
+	r.Mkdir(ctx, r.Fremote)
+	r.WriteFile("dir1/file1", "file1 contents", t1)
+
+	// A worker which isn't listening
+	worker := newTestWorker(t, new(int64))
+	worker.Close()
+
+	out, err := call.Fn(ctx, rc.Params{
+		"srcFs":        r.LocalName,
+		"dstFs":        r.FremoteName,
+		"workers":      []string{worker.URL},
+		"pollInterval": "10ms",
+	})
+	require.Error(t, err)
+	shards := out["shards"].([]rc.Params)
+	require.Equal(t, 1, len(shards))
+	assert.Equal(t, "no workers left", shards[0]["error"])
+
+	_, err = call.Fn(ctx, rc.Params{
+		"srcFs":   r.LocalName,
+		"dstFs":   r.FremoteName,
+		"workers": worker.URL,
+		"command": "potato",
+	})
+	assert.Error(t, err)
+}
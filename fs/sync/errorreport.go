@@ -0,0 +1,214 @@
+// Reports of the objects which failed written by --error-report
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// Actions which can fail in an error report
+const (
+	FailedCopy   = "copy"   // the file couldn't be copied
+	FailedMove   = "move"   // the file couldn't be moved
+	FailedDelete = "delete" // the file couldn't be deleted from the destination
+)
+
+// Failure is one object which failed in an error report
+type Failure struct {
+	Action    string `json:"action"`              // one of copy, move or delete
+	Path      string `json:"path"`                // path of the object relative to the source and destination
+	Error     string `json:"error"`               // the error
+	ErrorCode string `json:"errorCode,omitempty"` // the error code if known
+}
+
+// ErrorReport lists the objects which failed in a sync, copy or move
+// written by --error-report and read by retry-failed
+type ErrorReport struct {
+	mu       sync.Mutex
+	Src      string    `json:"src"`
+	Dst      string    `json:"dst"`
+	Time     time.Time `json:"time"`
+	Failures []Failure `json:"failures"`
+}
+
+// newErrorReport returns an ErrorReport to record the objects which
+// fail copying fsrc to fdst if --error-report is set, or nil if not.
+func newErrorReport(ctx context.Context, fdst, fsrc fs.Fs) *ErrorReport {
+	if fs.GetConfig(ctx).ErrorReport == "" {
+		return nil
+	}
+	return &ErrorReport{
+		Src:      fs.ConfigString(fsrc),
+		Dst:      fs.ConfigString(fdst),
+		Time:     time.Now(),
+		Failures: []Failure{},
+	}
+}
+
+// add records that action failed on remote with err
+func (r *ErrorReport) add(action string, remote string, err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failures = append(r.Failures, Failure{
+		Action:    action,
+		Path:      remote,
+		Error:     err.Error(),
+		ErrorCode: string(fserrors.ErrorCode(err)),
+	})
+}
+
+// deleteFailed records a failed delete of dst - it has the signature
+// needed by operations.DeleteFilesWithBackupDirFn
+func (r *ErrorReport) deleteFailed(dst fs.Object, err error) {
+	r.add(FailedDelete, dst.Remote(), err)
+}
+
+// write the report to reportPath
+func (r *ErrorReport) write(reportPath string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.SliceStable(r.Failures, func(i, j int) bool {
+		return r.Failures[i].Path < r.Failures[j].Path
+	})
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	err = ioutil.WriteFile(reportPath, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write error report")
+	}
+	if len(r.Failures) > 0 {
+		fs.Logf(nil, "Wrote %d failed objects to %q - use \"rclone retry-failed %s\" to retry them", len(r.Failures), reportPath, reportPath)
+	}
+	return nil
+}
+
+// ReadErrorReport reads an error report written by --error-report
+func ReadErrorReport(reportPath string) (*ErrorReport, error) {
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read error report")
+	}
+	var r ErrorReport
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode error report")
+	}
+	if r.Src == "" || r.Dst == "" {
+		return nil, errors.Errorf("%q isn't an error report", reportPath)
+	}
+	return &r, nil
+}
+
+// RetryFailed reattempts the objects which failed in report copying
+// fsrc to fdst.
+//
+// Only the objects in the report are looked at.  Files which have gone
+// from the source since are skipped, as are deletes of files which
+// have reappeared in the source.  If --error-report is set the objects
+// which fail again are written to it.
+func RetryFailed(ctx context.Context, fdst, fsrc fs.Fs, report *ErrorReport) (err error) {
+	ci := fs.GetConfig(ctx)
+	remaining := newErrorReport(ctx, fdst, fsrc)
+	if remaining != nil {
+		defer func() {
+			writeErr := remaining.write(ci.ErrorReport)
+			if err == nil {
+				err = writeErr
+			}
+		}()
+	}
+	var backupDir fs.Fs
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning {
+		backupDir, err = operations.BackupDir(ctx, fdst, fsrc, "")
+		if err != nil {
+			return err
+		}
+	}
+	var (
+		wg       sync.WaitGroup
+		errCount int32
+		failures = make(chan Failure, ci.Transfers)
+	)
+	wg.Add(ci.Transfers)
+	for i := 0; i < ci.Transfers; i++ {
+		go func() {
+			defer wg.Done()
+			for failure := range failures {
+				err := retryFailure(ctx, fdst, fsrc, backupDir, failure)
+				if err != nil {
+					atomic.AddInt32(&errCount, 1)
+					remaining.add(failure.Action, failure.Path, err)
+				}
+			}
+		}()
+	}
+outer:
+	for _, failure := range report.Failures {
+		select {
+		case <-ctx.Done():
+			break outer
+		case failures <- failure:
+		}
+	}
+	close(failures)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errCount > 0 {
+		return errors.Errorf("failed to retry %d of %d objects", errCount, len(report.Failures))
+	}
+	return nil
+}
+
+// retryFailure reattempts a single failure
+func retryFailure(ctx context.Context, fdst, fsrc fs.Fs, backupDir fs.Fs, failure Failure) error {
+	src, err := fsrc.NewObject(ctx, failure.Path)
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return fs.CountError(err)
+	}
+	switch failure.Action {
+	case FailedCopy, FailedMove:
+		if src == nil {
+			fs.Logf(failure.Path, "Not retrying %s as no longer in the source", failure.Action)
+			return nil
+		}
+		if failure.Action == FailedMove {
+			return operations.MoveFile(ctx, fdst, fsrc, failure.Path, failure.Path)
+		}
+		return operations.CopyFile(ctx, fdst, fsrc, failure.Path, failure.Path)
+	case FailedDelete:
+		if src != nil {
+			fs.Logf(failure.Path, "Not retrying delete as now in the source")
+			return nil
+		}
+		dst, err := fdst.NewObject(ctx, failure.Path)
+		if err == fs.ErrorObjectNotFound {
+			return nil
+		} else if err != nil {
+			return fs.CountError(err)
+		}
+		return operations.DeleteFileWithBackupDir(ctx, dst, backupDir)
+	}
+	return errors.Errorf("unknown action %q in error report", failure.Action)
+}
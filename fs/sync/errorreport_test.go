@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorReport(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	dir, err := ioutil.TempDir("", "rclone-error-report")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	reportPath := filepath.Join(dir, "errors.json")
+
+	// Not enabled
+	var report *ErrorReport
+	report.add(FailedCopy, "potato", errors.New("boom"))
+	require.NoError(t, report.write(reportPath))
+	assert.Nil(t, newErrorReport(ctx, r.Fremote, r.Flocal))
+
+	ci.ErrorReport = reportPath
+	report = newErrorReport(ctx, r.Fremote, r.Flocal)
+	require.NotNil(t, report)
+	report.add(FailedDelete, "b", fserrors.WithCode(errors.New("boom"), fserrors.CodeNotFound))
+	report.add(FailedCopy, "a", errors.New("bang"))
+	report.add(FailedCopy, "c", nil)
+	require.NoError(t, report.write(reportPath))
+
+	got, err := ReadErrorReport(reportPath)
+	require.NoError(t, err)
+	assert.Equal(t, fs.ConfigString(r.Flocal), got.Src)
+	assert.Equal(t, fs.ConfigString(r.Fremote), got.Dst)
+	assert.Equal(t, []Failure{
+		{Action: FailedCopy, Path: "a", Error: "bang", ErrorCode: string(fserrors.CodeUnknown)},
+		{Action: FailedDelete, Path: "b", Error: "boom", ErrorCode: string(fserrors.CodeNotFound)},
+	}, got.Failures)
+
+	_, err = ReadErrorReport(filepath.Join(dir, "potato.json"))
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(reportPath, []byte("{}"), 0600))
+	_, err = ReadErrorReport(reportPath)
+	assert.Error(t, err)
+
+	// A successful sync writes an empty report
+	file1 := r.WriteFile("a", "a", t1)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, file1)
+	got, err = ReadErrorReport(reportPath)
+	require.NoError(t, err)
+	assert.Equal(t, []Failure{}, got.Failures)
+}
+
+func TestRetryFailed(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	dir, err := ioutil.TempDir("", "rclone-error-report")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	reportPath := filepath.Join(dir, "errors.json")
+
+	file1 := r.WriteFile("copy", "copy", t1)
+	file2 := r.WriteBoth(ctx, "keep", "keep", t1)
+	r.WriteObject(ctx, "delete", "delete", t1)
+	file4 := r.WriteObject(ctx, "untouched", "untouched", t1)
+
+	report := &ErrorReport{
+		Src: fs.ConfigString(r.Flocal),
+		Dst: fs.ConfigString(r.Fremote),
+		Failures: []Failure{
+			{Action: FailedCopy, Path: "copy"},
+			{Action: FailedCopy, Path: "gone"},
+			{Action: FailedDelete, Path: "keep"},
+			{Action: FailedDelete, Path: "delete"},
+			{Action: FailedDelete, Path: "already-deleted"},
+		},
+	}
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, RetryFailed(ctx, r.Fremote, r.Flocal, report))
+	fstest.CheckItems(t, r.Fremote, file1, file2, file4)
+	fstest.CheckItems(t, r.Flocal, file1, file2)
+
+	// Failures are written to the report
+	ci.ErrorReport = reportPath
+	report.Failures = []Failure{{Action: "potato", Path: "copy"}}
+	accounting.GlobalStats().ResetCounters()
+	assert.Error(t, RetryFailed(ctx, r.Fremote, r.Flocal, report))
+	got, err := ReadErrorReport(reportPath)
+	require.NoError(t, err)
+	require.Len(t, got.Failures, 1)
+	assert.Equal(t, "copy", got.Failures[0].Path)
+	accounting.GlobalStats().ResetCounters()
+}
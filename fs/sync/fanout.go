@@ -0,0 +1,189 @@
+// Sync one source to several destinations reading it once
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// fanOutDst is one destination of SyncTo
+type fanOutDst struct {
+	f      fs.Fs
+	files  map[string]fs.Object // objects in the destination
+	errors int32                // number of errors syncing to this destination
+}
+
+// list reads the objects in the destination
+func (d *fanOutDst) list(ctx context.Context) error {
+	fi := filter.GetConfig(ctx)
+	ci := fs.GetConfig(ctx)
+	d.files = make(map[string]fs.Object)
+	err := walk.ListR(ctx, d.f, "", fi.Opt.DeleteExcluded, ci.MaxDepth, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(o fs.Object) {
+			d.files[o.Remote()] = o
+		})
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	return err
+}
+
+// SyncTo makes each of fdsts identical to fsrc, modifying the
+// destinations only.
+//
+// The source is listed once and each object which needs transferring
+// is read once and uploaded to all the destinations which need it in
+// parallel.  Errors are tracked per destination, so files are only
+// deleted from the destinations which had no errors.
+func SyncTo(ctx context.Context, fdsts []fs.Fs, fsrc fs.Fs) error {
+	ci := fs.GetConfig(ctx)
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning {
+		return errors.New("can't use --backup-dir, --suffix or --backup-versioning with multiple destinations")
+	}
+	dsts := make([]*fanOutDst, len(fdsts))
+	for i, fdst := range fdsts {
+		if operations.Same(fdst, fsrc) {
+			return errors.Errorf("source and destination %v are the same", fdst)
+		}
+		dsts[i] = &fanOutDst{f: fdst}
+	}
+
+	// List the destinations in parallel
+	var (
+		wg      sync.WaitGroup
+		listErr = make([]error, len(dsts))
+	)
+	for i, d := range dsts {
+		wg.Add(1)
+		go func(i int, d *fanOutDst) {
+			defer wg.Done()
+			listErr[i] = d.list(ctx)
+		}(i, d)
+	}
+	wg.Wait()
+	for i, err := range listErr {
+		if err != nil {
+			return errors.Wrapf(fs.CountError(err), "failed to list destination %v", dsts[i].f)
+		}
+	}
+
+	// List the source transferring what is needed as we go
+	var (
+		srcMu    sync.Mutex
+		srcFiles = make(map[string]struct{})
+		toCopy   = make(chan fs.Object, ci.Transfers)
+	)
+	wg.Add(ci.Transfers)
+	for i := 0; i < ci.Transfers; i++ {
+		go func() {
+			defer wg.Done()
+			for src := range toCopy {
+				syncToObject(ctx, dsts, src)
+			}
+		}()
+	}
+	srcErr := walk.ListR(ctx, fsrc, "", false, ci.MaxDepth, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			src, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			srcMu.Lock()
+			srcFiles[src.Remote()] = struct{}{}
+			srcMu.Unlock()
+			select {
+			case toCopy <- src:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	close(toCopy)
+	wg.Wait()
+	if srcErr != nil {
+		srcErr = errors.Wrap(fs.CountError(srcErr), "failed to list source")
+	}
+
+	// Delete the extra files from each destination without errors
+	var failed []string
+	for _, d := range dsts {
+		if srcErr == nil && (d.errors == 0 || ci.IgnoreErrors) {
+			err := d.deleteExtra(ctx, srcFiles)
+			if err != nil {
+				atomic.AddInt32(&d.errors, 1)
+			}
+		} else {
+			fs.GetLogger(ctx).Errorf(d.f, "%v", fs.ErrorNotDeleting)
+		}
+		if d.errors > 0 {
+			fs.GetLogger(ctx).Errorf(d.f, "Failed to sync with %d errors", d.errors)
+			failed = append(failed, fs.ConfigString(d.f))
+		}
+	}
+	if srcErr != nil {
+		return srcErr
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to sync to %d of %d destinations: %s", len(failed), len(dsts), strings.Join(failed, ", "))
+	}
+	if accounting.Stats(ctx).GetTransfers() == 0 {
+		fs.GetLogger(ctx).Infof(nil, "There was nothing to transfer")
+	}
+	return nil
+}
+
+// syncToObject transfers src to each of dsts which needs it
+func syncToObject(ctx context.Context, dsts []*fanOutDst, src fs.Object) {
+	var (
+		fdsts   []fs.Fs
+		objs    []fs.Object
+		targets []*fanOutDst
+	)
+	tr := accounting.Stats(ctx).NewCheckingTransfer(src)
+	for _, d := range dsts {
+		dst := d.files[src.Remote()]
+		if dst == nil || operations.NeedTransfer(ctx, dst, src) {
+			fdsts = append(fdsts, d.f)
+			objs = append(objs, dst)
+			targets = append(targets, d)
+		}
+	}
+	tr.Done(ctx, nil)
+	if len(targets) == 0 {
+		return
+	}
+	_, errs := operations.CopyFanOut(ctx, fdsts, objs, src)
+	for i, err := range errs {
+		if err != nil {
+			atomic.AddInt32(&targets[i].errors, 1)
+		}
+	}
+}
+
+// deleteExtra deletes the objects in the destination which aren't in
+// srcFiles
+func (d *fanOutDst) deleteExtra(ctx context.Context, srcFiles map[string]struct{}) error {
+	toDelete := make(fs.ObjectsChan, fs.GetConfig(ctx).Transfers)
+	go func() {
+		defer close(toDelete)
+		for remote, dst := range d.files {
+			if _, found := srcFiles[remote]; !found {
+				toDelete <- dst
+			}
+		}
+	}()
+	return operations.DeleteFiles(ctx, toDelete)
+}
@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTo(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	dir, err := ioutil.TempDir("", "rclone-syncto")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	fdst2, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	file2 := r.WriteFile("sub dir/file2", "file2 new contents", t2)
+	r.WriteObject(ctx, "sub dir/file2", "file2 old", t1)
+	r.WriteObject(ctx, "extra", "not in the source", t1)
+
+	accounting.GlobalStats().ResetCounters()
+	err = SyncTo(ctx, []fs.Fs{r.Fremote, fdst2}, r.Flocal)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), accounting.GlobalStats().GetTransfers())
+
+	fstest.CheckItems(t, r.Flocal, file1, file2)
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+	fstest.CheckItems(t, fdst2, file1, file2)
+
+	// Nothing to do the second time
+	accounting.GlobalStats().ResetCounters()
+	err = SyncTo(ctx, []fs.Fs{r.Fremote, fdst2}, r.Flocal)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetTransfers())
+
+	err = SyncTo(ctx, []fs.Fs{r.Fremote, r.Flocal}, r.Flocal)
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+// Sync to write once destinations with --immutable-dest
+
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// immutableDest makes the destination look as though the new versions
+// and tombstones written by --immutable-dest had replaced the files
+// they were written for, so the sync compares against the latest
+// version of each file.
+type immutableDest struct {
+	ctx  context.Context
+	mu   sync.Mutex
+	dead map[string]fs.Object // tombstones of files deleted in the destination
+}
+
+// newImmutableDest returns an immutableDest for the current
+// --immutable-dest-pattern or nil if --immutable-dest isn't set
+func newImmutableDest(ctx context.Context) *immutableDest {
+	if !fs.GetConfig(ctx).ImmutableDest {
+		return nil
+	}
+	return &immutableDest{
+		ctx:  ctx,
+		dead: make(map[string]fs.Object),
+	}
+}
+
+// versionObject is the latest version of a file in the destination
+// presented under the name of the file
+type versionObject struct {
+	fs.Object
+	remote string
+}
+
+// Remote returns the name of the file this is a version of
+func (o *versionObject) Remote() string {
+	return o.remote
+}
+
+// String returns the name of the file this is a version of
+func (o *versionObject) String() string {
+	return o.remote
+}
+
+// UnWrap returns the version
+func (o *versionObject) UnWrap() fs.Object {
+	return o.Object
+}
+
+// transform replaces the files in the destination listing entries
+// with their latest versions, leaving out the ones which have been
+// deleted.
+func (id *immutableDest) transform(entries fs.DirEntries) fs.DirEntries {
+	type latest struct {
+		o         fs.Object
+		t         time.Time
+		tombstone bool
+	}
+	var (
+		out     = make(fs.DirEntries, 0, len(entries))
+		remotes []string
+		files   = make(map[string]*latest)
+	)
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok {
+			out = append(out, entry)
+			continue
+		}
+		remote, t, tombstone := operations.ParseImmutableName(id.ctx, o.Remote())
+		l := files[remote]
+		if l == nil {
+			l = &latest{}
+			files[remote] = l
+			remotes = append(remotes, remote)
+		} else if !t.After(l.t) {
+			continue
+		}
+		l.o, l.t, l.tombstone = o, t, tombstone
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	for _, remote := range remotes {
+		l := files[remote]
+		switch {
+		case l.tombstone:
+			id.dead[remote] = l.o
+		case l.o.Remote() == remote:
+			out = append(out, l.o)
+		default:
+			out = append(out, &versionObject{Object: l.o, remote: remote})
+		}
+	}
+	return out
+}
+
+// deleted returns the tombstone of remote if the file was deleted in
+// the destination or nil if it wasn't
+func (id *immutableDest) deleted(remote string) fs.Object {
+	if id == nil {
+		return nil
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	return id.dead[remote]
+}
+
+// check the compatibility of --immutable-dest with the other flags
+func (id *immutableDest) check(ci *fs.ConfigInfo) error {
+	if id == nil {
+		return nil
+	}
+	if err := operations.CheckImmutableDestPattern(ci.ImmutableDestPattern); err != nil {
+		return err
+	}
+	switch {
+	case ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning:
+		return errors.New("can't use --immutable-dest with --backup-dir, --suffix or --backup-versioning")
+	case ci.SyncAtomic:
+		return errors.New("can't use --immutable-dest with --sync-atomic")
+	case ci.Immutable:
+		return errors.New("can't use --immutable-dest with --immutable")
+	}
+	return nil
+}
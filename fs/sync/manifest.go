@@ -0,0 +1,145 @@
+// Verify the files in a --files-from-manifest
+
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/fserrors"
+)
+
+// manifestCheck checks the files synced against the sizes and hashes
+// in the manifests read by --files-from-manifest
+type manifestCheck struct {
+	files map[string]*filter.ManifestFile
+	mu    sync.Mutex
+	seen  map[string]struct{} // source files found
+}
+
+// newManifestCheck returns a manifestCheck for the manifest in fi or
+// nil if there isn't one
+func newManifestCheck(fi *filter.Filter) *manifestCheck {
+	if !fi.HaveManifest() {
+		return nil
+	}
+	return &manifestCheck{
+		files: fi.Manifest(),
+		seen:  make(map[string]struct{}),
+	}
+}
+
+// see records the source object o as found
+func (mc *manifestCheck) see(o fs.Object) {
+	if mc == nil {
+		return
+	}
+	mc.mu.Lock()
+	mc.seen[o.Remote()] = struct{}{}
+	mc.mu.Unlock()
+}
+
+// mismatch returns an error for o not matching the manifest
+func mismatch(format string, args ...interface{}) error {
+	err := errors.Errorf("doesn't match manifest: "+format, args...)
+	return fserrors.NoRetryError(fserrors.WithCode(err, fserrors.CodeChecksum))
+}
+
+// check checks o against the manifest returning the number of hashes
+// checked
+func (mc *manifestCheck) check(ctx context.Context, o fs.Object) (checked int, err error) {
+	mf := mc.files[o.Remote()]
+	if mf == nil {
+		return 0, nil
+	}
+	if mf.Size != nil && o.Size() >= 0 && o.Size() != *mf.Size {
+		return 0, mismatch("size is %d but should be %d", o.Size(), *mf.Size)
+	}
+	hashes := o.Fs().Hashes()
+	for ht, want := range mf.Sums() {
+		if !hashes.Contains(ht) {
+			continue
+		}
+		got, err := o.Hash(ctx, ht)
+		if err != nil {
+			return checked, errors.Wrapf(err, "failed to read %v hash", ht)
+		}
+		if got == "" {
+			continue
+		}
+		checked++
+		if got != want {
+			return checked, mismatch("%v is %q but should be %q", ht, got, want)
+		}
+	}
+	return checked, nil
+}
+
+// checkSrc checks the source object o matches the manifest before it
+// is transferred
+func (mc *manifestCheck) checkSrc(ctx context.Context, o fs.Object) error {
+	if mc == nil {
+		return nil
+	}
+	checked, err := mc.check(ctx, o)
+	if err == nil && checked == 0 && len(mc.files[o.Remote()].Sums()) > 0 {
+		err = fserrors.NoRetryError(errors.Errorf("can't verify against manifest: %v has none of its hashes", o.Fs()))
+	}
+	if err != nil {
+		err = fs.CountError(err)
+		fs.GetLogger(ctx).Errorf(o, "Not transferring: %v", err)
+	}
+	return err
+}
+
+// checkDst checks the destination object o matches the manifest
+// after it has been transferred or found to be up to date.
+//
+// If the destination doesn't support the manifest's hashes then only
+// the size is checked here, but the source was checked and the
+// transfer compares the hashes of the source and destination when
+// they share one.
+func (mc *manifestCheck) checkDst(ctx context.Context, o fs.Object) error {
+	if mc == nil || o == nil {
+		return nil
+	}
+	_, err := mc.check(ctx, o)
+	if err != nil {
+		err = fs.CountError(err)
+		fs.GetLogger(ctx).Errorf(o, "Destination %v", err)
+	}
+	return err
+}
+
+// checkMissing returns an error if any files in the manifest under
+// dir weren't found in the source
+func (mc *manifestCheck) checkMissing(ctx context.Context, dir string) error {
+	if mc == nil {
+		return nil
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var missing []string
+	for remote := range mc.files {
+		if dir != "" && !strings.HasPrefix(remote, dir+"/") {
+			continue
+		}
+		if _, found := mc.seen[remote]; !found {
+			missing = append(missing, remote)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	for _, remote := range missing {
+		err := fs.CountError(errors.New("in manifest but not found in source"))
+		fs.GetLogger(ctx).Errorf(remote, "%v", err)
+	}
+	return fserrors.NoRetryError(errors.Errorf("%d files in the manifest weren't found in the source", len(missing)))
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeManifestFilter returns ctx with a filter reading manifest
+func makeManifestFilter(ctx context.Context, t *testing.T, manifest string) (context.Context, func()) {
+	dir, err := ioutil.TempDir("", "rclone-manifest")
+	require.NoError(t, err)
+	manifestPath := filepath.Join(dir, "manifest.json")
+	require.NoError(t, ioutil.WriteFile(manifestPath, []byte(manifest), 0600))
+	opt := filter.DefaultOpt
+	opt.FilesFromManifest = []string{manifestPath}
+	fi, err := filter.NewFilter(&opt)
+	require.NoError(t, err)
+	return filter.ReplaceConfig(ctx, fi), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestCopyWithFilesFromManifest(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("potato", "hello world", t1)
+	file2 := r.WriteFile("dir/potato2", "hello world", t1)
+	file3 := r.WriteFile("not in manifest", "hello world", t1)
+
+	ctx, cleanup := makeManifestFilter(ctx, t, `{"files": [
+		{"path": "potato", "size": 11, "hashes": {"md5": "5eb63bbbe01eeed093cb22bb8f5acdc3"}},
+		{"path": "dir/potato2", "size": 11}
+	]}`)
+	defer cleanup()
+
+	err := CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+
+	fstest.CheckItems(t, r.Flocal, file1, file2, file3)
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+}
+
+func TestCopyWithFilesFromManifestMismatch(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	defer accounting.GlobalStats().ResetCounters()
+	file1 := r.WriteFile("potato", "hello world", t1)
+	file2 := r.WriteFile("wrong size", "hello world", t1)
+	file3 := r.WriteFile("wrong hash", "hello world", t1)
+
+	ctx, cleanup := makeManifestFilter(ctx, t, `{"files": [
+		{"path": "potato", "size": 11, "hashes": {"md5": "5eb63bbbe01eeed093cb22bb8f5acdc3"}},
+		{"path": "wrong size", "size": 12},
+		{"path": "wrong hash", "hashes": {"md5": "00000000000000000000000000000000"}},
+		{"path": "missing", "size": 1}
+	]}`)
+	defer cleanup()
+
+	accounting.GlobalStats().ResetCounters()
+	err := CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	assert.True(t, fserrors.IsNoRetryError(err))
+	assert.Equal(t, int64(3), accounting.GlobalStats().GetErrors())
+
+	// only the file which matched is copied
+	fstest.CheckItems(t, r.Flocal, file1, file2, file3)
+	fstest.CheckItems(t, r.Fremote, file1)
+}
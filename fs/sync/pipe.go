@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 	"math/bits"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -10,6 +11,7 @@ import (
 	"github.com/aalpar/deheap"
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/fserrors"
 )
 
@@ -30,7 +32,7 @@ type pipe struct {
 	fraction  int
 }
 
-func newPipe(orderBy string, stats func(items int, totalSize int64), maxBacklog int) (*pipe, error) {
+func newPipe(orderBy string, orderByInclude []*regexp.Regexp, stats func(items int, totalSize int64), maxBacklog int) (*pipe, error) {
 	if maxBacklog < 0 {
 		maxBacklog = (1 << (bits.UintSize - 1)) - 1 // largest positive int
 	}
@@ -38,6 +40,12 @@ func newPipe(orderBy string, stats func(items int, totalSize int64), maxBacklog
 	if err != nil {
 		return nil, fserrors.FatalError(err)
 	}
+	if len(orderByInclude) > 0 {
+		if fraction >= 0 {
+			return nil, fserrors.FatalError(errors.New("can't use --order-by-include with a mixed --order-by"))
+		}
+		less = newPriorityLess(orderByInclude, less)
+	}
 	p := &pipe{
 		c:        make(chan struct{}, maxBacklog),
 		stats:    stats,
@@ -233,3 +241,40 @@ func newLess(orderBy string) (less lessFn, fraction int, err error) {
 	}
 	return less, fraction, nil
 }
+
+// newPriorityLess returns a less function which puts the items
+// matching the first of the include regexps first, then the items
+// matching the second and so on, then all the others.  Items with the
+// same priority are compared with less which may be nil.
+func newPriorityLess(include []*regexp.Regexp, less lessFn) lessFn {
+	priority := func(pair fs.ObjectPair) int {
+		remote := pair.Src.Remote()
+		for i, re := range include {
+			if re.MatchString(remote) {
+				return i
+			}
+		}
+		return len(include)
+	}
+	return func(a, b fs.ObjectPair) bool {
+		pa, pb := priority(a), priority(b)
+		if pa != pb || less == nil {
+			return pa < pb
+		}
+		return less(a, b)
+	}
+}
+
+// parseOrderByInclude compiles the globs in --order-by-include
+func parseOrderByInclude(ctx context.Context) (include []*regexp.Regexp, err error) {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	for _, glob := range ci.OrderByInclude {
+		re, err := filter.GlobToRegexp(glob, fi.Opt.IgnoreCase)
+		if err != nil {
+			return nil, fserrors.FatalError(errors.Wrapf(err, "bad --order-by-include %q", glob))
+		}
+		include = append(include, re)
+	}
+	return include, nil
+}
@@ -24,7 +24,7 @@ func TestPipe(t *testing.T) {
 	}
 
 	// Make a new pipe
-	p, err := newPipe("", stats, 10)
+	p, err := newPipe("", nil, stats, 10)
 	require.NoError(t, err)
 
 	checkStats := func(expectedN int, expectedSize int64) {
@@ -66,7 +66,7 @@ func TestPipe(t *testing.T) {
 	assert.Panics(t, func() { p.Put(ctx, pair1) })
 
 	// Make a new pipe
-	p, err = newPipe("", stats, 10)
+	p, err = newPipe("", nil, stats, 10)
 	require.NoError(t, err)
 	ctx2, cancel := context.WithCancel(ctx)
 
@@ -93,7 +93,7 @@ func TestPipeConcurrent(t *testing.T) {
 	stats := func(n int, size int64) {}
 
 	// Make a new pipe
-	p, err := newPipe("", stats, 10)
+	p, err := newPipe("", nil, stats, 10)
 	require.NoError(t, err)
 
 	var wg sync.WaitGroup
@@ -159,7 +159,7 @@ func TestPipeOrderBy(t *testing.T) {
 		{"size,mixed,51", true, true, 75},
 	} {
 		t.Run(test.orderBy, func(t *testing.T) {
-			p, err := newPipe(test.orderBy, stats, 10)
+			p, err := newPipe(test.orderBy, nil, stats, 10)
 			require.NoError(t, err)
 
 			readAndCheck := func(swapped bool) {
@@ -276,3 +276,62 @@ func TestNewLess(t *testing.T) {
 	}
 
 }
+
+func TestPipeOrderByInclude(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	stats := func(n int, size int64) {}
+	newPair := func(remote string, size int) fs.ObjectPair {
+		return fs.ObjectPair{Src: mockobject.New(remote).WithContent(make([]byte, size), mockobject.SeekModeNone)}
+	}
+
+	ci.OrderByInclude = []string{"*.db", "/top/**"}
+	include, err := parseOrderByInclude(ctx)
+	require.NoError(t, err)
+	require.Len(t, include, 2)
+
+	_, err = newPipe("size,mixed", include, stats, 10)
+	require.Error(t, err)
+
+	for _, test := range []struct {
+		orderBy string
+		want    []string
+	}{
+		{"", nil},
+		{"size", []string{"a.db", "dir/b.db", "top/small", "top/big", "small", "other"}},
+		{"size,desc", []string{"dir/b.db", "a.db", "top/big", "top/small", "other", "small"}},
+	} {
+		t.Run(test.orderBy, func(t *testing.T) {
+			p, err := newPipe(test.orderBy, include, stats, 10)
+			require.NoError(t, err)
+			for _, pair := range []fs.ObjectPair{
+				newPair("other", 5),
+				newPair("top/big", 4),
+				newPair("small", 1),
+				newPair("dir/b.db", 3),
+				newPair("top/small", 2),
+				newPair("a.db", 1),
+			} {
+				require.True(t, p.Put(ctx, pair))
+			}
+			var got []string
+			for i := 0; i < 6; i++ {
+				pair, ok := p.Get(ctx)
+				require.True(t, ok)
+				got = append(got, pair.Src.Remote())
+			}
+			if test.orderBy == "" {
+				// Only the priorities are ordered
+				assert.ElementsMatch(t, []string{"a.db", "dir/b.db"}, got[:2])
+				assert.ElementsMatch(t, []string{"top/big", "top/small"}, got[2:4])
+				assert.ElementsMatch(t, []string{"other", "small"}, got[4:])
+			} else {
+				assert.Equal(t, test.want, got)
+			}
+		})
+	}
+
+	ci.OrderByInclude = []string{"{a"}
+	_, err = parseOrderByInclude(ctx)
+	assert.Error(t, err)
+}
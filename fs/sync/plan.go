@@ -0,0 +1,281 @@
+// Plans of the changes a sync would make
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// planOutput is where the plan is written
+var planOutput io.Writer = os.Stdout
+
+// Actions in a plan
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+	planRename = "rename"
+)
+
+// planAction is one change in a plan
+type planAction struct {
+	Action  string    `json:"action"`            // one of create, update, delete or rename
+	Path    string    `json:"path"`              // path of the file in the destination
+	NewPath string    `json:"newPath,omitempty"` // path the file is renamed to
+	Reason  string    `json:"reason,omitempty"`  // why the file is changed
+	Size    int64     `json:"size"`              // size of the source for create and update, else the destination
+	ModTime time.Time `json:"modTime"`           // modification time of the source for create and update
+}
+
+// plan is the changes a sync would make, recorded by --dry-run
+// --diff-format and carried out by --apply-plan
+type plan struct {
+	mu      sync.Mutex
+	format  string       // format to write it in
+	Src     string       `json:"src"`
+	Dst     string       `json:"dst"`
+	Time    time.Time    `json:"time"`
+	Actions []planAction `json:"actions"`
+}
+
+// newPlan returns a plan to record the changes made syncing fsrc to
+// fdst if --diff-format is set, or nil if not.
+func newPlan(ctx context.Context, fdst, fsrc fs.Fs) (*plan, error) {
+	ci := fs.GetConfig(ctx)
+	switch ci.DiffFormat {
+	case "":
+		return nil, nil
+	case "text", "json":
+	default:
+		return nil, fserrors.FatalError(errors.Errorf("unknown --diff-format %q: use text or json", ci.DiffFormat))
+	}
+	if !ci.DryRun {
+		return nil, fserrors.FatalError(errors.New("--diff-format needs --dry-run"))
+	}
+	return &plan{
+		format: ci.DiffFormat,
+		Src:    fs.ConfigString(fsrc),
+		Dst:    fs.ConfigString(fdst),
+		Time:   time.Now(),
+	}, nil
+}
+
+// add records action.  It may be called on a nil *plan which does
+// nothing.
+func (p *plan) add(action planAction) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Actions = append(p.Actions, action)
+	p.mu.Unlock()
+}
+
+// transfer records that src will be copied over dst which may be nil
+func (p *plan) transfer(ctx context.Context, dst, src fs.Object) {
+	if p == nil {
+		return
+	}
+	action := planCreate
+	if dst != nil {
+		action = planUpdate
+	}
+	p.add(planAction{
+		Action:  action,
+		Path:    src.Remote(),
+		Reason:  operations.TransferReason(ctx, dst, src),
+		Size:    src.Size(),
+		ModTime: src.ModTime(ctx),
+	})
+}
+
+// delete records that dst will be deleted
+func (p *plan) delete(dst fs.Object) {
+	p.add(planAction{
+		Action: planDelete,
+		Path:   dst.Remote(),
+		Reason: "not in source",
+		Size:   dst.Size(),
+	})
+}
+
+// rename records that dst will be renamed to newPath
+func (p *plan) rename(dst fs.Object, newPath string) {
+	p.add(planAction{
+		Action:  planRename,
+		Path:    dst.Remote(),
+		NewPath: newPath,
+		Reason:  "renamed in source",
+		Size:    dst.Size(),
+	})
+}
+
+// write the plan to out in its format with the actions sorted by path
+func (p *plan) write(out io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sort.SliceStable(p.Actions, func(i, j int) bool {
+		return p.Actions[i].Path < p.Actions[j].Path
+	})
+	if p.format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+	_, err := fmt.Fprintf(out, "--- %s\n+++ %s\n", p.Dst, p.Src)
+	if err != nil {
+		return err
+	}
+	for _, action := range p.Actions {
+		switch action.Action {
+		case planCreate:
+			_, err = fmt.Fprintf(out, "+ %s (%s, %v)\n", action.Path, action.Reason, fs.SizeSuffix(action.Size))
+		case planUpdate:
+			_, err = fmt.Fprintf(out, "~ %s (%s, %v)\n", action.Path, action.Reason, fs.SizeSuffix(action.Size))
+		case planDelete:
+			_, err = fmt.Fprintf(out, "- %s\n", action.Path)
+		case planRename:
+			_, err = fmt.Fprintf(out, "> %s -> %s\n", action.Path, action.NewPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPlan makes exactly the changes in the plan read from planPath
+// to fdst.
+//
+// The renames are done first, then the files are copied and finally
+// the deletions are done.  Files which have changed in the source
+// since the plan was made are skipped with an error.
+func applyPlan(ctx context.Context, fdst, fsrc fs.Fs, planPath string) error {
+	ci := fs.GetConfig(ctx)
+	p := new(plan)
+	found, err := readStateFile(planPath, p)
+	if err != nil {
+		return fserrors.FatalError(errors.Wrap(err, "failed to read plan"))
+	}
+	if !found {
+		return fserrors.FatalError(errors.Errorf("plan %q not found", planPath))
+	}
+	if p.Src != fs.ConfigString(fsrc) || p.Dst != fs.ConfigString(fdst) {
+		return fserrors.FatalError(errors.Errorf("plan is for %q to %q not %q to %q", p.Src, p.Dst, fs.ConfigString(fsrc), fs.ConfigString(fdst)))
+	}
+	var backupDir fs.Fs
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning {
+		backupDir, err = operations.BackupDir(ctx, fdst, fsrc, "")
+		if err != nil {
+			return err
+		}
+	}
+	fs.Infof(fdst, "Applying plan with %d changes made at %v", len(p.Actions), p.Time)
+	var (
+		errMu    sync.Mutex
+		firstErr error
+		errCount int
+	)
+	processError := func(err error) {
+		if err == nil {
+			return
+		}
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errCount++
+		errMu.Unlock()
+	}
+	modifyWindow := fs.GetModifyWindow(ctx, fsrc, fdst)
+	apply := func(action planAction) error {
+		switch action.Action {
+		case planRename:
+			dst, err := fdst.NewObject(ctx, action.Path)
+			if err != nil {
+				return errors.Wrapf(err, "can't rename %q", action.Path)
+			}
+			overwritten, _ := fdst.NewObject(ctx, action.NewPath)
+			_, err = operations.Move(ctx, fdst, overwritten, action.NewPath, dst)
+			return err
+		case planCreate, planUpdate:
+			src, err := fsrc.NewObject(ctx, action.Path)
+			if err != nil {
+				return errors.Wrapf(err, "can't copy %q", action.Path)
+			}
+			dt := src.ModTime(ctx).Sub(action.ModTime)
+			if src.Size() != action.Size || (modifyWindow != fs.ModTimeNotSupported && (dt >= modifyWindow || dt <= -modifyWindow)) {
+				err = errors.New("not copying as the source has changed since the plan was made")
+				fs.Errorf(src, "%v", err)
+				return err
+			}
+			dst, err := fdst.NewObject(ctx, action.Path)
+			if err == fs.ErrorObjectNotFound {
+				dst = nil
+			} else if err != nil {
+				return errors.Wrapf(err, "can't copy %q", action.Path)
+			}
+			if dst != nil && backupDir != nil {
+				err = operations.MoveBackupDir(ctx, backupDir, dst)
+				if err != nil {
+					return err
+				}
+				dst = nil
+			}
+			_, err = operations.Copy(ctx, fdst, dst, action.Path, src)
+			return err
+		case planDelete:
+			dst, err := fdst.NewObject(ctx, action.Path)
+			if err == fs.ErrorObjectNotFound {
+				fs.Debugf(fdst, "Not deleting %q as it has gone already", action.Path)
+				return nil
+			} else if err != nil {
+				return errors.Wrapf(err, "can't delete %q", action.Path)
+			}
+			return operations.DeleteFileWithBackupDir(ctx, dst, backupDir)
+		}
+		return errors.Errorf("unknown action %q in plan", action.Action)
+	}
+	// run the actions of kind using --transfers workers
+	run := func(kinds ...string) {
+		in := make(chan planAction)
+		var wg sync.WaitGroup
+		for i := 0; i < ci.Transfers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for action := range in {
+					processError(fs.CountError(apply(action)))
+				}
+			}()
+		}
+		for _, action := range p.Actions {
+			for _, kind := range kinds {
+				if action.Action == kind && ctx.Err() == nil {
+					in <- action
+				}
+			}
+		}
+		close(in)
+		wg.Wait()
+	}
+	run(planRename)
+	run(planCreate, planUpdate)
+	run(planDelete)
+	if errCount > 1 {
+		return errors.Wrapf(firstErr, "applying plan failed with %d errors: first error", errCount)
+	}
+	return firstErr
+}
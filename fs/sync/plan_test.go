@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setPlanOutput captures the plan written to a buffer
+func setPlanOutput() (buf *bytes.Buffer, restore func()) {
+	buf = new(bytes.Buffer)
+	oldPlanOutput := planOutput
+	planOutput = buf
+	return buf, func() {
+		planOutput = oldPlanOutput
+	}
+}
+
+func TestSyncDiffFormat(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	buf, restore := setPlanOutput()
+	defer restore()
+
+	r.WriteFile("new", "new", t1)
+	r.WriteFile("changed", "changed", t2)
+	file1 := r.WriteObject(ctx, "changed", "old", t1)
+	file2 := r.WriteObject(ctx, "deleted", "deleted", t1)
+	file3 := r.WriteBoth(ctx, "same", "same", t1)
+
+	// Needs --dry-run
+	ci.DiffFormat = "text"
+	accounting.GlobalStats().ResetCounters()
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+
+	ci.DryRun = true
+	ci.DiffFormat = "potato"
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+
+	ci.DiffFormat = "text"
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, "--- "+fs.ConfigString(r.Fremote)+"\n+++ "+fs.ConfigString(r.Flocal)+"\n"+
+		"~ changed (size, 7)\n"+
+		"- deleted\n"+
+		"+ new (new, 3)\n", buf.String())
+
+	buf.Reset()
+	ci.DiffFormat = "json"
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	var p plan
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &p))
+	assert.Equal(t, fs.ConfigString(r.Flocal), p.Src)
+	assert.Equal(t, fs.ConfigString(r.Fremote), p.Dst)
+	require.Len(t, p.Actions, 3)
+	assert.Equal(t, planAction{Action: planUpdate, Path: "changed", Reason: "size", Size: 7, ModTime: p.Actions[0].ModTime}, p.Actions[0])
+	assert.Equal(t, planAction{Action: planDelete, Path: "deleted", Reason: "not in source", Size: 7}, p.Actions[1])
+	assert.Equal(t, planAction{Action: planCreate, Path: "new", Reason: "new", Size: 3, ModTime: p.Actions[2].ModTime}, p.Actions[2])
+
+	// Nothing was changed
+	fstest.CheckItems(t, r.Fremote, file1, file2, file3)
+}
+
+func TestSyncApplyPlan(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	buf, restore := setPlanOutput()
+	defer restore()
+	dir, err := ioutil.TempDir("", "rclone-plan")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	planPath := filepath.Join(dir, "plan.json")
+
+	file1 := r.WriteFile("new", "new", t1)
+	file2 := r.WriteFile("changed", "changed", t2)
+	r.WriteObject(ctx, "changed", "old", t1)
+	r.WriteObject(ctx, "deleted", "deleted", t1)
+	file3 := r.WriteBoth(ctx, "same", "same", t1)
+
+	ci.DryRun = true
+	ci.DiffFormat = "json"
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	require.NoError(t, ioutil.WriteFile(planPath, buf.Bytes(), 0600))
+
+	// A file added after the plan was made isn't copied
+	r.WriteFile("later", "later", t1)
+
+	ci.DryRun = false
+	ci.DiffFormat = ""
+	ci.ApplyPlan = planPath
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, file1, file2, file3)
+
+	// The plan must be for the same remotes
+	assert.Error(t, Sync(ctx, r.Flocal, r.Fremote, false))
+
+	// The plan must exist
+	ci.ApplyPlan = filepath.Join(dir, "potato.json")
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+}
+
+func TestSyncApplyPlanSourceChanged(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	buf, restore := setPlanOutput()
+	defer restore()
+	defer accounting.GlobalStats().ResetCounters()
+	dir, err := ioutil.TempDir("", "rclone-plan")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	planPath := filepath.Join(dir, "plan.json")
+
+	r.WriteFile("new", "new", t1)
+
+	ci.DryRun = true
+	ci.DiffFormat = "json"
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	require.NoError(t, ioutil.WriteFile(planPath, buf.Bytes(), 0600))
+
+	r.WriteFile("new", "newer", t2)
+
+	ci.DryRun = false
+	ci.DiffFormat = ""
+	ci.ApplyPlan = planPath
+	accounting.GlobalStats().ResetCounters()
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote)
+}
@@ -16,6 +16,7 @@ func init() {
 		rc.Add(rc.Call{
 			Path:         "sync/" + name,
 			AuthRequired: true,
+			Resumable:    true,
 			Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
 				return rcSyncCopyMove(ctx, in, name)
 			},
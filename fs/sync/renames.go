@@ -0,0 +1,206 @@
+// Fallback strategies for --track-renames
+
+package sync
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// inodeState is the state kept between syncs for the inode strategy
+type inodeState struct {
+	Inodes map[string]string `json:"inodes"` // src paths by inode
+}
+
+// inodeStatePath returns the path of the inode state file
+func (s *syncCopyMove) inodeStatePath() string {
+	return stateFilePath("track-renames", s.fsrc, s.fdst)
+}
+
+// loadInodes reads where the source files were at the last sync and
+// starts recording where they are now
+func (s *syncCopyMove) loadInodes() {
+	var state inodeState
+	found, err := readStateFile(s.inodeStatePath(), &state)
+	if err != nil {
+		fs.GetLogger(s.ctx).Errorf(s.fdst, "Ignoring inodes from the last sync for --track-renames: %v", err)
+	} else if !found {
+		fs.GetLogger(s.ctx).Infof(s.fdst, "No inodes from the last sync for --track-renames - they will be saved for next time")
+	}
+	s.prevInodes = state.Inodes
+	s.inodes = make(map[string]string)
+}
+
+// saveInodes writes where the source files are for the next sync
+func (s *syncCopyMove) saveInodes() {
+	if s.ci.DryRun {
+		return
+	}
+	err := writeStateFile(s.inodeStatePath(), inodeState{Inodes: s.inodes})
+	if err != nil {
+		fs.GetLogger(s.ctx).Errorf(s.fdst, "Failed to save inodes for --track-renames: %v", err)
+	}
+}
+
+// inodeOf returns the inode of o or "" if not known
+func inodeOf(o fs.Object) string {
+	if do, ok := o.(fs.Inoder); ok {
+		return do.Inode()
+	}
+	return ""
+}
+
+// recordInode records where src is if using the inode strategy
+func (s *syncCopyMove) recordInode(src fs.Object) {
+	if s.inodes == nil {
+		return
+	}
+	inode := inodeOf(src)
+	if inode == "" {
+		return
+	}
+	s.inodesMu.Lock()
+	s.inodes[inode] = src.Remote()
+	s.inodesMu.Unlock()
+}
+
+// findInodeRename returns the dst file which src was synced to last
+// time if src has been renamed since, or nil if not found.
+func (s *syncCopyMove) findInodeRename(src fs.Object) fs.Object {
+	inode := inodeOf(src)
+	if inode == "" {
+		return nil
+	}
+	prevRemote, found := s.prevInodes[inode]
+	if !found || prevRemote == src.Remote() {
+		return nil
+	}
+	s.dstFilesMu.Lock()
+	defer s.dstFilesMu.Unlock()
+	dst, found := s.dstFiles[prevRemote]
+	if !found {
+		return nil
+	}
+	delete(s.dstFiles, prevRemote)
+	return dst
+}
+
+// pushSimilar indexes dst by size for the similar strategy
+func (s *syncCopyMove) pushSimilar(dst fs.Object) {
+	if !s.trackRenamesStrategy.similar() {
+		return
+	}
+	size := dst.Size()
+	s.renameMapMu.Lock()
+	if s.similarMap == nil {
+		s.similarMap = make(map[int64][]fs.Object)
+	}
+	s.similarMap[size] = append(s.similarMap[size], dst)
+	s.renameMapMu.Unlock()
+}
+
+// pathSimilarity returns how many trailing path elements a and b have
+// in common
+func pathSimilarity(a, b string) (n int) {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for n < len(as) && n < len(bs) && as[len(as)-1-n] == bs[len(bs)-1-n] {
+		n++
+	}
+	return n
+}
+
+// editDistance returns the number of single character insertions,
+// deletions or substitutions needed to turn a into b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+// min3 returns the smallest of a, b and c
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// leafDistance returns the edit distance between the leaf names of a
+// and b and whether they are close enough to be the same file
+// renamed, which is when no more than a third of the characters of
+// the longer leaf need changing.
+func leafDistance(a, b string) (distance int, ok bool) {
+	aLeaf, bLeaf := path.Base(a), path.Base(b)
+	distance = editDistance(aLeaf, bLeaf)
+	longest := utf8.RuneCountInString(aLeaf)
+	if n := utf8.RuneCountInString(bLeaf); n > longest {
+		longest = n
+	}
+	return distance, distance*3 <= longest
+}
+
+// findSimilarRename returns the dst file with the same size as src
+// and the most similar leaf name, preferring the one whose
+// directories are most like src's if there are several, or nil if
+// not found.
+//
+// If using the modtime strategy the modification times must match
+// too.
+func (s *syncCopyMove) findSimilarRename(src fs.Object) fs.Object {
+	s.renameMapMu.Lock()
+	defer s.renameMapMu.Unlock()
+	s.dstFilesMu.Lock()
+	defer s.dstFilesMu.Unlock()
+	var (
+		best         fs.Object
+		bestDistance int
+		bestScore    int
+		srcTime      = src.ModTime(s.ctx)
+		srcDir       = path.Dir(src.Remote())
+	)
+	for _, dst := range s.similarMap[src.Size()] {
+		if _, found := s.dstFiles[dst.Remote()]; !found {
+			// renamed already
+			continue
+		}
+		distance, ok := leafDistance(src.Remote(), dst.Remote())
+		if !ok {
+			continue
+		}
+		if s.trackRenamesStrategy.modTime() {
+			dt := dst.ModTime(s.ctx).Sub(srcTime)
+			if dt >= s.modifyWindow || dt <= -s.modifyWindow {
+				continue
+			}
+		}
+		score := pathSimilarity(srcDir, path.Dir(dst.Remote()))
+		if best == nil || distance < bestDistance || (distance == bestDistance && score > bestScore) {
+			best, bestDistance, bestScore = dst, distance, score
+		}
+	}
+	if best != nil {
+		delete(s.dstFiles, best.Remote())
+	}
+	return best
+}
@@ -0,0 +1,63 @@
+// State files kept between runs
+
+package sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+)
+
+// stateFilePath returns the path of the state file for f1 and f2 in
+// the kind directory of the cache directory
+func stateFilePath(kind string, f1, f2 fs.Fs) string {
+	hash := md5.Sum([]byte(fs.ConfigString(f1) + "\x00" + fs.ConfigString(f2)))
+	return filepath.Join(config.CacheDir, kind, hex.EncodeToString(hash[:])+".json")
+}
+
+// readStateFile decodes the JSON in statePath into v returning false
+// if it doesn't exist
+func readStateFile(statePath string, v interface{}) (found bool, err error) {
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeStateFile writes v as JSON to statePath atomically, making
+// the directory if necessary
+func writeStateFile(statePath string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(statePath), 0700)
+	if err != nil {
+		return err
+	}
+	tmpPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, statePath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -13,13 +13,22 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/adaptive"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/march"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/tracing"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/version"
 )
 
+// ErrorMaxDurationReachedGraceful is returned from a sync, copy or
+// move which stopped queueing transfers when --max-duration was
+// reached with --cutoff-mode soft or cautious
+var ErrorMaxDurationReachedGraceful = fserrors.NoRetryError(errors.New("max transfer duration reached as set by --max-duration"))
+
 type syncCopyMove struct {
 	// parameters
 	fdst               fs.Fs
@@ -70,9 +79,26 @@ type syncCopyMove struct {
 	trackRenamesWg         sync.WaitGroup         // wg for background track renames
 	trackRenamesCh         chan fs.Object         // objects are pumped in here
 	renameCheck            []fs.Object            // accumulate files to check for rename here
+	similarMap             map[int64][]fs.Object  // dst files by size - only used by the similar strategy
+	inodesMu               sync.Mutex             // protect inodes
+	inodes                 map[string]string      // src paths by inode - only used by the inode strategy
+	prevInodes             map[string]string      // src paths by inode from the last sync
 	compareCopyDest        fs.Fs                  // place to check for files to server-side copy
 	backupDir              fs.Fs                  // place to store overwrites/deletes
 	checkFirst             bool                   // if set run all the checkers before starting transfers
+	deadline               time.Time              // stop queueing transfers at this time if set
+	checkpoint             *checkpoint            // files done for --resume, may be nil
+	plan                   *plan                  // changes recorded for --diff-format, may be nil
+	errorReport            *ErrorReport           // failures recorded for --error-report, may be nil
+	manifest               *manifestCheck         // files from --files-from-manifest, may be nil
+	checkerLimit           *adaptive.Controller   // limits the checkers for --adaptive-concurrency, may be nil
+	transferLimit          *adaptive.Controller   // limits the transfers for --adaptive-concurrency, may be nil
+	immutable              *immutableDest         // latest versions for --immutable-dest, may be nil
+	dirMetadata            *dirMetadata           // directories for --dirs-metadata, may be nil
+	stage                  fs.Fs                  // staging directory for --sync-atomic, may be nil
+	stageCtx               context.Context        // context for committing and removing the stage
+	stagedMu               sync.Mutex             // protect staged
+	staged                 []stagedFile           // files uploaded to the stage
 }
 
 type trackRenamesStrategy byte
@@ -81,8 +107,14 @@ const (
 	trackRenamesStrategyHash trackRenamesStrategy = 1 << iota
 	trackRenamesStrategyModtime
 	trackRenamesStrategyLeaf
+	trackRenamesStrategyInode
+	trackRenamesStrategySimilar
 )
 
+// trackRenamesFallbacks are the strategies tried when no dst file
+// matches exactly
+const trackRenamesFallbacks = trackRenamesStrategyInode | trackRenamesStrategySimilar
+
 func (strategy trackRenamesStrategy) hash() bool {
 	return (strategy & trackRenamesStrategyHash) != 0
 }
@@ -95,6 +127,26 @@ func (strategy trackRenamesStrategy) leaf() bool {
 	return (strategy & trackRenamesStrategyLeaf) != 0
 }
 
+func (strategy trackRenamesStrategy) inode() bool {
+	return (strategy & trackRenamesStrategyInode) != 0
+}
+
+func (strategy trackRenamesStrategy) similar() bool {
+	return (strategy & trackRenamesStrategySimilar) != 0
+}
+
+// exact returns true if dst files should be matched exactly by size
+// and the hash, modtime and leaf strategies.  This is true unless
+// only fallback strategies were asked for.
+func (strategy trackRenamesStrategy) exact() bool {
+	return strategy&^trackRenamesFallbacks != 0 || strategy&trackRenamesFallbacks == 0
+}
+
+// fallback returns true if a fallback strategy is in use
+func (strategy trackRenamesStrategy) fallback() bool {
+	return strategy&trackRenamesFallbacks != 0
+}
+
 func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) (*syncCopyMove, error) {
 	if (deleteMode != fs.DeleteModeOff || DoMove) && operations.Overlapping(fdst, fsrc) {
 		return nil, fserrors.FatalError(fs.ErrorOverlapping)
@@ -125,39 +177,68 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 		modifyWindow:           fs.GetModifyWindow(ctx, fsrc, fdst),
 		trackRenamesCh:         make(chan fs.Object, ci.Checkers),
 		checkFirst:             ci.CheckFirst,
+		immutable:              newImmutableDest(ctx),
+	}
+	if err := s.immutable.check(ci); err != nil {
+		return nil, err
+	}
+	if ci.AdaptiveConcurrency {
+		s.checkerLimit = adaptive.New("checkers", ci.Checkers)
+		s.transferLimit = adaptive.New("transfers", ci.Transfers)
 	}
 	backlog := ci.MaxBacklog
 	if s.checkFirst {
-		fs.Infof(s.fdst, "Running all checks before starting transfers")
+		fs.GetLogger(ctx).Infof(s.fdst, "Running all checks before starting transfers")
 		backlog = -1
 	}
-	var err error
-	s.toBeChecked, err = newPipe(ci.OrderBy, accounting.Stats(ctx).SetCheckQueue, backlog)
+	orderByInclude, err := parseOrderByInclude(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.toBeChecked, err = newPipe(ci.OrderBy, orderByInclude, accounting.Stats(ctx).SetCheckQueue, backlog)
 	if err != nil {
 		return nil, err
 	}
-	s.toBeUploaded, err = newPipe(ci.OrderBy, accounting.Stats(ctx).SetTransferQueue, backlog)
+	s.toBeUploaded, err = newPipe(ci.OrderBy, orderByInclude, accounting.Stats(ctx).SetTransferQueue, backlog)
 	if err != nil {
 		return nil, err
 	}
-	s.toBeRenamed, err = newPipe(ci.OrderBy, accounting.Stats(ctx).SetRenameQueue, backlog)
+	s.toBeRenamed, err = newPipe(ci.OrderBy, orderByInclude, accounting.Stats(ctx).SetRenameQueue, backlog)
 	if err != nil {
 		return nil, err
 	}
 	// If a max session duration has been defined add a deadline to the context
 	if ci.MaxDuration > 0 {
 		endTime := time.Now().Add(ci.MaxDuration)
-		fs.Infof(s.fdst, "Transfer session deadline: %s", endTime.Format("2006/01/02 15:04:05"))
-		s.ctx, s.cancel = context.WithDeadline(ctx, endTime)
+		fs.GetLogger(ctx).Infof(s.fdst, "Transfer session deadline: %s", endTime.Format("2006/01/02 15:04:05"))
+		if ci.CutoffMode == fs.CutoffModeHard {
+			s.ctx, s.cancel = context.WithDeadline(ctx, endTime)
+		} else {
+			// Stop queueing transfers at the deadline but let
+			// the ones in progress finish
+			s.ctx, s.cancel = context.WithCancel(ctx)
+			s.deadline = endTime
+		}
 	} else {
 		s.ctx, s.cancel = context.WithCancel(ctx)
 	}
 	// Input context - cancel this for graceful stop
 	s.inCtx, s.inCancel = context.WithCancel(s.ctx)
 	if s.noTraverse && s.deleteMode != fs.DeleteModeOff {
-		fs.Errorf(nil, "Ignoring --no-traverse with sync")
+		fs.GetLogger(ctx).Errorf(nil, "Ignoring --no-traverse with sync")
 		s.noTraverse = false
 	}
+	if s.immutable != nil {
+		// the versions need to be found in the listings
+		if s.noTraverse {
+			fs.GetLogger(ctx).Errorf(nil, "Ignoring --no-traverse with --immutable-dest")
+			s.noTraverse = false
+		}
+		if s.trackRenames {
+			fs.GetLogger(ctx).Errorf(nil, "Ignoring --track-renames with --immutable-dest")
+			s.trackRenames = false
+		}
+	}
 	s.trackRenamesStrategy, err = parseTrackRenamesStrategy(ci.TrackRenamesStrategy)
 	if err != nil {
 		return nil, err
@@ -176,21 +257,36 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 	if s.trackRenames {
 		// Don't track renames for remotes without server-side move support.
 		if !operations.CanServerSideMove(fdst) {
-			fs.Errorf(fdst, "Ignoring --track-renames as the destination does not support server-side move or copy")
+			fs.GetLogger(ctx).Errorf(fdst, "Ignoring --track-renames as the destination does not support server-side move or copy")
 			s.trackRenames = false
 		}
+		if s.trackRenamesStrategy.inode() && !fsrc.Features().IsLocal {
+			s.trackRenamesStrategy &^= trackRenamesStrategyInode
+			if s.trackRenamesStrategy == 0 {
+				fs.GetLogger(ctx).Errorf(fdst, "Ignoring --track-renames as the inode strategy needs a local source")
+				s.trackRenames = false
+			} else {
+				fs.GetLogger(ctx).Errorf(fdst, "Not using the inode strategy of --track-renames as the source isn't local")
+			}
+		}
+
 		if s.trackRenamesStrategy.hash() && s.commonHash == hash.None {
-			fs.Errorf(fdst, "Ignoring --track-renames as the source and destination do not have a common hash")
-			s.trackRenames = false
+			if s.trackRenamesStrategy.fallback() {
+				fs.GetLogger(ctx).Infof(fdst, "Not using the hash strategy of --track-renames as the source and destination do not have a common hash")
+				s.trackRenamesStrategy &^= trackRenamesStrategyHash
+			} else {
+				fs.GetLogger(ctx).Errorf(fdst, "Ignoring --track-renames as the source and destination do not have a common hash")
+				s.trackRenames = false
+			}
 		}
 
 		if s.trackRenamesStrategy.modTime() && s.modifyWindow == fs.ModTimeNotSupported {
-			fs.Errorf(fdst, "Ignoring --track-renames as either the source or destination do not support modtime")
+			fs.GetLogger(ctx).Errorf(fdst, "Ignoring --track-renames as either the source or destination do not support modtime")
 			s.trackRenames = false
 		}
 
 		if s.deleteMode == fs.DeleteModeOff {
-			fs.Errorf(fdst, "Ignoring --track-renames as it doesn't work with copy or move, only sync")
+			fs.GetLogger(ctx).Errorf(fdst, "Ignoring --track-renames as it doesn't work with copy or move, only sync")
 			s.trackRenames = false
 		}
 	}
@@ -200,12 +296,15 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 			s.deleteMode = fs.DeleteModeAfter
 		}
 		if s.noTraverse {
-			fs.Errorf(nil, "Ignoring --no-traverse with --track-renames")
+			fs.GetLogger(ctx).Errorf(nil, "Ignoring --no-traverse with --track-renames")
 			s.noTraverse = false
 		}
+		if s.trackRenamesStrategy.inode() {
+			s.loadInodes()
+		}
 	}
 	// Make Fs for --backup-dir if required
-	if ci.BackupDir != "" || ci.Suffix != "" {
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupVersioning {
 		var err error
 		s.backupDir, err = operations.BackupDir(ctx, fdst, fsrc, "")
 		if err != nil {
@@ -225,6 +324,16 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 			return nil, err
 		}
 	}
+	if ci.SyncAtomic && !ci.DryRun && s.deleteMode != fs.DeleteModeOnly {
+		err = s.makeStage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// deletions must wait for the commit
+		if s.deleteMode != fs.DeleteModeOff {
+			s.deleteMode = fs.DeleteModeAfter
+		}
+	}
 	return s, nil
 }
 
@@ -258,9 +367,12 @@ func (s *syncCopyMove) processError(err error) {
 	}
 	if err == context.DeadlineExceeded {
 		err = fserrors.NoRetryError(err)
-	} else if err == accounting.ErrorMaxTransferLimitReachedGraceful {
+	} else if err == accounting.ErrorMaxTransferLimitReachedGraceful || err == ErrorMaxDurationReachedGraceful {
 		if s.inCtx.Err() == nil {
-			fs.Logf(nil, "%v - stopping transfers", err)
+			fs.GetLogger(s.ctx).Logf(nil, "%v - stopping transfers", err)
+			if s.checkpoint == nil {
+				fs.GetLogger(s.ctx).Logf(nil, "Use --resume to carry on from where this stopped next time")
+			}
 			// Cancel the march and stop the pipes
 			s.inCancel()
 		}
@@ -270,7 +382,7 @@ func (s *syncCopyMove) processError(err error) {
 	switch {
 	case fserrors.IsFatalError(err):
 		if !s.aborting() {
-			fs.Errorf(nil, "Cancelling sync due to fatal error: %v", err)
+			fs.GetLogger(s.ctx).Errorf(nil, "Cancelling sync due to fatal error: %v", err)
 			s.cancel()
 		}
 		s.fatalErr = err
@@ -302,42 +414,50 @@ func (s *syncCopyMove) currentError() error {
 // FIXME potentially doing lots of hashes at once
 func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	ctx := adaptive.WithController(s.ctx, s.checkerLimit)
 	for {
 		pair, ok := in.GetMax(s.inCtx, fraction)
 		if !ok {
 			return
 		}
+		if s.checkerLimit.Acquire(ctx) != nil {
+			return
+		}
 		src := pair.Src
 		var err error
-		tr := accounting.Stats(s.ctx).NewCheckingTransfer(src)
+		tr := accounting.Stats(ctx).NewCheckingTransfer(src)
 		// Check to see if can store this
-		if src.Storable() {
-			NoNeedTransfer, err := operations.CompareOrCopyDest(s.ctx, s.fdst, pair.Dst, pair.Src, s.compareCopyDest, s.backupDir)
+		if pair.Dst != nil && s.checkpoint.isDone(ctx, src) {
+			fs.GetLogger(ctx).Debugf(src, "Already done according to the checkpoint")
+		} else if src.Storable() {
+			NoNeedTransfer, err := operations.CompareOrCopyDest(ctx, s.fdst, pair.Dst, pair.Src, s.compareCopyDest, s.backupDir)
 			if err != nil {
 				s.processError(err)
 			}
-			if !NoNeedTransfer && operations.NeedTransfer(s.ctx, pair.Dst, pair.Src) {
+			if !NoNeedTransfer && operations.NeedTransfer(ctx, pair.Dst, pair.Src) {
 				// If files are treated as immutable, fail if destination exists and does not match
 				if s.ci.Immutable && pair.Dst != nil {
-					fs.Errorf(pair.Dst, "Source and destination exist but do not match: immutable file modified")
+					fs.GetLogger(ctx).Errorf(pair.Dst, "Source and destination exist but do not match: immutable file modified")
 					s.processError(fs.ErrorImmutableModified)
 				} else {
 					// If destination already exists, then we must move it into --backup-dir if required
 					if pair.Dst != nil && s.backupDir != nil {
-						err := operations.MoveBackupDir(s.ctx, s.backupDir, pair.Dst)
+						err := operations.MoveBackupDir(ctx, s.backupDir, pair.Dst)
 						if err != nil {
 							s.processError(err)
 						} else {
 							// If successful zero out the dst as it is no longer there and copy the file
 							pair.Dst = nil
-							ok = out.Put(s.ctx, pair)
+							ok = out.Put(ctx, pair)
 							if !ok {
+								s.checkerLimit.Release()
 								return
 							}
 						}
 					} else {
-						ok = out.Put(s.ctx, pair)
+						ok = out.Put(ctx, pair)
 						if !ok {
+							s.checkerLimit.Release()
 							return
 						}
 					}
@@ -346,11 +466,19 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 				// If moving need to delete the files we don't need to copy
 				if s.DoMove {
 					// Delete src if no error on copy
-					s.processError(operations.DeleteFile(s.ctx, src))
+					s.processError(operations.DeleteFile(ctx, src))
+				} else if err == nil {
+					err = s.manifest.checkDst(ctx, pair.Dst)
+					if err != nil {
+						s.processError(err)
+					} else {
+						s.checkpoint.done(ctx, src)
+					}
 				}
 			}
 		}
-		tr.Done(s.ctx, err)
+		tr.Done(ctx, err)
+		s.checkerLimit.Release()
 	}
 }
 
@@ -364,8 +492,10 @@ func (s *syncCopyMove) pairRenamer(in *pipe, out *pipe, fraction int, wg *sync.W
 			return
 		}
 		src := pair.Src
-		if !s.tryRename(src) {
-			// pass on if not renamed
+		renamed, dst := s.tryRename(src)
+		if !renamed || dst != nil {
+			// pass on if not renamed or the renamed file needs updating
+			pair.Dst = dst
 			ok = out.Put(s.ctx, pair)
 			if !ok {
 				return
@@ -383,11 +513,43 @@ func (s *syncCopyMove) pairCopyOrMove(ctx context.Context, in *pipe, fdst fs.Fs,
 		if !ok {
 			return
 		}
+		if s.transferLimit.Acquire(ctx) != nil {
+			return
+		}
 		src := pair.Src
+		err = s.manifest.checkSrc(ctx, src)
+		if err != nil {
+			s.transferLimit.Release()
+			s.processError(err)
+			continue
+		}
+		s.plan.transfer(ctx, pair.Dst, src)
+		if pair.Dst == nil {
+			// write a new version of a file deleted with --immutable-dest
+			pair.Dst = s.immutable.deleted(src.Remote())
+		}
+		var dst fs.Object
+		if s.stage != nil {
+			dst, err = operations.Copy(ctx, fdst, nil, src.Remote(), src)
+			if err == nil && dst != nil {
+				s.addStaged(dst, pair.Dst)
+			}
+		} else if s.DoMove {
+			dst, err = operations.Move(ctx, fdst, pair.Dst, src.Remote(), src)
+		} else {
+			dst, err = operations.Copy(ctx, fdst, pair.Dst, src.Remote(), src)
+		}
+		if err == nil {
+			err = s.manifest.checkDst(ctx, dst)
+		}
+		if err == nil && s.stage == nil && !s.DoMove {
+			s.checkpoint.done(ctx, src)
+		}
+		s.transferLimit.Release()
 		if s.DoMove {
-			_, err = operations.Move(ctx, fdst, pair.Dst, src.Remote(), src)
+			s.errorReport.add(FailedMove, src.Remote(), err)
 		} else {
-			_, err = operations.Copy(ctx, fdst, pair.Dst, src.Remote(), src)
+			s.errorReport.add(FailedCopy, src.Remote(), err)
 		}
 		s.processError(err)
 	}
@@ -405,23 +567,27 @@ func (s *syncCopyMove) startCheckers() {
 // This stops the background checkers
 func (s *syncCopyMove) stopCheckers() {
 	s.toBeChecked.Close()
-	fs.Debugf(s.fdst, "Waiting for checks to finish")
+	fs.GetLogger(s.ctx).Debugf(s.fdst, "Waiting for checks to finish")
 	s.checkerWg.Wait()
 }
 
 // This starts the background transfers
 func (s *syncCopyMove) startTransfers() {
+	fdst := s.fdst
+	if s.stage != nil {
+		fdst = s.stage
+	}
 	s.transfersWg.Add(s.ci.Transfers)
 	for i := 0; i < s.ci.Transfers; i++ {
 		fraction := (100 * i) / s.ci.Transfers
-		go s.pairCopyOrMove(s.ctx, s.toBeUploaded, s.fdst, fraction, &s.transfersWg)
+		go s.pairCopyOrMove(adaptive.WithController(s.ctx, s.transferLimit), s.toBeUploaded, fdst, fraction, &s.transfersWg)
 	}
 }
 
 // This stops the background transfers
 func (s *syncCopyMove) stopTransfers() {
 	s.toBeUploaded.Close()
-	fs.Debugf(s.fdst, "Waiting for transfers to finish")
+	fs.GetLogger(s.ctx).Debugf(s.fdst, "Waiting for transfers to finish")
 	s.transfersWg.Wait()
 }
 
@@ -443,7 +609,7 @@ func (s *syncCopyMove) stopRenamers() {
 		return
 	}
 	s.toBeRenamed.Close()
-	fs.Debugf(s.fdst, "Waiting for renames to finish")
+	fs.GetLogger(s.ctx).Debugf(s.fdst, "Waiting for renames to finish")
 	s.renamerWg.Wait()
 }
 
@@ -478,7 +644,7 @@ func (s *syncCopyMove) startDeleters() {
 	s.deletersWg.Add(1)
 	go func() {
 		defer s.deletersWg.Done()
-		err := operations.DeleteFilesWithBackupDir(s.ctx, s.deleteFilesCh, s.backupDir)
+		err := operations.DeleteFilesWithBackupDirFn(s.ctx, s.deleteFilesCh, s.backupDir, s.deleteFailed)
 		s.processError(err)
 	}()
 }
@@ -499,7 +665,7 @@ func (s *syncCopyMove) stopDeleters() {
 // have been found have been removed from dstFiles already.
 func (s *syncCopyMove) deleteFiles(checkSrcMap bool) error {
 	if accounting.Stats(s.ctx).Errored() && !s.ci.IgnoreErrors {
-		fs.Errorf(s.fdst, "%v", fs.ErrorNotDeleting)
+		fs.GetLogger(s.ctx).Errorf(s.fdst, "%v", fs.ErrorNotDeleting)
 		return fs.ErrorNotDeleting
 	}
 
@@ -517,6 +683,7 @@ func (s *syncCopyMove) deleteFiles(checkSrcMap bool) error {
 			if s.aborting() {
 				break
 			}
+			s.plan.delete(o)
 			select {
 			case <-s.ctx.Done():
 				break outer
@@ -525,7 +692,12 @@ func (s *syncCopyMove) deleteFiles(checkSrcMap bool) error {
 		}
 		close(toDelete)
 	}()
-	return operations.DeleteFilesWithBackupDir(s.ctx, toDelete, s.backupDir)
+	return operations.DeleteFilesWithBackupDirFn(s.ctx, toDelete, s.backupDir, s.deleteFailed)
+}
+
+// deleteFailed records dst in the --error-report if set
+func (s *syncCopyMove) deleteFailed(dst fs.Object, err error) {
+	s.errorReport.deleteFailed(dst, err)
 }
 
 // This deletes the empty directories in the slice passed in.  It
@@ -535,7 +707,7 @@ func (s *syncCopyMove) deleteEmptyDirectories(ctx context.Context, f fs.Fs, entr
 		return nil
 	}
 	if accounting.Stats(ctx).Errored() && !s.ci.IgnoreErrors {
-		fs.Errorf(f, "%v", fs.ErrorNotDeletingDirs)
+		fs.GetLogger(ctx).Errorf(f, "%v", fs.ErrorNotDeletingDirs)
 		return fs.ErrorNotDeletingDirs
 	}
 
@@ -554,20 +726,20 @@ func (s *syncCopyMove) deleteEmptyDirectories(ctx context.Context, f fs.Fs, entr
 			// TryRmdir only deletes empty directories
 			err := operations.TryRmdir(ctx, f, dir.Remote())
 			if err != nil {
-				fs.Debugf(fs.LogDirName(f, dir.Remote()), "Failed to Rmdir: %v", err)
+				fs.GetLogger(ctx).Debugf(fs.LogDirName(f, dir.Remote()), "Failed to Rmdir: %v", err)
 				errorCount++
 			} else {
 				okCount++
 			}
 		} else {
-			fs.Errorf(f, "Not a directory: %v", entry)
+			fs.GetLogger(ctx).Errorf(f, "Not a directory: %v", entry)
 		}
 	}
 	if errorCount > 0 {
-		fs.Debugf(f, "failed to delete %d directories", errorCount)
+		fs.GetLogger(ctx).Debugf(f, "failed to delete %d directories", errorCount)
 	}
 	if okCount > 0 {
-		fs.Debugf(f, "deleted %d directories", okCount)
+		fs.GetLogger(ctx).Debugf(f, "deleted %d directories", okCount)
 	}
 	return nil
 }
@@ -585,21 +757,21 @@ func copyEmptyDirectories(ctx context.Context, f fs.Fs, entries map[string]fs.Di
 		if ok {
 			err := operations.Mkdir(ctx, f, dir.Remote())
 			if err != nil {
-				fs.Errorf(fs.LogDirName(f, dir.Remote()), "Failed to Mkdir: %v", err)
+				fs.GetLogger(ctx).Errorf(fs.LogDirName(f, dir.Remote()), "Failed to Mkdir: %v", err)
 			} else {
 				okCount++
 			}
 		} else {
-			fs.Errorf(f, "Not a directory: %v", entry)
+			fs.GetLogger(ctx).Errorf(f, "Not a directory: %v", entry)
 		}
 	}
 
 	if accounting.Stats(ctx).Errored() {
-		fs.Debugf(f, "failed to copy %d directories", accounting.Stats(ctx).GetErrors())
+		fs.GetLogger(ctx).Debugf(f, "failed to copy %d directories", accounting.Stats(ctx).GetErrors())
 	}
 
 	if okCount > 0 {
-		fs.Debugf(f, "copied %d directories", okCount)
+		fs.GetLogger(ctx).Debugf(f, "copied %d directories", okCount)
 	}
 	return nil
 }
@@ -632,6 +804,10 @@ func parseTrackRenamesStrategy(strategies string) (strategy trackRenamesStrategy
 			strategy |= trackRenamesStrategyModtime
 		case "leaf":
 			strategy |= trackRenamesStrategyLeaf
+		case "inode":
+			strategy |= trackRenamesStrategyInode
+		case "similar":
+			strategy |= trackRenamesStrategySimilar
 		case "size":
 			// ignore
 		default:
@@ -654,7 +830,7 @@ func (s *syncCopyMove) renameID(obj fs.Object, renamesStrategy trackRenamesStrat
 		hash, err := obj.Hash(s.ctx, s.commonHash)
 
 		if err != nil {
-			fs.Debugf(obj, "Hash failed: %v", err)
+			fs.GetLogger(s.ctx).Debugf(obj, "Hash failed: %v", err)
 			return ""
 		}
 		if hash == "" {
@@ -726,7 +902,7 @@ func (s *syncCopyMove) popRenameMap(hash string, src fs.Object) (dst fs.Object)
 // makeRenameMap builds a map of the destination files by hash that
 // match sizes in the slice of objects in s.renameCheck
 func (s *syncCopyMove) makeRenameMap() {
-	fs.Infof(s.fdst, "Making map for --track-renames")
+	fs.GetLogger(s.ctx).Infof(s.fdst, "Making map for --track-renames")
 
 	// first make a map of possible sizes we need to check
 	possibleSizes := map[int64]struct{}{}
@@ -740,6 +916,16 @@ func (s *syncCopyMove) makeRenameMap() {
 
 	// now make a map of size,hash for all dstFiles
 	s.renameMap = make(map[string][]fs.Object)
+	if !s.trackRenamesStrategy.exact() {
+		// only the fallback strategies need indexing
+		for obj := range in {
+			if _, found := possibleSizes[obj.Size()]; found {
+				s.pushSimilar(obj)
+			}
+		}
+		fs.GetLogger(s.ctx).Infof(s.fdst, "Finished making map for --track-renames")
+		return
+	}
 	var wg sync.WaitGroup
 	wg.Add(s.ci.Transfers)
 	for i := 0; i < s.ci.Transfers; i++ {
@@ -748,6 +934,7 @@ func (s *syncCopyMove) makeRenameMap() {
 			for obj := range in {
 				// only create hash for dst fs.Object if its size could match
 				if _, found := possibleSizes[obj.Size()]; found {
+					s.pushSimilar(obj)
 					tr := accounting.Stats(s.ctx).NewCheckingTransfer(obj)
 					hash := s.renameID(obj, s.trackRenamesStrategy, s.modifyWindow)
 
@@ -761,41 +948,74 @@ func (s *syncCopyMove) makeRenameMap() {
 		}()
 	}
 	wg.Wait()
-	fs.Infof(s.fdst, "Finished making map for --track-renames")
+	fs.GetLogger(s.ctx).Infof(s.fdst, "Finished making map for --track-renames")
 }
 
 // tryRename renames an src object when doing track renames if
 // possible, it returns true if the object was renamed.
-func (s *syncCopyMove) tryRename(src fs.Object) bool {
-	// Calculate the hash of the src object
-	hash := s.renameID(src, s.trackRenamesStrategy, fs.GetModifyWindow(s.ctx, s.fsrc, s.fdst))
-
-	if hash == "" {
-		return false
+//
+// If the dst file was found by a fallback strategy and is different
+// from src once renamed, the renamed object is returned so it can be
+// updated.
+func (s *syncCopyMove) tryRename(src fs.Object) (renamed bool, needsUpdate fs.Object) {
+	var dst fs.Object
+	if s.trackRenamesStrategy.exact() {
+		// Calculate the hash of the src object
+		hash := s.renameID(src, s.trackRenamesStrategy, fs.GetModifyWindow(s.ctx, s.fsrc, s.fdst))
+
+		// Get a match on fdst which hasn't been renamed already
+		for hash != "" {
+			dst = s.popRenameMap(hash, src)
+			if dst == nil || s.claimRenameDst(dst) {
+				break
+			}
+		}
+	}
+	fallback := false
+	if dst == nil && s.trackRenamesStrategy.inode() {
+		dst = s.findInodeRename(src)
+		fallback = dst != nil
+	}
+	if dst == nil && s.trackRenamesStrategy.similar() {
+		dst = s.findSimilarRename(src)
+		fallback = dst != nil
 	}
-
-	// Get a match on fdst
-	dst := s.popRenameMap(hash, src)
 	if dst == nil {
-		return false
+		return false, nil
 	}
 
 	// Find dst object we are about to overwrite if it exists
 	dstOverwritten, _ := s.fdst.NewObject(s.ctx, src.Remote())
 
 	// Rename dst to have name src.Remote()
-	_, err := operations.Move(s.ctx, s.fdst, dstOverwritten, src.Remote(), dst)
+	newDst, err := operations.Move(s.ctx, s.fdst, dstOverwritten, src.Remote(), dst)
 	if err != nil {
-		fs.Debugf(src, "Failed to rename to %q: %v", dst.Remote(), err)
-		return false
+		fs.GetLogger(s.ctx).Debugf(src, "Failed to rename to %q: %v", dst.Remote(), err)
+		// put the file back so it gets deleted if necessary
+		s.dstFilesMu.Lock()
+		s.dstFiles[dst.Remote()] = dst
+		s.dstFilesMu.Unlock()
+		return false, nil
 	}
 
-	// remove file from dstFiles if present
+	s.plan.rename(dst, src.Remote())
+
+	fs.GetLogger(s.ctx).Infof(src, "Renamed from %q", dst.Remote())
+	if fallback && newDst != nil && operations.NeedTransfer(s.ctx, newDst, src) {
+		return true, newDst
+	}
+	return true, nil
+}
+
+// claimRenameDst removes dst from the dst files so it can only be
+// renamed once.  It returns false if it has been renamed already.
+func (s *syncCopyMove) claimRenameDst(dst fs.Object) bool {
 	s.dstFilesMu.Lock()
+	defer s.dstFilesMu.Unlock()
+	if _, found := s.dstFiles[dst.Remote()]; !found {
+		return false
+	}
 	delete(s.dstFiles, dst.Remote())
-	s.dstFilesMu.Unlock()
-
-	fs.Infof(src, "Renamed from %q", dst.Remote())
 	return true
 }
 
@@ -808,7 +1028,7 @@ func (s *syncCopyMove) tryRename(src fs.Object) bool {
 // dir is the start directory, "" for root
 func (s *syncCopyMove) run() error {
 	if operations.Same(s.fdst, s.fsrc) {
-		fs.Errorf(s.fdst, "Nothing to do as source and destination are the same")
+		fs.GetLogger(s.ctx).Errorf(s.fdst, "Nothing to do as source and destination are the same")
 		return nil
 	}
 
@@ -823,6 +1043,14 @@ func (s *syncCopyMove) run() error {
 
 	s.startTrackRenames()
 
+	// Stop queueing transfers at the --max-duration deadline
+	var deadlineTimer *time.Timer
+	if !s.deadline.IsZero() {
+		deadlineTimer = time.AfterFunc(time.Until(s.deadline), func() {
+			s.processError(ErrorMaxDurationReachedGraceful)
+		})
+	}
+
 	// set up a march over fdst and fsrc
 	m := &march.March{
 		Ctx:                    s.inCtx,
@@ -835,7 +1063,11 @@ func (s *syncCopyMove) run() error {
 		NoCheckDest:            s.noCheckDest,
 		NoUnicodeNormalization: s.noUnicodeNormalization,
 	}
+	if s.immutable != nil {
+		m.DstTransform = s.immutable.transform
+	}
 	s.processError(m.Run(s.ctx))
+	s.processError(s.manifest.checkMissing(s.ctx, s.dir))
 
 	s.stopTrackRenames()
 	if s.trackRenames {
@@ -853,12 +1085,20 @@ func (s *syncCopyMove) run() error {
 	// Stop background checking and transferring pipeline
 	s.stopCheckers()
 	if s.checkFirst {
-		fs.Infof(s.fdst, "Checks finished, now starting transfers")
+		fs.GetLogger(s.ctx).Infof(s.fdst, "Checks finished, now starting transfers")
 		s.startTransfers()
 	}
 	s.stopRenamers()
 	s.stopTransfers()
 	s.stopDeleters()
+	if deadlineTimer != nil {
+		deadlineTimer.Stop()
+	}
+
+	// Move the files uploaded with --sync-atomic into place
+	if s.stage != nil {
+		s.finishStage()
+	}
 
 	if s.copyEmptySrcDirs {
 		s.processError(copyEmptyDirectories(s.ctx, s.fdst, s.srcEmptyDirs))
@@ -867,7 +1107,7 @@ func (s *syncCopyMove) run() error {
 	// Delete files after
 	if s.deleteMode == fs.DeleteModeAfter {
 		if s.currentError() != nil && !s.ci.IgnoreErrors {
-			fs.Errorf(s.fdst, "%v", fs.ErrorNotDeleting)
+			fs.GetLogger(s.ctx).Errorf(s.fdst, "%v", fs.ErrorNotDeleting)
 		} else {
 			s.processError(s.deleteFiles(false))
 		}
@@ -876,12 +1116,16 @@ func (s *syncCopyMove) run() error {
 	// Prune empty directories
 	if s.deleteMode != fs.DeleteModeOff {
 		if s.currentError() != nil && !s.ci.IgnoreErrors {
-			fs.Errorf(s.fdst, "%v", fs.ErrorNotDeletingDirs)
+			fs.GetLogger(s.ctx).Errorf(s.fdst, "%v", fs.ErrorNotDeletingDirs)
 		} else {
 			s.processError(s.deleteEmptyDirectories(s.ctx, s.fdst, s.dstEmptyDirs))
 		}
 	}
 
+	// Set the directory modification times and metadata now the
+	// contents are done, before any source directories are removed
+	s.processError(s.dirMetadata.apply(s.ctx))
+
 	// Delete empty fsrc subdirectories
 	// if DoMove and --delete-empty-src-dirs flag is set
 	if s.DoMove && s.deleteEmptySrcDirs {
@@ -892,8 +1136,13 @@ func (s *syncCopyMove) run() error {
 	// Read the error out of the context if there is one
 	s.processError(s.ctx.Err())
 
+	// Remember where the source files were for the next sync
+	if s.inodes != nil && s.currentError() == nil {
+		s.saveInodes()
+	}
+
 	if s.deleteMode != fs.DeleteModeOnly && accounting.Stats(s.ctx).GetTransfers() == 0 {
-		fs.Infof(nil, "There was nothing to transfer")
+		fs.GetLogger(s.ctx).Infof(nil, "There was nothing to transfer")
 	}
 
 	// cancel the context to free resources
@@ -906,6 +1155,13 @@ func (s *syncCopyMove) DstOnly(dst fs.DirEntry) (recurse bool) {
 	if s.deleteMode == fs.DeleteModeOff {
 		return false
 	}
+	if s.stage != nil && isStage(dst.Remote()) {
+		return false
+	}
+	if s.ci.BackupVersioning && version.Match(dst.Remote()) {
+		// keep the versions of files
+		return false
+	}
 	switch x := dst.(type) {
 	case fs.Object:
 		switch s.deleteMode {
@@ -915,6 +1171,7 @@ func (s *syncCopyMove) DstOnly(dst fs.DirEntry) (recurse bool) {
 			s.dstFiles[x.Remote()] = x
 			s.dstFilesMu.Unlock()
 		case fs.DeleteModeDuring, fs.DeleteModeOnly:
+			s.plan.delete(x)
 			select {
 			case <-s.ctx.Done():
 				return
@@ -952,6 +1209,8 @@ func (s *syncCopyMove) SrcOnly(src fs.DirEntry) (recurse bool) {
 		s.srcEmptyDirsMu.Lock()
 		s.srcParentDirCheck(src)
 		s.srcEmptyDirsMu.Unlock()
+		s.recordInode(x)
+		s.manifest.see(x)
 
 		if s.trackRenames {
 			// Save object to check for a rename later
@@ -981,6 +1240,7 @@ func (s *syncCopyMove) SrcOnly(src fs.DirEntry) (recurse bool) {
 		s.srcParentDirCheck(src)
 		s.srcEmptyDirs[src.Remote()] = src
 		s.srcEmptyDirsMu.Unlock()
+		s.dirMetadata.add(x)
 		return true
 	default:
 		panic("Bad object in DirEntries")
@@ -995,6 +1255,8 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 		s.srcEmptyDirsMu.Lock()
 		s.srcParentDirCheck(src)
 		s.srcEmptyDirsMu.Unlock()
+		s.recordInode(srcX)
+		s.manifest.see(srcX)
 
 		if s.deleteMode == fs.DeleteModeOnly {
 			return false
@@ -1008,13 +1270,14 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 		} else {
 			// FIXME src is file, dst is directory
 			err := errors.New("can't overwrite directory with file")
-			fs.Errorf(dst, "%v", err)
+			fs.GetLogger(ctx).Errorf(dst, "%v", err)
 			s.processError(err)
 		}
 	case fs.Directory:
 		// Do the same thing to the entire contents of the directory
 		_, ok := dst.(fs.Directory)
 		if ok {
+			s.dirMetadata.add(srcX)
 			// Only record matched (src & dst) empty dirs when performing move
 			if s.DoMove {
 				// Record the src directory for deletion
@@ -1028,7 +1291,7 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 		}
 		// FIXME src is dir, dst is file
 		err := errors.New("can't overwrite file with directory")
-		fs.Errorf(dst, "%v", err)
+		fs.GetLogger(ctx).Errorf(dst, "%v", err)
 		s.processError(err)
 	default:
 		panic("Bad object in DirEntries")
@@ -1043,21 +1306,97 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 // If DoMove is true then files will be moved instead of copied
 //
 // dir is the start directory, "" for root
-func runSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) error {
+func runSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) (err error) {
 	ci := fs.GetConfig(ctx)
+	spanName := "sync.CopyDir"
+	if DoMove {
+		spanName = "sync.MoveDir"
+	} else if deleteMode != fs.DeleteModeOff {
+		spanName = "sync.Sync"
+	}
+	ctx, span := tracing.Start(ctx, spanName, tracing.KindInternal)
+	span.SetAttribute("src", fs.ConfigString(fsrc))
+	span.SetAttribute("dst", fs.ConfigString(fdst))
+	defer func() {
+		span.End(err)
+	}()
+	// Give all the log messages of this sync the same ID
+	ctx = fs.WithLogValue(ctx, "syncID", random.String(8))
 	if deleteMode != fs.DeleteModeOff && DoMove {
 		return fserrors.FatalError(errors.New("can't delete and move at the same time"))
 	}
+	if deleteMode == fs.DeleteModeBefore && ci.TrackRenames {
+		return fserrors.FatalError(errors.New("can't use --delete-before with --track-renames"))
+	}
+	if ci.SyncAtomic && deleteMode == fs.DeleteModeBefore {
+		// deletions must wait for the staged files to be committed
+		deleteMode = fs.DeleteModeAfter
+	}
+	if ci.ApplyPlan != "" {
+		if DoMove {
+			return fserrors.FatalError(errors.New("can't use --apply-plan when moving"))
+		}
+		return applyPlan(ctx, fdst, fsrc, ci.ApplyPlan)
+	}
+	p, err := newPlan(ctx, fdst, fsrc)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		defer func() {
+			if err == nil {
+				err = p.write(planOutput)
+			}
+		}()
+	}
+	report := newErrorReport(ctx, fdst, fsrc)
+	if report != nil {
+		defer func() {
+			writeErr := report.write(ci.ErrorReport)
+			if err == nil {
+				err = writeErr
+			}
+		}()
+	}
+	var cp *checkpoint
+	if ci.Resume != "" {
+		if DoMove || ci.DryRun || ci.SyncAtomic {
+			fs.GetLogger(ctx).Infof(nil, "Ignoring --resume when moving, with --dry-run or with --sync-atomic")
+		} else {
+			cp, err = newCheckpoint(ctx, fdst, fsrc, ci.Resume)
+			if err != nil {
+				return err
+			}
+			cp.start(ci.CheckpointInterval)
+			defer func() {
+				cp.finish(err)
+			}()
+		}
+	}
+	syncDir := func(dir string) error {
+		return runSyncCopyMoveDir(ctx, fdst, fsrc, dir, deleteMode, DoMove, deleteEmptySrcDirs, copyEmptySrcDirs, cp, p, report)
+	}
+	if ci.UseChangeListing {
+		return runChangeSync(ctx, fdst, fsrc, deleteMode, DoMove, syncDir)
+	}
+	return syncDir("")
+}
+
+// runSyncCopyMoveDir syncs, copies or moves the directory dir of fsrc
+// to fdst, or all of them if dir is "", recording the files done in
+// cp, the changes made in p and the failures in report if they aren't
+// nil
+func runSyncCopyMoveDir(ctx context.Context, fdst, fsrc fs.Fs, dir string, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool, cp *checkpoint, p *plan, report *ErrorReport) error {
 	// Run an extra pass to delete only
 	if deleteMode == fs.DeleteModeBefore {
-		if ci.TrackRenames {
-			return fserrors.FatalError(errors.New("can't use --delete-before with --track-renames"))
-		}
 		// only delete stuff during in this pass
 		do, err := newSyncCopyMove(ctx, fdst, fsrc, fs.DeleteModeOnly, false, deleteEmptySrcDirs, copyEmptySrcDirs)
 		if err != nil {
 			return err
 		}
+		do.dir = dir
+		do.plan = p
+		do.errorReport = report
 		err = do.run()
 		if err != nil {
 			return err
@@ -1069,6 +1408,12 @@ func runSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 	if err != nil {
 		return err
 	}
+	do.dir = dir
+	do.checkpoint = cp
+	do.plan = p
+	do.errorReport = report
+	do.manifest = newManifestCheck(do.fi)
+	do.dirMetadata = newDirMetadata(ctx, fdst, fsrc, dir)
 	return do.run()
 }
 
@@ -1092,7 +1437,7 @@ func moveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, cop
 func MoveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) error {
 	fi := filter.GetConfig(ctx)
 	if operations.Same(fdst, fsrc) {
-		fs.Errorf(fdst, "Nothing to do as source and destination are the same")
+		fs.GetLogger(ctx).Errorf(fdst, "Nothing to do as source and destination are the same")
 		return nil
 	}
 
@@ -1101,17 +1446,17 @@ func MoveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, cop
 		if operations.SkipDestructive(ctx, fdst, "server-side directory move") {
 			return nil
 		}
-		fs.Debugf(fdst, "Using server-side directory move")
+		fs.GetLogger(ctx).Debugf(fdst, "Using server-side directory move")
 		err := fdstDirMove(ctx, fsrc, "", "")
 		switch err {
 		case fs.ErrorCantDirMove, fs.ErrorDirExists:
-			fs.Infof(fdst, "Server side directory move failed - fallback to file moves: %v", err)
+			fs.GetLogger(ctx).Infof(fdst, "Server side directory move failed - fallback to file moves: %v", err)
 		case nil:
-			fs.Infof(fdst, "Server side directory move succeeded")
+			fs.GetLogger(ctx).Infof(fdst, "Server side directory move succeeded")
 			return nil
 		default:
 			err = fs.CountError(err)
-			fs.Errorf(fdst, "Server side directory move failed: %v", err)
+			fs.GetLogger(ctx).Errorf(fdst, "Server side directory move failed: %v", err)
 			return err
 		}
 	}
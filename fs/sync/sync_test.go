@@ -5,7 +5,11 @@ package sync
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -14,6 +18,7 @@ import (
 	_ "github.com/rclone/rclone/backend/all" // import all backends
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/hash"
@@ -69,6 +74,28 @@ func TestCopy(t *testing.T) {
 	fstest.CheckItems(t, r.Fremote, file1)
 }
 
+// Test copy with --adaptive-concurrency
+func TestCopyWithAdaptiveConcurrency(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("sub dir/hello world", "hello world", t1)
+	file2 := r.WriteFile("sub dir/hello world2", "hello world2", t2)
+	file3 := r.WriteFile("potato", "hello world3", t3)
+	r.WriteObject(ctx, "potato", "changed", t1)
+
+	ci.AdaptiveConcurrency = true
+	ci.Transfers = 2
+	ci.Checkers = 2
+
+	err := CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+
+	fstest.CheckItems(t, r.Flocal, file1, file2, file3)
+	fstest.CheckItems(t, r.Fremote, file1, file2, file3)
+}
+
 func TestCopyMissingDirectory(t *testing.T) {
 	ctx := context.Background()
 	r := fstest.NewRun(t)
@@ -1111,6 +1138,52 @@ func TestSyncWithMaxDuration(t *testing.T) {
 	require.True(t, accounting.GlobalStats().GetTransfers() < int64(len(testFiles)))
 }
 
+// Test with a max transfer duration and a soft cutoff lets the
+// transfers in progress finish
+func TestSyncWithMaxDurationGraceful(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	maxDuration := 250 * time.Millisecond
+	ci.MaxDuration = maxDuration
+	ci.CutoffMode = fs.CutoffModeSoft
+	bytesPerSecond := 300
+	accounting.SetBwLimit(fs.SizeSuffix(bytesPerSecond))
+	oldTransfers := ci.Transfers
+	ci.Transfers = 1
+	defer func() {
+		ci.MaxDuration = 0 // reset back to default
+		ci.CutoffMode = fs.CutoffModeHard
+		ci.Transfers = oldTransfers
+		accounting.SetBwLimit(fs.SizeSuffix(0))
+	}()
+
+	testFiles := make([]fstest.Item, 5)
+	for i := 0; i < len(testFiles); i++ {
+		testFiles[i] = r.WriteFile(fmt.Sprintf("file%d", i), "------------------------------------------------------------", t1)
+	}
+	fstest.CheckListing(t, r.Flocal, testFiles)
+
+	accounting.GlobalStats().ResetCounters()
+	err := Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Equal(t, ErrorMaxDurationReachedGraceful, err)
+	fserrors.Count(err)
+
+	// the transfer in progress at the deadline must have finished
+	entries, err := r.Fremote.List(ctx, "")
+	require.NoError(t, err)
+	assert.True(t, len(entries) > 0)
+	assert.True(t, len(entries) < len(testFiles))
+	for _, entry := range entries {
+		assert.Equal(t, int64(60), entry.Size(), entry.Remote())
+	}
+}
+
 // Test with TrackRenames set
 func TestSyncWithTrackRenames(t *testing.T) {
 	ctx := context.Background()
@@ -1163,6 +1236,7 @@ func TestParseRenamesStrategyModtime(t *testing.T) {
 		{"size", 0, false},
 		{"modtime,hash", trackRenamesStrategyModtime | trackRenamesStrategyHash, false},
 		{"hash,modtime,size", trackRenamesStrategyModtime | trackRenamesStrategyHash, false},
+		{"hash,inode,similar", trackRenamesStrategyHash | trackRenamesStrategyInode | trackRenamesStrategySimilar, false},
 		{"size,boom", 0, true},
 	} {
 		got, err := parseTrackRenamesStrategy(test.in)
@@ -1182,6 +1256,51 @@ func TestRenamesStrategyModtime(t *testing.T) {
 	assert.False(t, hash.modTime())
 	assert.False(t, modTime.hash())
 	assert.True(t, modTime.modTime())
+
+	similar := trackRenamesStrategySimilar
+	assert.True(t, similar.similar())
+	assert.True(t, similar.fallback())
+	assert.False(t, similar.exact())
+	assert.True(t, (similar | hash).exact())
+	assert.True(t, trackRenamesStrategy(0).exact())
+	assert.False(t, hash.fallback())
+}
+
+func TestPathSimilarity(t *testing.T) {
+	assert.Equal(t, 0, pathSimilarity("a/b", "a/c"))
+	assert.Equal(t, 1, pathSimilarity("a/b", "c/b"))
+	assert.Equal(t, 2, pathSimilarity("x/a/b", "y/a/b"))
+	assert.Equal(t, 2, pathSimilarity("a/b", "a/b"))
+	assert.Equal(t, 1, pathSimilarity("b", "a/b"))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("", ""))
+	assert.Equal(t, 3, editDistance("", "abc"))
+	assert.Equal(t, 3, editDistance("abc", ""))
+	assert.Equal(t, 0, editDistance("potato", "potato"))
+	assert.Equal(t, 1, editDistance("potato", "potatoe"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, 1, editDistance("café", "cafe"))
+}
+
+func TestLeafDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		distance int
+		ok       bool
+	}{
+		{"a/potato", "b/potato", 0, true},
+		{"potato.txt", "dir/potato (1).txt", 4, true},
+		{"potato.txt", "Potato.TXT", 4, false},
+		{"potato.txt", "tomato.txt", 2, true},
+		{"potato.txt", "yam.doc", 8, false},
+		{"a", "b", 1, false},
+	} {
+		distance, ok := leafDistance(test.a, test.b)
+		assert.Equal(t, test.distance, distance, test)
+		assert.Equal(t, test.ok, ok, test)
+	}
 }
 
 func TestSyncWithTrackRenamesStrategyModtime(t *testing.T) {
@@ -1264,6 +1383,91 @@ func TestSyncWithTrackRenamesStrategyLeaf(t *testing.T) {
 	}
 }
 
+func TestSyncWithTrackRenamesStrategySimilar(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	ci.TrackRenames = true
+	ci.TrackRenamesStrategy = "similar"
+
+	canTrackRenames := operations.CanServerSideMove(r.Fremote)
+	t.Logf("Can track renames: %v", canTrackRenames)
+
+	f1 := r.WriteFile("dir/a/potato", "Potato Content", t1)
+	f2 := r.WriteFile("dir/b/potato", "Tomato Content", t1)
+	f3 := r.WriteFile("dir/b/yam", "Yam Content", t2)
+	f4 := r.WriteFile("dir/b/carrot.txt", "Carrot Content", t2)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, f1, f2, f3, f4)
+
+	// Move the directory, change one of the files without
+	// changing its size and rename another one slightly
+	require.NoError(t, os.Rename(path.Join(r.LocalName, "dir"), path.Join(r.LocalName, "moved")))
+	require.NoError(t, os.Rename(path.Join(r.LocalName, "moved/b/carrot.txt"), path.Join(r.LocalName, "moved/b/carrot (1).txt")))
+	f1 = fstest.NewItem("moved/a/potato", "Potato Content", t1)
+	f2 = fstest.NewItem("moved/b/potato", "Tomato Content", t1)
+	f3 = r.WriteFile("moved/b/yam", "Ham Content", t3)
+	f4 = fstest.NewItem("moved/b/carrot (1).txt", "Carrot Content", t2)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, f1, f2, f3, f4)
+
+	if canTrackRenames {
+		assert.Equal(t, int64(4), accounting.GlobalStats().Renames(0))
+		// only the changed file was uploaded
+		assert.Equal(t, toyFileTransfers(r), accounting.GlobalStats().GetTransfers())
+	}
+}
+
+func TestSyncWithTrackRenamesStrategyInode(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	cacheDir, err := ioutil.TempDir("", "rclone-track-renames")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(cacheDir)
+	}()
+	oldCacheDir := config.CacheDir
+	config.CacheDir = cacheDir
+	defer func() {
+		config.CacheDir = oldCacheDir
+	}()
+
+	ci.TrackRenames = true
+	ci.TrackRenamesStrategy = "inode"
+
+	canTrackRenames := operations.CanServerSideMove(r.Fremote) && runtime.GOOS != "windows"
+	t.Logf("Can track renames: %v", canTrackRenames)
+
+	f1 := r.WriteFile("potato", "Potato Content", t1)
+	f2 := r.WriteFile("sub/yam", "Yam Content", t2)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, f1, f2)
+
+	// Rename to completely different names and change a file
+	f1 = r.RenameFile(f1, "carrot")
+	r.RenameFile(f2, "sub/parsnip")
+	f2 = r.WriteFile("sub/parsnip", "Parsnip Content", t3)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	fstest.CheckItems(t, r.Fremote, f1, f2)
+
+	if canTrackRenames {
+		assert.Equal(t, int64(2), accounting.GlobalStats().Renames(0))
+	}
+}
+
 func toyFileTransfers(r *fstest.Run) int64 {
 	remote := r.Fremote.Name()
 	transfers := 1
@@ -1860,6 +2064,117 @@ func testSyncSuffix(t *testing.T, suffix string, suffixKeepExtension bool) {
 func TestSyncSuffix(t *testing.T)              { testSyncSuffix(t, ".bak", false) }
 func TestSyncSuffixKeepExtension(t *testing.T) { testSyncSuffix(t, "-2019-01-01", true) }
 
+// Test with --backup-versioning
+func TestSyncBackupVersioning(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	if !operations.CanServerSideMove(r.Fremote) {
+		t.Skip("Skipping test as remote does not support server-side move")
+	}
+	ci.BackupVersioning = true
+
+	file1 := r.WriteFile("one", "oneA", t2)
+	r.WriteObject(ctx, "one", "one", t1)
+	r.WriteObject(ctx, "two", "two", t1)
+
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+
+	// one was overwritten and two deleted so both are kept as versions
+	obj, err := r.Fremote.NewObject(ctx, "one")
+	require.NoError(t, err)
+	assert.Equal(t, file1.Size, obj.Size())
+	_, err = r.Fremote.NewObject(ctx, "two")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	versions, err := operations.ListVersions(ctx, r.Fremote, "", true)
+	require.NoError(t, err)
+	assert.Len(t, versions["one"], 1)
+	assert.Len(t, versions["two"], 1)
+
+	// The versions aren't deleted by the next sync
+	r.WriteFile("one", "oneBB", t3)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	versions, err = operations.ListVersions(ctx, r.Fremote, "", true)
+	require.NoError(t, err)
+	assert.Len(t, versions["one"], 2)
+	assert.Len(t, versions["two"], 1)
+
+	// Can't be used with --suffix
+	ci.Suffix = ".bak"
+	accounting.GlobalStats().ResetCounters()
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+}
+
+func TestSyncImmutableDest(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	ci.ImmutableDest = true
+
+	// list the names in the remote with the versions and tombstones
+	// replaced with "version of" and "tombstone of"
+	list := func() (names []string) {
+		entries, err := r.Fremote.List(ctx, "")
+		require.NoError(t, err)
+		for _, entry := range entries {
+			remote, when, tombstone := operations.ParseImmutableName(ctx, entry.Remote())
+			switch {
+			case tombstone:
+				remote = "tombstone of " + remote
+			case !when.IsZero():
+				remote = "version of " + remote
+			}
+			names = append(names, remote)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	r.WriteFile("one", "one", t1)
+	r.WriteFile("two", "two", t1)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, []string{"one", "two"}, list())
+
+	// a changed file is written as a new version and a deleted file
+	// gets a tombstone
+	file1 := r.WriteFile("one", "oneBB", t2)
+	obj, err := r.Flocal.NewObject(ctx, "two")
+	require.NoError(t, err)
+	require.NoError(t, obj.Remove(ctx))
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, []string{"one", "tombstone of two", "two", "version of one"}, list())
+	obj, err = r.Fremote.NewObject(ctx, "one")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), obj.Size(), "original overwritten")
+
+	// syncing again compares with the latest versions so does nothing
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetTransfers())
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetDeletes())
+	assert.Equal(t, []string{"one", "tombstone of two", "two", "version of one"}, list())
+
+	// a deleted file which comes back is written as a new version
+	r.WriteFile("two", "twoCCC", t3)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetTransfers())
+	assert.Equal(t, []string{"one", "tombstone of two", "two", "version of one", "version of two"}, list())
+	fstest.CheckItems(t, r.Flocal, file1, fstest.NewItem("two", "twoCCC", t3))
+
+	// Can't be used with --backup-dir
+	ci.BackupVersioning = true
+	accounting.GlobalStats().ResetCounters()
+	assert.Error(t, Sync(ctx, r.Fremote, r.Flocal, false))
+}
+
 // Check we can sync two files with differing UTF-8 representations
 func TestSyncUTFNorm(t *testing.T) {
 	ctx := context.Background()
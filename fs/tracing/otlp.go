@@ -0,0 +1,301 @@
+// Export spans using the OTLP/HTTP protocol with JSON encoding
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	maxQueuedSpans = 4096             // drop spans if more than this are waiting for export
+	maxBatchSpans  = 512              // export at most this many spans in one request
+	exportInterval = 5 * time.Second  // export queued spans this often
+	exportTimeout  = 30 * time.Second // timeout for each export request
+	exportRetries  = 3                // number of tries for each export request
+)
+
+// otlpExporter batches up ended spans and POSTs them to an OTLP
+// collector in the background.
+type otlpExporter struct {
+	url         string
+	serviceName string
+	headers     http.Header
+	client      *http.Client
+	spans       chan *Span
+	flush       chan chan struct{}
+	done        chan struct{}
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	dropped     int
+}
+
+// newOTLPExporter makes a new exporter and starts it running
+func newOTLPExporter(opt *Options) *otlpExporter {
+	e := &otlpExporter{
+		url:         opt.URL,
+		serviceName: opt.ServiceName,
+		headers:     make(http.Header),
+		// Don't use fshttp here as its requests are traced
+		client: &http.Client{
+			Timeout: exportTimeout,
+		},
+		spans: make(chan *Span, maxQueuedSpans),
+		flush: make(chan chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, header := range opt.Headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			fs.Errorf(nil, "Ignoring invalid trace header %q - expecting \"Key: Value\"", header)
+			continue
+		}
+		e.headers.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// export queues span for exporting dropping it if the queue is full
+func (e *otlpExporter) export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// run the exporter until shutdown
+func (e *otlpExporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSpans {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-e.flush:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+				if len(batch) >= maxBatchSpans {
+					send()
+				}
+			}
+			send()
+			close(flushed)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// shutdown exports any queued spans and stops the exporter
+func (e *otlpExporter) shutdown() {
+	flushed := make(chan struct{})
+	e.flush <- flushed
+	<-flushed
+	close(e.done)
+	e.wg.Wait()
+	e.mu.Lock()
+	dropped := e.dropped
+	e.mu.Unlock()
+	if dropped > 0 {
+		fs.Errorf(nil, "Dropped %d trace spans as the exporter couldn't keep up", dropped)
+	}
+}
+
+// send the batch of spans to the collector, retrying on failure
+func (e *otlpExporter) send(batch []*Span) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		fs.Errorf(nil, "Failed to encode trace spans: %v", err)
+		return
+	}
+	for try := 1; try <= exportRetries; try++ {
+		err = e.post(body)
+		if err == nil {
+			return
+		}
+		fs.Debugf(nil, "Failed to export %d trace spans (try %d/%d): %v", len(batch), try, exportRetries, err)
+		time.Sleep(time.Duration(try) * time.Second)
+	}
+	fs.Errorf(nil, "Failed to export %d trace spans: %v", len(batch), err)
+}
+
+// post body to the collector
+func (e *otlpExporter) post(body []byte) (err error) {
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("HTTP error %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding - see
+// https://github.com/open-telemetry/opentelemetry-proto
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// encodeValue converts value into an OTLP attribute value
+func encodeValue(value interface{}) (v otlpAnyValue) {
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		s := strconv.FormatInt(int64(x), 10)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return v
+}
+
+// encodeTime converts t into OTLP nanoseconds since the epoch
+func encodeTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// encode the batch of spans as an OTLP traces export request
+func (e *otlpExporter) encode(batch []*Span) *otlpTraces {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		span.mu.Lock()
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: encodeTime(span.start),
+			EndTimeUnixNano:   encodeTime(span.end),
+			Status: otlpStatus{
+				Code:    span.status,
+				Message: span.message,
+			},
+		}
+		if span.parentID != (SpanID{}) {
+			out.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		for _, attr := range span.attributes {
+			out.Attributes = append(out.Attributes, otlpKeyValue{Key: attr.key, Value: encodeValue(attr.value)})
+		}
+		span.mu.Unlock()
+		spans = append(spans, out)
+	}
+	serviceName := e.serviceName
+	version := fs.Version
+	return &otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{
+					{Key: "service.name", Value: otlpAnyValue{StringValue: &serviceName}},
+					{Key: "service.version", Value: otlpAnyValue{StringValue: &version}},
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{
+					Name:    "github.com/rclone/rclone",
+					Version: fs.Version,
+				},
+				Spans: spans,
+			}},
+		}},
+	}
+}
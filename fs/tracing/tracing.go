@@ -0,0 +1,228 @@
+// Package tracing provides tracing of rclone operations as spans
+// which can be exported with OTLP to OpenTelemetry collectors.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Options contains options for controlling the tracing
+type Options struct {
+	URL         string   // POST spans in OTLP/HTTP JSON format to this URL
+	ServiceName string   // service.name resource attribute
+	Headers     []string // extra HTTP headers to send as "Key: Value"
+}
+
+// DefaultOpt is the default values used for Opt
+var DefaultOpt = Options{
+	ServiceName: "rclone",
+}
+
+// Opt is the options for tracing
+var Opt = DefaultOpt
+
+// Kinds of span as defined by OpenTelemetry
+const (
+	KindInternal = 1 // an internal operation
+	KindClient   = 3 // a request to a remote service
+)
+
+// statusError is the OpenTelemetry status code for a failed span
+const statusError = 2
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// attribute is a key value pair attached to a span
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Span records a single timed operation within a trace.
+//
+// All the methods may be called on a nil *Span which is what Start
+// returns when tracing is disabled.
+type Span struct {
+	mu         sync.Mutex
+	traceID    TraceID
+	spanID     SpanID
+	parentID   SpanID
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes []attribute
+	status     int
+	message    string
+	ended      bool
+}
+
+type spanContextKey struct{}
+
+// the exporter in use or nil if tracing is disabled
+var exporter *otlpExporter
+
+// Enabled returns true if tracing is enabled
+func Enabled() bool {
+	return exporter != nil
+}
+
+// newID fills id with random bytes
+func newID(id []byte) {
+	_, _ = rand.Read(id)
+}
+
+// FromContext returns the current span in ctx or nil if there isn't
+// one
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// Start a span called name of kind as a child of the span in ctx if
+// any.
+//
+// It returns a context containing the new span which should be passed
+// to any operations it is the parent of.  Call End on the span when
+// the operation is finished.
+//
+// If tracing is disabled it returns ctx unchanged and a nil *Span.
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if exporter == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else if remoteParent.ok {
+		span.traceID = remoteParent.traceID
+		span.parentID = remoteParent.parentID
+	} else {
+		newID(span.traceID[:])
+	}
+	newID(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SetAttribute sets key to value on the span
+//
+// value should be a string, bool, integer or float - anything else
+// will be converted to a string.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes = append(s.attributes, attribute{key: key, value: value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with message
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status = statusError
+	s.message = message
+	s.mu.Unlock()
+}
+
+// End finishes the span marking it as failed if err is not nil and
+// queues it for export.
+//
+// Calling End more than once has no effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.status = statusError
+		s.message = err.Error()
+	}
+	s.mu.Unlock()
+	if exporter != nil {
+		exporter.export(s)
+	}
+}
+
+// TraceParent returns the W3C traceparent header value for the span
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// parseTraceParent parses a W3C traceparent header value
+func parseTraceParent(traceParent string) (traceID TraceID, spanID SpanID, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, traceID != TraceID{}
+}
+
+// remoteParent is the parent of all the top level spans if rclone
+// was started with a TRACEPARENT environment variable.
+var remoteParent struct {
+	traceID  TraceID
+	parentID SpanID
+	ok       bool
+}
+
+// InitTracing starts tracing as per the command line flags
+func InitTracing() {
+	if Opt.URL == "" {
+		return
+	}
+	if traceParent := os.Getenv("TRACEPARENT"); traceParent != "" {
+		traceID, spanID, ok := parseTraceParent(traceParent)
+		if ok {
+			remoteParent.traceID, remoteParent.parentID, remoteParent.ok = traceID, spanID, true
+		} else {
+			fs.Errorf(nil, "Ignoring invalid TRACEPARENT %q", traceParent)
+		}
+	}
+	exporter = newOTLPExporter(&Opt)
+	fs.Debugf(nil, "Exporting traces to %q", Opt.URL)
+}
+
+// Shutdown flushes any pending spans and stops tracing
+func Shutdown() {
+	if exporter == nil {
+		return
+	}
+	exporter.shutdown()
+	exporter = nil
+}
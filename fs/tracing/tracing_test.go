@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartDisabled(t *testing.T) {
+	ctx := context.Background()
+	newCtx, span := Start(ctx, "potato", KindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, newCtx)
+	// methods on nil span must not crash
+	span.SetAttribute("key", "value")
+	span.SetError("oops")
+	span.End(nil)
+	assert.Equal(t, "", span.TraceParent())
+}
+
+func TestParseTraceParent(t *testing.T) {
+	traceID, spanID, ok := parseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.True(t, ok)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", hex.EncodeToString(traceID[:]))
+	assert.Equal(t, "b7ad6b7169203331", hex.EncodeToString(spanID[:]))
+
+	for _, bad := range []string{
+		"",
+		"potato",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319X-b7ad6b7169203331-01",
+	} {
+		_, _, ok = parseTraceParent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var got otlpTraces
+	var gotHeader string
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		gotHeader = r.Header.Get("X-Potato")
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &got))
+	}))
+	defer ts.Close()
+
+	exporter = newOTLPExporter(&Options{
+		URL:         ts.URL,
+		ServiceName: "test",
+		Headers:     []string{"X-Potato: Jersey Royal"},
+	})
+
+	ctx, parent := Start(context.Background(), "parent", KindInternal)
+	parent.SetAttribute("size", int64(42))
+	_, child := Start(ctx, "child", KindClient)
+	child.SetAttribute("ok", true)
+	child.End(errors.New("boom"))
+	parent.End(nil)
+	Shutdown()
+
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, "Jersey Royal", gotHeader)
+	require.Equal(t, 1, len(got.ResourceSpans))
+	resource := got.ResourceSpans[0]
+	assert.Equal(t, "service.name", resource.Resource.Attributes[0].Key)
+	assert.Equal(t, "test", *resource.Resource.Attributes[0].Value.StringValue)
+	spans := resource.ScopeSpans[0].Spans
+	require.Equal(t, 2, len(spans))
+
+	childSpan, parentSpan := spans[0], spans[1]
+	assert.Equal(t, "child", childSpan.Name)
+	assert.Equal(t, KindClient, childSpan.Kind)
+	assert.Equal(t, statusError, childSpan.Status.Code)
+	assert.Equal(t, "boom", childSpan.Status.Message)
+	assert.Equal(t, "ok", childSpan.Attributes[0].Key)
+	assert.Equal(t, true, *childSpan.Attributes[0].Value.BoolValue)
+
+	assert.Equal(t, "parent", parentSpan.Name)
+	assert.Equal(t, "", parentSpan.ParentSpanID)
+	assert.Equal(t, 0, parentSpan.Status.Code)
+	assert.Equal(t, "42", *parentSpan.Attributes[0].Value.IntValue)
+
+	assert.Equal(t, parentSpan.TraceID, childSpan.TraceID)
+	assert.Equal(t, parentSpan.SpanID, childSpan.ParentSpanID)
+}
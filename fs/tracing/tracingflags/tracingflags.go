@@ -0,0 +1,17 @@
+// Package tracingflags implements command line flags to set up tracing
+package tracingflags
+
+import (
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/tracing"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the tracing flags to the flagSet
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("tracing", &tracing.Opt)
+	flags.StringVarP(flagSet, &tracing.Opt.URL, "trace-otlp-url", "", tracing.Opt.URL, "Export traces to this OTLP/HTTP URL, e.g. http://localhost:4318/v1/traces")
+	flags.StringVarP(flagSet, &tracing.Opt.ServiceName, "trace-service-name", "", tracing.Opt.ServiceName, "Service name to report in traces")
+	flags.StringArrayVarP(flagSet, &tracing.Opt.Headers, "trace-otlp-header", "", tracing.Opt.Headers, "Set HTTP header for trace exports, e.g. \"Authorization: Bearer XXX\"")
+}
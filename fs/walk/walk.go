@@ -11,6 +11,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/dirtree"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/list"
@@ -287,6 +288,8 @@ func listR(ctx context.Context, f fs.Fs, path string, includeAll bool, listType
 		dm = newDirMap(path)
 	}
 	var mu sync.Mutex
+	// Not timed as a list call since it lists the whole tree
+	doneListR := accounting.StartCall()
 	err := doListR(ctx, path, func(entries fs.DirEntries) (err error) {
 		if synthesizeDirs {
 			err = dm.addEntries(entries)
@@ -322,6 +325,7 @@ func listR(ctx context.Context, f fs.Fs, path string, includeAll bool, listType
 		defer mu.Unlock()
 		return fn(entries)
 	})
+	doneListR()
 	if err != nil {
 		return err
 	}
@@ -369,7 +373,8 @@ func walk(ctx context.Context, f fs.Fs, path string, includeAll bool, maxLevel i
 		depth  int
 	}
 
-	in := make(chan listJob, ci.Checkers)
+	listWorkers := ci.ListWorkerCount()
+	in := make(chan listJob, listWorkers)
 	errs := make(chan error, 1)
 	quit := make(chan struct{})
 	closeQuit := func() {
@@ -382,7 +387,7 @@ func walk(ctx context.Context, f fs.Fs, path string, includeAll bool, maxLevel i
 			}()
 		})
 	}
-	for i := 0; i < ci.Checkers; i++ {
+	for i := 0; i < listWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
@@ -296,6 +297,47 @@ a/b/c/d/
 `, entries.String())
 }
 
+func TestWalkListWorkers(t *testing.T) {
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.ListWorkers = 3
+
+	var (
+		mu            sync.Mutex
+		running       int
+		maxRunning    int
+		root          fs.DirEntries
+		listed        = map[string]bool{}
+		expectedCalls = 11
+	)
+	for i := 0; i < expectedCalls-1; i++ {
+		root = append(root, mockdir.New(fmt.Sprintf("dir%d", i)))
+	}
+	listDir := func(ctx context.Context, f fs.Fs, includeAll bool, dir string) (fs.DirEntries, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		listed[dir] = true
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if dir == "" {
+			return root, nil
+		}
+		return nil, nil
+	}
+	fn := func(dirPath string, entries fs.DirEntries, err error) error {
+		return err
+	}
+	require.NoError(t, walk(ctx, nil, "", true, -1, fn, listDir))
+	assert.Len(t, listed, expectedCalls)
+	assert.LessOrEqual(t, maxRunning, 3)
+	assert.Greater(t, maxRunning, 1)
+}
+
 func testWalkLevelsNoRecursive(t *testing.T) *listDirs {
 	da := mockdir.New("a")
 	oA := mockobject.Object("A")
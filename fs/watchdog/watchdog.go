@@ -0,0 +1,133 @@
+// Package watchdog detects when rclone has stopped making progress
+// and logs what it is doing so the cause can be found.
+package watchdog
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+)
+
+// Options contains options for controlling the watchdog
+type Options struct {
+	Timeout time.Duration // Fire if no progress has been made for this long, 0 to disable
+	Abort   bool          // Exit with an error when the watchdog fires
+}
+
+// DefaultOpt is the default values used for Opt
+var DefaultOpt = Options{}
+
+// Opt is the options for the watchdog
+var Opt = DefaultOpt
+
+// progress is a snapshot of the counters which show progress
+type progress struct {
+	bytes     int64
+	checks    int64
+	transfers int64
+	errors    int64
+	calls     int64
+}
+
+// readProgress reads the progress and whether there is anything in
+// progress which should be making some.
+func readProgress() (p progress, busy bool) {
+	stats := accounting.GlobalStats()
+	inFlight, done := accounting.Calls()
+	p = progress{
+		bytes:     stats.GetBytes(),
+		checks:    stats.GetChecks(),
+		transfers: stats.GetTransfers(),
+		errors:    stats.GetErrors(),
+		calls:     done,
+	}
+	return p, inFlight > 0 || stats.InProgress()
+}
+
+// watchdog checks progress is being made
+type watchdog struct {
+	timeout    time.Duration
+	read       func() (progress, bool)
+	fire       func(stalled time.Duration)
+	last       progress
+	lastChange time.Time
+	fired      bool
+}
+
+// check progress has been made at now firing the watchdog if not.
+//
+// It only fires once for each stall.
+func (w *watchdog) check(now time.Time) {
+	p, busy := w.read()
+	if !busy || p != w.last || w.lastChange.IsZero() {
+		w.last = p
+		w.lastChange = now
+		w.fired = false
+		return
+	}
+	stalled := now.Sub(w.lastChange)
+	if stalled >= w.timeout && !w.fired {
+		w.fired = true
+		w.fire(stalled)
+	}
+}
+
+// stacks returns the stacks of all the goroutines
+func stacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// report logs the state of rclone when the watchdog fires
+func report(stalled time.Duration) {
+	fs.Errorf(nil, "Watchdog: no progress has been made for %v", stalled.Truncate(time.Second))
+	fs.Errorf(nil, "Watchdog: stats:%s", accounting.GlobalStats().String())
+	fs.Errorf(nil, "Watchdog: goroutines:\n%s", stacks())
+}
+
+// Start the watchdog if configured.
+//
+// If Opt.Abort is set then abort is called after the watchdog has
+// reported the stall.
+func Start(ctx context.Context, abort func()) {
+	if Opt.Timeout <= 0 {
+		return
+	}
+	w := &watchdog{
+		timeout: Opt.Timeout,
+		read:    readProgress,
+		fire: func(stalled time.Duration) {
+			report(stalled)
+			if Opt.Abort {
+				fs.Errorf(nil, "Watchdog: aborting")
+				abort()
+			}
+		},
+	}
+	interval := Opt.Timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	fs.Debugf(nil, "Watchdog: started with timeout %v", Opt.Timeout)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				w.check(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	var (
+		p       progress
+		busy    = true
+		firings []time.Duration
+	)
+	w := &watchdog{
+		timeout: time.Minute,
+		read: func() (progress, bool) {
+			return p, busy
+		},
+		fire: func(stalled time.Duration) {
+			firings = append(firings, stalled)
+		},
+	}
+	start := time.Now()
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	w.check(at(0))
+	w.check(at(30 * time.Second))
+	assert.Empty(t, firings)
+
+	// Progress resets the timer
+	p.bytes = 100
+	w.check(at(50 * time.Second))
+	w.check(at(100 * time.Second))
+	assert.Empty(t, firings)
+
+	// No progress for the timeout fires once only
+	w.check(at(110 * time.Second))
+	w.check(at(120 * time.Second))
+	assert.Equal(t, []time.Duration{time.Minute}, firings)
+
+	// Progress then a new stall fires again
+	p.calls++
+	w.check(at(130 * time.Second))
+	w.check(at(200 * time.Second))
+	assert.Equal(t, []time.Duration{time.Minute, 70 * time.Second}, firings)
+
+	// Not busy doesn't fire
+	firings = nil
+	busy = false
+	w.check(at(300 * time.Second))
+	w.check(at(500 * time.Second))
+	assert.Empty(t, firings)
+}
+
+func TestStacks(t *testing.T) {
+	assert.Contains(t, string(stacks()), "TestStacks")
+}
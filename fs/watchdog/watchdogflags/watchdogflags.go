@@ -0,0 +1,16 @@
+// Package watchdogflags implements command line flags to set up the watchdog
+package watchdogflags
+
+import (
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/watchdog"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the watchdog flags to the flagSet
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("watchdog", &watchdog.Opt)
+	flags.DurationVarP(flagSet, &watchdog.Opt.Timeout, "watchdog", "", watchdog.Opt.Timeout, "Dump the goroutines and transfers if no progress is made for this long (0 to disable)")
+	flags.BoolVarP(flagSet, &watchdog.Opt.Abort, "watchdog-abort", "", watchdog.Opt.Abort, "Exit with an error when the --watchdog fires")
+}
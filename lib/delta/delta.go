@@ -0,0 +1,267 @@
+// Package delta implements the rsync rolling checksum algorithm to
+// find the parts of a file which are the same as an older version of
+// it.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"io"
+	"math"
+)
+
+// Limits for the block size chosen by BlockSize
+const (
+	MinBlockSize = 1024
+	MaxBlockSize = 128 * 1024
+)
+
+// BlockSize returns the block size to use for a file of size bytes.
+//
+// Like rsync this is about the square root of the size so the number
+// of blocks and the size of the blocks grow together.
+func BlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size)))
+	blockSize = (blockSize + MinBlockSize - 1) / MinBlockSize * MinBlockSize
+	if blockSize < MinBlockSize {
+		blockSize = MinBlockSize
+	}
+	if blockSize > MaxBlockSize {
+		blockSize = MaxBlockSize
+	}
+	return blockSize
+}
+
+// Block is the checksums of one block of the old file
+type Block struct {
+	Weak   uint32         // rolling checksum of the block
+	Strong [md5.Size]byte // MD5 of the block
+}
+
+// Signature is the checksums of the blocks of the old file
+type Signature struct {
+	BlockSize int     // size of each block - the last may be shorter
+	Size      int64   // size of the old file
+	Blocks    []Block // checksums of each block
+}
+
+// Op is a part of the new file found by Diff
+type Op struct {
+	Offset int64  // offset of Data in the new file
+	Block  int    // index of the block of the old file Data is the same as or -1 if none
+	Data   []byte // the data - only valid until the callback returns
+}
+
+// rollsum is the rsync weak checksum of a window of data which can be
+// moved along the data one byte at a time
+type rollsum struct {
+	a, b uint32
+	n    uint32 // number of bytes in the window
+}
+
+// init sets the checksum to that of p
+func (r *rollsum) init(p []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(p))
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += uint32(len(p)-i) * uint32(c)
+	}
+}
+
+// roll moves the window along one byte removing out and adding in
+func (r *rollsum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+// rollOut removes out from the start of the window
+func (r *rollsum) rollOut(out byte) {
+	r.a -= uint32(out)
+	r.b -= r.n * uint32(out)
+	r.n--
+}
+
+// sum returns the checksum
+func (r *rollsum) sum() uint32 {
+	return (r.a & 0xffff) | (r.b << 16)
+}
+
+// NewSignature reads the old file from in and returns the checksums
+// of its blocks of blockSize bytes.
+func NewSignature(in io.Reader, blockSize int) (*Signature, error) {
+	sig := &Signature{
+		BlockSize: blockSize,
+	}
+	buf := make([]byte, blockSize)
+	var rs rollsum
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			rs.init(buf[:n])
+			sig.Blocks = append(sig.Blocks, Block{
+				Weak:   rs.sum(),
+				Strong: md5.Sum(buf[:n]),
+			})
+			sig.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// blockLen returns the size of block i of the old file
+func (sig *Signature) blockLen(i int) int {
+	blockLen := sig.Size - int64(i)*int64(sig.BlockSize)
+	if blockLen > int64(sig.BlockSize) {
+		return sig.BlockSize
+	}
+	return int(blockLen)
+}
+
+// InPlace returns true if op is a block of the old file at the same
+// offset in the new file, so doesn't need writing if the old file is
+// updated in place.
+func (sig *Signature) InPlace(op Op) bool {
+	return op.Block >= 0 && int64(op.Block)*int64(sig.BlockSize) == op.Offset
+}
+
+// match returns the index of the block of the old file which window
+// at offset in the new file is the same as, or -1 if none.  It
+// prefers the block at the same offset if there is more than one.
+func (sig *Signature) match(index map[uint32][]int, weak uint32, window []byte, offset int64) int {
+	candidates := index[weak]
+	if len(candidates) == 0 {
+		return -1
+	}
+	var (
+		strong   [md5.Size]byte
+		gotSum   bool
+		matching = -1
+	)
+	for _, i := range candidates {
+		if sig.blockLen(i) != len(window) {
+			continue
+		}
+		if !gotSum {
+			strong = md5.Sum(window)
+			gotSum = true
+		}
+		if !bytes.Equal(strong[:], sig.Blocks[i].Strong[:]) {
+			continue
+		}
+		if int64(i)*int64(sig.BlockSize) == offset {
+			return i
+		}
+		if matching < 0 {
+			matching = i
+		}
+	}
+	return matching
+}
+
+// Diff reads the new file from in and calls fn with each part of it
+// in order, either a block which is the same as one in the old file
+// or data which isn't in the old file.  The data not in the old file
+// is passed in parts of at most BlockSize bytes.
+//
+// If fn returns an error Diff stops and returns it.
+func (sig *Signature) Diff(in io.Reader, fn func(op Op) error) error {
+	blockSize := sig.BlockSize
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, block := range sig.Blocks {
+		index[block.Weak] = append(index[block.Weak], i)
+	}
+	var (
+		br         = bufio.NewReaderSize(in, 4*blockSize)
+		buf        = make([]byte, 2*blockSize) // the window is buf[start:end]
+		start, end int
+		offset     int64 // offset of the window in the new file
+		literal    = make([]byte, 0, blockSize)
+		rs         rollsum
+		eof        bool
+	)
+	// fill reads a new window
+	fill := func() error {
+		n, err := io.ReadFull(br, buf[:blockSize])
+		start, end = 0, n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+			err = nil
+		}
+		rs.init(buf[:end])
+		return err
+	}
+	// flush sends the data not in the old file
+	flush := func() error {
+		if len(literal) == 0 {
+			return nil
+		}
+		err := fn(Op{Offset: offset - int64(len(literal)), Block: -1, Data: literal})
+		literal = literal[:0]
+		return err
+	}
+	err := fill()
+	if err != nil {
+		return err
+	}
+	for end > start {
+		window := buf[start:end]
+		if i := sig.match(index, rs.sum(), window, offset); i >= 0 {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			err = fn(Op{Offset: offset, Block: i, Data: window})
+			if err != nil {
+				return err
+			}
+			offset += int64(len(window))
+			if eof {
+				break
+			}
+			err = fill()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Move the window along a byte
+		out := buf[start]
+		literal = append(literal, out)
+		start++
+		offset++
+		rolled := false
+		if !eof {
+			c, err := br.ReadByte()
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			} else {
+				if end == len(buf) {
+					end = copy(buf, buf[start:end])
+					start = 0
+				}
+				buf[end] = c
+				end++
+				rs.roll(out, c)
+				rolled = true
+			}
+		}
+		if !rolled {
+			rs.rollOut(out)
+		}
+		if len(literal) >= blockSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
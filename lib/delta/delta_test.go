@@ -0,0 +1,104 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSize(t *testing.T) {
+	assert.Equal(t, MinBlockSize, BlockSize(0))
+	assert.Equal(t, MinBlockSize, BlockSize(1000))
+	assert.Equal(t, 10*1024, BlockSize(10000*10000))
+	assert.Equal(t, MaxBlockSize, BlockSize(50<<30))
+}
+
+func TestRollsum(t *testing.T) {
+	data := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(data)
+	var rolled, direct rollsum
+	rolled.init(data[:10])
+	for i := 10; i < len(data); i++ {
+		rolled.roll(data[i-10], data[i])
+		direct.init(data[i-9 : i+1])
+		require.Equal(t, direct.sum(), rolled.sum(), "at %d", i)
+	}
+	for i := len(data) - 10; i < len(data)-1; i++ {
+		rolled.rollOut(data[i])
+		direct.init(data[i+1:])
+		require.Equal(t, direct.sum(), rolled.sum(), "at %d", i)
+	}
+}
+
+// diff runs Diff and rebuilds the new file from the ops, checking
+// the blocks claimed to match old do.  It returns the number of bytes
+// which would need writing to update old in place.
+func diff(t *testing.T, blockSize int, old, new []byte) (written int) {
+	sig, err := NewSignature(bytes.NewReader(old), blockSize)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(old)), sig.Size)
+	var rebuilt []byte
+	err = sig.Diff(bytes.NewReader(new), func(op Op) error {
+		require.Equal(t, int64(len(rebuilt)), op.Offset)
+		if op.Block >= 0 {
+			start := op.Block * blockSize
+			assert.Equal(t, old[start:start+sig.blockLen(op.Block)], op.Data)
+		} else {
+			assert.LessOrEqual(t, len(op.Data), blockSize)
+		}
+		if !sig.InPlace(op) {
+			written += len(op.Data)
+		}
+		rebuilt = append(rebuilt, op.Data...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, new, rebuilt)
+	return written
+}
+
+func TestDiff(t *testing.T) {
+	const blockSize = 16
+	old := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(old)
+	clone := func() []byte {
+		return append([]byte(nil), old...)
+	}
+
+	assert.Equal(t, 0, diff(t, blockSize, old, old))
+	assert.Equal(t, 0, diff(t, blockSize, old, old[:992]))
+	assert.Equal(t, len(old), diff(t, blockSize, nil, old))
+	assert.Equal(t, 0, diff(t, blockSize, old, nil))
+
+	changed := clone()
+	changed[500] ^= 0xFF
+	assert.Equal(t, blockSize, diff(t, blockSize, old, changed))
+
+	longer := append(clone(), "potato"...)
+	assert.Equal(t, 8+6, diff(t, blockSize, old, longer))
+
+	// An insertion means everything after it moves
+	inserted := append([]byte("X"), old...)
+	assert.Equal(t, len(inserted), diff(t, blockSize, old, inserted))
+
+	removed := append(clone()[:100], old[101:]...)
+	assert.Equal(t, len(removed)-96, diff(t, blockSize, old, removed))
+
+	different := make([]byte, 1000)
+	rand.New(rand.NewSource(2)).Read(different)
+	assert.Equal(t, len(different), diff(t, blockSize, old, different))
+}
+
+func TestDiffError(t *testing.T) {
+	sig, err := NewSignature(bytes.NewReader([]byte("hello")), 4)
+	require.NoError(t, err)
+	boom := errors.New("boom")
+	err = sig.Diff(bytes.NewReader([]byte("hello")), func(op Op) error {
+		return boom
+	})
+	assert.Equal(t, boom, err)
+}
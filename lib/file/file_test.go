@@ -152,3 +152,24 @@ func TestOpenFileOperations(t *testing.T) {
 	})
 
 }
+
+// Test PunchHole makes the bytes read as zeros
+func TestPunchHole(t *testing.T) {
+	dir, tidy := testDir(t)
+	defer tidy()
+
+	filepath := path.Join(dir, "file1")
+	f, err := Create(filepath)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("hello world"))
+	require.NoError(t, err)
+
+	require.NoError(t, PunchHole(f, 2, 0))
+	require.NoError(t, PunchHole(f, 2, 3))
+	require.NoError(t, f.Close())
+
+	contents, err := ioutil.ReadFile(filepath)
+	require.NoError(t, err)
+	assert.Equal(t, "he\x00\x00\x00 world", string(contents))
+}
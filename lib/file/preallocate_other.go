@@ -21,3 +21,16 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole deallocates the space.
+const PunchHoleImplemented = false
+
+// PunchHole makes size bytes of out at offset read as zeros, without
+// changing the size of the file.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return writeZeros(out, offset, size)
+}
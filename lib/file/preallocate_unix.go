@@ -57,3 +57,24 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole deallocates the space.
+const PunchHoleImplemented = true
+
+// PunchHole deallocates size bytes of out at offset so they read as
+// zeros, without changing the size of the file.
+//
+// If the file system doesn't support deallocating then zeros are
+// written instead.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := unix.Fallocate(int(out.Fd()), unix.FALLOC_FL_KEEP_SIZE|unix.FALLOC_FL_PUNCH_HOLE, offset, size)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		fs.Debugf(nil, "punchHole: fallocate not supported, writing zeros instead: %v", err)
+		return writeZeros(out, offset, size)
+	}
+	return err
+}
@@ -83,7 +83,8 @@ func PreAllocate(size int64, out *os.File) error {
 }
 
 const (
-	FSCTL_SET_SPARSE = 0x000900c4
+	FSCTL_SET_SPARSE    = 0x000900c4
+	FSCTL_SET_ZERO_DATA = 0x000980c8
 )
 
 // SetSparseImplemented is a constant indicating whether the
@@ -99,3 +100,33 @@ func SetSparse(out *os.File) error {
 	}
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole deallocates the space.
+const PunchHoleImplemented = true
+
+type fileZeroDataInformation struct {
+	FileOffset      int64
+	BeyondFinalZero int64
+}
+
+// PunchHole deallocates size bytes of out at offset so they read as
+// zeros, without changing the size of the file.
+//
+// The space is only deallocated if the file is a sparse file,
+// otherwise zeros are written.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	var bytesReturned uint32
+	zeroData := fileZeroDataInformation{
+		FileOffset:      offset,
+		BeyondFinalZero: offset + size,
+	}
+	err := syscall.DeviceIoControl(syscall.Handle(out.Fd()), FSCTL_SET_ZERO_DATA, (*byte)(unsafe.Pointer(&zeroData)), uint32(unsafe.Sizeof(zeroData)), nil, 0, &bytesReturned, nil)
+	if err != nil {
+		return errors.Wrap(err, "DeviceIoControl FSCTL_SET_ZERO_DATA")
+	}
+	return nil
+}
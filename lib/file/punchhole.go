@@ -0,0 +1,31 @@
+package file
+
+import (
+	"io"
+	"os"
+)
+
+// writeZeros writes size zero bytes to out at offset
+//
+// This is used to punch holes in files where the OS or file system
+// can't do it.
+func writeZeros(out *os.File, offset, size int64) error {
+	const bufSize = 1024 * 1024
+	buf := make([]byte, bufSize)
+	for size > 0 {
+		n := size
+		if n > bufSize {
+			n = bufSize
+		}
+		nw, err := out.WriteAt(buf[:n], offset)
+		if err != nil {
+			return err
+		}
+		if int64(nw) != n {
+			return io.ErrShortWrite
+		}
+		offset += n
+		size -= n
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/skratchdot/open-golang/open"
@@ -234,7 +235,12 @@ func (ts *TokenSource) Token() (*oauth2.Token, error) {
 		time.Sleep(1 * time.Second)
 	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldn't fetch token - maybe it has expired? - refresh with \"rclone config reconnect %s:\"", ts.name)
+		err = errors.Wrapf(err, "couldn't fetch token - maybe it has expired? - refresh with \"rclone config reconnect %s:\"", ts.name)
+		// Network problems aren't the fault of the token
+		if code := fserrors.ErrorCode(err); code != fserrors.CodeNetwork && code != fserrors.CodeTimeout {
+			err = fserrors.WithCode(err, fserrors.CodeAuth)
+		}
+		return nil, err
 	}
 	changed = changed || (*token != *ts.token)
 	ts.token = token
@@ -399,6 +405,16 @@ func Config(ctx context.Context, id, name string, m configmap.Mapper, oauthConfi
 		opt = &Options{}
 	}
 	oauthConfig, changed := overrideCredentials(name, m, oauthConfig)
+
+	// See if the flow is being run over the rc
+	if flow := getFlow(ctx); flow != nil {
+		return flow.config(ctx, name, m, oauthConfig, opt)
+	}
+	if config.OAuthDeferred(ctx) {
+		fs.Debugf(nil, "Not running OAuth flow for %q - use config/oauth/start", name)
+		return nil
+	}
+
 	authorizeOnlyValue, ok := m.Get(config.ConfigAuthorize)
 	authorizeOnly := ok && authorizeOnlyValue != "" // set if being run by "rclone authorize"
 	authorizeNoAutoBrowserValue, ok := m.Get(config.ConfigAuthNoBrowser)
@@ -550,6 +566,7 @@ type authServer struct {
 	listener    net.Listener
 	bindAddress string
 	authURL     string
+	redirect    string // if set send the browser here with the result
 	server      *http.Server
 	result      chan *AuthResult
 }
@@ -571,6 +588,11 @@ func (s *authServer) handleAuth(w http.ResponseWriter, req *http.Request) {
 
 	// Reply with the response to the user and to the channel
 	reply := func(status int, res *AuthResult) {
+		if s.redirect != "" {
+			http.Redirect(w, req, s.redirectURL(res), http.StatusFound)
+			s.result <- res
+			return
+		}
 		w.WriteHeader(status)
 		w.Header().Set("Content-Type", "text/html")
 		var t = template.Must(template.New("authResponse").Parse(AuthResponseTemplate))
@@ -618,6 +640,26 @@ func (s *authServer) handleAuth(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// redirectURL returns the URL to send the browser to with the result
+// added to the query
+func (s *authServer) redirectURL(res *AuthResult) string {
+	u, err := url.Parse(s.redirect)
+	if err != nil {
+		// checked when the flow was started
+		return s.redirect
+	}
+	q := u.Query()
+	q.Set("state", s.state)
+	if res.OK {
+		q.Set("status", "ok")
+	} else {
+		q.Set("status", "error")
+		q.Set("error", res.Name+": "+res.Description)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 // Init gets the internal web server ready to receive config details
 func (s *authServer) Init() error {
 	fs.Debugf(nil, "Starting auth server on %s", s.bindAddress)
@@ -0,0 +1,463 @@
+// Run the OAuth flow for a remote over the rc
+
+package oauthutil
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/random"
+	"golang.org/x/oauth2"
+)
+
+// Status of an rcFlow
+const (
+	flowStarting = "starting" // running the backend config
+	flowPending  = "pending"  // waiting for the user to authorize
+	flowOK       = "ok"       // finished successfully
+	flowError    = "error"    // finished with an error
+)
+
+var (
+	// rcBindAddress is where the auth server listens for flows
+	// run over the rc
+	rcBindAddress = bindAddress
+	// rcStartTimeout is how long config/oauth/start waits for the
+	// backend to make the auth URL
+	rcStartTimeout = 30 * time.Second
+	// rcFlowExpire is how long finished flows are remembered for
+	rcFlowExpire = time.Hour
+)
+
+// rcFlow is an OAuth flow for a remote being driven over the rc
+type rcFlow struct {
+	mu       sync.Mutex
+	name     string        // name of the remote
+	state    string        // state for the auth which identifies the flow
+	redirect string        // where to send the browser when the code arrives
+	started  time.Time     // when the flow was started
+	status   string        // one of the flow constants above
+	authURL  string        // URL the user should visit
+	err      error         // error if status is flowError
+	ready    chan struct{} // closed when the authURL is known or the flow finishes
+	codes    chan *AuthResult
+	cancel   func()
+}
+
+// rcFlows holds the flows by state
+var rcFlows = struct {
+	mu    sync.Mutex
+	flows map[string]*rcFlow
+}{
+	flows: map[string]*rcFlow{},
+}
+
+// rcFlowKey is the context key for the flow being run
+type rcFlowKey struct{}
+
+// getFlow returns the flow being run or nil if the config isn't
+// being run over the rc
+func getFlow(ctx context.Context) *rcFlow {
+	flow, _ := ctx.Value(rcFlowKey{}).(*rcFlow)
+	return flow
+}
+
+// setURL records the URL the user should visit and marks the flow
+// as ready
+func (flow *rcFlow) setURL(authURL string) {
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+	flow.authURL = authURL
+	flow.status = flowPending
+	close(flow.ready)
+}
+
+// finish marks the flow as done with err if it hasn't failed already
+func (flow *rcFlow) finish(err error) {
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+	if flow.status == flowError || flow.status == flowOK {
+		return
+	}
+	if err == nil && flow.status == flowStarting {
+		err = errors.Errorf("remote %q didn't start an OAuth flow", flow.name)
+	}
+	if err != nil {
+		flow.status = flowError
+		flow.err = err
+		fs.Errorf(nil, "OAuth flow for %q failed: %v", flow.name, err)
+	} else {
+		flow.status = flowOK
+	}
+	if flow.authURL == "" {
+		close(flow.ready)
+	}
+}
+
+// params returns the state of the flow for the rc
+func (flow *rcFlow) params() rc.Params {
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+	out := rc.Params{
+		"name":    flow.name,
+		"state":   flow.state,
+		"status":  flow.status,
+		"authURL": flow.authURL,
+		"error":   "",
+	}
+	if flow.err != nil {
+		out["error"] = flow.err.Error()
+	}
+	return out
+}
+
+// config runs the OAuth flow for the remote, getting the code from
+// the auth server or from config/oauth/finish.
+//
+// It is called by Config instead of asking the user. Any errors are
+// recorded in the flow rather than returned as the backends treat
+// errors from Config as fatal.
+func (flow *rcFlow) config(ctx context.Context, name string, m configmap.Mapper, oauthConfig *oauth2.Config, opt *Options) error {
+	err := flow.run(ctx, name, m, oauthConfig, opt)
+	if err != nil {
+		flow.finish(err)
+	}
+	return nil
+}
+
+// run does the work for config
+func (flow *rcFlow) run(ctx context.Context, name string, m configmap.Mapper, oauthConfig *oauth2.Config, opt *Options) error {
+	if oauthConfig.RedirectURL == TitleBarRedirectURL {
+		// copy the config and set to use the internal webserver
+		configCopy := *oauthConfig
+		oauthConfig = &configCopy
+		oauthConfig.RedirectURL = RedirectURL
+	}
+	opts := opt.OAuth2Opts
+	if !opt.NoOffline {
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+	authURL := oauthConfig.AuthCodeURL(flow.state, opts...)
+
+	server := newAuthServer(opt, rcBindAddress, flow.state, authURL)
+	server.redirect = flow.redirect
+	err := server.Init()
+	if err != nil {
+		return errors.Wrap(err, "failed to start auth webserver")
+	}
+	go server.Serve()
+	defer server.Stop()
+	flow.setURL(authURL)
+
+	var auth *AuthResult
+	select {
+	case auth = <-server.result:
+	case auth = <-flow.codes:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "OAuth flow cancelled")
+	}
+	if !auth.OK || auth.Code == "" {
+		return auth
+	}
+	if opt.CheckAuth != nil {
+		err = opt.CheckAuth(oauthConfig, auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Exchange the code for a token
+	ctx = Context(ctx, fshttp.NewClient(ctx))
+	token, err := oauthConfig.Exchange(ctx, auth.Code)
+	if err != nil {
+		return errors.Wrap(err, "failed to get token")
+	}
+	return PutToken(name, m, token, true)
+}
+
+// expireFlows removes finished flows which are too old
+//
+// Call with rcFlows.mu held
+func expireFlows() {
+	for state, flow := range rcFlows.flows {
+		flow.mu.Lock()
+		finished := flow.status == flowOK || flow.status == flowError
+		old := time.Since(flow.started) > rcFlowExpire
+		flow.mu.Unlock()
+		if finished && old {
+			delete(rcFlows.flows, state)
+		}
+	}
+}
+
+// findFlow returns the flow for the state in the input
+func findFlow(in rc.Params) (*rcFlow, error) {
+	state, err := in.GetString("state")
+	if err != nil {
+		return nil, err
+	}
+	rcFlows.mu.Lock()
+	defer rcFlows.mu.Unlock()
+	flow := rcFlows.flows[state]
+	if flow == nil {
+		return nil, errors.New("OAuth flow not found")
+	}
+	return flow, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "config/oauth/start",
+		Fn:           rcOAuthStart,
+		Title:        "Start the OAuth flow for a remote.",
+		AuthRequired: true,
+		Help: `This runs the config for a remote which needs an OAuth flow, such as
+drive or onedrive, and returns the URL the user must visit to
+authorize rclone.  Create the remote with config/create first.
+
+Parameters:
+
+- name - name of the remote
+- redirect - optional URL to send the browser to when the user has authorized rclone
+
+Returns:
+
+- authURL - URL for the user to visit
+- state - ID for the flow to use with the other config/oauth calls
+
+Once the user has authorized rclone the provider redirects their
+browser to the auth server rclone runs on ` + RedirectURL + ` which
+collects the code and then sends the browser on to the redirect URL
+with these query parameters added
+
+- state - the state returned above
+- status - "ok" if the code was received or "error"
+- error - the error if status is "error"
+
+If the browser isn't on the same machine as rclone then the redirect
+to ` + RedirectURL + ` will fail.  In this case ask the user for the
+URL in the browser's address bar and pass it to config/oauth/finish.
+
+The token is then fetched and stored in the config in the background.
+Use config/oauth/status to find out when it is done.
+
+Only one flow can be pending at once as they share the auth server.
+`,
+	})
+}
+
+// Start an OAuth flow for a remote
+func rcOAuthStart(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := in.GetString("redirect")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if redirect != "" {
+		u, err := url.Parse(redirect)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.Errorf("redirect must be an http or https URL: %q", redirect)
+		}
+	}
+	remoteType := config.FileGet(name, "type")
+	if remoteType == "" {
+		return nil, errors.Errorf("remote %q not found", name)
+	}
+	ri, err := fs.Find(remoteType)
+	if err != nil {
+		return nil, err
+	}
+	if !config.UsesOAuth(ri) {
+		return nil, errors.Errorf("remote %q of type %q doesn't use OAuth", name, remoteType)
+	}
+	state, err := random.Password(128)
+	if err != nil {
+		return nil, err
+	}
+
+	rcFlows.mu.Lock()
+	expireFlows()
+	for _, other := range rcFlows.flows {
+		other.mu.Lock()
+		busy := other.status == flowStarting || other.status == flowPending
+		other.mu.Unlock()
+		if busy {
+			rcFlows.mu.Unlock()
+			return nil, errors.Errorf("OAuth flow for %q is already running - finish or cancel it first", other.name)
+		}
+	}
+	// The flow outlives this call so doesn't use its context
+	flowCtx, ci := fs.AddConfig(context.Background())
+	ci.AutoConfirm = true
+	flowCtx, cancel := context.WithCancel(flowCtx)
+	flow := &rcFlow{
+		name:     name,
+		state:    state,
+		redirect: redirect,
+		started:  time.Now(),
+		status:   flowStarting,
+		ready:    make(chan struct{}),
+		codes:    make(chan *AuthResult, 1),
+		cancel:   cancel,
+	}
+	rcFlows.flows[state] = flow
+	rcFlows.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		config.RemoteConfig(context.WithValue(flowCtx, rcFlowKey{}, flow), name)
+		config.SaveConfig()
+		flow.finish(nil)
+	}()
+
+	select {
+	case <-flow.ready:
+	case <-time.After(rcStartTimeout):
+		cancel()
+		return nil, errors.New("timed out waiting for the OAuth flow to start")
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+	out = flow.params()
+	if out["status"] == flowError {
+		return nil, errors.New(out["error"].(string))
+	}
+	return rc.Params{
+		"authURL": out["authURL"],
+		"state":   state,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "config/oauth/finish",
+		Fn:           rcOAuthFinish,
+		Title:        "Finish the OAuth flow for a remote with the code.",
+		AuthRequired: true,
+		Help: `Use this to pass the code to an OAuth flow started with
+config/oauth/start if the browser couldn't reach rclone's auth server.
+
+Parameters:
+
+- state - the state returned by config/oauth/start
+- url - the URL the provider redirected the browser to
+- code - the code from the provider if url isn't set
+
+The token is fetched and stored in the config in the background.
+Use config/oauth/status to find out when it is done.
+`,
+	})
+}
+
+// Pass the code to an OAuth flow
+func rcOAuthFinish(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	flow, err := findFlow(in)
+	if err != nil {
+		return nil, err
+	}
+	auth := &AuthResult{OK: true}
+	redirectURL, err := in.GetString("url")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if redirectURL != "" {
+		u, err := url.Parse(redirectURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad url")
+		}
+		auth.Form = u.Query()
+		auth.Code = auth.Form.Get("code")
+		if state := auth.Form.Get("state"); state != flow.state && state != "" {
+			return nil, errors.New("state in url doesn't match the flow")
+		}
+	} else {
+		auth.Code, err = in.GetString("code")
+		if err != nil {
+			return nil, err
+		}
+		auth.Form = url.Values{"code": {auth.Code}}
+	}
+	if auth.Code == "" {
+		return nil, errors.New("no code found")
+	}
+	flow.mu.Lock()
+	pending := flow.status == flowPending
+	flow.mu.Unlock()
+	if !pending {
+		return nil, errors.New("OAuth flow isn't waiting for a code")
+	}
+	select {
+	case flow.codes <- auth:
+	default:
+		return nil, errors.New("OAuth flow already has a code")
+	}
+	return rc.Params{}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "config/oauth/status",
+		Fn:           rcOAuthStatus,
+		Title:        "Show the status of the OAuth flow for a remote.",
+		AuthRequired: true,
+		Help: `Parameters:
+
+- state - the state returned by config/oauth/start
+
+Returns:
+
+- name - name of the remote
+- state - as passed in
+- status - "pending" while waiting for the user, "ok" when the token is stored or "error"
+- authURL - URL for the user to visit
+- error - the error if status is "error"
+`,
+	})
+}
+
+// Return the status of an OAuth flow
+func rcOAuthStatus(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	flow, err := findFlow(in)
+	if err != nil {
+		return nil, err
+	}
+	return flow.params(), nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "config/oauth/cancel",
+		Fn:           rcOAuthCancel,
+		Title:        "Cancel the OAuth flow for a remote.",
+		AuthRequired: true,
+		Help: `This stops the flow and the auth server so another flow can be
+started.
+
+Parameters:
+
+- state - the state returned by config/oauth/start
+`,
+	})
+}
+
+// Cancel an OAuth flow
+func rcOAuthCancel(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	flow, err := findFlow(in)
+	if err != nil {
+		return nil, err
+	}
+	flow.cancel()
+	return rc.Params{}, nil
+}
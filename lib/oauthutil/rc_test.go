@@ -0,0 +1,206 @@
+package oauthutil
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const testRemote = "oauthutilTestRemote"
+
+// testOAuthConfig is used by the test backend
+var testOAuthConfig *oauth2.Config
+
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name: "oauthutil_test",
+		Config: func(ctx context.Context, name string, m configmap.Mapper) {
+			err := Config(ctx, "oauthutil_test", name, m, testOAuthConfig, nil)
+			if err != nil {
+				fs.Errorf(nil, "Config failed: %v", err)
+			}
+		},
+		Options: SharedOptions,
+	})
+}
+
+// setupRcTest makes a token server for the test backend and a
+// temporary config file - call the returned function to undo it
+func setupRcTest(t *testing.T) func() {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("code") != "good-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-access","token_type":"Bearer","refresh_token":"test-refresh","expires_in":3600}`))
+	}))
+	testOAuthConfig = &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  tokenServer.URL + "/auth",
+			TokenURL: tokenServer.URL + "/token",
+		},
+		RedirectURL: RedirectURL,
+	}
+
+	// Run the auth server on a free port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	oldBindAddress := rcBindAddress
+	rcBindAddress = listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	tempFile, err := ioutil.TempFile("", "oauthutil.conf")
+	require.NoError(t, err)
+	require.NoError(t, tempFile.Close())
+	oldConfigPath := config.ConfigPath
+	config.ConfigPath = tempFile.Name()
+	config.LoadConfig(context.Background())
+	config.FileSet(testRemote, "type", "oauthutil_test")
+
+	return func() {
+		tokenServer.Close()
+		rcBindAddress = oldBindAddress
+		config.ConfigPath = oldConfigPath
+		config.LoadConfig(context.Background())
+		_ = os.Remove(tempFile.Name())
+	}
+}
+
+// callRc calls the rc function at path with in
+func callRc(t *testing.T, path string, in rc.Params) (rc.Params, error) {
+	call := rc.Calls.Get(path)
+	require.NotNil(t, call, path)
+	return call.Fn(context.Background(), in)
+}
+
+// waitForFlow waits for the flow to stop being pending
+func waitForFlow(t *testing.T, state string) rc.Params {
+	for i := 0; i < 100; i++ {
+		out, err := callRc(t, "config/oauth/status", rc.Params{"state": state})
+		require.NoError(t, err)
+		if out["status"] != flowPending {
+			return out
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for OAuth flow")
+	return nil
+}
+
+func TestRcOAuthDeferred(t *testing.T) {
+	defer setupRcTest(t)()
+
+	// The rc config calls don't run the OAuth flow
+	_, err := callRc(t, "config/update", rc.Params{
+		"name":       testRemote,
+		"parameters": rc.Params{"client_id": "client"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", config.FileGet(testRemote, "token"))
+}
+
+func TestRcOAuthFlow(t *testing.T) {
+	defer setupRcTest(t)()
+
+	_, err := callRc(t, "config/oauth/start", rc.Params{"name": "notfound"})
+	assert.Error(t, err)
+	_, err = callRc(t, "config/oauth/start", rc.Params{"name": testRemote, "redirect": "potato"})
+	assert.Error(t, err)
+
+	out, err := callRc(t, "config/oauth/start", rc.Params{
+		"name":     testRemote,
+		"redirect": "https://gui.example.com/done?remote=1",
+	})
+	require.NoError(t, err)
+	state := out["state"].(string)
+	assert.True(t, strings.HasPrefix(out["authURL"].(string), "http"), out["authURL"])
+	assert.Contains(t, out["authURL"], "state="+url.QueryEscape(state))
+
+	// Only one flow at once
+	_, err = callRc(t, "config/oauth/start", rc.Params{"name": testRemote})
+	assert.Error(t, err)
+
+	// The provider sends the browser to the auth server which
+	// bounces it to the redirect
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + rcBindAddress + "/?code=good-code&state=" + url.QueryEscape(state))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "gui.example.com", location.Host)
+	assert.Equal(t, "1", location.Query().Get("remote"))
+	assert.Equal(t, "ok", location.Query().Get("status"))
+	assert.Equal(t, state, location.Query().Get("state"))
+
+	out = waitForFlow(t, state)
+	assert.Equal(t, flowOK, out["status"], out["error"])
+	assert.Contains(t, config.FileGet(testRemote, "token"), "test-access")
+}
+
+func TestRcOAuthFinish(t *testing.T) {
+	defer setupRcTest(t)()
+
+	out, err := callRc(t, "config/oauth/start", rc.Params{"name": testRemote})
+	require.NoError(t, err)
+	state := out["state"].(string)
+
+	_, err = callRc(t, "config/oauth/finish", rc.Params{"state": "potato", "code": "good-code"})
+	assert.Error(t, err)
+	_, err = callRc(t, "config/oauth/finish", rc.Params{"state": state, "url": RedirectURL + "?code=good-code&state=potato"})
+	assert.Error(t, err)
+
+	_, err = callRc(t, "config/oauth/finish", rc.Params{"state": state, "url": RedirectURL + "?code=bad-code&state=" + url.QueryEscape(state)})
+	require.NoError(t, err)
+	out = waitForFlow(t, state)
+	assert.Equal(t, flowError, out["status"])
+	assert.Contains(t, out["error"], "failed to get token")
+	assert.Equal(t, "", config.FileGet(testRemote, "token"))
+
+	// Try again with a good code
+	out, err = callRc(t, "config/oauth/start", rc.Params{"name": testRemote})
+	require.NoError(t, err)
+	state = out["state"].(string)
+	_, err = callRc(t, "config/oauth/finish", rc.Params{"state": state, "code": "good-code"})
+	require.NoError(t, err)
+	out = waitForFlow(t, state)
+	assert.Equal(t, flowOK, out["status"], out["error"])
+	assert.Contains(t, config.FileGet(testRemote, "token"), "test-access")
+}
+
+func TestRcOAuthCancel(t *testing.T) {
+	defer setupRcTest(t)()
+
+	out, err := callRc(t, "config/oauth/start", rc.Params{"name": testRemote})
+	require.NoError(t, err)
+	state := out["state"].(string)
+	_, err = callRc(t, "config/oauth/cancel", rc.Params{"state": state})
+	require.NoError(t, err)
+	out = waitForFlow(t, state)
+	assert.Equal(t, flowError, out["status"])
+	assert.Contains(t, out["error"], "cancelled")
+}
@@ -0,0 +1,39 @@
+package smb2
+
+// The parts of NDR (the encoding of DCE/RPC) needed for the srvsvc
+// calls made over the IPC$ share.
+
+// ReadNDRString reads a conformant varying unicode string
+func ReadNDRString(r *Reader) string {
+	r.Align(4)
+	r.Skip(4) // max count
+	r.Skip(4) // offset
+	n := int(r.Uint32())
+	s := DecodeUTF16(r.Next(2 * n))
+	r.Align(4)
+	return s
+}
+
+// NDRWriter encodes NDR data with deferred pointers
+type NDRWriter struct {
+	Writer
+	refID uint32
+}
+
+// Pointer writes a new non-null referent id
+func (w *NDRWriter) Pointer() {
+	w.refID += 4
+	w.Uint32(0x20000 + w.refID)
+}
+
+// String writes a conformant varying unicode string
+func (w *NDRWriter) String(s string) {
+	w.Align(4)
+	data := EncodeUTF16(s + "\x00")
+	n := uint32(len(data) / 2)
+	w.Uint32(n)
+	w.Uint32(0)
+	w.Uint32(n)
+	w.Append(data)
+	w.Align(4)
+}
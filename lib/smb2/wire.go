@@ -0,0 +1,250 @@
+// Package smb2 implements the encoding of the SMB2 messages shared by
+// the smb backend and rclone serve smb.
+package smb2
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBadMessage is returned when a message can't be decoded
+var ErrBadMessage = errors.New("badly formed SMB2 message")
+
+// Reader decodes little endian values from a buffer.
+//
+// Errors are sticky so a whole structure can be read before checking
+// Err. Once an error has occurred reads return zero values.
+type Reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+// NewReader makes a Reader reading from buf
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+// Err returns the first error which occurred while decoding
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Offset returns the offset of the next byte to be read
+func (r *Reader) Offset() int {
+	return r.off
+}
+
+// Seek sets the offset of the next byte to be read.
+//
+// Reads past the end of the buffer set Err rather than Seek.
+func (r *Reader) Seek(off int) {
+	r.off = off
+}
+
+// Align moves the offset on to a multiple of n bytes
+func (r *Reader) Align(n int) {
+	r.off = (r.off + n - 1) / n * n
+}
+
+// Limit discards all but the first n bytes of the buffer, setting
+// Err if there aren't that many
+func (r *Reader) Limit(n int) {
+	if n < 0 || n > len(r.buf) {
+		r.err = ErrBadMessage
+		return
+	}
+	r.buf = r.buf[:n]
+}
+
+// Rest returns the bytes after the offset without reading them
+func (r *Reader) Rest() []byte {
+	if r.off > len(r.buf) {
+		return nil
+	}
+	return r.buf[r.off:]
+}
+
+// Next returns the next n bytes
+func (r *Reader) Next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off < 0 || r.off+n > len(r.buf) {
+		r.err = ErrBadMessage
+		return nil
+	}
+	data := r.buf[r.off : r.off+n]
+	r.off += n
+	return data
+}
+
+// Skip n bytes
+func (r *Reader) Skip(n int) {
+	_ = r.Next(n)
+}
+
+// Uint8 reads a byte
+func (r *Reader) Uint8() uint8 {
+	data := r.Next(1)
+	if data == nil {
+		return 0
+	}
+	return data[0]
+}
+
+// Uint16 reads a little endian uint16
+func (r *Reader) Uint16() uint16 {
+	data := r.Next(2)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(data)
+}
+
+// Uint32 reads a little endian uint32
+func (r *Reader) Uint32() uint32 {
+	data := r.Next(4)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+// Uint64 reads a little endian uint64
+func (r *Reader) Uint64() uint64 {
+	data := r.Next(8)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+// At returns the length bytes at offset in the buffer, setting Err
+// if they aren't all there
+func (r *Reader) At(offset, length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if offset < 0 || length < 0 || offset+length > len(r.buf) {
+		r.err = ErrBadMessage
+		return nil
+	}
+	return r.buf[offset : offset+length]
+}
+
+// Writer encodes little endian values into a buffer
+type Writer struct {
+	buf []byte
+}
+
+// NewWriter makes a Writer with room for size bytes
+func NewWriter(size int) *Writer {
+	return &Writer{buf: make([]byte, 0, size)}
+}
+
+// Bytes returns the data written so far
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Len returns the number of bytes written
+func (w *Writer) Len() int {
+	return len(w.buf)
+}
+
+// Truncate discards all but the first n bytes written
+func (w *Writer) Truncate(n int) {
+	w.buf = w.buf[:n]
+}
+
+// Uint8 writes a byte
+func (w *Writer) Uint8(x uint8) {
+	w.buf = append(w.buf, x)
+}
+
+// Uint16 writes a little endian uint16
+func (w *Writer) Uint16(x uint16) {
+	w.buf = append(w.buf, byte(x), byte(x>>8))
+}
+
+// Uint32 writes a little endian uint32
+func (w *Writer) Uint32(x uint32) {
+	w.buf = append(w.buf, byte(x), byte(x>>8), byte(x>>16), byte(x>>24))
+}
+
+// Uint64 writes a little endian uint64
+func (w *Writer) Uint64(x uint64) {
+	w.Uint32(uint32(x))
+	w.Uint32(uint32(x >> 32))
+}
+
+// Append writes data
+func (w *Writer) Append(data []byte) {
+	w.buf = append(w.buf, data...)
+}
+
+// Zero writes n zero bytes
+func (w *Writer) Zero(n int) {
+	w.buf = append(w.buf, make([]byte, n)...)
+}
+
+// Align pads the buffer with zeros to a multiple of n bytes
+func (w *Writer) Align(n int) {
+	w.Zero((n - len(w.buf)%n) % n)
+}
+
+// PutUint32 overwrites the uint32 at offset
+func (w *Writer) PutUint32(offset int, x uint32) {
+	binary.LittleEndian.PutUint32(w.buf[offset:], x)
+}
+
+// PutUint16 overwrites the uint16 at offset
+func (w *Writer) PutUint16(offset int, x uint16) {
+	binary.LittleEndian.PutUint16(w.buf[offset:], x)
+}
+
+// EncodeUTF16 encodes s as UTF-16LE without a terminator
+func EncodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	data := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(data[2*i:], c)
+	}
+	return data
+}
+
+// DecodeUTF16 decodes UTF-16LE data into a string, stopping at a
+// terminating zero if there is one
+func DecodeUTF16(data []byte) string {
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// ticksToUnixEpoch is the number of 100ns intervals between the
+// FILETIME epoch of 1601-01-01 and the unix epoch
+const ticksToUnixEpoch = 116444736000000000
+
+// ToFiletime converts t to a FILETIME
+func ToFiletime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100 + ticksToUnixEpoch)
+}
+
+// FromFiletime converts a FILETIME into a time
+func FromFiletime(ft uint64) time.Time {
+	ticks := int64(ft) - ticksToUnixEpoch
+	return time.Unix(ticks/1e7, (ticks%1e7)*100)
+}
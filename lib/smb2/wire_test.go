@@ -0,0 +1,69 @@
+package smb2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWire(t *testing.T) {
+	w := NewWriter(64)
+	w.Uint8(1)
+	w.Align(4)
+	w.Uint16(2)
+	w.Uint32(3)
+	w.Uint64(4)
+	w.Append(EncodeUTF16("héllo"))
+	w.Zero(2)
+	w.PutUint16(4, 20)
+	assert.Equal(t, 4+2+4+8+10+2, w.Len())
+
+	r := NewReader(w.Bytes())
+	assert.Equal(t, uint8(1), r.Uint8())
+	r.Align(4)
+	assert.Equal(t, 4, r.Offset())
+	assert.Equal(t, uint16(20), r.Uint16())
+	assert.Equal(t, uint32(3), r.Uint32())
+	assert.Equal(t, uint64(4), r.Uint64())
+	assert.Equal(t, "héllo", DecodeUTF16(r.Rest()))
+	assert.Equal(t, []byte{3, 0}, r.At(6, 2))
+	require.NoError(t, r.Err())
+
+	// reading past the end is an error which sticks
+	r.Seek(w.Len() - 1)
+	assert.Equal(t, uint16(0), r.Uint16())
+	assert.Equal(t, ErrBadMessage, r.Err())
+	r.Seek(0)
+	assert.Equal(t, uint8(0), r.Uint8())
+
+	r = NewReader(w.Bytes())
+	r.Limit(4)
+	assert.Nil(t, r.At(4, 1))
+	assert.Equal(t, ErrBadMessage, r.Err())
+
+	w.Truncate(1)
+	assert.Equal(t, []byte{1}, w.Bytes())
+}
+
+func TestFiletime(t *testing.T) {
+	assert.Equal(t, uint64(0), ToFiletime(time.Time{}))
+	assert.Equal(t, uint64(116444736000000000), ToFiletime(time.Unix(0, 0)))
+	now := time.Unix(1600000000, 123456700)
+	assert.True(t, now.Equal(FromFiletime(ToFiletime(now))))
+}
+
+func TestNDR(t *testing.T) {
+	var w NDRWriter
+	w.Pointer()
+	w.String("share")
+	w.Pointer()
+	assert.Equal(t, 0, w.Len()%4)
+
+	r := NewReader(w.Bytes())
+	assert.Equal(t, uint32(0x20004), r.Uint32())
+	assert.Equal(t, "share", ReadNDRString(r))
+	assert.Equal(t, uint32(0x20008), r.Uint32())
+	require.NoError(t, r.Err())
+}
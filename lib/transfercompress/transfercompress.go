@@ -0,0 +1,92 @@
+// Package transfercompress compresses data in transit between rclone
+// and remotes which can compress and decompress it at their end, as
+// set by --transfer-compression.
+//
+// The data is stored uncompressed - only the stream is compressed.
+package transfercompress
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Values for --transfer-compression
+const (
+	Off  = "off"  // don't compress data in transit
+	Auto = "auto" // use the best method the remote supports
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Methods are the compression methods in order of preference
+var Methods = []string{Zstd, Gzip}
+
+// Check returns an error if setting isn't a valid value for
+// --transfer-compression
+func Check(setting string) error {
+	switch setting {
+	case "", Off, Auto, Gzip, Zstd:
+		return nil
+	}
+	return errors.Errorf("unknown transfer compression %q - use %s, %s, %s or %s", setting, Off, Auto, Gzip, Zstd)
+}
+
+// Choose returns the method to use for the setting given the
+// methods the remote supports, or "" if the data shouldn't be
+// compressed.
+func Choose(setting string, supported []string) string {
+	for _, method := range Methods {
+		if setting != Auto && setting != method {
+			continue
+		}
+		for _, s := range supported {
+			if s == method {
+				return method
+			}
+		}
+	}
+	return ""
+}
+
+// NewReader returns a reader which decompresses the data read from in
+// which was compressed with method
+func NewReader(method string, in io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case Gzip:
+		return gzip.NewReader(in)
+	case Zstd:
+		dec, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{dec}, nil
+	}
+	return nil, errors.Errorf("unknown transfer compression %q", method)
+}
+
+// zstdReadCloser adapts a zstd.Decoder to an io.ReadCloser
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+// Close the decoder
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// NewWriter returns a writer which compresses the data written to it
+// with method and writes it to out.  It must be closed to flush the
+// end of the data.
+func NewWriter(method string, out io.Writer) (io.WriteCloser, error) {
+	switch method {
+	case Gzip:
+		return gzip.NewWriterLevel(out, gzip.BestSpeed)
+	case Zstd:
+		return zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	}
+	return nil, errors.Errorf("unknown transfer compression %q", method)
+}
@@ -0,0 +1,61 @@
+package transfercompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	for _, setting := range []string{"", Off, Auto, Gzip, Zstd} {
+		assert.NoError(t, Check(setting), setting)
+	}
+	assert.EqualError(t, Check("lz4"), `unknown transfer compression "lz4" - use off, auto, gzip or zstd`)
+}
+
+func TestChoose(t *testing.T) {
+	for _, test := range []struct {
+		setting   string
+		supported []string
+		want      string
+	}{
+		{"", []string{Gzip, Zstd}, ""},
+		{Off, []string{Gzip, Zstd}, ""},
+		{Auto, []string{Gzip, Zstd}, Zstd},
+		{Auto, []string{Gzip}, Gzip},
+		{Auto, nil, ""},
+		{Gzip, []string{Gzip, Zstd}, Gzip},
+		{Zstd, []string{Gzip}, ""},
+	} {
+		assert.Equal(t, test.want, Choose(test.setting, test.supported), test)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible data "), 10000)
+	for _, method := range Methods {
+		t.Run(method, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(method, &buf)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			assert.Less(t, buf.Len(), len(data)/10)
+
+			r, err := NewReader(method, &buf)
+			require.NoError(t, err)
+			got, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.Equal(t, data, got)
+		})
+	}
+	_, err := NewReader("lz4", nil)
+	assert.Error(t, err)
+	_, err = NewWriter("lz4", nil)
+	assert.Error(t, err)
+}
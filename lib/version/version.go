@@ -0,0 +1,60 @@
+// Package version provides functions for adding a timestamp to file
+// names to make versions of them, and for finding the timestamp again.
+package version
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// versionFormat is the format the timestamp is added in - the "." is
+// replaced with a "-" so the extension isn't changed
+const versionFormat = "-v2006-01-02-150405.000"
+
+// versionRegexp matches a timestamp added by Add
+var versionRegexp = regexp.MustCompile(`-v\d{4}-\d{2}-\d{2}-\d{6}-\d{3}$`)
+
+// split fileName into the base and extension
+func split(fileName string) (base, ext string) {
+	ext = path.Ext(fileName)
+	base = fileName[:len(fileName)-len(ext)]
+	return base, ext
+}
+
+// Add adds the timestamp t in UTC to fileName before the extension,
+// so "file.txt" becomes "file-v2021-01-02-150405-000.txt"
+func Add(fileName string, t time.Time) string {
+	base, ext := split(fileName)
+	s := t.UTC().Format(versionFormat)
+	// Replace the '.' with a '-'
+	s = strings.Replace(s, ".", "-", -1)
+	return base + s + ext
+}
+
+// Remove removes the timestamp from fileName.
+//
+// It returns the timestamp and the file name without it, or a zero
+// timestamp and fileName if it doesn't have a timestamp.
+func Remove(fileName string) (t time.Time, fileNameWithoutVersion string) {
+	fileNameWithoutVersion = fileName
+	base, ext := split(fileName)
+	if !versionRegexp.MatchString(base) {
+		return
+	}
+	versionStart := len(base) - len(versionFormat)
+	// Replace the last '-' with '.' for parsing
+	s := base[versionStart:len(base)-4] + "." + base[len(base)-3:]
+	t, err := time.Parse(versionFormat, s)
+	if err != nil {
+		return time.Time{}, fileName
+	}
+	return t, base[:versionStart] + ext
+}
+
+// Match returns true if fileName has a timestamp added by Add
+func Match(fileName string) bool {
+	t, _ := Remove(fileName)
+	return !t.IsZero()
+}
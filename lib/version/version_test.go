@@ -0,0 +1,48 @@
+package version
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 15, 4, 5, 123456789, time.UTC)
+	t0ms := time.Date(2021, 1, 2, 15, 4, 5, 123000000, time.UTC)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"file.txt", "file-v2021-01-02-150405-123.txt"},
+		{"dir/file.txt", "dir/file-v2021-01-02-150405-123.txt"},
+		{"dir.d/file", "dir.d/file-v2021-01-02-150405-123"},
+		{"file", "file-v2021-01-02-150405-123"},
+		{".hidden", "-v2021-01-02-150405-123.hidden"},
+		{"file.tar.gz", "file.tar-v2021-01-02-150405-123.gz"},
+	} {
+		got := Add(test.in, t0)
+		assert.Equal(t, test.want, got, test.in)
+		assert.True(t, Match(got), got)
+		assert.False(t, Match(test.in), test.in)
+		gotT, gotName := Remove(got)
+		assert.Equal(t, test.in, gotName)
+		assert.True(t, t0ms.Equal(gotT), gotT)
+	}
+
+	// Local times are converted to UTC
+	assert.Equal(t, "file-v2021-01-02-150405-123.txt", Add("file.txt", t0.In(time.FixedZone("X", 3600))))
+
+	// Not versions
+	for _, in := range []string{
+		"file-v2021-01-02-150405.txt",
+		"file-v2021-13-02-150405-123.txt",
+		"file-v2021-01-02-150405-123x.txt",
+		"file-w2021-01-02-150405-123.txt",
+	} {
+		gotT, gotName := Remove(in)
+		assert.True(t, gotT.IsZero(), in)
+		assert.Equal(t, in, gotName)
+		assert.False(t, Match(in), in)
+	}
+}
@@ -0,0 +1,225 @@
+// Package xdr implements the parts of XDR (RFC 4506) needed for ONC
+// RPC and NFS.
+package xdr
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBadXDR is returned when data can't be decoded
+var ErrBadXDR = errors.New("badly formed XDR")
+
+// Reader decodes XDR from a buffer.
+//
+// Errors are sticky so a whole structure can be read before checking
+// Err. Once an error has occurred reads return zero values.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+// NewReader makes a Reader reading from buf
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+// Err returns the first error which occurred while decoding
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// SetErr sets the error returned by Err if there isn't one already,
+// for errors found by the caller in the data decoded.
+func (r *Reader) SetErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Len returns the number of bytes left to decode
+func (r *Reader) Len() int {
+	return len(r.buf)
+}
+
+// Fixed decodes n bytes of fixed length opaque data and the padding
+// after them
+func (r *Reader) Fixed(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	padded := (n + 3) &^ 3
+	if n < 0 || padded > len(r.buf) {
+		r.err = ErrBadXDR
+		r.buf = nil
+		return nil
+	}
+	data := r.buf[:n]
+	r.buf = r.buf[padded:]
+	return data
+}
+
+// Uint32 decodes an unsigned int
+func (r *Reader) Uint32() uint32 {
+	data := r.Fixed(4)
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
+
+// Uint64 decodes an unsigned hyper
+func (r *Reader) Uint64() uint64 {
+	data := r.Fixed(8)
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// Bool decodes a bool
+func (r *Reader) Bool() bool {
+	return r.Uint32() != 0
+}
+
+// Opaque decodes variable length opaque data of up to max bytes
+func (r *Reader) Opaque(max int) []byte {
+	n := r.Uint32()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint32(max) {
+		r.err = ErrBadXDR
+		return nil
+	}
+	return r.Fixed(int(n))
+}
+
+// String decodes a string of up to max bytes
+func (r *Reader) String(max int) string {
+	return string(r.Opaque(max))
+}
+
+// Bitmap decodes a bitmap4 as used by NFSv4
+func (r *Reader) Bitmap() Bitmap {
+	n := r.Uint32()
+	if r.err != nil {
+		return nil
+	}
+	if n > 8 {
+		r.err = ErrBadXDR
+		return nil
+	}
+	b := make(Bitmap, 0, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		b = append(b, r.Uint32())
+	}
+	return b
+}
+
+// Writer encodes XDR into a buffer
+type Writer struct {
+	buf []byte
+}
+
+// Bytes returns the data encoded so far
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Len returns the number of bytes encoded so far
+func (w *Writer) Len() int {
+	return len(w.buf)
+}
+
+// Truncate discards all but the first n bytes encoded
+func (w *Writer) Truncate(n int) {
+	w.buf = w.buf[:n]
+}
+
+// Fixed encodes fixed length opaque data padding it to a multiple of
+// 4 bytes
+func (w *Writer) Fixed(b []byte) {
+	w.buf = append(w.buf, b...)
+	for i := len(b); i%4 != 0; i++ {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// Uint32 encodes an unsigned int
+func (w *Writer) Uint32(x uint32) {
+	w.buf = append(w.buf, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+}
+
+// Uint64 encodes an unsigned hyper
+func (w *Writer) Uint64(x uint64) {
+	w.Uint32(uint32(x >> 32))
+	w.Uint32(uint32(x))
+}
+
+// Bool encodes a bool
+func (w *Writer) Bool(x bool) {
+	if x {
+		w.Uint32(1)
+	} else {
+		w.Uint32(0)
+	}
+}
+
+// Opaque encodes variable length opaque data
+func (w *Writer) Opaque(b []byte) {
+	w.Uint32(uint32(len(b)))
+	w.Fixed(b)
+}
+
+// String encodes a string
+func (w *Writer) String(s string) {
+	w.Opaque([]byte(s))
+}
+
+// Bitmap encodes a bitmap4 as used by NFSv4
+func (w *Writer) Bitmap(b Bitmap) {
+	w.Uint32(uint32(len(b)))
+	for _, x := range b {
+		w.Uint32(x)
+	}
+}
+
+// Bitmap is a bitmap4 as used by NFSv4 - bit n is set in word n/32
+type Bitmap []uint32
+
+// NewBitmap makes a Bitmap with the bits given set
+func NewBitmap(bits ...int) (b Bitmap) {
+	for _, bit := range bits {
+		b.Set(bit)
+	}
+	return b
+}
+
+// IsSet returns whether bit is set
+func (b Bitmap) IsSet(bit int) bool {
+	word := bit / 32
+	return word < len(b) && b[word]&(1<<uint(bit%32)) != 0
+}
+
+// Set sets bit, growing b if necessary
+func (b *Bitmap) Set(bit int) {
+	word := bit / 32
+	for len(*b) <= word {
+		*b = append(*b, 0)
+	}
+	(*b)[word] |= 1 << uint(bit%32)
+}
+
+// Bits returns the bits which are set in ascending order
+func (b Bitmap) Bits() (bits []int) {
+	for word, x := range b {
+		for i := 0; i < 32; i++ {
+			if x&(1<<uint(i)) != 0 {
+				bits = append(bits, word*32+i)
+			}
+		}
+	}
+	return bits
+}
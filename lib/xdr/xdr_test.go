@@ -0,0 +1,49 @@
+package xdr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXDR(t *testing.T) {
+	var w Writer
+	w.Uint32(1)
+	w.Uint64(1 << 40)
+	w.Bool(true)
+	w.Opaque([]byte("hello"))
+	w.String("")
+	w.Fixed([]byte{1, 2})
+	w.Bitmap(NewBitmap(1, 33))
+	assert.Equal(t, 4+8+4+(4+8)+4+4+(4+8), w.Len())
+
+	r := NewReader(w.Bytes())
+	assert.Equal(t, uint32(1), r.Uint32())
+	assert.Equal(t, uint64(1<<40), r.Uint64())
+	assert.Equal(t, true, r.Bool())
+	assert.Equal(t, []byte("hello"), r.Opaque(10))
+	assert.Equal(t, "", r.String(10))
+	assert.Equal(t, []byte{1, 2}, r.Fixed(2))
+	b := r.Bitmap()
+	assert.Equal(t, []int{1, 33}, b.Bits())
+	assert.True(t, b.IsSet(33))
+	assert.False(t, b.IsSet(2))
+	assert.False(t, b.IsSet(100))
+	require.NoError(t, r.Err())
+	assert.Equal(t, 0, r.Len())
+
+	// reading past the end latches an error
+	assert.Equal(t, uint32(0), r.Uint32())
+	assert.Equal(t, ErrBadXDR, r.Err())
+	r.SetErr(assert.AnError)
+	assert.Equal(t, ErrBadXDR, r.Err())
+
+	// too long opaque data is an error
+	r = NewReader(w.Bytes()[16:])
+	assert.Nil(t, r.Opaque(4))
+	assert.Equal(t, ErrBadXDR, r.Err())
+
+	w.Truncate(4)
+	assert.Equal(t, []byte{0, 0, 0, 1}, w.Bytes())
+}
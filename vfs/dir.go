@@ -31,6 +31,7 @@ type Dir struct {
 	path    string
 	entry   fs.Directory
 	read    time.Time         // time directory entry last read
+	checked bool              // set if the persisted directory cache has been checked
 	items   map[string]Node   // directory entries - can be empty but not nil
 	virtual map[string]vState // virtual directory entries - may be nil
 	sys     atomic.Value      // user defined info to be attached here
@@ -149,6 +150,7 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	d._purgeVirtual()
 
 	d.read = time.Time{}
+	d.vfs.dirCache.remove(d.path, true)
 	// Check if this dir has virtual entries
 	if len(d.virtual) != 0 {
 		hasVirtual = true
@@ -176,6 +178,7 @@ func (d *Dir) forgetDirPath(relativePath string) {
 
 // invalidateDir invalidates the directory cache for absPath relative to the root
 func (d *Dir) invalidateDir(absPath string) {
+	d.vfs.dirCache.remove(absPath, false)
 	node := d.vfs.root.cachedNode(absPath)
 	if dir, ok := node.(*Dir); ok {
 		dir.mu.Lock()
@@ -191,6 +194,8 @@ func (d *Dir) invalidateDir(absPath string) {
 // passed in.
 //
 // if entryType is a directory it invalidates the parent of the directory too.
+//
+// It then passes the change on to any subscribers from ChangeNotify.
 func (d *Dir) changeNotify(relativePath string, entryType fs.EntryType) {
 	defer log.Trace(d.path, "relativePath=%q, type=%v", relativePath, entryType)("")
 	d.mu.RLock()
@@ -199,7 +204,9 @@ func (d *Dir) changeNotify(relativePath string, entryType fs.EntryType) {
 	d.invalidateDir(vfscommon.FindParent(absPath))
 	if entryType == fs.EntryDirectory {
 		d.invalidateDir(absPath)
+		d.vfs.dirCache.remove(absPath, true)
 	}
+	d.vfs.notifiers.notify(absPath, entryType)
 }
 
 // ForgetPath clears the cache for itself and all subdirectories if
@@ -284,6 +291,8 @@ func (d *Dir) rename(newParent *Dir, fsDir fs.Directory) {
 	d.entry = fsDir
 	d.path = fsDir.Remote()
 	d.read = time.Time{}
+	// Anything persisted at the new path is out of date
+	d.vfs.dirCache.remove(d.path, true)
 	d.mu.Unlock()
 }
 
@@ -297,6 +306,7 @@ func (d *Dir) addObject(node Node) {
 	d.mu.Lock()
 	leaf := node.Name()
 	d.items[leaf] = node
+	d.vfs.dirCache.remove(d.path, false)
 	if d.virtual == nil {
 		d.virtual = make(map[string]vState)
 	}
@@ -345,6 +355,7 @@ func (d *Dir) AddVirtual(leaf string, size int64, isDir bool) {
 func (d *Dir) delObject(leaf string) {
 	d.mu.Lock()
 	delete(d.items, leaf)
+	d.vfs.dirCache.remove(d.path, false)
 	if d.virtual == nil {
 		d.virtual = make(map[string]vState)
 	}
@@ -374,11 +385,23 @@ func (d *Dir) _readDir() error {
 	} else {
 		return nil
 	}
+	if !d.checked {
+		d.checked = true
+		if d._readDirFromPersisted(when) {
+			return nil
+		}
+	}
+	if d.vfs.isOffline() && d._readDirOffline() {
+		return nil
+	}
 	entries, err := list.DirSorted(context.TODO(), d.f, false, d.path)
 	if err == fs.ErrorDirNotFound {
 		// We treat directory not found as empty because we
 		// create directories on the fly
 	} else if err != nil {
+		if d.vfs.remoteFailed(err) && d._readDirOffline() {
+			return nil
+		}
 		return err
 	}
 
@@ -388,13 +411,67 @@ func (d *Dir) _readDir() error {
 	}
 
 	d.read = when
+	d.vfs.dirCache.put(d.path, entries, when)
 	return nil
 }
 
+// read the directory from the persisted directory cache if it is
+// there and not older than --dir-cache-time - must be called with the
+// lock held
+//
+// It returns true if the directory was read.
+func (d *Dir) _readDirFromPersisted(when time.Time) bool {
+	entries, read, ok := d.vfs.dirCache.get(d.path)
+	if !ok {
+		return false
+	}
+	age := when.Sub(read)
+	if age > d.vfs.Opt.DirCacheTime {
+		fs.Debugf(d.path, "Not using persisted directory cache as it is too old (%v old)", age)
+		return false
+	}
+	err := d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		return false
+	}
+	fs.Debugf(d.path, "Read directory from persisted cache (%v old)", age)
+	d.read = read
+	return true
+}
+
+// use the directory entries already read or the persisted directory
+// cache whatever its age as the remote is unreachable - must be called
+// with the lock held
+//
+// It returns true if there was anything cached to use. d.read is left
+// alone so the directory is read again when the remote is back.
+func (d *Dir) _readDirOffline() bool {
+	if !d.read.IsZero() || len(d.items) != 0 {
+		fs.Debugf(d.path, "Remote is unreachable - using cached directory listing")
+		return true
+	}
+	entries, _, ok := d.vfs.dirCache.get(d.path)
+	if !ok {
+		return false
+	}
+	err := d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		return false
+	}
+	fs.Debugf(d.path, "Remote is unreachable - using persisted directory cache")
+	return true
+}
+
 // update d.items for each dir in the DirTree below this one and
 // set the last read time - must be called with the lock held
 func (d *Dir) _readDirFromDirTree(dirTree dirtree.DirTree, when time.Time) error {
-	return d._readDirFromEntries(dirTree[d.path], dirTree, when)
+	d.checked = true
+	err := d._readDirFromEntries(dirTree[d.path], dirTree, when)
+	if err != nil {
+		return err
+	}
+	d.vfs.dirCache.put(d.path, dirTree[d.path], when)
+	return nil
 }
 
 // Remove the virtual directory entry leaf
@@ -451,7 +528,7 @@ func (d *Dir) _purgeVirtual() {
 				// if writing in progress then leave virtual
 				continue
 			}
-			if d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal && d.vfs.cache.InUse(f.Path()) {
+			if d.vfs.cacheMode(f.Path()) >= vfscommon.CacheModeMinimal && d.vfs.cache.InUse(f.Path()) {
 				// if object in use or dirty then leave virtual
 				continue
 			}
@@ -612,6 +689,7 @@ func (d *Dir) readDir() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.read = time.Time{}
+	d.checked = true
 	return d._readDir()
 }
 
@@ -620,6 +698,10 @@ func (d *Dir) readDir() error {
 // returns ENOENT if not found.
 // returns a custom error if directory on a case-insensitive file system
 // contains files with names that differ only by case.
+//
+// With --vfs-fold-names set names which differ only by case or
+// unicode normalization match and the first of any which collide is
+// returned, as shown by ReadDirAll.
 func (d *Dir) stat(leaf string) (Node, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -629,7 +711,15 @@ func (d *Dir) stat(leaf string) (Node, error) {
 	}
 	item, ok := d.items[leaf]
 
-	if !ok && d.vfs.Opt.CaseInsensitive {
+	if !ok && d.vfs.Opt.FoldNames {
+		leafFolded := foldName(leaf)
+		for name, node := range d.items {
+			if foldName(name) == leafFolded && (!ok || name < item.Name()) {
+				ok = true
+				item = node
+			}
+		}
+	} else if !ok && d.vfs.Opt.CaseInsensitive {
 		leafLower := strings.ToLower(leaf)
 		for name, node := range d.items {
 			if strings.ToLower(name) == leafLower {
@@ -685,6 +775,29 @@ func (d *Dir) SetModTime(modTime time.Time) error {
 	return nil
 }
 
+// Metadata returns the metadata of the directory or nil if the
+// backend doesn't support it
+func (d *Dir) Metadata() (fs.Metadata, error) {
+	do := d.f.Features().DirMetadata
+	if do == nil {
+		return nil, nil
+	}
+	return do(context.TODO(), d.path)
+}
+
+// SetMetadata sets the extended attributes of the directory to those
+// in metadata
+func (d *Dir) SetMetadata(metadata fs.Metadata) error {
+	if d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	do := d.f.Features().DirSetMetadata
+	if do == nil {
+		return ENOTSUP
+	}
+	return do(context.TODO(), d.path, metadata.Xattrs())
+}
+
 func (d *Dir) cachedDir(relativePath string) (dir *Dir) {
 	dir, _ = d.cachedNode(relativePath).(*Dir)
 	return
@@ -746,6 +859,9 @@ func (d *Dir) ReadDirAll() (items Nodes, err error) {
 	}
 	d.mu.Unlock()
 	sort.Sort(items)
+	if d.vfs.Opt.FoldNames {
+		items = d.foldNodes(items)
+	}
 	// fs.Debugf(d.path, "Dir.ReadDirAll OK with %d entries", len(items))
 	return items, nil
 }
@@ -890,6 +1006,17 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 		fs.Errorf(oldPath, "Dir.Rename error: %v", err)
 		return err
 	}
+	// The name may have been matched case insensitively
+	oldLeaf := oldNode.Name()
+	if d.vfs.Opt.FoldNames {
+		// Replace a file whose name only differs by case or
+		// normalization rather than making a second one
+		if existing, err := destDir.stat(newName); err == nil && existing != oldNode && existing.Name() != newName {
+			fs.Debugf(newPath, "Dir.Rename replacing %q with --vfs-fold-names", existing.Name())
+			newName = existing.Name()
+			newPath = path.Join(destDir.path, newName)
+		}
+	}
 	switch x := oldNode.DirEntry().(type) {
 	case nil:
 		if oldFile, ok := oldNode.(*File); ok {
@@ -941,7 +1068,7 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 	}
 
 	// Show moved - delete from old dir and add to new
-	d.delObject(oldName)
+	d.delObject(oldLeaf)
 	destDir.addObject(oldNode)
 
 	// fs.Debugf(newPath, "Dir.Rename renamed from %q", oldPath)
@@ -0,0 +1,362 @@
+// +build !plan9,!js
+
+// Persist the directory cache with --vfs-dir-cache-persist
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dirCacheDirsBucket = "dirs"
+	dirCacheMetaBucket = "meta"
+	dirCacheVersionKey = "version"
+	dirCacheTokenKey   = "changeToken"
+	dirCacheVersion    = "1" // change this if the format changes
+	dirCacheBatch      = 1000
+)
+
+// dirCache persists the directory listings read by the VFS so they
+// can be used instead of listing the remote again after a restart.
+//
+// The listings are written to a bolt database by a background
+// goroutine so reading directories isn't slowed down.
+type dirCache struct {
+	f      fs.Fs
+	db     *bolt.DB
+	mu     sync.Mutex // protects closed
+	closed bool
+	ops    chan dirCacheOp
+	done   chan struct{} // closed when the writer has finished
+}
+
+// dirCacheOp is an update for the writer
+type dirCacheOp struct {
+	dir       string
+	entries   fs.DirEntries // listing to store if set
+	read      time.Time     // time the listing was read
+	put       bool          // set to store the listing rather than remove it
+	recursive bool          // set to remove the listings of all subdirectories too
+	flushed   chan struct{} // if set closed once all the previous ops are written
+}
+
+// newDirCache opens the persisted directory cache for f, discarding
+// any listings which have changed since it was last used.
+func newDirCache(ctx context.Context, f fs.Fs) (*dirCache, error) {
+	dbPath, err := dirCachePath(f)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(dbPath), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create directory cache directory")
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open directory cache %q - is another rclone using it?", dbPath)
+	}
+	fs.Debugf(f, "vfs dir cache: database is %q", dbPath)
+	dc := &dirCache{
+		f:    f,
+		db:   db,
+		ops:  make(chan dirCacheOp, dirCacheBatch),
+		done: make(chan struct{}),
+	}
+	err = dc.init(ctx)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	go dc.writer()
+	return dc, nil
+}
+
+// dirCachePath returns the path of the database for f in the --cache-dir
+func dirCachePath(f fs.Fs) (string, error) {
+	fRoot := filepath.FromSlash(f.Root())
+	if runtime.GOOS == "windows" {
+		if strings.HasPrefix(fRoot, `\\?`) {
+			fRoot = fRoot[3:]
+		}
+		fRoot = strings.Replace(fRoot, ":", "", -1)
+	}
+	cacheDir, err := filepath.Abs(config.CacheDir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make --cache-dir absolute")
+	}
+	return file.UNCPath(filepath.Join(cacheDir, "vfsDir", f.Name(), fRoot, "dirs.db")), nil
+}
+
+// dirCacheKey returns the key the listing of dir is stored under
+func dirCacheKey(dir string) []byte {
+	return []byte("/" + dir)
+}
+
+// dirCachePrefix returns the prefix of the keys of the listings of
+// the subdirectories of dir
+func dirCachePrefix(dir string) []byte {
+	if dir == "" {
+		return []byte("/")
+	}
+	return []byte("/" + dir + "/")
+}
+
+// init makes the buckets and removes the listings which are out of
+// date.
+//
+// If the remote can list the changes since a change token then the
+// listings of the directories which have changed since the last run
+// are removed, otherwise the listings are used until they are older
+// than --dir-cache-time.
+func (dc *dirCache) init(ctx context.Context) error {
+	features := dc.f.Features()
+	var (
+		token   string
+		changed []string
+		purge   bool
+	)
+	err := dc.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(dirCacheMetaBucket))
+		if meta == nil || string(meta.Get([]byte(dirCacheVersionKey))) != dirCacheVersion {
+			purge = true
+			return nil
+		}
+		if features.ChangeToken == nil {
+			return nil
+		}
+		// Read a new token before the changes so none are missed
+		var err error
+		token, err = features.ChangeToken(ctx)
+		if err != nil {
+			fs.Errorf(dc.f, "vfs dir cache: discarding persisted directories as failed to read change token: %v", err)
+			purge = true
+			return nil
+		}
+		oldToken := string(meta.Get([]byte(dirCacheTokenKey)))
+		if oldToken == "" {
+			// Don't know what has changed
+			purge = true
+			return nil
+		}
+		changed, _, err = features.Changes(ctx, oldToken)
+		if err != nil {
+			fs.Errorf(dc.f, "vfs dir cache: discarding persisted directories as failed to read changes: %v", err)
+			purge = true
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to read directory cache")
+	}
+	err = dc.db.Update(func(tx *bolt.Tx) error {
+		if purge {
+			err := tx.DeleteBucket([]byte(dirCacheDirsBucket))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(dirCacheMetaBucket))
+		if err != nil {
+			return err
+		}
+		dirs, err := tx.CreateBucketIfNotExists([]byte(dirCacheDirsBucket))
+		if err != nil {
+			return err
+		}
+		for _, changedPath := range changed {
+			err = dirCacheRemove(dirs, vfscommon.FindParent(changedPath), false)
+			if err != nil {
+				return err
+			}
+			// The path may be a directory
+			err = dirCacheRemove(dirs, changedPath, true)
+			if err != nil {
+				return err
+			}
+		}
+		if len(changed) > 0 {
+			fs.Debugf(dc.f, "vfs dir cache: invalidated %d changed paths", len(changed))
+		}
+		err = meta.Put([]byte(dirCacheVersionKey), []byte(dirCacheVersion))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(dirCacheTokenKey), []byte(token))
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to initialise directory cache")
+	}
+	return nil
+}
+
+// dirCacheRemove removes the listing of dir from the bucket and those
+// of its subdirectories if recursive is set
+func dirCacheRemove(dirs *bolt.Bucket, dir string, recursive bool) error {
+	err := dirs.Delete(dirCacheKey(dir))
+	if err != nil || !recursive {
+		return err
+	}
+	prefix := dirCachePrefix(dir)
+	c := dirs.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		err = dirs.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send passes op to the writer returning false if the cache is closed
+func (dc *dirCache) send(op dirCacheOp) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.closed {
+		return false
+	}
+	dc.ops <- op
+	return true
+}
+
+// writer writes the ops to the database in batches until the cache
+// is closed
+func (dc *dirCache) writer() {
+	defer close(dc.done)
+	for op := range dc.ops {
+		batch := []dirCacheOp{op}
+	collect:
+		for len(batch) < dirCacheBatch {
+			select {
+			case op, ok := <-dc.ops:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, op)
+			default:
+				break collect
+			}
+		}
+		dc.commit(batch)
+	}
+}
+
+// commit writes the batch of ops to the database
+func (dc *dirCache) commit(batch []dirCacheOp) {
+	err := dc.db.Update(func(tx *bolt.Tx) error {
+		dirs := tx.Bucket([]byte(dirCacheDirsBucket))
+		for _, op := range batch {
+			if op.flushed != nil {
+				continue
+			}
+			if !op.put {
+				err := dirCacheRemove(dirs, op.dir, op.recursive)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := json.Marshal(newDirCacheListing(context.Background(), dc.f, op.entries, op.read))
+			if err != nil {
+				return err
+			}
+			err = dirs.Put(dirCacheKey(op.dir), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fs.Errorf(dc.f, "vfs dir cache: failed to write: %v", err)
+	}
+	for _, op := range batch {
+		if op.flushed != nil {
+			close(op.flushed)
+		}
+	}
+}
+
+// get returns the persisted listing of dir and the time it was read
+// or ok false if it isn't persisted
+func (dc *dirCache) get(dir string) (entries fs.DirEntries, read time.Time, ok bool) {
+	if dc == nil {
+		return nil, read, false
+	}
+	// Wait for the pending updates to be written so we don't read
+	// a listing which has been removed
+	flushed := make(chan struct{})
+	if !dc.send(dirCacheOp{flushed: flushed}) {
+		return nil, read, false
+	}
+	<-flushed
+	var listing dirCacheListing
+	err := dc.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(dirCacheDirsBucket)).Get(dirCacheKey(dir))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &listing)
+	})
+	if err != nil {
+		fs.Errorf(dc.f, "vfs dir cache: failed to read %q: %v", dir, err)
+		return nil, read, false
+	}
+	if !ok {
+		return nil, read, false
+	}
+	return listing.dirEntries(dc.f, dir), listing.Read, true
+}
+
+// put persists the listing entries of dir read at read
+func (dc *dirCache) put(dir string, entries fs.DirEntries, read time.Time) {
+	if dc == nil {
+		return
+	}
+	dc.send(dirCacheOp{dir: dir, entries: entries, read: read, put: true})
+}
+
+// remove removes the persisted listing of dir and those of its
+// subdirectories if recursive is set
+func (dc *dirCache) remove(dir string, recursive bool) {
+	if dc == nil {
+		return
+	}
+	dc.send(dirCacheOp{dir: dir, recursive: recursive})
+}
+
+// close writes the pending updates and closes the database
+func (dc *dirCache) close() {
+	if dc == nil {
+		return
+	}
+	dc.mu.Lock()
+	if dc.closed {
+		dc.mu.Unlock()
+		return
+	}
+	dc.closed = true
+	close(dc.ops)
+	dc.mu.Unlock()
+	<-dc.done
+	err := dc.db.Close()
+	if err != nil {
+		fs.Errorf(dc.f, "vfs dir cache: failed to close: %v", err)
+	}
+}
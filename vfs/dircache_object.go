@@ -0,0 +1,235 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// dirCacheListing is a directory listing as persisted by the dirCache
+type dirCacheListing struct {
+	Read    time.Time       `json:"read"`
+	Entries []dirCacheEntry `json:"entries"`
+}
+
+// dirCacheEntry is a file or directory in a dirCacheListing
+type dirCacheEntry struct {
+	Name    string            `json:"n"`
+	Dir     bool              `json:"d,omitempty"`
+	Size    int64             `json:"s"`
+	ModTime time.Time         `json:"t"`
+	ID      string            `json:"i,omitempty"`
+	Hashes  map[string]string `json:"h,omitempty"`
+}
+
+// newDirCacheListing makes a dirCacheListing from the entries read
+// from f at read.
+//
+// The hashes of the objects are stored too unless they are slow to
+// read.
+func newDirCacheListing(ctx context.Context, f fs.Fs, entries fs.DirEntries, read time.Time) *dirCacheListing {
+	var hashes []hash.Type
+	if !f.Features().SlowHash {
+		hashes = f.Hashes().Array()
+	}
+	listing := &dirCacheListing{
+		Read:    read,
+		Entries: make([]dirCacheEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		item := dirCacheEntry{
+			Name:    path.Base(entry.Remote()),
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(ctx),
+		}
+		switch x := entry.(type) {
+		case fs.Directory:
+			item.Dir = true
+			item.ID = x.ID()
+		case fs.Object:
+			for _, ht := range hashes {
+				sum, err := x.Hash(ctx, ht)
+				if err == nil && sum != "" {
+					if item.Hashes == nil {
+						item.Hashes = make(map[string]string, len(hashes))
+					}
+					item.Hashes[ht.String()] = sum
+				}
+			}
+		}
+		listing.Entries = append(listing.Entries, item)
+	}
+	return listing
+}
+
+// dirEntries returns the entries of the listing of dir in f
+func (listing *dirCacheListing) dirEntries(f fs.Fs, dir string) fs.DirEntries {
+	entries := make(fs.DirEntries, 0, len(listing.Entries))
+	for _, item := range listing.Entries {
+		remote := path.Join(dir, item.Name)
+		if item.Dir {
+			entries = append(entries, fs.NewDir(remote, item.ModTime).SetSize(item.Size).SetID(item.ID))
+			continue
+		}
+		o := &dirCacheObject{
+			f:       f,
+			remote:  remote,
+			size:    item.Size,
+			modTime: item.ModTime,
+		}
+		for name, sum := range item.Hashes {
+			var ht hash.Type
+			if ht.Set(name) == nil {
+				if o.hashes == nil {
+					o.hashes = make(map[hash.Type]string, len(item.Hashes))
+				}
+				o.hashes[ht] = sum
+			}
+		}
+		entries = append(entries, o)
+	}
+	return entries
+}
+
+// dirCacheObject is a file read from the persisted directory cache.
+//
+// It finds the object on the remote the first time it is needed for
+// anything the listing didn't store.
+type dirCacheObject struct {
+	f       fs.Fs
+	remote  string
+	mu      sync.Mutex // protects the following
+	hashes  map[hash.Type]string
+	size    int64
+	modTime time.Time
+	o       fs.Object // the object on the remote if found
+}
+
+// object returns the object on the remote, finding it if necessary
+func (o *dirCacheObject) object(ctx context.Context) (fs.Object, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o == nil {
+		obj, err := o.f.NewObject(ctx, o.remote)
+		if err != nil {
+			return nil, err
+		}
+		o.o = obj
+	}
+	return o.o, nil
+}
+
+// update reads the size and modification time from the remote object
+// after it has been changed
+func (o *dirCacheObject) update(ctx context.Context, obj fs.Object) {
+	o.mu.Lock()
+	o.size = obj.Size()
+	o.modTime = obj.ModTime(ctx)
+	o.hashes = nil
+	o.mu.Unlock()
+}
+
+// Fs returns the Fs the object is in
+func (o *dirCacheObject) Fs() fs.Info {
+	return o.f
+}
+
+// String returns the remote path
+func (o *dirCacheObject) String() string {
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *dirCacheObject) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time
+func (o *dirCacheObject) ModTime(ctx context.Context) time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.modTime
+}
+
+// Size returns the size of the file
+func (o *dirCacheObject) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// Storable returns whether the object is storable
+func (o *dirCacheObject) Storable() bool {
+	return true
+}
+
+// Hash returns the stored hash of type ht or reads it from the remote
+func (o *dirCacheObject) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	o.mu.Lock()
+	sum, ok := o.hashes[ht]
+	o.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	obj, err := o.object(ctx)
+	if err != nil {
+		return "", err
+	}
+	return obj.Hash(ctx, ht)
+}
+
+// SetModTime sets the modification time of the remote object
+func (o *dirCacheObject) SetModTime(ctx context.Context, modTime time.Time) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	err = obj.SetModTime(ctx, modTime)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.modTime = modTime
+	o.mu.Unlock()
+	return nil
+}
+
+// Open opens the remote object for reading
+func (o *dirCacheObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Open(ctx, options...)
+}
+
+// Update replaces the contents of the remote object
+func (o *dirCacheObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	err = obj.Update(ctx, in, src, options...)
+	if err != nil {
+		return err
+	}
+	o.update(ctx, obj)
+	return nil
+}
+
+// Remove removes the remote object
+func (o *dirCacheObject) Remove(ctx context.Context) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// Check the interfaces are satisfied
+var _ fs.Object = (*dirCacheObject)(nil)
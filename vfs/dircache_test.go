@@ -0,0 +1,81 @@
+// +build !plan9,!js
+
+package vfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirCachePersist(t *testing.T) {
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	cacheDir, err := ioutil.TempDir("", "rclone-vfs-dircache")
+	require.NoError(t, err)
+	oldCacheDir := config.CacheDir
+	config.CacheDir = cacheDir
+	defer func() {
+		config.CacheDir = oldCacheDir
+		_ = os.RemoveAll(cacheDir)
+	}()
+
+	opt := vfscommon.DefaultOpt
+	opt.DirCachePersist = true
+	opt.DirCacheTime = time.Hour
+
+	file1 := r.WriteObject(context.Background(), "dir/file1", "file1 contents", t1)
+	fstest.CheckItems(t, r.Fremote, file1)
+
+	// Read the directory to persist it
+	vfs := New(r.Fremote, &opt)
+	require.NotNil(t, vfs.dirCache)
+	_, err = vfs.ReadDir("dir")
+	require.NoError(t, err)
+	vfs.Shutdown()
+
+	// Change the remote behind the VFS's back
+	file2 := r.WriteObject(context.Background(), "dir/file2", "file2 contents", t2)
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+
+	// The persisted listing is used so file2 isn't seen
+	vfs = New(r.Fremote, &opt)
+	node, err := vfs.Stat("dir/file1")
+	require.NoError(t, err)
+	assert.Equal(t, file1.Size, node.Size())
+	assert.Equal(t, t1.Unix(), node.ModTime().Unix())
+	_, err = vfs.Stat("dir/file2")
+	assert.Equal(t, ENOENT, err)
+
+	// The file can be read though it came from the persisted listing
+	data, err := vfs.ReadFile("dir/file1")
+	require.NoError(t, err)
+	assert.Equal(t, "file1 contents", string(data))
+
+	// Forgetting the directory cache removes it from disk too
+	vfs.FlushDirCache()
+	vfs.Shutdown()
+
+	vfs = New(r.Fremote, &opt)
+	_, err = vfs.Stat("dir/file2")
+	assert.NoError(t, err)
+	vfs.Shutdown()
+
+	// Listings older than --dir-cache-time aren't used
+	file3 := r.WriteObject(context.Background(), "dir/file3", "file3 contents", t3)
+	fstest.CheckItems(t, r.Fremote, file1, file2, file3)
+	opt.DirCacheTime = time.Nanosecond
+	vfs = New(r.Fremote, &opt)
+	_, err = vfs.Stat("dir/file3")
+	assert.NoError(t, err)
+	vfs.Shutdown()
+}
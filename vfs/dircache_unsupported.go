@@ -0,0 +1,33 @@
+// Build for unsupported platforms to stop go complaining
+// about "no buildable Go source files "
+
+// +build plan9 js
+
+package vfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// dirCache isn't supported on this platform
+type dirCache struct{}
+
+// newDirCache returns an error as the directory cache can't be
+// persisted on this platform
+func newDirCache(ctx context.Context, f fs.Fs) (*dirCache, error) {
+	return nil, errors.New("persisting the directory cache isn't supported on this platform")
+}
+
+func (dc *dirCache) get(dir string) (entries fs.DirEntries, read time.Time, ok bool) {
+	return nil, read, false
+}
+
+func (dc *dirCache) put(dir string, entries fs.DirEntries, read time.Time) {}
+
+func (dc *dirCache) remove(dir string, recursive bool) {}
+
+func (dc *dirCache) close() {}
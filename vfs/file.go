@@ -46,6 +46,7 @@ type File struct {
 	writers          []Handle                        // writers for this file
 	nwriters         int32                           // len(writers) which is read/updated with atomic
 	pendingModTime   time.Time                       // will be applied once o becomes available, i.e. after file was written
+	pendingMetadata  fs.Metadata                     // extended attributes to be applied once o becomes available
 	pendingRenameFun func(ctx context.Context) error // will be run/renamed after all writers close
 	appendMode       bool                            // file was opened with O_APPEND
 	sys              atomic.Value                    // user defined info to be attached here
@@ -237,9 +238,12 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 	writing := f._writingInProgress()
 	f.mu.Unlock()
 
+	// The locks belong to the file so move them with it
+	d.vfs.locks.rename(oldPath, newPath)
+
 	// Delay the rename if not using RW caching. For the minimal case we
 	// need to look in the cache to see if caching is in use.
-	CacheMode := d.vfs.Opt.CacheMode
+	CacheMode := d.vfs.cacheMode(oldPath)
 	if writing &&
 		(CacheMode < vfscommon.CacheModeMinimal ||
 			(CacheMode == vfscommon.CacheModeMinimal && !destDir.vfs.cache.Exists(oldPath))) {
@@ -323,7 +327,7 @@ func (f *File) Size() int64 {
 	defer f.mu.RUnlock()
 
 	// Read the size from a dirty item if it exists
-	if f.d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal {
+	if f.d.vfs.cacheMode(f._path()) >= vfscommon.CacheModeMinimal {
 		if item := f.d.vfs.cache.DirtyItem(f._path()); item != nil {
 			size, err := item.GetSize()
 			if err != nil {
@@ -397,6 +401,71 @@ func (f *File) _applyPendingModTime() error {
 	return nil
 }
 
+// Metadata returns the metadata of the file or nil if the backend
+// doesn't support it
+func (f *File) Metadata() (fs.Metadata, error) {
+	f.mu.RLock()
+	o, pendingMetadata := f.o, f.pendingMetadata
+	f.mu.RUnlock()
+	if pendingMetadata != nil {
+		return pendingMetadata, nil
+	}
+	if o == nil {
+		return nil, nil
+	}
+	return fs.GetMetadata(context.TODO(), o)
+}
+
+// SetMetadata sets the extended attributes of the file to those in
+// metadata
+func (f *File) SetMetadata(metadata fs.Metadata) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	if f.o != nil {
+		if _, ok := f.o.(fs.SetMetadataer); !ok {
+			return ENOTSUP
+		}
+	}
+
+	f.pendingMetadata = metadata.Xattrs()
+
+	// Only update the metadata when there are no writers, setObject will do it
+	if !f._writingInProgress() {
+		return f._applyPendingMetadata()
+	}
+
+	// queue up for later, hoping f.o becomes available
+	return nil
+}
+
+// Apply pending metadata
+// Call with the mutex held
+func (f *File) _applyPendingMetadata() error {
+	if f.pendingMetadata == nil {
+		return nil
+	}
+	defer func() { f.pendingMetadata = nil }()
+
+	if f.o == nil {
+		return errors.New("Cannot apply metadata, file object is not available")
+	}
+	do, ok := f.o.(fs.SetMetadataer)
+	if !ok {
+		fs.Debugf(f.o, "Can't set metadata on this remote")
+		return ENOTSUP
+	}
+	err := do.SetMetadata(context.TODO(), f.pendingMetadata)
+	if err != nil {
+		fs.Errorf(f.o, "Failed to apply pending metadata: %v", err)
+		return err
+	}
+	fs.Debugf(f.o, "Applied pending metadata OK")
+	return nil
+}
+
 // _writingInProgress returns true of there are any open writers
 // Call with read lock held
 func (f *File) _writingInProgress() bool {
@@ -420,6 +489,7 @@ func (f *File) setObject(o fs.Object) {
 	f.mu.Lock()
 	f.o = o
 	_ = f._applyPendingModTime()
+	_ = f._applyPendingMetadata()
 	d := f.d
 	f.mu.Unlock()
 
@@ -667,7 +737,7 @@ func (f *File) Open(flags int) (fd Handle, err error) {
 	f.mu.RLock()
 	d := f.d
 	f.mu.RUnlock()
-	CacheMode := d.vfs.Opt.CacheMode
+	CacheMode := d.vfs.cacheMode(f.Path())
 	if CacheMode >= vfscommon.CacheModeMinimal && (d.vfs.cache.InUse(f.Path()) || d.vfs.cache.Exists(f.Path())) {
 		fd, err = f.openRW(flags)
 	} else if read && write {
@@ -0,0 +1,64 @@
+// Folding names for --vfs-fold-names
+
+package vfs
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldName returns the key names are compared with when
+// --vfs-fold-names is set. Names which differ only by case or
+// unicode normalization have the same key.
+func foldName(name string) string {
+	return strings.ToLower(norm.NFC.String(name))
+}
+
+// collisions remembers the names hidden by --vfs-fold-names so each
+// is only logged once
+type collisions struct {
+	mu     sync.Mutex
+	hidden map[string]struct{}
+}
+
+// newCollisions makes a new empty set of collisions
+func newCollisions() *collisions {
+	return &collisions{
+		hidden: make(map[string]struct{}),
+	}
+}
+
+// hide logs that name is hidden by shown if it hasn't been already
+func (c *collisions) hide(dir, name, shown string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hiddenPath := path.Join(dir, name)
+	if _, found := c.hidden[hiddenPath]; found {
+		return
+	}
+	c.hidden[hiddenPath] = struct{}{}
+	fs.Errorf(hiddenPath, "Hiding file as its name differs from %q only by case or unicode normalization with --vfs-fold-names set", shown)
+}
+
+// foldNodes removes the nodes whose names fold to the same key as an
+// earlier node in the sorted nodes passed in, so only one of a set of
+// colliding names is shown.
+func (d *Dir) foldNodes(nodes Nodes) Nodes {
+	seen := make(map[string]string, len(nodes))
+	folded := nodes[:0]
+	for _, node := range nodes {
+		name := node.Name()
+		key := foldName(name)
+		if shown, found := seen[key]; found {
+			d.vfs.collisions.hide(d.path, name, shown)
+			continue
+		}
+		seen[key] = name
+		folded = append(folded, node)
+	}
+	return folded
+}
@@ -48,6 +48,31 @@ Or individual files or directories:
 
     rclone rc vfs/forget file=path/to/file dir=path/to/dir
 
+#### --vfs-dir-cache-persist
+
+Normally the directory cache is kept in memory so it has to be read
+from the remote again each time rclone starts. On remotes with a lot
+of files this can take a long time.
+
+    --vfs-dir-cache-persist   Persist the directory cache to disk so it can be used after a restart.
+
+With ` + "`--vfs-dir-cache-persist`" + ` the directory listings are
+stored in a database in the ` + "`--cache-dir`" + ` and read from there
+the first time each directory is used after a restart, so long as
+they are no older than ` + "`--dir-cache-time`" + `. This works best
+with a long ` + "`--dir-cache-time`" + ` and a remote which supports
+polling for changes.
+
+If the remote can list the changes made since a point in time (eg
+Google Drive and Dropbox) then the listings of the directories which
+have changed while rclone wasn't running are discarded when it starts.
+Otherwise changes made directly on the remote while rclone wasn't
+running won't be seen until the listings expire, or the cache is
+flushed as described above, which removes the persisted listings too.
+
+Only one rclone may use the persisted directory cache of a remote at
+once.
+
 ### VFS File Buffering
 
 The ` + "`--buffer-size`" + ` flag determines the amount of memory,
@@ -81,8 +106,10 @@ find that you need one or the other or both.
     --vfs-cache-mode CacheMode           Cache mode off|minimal|writes|full (default off)
     --vfs-cache-max-age duration         Max age of objects in the cache. (default 1h0m0s)
     --vfs-cache-max-size SizeSuffix      Max total size of objects in the cache. (default off)
+    --vfs-cache-min-free-space SizeSuffix   Remove objects from the cache to keep this much free space on the cache disk. (default off)
     --vfs-cache-poll-interval duration   Interval to poll the cache for stale objects. (default 1m0s)
     --vfs-write-back duration            Time to writeback files after last use when using cache. (default 5s)
+    --vfs-write-back-max-concurrency int   Max number of files to upload at once when using cache. 0 means use --transfers.
 
 If run with ` + "`-vv`" + ` rclone will print the location of the file cache.  The
 files are stored in the user cache file area which is OS dependent but
@@ -99,11 +126,33 @@ second. If rclone is quit or dies with files that haven't been
 uploaded, these will be uploaded next time rclone is run with the same
 flags.
 
+Up to --transfers files are uploaded at once. This can be lowered for
+the cache with --vfs-write-back-max-concurrency, for example to 1 to
+upload one file at a time on a slow or metered connection.
+
+The files waiting to be uploaded can be listed with
+` + "`rclone rc vfs/queue`" + `. Uploads can be paused and resumed with
+` + "`vfs/queue-pause`" + ` and ` + "`vfs/queue-resume`" + `, a file can be
+uploaded next with ` + "`vfs/queue-prioritize`" + ` and taken off the queue
+with ` + "`vfs/queue-cancel`" + `, which leaves it in the cache to be
+uploaded when it is next changed or rclone is restarted. See the
+[remote control docs](/rc/#vfs-queue) for details.
+
 If using --vfs-cache-max-size note that the cache may exceed this size
 for two reasons.  Firstly because it is only checked every
 --vfs-cache-poll-interval.  Secondly because open files cannot be
 evicted from the cache.
 
+As other programs use the same disk, a fixed --vfs-cache-max-size
+can't stop the cache filling it. With --vfs-cache-min-free-space
+rclone removes the least recently used files which aren't open from
+the cache whenever the free space on the disk holding the cache falls
+below the amount given. This is checked every
+--vfs-cache-poll-interval and, while files are being written to the
+cache, every second. If the free space can't be recovered, eg because
+the cache is full of open files or files waiting to be uploaded, the
+writes to the cache are slowed down until it can.
+
 #### --vfs-cache-mode off
 
 In this mode (the default) the cache will read directly from the remote and write
@@ -166,11 +215,51 @@ whereas the --vfs-read-ahead is buffered on disk.
 When using this mode it is recommended that --buffer-size is not set
 too big and --vfs-read-ahead is set large if required.
 
+Rclone also keeps track of the holes in files written through the
+cache - the parts of the file which were skipped over by seeking past
+the end of the file, extending it with truncate or punching holes with
+fallocate (mount2 only).  If the remote supports random access writes
+(currently only the local backend) then only the data is uploaded, so
+writing sparse files such as disk images doesn't upload all the zeros.
+Other remotes are sent the zeros as normal.
+
 **IMPORTANT** not all file systems support sparse files. In particular
 FAT/exFAT do not. Rclone will perform very badly if the cache
 directory is on a filesystem which doesn't support sparse files and it
 will log an ERROR message if one is detected.
 
+#### Encrypting the cache
+
+The files in the cache are normally stored as they are, so the
+contents of files from a crypt remote, for example, are stored
+unencrypted in the ` + "`--cache-dir`" + `.
+
+    --vfs-cache-encrypt                  Encrypt the files in the cache.
+    --vfs-cache-encrypt-keyfile string   File to make the cache encryption key from instead of the config password.
+
+With ` + "`--vfs-cache-encrypt`" + ` the contents of the cache files are
+encrypted with AES-256 in GCM mode in blocks of 64k. Each block is
+encrypted with a new random nonce every time it is written. The
+key is derived from the [config password](/docs/#configuration-encryption)
+so the config file must be encrypted, unless
+` + "`--vfs-cache-encrypt-keyfile`" + ` is given in which case the key
+is made from the contents of that file instead. The names of the files
+in the cache and their metadata are not encrypted.
+
+This protects the cached data from anyone who can read the cache
+directory but doesn't have the key. Each block is authenticated too so
+changes made to the cache files are detected when they are read and
+give an error rather than returning the changed data.
+
+Files which were cached before the encryption was turned on or off are
+removed from the cache and downloaded again, unless they haven't been
+uploaded yet, in which case they are uploaded first. Files cached with
+encryption can't be read or uploaded without the key.
+
+As the file contents are encrypted, extending a file with zeros, eg
+with truncate, writes the zeros to the cache file in full rather than
+leaving a sparse gap.
+
 ### VFS Performance
 
 These flags may be used to enable/disable features of the VFS for
@@ -198,6 +287,22 @@ unless it is set to "off" in which case there will be no limit.
     --vfs-read-chunk-size SizeSuffix        Read the source objects in chunks. (default 128M)
     --vfs-read-chunk-size-limit SizeSuffix  Max chunk doubling size (default "off")
 
+On remotes with high latency reading a file in sequence over a single
+connection can be slow. With --vfs-read-prefetch rclone will notice
+when a file is being read in sequence, for example when streaming
+media or copying a file, and fetch the next chunks of the file in
+parallel ahead of the reader, each over its own connection. After a
+seek the prefetching stops until another chunk has been read in
+sequence.
+
+    --vfs-read-prefetch int                        Number of chunks to read ahead in parallel when a file is read sequentially. 0 is off.
+    --vfs-read-prefetch-chunk-size SizeSuffix      Size of the chunks read ahead with --vfs-read-prefetch. (default 16M)
+
+The chunks read ahead are held in memory so this uses up to
+--vfs-read-prefetch times --vfs-read-prefetch-chunk-size of memory for
+each open file. This only comes into effect when not using an on disk
+cache file - use --vfs-read-ahead with --vfs-cache-mode full instead.
+
 Sometimes rclone is delivered reads or writes out of order. Rather
 than seeking rclone will wait a short time for the in sequence read or
 write to come in. These flags only come into effect when not using an
@@ -206,6 +311,88 @@ on disk cache file.
     --vfs-read-wait duration   Time to wait for in-sequence read before seeking. (default 20ms)
     --vfs-write-wait duration  Time to wait for in-sequence write before giving error. (default 1s)
 
+### VFS Rules
+
+The cache and prefetch options can be set differently for different
+parts of the remote with --vfs-rule. This is useful, for example, to
+use --vfs-cache-mode full for a media directory while leaving the rest
+of the remote uncached.
+
+    --vfs-rule stringArray   Override the cache and prefetch options for paths matching a glob, eg "media/** mode=full". Can be repeated.
+
+Each rule is a glob followed by one or more settings, separated by
+spaces, eg
+
+    --vfs-rule "/media/** mode=full read-ahead=64M max-age=24h"
+    --vfs-rule "*.iso prefetch=8 prefetch-chunk-size=32M"
+
+The settings are
+
+  - ` + "`mode`" + ` - the ` + "`--vfs-cache-mode`" + `
+  - ` + "`max-age`" + ` - the ` + "`--vfs-cache-max-age`" + `
+  - ` + "`read-ahead`" + ` - the ` + "`--vfs-read-ahead`" + `
+  - ` + "`prefetch`" + ` - the ` + "`--vfs-read-prefetch`" + `
+  - ` + "`prefetch-chunk-size`" + ` - the ` + "`--vfs-read-prefetch-chunk-size`" + `
+
+and any which aren't set are taken from the flags.
+
+The globs are matched against the path of the file in the same way as
+the [filters](/filtering/), so a glob starting with ` + "`/`" + ` matches
+from the root and one without matches at any depth. The rules are
+tried in the order they are given and the first one which matches is
+used.
+
+The cache is started if any of the rules need it, even if
+--vfs-cache-mode is off. Note that the rules are matched against the
+path of the file when it is opened, so renaming a file from one rule
+to another while it is open doesn't change how it is cached.
+
+### VFS Locking
+
+The VFS supports advisory file locks. They are held in memory by the
+rclone process and are shared by everything using the same VFS. The
+VFS is shared by users of the same remote with the same VFS options in
+one rclone process, for example mounts of the same remote made with
+the ` + "`mount/mount`" + ` remote control command, so locks taken through
+one of them are seen by the others. The locks taken by
+` + "`rclone serve nfs`" + ` and ` + "`rclone serve webdav`" + ` clients are
+shared in the same way.
+
+Locks aren't stored on the remote, so they don't work between
+different rclone processes or other users of the remote.
+
+With ` + "`rclone mount`" + ` both POSIX (fcntl) and BSD (flock) locks are
+supported. With ` + "`rclone cmount`" + ` locks are handled by the kernel
+so they only work between programs using the mount.
+
+### VFS Offline Mode
+
+Normally if the remote can't be reached, for example because the
+network connection has dropped, reading directories which aren't in
+the directory cache returns an I/O error.
+
+With ` + "`--vfs-offline`" + ` rclone notices when the remote is unreachable
+and keeps serving the directory listings it has already read, however
+old they are, including those in the persisted directory cache if
+` + "`--vfs-dir-cache-persist`" + ` is set. Files can be read if they are
+in the VFS cache, so ` + "`--vfs-cache-mode full`" + ` is recommended.
+Files written are kept in the VFS cache and queued for upload, so this
+needs ` + "`--vfs-cache-mode writes`" + ` or ` + "`full`" + `. Uploads which fail
+are retried with increasing delays.
+
+While offline rclone checks the remote every 30 seconds. When it can
+be reached again the directory listings are re-read and the queued
+uploads are retried straight away. If a file was changed on the remote
+while offline as well as locally, the local version is uploaded over
+it.
+
+Creating directories, renaming and deleting need the remote so they
+still fail while it is unreachable.
+
+The state of the remote and the upload queue can be seen with
+
+    rclone rc vfs/queue
+
 ### VFS Case Sensitivity
 
 Linux file systems are case-sensitive: two files can differ only
@@ -240,4 +427,22 @@ The flag controls whether "fixup" is performed to satisfy the target.
 If the flag is not provided on the command line, then its default value depends
 on the operating system where rclone runs: "true" on Windows and macOS, "false"
 otherwise. If the flag is provided without a value, then it is "true".
+
+#### --vfs-fold-names
+
+The "--vfs-fold-names" flag goes further to present a case-sensitive
+remote as a case-insensitive file system. Names which differ only by
+case or by unicode normalization, for example "café" written as one
+character "é" or as "e" followed by a combining accent, are treated as
+the same name.
+
+If the remote has more than one file with names which are the same
+this way, only the first in sorted order is listed and opened, and the
+others are hidden with an error logged. They can still be reached by
+their exact names.
+
+Opening, creating or renaming a file with a name which differs only by
+case or normalization from an existing one uses the existing file, so
+programs which vary the case of names don't create duplicates on the
+remote.
 `
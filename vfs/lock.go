@@ -0,0 +1,195 @@
+// Advisory file locking
+
+package vfs
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// LockEOF is the End of a lock which extends to the end of the file
+// however long it becomes. A whole file lock is from 0 to LockEOF.
+const LockEOF = math.MaxInt64
+
+// Lock is an advisory byte range lock on a file
+//
+// Locks are only advisory - they don't stop files being read or
+// written, they only conflict with other locks. They are shared
+// between all the users of the VFS, so locks taken by a mount, for
+// example, are seen by the servers serving the same VFS.
+type Lock struct {
+	// Owner identifies the holder of the lock. It must be a
+	// comparable value, and should be of a type private to the
+	// package taking the lock so owners from different packages
+	// can't be equal. Locks with the same Owner don't conflict.
+	Owner interface{}
+	Write bool  // set for an exclusive lock, otherwise the lock is shared
+	Start int64 // offset of the first byte locked
+	End   int64 // offset after the last byte locked or LockEOF
+}
+
+// overlaps returns whether l overlaps the range start, end
+func (l *Lock) overlaps(start, end int64) bool {
+	return l.Start < end && start < l.End
+}
+
+// conflicts returns whether l stops other being taken
+func (l *Lock) conflicts(other *Lock) bool {
+	return l.Owner != other.Owner && (l.Write || other.Write) && l.overlaps(other.Start, other.End)
+}
+
+// locks holds the locks on the files in the VFS keyed by path
+type locks struct {
+	mu      sync.Mutex
+	byPath  map[string][]Lock
+	changed chan struct{} // closed and replaced when any lock is removed
+}
+
+// newLocks makes a new empty lock table
+func newLocks() *locks {
+	return &locks{
+		byPath:  make(map[string][]Lock),
+		changed: make(chan struct{}),
+	}
+}
+
+// _conflicting returns the first lock on path which conflicts with l
+// or nil if there isn't one
+//
+// Call with mu held
+func (ls *locks) _conflicting(path string, l *Lock) *Lock {
+	for i := range ls.byPath[path] {
+		if held := &ls.byPath[path][i]; held.conflicts(l) {
+			lock := *held
+			return &lock
+		}
+	}
+	return nil
+}
+
+// _unlock removes the range start, end from the locks held by owner
+// on path, splitting locks which are partly in it
+//
+// Call with mu held
+func (ls *locks) _unlock(path string, owner interface{}, start, end int64) {
+	var newLocks []Lock
+	removed := false
+	for _, held := range ls.byPath[path] {
+		if held.Owner != owner || !held.overlaps(start, end) {
+			newLocks = append(newLocks, held)
+			continue
+		}
+		removed = true
+		// Keep the parts of the lock outside the range
+		if held.Start < start {
+			before := held
+			before.End = start
+			newLocks = append(newLocks, before)
+		}
+		if held.End > end {
+			after := held
+			after.Start = end
+			newLocks = append(newLocks, after)
+		}
+	}
+	if len(newLocks) == 0 {
+		delete(ls.byPath, path)
+	} else {
+		ls.byPath[path] = newLocks
+	}
+	if removed {
+		// wake up anyone waiting for a lock
+		close(ls.changed)
+		ls.changed = make(chan struct{})
+	}
+}
+
+// tryLock takes the lock l on path if it doesn't conflict with any
+// other lock, returning the conflicting lock if it does.
+//
+// If there is a conflict it also returns a channel which is closed
+// the next time a lock is removed.
+func (ls *locks) tryLock(path string, l Lock) (conflict *Lock, changed <-chan struct{}) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if conflict = ls._conflicting(path, &l); conflict != nil {
+		return conflict, ls.changed
+	}
+	ls._unlock(path, l.Owner, l.Start, l.End)
+	ls.byPath[path] = append(ls.byPath[path], l)
+	return nil, nil
+}
+
+// rename moves the locks on oldPath to newPath
+func (ls *locks) rename(oldPath, newPath string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if held, ok := ls.byPath[oldPath]; ok {
+		delete(ls.byPath, oldPath)
+		ls.byPath[newPath] = held
+	}
+}
+
+// TestLock returns the first lock on the file at path which would
+// stop l being taken, or nil if l could be taken.
+func (vfs *VFS) TestLock(path string, l Lock) *Lock {
+	vfs.locks.mu.Lock()
+	defer vfs.locks.mu.Unlock()
+	return vfs.locks._conflicting(path, &l)
+}
+
+// Lock takes the lock l on the file at path, returning EAGAIN if a
+// conflicting lock is held.
+//
+// Any locks already held by l.Owner in the range of l are replaced,
+// so this can be used to change the type of a lock.
+func (vfs *VFS) Lock(path string, l Lock) error {
+	if conflict, _ := vfs.locks.tryLock(path, l); conflict != nil {
+		return EAGAIN
+	}
+	return nil
+}
+
+// LockWait takes the lock l on the file at path like Lock, but waits
+// for any conflicting locks to be released first.
+//
+// It returns the context error if the context is cancelled while
+// waiting.
+func (vfs *VFS) LockWait(ctx context.Context, path string, l Lock) error {
+	for {
+		conflict, changed := vfs.locks.tryLock(path, l)
+		if conflict == nil {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Unlock releases the range start, end of the locks held by owner on
+// the file at path. Use 0, LockEOF to release all of them.
+func (vfs *VFS) Unlock(path string, owner interface{}, start, end int64) {
+	vfs.locks.mu.Lock()
+	defer vfs.locks.mu.Unlock()
+	vfs.locks._unlock(path, owner, start, end)
+}
+
+// UnlockAll releases all the locks held by owner on any file
+func (vfs *VFS) UnlockAll(owner interface{}) {
+	vfs.locks.mu.Lock()
+	defer vfs.locks.mu.Unlock()
+	for path := range vfs.locks.byPath {
+		vfs.locks._unlock(path, owner, 0, LockEOF)
+	}
+}
+
+// Locks returns the locks held on the file at path
+func (vfs *VFS) Locks(path string) []Lock {
+	vfs.locks.mu.Lock()
+	defer vfs.locks.mu.Unlock()
+	return append([]Lock(nil), vfs.locks.byPath[path]...)
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLockOwner string
+
+func TestLockConflicts(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	a, b := testLockOwner("a"), testLockOwner("b")
+
+	// Read locks can be shared
+	require.NoError(t, vfs.Lock("file", Lock{Owner: a, Start: 0, End: 10}))
+	require.NoError(t, vfs.Lock("file", Lock{Owner: b, Start: 5, End: 15}))
+
+	// But stop write locks
+	assert.Equal(t, EAGAIN, vfs.Lock("file", Lock{Owner: b, Write: true, Start: 0, End: 5}))
+	conflict := vfs.TestLock("file", Lock{Owner: b, Write: true, Start: 0, End: 5})
+	require.NotNil(t, conflict)
+	assert.Equal(t, Lock{Owner: a, Start: 0, End: 10}, *conflict)
+
+	// Locks on other ranges and files don't conflict
+	assert.Nil(t, vfs.TestLock("file", Lock{Owner: b, Write: true, Start: 15, End: LockEOF}))
+	assert.Nil(t, vfs.TestLock("file2", Lock{Owner: b, Write: true, Start: 0, End: LockEOF}))
+
+	// The owner's own locks don't conflict so can be upgraded
+	vfs.Unlock("file", b, 0, LockEOF)
+	require.NoError(t, vfs.Lock("file", Lock{Owner: a, Write: true, Start: 2, End: 4}))
+	assert.Equal(t, []Lock{
+		{Owner: a, Start: 0, End: 2},
+		{Owner: a, Start: 4, End: 10},
+		{Owner: a, Write: true, Start: 2, End: 4},
+	}, vfs.Locks("file"))
+
+	// Unlocking part of a lock splits it
+	vfs.Unlock("file", a, 5, 7)
+	assert.Equal(t, []Lock{
+		{Owner: a, Start: 0, End: 2},
+		{Owner: a, Start: 4, End: 5},
+		{Owner: a, Start: 7, End: 10},
+		{Owner: a, Write: true, Start: 2, End: 4},
+	}, vfs.Locks("file"))
+
+	// Unlocking another owner's locks does nothing
+	vfs.Unlock("file", b, 0, LockEOF)
+	assert.Len(t, vfs.Locks("file"), 4)
+
+	vfs.Unlock("file", a, 0, LockEOF)
+	assert.Len(t, vfs.Locks("file"), 0)
+	assert.Len(t, vfs.locks.byPath, 0)
+}
+
+func TestLockWait(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	a, b := testLockOwner("a"), testLockOwner("b")
+	require.NoError(t, vfs.Lock("file", Lock{Owner: a, Write: true, Start: 0, End: LockEOF}))
+
+	// Check the wait is cancelled by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := vfs.LockWait(ctx, "file", Lock{Owner: b, Start: 0, End: 1})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Check the wait finishes when the lock is released
+	done := make(chan error)
+	go func() {
+		done <- vfs.LockWait(context.Background(), "file", Lock{Owner: b, Start: 0, End: 1})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("LockWait returned early with %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	vfs.Unlock("file", a, 0, LockEOF)
+	require.NoError(t, <-done)
+	assert.Equal(t, []Lock{{Owner: b, Start: 0, End: 1}}, vfs.Locks("file"))
+}
+
+func TestLockUnlockAll(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	a, b := testLockOwner("a"), testLockOwner("b")
+	require.NoError(t, vfs.Lock("file1", Lock{Owner: a, Start: 0, End: LockEOF}))
+	require.NoError(t, vfs.Lock("file2", Lock{Owner: a, Start: 0, End: LockEOF}))
+	require.NoError(t, vfs.Lock("file2", Lock{Owner: b, Start: 0, End: LockEOF}))
+
+	vfs.UnlockAll(a)
+	assert.Len(t, vfs.Locks("file1"), 0)
+	assert.Equal(t, []Lock{{Owner: b, Start: 0, End: LockEOF}}, vfs.Locks("file2"))
+}
+
+func TestLockRename(t *testing.T) {
+	r, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	features := r.Fremote.Features()
+	if features.Move == nil && features.Copy == nil {
+		t.Skip("skip as can't rename files")
+	}
+
+	r.WriteObject(context.Background(), "dir/file1", "file1 contents", t1)
+	a := testLockOwner("a")
+	lock := Lock{Owner: a, Write: true, Start: 0, End: LockEOF}
+	require.NoError(t, vfs.Lock("dir/file1", lock))
+
+	require.NoError(t, vfs.Rename("dir/file1", "file2"))
+	assert.Len(t, vfs.Locks("dir/file1"), 0)
+	assert.Equal(t, []Lock{lock}, vfs.Locks("file2"))
+}
@@ -0,0 +1,138 @@
+// Passing on change notifications from the remote
+
+package vfs
+
+import (
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// change is a change to a path reported by the remote
+type change struct {
+	path      string
+	entryType fs.EntryType
+}
+
+// subscriber receives the changes reported by the remote
+//
+// The changes are queued without limit so passing them on never
+// blocks the remote's change notifications.
+type subscriber struct {
+	fn    func(path string, entryType fs.EntryType)
+	mu    sync.Mutex
+	queue []change      // changes waiting for fn
+	wake  chan struct{} // signalled when changes are queued
+	stop  chan struct{} // closed to stop run
+	done  chan struct{} // closed when run has returned
+}
+
+// add queues a change for fn
+func (s *subscriber) add(c change) {
+	s.mu.Lock()
+	s.queue = append(s.queue, c)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the next change from the queue returning false if it is empty
+func (s *subscriber) next() (c change, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return c, false
+	}
+	c = s.queue[0]
+	s.queue[0] = change{}
+	s.queue = s.queue[1:]
+	return c, true
+}
+
+// run calls fn with the changes until stop is closed
+func (s *subscriber) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+		for {
+			c, ok := s.next()
+			if !ok {
+				break
+			}
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			s.fn(c.path, c.entryType)
+		}
+	}
+}
+
+// notifiers holds the subscribers to the changes of the VFS
+type notifiers struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+// newNotifiers makes a new empty set of subscribers
+func newNotifiers() *notifiers {
+	return &notifiers{
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// notify passes the change to all the subscribers
+func (ns *notifiers) notify(path string, entryType fs.EntryType) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	for s := range ns.subscribers {
+		s.add(change{path: path, entryType: entryType})
+	}
+}
+
+// ChangeNotify calls fn with the path of each file or directory the
+// remote reports has changed, after the VFS has invalidated its own
+// caches for it. It is used by the mounts to tell the OS about changes
+// made on the remote.
+//
+// This only works if the remote supports change notifications and
+// --poll-interval isn't 0.
+//
+// fn is called in its own go routine, one change at a time, so it
+// may be slow without holding up the remote, but shouldn't block
+// forever as the changes queue up until it returns. It is passed the
+// path of the file or directory from the root of the VFS and
+// fs.EntryObject or fs.EntryDirectory - note that the remote may report the change
+// of a file as a change of its directory.
+//
+// Call the returned stop function to stop calling fn. It waits for
+// any call of fn in progress to finish.
+func (vfs *VFS) ChangeNotify(fn func(path string, entryType fs.EntryType)) (stop func()) {
+	s := &subscriber{
+		fn:   fn,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	vfs.notifiers.mu.Lock()
+	vfs.notifiers.subscribers[s] = struct{}{}
+	vfs.notifiers.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			vfs.notifiers.mu.Lock()
+			delete(vfs.notifiers.subscribers, s)
+			vfs.notifiers.mu.Unlock()
+			close(s.stop)
+			<-s.done
+		})
+	}
+}
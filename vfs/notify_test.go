@@ -0,0 +1,95 @@
+package vfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVFSChangeNotify(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	type change struct {
+		path      string
+		entryType fs.EntryType
+	}
+	changes := make(chan change, 10)
+	stop := vfs.ChangeNotify(func(path string, entryType fs.EntryType) {
+		changes <- change{path, entryType}
+	})
+
+	vfs.root.changeNotify("dir/file", fs.EntryObject)
+	vfs.root.changeNotify("dir", fs.EntryDirectory)
+	for _, want := range []change{
+		{"dir/file", fs.EntryObject},
+		{"dir", fs.EntryDirectory},
+	} {
+		select {
+		case got := <-changes:
+			assert.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+
+	// No more changes after stop and stop can be called twice
+	stop()
+	stop()
+	vfs.root.changeNotify("dir/file2", fs.EntryObject)
+	assert.Len(t, vfs.notifiers.subscribers, 0)
+	assert.Len(t, changes, 0)
+}
+
+// A slow subscriber doesn't block the changes from the remote
+func TestVFSChangeNotifySlow(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	block := make(chan struct{})
+	var got []string
+	stop := vfs.ChangeNotify(func(path string, entryType fs.EntryType) {
+		<-block
+		got = append(got, path)
+	})
+
+	const n = 5000
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			vfs.notifiers.notify(fmt.Sprintf("file%d", i), fs.EntryObject)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify blocked on a slow subscriber")
+	}
+
+	// All the changes are passed on in order once it catches up
+	close(block)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		vfs.notifiers.mu.Lock()
+		var queued int
+		for s := range vfs.notifiers.subscribers {
+			s.mu.Lock()
+			queued = len(s.queue)
+			s.mu.Unlock()
+		}
+		vfs.notifiers.mu.Unlock()
+		if queued == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	require.Equal(t, n, len(got))
+	assert.Equal(t, "file0", got[0])
+	assert.Equal(t, fmt.Sprintf("file%d", n-1), got[n-1])
+}
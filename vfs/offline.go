@@ -0,0 +1,187 @@
+// Keeping the VFS going while the remote is unreachable
+
+package vfs
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/errors"
+)
+
+// offlineProbeInterval is how often an offline remote is checked to
+// see if it is reachable again
+var offlineProbeInterval = 30 * time.Second
+
+// offlineProbeName is the name looked up on the remote to check it
+// is reachable - it doesn't matter whether it exists
+const offlineProbeName = ".rclone-offline-probe"
+
+// offline tracks whether the remote is reachable when --vfs-offline
+// is in use
+type offline struct {
+	mu       sync.Mutex
+	offline  bool          // set if the remote is unreachable
+	since    time.Time     // when the remote became unreachable
+	lastErr  error         // the error which made the remote unreachable
+	probing  bool          // set if the prober is running
+	interval time.Duration // how often the prober checks the remote
+	stop     chan struct{} // closed to stop the prober
+}
+
+// newOffline makes a new offline tracker for a reachable remote
+func newOffline() *offline {
+	return &offline{
+		interval: offlineProbeInterval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// OfflineStatus describes whether the remote is reachable
+type OfflineStatus struct {
+	Offline   bool      `json:"offline"`             // set if the remote is unreachable
+	Since     time.Time `json:"since,omitempty"`     // when the remote became unreachable
+	LastError string    `json:"lastError,omitempty"` // the error which made the remote unreachable
+}
+
+// Offline returns whether the remote is unreachable and the VFS is
+// serving from its caches. This is only ever set with --vfs-offline.
+func (vfs *VFS) Offline() (status OfflineStatus) {
+	o := vfs.offline
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status.Offline = o.offline
+	if o.offline {
+		status.Since = o.since
+		status.LastError = o.lastErr.Error()
+	}
+	return status
+}
+
+// isOffline returns true if the remote is unreachable
+func (vfs *VFS) isOffline() bool {
+	o := vfs.offline
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.offline
+}
+
+// isUnreachable returns true if err means the remote couldn't be
+// contacted rather than it refused the operation
+func isUnreachable(err error) (unreachable bool) {
+	if err == nil {
+		return false
+	}
+	if fserrors.ShouldRetry(err) {
+		return true
+	}
+	errors.Walk(err, func(err error) bool {
+		if _, ok := err.(net.Error); ok {
+			unreachable = true
+			return true
+		}
+		return false
+	})
+	return unreachable
+}
+
+// remoteFailed should be called with the error when an operation on
+// the remote fails.
+//
+// If --vfs-offline is set and the error means the remote is
+// unreachable, the VFS is marked offline, a prober is started to
+// notice when the remote comes back and true is returned so the
+// caller can carry on with what it has cached.
+func (vfs *VFS) remoteFailed(err error) bool {
+	if !vfs.Opt.Offline || !isUnreachable(err) {
+		return false
+	}
+	o := vfs.offline
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastErr = err
+	if !o.offline {
+		fs.Errorf(vfs.f, "Remote is unreachable - serving from the cache until it is back: %v", err)
+		o.offline = true
+		o.since = time.Now()
+	}
+	if !o.probing {
+		o.probing = true
+		go vfs.prober()
+	}
+	return true
+}
+
+// probe returns nil if the remote can be reached
+func (vfs *VFS) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), vfs.offline.interval)
+	defer cancel()
+	_, err := vfs.f.NewObject(ctx, offlineProbeName)
+	if isUnreachable(err) {
+		return err
+	}
+	return nil
+}
+
+// prober checks whether the remote is reachable again every
+// probe interval until it is or the VFS is shut down
+func (vfs *VFS) prober() {
+	o := vfs.offline
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.stop:
+			return
+		}
+		o.mu.Lock()
+		if !o.offline {
+			o.probing = false
+			o.mu.Unlock()
+			return
+		}
+		o.mu.Unlock()
+		if err := vfs.probe(); err != nil {
+			fs.Debugf(vfs.f, "Remote is still unreachable: %v", err)
+			continue
+		}
+		vfs.online()
+	}
+}
+
+// online marks the VFS as online again and reconciles it with the
+// remote.
+//
+// The directory listings are marked stale so they are read again
+// and the uploads which failed while the remote was unreachable are
+// retried straight away. Files changed both locally and on the
+// remote while offline are resolved by the upload overwriting the
+// remote copy.
+func (vfs *VFS) online() {
+	o := vfs.offline
+	o.mu.Lock()
+	if !o.offline {
+		o.mu.Unlock()
+		return
+	}
+	o.offline = false
+	fs.Logf(vfs.f, "Remote is reachable again after %v - reconciling", time.Since(o.since).Truncate(time.Second))
+	o.mu.Unlock()
+
+	vfs.root.walk(func(d *Dir) {
+		d.read = time.Time{}
+	})
+	if vfs.cache != nil {
+		vfs.cache.RetryUploads()
+	}
+}
+
+// stopOffline stops the prober if it is running
+func (vfs *VFS) stopOffline() {
+	close(vfs.offline.stop)
+}
@@ -0,0 +1,106 @@
+package vfs
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// an error as returned when the network is down
+var errNetDown = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+func TestOfflineIsUnreachable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("permission denied"), false},
+		{os.ErrNotExist, false},
+		{errNetDown, true},
+		{errors.Wrap(errNetDown, "list failed"), true},
+		{&net.DNSError{Err: "no such host", Name: "example.com"}, true},
+	} {
+		assert.Equal(t, test.want, isUnreachable(test.err), test.err)
+	}
+}
+
+func TestOfflineDisabled(t *testing.T) {
+	_, vfs, cleanup := newTestVFS(t)
+	defer cleanup()
+
+	assert.False(t, vfs.remoteFailed(errNetDown))
+	assert.False(t, vfs.Offline().Offline)
+}
+
+func TestOfflineReadDir(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.Offline = true
+	r, vfs, cleanup := newTestVFSOpt(t, &opt)
+	defer cleanup()
+
+	file1 := r.WriteObject(context.Background(), "dir/file1", "file1 contents", t1)
+	fstest.CheckItems(t, r.Fremote, file1)
+
+	names := func() (names []string) {
+		entries, err := vfs.ReadDir("dir")
+		require.NoError(t, err)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"file1"}, names())
+
+	// Errors which aren't network errors don't make the remote offline
+	assert.False(t, vfs.remoteFailed(errors.New("permission denied")))
+	assert.False(t, vfs.Offline().Offline)
+
+	assert.True(t, vfs.remoteFailed(errNetDown))
+	status := vfs.Offline()
+	assert.True(t, status.Offline)
+	assert.Equal(t, errNetDown.Error(), status.LastError)
+	assert.WithinDuration(t, time.Now(), status.Since, time.Minute)
+
+	// While offline the cached listing is used even when stale
+	r.WriteObject(context.Background(), "dir/file2", "file2 contents", t2)
+	vfs.root.invalidateDir("dir")
+	assert.Equal(t, []string{"file1"}, names())
+
+	// When back online the listings are read again
+	vfs.online()
+	assert.False(t, vfs.Offline().Offline)
+	assert.Equal(t, []string{"file1", "file2"}, names())
+}
+
+func TestOfflineProber(t *testing.T) {
+	oldInterval := offlineProbeInterval
+	offlineProbeInterval = 10 * time.Millisecond
+	defer func() {
+		offlineProbeInterval = oldInterval
+	}()
+	opt := vfscommon.DefaultOpt
+	opt.Offline = true
+	_, vfs, cleanup := newTestVFSOpt(t, &opt)
+	defer cleanup()
+
+	// The remote is really reachable so the prober should notice
+	assert.True(t, vfs.remoteFailed(errNetDown))
+	assert.Eventually(t, func() bool {
+		return !vfs.isOffline()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		vfs.offline.mu.Lock()
+		defer vfs.offline.mu.Unlock()
+		return !vfs.offline.probing
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,116 @@
+package vfs
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// prefetcher reads an object from an offset to the end, fetching the
+// chunks ahead of the reader in parallel over separate connections.
+//
+// It is used by the ReadFileHandle with --vfs-read-prefetch once the
+// file is being read sequentially.
+type prefetcher struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	o         fs.Object
+	size      int64            // size of the object
+	chunkSize int64            // size of each chunk
+	chunks    int              // number of chunks to fetch ahead
+	next      int64            // offset of the next chunk to fetch
+	queue     []*prefetchChunk // chunks being fetched in order
+	pos       int              // position of the reader in queue[0]
+}
+
+// prefetchChunk is a chunk of the object being fetched
+type prefetchChunk struct {
+	done chan struct{} // closed when the fetch has finished
+	data []byte
+	err  error
+}
+
+// newPrefetcher returns a prefetcher reading o of size from offset in
+// chunks of chunkSize, fetching up to chunks ahead
+func newPrefetcher(o fs.Object, offset, size, chunkSize int64, chunks int) *prefetcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &prefetcher{
+		ctx:       ctx,
+		cancel:    cancel,
+		o:         o,
+		size:      size,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		next:      offset,
+	}
+}
+
+// fetch starts fetching the chunks up to the limit in the background
+func (p *prefetcher) fetch() {
+	for len(p.queue) < p.chunks && p.next < p.size {
+		size := p.chunkSize
+		if p.next+size > p.size {
+			size = p.size - p.next
+		}
+		chunk := &prefetchChunk{done: make(chan struct{})}
+		go chunk.fetch(p.ctx, p.o, p.next, size)
+		p.queue = append(p.queue, chunk)
+		p.next += size
+	}
+}
+
+// fetch reads size bytes of o from offset into the chunk
+func (chunk *prefetchChunk) fetch(ctx context.Context, o fs.Object, offset, size int64) {
+	defer close(chunk.done)
+	in, err := o.Open(ctx, &fs.RangeOption{Start: offset, End: offset + size - 1})
+	if err != nil {
+		chunk.err = errors.Wrap(err, "prefetch: failed to open chunk")
+		return
+	}
+	defer fs.CheckClose(in, &chunk.err)
+	chunk.data = make([]byte, size)
+	_, err = io.ReadFull(in, chunk.data)
+	if err != nil {
+		chunk.err = errors.Wrap(err, "prefetch: failed to read chunk")
+	}
+}
+
+// Read reads the next data from the chunks in order
+func (p *prefetcher) Read(buf []byte) (n int, err error) {
+	p.fetch()
+	if len(p.queue) == 0 {
+		return 0, io.EOF
+	}
+	chunk := p.queue[0]
+	select {
+	case <-chunk.done:
+	case <-p.ctx.Done():
+		return 0, p.ctx.Err()
+	}
+	if chunk.err != nil {
+		return 0, chunk.err
+	}
+	n = copy(buf, chunk.data[p.pos:])
+	p.pos += n
+	if p.pos >= len(chunk.data) {
+		// Finished with this chunk so fetch another
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.pos = 0
+		p.fetch()
+	}
+	return n, nil
+}
+
+// Close stops any fetches in progress
+func (p *prefetcher) Close() error {
+	p.cancel()
+	p.queue = nil
+	p.next = p.size
+	return nil
+}
+
+// Check interfaces
+var _ io.ReadCloser = (*prefetcher)(nil)
@@ -0,0 +1,95 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetcher(t *testing.T) {
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	ctx := context.Background()
+
+	contents := random.String(1000)
+	r.WriteObject(ctx, "file", contents, t1)
+	o, err := r.Fremote.NewObject(ctx, "file")
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		offset    int64
+		chunkSize int64
+		chunks    int
+	}{
+		{0, 64, 1},
+		{0, 64, 4},
+		{100, 64, 3},
+		{100, 1000, 2},
+		{999, 7, 5},
+	} {
+		p := newPrefetcher(o, test.offset, o.Size(), test.chunkSize, test.chunks)
+		got, err := ioutil.ReadAll(p)
+		require.NoError(t, err)
+		assert.Equal(t, contents[test.offset:], string(got), "%+v", test)
+		assert.NoError(t, p.Close())
+	}
+
+	// Read after close
+	p := newPrefetcher(o, 0, o.Size(), 64, 4)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(p, buf)
+	require.NoError(t, err)
+	assert.Equal(t, contents[:10], string(buf))
+	require.NoError(t, p.Close())
+	n, err := p.Read(buf)
+	assert.Equal(t, 0, n)
+	assert.Error(t, err)
+}
+
+func TestReadFileHandlePrefetch(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.PrefetchChunks = 4
+	opt.PrefetchSize = 100
+	r, vfs, cleanup := newTestVFSOpt(t, &opt)
+	defer cleanup()
+
+	contents := random.String(10000)
+	file1 := r.WriteObject(context.Background(), "file1", contents, t1)
+	fstest.CheckItems(t, r.Fremote, file1)
+
+	h, err := vfs.OpenFile("file1", os.O_RDONLY, 0777)
+	require.NoError(t, err)
+	fh, ok := h.(*ReadFileHandle)
+	require.True(t, ok)
+	defer func() {
+		require.NoError(t, fh.Close())
+	}()
+
+	// Not prefetching until a chunk has been read in sequence
+	assert.Equal(t, contents[:60], readString(t, fh, 60))
+	assert.False(t, fh.prefetching)
+	assert.Equal(t, contents[60:120], readString(t, fh, 60))
+	assert.Equal(t, contents[120:180], readString(t, fh, 60))
+	assert.True(t, fh.prefetching)
+	assert.Equal(t, contents[180:5000], readString(t, fh, 4820))
+
+	// Seeking stops the prefetching
+	_, err = fh.Seek(10, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, contents[10:20], readString(t, fh, 10))
+	assert.False(t, fh.prefetching)
+
+	// Then reading in sequence starts it again
+	assert.Equal(t, contents[20:200], readString(t, fh, 180))
+	assert.Equal(t, contents[200:9000], readString(t, fh, 8800))
+	assert.True(t, fh.prefetching)
+	assert.Equal(t, contents[9000:], readString(t, fh, 2000))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
 )
 
 const getVFSHelp = ` 
@@ -389,3 +390,207 @@ func rcList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	out["vfses"] = names
 	return out, nil
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue",
+		Title: "Show whether the remote is reachable and the queue of uploads.",
+		Help: `
+This returns whether the VFS has lost contact with the remote and is
+serving from its caches because --vfs-offline is set, and the list of
+files waiting to be uploaded.
+
+    rclone rc vfs/queue
+
+If the parameter retry=true is given the uploads which have failed
+are retried straight away rather than waiting for their retry delay.
+
+It returns
+
+- offline - true if the remote is unreachable
+- since - when the remote became unreachable
+- lastError - the error which made the remote unreachable
+- paused - true if uploads have been paused with vfs/queue-pause
+- queue - a list of the files to upload, each with
+    - name - the path of the file
+    - id - the id of the upload
+    - expiry - when the next upload attempt is due
+    - tries - how many upload attempts have been made
+    - uploading - true if the file is being uploaded now
+
+The queue is only available if --vfs-cache-mode is writes or full.
+` + getVFSHelp,
+		Fn: rcQueue,
+	})
+}
+
+func rcQueue(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := in.GetBool("retry")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	delete(in, "retry")
+	for k, v := range in {
+		return nil, errors.Errorf("invalid parameter: %s=%s", k, v)
+	}
+	status := vfs.Offline()
+	out = rc.Params{
+		"offline": status.Offline,
+	}
+	if status.Offline {
+		out["since"] = status.Since
+		out["lastError"] = status.LastError
+	}
+	queue := []writeback.QueueInfo{}
+	paused := false
+	if vfs.cache != nil {
+		if retry {
+			vfs.cache.RetryUploads()
+		}
+		queue = vfs.cache.UploadQueue()
+		paused = vfs.cache.UploadsPaused()
+	}
+	out["paused"] = paused
+	out["queue"] = queue
+	return out, nil
+}
+
+// getQueueVFS gets the VFS as getVFS does and checks it has a cache
+// with an upload queue
+func getQueueVFS(in rc.Params) (vfs *VFS, err error) {
+	vfs, err = getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, errors.New("VFS cache not in use - need --vfs-cache-mode writes or full for an upload queue")
+	}
+	return vfs, nil
+}
+
+// getQueueID reads the "id" parameter from the queue returned by
+// vfs/queue and checks there are no other parameters
+func getQueueID(in rc.Params) (id writeback.Handle, err error) {
+	value, err := in.GetInt64("id")
+	if err != nil {
+		return 0, err
+	}
+	delete(in, "id")
+	for k, v := range in {
+		return 0, errors.Errorf("invalid parameter: %s=%s", k, v)
+	}
+	return writeback.Handle(value), nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-pause",
+		Title: "Stop starting uploads from the VFS cache.",
+		Help: `
+This stops rclone starting any new uploads of files from the VFS
+cache. Uploads in progress carry on. Files written are kept in the
+cache and queued until uploads are resumed with vfs/queue-resume.
+
+    rclone rc vfs/queue-pause
+
+The pause lasts until rclone is restarted.
+` + getVFSHelp,
+		Fn: func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+			return rcQueuePause(in, true)
+		},
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-resume",
+		Title: "Start uploads from the VFS cache again.",
+		Help: `
+This undoes vfs/queue-pause, starting the uploads which are due.
+
+    rclone rc vfs/queue-resume
+` + getVFSHelp,
+		Fn: func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+			return rcQueuePause(in, false)
+		},
+	})
+}
+
+func rcQueuePause(in rc.Params, paused bool) (out rc.Params, err error) {
+	vfs, err := getQueueVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range in {
+		return nil, errors.Errorf("invalid parameter: %s=%s", k, v)
+	}
+	vfs.cache.PauseUploads(paused)
+	return nil, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-prioritize",
+		Title: "Upload a file in the VFS upload queue next.",
+		Help: `
+This makes the file with the id given, as returned by vfs/queue, the
+next one to be uploaded, without waiting for --vfs-write-back or any
+retry delay.
+
+    rclone rc vfs/queue-prioritize id=12
+
+It returns an error if the file isn't in the queue.
+` + getVFSHelp,
+		Fn: rcQueuePrioritize,
+	})
+}
+
+func rcQueuePrioritize(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getQueueVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	id, err := getQueueID(in)
+	if err != nil {
+		return nil, err
+	}
+	if !vfs.cache.PrioritizeUpload(id) {
+		return nil, errors.Errorf("no file with id %d in the upload queue", id)
+	}
+	return nil, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-cancel",
+		Title: "Remove a file from the VFS upload queue.",
+		Help: `
+This removes the file with the id given, as returned by vfs/queue,
+from the upload queue, cancelling its upload if it is in progress.
+
+    rclone rc vfs/queue-cancel id=12
+
+The file isn't deleted - it stays in the VFS cache and is queued for
+upload again when it is next modified or when rclone is restarted.
+
+It returns an error if the file isn't in the queue.
+` + getVFSHelp,
+		Fn: rcQueueCancel,
+	})
+}
+
+func rcQueueCancel(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getQueueVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	id, err := getQueueID(in)
+	if err != nil {
+		return nil, err
+	}
+	if !vfs.cache.CancelUpload(id) {
+		return nil, errors.Errorf("no file with id %d in the upload queue", id)
+	}
+	return nil, nil
+}
@@ -2,11 +2,14 @@ package vfs
 
 import (
 	"context"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -119,3 +122,83 @@ func TestRcList(t *testing.T) {
 		},
 	}, out)
 }
+
+func TestRcQueue(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	ctx := context.Background()
+	opt := vfscommon.DefaultOpt
+	opt.CacheMode = vfscommon.CacheModeWrites
+	opt.WriteBack = time.Hour
+	_, vfs, cleanup := newTestVFSOpt(t, &opt)
+	defer cleanup()
+
+	write := func() {
+		fd, err := vfs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0777)
+		require.NoError(t, err)
+		_, err = fd.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, fd.Close())
+	}
+	write()
+
+	queue := func() (paused bool, items []writeback.QueueInfo) {
+		out, err := rc.Calls.Get("vfs/queue").Fn(ctx, rc.Params{})
+		require.NoError(t, err)
+		assert.Equal(t, false, out["offline"])
+		return out["paused"].(bool), out["queue"].([]writeback.QueueInfo)
+	}
+	paused, items := queue()
+	assert.False(t, paused)
+	require.Len(t, items, 1)
+	assert.Equal(t, "file1", items[0].Name)
+	assert.True(t, items[0].Expiry.After(time.Now()))
+	id := int64(items[0].ID)
+
+	// Pause then prioritize so it is due but doesn't upload
+	_, err := rc.Calls.Get("vfs/queue-pause").Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	_, err = rc.Calls.Get("vfs/queue-prioritize").Fn(ctx, rc.Params{"id": id})
+	require.NoError(t, err)
+	paused, items = queue()
+	assert.True(t, paused)
+	require.Len(t, items, 1)
+	assert.False(t, items[0].Expiry.After(time.Now()))
+	assert.False(t, items[0].Uploading)
+
+	// Cancel removes it from the queue
+	_, err = rc.Calls.Get("vfs/queue-cancel").Fn(ctx, rc.Params{"id": id})
+	require.NoError(t, err)
+	_, items = queue()
+	assert.Len(t, items, 0)
+
+	_, err = rc.Calls.Get("vfs/queue-cancel").Fn(ctx, rc.Params{"id": id})
+	assert.Error(t, err)
+	_, err = rc.Calls.Get("vfs/queue-prioritize").Fn(ctx, rc.Params{"id": id, "bad": 1})
+	assert.Error(t, err)
+
+	// Writing the file again queues it again
+	write()
+	_, items = queue()
+	require.Len(t, items, 1)
+	id = int64(items[0].ID)
+
+	// Resume and prioritize uploads it now
+	_, err = rc.Calls.Get("vfs/queue-resume").Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	_, err = rc.Calls.Get("vfs/queue-prioritize").Fn(ctx, rc.Params{"id": id})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		paused, items = queue()
+		return !paused && len(items) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestRcQueueNoCache(t *testing.T) {
+	_, _, cleanup, call := rcNewRun(t, "vfs/queue-pause")
+	defer cleanup()
+	_, err := call.Fn(context.Background(), rc.Params{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "VFS cache not in use")
+}
@@ -32,6 +32,8 @@ type ReadFileHandle struct {
 	hash        *hash.MultiHasher
 	opened      bool
 	remote      string
+	seqBytes    int64 // bytes read in sequence since the last seek
+	prefetching bool  // set if reading with the prefetcher
 }
 
 // Check interfaces
@@ -123,11 +125,14 @@ func (fh *ReadFileHandle) seek(offset int64, reopen bool) (err error) {
 			return nil
 		}
 	}
+	fh.seqBytes = 0
 	fh.r.StopBuffering() // stop the background reading first
 	oldReader := fh.r.GetReader()
 	r, ok := oldReader.(*chunkedreader.ChunkedReader)
 	if !ok {
-		fs.Logf(fh.remote, "ReadFileHandle.Read expected reader to be a ChunkedReader, got %T", oldReader)
+		if _, ok := oldReader.(*prefetcher); !ok {
+			fs.Logf(fh.remote, "ReadFileHandle.Read expected reader to be a ChunkedReader, got %T", oldReader)
+		}
 		reopen = true
 	}
 	if !reopen {
@@ -160,9 +165,37 @@ func (fh *ReadFileHandle) seek(offset int64, reopen bool) (err error) {
 	}
 	fh.r.UpdateReader(context.TODO(), r)
 	fh.offset = offset
+	fh.prefetching = false
 	return nil
 }
 
+// startPrefetch switches to reading with the prefetcher if
+// --vfs-read-prefetch is set and enough of the file has been read in
+// sequence.
+//
+// Must be called with fh.mu held
+func (fh *ReadFileHandle) startPrefetch() {
+	opt := fh.file.VFS().optFor(fh.file.Path())
+	chunkSize := int64(opt.PrefetchSize)
+	if opt.PrefetchChunks <= 0 || chunkSize <= 0 || fh.prefetching || fh.sizeUnknown {
+		return
+	}
+	// Wait until a chunk has been read in sequence and don't
+	// bother for the last chunk
+	if fh.seqBytes < chunkSize || fh.size-fh.offset <= chunkSize {
+		return
+	}
+	fs.Debugf(fh.remote, "ReadFileHandle.Read sequential reading detected at %d - prefetching %d chunks of %v", fh.offset, opt.PrefetchChunks, opt.PrefetchSize)
+	fh.r.StopBuffering() // stop the background reading first
+	err := fh.r.GetReader().Close()
+	if err != nil {
+		fs.Debugf(fh.remote, "ReadFileHandle.Read prefetch close old failed: %v", err)
+	}
+	o := fh.file.getObject()
+	fh.r.UpdateReader(context.TODO(), newPrefetcher(o, fh.offset, fh.size, chunkSize, opt.PrefetchChunks))
+	fh.prefetching = true
+}
+
 // Seek the file - returns ESPIPE if seeking isn't possible
 func (fh *ReadFileHandle) Seek(offset int64, whence int) (n int64, err error) {
 	fh.mu.Lock()
@@ -272,6 +305,9 @@ func (fh *ReadFileHandle) readAt(p []byte, off int64) (n int, err error) {
 	if doSeek && fh.noSeek {
 		return 0, ESPIPE
 	}
+	if !doSeek {
+		fh.startPrefetch()
+	}
 	var newOffset int64
 	retries := 0
 	reqSize := len(p)
@@ -325,6 +361,7 @@ func (fh *ReadFileHandle) readAt(p []byte, off int64) (n int, err error) {
 		fs.Errorf(fh.remote, "ReadFileHandle.Read error: %v", err)
 	} else {
 		fh.offset = newOffset
+		fh.seqBytes += int64(n)
 		// fs.Debugf(fh.remote, "ReadFileHandle.Read OK")
 
 		if fh.hash != nil {
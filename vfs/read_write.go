@@ -398,6 +398,25 @@ func (fh *RWFileHandle) Truncate(size int64) (err error) {
 	return fh._truncate(size)
 }
 
+// PunchHole makes size bytes of the file at off read as zeros without
+// changing the size of the file
+func (fh *RWFileHandle) PunchHole(off, size int64) (err error) {
+	defer log.Trace(fh.logPrefix(), "off=%d, size=%d", off, size)("err=%v", &err)
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.closed {
+		return ECLOSED
+	}
+	if fh.readOnly() {
+		return EBADF
+	}
+	if err = fh.openPending(); err != nil {
+		return err
+	}
+	fh.writeCalled = true
+	return fh.item.PunchHole(off, size)
+}
+
 // Sync commits the current contents of the file to stable storage. Typically,
 // this means flushing the file system's in-memory copy of recently written
 // data to disk.
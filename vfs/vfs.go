@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -117,6 +118,7 @@ type Handle interface {
 	Flush() error
 	Release() error
 	Node() Node
+	PunchHole(off, size int64) error
 	//	Size() int64
 }
 
@@ -143,6 +145,7 @@ func (h baseHandle) WriteString(s string) (n int, err error)              { retu
 func (h baseHandle) Flush() (err error)                                   { return ENOSYS }
 func (h baseHandle) Release() (err error)                                 { return ENOSYS }
 func (h baseHandle) Node() Node                                           { return nil }
+func (h baseHandle) PunchHole(off, size int64) error                      { return ENOSYS }
 
 //func (h baseHandle) Size() int64                                          { return 0 }
 
@@ -166,7 +169,13 @@ type VFS struct {
 	usageTime   time.Time
 	usage       *fs.Usage
 	pollChan    chan time.Duration
-	inUse       int32 // count of number of opens accessed with atomic
+	dirCache    *dirCache       // persisted directory cache if set
+	rules       vfscommon.Rules // per path overrides of Opt from --vfs-rule
+	inUse       int32           // count of number of opens accessed with atomic
+	locks       *locks          // advisory locks on the files
+	notifiers   *notifiers      // subscribers to changes on the remote
+	offline     *offline        // whether the remote is reachable for --vfs-offline
+	collisions  *collisions     // names hidden by --vfs-fold-names
 }
 
 // Keep track of active VFS keyed on fs.ConfigString(f)
@@ -180,8 +189,12 @@ var (
 func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	fsDir := fs.NewDir("", time.Now())
 	vfs := &VFS{
-		f:     f,
-		inUse: int32(1),
+		f:          f,
+		inUse:      int32(1),
+		locks:      newLocks(),
+		notifiers:  newNotifiers(),
+		offline:    newOffline(),
+		collisions: newCollisions(),
 	}
 
 	// Make a copy of the options
@@ -203,7 +216,7 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	defer activeMu.Unlock()
 	configName := fs.ConfigString(f)
 	for _, activeVFS := range active[configName] {
-		if vfs.Opt == activeVFS.Opt {
+		if reflect.DeepEqual(vfs.Opt, activeVFS.Opt) {
 			fs.Debugf(f, "Re-using VFS from active cache")
 			atomic.AddInt32(&activeVFS.inUse, 1)
 			return activeVFS
@@ -212,6 +225,25 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	// Put the VFS into the active cache
 	active[configName] = append(active[configName], vfs)
 
+	// Parse the per path rules
+	rules, err := vfscommon.ParseRules(vfs.Opt.Rules)
+	if err != nil {
+		fs.Errorf(f, "Failed to parse --vfs-rule - ignoring rules: %v", err)
+	} else {
+		vfs.rules = rules
+	}
+
+	// Open the persisted directory cache - this must be done before
+	// polling starts so the changes since it was last used are found
+	if vfs.Opt.DirCachePersist {
+		dirCache, err := newDirCache(context.TODO(), f)
+		if err != nil {
+			fs.Errorf(f, "Failed to open persisted directory cache - disabling: %v", err)
+		} else {
+			vfs.dirCache = dirCache
+		}
+	}
+
 	// Create root directory
 	vfs.root = newDir(vfs, f, nil, fsDir)
 
@@ -263,7 +295,7 @@ func (vfs *VFS) Fs() fs.Fs {
 func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 	vfs.shutdownCache()
 	vfs.cache = nil
-	if cacheMode > vfscommon.CacheModeOff {
+	if vfs.rules.MaxCacheMode(cacheMode) > vfscommon.CacheModeOff {
 		ctx, cancel := context.WithCancel(context.Background())
 		cache, err := vfscache.New(ctx, vfs.f, &vfs.Opt, vfs.AddVirtual) // FIXME pass on context or get from Opt?
 		if err != nil {
@@ -278,6 +310,20 @@ func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 	}
 }
 
+// optFor returns the options to use for path taking into account
+// the --vfs-rule overrides
+func (vfs *VFS) optFor(path string) *vfscommon.Options {
+	return vfs.rules.Options(&vfs.Opt, path)
+}
+
+// cacheMode returns the cache mode to use for path
+func (vfs *VFS) cacheMode(path string) vfscommon.CacheMode {
+	if vfs.cache == nil {
+		return vfscommon.CacheModeOff
+	}
+	return vfs.optFor(path).CacheMode
+}
+
 // shutdown the cache if it was running
 func (vfs *VFS) shutdownCache() {
 	if vfs.cancelCache != nil {
@@ -306,12 +352,14 @@ func (vfs *VFS) Shutdown() {
 	}
 	activeMu.Unlock()
 
+	vfs.stopOffline()
 	vfs.shutdownCache()
+	vfs.dirCache.close()
 }
 
 // CleanUp deletes the contents of the on disk cache
 func (vfs *VFS) CleanUp() error {
-	if vfs.Opt.CacheMode == vfscommon.CacheModeOff {
+	if vfs.cache == nil {
 		return nil
 	}
 	return vfs.cache.CleanUp()
@@ -370,6 +418,12 @@ func newInode() (inode uint64) {
 	return atomic.AddUint64(&inodeCount, 1)
 }
 
+// CachedNode returns the Node at path if it is in the directory cache
+// or nil if it isn't, without reading any directories from the remote
+func (vfs *VFS) CachedNode(path string) Node {
+	return vfs.root.cachedNode(path)
+}
+
 // Stat finds the Node by path starting from the root
 //
 // It is the equivalent of os.Stat - Node contains the os.FileInfo
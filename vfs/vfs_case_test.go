@@ -161,3 +161,47 @@ func assertFileAbsentVFS(t *testing.T, vfs *VFS, name string) {
 	assert.Error(t, err)
 	assert.Equal(t, err, ENOENT)
 }
+
+func TestFoldNames(t *testing.T) {
+	assert.Equal(t, foldName("café"), foldName("CAFÉ"))
+	assert.NotEqual(t, foldName("cafe"), foldName("café"))
+
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+
+	if r.Fremote.Features().CaseInsensitive {
+		t.Skip("Can't test name folding - this remote is officially not case-sensitive")
+	}
+
+	ctx := context.Background()
+	file1 := r.WriteObject(ctx, "FiLeA", "data1", t1)
+	file2 := r.WriteObject(ctx, "filea", "data2", t2)
+	file3 := r.WriteObject(ctx, "café", "data3", t3)
+	file4 := r.WriteObject(ctx, "other", "data4", t1)
+	fstest.CheckItems(t, r.Fremote, file1, file2, file3, file4)
+
+	opt := vfscommon.DefaultOpt
+	opt.FoldNames = true
+	vfs := New(r.Fremote, &opt)
+	defer cleanupVFS(t, vfs)
+
+	// Only the first of the colliding names is listed
+	nodes, err := vfs.ReadDir("")
+	require.NoError(t, err)
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name())
+	}
+	assert.Equal(t, []string{"FiLeA", "café", "other"}, names)
+
+	// Names are matched regardless of case and normalization
+	assertFileDataVFS(t, vfs, "FILEA", "data1")
+	assertFileDataVFS(t, vfs, "filea", "data2")
+	assertFileDataVFS(t, vfs, "CAFÉ", "data3")
+
+	// Renaming onto a name differing by case replaces the file
+	require.NoError(t, vfs.Rename("other", "CAFÉ"))
+	assertFileDataVFS(t, vfs, "café", "data4")
+	file4.Path = "café"
+	fstest.CheckItems(t, r.Fremote, file1, file2, file4)
+}
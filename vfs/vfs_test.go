@@ -292,6 +292,33 @@ func TestVFSOpenFile(t *testing.T) {
 	assert.Nil(t, fd)
 }
 
+func TestVFSRules(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.Rules = []string{"/cached/** mode=full"}
+	r, vfs, cleanup := newTestVFSOpt(t, &opt)
+	defer cleanup()
+
+	// The cache is started for the rule
+	require.NotNil(t, vfs.cache)
+	assert.Equal(t, vfscommon.CacheModeOff, vfs.Opt.CacheMode)
+	assert.Equal(t, vfscommon.CacheModeFull, vfs.cacheMode("cached/file1"))
+	assert.Equal(t, vfscommon.CacheModeOff, vfs.cacheMode("file1"))
+
+	file1 := r.WriteObject(context.Background(), "file1", "file1 contents", t1)
+	file2 := r.WriteObject(context.Background(), "cached/file2", "file2 contents", t2)
+	fstest.CheckItems(t, r.Fremote, file1, file2)
+
+	fd, err := vfs.OpenFile("file1", os.O_RDONLY, 0777)
+	require.NoError(t, err)
+	assert.IsType(t, &ReadFileHandle{}, fd)
+	require.NoError(t, fd.Close())
+
+	fd, err = vfs.OpenFile("cached/file2", os.O_RDONLY, 0777)
+	require.NoError(t, err)
+	assert.IsType(t, &RWFileHandle{}, fd)
+	require.NoError(t, fd.Close())
+}
+
 func TestVFSRename(t *testing.T) {
 	r, vfs, cleanup := newTestVFS(t)
 	defer cleanup()
@@ -42,10 +42,12 @@ type Cache struct {
 	fcache     fs.Fs                // fs for the cache directory
 	fcacheMeta fs.Fs                // fs for the cache metadata directory
 	opt        *vfscommon.Options   // vfs Options
+	rules      vfscommon.Rules      // per path overrides of opt
 	root       string               // root of the cache directory
 	metaRoot   string               // root of the cache metadata directory
 	hashType   hash.Type            // hash to use locally and remotely
 	hashOption *fs.HashesOption     // corresponding OpenOption
+	cipher     *cacheCipher         // to encrypt the cache files with if set
 	writeback  *writeback.WriteBack // holds Items for writeback
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
 
@@ -58,6 +60,9 @@ type Cache struct {
 	cleanerKicked bool             // some thread kicked the cleaner upon out of space
 	kickerMu      sync.Mutex       // mutex for cleanerKicked
 	kick          chan struct{}    // channel for kicking clear to start
+	freeMu        sync.Mutex       // protects the following variables
+	freeChecked   time.Time        // when the free space was last checked by a write
+	freeLow       bool             // set if the free space was low when last checked
 
 }
 
@@ -91,6 +96,16 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	metaRoot := file.UNCPath(filepath.Join(cacheDir, "vfsMeta", fremote.Name(), fRoot))
 	fs.Debugf(nil, "vfs cache: metadata root is %q", root)
 
+	cipher, err := newCacheCipher(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := vfscommon.ParseRules(opt.Rules)
+	if err != nil {
+		return nil, err
+	}
+
 	fcache, err := fscache.Get(ctx, root)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create cache remote")
@@ -107,12 +122,14 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		fcache:     fcache,
 		fcacheMeta: fcacheMeta,
 		opt:        opt,
+		rules:      rules,
 		root:       root,
 		metaRoot:   metaRoot,
 		item:       make(map[string]*Item),
 		errItems:   make(map[string]error),
 		hashType:   hashType,
 		hashOption: hashOption,
+		cipher:     cipher,
 		writeback:  writeback.New(ctx, opt),
 		avFn:       avFn,
 	}
@@ -535,12 +552,18 @@ func (c *Cache) purgeClean(quota int64) {
 }
 
 // purgeOld gets rid of any files that are over age
+//
+// A --vfs-rule with a max-age overrides maxAge for the files it matches
 func (c *Cache) purgeOld(maxAge time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	// cutoff := time.Now().Add(-maxAge)
-	for _, item := range c.item {
-		c.removeNotInUse(item, maxAge, false)
+	for name, item := range c.item {
+		itemMaxAge := maxAge
+		if rule := c.rules.Match(name); rule != nil && rule.CacheMaxAge != nil {
+			itemMaxAge = *rule.CacheMaxAge
+		}
+		c.removeNotInUse(item, itemMaxAge, false)
 	}
 	if c.used < int64(c.opt.CacheMaxSize) {
 		c.outOfSpace = false
@@ -629,6 +652,10 @@ func (c *Cache) clean(removeCleanFiles bool) {
 		// oldest first
 		c.purgeOverQuota(int64(c.opt.CacheMaxSize))
 
+		// Then remove files not in use until there is enough
+		// free space on the disk
+		c.purgeFreeSpace()
+
 		// removeCleanFiles indicates that we got ENOSPC error
 		// We remove cache files that are not dirty if we are still above the max cache size
 		if removeCleanFiles {
@@ -710,6 +737,45 @@ func (c *Cache) TotalInUse() (n int) {
 	return n
 }
 
+// UploadQueue returns the files waiting to be uploaded or being
+// uploaded in the order they will be uploaded
+func (c *Cache) UploadQueue() []writeback.QueueInfo {
+	return c.writeback.Queue()
+}
+
+// RetryUploads retries the uploads which have failed now rather than
+// waiting for their backoff delays to expire
+func (c *Cache) RetryUploads() {
+	c.writeback.RetryNow()
+}
+
+// PrioritizeUpload makes the file with the writeback id passed in the
+// next to be uploaded. It returns false if it isn't in the queue.
+func (c *Cache) PrioritizeUpload(id writeback.Handle) bool {
+	return c.writeback.Prioritize(id)
+}
+
+// CancelUpload removes the file with the writeback id passed in from
+// the upload queue, cancelling the upload if it is in progress. It
+// returns false if it isn't in the queue.
+//
+// The file stays in the cache marked dirty so it is queued again
+// when it is next modified or rclone is restarted.
+func (c *Cache) CancelUpload(id writeback.Handle) bool {
+	return c.writeback.Remove(id)
+}
+
+// PauseUploads stops new uploads being started if paused is set or
+// starts them again if not
+func (c *Cache) PauseUploads(paused bool) {
+	c.writeback.SetPaused(paused)
+}
+
+// UploadsPaused returns whether uploads are paused
+func (c *Cache) UploadsPaused() bool {
+	return c.writeback.Paused()
+}
+
 // Dump the cache into a string for debugging purposes
 func (c *Cache) Dump() string {
 	if c == nil {
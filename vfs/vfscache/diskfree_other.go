@@ -0,0 +1,11 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package vfscache
+
+import "github.com/pkg/errors"
+
+// getDiskFree returns an error as reading the free space isn't
+// supported on this OS
+func getDiskFree(path string) (int64, error) {
+	return 0, errors.New("reading disk free space not supported on this OS")
+}
@@ -0,0 +1,20 @@
+// +build darwin dragonfly freebsd linux
+
+package vfscache
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// getDiskFree returns the number of bytes available on the disk
+// holding path
+func getDiskFree(path string) (int64, error) {
+	var s syscall.Statfs_t
+	err := syscall.Statfs(path, &s)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read disk usage")
+	}
+	return int64(s.Bsize) * int64(s.Bavail), nil // nolint: unconvert
+}
@@ -0,0 +1,402 @@
+// Encrypt the cache files with --vfs-cache-encrypt
+
+package vfscache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// cacheCipher encrypts the contents of the cache files with AES-256
+// in GCM mode.
+//
+// The files are encrypted in blocks of cryptBlockSize so that any
+// part of a file can be read or written without reading the rest of
+// it. Each block is stored at a fixed place in the cache file as
+//
+//	nonce (12 bytes) | length (4 bytes) | ciphertext (length bytes) | tag (16 bytes)
+//
+// Each time a block is written it is encrypted with a new random
+// nonce so the key stream is never reused, and the tag authenticates
+// the data, its length, the number of the block and the random ID of
+// the file so changes to the cache file, or blocks moved about within
+// it or between files, are detected when they are read.
+type cacheCipher struct {
+	aead cipher.AEAD
+}
+
+// Sizes of the blocks of an encrypted cache file
+const (
+	cryptBlockSize  = 64 * 1024                      // size of the data in a block
+	cryptNonceSize  = 12                             // size of the nonce
+	cryptHeaderSize = cryptNonceSize + 4             // size of the nonce and length before the data
+	cryptTagSize    = 16                             // size of the tag after the data
+	cryptOverhead   = cryptHeaderSize + cryptTagSize // extra bytes stored with each block
+	cryptBlockSpace = cryptBlockSize + cryptOverhead // space each block takes in the cache file
+	cryptFileIDSize = 16                             // size of the random ID of each file
+)
+
+// errCorrupted is returned if a block of an encrypted cache file fails
+// its integrity check
+var errCorrupted = errors.New("vfs cache: encrypted cache file is corrupted or has been tampered with")
+
+// errBlockMissing is returned if a block of an encrypted cache file
+// has never been written
+var errBlockMissing = errors.New("vfs cache: block of encrypted cache file missing")
+
+// newCacheCipher returns the cacheCipher for the options or nil if
+// --vfs-cache-encrypt isn't set.
+//
+// The key is read from --vfs-cache-encrypt-keyfile if set, otherwise
+// it is derived from the config password.
+func newCacheCipher(opt *vfscommon.Options) (*cacheCipher, error) {
+	if !opt.CacheEncrypt {
+		return nil, nil
+	}
+	var key []byte
+	if opt.CacheKeyFile != "" {
+		data, err := ioutil.ReadFile(opt.CacheKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read --vfs-cache-encrypt-keyfile")
+		}
+		if len(data) == 0 {
+			return nil, errors.New("--vfs-cache-encrypt-keyfile is empty")
+		}
+		sum := sha256.Sum256(data)
+		key = sum[:]
+	} else {
+		var err error
+		key, err = config.DeriveKey("vfs-cache")
+		if err == config.ErrorConfigNotEncrypted {
+			return nil, errors.New("--vfs-cache-encrypt needs an encrypted config file or --vfs-cache-encrypt-keyfile")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make cache cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make cache cipher")
+	}
+	return &cacheCipher{aead: aead}, nil
+}
+
+// newFileID returns a new random ID for a cache file
+func (cc *cacheCipher) newFileID() ([]byte, error) {
+	id := make([]byte, cryptFileIDSize)
+	_, err := io.ReadFull(rand.Reader, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make file ID")
+	}
+	return id, nil
+}
+
+// cryptSize returns the size of the encrypted cache file holding
+// size bytes
+func cryptSize(size int64) int64 {
+	blocks, rem := size/cryptBlockSize, size%cryptBlockSize
+	cryptSize := blocks * cryptBlockSpace
+	if rem > 0 {
+		cryptSize += cryptOverhead + rem
+	}
+	return cryptSize
+}
+
+// plainSize returns the size of the data held in an encrypted cache
+// file of cryptSize bytes
+func plainSize(cryptSize int64) int64 {
+	blocks, rem := cryptSize/cryptBlockSpace, cryptSize%cryptBlockSpace
+	size := blocks * cryptBlockSize
+	if rem > cryptOverhead {
+		size += rem - cryptOverhead
+	}
+	return size
+}
+
+// cryptFile reads and writes the data in an encrypted cache file
+type cryptFile struct {
+	cc *cacheCipher
+	id []byte      // random ID of the file
+	fd *os.File    // the cache file
+	mu *sync.Mutex // held while reading and writing blocks
+}
+
+// additionalData returns the data authenticated with block i of
+// length n
+func (cf *cryptFile) additionalData(i int64, n int) []byte {
+	ad := make([]byte, len(cf.id)+12)
+	copy(ad, cf.id)
+	binary.BigEndian.PutUint64(ad[len(cf.id):], uint64(i))
+	binary.BigEndian.PutUint32(ad[len(cf.id)+8:], uint32(n))
+	return ad
+}
+
+// readBlock reads and decrypts block i returning its data.
+//
+// It returns errBlockMissing if the block has never been written and
+// errCorrupted if it fails its integrity check.
+func (cf *cryptFile) readBlock(i int64) ([]byte, error) {
+	buf := make([]byte, cryptBlockSpace)
+	n, err := cf.fd.ReadAt(buf, i*cryptBlockSpace)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "vfs cache: failed to read encrypted block")
+	}
+	buf = buf[:n]
+	if n < cryptHeaderSize || isZero(buf[:cryptHeaderSize]) {
+		return nil, errBlockMissing
+	}
+	nonce := buf[:cryptNonceSize]
+	length := int(binary.BigEndian.Uint32(buf[cryptNonceSize:cryptHeaderSize]))
+	if length > cryptBlockSize || n < cryptOverhead+length {
+		return nil, errCorrupted
+	}
+	data, err := cf.cc.aead.Open(nil, nonce, buf[cryptHeaderSize:cryptOverhead+length], cf.additionalData(i, length))
+	if err != nil {
+		return nil, errCorrupted
+	}
+	return data, nil
+}
+
+// writeBlock encrypts data with a new nonce and writes it as block i
+func (cf *cryptFile) writeBlock(i int64, data []byte) error {
+	buf := make([]byte, cryptHeaderSize, cryptOverhead+len(data))
+	_, err := io.ReadFull(rand.Reader, buf[:cryptNonceSize])
+	if err != nil {
+		return errors.Wrap(err, "vfs cache: failed to make nonce")
+	}
+	binary.BigEndian.PutUint32(buf[cryptNonceSize:], uint32(len(data)))
+	buf = cf.cc.aead.Seal(buf, buf[:cryptNonceSize], data, cf.additionalData(i, len(data)))
+	_, err = cf.fd.WriteAt(buf, i*cryptBlockSpace)
+	if err != nil {
+		return errors.Wrap(err, "vfs cache: failed to write encrypted block")
+	}
+	return nil
+}
+
+// size returns the size of the data in the cache file
+func (cf *cryptFile) size() (int64, error) {
+	fi, err := cf.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return plainSize(fi.Size()), nil
+}
+
+// ReadAt reads len(b) bytes of data from the cache file at off,
+// checking the integrity of each block read.
+//
+// Parts of blocks which haven't been written read as zeros.
+func (cf *cryptFile) ReadAt(b []byte, off int64) (n int, err error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	size, err := cf.size()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	if end := off + int64(len(b)); end > size {
+		b = b[:size-off]
+		err = io.EOF
+	}
+	for n < len(b) {
+		i := (off + int64(n)) / cryptBlockSize
+		start := int((off + int64(n)) % cryptBlockSize)
+		data, readErr := cf.readBlock(i)
+		if readErr != nil {
+			return n, readErr
+		}
+		m := cryptBlockSize - start
+		if m > len(b)-n {
+			m = len(b) - n
+		}
+		chunk := b[n : n+m]
+		copied := 0
+		if start < len(data) {
+			copied = copy(chunk, data[start:])
+		}
+		for j := copied; j < len(chunk); j++ {
+			chunk[j] = 0
+		}
+		n += m
+	}
+	return n, err
+}
+
+// WriteAt writes b to the cache file at off.
+//
+// Each block written to is encrypted again with a new nonce.
+func (cf *cryptFile) WriteAt(b []byte, off int64) (n int, err error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	for n < len(b) {
+		i := (off + int64(n)) / cryptBlockSize
+		start := int((off + int64(n)) % cryptBlockSize)
+		m := cryptBlockSize - start
+		if m > len(b)-n {
+			m = len(b) - n
+		}
+		var data []byte
+		if start == 0 && m == cryptBlockSize {
+			data = b[n : n+m]
+		} else {
+			old, readErr := cf.readBlock(i)
+			if readErr != nil && readErr != errBlockMissing {
+				return n, readErr
+			}
+			length := len(old)
+			if start+m > length {
+				length = start + m
+			}
+			data = make([]byte, length)
+			copy(data, old)
+			copy(data[start:], b[n:n+m])
+		}
+		err = cf.writeBlock(i, data)
+		if err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Truncate changes the size of the data in the cache file to size.
+//
+// If the file is cut in the middle of a block that block is
+// encrypted again with its new length.
+func (cf *cryptFile) Truncate(size int64) error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if rem := int(size % cryptBlockSize); rem != 0 {
+		i := size / cryptBlockSize
+		data, err := cf.readBlock(i)
+		if err != nil && err != errBlockMissing {
+			return err
+		}
+		if len(data) > rem {
+			err = cf.writeBlock(i, data[:rem])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return cf.fd.Truncate(cryptSize(size))
+}
+
+// isZero returns true if b is all zeros
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decryptingReader reads the data from an encrypted cache file
+// starting at offset off up to end
+type decryptingReader struct {
+	cf  *cryptFile
+	off int64
+	end int64
+}
+
+// Read decrypts the data as it is read
+func (r *decryptingReader) Read(p []byte) (n int, err error) {
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.end-r.off {
+		p = p[:r.end-r.off]
+	}
+	n, err = r.cf.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close the cache file
+func (r *decryptingReader) Close() error {
+	return r.cf.fd.Close()
+}
+
+// decryptingObject presents an encrypted cache file as the data it
+// contains so it can be uploaded to the remote
+type decryptingObject struct {
+	fs.Object
+	cc     *cacheCipher
+	id     []byte
+	mu     *sync.Mutex
+	osPath string
+}
+
+// Size returns the size of the data in the cache file
+func (o *decryptingObject) Size() int64 {
+	return plainSize(o.Object.Size())
+}
+
+// Open opens the cache file for reading the decrypted data
+func (o *decryptingObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	size := o.Size()
+	offset, limit := int64(0), int64(-1)
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(size)
+		}
+	}
+	end := size
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+	fd, err := file.Open(o.osPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "vfs cache: failed to open cache file")
+	}
+	cf := &cryptFile{cc: o.cc, id: o.id, fd: fd, mu: o.mu}
+	return &decryptingReader{cf: cf, off: offset, end: end}, nil
+}
+
+// Hash returns the hash of the decrypted data
+func (o *decryptingObject) Hash(ctx context.Context, ht hash.Type) (sum string, err error) {
+	if !o.Object.Fs().Hashes().Contains(ht) {
+		return "", hash.ErrUnsupported
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read cache file for hash")
+	}
+	defer fs.CheckClose(in, &err)
+	sums, err := hash.StreamTypes(in, hash.NewHashSet(ht))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to hash cache file")
+	}
+	return sums[ht], nil
+}
+
+// Check the interfaces are satisfied
+var _ fs.Object = (*decryptingObject)(nil)
@@ -0,0 +1,187 @@
+package vfscache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEncryptTestCache makes a cache encrypted with a key file
+func newEncryptTestCache(t *testing.T) (r *fstest.Run, c *Cache, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rclone-vfs-encrypt")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("potato"), 0600))
+
+	opt := vfscommon.DefaultOpt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheEncrypt = true
+	opt.CacheKeyFile = keyFile
+
+	r, c, cacheCleanup := newTestCacheOpt(t, opt)
+	return r, c, func() {
+		cacheCleanup()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// newTestCryptFile makes an empty encrypted file to test cryptFile
+func newTestCryptFile(t *testing.T, cc *cacheCipher) (cf *cryptFile, cleanup func()) {
+	fd, err := ioutil.TempFile("", "rclone-vfs-cryptfile")
+	require.NoError(t, err)
+	id, err := cc.newFileID()
+	require.NoError(t, err)
+	cf = &cryptFile{cc: cc, id: id, fd: fd, mu: new(sync.Mutex)}
+	return cf, func() {
+		_ = fd.Close()
+		_ = os.Remove(fd.Name())
+	}
+}
+
+func TestCryptSize(t *testing.T) {
+	for _, size := range []int64{0, 1, cryptBlockSize - 1, cryptBlockSize, cryptBlockSize + 1, 3*cryptBlockSize + 17} {
+		assert.Equal(t, size, plainSize(cryptSize(size)), size)
+	}
+	assert.Equal(t, int64(0), cryptSize(0))
+	assert.Equal(t, int64(1+cryptOverhead), cryptSize(1))
+	assert.Equal(t, int64(cryptBlockSpace), cryptSize(cryptBlockSize))
+}
+
+func TestCryptFile(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.CacheEncrypt = true
+	_, err := newCacheCipher(&opt)
+	assert.EqualError(t, err, "--vfs-cache-encrypt needs an encrypted config file or --vfs-cache-encrypt-keyfile")
+
+	_, c, cleanup := newEncryptTestCache(t)
+	defer cleanup()
+	require.NotNil(t, c.cipher)
+	cf, cleanupFile := newTestCryptFile(t, c.cipher)
+	defer cleanupFile()
+
+	// Write in pieces which straddle the blocks
+	plain := []byte(random.String(3*cryptBlockSize + 1000))
+	for _, r := range [][2]int{{0, 1}, {1, cryptBlockSize}, {cryptBlockSize + 1, 2 * cryptBlockSize}, {3*cryptBlockSize + 1, 999}} {
+		n, err := cf.WriteAt(plain[r[0]:r[0]+r[1]], int64(r[0]))
+		require.NoError(t, err)
+		assert.Equal(t, r[1], n)
+	}
+	size, err := cf.size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), size)
+
+	raw, err := ioutil.ReadFile(cf.fd.Name())
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, plain[1000:1100]))
+
+	// Read back in pieces and past the end
+	for _, r := range [][2]int{{0, 1}, {17, 100}, {cryptBlockSize - 1, 2}, {0, len(plain)}} {
+		buf := make([]byte, r[1])
+		n, err := cf.ReadAt(buf, int64(r[0]))
+		require.NoError(t, err)
+		assert.Equal(t, plain[r[0]:r[0]+r[1]], buf[:n], "range %v", r)
+	}
+	buf := make([]byte, 100)
+	n, err := cf.ReadAt(buf, int64(len(plain)-10))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, plain[len(plain)-10:], buf[:n])
+
+	// Rewriting a block uses a new nonce
+	nonce := append([]byte(nil), raw[:cryptNonceSize]...)
+	_, err = cf.WriteAt(plain[5:6], 5)
+	require.NoError(t, err)
+	raw, err = ioutil.ReadFile(cf.fd.Name())
+	require.NoError(t, err)
+	assert.NotEqual(t, nonce, raw[:cryptNonceSize])
+
+	// Truncating in the middle of a block
+	require.NoError(t, cf.Truncate(cryptBlockSize+10))
+	size, err = cf.size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(cryptBlockSize+10), size)
+	buf = make([]byte, cryptBlockSize+20)
+	n, err = cf.ReadAt(buf, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, plain[:cryptBlockSize+10], buf[:n])
+
+	raw, err = ioutil.ReadFile(cf.fd.Name())
+	require.NoError(t, err)
+	assert.Equal(t, cryptSize(cryptBlockSize+10), int64(len(raw)))
+
+	// Changes to the cache file are detected
+	_, err = cf.fd.WriteAt([]byte{raw[cryptHeaderSize+7] ^ 1}, cryptHeaderSize+7)
+	require.NoError(t, err)
+	_, err = cf.ReadAt(buf[:10], 0)
+	assert.Equal(t, errCorrupted, err)
+	_, err = cf.WriteAt(buf[:10], 20)
+	assert.Equal(t, errCorrupted, err)
+
+	// Blocks moved between files are detected
+	cf2, cleanupFile2 := newTestCryptFile(t, c.cipher)
+	defer cleanupFile2()
+	_, err = cf.ReadAt(buf[:10], cryptBlockSize)
+	require.NoError(t, err)
+	_, err = cf2.fd.WriteAt(raw[cryptBlockSpace:], cryptBlockSpace)
+	require.NoError(t, err)
+	_, err = cf2.ReadAt(buf[:10], cryptBlockSize)
+	assert.Equal(t, errCorrupted, err)
+
+	// Blocks which haven't been written can't be read
+	_, err = cf2.ReadAt(buf[:10], 0)
+	assert.Equal(t, errBlockMissing, err)
+}
+
+func TestItemEncrypt(t *testing.T) {
+	r, c, cleanup := newEncryptTestCache(t)
+	defer cleanup()
+
+	// Download an existing file
+	contents, obj, item := newFile(t, r, c, "existing")
+	require.NoError(t, item.Open(obj))
+	buf := make([]byte, 10)
+	n, err := item.ReadAt(buf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, contents[10:20], string(buf[:n]))
+	require.NoError(t, item.Close(nil))
+	assert.NotNil(t, item.info.FileID)
+
+	osPath := c.toOSPath("existing")
+	raw, err := ioutil.ReadFile(osPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), contents[10:20])
+
+	// Write a new file with a gap and extend it
+	item, _ = c.get("potato")
+	require.NoError(t, item.Open(nil))
+	_, err = item.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	_, err = item.WriteAt([]byte("world"), 10)
+	require.NoError(t, err)
+	require.NoError(t, item.Truncate(20))
+
+	buf = make([]byte, 20)
+	n, err = item.ReadAt(buf, 0)
+	require.NoError(t, err)
+	want := "hello\x00\x00\x00\x00\x00world\x00\x00\x00\x00\x00"
+	assert.Equal(t, want, string(buf[:n]))
+
+	raw, err = ioutil.ReadFile(c.toOSPath("potato"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "hello")
+	assert.NotContains(t, string(raw), "world")
+
+	// The file is uploaded decrypted
+	require.NoError(t, item.Close(nil))
+	checkObject(t, r, "potato", want)
+}
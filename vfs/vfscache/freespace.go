@@ -0,0 +1,75 @@
+// Keep --vfs-cache-min-free-space free on the disk holding the cache
+
+package vfscache
+
+import (
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	freeSpaceCheckInterval = time.Second            // how often writes check the free space
+	freeSpaceThrottle      = 100 * time.Millisecond // how long writes are delayed when it is low
+)
+
+// diskFree returns the number of bytes available on the disk holding
+// path - it is a variable so it can be replaced in the tests
+var diskFree = getDiskFree
+
+// purgeFreeSpace removes the cache files which are not in use, oldest
+// first, until there is --vfs-cache-min-free-space free on the disk
+// holding the cache.
+//
+// It returns false if there still isn't enough free space afterwards.
+func (c *Cache) purgeFreeSpace() bool {
+	minFree := int64(c.opt.CacheMinFreeSpace)
+	if minFree <= 0 {
+		return true
+	}
+	free, err := diskFree(c.root)
+	if err != nil {
+		fs.Debugf(nil, "vfs cache: can't read free space - ignoring --vfs-cache-min-free-space: %v", err)
+		return true
+	}
+	if free >= minFree {
+		return true
+	}
+	quota := c.updateUsed() - (minFree - free)
+	fs.Debugf(nil, "vfs cache: free space %v is below --vfs-cache-min-free-space %v - reducing cache to %v", fs.SizeSuffix(free), c.opt.CacheMinFreeSpace, fs.SizeSuffix(quota))
+	if quota < 1 {
+		quota = 1 // a quota of 0 means no limit
+	}
+	c.purgeOverQuota(quota)
+	free, err = diskFree(c.root)
+	return err != nil || free >= minFree
+}
+
+// throttleWrite should be called before writing to the cache.
+//
+// If --vfs-cache-min-free-space is set it checks the free space at
+// most once every freeSpaceCheckInterval, freeing space if it is
+// low. If there still isn't enough free space, eg because the cache
+// is full of files waiting to be uploaded, the write is delayed to
+// give the uploads time to catch up.
+func (c *Cache) throttleWrite() {
+	if c.opt.CacheMinFreeSpace <= 0 {
+		return
+	}
+	c.freeMu.Lock()
+	if time.Since(c.freeChecked) >= freeSpaceCheckInterval {
+		c.freeChecked = time.Now()
+		low := !c.purgeFreeSpace()
+		if low && !c.freeLow {
+			fs.Logf(nil, "vfs cache: free space is below --vfs-cache-min-free-space %v - slowing writes down", c.opt.CacheMinFreeSpace)
+		} else if !low && c.freeLow {
+			fs.Logf(nil, "vfs cache: free space is above --vfs-cache-min-free-space %v again", c.opt.CacheMinFreeSpace)
+		}
+		c.freeLow = low
+	}
+	low := c.freeLow
+	c.freeMu.Unlock()
+	if low {
+		time.Sleep(freeSpaceThrottle)
+	}
+}
@@ -0,0 +1,75 @@
+package vfscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheMinFreeSpace(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheMinFreeSpace = 10
+	_, c, cleanup := newTestCacheOpt(t, opt)
+	defer cleanup()
+
+	// Pretend the cache is on a disk of 20 bytes
+	oldDiskFree := diskFree
+	defer func() {
+		diskFree = oldDiskFree
+	}()
+	diskFree = func(path string) (int64, error) {
+		assert.Equal(t, c.root, path)
+		return 20 - c.updateUsed(), nil
+	}
+
+	// Make some test files
+	potato := c.Item("sub/dir/potato")
+	itemWrite(t, potato, "hello")
+	require.NoError(t, potato.Close(nil))
+
+	potato2 := c.Item("sub/dir2/potato2")
+	itemWrite(t, potato2, "hello2")
+	require.NoError(t, potato2.Close(nil))
+
+	// make potato2 definitely after potato
+	potato2.info.ATime = time.Now().Add(10 * time.Second)
+
+	// Check only potato removed to get enough free space
+	assert.True(t, c.purgeFreeSpace())
+	assert.Equal(t, []string{
+		`name="sub/dir2/potato2" opens=0 size=6`,
+	}, itemAsString(c))
+
+	// Write a file which fills the disk while it is open
+	potato3 := c.Item("sub/dir/potato3")
+	itemWrite(t, potato3, "hello world 123")
+	c.freeChecked = time.Time{}
+	start := time.Now()
+	c.throttleWrite()
+	assert.True(t, time.Since(start) >= freeSpaceThrottle)
+	assert.True(t, c.freeLow)
+	assert.Equal(t, []string{
+		`name="sub/dir/potato3" opens=1 size=15`,
+	}, itemAsString(c))
+
+	// Closing it lets it be removed
+	require.NoError(t, potato3.Close(nil))
+	c.freeChecked = time.Time{}
+	c.throttleWrite()
+	assert.False(t, c.freeLow)
+	assert.Equal(t, []string(nil), itemAsString(c))
+
+	// Nothing happens with no minimum set
+	c.opt.CacheMinFreeSpace = -1
+	diskFree = func(path string) (int64, error) {
+		t.Fatal("unexpected call")
+		return 0, nil
+	}
+	assert.True(t, c.purgeFreeSpace())
+	c.throttleWrite()
+}
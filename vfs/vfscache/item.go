@@ -69,6 +69,7 @@ type Item struct {
 	writeBackID     writeback.Handle         // id of any writebacks in progress
 	pendingAccesses int                      // number of threads - cache reset not allowed if not zero
 	beingReset      bool                     // cache cleaner is resetting the cache file, access not allowed
+	cryptMu         sync.Mutex               // held while reading or writing the blocks of an encrypted cache file
 }
 
 // Info is persisted to backing store
@@ -79,6 +80,8 @@ type Info struct {
 	Rs          ranges.Ranges // which parts of the file are present
 	Fingerprint string        // fingerprint of remote object
 	Dirty       bool          // set if the backing file has been modified
+	FileID      []byte        `json:",omitempty"` // random ID of the backing file if it is encrypted
+	Holes       ranges.Ranges `json:",omitempty"` // parts of the file which haven't been written so read as zeros
 }
 
 // Items are a slice of *Item ordered by ATime
@@ -157,11 +160,16 @@ func newItem(c *Cache, name string) (item *Item) {
 		item._removeFile("metadata doesn't exist")
 	} else if err != nil {
 		item.remove(fmt.Sprintf("failed to load metadata: %v", err))
+	} else if (item.info.FileID != nil) != (c.cipher != nil) && !item.info.Dirty {
+		item.remove("--vfs-cache-encrypt has changed")
 	}
 
 	// Get size estimate (which is best we can do until Open() called)
 	if statErr == nil {
 		item.info.Size = fi.Size()
+		if item.info.FileID != nil {
+			item.info.Size = plainSize(fi.Size())
+		}
 	}
 	return item
 }
@@ -249,6 +257,10 @@ func (item *Item) _truncate(size int64) (err error) {
 		// If the metadata says we have some blocks cached then the
 		// file should exist, so open without O_CREATE
 		oFlags := os.O_WRONLY
+		if item.info.FileID != nil {
+			// The block the file is cut in is read to encrypt it again
+			oFlags = os.O_RDWR
+		}
 		if item.info.Rs.Size() == 0 {
 			oFlags |= os.O_CREATE
 		}
@@ -260,8 +272,9 @@ func (item *Item) _truncate(size int64) (err error) {
 			fs.Errorf(item.name, "vfs cache: detected external removal of cache file")
 			item.info.Rs = nil      // show we have no blocks cached
 			item.info.Dirty = false // file can't be dirty if it doesn't exist
+			item.info.Holes = nil
 			item._removeMeta("cache file externally deleted")
-			fd, err = file.OpenFile(osPath, os.O_CREATE|os.O_WRONLY, 0600)
+			fd, err = file.OpenFile(osPath, oFlags|os.O_CREATE, 0600)
 		}
 		if err != nil {
 			return errors.Wrap(err, "vfs cache: truncate: failed to open cache file")
@@ -277,7 +290,15 @@ func (item *Item) _truncate(size int64) (err error) {
 
 	fs.Debugf(item.name, "vfs cache: truncate to size=%d", size)
 
-	err = fd.Truncate(size)
+	cf, err := item._cryptFile(fd)
+	if err != nil {
+		return err
+	}
+	if cf != nil {
+		err = cf.Truncate(size)
+	} else {
+		err = fd.Truncate(size)
+	}
 	if err != nil {
 		return errors.Wrap(err, "vfs cache: truncate")
 	}
@@ -342,13 +363,20 @@ func (item *Item) Truncate(size int64) (err error) {
 
 	changed := true
 	if size > oldSize {
+		err = item._writeZeros(oldSize, size)
+		if err != nil {
+			return err
+		}
 		// Truncate extends the file in which case all new bytes are
 		// read as zeros. In this case we must show we have written to
 		// the new parts of the file.
 		item._written(oldSize, size)
+		// The new parts are holes which needn't be uploaded
+		item.info.Holes.Insert(ranges.Range{Pos: oldSize, Size: size - oldSize})
 	} else if size < oldSize {
 		// Truncate shrinks the file so clip the downloaded ranges
 		item.info.Rs = item.info.Rs.Intersection(ranges.Range{Pos: 0, Size: size})
+		item.info.Holes = item.info.Holes.Intersection(ranges.Range{Pos: 0, Size: size})
 	} else {
 		changed = item.o == nil
 	}
@@ -377,6 +405,9 @@ func (item *Item) _getSize() (size int64, err error) {
 		}
 	} else {
 		size = fi.Size()
+		if item.info.FileID != nil {
+			size = plainSize(size)
+		}
 	}
 	if err == nil {
 		item.info.Size = size
@@ -466,6 +497,13 @@ func (item *Item) _createFile(osPath string) (err error) {
 		return errors.New("vfs cache item: internal error: didn't Close file")
 	}
 	item.modified = false
+	if item.c.cipher != nil && item.info.FileID == nil && item.info.Rs.Size() == 0 {
+		// Nothing is cached yet so encrypt the file from now on
+		item.info.FileID, err = item.c.cipher.newFileID()
+		if err != nil {
+			return errors.Wrap(err, "vfs cache item: failed to start encryption")
+		}
+	}
 	fd, err := file.OpenFile(osPath, os.O_RDWR, 0600)
 	if err != nil {
 		return errors.Wrap(err, "vfs cache item: open failed")
@@ -564,7 +602,7 @@ func (item *Item) open(o fs.Object) (err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item, item.c.rules.Options(item.c.opt, item.name), item.name, item.o)
 	}
 
 	return err
@@ -583,11 +621,33 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 		return errors.Wrap(err, "vfs cache: failed to find cache file")
 	}
 
+	// Upload the decrypted contents if the cache file is encrypted
+	cf, err := item._cryptFile(item.fd)
+	if err != nil {
+		return err
+	}
+	if cacheObj != nil && cf != nil {
+		cacheObj = &decryptingObject{
+			Object: cacheObj,
+			cc:     cf.cc,
+			id:     cf.id,
+			mu:     cf.mu,
+			osPath: item.c.toOSPath(item.name),
+		}
+	}
+
 	// Object has disappeared if cacheObj == nil
 	if cacheObj != nil {
 		o, name := item.o, item.name
+		holes := append(ranges.Ranges(nil), item.info.Holes...)
+		sparse := len(holes) > 0 && item.c.fremote.Features().OpenWriterAt != nil
 		item.mu.Unlock()
-		o, err := operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
+		if sparse {
+			// Only upload the parts of the file which aren't holes
+			o, err = sparseCopy(ctx, item.c.fremote, name, cacheObj, holes)
+		} else {
+			o, err = operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
+		}
 		item.mu.Lock()
 		if err != nil {
 			return errors.Wrap(err, "vfs cache: failed to transfer file from cache to remote")
@@ -1026,7 +1086,7 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item, item.c.rules.Options(item.c.opt, item.name), item.name, item.o)
 	}
 
 	/* The item will stay in the beingReset state if we get an error that prevents us from
@@ -1227,6 +1287,11 @@ func (item *Item) readAt(b []byte, off int64) (n int, err error) {
 	}
 	defer item.mu.Unlock()
 
+	cf, err := item._cryptFile(item.fd)
+	if err != nil {
+		return 0, err
+	}
+
 	err = item._ensure(off, int64(len(b)))
 	if err != nil {
 		return 0, err
@@ -1234,12 +1299,16 @@ func (item *Item) readAt(b []byte, off int64) (n int, err error) {
 
 	item.info.ATime = time.Now()
 	// Do the reading with Item.mu unlocked and cache protected by preAccess
+	if cf != nil {
+		return cf.ReadAt(b, off)
+	}
 	n, err = item.fd.ReadAt(b, off)
 	return n, err
 }
 
 // WriteAt bytes to the file at off
 func (item *Item) WriteAt(b []byte, off int64) (n int, err error) {
+	item.c.throttleWrite()
 	item.preAccess()
 	defer item.postAccess()
 	item.mu.Lock()
@@ -1247,14 +1316,23 @@ func (item *Item) WriteAt(b []byte, off int64) (n int, err error) {
 		item.mu.Unlock()
 		return 0, errors.New("vfs cache item WriteAt: internal error: didn't Open file")
 	}
+	cf, err := item._cryptFile(item.fd)
 	item.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
 	// Do the writing with Item.mu unlocked
-	n, err = item.fd.WriteAt(b, off)
+	if cf != nil {
+		n, err = cf.WriteAt(b, off)
+	} else {
+		n, err = item.fd.WriteAt(b, off)
+	}
 	if err == nil && n != len(b) {
 		err = errors.Errorf("short write: tried to write %d but only %d written", len(b), n)
 	}
 	item.mu.Lock()
 	item._written(off, int64(n))
+	item.info.Holes.Remove(ranges.Range{Pos: off, Size: int64(n)})
 	if n > 0 {
 		item._dirty()
 	}
@@ -1263,7 +1341,12 @@ func (item *Item) WriteAt(b []byte, off int64) (n int, err error) {
 	// zeroes.  we do this by showing that we have written to the
 	// new parts of the file.
 	if off > item.info.Size {
+		zerosErr := item._writeZeros(item.info.Size, off)
+		if err == nil {
+			err = zerosErr
+		}
 		item._written(item.info.Size, off-item.info.Size)
+		item.info.Holes.Insert(ranges.Range{Pos: item.info.Size, Size: off - item.info.Size})
 		item._dirty()
 	}
 	// Update size
@@ -1274,6 +1357,43 @@ func (item *Item) WriteAt(b []byte, off int64) (n int, err error) {
 	return n, err
 }
 
+// PunchHole makes size bytes of the file at off read as zeros without
+// changing the size of the file, deallocating them in the cache file
+// if possible.
+//
+// The range is recorded as a hole so it isn't uploaded if the remote
+// supports writing files sparsely.
+func (item *Item) PunchHole(off, size int64) (err error) {
+	item.preAccess()
+	defer item.postAccess()
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.fd == nil {
+		return errors.New("vfs cache item PunchHole: internal error: didn't Open file")
+	}
+	r := ranges.Range{Pos: off, Size: size}
+	r.Clip(item.info.Size)
+	if r.IsEmpty() {
+		return nil
+	}
+	cf, err := item._cryptFile(item.fd)
+	if err != nil {
+		return err
+	}
+	if cf != nil {
+		err = item._writeZeros(r.Pos, r.End())
+	} else {
+		err = file.PunchHole(item.fd, r.Pos, r.Size)
+	}
+	if err != nil {
+		return errors.Wrap(err, "vfs cache: failed to punch hole")
+	}
+	item._written(r.Pos, r.Size)
+	item.info.Holes.Insert(r)
+	item._dirty()
+	return nil
+}
+
 // WriteAtNoOverwrite writes b to the file, but will not overwrite
 // already present ranges.
 //
@@ -1293,6 +1413,12 @@ func (item *Item) WriteAtNoOverwrite(b []byte, off int64) (n int, skipped int, e
 		nn int
 	)
 
+	cf, err := item._cryptFile(item.fd)
+	if err != nil {
+		item.mu.Unlock()
+		return 0, 0, err
+	}
+
 	// Write the range out ignoring already written chunks
 	// fs.Debugf(item.name, "Ranges = %v", item.info.Rs)
 	for i := range foundRanges {
@@ -1311,7 +1437,11 @@ func (item *Item) WriteAtNoOverwrite(b []byte, off int64) (n int, skipped int, e
 		} else {
 			// if range not present then we want to write it
 			// fs.Debugf(item.name, "write chunk offset=%d size=%d", off, size)
-			nn, err = item.fd.WriteAt(b[:size], off)
+			if cf != nil {
+				nn, err = cf.WriteAt(b[:size], off)
+			} else {
+				nn, err = item.fd.WriteAt(b[:size], off)
+			}
 			if err == nil && nn != size {
 				err = errors.Errorf("downloader: short write: tried to write %d but only %d written", size, nn)
 			}
@@ -1328,6 +1458,54 @@ func (item *Item) WriteAtNoOverwrite(b []byte, off int64) (n int, skipped int, e
 	return n, skipped, err
 }
 
+// _cryptFile returns a cryptFile to read and write the encrypted
+// cache file open as fd, or nil if it isn't encrypted.
+//
+// It returns an error if the file is encrypted but
+// --vfs-cache-encrypt isn't set.
+//
+// call with lock held
+func (item *Item) _cryptFile(fd *os.File) (cf *cryptFile, err error) {
+	if item.info.FileID == nil {
+		return nil, nil
+	}
+	if item.c.cipher == nil {
+		return nil, errors.New("vfs cache: cache file is encrypted but --vfs-cache-encrypt isn't set")
+	}
+	return &cryptFile{
+		cc: item.c.cipher,
+		id: item.info.FileID,
+		fd: fd,
+		mu: &item.cryptMu,
+	}, nil
+}
+
+// _writeZeros writes encrypted zeros to the cache file from start up
+// to end if it is encrypted, as the blocks the file is extended with
+// would fail their integrity check otherwise.
+//
+// call with lock held
+func (item *Item) _writeZeros(start, end int64) error {
+	cf, err := item._cryptFile(item.fd)
+	if err != nil || cf == nil {
+		return err
+	}
+	const bufSize = 1024 * 1024
+	buf := make([]byte, bufSize)
+	for off := start; off < end; {
+		size := end - off
+		if size > bufSize {
+			size = bufSize
+		}
+		_, err = cf.WriteAt(buf[:size], off)
+		if err != nil {
+			return errors.Wrap(err, "vfs cache: failed to write encrypted zeros")
+		}
+		off += size
+	}
+	return nil
+}
+
 // Sync commits the current contents of the file to stable storage. Typically,
 // this means flushing the file system's in-memory copy of recently written
 // data to disk.
@@ -16,6 +16,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/lib/readers"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
@@ -276,6 +277,48 @@ func TestItemWriteAtExisting(t *testing.T) {
 	checkObject(t, r, "existing", contents[:10]+"HELLO"+contents[15:95]+"THEND"+zeroes[:20]+"THEVERYEND")
 }
 
+func TestItemSparse(t *testing.T) {
+	r, c, cleanup := newItemTestCache(t)
+	defer cleanup()
+	item, _ := c.get("potato")
+
+	require.NoError(t, item.Open(nil))
+
+	_, err := item.WriteAt([]byte("HELLO"), 10)
+	require.NoError(t, err)
+	require.NoError(t, item.Truncate(30))
+	_, err = item.WriteAt([]byte("X"), 20)
+	require.NoError(t, err)
+	assert.Equal(t, ranges.Ranges{
+		{Pos: 0, Size: 10},
+		{Pos: 15, Size: 5},
+		{Pos: 21, Size: 9},
+	}, item.info.Holes)
+
+	require.NoError(t, item.PunchHole(11, 2))
+	require.NoError(t, item.PunchHole(25, 10))
+	assert.Equal(t, ranges.Ranges{
+		{Pos: 0, Size: 10},
+		{Pos: 11, Size: 2},
+		{Pos: 15, Size: 5},
+		{Pos: 21, Size: 9},
+	}, item.info.Holes)
+
+	// Overwriting part of a hole removes it
+	_, err = item.WriteAt([]byte("YZ"), 8)
+	require.NoError(t, err)
+	assert.Equal(t, ranges.Ranges{
+		{Pos: 0, Size: 8},
+		{Pos: 11, Size: 2},
+		{Pos: 15, Size: 5},
+		{Pos: 21, Size: 9},
+	}, item.info.Holes)
+
+	require.NoError(t, item.Close(nil))
+
+	checkObject(t, r, "potato", zeroes[:8]+"YZH"+zeroes[:2]+"LO"+zeroes[:5]+"X"+zeroes[:9])
+}
+
 func TestItemLoadMeta(t *testing.T) {
 	r, c, cleanup := newItemTestCache(t)
 	defer cleanup()
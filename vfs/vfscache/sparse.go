@@ -0,0 +1,127 @@
+// Uploading of sparse files
+
+package vfscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/lib/ranges"
+)
+
+// sparseBufferSize is the size of the buffer used to copy each data
+// range of a sparse file
+const sparseBufferSize = 1024 * 1024
+
+// sparseCopy uploads src to name on f with the OpenWriterAt feature,
+// only writing the parts of src which aren't in holes.
+//
+// The holes are the parts of the cache file which have never been
+// written to so read as zeros. A file opened with OpenWriterAt reads
+// as zeros until it is written so the holes don't need uploading.
+func sparseCopy(ctx context.Context, f fs.Fs, name string, src fs.Object, holes ranges.Ranges) (o fs.Object, err error) {
+	openWriterAt := f.Features().OpenWriterAt
+	if openWriterAt == nil {
+		return nil, errors.New("sparse copy: OpenWriterAt not supported")
+	}
+	size := src.Size()
+	holes = holes.Intersection(ranges.Range{Pos: 0, Size: size})
+
+	tr := accounting.Stats(ctx).NewTransfer(src)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	acc := tr.Account(ctx, nil)
+
+	wc, err := openWriterAt(ctx, name, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "sparse copy: failed to open destination")
+	}
+	fs.Debugf(name, "vfs cache: sparse upload of %v skipping %v of holes", fs.SizeSuffix(size-holes.Size()), fs.SizeSuffix(holes.Size()))
+	buf := make([]byte, sparseBufferSize)
+	for _, fr := range holes.FindAll(ranges.Range{Pos: 0, Size: size}) {
+		if fr.Present {
+			continue
+		}
+		err = sparseCopyRange(ctx, wc, src, fr.R, buf, acc)
+		if err != nil {
+			break
+		}
+	}
+	// Write the last byte if the file ends in a hole so the
+	// destination is the right size
+	if err == nil && size > 0 && holes.Present(ranges.Range{Pos: size - 1, Size: 1}) {
+		_, err = wc.WriteAt([]byte{0}, size-1)
+		if err != nil {
+			err = errors.Wrap(err, "sparse copy: write failed")
+		}
+	}
+	closeErr := wc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, errors.Wrap(closeErr, "sparse copy: failed to close object after copy")
+	}
+
+	o, err = f.NewObject(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "sparse copy: failed to find object after copy")
+	}
+	if o.Size() != size {
+		return nil, errors.Errorf("sparse copy: corrupted on transfer: sizes differ %d vs %d", size, o.Size())
+	}
+
+	err = o.SetModTime(ctx, src.ModTime(ctx))
+	switch err {
+	case nil, fs.ErrorCantSetModTime, fs.ErrorCantSetModTimeWithoutDelete:
+	default:
+		return nil, errors.Wrap(err, "sparse copy: failed to set modification time")
+	}
+	return o, nil
+}
+
+// sparseCopyRange copies the range r of src to wc using buf
+func sparseCopyRange(ctx context.Context, wc fs.WriterAtCloser, src fs.Object, r ranges.Range, buf []byte, acc *accounting.Account) (err error) {
+	in, err := src.Open(ctx, &fs.RangeOption{Start: r.Pos, End: r.End() - 1})
+	if err != nil {
+		return errors.Wrap(err, "sparse copy: failed to open source")
+	}
+	defer fs.CheckClose(in, &err)
+
+	offset := r.Pos
+	for offset < r.End() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n := r.End() - offset
+		if n > int64(len(buf)) {
+			n = int64(len(buf))
+		}
+		nr, er := io.ReadFull(in, buf[:n])
+		if nr > 0 {
+			err = acc.AccountRead(nr)
+			if err != nil {
+				return errors.Wrap(err, "sparse copy: accounting failed")
+			}
+			_, err = wc.WriteAt(buf[:nr], offset)
+			if err != nil {
+				return errors.Wrap(err, "sparse copy: write failed")
+			}
+			offset += int64(nr)
+		}
+		if er != nil {
+			if er == io.EOF || er == io.ErrUnexpectedEOF {
+				break
+			}
+			return errors.Wrap(er, "sparse copy: read failed")
+		}
+	}
+	if offset != r.End() {
+		return errors.Errorf("sparse copy: wrote %d bytes but expected to write %d", offset-r.Pos, r.Size)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package writeback
 import (
 	"container/heap"
 	"context"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,6 +35,7 @@ type WriteBack struct {
 	timer   *time.Timer               // next scheduled time for the uploader
 	expiry  time.Time                 // time the next item expires or IsZero
 	uploads int                       // number of uploads in progress
+	paused  bool                      // set if no new uploads should be started
 
 	// read and written with atomic
 	id Handle // id of the last writeBackItem created
@@ -414,10 +416,20 @@ func (wb *WriteBack) processItems(ctx context.Context) {
 	}
 
 	resetTimer := true
+	maxUploads := wb.opt.MaxUploads
+	if maxUploads <= 0 {
+		maxUploads = fs.GetConfig(context.TODO()).Transfers
+	}
 	for wbItem := wb._peekItem(); wbItem != nil && time.Until(wbItem.expiry) <= 0; wbItem = wb._peekItem() {
+		// If paused don't restart the timer - SetPaused will
+		if wb.paused {
+			fs.Debugf(wbItem.name, "vfs cache: delaying writeback as uploads are paused")
+			resetTimer = false
+			break
+		}
 		// If reached transfer limit don't restart the timer
-		if wb.uploads >= fs.GetConfig(context.TODO()).Transfers {
-			fs.Debugf(wbItem.name, "vfs cache: delaying writeback as --transfers exceeded")
+		if wb.uploads >= maxUploads {
+			fs.Debugf(wbItem.name, "vfs cache: delaying writeback as maximum concurrent uploads exceeded")
 			resetTimer = false
 			break
 		}
@@ -445,3 +457,107 @@ func (wb *WriteBack) Stats() (uploadsInProgress, uploadsQueued int) {
 	defer wb.mu.Unlock()
 	return wb.uploads, len(wb.items)
 }
+
+// QueueInfo describes an item in the writeback queue
+type QueueInfo struct {
+	Name      string    `json:"name"`      // name of the file
+	ID        Handle    `json:"id"`        // id of the item
+	Expiry    time.Time `json:"expiry"`    // when the next upload attempt is due
+	Tries     int       `json:"tries"`     // number of upload attempts so far
+	Uploading bool      `json:"uploading"` // set if the item is being uploaded now
+}
+
+// Queue returns the items waiting to be written back or being
+// uploaded, in the order they will be uploaded.
+func (wb *WriteBack) Queue() []QueueInfo {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	queue := make([]QueueInfo, 0, len(wb.lookup))
+	for _, wbItem := range wb.lookup {
+		queue = append(queue, QueueInfo{
+			Name:      wbItem.name,
+			ID:        wbItem.id,
+			Expiry:    wbItem.expiry,
+			Tries:     wbItem.tries,
+			Uploading: wbItem.uploading,
+		})
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if a.Uploading != b.Uploading {
+			return a.Uploading
+		}
+		if a.Expiry.Equal(b.Expiry) {
+			return a.ID < b.ID
+		}
+		return a.Expiry.Before(b.Expiry)
+	})
+	return queue
+}
+
+// RetryNow makes the items whose uploads have failed due for upload
+// now rather than after their backoff delay, and resets the delay.
+//
+// This is used when the remote becomes reachable again.
+func (wb *WriteBack) RetryNow() {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	now := time.Now()
+	for _, wbItem := range wb.items {
+		if wbItem.tries > 0 {
+			wbItem.expiry = now
+			wbItem.delay = wb.opt.WriteBack
+		}
+	}
+	heap.Init(&wb.items)
+	wb._resetTimer()
+}
+
+// Prioritize makes the item with the id passed in the next to be
+// uploaded and due for upload now. It returns false if the item
+// isn't in the queue.
+//
+// Nothing needs doing if the item is being uploaded already.
+func (wb *WriteBack) Prioritize(id Handle) (found bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wbItem, found := wb.lookup[id]
+	if !found {
+		return false
+	}
+	if wbItem.onHeap {
+		expiry := time.Now()
+		if first := wb._peekItem(); first != nil && first != wbItem && !first.expiry.After(expiry) {
+			expiry = first.expiry.Add(-time.Nanosecond)
+		}
+		fs.Infof(wbItem.name, "vfs cache: prioritizing upload")
+		wbItem.delay = wb.opt.WriteBack
+		wb.items._update(wbItem, expiry)
+		wb._resetTimer()
+	}
+	return true
+}
+
+// SetPaused stops new uploads being started if paused is set, or
+// starts them again if not. Uploads in progress carry on.
+func (wb *WriteBack) SetPaused(paused bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.paused == paused {
+		return
+	}
+	wb.paused = paused
+	if paused {
+		fs.Infof(nil, "vfs cache: uploads paused")
+	} else {
+		fs.Infof(nil, "vfs cache: uploads resumed")
+		wb._resetTimer()
+	}
+}
+
+// Paused returns whether new uploads are stopped
+func (wb *WriteBack) Paused() bool {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.paused
+}
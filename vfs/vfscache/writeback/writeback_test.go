@@ -615,3 +615,91 @@ func TestWriteBackCancelUpload(t *testing.T) {
 	checkInLookup(t, wb, wbItem)
 	assert.True(t, pi.cancelled)
 }
+
+func TestWriteBackQueueRetryNow(t *testing.T) {
+	wb, cancel := newTestWriteBack(t)
+	defer cancel()
+
+	pi := newPutItem(t)
+
+	id := wb.Add(0, "one", true, pi.put)
+	wbItem := wb.lookup[id]
+
+	queue := wb.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Equal(t, "one", queue[0].Name)
+	assert.Equal(t, id, queue[0].ID)
+	assert.Equal(t, 0, queue[0].Tries)
+
+	<-pi.started
+	queue = wb.Queue()
+	assert.True(t, queue[0].Uploading)
+
+	pi.finish(errors.New("transfer failed BOOM"))
+	waitUntilNoTransfers(t, wb)
+
+	// Push the retry a long way into the future
+	wb.mu.Lock()
+	wb.items._update(wbItem, time.Now().Add(time.Hour))
+	wb._resetTimer()
+	wb.mu.Unlock()
+
+	queue = wb.Queue()
+	assert.Equal(t, 1, queue[0].Tries)
+	assert.False(t, queue[0].Uploading)
+
+	// RetryNow should start it straight away
+	wb.RetryNow()
+	select {
+	case <-pi.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for retry")
+	}
+	assert.Equal(t, wb.opt.WriteBack, wbItem.delay)
+	pi.finish(nil)
+	waitUntilNoTransfers(t, wb)
+}
+
+func TestWriteBackPausePrioritize(t *testing.T) {
+	ctx := context.Background()
+	ci := fs.GetConfig(ctx)
+	wb, cancel := newTestWriteBack(t)
+	defer cancel()
+	wb.opt.MaxUploads = 1
+	assert.True(t, ci.Transfers > 1)
+
+	wb.SetPaused(true)
+	assert.True(t, wb.Paused())
+
+	pi1, pi2, pi3 := newPutItem(t), newPutItem(t), newPutItem(t)
+	wb.Add(0, "one", true, pi1.put)
+	wb.Add(0, "two", true, pi2.put)
+	id3 := wb.Add(0, "three", true, pi3.put)
+	assert.Equal(t, "one,two,three", wb.string(t))
+
+	// Nothing should start while paused
+	time.Sleep(3 * wb.opt.WriteBack)
+	assert.False(t, pi1.called)
+	inProgress, queued := wb.Stats()
+	assert.Equal(t, 0, inProgress)
+	assert.Equal(t, 3, queued)
+
+	assert.True(t, wb.Prioritize(id3))
+	assert.False(t, wb.Prioritize(id3+100))
+	assert.Equal(t, "three,one,two", wb.string(t))
+
+	// Only one upload at once as MaxUploads is 1
+	wb.SetPaused(false)
+	<-pi3.started
+	time.Sleep(3 * wb.opt.WriteBack)
+	inProgress, queued = wb.Stats()
+	assert.Equal(t, 1, inProgress)
+	assert.Equal(t, 2, queued)
+
+	pi3.finish(nil)
+	<-pi1.started
+	pi1.finish(nil)
+	<-pi2.started
+	pi2.finish(nil)
+	waitUntilNoTransfers(t, wb)
+}
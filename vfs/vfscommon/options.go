@@ -26,12 +26,22 @@ type Options struct {
 	CacheMode         CacheMode
 	CacheMaxAge       time.Duration
 	CacheMaxSize      fs.SizeSuffix
+	CacheMinFreeSpace fs.SizeSuffix // remove files from the cache to keep this much free on the disk
 	CachePollInterval time.Duration
 	CaseInsensitive   bool
+	FoldNames         bool          // if set names differing only by case or unicode normalization are the same
 	WriteWait         time.Duration // time to wait for in-sequence write
 	ReadWait          time.Duration // time to wait for in-sequence read
 	WriteBack         time.Duration // time to wait before writing back dirty files
+	MaxUploads        int           // max number of files to upload at once or 0 for --transfers
 	ReadAhead         fs.SizeSuffix // bytes to read ahead in cache mode "full"
+	DirCachePersist   bool          // if set persist the directory cache to disk
+	CacheEncrypt      bool          // if set encrypt the files in the cache
+	CacheKeyFile      string        // file to read the cache encryption key from
+	PrefetchChunks    int           // number of chunks to read ahead in parallel when reading sequentially
+	PrefetchSize      fs.SizeSuffix // size of the chunks to read ahead
+	Rules             []string      // per path overrides of the options
+	Offline           bool          // if set keep serving from the caches when the remote is unreachable
 }
 
 // DefaultOpt is the default values uses for Opt
@@ -53,9 +63,11 @@ var DefaultOpt = Options{
 	ChunkSize:         128 * fs.MebiByte,
 	ChunkSizeLimit:    -1,
 	CacheMaxSize:      -1,
+	CacheMinFreeSpace: -1,
 	CaseInsensitive:   runtime.GOOS == "windows" || runtime.GOOS == "darwin", // default to true on Windows and Mac, false otherwise
 	WriteWait:         1000 * time.Millisecond,
 	ReadWait:          20 * time.Millisecond,
 	WriteBack:         5 * time.Second,
 	ReadAhead:         0 * fs.MebiByte,
+	PrefetchSize:      16 * fs.MebiByte,
 }
@@ -0,0 +1,144 @@
+package vfscommon
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+)
+
+// Rule overrides some of the Options for the paths matching a glob.
+//
+// It is parsed from a --vfs-rule like
+//
+//	media/** mode=full read-ahead=64M
+//
+// The settings which aren't set are nil.
+type Rule struct {
+	Pattern        string
+	re             *regexp.Regexp
+	CacheMode      *CacheMode
+	CacheMaxAge    *time.Duration
+	ReadAhead      *fs.SizeSuffix
+	PrefetchChunks *int
+	PrefetchSize   *fs.SizeSuffix
+}
+
+// Rules is a list of Rule - the first one which matches a path is used
+type Rules []*Rule
+
+// ParseRule parses a --vfs-rule
+func ParseRule(s string) (*Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return nil, errors.Errorf("vfs rule %q: need a pattern and at least one setting", s)
+	}
+	re, err := filter.GlobToRegexp(fields[0], false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "vfs rule %q", s)
+	}
+	rule := &Rule{
+		Pattern: fields[0],
+		re:      re,
+	}
+	for _, field := range fields[1:] {
+		equals := strings.IndexRune(field, '=')
+		if equals < 0 {
+			return nil, errors.Errorf("vfs rule %q: setting %q should be key=value", s, field)
+		}
+		key, value := field[:equals], field[equals+1:]
+		switch key {
+		case "mode":
+			var mode CacheMode
+			err = mode.Set(value)
+			rule.CacheMode = &mode
+		case "max-age":
+			var maxAge fs.Duration
+			err = maxAge.Set(value)
+			d := time.Duration(maxAge)
+			rule.CacheMaxAge = &d
+		case "read-ahead":
+			var readAhead fs.SizeSuffix
+			err = readAhead.Set(value)
+			rule.ReadAhead = &readAhead
+		case "prefetch":
+			var chunks int
+			chunks, err = strconv.Atoi(value)
+			rule.PrefetchChunks = &chunks
+		case "prefetch-chunk-size":
+			var size fs.SizeSuffix
+			err = size.Set(value)
+			rule.PrefetchSize = &size
+		default:
+			return nil, errors.Errorf("vfs rule %q: unknown setting %q", s, key)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "vfs rule %q: bad %s", s, key)
+		}
+	}
+	return rule, nil
+}
+
+// ParseRules parses the --vfs-rule flags in order
+func ParseRules(rules []string) (rs Rules, err error) {
+	for _, s := range rules {
+		rule, err := ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, rule)
+	}
+	return rs, nil
+}
+
+// Match returns the first rule which matches path or nil if none do
+func (rs Rules) Match(path string) *Rule {
+	for _, rule := range rs {
+		if rule.re.MatchString(path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Options returns the options to use for path - this is opt if no
+// rule matches or a copy of opt with the settings of the rule
+// otherwise.
+func (rs Rules) Options(opt *Options, path string) *Options {
+	rule := rs.Match(path)
+	if rule == nil {
+		return opt
+	}
+	newOpt := *opt
+	if rule.CacheMode != nil {
+		newOpt.CacheMode = *rule.CacheMode
+	}
+	if rule.CacheMaxAge != nil {
+		newOpt.CacheMaxAge = *rule.CacheMaxAge
+	}
+	if rule.ReadAhead != nil {
+		newOpt.ReadAhead = *rule.ReadAhead
+	}
+	if rule.PrefetchChunks != nil {
+		newOpt.PrefetchChunks = *rule.PrefetchChunks
+	}
+	if rule.PrefetchSize != nil {
+		newOpt.PrefetchSize = *rule.PrefetchSize
+	}
+	return &newOpt
+}
+
+// MaxCacheMode returns the highest of mode and the cache modes of
+// the rules
+func (rs Rules) MaxCacheMode(mode CacheMode) CacheMode {
+	for _, rule := range rs {
+		if rule.CacheMode != nil && *rule.CacheMode > mode {
+			mode = *rule.CacheMode
+		}
+	}
+	return mode
+}
@@ -0,0 +1,93 @@
+package vfscommon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("media/** mode=full max-age=2h read-ahead=64M prefetch=4 prefetch-chunk-size=8M")
+	require.NoError(t, err)
+	assert.Equal(t, "media/**", rule.Pattern)
+	assert.Equal(t, CacheModeFull, *rule.CacheMode)
+	assert.Equal(t, 2*time.Hour, *rule.CacheMaxAge)
+	assert.Equal(t, 64*fs.MebiByte, *rule.ReadAhead)
+	assert.Equal(t, 4, *rule.PrefetchChunks)
+	assert.Equal(t, 8*fs.MebiByte, *rule.PrefetchSize)
+
+	rule, err = ParseRule("  *.iso   mode=off ")
+	require.NoError(t, err)
+	assert.Equal(t, CacheModeOff, *rule.CacheMode)
+	assert.Nil(t, rule.CacheMaxAge)
+	assert.Nil(t, rule.ReadAhead)
+
+	for _, in := range []string{
+		"",
+		"media/**",
+		"media/** mode",
+		"media/** mode=potato",
+		"media/** max-age=potato",
+		"media/** read-ahead=potato",
+		"media/** prefetch=potato",
+		"media/** prefetch-chunk-size=potato",
+		"media/** potato=1",
+		"media/[** mode=full",
+	} {
+		_, err := ParseRule(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules([]string{
+		"/media/** mode=full read-ahead=64M",
+		"*.tmp mode=off",
+		"/docs/** max-age=1m",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, CacheModeFull, rules.MaxCacheMode(CacheModeOff))
+	assert.Equal(t, CacheModeFull, rules.MaxCacheMode(CacheModeWrites))
+
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{"media/film.mkv", "/media/**"},
+		{"media/a/b/film.tmp", "/media/**"},
+		{"other/media/film.mkv", ""},
+		{"other/file.tmp", "*.tmp"},
+		{"file.tmp", "*.tmp"},
+		{"docs/a.txt", "/docs/**"},
+		{"file.txt", ""},
+	} {
+		rule := rules.Match(test.path)
+		if test.want == "" {
+			assert.Nil(t, rule, test.path)
+		} else {
+			require.NotNil(t, rule, test.path)
+			assert.Equal(t, test.want, rule.Pattern, test.path)
+		}
+	}
+
+	opt := DefaultOpt
+	opt.CacheMode = CacheModeWrites
+	assert.True(t, &opt == rules.Options(&opt, "file.txt"))
+
+	got := rules.Options(&opt, "media/film.mkv")
+	assert.Equal(t, CacheModeFull, got.CacheMode)
+	assert.Equal(t, 64*fs.MebiByte, got.ReadAhead)
+	assert.Equal(t, opt.CacheMaxAge, got.CacheMaxAge)
+	assert.Equal(t, CacheModeWrites, opt.CacheMode)
+
+	got = rules.Options(&opt, "docs/a.txt")
+	assert.Equal(t, CacheModeWrites, got.CacheMode)
+	assert.Equal(t, time.Minute, got.CacheMaxAge)
+
+	var empty Rules
+	assert.Equal(t, CacheModeMinimal, empty.MaxCacheMode(CacheModeMinimal))
+	assert.Nil(t, empty.Match("file.txt"))
+}
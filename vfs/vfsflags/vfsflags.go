@@ -22,20 +22,30 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &Opt.NoChecksum, "no-checksum", "", Opt.NoChecksum, "Don't compare checksums on up/download.")
 	flags.BoolVarP(flagSet, &Opt.NoSeek, "no-seek", "", Opt.NoSeek, "Don't allow seeking in files.")
 	flags.DurationVarP(flagSet, &Opt.DirCacheTime, "dir-cache-time", "", Opt.DirCacheTime, "Time to cache directory entries for.")
+	flags.BoolVarP(flagSet, &Opt.DirCachePersist, "vfs-dir-cache-persist", "", Opt.DirCachePersist, "Persist the directory cache to disk so it can be used after a restart.")
 	flags.DurationVarP(flagSet, &Opt.PollInterval, "poll-interval", "", Opt.PollInterval, "Time to wait between polling for changes. Must be smaller than dir-cache-time. Only on supported remotes. Set to 0 to disable.")
 	flags.BoolVarP(flagSet, &Opt.ReadOnly, "read-only", "", Opt.ReadOnly, "Mount read-only.")
 	flags.FVarP(flagSet, &Opt.CacheMode, "vfs-cache-mode", "", "Cache mode off|minimal|writes|full")
 	flags.DurationVarP(flagSet, &Opt.CachePollInterval, "vfs-cache-poll-interval", "", Opt.CachePollInterval, "Interval to poll the cache for stale objects.")
 	flags.DurationVarP(flagSet, &Opt.CacheMaxAge, "vfs-cache-max-age", "", Opt.CacheMaxAge, "Max age of objects in the cache.")
 	flags.FVarP(flagSet, &Opt.CacheMaxSize, "vfs-cache-max-size", "", "Max total size of objects in the cache.")
+	flags.FVarP(flagSet, &Opt.CacheMinFreeSpace, "vfs-cache-min-free-space", "", "Remove objects from the cache to keep this much free space on the cache disk.")
+	flags.BoolVarP(flagSet, &Opt.CacheEncrypt, "vfs-cache-encrypt", "", Opt.CacheEncrypt, "Encrypt the files in the cache.")
+	flags.StringVarP(flagSet, &Opt.CacheKeyFile, "vfs-cache-encrypt-keyfile", "", Opt.CacheKeyFile, "File to make the cache encryption key from instead of the config password.")
 	flags.FVarP(flagSet, &Opt.ChunkSize, "vfs-read-chunk-size", "", "Read the source objects in chunks.")
 	flags.FVarP(flagSet, &Opt.ChunkSizeLimit, "vfs-read-chunk-size-limit", "", "If greater than --vfs-read-chunk-size, double the chunk size after each chunk read, until the limit is reached. 'off' is unlimited.")
 	flags.FVarP(flagSet, DirPerms, "dir-perms", "", "Directory permissions")
 	flags.FVarP(flagSet, FilePerms, "file-perms", "", "File permissions")
 	flags.BoolVarP(flagSet, &Opt.CaseInsensitive, "vfs-case-insensitive", "", Opt.CaseInsensitive, "If a file name not found, find a case insensitive match.")
+	flags.BoolVarP(flagSet, &Opt.FoldNames, "vfs-fold-names", "", Opt.FoldNames, "Treat file names differing only by case or unicode normalization as the same, hiding duplicates.")
 	flags.DurationVarP(flagSet, &Opt.WriteWait, "vfs-write-wait", "", Opt.WriteWait, "Time to wait for in-sequence write before giving error.")
 	flags.DurationVarP(flagSet, &Opt.ReadWait, "vfs-read-wait", "", Opt.ReadWait, "Time to wait for in-sequence read before seeking.")
 	flags.DurationVarP(flagSet, &Opt.WriteBack, "vfs-write-back", "", Opt.WriteBack, "Time to writeback files after last use when using cache.")
+	flags.IntVarP(flagSet, &Opt.MaxUploads, "vfs-write-back-max-concurrency", "", Opt.MaxUploads, "Max number of files to upload at once when using cache. 0 means use --transfers.")
 	flags.FVarP(flagSet, &Opt.ReadAhead, "vfs-read-ahead", "", "Extra read ahead over --buffer-size when using cache-mode full.")
+	flags.IntVarP(flagSet, &Opt.PrefetchChunks, "vfs-read-prefetch", "", Opt.PrefetchChunks, "Number of chunks to read ahead in parallel when a file is read sequentially. 0 is off.")
+	flags.FVarP(flagSet, &Opt.PrefetchSize, "vfs-read-prefetch-chunk-size", "", "Size of the chunks read ahead with --vfs-read-prefetch.")
+	flags.BoolVarP(flagSet, &Opt.Offline, "vfs-offline", "", Opt.Offline, "Keep serving cached directories and files if the remote becomes unreachable.")
+	flags.StringArrayVarP(flagSet, &Opt.Rules, "vfs-rule", "", Opt.Rules, "Override the cache and prefetch options for paths matching a glob, eg \"media/** mode=full\". Can be repeated.")
 	platformFlags(flagSet)
 }
@@ -49,6 +49,11 @@ func (f realOsFile) Node() vfs.Node {
 	return nil
 }
 
+// PunchHole
+func (f realOsFile) PunchHole(off, size int64) error {
+	return file.PunchHole(f.File, off, size)
+}
+
 // Chtimes
 func (r realOs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return os.Chtimes(name, atime, mtime)
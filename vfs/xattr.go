@@ -0,0 +1,119 @@
+// Extended attributes
+
+package vfs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Flags for SetXattr
+const (
+	XattrCreate  = 1 << iota // fail if the attribute exists already
+	XattrReplace             // fail if the attribute doesn't exist
+)
+
+// metadataNode is a Node with metadata
+type metadataNode interface {
+	Node
+	Metadata() (fs.Metadata, error)
+	SetMetadata(metadata fs.Metadata) error
+}
+
+// xattrs returns the extended attributes of node
+func xattrs(node Node) (metadataNode, fs.Metadata, error) {
+	mn, ok := node.(metadataNode)
+	if !ok {
+		return nil, nil, ENOTSUP
+	}
+	metadata, err := mn.Metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	return mn, metadata.Xattrs(), nil
+}
+
+// ListXattr returns the names of the extended attributes of node
+//
+// The extended attributes are the metadata of the object or directory
+// with the "user." prefix.
+func ListXattr(node Node) (names []string, err error) {
+	_, xattrs, err := xattrs(node)
+	if err != nil {
+		return nil, err
+	}
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetXattr returns the value of the extended attribute name of node
+//
+// If there is no attribute with that name it returns ENOATTR.
+func GetXattr(node Node, name string) ([]byte, error) {
+	if !strings.HasPrefix(name, fs.XattrPrefix) {
+		// don't read the metadata for attributes which can't exist
+		return nil, ENOATTR
+	}
+	_, xattrs, err := xattrs(node)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := xattrs[name]
+	if !ok {
+		return nil, ENOATTR
+	}
+	return []byte(value), nil
+}
+
+// SetXattr sets the extended attribute name of node to value
+//
+// flags may contain XattrCreate or XattrReplace.
+func SetXattr(node Node, name string, value []byte, flags int) error {
+	if !strings.HasPrefix(name, fs.XattrPrefix) {
+		return ENOTSUP
+	}
+	if node.VFS().Opt.ReadOnly {
+		return EROFS
+	}
+	mn, xattrs, err := xattrs(node)
+	if err != nil {
+		return err
+	}
+	oldValue, exists := xattrs[name]
+	switch {
+	case flags&XattrCreate != 0 && exists:
+		return EEXIST
+	case flags&XattrReplace != 0 && !exists:
+		return ENOATTR
+	case exists && oldValue == string(value):
+		return nil
+	}
+	xattrs[name] = string(value)
+	return mn.SetMetadata(xattrs)
+}
+
+// RemoveXattr removes the extended attribute name from node
+//
+// If there is no attribute with that name it returns ENOATTR.
+func RemoveXattr(node Node, name string) error {
+	if !strings.HasPrefix(name, fs.XattrPrefix) {
+		return ENOATTR
+	}
+	if node.VFS().Opt.ReadOnly {
+		return EROFS
+	}
+	mn, xattrs, err := xattrs(node)
+	if err != nil {
+		return err
+	}
+	if _, exists := xattrs[name]; !exists {
+		return ENOATTR
+	}
+	delete(xattrs, name)
+	return mn.SetMetadata(xattrs)
+}
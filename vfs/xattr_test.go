@@ -0,0 +1,59 @@
+// +build linux
+
+package vfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattr(t *testing.T) {
+	_, vfs, file, _, cleanup := fileCreate(t, vfscommon.CacheModeOff)
+	defer cleanup()
+	if _, ok := file.getObject().(fs.SetMetadataer); !ok {
+		t.Skip("remote can't set metadata")
+	}
+
+	names, err := ListXattr(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string(nil), names)
+
+	// attributes outside the user namespace aren't supported
+	_, err = GetXattr(file, "security.capability")
+	assert.Equal(t, ENOATTR, err)
+	assert.Equal(t, ENOTSUP, SetXattr(file, "trusted.potato", []byte("x"), 0))
+
+	require.NoError(t, SetXattr(file, "user.potato", []byte("jersey royal"), 0))
+	require.NoError(t, SetXattr(file, "user.colour", []byte("red"), XattrCreate))
+	assert.Equal(t, EEXIST, SetXattr(file, "user.colour", []byte("blue"), XattrCreate))
+	assert.Equal(t, ENOATTR, SetXattr(file, "user.missing", []byte("x"), XattrReplace))
+
+	names, err = ListXattr(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.colour", "user.potato"}, names)
+	value, err := GetXattr(file, "user.potato")
+	require.NoError(t, err)
+	assert.Equal(t, "jersey royal", string(value))
+	_, err = GetXattr(file, "user.missing")
+	assert.Equal(t, ENOATTR, err)
+
+	// check the metadata was written to the object
+	metadata, err := fs.GetMetadata(context.Background(), file.getObject())
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{"user.colour": "red", "user.potato": "jersey royal"}, metadata.Xattrs())
+
+	require.NoError(t, RemoveXattr(file, "user.potato"))
+	assert.Equal(t, ENOATTR, RemoveXattr(file, "user.potato"))
+	names, err = ListXattr(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.colour"}, names)
+
+	vfs.Opt.ReadOnly = true
+	assert.Equal(t, EROFS, SetXattr(file, "user.potato", []byte("x"), 0))
+	assert.Equal(t, EROFS, RemoveXattr(file, "user.colour"))
+}